@@ -1,7 +1,11 @@
 package acme
 
 import (
+	"context"
 	"encoding/json"
+	"net/mail"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -50,6 +54,39 @@ type AccountOptions struct {
 	Contact []string
 }
 
+// validateContacts validates the contacts of an account using the given
+// configuration.
+func validateContacts(c *ContactsConfig, contacts []string) error {
+	if !c.IsEnabled() {
+		return nil
+	}
+	if c.MaxContacts > 0 && len(contacts) > c.MaxContacts {
+		return MalformedErr(errors.Errorf("too many contacts; the maximum is %d", c.MaxContacts))
+	}
+	for _, contact := range contacts {
+		u, err := url.Parse(contact)
+		if err != nil {
+			return InvalidContactErr(errors.Wrapf(err, "error parsing contact %s", contact))
+		}
+		if u.Scheme != "mailto" {
+			return UnsupportedContactErr(errors.Errorf("contact %s is not supported; only mailto contacts are allowed", contact))
+		}
+		// RFC 8555: mailto URLs with hfields or more than one address must be
+		// rejected.
+		if u.Opaque == "" || u.RawQuery != "" || u.ForceQuery || strings.Contains(u.Opaque, ",") {
+			return InvalidContactErr(errors.Errorf("contact %s must contain a single email address", contact))
+		}
+		email, err := url.PathUnescape(u.Opaque)
+		if err != nil {
+			return InvalidContactErr(errors.Wrapf(err, "error parsing contact %s", contact))
+		}
+		if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+			return InvalidContactErr(errors.Errorf("contact %s does not contain a valid email address", contact))
+		}
+	}
+	return nil
+}
+
 // account represents an ACME account.
 type account struct {
 	ID          string           `json:"id"`
@@ -79,11 +116,11 @@ func newAccount(db nosql.DB, ops AccountOptions) (*account, error) {
 
 // toACME converts the internal Account type into the public acmeAccount
 // type for presentation in the ACME protocol.
-func (a *account) toACME(db nosql.DB, dir *directory, p provisioner.Interface) (*Account, error) {
+func (a *account) toACME(ctx context.Context, db nosql.DB, dir *directory, p provisioner.Interface) (*Account, error) {
 	return &Account{
 		Status:  a.Status,
 		Contact: a.Contact,
-		Orders:  dir.getLink(OrdersByAccountLink, URLSafeProvisionerName(p), true, a.ID),
+		Orders:  dir.getLink(ctx, OrdersByAccountLink, URLSafeProvisionerName(p), true, a.ID),
 		Key:     a.Key,
 		ID:      a.ID,
 	}, nil
@@ -195,20 +232,3 @@ func getAccountByKeyID(db nosql.DB, kid string) (*account, error) {
 	}
 	return getAccountByID(db, string(id))
 }
-
-// getOrderIDsByAccount retrieves a list of Order IDs that were created by the
-// account.
-func getOrderIDsByAccount(db nosql.DB, id string) ([]string, error) {
-	b, err := db.Get(ordersByAccountIDTable, []byte(id))
-	if err != nil {
-		if nosql.IsErrNotFound(err) {
-			return []string{}, nil
-		}
-		return nil, ServerInternalErr(errors.Wrapf(err, "error loading orderIDs for account %s", id))
-	}
-	var orderIDs []string
-	if err := json.Unmarshal(b, &orderIDs); err != nil {
-		return nil, ServerInternalErr(errors.Wrapf(err, "error unmarshaling orderIDs for account %s", id))
-	}
-	return orderIDs, nil
-}
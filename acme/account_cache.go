@@ -0,0 +1,231 @@
+package acme
+
+import (
+	"container/list"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/smallstep/certificates/db/redis"
+)
+
+// accountCache is an LRU cache of the accounts indexed by id and by key id,
+// or, if the redis client is set, a cache shared in Redis. A nil accountCache
+// is valid and disables the cache.
+type accountCache struct {
+	mu       sync.Mutex
+	max      int
+	ttl      time.Duration
+	list     *list.List
+	accounts map[string]*list.Element
+	keyIDs   map[string]string
+	redis    *redis.Client
+}
+
+type accountCacheEntry struct {
+	kid     string
+	acc     *account
+	expires time.Time
+}
+
+// newAccountCache returns the cache for the given configuration, or nil if
+// the cache is not enabled. The redis client is only used by the redis
+// store.
+func newAccountCache(c *AccountCacheConfig, client *redis.Client) *accountCache {
+	if c == nil {
+		return nil
+	}
+	if c.GetStore() == AccountCacheStoreRedis {
+		return &accountCache{ttl: c.GetTTL(), redis: client}
+	}
+	return &accountCache{
+		max:      c.GetMaxAccounts(),
+		ttl:      c.GetTTL(),
+		list:     list.New(),
+		accounts: make(map[string]*list.Element),
+		keyIDs:   make(map[string]string),
+	}
+}
+
+// get returns a copy of the cached account with the given id.
+func (c *accountCache) get(id string) (*account, bool) {
+	if c == nil {
+		return nil, false
+	}
+	if c.redis != nil {
+		return c.redisGet(id)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.getLocked(id)
+}
+
+// getByKeyID returns a copy of the cached account with the given key id.
+func (c *accountCache) getByKeyID(kid string) (*account, bool) {
+	if c == nil {
+		return nil, false
+	}
+	if c.redis != nil {
+		id, err := c.redis.Get(c.redis.Key(string(accountByKeyIDTable), kid))
+		if err != nil {
+			logRedisError(err)
+			return nil, false
+		}
+		return c.redisGet(string(id))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id, ok := c.keyIDs[kid]
+	if !ok {
+		return nil, false
+	}
+	return c.getLocked(id)
+}
+
+func (c *accountCache) getLocked(id string) (*account, bool) {
+	e, ok := c.accounts[id]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*accountCacheEntry)
+	if clock.Now().After(entry.expires) {
+		c.removeElement(e)
+		return nil, false
+	}
+	c.list.MoveToFront(e)
+	acc := *entry.acc
+	return &acc, true
+}
+
+// add adds a copy of the account to the cache, replacing the previous
+// version, and evicts the least recently used accounts if the cache is full.
+func (c *accountCache) add(acc *account) {
+	if c == nil {
+		return
+	}
+	kid, err := keyToID(acc.Key)
+	if err != nil {
+		return
+	}
+	if c.redis != nil {
+		c.redisAdd(kid, acc)
+		return
+	}
+	cp := *acc
+	entry := &accountCacheEntry{kid: kid, acc: &cp, expires: clock.Now().Add(c.ttl)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.accounts[acc.ID]; ok {
+		c.removeElement(e)
+	}
+	c.accounts[acc.ID] = c.list.PushFront(entry)
+	c.keyIDs[kid] = acc.ID
+	for c.list.Len() > c.max {
+		c.removeElement(c.list.Back())
+	}
+}
+
+// remove removes the account with the given id from the cache.
+func (c *accountCache) remove(id string) {
+	if c == nil {
+		return
+	}
+	if c.redis != nil {
+		if _, err := c.redis.Del(c.redis.Key(string(accountTable), id)); err != nil {
+			logRedisError(err)
+		}
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.accounts[id]; ok {
+		c.removeElement(e)
+	}
+}
+
+func (c *accountCache) removeElement(e *list.Element) {
+	entry := c.list.Remove(e).(*accountCacheEntry)
+	delete(c.accounts, entry.acc.ID)
+	delete(c.keyIDs, entry.kid)
+}
+
+// redisGet returns the account with the given id cached in Redis.
+func (c *accountCache) redisGet(id string) (*account, bool) {
+	b, err := c.redis.Get(c.redis.Key(string(accountTable), id))
+	if err != nil {
+		logRedisError(err)
+		return nil, false
+	}
+	acc := new(account)
+	if err := json.Unmarshal(b, acc); err != nil {
+		log.Printf("error unmarshaling cached acme account %s: %v", id, err)
+		return nil, false
+	}
+	return acc, true
+}
+
+// redisAdd caches the account and its key id in Redis. The key id is stored
+// after the account, so it never points to an account that is not cached.
+func (c *accountCache) redisAdd(kid string, acc *account) {
+	b, err := json.Marshal(acc)
+	if err != nil {
+		log.Printf("error marshaling acme account %s: %v", acc.ID, err)
+		return
+	}
+	if err := c.redis.Set(c.redis.Key(string(accountTable), acc.ID), b, c.ttl); err != nil {
+		logRedisError(err)
+		return
+	}
+	if err := c.redis.Set(c.redis.Key(string(accountByKeyIDTable), kid), []byte(acc.ID), c.ttl); err != nil {
+		logRedisError(err)
+	}
+}
+
+// logRedisError logs the errors of the redis cache, the accounts are then
+// loaded from the database.
+func logRedisError(err error) {
+	if err != redis.ErrNotFound {
+		log.Printf("error using the acme account cache: %v", err)
+	}
+}
+
+// getAccount returns the account with the given id from the cache, or loads
+// it from the database and caches it.
+func (a *Authority) getAccount(id string) (*account, error) {
+	if acc, ok := a.accounts.get(id); ok {
+		return acc, nil
+	}
+	acc, err := getAccountByID(a.db, id)
+	if err != nil {
+		return nil, err
+	}
+	a.accounts.add(acc)
+	return acc, nil
+}
+
+// getAccountByKeyID returns the account with the given key id from the
+// cache, or loads it from the database and caches it.
+func (a *Authority) getAccountByKeyID(kid string) (*account, error) {
+	if acc, ok := a.accounts.getByKeyID(kid); ok {
+		return acc, nil
+	}
+	acc, err := getAccountByKeyID(a.db, kid)
+	if err != nil {
+		return nil, err
+	}
+	a.accounts.add(acc)
+	return acc, nil
+}
+
+// accountChanged replaces the cached account after it's modified. If the
+// modification failed, the account is removed from the cache, as the version
+// in the database is unknown.
+func (a *Authority) accountChanged(id string, acc *account, err error) {
+	if err != nil {
+		a.accounts.remove(id)
+		return
+	}
+	a.accounts.add(acc)
+}
@@ -0,0 +1,204 @@
+package acme
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/db/redis"
+)
+
+func TestAccountCache(t *testing.T) {
+	acc1, err := newAcc()
+	assert.FatalError(t, err)
+	acc2, err := newAcc()
+	assert.FatalError(t, err)
+	acc3, err := newAcc()
+	assert.FatalError(t, err)
+	kid1, err := keyToID(acc1.Key)
+	assert.FatalError(t, err)
+	kid2, err := keyToID(acc2.Key)
+	assert.FatalError(t, err)
+
+	c := newAccountCache(&AccountCacheConfig{MaxAccounts: 2}, nil)
+	_, ok := c.get(acc1.ID)
+	assert.False(t, ok)
+
+	c.add(acc1)
+	c.add(acc2)
+	got, ok := c.get(acc1.ID)
+	assert.True(t, ok)
+	assert.Equals(t, acc1, got)
+	got, ok = c.getByKeyID(kid2)
+	assert.True(t, ok)
+	assert.Equals(t, acc2, got)
+
+	// The cache returns copies of the accounts.
+	got.Status = StatusDeactivated
+	got, ok = c.get(acc2.ID)
+	assert.True(t, ok)
+	assert.Equals(t, StatusValid, got.Status)
+
+	// acc1 is the least recently used account.
+	c.add(acc3)
+	_, ok = c.get(acc1.ID)
+	assert.False(t, ok)
+	_, ok = c.getByKeyID(kid1)
+	assert.False(t, ok)
+	_, ok = c.get(acc3.ID)
+	assert.True(t, ok)
+
+	// Replacing an account updates both indexes.
+	upd := *acc2
+	upd.Contact = []string{"mailto:admin@example.com"}
+	c.add(&upd)
+	got, ok = c.getByKeyID(kid2)
+	assert.True(t, ok)
+	assert.Equals(t, upd.Contact, got.Contact)
+	assert.Equals(t, 2, c.list.Len())
+
+	c.remove(acc2.ID)
+	_, ok = c.get(acc2.ID)
+	assert.False(t, ok)
+	_, ok = c.getByKeyID(kid2)
+	assert.False(t, ok)
+
+	// Expired accounts are removed.
+	c.ttl = -time.Second
+	c.add(acc1)
+	_, ok = c.get(acc1.ID)
+	assert.False(t, ok)
+	assert.Equals(t, 1, c.list.Len())
+
+	// A nil cache is disabled.
+	var nilCache *accountCache
+	nilCache.add(acc1)
+	nilCache.remove(acc1.ID)
+	_, ok = nilCache.get(acc1.ID)
+	assert.False(t, ok)
+	_, ok = nilCache.getByKeyID(kid1)
+	assert.False(t, ok)
+	assert.Nil(t, newAccountCache(nil, nil))
+}
+
+func TestAuthorityAccountCache(t *testing.T) {
+	prov := newProv()
+	acc, err := newAcc()
+	assert.FatalError(t, err)
+	b, err := json.Marshal(acc)
+	assert.FatalError(t, err)
+
+	var reads int
+	auth, err := NewAuthority(&db.MockNoSQLDB{
+		MGet: func(bucket, key []byte) ([]byte, error) {
+			reads++
+			if string(bucket) == string(accountByKeyIDTable) {
+				return []byte(acc.ID), nil
+			}
+			return b, nil
+		},
+		MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+			return nil, true, nil
+		},
+	}, "ca.smallstep.com", "acme", nil, WithConfig(&Config{AccountCache: &AccountCacheConfig{}}))
+	assert.FatalError(t, err)
+
+	// The first request loads the account from the database.
+	res, err := auth.GetAccountByKey(context.Background(), prov, acc.Key)
+	assert.FatalError(t, err)
+	assert.Equals(t, acc.ID, res.ID)
+	assert.Equals(t, 2, reads)
+	res, err = auth.GetAccountByKey(context.Background(), prov, acc.Key)
+	assert.FatalError(t, err)
+	assert.Equals(t, acc.ID, res.ID)
+	res, err = auth.GetAccount(context.Background(), prov, acc.ID)
+	assert.FatalError(t, err)
+	assert.Equals(t, StatusValid, res.Status)
+	assert.Equals(t, 2, reads)
+
+	// Modifications are always based on the database and update the cache.
+	_, err = auth.DeactivateAccount(context.Background(), prov, acc.ID)
+	assert.FatalError(t, err)
+	assert.Equals(t, 3, reads)
+	res, err = auth.GetAccount(context.Background(), prov, acc.ID)
+	assert.FatalError(t, err)
+	assert.Equals(t, StatusDeactivated, res.Status)
+	assert.Equals(t, 3, reads)
+}
+
+func TestAuthorityAccountCache_redis(t *testing.T) {
+	m, err := miniredis.Run()
+	assert.FatalError(t, err)
+	defer m.Close()
+
+	prov := newProv()
+	acc, err := newAcc()
+	assert.FatalError(t, err)
+	kid, err := keyToID(acc.Key)
+	assert.FatalError(t, err)
+	b, err := json.Marshal(acc)
+	assert.FatalError(t, err)
+
+	var reads int
+	mdb := &db.MockNoSQLDB{
+		MGet: func(bucket, key []byte) ([]byte, error) {
+			reads++
+			if string(bucket) == string(accountByKeyIDTable) {
+				return []byte(acc.ID), nil
+			}
+			return b, nil
+		},
+		MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+			return nil, true, nil
+		},
+	}
+	config := &Config{
+		AccountCache: &AccountCacheConfig{Store: AccountCacheStoreRedis},
+		Redis:        &redis.Options{Address: m.Addr()},
+	}
+	auth, err := NewAuthority(mdb, "ca.smallstep.com", "acme", nil, WithConfig(config))
+	assert.FatalError(t, err)
+	defer auth.Stop()
+
+	// The account is cached in Redis with its key id.
+	res, err := auth.GetAccountByKey(context.Background(), prov, acc.Key)
+	assert.FatalError(t, err)
+	assert.Equals(t, acc.ID, res.ID)
+	assert.Equals(t, 2, reads)
+	id, err := m.Get("step-ca:acme_keyID_accountID_index:" + kid)
+	assert.FatalError(t, err)
+	assert.Equals(t, acc.ID, id)
+	assert.Equals(t, time.Minute, m.TTL("step-ca:acme_keyID_accountID_index:"+kid))
+
+	// Other instances share the cache.
+	other, err := NewAuthority(mdb, "ca.smallstep.com", "acme", nil, WithConfig(config))
+	assert.FatalError(t, err)
+	defer other.Stop()
+	res, err = other.GetAccountByKey(context.Background(), prov, acc.Key)
+	assert.FatalError(t, err)
+	assert.Equals(t, acc.ID, res.ID)
+	res, err = other.GetAccount(context.Background(), prov, acc.ID)
+	assert.FatalError(t, err)
+	assert.Equals(t, StatusValid, res.Status)
+	assert.Equals(t, 2, reads)
+
+	// Modifications update the cache of every instance.
+	_, err = auth.DeactivateAccount(context.Background(), prov, acc.ID)
+	assert.FatalError(t, err)
+	assert.Equals(t, 3, reads)
+	res, err = other.GetAccount(context.Background(), prov, acc.ID)
+	assert.FatalError(t, err)
+	assert.Equals(t, StatusDeactivated, res.Status)
+	assert.Equals(t, 3, reads)
+
+	// Accounts are loaded from the database if Redis is not available.
+	m.Close()
+	res, err = auth.GetAccount(context.Background(), prov, acc.ID)
+	assert.FatalError(t, err)
+	assert.Equals(t, acc.ID, res.ID)
+	assert.Equals(t, 4, reads)
+}
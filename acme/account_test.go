@@ -1,6 +1,7 @@
 package acme
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"testing"
@@ -52,6 +53,73 @@ func newAcc() (*account, error) {
 	})
 }
 
+func TestValidateContacts(t *testing.T) {
+	type test struct {
+		config   *ContactsConfig
+		contacts []string
+		err      *Error
+	}
+	tests := map[string]test{
+		"ok/disabled": {
+			contacts: []string{"tel:+12025550123", "foo"},
+		},
+		"fail/max-contacts": {
+			config:   &ContactsConfig{MaxContacts: 1},
+			contacts: []string{"mailto:foo@example.com", "mailto:bar@example.com"},
+			err:      MalformedErr(errors.New("too many contacts; the maximum is 1")),
+		},
+		"fail/unsupported-scheme": {
+			config:   &ContactsConfig{},
+			contacts: []string{"tel:+12025550123"},
+			err:      UnsupportedContactErr(errors.New("contact tel:+12025550123 is not supported; only mailto contacts are allowed")),
+		},
+		"fail/no-scheme": {
+			config:   &ContactsConfig{},
+			contacts: []string{"foo@example.com"},
+			err:      UnsupportedContactErr(errors.New("contact foo@example.com is not supported; only mailto contacts are allowed")),
+		},
+		"fail/hfields": {
+			config:   &ContactsConfig{},
+			contacts: []string{"mailto:foo@example.com?subject=hello"},
+			err:      InvalidContactErr(errors.New("contact mailto:foo@example.com?subject=hello must contain a single email address")),
+		},
+		"fail/multiple-addresses": {
+			config:   &ContactsConfig{},
+			contacts: []string{"mailto:foo@example.com,bar@example.com"},
+			err:      InvalidContactErr(errors.New("contact mailto:foo@example.com,bar@example.com must contain a single email address")),
+		},
+		"fail/invalid-email": {
+			config:   &ContactsConfig{},
+			contacts: []string{"mailto:foo"},
+			err:      InvalidContactErr(errors.New("contact mailto:foo does not contain a valid email address")),
+		},
+		"fail/display-name": {
+			config:   &ContactsConfig{},
+			contacts: []string{"mailto:Foo%20%3Cfoo@example.com%3E"},
+			err:      InvalidContactErr(errors.New("contact mailto:Foo%20%3Cfoo@example.com%3E does not contain a valid email address")),
+		},
+		"ok": {
+			config:   &ContactsConfig{MaxContacts: 2},
+			contacts: []string{"mailto:foo@example.com", "mailto:bar+acme@example.com"},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := validateContacts(tc.config, tc.contacts); err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
 func TestGetAccountByID(t *testing.T) {
 	type test struct {
 		id  string
@@ -249,86 +317,6 @@ func TestGetAccountByKeyID(t *testing.T) {
 	}
 }
 
-func TestGetAccountIDsByAccount(t *testing.T) {
-	type test struct {
-		id  string
-		db  nosql.DB
-		res []string
-		err *Error
-	}
-	tests := map[string]func(t *testing.T) test{
-		"ok/not-found": func(t *testing.T) test {
-			return test{
-				id: "foo",
-				db: &db.MockNoSQLDB{
-					MGet: func(bucket, key []byte) ([]byte, error) {
-						return nil, database.ErrNotFound
-					},
-				},
-				res: []string{},
-			}
-		},
-		"fail/db-error": func(t *testing.T) test {
-			return test{
-				id: "foo",
-				db: &db.MockNoSQLDB{
-					MGet: func(bucket, key []byte) ([]byte, error) {
-						return nil, errors.New("force")
-					},
-				},
-				err: ServerInternalErr(errors.New("error loading orderIDs for account foo: force")),
-			}
-		},
-		"fail/unmarshal-error": func(t *testing.T) test {
-			return test{
-				id: "foo",
-				db: &db.MockNoSQLDB{
-					MGet: func(bucket, key []byte) ([]byte, error) {
-						assert.Equals(t, bucket, ordersByAccountIDTable)
-						assert.Equals(t, key, []byte("foo"))
-						return nil, nil
-					},
-				},
-				err: ServerInternalErr(errors.New("error unmarshaling orderIDs for account foo: unexpected end of JSON input")),
-			}
-		},
-		"ok": func(t *testing.T) test {
-			oids := []string{"foo", "bar", "baz"}
-			b, err := json.Marshal(oids)
-			assert.FatalError(t, err)
-			return test{
-				id: "foo",
-				db: &db.MockNoSQLDB{
-					MGet: func(bucket, key []byte) ([]byte, error) {
-						assert.Equals(t, bucket, ordersByAccountIDTable)
-						assert.Equals(t, key, []byte("foo"))
-						return b, nil
-					},
-				},
-				res: oids,
-			}
-		},
-	}
-	for name, run := range tests {
-		t.Run(name, func(t *testing.T) {
-			tc := run(t)
-			if oids, err := getOrderIDsByAccount(tc.db, tc.id); err != nil {
-				if assert.NotNil(t, tc.err) {
-					ae, ok := err.(*Error)
-					assert.True(t, ok)
-					assert.HasPrefix(t, ae.Error(), tc.err.Error())
-					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
-					assert.Equals(t, ae.Type, tc.err.Type)
-				}
-			} else {
-				if assert.Nil(t, tc.err) {
-					assert.Equals(t, tc.res, oids)
-				}
-			}
-		})
-	}
-}
-
 func TestAccountToACME(t *testing.T) {
 	dir := newDirectory("ca.smallstep.com", "acme")
 	prov := newProv()
@@ -347,7 +335,7 @@ func TestAccountToACME(t *testing.T) {
 	for name, run := range tests {
 		tc := run(t)
 		t.Run(name, func(t *testing.T) {
-			acmeAccount, err := tc.acc.toACME(nil, dir, prov)
+			acmeAccount, err := tc.acc.toACME(context.Background(), nil, dir, prov)
 			if err != nil {
 				if assert.NotNil(t, tc.err) {
 					ae, ok := err.(*Error)
@@ -0,0 +1,340 @@
+package acme
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/cli/jose"
+	"github.com/smallstep/nosql"
+)
+
+// AdminInterface is the interface used by the admin API to manage ACME
+// accounts.
+type AdminInterface interface {
+	AuthorizeAdmin(*x509.Certificate) error
+	AdminDeactivateAccount(string, bool) (*AdminAccount, error)
+	AdminGetAccount(string) (*AdminAccount, error)
+	AdminGetAccountByThumbprint(string) (*AdminAccountKey, error)
+	AdminGetChallenge(string) (*AdminChallenge, error)
+	AdminListAccounts() ([]*AdminAccount, error)
+	AdminListCertificates(string) ([]*AdminCertificate, error)
+	AdminListOrders(string) ([]*AdminOrder, error)
+	AdminPurgeAccount(string) (*PurgeStats, error)
+}
+
+// AdminAccount is the representation of an ACME account in the admin API.
+type AdminAccount struct {
+	ID          string           `json:"id"`
+	Key         *jose.JSONWebKey `json:"key"`
+	Contact     []string         `json:"contact,omitempty"`
+	Status      string           `json:"status"`
+	Created     time.Time        `json:"created"`
+	Deactivated *time.Time       `json:"deactivated,omitempty"`
+}
+
+// AdminAccountKey is the result of the lookup of an ACME account by the JWK
+// thumbprint of its key.
+type AdminAccountKey struct {
+	Thumbprint string        `json:"thumbprint"`
+	Account    *AdminAccount `json:"account"`
+	OrderCount int           `json:"orderCount"`
+}
+
+// AdminOrder is the representation of an ACME order in the admin API.
+type AdminOrder struct {
+	ID            string       `json:"id"`
+	Status        string       `json:"status"`
+	Identifiers   []Identifier `json:"identifiers"`
+	Created       time.Time    `json:"created"`
+	Expires       time.Time    `json:"expires"`
+	Certificate   string       `json:"certificate,omitempty"`
+	ProvisionerID string       `json:"provisionerID,omitempty"`
+}
+
+// AdminCertificate is the representation of a certificate issued to an ACME
+// account in the admin API.
+type AdminCertificate struct {
+	ID           string    `json:"id"`
+	OrderID      string    `json:"orderID"`
+	SerialNumber string    `json:"serialNumber"`
+	Subject      string    `json:"subject"`
+	DNSNames     []string  `json:"dnsNames,omitempty"`
+	NotBefore    time.Time `json:"notBefore"`
+	NotAfter     time.Time `json:"notAfter"`
+}
+
+// AdminChallenge is the representation of an ACME challenge in the admin API.
+// Attempts contains all the validation attempts of the challenge, successful
+// or not.
+type AdminChallenge struct {
+	ID        string               `json:"id"`
+	AccountID string               `json:"accountID"`
+	AuthzID   string               `json:"authzID"`
+	Type      string               `json:"type"`
+	Status    string               `json:"status"`
+	Value     string               `json:"value"`
+	Created   time.Time            `json:"created"`
+	Validated *time.Time           `json:"validated,omitempty"`
+	Error     *AError              `json:"error,omitempty"`
+	Attempts  []*ValidationAttempt `json:"attempts,omitempty"`
+}
+
+// PurgeStats contains the number of objects deleted by the purge of an
+// account.
+type PurgeStats struct {
+	Orders     int `json:"orders"`
+	Authzs     int `json:"authzs"`
+	Challenges int `json:"challenges"`
+}
+
+func (a *account) toAdmin() *AdminAccount {
+	aa := &AdminAccount{
+		ID:      a.ID,
+		Key:     a.Key,
+		Contact: a.Contact,
+		Status:  a.Status,
+		Created: a.Created,
+	}
+	if !a.Deactivated.IsZero() {
+		t := a.Deactivated
+		aa.Deactivated = &t
+	}
+	return aa
+}
+
+func (o *order) toAdmin() *AdminOrder {
+	return &AdminOrder{
+		ID:            o.ID,
+		Status:        o.Status,
+		Identifiers:   o.Identifiers,
+		Created:       o.Created,
+		Expires:       o.Expires,
+		Certificate:   o.Certificate,
+		ProvisionerID: o.ProvisionerID,
+	}
+}
+
+func (bc *baseChallenge) toAdmin() *AdminChallenge {
+	ac := &AdminChallenge{
+		ID:        bc.ID,
+		AccountID: bc.AccountID,
+		AuthzID:   bc.AuthzID,
+		Type:      bc.Type,
+		Status:    bc.Status,
+		Value:     bc.Value,
+		Created:   bc.Created,
+		Error:     bc.Error,
+		Attempts:  bc.Attempts,
+	}
+	if !bc.Validated.IsZero() {
+		t := bc.Validated
+		ac.Validated = &t
+	}
+	return ac
+}
+
+func (c *certificate) toAdmin() (*AdminCertificate, error) {
+	crt, err := c.parseLeaf()
+	if err != nil {
+		return nil, err
+	}
+	return &AdminCertificate{
+		ID:           c.ID,
+		OrderID:      c.OrderID,
+		SerialNumber: crt.SerialNumber.String(),
+		Subject:      crt.Subject.CommonName,
+		DNSNames:     crt.DNSNames,
+		NotBefore:    crt.NotBefore,
+		NotAfter:     crt.NotAfter,
+	}, nil
+}
+
+// AuthorizeAdmin checks that the given client certificate belongs to one of
+// the configured admins.
+func (a *Authority) AuthorizeAdmin(crt *x509.Certificate) error {
+	switch {
+	case crt == nil:
+		return UnauthorizedErr(errors.New("missing client certificate"))
+	case !a.config.Admin.IsEnabled():
+		return UnauthorizedErr(errors.New("admin API is not enabled"))
+	case !a.config.Admin.IsAdmin(crt):
+		return UnauthorizedErr(errors.New("client certificate does not belong to an admin"))
+	default:
+		return nil
+	}
+}
+
+// AdminListAccounts returns all the ACME accounts sorted by creation time.
+func (a *Authority) AdminListAccounts() ([]*AdminAccount, error) {
+	entries, err := a.db.List(accountTable)
+	if err != nil {
+		return nil, ServerInternalErr(errors.Wrap(err, "error listing accounts"))
+	}
+	accs := make([]*AdminAccount, 0, len(entries))
+	for _, e := range entries {
+		acc := new(account)
+		if err := json.Unmarshal(e.Value, acc); err != nil {
+			return nil, ServerInternalErr(errors.Wrapf(err, "error unmarshaling account %s", e.Key))
+		}
+		accs = append(accs, acc.toAdmin())
+	}
+	sort.SliceStable(accs, func(i, j int) bool {
+		return accs[i].Created.Before(accs[j].Created)
+	})
+	return accs, nil
+}
+
+// AdminGetAccount returns the ACME account with the given id.
+func (a *Authority) AdminGetAccount(id string) (*AdminAccount, error) {
+	acc, err := getAccountByID(a.db, id)
+	if err != nil {
+		return nil, err
+	}
+	return acc.toAdmin(), nil
+}
+
+// AdminGetAccountByThumbprint returns the ACME account with the key that has
+// the given base64url encoded SHA-256 JWK thumbprint (RFC 7638).
+func (a *Authority) AdminGetAccountByThumbprint(thumbprint string) (*AdminAccountKey, error) {
+	if b, err := base64.RawURLEncoding.DecodeString(thumbprint); err != nil || len(b) != sha256.Size {
+		return nil, MalformedErr(errors.Errorf("thumbprint %s is not a valid base64url encoded SHA-256 thumbprint", thumbprint))
+	}
+	acc, err := getAccountByKeyID(a.db, thumbprint)
+	if err != nil {
+		return nil, err
+	}
+	oids, err := getOrderIDsByAccount(a.db, acc.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &AdminAccountKey{
+		Thumbprint: thumbprint,
+		Account:    acc.toAdmin(),
+		OrderCount: len(oids),
+	}, nil
+}
+
+// AdminGetChallenge returns the ACME challenge with the given id, together
+// with its validation attempts.
+func (a *Authority) AdminGetChallenge(id string) (*AdminChallenge, error) {
+	ch, err := getChallenge(a.db, id)
+	if err != nil {
+		return nil, err
+	}
+	return ch.clone().toAdmin(), nil
+}
+
+// AdminListOrders returns the orders of the ACME account with the given id.
+func (a *Authority) AdminListOrders(id string) ([]*AdminOrder, error) {
+	orders, err := getOrdersByAccount(a.db, id)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*AdminOrder, len(orders))
+	for i, o := range orders {
+		ret[i] = o.toAdmin()
+	}
+	return ret, nil
+}
+
+// AdminListCertificates returns the certificates issued to the ACME account
+// with the given id.
+func (a *Authority) AdminListCertificates(id string) ([]*AdminCertificate, error) {
+	orders, err := getOrdersByAccount(a.db, id)
+	if err != nil {
+		return nil, err
+	}
+	ret := []*AdminCertificate{}
+	for _, o := range orders {
+		if o.Certificate == "" {
+			continue
+		}
+		cert, err := getCert(a.db, o.Certificate)
+		if err != nil {
+			return nil, err
+		}
+		ac, err := cert.toAdmin()
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, ac)
+	}
+	return ret, nil
+}
+
+// AdminDeactivateAccount deactivates the ACME account with the given id. An
+// account that is already deactivated is returned as it is. If revoke is true,
+// the unexpired certificates of the account are revoked first, like in the
+// deactivations of the accounts of the provisioners with revokeOnDeactivation,
+// so a failed revocation can be retried.
+func (a *Authority) AdminDeactivateAccount(id string, revoke bool) (*AdminAccount, error) {
+	acc, err := getAccountByID(a.db, id)
+	if err != nil {
+		return nil, err
+	}
+	if revoke {
+		if err := a.revokeAccountCertificates(id); err != nil {
+			return nil, err
+		}
+	}
+	if acc.Status != StatusDeactivated {
+		acc, err = acc.deactivate(a.db)
+		a.accountChanged(id, acc, err)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return acc.toAdmin(), nil
+}
+
+// AdminPurgeAccount deletes the pending and ready orders of the ACME account
+// with the given id, together with their authorizations and challenges.
+func (a *Authority) AdminPurgeAccount(id string) (*PurgeStats, error) {
+	if _, err := getAccountByID(a.db, id); err != nil {
+		return nil, err
+	}
+	orders, err := getOrdersByAccount(a.db, id)
+	if err != nil {
+		return nil, err
+	}
+	stats := new(cleanupStats)
+	var deleted []string
+	for _, o := range orders {
+		if o.Status != StatusPending && o.Status != StatusReady {
+			continue
+		}
+		for _, azID := range o.Authorizations {
+			if err := deleteAuthz(a.db, azID, stats); err != nil {
+				return nil, err
+			}
+		}
+		if err := a.db.Del(orderTable, []byte(o.ID)); err != nil && !nosql.IsErrNotFound(err) {
+			return nil, ServerInternalErr(errors.Wrapf(err, "error deleting order %s", o.ID))
+		}
+		stats.Orders++
+		deleted = append(deleted, o.ID)
+	}
+	if err := removeOrderIDs(a.db, id, deleted); err != nil {
+		return nil, err
+	}
+	return &PurgeStats{
+		Orders:     stats.Orders,
+		Authzs:     stats.Authzs,
+		Challenges: stats.Challenges,
+	}, nil
+}
+
+// getOrdersByAccount returns the orders in the orders-by-account index of the
+// account with the given id.
+func getOrdersByAccount(db nosql.DB, id string) ([]*order, error) {
+	oids, err := getOrderIDsByAccount(db, id)
+	if err != nil {
+		return nil, err
+	}
+	return getOrders(db, oids)
+}
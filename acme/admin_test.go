@@ -0,0 +1,537 @@
+package acme
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/admin"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/cli/crypto/pemutil"
+	"github.com/smallstep/cli/jose"
+	"github.com/smallstep/nosql/database"
+)
+
+func assertAcmeError(t *testing.T, want *Error, err error) {
+	t.Helper()
+	if assert.NotNil(t, want) {
+		ae, ok := err.(*Error)
+		assert.True(t, ok)
+		assert.HasPrefix(t, ae.Error(), want.Error())
+		assert.Equals(t, ae.StatusCode(), want.StatusCode())
+		assert.Equals(t, ae.Type, want.Type)
+	}
+}
+
+func TestAuthorityAuthorizeAdmin(t *testing.T) {
+	sum := sha256.Sum256([]byte("certificate"))
+	crt := &x509.Certificate{Raw: []byte("certificate"), Subject: pkix.Name{CommonName: "admin"}}
+	type test struct {
+		config *Config
+		crt    *x509.Certificate
+		err    *Error
+	}
+	tests := map[string]test{
+		"fail/disabled": {
+			config: &Config{},
+			crt:    crt,
+			err:    UnauthorizedErr(errors.New("admin API is not enabled")),
+		},
+		"fail/nil-crt": {
+			config: &Config{Admin: &admin.Config{Admins: []*admin.Admin{{Fingerprint: hex.EncodeToString(sum[:])}}}},
+			err:    UnauthorizedErr(errors.New("missing client certificate")),
+		},
+		"fail/not-admin": {
+			config: &Config{Admin: &admin.Config{Admins: []*admin.Admin{{Subject: "admin", Provisioner: "admin", ProvisionerType: "JWK"}}}},
+			crt:    crt,
+			err:    UnauthorizedErr(errors.New("client certificate does not belong to an admin")),
+		},
+		"ok": {
+			config: &Config{Admin: &admin.Config{Admins: []*admin.Admin{{Fingerprint: hex.EncodeToString(sum[:])}}}},
+			crt:    crt,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			auth, err := NewAuthority(&db.MockNoSQLDB{}, "ca.smallstep.com", "acme", nil, WithConfig(tc.config))
+			assert.FatalError(t, err)
+			if err := auth.AuthorizeAdmin(tc.crt); err != nil {
+				assertAcmeError(t, tc.err, err)
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestAuthorityAdminListAccounts(t *testing.T) {
+	now := clock.Now()
+	marshal := func(v interface{}) []byte {
+		b, err := json.Marshal(v)
+		assert.FatalError(t, err)
+		return b
+	}
+	type test struct {
+		db   *db.MockNoSQLDB
+		accs []*AdminAccount
+		err  *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/list-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MList: func(bucket []byte) ([]*database.Entry, error) {
+						assert.Equals(t, bucket, accountTable)
+						return nil, errors.New("force")
+					},
+				},
+				err: ServerInternalErr(errors.New("error listing accounts: force")),
+			}
+		},
+		"fail/unmarshal-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MList: func(bucket []byte) ([]*database.Entry, error) {
+						return []*database.Entry{{Bucket: accountTable, Key: []byte("foo"), Value: []byte("{")}}, nil
+					},
+				},
+				err: ServerInternalErr(errors.New("error unmarshaling account foo")),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			deactivated := now.Add(-time.Minute)
+			tables := map[string]map[string][]byte{
+				string(accountTable): {
+					"acc1": marshal(&account{ID: "acc1", Status: StatusValid, Created: now}),
+					"acc2": marshal(&account{ID: "acc2", Status: StatusDeactivated, Created: now.Add(-time.Hour), Deactivated: deactivated}),
+				},
+			}
+			return test{
+				db: newCleanupDB(tables),
+				accs: []*AdminAccount{
+					{ID: "acc2", Status: StatusDeactivated, Created: now.Add(-time.Hour), Deactivated: &deactivated},
+					{ID: "acc1", Status: StatusValid, Created: now},
+				},
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			auth, err := NewAuthority(tc.db, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			if accs, err := auth.AdminListAccounts(); err != nil {
+				assertAcmeError(t, tc.err, err)
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, marshal(tc.accs), marshal(accs))
+			}
+		})
+	}
+}
+
+func TestAuthorityAdminGetAccountByThumbprint(t *testing.T) {
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	kid, err := keyToID(jwk)
+	assert.FatalError(t, err)
+	now := clock.Now()
+	marshal := func(v interface{}) []byte {
+		b, err := json.Marshal(v)
+		assert.FatalError(t, err)
+		return b
+	}
+	tables := map[string]map[string][]byte{
+		string(accountTable): {
+			"accID": marshal(&account{ID: "accID", Status: StatusValid, Contact: []string{"mailto:admin@example.com"}, Created: now}),
+		},
+		string(accountByKeyIDTable): {
+			kid: []byte("accID"),
+		},
+		string(ordersByAccountIDTable): {
+			"accID": marshal([]string{"ord1", "ord2"}),
+		},
+	}
+	type test struct {
+		thumbprint string
+		ak         *AdminAccountKey
+		err        *Error
+	}
+	tests := map[string]test{
+		"fail/not-base64url": {
+			thumbprint: "not+base64",
+			err:        MalformedErr(errors.New("thumbprint not+base64 is not a valid base64url encoded SHA-256 thumbprint")),
+		},
+		"fail/not-sha256": {
+			thumbprint: "Zm9v",
+			err:        MalformedErr(errors.New("thumbprint Zm9v is not a valid base64url encoded SHA-256 thumbprint")),
+		},
+		"fail/not-found": {
+			thumbprint: "47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU",
+			err:        MalformedErr(errors.New("account with key id 47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU not found")),
+		},
+		"ok": {
+			thumbprint: kid,
+			ak: &AdminAccountKey{
+				Thumbprint: kid,
+				Account:    &AdminAccount{ID: "accID", Status: StatusValid, Contact: []string{"mailto:admin@example.com"}, Created: now},
+				OrderCount: 2,
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			auth, err := NewAuthority(newCleanupDB(tables), "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			if ak, err := auth.AdminGetAccountByThumbprint(tc.thumbprint); err != nil {
+				assertAcmeError(t, tc.err, err)
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, tc.ak.Thumbprint, ak.Thumbprint)
+				assert.Equals(t, tc.ak.OrderCount, ak.OrderCount)
+				assert.Equals(t, tc.ak.Account.ID, ak.Account.ID)
+				assert.Equals(t, tc.ak.Account.Contact, ak.Account.Contact)
+				assert.Equals(t, tc.ak.Account.Status, ak.Account.Status)
+			}
+		})
+	}
+}
+
+func TestAuthorityAdminGetChallenge(t *testing.T) {
+	now := clock.Now()
+	marshal := func(v interface{}) []byte {
+		b, err := json.Marshal(v)
+		assert.FatalError(t, err)
+		return b
+	}
+	verr := ConnectionErr(errors.New("force")).ToACME()
+	attempts := []*ValidationAttempt{
+		{Time: now.Add(-time.Minute), Vantage: "local", TargetIP: "192.0.2.1", Error: verr},
+		{Time: now, Vantage: "local", TargetIP: "192.0.2.1", ResponseDigest: "digest"},
+	}
+	tables := map[string]map[string][]byte{
+		string(challengeTable): {
+			"invalid": marshal(&baseChallenge{ID: "invalid", Type: "http-01", Status: StatusProcessing, Value: "zap.internal", Created: now, Error: verr, Attempts: attempts[:1]}),
+			"valid":   marshal(&baseChallenge{ID: "valid", Type: "http-01", Status: StatusValid, Value: "zap.internal", Created: now, Validated: now, Attempts: attempts}),
+		},
+	}
+	type test struct {
+		id  string
+		ch  *AdminChallenge
+		err *Error
+	}
+	tests := map[string]test{
+		"fail/not-found": {
+			id:  "foo",
+			err: MalformedErr(errors.New("challenge foo not found")),
+		},
+		"ok/processing": {
+			id: "invalid",
+			ch: &AdminChallenge{ID: "invalid", Type: "http-01", Status: StatusProcessing, Value: "zap.internal", Created: now, Error: verr, Attempts: attempts[:1]},
+		},
+		"ok/valid": {
+			id: "valid",
+			ch: &AdminChallenge{ID: "valid", Type: "http-01", Status: StatusValid, Value: "zap.internal", Created: now, Validated: &now, Attempts: attempts},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			auth, err := NewAuthority(newCleanupDB(tables), "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			if ch, err := auth.AdminGetChallenge(tc.id); err != nil {
+				assertAcmeError(t, tc.err, err)
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, marshal(tc.ch), marshal(ch))
+			}
+		})
+	}
+}
+
+func TestAuthorityAdminDeactivateAccount(t *testing.T) {
+	deactivated := clock.Now().Add(-time.Hour)
+	valid, err := pemutil.ReadCertificate("../authority/testdata/certs/intermediate_ca.crt")
+	assert.FatalError(t, err)
+	type test struct {
+		db       *db.MockNoSQLDB
+		signAuth SignAuthority
+		id       string
+		revoke   bool
+		revoked  *int
+		status   string
+		err      *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/revoke-error": func(t *testing.T) test {
+			acc, err := newAcc()
+			assert.FatalError(t, err)
+			b, err := json.Marshal(acc)
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return b, nil
+					},
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						assert.FatalError(t, errors.New("account should not be saved"))
+						return nil, false, nil
+					},
+				},
+				signAuth: &mockSignAuth{},
+				id:       acc.ID,
+				revoke:   true,
+				err:      ServerInternalErr(errors.New("certificate authority does not support revocation")),
+			}
+		},
+		"fail/not-found": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, database.ErrNotFound
+					},
+				},
+				id:  "foo",
+				err: MalformedErr(errors.New("account foo not found")),
+			}
+		},
+		"fail/save-error": func(t *testing.T) test {
+			acc, err := newAcc()
+			assert.FatalError(t, err)
+			b, err := json.Marshal(acc)
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return b, nil
+					},
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						return nil, false, errors.New("force")
+					},
+				},
+				id:  acc.ID,
+				err: ServerInternalErr(errors.New("error storing account: force")),
+			}
+		},
+		"ok/already-deactivated": func(t *testing.T) test {
+			acc, err := newAcc()
+			assert.FatalError(t, err)
+			acc.Status = StatusDeactivated
+			acc.Deactivated = deactivated
+			b, err := json.Marshal(acc)
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return b, nil
+					},
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						assert.FatalError(t, errors.New("account should not be saved"))
+						return nil, false, nil
+					},
+				},
+				id:     acc.ID,
+				status: StatusDeactivated,
+			}
+		},
+		"ok": func(t *testing.T) test {
+			acc, err := newAcc()
+			assert.FatalError(t, err)
+			b, err := json.Marshal(acc)
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, bucket, accountTable)
+						return b, nil
+					},
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						assert.Equals(t, bucket, accountTable)
+						assert.Equals(t, key, []byte(acc.ID))
+						assert.Equals(t, old, b)
+						return nil, true, nil
+					},
+				},
+				id:     acc.ID,
+				status: StatusDeactivated,
+			}
+		},
+		"ok/revoke": func(t *testing.T) test {
+			acc, err := newAcc()
+			assert.FatalError(t, err)
+			b, err := json.Marshal(acc)
+			assert.FatalError(t, err)
+			tables := map[string]map[string][]byte{
+				string(accountTable):      {acc.ID: b},
+				string(certTable):         {},
+				string(accountCertsTable): {},
+			}
+			_, err = newCert(newCleanupDB(tables), CertOptions{AccountID: acc.ID, OrderID: "ordID", Leaf: valid})
+			assert.FatalError(t, err)
+			var revoked int
+			return test{
+				db: newCleanupDB(tables),
+				signAuth: &mockRevokeAuthority{
+					revoke: func(crt *x509.Certificate, reasonCode int, reason string) error {
+						assert.Equals(t, valid, crt)
+						assert.Equals(t, 9, reasonCode)
+						revoked++
+						return nil
+					},
+				},
+				id:      acc.ID,
+				revoke:  true,
+				revoked: &revoked,
+				status:  StatusDeactivated,
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			auth, err := NewAuthority(tc.db, "ca.smallstep.com", "acme", tc.signAuth)
+			assert.FatalError(t, err)
+			if acc, err := auth.AdminDeactivateAccount(tc.id, tc.revoke); err != nil {
+				assertAcmeError(t, tc.err, err)
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, tc.id, acc.ID)
+				assert.Equals(t, tc.status, acc.Status)
+				assert.NotNil(t, acc.Deactivated)
+				if tc.revoked != nil {
+					assert.Equals(t, 1, *tc.revoked)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthorityAdminListCertificates(t *testing.T) {
+	leaf, err := pemutil.ReadCertificate("../authority/testdata/certs/foo.crt")
+	assert.FatalError(t, err)
+	marshal := func(v interface{}) []byte {
+		b, err := json.Marshal(v)
+		assert.FatalError(t, err)
+		return b
+	}
+	type test struct {
+		tables map[string]map[string][]byte
+		certs  []*AdminCertificate
+		err    *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/missing-order": func(t *testing.T) test {
+			return test{
+				tables: map[string]map[string][]byte{
+					string(ordersByAccountIDTable): {"acc": marshal([]string{"o1"})},
+				},
+				err: MalformedErr(errors.New("order o1 not found")),
+			}
+		},
+		"fail/parse-error": func(t *testing.T) test {
+			return test{
+				tables: map[string]map[string][]byte{
+					string(ordersByAccountIDTable): {"acc": marshal([]string{"o1"})},
+					string(orderTable):             {"o1": marshal(&order{ID: "o1", Certificate: "c1"})},
+					string(certTable):              {"c1": marshal(&certificate{ID: "c1", Leaf: []byte("foo")})},
+				},
+				err: ServerInternalErr(errors.New("error decoding certificate c1")),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				tables: map[string]map[string][]byte{
+					string(ordersByAccountIDTable): {"acc": marshal([]string{"o1", "o2"})},
+					string(orderTable): {
+						"o1": marshal(&order{ID: "o1", Status: StatusPending}),
+						"o2": marshal(&order{ID: "o2", Status: StatusValid, Certificate: "c1"}),
+					},
+					string(certTable): {"c1": marshal(&certificate{
+						ID:      "c1",
+						OrderID: "o2",
+						Leaf:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw}),
+					})},
+				},
+				certs: []*AdminCertificate{{
+					ID:           "c1",
+					OrderID:      "o2",
+					SerialNumber: leaf.SerialNumber.String(),
+					Subject:      leaf.Subject.CommonName,
+					DNSNames:     leaf.DNSNames,
+					NotBefore:    leaf.NotBefore,
+					NotAfter:     leaf.NotAfter,
+				}},
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			auth, err := NewAuthority(newCleanupDB(tc.tables), "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			if certs, err := auth.AdminListCertificates("acc"); err != nil {
+				assertAcmeError(t, tc.err, err)
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, tc.certs, certs)
+			}
+		})
+	}
+}
+
+func TestAuthorityAdminPurgeAccount(t *testing.T) {
+	marshal := func(v interface{}) []byte {
+		b, err := json.Marshal(v)
+		assert.FatalError(t, err)
+		return b
+	}
+	keys := func(m map[string][]byte) []string {
+		var ret []string
+		for k := range m {
+			ret = append(ret, k)
+		}
+		sort.Strings(ret)
+		return ret
+	}
+	newAuthz := func(id string, chs ...string) []byte {
+		return marshal(&baseAuthz{ID: id, Identifier: Identifier{Type: "dns", Value: "example.com"}, Challenges: chs})
+	}
+	tables := map[string]map[string][]byte{
+		string(accountTable): {"acc": marshal(&account{ID: "acc", Status: StatusValid})},
+		string(orderTable): {
+			"o1": marshal(&order{ID: "o1", AccountID: "acc", Status: StatusPending, Authorizations: []string{"az1"}}),
+			"o2": marshal(&order{ID: "o2", AccountID: "acc", Status: StatusReady, Authorizations: []string{"az2"}}),
+			"o3": marshal(&order{ID: "o3", AccountID: "acc", Status: StatusValid, Authorizations: []string{"az3"}}),
+		},
+		string(authzTable): {
+			"az1": newAuthz("az1", "ch1", "ch2"),
+			"az2": newAuthz("az2", "ch3"),
+			"az3": newAuthz("az3", "ch4"),
+		},
+		string(challengeTable): {
+			"ch1": []byte("{}"), "ch2": []byte("{}"), "ch3": []byte("{}"), "ch4": []byte("{}"),
+		},
+		string(ordersByAccountIDTable): {"acc": marshal([]string{"o1", "o2", "o3"})},
+	}
+
+	t.Run("fail/not-found", func(t *testing.T) {
+		auth, err := NewAuthority(newCleanupDB(tables), "ca.smallstep.com", "acme", nil)
+		assert.FatalError(t, err)
+		_, err = auth.AdminPurgeAccount("foo")
+		assertAcmeError(t, MalformedErr(errors.New("account foo not found")), err)
+	})
+
+	t.Run("ok", func(t *testing.T) {
+		auth, err := NewAuthority(newCleanupDB(tables), "ca.smallstep.com", "acme", nil)
+		assert.FatalError(t, err)
+		stats, err := auth.AdminPurgeAccount("acc")
+		assert.FatalError(t, err)
+		assert.Equals(t, &PurgeStats{Orders: 2, Authzs: 2, Challenges: 3}, stats)
+		assert.Equals(t, []string{"o3"}, keys(tables[string(orderTable)]))
+		assert.Equals(t, []string{"az3"}, keys(tables[string(authzTable)]))
+		assert.Equals(t, []string{"ch4"}, keys(tables[string(challengeTable)]))
+		assert.Equals(t, marshal([]string{"o3"}), tables[string(ordersByAccountIDTable)]["acc"])
+	})
+}
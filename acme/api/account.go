@@ -8,6 +8,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/acme"
 	"github.com/smallstep/certificates/api"
+	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/logging"
 )
 
@@ -107,25 +108,31 @@ func (h *Handler) NewAccount(w http.ResponseWriter, r *http.Request) {
 			api.WriteError(w, acme.AccountDoesNotExistErr(nil))
 			return
 		}
+		if p, ok := prov.(*provisioner.ACME); ok && p.RequireTermsOfServiceAgreed && !nar.TermsOfServiceAgreed {
+			w.Header().Add("Link", link(p.TermsOfService, "terms-of-service"))
+			api.WriteError(w, acme.UserActionRequiredErr(errors.New("terms of service must be agreed to create an account")))
+			return
+		}
 		jwk, err := jwkFromContext(r)
 		if err != nil {
 			api.WriteError(w, err)
 			return
 		}
 
-		if acc, err = h.Auth.NewAccount(prov, acme.AccountOptions{
+		if acc, err = h.Auth.NewAccount(r.Context(), prov, acme.AccountOptions{
 			Key:     jwk,
 			Contact: nar.Contact,
 		}); err != nil {
 			api.WriteError(w, err)
 			return
 		}
+		logAccount(w, acc)
 	} else {
 		// Account exists //
 		httpStatus = http.StatusOK
 	}
 
-	w.Header().Set("Location", h.Auth.GetLink(acme.AccountLink,
+	w.Header().Set("Location", h.Auth.GetLink(r.Context(), acme.AccountLink,
 		acme.URLSafeProvisionerName(prov), true, acc.GetID()))
 	api.JSONStatus(w, acc, httpStatus)
 }
@@ -160,16 +167,16 @@ func (h *Handler) GetUpdateAccount(w http.ResponseWriter, r *http.Request) {
 		}
 		var err error
 		if uar.IsDeactivateRequest() {
-			acc, err = h.Auth.DeactivateAccount(prov, acc.GetID())
+			acc, err = h.Auth.DeactivateAccount(r.Context(), prov, acc.GetID())
 		} else {
-			acc, err = h.Auth.UpdateAccount(prov, acc.GetID(), uar.Contact)
+			acc, err = h.Auth.UpdateAccount(r.Context(), prov, acc.GetID(), uar.Contact)
 		}
 		if err != nil {
 			api.WriteError(w, err)
 			return
 		}
 	}
-	w.Header().Set("Location", h.Auth.GetLink(acme.AccountLink, acme.URLSafeProvisionerName(prov), true, acc.GetID()))
+	w.Header().Set("Location", h.Auth.GetLink(r.Context(), acme.AccountLink, acme.URLSafeProvisionerName(prov), true, acc.GetID()))
 	api.JSON(w, acc)
 }
 
@@ -200,7 +207,7 @@ func (h *Handler) GetOrdersByAccount(w http.ResponseWriter, r *http.Request) {
 		api.WriteError(w, acme.UnauthorizedErr(errors.New("account ID does not match url param")))
 		return
 	}
-	orders, err := h.Auth.GetOrdersByAccount(prov, acc.GetID())
+	orders, err := h.Auth.GetOrdersByAccount(r.Context(), prov, acc.GetID())
 	if err != nil {
 		api.WriteError(w, err)
 		return
@@ -312,6 +312,7 @@ func TestHandlerNewAccount(t *testing.T) {
 		ctx        context.Context
 		statusCode int
 		problem    *acme.Error
+		link       []string
 	}
 	var tests = map[string]func(t *testing.T) test{
 		"fail/no-provisioner": func(t *testing.T) test {
@@ -411,6 +412,28 @@ func TestHandlerNewAccount(t *testing.T) {
 				problem:    acme.ServerInternalErr(errors.Errorf("jwk expected in request context")),
 			}
 		},
+		"fail/terms-of-service-not-agreed": func(t *testing.T) test {
+			nar := &NewAccountRequest{
+				Contact: []string{"foo", "bar"},
+			}
+			b, err := json.Marshal(nar)
+			assert.FatalError(t, err)
+			tosProv := &provisioner.ACME{
+				Type:                        "ACME",
+				Name:                        "test@acme-provisioner.com",
+				TermsOfService:              "https://ca.smallstep.com/tos",
+				RequireTermsOfServiceAgreed: true,
+			}
+			assert.FatalError(t, tosProv.Init(provisioner.Config{Claims: globalProvisionerClaims}))
+			ctx := context.WithValue(context.Background(), provisionerContextKey, tosProv)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{value: b})
+			return test{
+				ctx:        ctx,
+				statusCode: 400,
+				problem:    acme.UserActionRequiredErr(errors.New("terms of service must be agreed to create an account")),
+				link:       []string{`<https://ca.smallstep.com/tos>;rel="terms-of-service"`},
+			}
+		},
 		"fail/NewAccount-error": func(t *testing.T) test {
 			nar := &NewAccountRequest{
 				Contact: []string{"foo", "bar"},
@@ -519,6 +542,7 @@ func TestHandlerNewAccount(t *testing.T) {
 				assert.Equals(t, ae.Identifier, prob.Identifier)
 				assert.Equals(t, ae.Subproblems, prob.Subproblems)
 				assert.Equals(t, res.Header["Content-Type"], []string{"application/problem+json"})
+				assert.Equals(t, res.Header["Link"], tc.link)
 			} else {
 				expB, err := json.Marshal(acc)
 				assert.FatalError(t, err)
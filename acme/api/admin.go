@@ -0,0 +1,131 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/api"
+)
+
+// NewAdmin returns a new router for the ACME admin API.
+func NewAdmin(auth acme.AdminInterface) api.RouterHandler {
+	return &AdminHandler{auth}
+}
+
+// AdminHandler is the ACME admin API request handler. All the requests must
+// be authenticated with a client certificate of an admin.
+type AdminHandler struct {
+	Auth acme.AdminInterface
+}
+
+// Route traffic and implement the Router interface.
+func (h *AdminHandler) Route(r api.Router) {
+	r.MethodFunc("GET", "/accounts", h.authorize(h.ListAccounts))
+	r.MethodFunc("GET", "/accounts/{accID}", h.authorize(h.GetAccount))
+	r.MethodFunc("GET", "/accounts/{accID}/orders", h.authorize(h.ListOrders))
+	r.MethodFunc("GET", "/accounts/{accID}/certificates", h.authorize(h.ListCertificates))
+	r.MethodFunc("POST", "/accounts/{accID}/deactivate", h.authorize(h.DeactivateAccount))
+	r.MethodFunc("POST", "/accounts/{accID}/purge", h.authorize(h.PurgeAccount))
+	r.MethodFunc("GET", "/keys/{thumbprint}", h.authorize(h.GetAccountByThumbprint))
+	r.MethodFunc("GET", "/challenges/{chID}", h.authorize(h.GetChallenge))
+}
+
+// authorize is a middleware that checks that the request has been made over
+// mTLS with the certificate of an admin.
+func (h *AdminHandler) authorize(next nextHTTP) nextHTTP {
+	return api.AuthorizeAdmin(h.Auth.AuthorizeAdmin, next)
+}
+
+// ListAccounts returns all the ACME accounts.
+func (h *AdminHandler) ListAccounts(w http.ResponseWriter, r *http.Request) {
+	accs, err := h.Auth.AdminListAccounts()
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	api.JSON(w, accs)
+}
+
+// GetAccount returns an ACME account.
+func (h *AdminHandler) GetAccount(w http.ResponseWriter, r *http.Request) {
+	acc, err := h.Auth.AdminGetAccount(chi.URLParam(r, "accID"))
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	api.JSON(w, acc)
+}
+
+// GetAccountByThumbprint returns the ACME account with the key that has the
+// given JWK thumbprint, and the number of orders of the account.
+func (h *AdminHandler) GetAccountByThumbprint(w http.ResponseWriter, r *http.Request) {
+	ak, err := h.Auth.AdminGetAccountByThumbprint(chi.URLParam(r, "thumbprint"))
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	api.JSON(w, ak)
+}
+
+// GetChallenge returns an ACME challenge and its validation attempts.
+func (h *AdminHandler) GetChallenge(w http.ResponseWriter, r *http.Request) {
+	ch, err := h.Auth.AdminGetChallenge(chi.URLParam(r, "chID"))
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	api.JSON(w, ch)
+}
+
+// ListOrders returns the orders of an ACME account.
+func (h *AdminHandler) ListOrders(w http.ResponseWriter, r *http.Request) {
+	orders, err := h.Auth.AdminListOrders(chi.URLParam(r, "accID"))
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	api.JSON(w, orders)
+}
+
+// ListCertificates returns the certificates issued to an ACME account.
+func (h *AdminHandler) ListCertificates(w http.ResponseWriter, r *http.Request) {
+	certs, err := h.Auth.AdminListCertificates(chi.URLParam(r, "accID"))
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	api.JSON(w, certs)
+}
+
+// DeactivateAccount deactivates an ACME account. With the revoke=true query
+// parameter the unexpired certificates of the account are revoked too.
+func (h *AdminHandler) DeactivateAccount(w http.ResponseWriter, r *http.Request) {
+	var revoke bool
+	if v := r.URL.Query().Get("revoke"); v != "" {
+		var err error
+		if revoke, err = strconv.ParseBool(v); err != nil {
+			api.WriteError(w, acme.MalformedErr(errors.Wrapf(err, "error parsing revoke %s", v)))
+			return
+		}
+	}
+	acc, err := h.Auth.AdminDeactivateAccount(chi.URLParam(r, "accID"), revoke)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	api.JSON(w, acc)
+}
+
+// PurgeAccount deletes the pending orders, authorizations and challenges of
+// an ACME account.
+func (h *AdminHandler) PurgeAccount(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.Auth.AdminPurgeAccount(chi.URLParam(r, "accID"))
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	api.JSON(w, stats)
+}
@@ -0,0 +1,331 @@
+package api
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/acme"
+)
+
+type mockAdminAuthority struct {
+	authorizeAdmin         func(*x509.Certificate) error
+	adminDeactivateAccount func(string, bool) (*acme.AdminAccount, error)
+	adminGetAccount        func(string) (*acme.AdminAccount, error)
+	adminGetAccountByKey   func(string) (*acme.AdminAccountKey, error)
+	adminGetChallenge      func(string) (*acme.AdminChallenge, error)
+	adminListAccounts      func() ([]*acme.AdminAccount, error)
+	adminListCertificates  func(string) ([]*acme.AdminCertificate, error)
+	adminListOrders        func(string) ([]*acme.AdminOrder, error)
+	adminPurgeAccount      func(string) (*acme.PurgeStats, error)
+}
+
+func (m *mockAdminAuthority) AuthorizeAdmin(crt *x509.Certificate) error {
+	if m.authorizeAdmin != nil {
+		return m.authorizeAdmin(crt)
+	}
+	if crt == nil {
+		return acme.UnauthorizedErr(errors.New("missing client certificate"))
+	}
+	return nil
+}
+
+func (m *mockAdminAuthority) AdminDeactivateAccount(id string, revoke bool) (*acme.AdminAccount, error) {
+	return m.adminDeactivateAccount(id, revoke)
+}
+
+func (m *mockAdminAuthority) AdminGetAccount(id string) (*acme.AdminAccount, error) {
+	return m.adminGetAccount(id)
+}
+
+func (m *mockAdminAuthority) AdminGetAccountByThumbprint(thumbprint string) (*acme.AdminAccountKey, error) {
+	return m.adminGetAccountByKey(thumbprint)
+}
+
+func (m *mockAdminAuthority) AdminGetChallenge(id string) (*acme.AdminChallenge, error) {
+	return m.adminGetChallenge(id)
+}
+
+func (m *mockAdminAuthority) AdminListAccounts() ([]*acme.AdminAccount, error) {
+	return m.adminListAccounts()
+}
+
+func (m *mockAdminAuthority) AdminListCertificates(id string) ([]*acme.AdminCertificate, error) {
+	return m.adminListCertificates(id)
+}
+
+func (m *mockAdminAuthority) AdminListOrders(id string) ([]*acme.AdminOrder, error) {
+	return m.adminListOrders(id)
+}
+
+func (m *mockAdminAuthority) AdminPurgeAccount(id string) (*acme.PurgeStats, error) {
+	return m.adminPurgeAccount(id)
+}
+
+func TestAdminHandler(t *testing.T) {
+	admin := &x509.Certificate{Subject: pkix.Name{CommonName: "admin"}}
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{admin}}}
+	acc := &acme.AdminAccount{ID: "accID", Status: "valid"}
+
+	type test struct {
+		auth       acme.AdminInterface
+		method     string
+		path       string
+		tls        *tls.ConnectionState
+		statusCode int
+		problem    *acme.Error
+		resp       interface{}
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/no-tls": func(t *testing.T) test {
+			return test{
+				auth:       &mockAdminAuthority{},
+				method:     "GET",
+				path:       "/accounts",
+				statusCode: 401,
+				problem:    acme.UnauthorizedErr(errors.New("missing client certificate")),
+			}
+		},
+		"fail/no-verified-chains": func(t *testing.T) test {
+			return test{
+				auth:       &mockAdminAuthority{},
+				method:     "GET",
+				path:       "/accounts",
+				tls:        &tls.ConnectionState{PeerCertificates: []*x509.Certificate{admin}},
+				statusCode: 401,
+				problem:    acme.UnauthorizedErr(errors.New("missing client certificate")),
+			}
+		},
+		"fail/not-admin": func(t *testing.T) test {
+			return test{
+				auth: &mockAdminAuthority{
+					authorizeAdmin: func(crt *x509.Certificate) error {
+						assert.Equals(t, admin, crt)
+						return acme.UnauthorizedErr(errors.New("client certificate does not belong to an admin"))
+					},
+				},
+				method:     "GET",
+				path:       "/accounts",
+				tls:        verified,
+				statusCode: 401,
+				problem:    acme.UnauthorizedErr(errors.New("client certificate does not belong to an admin")),
+			}
+		},
+		"fail/getAccount-error": func(t *testing.T) test {
+			return test{
+				auth: &mockAdminAuthority{
+					adminGetAccount: func(id string) (*acme.AdminAccount, error) {
+						return nil, acme.MalformedErr(errors.Errorf("account %s not found", id))
+					},
+				},
+				method:     "GET",
+				path:       "/accounts/foo",
+				tls:        verified,
+				statusCode: 400,
+				problem:    acme.MalformedErr(errors.New("account foo not found")),
+			}
+		},
+		"ok/listAccounts": func(t *testing.T) test {
+			return test{
+				auth: &mockAdminAuthority{
+					adminListAccounts: func() ([]*acme.AdminAccount, error) {
+						return []*acme.AdminAccount{acc}, nil
+					},
+				},
+				method:     "GET",
+				path:       "/accounts",
+				tls:        verified,
+				statusCode: 200,
+				resp:       []*acme.AdminAccount{acc},
+			}
+		},
+		"ok/getAccount": func(t *testing.T) test {
+			return test{
+				auth: &mockAdminAuthority{
+					adminGetAccount: func(id string) (*acme.AdminAccount, error) {
+						assert.Equals(t, "accID", id)
+						return acc, nil
+					},
+				},
+				method:     "GET",
+				path:       "/accounts/accID",
+				tls:        verified,
+				statusCode: 200,
+				resp:       acc,
+			}
+		},
+		"ok/getAccountByThumbprint": func(t *testing.T) test {
+			ak := &acme.AdminAccountKey{Thumbprint: "thumbprint", Account: acc, OrderCount: 2}
+			return test{
+				auth: &mockAdminAuthority{
+					adminGetAccountByKey: func(thumbprint string) (*acme.AdminAccountKey, error) {
+						assert.Equals(t, "thumbprint", thumbprint)
+						return ak, nil
+					},
+				},
+				method:     "GET",
+				path:       "/keys/thumbprint",
+				tls:        verified,
+				statusCode: 200,
+				resp:       ak,
+			}
+		},
+		"ok/getChallenge": func(t *testing.T) test {
+			ch := &acme.AdminChallenge{
+				ID:     "chID",
+				Type:   "http-01",
+				Status: "processing",
+				Error:  acme.ConnectionErr(errors.New("force")).ToACME(),
+				Attempts: []*acme.ValidationAttempt{
+					{Vantage: "local", TargetIP: "192.0.2.1", Error: acme.ConnectionErr(errors.New("force")).ToACME()},
+				},
+			}
+			return test{
+				auth: &mockAdminAuthority{
+					adminGetChallenge: func(id string) (*acme.AdminChallenge, error) {
+						assert.Equals(t, "chID", id)
+						return ch, nil
+					},
+				},
+				method:     "GET",
+				path:       "/challenges/chID",
+				tls:        verified,
+				statusCode: 200,
+				resp:       ch,
+			}
+		},
+		"ok/listOrders": func(t *testing.T) test {
+			orders := []*acme.AdminOrder{{ID: "ordID", Status: "valid", Certificate: "certID"}}
+			return test{
+				auth: &mockAdminAuthority{
+					adminListOrders: func(id string) ([]*acme.AdminOrder, error) {
+						assert.Equals(t, "accID", id)
+						return orders, nil
+					},
+				},
+				method:     "GET",
+				path:       "/accounts/accID/orders",
+				tls:        verified,
+				statusCode: 200,
+				resp:       orders,
+			}
+		},
+		"ok/listCertificates": func(t *testing.T) test {
+			certs := []*acme.AdminCertificate{{ID: "certID", OrderID: "ordID", SerialNumber: "1234"}}
+			return test{
+				auth: &mockAdminAuthority{
+					adminListCertificates: func(id string) ([]*acme.AdminCertificate, error) {
+						assert.Equals(t, "accID", id)
+						return certs, nil
+					},
+				},
+				method:     "GET",
+				path:       "/accounts/accID/certificates",
+				tls:        verified,
+				statusCode: 200,
+				resp:       certs,
+			}
+		},
+		"fail/deactivateAccount-revoke": func(t *testing.T) test {
+			return test{
+				auth:       &mockAdminAuthority{},
+				method:     "POST",
+				path:       "/accounts/accID/deactivate?revoke=foo",
+				tls:        verified,
+				statusCode: 400,
+				problem:    acme.MalformedErr(errors.New(`error parsing revoke foo: strconv.ParseBool: parsing "foo": invalid syntax`)),
+			}
+		},
+		"ok/deactivateAccount": func(t *testing.T) test {
+			deactivated := &acme.AdminAccount{ID: "accID", Status: "deactivated"}
+			return test{
+				auth: &mockAdminAuthority{
+					adminDeactivateAccount: func(id string, revoke bool) (*acme.AdminAccount, error) {
+						assert.Equals(t, "accID", id)
+						assert.False(t, revoke)
+						return deactivated, nil
+					},
+				},
+				method:     "POST",
+				path:       "/accounts/accID/deactivate",
+				tls:        verified,
+				statusCode: 200,
+				resp:       deactivated,
+			}
+		},
+		"ok/deactivateAccount-revoke": func(t *testing.T) test {
+			deactivated := &acme.AdminAccount{ID: "accID", Status: "deactivated"}
+			return test{
+				auth: &mockAdminAuthority{
+					adminDeactivateAccount: func(id string, revoke bool) (*acme.AdminAccount, error) {
+						assert.Equals(t, "accID", id)
+						assert.True(t, revoke)
+						return deactivated, nil
+					},
+				},
+				method:     "POST",
+				path:       "/accounts/accID/deactivate?revoke=true",
+				tls:        verified,
+				statusCode: 200,
+				resp:       deactivated,
+			}
+		},
+		"ok/purgeAccount": func(t *testing.T) test {
+			stats := &acme.PurgeStats{Orders: 1, Authzs: 2, Challenges: 3}
+			return test{
+				auth: &mockAdminAuthority{
+					adminPurgeAccount: func(id string) (*acme.PurgeStats, error) {
+						assert.Equals(t, "accID", id)
+						return stats, nil
+					},
+				},
+				method:     "POST",
+				path:       "/accounts/accID/purge",
+				tls:        verified,
+				statusCode: 200,
+				resp:       stats,
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			r := chi.NewRouter()
+			NewAdmin(tc.auth).Route(r)
+			req := httptest.NewRequest(tc.method, "https://ca.smallstep.com"+tc.path, nil)
+			req.TLS = tc.tls
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			res := w.Result()
+
+			assert.Equals(t, res.StatusCode, tc.statusCode)
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+
+			if res.StatusCode >= 400 && assert.NotNil(t, tc.problem) {
+				var ae acme.AError
+				assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &ae))
+				prob := tc.problem.ToACME()
+
+				assert.Equals(t, ae.Type, prob.Type)
+				assert.Equals(t, ae.Detail, prob.Detail)
+				assert.Equals(t, res.Header["Content-Type"], []string{"application/problem+json"})
+			} else {
+				expB, err := json.Marshal(tc.resp)
+				assert.FatalError(t, err)
+				assert.Equals(t, bytes.TrimSpace(body), expB)
+				assert.Equals(t, res.Header["Content-Type"], []string{"application/json"})
+			}
+		})
+	}
+}
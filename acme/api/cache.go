@@ -0,0 +1,58 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// Cache-Control headers of the cacheable resources. The directory and the
+// STAR certificates change over time, so clients must revalidate them on
+// every use, while the issued certificates never change.
+const (
+	directoryCacheControl       = "public, max-age=0, no-cache"
+	certificateCacheControl     = "private, max-age=86400"
+	starCertificateCacheControl = "no-cache"
+)
+
+// etag returns the strong entity tag of the given response body.
+func etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:]) + `"`
+}
+
+// etagMatch returns true if the given If-None-Match header matches the entity
+// tag. Following RFC 7232, it uses the weak comparison.
+func etagMatch(ifNoneMatch, tag string) bool {
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeCacheable writes the given body with its entity tag and the given
+// Cache-Control header. If the entity tag of a GET or HEAD request matches its
+// If-None-Match header it writes a 304 Not Modified response without body.
+// The conditional headers of other requests, like the POST-as-GET requests,
+// are ignored.
+func writeCacheable(w http.ResponseWriter, r *http.Request, cacheControl, contentType string, body []byte) {
+	tag := etag(body)
+	w.Header().Set("ETag", tag)
+	w.Header().Set("Cache-Control", cacheControl)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && isSafeMethod(r.Method) && etagMatch(inm, tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(body)
+}
+
+// isSafeMethod returns true if the conditional headers of requests with the
+// given method can be answered with a 304 Not Modified response.
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+func TestEtagMatch(t *testing.T) {
+	tag := etag([]byte("foo"))
+	tests := map[string]struct {
+		ifNoneMatch string
+		match       bool
+	}{
+		"ok/strong":   {tag, true},
+		"ok/weak":     {"W/" + tag, true},
+		"ok/list":     {`"bar", ` + tag, true},
+		"ok/any":      {"*", true},
+		"ok/mismatch": {`"bar", W/"baz"`, false},
+		"ok/unquoted": {tag[1 : len(tag)-1], false},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equals(t, tc.match, etagMatch(tc.ifNoneMatch, tag))
+		})
+	}
+}
+
+func TestWriteCacheable(t *testing.T) {
+	body := []byte("foo")
+	tests := map[string]struct {
+		method      string
+		ifNoneMatch string
+		statusCode  int
+		body        []byte
+	}{
+		"ok":                   {"GET", "", 200, body},
+		"ok/etag-changed":      {"GET", etag([]byte("bar")), 200, body},
+		"ok/not-modified":      {"GET", etag(body), 304, nil},
+		"ok/head-not-modified": {"HEAD", etag(body), 304, nil},
+		"ok/post":              {"POST", etag(body), 200, body},
+		"ok/post-any":          {"POST", "*", 200, body},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "http://ca.smallstep.com/acme/directory", nil)
+			if tc.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tc.ifNoneMatch)
+			}
+			w := httptest.NewRecorder()
+			writeCacheable(w, req, "no-cache", "text/plain", body)
+			assert.Equals(t, tc.statusCode, w.Code)
+			assert.Equals(t, string(tc.body), w.Body.String())
+			assert.Equals(t, etag(body), w.Header().Get("ETag"))
+			assert.Equals(t, "no-cache", w.Header().Get("Cache-Control"))
+			if tc.statusCode == 200 {
+				assert.Equals(t, "text/plain", w.Header().Get("Content-Type"))
+			} else {
+				assert.Equals(t, "", w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
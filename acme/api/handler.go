@@ -1,6 +1,8 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 
@@ -9,6 +11,7 @@ import (
 	"github.com/smallstep/certificates/acme"
 	"github.com/smallstep/certificates/api"
 	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/tracing"
 	"github.com/smallstep/cli/jose"
 )
 
@@ -80,12 +83,14 @@ type Handler struct {
 
 // Route traffic and implement the Router interface.
 func (h *Handler) Route(r api.Router) {
-	getLink := h.Auth.GetLink
+	getLink := func(typ acme.Link, provID string, abs bool, inputs ...string) string {
+		return h.Auth.GetLink(context.Background(), typ, provID, abs, inputs...)
+	}
 	// Standard ACME API
-	r.MethodFunc("GET", getLink(acme.NewNonceLink, "{provisionerID}", false), h.lookupProvisioner(h.addNonce(h.GetNonce)))
-	r.MethodFunc("HEAD", getLink(acme.NewNonceLink, "{provisionerID}", false), h.lookupProvisioner(h.addNonce(h.GetNonce)))
-	r.MethodFunc("GET", getLink(acme.DirectoryLink, "{provisionerID}", false), h.lookupProvisioner(h.addNonce(h.GetDirectory)))
-	r.MethodFunc("HEAD", getLink(acme.DirectoryLink, "{provisionerID}", false), h.lookupProvisioner(h.addNonce(h.GetDirectory)))
+	r.MethodFunc("GET", getLink(acme.NewNonceLink, "{provisionerID}", false), instrument("new-nonce", h.lookupProvisioner(h.addNonce(h.GetNonce))))
+	r.MethodFunc("HEAD", getLink(acme.NewNonceLink, "{provisionerID}", false), instrument("new-nonce", h.lookupProvisioner(h.addNonce(h.GetNonce))))
+	r.MethodFunc("GET", getLink(acme.DirectoryLink, "{provisionerID}", false), instrument("directory", h.lookupProvisioner(h.addNonce(h.GetDirectory))))
+	r.MethodFunc("HEAD", getLink(acme.DirectoryLink, "{provisionerID}", false), instrument("directory", h.lookupProvisioner(h.addNonce(h.GetDirectory))))
 
 	extractPayloadByJWK := func(next nextHTTP) nextHTTP {
 		return h.lookupProvisioner(h.addNonce(h.addDirLink(h.verifyContentType(h.parseJWS(h.validateJWS(h.extractJWK(h.verifyAndExtractJWSPayload(next))))))))
@@ -94,15 +99,18 @@ func (h *Handler) Route(r api.Router) {
 		return h.lookupProvisioner(h.addNonce(h.addDirLink(h.verifyContentType(h.parseJWS(h.validateJWS(h.lookupJWK(h.verifyAndExtractJWSPayload(next))))))))
 	}
 
-	r.MethodFunc("POST", getLink(acme.NewAccountLink, "{provisionerID}", false), extractPayloadByJWK(h.NewAccount))
-	r.MethodFunc("POST", getLink(acme.AccountLink, "{provisionerID}", false, "{accID}"), extractPayloadByKid(h.GetUpdateAccount))
-	r.MethodFunc("POST", getLink(acme.NewOrderLink, "{provisionerID}", false), extractPayloadByKid(h.NewOrder))
-	r.MethodFunc("POST", getLink(acme.OrderLink, "{provisionerID}", false, "{ordID}"), extractPayloadByKid(h.isPostAsGet(h.GetOrder)))
-	r.MethodFunc("POST", getLink(acme.OrdersByAccountLink, "{provisionerID}", false, "{accID}"), extractPayloadByKid(h.isPostAsGet(h.GetOrdersByAccount)))
-	r.MethodFunc("POST", getLink(acme.FinalizeLink, "{provisionerID}", false, "{ordID}"), extractPayloadByKid(h.FinalizeOrder))
-	r.MethodFunc("POST", getLink(acme.AuthzLink, "{provisionerID}", false, "{authzID}"), extractPayloadByKid(h.isPostAsGet(h.GetAuthz)))
-	r.MethodFunc("POST", getLink(acme.ChallengeLink, "{provisionerID}", false, "{chID}"), extractPayloadByKid(h.GetChallenge))
-	r.MethodFunc("POST", getLink(acme.CertificateLink, "{provisionerID}", false, "{certID}"), extractPayloadByKid(h.isPostAsGet(h.GetCertificate)))
+	r.MethodFunc("POST", getLink(acme.NewAccountLink, "{provisionerID}", false), instrument("new-account", extractPayloadByJWK(h.NewAccount)))
+	r.MethodFunc("POST", getLink(acme.AccountLink, "{provisionerID}", false, "{accID}"), instrument("account", extractPayloadByKid(h.GetUpdateAccount)))
+	r.MethodFunc("POST", getLink(acme.NewOrderLink, "{provisionerID}", false), instrument("new-order", extractPayloadByKid(h.NewOrder)))
+	r.MethodFunc("POST", getLink(acme.OrderLink, "{provisionerID}", false, "{ordID}"), instrument("order", extractPayloadByKid(h.isPostAsGet(h.GetOrder))))
+	r.MethodFunc("POST", getLink(acme.OrdersByAccountLink, "{provisionerID}", false, "{accID}"), instrument("orders", extractPayloadByKid(h.isPostAsGet(h.GetOrdersByAccount))))
+	r.MethodFunc("POST", getLink(acme.FinalizeLink, "{provisionerID}", false, "{ordID}"), instrument("finalize", extractPayloadByKid(h.FinalizeOrder)))
+	r.MethodFunc("POST", getLink(acme.AuthzLink, "{provisionerID}", false, "{authzID}"), instrument("authz", extractPayloadByKid(h.isPostAsGet(h.GetAuthz))))
+	r.MethodFunc("POST", getLink(acme.ChallengeLink, "{provisionerID}", false, "{chID}"), instrument("challenge", extractPayloadByKid(h.GetChallenge)))
+	r.MethodFunc("POST", getLink(acme.RevokeCertLink, "{provisionerID}", false), instrument("revoke-cert", extractPayloadByKid(h.RevokeCert)))
+	r.MethodFunc("POST", getLink(acme.CertificateLink, "{provisionerID}", false, "{certID}"), instrument("certificate", extractPayloadByKid(h.isPostAsGet(h.GetCertificate))))
+	r.MethodFunc("POST", getLink(acme.StarCertificateLink, "{provisionerID}", false, "{ordID}"), instrument("star-certificate", extractPayloadByKid(h.isPostAsGet(h.GetStarCertificate))))
+	r.MethodFunc("GET", getLink(acme.StarCertificateLink, "{provisionerID}", false, "{ordID}"), instrument("star-certificate", h.lookupProvisioner(h.GetStarCertificate)))
 }
 
 // GetNonce just sets the right header since a Nonce is added to each response
@@ -123,8 +131,14 @@ func (h *Handler) GetDirectory(w http.ResponseWriter, r *http.Request) {
 		api.WriteError(w, err)
 		return
 	}
-	dir := h.Auth.GetDirectory(prov)
-	api.JSON(w, dir)
+	dir := h.Auth.GetDirectory(r.Context(), prov)
+	b, err := json.Marshal(dir)
+	if err != nil {
+		api.WriteError(w, acme.ServerInternalErr(errors.Wrap(err, "error marshaling directory")))
+		return
+	}
+	writeCacheable(w, r, directoryCacheControl, "application/json", append(b, '\n'))
+	api.LogEnabledResponse(w, dir)
 }
 
 // GetAuthz ACME api for retrieving an Authz.
@@ -139,13 +153,13 @@ func (h *Handler) GetAuthz(w http.ResponseWriter, r *http.Request) {
 		api.WriteError(w, err)
 		return
 	}
-	authz, err := h.Auth.GetAuthz(prov, acc.GetID(), chi.URLParam(r, "authzID"))
+	authz, err := h.Auth.GetAuthz(r.Context(), prov, acc.GetID(), chi.URLParam(r, "authzID"))
 	if err != nil {
 		api.WriteError(w, err)
 		return
 	}
 
-	w.Header().Set("Location", h.Auth.GetLink(acme.AuthzLink, acme.URLSafeProvisionerName(prov), true, authz.GetID()))
+	w.Header().Set("Location", h.Auth.GetLink(r.Context(), acme.AuthzLink, acme.URLSafeProvisionerName(prov), true, authz.GetID()))
 	api.JSON(w, authz)
 }
 
@@ -163,7 +177,7 @@ func (h *Handler) GetChallenge(w http.ResponseWriter, r *http.Request) {
 	}
 	// Just verify that the payload was set, since we're not strictly adhering
 	// to ACME V2 spec for reasons specified below.
-	_, err = payloadFromContext(r)
+	payload, err := payloadFromContext(r)
 	if err != nil {
 		api.WriteError(w, err)
 		return
@@ -173,37 +187,51 @@ func (h *Handler) GetChallenge(w http.ResponseWriter, r *http.Request) {
 	// that the payload is an empty JSON block ({}). However, older ACME clients
 	// still send a vestigial body (rather than an empty JSON block) and
 	// strict enforcement would render these clients broken. For the time being
-	// we'll just ignore the body.
+	// we'll just ignore the body. The body is only used by device-attest-01
+	// challenges, which receive the attestation statement in it.
 	var (
 		ch   *acme.Challenge
 		chID = chi.URLParam(r, "chID")
 	)
-	ch, err = h.Auth.ValidateChallenge(prov, acc.GetID(), chID, acc.GetKey())
+	ctx, span := tracing.Start(r.Context(), "acme.ValidateChallenge")
+	ch, err = h.Auth.ValidateChallenge(ctx, prov, acc.GetID(), chID, acc.GetKey(), payload.value)
+	if ch != nil {
+		span.SetAttribute("type", ch.Type)
+	}
+	span.SetError(err)
+	span.End()
 	if err != nil {
 		api.WriteError(w, err)
 		return
 	}
 
 	getLink := h.Auth.GetLink
-	w.Header().Add("Link", link(getLink(acme.AuthzLink, acme.URLSafeProvisionerName(prov), true, ch.GetAuthzID()), "up"))
-	w.Header().Set("Location", getLink(acme.ChallengeLink, acme.URLSafeProvisionerName(prov), true, ch.GetID()))
+	w.Header().Add("Link", link(getLink(r.Context(), acme.AuthzLink, acme.URLSafeProvisionerName(prov), true, ch.GetAuthzID()), "up"))
+	w.Header().Set("Location", getLink(r.Context(), acme.ChallengeLink, acme.URLSafeProvisionerName(prov), true, ch.GetID()))
+	if ch.RetryAfter != "" {
+		w.Header().Set("Retry-After", ch.RetryAfter)
+	}
 	api.JSON(w, ch)
 }
 
 // GetCertificate ACME api for retrieving a Certificate.
 func (h *Handler) GetCertificate(w http.ResponseWriter, r *http.Request) {
+	prov, err := provisionerFromContext(r)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
 	acc, err := accountFromContext(r)
 	if err != nil {
 		api.WriteError(w, err)
 		return
 	}
 	certID := chi.URLParam(r, "certID")
-	certBytes, err := h.Auth.GetCertificate(acc.GetID(), certID)
+	certBytes, err := h.Auth.GetCertificate(prov, acc.GetID(), certID)
 	if err != nil {
 		api.WriteError(w, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/pem-certificate-chain; charset=utf-8")
-	w.Write(certBytes)
+	writeCacheable(w, r, certificateCacheControl, "application/pem-certificate-chain; charset=utf-8", certBytes)
 }
@@ -28,24 +28,26 @@ type mockAcmeAuthority struct {
 	getAccount          func(p provisioner.Interface, id string) (*acme.Account, error)
 	getAccountByKey     func(provisioner.Interface, *jose.JSONWebKey) (*acme.Account, error)
 	getAuthz            func(p provisioner.Interface, accID string, id string) (*acme.Authz, error)
-	getCertificate      func(accID string, id string) ([]byte, error)
+	getCertificate      func(p provisioner.Interface, accID string, id string) ([]byte, error)
 	getChallenge        func(p provisioner.Interface, accID string, id string) (*acme.Challenge, error)
 	getDirectory        func(provisioner.Interface) *acme.Directory
 	getLink             func(acme.Link, string, bool, ...string) string
 	getOrder            func(p provisioner.Interface, accID string, id string) (*acme.Order, error)
 	getOrdersByAccount  func(p provisioner.Interface, id string) ([]string, error)
+	getStarCertificate  func(p provisioner.Interface, accID string, id string) ([]byte, error)
 	loadProvisionerByID func(string) (provisioner.Interface, error)
 	newAccount          func(provisioner.Interface, acme.AccountOptions) (*acme.Account, error)
 	newNonce            func() (string, error)
 	newOrder            func(provisioner.Interface, acme.OrderOptions) (*acme.Order, error)
+	revokeCertificate   func(p provisioner.Interface, accID string, crt *x509.Certificate, reasonCode int) error
 	updateAccount       func(provisioner.Interface, string, []string) (*acme.Account, error)
 	useNonce            func(string) error
-	validateChallenge   func(p provisioner.Interface, accID string, id string, jwk *jose.JSONWebKey) (*acme.Challenge, error)
+	validateChallenge   func(p provisioner.Interface, accID string, id string, jwk *jose.JSONWebKey, payload []byte) (*acme.Challenge, error)
 	ret1                interface{}
 	err                 error
 }
 
-func (m *mockAcmeAuthority) DeactivateAccount(p provisioner.Interface, id string) (*acme.Account, error) {
+func (m *mockAcmeAuthority) DeactivateAccount(ctx context.Context, p provisioner.Interface, id string) (*acme.Account, error) {
 	if m.deactivateAccount != nil {
 		return m.deactivateAccount(p, id)
 	} else if m.err != nil {
@@ -54,7 +56,7 @@ func (m *mockAcmeAuthority) DeactivateAccount(p provisioner.Interface, id string
 	return m.ret1.(*acme.Account), m.err
 }
 
-func (m *mockAcmeAuthority) FinalizeOrder(p provisioner.Interface, accID, id string, csr *x509.CertificateRequest) (*acme.Order, error) {
+func (m *mockAcmeAuthority) FinalizeOrder(ctx context.Context, p provisioner.Interface, accID, id string, csr *x509.CertificateRequest) (*acme.Order, error) {
 	if m.finalizeOrder != nil {
 		return m.finalizeOrder(p, accID, id, csr)
 	} else if m.err != nil {
@@ -63,7 +65,7 @@ func (m *mockAcmeAuthority) FinalizeOrder(p provisioner.Interface, accID, id str
 	return m.ret1.(*acme.Order), m.err
 }
 
-func (m *mockAcmeAuthority) GetAccount(p provisioner.Interface, id string) (*acme.Account, error) {
+func (m *mockAcmeAuthority) GetAccount(ctx context.Context, p provisioner.Interface, id string) (*acme.Account, error) {
 	if m.getAccount != nil {
 		return m.getAccount(p, id)
 	} else if m.err != nil {
@@ -72,7 +74,7 @@ func (m *mockAcmeAuthority) GetAccount(p provisioner.Interface, id string) (*acm
 	return m.ret1.(*acme.Account), m.err
 }
 
-func (m *mockAcmeAuthority) GetAccountByKey(p provisioner.Interface, jwk *jose.JSONWebKey) (*acme.Account, error) {
+func (m *mockAcmeAuthority) GetAccountByKey(ctx context.Context, p provisioner.Interface, jwk *jose.JSONWebKey) (*acme.Account, error) {
 	if m.getAccountByKey != nil {
 		return m.getAccountByKey(p, jwk)
 	} else if m.err != nil {
@@ -81,7 +83,7 @@ func (m *mockAcmeAuthority) GetAccountByKey(p provisioner.Interface, jwk *jose.J
 	return m.ret1.(*acme.Account), m.err
 }
 
-func (m *mockAcmeAuthority) GetAuthz(p provisioner.Interface, accID, id string) (*acme.Authz, error) {
+func (m *mockAcmeAuthority) GetAuthz(ctx context.Context, p provisioner.Interface, accID, id string) (*acme.Authz, error) {
 	if m.getAuthz != nil {
 		return m.getAuthz(p, accID, id)
 	} else if m.err != nil {
@@ -90,9 +92,9 @@ func (m *mockAcmeAuthority) GetAuthz(p provisioner.Interface, accID, id string)
 	return m.ret1.(*acme.Authz), m.err
 }
 
-func (m *mockAcmeAuthority) GetCertificate(accID, id string) ([]byte, error) {
+func (m *mockAcmeAuthority) GetCertificate(p provisioner.Interface, accID, id string) ([]byte, error) {
 	if m.getCertificate != nil {
-		return m.getCertificate(accID, id)
+		return m.getCertificate(p, accID, id)
 	} else if m.err != nil {
 		return nil, m.err
 	}
@@ -108,21 +110,21 @@ func (m *mockAcmeAuthority) GetChallenge(p provisioner.Interface, accID, id stri
 	return m.ret1.(*acme.Challenge), m.err
 }
 
-func (m *mockAcmeAuthority) GetDirectory(p provisioner.Interface) *acme.Directory {
+func (m *mockAcmeAuthority) GetDirectory(ctx context.Context, p provisioner.Interface) *acme.Directory {
 	if m.getDirectory != nil {
 		return m.getDirectory(p)
 	}
 	return m.ret1.(*acme.Directory)
 }
 
-func (m *mockAcmeAuthority) GetLink(typ acme.Link, provID string, abs bool, in ...string) string {
+func (m *mockAcmeAuthority) GetLink(ctx context.Context, typ acme.Link, provID string, abs bool, in ...string) string {
 	if m.getLink != nil {
 		return m.getLink(typ, provID, abs, in...)
 	}
 	return m.ret1.(string)
 }
 
-func (m *mockAcmeAuthority) GetOrder(p provisioner.Interface, accID, id string) (*acme.Order, error) {
+func (m *mockAcmeAuthority) GetOrder(ctx context.Context, p provisioner.Interface, accID, id string) (*acme.Order, error) {
 	if m.getOrder != nil {
 		return m.getOrder(p, accID, id)
 	} else if m.err != nil {
@@ -131,7 +133,7 @@ func (m *mockAcmeAuthority) GetOrder(p provisioner.Interface, accID, id string)
 	return m.ret1.(*acme.Order), m.err
 }
 
-func (m *mockAcmeAuthority) GetOrdersByAccount(p provisioner.Interface, id string) ([]string, error) {
+func (m *mockAcmeAuthority) GetOrdersByAccount(ctx context.Context, p provisioner.Interface, id string) ([]string, error) {
 	if m.getOrdersByAccount != nil {
 		return m.getOrdersByAccount(p, id)
 	} else if m.err != nil {
@@ -140,6 +142,15 @@ func (m *mockAcmeAuthority) GetOrdersByAccount(p provisioner.Interface, id strin
 	return m.ret1.([]string), m.err
 }
 
+func (m *mockAcmeAuthority) GetStarCertificate(p provisioner.Interface, accID, id string) ([]byte, error) {
+	if m.getStarCertificate != nil {
+		return m.getStarCertificate(p, accID, id)
+	} else if m.err != nil {
+		return nil, m.err
+	}
+	return m.ret1.([]byte), m.err
+}
+
 func (m *mockAcmeAuthority) LoadProvisionerByID(provID string) (provisioner.Interface, error) {
 	if m.loadProvisionerByID != nil {
 		return m.loadProvisionerByID(provID)
@@ -149,7 +160,7 @@ func (m *mockAcmeAuthority) LoadProvisionerByID(provID string) (provisioner.Inte
 	return m.ret1.(provisioner.Interface), m.err
 }
 
-func (m *mockAcmeAuthority) NewAccount(p provisioner.Interface, ops acme.AccountOptions) (*acme.Account, error) {
+func (m *mockAcmeAuthority) NewAccount(ctx context.Context, p provisioner.Interface, ops acme.AccountOptions) (*acme.Account, error) {
 	if m.newAccount != nil {
 		return m.newAccount(p, ops)
 	} else if m.err != nil {
@@ -167,7 +178,7 @@ func (m *mockAcmeAuthority) NewNonce() (string, error) {
 	return m.ret1.(string), m.err
 }
 
-func (m *mockAcmeAuthority) NewOrder(p provisioner.Interface, ops acme.OrderOptions) (*acme.Order, error) {
+func (m *mockAcmeAuthority) NewOrder(ctx context.Context, p provisioner.Interface, ops acme.OrderOptions) (*acme.Order, error) {
 	if m.newOrder != nil {
 		return m.newOrder(p, ops)
 	} else if m.err != nil {
@@ -176,7 +187,14 @@ func (m *mockAcmeAuthority) NewOrder(p provisioner.Interface, ops acme.OrderOpti
 	return m.ret1.(*acme.Order), m.err
 }
 
-func (m *mockAcmeAuthority) UpdateAccount(p provisioner.Interface, id string, contact []string) (*acme.Account, error) {
+func (m *mockAcmeAuthority) RevokeCertificate(p provisioner.Interface, accID string, crt *x509.Certificate, reasonCode int) error {
+	if m.revokeCertificate != nil {
+		return m.revokeCertificate(p, accID, crt, reasonCode)
+	}
+	return m.err
+}
+
+func (m *mockAcmeAuthority) UpdateAccount(ctx context.Context, p provisioner.Interface, id string, contact []string) (*acme.Account, error) {
 	if m.updateAccount != nil {
 		return m.updateAccount(p, id, contact)
 	} else if m.err != nil {
@@ -192,10 +210,10 @@ func (m *mockAcmeAuthority) UseNonce(nonce string) error {
 	return m.err
 }
 
-func (m *mockAcmeAuthority) ValidateChallenge(p provisioner.Interface, accID string, id string, jwk *jose.JSONWebKey) (*acme.Challenge, error) {
+func (m *mockAcmeAuthority) ValidateChallenge(ctx context.Context, p provisioner.Interface, accID string, id string, jwk *jose.JSONWebKey, payload []byte) (*acme.Challenge, error) {
 	switch {
 	case m.validateChallenge != nil:
-		return m.validateChallenge(p, accID, id, jwk)
+		return m.validateChallenge(p, accID, id, jwk, payload)
 	case m.err != nil:
 		return nil, m.err
 	default:
@@ -244,10 +262,15 @@ func TestHandlerGetDirectory(t *testing.T) {
 		KeyChange:  fmt.Sprintf("https://ca.smallstep.com/acme/%s/key-change", acme.URLSafeProvisionerName(prov)),
 	}
 
+	dirBytes, err := json.Marshal(expDir)
+	assert.FatalError(t, err)
+	dirETag := etag(append(dirBytes, '\n'))
+
 	type test struct {
-		ctx        context.Context
-		statusCode int
-		problem    *acme.Error
+		ctx         context.Context
+		ifNoneMatch string
+		statusCode  int
+		problem     *acme.Error
 	}
 	var tests = map[string]func(t *testing.T) test{
 		"fail/no-provisioner": func(t *testing.T) test {
@@ -270,6 +293,20 @@ func TestHandlerGetDirectory(t *testing.T) {
 				statusCode: 200,
 			}
 		},
+		"ok/etag-mismatch": func(t *testing.T) test {
+			return test{
+				ctx:         context.WithValue(context.Background(), provisionerContextKey, prov),
+				ifNoneMatch: `"foo"`,
+				statusCode:  200,
+			}
+		},
+		"ok/not-modified": func(t *testing.T) test {
+			return test{
+				ctx:         context.WithValue(context.Background(), provisionerContextKey, prov),
+				ifNoneMatch: `"foo", W/` + dirETag,
+				statusCode:  304,
+			}
+		},
 	}
 	for name, run := range tests {
 		tc := run(t)
@@ -277,6 +314,9 @@ func TestHandlerGetDirectory(t *testing.T) {
 			h := New(auth).(*Handler)
 			req := httptest.NewRequest("GET", url, nil)
 			req = req.WithContext(tc.ctx)
+			if tc.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tc.ifNoneMatch)
+			}
 			w := httptest.NewRecorder()
 			h.GetDirectory(w, req)
 			res := w.Result()
@@ -297,11 +337,17 @@ func TestHandlerGetDirectory(t *testing.T) {
 				assert.Equals(t, ae.Identifier, prob.Identifier)
 				assert.Equals(t, ae.Subproblems, prob.Subproblems)
 				assert.Equals(t, res.Header["Content-Type"], []string{"application/problem+json"})
+			} else if res.StatusCode == 304 {
+				assert.Equals(t, len(body), 0)
+				assert.Equals(t, res.Header.Get("ETag"), dirETag)
+				assert.Equals(t, res.Header.Get("Cache-Control"), "public, max-age=0, no-cache")
 			} else {
 				var dir acme.Directory
 				json.Unmarshal(bytes.TrimSpace(body), &dir)
 				assert.Equals(t, dir, expDir)
 				assert.Equals(t, res.Header["Content-Type"], []string{"application/json"})
+				assert.Equals(t, res.Header.Get("ETag"), dirETag)
+				assert.Equals(t, res.Header.Get("Cache-Control"), "public, max-age=0, no-cache")
 			}
 		})
 	}
@@ -494,12 +540,21 @@ func TestHandlerGetCertificate(t *testing.T) {
 		acme.URLSafeProvisionerName(prov), certID)
 
 	type test struct {
-		auth       acme.Interface
-		ctx        context.Context
-		statusCode int
-		problem    *acme.Error
+		auth        acme.Interface
+		ctx         context.Context
+		ifNoneMatch string
+		statusCode  int
+		problem     *acme.Error
 	}
 	var tests = map[string]func(t *testing.T) test{
+		"fail/no-provisioner": func(t *testing.T) test {
+			return test{
+				auth:       &mockAcmeAuthority{},
+				ctx:        context.Background(),
+				statusCode: 500,
+				problem:    acme.ServerInternalErr(errors.New("provisioner expected in request context")),
+			}
+		},
 		"fail/no-account": func(t *testing.T) test {
 			return test{
 				auth:       &mockAcmeAuthority{},
@@ -509,7 +564,8 @@ func TestHandlerGetCertificate(t *testing.T) {
 			}
 		},
 		"fail/nil-account": func(t *testing.T) test {
-			ctx := context.WithValue(context.Background(), accContextKey, nil)
+			ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+			ctx = context.WithValue(ctx, accContextKey, nil)
 			return test{
 				auth:       &mockAcmeAuthority{},
 				ctx:        ctx,
@@ -519,7 +575,8 @@ func TestHandlerGetCertificate(t *testing.T) {
 		},
 		"fail/getCertificate-error": func(t *testing.T) test {
 			acc := &acme.Account{ID: "accID"}
-			ctx := context.WithValue(context.Background(), accContextKey, acc)
+			ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+			ctx = context.WithValue(ctx, accContextKey, acc)
 			ctx = context.WithValue(ctx, chi.RouteCtxKey, chiCtx)
 			return test{
 				auth: &mockAcmeAuthority{
@@ -532,11 +589,13 @@ func TestHandlerGetCertificate(t *testing.T) {
 		},
 		"ok": func(t *testing.T) test {
 			acc := &acme.Account{ID: "accID"}
-			ctx := context.WithValue(context.Background(), accContextKey, acc)
+			ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+			ctx = context.WithValue(ctx, accContextKey, acc)
 			ctx = context.WithValue(ctx, chi.RouteCtxKey, chiCtx)
 			return test{
 				auth: &mockAcmeAuthority{
-					getCertificate: func(accID, id string) ([]byte, error) {
+					getCertificate: func(p provisioner.Interface, accID, id string) ([]byte, error) {
+						assert.Equals(t, p, prov)
 						assert.Equals(t, accID, acc.ID)
 						assert.Equals(t, id, certID)
 						return certBytes, nil
@@ -546,6 +605,22 @@ func TestHandlerGetCertificate(t *testing.T) {
 				statusCode: 200,
 			}
 		},
+		"ok/not-modified": func(t *testing.T) test {
+			acc := &acme.Account{ID: "accID"}
+			ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			ctx = context.WithValue(ctx, chi.RouteCtxKey, chiCtx)
+			return test{
+				auth: &mockAcmeAuthority{
+					getCertificate: func(p provisioner.Interface, accID, id string) ([]byte, error) {
+						return certBytes, nil
+					},
+				},
+				ctx:         ctx,
+				ifNoneMatch: etag(certBytes),
+				statusCode:  304,
+			}
+		},
 	}
 	for name, run := range tests {
 		tc := run(t)
@@ -553,6 +628,9 @@ func TestHandlerGetCertificate(t *testing.T) {
 			h := New(tc.auth).(*Handler)
 			req := httptest.NewRequest("GET", url, nil)
 			req = req.WithContext(tc.ctx)
+			if tc.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tc.ifNoneMatch)
+			}
 			w := httptest.NewRecorder()
 			h.GetCertificate(w, req)
 			res := w.Result()
@@ -573,9 +651,14 @@ func TestHandlerGetCertificate(t *testing.T) {
 				assert.Equals(t, ae.Identifier, prob.Identifier)
 				assert.Equals(t, ae.Subproblems, prob.Subproblems)
 				assert.Equals(t, res.Header["Content-Type"], []string{"application/problem+json"})
+			} else if res.StatusCode == 304 {
+				assert.Equals(t, len(body), 0)
+				assert.Equals(t, res.Header.Get("ETag"), etag(certBytes))
 			} else {
 				assert.Equals(t, bytes.TrimSpace(body), bytes.TrimSpace(certBytes))
 				assert.Equals(t, res.Header["Content-Type"], []string{"application/pem-certificate-chain; charset=utf-8"})
+				assert.Equals(t, res.Header.Get("ETag"), etag(certBytes))
+				assert.Equals(t, res.Header.Get("Cache-Control"), "private, max-age=86400")
 			}
 		})
 	}
@@ -701,7 +784,53 @@ func TestHandlerGetChallenge(t *testing.T) {
 			count := 0
 			return test{
 				auth: &mockAcmeAuthority{
-					validateChallenge: func(p provisioner.Interface, accID, id string, jwk *jose.JSONWebKey) (*acme.Challenge, error) {
+					validateChallenge: func(p provisioner.Interface, accID, id string, jwk *jose.JSONWebKey, payload []byte) (*acme.Challenge, error) {
+						assert.Equals(t, p, prov)
+						assert.Equals(t, accID, acc.ID)
+						assert.Equals(t, id, ch.ID)
+						assert.Equals(t, jwk.KeyID, key.KeyID)
+						return &ch, nil
+					},
+					getLink: func(typ acme.Link, provID string, abs bool, in ...string) string {
+						var ret string
+						switch count {
+						case 0:
+							assert.Equals(t, typ, acme.AuthzLink)
+							assert.Equals(t, provID, acme.URLSafeProvisionerName(prov))
+							assert.True(t, abs)
+							assert.Equals(t, in, []string{ch.AuthzID})
+							ret = fmt.Sprintf("https://ca.smallstep.com/acme/authz/%s", ch.AuthzID)
+						case 1:
+							assert.Equals(t, typ, acme.ChallengeLink)
+							assert.Equals(t, provID, acme.URLSafeProvisionerName(prov))
+							assert.True(t, abs)
+							assert.Equals(t, in, []string{ch.ID})
+							ret = url
+						}
+						count++
+						return ret
+					},
+				},
+				ctx:        ctx,
+				statusCode: 200,
+				ch:         ch,
+			}
+		},
+		"ok/processing-challenge": func(t *testing.T) test {
+			key, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			acc := &acme.Account{ID: "accID", Key: key}
+			ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{isEmptyJSON: true})
+			ctx = context.WithValue(ctx, chi.RouteCtxKey, chiCtx)
+			ch := ch()
+			ch.Status = "processing"
+			ch.RetryAfter = "10"
+			count := 0
+			return test{
+				auth: &mockAcmeAuthority{
+					validateChallenge: func(p provisioner.Interface, accID, id string, jwk *jose.JSONWebKey, payload []byte) (*acme.Challenge, error) {
 						assert.Equals(t, p, prov)
 						assert.Equals(t, accID, acc.ID)
 						assert.Equals(t, id, ch.ID)
@@ -766,6 +895,7 @@ func TestHandlerGetChallenge(t *testing.T) {
 				assert.Equals(t, bytes.TrimSpace(body), expB)
 				assert.Equals(t, res.Header["Link"], []string{fmt.Sprintf("<https://ca.smallstep.com/acme/authz/%s>;rel=\"up\"", tc.ch.AuthzID)})
 				assert.Equals(t, res.Header["Location"], []string{url})
+				assert.Equals(t, res.Header.Get("Retry-After"), tc.ch.RetryAfter)
 				assert.Equals(t, res.Header["Content-Type"], []string{"application/json"})
 			}
 		})
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/smallstep/certificates/logging"
+	"github.com/smallstep/certificates/metrics"
+)
+
+var (
+	requestsTotal = metrics.NewCounter("acme_http_requests_total",
+		"Number of ACME requests, by handler and status code.", "handler", "code")
+	requestDuration = metrics.NewHistogram("acme_http_request_duration_seconds",
+		"Duration of the ACME requests, by handler.", metrics.DefaultBuckets, "handler")
+)
+
+// instrument is a middleware that records the number and duration of the
+// requests to the handler with the given name.
+func instrument(name string, next nextHTTP) nextHTTP {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := logging.NewResponseLogger(w)
+		next(rw, r)
+		requestsTotal.Inc(name, strconv.Itoa(rw.StatusCode()))
+		requestDuration.Observe(time.Since(start).Seconds(), name)
+	}
+}
@@ -0,0 +1,24 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+func TestInstrument(t *testing.T) {
+	count := requestsTotal.Value("test", "418")
+	observations := requestDuration.Count("test")
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}
+	w := httptest.NewRecorder()
+	instrument("test", next)(w, httptest.NewRequest("POST", "/acme/prov/new-order", nil))
+
+	assert.Equals(t, http.StatusTeapot, w.Code)
+	assert.Equals(t, count+1, requestsTotal.Value("test", "418"))
+	assert.Equals(t, observations+1, requestDuration.Count("test"))
+}
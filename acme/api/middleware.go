@@ -2,6 +2,9 @@ package api
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rsa"
 	"io/ioutil"
 	"net/http"
@@ -14,6 +17,7 @@ import (
 	"github.com/smallstep/certificates/api"
 	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/logging"
+	"github.com/smallstep/certificates/tracing"
 	"github.com/smallstep/cli/crypto/keys"
 	"github.com/smallstep/cli/jose"
 	"github.com/smallstep/nosql"
@@ -21,6 +25,21 @@ import (
 
 type nextHTTP = func(http.ResponseWriter, *http.Request)
 
+// supportedAlgorithms is the list of JWS algorithms accepted for account keys
+// and requests, it's returned to clients using an unsupported algorithm.
+var supportedAlgorithms = []string{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+// ecdsaCurves maps the ECDSA JWS algorithms to the curve of their keys.
+var ecdsaCurves = map[string]elliptic.Curve{
+	jose.ES256: elliptic.P256(),
+	jose.ES384: elliptic.P384(),
+	jose.ES512: elliptic.P521(),
+}
+
 func logNonce(w http.ResponseWriter, nonce string) {
 	if rl, ok := w.(logging.ResponseLogger); ok {
 		m := map[string]interface{}{
@@ -30,10 +49,29 @@ func logNonce(w http.ResponseWriter, nonce string) {
 	}
 }
 
+func logProvisioner(w http.ResponseWriter, p provisioner.Interface) {
+	if rl, ok := w.(logging.ResponseLogger); ok {
+		rl.WithFields(map[string]interface{}{
+			"provisioner": p.GetName(),
+		})
+	}
+}
+
+func logAccount(w http.ResponseWriter, acc *acme.Account) {
+	if rl, ok := w.(logging.ResponseLogger); ok {
+		rl.WithFields(map[string]interface{}{
+			"account-id": acc.ID,
+		})
+	}
+}
+
 // addNonce is a middleware that adds a nonce to the response header.
 func (h *Handler) addNonce(next nextHTTP) nextHTTP {
 	return func(w http.ResponseWriter, r *http.Request) {
+		_, span := tracing.Start(r.Context(), "acme.NewNonce")
 		nonce, err := h.Auth.NewNonce()
+		span.SetError(err)
+		span.End()
 		if err != nil {
 			api.WriteError(w, err)
 			return
@@ -54,7 +92,7 @@ func (h *Handler) addDirLink(next nextHTTP) nextHTTP {
 			api.WriteError(w, err)
 			return
 		}
-		w.Header().Add("Link", link(h.Auth.GetLink(acme.DirectoryLink, acme.URLSafeProvisionerName(prov), true), "index"))
+		w.Header().Add("Link", link(h.Auth.GetLink(r.Context(), acme.DirectoryLink, acme.URLSafeProvisionerName(prov), true), "index"))
 		next(w, r)
 	}
 }
@@ -70,7 +108,7 @@ func (h *Handler) verifyContentType(next nextHTTP) nextHTTP {
 		}
 		ct := r.Header.Get("Content-Type")
 		var expected []string
-		if strings.Contains(r.URL.Path, h.Auth.GetLink(acme.CertificateLink, acme.URLSafeProvisionerName(prov), false, "")) {
+		if strings.Contains(r.URL.Path, h.Auth.GetLink(r.Context(), acme.CertificateLink, acme.URLSafeProvisionerName(prov), false, "")) {
 			// GET /certificate requests allow a greater range of content types.
 			expected = []string{"application/jose+json", "application/pkix-cert", "application/pkcs7-mime"}
 		} else {
@@ -93,9 +131,19 @@ func (h *Handler) parseJWS(next nextHTTP) nextHTTP {
 	return func(w http.ResponseWriter, r *http.Request) {
 		body, err := ioutil.ReadAll(r.Body)
 		if err != nil {
+			if err == api.ErrBodyTooLarge {
+				e := acme.MalformedErr(errors.Wrap(err, "failed to read request body"))
+				e.Status = http.StatusRequestEntityTooLarge
+				api.WriteError(w, e)
+				return
+			}
 			api.WriteError(w, acme.ServerInternalErr(errors.Wrap(err, "failed to read request body")))
 			return
 		}
+		if err := api.CheckJSONDepth(body, api.JSONDepthLimit(r.Body)); err != nil {
+			api.WriteError(w, acme.MalformedErr(errors.Wrap(err, "failed to parse JWS from request body")))
+			return
+		}
 		jws, err := jose.ParseJWS(string(body))
 		if err != nil {
 			api.WriteError(w, acme.MalformedErr(errors.Wrap(err, "failed to parse JWS from request body")))
@@ -164,15 +212,34 @@ func (h *Handler) validateJWS(next nextHTTP) nextHTTP {
 					return
 				}
 			}
-		case jose.ES256, jose.ES384, jose.ES512, jose.EdDSA:
-			// we good
+		case jose.ES256, jose.ES384, jose.ES512:
+			if hdr.JSONWebKey != nil {
+				k, ok := hdr.JSONWebKey.Key.(*ecdsa.PublicKey)
+				if !ok || k.Curve != ecdsaCurves[hdr.Algorithm] {
+					api.WriteError(w, acme.MalformedErr(errors.Errorf("jws key type and algorithm do not match")))
+					return
+				}
+			}
+		case jose.EdDSA:
+			if hdr.JSONWebKey != nil {
+				if _, ok := hdr.JSONWebKey.Key.(ed25519.PublicKey); !ok {
+					api.WriteError(w, acme.MalformedErr(errors.Errorf("jws key type and algorithm do not match")))
+					return
+				}
+			}
 		default:
-			api.WriteError(w, acme.MalformedErr(errors.Errorf("unsuitable algorithm: %s", hdr.Algorithm)))
+			err := acme.BadSignatureAlgorithmErr(errors.Errorf("unsuitable algorithm: %s", hdr.Algorithm))
+			err.Algorithms = supportedAlgorithms
+			api.WriteError(w, err)
 			return
 		}
 
 		// Check the validity/freshness of the Nonce.
-		if err := h.Auth.UseNonce(hdr.Nonce); err != nil {
+		_, span := tracing.Start(r.Context(), "acme.UseNonce")
+		err = h.Auth.UseNonce(hdr.Nonce)
+		span.SetError(err)
+		span.End()
+		if err != nil {
 			api.WriteError(w, err)
 			return
 		}
@@ -184,6 +251,9 @@ func (h *Handler) validateJWS(next nextHTTP) nextHTTP {
 			return
 		}
 		reqURL := &url.URL{Scheme: "https", Host: r.Host, Path: r.URL.Path}
+		if u := acme.BaseURLFromContext(r.Context()); u != nil {
+			reqURL.Scheme, reqURL.Host = u.Scheme, u.Host
+		}
 		if jwsURL != reqURL.String() {
 			api.WriteError(w, acme.MalformedErr(errors.Errorf("url header in JWS (%s) does not match request url (%s)", jwsURL, reqURL)))
 			return
@@ -227,7 +297,7 @@ func (h *Handler) extractJWK(next nextHTTP) nextHTTP {
 			return
 		}
 		ctx = context.WithValue(ctx, jwkContextKey, jwk)
-		acc, err := h.Auth.GetAccountByKey(prov, jwk)
+		acc, err := h.Auth.GetAccountByKey(r.Context(), prov, jwk)
 		switch {
 		case nosql.IsErrNotFound(err):
 			// For NewAccount requests ...
@@ -240,6 +310,7 @@ func (h *Handler) extractJWK(next nextHTTP) nextHTTP {
 				api.WriteError(w, acme.UnauthorizedErr(errors.New("account is not active")))
 				return
 			}
+			logAccount(w, acc)
 			ctx = context.WithValue(ctx, accContextKey, acc)
 		}
 		next(w, r.WithContext(ctx))
@@ -267,6 +338,7 @@ func (h *Handler) lookupProvisioner(next nextHTTP) nextHTTP {
 			api.WriteError(w, acme.AccountDoesNotExistErr(errors.New("provisioner must be of type ACME")))
 			return
 		}
+		logProvisioner(w, p)
 		ctx = context.WithValue(ctx, provisionerContextKey, p)
 		next(w, r.WithContext(ctx))
 	}
@@ -289,7 +361,7 @@ func (h *Handler) lookupJWK(next nextHTTP) nextHTTP {
 			return
 		}
 
-		kidPrefix := h.Auth.GetLink(acme.AccountLink, acme.URLSafeProvisionerName(prov), true, "")
+		kidPrefix := h.Auth.GetLink(r.Context(), acme.AccountLink, acme.URLSafeProvisionerName(prov), true, "")
 		kid := jws.Signatures[0].Protected.KeyID
 		if !strings.HasPrefix(kid, kidPrefix) {
 			api.WriteError(w, acme.MalformedErr(errors.Errorf("kid does not have "+
@@ -298,7 +370,10 @@ func (h *Handler) lookupJWK(next nextHTTP) nextHTTP {
 		}
 
 		accID := strings.TrimPrefix(kid, kidPrefix)
-		acc, err := h.Auth.GetAccount(prov, accID)
+		spanCtx, span := tracing.Start(ctx, "acme.GetAccount")
+		acc, err := h.Auth.GetAccount(spanCtx, prov, accID)
+		span.SetError(err)
+		span.End()
 		switch {
 		case nosql.IsErrNotFound(err):
 			api.WriteError(w, acme.AccountDoesNotExistErr(nil))
@@ -311,6 +386,7 @@ func (h *Handler) lookupJWK(next nextHTTP) nextHTTP {
 				api.WriteError(w, acme.UnauthorizedErr(errors.New("account is not active")))
 				return
 			}
+			logAccount(w, acc)
 			ctx = context.WithValue(ctx, accContextKey, acc)
 			ctx = context.WithValue(ctx, jwkContextKey, acc.Key)
 			next(w, r.WithContext(ctx))
@@ -337,11 +413,19 @@ func (h *Handler) verifyAndExtractJWSPayload(next nextHTTP) nextHTTP {
 			api.WriteError(w, acme.MalformedErr(errors.New("verifier and signature algorithm do not match")))
 			return
 		}
+		_, span := tracing.Start(r.Context(), "acme.VerifyJWS")
+		span.SetAttribute("alg", jws.Signatures[0].Protected.Algorithm)
 		payload, err := jws.Verify(jwk)
+		span.SetError(err)
+		span.End()
 		if err != nil {
 			api.WriteError(w, acme.MalformedErr(errors.Wrap(err, "error verifying jws")))
 			return
 		}
+		if err := api.CheckJSONDepth(payload, api.JSONDepthLimit(r.Body)); err != nil {
+			api.WriteError(w, acme.MalformedErr(errors.Wrap(err, "error parsing jws payload")))
+			return
+		}
 		ctx := context.WithValue(r.Context(), payloadContextKey, &payloadInfo{
 			value:       payload,
 			isPostAsGet: string(payload) == "",
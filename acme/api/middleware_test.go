@@ -11,12 +11,14 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	neturl "net/url"
 	"strings"
 	"testing"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
 	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/api"
 	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/cli/jose"
 	"github.com/smallstep/nosql/database"
@@ -430,10 +432,29 @@ func TestHandlerParseJWS(t *testing.T) {
 	type test struct {
 		next       nextHTTP
 		body       io.Reader
+		maxSize    int64
 		problem    *acme.Error
 		statusCode int
 	}
 	var tests = map[string]func(t *testing.T) test{
+		"fail/body-too-large": func(t *testing.T) test {
+			e := acme.MalformedErr(errors.New("failed to read request body: request body too large"))
+			e.Status = 413
+			return test{
+				body:       strings.NewReader(strings.Repeat("a", 100)),
+				maxSize:    99,
+				statusCode: 413,
+				problem:    e,
+			}
+		},
+		"fail/json-too-deep": func(t *testing.T) test {
+			return test{
+				body:       strings.NewReader(`{"protected":[[[[{"a":"]]]]"}]]]]}`),
+				maxSize:    100,
+				statusCode: 400,
+				problem:    acme.MalformedErr(errors.New("failed to parse JWS from request body: json document exceeds the maximum depth of 4")),
+			}
+		},
 		"fail/read-body-error": func(t *testing.T) test {
 			return test{
 				body:       errReader(0),
@@ -481,7 +502,11 @@ func TestHandlerParseJWS(t *testing.T) {
 			h := New(nil).(*Handler)
 			req := httptest.NewRequest("GET", url, tc.body)
 			w := httptest.NewRecorder()
-			h.parseJWS(tc.next)(w, req)
+			var handler http.Handler = http.HandlerFunc(h.parseJWS(tc.next))
+			if tc.maxSize > 0 {
+				handler = api.LimitBody(handler, tc.maxSize, 4)
+			}
+			handler.ServeHTTP(w, req)
 			res := w.Result()
 
 			assert.Equals(t, res.StatusCode, tc.statusCode)
@@ -604,6 +629,36 @@ func TestHandlerVerifyAndExtractJWSPayload(t *testing.T) {
 				},
 			}
 		},
+		"ok/ed25519": func(t *testing.T) test {
+			edJWK, err := jose.GenerateJWK("OKP", "Ed25519", "EdDSA", "sig", "", 0)
+			assert.FatalError(t, err)
+			edPub := edJWK.Public()
+			edSigner, err := jose.NewSigner(jose.SigningKey{
+				Algorithm: jose.EdDSA,
+				Key:       edJWK.Key,
+			}, new(jose.SignerOptions))
+			assert.FatalError(t, err)
+			_jws, err := edSigner.Sign([]byte("baz"))
+			assert.FatalError(t, err)
+			_raw, err := _jws.CompactSerialize()
+			assert.FatalError(t, err)
+			_parsed, err := jose.ParseJWS(_raw)
+			assert.FatalError(t, err)
+			ctx := context.WithValue(context.Background(), jwsContextKey, _parsed)
+			ctx = context.WithValue(ctx, jwkContextKey, &edPub)
+			return test{
+				ctx:        ctx,
+				statusCode: 200,
+				next: func(w http.ResponseWriter, r *http.Request) {
+					p, err := payloadFromContext(r)
+					assert.FatalError(t, err)
+					if assert.NotNil(t, p) {
+						assert.Equals(t, p.value, []byte("baz"))
+					}
+					w.Write(testBody)
+				},
+			}
+		},
 		"ok/empty-algorithm-in-jwk": func(t *testing.T) test {
 			_pub := *pub
 			clone := &_pub
@@ -1173,6 +1228,12 @@ func TestHandlerExtractJWK(t *testing.T) {
 	}
 }
 
+func badSignatureAlgorithm(err error) *acme.Error {
+	e := acme.BadSignatureAlgorithmErr(err)
+	e.Algorithms = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "EdDSA"}
+	return e
+}
+
 func TestHandlerValidateJWS(t *testing.T) {
 	url := "https://ca.smallstep.com/acme/account/1234"
 	type test struct {
@@ -1238,7 +1299,7 @@ func TestHandlerValidateJWS(t *testing.T) {
 			return test{
 				ctx:        context.WithValue(context.Background(), jwsContextKey, jws),
 				statusCode: 400,
-				problem:    acme.MalformedErr(errors.New("unsuitable algorithm: none")),
+				problem:    badSignatureAlgorithm(errors.New("unsuitable algorithm: none")),
 			}
 		},
 		"fail/unsuitable-algorithm-mac": func(t *testing.T) test {
@@ -1250,7 +1311,7 @@ func TestHandlerValidateJWS(t *testing.T) {
 			return test{
 				ctx:        context.WithValue(context.Background(), jwsContextKey, jws),
 				statusCode: 400,
-				problem:    acme.MalformedErr(errors.Errorf("unsuitable algorithm: %s", jose.HS256)),
+				problem:    badSignatureAlgorithm(errors.Errorf("unsuitable algorithm: %s", jose.HS256)),
 			}
 		},
 		"fail/rsa-key-&-alg-mismatch": func(t *testing.T) test {
@@ -1281,6 +1342,52 @@ func TestHandlerValidateJWS(t *testing.T) {
 				problem:    acme.MalformedErr(errors.Errorf("jws key type and algorithm do not match")),
 			}
 		},
+		"fail/ec-key-&-alg-mismatch": func(t *testing.T) test {
+			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			pub := jwk.Public()
+			jws := &jose.JSONWebSignature{
+				Signatures: []jose.Signature{
+					{
+						Protected: jose.Header{
+							Algorithm:  jose.ES384,
+							JSONWebKey: &pub,
+							ExtraHeaders: map[jose.HeaderKey]interface{}{
+								"url": url,
+							},
+						},
+					},
+				},
+			}
+			return test{
+				ctx:        context.WithValue(context.Background(), jwsContextKey, jws),
+				statusCode: 400,
+				problem:    acme.MalformedErr(errors.Errorf("jws key type and algorithm do not match")),
+			}
+		},
+		"fail/ed25519-key-&-alg-mismatch": func(t *testing.T) test {
+			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			pub := jwk.Public()
+			jws := &jose.JSONWebSignature{
+				Signatures: []jose.Signature{
+					{
+						Protected: jose.Header{
+							Algorithm:  jose.EdDSA,
+							JSONWebKey: &pub,
+							ExtraHeaders: map[jose.HeaderKey]interface{}{
+								"url": url,
+							},
+						},
+					},
+				},
+			}
+			return test{
+				ctx:        context.WithValue(context.Background(), jwsContextKey, jws),
+				statusCode: 400,
+				problem:    acme.MalformedErr(errors.Errorf("jws key type and algorithm do not match")),
+			}
+		},
 		"fail/rsa-key-too-small": func(t *testing.T) test {
 			jwk, err := jose.GenerateJWK("RSA", "", "", "sig", "", 1024)
 			assert.FatalError(t, err)
@@ -1447,6 +1554,34 @@ func TestHandlerValidateJWS(t *testing.T) {
 				statusCode: 200,
 			}
 		},
+		"ok/base-url": func(t *testing.T) test {
+			jws := &jose.JSONWebSignature{
+				Signatures: []jose.Signature{
+					{
+						Protected: jose.Header{
+							Algorithm: jose.ES256,
+							KeyID:     "bar",
+							ExtraHeaders: map[jose.HeaderKey]interface{}{
+								"url": "http://acme.example.com/acme/account/1234",
+							},
+						},
+					},
+				},
+			}
+			ctx := context.WithValue(context.Background(), jwsContextKey, jws)
+			return test{
+				auth: &mockAcmeAuthority{
+					useNonce: func(n string) error {
+						return nil
+					},
+				},
+				ctx: acme.NewContextWithBaseURL(ctx, &neturl.URL{Scheme: "http", Host: "acme.example.com"}),
+				next: func(w http.ResponseWriter, r *http.Request) {
+					w.Write(testBody)
+				},
+				statusCode: 200,
+			}
+		},
 		"ok/jwk/ecdsa": func(t *testing.T) test {
 			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
 			assert.FatalError(t, err)
@@ -1477,6 +1612,36 @@ func TestHandlerValidateJWS(t *testing.T) {
 				statusCode: 200,
 			}
 		},
+		"ok/jwk/ed25519": func(t *testing.T) test {
+			jwk, err := jose.GenerateJWK("OKP", "Ed25519", "EdDSA", "sig", "", 0)
+			assert.FatalError(t, err)
+			pub := jwk.Public()
+			jws := &jose.JSONWebSignature{
+				Signatures: []jose.Signature{
+					{
+						Protected: jose.Header{
+							Algorithm:  jose.EdDSA,
+							JSONWebKey: &pub,
+							ExtraHeaders: map[jose.HeaderKey]interface{}{
+								"url": url,
+							},
+						},
+					},
+				},
+			}
+			return test{
+				auth: &mockAcmeAuthority{
+					useNonce: func(n string) error {
+						return nil
+					},
+				},
+				ctx: context.WithValue(context.Background(), jwsContextKey, jws),
+				next: func(w http.ResponseWriter, r *http.Request) {
+					w.Write(testBody)
+				},
+				statusCode: 200,
+			}
+		},
 		"ok/jwk/rsa": func(t *testing.T) test {
 			jwk, err := jose.GenerateJWK("RSA", "", "", "sig", "", 2048)
 			assert.FatalError(t, err)
@@ -1533,6 +1698,7 @@ func TestHandlerValidateJWS(t *testing.T) {
 				assert.Equals(t, ae.Detail, prob.Detail)
 				assert.Equals(t, ae.Identifier, prob.Identifier)
 				assert.Equals(t, ae.Subproblems, prob.Subproblems)
+				assert.Equals(t, ae.Algorithms, prob.Algorithms)
 				assert.Equals(t, res.Header["Content-Type"], []string{"application/problem+json"})
 			} else {
 				assert.Equals(t, bytes.TrimSpace(body), testBody)
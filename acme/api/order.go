@@ -4,6 +4,7 @@ import (
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"net/http"
 	"time"
 
@@ -11,6 +12,9 @@ import (
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/acme"
 	"github.com/smallstep/certificates/api"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/logging"
+	"github.com/smallstep/certificates/tracing"
 )
 
 // NewOrderRequest represents the body for a NewOrder request.
@@ -18,18 +22,37 @@ type NewOrderRequest struct {
 	Identifiers []acme.Identifier `json:"identifiers"`
 	NotBefore   time.Time         `json:"notBefore,omitempty"`
 	NotAfter    time.Time         `json:"notAfter,omitempty"`
+	AutoRenewal *acme.AutoRenewal `json:"auto-renewal,omitempty"`
+	Profile     string            `json:"profile,omitempty"`
 }
 
-// Validate validates a new-order request body.
-func (n *NewOrderRequest) Validate() error {
+// Validate validates a new-order request body using the limits of the given
+// provisioner.
+func (n *NewOrderRequest) Validate(p provisioner.Interface) error {
 	if len(n.Identifiers) == 0 {
 		return acme.MalformedErr(errors.Errorf("identifiers list cannot be empty"))
 	}
+	if acmeProv, ok := p.(*provisioner.ACME); ok && acmeProv.MaxIdentifiersPerOrder > 0 && len(n.Identifiers) > acmeProv.MaxIdentifiersPerOrder {
+		return acme.MalformedErr(errors.Errorf("too many identifiers; the maximum is %d", acmeProv.MaxIdentifiersPerOrder))
+	}
+	var subs []*acme.Error
 	for _, id := range n.Identifiers {
-		if id.Type != "dns" {
-			return acme.MalformedErr(errors.Errorf("identifier type unsupported: %s", id.Type))
+		switch id.Type {
+		case "dns":
+		case "permanent-identifier", "TNAuthList":
+			if len(n.Identifiers) > 1 {
+				return acme.MalformedErr(errors.Errorf("%s cannot be combined with other identifiers", id.Type))
+			}
+		default:
+			subs = append(subs, acme.UnsupportedIdentifierErr(errors.Errorf("identifier type unsupported: %s", id.Type)).WithIdentifier(id))
 		}
 	}
+	if err := acme.SubproblemsErr(subs); err != nil {
+		return err
+	}
+	if n.AutoRenewal != nil && (!n.NotBefore.IsZero() || !n.NotAfter.IsZero()) {
+		return acme.MalformedErr(errors.New("notBefore and notAfter cannot be used with auto-renewal"))
+	}
 	return nil
 }
 
@@ -79,26 +102,42 @@ func (h *Handler) NewOrder(w http.ResponseWriter, r *http.Request) {
 			"failed to unmarshal new-order request payload")))
 		return
 	}
-	if err := nor.Validate(); err != nil {
+	if err := nor.Validate(prov); err != nil {
 		api.WriteError(w, err)
 		return
 	}
 
-	o, err := h.Auth.NewOrder(prov, acme.OrderOptions{
+	o, err := h.Auth.NewOrder(r.Context(), prov, acme.OrderOptions{
 		AccountID:   acc.GetID(),
 		Identifiers: nor.Identifiers,
 		NotBefore:   nor.NotBefore,
 		NotAfter:    nor.NotAfter,
+		AutoRenewal: nor.AutoRenewal,
+		Profile:     nor.Profile,
 	})
 	if err != nil {
 		api.WriteError(w, err)
 		return
 	}
 
-	w.Header().Set("Location", h.Auth.GetLink(acme.OrderLink, acme.URLSafeProvisionerName(prov), true, o.GetID()))
+	logOrder(w, o)
+	w.Header().Set("Location", h.Auth.GetLink(r.Context(), acme.OrderLink, acme.URLSafeProvisionerName(prov), true, o.GetID()))
 	api.JSONStatus(w, o, http.StatusCreated)
 }
 
+func logOrder(w http.ResponseWriter, o *acme.Order) {
+	if rl, ok := w.(logging.ResponseLogger); ok {
+		m := map[string]interface{}{
+			"order-id":     o.ID,
+			"order-status": o.Status,
+		}
+		if o.Certificate != "" {
+			m["certificate"] = o.Certificate
+		}
+		rl.WithFields(m)
+	}
+}
+
 // GetOrder ACME api for retrieving an order.
 func (h *Handler) GetOrder(w http.ResponseWriter, r *http.Request) {
 	prov, err := provisionerFromContext(r)
@@ -112,13 +151,13 @@ func (h *Handler) GetOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	oid := chi.URLParam(r, "ordID")
-	o, err := h.Auth.GetOrder(prov, acc.GetID(), oid)
+	o, err := h.Auth.GetOrder(r.Context(), prov, acc.GetID(), oid)
 	if err != nil {
 		api.WriteError(w, err)
 		return
 	}
 
-	w.Header().Set("Location", h.Auth.GetLink(acme.OrderLink, acme.URLSafeProvisionerName(prov), true, o.GetID()))
+	w.Header().Set("Location", h.Auth.GetLink(r.Context(), acme.OrderLink, acme.URLSafeProvisionerName(prov), true, o.GetID()))
 	api.JSON(w, o)
 }
 
@@ -150,12 +189,50 @@ func (h *Handler) FinalizeOrder(w http.ResponseWriter, r *http.Request) {
 	}
 
 	oid := chi.URLParam(r, "ordID")
-	o, err := h.Auth.FinalizeOrder(prov, acc.GetID(), oid, fr.csr)
+	ctx, span := tracing.Start(r.Context(), "acme.FinalizeOrder")
+	o, err := h.Auth.FinalizeOrder(ctx, prov, acc.GetID(), oid, fr.csr)
+	span.SetError(err)
+	span.End()
 	if err != nil {
 		api.WriteError(w, err)
 		return
 	}
 
-	w.Header().Set("Location", h.Auth.GetLink(acme.OrderLink, acme.URLSafeProvisionerName(prov), true, o.ID))
+	logOrder(w, o)
+	w.Header().Set("Location", h.Auth.GetLink(r.Context(), acme.OrderLink, acme.URLSafeProvisionerName(prov), true, o.ID))
 	api.JSON(w, o)
 }
+
+// GetStarCertificate ACME api for retrieving the latest certificate of a STAR
+// order. Unauthenticated GET requests are accepted if the order allows them.
+func (h *Handler) GetStarCertificate(w http.ResponseWriter, r *http.Request) {
+	prov, err := provisionerFromContext(r)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	var accID string
+	if r.Method != "GET" {
+		acc, err := accountFromContext(r)
+		if err != nil {
+			api.WriteError(w, err)
+			return
+		}
+		accID = acc.GetID()
+	}
+	oid := chi.URLParam(r, "ordID")
+	certBytes, err := h.Auth.GetStarCertificate(prov, accID, oid)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	// RFC 8739 headers with the validity of the certificate.
+	if block, _ := pem.Decode(certBytes); block != nil {
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			w.Header().Set("Cert-Not-Before", cert.NotBefore.UTC().Format(time.RFC3339))
+			w.Header().Set("Cert-Not-After", cert.NotAfter.UTC().Format(time.RFC3339))
+		}
+	}
+	writeCacheable(w, r, starCertificateCacheControl, "application/pem-certificate-chain; charset=utf-8", certBytes)
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"net/http/httptest"
@@ -23,6 +24,7 @@ import (
 func TestNewOrderRequestValidate(t *testing.T) {
 	type test struct {
 		nor      *NewOrderRequest
+		prov     provisioner.Interface
 		nbf, naf time.Time
 		err      *acme.Error
 	}
@@ -33,6 +35,19 @@ func TestNewOrderRequestValidate(t *testing.T) {
 				err: acme.MalformedErr(errors.Errorf("identifiers list cannot be empty")),
 			}
 		},
+		"fail/too-many-identifiers": func(t *testing.T) test {
+			return test{
+				nor: &NewOrderRequest{
+					Identifiers: []acme.Identifier{
+						{Type: "dns", Value: "example.com"},
+						{Type: "dns", Value: "www.example.com"},
+						{Type: "dns", Value: "api.example.com"},
+					},
+				},
+				prov: &provisioner.ACME{Type: "ACME", Name: "acme", MaxIdentifiersPerOrder: 2},
+				err:  acme.MalformedErr(errors.New("too many identifiers; the maximum is 2")),
+			}
+		},
 		"fail/bad-identifier": func(t *testing.T) test {
 			return test{
 				nor: &NewOrderRequest{
@@ -41,7 +56,53 @@ func TestNewOrderRequestValidate(t *testing.T) {
 						{Type: "foo", Value: "bar.com"},
 					},
 				},
-				err: acme.MalformedErr(errors.Errorf("identifier type unsupported: foo")),
+				err: acme.UnsupportedIdentifierErr(errors.Errorf("identifier type unsupported: foo")),
+			}
+		},
+		"fail/bad-identifiers": func(t *testing.T) test {
+			return test{
+				nor: &NewOrderRequest{
+					Identifiers: []acme.Identifier{
+						{Type: "foo", Value: "foo.com"},
+						{Type: "dns", Value: "example.com"},
+						{Type: "bar", Value: "bar.com"},
+					},
+				},
+				err: acme.CompoundErr(errors.New("2 identifiers have errors: identifier type unsupported: foo; identifier type unsupported: bar")),
+			}
+		},
+		"fail/permanent-identifier-with-dns": func(t *testing.T) test {
+			return test{
+				nor: &NewOrderRequest{
+					Identifiers: []acme.Identifier{
+						{Type: "permanent-identifier", Value: "12345678"},
+						{Type: "dns", Value: "example.com"},
+					},
+				},
+				err: acme.MalformedErr(errors.New("permanent-identifier cannot be combined with other identifiers")),
+			}
+		},
+		"fail/tnauthlist-with-dns": func(t *testing.T) test {
+			return test{
+				nor: &NewOrderRequest{
+					Identifiers: []acme.Identifier{
+						{Type: "dns", Value: "example.com"},
+						{Type: "TNAuthList", Value: "MAigBhYEMTIzNA=="},
+					},
+				},
+				err: acme.MalformedErr(errors.New("TNAuthList cannot be combined with other identifiers")),
+			}
+		},
+		"fail/auto-renewal-with-dates": func(t *testing.T) test {
+			return test{
+				nor: &NewOrderRequest{
+					Identifiers: []acme.Identifier{
+						{Type: "dns", Value: "example.com"},
+					},
+					NotAfter:    time.Now().UTC().Add(time.Hour),
+					AutoRenewal: &acme.AutoRenewal{Lifetime: 3600},
+				},
+				err: acme.MalformedErr(errors.New("notBefore and notAfter cannot be used with auto-renewal")),
 			}
 		},
 		"ok": func(t *testing.T) test {
@@ -60,11 +121,29 @@ func TestNewOrderRequestValidate(t *testing.T) {
 				naf: naf,
 			}
 		},
+		"ok/permanent-identifier": func(t *testing.T) test {
+			nbf := time.Now().UTC().Add(time.Minute)
+			naf := time.Now().UTC().Add(5 * time.Minute)
+			return test{
+				nor: &NewOrderRequest{
+					Identifiers: []acme.Identifier{
+						{Type: "permanent-identifier", Value: "12345678"},
+					},
+					NotAfter:  naf,
+					NotBefore: nbf,
+				},
+				nbf: nbf,
+				naf: naf,
+			}
+		},
 	}
 	for name, run := range tests {
 		tc := run(t)
 		t.Run(name, func(t *testing.T) {
-			if err := tc.nor.Validate(); err != nil {
+			if tc.prov == nil {
+				tc.prov = newProv()
+			}
+			if err := tc.nor.Validate(tc.prov); err != nil {
 				if assert.NotNil(t, err) {
 					ae, ok := err.(*acme.Error)
 					assert.True(t, ok)
@@ -438,6 +517,7 @@ func TestHandlerNewOrder(t *testing.T) {
 				},
 				NotBefore: nbf,
 				NotAfter:  naf,
+				Profile:   "shortlived",
 			}
 			b, err := json.Marshal(nor)
 			assert.FatalError(t, err)
@@ -452,6 +532,7 @@ func TestHandlerNewOrder(t *testing.T) {
 						assert.Equals(t, ops.Identifiers, nor.Identifiers)
 						assert.Equals(t, ops.NotBefore, nbf)
 						assert.Equals(t, ops.NotAfter, naf)
+						assert.Equals(t, ops.Profile, "shortlived")
 						return &o, nil
 					},
 					getLink: func(typ acme.Link, provID string, abs bool, in ...string) string {
@@ -755,3 +836,127 @@ func TestHandlerFinalizeOrder(t *testing.T) {
 		})
 	}
 }
+
+func TestHandlerGetStarCertificate(t *testing.T) {
+	leaf, err := pemutil.ReadCertificate("../../authority/testdata/certs/foo.crt")
+	assert.FatalError(t, err)
+	certBytes := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: leaf.Raw,
+	})
+	oid := "ordID"
+
+	prov := newProv()
+	chiCtx := chi.NewRouteContext()
+	chiCtx.URLParams.Add("ordID", oid)
+	url := fmt.Sprintf("http://ca.smallstep.com/acme/%s/star-certificate/%s",
+		acme.URLSafeProvisionerName(prov), oid)
+
+	type test struct {
+		auth       acme.Interface
+		method     string
+		ctx        context.Context
+		statusCode int
+		problem    *acme.Error
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/no-provisioner": func(t *testing.T) test {
+			return test{
+				auth:       &mockAcmeAuthority{},
+				method:     "GET",
+				ctx:        context.Background(),
+				statusCode: 500,
+				problem:    acme.ServerInternalErr(errors.New("provisioner expected in request context")),
+			}
+		},
+		"fail/post-no-account": func(t *testing.T) test {
+			return test{
+				auth:       &mockAcmeAuthority{},
+				method:     "POST",
+				ctx:        context.WithValue(context.Background(), provisionerContextKey, prov),
+				statusCode: 400,
+				problem:    acme.AccountDoesNotExistErr(nil),
+			}
+		},
+		"fail/getStarCertificate-error": func(t *testing.T) test {
+			ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+			ctx = context.WithValue(ctx, chi.RouteCtxKey, chiCtx)
+			return test{
+				auth: &mockAcmeAuthority{
+					err: acme.UnauthorizedErr(errors.New("force")),
+				},
+				method:     "GET",
+				ctx:        ctx,
+				statusCode: 401,
+				problem:    acme.UnauthorizedErr(errors.New("force")),
+			}
+		},
+		"ok/get": func(t *testing.T) test {
+			ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+			ctx = context.WithValue(ctx, chi.RouteCtxKey, chiCtx)
+			return test{
+				auth: &mockAcmeAuthority{
+					getStarCertificate: func(p provisioner.Interface, accID, id string) ([]byte, error) {
+						assert.Equals(t, p, prov)
+						assert.Equals(t, accID, "")
+						assert.Equals(t, id, oid)
+						return certBytes, nil
+					},
+				},
+				method:     "GET",
+				ctx:        ctx,
+				statusCode: 200,
+			}
+		},
+		"ok/post-as-get": func(t *testing.T) test {
+			acc := &acme.Account{ID: "accID"}
+			ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			ctx = context.WithValue(ctx, chi.RouteCtxKey, chiCtx)
+			return test{
+				auth: &mockAcmeAuthority{
+					getStarCertificate: func(p provisioner.Interface, accID, id string) ([]byte, error) {
+						assert.Equals(t, accID, acc.ID)
+						assert.Equals(t, id, oid)
+						return certBytes, nil
+					},
+				},
+				method:     "POST",
+				ctx:        ctx,
+				statusCode: 200,
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			h := New(tc.auth).(*Handler)
+			req := httptest.NewRequest(tc.method, url, nil)
+			req = req.WithContext(tc.ctx)
+			w := httptest.NewRecorder()
+			h.GetStarCertificate(w, req)
+			res := w.Result()
+
+			assert.Equals(t, res.StatusCode, tc.statusCode)
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+
+			if res.StatusCode >= 400 && assert.NotNil(t, tc.problem) {
+				var ae acme.AError
+				assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &ae))
+				prob := tc.problem.ToACME()
+				assert.Equals(t, ae.Type, prob.Type)
+				assert.Equals(t, ae.Detail, prob.Detail)
+			} else {
+				assert.Equals(t, body, certBytes)
+				assert.Equals(t, res.Header["Content-Type"], []string{"application/pem-certificate-chain; charset=utf-8"})
+				assert.Equals(t, res.Header.Get("Cert-Not-Before"), leaf.NotBefore.UTC().Format(time.RFC3339))
+				assert.Equals(t, res.Header.Get("Cert-Not-After"), leaf.NotAfter.UTC().Format(time.RFC3339))
+				assert.Equals(t, res.Header.Get("ETag"), etag(certBytes))
+				assert.Equals(t, res.Header.Get("Cache-Control"), "no-cache")
+			}
+		})
+	}
+}
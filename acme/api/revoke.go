@@ -0,0 +1,72 @@
+package api
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/api"
+)
+
+// RevokeCertRequest is the request body of an ACME revokeCert request.
+type RevokeCertRequest struct {
+	Certificate string `json:"certificate"`
+	Reason      *int   `json:"reason,omitempty"`
+	crt         *x509.Certificate
+}
+
+// Validate validates a revokeCert request body.
+func (r *RevokeCertRequest) Validate() error {
+	der, err := base64.RawURLEncoding.DecodeString(r.Certificate)
+	if err != nil {
+		return acme.MalformedErr(errors.Wrap(err, "error base64url decoding certificate"))
+	}
+	r.crt, err = x509.ParseCertificate(der)
+	if err != nil {
+		return acme.MalformedErr(errors.Wrap(err, "unable to parse certificate"))
+	}
+	return nil
+}
+
+// RevokeCert ACME api for revoking a certificate issued to the account that
+// signs the request.
+func (h *Handler) RevokeCert(w http.ResponseWriter, r *http.Request) {
+	prov, err := provisionerFromContext(r)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	acc, err := accountFromContext(r)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	payload, err := payloadFromContext(r)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	var rr RevokeCertRequest
+	if err := json.Unmarshal(payload.value, &rr); err != nil {
+		api.WriteError(w, acme.MalformedErr(errors.Wrap(err, "failed to unmarshal revoke-cert request payload")))
+		return
+	}
+	if err := rr.Validate(); err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	var reasonCode int
+	if rr.Reason != nil {
+		reasonCode = *rr.Reason
+	}
+	if err := h.Auth.RevokeCertificate(prov, acc.GetID(), rr.crt, reasonCode); err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
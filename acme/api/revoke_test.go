@@ -0,0 +1,165 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/cli/crypto/pemutil"
+)
+
+func TestHandlerRevokeCert(t *testing.T) {
+	crt, err := pemutil.ReadCertificate("../../authority/testdata/certs/foo.crt")
+	assert.FatalError(t, err)
+	prov := newProv()
+	acc := &acme.Account{ID: "accID"}
+	url := fmt.Sprintf("http://ca.smallstep.com/acme/%s/revoke-cert", acme.URLSafeProvisionerName(prov))
+
+	newContext := func(v interface{}) context.Context {
+		b, err := json.Marshal(v)
+		assert.FatalError(t, err)
+		ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+		ctx = context.WithValue(ctx, accContextKey, acc)
+		return context.WithValue(ctx, payloadContextKey, &payloadInfo{value: b})
+	}
+	keyCompromise := 1
+
+	type test struct {
+		auth       acme.Interface
+		ctx        context.Context
+		statusCode int
+		problem    *acme.Error
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/no-provisioner": func(t *testing.T) test {
+			return test{
+				auth:       &mockAcmeAuthority{},
+				ctx:        context.Background(),
+				statusCode: 500,
+				problem:    acme.ServerInternalErr(errors.New("provisioner expected in request context")),
+			}
+		},
+		"fail/no-account": func(t *testing.T) test {
+			return test{
+				auth:       &mockAcmeAuthority{},
+				ctx:        context.WithValue(context.Background(), provisionerContextKey, prov),
+				statusCode: 400,
+				problem:    acme.AccountDoesNotExistErr(nil),
+			}
+		},
+		"fail/no-payload": func(t *testing.T) test {
+			ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			return test{
+				ctx:        ctx,
+				statusCode: 500,
+				problem:    acme.ServerInternalErr(errors.New("payload expected in request context")),
+			}
+		},
+		"fail/unmarshal-payload-error": func(t *testing.T) test {
+			ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{})
+			return test{
+				ctx:        ctx,
+				statusCode: 400,
+				problem:    acme.MalformedErr(errors.New("failed to unmarshal revoke-cert request payload: unexpected end of JSON input")),
+			}
+		},
+		"fail/base64-error": func(t *testing.T) test {
+			return test{
+				ctx:        newContext(&RevokeCertRequest{Certificate: "%"}),
+				statusCode: 400,
+				problem:    acme.MalformedErr(errors.New("error base64url decoding certificate: illegal base64 data at input byte 0")),
+			}
+		},
+		"fail/parse-error": func(t *testing.T) test {
+			return test{
+				ctx:        newContext(&RevokeCertRequest{Certificate: "Zm9v"}),
+				statusCode: 400,
+				problem:    acme.MalformedErr(errors.New("unable to parse certificate: x509: malformed certificate")),
+			}
+		},
+		"fail/RevokeCertificate-error": func(t *testing.T) test {
+			return test{
+				auth: &mockAcmeAuthority{
+					revokeCertificate: func(p provisioner.Interface, accID string, c *x509.Certificate, reasonCode int) error {
+						return acme.AlreadyRevokedErr(errors.New("force"))
+					},
+				},
+				ctx:        newContext(&RevokeCertRequest{Certificate: base64.RawURLEncoding.EncodeToString(crt.Raw)}),
+				statusCode: 400,
+				problem:    acme.AlreadyRevokedErr(errors.New("force")),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				auth: &mockAcmeAuthority{
+					revokeCertificate: func(p provisioner.Interface, accID string, c *x509.Certificate, reasonCode int) error {
+						assert.Equals(t, p, prov)
+						assert.Equals(t, accID, acc.ID)
+						assert.Equals(t, c.Raw, crt.Raw)
+						assert.Equals(t, 0, reasonCode)
+						return nil
+					},
+				},
+				ctx:        newContext(&RevokeCertRequest{Certificate: base64.RawURLEncoding.EncodeToString(crt.Raw)}),
+				statusCode: 200,
+			}
+		},
+		"ok/reason": func(t *testing.T) test {
+			return test{
+				auth: &mockAcmeAuthority{
+					revokeCertificate: func(p provisioner.Interface, accID string, c *x509.Certificate, reasonCode int) error {
+						assert.Equals(t, 1, reasonCode)
+						return nil
+					},
+				},
+				ctx: newContext(&RevokeCertRequest{
+					Certificate: base64.RawURLEncoding.EncodeToString(crt.Raw),
+					Reason:      &keyCompromise,
+				}),
+				statusCode: 200,
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			h := New(tc.auth).(*Handler)
+			req := httptest.NewRequest("POST", url, nil)
+			req = req.WithContext(tc.ctx)
+			w := httptest.NewRecorder()
+			h.RevokeCert(w, req)
+			res := w.Result()
+
+			assert.Equals(t, res.StatusCode, tc.statusCode)
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+
+			if res.StatusCode >= 400 && assert.NotNil(t, tc.problem) {
+				var ae acme.AError
+				assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &ae))
+				prob := tc.problem.ToACME()
+
+				assert.Equals(t, ae.Type, prob.Type)
+				assert.Equals(t, ae.Detail, prob.Detail)
+				assert.Equals(t, res.Header["Content-Type"], []string{"application/problem+json"})
+			} else {
+				assert.Len(t, 0, body)
+			}
+		})
+	}
+}
@@ -1,48 +1,70 @@
 package acme
 
 import (
+	"context"
 	"crypto"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
+	"log"
 	"net"
-	"net/http"
 	"net/url"
+	"reflect"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/audit"
 	"github.com/smallstep/certificates/authority/provisioner"
 	database "github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/db/redis"
+	"github.com/smallstep/certificates/tracing"
 	"github.com/smallstep/cli/jose"
 	"github.com/smallstep/nosql"
 )
 
 // Interface is the acme authority interface.
 type Interface interface {
-	DeactivateAccount(provisioner.Interface, string) (*Account, error)
-	FinalizeOrder(provisioner.Interface, string, string, *x509.CertificateRequest) (*Order, error)
-	GetAccount(provisioner.Interface, string) (*Account, error)
-	GetAccountByKey(provisioner.Interface, *jose.JSONWebKey) (*Account, error)
-	GetAuthz(provisioner.Interface, string, string) (*Authz, error)
-	GetCertificate(string, string) ([]byte, error)
-	GetDirectory(provisioner.Interface) *Directory
-	GetLink(Link, string, bool, ...string) string
-	GetOrder(provisioner.Interface, string, string) (*Order, error)
-	GetOrdersByAccount(provisioner.Interface, string) ([]string, error)
+	DeactivateAccount(context.Context, provisioner.Interface, string) (*Account, error)
+	FinalizeOrder(context.Context, provisioner.Interface, string, string, *x509.CertificateRequest) (*Order, error)
+	GetAccount(context.Context, provisioner.Interface, string) (*Account, error)
+	GetAccountByKey(context.Context, provisioner.Interface, *jose.JSONWebKey) (*Account, error)
+	GetAuthz(context.Context, provisioner.Interface, string, string) (*Authz, error)
+	GetCertificate(provisioner.Interface, string, string) ([]byte, error)
+	GetDirectory(context.Context, provisioner.Interface) *Directory
+	GetLink(context.Context, Link, string, bool, ...string) string
+	GetOrder(context.Context, provisioner.Interface, string, string) (*Order, error)
+	GetOrdersByAccount(context.Context, provisioner.Interface, string) ([]string, error)
+	GetStarCertificate(provisioner.Interface, string, string) ([]byte, error)
 	LoadProvisionerByID(string) (provisioner.Interface, error)
-	NewAccount(provisioner.Interface, AccountOptions) (*Account, error)
+	NewAccount(context.Context, provisioner.Interface, AccountOptions) (*Account, error)
 	NewNonce() (string, error)
-	NewOrder(provisioner.Interface, OrderOptions) (*Order, error)
-	UpdateAccount(provisioner.Interface, string, []string) (*Account, error)
+	NewOrder(context.Context, provisioner.Interface, OrderOptions) (*Order, error)
+	RevokeCertificate(provisioner.Interface, string, *x509.Certificate, int) error
+	UpdateAccount(context.Context, provisioner.Interface, string, []string) (*Account, error)
 	UseNonce(string) error
-	ValidateChallenge(provisioner.Interface, string, string, *jose.JSONWebKey) (*Challenge, error)
+	ValidateChallenge(context.Context, provisioner.Interface, string, string, *jose.JSONWebKey, []byte) (*Challenge, error)
 }
 
 // Authority is the layer that handles all ACME interactions.
 type Authority struct {
-	db       nosql.DB
-	dir      *directory
-	signAuth SignAuthority
+	db           nosql.DB
+	dir          *directory
+	signAuth     SignAuthority
+	config       *Config
+	lookupTxt    lookupTxt
+	nonces       nonceStore
+	perspectives *perspectiveClient
+	webhooks     *webhookNotifier
+	accounts     *accountCache
+	redis        *redis.Client
+	limiter      *rateLimiter
+	audit        *audit.Logger
+	previous     *Authority
+	workers      *workers
+	stop         chan struct{}
+	stopOnce     sync.Once
 }
 
 var (
@@ -53,44 +75,244 @@ var (
 	nonceTable             = []byte("nonces")
 	orderTable             = []byte("acme_orders")
 	ordersByAccountIDTable = []byte("acme_account_orders_index")
+	accountOrdersTable     = []byte("acme_account_orders")
 	certTable              = []byte("acme_certs")
+	accountCertsTable      = []byte("acme_account_certs")
+	compromisedKeyTable    = []byte("acme_compromised_keys")
 )
 
+// Tables returns the tables with the state of the ACME server. The nonces
+// table is not included, nonces are only valid for a short time.
+func Tables() [][]byte {
+	return [][]byte{accountTable, accountByKeyIDTable, authzTable,
+		challengeTable, orderTable, ordersByAccountIDTable,
+		accountOrdersTable, certTable, accountCertsTable, compromisedKeyTable}
+}
+
 // NewAuthority returns a new Authority that implements the ACME interface.
-func NewAuthority(db nosql.DB, dns, prefix string, signAuth SignAuthority) (*Authority, error) {
+func NewAuthority(db nosql.DB, dns, prefix string, signAuth SignAuthority, opts ...Option) (*Authority, error) {
 	if _, ok := db.(*database.SimpleDB); !ok {
 		// If it's not a SimpleDB then go ahead and bootstrap the DB with the
 		// necessary ACME tables. SimpleDB should ONLY be used for testing.
-		tables := [][]byte{accountTable, accountByKeyIDTable, authzTable,
-			challengeTable, nonceTable, orderTable, ordersByAccountIDTable,
-			certTable}
-		for _, b := range tables {
+		for _, b := range append(Tables(), nonceTable) {
 			if err := db.CreateTable(b); err != nil {
 				return nil, errors.Wrapf(err, "error creating table %s",
 					string(b))
 			}
 		}
 	}
-	return &Authority{
-		db: db, dir: newDirectory(dns, prefix), signAuth: signAuth,
-	}, nil
+	a := &Authority{
+		db: &metricsDB{db}, dir: newDirectory(dns, prefix), signAuth: signAuth,
+		limiter: newRateLimiter(),
+	}
+	for _, fn := range opts {
+		if err := fn(a); err != nil {
+			return nil, err
+		}
+	}
+	if a.config == nil {
+		a.config = new(Config)
+	}
+	if prev := a.previous; prev != nil {
+		a.previous = nil
+		a.limiter = prev.limiter
+		a.workers = prev.workers
+		if reflect.DeepEqual(prev.config.Redis, a.config.Redis) {
+			a.redis = prev.redis
+		}
+		// The redis nonce store is replaced if the redis server changes.
+		if reflect.DeepEqual(prev.config.Nonce, a.config.Nonce) &&
+			(a.config.Nonce.GetStore() != NonceStoreRedis || a.redis != nil) {
+			a.nonces = prev.nonces
+		}
+	}
+	if a.redis == nil && a.config.Redis != nil {
+		client, err := redis.New(a.config.Redis)
+		if err != nil {
+			return nil, errors.Wrap(err, "error creating redis client")
+		}
+		a.redis = client
+	}
+	if a.nonces == nil {
+		nonces, err := newNonceStore(a.db, a.redis, a.config.Nonce)
+		if err != nil {
+			return nil, errors.Wrap(err, "error creating nonce store")
+		}
+		a.nonces = nonces
+	}
+	lookupTxt, err := newDNS01LookupTxt(a.config.DNS01)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating dns-01 resolver")
+	}
+	a.lookupTxt = lookupTxt
+	if a.perspectives, err = newPerspectiveClient(a.config.Perspectives); err != nil {
+		return nil, errors.Wrap(err, "error creating perspectives client")
+	}
+	if a.workers == nil {
+		a.workers = newWorkers()
+	}
+	a.webhooks = newWebhookNotifier(a.config.Webhooks)
+	if a.webhooks != nil {
+		a.webhooks.workers = a.workers
+	}
+	a.accounts = newAccountCache(a.config.AccountCache, a.redis)
+	return a, nil
+}
+
+// Run starts the background jobs of the ACME authority. Run must be called
+// only once, and the jobs are stopped with Stop.
+func (a *Authority) Run() {
+	a.stop = make(chan struct{})
+	a.workers.run(func() {
+		a.runNonceGC(a.config.Nonce.GetGCInterval())
+	})
+	if interval := a.config.Nonce.GetPersistInterval(); interval > 0 {
+		a.workers.run(func() {
+			a.runNonceFlush(interval)
+		})
+	}
+	if a.config.Cleanup.IsEnabled() {
+		a.workers.run(func() {
+			a.runCleanup(a.config.Cleanup.GetInterval())
+		})
+	}
+	a.workers.run(func() {
+		a.runAutoRenewal(a.config.AutoRenewal.GetInterval())
+	})
+	a.workers.run(a.resumeValidations)
+}
+
+// Stop stops the background jobs of the ACME authority.
+func (a *Authority) Stop() {
+	a.stopOnce.Do(func() {
+		if a.stop != nil {
+			close(a.stop)
+		}
+		if err := a.nonces.flush(); err != nil {
+			log.Printf("error storing acme nonces: %v", err)
+		}
+	})
+}
+
+// Shutdown stops the background jobs and waits until the in-flight challenge
+// validations and webhook deliveries, including the ones started before a
+// reload, are finished or the context is done. The pending retries are not
+// attempted, their challenges keep the processing status and the time of the
+// next attempt, and Run resumes them when the CA starts again. The Redis
+// client, shared with the authorities created on a reload, is closed after
+// the workers are done.
+func (a *Authority) Shutdown(ctx context.Context) error {
+	a.Stop()
+	err := a.workers.shutdown(ctx)
+	if a.redis != nil {
+		if cerr := a.redis.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// runNonceGC periodically deletes the expired nonces until the authority is
+// stopped.
+func (a *Authority) runNonceGC(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+			before := clock.Now().Add(-a.config.Nonce.GetMaxAge())
+			if _, err := a.nonces.deleteExpired(before); err != nil {
+				log.Printf("error deleting expired acme nonces: %v", err)
+			}
+		}
+	}
+}
+
+// runNonceFlush periodically writes the changes in the memory nonce store to
+// the database until the authority is stopped.
+func (a *Authority) runNonceFlush(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+			if err := a.nonces.flush(); err != nil {
+				log.Printf("error storing acme nonces: %v", err)
+			}
+		}
+	}
+}
+
+// runCleanup periodically deletes the expired orders, authorizations and
+// challenges until the authority is stopped.
+func (a *Authority) runCleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+			before := clock.Now().Add(-a.config.Cleanup.GetRetention())
+			if _, err := deleteExpiredOrders(a.db, before); err != nil {
+				log.Printf("error deleting expired acme orders: %v", err)
+			}
+		}
+	}
+}
+
+// runAutoRenewal periodically issues the certificates of the STAR orders
+// until the authority is stopped.
+func (a *Authority) runAutoRenewal(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+			if _, err := renewStarOrders(a.db, a.signAuth); err != nil {
+				log.Printf("error renewing acme star orders: %v", err)
+			}
+		}
+	}
 }
 
 // GetLink returns the requested link from the directory.
-func (a *Authority) GetLink(typ Link, provID string, abs bool, inputs ...string) string {
-	return a.dir.getLink(typ, provID, abs, inputs...)
+func (a *Authority) GetLink(ctx context.Context, typ Link, provID string, abs bool, inputs ...string) string {
+	return a.dir.getLink(ctx, typ, provID, abs, inputs...)
 }
 
 // GetDirectory returns the ACME directory object.
-func (a *Authority) GetDirectory(p provisioner.Interface) *Directory {
+func (a *Authority) GetDirectory(ctx context.Context, p provisioner.Interface) *Directory {
 	name := url.PathEscape(p.GetName())
-	return &Directory{
-		NewNonce:   a.dir.getLink(NewNonceLink, name, true),
-		NewAccount: a.dir.getLink(NewAccountLink, name, true),
-		NewOrder:   a.dir.getLink(NewOrderLink, name, true),
-		RevokeCert: a.dir.getLink(RevokeCertLink, name, true),
-		KeyChange:  a.dir.getLink(KeyChangeLink, name, true),
+	dir := &Directory{
+		NewNonce:   a.dir.getLink(ctx, NewNonceLink, name, true),
+		NewAccount: a.dir.getLink(ctx, NewAccountLink, name, true),
+		NewOrder:   a.dir.getLink(ctx, NewOrderLink, name, true),
+		RevokeCert: a.dir.getLink(ctx, RevokeCertLink, name, true),
+		KeyChange:  a.dir.getLink(ctx, KeyChangeLink, name, true),
 	}
+	meta := &Meta{
+		AutoRenewal: newMetaAutoRenewal(p),
+	}
+	if acmeProv, ok := p.(*provisioner.ACME); ok {
+		meta.TermsOfService = acmeProv.TermsOfService
+		if len(acmeProv.Profiles) > 0 {
+			meta.Profiles = make(map[string]string, len(acmeProv.Profiles))
+			for name, prof := range acmeProv.Profiles {
+				meta.Profiles[name] = prof.Description
+			}
+		}
+	}
+	if meta.TermsOfService != "" || meta.AutoRenewal != nil || meta.Profiles != nil {
+		dir.Meta = meta
+	}
+	return dir
 }
 
 // LoadProvisionerByID calls out to the SignAuthority interface to load a
@@ -101,58 +323,105 @@ func (a *Authority) LoadProvisionerByID(id string) (provisioner.Interface, error
 
 // NewNonce generates, stores, and returns a new ACME nonce.
 func (a *Authority) NewNonce() (string, error) {
-	n, err := newNonce(a.db)
+	n, err := a.nonces.newNonce()
 	if err != nil {
 		return "", err
 	}
+	noncesCreatedTotal.Inc()
 	return n.ID, nil
 }
 
 // UseNonce consumes the given nonce if it is valid, returns error otherwise.
 func (a *Authority) UseNonce(nonce string) error {
-	return useNonce(a.db, nonce)
+	err := a.nonces.useNonce(nonce, a.config.Nonce.GetMaxAge())
+	noncesUsedTotal.Inc(resultLabel(err))
+	return err
 }
 
 // NewAccount creates, stores, and returns a new ACME account.
-func (a *Authority) NewAccount(p provisioner.Interface, ao AccountOptions) (*Account, error) {
+func (a *Authority) NewAccount(ctx context.Context, p provisioner.Interface, ao AccountOptions) (*Account, error) {
+	if err := validateContacts(a.config.Contacts, ao.Contact); err != nil {
+		return nil, err
+	}
 	acc, err := newAccount(a.db, ao)
 	if err != nil {
 		return nil, err
 	}
-	return acc.toACME(a.db, a.dir, p)
+	a.auditAccount(ctx, audit.AccountCreated, p, acc)
+	return acc.toACME(ctx, a.db, a.dir, p)
 }
 
 // UpdateAccount updates an ACME account.
-func (a *Authority) UpdateAccount(p provisioner.Interface, id string, contact []string) (*Account, error) {
+func (a *Authority) UpdateAccount(ctx context.Context, p provisioner.Interface, id string, contact []string) (*Account, error) {
+	if err := validateContacts(a.config.Contacts, contact); err != nil {
+		return nil, err
+	}
 	acc, err := getAccountByID(a.db, id)
 	if err != nil {
 		return nil, ServerInternalErr(err)
 	}
-	if acc, err = acc.update(a.db, contact); err != nil {
+	acc, err = acc.update(a.db, contact)
+	a.accountChanged(id, acc, err)
+	if err != nil {
 		return nil, err
 	}
-	return acc.toACME(a.db, a.dir, p)
+	a.auditAccount(ctx, audit.AccountUpdated, p, acc)
+	return acc.toACME(ctx, a.db, a.dir, p)
 }
 
 // GetAccount returns an ACME account.
-func (a *Authority) GetAccount(p provisioner.Interface, id string) (*Account, error) {
-	acc, err := getAccountByID(a.db, id)
+func (a *Authority) GetAccount(ctx context.Context, p provisioner.Interface, id string) (*Account, error) {
+	acc, err := a.getAccount(id)
 	if err != nil {
 		return nil, err
 	}
-	return acc.toACME(a.db, a.dir, p)
+	return acc.toACME(ctx, a.db, a.dir, p)
 }
 
-// DeactivateAccount deactivates an ACME account.
-func (a *Authority) DeactivateAccount(p provisioner.Interface, id string) (*Account, error) {
+// DeactivateAccount deactivates an ACME account. If the provisioner is
+// configured to do it, the unexpired certificates of the account are revoked.
+func (a *Authority) DeactivateAccount(ctx context.Context, p provisioner.Interface, id string) (*Account, error) {
 	acc, err := getAccountByID(a.db, id)
 	if err != nil {
 		return nil, err
 	}
-	if acc, err = acc.deactivate(a.db); err != nil {
+	// Certificates are revoked first, so a failed revocation can be retried
+	// with a new deactivation request.
+	if revokeOnDeactivation(p) {
+		if err := a.revokeAccountCertificates(id); err != nil {
+			return nil, err
+		}
+	}
+	acc, err = acc.deactivate(a.db)
+	a.accountChanged(id, acc, err)
+	if err != nil {
 		return nil, err
 	}
-	return acc.toACME(a.db, a.dir, p)
+	a.auditAccount(ctx, audit.AccountDeactivated, p, acc)
+	return acc.toACME(ctx, a.db, a.dir, p)
+}
+
+// auditAccount records a change in an ACME account in the audit log.
+func (a *Authority) auditAccount(ctx context.Context, typ string, p provisioner.Interface, acc *account) {
+	if a.audit == nil {
+		return
+	}
+	e := &audit.Event{
+		Type:     typ,
+		Actor:    acc.ID,
+		ClientIP: audit.ClientIPFromContext(ctx),
+		Subject:  acc.ID,
+		Details: map[string]string{
+			"status": acc.Status,
+		},
+	}
+	if p != nil {
+		e.Provisioner = p.GetName()
+	}
+	if len(acc.Contact) > 0 {
+		e.Details["contact"] = strings.Join(acc.Contact, ",")
+	}
+	a.audit.Log(e)
 }
 
 func keyToID(jwk *jose.JSONWebKey) (string, error) {
@@ -164,20 +433,20 @@ func keyToID(jwk *jose.JSONWebKey) (string, error) {
 }
 
 // GetAccountByKey returns the ACME associated with the jwk id.
-func (a *Authority) GetAccountByKey(p provisioner.Interface, jwk *jose.JSONWebKey) (*Account, error) {
+func (a *Authority) GetAccountByKey(ctx context.Context, p provisioner.Interface, jwk *jose.JSONWebKey) (*Account, error) {
 	kid, err := keyToID(jwk)
 	if err != nil {
 		return nil, err
 	}
-	acc, err := getAccountByKeyID(a.db, kid)
+	acc, err := a.getAccountByKeyID(kid)
 	if err != nil {
 		return nil, err
 	}
-	return acc.toACME(a.db, a.dir, p)
+	return acc.toACME(ctx, a.db, a.dir, p)
 }
 
 // GetOrder returns an ACME order.
-func (a *Authority) GetOrder(p provisioner.Interface, accID, orderID string) (*Order, error) {
+func (a *Authority) GetOrder(ctx context.Context, p provisioner.Interface, accID, orderID string) (*Order, error) {
 	o, err := getOrder(a.db, orderID)
 	if err != nil {
 		return nil, err
@@ -188,41 +457,82 @@ func (a *Authority) GetOrder(p provisioner.Interface, accID, orderID string) (*O
 	if o, err = o.updateStatus(a.db); err != nil {
 		return nil, err
 	}
-	return o.toACME(a.db, a.dir, p)
+	return o.toACME(ctx, a.db, a.dir, p)
 }
 
 // GetOrdersByAccount returns the list of order urls owned by the account.
-func (a *Authority) GetOrdersByAccount(p provisioner.Interface, id string) ([]string, error) {
+func (a *Authority) GetOrdersByAccount(ctx context.Context, p provisioner.Interface, id string) ([]string, error) {
 	oids, err := getOrderIDsByAccount(a.db, id)
 	if err != nil {
 		return nil, err
 	}
 
+	orders, err := getOrders(a.db, oids)
+	if err != nil {
+		return nil, ServerInternalErr(err)
+	}
+
 	var ret = []string{}
-	for _, oid := range oids {
-		o, err := getOrder(a.db, oid)
-		if err != nil {
-			return nil, ServerInternalErr(err)
-		}
+	for _, o := range orders {
 		if o.Status == StatusInvalid {
 			continue
 		}
-		ret = append(ret, a.dir.getLink(OrderLink, URLSafeProvisionerName(p), true, o.ID))
+		ret = append(ret, a.dir.getLink(ctx, OrderLink, URLSafeProvisionerName(p), true, o.ID))
 	}
 	return ret, nil
 }
 
 // NewOrder generates, stores, and returns a new ACME order.
-func (a *Authority) NewOrder(p provisioner.Interface, ops OrderOptions) (*Order, error) {
-	order, err := newOrder(a.db, ops)
+func (a *Authority) NewOrder(ctx context.Context, p provisioner.Interface, ops OrderOptions) (*Order, error) {
+	if err := authorizeIdentifiers(p, ops.Identifiers); err != nil {
+		return nil, err
+	}
+	profile, err := authorizeProfile(p, ops.Profile)
+	if err != nil {
+		return nil, err
+	}
+	ops.Profile = profile
+	if err := authorizeValidity(p, ops.Profile, ops.NotBefore, ops.NotAfter); err != nil {
+		return nil, err
+	}
+	if ops.AutoRenewal != nil {
+		ar, err := validateAutoRenewal(p, ops.Profile, ops.AutoRenewal)
+		if err != nil {
+			return nil, err
+		}
+		ops.AutoRenewal = ar
+	}
+	ops.ProvisionerID = p.GetID()
+	ops.ChallengeTypes = getChallengeTypes(p)
+
+	// A pending or ready order with the same options is returned again, it
+	// does not count against the rate limits.
+	reused, err := findReusableOrder(a.db, ops)
 	if err != nil {
+		return nil, Wrap(err, "error looking up existing orders")
+	}
+	if reused != nil {
+		return reused.toACME(ctx, a.db, a.dir, p)
+	}
+
+	limits := getRateLimits(p)
+	key := ordersPerAccountKey(p, ops.AccountID)
+	res, err := a.limiter.reserve(key, limits.OrdersPerAccount, ordersPerAccountWindow)
+	if err != nil {
+		return nil, err
+	}
+	order, err := newOrder(a.db, ops, a.config.Cleanup)
+	if err != nil {
+		res.cancel()
 		return nil, Wrap(err, "error creating order")
 	}
-	return order.toACME(a.db, a.dir, p)
+	ordersCreatedTotal.Inc(p.GetName())
+	a.webhooks.notify(WebhookOrderCreated, p, order.AccountID, order.toAdmin())
+	return order.toACME(ctx, a.db, a.dir, p)
 }
 
 // FinalizeOrder attempts to finalize an order and generate a new certificate.
-func (a *Authority) FinalizeOrder(p provisioner.Interface, accID, orderID string, csr *x509.CertificateRequest) (*Order, error) {
+func (a *Authority) FinalizeOrder(ctx context.Context, p provisioner.Interface, accID, orderID string, csr *x509.CertificateRequest) (*Order, error) {
 	o, err := getOrder(a.db, orderID)
 	if err != nil {
 		return nil, err
@@ -230,16 +540,68 @@ func (a *Authority) FinalizeOrder(p provisioner.Interface, accID, orderID string
 	if accID != o.AccountID {
 		return nil, UnauthorizedErr(errors.New("account does not own order"))
 	}
-	o, err = o.finalize(a.db, csr, a.signAuth, p)
+
+	// Only the first successful finalization issues a certificate. The
+	// reservations are canceled if no certificate is issued.
+	limits := getRateLimits(p)
+	var reserved []*reservation
+	cancel := func() {
+		for _, res := range reserved {
+			res.cancel()
+		}
+	}
+	wasValid := o.Status == StatusValid
+	if !wasValid {
+		domains := make(map[string]bool)
+		for _, id := range o.Identifiers {
+			domain := registeredDomain(id.Value)
+			if domains[domain] {
+				continue
+			}
+			domains[domain] = true
+			res, err := a.limiter.reserve(certificatesPerDomainKey(p, domain), limits.CertificatesPerDomain, certificatesPerDomainWindow)
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			reserved = append(reserved, res)
+		}
+	}
+
+	start := time.Now()
+	o, err = o.finalize(ctx, a.db, csr, a.config.CSR, a.signAuth, p)
+	finalizeDuration.Observe(time.Since(start).Seconds(), p.GetName(), resultLabel(err))
 	if err != nil {
+		cancel()
 		return nil, Wrap(err, "error finalizing order")
 	}
-	return o.toACME(a.db, a.dir, p)
+	if o.Status != StatusValid {
+		cancel()
+	}
+	if o.Status == StatusValid && !wasValid {
+		a.webhooks.notify(WebhookOrderFinalized, p, o.AccountID, o.toAdmin())
+	}
+	return o.toACME(ctx, a.db, a.dir, p)
+}
+
+// GetStarCertificate retrieves the latest certificate of a STAR order. An
+// empty accID is used for unauthenticated requests.
+func (a *Authority) GetStarCertificate(p provisioner.Interface, accID, orderID string) ([]byte, error) {
+	cert, err := getStarCertificate(a.db, accID, orderID)
+	if err != nil {
+		return nil, err
+	}
+	b, err := cert.toACME(a.db, a.dir, p, a.getRoots())
+	if err != nil {
+		return nil, err
+	}
+	a.notifyCertificate(p, cert)
+	return b, nil
 }
 
 // GetAuthz retrieves and attempts to update the status on an ACME authz
 // before returning.
-func (a *Authority) GetAuthz(p provisioner.Interface, accID, authzID string) (*Authz, error) {
+func (a *Authority) GetAuthz(ctx context.Context, p provisioner.Interface, accID, authzID string) (*Authz, error) {
 	az, err := getAuthz(a.db, authzID)
 	if err != nil {
 		return nil, err
@@ -251,11 +613,16 @@ func (a *Authority) GetAuthz(p provisioner.Interface, accID, authzID string) (*A
 	if err != nil {
 		return nil, Wrap(err, "error updating authz status")
 	}
-	return az.toACME(a.db, a.dir, p)
+	return az.toACME(ctx, a.db, a.dir, p)
 }
 
-// ValidateChallenge attempts to validate the challenge.
-func (a *Authority) ValidateChallenge(p provisioner.Interface, accID, chID string, jwk *jose.JSONWebKey) (*Challenge, error) {
+// ValidateChallenge starts the validation of the challenge. The validation is
+// performed in the background, the status of the challenge will be updated
+// once the validation succeeds or the maximum number of attempts is reached.
+// The attestation statement of device-attest-01 challenges and the authority
+// token of tkauth-01 challenges are part of the payload, and these challenges
+// are validated before returning.
+func (a *Authority) ValidateChallenge(ctx context.Context, p provisioner.Interface, accID, chID string, jwk *jose.JSONWebKey, payload []byte) (*Challenge, error) {
 	ch, err := getChallenge(a.db, chID)
 	if err != nil {
 		return nil, err
@@ -263,27 +630,308 @@ func (a *Authority) ValidateChallenge(p provisioner.Interface, accID, chID strin
 	if accID != ch.getAccountID() {
 		return nil, UnauthorizedErr(errors.New("account does not own challenge"))
 	}
-	client := http.Client{
-		Timeout: time.Duration(30 * time.Second),
+	if ch.getStatus() != StatusPending {
+		return ch.toACME(ctx, a.db, a.dir, p)
+	}
+
+	// The validation counts as failed until it succeeds, so concurrent
+	// validations cannot exceed the limit.
+	res, err := a.limiter.reserve(failedValidationsKey(p, ch.getValue()), getRateLimits(p).FailedValidationsPerIdentifier, failedValidationsPerIdentifierWindow)
+	if err != nil {
+		return nil, err
+	}
+	switch ch.getType() {
+	case "device-attest-01":
+		return a.validateDeviceAttestation(ctx, p, ch, jwk, payload, res)
+	case "tkauth-01":
+		return a.validateAuthorityToken(ctx, p, ch, jwk, payload, res)
+	}
+	if ch, err = ch.process(a.db, p.GetID()); err != nil {
+		res.cancel()
+		return nil, Wrap(err, "error attempting challenge validation")
+	}
+	a.startValidation(tracing.Detach(ctx), p, ch.getID(), jwk, 0, a.config.Retry.GetBackoff(), res)
+	return ch.toACME(ctx, a.db, a.dir, p)
+}
+
+// validateDeviceAttestation validates a device-attest-01 challenge using the
+// attestation roots and formats of the provisioner.
+func (a *Authority) validateDeviceAttestation(ctx context.Context, p provisioner.Interface, ch challenge, jwk *jose.JSONWebKey, payload []byte, res *reservation) (*Challenge, error) {
+	acmeProv, ok := p.(*provisioner.ACME)
+	if !ok {
+		res.cancel()
+		return nil, ServerInternalErr(errors.Errorf("provisioner %s is not an ACME provisioner", p.GetName()))
+	}
+	roots, ok := acmeProv.GetAttestationRoots()
+	if !ok {
+		res.cancel()
+		return nil, RejectedIdentifierErr(errors.Errorf("provisioner %s does not support device attestation", p.GetName()))
+	}
+	vo := a.validateOptions(p)
+	vo.attestation = &attestationOptions{
+		payload:         payload,
+		roots:           roots,
+		isFormatEnabled: acmeProv.IsAttestationFormatEnabled,
+	}
+	return a.validateNow(ctx, p, ch, jwk, vo, res)
+}
+
+// validateAuthorityToken validates a tkauth-01 challenge using the token
+// authority roots of the provisioner.
+func (a *Authority) validateAuthorityToken(ctx context.Context, p provisioner.Interface, ch challenge, jwk *jose.JSONWebKey, payload []byte, res *reservation) (*Challenge, error) {
+	acmeProv, ok := p.(*provisioner.ACME)
+	if !ok {
+		res.cancel()
+		return nil, ServerInternalErr(errors.Errorf("provisioner %s is not an ACME provisioner", p.GetName()))
+	}
+	roots, ok := acmeProv.GetTokenAuthorityRoots()
+	if !ok {
+		res.cancel()
+		return nil, RejectedIdentifierErr(errors.Errorf("provisioner %s does not support authority tokens", p.GetName()))
+	}
+	vo := a.validateOptions(p)
+	vo.tkauth = &tkauthOptions{
+		payload: payload,
+		roots:   roots,
+	}
+	return a.validateNow(ctx, p, ch, jwk, vo, res)
+}
+
+// validateNow validates a challenge that is not retried in the background.
+// The reservation of the failed validation is canceled unless the challenge
+// becomes invalid.
+func (a *Authority) validateNow(ctx context.Context, p provisioner.Interface, ch challenge, jwk *jose.JSONWebKey, vo validateOptions, res *reservation) (*Challenge, error) {
+	ch, err := ch.validate(a.db, jwk, vo)
+	if err != nil {
+		res.cancel()
+		return nil, Wrap(err, "error validating challenge")
+	}
+	if ch.getStatus() != StatusInvalid {
+		res.cancel()
+	}
+	a.reportChallenge(p, ch)
+	return ch.toACME(ctx, a.db, a.dir, p)
+}
+
+// validateOptions returns the options used to validate a challenge of the
+// given provisioner.
+func (a *Authority) validateOptions(p provisioner.Interface) validateOptions {
+	var proxy *provisioner.ACMEHTTPProxy
+	if acmeProv, ok := p.(*provisioner.ACME); ok {
+		proxy = acmeProv.HTTP01Proxy
 	}
+	client := newHTTP01Client(a.config.HTTP01, proxy)
 	dialer := &net.Dialer{
 		Timeout: 30 * time.Second,
 	}
-	ch, err = ch.validate(a.db, jwk, validateOptions{
-		httpGet:   client.Get,
-		lookupTxt: net.LookupTXT,
+	vo := validateOptions{
+		httpGet:   client.Do,
+		lookupTxt: a.lookupTxt,
 		tlsDial: func(network, addr string, config *tls.Config) (*tls.Conn, error) {
 			return tls.DialWithDialer(dialer, network, addr, config)
 		},
+	}
+	if a.perspectives != nil {
+		vo.corroborate = a.perspectives.corroborate
+	}
+	return vo
+}
+
+// startValidation validates the challenge in the background after the given
+// delay. A challenge is only validated by one worker at a time, so resumed
+// validations do not duplicate the ones already running. The reservation of
+// the failed validation, if any, is kept if the challenge becomes invalid. The
+// attempts are traced as part of the span in the given context.
+func (a *Authority) startValidation(ctx context.Context, p provisioner.Interface, chID string, jwk *jose.JSONWebKey, delay, backoff time.Duration, res *reservation) {
+	if !a.workers.claim(chID) {
+		res.cancel()
+		return
+	}
+	a.workers.run(func() {
+		defer a.workers.release(chID)
+		if delay > 0 && !a.workers.sleep(delay) {
+			return
+		}
+		a.validateWithRetries(ctx, p, chID, jwk, backoff, res)
 	})
+}
+
+// validateWithRetries attempts to validate the challenge until it becomes
+// valid or the maximum number of attempts is reached, waiting an exponential
+// backoff between attempts. If the authority is shut down the challenge keeps
+// the processing status and the validation is resumed by the next Run.
+func (a *Authority) validateWithRetries(ctx context.Context, p provisioner.Interface, chID string, jwk *jose.JSONWebKey, backoff time.Duration, res *reservation) {
+	maxBackoff := a.config.Retry.GetMaxBackoff()
+	for {
+		_, span := tracing.Start(ctx, "acme.ValidateChallenge.attempt")
+		span.SetAttribute("challenge.id", chID)
+		retry, err := a.attemptValidation(p, chID, jwk, backoff, res)
+		span.SetAttribute("retry", retry)
+		span.SetError(err)
+		span.End()
+		if err != nil {
+			log.Printf("error validating acme challenge %s: %v", chID, err)
+			a.failValidation(p, chID, err)
+			return
+		}
+		if !retry {
+			return
+		}
+		if !a.workers.sleep(backoff) {
+			log.Printf("acme challenge %s not validated: the authority is shutting down", chID)
+			return
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// failValidation marks as invalid a challenge whose validation cannot
+// continue. The challenge is not modified if it's no longer processing, or if
+// another worker has scheduled a new attempt.
+func (a *Authority) failValidation(p provisioner.Interface, chID string, cause error) {
+	ch, err := getChallenge(a.db, chID)
 	if err != nil {
-		return nil, Wrap(err, "error attempting challenge validation")
+		log.Printf("error loading acme challenge %s: %v", chID, err)
+		return
+	}
+	if ch.getStatus() != StatusProcessing {
+		return
+	}
+	if r := ch.getRetry(); r != nil && r.NextAttempt.After(clock.Now()) {
+		return
+	}
+	upd := ch.clone()
+	upd.Status = StatusInvalid
+	upd.Error = ServerInternalErr(errors.Wrap(cause, "error validating challenge")).ToACME()
+	if upd.Retry != nil {
+		upd.Retry.NextAttempt = time.Time{}
+	}
+	if err := upd.save(a.db, ch); err != nil {
+		log.Printf("error marking acme challenge %s as invalid: %v", chID, err)
+		return
+	}
+	a.reportChallenge(p, upd)
+}
+
+// resumeValidations restarts the validation of the challenges left in the
+// processing status by a previous run, or by a worker that was stopped on a
+// reload, at the time of their next attempt.
+func (a *Authority) resumeValidations() {
+	entries, err := a.db.List(challengeTable)
+	if err != nil {
+		log.Printf("error listing acme challenges: %v", err)
+		return
+	}
+	for _, e := range entries {
+		ch, err := unmarshalChallenge(e.Value)
+		if err != nil {
+			log.Printf("error unmarshaling acme challenge %s: %v", e.Key, err)
+			continue
+		}
+		if ch.getStatus() == StatusProcessing {
+			a.resumeValidation(ch)
+		}
+	}
+}
+
+// resumeValidation restarts the validation of a processing challenge with the
+// provisioner and the account key used to start it. The backoff continues
+// from the number of attempts already performed.
+func (a *Authority) resumeValidation(ch challenge) {
+	retry := ch.getRetry()
+	if retry == nil || retry.ProvisionerID == "" {
+		a.failValidation(nil, ch.getID(), errors.New("validation cannot be resumed"))
+		return
+	}
+	p, err := a.signAuth.LoadProvisionerByID(retry.ProvisionerID)
+	if err != nil {
+		a.failValidation(nil, ch.getID(), errors.Wrapf(err, "error loading provisioner %s", retry.ProvisionerID))
+		return
+	}
+	acc, err := getAccountByID(a.db, ch.getAccountID())
+	if err != nil {
+		a.failValidation(p, ch.getID(), err)
+		return
+	}
+	backoff := a.config.Retry.GetBackoff()
+	maxBackoff := a.config.Retry.GetMaxBackoff()
+	for i := 0; i < retry.Count && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	a.startValidation(context.Background(), p, ch.getID(), acc.Key, retry.NextAttempt.Sub(clock.Now()), backoff, nil)
+}
+
+// attemptValidation performs one validation attempt of the challenge and
+// records the result. It returns true if a new attempt must be performed after
+// the given backoff. The reservation of the failed validation is canceled if
+// the challenge becomes valid, and a failure is counted if the validation did
+// not start with a reservation.
+func (a *Authority) attemptValidation(p provisioner.Interface, chID string, jwk *jose.JSONWebKey, backoff time.Duration, res *reservation) (bool, error) {
+	ch, err := getChallenge(a.db, chID)
+	if err != nil {
+		return false, err
+	}
+	if ch.getStatus() != StatusProcessing {
+		return false, nil
+	}
+	if _, err := ch.validate(a.db, jwk, a.validateOptions(p)); err != nil {
+		return false, err
+	}
+
+	// Reload the challenge, validate stores the error of a failed attempt.
+	if ch, err = getChallenge(a.db, chID); err != nil {
+		return false, err
+	}
+	if ch.getStatus() != StatusProcessing {
+		if ch.getStatus() == StatusInvalid {
+			a.countFailedValidation(p, ch, res)
+		} else {
+			res.cancel()
+		}
+		a.reportChallenge(p, ch)
+		return false, nil
+	}
+
+	upd := ch.clone()
+	if upd.Retry == nil {
+		upd.Retry = &Retry{}
+	}
+	upd.Retry.Count++
+	if upd.Error != nil {
+		upd.Retry.Errors = append(upd.Retry.Errors, upd.Error)
+	}
+	retry := upd.Retry.Count < a.config.Retry.GetMaxAttempts()
+	if retry {
+		upd.Retry.NextAttempt = clock.Now().Add(backoff)
+	} else {
+		upd.Status = StatusInvalid
+		upd.Retry.NextAttempt = time.Time{}
+	}
+	if err := upd.save(a.db, ch); err != nil {
+		return false, err
+	}
+	if !retry {
+		a.countFailedValidation(p, ch, res)
+		a.reportChallenge(p, upd)
+	}
+	return retry, nil
+}
+
+// countFailedValidation records a failed validation unless it was already
+// counted by the reservation made when the validation started.
+func (a *Authority) countFailedValidation(p provisioner.Interface, ch challenge, res *reservation) {
+	if res == nil {
+		a.limiter.add(failedValidationsKey(p, ch.getValue()), failedValidationsPerIdentifierWindow)
 	}
-	return ch.toACME(a.db, a.dir, p)
 }
 
 // GetCertificate retrieves the Certificate by ID.
-func (a *Authority) GetCertificate(accID, certID string) ([]byte, error) {
+func (a *Authority) GetCertificate(p provisioner.Interface, accID, certID string) ([]byte, error) {
 	cert, err := getCert(a.db, certID)
 	if err != nil {
 		return nil, err
@@ -291,5 +939,48 @@ func (a *Authority) GetCertificate(accID, certID string) ([]byte, error) {
 	if accID != cert.AccountID {
 		return nil, UnauthorizedErr(errors.New("account does not own certificate"))
 	}
-	return cert.toACME(a.db, a.dir)
+	b, err := cert.toACME(a.db, a.dir, p, a.getRoots())
+	if err != nil {
+		return nil, err
+	}
+	a.notifyCertificate(p, cert)
+	return b, nil
+}
+
+// getRoots returns the root certificates of the CA, if the sign authority
+// provides them.
+func (a *Authority) getRoots() []*x509.Certificate {
+	if ra, ok := a.signAuth.(rootsAuthority); ok {
+		return ra.GetRootCertificates()
+	}
+	return nil
+}
+
+// reportChallenge records the metrics and sends the webhook event of a
+// challenge that has become valid or invalid.
+func (a *Authority) reportChallenge(p provisioner.Interface, ch challenge) {
+	var event string
+	switch ch.getStatus() {
+	case StatusValid:
+		event = WebhookChallengeValid
+	case StatusInvalid:
+		event = WebhookChallengeInvalid
+	default:
+		return
+	}
+	challengesTotal.Inc(ch.getType(), ch.getStatus())
+	a.webhooks.notify(event, p, ch.getAccountID(), toWebhookChallenge(ch))
+}
+
+// notifyCertificate sends the webhook event of a downloaded certificate.
+func (a *Authority) notifyCertificate(p provisioner.Interface, cert *certificate) {
+	if a.webhooks == nil {
+		return
+	}
+	ac, err := cert.toAdmin()
+	if err != nil {
+		log.Printf("error sending acme webhook event for certificate %s: %v", cert.ID, err)
+		return
+	}
+	a.webhooks.notify(WebhookCertificateDownloaded, p, cert.AccountID, ac)
 }
@@ -1,14 +1,22 @@
 package acme
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/audit"
+	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/cli/crypto/pemutil"
 	"github.com/smallstep/cli/jose"
 	"github.com/smallstep/nosql/database"
 )
@@ -63,7 +71,7 @@ func TestAuthorityGetLink(t *testing.T) {
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := run(t)
-			link := tc.auth.GetLink(tc.typ, provID, tc.abs, tc.inputs...)
+			link := tc.auth.GetLink(context.Background(), tc.typ, provID, tc.abs, tc.inputs...)
 			assert.Equals(t, tc.res, link)
 		})
 	}
@@ -73,13 +81,81 @@ func TestAuthorityGetDirectory(t *testing.T) {
 	auth, err := NewAuthority(new(db.MockNoSQLDB), "ca.smallstep.com", "acme", nil)
 	assert.FatalError(t, err)
 	prov := newProv()
-	acmeDir := auth.GetDirectory(prov)
+	acmeDir := auth.GetDirectory(context.Background(), prov)
 	assert.Equals(t, acmeDir.NewNonce, fmt.Sprintf("https://ca.smallstep.com/acme/%s/new-nonce", URLSafeProvisionerName(prov)))
 	assert.Equals(t, acmeDir.NewAccount, fmt.Sprintf("https://ca.smallstep.com/acme/%s/new-account", URLSafeProvisionerName(prov)))
 	assert.Equals(t, acmeDir.NewOrder, fmt.Sprintf("https://ca.smallstep.com/acme/%s/new-order", URLSafeProvisionerName(prov)))
 	//assert.Equals(t, acmeDir.NewOrder, "httsp://ca.smallstep.com/acme/new-authz")
 	assert.Equals(t, acmeDir.RevokeCert, fmt.Sprintf("https://ca.smallstep.com/acme/%s/revoke-cert", URLSafeProvisionerName(prov)))
 	assert.Equals(t, acmeDir.KeyChange, fmt.Sprintf("https://ca.smallstep.com/acme/%s/key-change", URLSafeProvisionerName(prov)))
+	assert.Nil(t, acmeDir.Meta)
+
+	tosProv := &provisioner.ACME{
+		Type:           "ACME",
+		Name:           "test@acme-provisioner.com",
+		TermsOfService: "https://ca.smallstep.com/tos",
+	}
+	acmeDir = auth.GetDirectory(context.Background(), tosProv)
+	assert.Equals(t, acmeDir.Meta, &Meta{TermsOfService: "https://ca.smallstep.com/tos"})
+
+	starProv := newStarProv(&provisioner.ACMEAutoRenewal{AllowCertificateGet: true})
+	acmeDir = auth.GetDirectory(context.Background(), starProv)
+	assert.Equals(t, acmeDir.Meta, &Meta{AutoRenewal: &MetaAutoRenewal{
+		MinLifetime:         3600,
+		MaxDuration:         365 * 24 * 3600,
+		AllowCertificateGet: true,
+	}})
+
+	profProv := &provisioner.ACME{
+		Type: "ACME",
+		Name: "test@acme-provisioner.com",
+		Profiles: provisioner.ACMEProfiles{
+			"server":     {Description: "TLS server certificates"},
+			"shortlived": {},
+		},
+	}
+	acmeDir = auth.GetDirectory(context.Background(), profProv)
+	assert.Equals(t, acmeDir.Meta, &Meta{Profiles: map[string]string{
+		"server":     "TLS server certificates",
+		"shortlived": "",
+	}})
+}
+
+func TestAuthorityRunStop(t *testing.T) {
+	deleted := make(chan []byte, 1)
+	auth, err := NewAuthority(&db.MockNoSQLDB{
+		MList: func(bucket []byte) ([]*database.Entry, error) {
+			if string(bucket) == string(challengeTable) {
+				return nil, nil
+			}
+			assert.Equals(t, bucket, nonceTable)
+			b, err := json.Marshal(&nonce{ID: "foo", Created: clock.Now().Add(-2 * time.Hour)})
+			assert.FatalError(t, err)
+			return []*database.Entry{{Bucket: nonceTable, Key: []byte("foo"), Value: b}}, nil
+		},
+		MDel: func(bucket, key []byte) error {
+			select {
+			case deleted <- key:
+			default:
+			}
+			return nil
+		},
+	}, "ca.smallstep.com", "acme", nil, WithConfig(&Config{
+		Nonce: &NonceConfig{
+			GCInterval: &provisioner.Duration{Duration: 10 * time.Millisecond},
+		},
+	}))
+	assert.FatalError(t, err)
+
+	auth.Run()
+	select {
+	case key := <-deleted:
+		assert.Equals(t, []byte("foo"), key)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expired nonce was not deleted")
+	}
+	auth.Stop()
+	auth.Stop()
 }
 
 func TestAuthorityNewNonce(t *testing.T) {
@@ -213,6 +289,16 @@ func TestAuthorityNewAccount(t *testing.T) {
 				err:  ServerInternalErr(errors.New("error setting key-id to account-id index: force")),
 			}
 		},
+		"fail/invalid-contact": func(t *testing.T) test {
+			auth, err := NewAuthority(&db.MockNoSQLDB{}, "ca.smallstep.com", "acme", nil,
+				WithConfig(&Config{Contacts: &ContactsConfig{}}))
+			assert.FatalError(t, err)
+			return test{
+				auth: auth,
+				ops:  ops,
+				err:  UnsupportedContactErr(errors.New("contact foo is not supported; only mailto contacts are allowed")),
+			}
+		},
 		"ok": func(t *testing.T) test {
 			var (
 				_acmeacc = &Account{}
@@ -225,7 +311,7 @@ func TestAuthorityNewAccount(t *testing.T) {
 					if count == 1 {
 						var acc *account
 						assert.FatalError(t, json.Unmarshal(newval, &acc))
-						*acmeacc, err = acc.toACME(nil, dir, prov)
+						*acmeacc, err = acc.toACME(context.Background(), nil, dir, prov)
 						return nil, true, nil
 					}
 					count++
@@ -243,7 +329,7 @@ func TestAuthorityNewAccount(t *testing.T) {
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := run(t)
-			if acmeAcc, err := tc.auth.NewAccount(prov, tc.ops); err != nil {
+			if acmeAcc, err := tc.auth.NewAccount(context.Background(), prov, tc.ops); err != nil {
 				if assert.NotNil(t, tc.err) {
 					ae, ok := err.(*Error)
 					assert.True(t, ok)
@@ -310,7 +396,7 @@ func TestAuthorityGetAccount(t *testing.T) {
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := run(t)
-			if acmeAcc, err := tc.auth.GetAccount(prov, tc.id); err != nil {
+			if acmeAcc, err := tc.auth.GetAccount(context.Background(), prov, tc.id); err != nil {
 				if assert.NotNil(t, tc.err) {
 					ae, ok := err.(*Error)
 					assert.True(t, ok)
@@ -323,7 +409,7 @@ func TestAuthorityGetAccount(t *testing.T) {
 					gotb, err := json.Marshal(acmeAcc)
 					assert.FatalError(t, err)
 
-					acmeExp, err := tc.acc.toACME(nil, tc.auth.dir, prov)
+					acmeExp, err := tc.acc.toACME(context.Background(), nil, tc.auth.dir, prov)
 					assert.FatalError(t, err)
 					expb, err := json.Marshal(acmeExp)
 					assert.FatalError(t, err)
@@ -411,7 +497,7 @@ func TestAuthorityGetAccountByKey(t *testing.T) {
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := run(t)
-			if acmeAcc, err := tc.auth.GetAccountByKey(prov, tc.jwk); err != nil {
+			if acmeAcc, err := tc.auth.GetAccountByKey(context.Background(), prov, tc.jwk); err != nil {
 				if assert.NotNil(t, tc.err) {
 					ae, ok := err.(*Error)
 					assert.True(t, ok)
@@ -424,7 +510,7 @@ func TestAuthorityGetAccountByKey(t *testing.T) {
 					gotb, err := json.Marshal(acmeAcc)
 					assert.FatalError(t, err)
 
-					acmeExp, err := tc.acc.toACME(nil, tc.auth.dir, prov)
+					acmeExp, err := tc.acc.toACME(context.Background(), nil, tc.auth.dir, prov)
 					assert.FatalError(t, err)
 					expb, err := json.Marshal(acmeExp)
 					assert.FatalError(t, err)
@@ -486,18 +572,14 @@ func TestAuthorityGetOrder(t *testing.T) {
 			assert.FatalError(t, err)
 			b, err := json.Marshal(o)
 			assert.FatalError(t, err)
-			i := 0
 			auth, err := NewAuthority(&db.MockNoSQLDB{
 				MGet: func(bucket, key []byte) ([]byte, error) {
-					switch {
-					case i == 0:
-						i++
-						assert.Equals(t, bucket, orderTable)
+					switch string(bucket) {
+					case string(orderTable):
 						assert.Equals(t, key, []byte(o.ID))
 						return b, nil
 					default:
 						assert.Equals(t, bucket, authzTable)
-						assert.Equals(t, key, []byte(o.Authorizations[0]))
 						return nil, ServerInternalErr(errors.New("force"))
 					}
 				},
@@ -507,7 +589,7 @@ func TestAuthorityGetOrder(t *testing.T) {
 				auth:  auth,
 				id:    o.ID,
 				accID: o.AccountID,
-				err:   ServerInternalErr(errors.Errorf("error loading authz %s: force", o.Authorizations[0])),
+				err:   ServerInternalErr(errors.Errorf("error loading authz batch: error getting acme_authzs/%s: force", o.Authorizations[0])),
 			}
 		},
 		"ok": func(t *testing.T) test {
@@ -535,7 +617,7 @@ func TestAuthorityGetOrder(t *testing.T) {
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := run(t)
-			if acmeO, err := tc.auth.GetOrder(prov, tc.accID, tc.id); err != nil {
+			if acmeO, err := tc.auth.GetOrder(context.Background(), prov, tc.accID, tc.id); err != nil {
 				if assert.NotNil(t, tc.err) {
 					ae, ok := err.(*Error)
 					assert.True(t, ok)
@@ -548,7 +630,7 @@ func TestAuthorityGetOrder(t *testing.T) {
 					gotb, err := json.Marshal(acmeO)
 					assert.FatalError(t, err)
 
-					acmeExp, err := tc.o.toACME(nil, tc.auth.dir, prov)
+					acmeExp, err := tc.o.toACME(context.Background(), nil, tc.auth.dir, prov)
 					assert.FatalError(t, err)
 					expb, err := json.Marshal(acmeExp)
 					assert.FatalError(t, err)
@@ -561,6 +643,7 @@ func TestAuthorityGetOrder(t *testing.T) {
 }
 
 func TestAuthorityGetCertificate(t *testing.T) {
+	prov := newProv()
 	type test struct {
 		auth      *Authority
 		id, accID string
@@ -628,7 +711,7 @@ func TestAuthorityGetCertificate(t *testing.T) {
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := run(t)
-			if acmeCert, err := tc.auth.GetCertificate(tc.accID, tc.id); err != nil {
+			if acmeCert, err := tc.auth.GetCertificate(prov, tc.accID, tc.id); err != nil {
 				if assert.NotNil(t, tc.err) {
 					ae, ok := err.(*Error)
 					assert.True(t, ok)
@@ -641,7 +724,7 @@ func TestAuthorityGetCertificate(t *testing.T) {
 					gotb, err := json.Marshal(acmeCert)
 					assert.FatalError(t, err)
 
-					acmeExp, err := tc.cert.toACME(nil, tc.auth.dir)
+					acmeExp, err := tc.cert.toACME(nil, tc.auth.dir, prov, nil)
 					assert.FatalError(t, err)
 					expb, err := json.Marshal(acmeExp)
 					assert.FatalError(t, err)
@@ -653,6 +736,26 @@ func TestAuthorityGetCertificate(t *testing.T) {
 	}
 }
 
+type mockRootsAuthority struct {
+	mockSignAuth
+	roots []*x509.Certificate
+}
+
+func (m *mockRootsAuthority) GetRootCertificates() []*x509.Certificate {
+	return m.roots
+}
+
+func TestAuthorityGetRoots(t *testing.T) {
+	roots := []*x509.Certificate{{Raw: []byte("root")}}
+	auth, err := NewAuthority(&db.MockNoSQLDB{}, "ca.smallstep.com", "acme", &mockRootsAuthority{roots: roots})
+	assert.FatalError(t, err)
+	assert.Equals(t, roots, auth.getRoots())
+
+	auth, err = NewAuthority(&db.MockNoSQLDB{}, "ca.smallstep.com", "acme", &mockSignAuth{})
+	assert.FatalError(t, err)
+	assert.Nil(t, auth.getRoots())
+}
+
 func TestAuthorityGetAuthz(t *testing.T) {
 	prov := newProv()
 	type test struct {
@@ -748,7 +851,7 @@ func TestAuthorityGetAuthz(t *testing.T) {
 			}
 			az, err := newAuthz(mockdb, "1234", Identifier{
 				Type: "dns", Value: "acme.example.com",
-			})
+			}, nil)
 			assert.FatalError(t, err)
 			_az, ok := az.(*dnsAuthz)
 			assert.Fatal(t, ok)
@@ -784,7 +887,7 @@ func TestAuthorityGetAuthz(t *testing.T) {
 					return ret, nil
 				},
 			}
-			acmeAz, err := az.toACME(mockdb, newDirectory("ca.smallstep.com", "acme"), prov)
+			acmeAz, err := az.toACME(context.Background(), mockdb, newDirectory("ca.smallstep.com", "acme"), prov)
 			assert.FatalError(t, err)
 
 			count = 0
@@ -825,7 +928,7 @@ func TestAuthorityGetAuthz(t *testing.T) {
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := run(t)
-			if acmeAz, err := tc.auth.GetAuthz(prov, tc.accID, tc.id); err != nil {
+			if acmeAz, err := tc.auth.GetAuthz(context.Background(), prov, tc.accID, tc.id); err != nil {
 				if assert.NotNil(t, tc.err) {
 					ae, ok := err.(*Error)
 					assert.True(t, ok)
@@ -857,24 +960,65 @@ func TestAuthorityNewOrder(t *testing.T) {
 		o    **Order
 	}
 	tests := map[string]func(t *testing.T) test{
+		"fail/findReusableOrder-error": func(t *testing.T) test {
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					assert.Equals(t, bucket, accountOrdersTable)
+					return nil, errors.New("force")
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			return test{
+				auth: auth,
+				ops:  defaultOrderOps(),
+				err:  ServerInternalErr(errors.New("error looking up existing orders: error loading order index for account accID: force")),
+			}
+		},
 		"fail/newOrder-error": func(t *testing.T) test {
 			auth, err := NewAuthority(&db.MockNoSQLDB{
 				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
-					return nil, false, errors.New("force")
+					return newval, true, nil
+				},
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					return nil, database.ErrNotFound
+				},
+				MUpdate: func(tx *database.Tx) error {
+					return errors.New("force")
 				},
 			}, "ca.smallstep.com", "acme", nil)
 			assert.FatalError(t, err)
 			return test{
 				auth: auth,
 				ops:  defaultOrderOps(),
-				err:  ServerInternalErr(errors.New("error creating order: error creating http challenge: error saving acme challenge: force")),
+				err:  ServerInternalErr(errors.New("error creating order: error committing transaction: force")),
+			}
+		},
+		"fail/permanent-identifier-not-supported": func(t *testing.T) test {
+			auth, err := NewAuthority(&db.MockNoSQLDB{}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			ops := defaultOrderOps()
+			ops.Identifiers = []Identifier{{Type: "permanent-identifier", Value: "12345678"}}
+			return test{
+				auth: auth,
+				ops:  ops,
+				err:  RejectedIdentifierErr(errors.New("provisioner test@acme-provisioner.com does not support permanent-identifier identifiers")),
+			}
+		},
+		"fail/validity-not-allowed": func(t *testing.T) test {
+			auth, err := NewAuthority(&db.MockNoSQLDB{}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			ops := defaultOrderOps()
+			ops.NotAfter = ops.NotBefore.Add(30 * 24 * time.Hour)
+			return test{
+				auth: auth,
+				ops:  ops,
+				err:  MalformedErr(errors.New("invalid notBefore or notAfter: requested duration of 720h0m0s is more than the authorized maximum certificate duration")),
 			}
 		},
 		"ok": func(t *testing.T) test {
 			var (
 				_acmeO = &Order{}
 				acmeO  = &_acmeO
-				count  = 0
 				dir    = newDirectory("ca.smallstep.com", "acme")
 				err    error
 				_accID string
@@ -882,36 +1026,38 @@ func TestAuthorityNewOrder(t *testing.T) {
 			)
 			auth, err := NewAuthority(&db.MockNoSQLDB{
 				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
-					switch count {
-					case 0:
-						assert.Equals(t, bucket, challengeTable)
-					case 1:
-						assert.Equals(t, bucket, challengeTable)
-					case 2:
-						assert.Equals(t, bucket, challengeTable)
-					case 3:
-						assert.Equals(t, bucket, authzTable)
-					case 4:
-						assert.Equals(t, bucket, challengeTable)
-					case 5:
-						assert.Equals(t, bucket, challengeTable)
-					case 6:
-						assert.Equals(t, bucket, challengeTable)
-					case 7:
-						assert.Equals(t, bucket, authzTable)
-					case 8:
-						assert.Equals(t, bucket, orderTable)
-						var o order
-						assert.FatalError(t, json.Unmarshal(newval, &o))
-						*acmeO, err = o.toACME(nil, dir, prov)
-						assert.FatalError(t, err)
-						*accID = o.AccountID
-					case 9:
-						assert.Equals(t, bucket, ordersByAccountIDTable)
-						assert.Equals(t, string(key), *accID)
+					assert.Equals(t, bucket, accountOrdersTable)
+					assert.Equals(t, old, nil)
+					assert.Equals(t, newval, orderIndexHead{next: 1}.bytes())
+					*accID = string(key)
+					return newval, true, nil
+				},
+				MUpdate: func(tx *database.Tx) error {
+					assert.Equals(t, 10, len(tx.Operations))
+					for i, op := range tx.Operations {
+						switch i {
+						case 0, 1, 2, 4, 5, 6:
+							assert.Equals(t, op.Bucket, challengeTable)
+						case 3, 7:
+							assert.Equals(t, op.Bucket, authzTable)
+						case 8:
+							assert.Equals(t, op.Bucket, orderTable)
+							var o order
+							assert.FatalError(t, json.Unmarshal(op.Value, &o))
+							*acmeO, err = o.toACME(context.Background(), nil, dir, prov)
+							assert.FatalError(t, err)
+							assert.Equals(t, o.AccountID, *accID)
+						case 9:
+							assert.Equals(t, op.Cmd, database.Set)
+							assert.Equals(t, op.Bucket, accountOrdersTable)
+							assert.Equals(t, op.Key, orderIndexKey(*accID, 0))
+						}
+						if op.Cmd == database.CmpAndSwap {
+							assert.Equals(t, op.CmpValue, nil)
+							op.Swapped = true
+						}
 					}
-					count++
-					return nil, true, nil
+					return nil
 				},
 				MGet: func(bucket, key []byte) ([]byte, error) {
 					return nil, database.ErrNotFound
@@ -924,11 +1070,68 @@ func TestAuthorityNewOrder(t *testing.T) {
 				o:    acmeO,
 			}
 		},
+		"ok/reused": func(t *testing.T) test {
+			ops := defaultOrderOps()
+			ops.ProvisionerID = prov.GetID()
+			az, err := newAz()
+			assert.FatalError(t, err)
+			o, err := newO()
+			assert.FatalError(t, err)
+			o.ProvisionerID = ops.ProvisionerID
+			o.NotBefore, o.NotAfter = ops.NotBefore, ops.NotAfter
+			o.Authorizations = []string{az.getID()}
+			// Identifiers can be requested in any order and case.
+			o.Identifiers = []Identifier{ops.Identifiers[1], {Type: "dns", Value: strings.ToUpper(ops.Identifiers[0].Value)}}
+
+			other, err := newO()
+			assert.FatalError(t, err)
+			other.ProvisionerID = ops.ProvisionerID
+			other.Profile = "shortlived"
+
+			values := map[string][]byte{}
+			values[ops.AccountID], err = json.Marshal([]string{o.ID, other.ID})
+			assert.FatalError(t, err)
+			for id, v := range map[string]interface{}{o.ID: o, other.ID: other, az.getID(): az} {
+				values[id], err = json.Marshal(v)
+				assert.FatalError(t, err)
+			}
+			ch, err := newHTTPCh()
+			assert.FatalError(t, err)
+			chb, err := json.Marshal(ch)
+			assert.FatalError(t, err)
+
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					switch string(bucket) {
+					case string(accountOrdersTable):
+						// Orders are read from the legacy index.
+						return nil, database.ErrNotFound
+					case string(challengeTable):
+						return chb, nil
+					}
+					return values[string(key)], nil
+				},
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					// Only the status of the reused order is updated.
+					assert.Equals(t, bucket, orderTable)
+					assert.Equals(t, key, []byte(o.ID))
+					return nil, true, nil
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			acmeO, err := o.toACME(context.Background(), nil, auth.dir, prov)
+			assert.FatalError(t, err)
+			return test{
+				auth: auth,
+				ops:  ops,
+				o:    &acmeO,
+			}
+		},
 	}
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := run(t)
-			if acmeO, err := tc.auth.NewOrder(prov, tc.ops); err != nil {
+			if acmeO, err := tc.auth.NewOrder(context.Background(), prov, tc.ops); err != nil {
 				if assert.NotNil(t, tc.err) {
 					ae, ok := err.(*Error)
 					assert.True(t, ok)
@@ -962,7 +1165,7 @@ func TestAuthorityGetOrdersByAccount(t *testing.T) {
 			id := "foo"
 			auth, err := NewAuthority(&db.MockNoSQLDB{
 				MGet: func(bucket, key []byte) ([]byte, error) {
-					assert.Equals(t, bucket, ordersByAccountIDTable)
+					assert.Equals(t, bucket, accountOrdersTable)
 					assert.Equals(t, key, []byte(id))
 					return nil, errors.New("force")
 				},
@@ -971,79 +1174,66 @@ func TestAuthorityGetOrdersByAccount(t *testing.T) {
 			return test{
 				auth: auth,
 				id:   id,
-				err:  ServerInternalErr(errors.New("error loading orderIDs for account foo: force")),
+				err:  ServerInternalErr(errors.New("error loading order index for account foo: force")),
 			}
 		},
 		"fail/getOrder-error": func(t *testing.T) test {
 			var (
-				id    = "zap"
-				oids  = []string{"foo", "bar"}
-				count = 0
-				err   error
+				id   = "zap"
+				oids = []string{"foo", "bar"}
 			)
+			oidsb, err := json.Marshal(oids)
+			assert.FatalError(t, err)
 			auth, err := NewAuthority(&db.MockNoSQLDB{
 				MGet: func(bucket, key []byte) ([]byte, error) {
-					var ret []byte
-					switch count {
-					case 0:
-						assert.Equals(t, bucket, ordersByAccountIDTable)
+					switch string(bucket) {
+					case string(accountOrdersTable):
+						return nil, database.ErrNotFound
+					case string(ordersByAccountIDTable):
 						assert.Equals(t, key, []byte(id))
-						ret, err = json.Marshal(oids)
-						assert.FatalError(t, err)
-					case 1:
+						return oidsb, nil
+					default:
 						assert.Equals(t, bucket, orderTable)
-						assert.Equals(t, key, []byte(oids[0]))
 						return nil, errors.New("force")
 					}
-					count++
-					return ret, nil
 				},
 			}, "ca.smallstep.com", "acme", nil)
 			assert.FatalError(t, err)
 			return test{
 				auth: auth,
 				id:   id,
-				err:  ServerInternalErr(errors.New("error loading order foo: force")),
+				err:  ServerInternalErr(errors.New("error loading order batch: error getting acme_orders/foo: force")),
 			}
 		},
 		"ok": func(t *testing.T) test {
-			var (
-				id    = "zap"
-				count = 0
-				err   error
-			)
+			id := "zap"
 			foo, err := newO()
+			assert.FatalError(t, err)
 			bar, err := newO()
+			assert.FatalError(t, err)
 			baz, err := newO()
+			assert.FatalError(t, err)
 			bar.Status = StatusInvalid
 
+			index := map[string][]byte{id: orderIndexHead{next: 3}.bytes()}
+			values := map[string][]byte{}
+			for i, o := range []*order{foo, bar, baz} {
+				index[string(orderIndexKey(id, uint64(i)))] = []byte(o.ID)
+				values[o.ID], err = json.Marshal(o)
+				assert.FatalError(t, err)
+			}
+
 			auth, err := NewAuthority(&db.MockNoSQLDB{
 				MGet: func(bucket, key []byte) ([]byte, error) {
-					var ret []byte
-					switch count {
-					case 0:
-						assert.Equals(t, bucket, ordersByAccountIDTable)
-						assert.Equals(t, key, []byte(id))
-						ret, err = json.Marshal([]string{foo.ID, bar.ID, baz.ID})
-						assert.FatalError(t, err)
-					case 1:
-						assert.Equals(t, bucket, orderTable)
-						assert.Equals(t, key, []byte(foo.ID))
-						ret, err = json.Marshal(foo)
-						assert.FatalError(t, err)
-					case 2:
-						assert.Equals(t, bucket, orderTable)
-						assert.Equals(t, key, []byte(bar.ID))
-						ret, err = json.Marshal(bar)
-						assert.FatalError(t, err)
-					case 3:
+					switch string(bucket) {
+					case string(accountOrdersTable):
+						return index[string(key)], nil
+					case string(ordersByAccountIDTable):
+						return nil, database.ErrNotFound
+					default:
 						assert.Equals(t, bucket, orderTable)
-						assert.Equals(t, key, []byte(baz.ID))
-						ret, err = json.Marshal(baz)
-						assert.FatalError(t, err)
+						return values[string(key)], nil
 					}
-					count++
-					return ret, nil
 				},
 			}, "ca.smallstep.com", "acme", nil)
 			assert.FatalError(t, err)
@@ -1060,7 +1250,7 @@ func TestAuthorityGetOrdersByAccount(t *testing.T) {
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := run(t)
-			if orderLinks, err := tc.auth.GetOrdersByAccount(prov, tc.id); err != nil {
+			if orderLinks, err := tc.auth.GetOrdersByAccount(context.Background(), prov, tc.id); err != nil {
 				if assert.NotNil(t, tc.err) {
 					ae, ok := err.(*Error)
 					assert.True(t, ok)
@@ -1174,7 +1364,7 @@ func TestAuthorityFinalizeOrder(t *testing.T) {
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := run(t)
-			if acmeO, err := tc.auth.FinalizeOrder(prov, tc.accID, tc.id, nil); err != nil {
+			if acmeO, err := tc.auth.FinalizeOrder(context.Background(), prov, tc.accID, tc.id, nil); err != nil {
 				if assert.NotNil(t, tc.err) {
 					ae, ok := err.(*Error)
 					assert.True(t, ok)
@@ -1187,7 +1377,7 @@ func TestAuthorityFinalizeOrder(t *testing.T) {
 					gotb, err := json.Marshal(acmeO)
 					assert.FatalError(t, err)
 
-					acmeExp, err := tc.o.toACME(nil, tc.auth.dir, prov)
+					acmeExp, err := tc.o.toACME(context.Background(), nil, tc.auth.dir, prov)
 					assert.FatalError(t, err)
 					expb, err := json.Marshal(acmeExp)
 					assert.FatalError(t, err)
@@ -1297,7 +1487,7 @@ func TestAuthorityValidateChallenge(t *testing.T) {
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := run(t)
-			if acmeCh, err := tc.auth.ValidateChallenge(prov, tc.accID, tc.id, nil); err != nil {
+			if acmeCh, err := tc.auth.ValidateChallenge(context.Background(), prov, tc.accID, tc.id, nil, nil); err != nil {
 				if assert.NotNil(t, tc.err) {
 					ae, ok := err.(*Error)
 					assert.True(t, ok)
@@ -1310,7 +1500,7 @@ func TestAuthorityValidateChallenge(t *testing.T) {
 					gotb, err := json.Marshal(acmeCh)
 					assert.FatalError(t, err)
 
-					acmeExp, err := tc.ch.toACME(nil, tc.auth.dir, prov)
+					acmeExp, err := tc.ch.toACME(context.Background(), nil, tc.auth.dir, prov)
 					assert.FatalError(t, err)
 					expb, err := json.Marshal(acmeExp)
 					assert.FatalError(t, err)
@@ -1322,6 +1512,288 @@ func TestAuthorityValidateChallenge(t *testing.T) {
 	}
 }
 
+func TestAuthorityAttemptValidation(t *testing.T) {
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	prov := newProv()
+
+	type test struct {
+		auth      *Authority
+		ch        challenge
+		lookupTxt lookupTxt
+		retry     bool
+		status    string
+		count     int
+	}
+	newTest := func(t *testing.T, status string, config *Config) (*Authority, challenge, func() challenge) {
+		ch, err := newDNSCh()
+		assert.FatalError(t, err)
+		_ch, ok := ch.(*dns01Challenge)
+		assert.Fatal(t, ok)
+		_ch.baseChallenge.Status = status
+		b, err := json.Marshal(ch)
+		assert.FatalError(t, err)
+		auth, err := NewAuthority(&db.MockNoSQLDB{
+			MGet: func(bucket, key []byte) ([]byte, error) {
+				assert.Equals(t, bucket, challengeTable)
+				assert.Equals(t, key, []byte(ch.getID()))
+				return b, nil
+			},
+			MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				assert.Equals(t, bucket, challengeTable)
+				assert.Equals(t, old, b)
+				b = newval
+				return nil, true, nil
+			},
+		}, "ca.smallstep.com", "acme", nil, WithConfig(config))
+		assert.FatalError(t, err)
+		return auth, ch, func() challenge {
+			ch, err := unmarshalChallenge(b)
+			assert.FatalError(t, err)
+			return ch
+		}
+	}
+
+	t.Run("ok/retry", func(t *testing.T) {
+		auth, ch, load := newTest(t, StatusProcessing, nil)
+		auth.lookupTxt = func(string) ([]string, error) {
+			return nil, errors.New("force")
+		}
+		retry, err := auth.attemptValidation(prov, ch.getID(), jwk, time.Minute, nil)
+		assert.FatalError(t, err)
+		assert.True(t, retry)
+		upd := load()
+		assert.Equals(t, StatusProcessing, upd.getStatus())
+		assert.Equals(t, 1, upd.getRetry().Count)
+		assert.Len(t, 1, upd.getRetry().Errors)
+		assert.Equals(t, upd.getError(), upd.getRetry().Errors[0])
+		assert.False(t, upd.getRetry().NextAttempt.IsZero())
+
+		acmeCh, err := upd.toACME(context.Background(), nil, auth.dir, newProv())
+		assert.FatalError(t, err)
+		assert.NotEquals(t, "", acmeCh.RetryAfter)
+	})
+
+	t.Run("ok/max-attempts", func(t *testing.T) {
+		auth, ch, load := newTest(t, StatusProcessing, &Config{Retry: &RetryConfig{MaxAttempts: 1}})
+		auth.lookupTxt = func(string) ([]string, error) {
+			return []string{"foo"}, nil
+		}
+		retry, err := auth.attemptValidation(prov, ch.getID(), jwk, time.Minute, nil)
+		assert.FatalError(t, err)
+		assert.False(t, retry)
+		upd := load()
+		assert.Equals(t, StatusInvalid, upd.getStatus())
+		assert.Equals(t, 1, upd.getRetry().Count)
+		assert.True(t, upd.getRetry().NextAttempt.IsZero())
+
+		// The failed validation is counted by the rate limiter.
+		key := failedValidationsKey(prov, ch.getValue())
+		_, err = auth.limiter.reserve(key, 1, failedValidationsPerIdentifierWindow)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ok/valid", func(t *testing.T) {
+		auth, ch, load := newTest(t, StatusProcessing, nil)
+		keyAuth, err := KeyAuthorization(ch.getToken(), jwk)
+		assert.FatalError(t, err)
+		h := sha256.Sum256([]byte(keyAuth))
+		auth.lookupTxt = func(string) ([]string, error) {
+			return []string{base64.RawURLEncoding.EncodeToString(h[:])}, nil
+		}
+		retry, err := auth.attemptValidation(prov, ch.getID(), jwk, time.Minute, nil)
+		assert.FatalError(t, err)
+		assert.False(t, retry)
+		assert.Equals(t, StatusValid, load().getStatus())
+	})
+
+	t.Run("ok/not-processing", func(t *testing.T) {
+		auth, ch, load := newTest(t, StatusValid, nil)
+		auth.lookupTxt = func(string) ([]string, error) {
+			t.Fatal("lookupTxt should not be called")
+			return nil, nil
+		}
+		retry, err := auth.attemptValidation(prov, ch.getID(), jwk, time.Minute, nil)
+		assert.FatalError(t, err)
+		assert.False(t, retry)
+		assert.Equals(t, StatusValid, load().getStatus())
+	})
+}
+
+func TestAuthorityResumeValidations(t *testing.T) {
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	prov := newProv()
+
+	newCh := func(status string, retry *Retry) (challenge, []byte) {
+		ch, err := newDNSCh()
+		assert.FatalError(t, err)
+		_ch := ch.(*dns01Challenge)
+		_ch.baseChallenge.Status = status
+		_ch.baseChallenge.AccountID = "acc-id"
+		_ch.baseChallenge.Retry = retry
+		b, err := json.Marshal(ch)
+		assert.FatalError(t, err)
+		return ch, b
+	}
+	resumed, resumedBytes := newCh(StatusProcessing, &Retry{
+		Count:         2,
+		NextAttempt:   clock.Now().Add(time.Hour),
+		ProvisionerID: prov.GetID(),
+	})
+	orphan, orphanBytes := newCh(StatusProcessing, nil)
+	unknown, unknownBytes := newCh(StatusProcessing, &Retry{ProvisionerID: "unknown"})
+	valid, validBytes := newCh(StatusValid, nil)
+	acc, err := json.Marshal(&account{ID: "acc-id", Key: jwk, Status: StatusValid})
+	assert.FatalError(t, err)
+
+	tables := map[string]map[string][]byte{
+		string(challengeTable): {
+			resumed.getID(): resumedBytes,
+			orphan.getID():  orphanBytes,
+			unknown.getID(): unknownBytes,
+			valid.getID():   validBytes,
+			"bad":           []byte("{"),
+		},
+		string(accountTable): {"acc-id": acc},
+	}
+	auth, err := NewAuthority(newCleanupDB(tables), "ca.smallstep.com", "acme", &mockSignAuth{
+		loadProvisionerByID: func(id string) (provisioner.Interface, error) {
+			if id == prov.GetID() {
+				return prov, nil
+			}
+			return nil, errors.New("not found")
+		},
+	})
+	assert.FatalError(t, err)
+
+	load := func(id string) challenge {
+		ch, err := unmarshalChallenge(tables[string(challengeTable)][id])
+		assert.FatalError(t, err)
+		return ch
+	}
+
+	auth.resumeValidations()
+
+	// The challenge with a provisioner waits for its next attempt.
+	assert.False(t, auth.workers.claim(resumed.getID()))
+	assert.Equals(t, StatusProcessing, load(resumed.getID()).getStatus())
+
+	// The challenges that cannot be resumed are invalid.
+	for _, id := range []string{orphan.getID(), unknown.getID()} {
+		ch := load(id)
+		assert.Equals(t, StatusInvalid, ch.getStatus())
+		assert.NotNil(t, ch.getError())
+	}
+	assert.Equals(t, StatusValid, load(valid.getID()).getStatus())
+
+	// The pending attempt is abandoned on shutdown and the challenge is
+	// resumed by the next run.
+	assert.FatalError(t, auth.Shutdown(context.Background()))
+	assert.Equals(t, StatusProcessing, load(resumed.getID()).getStatus())
+	assert.Equals(t, 2, load(resumed.getID()).getRetry().Count)
+}
+
+func TestAuthorityShutdown(t *testing.T) {
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	prov := newProv()
+
+	ch, err := newDNSCh()
+	assert.FatalError(t, err)
+	_ch := ch.(*dns01Challenge)
+	_ch.baseChallenge.AccountID = "acc-id"
+	b, err := json.Marshal(ch)
+	assert.FatalError(t, err)
+	acc, err := json.Marshal(&account{ID: "acc-id", Key: jwk, Status: StatusValid})
+	assert.FatalError(t, err)
+	tables := map[string]map[string][]byte{
+		string(challengeTable): {ch.getID(): b},
+		string(accountTable):   {"acc-id": acc},
+	}
+	load := func() challenge {
+		ch, err := unmarshalChallenge(tables[string(challengeTable)][ch.getID()])
+		assert.FatalError(t, err)
+		return ch
+	}
+	newAuth := func() *Authority {
+		auth, err := NewAuthority(newCleanupDB(tables), "ca.smallstep.com", "acme", &mockSignAuth{
+			loadProvisionerByID: func(id string) (provisioner.Interface, error) {
+				assert.Equals(t, prov.GetID(), id)
+				return prov, nil
+			},
+		}, WithConfig(&Config{Retry: &RetryConfig{Backoff: &provisioner.Duration{Duration: time.Hour}}}))
+		assert.FatalError(t, err)
+		return auth
+	}
+
+	// The first attempt fails and the next one is not performed on shutdown.
+	auth := newAuth()
+	auth.lookupTxt = func(string) ([]string, error) {
+		return nil, errors.New("force")
+	}
+	_, err = auth.ValidateChallenge(context.Background(), prov, "acc-id", ch.getID(), jwk, nil)
+	assert.FatalError(t, err)
+	assert.FatalError(t, auth.Shutdown(context.Background()))
+	upd := load()
+	assert.Equals(t, StatusProcessing, upd.getStatus())
+	assert.Equals(t, 1, upd.getRetry().Count)
+	assert.Equals(t, prov.GetID(), upd.getRetry().ProvisionerID)
+
+	// The validation is resumed by the next authority.
+	auth = newAuth()
+	auth.resumeValidations()
+	assert.False(t, auth.workers.claim(ch.getID()))
+	assert.FatalError(t, auth.Shutdown(context.Background()))
+	assert.Equals(t, StatusProcessing, load().getStatus())
+}
+
+func TestAuthorityFailValidation(t *testing.T) {
+	prov := newProv()
+	newTest := func(status string, retry *Retry) (*Authority, challenge, func() challenge) {
+		ch, err := newDNSCh()
+		assert.FatalError(t, err)
+		_ch := ch.(*dns01Challenge)
+		_ch.baseChallenge.Status = status
+		_ch.baseChallenge.Retry = retry
+		b, err := json.Marshal(ch)
+		assert.FatalError(t, err)
+		tables := map[string]map[string][]byte{
+			string(challengeTable): {ch.getID(): b},
+		}
+		auth, err := NewAuthority(newCleanupDB(tables), "ca.smallstep.com", "acme", nil)
+		assert.FatalError(t, err)
+		return auth, ch, func() challenge {
+			ch, err := unmarshalChallenge(tables[string(challengeTable)][ch.getID()])
+			assert.FatalError(t, err)
+			return ch
+		}
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		auth, ch, load := newTest(StatusProcessing, &Retry{Count: 1, NextAttempt: clock.Now().Add(-time.Minute)})
+		auth.failValidation(prov, ch.getID(), errors.New("force"))
+		upd := load()
+		assert.Equals(t, StatusInvalid, upd.getStatus())
+		assert.NotNil(t, upd.getError())
+		assert.True(t, upd.getRetry().NextAttempt.IsZero())
+	})
+
+	t.Run("ok/scheduled", func(t *testing.T) {
+		auth, ch, load := newTest(StatusProcessing, &Retry{Count: 1, NextAttempt: clock.Now().Add(time.Minute)})
+		auth.failValidation(prov, ch.getID(), errors.New("force"))
+		assert.Equals(t, StatusProcessing, load().getStatus())
+	})
+
+	t.Run("ok/not-processing", func(t *testing.T) {
+		auth, ch, load := newTest(StatusValid, nil)
+		auth.failValidation(prov, ch.getID(), errors.New("force"))
+		upd := load()
+		assert.Equals(t, StatusValid, upd.getStatus())
+		assert.Nil(t, upd.getError())
+	})
+}
+
 func TestAuthorityUpdateAccount(t *testing.T) {
 	contact := []string{"baz", "zap"}
 	prov := newProv()
@@ -1404,7 +1876,7 @@ func TestAuthorityUpdateAccount(t *testing.T) {
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := run(t)
-			if acmeAcc, err := tc.auth.UpdateAccount(prov, tc.id, tc.contact); err != nil {
+			if acmeAcc, err := tc.auth.UpdateAccount(context.Background(), prov, tc.id, tc.contact); err != nil {
 				if assert.NotNil(t, tc.err) {
 					ae, ok := err.(*Error)
 					assert.True(t, ok)
@@ -1417,7 +1889,7 @@ func TestAuthorityUpdateAccount(t *testing.T) {
 					gotb, err := json.Marshal(acmeAcc)
 					assert.FatalError(t, err)
 
-					acmeExp, err := tc.acc.toACME(nil, tc.auth.dir, prov)
+					acmeExp, err := tc.acc.toACME(context.Background(), nil, tc.auth.dir, prov)
 					assert.FatalError(t, err)
 					expb, err := json.Marshal(acmeExp)
 					assert.FatalError(t, err)
@@ -1431,8 +1903,11 @@ func TestAuthorityUpdateAccount(t *testing.T) {
 
 func TestAuthorityDeactivateAccount(t *testing.T) {
 	prov := newProv()
+	revokeProv := newProv()
+	revokeProv.(*provisioner.ACME).RevokeOnDeactivation = true
 	type test struct {
 		auth *Authority
+		prov provisioner.Interface
 		id   string
 		acc  *account
 		err  *Error
@@ -1476,6 +1951,73 @@ func TestAuthorityDeactivateAccount(t *testing.T) {
 			}
 		},
 
+		"fail/revoke-error": func(t *testing.T) test {
+			acc, err := newAcc()
+			assert.FatalError(t, err)
+			b, err := json.Marshal(acc)
+			assert.FatalError(t, err)
+
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					if string(bucket) == string(accountCertsTable) {
+						return nil, errors.New("force")
+					}
+					return b, nil
+				},
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					t.Fatal("the account must not be deactivated")
+					return nil, false, nil
+				},
+			}, "ca.smallstep.com", "acme", &mockRevokeAuthority{})
+			assert.FatalError(t, err)
+			return test{
+				auth: auth,
+				prov: revokeProv,
+				id:   acc.ID,
+				err:  ServerInternalErr(errors.Errorf("error loading certificate index for account %s: force", acc.ID)),
+			}
+		},
+		"ok/revoke": func(t *testing.T) test {
+			acc, err := newAcc()
+			assert.FatalError(t, err)
+			b, err := json.Marshal(acc)
+			assert.FatalError(t, err)
+			// The certificate must not be expired.
+			leaf, err := pemutil.ReadCertificate("../authority/testdata/certs/intermediate_ca.crt")
+			assert.FatalError(t, err)
+			tables := map[string]map[string][]byte{
+				string(accountTable):      {acc.ID: b},
+				string(certTable):         {},
+				string(accountCertsTable): {},
+			}
+			_, err = newCert(newCleanupDB(tables), CertOptions{AccountID: acc.ID, OrderID: "ordID", Leaf: leaf})
+			assert.FatalError(t, err)
+
+			_acc := *acc
+			clone := &_acc
+			clone.Status = StatusDeactivated
+			clone.Deactivated = clock.Now()
+			var revoked bool
+			mockdb := newCleanupDB(tables)
+			mockdb.MCmpAndSwap = func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				assert.True(t, revoked)
+				return nil, true, nil
+			}
+			auth, err := NewAuthority(mockdb, "ca.smallstep.com", "acme", &mockRevokeAuthority{
+				revoke: func(crt *x509.Certificate, reasonCode int, reason string) error {
+					assert.Equals(t, leaf, crt)
+					revoked = true
+					return nil
+				},
+			})
+			assert.FatalError(t, err)
+			return test{
+				auth: auth,
+				prov: revokeProv,
+				id:   acc.ID,
+				acc:  clone,
+			}
+		},
 		"ok": func(t *testing.T) test {
 			acc, err := newAcc()
 			assert.FatalError(t, err)
@@ -1507,7 +2049,10 @@ func TestAuthorityDeactivateAccount(t *testing.T) {
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := run(t)
-			if acmeAcc, err := tc.auth.DeactivateAccount(prov, tc.id); err != nil {
+			if tc.prov == nil {
+				tc.prov = prov
+			}
+			if acmeAcc, err := tc.auth.DeactivateAccount(context.Background(), tc.prov, tc.id); err != nil {
 				if assert.NotNil(t, tc.err) {
 					ae, ok := err.(*Error)
 					assert.True(t, ok)
@@ -1520,7 +2065,7 @@ func TestAuthorityDeactivateAccount(t *testing.T) {
 					gotb, err := json.Marshal(acmeAcc)
 					assert.FatalError(t, err)
 
-					acmeExp, err := tc.acc.toACME(nil, tc.auth.dir, prov)
+					acmeExp, err := tc.acc.toACME(context.Background(), nil, tc.auth.dir, tc.prov)
 					assert.FatalError(t, err)
 					expb, err := json.Marshal(acmeExp)
 					assert.FatalError(t, err)
@@ -1531,3 +2076,83 @@ func TestAuthorityDeactivateAccount(t *testing.T) {
 		})
 	}
 }
+
+type auditSink struct {
+	events []*audit.Event
+}
+
+func (s *auditSink) Write(e *audit.Event) error {
+	c := *e
+	s.events = append(s.events, &c)
+	return nil
+}
+
+func (s *auditSink) Close() error {
+	return nil
+}
+
+func TestAuthorityAuditAccount(t *testing.T) {
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	prov := newProv()
+	sink := new(auditSink)
+	auth, err := NewAuthority(&db.MockNoSQLDB{
+		MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+			return nil, true, nil
+		},
+	}, "ca.smallstep.com", "acme", nil, WithAuditLogger(audit.New(nil, sink)))
+	assert.FatalError(t, err)
+
+	ctx := audit.NewContextWithClientIP(context.Background(), "10.0.0.1")
+	acc, err := auth.NewAccount(ctx, prov, AccountOptions{
+		Key: jwk, Contact: []string{"mailto:foo@example.com", "mailto:bar@example.com"},
+	})
+	assert.FatalError(t, err)
+	auth.auditAccount(context.Background(), audit.AccountDeactivated, nil, &account{ID: acc.ID, Status: StatusDeactivated})
+
+	if assert.Equals(t, 2, len(sink.events)) {
+		e := sink.events[0]
+		assert.Equals(t, audit.AccountCreated, e.Type)
+		assert.Equals(t, acc.ID, e.Actor)
+		assert.Equals(t, "10.0.0.1", e.ClientIP)
+		assert.Equals(t, acc.ID, e.Subject)
+		assert.Equals(t, prov.GetName(), e.Provisioner)
+		assert.Equals(t, map[string]string{
+			"status":  StatusValid,
+			"contact": "mailto:foo@example.com,mailto:bar@example.com",
+		}, e.Details)
+
+		e = sink.events[1]
+		assert.Equals(t, audit.AccountDeactivated, e.Type)
+		assert.Equals(t, "", e.Provisioner)
+		assert.Equals(t, "", e.ClientIP)
+		assert.Equals(t, map[string]string{"status": StatusDeactivated}, e.Details)
+	}
+}
+
+func TestAuthorityWithPrevious(t *testing.T) {
+	memory := &Config{Nonce: &NonceConfig{Store: NonceStoreMemory}}
+	prev, err := NewAuthority(new(db.MockNoSQLDB), "ca.smallstep.com", "acme", nil, WithConfig(memory))
+	assert.FatalError(t, err)
+	nonce, err := prev.NewNonce()
+	assert.FatalError(t, err)
+
+	// The nonces of the previous authority are valid with the same nonce
+	// configuration.
+	auth, err := NewAuthority(new(db.MockNoSQLDB), "ca.smallstep.com", "acme", nil,
+		WithPrevious(prev), WithConfig(&Config{Nonce: &NonceConfig{Store: NonceStoreMemory}}))
+	assert.FatalError(t, err)
+	assert.Nil(t, auth.previous)
+	assert.True(t, auth.limiter == prev.limiter)
+	assert.FatalError(t, auth.UseNonce(nonce))
+
+	// A new nonce store is created if the configuration changes.
+	nonce, err = auth.NewNonce()
+	assert.FatalError(t, err)
+	auth2, err := NewAuthority(new(db.MockNoSQLDB), "ca.smallstep.com", "acme", nil,
+		WithPrevious(auth), WithConfig(&Config{Nonce: &NonceConfig{Store: NonceStoreMemory, MaxNonces: 10}}))
+	assert.FatalError(t, err)
+	assert.True(t, auth2.limiter == prev.limiter)
+	assert.NotNil(t, auth2.UseNonce(nonce))
+	assert.FatalError(t, auth.UseNonce(nonce))
+}
@@ -1,6 +1,7 @@
 package acme
 
 import (
+	"context"
 	"encoding/json"
 	"strings"
 	"time"
@@ -51,7 +52,7 @@ type authz interface {
 	getChallenges() []string
 	getCreated() time.Time
 	updateStatus(db nosql.DB) (authz, error)
-	toACME(nosql.DB, *directory, provisioner.Interface) (*Authz, error)
+	toACME(context.Context, nosql.DB, *directory, provisioner.Interface) (*Authz, error)
 }
 
 // baseAuthz is the base authz type that others build from.
@@ -141,14 +142,14 @@ func (ba *baseAuthz) getCreated() time.Time {
 
 // toACME converts the internal Authz type into the public acmeAuthz type for
 // presentation in the ACME protocol.
-func (ba *baseAuthz) toACME(db nosql.DB, dir *directory, p provisioner.Interface) (*Authz, error) {
+func (ba *baseAuthz) toACME(ctx context.Context, db nosql.DB, dir *directory, p provisioner.Interface) (*Authz, error) {
 	var chs = make([]*Challenge, len(ba.Challenges))
 	for i, chID := range ba.Challenges {
 		ch, err := getChallenge(db, chID)
 		if err != nil {
 			return nil, err
 		}
-		chs[i], err = ch.toACME(db, dir, p)
+		chs[i], err = ch.toACME(ctx, db, dir, p)
 		if err != nil {
 			return nil, err
 		}
@@ -196,7 +197,14 @@ func (ba *baseAuthz) clone() *baseAuthz {
 }
 
 func (ba *baseAuthz) parent() authz {
-	return &dnsAuthz{ba}
+	switch ba.Identifier.Type {
+	case "permanent-identifier":
+		return &deviceAuthz{ba}
+	case "TNAuthList":
+		return &tnAuthListAuthz{ba}
+	default:
+		return &dnsAuthz{ba}
+	}
 }
 
 // updateStatus attempts to update the status on a baseAuthz and stores the
@@ -261,6 +269,18 @@ func unmarshalAuthz(data []byte) (authz, error) {
 			return nil, ServerInternalErr(errors.Wrap(err, "error unmarshaling authz type into dnsAuthz"))
 		}
 		return &dnsAuthz{&ba}, nil
+	case "permanent-identifier":
+		var ba baseAuthz
+		if err := json.Unmarshal(data, &ba); err != nil {
+			return nil, ServerInternalErr(errors.Wrap(err, "error unmarshaling authz type into deviceAuthz"))
+		}
+		return &deviceAuthz{&ba}, nil
+	case "TNAuthList":
+		var ba baseAuthz
+		if err := json.Unmarshal(data, &ba); err != nil {
+			return nil, ServerInternalErr(errors.Wrap(err, "error unmarshaling authz type into tnAuthListAuthz"))
+		}
+		return &tnAuthListAuthz{&ba}, nil
 	default:
 		return nil, ServerInternalErr(errors.Errorf("unexpected authz type %s",
 			getType.Identifier.Type))
@@ -273,11 +293,16 @@ type dnsAuthz struct {
 }
 
 // newAuthz returns a new acme authorization object based on the identifier
-// type.
-func newAuthz(db nosql.DB, accID string, identifier Identifier) (a authz, err error) {
+// type. The challengeTypes limit the challenges of dns identifiers, all of
+// them are created if it's empty.
+func newAuthz(db nosql.DB, accID string, identifier Identifier, challengeTypes []string) (a authz, err error) {
 	switch identifier.Type {
 	case "dns":
-		a, err = newDNSAuthz(db, accID, identifier)
+		a, err = newDNSAuthz(db, accID, identifier, challengeTypes)
+	case "permanent-identifier":
+		a, err = newDeviceAuthz(db, accID, identifier)
+	case "TNAuthList":
+		a, err = newTNAuthListAuthz(db, accID, identifier)
 	default:
 		err = MalformedErr(errors.Errorf("unexpected authz type %s",
 			identifier.Type))
@@ -286,7 +311,7 @@ func newAuthz(db nosql.DB, accID string, identifier Identifier) (a authz, err er
 }
 
 // newDNSAuthz returns a new dns acme authorization object.
-func newDNSAuthz(db nosql.DB, accID string, identifier Identifier) (authz, error) {
+func newDNSAuthz(db nosql.DB, accID string, identifier Identifier, challengeTypes []string) (authz, error) {
 	ba, err := newBaseAuthz(accID, identifier)
 	if err != nil {
 		return nil, err
@@ -295,35 +320,90 @@ func newDNSAuthz(db nosql.DB, accID string, identifier Identifier) (authz, error
 	ba.Challenges = []string{}
 	if !ba.Wildcard {
 		// http and alpn challenges are only permitted if the DNS is not a wildcard dns.
-		ch1, err := newHTTP01Challenge(db, ChallengeOptions{
-			AccountID:  accID,
-			AuthzID:    ba.ID,
-			Identifier: ba.Identifier})
-		if err != nil {
-			return nil, Wrap(err, "error creating http challenge")
+		if isChallengeEnabled(challengeTypes, "http-01") {
+			ch1, err := newHTTP01Challenge(db, ChallengeOptions{
+				AccountID:  accID,
+				AuthzID:    ba.ID,
+				Identifier: ba.Identifier})
+			if err != nil {
+				return nil, Wrap(err, "error creating http challenge")
+			}
+			ba.Challenges = append(ba.Challenges, ch1.getID())
 		}
-		ba.Challenges = append(ba.Challenges, ch1.getID())
 
-		ch2, err := newTLSALPN01Challenge(db, ChallengeOptions{
+		if isChallengeEnabled(challengeTypes, "tls-alpn-01") {
+			ch2, err := newTLSALPN01Challenge(db, ChallengeOptions{
+				AccountID:  accID,
+				AuthzID:    ba.ID,
+				Identifier: ba.Identifier,
+			})
+			if err != nil {
+				return nil, Wrap(err, "error creating alpn challenge")
+			}
+			ba.Challenges = append(ba.Challenges, ch2.getID())
+		}
+	}
+	if isChallengeEnabled(challengeTypes, "dns-01") {
+		ch3, err := newDNS01Challenge(db, ChallengeOptions{
 			AccountID:  accID,
 			AuthzID:    ba.ID,
-			Identifier: ba.Identifier,
-		})
+			Identifier: identifier})
 		if err != nil {
-			return nil, Wrap(err, "error creating alpn challenge")
+			return nil, Wrap(err, "error creating dns challenge")
 		}
-		ba.Challenges = append(ba.Challenges, ch2.getID())
+		ba.Challenges = append(ba.Challenges, ch3.getID())
+	}
+	if len(ba.Challenges) == 0 {
+		return nil, RejectedIdentifierErr(errors.Errorf("no challenge types are enabled for identifier %s",
+			identifier.Value))
+	}
+
+	da := &dnsAuthz{ba}
+	if err := da.save(db, nil); err != nil {
+		return nil, err
+	}
+
+	return da, nil
+}
+
+// isChallengeEnabled returns true if the given challenge type is in the list
+// of enabled types, an empty list enables all of them.
+func isChallengeEnabled(challengeTypes []string, typ string) bool {
+	if len(challengeTypes) == 0 {
+		return true
+	}
+	for _, ct := range challengeTypes {
+		if ct == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// deviceAuthz represents a permanent-identifier acme authorization.
+type deviceAuthz struct {
+	*baseAuthz
+}
+
+// newDeviceAuthz returns a new permanent-identifier acme authorization object.
+// The device identity can only be validated with a device-attest-01
+// challenge.
+func newDeviceAuthz(db nosql.DB, accID string, identifier Identifier) (authz, error) {
+	ba, err := newBaseAuthz(accID, identifier)
+	if err != nil {
+		return nil, err
 	}
-	ch3, err := newDNS01Challenge(db, ChallengeOptions{
+
+	ch, err := newDeviceAttest01Challenge(db, ChallengeOptions{
 		AccountID:  accID,
 		AuthzID:    ba.ID,
 		Identifier: identifier})
 	if err != nil {
-		return nil, Wrap(err, "error creating dns challenge")
+		return nil, Wrap(err, "error creating device-attest challenge")
 	}
-	ba.Challenges = append(ba.Challenges, ch3.getID())
+	ba.Challenges = []string{ch.getID()}
 
-	da := &dnsAuthz{ba}
+	da := &deviceAuthz{ba}
 	if err := da.save(db, nil); err != nil {
 		return nil, err
 	}
@@ -331,6 +411,54 @@ func newDNSAuthz(db nosql.DB, accID string, identifier Identifier) (authz, error
 	return da, nil
 }
 
+// tnAuthListAuthz represents a TNAuthList acme authorization.
+type tnAuthListAuthz struct {
+	*baseAuthz
+}
+
+// newTNAuthListAuthz returns a new TNAuthList acme authorization object.
+func newTNAuthListAuthz(db nosql.DB, accID string, identifier Identifier) (authz, error) {
+	ba, err := newBaseAuthz(accID, identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := newTKAuth01Challenge(db, ChallengeOptions{
+		AccountID:  accID,
+		AuthzID:    ba.ID,
+		Identifier: identifier})
+	if err != nil {
+		return nil, Wrap(err, "error creating tkauth challenge")
+	}
+	ba.Challenges = []string{ch.getID()}
+
+	ta := &tnAuthListAuthz{ba}
+	if err := ta.save(db, nil); err != nil {
+		return nil, err
+	}
+
+	return ta, nil
+}
+
+// getAuthzs retrieves and unmarshals the ACME authzs with the given ids using
+// a single batched read.
+func getAuthzs(db nosql.DB, ids []string) ([]authz, error) {
+	values, err := batchGet(db, authzTable, "authz", ids)
+	if err != nil {
+		return nil, ServerInternalErr(err)
+	}
+	azs := make([]authz, len(ids))
+	for i, b := range values {
+		if b == nil {
+			return nil, MalformedErr(errors.Errorf("authz %s not found", ids[i]))
+		}
+		if azs[i], err = unmarshalAuthz(b); err != nil {
+			return nil, err
+		}
+	}
+	return azs, nil
+}
+
 // getAuthz retrieves and unmarshals an ACME authz type from the database.
 func getAuthz(db nosql.DB, id string) (authz, error) {
 	b, err := db.Get(authzTable, []byte(id))
@@ -1,6 +1,7 @@
 package acme
 
 import (
+	"context"
 	"encoding/json"
 	"strings"
 	"testing"
@@ -21,7 +22,7 @@ func newAz() (authz, error) {
 	}
 	return newAuthz(mockdb, "1234", Identifier{
 		Type: "dns", Value: "acme.example.com",
-	})
+	}, nil)
 }
 
 func TestGetAuthz(t *testing.T) {
@@ -150,10 +151,11 @@ func TestNewAuthz(t *testing.T) {
 	}
 	accID := "1234"
 	type test struct {
-		iden   Identifier
-		db     nosql.DB
-		err    *Error
-		resChs *([]string)
+		iden           Identifier
+		challengeTypes []string
+		db             nosql.DB
+		err            *Error
+		resChs         *([]string)
 	}
 	tests := map[string]func(t *testing.T) test{
 		"fail/unexpected-type": func(t *testing.T) test {
@@ -205,6 +207,13 @@ func TestNewAuthz(t *testing.T) {
 				err: ServerInternalErr(errors.New("error creating dns challenge: error saving acme challenge: force")),
 			}
 		},
+		"fail/no-challenge-types": func(t *testing.T) test {
+			return test{
+				iden:           Identifier{Type: "dns", Value: "*.acme.example.com"},
+				challengeTypes: []string{"http-01", "tls-alpn-01"},
+				err:            RejectedIdentifierErr(errors.New("no challenge types are enabled for identifier *.acme.example.com")),
+			}
+		},
 		"fail/save-authz-error": func(t *testing.T) test {
 			count := 0
 			return test{
@@ -296,11 +305,105 @@ func TestNewAuthz(t *testing.T) {
 				resChs: chs,
 			}
 		},
+		"ok/dns-01-only": func(t *testing.T) test {
+			chs := &([]string{})
+			count := 0
+			return test{
+				iden:           iden,
+				challengeTypes: []string{"dns-01"},
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						switch count {
+						case 0:
+							assert.Equals(t, bucket, challengeTable)
+							ch, err := unmarshalChallenge(newval)
+							assert.FatalError(t, err)
+							assert.Equals(t, ch.getType(), "dns-01")
+						case 1:
+							assert.Equals(t, bucket, authzTable)
+							az, err := unmarshalAuthz(newval)
+							assert.FatalError(t, err)
+							*chs = az.getChallenges()
+							assert.Equals(t, len(*chs), 1)
+						default:
+							t.Fatal("unexpected db write")
+						}
+						count++
+						return nil, true, nil
+					},
+				},
+				resChs: chs,
+			}
+		},
+		"ok/permanent-identifier": func(t *testing.T) test {
+			chs := &([]string{})
+			count := 0
+			_iden := Identifier{Type: "permanent-identifier", Value: "12345678"}
+			return test{
+				iden: _iden,
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						switch count {
+						case 0:
+							assert.Equals(t, bucket, challengeTable)
+							ch, err := unmarshalChallenge(newval)
+							assert.FatalError(t, err)
+							assert.Equals(t, ch.getType(), "device-attest-01")
+							assert.Equals(t, ch.getValue(), "12345678")
+						case 1:
+							assert.Equals(t, bucket, authzTable)
+							az, err := unmarshalAuthz(newval)
+							assert.FatalError(t, err)
+							_, ok := az.(*deviceAuthz)
+							assert.True(t, ok)
+							assert.Equals(t, az.getIdentifier(), _iden)
+							*chs = az.getChallenges()
+							assert.True(t, len(*chs) == 1)
+						}
+						count++
+						return nil, true, nil
+					},
+				},
+				resChs: chs,
+			}
+		},
+		"ok/tnauthlist": func(t *testing.T) test {
+			chs := &([]string{})
+			count := 0
+			_iden := Identifier{Type: "TNAuthList", Value: testTNAuthList}
+			return test{
+				iden: _iden,
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						switch count {
+						case 0:
+							assert.Equals(t, bucket, challengeTable)
+							ch, err := unmarshalChallenge(newval)
+							assert.FatalError(t, err)
+							assert.Equals(t, ch.getType(), "tkauth-01")
+							assert.Equals(t, ch.getValue(), testTNAuthList)
+						case 1:
+							assert.Equals(t, bucket, authzTable)
+							az, err := unmarshalAuthz(newval)
+							assert.FatalError(t, err)
+							_, ok := az.(*tnAuthListAuthz)
+							assert.True(t, ok)
+							assert.Equals(t, az.getIdentifier(), _iden)
+							*chs = az.getChallenges()
+							assert.True(t, len(*chs) == 1)
+						}
+						count++
+						return nil, true, nil
+					},
+				},
+				resChs: chs,
+			}
+		},
 	}
 	for name, run := range tests {
 		tc := run(t)
 		t.Run(name, func(t *testing.T) {
-			az, err := newAuthz(tc.db, accID, tc.iden)
+			az, err := newAuthz(tc.db, accID, tc.iden, tc.challengeTypes)
 			if err != nil {
 				if assert.NotNil(t, tc.err) {
 					ae, ok := err.(*Error)
@@ -312,7 +415,7 @@ func TestNewAuthz(t *testing.T) {
 			} else {
 				if assert.Nil(t, tc.err) {
 					assert.Equals(t, az.getAccountID(), accID)
-					assert.Equals(t, az.getType(), "dns")
+					assert.Equals(t, az.getType(), tc.iden.Type)
 					assert.Equals(t, az.getStatus(), StatusPending)
 
 					assert.True(t, az.getCreated().Before(time.Now().UTC().Add(time.Minute)))
@@ -367,7 +470,7 @@ func TestAuthzToACME(t *testing.T) {
 	iden := Identifier{
 		Type: "dns", Value: "acme.example.com",
 	}
-	az, err := newAuthz(mockdb, "1234", iden)
+	az, err := newAuthz(mockdb, "1234", iden, nil)
 	assert.FatalError(t, err)
 	prov := newProv()
 
@@ -419,7 +522,7 @@ func TestAuthzToACME(t *testing.T) {
 	for name, run := range tests {
 		tc := run(t)
 		t.Run(name, func(t *testing.T) {
-			acmeAz, err := az.toACME(tc.db, dir, prov)
+			acmeAz, err := az.toACME(context.Background(), tc.db, dir, prov)
 			if err != nil {
 				if assert.NotNil(t, tc.err) {
 					ae, ok := err.(*Error)
@@ -434,9 +537,9 @@ func TestAuthzToACME(t *testing.T) {
 					assert.Equals(t, acmeAz.Identifier, iden)
 					assert.Equals(t, acmeAz.Status, StatusPending)
 
-					acmeCh1, err := ch1.toACME(nil, dir, prov)
+					acmeCh1, err := ch1.toACME(context.Background(), nil, dir, prov)
 					assert.FatalError(t, err)
-					acmeCh2, err := ch2.toACME(nil, dir, prov)
+					acmeCh2, err := ch2.toACME(context.Background(), nil, dir, prov)
 					assert.FatalError(t, err)
 
 					assert.Equals(t, acmeAz.Challenges[0], acmeCh1)
@@ -730,7 +833,7 @@ func TestAuthzUpdateStatus(t *testing.T) {
 			iden := Identifier{
 				Type: "dns", Value: "acme.example.com",
 			}
-			az, err := newAuthz(mockdb, "1234", iden)
+			az, err := newAuthz(mockdb, "1234", iden, nil)
 			assert.FatalError(t, err)
 			_az, ok := az.(*dnsAuthz)
 			assert.Fatal(t, ok)
@@ -786,7 +889,7 @@ func TestAuthzUpdateStatus(t *testing.T) {
 			iden := Identifier{
 				Type: "dns", Value: "acme.example.com",
 			}
-			az, err := newAuthz(mockdb, "1234", iden)
+			az, err := newAuthz(mockdb, "1234", iden, nil)
 			assert.FatalError(t, err)
 
 			count = 0
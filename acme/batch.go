@@ -0,0 +1,59 @@
+package acme
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	cadb "github.com/smallstep/certificates/db"
+	"github.com/smallstep/nosql"
+)
+
+// maxConcurrentReads is the maximum number of concurrent operations used to
+// load the authorizations of an order.
+const maxConcurrentReads = 8
+
+// batchGet returns the values of the given keys in the bucket, keys not found
+// have a nil value. The databases that implement db.BatchDB, like Badger and
+// etcd, read all the keys in a single operation, with the rest the keys are
+// read concurrently. The name of the type stored in the bucket is used in the
+// error messages.
+func batchGet(db nosql.DB, bucket []byte, name string, ids []string) ([][]byte, error) {
+	keys := make([][]byte, len(ids))
+	for i, id := range ids {
+		keys[i] = []byte(id)
+	}
+	values, err := cadb.BatchGet(db, bucket, keys)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading %s batch", name)
+	}
+	return values, nil
+}
+
+// forEach calls fn for every index in [0, n) using at most maxConcurrentReads
+// goroutines. It returns the error with the lowest index, if any.
+func forEach(n int, fn func(i int) error) error {
+	if n == 1 {
+		return fn(0)
+	}
+	errs := make([]error, n)
+	sem := make(chan struct{}, maxConcurrentReads)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			errs[i] = fn(i)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package acme
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/nosql"
+	"github.com/smallstep/nosql/database"
+)
+
+// mockBatchDB is a mock database that implements db.BatchDB.
+type mockBatchDB struct {
+	*db.MockNoSQLDB
+	batchGet func(bucket []byte, keys [][]byte) ([][]byte, error)
+}
+
+func (m *mockBatchDB) BatchGet(bucket []byte, keys [][]byte) ([][]byte, error) {
+	return m.batchGet(bucket, keys)
+}
+
+func TestForEach(t *testing.T) {
+	var running, maxRunning int32
+	done := make([]bool, 100)
+	err := forEach(len(done), func(i int) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		done[i] = true
+		return nil
+	})
+	assert.FatalError(t, err)
+	assert.True(t, atomic.LoadInt32(&maxRunning) <= maxConcurrentReads)
+	for _, ok := range done {
+		assert.True(t, ok)
+	}
+
+	err = forEach(10, func(i int) error {
+		if i%3 == 2 {
+			return errors.Errorf("error %d", i)
+		}
+		return nil
+	})
+	assert.Equals(t, "error 2", err.Error())
+	assert.Nil(t, forEach(0, nil))
+}
+
+func TestBatchGet(t *testing.T) {
+	type test struct {
+		db  nosql.DB
+		res [][]byte
+		err error
+	}
+	ids := []string{"foo", "bar", "baz"}
+	tests := map[string]func(t *testing.T) test{
+		"fail/get-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						if string(key) == "baz" {
+							return nil, errors.New("force")
+						}
+						return key, nil
+					},
+				},
+				err: errors.New("error loading authz batch: error getting acme_authzs/baz: force"),
+			}
+		},
+		"fail/batch-error": func(t *testing.T) test {
+			return test{
+				db: &mockBatchDB{
+					batchGet: func(bucket []byte, keys [][]byte) ([][]byte, error) {
+						return nil, errors.New("force")
+					},
+				},
+				err: errors.New("error loading authz batch: force"),
+			}
+		},
+		"fail/batch-length": func(t *testing.T) test {
+			return test{
+				db: &mockBatchDB{
+					batchGet: func(bucket []byte, keys [][]byte) ([][]byte, error) {
+						return keys[:1], nil
+					},
+				},
+				err: errors.New("error loading authz batch: got 1 values, expected 3"),
+			}
+		},
+		"ok/get": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, bucket, authzTable)
+						if string(key) == "bar" {
+							return nil, database.ErrNotFound
+						}
+						return key, nil
+					},
+				},
+				res: [][]byte{[]byte("foo"), nil, []byte("baz")},
+			}
+		},
+		"ok/batch": func(t *testing.T) test {
+			return test{
+				db: &mockBatchDB{
+					batchGet: func(bucket []byte, keys [][]byte) ([][]byte, error) {
+						assert.Equals(t, bucket, authzTable)
+						assert.Equals(t, [][]byte{[]byte("foo"), []byte("bar"), []byte("baz")}, keys)
+						return [][]byte{[]byte("foo"), nil, []byte("baz")}, nil
+					},
+				},
+				res: [][]byte{[]byte("foo"), nil, []byte("baz")},
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			// The authority and transaction databases use the BatchGet of the
+			// database they wrap.
+			for _, d := range []nosql.DB{tc.db, &metricsDB{tc.db}, newTxDB(tc.db)} {
+				res, err := batchGet(d, authzTable, "authz", ids)
+				if err != nil {
+					if assert.NotNil(t, tc.err) {
+						assert.Equals(t, tc.err.Error(), err.Error())
+					}
+				} else if assert.Nil(t, tc.err) {
+					assert.Equals(t, tc.res, res)
+				}
+			}
+		})
+	}
+}
@@ -1,12 +1,14 @@
 package acme
 
 import (
+	"bytes"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/nosql"
 )
 
@@ -58,11 +60,27 @@ func newCert(db nosql.DB, ops CertOptions) (*certificate, error) {
 		return nil, ServerInternalErr(errors.Wrap(err, "error marshaling certificate"))
 	}
 
+	// The entry of the certificates-by-account index is written first, so
+	// every stored certificate can be found by its account. The entries of
+	// the certificates that are not stored are skipped, or written as a
+	// tombstone.
+	seq, err := certIndex.reserve(db, ops.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	key := orderIndexKey(ops.AccountID, seq)
+	if err := db.Set(accountCertsTable, key, []byte(id)); err != nil {
+		db.Set(accountCertsTable, key, []byte(orderIndexTombstone))
+		return nil, ServerInternalErr(errors.Wrapf(err, "error storing certificate %s in the index of account %s", id, ops.AccountID))
+	}
+
 	_, swapped, err := db.CmpAndSwap(certTable, []byte(id), nil, certB)
 	switch {
 	case err != nil:
+		db.Set(accountCertsTable, key, []byte(orderIndexTombstone))
 		return nil, ServerInternalErr(errors.Wrap(err, "error storing certificate"))
 	case !swapped:
+		db.Set(accountCertsTable, key, []byte(orderIndexTombstone))
 		return nil, ServerInternalErr(errors.New("error storing certificate; " +
 			"value has changed since last read"))
 	default:
@@ -70,8 +88,95 @@ func newCert(db nosql.DB, ops CertOptions) (*certificate, error) {
 	}
 }
 
-func (c *certificate) toACME(db nosql.DB, dir *directory) ([]byte, error) {
-	return append(c.Leaf, c.Intermediates...), nil
+// getCertsByAccount returns the certificates issued to the account, sorted by
+// creation time.
+func getCertsByAccount(db nosql.DB, accID string) ([]*certificate, error) {
+	ids, err := certIndex.listAll(db, accID)
+	if err != nil {
+		return nil, err
+	}
+	values, err := batchGet(db, certTable, "certificate", ids)
+	if err != nil {
+		return nil, ServerInternalErr(err)
+	}
+	certs := make([]*certificate, 0, len(values))
+	seen := make(map[string]bool, len(values))
+	for i, b := range values {
+		// Skip the certificates that are being stored, and the ones indexed
+		// twice by concurrent migrations.
+		if b == nil || seen[ids[i]] {
+			continue
+		}
+		seen[ids[i]] = true
+		cert := new(certificate)
+		if err := json.Unmarshal(b, cert); err != nil {
+			return nil, ServerInternalErr(errors.Wrapf(err, "error unmarshaling certificate %s", ids[i]))
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// toACME returns the PEM chain of the certificate with the contents selected
+// by the provisioner. The roots are the root certificates of the CA, the one
+// that signs the last certificate of the chain is added if the provisioner
+// requires it.
+func (c *certificate) toACME(db nosql.DB, dir *directory, p provisioner.Interface, roots []*x509.Certificate) ([]byte, error) {
+	switch getCertificateChain(p) {
+	case provisioner.ACMEChainLeaf:
+		return c.Leaf, nil
+	case provisioner.ACMEChainRoot:
+		// The root must sign the last certificate in the chain.
+		block, rest := pem.Decode(c.Leaf)
+		for rest = c.Intermediates; len(rest) > 0; {
+			var next *pem.Block
+			if next, rest = pem.Decode(rest); next == nil {
+				break
+			}
+			block = next
+		}
+		if block == nil {
+			return nil, ServerInternalErr(errors.Errorf("error decoding certificate %s", c.ID))
+		}
+		crt, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, ServerInternalErr(errors.Wrapf(err, "error parsing certificate %s", c.ID))
+		}
+		for _, root := range roots {
+			if bytes.Equal(crt.RawIssuer, root.RawSubject) && crt.CheckSignatureFrom(root) == nil {
+				chain := append(append([]byte{}, c.Leaf...), c.Intermediates...)
+				return append(chain, pem.EncodeToMemory(&pem.Block{
+					Type:  "CERTIFICATE",
+					Bytes: root.Raw,
+				})...), nil
+			}
+		}
+		return nil, ServerInternalErr(errors.Errorf("error adding root to certificate %s: root not found", c.ID))
+	default:
+		return append(append([]byte{}, c.Leaf...), c.Intermediates...), nil
+	}
+}
+
+// getCertificateChain returns the contents of the certificate chains of the
+// provisioner.
+func getCertificateChain(p provisioner.Interface) string {
+	if acmeProv, ok := p.(*provisioner.ACME); ok {
+		return acmeProv.GetCertificateChain()
+	}
+	return provisioner.ACMEChainIntermediates
+}
+
+// parseLeaf returns the parsed leaf certificate.
+func (c *certificate) parseLeaf() (*x509.Certificate, error) {
+	block, _ := pem.Decode(c.Leaf)
+	if block == nil {
+		return nil, ServerInternalErr(errors.Errorf("error decoding certificate %s", c.ID))
+	}
+	crt, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, ServerInternalErr(errors.Wrapf(err, "error parsing certificate %s", c.ID))
+	}
+	return crt, nil
 }
 
 func getCert(db nosql.DB, id string) (*certificate, error) {
@@ -9,6 +9,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/db"
 	"github.com/smallstep/cli/crypto/pemutil"
 	"github.com/smallstep/nosql"
@@ -41,50 +42,110 @@ func newcert() (*certificate, error) {
 	if err != nil {
 		return nil, err
 	}
-	mockdb := &db.MockNoSQLDB{
+	return newCert(newCertDB(), *ops)
+}
+
+// newCertDB returns a mock database with empty certificates tables.
+func newCertDB() *db.MockNoSQLDB {
+	return newCleanupDB(map[string]map[string][]byte{
+		string(certTable):         {},
+		string(accountCertsTable): {},
+	})
+}
+
+// newCertIndexDB returns a mock database with an empty certificates index
+// that stores the certificates with the given function.
+func newCertIndexDB(t *testing.T, index map[string][]byte, cmpAndSwap func(bucket, key, old, newval []byte) ([]byte, bool, error)) *db.MockNoSQLDB {
+	return &db.MockNoSQLDB{
+		MGet: func(bucket, key []byte) ([]byte, error) {
+			assert.Equals(t, bucket, accountCertsTable)
+			return nil, database.ErrNotFound
+		},
+		MSet: func(bucket, key, value []byte) error {
+			assert.Equals(t, bucket, accountCertsTable)
+			index[string(key)] = value
+			return nil
+		},
 		MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
-			return nil, true, nil
+			if string(bucket) == string(accountCertsTable) {
+				assert.Equals(t, key, []byte("accID"))
+				assert.Equals(t, old, nil)
+				assert.Equals(t, newval, orderIndexHead{next: 1}.bytes())
+				return newval, true, nil
+			}
+			return cmpAndSwap(bucket, key, old, newval)
 		},
 	}
-	return newCert(mockdb, *ops)
 }
 
 func TestNewCert(t *testing.T) {
 	type test struct {
-		db  nosql.DB
-		ops CertOptions
-		err *Error
-		id  *string
+		db    nosql.DB
+		ops   CertOptions
+		err   *Error
+		id    *string
+		index map[string][]byte
+		entry string
 	}
+	key := string(orderIndexKey("accID", 0))
 	tests := map[string]func(t *testing.T) test{
-		"fail/cmpAndSwap-error": func(t *testing.T) test {
+		"fail/index-error": func(t *testing.T) test {
 			ops, err := defaultCertOps()
 			assert.FatalError(t, err)
 			return test{
 				ops: *ops,
 				db: &db.MockNoSQLDB{
-					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
-						assert.Equals(t, bucket, certTable)
-						assert.Equals(t, old, nil)
-						return nil, false, errors.New("force")
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, bucket, accountCertsTable)
+						return nil, errors.New("force")
 					},
 				},
-				err: ServerInternalErr(errors.Errorf("error storing certificate: force")),
+				err: ServerInternalErr(errors.Errorf("error loading certificate index for account accID: force")),
+			}
+		},
+		"fail/index-set-error": func(t *testing.T) test {
+			ops, err := defaultCertOps()
+			assert.FatalError(t, err)
+			mockdb := newCertIndexDB(t, nil, nil)
+			mockdb.MSet = func(bucket, key, value []byte) error {
+				return errors.New("force")
+			}
+			return test{
+				ops: *ops,
+				db:  mockdb,
+				err: ServerInternalErr(errors.Errorf("error storing certificate ")),
+			}
+		},
+		"fail/cmpAndSwap-error": func(t *testing.T) test {
+			ops, err := defaultCertOps()
+			assert.FatalError(t, err)
+			index := map[string][]byte{}
+			return test{
+				ops: *ops,
+				db: newCertIndexDB(t, index, func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					assert.Equals(t, bucket, certTable)
+					assert.Equals(t, old, nil)
+					return nil, false, errors.New("force")
+				}),
+				err:   ServerInternalErr(errors.Errorf("error storing certificate: force")),
+				index: index,
+				entry: orderIndexTombstone,
 			}
 		},
 		"fail/cmpAndSwap-false": func(t *testing.T) test {
 			ops, err := defaultCertOps()
 			assert.FatalError(t, err)
+			index := map[string][]byte{}
 			return test{
 				ops: *ops,
-				db: &db.MockNoSQLDB{
-					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
-						assert.Equals(t, bucket, certTable)
-						assert.Equals(t, old, nil)
-						return nil, false, nil
-					},
-				},
-				err: ServerInternalErr(errors.Errorf("error storing certificate; value has changed since last read")),
+				db: newCertIndexDB(t, index, func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					assert.Equals(t, bucket, certTable)
+					assert.Equals(t, old, nil)
+					return nil, false, nil
+				}),
+				err:   ServerInternalErr(errors.Errorf("error storing certificate; value has changed since last read")),
+				index: index,
+				entry: orderIndexTombstone,
 			}
 		},
 		"ok": func(t *testing.T) test {
@@ -92,17 +153,19 @@ func TestNewCert(t *testing.T) {
 			assert.FatalError(t, err)
 			var _id string
 			id := &_id
+			index := map[string][]byte{}
 			return test{
 				ops: *ops,
-				db: &db.MockNoSQLDB{
-					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
-						assert.Equals(t, bucket, certTable)
-						assert.Equals(t, old, nil)
-						*id = string(key)
-						return nil, true, nil
-					},
-				},
-				id: id,
+				db: newCertIndexDB(t, index, func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					assert.Equals(t, bucket, certTable)
+					assert.Equals(t, old, nil)
+					// The certificate is indexed before it's stored.
+					assert.Equals(t, index[string(orderIndexKey("accID", 0))], key)
+					*id = string(key)
+					return nil, true, nil
+				}),
+				id:    id,
+				index: index,
 			}
 		},
 	}
@@ -117,9 +180,13 @@ func TestNewCert(t *testing.T) {
 					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
 					assert.Equals(t, ae.Type, tc.err.Type)
 				}
+				if tc.entry != "" {
+					assert.Equals(t, []byte(tc.entry), tc.index[key])
+				}
 			} else {
 				if assert.Nil(t, tc.err) {
 					assert.Equals(t, cert.ID, *tc.id)
+					assert.Equals(t, []byte(cert.ID), tc.index[key])
 					assert.Equals(t, cert.AccountID, tc.ops.AccountID)
 					assert.Equals(t, cert.OrderID, tc.ops.OrderID)
 
@@ -245,9 +312,47 @@ func TestGetCert(t *testing.T) {
 }
 
 func TestCertificateToACME(t *testing.T) {
-	cert, err := newcert()
+	ops, err := defaultCertOps()
 	assert.FatalError(t, err)
-	acmeCert, err := cert.toACME(nil, nil)
+	root := ops.Intermediates[1]
+	ops.Intermediates = ops.Intermediates[:1]
+	cert, err := newCert(newCertDB(), *ops)
 	assert.FatalError(t, err)
-	assert.Equals(t, append(cert.Leaf, cert.Intermediates...), acmeCert)
+	rootPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})
+	chain := append(append([]byte{}, cert.Leaf...), cert.Intermediates...)
+
+	newProv := func(chain string) provisioner.Interface {
+		return &provisioner.ACME{Name: "acme", Type: "ACME", CertificateChain: chain}
+	}
+	type test struct {
+		p     provisioner.Interface
+		roots []*x509.Certificate
+		res   []byte
+		err   *Error
+	}
+	tests := map[string]test{
+		"ok/default":       {res: chain},
+		"ok/intermediates": {p: newProv(provisioner.ACMEChainIntermediates), roots: []*x509.Certificate{root}, res: chain},
+		"ok/leaf":          {p: newProv(provisioner.ACMEChainLeaf), res: cert.Leaf},
+		"ok/root":          {p: newProv(provisioner.ACMEChainRoot), roots: []*x509.Certificate{ops.Leaf, root}, res: append(chain, rootPEM...)},
+		"fail/root-not-found": {
+			p:     newProv(provisioner.ACMEChainRoot),
+			roots: []*x509.Certificate{ops.Leaf},
+			err:   ServerInternalErr(errors.Errorf("error adding root to certificate %s: root not found", cert.ID)),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			res, err := cert.toACME(nil, nil, tc.p, tc.roots)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assertAcmeError(t, tc.err, err)
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, tc.res, res)
+			}
+		})
+	}
+	// The stored chain is not modified.
+	assert.Equals(t, chain, append(append([]byte{}, cert.Leaf...), cert.Intermediates...))
 }
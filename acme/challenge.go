@@ -1,6 +1,7 @@
 package acme
 
 import (
+	"context"
 	"crypto"
 	"crypto/sha256"
 	"crypto/subtle"
@@ -13,6 +14,8 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptrace"
+	"strconv"
 	"strings"
 	"time"
 
@@ -25,14 +28,17 @@ import (
 // Challenge is a subset of the challenge type containing only those attributes
 // required for responses in the ACME protocol.
 type Challenge struct {
-	Type      string  `json:"type"`
-	Status    string  `json:"status"`
-	Token     string  `json:"token"`
-	Validated string  `json:"validated,omitempty"`
-	URL       string  `json:"url"`
-	Error     *AError `json:"error,omitempty"`
-	ID        string  `json:"-"`
-	AuthzID   string  `json:"-"`
+	Type           string  `json:"type"`
+	Status         string  `json:"status"`
+	Token          string  `json:"token"`
+	Validated      string  `json:"validated,omitempty"`
+	URL            string  `json:"url"`
+	Error          *AError `json:"error,omitempty"`
+	TKAuthType     string  `json:"tkauth-type,omitempty"`
+	TokenAuthority string  `json:"token-authority,omitempty"`
+	ID             string  `json:"-"`
+	AuthzID        string  `json:"-"`
+	RetryAfter     string  `json:"-"`
 }
 
 // ToLog enables response logging.
@@ -54,14 +60,202 @@ func (c *Challenge) GetAuthzID() string {
 	return c.AuthzID
 }
 
-type httpGetter func(string) (*http.Response, error)
+type httpGetter func(*http.Request) (*http.Response, error)
 type lookupTxt func(string) ([]string, error)
 type tlsDialer func(network, addr string, config *tls.Config) (*tls.Conn, error)
 
 type validateOptions struct {
-	httpGet   httpGetter
-	lookupTxt lookupTxt
-	tlsDial   tlsDialer
+	httpGet     httpGetter
+	lookupTxt   lookupTxt
+	tlsDial     tlsDialer
+	attestation *attestationOptions
+	tkauth      *tkauthOptions
+	corroborate func(PerspectiveRequest) ([]*ValidationAttempt, *Error)
+}
+
+// corroborateChallenge requests the validation of a locally validated
+// challenge to the remote perspectives, if they are configured. It returns
+// the attempts of the remote perspectives, and the error to store in the
+// challenge if the quorum is not reached.
+func (vo validateOptions) corroborateChallenge(bc *baseChallenge, keyAuth string) ([]*ValidationAttempt, *Error) {
+	if vo.corroborate == nil {
+		return nil, nil
+	}
+	return vo.corroborate(PerspectiveRequest{
+		Type:             bc.Type,
+		Identifier:       bc.Value,
+		Token:            bc.Token,
+		KeyAuthorization: keyAuth,
+	})
+}
+
+// defaultHTTP01MaxRedirects is the default maximum number of redirects
+// followed validating http-01 challenges.
+const defaultHTTP01MaxRedirects = 10
+
+// newHTTP01Client returns the http.Client used to validate http-01 challenges.
+// The given configuration can replace the default port 80, set the local
+// address used in the connections, change the dial timeout, set the proxy
+// used to reach the targets, and change the redirect and IP family policies.
+// The given proxy, if any, replaces the one in the configuration, and if
+// neither is set the proxy is taken from the environment.
+func newHTTP01Client(c *HTTP01Config, proxy *provisioner.ACMEHTTPProxy) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+	}
+	hd := &http01Dialer{
+		dialer:   dialer,
+		lookupIP: net.DefaultResolver.LookupIPAddr,
+		fallback: true,
+	}
+	rp := &http01RedirectPolicy{
+		maxRedirects: defaultHTTP01MaxRedirects,
+	}
+	var port string
+	if c != nil {
+		hd.preferIPv4 = c.PreferIPv4
+		hd.fallback = !c.DisableIPFallback
+		switch {
+		case c.MaxRedirects < 0:
+			rp.maxRedirects = 0
+		case c.MaxRedirects > 0:
+			rp.maxRedirects = c.MaxRedirects
+		}
+		rp.sameHost = c.DisableCrossHostRedirects
+		rp.anyPort = c.AllowAnyRedirectPort
+		if c.DialTimeout != nil && c.DialTimeout.Duration > 0 {
+			dialer.Timeout = c.DialTimeout.Duration
+		}
+		if c.BindAddress != "" {
+			dialer.LocalAddr = &net.TCPAddr{IP: net.ParseIP(c.BindAddress)}
+		}
+		if c.Port > 0 {
+			port = strconv.Itoa(c.Port)
+		}
+		if proxy == nil {
+			proxy = c.Proxy
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = hd.DialContext
+	if proxy != nil {
+		transport.Proxy = proxy.ProxyFunc()
+	} else {
+		transport.Proxy = http.ProxyFromEnvironment
+	}
+	return &http.Client{
+		Timeout:       30 * time.Second,
+		Transport:     &http01Transport{transport: transport, port: port},
+		CheckRedirect: rp.checkRedirect,
+	}
+}
+
+// http01RedirectPolicy is the policy used to follow the redirects of http-01
+// challenges. Like Let's Encrypt, by default it follows up to 10 redirects
+// to http or https URLs on any host, but only on the ports 80 and 443.
+type http01RedirectPolicy struct {
+	maxRedirects int
+	sameHost     bool
+	anyPort      bool
+}
+
+// checkRedirect implements the CheckRedirect function of an http.Client.
+func (rp *http01RedirectPolicy) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > rp.maxRedirects {
+		return errors.Errorf("too many redirects, the maximum is %d", rp.maxRedirects)
+	}
+	u := req.URL
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.Errorf("redirect to %s is not allowed: invalid scheme %s", u, u.Scheme)
+	}
+	if port := u.Port(); !rp.anyPort && port != "" && port != "80" && port != "443" {
+		return errors.Errorf("redirect to %s is not allowed: port %s is not 80 or 443", u, port)
+	}
+	if rp.sameHost && !strings.EqualFold(u.Hostname(), via[0].URL.Hostname()) {
+		return errors.Errorf("redirect to %s is not allowed: host must be %s", u, via[0].URL.Hostname())
+	}
+	return nil
+}
+
+// http01Dialer is the dialer used to validate http-01 challenges. If a host
+// has both IPv6 and IPv4 addresses, like Let's Encrypt, by default it
+// connects to the first IPv6 address, and if the connection fails it retries
+// with the first IPv4 address.
+type http01Dialer struct {
+	dialer     *net.Dialer
+	lookupIP   func(ctx context.Context, host string) ([]net.IPAddr, error)
+	preferIPv4 bool
+	fallback   bool
+}
+
+// DialContext connects to the given address using the IP family policy of
+// the dialer.
+func (d *http01Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return d.dialer.DialContext(ctx, network, addr)
+	}
+	addrs, err := d.lookupIP(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var ipv4, ipv6 []net.IP
+	for _, a := range addrs {
+		if a.IP.To4() != nil {
+			ipv4 = append(ipv4, a.IP)
+		} else {
+			ipv6 = append(ipv6, a.IP)
+		}
+	}
+	first, second := ipv6, ipv4
+	if d.preferIPv4 {
+		first, second = ipv4, ipv6
+	}
+	if len(first) == 0 {
+		first, second = second, nil
+	}
+	if len(first) == 0 {
+		return nil, errors.Errorf("no IP addresses found for %s", host)
+	}
+	conn, err := d.dialer.DialContext(ctx, network, net.JoinHostPort(first[0].String(), port))
+	if err == nil || !d.fallback || len(second) == 0 {
+		return conn, err
+	}
+	return d.dialer.DialContext(ctx, network, net.JoinHostPort(second[0].String(), port))
+}
+
+// http01Transport is the http.RoundTripper used to validate http-01
+// challenges. It replaces the port of the requests to the default http port,
+// requests to other ports, e.g. https redirects, are left untouched. The Host
+// header keeps the original value on direct connections, but proxies get the
+// new port in the Host header, as they use it to connect to the target.
+type http01Transport struct {
+	transport *http.Transport
+	port      string
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *http01Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.port == "" || req.URL.Scheme != "http" || (req.URL.Port() != "" && req.URL.Port() != "80") {
+		return t.transport.RoundTrip(req)
+	}
+	proxyURL, err := t.transport.Proxy(req)
+	if err != nil {
+		return nil, err
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	req = req.Clone(req.Context())
+	req.URL.Host = net.JoinHostPort(req.URL.Hostname(), t.port)
+	if proxyURL == nil {
+		req.Host = host
+	} else {
+		req.Host = req.URL.Host
+	}
+	return t.transport.RoundTrip(req)
 }
 
 // challenge is the interface ACME challenege types must implement.
@@ -79,7 +273,9 @@ type challenge interface {
 	getAccountID() string
 	getValidated() time.Time
 	getCreated() time.Time
-	toACME(nosql.DB, *directory, provisioner.Interface) (*Challenge, error)
+	getRetry() *Retry
+	process(nosql.DB, string) (challenge, error)
+	toACME(context.Context, nosql.DB, *directory, provisioner.Interface) (*Challenge, error)
 }
 
 // ChallengeOptions is the type used to created a new Challenge.
@@ -89,18 +285,123 @@ type ChallengeOptions struct {
 	Identifier Identifier
 }
 
-// baseChallenge is the base Challenge type that others build from.
+// baseChallenge is the base Challenge type that others build from. The
+// Fingerprint is set by device-attest-01 challenges to the fingerprint of the
+// attested key.
 type baseChallenge struct {
-	ID        string    `json:"id"`
-	AccountID string    `json:"accountID"`
-	AuthzID   string    `json:"authzID"`
-	Type      string    `json:"type"`
-	Status    string    `json:"status"`
-	Token     string    `json:"token"`
-	Value     string    `json:"value"`
-	Validated time.Time `json:"validated"`
-	Created   time.Time `json:"created"`
-	Error     *AError   `json:"error"`
+	ID          string               `json:"id"`
+	AccountID   string               `json:"accountID"`
+	AuthzID     string               `json:"authzID"`
+	Type        string               `json:"type"`
+	Status      string               `json:"status"`
+	Token       string               `json:"token"`
+	Value       string               `json:"value"`
+	Validated   time.Time            `json:"validated"`
+	Created     time.Time            `json:"created"`
+	Error       *AError              `json:"error"`
+	Retry       *Retry               `json:"retry,omitempty"`
+	Attempts    []*ValidationAttempt `json:"attempts,omitempty"`
+	Fingerprint string               `json:"fingerprint,omitempty"`
+}
+
+// Retry contains the state of the asynchronous validation of a challenge. It
+// keeps the number of validation attempts, the time of the next attempt, the
+// errors of the failed attempts, and the provisioner used to resume the
+// validation after a restart.
+type Retry struct {
+	Count         int       `json:"count"`
+	NextAttempt   time.Time `json:"nextAttempt"`
+	Errors        []*AError `json:"errors,omitempty"`
+	ProvisionerID string    `json:"provisionerID,omitempty"`
+}
+
+// localVantage is the vantage of the validation attempts performed by the ACME
+// server itself, remote perspectives use the URL of the agent.
+const localVantage = "local"
+
+// remoteVantage replaces the URL of the remote perspective agents in the
+// attempts presented to the ACME clients, the URLs are only available to the
+// admins.
+const remoteVantage = "remote"
+
+// redactAttempts returns a copy of the given attempts without the URLs of
+// the remote perspective agents.
+func redactAttempts(attempts []*ValidationAttempt) []*ValidationAttempt {
+	if attempts == nil {
+		return nil
+	}
+	redacted := make([]*ValidationAttempt, len(attempts))
+	for i, att := range attempts {
+		r := *att
+		if r.Vantage != localVantage {
+			r.Vantage = remoteVantage
+			if r.Error != nil {
+				e := *r.Error
+				e.Detail = redactVantages(e.Detail, attempts)
+				r.Error = &e
+			}
+		}
+		redacted[i] = &r
+	}
+	return redacted
+}
+
+// redactVantages replaces the URLs of the remote perspective agents of the
+// given attempts in s.
+func redactVantages(s string, attempts []*ValidationAttempt) string {
+	for _, att := range attempts {
+		if att.Vantage != localVantage && att.Vantage != "" {
+			s = strings.Replace(s, att.Vantage, remoteVantage, -1)
+		}
+	}
+	return s
+}
+
+// ValidationAttempt is the record of one attempt to validate a challenge from
+// the given vantage. TargetIP is the address the vantage connected to, and
+// ResponseDigest is the hex encoded SHA-256 digest of the response: the body
+// of an http-01 request, the TXT records of a dns-01 lookup, separated by new
+// lines, or the leaf certificate of a tls-alpn-01 handshake. Error is empty
+// if the attempt succeeded.
+type ValidationAttempt struct {
+	Time           time.Time `json:"time"`
+	Vantage        string    `json:"vantage"`
+	TargetIP       string    `json:"targetIP,omitempty"`
+	ResponseDigest string    `json:"responseDigest,omitempty"`
+	Error          *AError   `json:"error,omitempty"`
+}
+
+// newValidationAttempt returns a new attempt from the given vantage that
+// starts now.
+func newValidationAttempt(vantage string) *ValidationAttempt {
+	return &ValidationAttempt{
+		Time:    clock.Now(),
+		Vantage: vantage,
+	}
+}
+
+// setResponse sets the digest of the given response.
+func (va *ValidationAttempt) setResponse(b []byte) {
+	sum := sha256.Sum256(b)
+	va.ResponseDigest = hex.EncodeToString(sum[:])
+}
+
+// setTarget sets the IP of the given remote address.
+func (va *ValidationAttempt) setTarget(addr net.Addr) {
+	if addr == nil {
+		return
+	}
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		va.TargetIP = host
+	} else {
+		va.TargetIP = addr.String()
+	}
+}
+
+// fail sets the error of the attempt and returns it.
+func (va *ValidationAttempt) fail(err *Error) *ValidationAttempt {
+	va.Error = err.ToACME()
+	return va
 }
 
 func newBaseChallenge(accountID, authzID string) (*baseChallenge, error) {
@@ -173,14 +474,19 @@ func (bc *baseChallenge) getError() *AError {
 	return bc.Error
 }
 
+// getRetry returns the validation retry state of the baseChallenge.
+func (bc *baseChallenge) getRetry() *Retry {
+	return bc.Retry
+}
+
 // toACME converts the internal Challenge type into the public acmeChallenge
 // type for presentation in the ACME protocol.
-func (bc *baseChallenge) toACME(db nosql.DB, dir *directory, p provisioner.Interface) (*Challenge, error) {
+func (bc *baseChallenge) toACME(ctx context.Context, db nosql.DB, dir *directory, p provisioner.Interface) (*Challenge, error) {
 	ac := &Challenge{
 		Type:    bc.getType(),
 		Status:  bc.getStatus(),
 		Token:   bc.getToken(),
-		URL:     dir.getLink(ChallengeLink, URLSafeProvisionerName(p), true, bc.getID()),
+		URL:     dir.getLink(ctx, ChallengeLink, URLSafeProvisionerName(p), true, bc.getID()),
 		ID:      bc.getID(),
 		AuthzID: bc.getAuthzID(),
 	}
@@ -188,7 +494,17 @@ func (bc *baseChallenge) toACME(db nosql.DB, dir *directory, p provisioner.Inter
 		ac.Validated = bc.Validated.Format(time.RFC3339)
 	}
 	if bc.Error != nil {
-		ac.Error = bc.Error
+		ae := *bc.Error
+		ae.Detail = redactVantages(ae.Detail, bc.Attempts)
+		ae.Attempts = redactAttempts(bc.Attempts)
+		ac.Error = &ae
+	}
+	if bc.Status == StatusProcessing && bc.Retry != nil && !bc.Retry.NextAttempt.IsZero() {
+		retryAfter := int(bc.Retry.NextAttempt.Sub(clock.Now()).Seconds())
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		ac.RetryAfter = strconv.Itoa(retryAfter)
 	}
 	return ac, nil
 }
@@ -228,16 +544,56 @@ func (bc *baseChallenge) save(db nosql.DB, old challenge) error {
 
 func (bc *baseChallenge) clone() *baseChallenge {
 	u := *bc
+	if bc.Retry != nil {
+		r := *bc.Retry
+		u.Retry = &r
+	}
+	if bc.Attempts != nil {
+		u.Attempts = append([]*ValidationAttempt{}, bc.Attempts...)
+	}
 	return &u
 }
 
+// morph returns the challenge type that corresponds to the type of the
+// baseChallenge.
+func (bc *baseChallenge) morph() challenge {
+	switch bc.Type {
+	case "dns-01":
+		return &dns01Challenge{bc}
+	case "http-01":
+		return &http01Challenge{bc}
+	case "tls-alpn-01":
+		return &tlsALPN01Challenge{bc}
+	case "device-attest-01":
+		return &deviceAttest01Challenge{bc}
+	default:
+		return bc
+	}
+}
+
+// process marks the challenge as processing. The background validation of the
+// challenge must be started only if the new status is stored successfully. The
+// provisioner ID is kept to resume the validation after a restart.
+func (bc *baseChallenge) process(db nosql.DB, provID string) (challenge, error) {
+	upd := bc.clone()
+	upd.Status = StatusProcessing
+	upd.Retry = &Retry{ProvisionerID: provID}
+	if err := upd.save(db, bc); err != nil {
+		return nil, err
+	}
+	return upd.morph(), nil
+}
+
 func (bc *baseChallenge) validate(db nosql.DB, jwk *jose.JSONWebKey, vo validateOptions) (challenge, error) {
 	return nil, ServerInternalErr(errors.New("unimplemented"))
 }
 
-func (bc *baseChallenge) storeError(db nosql.DB, err *Error) error {
+// storeError stores the error of a failed validation, together with the
+// attempts performed.
+func (bc *baseChallenge) storeError(db nosql.DB, err *Error, attempts ...*ValidationAttempt) error {
 	clone := bc.clone()
 	clone.Error = err.ToACME()
+	clone.Attempts = append(clone.Attempts, attempts...)
 	return clone.save(db, bc)
 }
 
@@ -272,6 +628,20 @@ func unmarshalChallenge(data []byte) (challenge, error) {
 				"challenge type into tlsALPN01Challenge"))
 		}
 		return &tlsALPN01Challenge{&bc}, nil
+	case "device-attest-01":
+		var bc baseChallenge
+		if err := json.Unmarshal(data, &bc); err != nil {
+			return nil, ServerInternalErr(errors.Wrap(err, "error unmarshaling "+
+				"challenge type into deviceAttest01Challenge"))
+		}
+		return &deviceAttest01Challenge{&bc}, nil
+	case "tkauth-01":
+		var bc baseChallenge
+		if err := json.Unmarshal(data, &bc); err != nil {
+			return nil, ServerInternalErr(errors.Wrap(err, "error unmarshaling "+
+				"challenge type into tkAuth01Challenge"))
+		}
+		return &tkAuth01Challenge{&bc}, nil
 	default:
 		return nil, ServerInternalErr(errors.Errorf("unexpected challenge type %s", getType.Type))
 	}
@@ -306,41 +676,33 @@ func (hc *http01Challenge) validate(db nosql.DB, jwk *jose.JSONWebKey, vo valida
 	if hc.getStatus() == StatusValid || hc.getStatus() == StatusInvalid {
 		return hc, nil
 	}
-	url := fmt.Sprintf("http://%s/.well-known/acme-challenge/%s", hc.Value, hc.Token)
-
-	resp, err := vo.httpGet(url)
+	att := newValidationAttempt(localVantage)
+	keyAuth, verr, err := http01KeyAuthorization(vo.httpGet, hc.Value, hc.Token, att)
 	if err != nil {
-		if err = hc.storeError(db, ConnectionErr(errors.Wrapf(err,
-			"error doing http GET for url %s", url))); err != nil {
-			return nil, err
-		}
-		return hc, nil
+		return nil, err
 	}
-	if resp.StatusCode >= 400 {
-		if err = hc.storeError(db,
-			ConnectionErr(errors.Errorf("error doing http GET for url %s with status code %d",
-				url, resp.StatusCode))); err != nil {
+	if verr != nil {
+		if err = hc.storeError(db, verr, att.fail(verr)); err != nil {
 			return nil, err
 		}
 		return hc, nil
 	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, ServerInternalErr(errors.Wrapf(err, "error reading "+
-			"response body for url %s", url))
-	}
-	keyAuth := strings.Trim(string(body), "\r\n")
 
 	expected, err := KeyAuthorization(hc.Token, jwk)
 	if err != nil {
 		return nil, err
 	}
 	if keyAuth != expected {
-		if err = hc.storeError(db,
-			RejectedIdentifierErr(errors.Errorf("keyAuthorization does not match; "+
-				"expected %s, but got %s", expected, keyAuth))); err != nil {
+		verr = RejectedIdentifierErr(errors.Errorf("keyAuthorization does not match; "+
+			"expected %s, but got %s", expected, keyAuth))
+		if err = hc.storeError(db, verr, att.fail(verr)); err != nil {
+			return nil, err
+		}
+		return hc, nil
+	}
+	remote, verr := vo.corroborateChallenge(hc.baseChallenge, expected)
+	if verr != nil {
+		if err = hc.storeError(db, verr, append([]*ValidationAttempt{att}, remote...)...); err != nil {
 			return nil, err
 		}
 		return hc, nil
@@ -351,6 +713,8 @@ func (hc *http01Challenge) validate(db nosql.DB, jwk *jose.JSONWebKey, vo valida
 	upd.Status = StatusValid
 	upd.Error = nil
 	upd.Validated = clock.Now()
+	upd.Attempts = append(upd.Attempts, att)
+	upd.Attempts = append(upd.Attempts, remote...)
 
 	if err := upd.save(db, hc); err != nil {
 		return nil, err
@@ -358,6 +722,44 @@ func (hc *http01Challenge) validate(db nosql.DB, jwk *jose.JSONWebKey, vo valida
 	return upd, nil
 }
 
+// http01KeyAuthorization requests the key authorization of an http-01
+// challenge to the given domain, recording the target and the response in the
+// given attempt. It returns the error to store in the challenge if the request
+// fails, or an error if the response cannot be read.
+func http01KeyAuthorization(httpGet httpGetter, domain, token string, att *ValidationAttempt) (string, *Error, error) {
+	url := fmt.Sprintf("http://%s/.well-known/acme-challenge/%s", domain, token)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", ConnectionErr(errors.Wrapf(err,
+			"error doing http GET for url %s", url)), nil
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			att.setTarget(info.Conn.RemoteAddr())
+		},
+	}))
+
+	resp, err := httpGet(req)
+	if err != nil {
+		return "", ConnectionErr(errors.Wrapf(err,
+			"error doing http GET for url %s", url)), nil
+	}
+	if resp.StatusCode >= 400 {
+		return "", ConnectionErr(errors.Errorf("error doing http GET for url %s with status code %d",
+			url, resp.StatusCode)), nil
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", nil, ServerInternalErr(errors.Wrapf(err, "error reading "+
+			"response body for url %s", url))
+	}
+	att.setResponse(body)
+	return strings.Trim(string(body), "\r\n"), nil, nil
+}
+
 type tlsALPN01Challenge struct {
 	*baseChallenge
 }
@@ -391,47 +793,40 @@ func (tc *tlsALPN01Challenge) validate(db nosql.DB, jwk *jose.JSONWebKey, vo val
 	}
 
 	hostPort := net.JoinHostPort(tc.Value, "443")
-
-	conn, err := vo.tlsDial("tcp", hostPort, config)
-	if err != nil {
-		if err = tc.storeError(db,
-			ConnectionErr(errors.Wrapf(err, "error doing TLS dial for %s", hostPort))); err != nil {
+	att := newValidationAttempt(localVantage)
+	fail := func(verr *Error) (challenge, error) {
+		if err := tc.storeError(db, verr, att.fail(verr)); err != nil {
 			return nil, err
 		}
 		return tc, nil
 	}
+
+	conn, err := vo.tlsDial("tcp", hostPort, config)
+	if err != nil {
+		return fail(ConnectionErr(errors.Wrapf(err, "error doing TLS dial for %s", hostPort)))
+	}
 	defer conn.Close()
+	att.setTarget(conn.RemoteAddr())
 
 	cs := conn.ConnectionState()
 	certs := cs.PeerCertificates
 
 	if len(certs) == 0 {
-		if err = tc.storeError(db,
-			RejectedIdentifierErr(errors.Errorf("%s challenge for %s resulted in no certificates",
-				tc.Type, tc.Value))); err != nil {
-			return nil, err
-		}
-		return tc, nil
+		return fail(RejectedIdentifierErr(errors.Errorf("%s challenge for %s resulted in no certificates",
+			tc.Type, tc.Value)))
 	}
 
 	if !cs.NegotiatedProtocolIsMutual || cs.NegotiatedProtocol != "acme-tls/1" {
-		if err = tc.storeError(db,
-			RejectedIdentifierErr(errors.Errorf("cannot negotiate ALPN acme-tls/1 protocol for "+
-				"tls-alpn-01 challenge"))); err != nil {
-			return nil, err
-		}
-		return tc, nil
+		return fail(RejectedIdentifierErr(errors.Errorf("cannot negotiate ALPN acme-tls/1 protocol for " +
+			"tls-alpn-01 challenge")))
 	}
 
 	leafCert := certs[0]
+	att.setResponse(leafCert.Raw)
 
 	if len(leafCert.DNSNames) != 1 || !strings.EqualFold(leafCert.DNSNames[0], tc.Value) {
-		if err = tc.storeError(db,
-			RejectedIdentifierErr(errors.Errorf("incorrect certificate for tls-alpn-01 challenge: "+
-				"leaf certificate must contain a single DNS name, %v", tc.Value))); err != nil {
-			return nil, err
-		}
-		return tc, nil
+		return fail(RejectedIdentifierErr(errors.Errorf("incorrect certificate for tls-alpn-01 challenge: "+
+			"leaf certificate must contain a single DNS name, %v", tc.Value)))
 	}
 
 	idPeAcmeIdentifier := asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
@@ -447,40 +842,29 @@ func (tc *tlsALPN01Challenge) validate(db nosql.DB, jwk *jose.JSONWebKey, vo val
 	for _, ext := range leafCert.Extensions {
 		if idPeAcmeIdentifier.Equal(ext.Id) {
 			if !ext.Critical {
-				if err = tc.storeError(db,
-					RejectedIdentifierErr(errors.Errorf("incorrect certificate for tls-alpn-01 challenge: "+
-						"acmeValidationV1 extension not critical"))); err != nil {
-					return nil, err
-				}
-				return tc, nil
+				return fail(RejectedIdentifierErr(errors.Errorf("incorrect certificate for tls-alpn-01 challenge: " +
+					"acmeValidationV1 extension not critical")))
 			}
 
 			var extValue []byte
 			rest, err := asn1.Unmarshal(ext.Value, &extValue)
 
 			if err != nil || len(rest) > 0 || len(hashedKeyAuth) != len(extValue) {
-				if err = tc.storeError(db,
-					RejectedIdentifierErr(errors.Errorf("incorrect certificate for tls-alpn-01 challenge: "+
-						"malformed acmeValidationV1 extension value"))); err != nil {
-					return nil, err
-				}
-				return tc, nil
+				return fail(RejectedIdentifierErr(errors.Errorf("incorrect certificate for tls-alpn-01 challenge: " +
+					"malformed acmeValidationV1 extension value")))
 			}
 
 			if subtle.ConstantTimeCompare(hashedKeyAuth[:], extValue) != 1 {
-				if err = tc.storeError(db,
-					RejectedIdentifierErr(errors.Errorf("incorrect certificate for tls-alpn-01 challenge: "+
-						"expected acmeValidationV1 extension value %s for this challenge but got %s",
-						hex.EncodeToString(hashedKeyAuth[:]), hex.EncodeToString(extValue)))); err != nil {
-					return nil, err
-				}
-				return tc, nil
+				return fail(RejectedIdentifierErr(errors.Errorf("incorrect certificate for tls-alpn-01 challenge: "+
+					"expected acmeValidationV1 extension value %s for this challenge but got %s",
+					hex.EncodeToString(hashedKeyAuth[:]), hex.EncodeToString(extValue))))
 			}
 
 			upd := &tlsALPN01Challenge{tc.baseChallenge.clone()}
 			upd.Status = StatusValid
 			upd.Error = nil
 			upd.Validated = clock.Now()
+			upd.Attempts = append(upd.Attempts, att)
 
 			if err := upd.save(db, tc); err != nil {
 				return nil, err
@@ -494,20 +878,12 @@ func (tc *tlsALPN01Challenge) validate(db nosql.DB, jwk *jose.JSONWebKey, vo val
 	}
 
 	if foundIDPeAcmeIdentifierV1Obsolete {
-		if err = tc.storeError(db,
-			RejectedIdentifierErr(errors.Errorf("incorrect certificate for tls-alpn-01 challenge: "+
-				"obsolete id-pe-acmeIdentifier in acmeValidationV1 extension"))); err != nil {
-			return nil, err
-		}
-		return tc, nil
+		return fail(RejectedIdentifierErr(errors.Errorf("incorrect certificate for tls-alpn-01 challenge: " +
+			"obsolete id-pe-acmeIdentifier in acmeValidationV1 extension")))
 	}
 
-	if err = tc.storeError(db,
-		RejectedIdentifierErr(errors.Errorf("incorrect certificate for tls-alpn-01 challenge: "+
-			"missing acmeValidationV1 extension"))); err != nil {
-		return nil, err
-	}
-	return tc, nil
+	return fail(RejectedIdentifierErr(errors.Errorf("incorrect certificate for tls-alpn-01 challenge: " +
+		"missing acmeValidationV1 extension")))
 }
 
 // dns01Challenge represents an dns-01 acme challenge.
@@ -551,17 +927,10 @@ func (dc *dns01Challenge) validate(db nosql.DB, jwk *jose.JSONWebKey, vo validat
 		return dc, nil
 	}
 
-	// Normalize domain for wildcard DNS names
-	// This is done to avoid making TXT lookups for domains like
-	// _acme-challenge.*.example.com
-	// Instead perform txt lookup for _acme-challenge.example.com
-	domain := strings.TrimPrefix(dc.Value, "*.")
-
-	txtRecords, err := vo.lookupTxt("_acme-challenge." + domain)
-	if err != nil {
-		if err = dc.storeError(db,
-			DNSErr(errors.Wrapf(err, "error looking up TXT "+
-				"records for domain %s", domain))); err != nil {
+	att := newValidationAttempt(localVantage)
+	txtRecords, verr := dns01TXTRecords(vo.lookupTxt, dc.Value, att)
+	if verr != nil {
+		if err := dc.storeError(db, verr, att.fail(verr)); err != nil {
 			return nil, err
 		}
 		return dc, nil
@@ -571,19 +940,17 @@ func (dc *dns01Challenge) validate(db nosql.DB, jwk *jose.JSONWebKey, vo validat
 	if err != nil {
 		return nil, err
 	}
-	h := sha256.Sum256([]byte(expectedKeyAuth))
-	expected := base64.RawURLEncoding.EncodeToString(h[:])
-	var found bool
-	for _, r := range txtRecords {
-		if r == expected {
-			found = true
-			break
+	if !dns01Match(txtRecords, expectedKeyAuth) {
+		verr = RejectedIdentifierErr(errors.Errorf("keyAuthorization "+
+			"does not match; expected %s, but got %s", expectedKeyAuth, txtRecords))
+		if err = dc.storeError(db, verr, att.fail(verr)); err != nil {
+			return nil, err
 		}
+		return dc, nil
 	}
-	if !found {
-		if err = dc.storeError(db,
-			RejectedIdentifierErr(errors.Errorf("keyAuthorization "+
-				"does not match; expected %s, but got %s", expectedKeyAuth, txtRecords))); err != nil {
+	remote, verr := vo.corroborateChallenge(dc.baseChallenge, expectedKeyAuth)
+	if verr != nil {
+		if err = dc.storeError(db, verr, append([]*ValidationAttempt{att}, remote...)...); err != nil {
 			return nil, err
 		}
 		return dc, nil
@@ -594,6 +961,8 @@ func (dc *dns01Challenge) validate(db nosql.DB, jwk *jose.JSONWebKey, vo validat
 	upd.Status = StatusValid
 	upd.Error = nil
 	upd.Validated = time.Now().UTC()
+	upd.Attempts = append(upd.Attempts, att)
+	upd.Attempts = append(upd.Attempts, remote...)
 
 	if err := upd.save(db, dc); err != nil {
 		return nil, err
@@ -601,6 +970,38 @@ func (dc *dns01Challenge) validate(db nosql.DB, jwk *jose.JSONWebKey, vo validat
 	return upd, nil
 }
 
+// dns01TXTRecords looks up the TXT records of a dns-01 challenge for the
+// given domain, recording the response in the given attempt. It returns the
+// error to store in the challenge if the lookup fails.
+func dns01TXTRecords(lookup lookupTxt, domain string, att *ValidationAttempt) ([]string, *Error) {
+	// Normalize domain for wildcard DNS names
+	// This is done to avoid making TXT lookups for domains like
+	// _acme-challenge.*.example.com
+	// Instead perform txt lookup for _acme-challenge.example.com
+	domain = strings.TrimPrefix(domain, "*.")
+
+	txtRecords, err := lookup("_acme-challenge." + domain)
+	if err != nil {
+		return nil, DNSErr(errors.Wrapf(err, "error looking up TXT "+
+			"records for domain %s", domain))
+	}
+	att.setResponse([]byte(strings.Join(txtRecords, "\n")))
+	return txtRecords, nil
+}
+
+// dns01Match returns true if one of the given TXT records is the digest of
+// the key authorization.
+func dns01Match(txtRecords []string, keyAuth string) bool {
+	h := sha256.Sum256([]byte(keyAuth))
+	expected := base64.RawURLEncoding.EncodeToString(h[:])
+	for _, r := range txtRecords {
+		if r == expected {
+			return true
+		}
+	}
+	return false
+}
+
 // getChallenge retrieves and unmarshals an ACME challenge type from the database.
 func getChallenge(db nosql.DB, id string) (challenge, error) {
 	b, err := db.Get(challengeTable, []byte(id))
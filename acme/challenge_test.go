@@ -2,6 +2,7 @@ package acme
 
 import (
 	"bytes"
+	"context"
 	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
@@ -20,11 +21,14 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/db"
 	"github.com/smallstep/cli/jose"
 	"github.com/smallstep/nosql"
@@ -67,6 +71,52 @@ func newHTTPCh() (challenge, error) {
 	return newHTTP01Challenge(mockdb, testOps)
 }
 
+// newLocalAttempt returns the expected local validation attempt with the
+// given error and response, without time.
+func newLocalAttempt(err *Error, response string) *ValidationAttempt {
+	att := &ValidationAttempt{Vantage: "local"}
+	if response != "" {
+		att.setResponse([]byte(response))
+	}
+	if err != nil {
+		att.fail(err)
+	}
+	return att
+}
+
+// clearLocalAttempt checks that the given marshaled challenge has one local
+// validation attempt that failed with the given error, and returns the
+// challenge without it.
+func clearLocalAttempt(t *testing.T, b []byte, err *Error) []byte {
+	bc := new(baseChallenge)
+	assert.FatalError(t, json.Unmarshal(b, bc))
+	if assert.Equals(t, 1, len(bc.Attempts)) {
+		assert.Equals(t, "local", bc.Attempts[0].Vantage)
+		if assert.NotNil(t, bc.Attempts[0].Error) {
+			assert.Equals(t, err.ToACME().Type, bc.Attempts[0].Error.Type)
+			assert.Equals(t, err.ToACME().Detail, bc.Attempts[0].Error.Detail)
+		}
+	}
+	bc.Attempts = nil
+	b, e := json.Marshal(bc)
+	assert.FatalError(t, e)
+	return b
+}
+
+// clearAttemptTimes returns the given marshaled challenge without the time of
+// its validation attempts.
+func clearAttemptTimes(t *testing.T, b []byte) []byte {
+	bc := new(baseChallenge)
+	assert.FatalError(t, json.Unmarshal(b, bc))
+	for _, att := range bc.Attempts {
+		assert.False(t, att.Time.IsZero())
+		att.Time = time.Time{}
+	}
+	b, err := json.Marshal(bc)
+	assert.FatalError(t, err)
+	return b
+}
+
 func TestNewHTTP01Challenge(t *testing.T) {
 	ops := ChallengeOptions{
 		AccountID: "accID",
@@ -271,6 +321,15 @@ func TestChallengeToACME(t *testing.T) {
 	assert.FatalError(t, err)
 	tlsALPNCh, err := newTLSALPNCh()
 	assert.FatalError(t, err)
+	_tlsALPNCh, ok := tlsALPNCh.(*tlsALPN01Challenge)
+	assert.Fatal(t, ok)
+	agent := "https://agent.internal:8443/validate"
+	remote := &ValidationAttempt{Vantage: agent, TargetIP: "10.0.0.1"}
+	remote.fail(IncorrectResponseErr(errors.New(agent + ": challenge is not valid")))
+	attempts := []*ValidationAttempt{newLocalAttempt(nil, "response"), remote}
+	_tlsALPNCh.baseChallenge.Error = IncorrectResponseErr(errors.New("challenge validated by 0 of 1 " +
+		"remote perspectives, 1 required: " + agent + ": challenge is not valid")).ToACME()
+	_tlsALPNCh.baseChallenge.Attempts = attempts
 
 	prov := newProv()
 	tests := map[string]challenge{
@@ -280,7 +339,7 @@ func TestChallengeToACME(t *testing.T) {
 	}
 	for name, ch := range tests {
 		t.Run(name, func(t *testing.T) {
-			ach, err := ch.toACME(nil, dir, prov)
+			ach, err := ch.toACME(context.Background(), nil, dir, prov)
 			assert.FatalError(t, err)
 
 			assert.Equals(t, ach.Type, ch.getType())
@@ -299,6 +358,26 @@ func TestChallengeToACME(t *testing.T) {
 			} else {
 				assert.Equals(t, ach.Validated, "")
 			}
+
+			// The error of the challenge includes the validation attempts,
+			// without the URLs of the remote perspective agents.
+			if ach.Type == "tls-alpn-01" {
+				if assert.NotNil(t, ach.Error) {
+					assert.Equals(t, ach.Error.Detail, "challenge validated by 0 of 1 remote perspectives, "+
+						"1 required: remote: challenge is not valid")
+					if assert.Equals(t, 2, len(ach.Error.Attempts)) {
+						assert.Equals(t, ach.Error.Attempts[0], attempts[0])
+						assert.Equals(t, ach.Error.Attempts[1].Vantage, "remote")
+						assert.Equals(t, ach.Error.Attempts[1].TargetIP, "10.0.0.1")
+						assert.Equals(t, ach.Error.Attempts[1].Error.Detail, "remote: challenge is not valid")
+					}
+				}
+				assert.Nil(t, _tlsALPNCh.baseChallenge.Error.Attempts)
+				assert.Equals(t, agent, attempts[1].Vantage)
+				assert.Equals(t, agent+": challenge is not valid", attempts[1].Error.Detail)
+			} else {
+				assert.Nil(t, ach.Error)
+			}
 		})
 	}
 }
@@ -418,6 +497,14 @@ func TestChallengeClone(t *testing.T) {
 	clone.Status = StatusValid
 
 	assert.NotEquals(t, clone.getStatus(), ch.getStatus())
+
+	_ch, ok := ch.(*http01Challenge)
+	assert.Fatal(t, ok)
+	att := newLocalAttempt(nil, "foo")
+	_ch.baseChallenge.Attempts = []*ValidationAttempt{att}
+	clone = ch.clone()
+	clone.Attempts[0] = newLocalAttempt(nil, "bar")
+	assert.Equals(t, []*ValidationAttempt{att}, _ch.baseChallenge.Attempts)
 }
 
 func TestChallengeUnmarshal(t *testing.T) {
@@ -703,6 +790,163 @@ func (errReader) Close() error {
 	return nil
 }
 
+func TestNewHTTP01Client(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	assert.FatalError(t, err)
+	port, err := strconv.Atoi(u.Port())
+	assert.FatalError(t, err)
+
+	client := newHTTP01Client(&HTTP01Config{
+		Port:        port,
+		BindAddress: "127.0.0.1",
+		DialTimeout: &provisioner.Duration{Duration: 5 * time.Second},
+	}, nil)
+	resp, err := client.Get("http://127.0.0.1/.well-known/acme-challenge/token")
+	assert.FatalError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.FatalError(t, err)
+	// The host header must not contain the replaced port.
+	assert.Equals(t, "127.0.0.1", string(body))
+
+	// Without configuration the default port is used.
+	client = newHTTP01Client(nil, nil)
+	resp, err = client.Get(srv.URL)
+	assert.FatalError(t, err)
+	resp.Body.Close()
+	assert.Equals(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestHTTP01RedirectPolicy(t *testing.T) {
+	type test struct {
+		c   *HTTP01Config
+		via int
+		url string
+		err error
+	}
+	tests := map[string]test{
+		"ok/default":     {url: "https://other.example.com/path", via: 10},
+		"ok/port-80":     {url: "http://other.example.com:80/path"},
+		"ok/same-host":   {c: &HTTP01Config{DisableCrossHostRedirects: true}, url: "https://EXAMPLE.com:443/path"},
+		"ok/any-port":    {c: &HTTP01Config{AllowAnyRedirectPort: true}, url: "http://example.com:8080/path"},
+		"ok/max":         {c: &HTTP01Config{MaxRedirects: 20}, url: "http://example.com/path", via: 20},
+		"fail/too-many":  {url: "http://example.com/path", via: 11, err: errors.New("too many redirects, the maximum is 10")},
+		"fail/disabled":  {c: &HTTP01Config{MaxRedirects: -1}, url: "http://example.com/path", via: 1, err: errors.New("too many redirects, the maximum is 0")},
+		"fail/scheme":    {url: "ftp://example.com/path", err: errors.New("redirect to ftp://example.com/path is not allowed: invalid scheme ftp")},
+		"fail/port":      {url: "http://example.com:8080/path", err: errors.New("redirect to http://example.com:8080/path is not allowed: port 8080 is not 80 or 443")},
+		"fail/same-host": {c: &HTTP01Config{DisableCrossHostRedirects: true}, url: "http://other.example.com/path", err: errors.New("redirect to http://other.example.com/path is not allowed: host must be example.com")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			client := newHTTP01Client(tc.c, nil)
+			first := httptest.NewRequest("GET", "http://example.com/.well-known/acme-challenge/token", nil)
+			via := []*http.Request{first}
+			for i := 1; i < tc.via; i++ {
+				via = append(via, first)
+			}
+			err := client.CheckRedirect(httptest.NewRequest("GET", tc.url, nil), via)
+			if tc.err != nil {
+				if assert.NotNil(t, err) {
+					assert.Equals(t, tc.err.Error(), err.Error())
+				}
+			} else {
+				assert.FatalError(t, err)
+			}
+		})
+	}
+}
+
+func TestHTTP01Dialer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	assert.FatalError(t, err)
+	// The server only listens on 127.0.0.1, and the IPv6 address is the
+	// discard prefix.
+	lookupIP := func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		assert.Equals(t, "example.com", host)
+		return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}, {IP: net.ParseIP("100::1")}}, nil
+	}
+	dial := func(preferIPv4, fallback bool) error {
+		d := &http01Dialer{
+			dialer:     &net.Dialer{Timeout: 200 * time.Millisecond},
+			lookupIP:   lookupIP,
+			preferIPv4: preferIPv4,
+			fallback:   fallback,
+		}
+		conn, err := d.DialContext(context.Background(), "tcp", net.JoinHostPort("example.com", u.Port()))
+		if err != nil {
+			return err
+		}
+		assert.Equals(t, u.Host, conn.RemoteAddr().String())
+		return conn.Close()
+	}
+
+	assert.FatalError(t, dial(false, true))
+	assert.FatalError(t, dial(true, false))
+	assert.NotNil(t, dial(false, false))
+
+	// IP addresses are not resolved.
+	d := &http01Dialer{dialer: &net.Dialer{}}
+	conn, err := d.DialContext(context.Background(), "tcp", u.Host)
+	assert.FatalError(t, err)
+	conn.Close()
+
+	d.lookupIP = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		return nil, nil
+	}
+	_, err = d.DialContext(context.Background(), "tcp", "example.com:80")
+	assert.Equals(t, "no IP addresses found for example.com", err.Error())
+}
+
+func TestNewHTTP01ClientProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Proxied requests contain the absolute URL.
+		w.Write([]byte(r.URL.String() + " " + r.Host))
+	}))
+	defer proxy.Close()
+
+	get := func(client *http.Client, url string) (string, error) {
+		resp, err := client.Get(url)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	// The port is replaced in the URL and the Host sent to the proxy.
+	client := newHTTP01Client(&HTTP01Config{
+		Port:  8080,
+		Proxy: &provisioner.ACMEHTTPProxy{URL: proxy.URL},
+	}, nil)
+	body, err := get(client, "http://zap.internal/.well-known/acme-challenge/token")
+	assert.FatalError(t, err)
+	assert.Equals(t, "http://zap.internal:8080/.well-known/acme-challenge/token zap.internal:8080", body)
+
+	// The proxy of the provisioner replaces the one in the configuration.
+	client = newHTTP01Client(&HTTP01Config{
+		Proxy: &provisioner.ACMEHTTPProxy{URL: "http://127.0.0.1:1"},
+	}, &provisioner.ACMEHTTPProxy{URL: proxy.URL})
+	body, err = get(client, "http://zap.internal/.well-known/acme-challenge/token")
+	assert.FatalError(t, err)
+	assert.Equals(t, "http://zap.internal/.well-known/acme-challenge/token zap.internal", body)
+
+	// Hosts in NoProxy are reached directly.
+	client = newHTTP01Client(nil, &provisioner.ACMEHTTPProxy{URL: proxy.URL, NoProxy: []string{".internal"}})
+	_, err = get(client, "http://zap.internal/.well-known/acme-challenge/token")
+	assert.NotNil(t, err)
+}
+
 func TestHTTP01Validate(t *testing.T) {
 	type test struct {
 		vo  validateOptions
@@ -745,13 +989,14 @@ func TestHTTP01Validate(t *testing.T) {
 				"http://zap.internal/.well-known/acme-challenge/%s: force", ch.getToken()))
 			baseClone := ch.clone()
 			baseClone.Error = expErr.ToACME()
+			baseClone.Attempts = []*ValidationAttempt{newLocalAttempt(expErr, "")}
 			newCh := &http01Challenge{baseClone}
 			newb, err := json.Marshal(newCh)
 			assert.FatalError(t, err)
 			return test{
 				ch: ch,
 				vo: validateOptions{
-					httpGet: func(url string) (*http.Response, error) {
+					httpGet: func(req *http.Request) (*http.Response, error) {
 						return nil, errors.New("force")
 					},
 				},
@@ -760,7 +1005,7 @@ func TestHTTP01Validate(t *testing.T) {
 						assert.Equals(t, bucket, challengeTable)
 						assert.Equals(t, key, []byte(ch.getID()))
 						assert.Equals(t, old, oldb)
-						assert.Equals(t, newval, newb)
+						assert.Equals(t, clearAttemptTimes(t, newval), newb)
 						return nil, true, nil
 					},
 				},
@@ -777,13 +1022,14 @@ func TestHTTP01Validate(t *testing.T) {
 				"http://zap.internal/.well-known/acme-challenge/%s with status code 400", ch.getToken()))
 			baseClone := ch.clone()
 			baseClone.Error = expErr.ToACME()
+			baseClone.Attempts = []*ValidationAttempt{newLocalAttempt(expErr, "")}
 			newCh := &http01Challenge{baseClone}
 			newb, err := json.Marshal(newCh)
 			assert.FatalError(t, err)
 			return test{
 				ch: ch,
 				vo: validateOptions{
-					httpGet: func(url string) (*http.Response, error) {
+					httpGet: func(req *http.Request) (*http.Response, error) {
 						return &http.Response{
 							StatusCode: http.StatusBadRequest,
 						}, nil
@@ -794,7 +1040,7 @@ func TestHTTP01Validate(t *testing.T) {
 						assert.Equals(t, bucket, challengeTable)
 						assert.Equals(t, key, []byte(ch.getID()))
 						assert.Equals(t, old, oldb)
-						assert.Equals(t, newval, newb)
+						assert.Equals(t, clearAttemptTimes(t, newval), newb)
 						return nil, true, nil
 					},
 				},
@@ -811,7 +1057,7 @@ func TestHTTP01Validate(t *testing.T) {
 			return test{
 				ch: ch,
 				vo: validateOptions{
-					httpGet: func(url string) (*http.Response, error) {
+					httpGet: func(req *http.Request) (*http.Response, error) {
 						return &http.Response{
 							Body: errReader(0),
 						}, nil
@@ -832,7 +1078,7 @@ func TestHTTP01Validate(t *testing.T) {
 			return test{
 				ch: ch,
 				vo: validateOptions{
-					httpGet: func(url string) (*http.Response, error) {
+					httpGet: func(req *http.Request) (*http.Response, error) {
 						return &http.Response{
 							Body: ioutil.NopCloser(bytes.NewBufferString("foo")),
 						}, nil
@@ -858,6 +1104,7 @@ func TestHTTP01Validate(t *testing.T) {
 				"expected %s, but got foo", expKeyAuth))
 			baseClone := ch.clone()
 			baseClone.Error = expErr.ToACME()
+			baseClone.Attempts = []*ValidationAttempt{newLocalAttempt(expErr, "foo")}
 			newCh := &http01Challenge{baseClone}
 			newb, err := json.Marshal(newCh)
 			assert.FatalError(t, err)
@@ -865,7 +1112,7 @@ func TestHTTP01Validate(t *testing.T) {
 			return test{
 				ch: ch,
 				vo: validateOptions{
-					httpGet: func(url string) (*http.Response, error) {
+					httpGet: func(req *http.Request) (*http.Response, error) {
 						return &http.Response{
 							Body: ioutil.NopCloser(bytes.NewBufferString("foo")),
 						}, nil
@@ -877,7 +1124,57 @@ func TestHTTP01Validate(t *testing.T) {
 						assert.Equals(t, bucket, challengeTable)
 						assert.Equals(t, key, []byte(ch.getID()))
 						assert.Equals(t, old, oldb)
-						assert.Equals(t, newval, newb)
+						assert.Equals(t, clearAttemptTimes(t, newval), newb)
+						return nil, true, nil
+					},
+				},
+				res: ch,
+			}
+		},
+		"ok/perspectives-failure": func(t *testing.T) test {
+			ch, err := newHTTPCh()
+			assert.FatalError(t, err)
+			oldb, err := json.Marshal(ch)
+			assert.FatalError(t, err)
+
+			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+
+			expKeyAuth, err := KeyAuthorization(ch.getToken(), jwk)
+			assert.FatalError(t, err)
+
+			expErr := IncorrectResponseErr(errors.New("challenge validated by 0 of 1 remote perspectives, 1 required: force"))
+			baseClone := ch.clone()
+			baseClone.Error = expErr.ToACME()
+			remote := []*ValidationAttempt{{Time: clock.Now(), Vantage: "https://agent.internal", Error: expErr.ToACME()}}
+			baseClone.Attempts = []*ValidationAttempt{newLocalAttempt(nil, expKeyAuth), {Vantage: "https://agent.internal", Error: expErr.ToACME()}}
+			newCh := &http01Challenge{baseClone}
+			newb, err := json.Marshal(newCh)
+			assert.FatalError(t, err)
+
+			return test{
+				ch: ch,
+				vo: validateOptions{
+					httpGet: func(req *http.Request) (*http.Response, error) {
+						return &http.Response{
+							Body: ioutil.NopCloser(bytes.NewBufferString(expKeyAuth)),
+						}, nil
+					},
+					corroborate: func(req PerspectiveRequest) ([]*ValidationAttempt, *Error) {
+						assert.Equals(t, req, PerspectiveRequest{
+							Type:             "http-01",
+							Identifier:       ch.getValue(),
+							Token:            ch.getToken(),
+							KeyAuthorization: expKeyAuth,
+						})
+						return remote, expErr
+					},
+				},
+				jwk: jwk,
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						assert.Equals(t, old, oldb)
+						assert.Equals(t, clearAttemptTimes(t, newval), newb)
 						return nil, true, nil
 					},
 				},
@@ -896,7 +1193,7 @@ func TestHTTP01Validate(t *testing.T) {
 			return test{
 				ch: ch,
 				vo: validateOptions{
-					httpGet: func(url string) (*http.Response, error) {
+					httpGet: func(req *http.Request) (*http.Response, error) {
 						return &http.Response{
 							Body: ioutil.NopCloser(bytes.NewBufferString(expKeyAuth)),
 						}, nil
@@ -935,7 +1232,7 @@ func TestHTTP01Validate(t *testing.T) {
 				ch:  ch,
 				res: newCh,
 				vo: validateOptions{
-					httpGet: func(url string) (*http.Response, error) {
+					httpGet: func(req *http.Request) (*http.Response, error) {
 						return &http.Response{
 							Body: ioutil.NopCloser(bytes.NewBufferString(expKeyAuth)),
 						}, nil
@@ -953,6 +1250,9 @@ func TestHTTP01Validate(t *testing.T) {
 						assert.Equals(t, httpCh.getStatus(), StatusValid)
 						assert.True(t, httpCh.getValidated().Before(time.Now().UTC().Add(time.Minute)))
 						assert.True(t, httpCh.getValidated().After(time.Now().UTC().Add(-1*time.Second)))
+						cleared, err := unmarshalChallenge(clearAttemptTimes(t, newval))
+						assert.FatalError(t, err)
+						assert.Equals(t, []*ValidationAttempt{newLocalAttempt(nil, expKeyAuth)}, cleared.clone().Attempts)
 
 						baseClone.Validated = httpCh.getValidated()
 
@@ -1049,7 +1349,7 @@ func TestTLSALPN01Validate(t *testing.T) {
 						assert.Equals(t, bucket, challengeTable)
 						assert.Equals(t, key, []byte(ch.getID()))
 						assert.Equals(t, old, oldb)
-						assert.Equals(t, newval, newb)
+						assert.Equals(t, clearLocalAttempt(t, newval, expErr), newb)
 						return nil, true, nil
 					},
 				},
@@ -1083,7 +1383,7 @@ func TestTLSALPN01Validate(t *testing.T) {
 						assert.Equals(t, bucket, challengeTable)
 						assert.Equals(t, key, []byte(ch.getID()))
 						assert.Equals(t, old, oldb)
-						assert.Equals(t, string(newval), string(newb))
+						assert.Equals(t, string(clearLocalAttempt(t, newval, expErr)), string(newb))
 						return nil, true, nil
 					},
 				},
@@ -1115,7 +1415,7 @@ func TestTLSALPN01Validate(t *testing.T) {
 						assert.Equals(t, bucket, challengeTable)
 						assert.Equals(t, key, []byte(ch.getID()))
 						assert.Equals(t, old, oldb)
-						assert.Equals(t, string(newval), string(newb))
+						assert.Equals(t, string(clearLocalAttempt(t, newval, expErr)), string(newb))
 						return nil, true, nil
 					},
 				},
@@ -1160,7 +1460,7 @@ func TestTLSALPN01Validate(t *testing.T) {
 						assert.Equals(t, bucket, challengeTable)
 						assert.Equals(t, key, []byte(ch.getID()))
 						assert.Equals(t, old, oldb)
-						assert.Equals(t, string(newval), string(newb))
+						assert.Equals(t, string(clearLocalAttempt(t, newval, expErr)), string(newb))
 						return nil, true, nil
 					},
 				},
@@ -1205,7 +1505,7 @@ func TestTLSALPN01Validate(t *testing.T) {
 						assert.Equals(t, bucket, challengeTable)
 						assert.Equals(t, key, []byte(ch.getID()))
 						assert.Equals(t, old, oldb)
-						assert.Equals(t, string(newval), string(newb))
+						assert.Equals(t, string(clearLocalAttempt(t, newval, expErr)), string(newb))
 						return nil, true, nil
 					},
 				},
@@ -1250,7 +1550,7 @@ func TestTLSALPN01Validate(t *testing.T) {
 						assert.Equals(t, bucket, challengeTable)
 						assert.Equals(t, key, []byte(ch.getID()))
 						assert.Equals(t, old, oldb)
-						assert.Equals(t, string(newval), string(newb))
+						assert.Equals(t, string(clearLocalAttempt(t, newval, expErr)), string(newb))
 						return nil, true, nil
 					},
 				},
@@ -1291,7 +1591,7 @@ func TestTLSALPN01Validate(t *testing.T) {
 						assert.Equals(t, bucket, challengeTable)
 						assert.Equals(t, key, []byte(ch.getID()))
 						assert.Equals(t, old, oldb)
-						assert.Equals(t, string(newval), string(newb))
+						assert.Equals(t, string(clearLocalAttempt(t, newval, expErr)), string(newb))
 						return nil, true, nil
 					},
 				},
@@ -1336,7 +1636,7 @@ func TestTLSALPN01Validate(t *testing.T) {
 						assert.Equals(t, bucket, challengeTable)
 						assert.Equals(t, key, []byte(ch.getID()))
 						assert.Equals(t, old, oldb)
-						assert.Equals(t, string(newval), string(newb))
+						assert.Equals(t, string(clearLocalAttempt(t, newval, expErr)), string(newb))
 						return nil, true, nil
 					},
 				},
@@ -1377,7 +1677,7 @@ func TestTLSALPN01Validate(t *testing.T) {
 						assert.Equals(t, bucket, challengeTable)
 						assert.Equals(t, key, []byte(ch.getID()))
 						assert.Equals(t, old, oldb)
-						assert.Equals(t, string(newval), string(newb))
+						assert.Equals(t, string(clearLocalAttempt(t, newval, expErr)), string(newb))
 						return nil, true, nil
 					},
 				},
@@ -1416,7 +1716,7 @@ func TestTLSALPN01Validate(t *testing.T) {
 						assert.Equals(t, bucket, challengeTable)
 						assert.Equals(t, key, []byte(ch.getID()))
 						assert.Equals(t, old, oldb)
-						assert.Equals(t, string(newval), string(newb))
+						assert.Equals(t, string(clearLocalAttempt(t, newval, expErr)), string(newb))
 						return nil, true, nil
 					},
 				},
@@ -1464,7 +1764,7 @@ func TestTLSALPN01Validate(t *testing.T) {
 						assert.Equals(t, bucket, challengeTable)
 						assert.Equals(t, key, []byte(ch.getID()))
 						assert.Equals(t, old, oldb)
-						assert.Equals(t, string(newval), string(newb))
+						assert.Equals(t, string(clearLocalAttempt(t, newval, expErr)), string(newb))
 						return nil, true, nil
 					},
 				},
@@ -1510,7 +1810,7 @@ func TestTLSALPN01Validate(t *testing.T) {
 						assert.Equals(t, bucket, challengeTable)
 						assert.Equals(t, key, []byte(ch.getID()))
 						assert.Equals(t, old, oldb)
-						assert.Equals(t, string(newval), string(newb))
+						assert.Equals(t, string(clearLocalAttempt(t, newval, expErr)), string(newb))
 						return nil, true, nil
 					},
 				},
@@ -1570,6 +1870,11 @@ func TestTLSALPN01Validate(t *testing.T) {
 						assert.Equals(t, alpnCh.getStatus(), StatusValid)
 						assert.True(t, alpnCh.getValidated().Before(time.Now().UTC().Add(time.Minute)))
 						assert.True(t, alpnCh.getValidated().After(time.Now().UTC().Add(-1*time.Second)))
+						if atts := alpnCh.clone().Attempts; assert.Equals(t, 1, len(atts)) {
+							assert.Equals(t, "127.0.0.1", atts[0].TargetIP)
+							assert.Equals(t, newLocalAttempt(nil, string(cert.Certificate[0])).ResponseDigest, atts[0].ResponseDigest)
+							assert.Nil(t, atts[0].Error)
+						}
 
 						baseClone.Validated = alpnCh.getValidated()
 
@@ -1746,6 +2051,7 @@ func TestDNS01Validate(t *testing.T) {
 				"domain %s: force", ch.getValue()))
 			baseClone := ch.clone()
 			baseClone.Error = expErr.ToACME()
+			baseClone.Attempts = []*ValidationAttempt{newLocalAttempt(expErr, "")}
 			newCh := &dns01Challenge{baseClone}
 			newb, err := json.Marshal(newCh)
 			assert.FatalError(t, err)
@@ -1761,7 +2067,7 @@ func TestDNS01Validate(t *testing.T) {
 						assert.Equals(t, bucket, challengeTable)
 						assert.Equals(t, key, []byte(ch.getID()))
 						assert.Equals(t, old, oldb)
-						assert.Equals(t, newval, newb)
+						assert.Equals(t, clearAttemptTimes(t, newval), newb)
 						return nil, true, nil
 					},
 				},
@@ -1842,6 +2148,7 @@ func TestDNS01Validate(t *testing.T) {
 				"expected %s, but got %s", expKeyAuth, []string{"foo", "bar"}))
 			baseClone := ch.clone()
 			baseClone.Error = expErr.ToACME()
+			baseClone.Attempts = []*ValidationAttempt{newLocalAttempt(expErr, "foo\nbar")}
 			newCh := &http01Challenge{baseClone}
 			newb, err := json.Marshal(newCh)
 			assert.FatalError(t, err)
@@ -1859,7 +2166,53 @@ func TestDNS01Validate(t *testing.T) {
 						assert.Equals(t, bucket, challengeTable)
 						assert.Equals(t, key, []byte(ch.getID()))
 						assert.Equals(t, old, oldb)
-						assert.Equals(t, newval, newb)
+						assert.Equals(t, clearAttemptTimes(t, newval), newb)
+						return nil, true, nil
+					},
+				},
+				res: ch,
+			}
+		},
+		"ok/perspectives-failure": func(t *testing.T) test {
+			ch, err := newDNSCh()
+			assert.FatalError(t, err)
+			oldb, err := json.Marshal(ch)
+			assert.FatalError(t, err)
+
+			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+
+			expKeyAuth, err := KeyAuthorization(ch.getToken(), jwk)
+			assert.FatalError(t, err)
+			h := sha256.Sum256([]byte(expKeyAuth))
+			expected := base64.RawURLEncoding.EncodeToString(h[:])
+
+			expErr := IncorrectResponseErr(errors.New("challenge validated by 1 of 3 remote perspectives, 2 required: force"))
+			baseClone := ch.clone()
+			baseClone.Error = expErr.ToACME()
+			remote := []*ValidationAttempt{{Time: clock.Now(), Vantage: "https://agent.internal", Error: expErr.ToACME()}}
+			baseClone.Attempts = []*ValidationAttempt{newLocalAttempt(nil, expected), {Vantage: "https://agent.internal", Error: expErr.ToACME()}}
+			newCh := &dns01Challenge{baseClone}
+			newb, err := json.Marshal(newCh)
+			assert.FatalError(t, err)
+
+			return test{
+				ch: ch,
+				vo: validateOptions{
+					lookupTxt: func(url string) ([]string, error) {
+						return []string{expected}, nil
+					},
+					corroborate: func(req PerspectiveRequest) ([]*ValidationAttempt, *Error) {
+						assert.Equals(t, req.Type, "dns-01")
+						assert.Equals(t, req.KeyAuthorization, expKeyAuth)
+						return remote, expErr
+					},
+				},
+				jwk: jwk,
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						assert.Equals(t, old, oldb)
+						assert.Equals(t, clearAttemptTimes(t, newval), newb)
 						return nil, true, nil
 					},
 				},
@@ -1935,6 +2288,9 @@ func TestDNS01Validate(t *testing.T) {
 						assert.Equals(t, dnsCh.getStatus(), StatusValid)
 						assert.True(t, dnsCh.getValidated().Before(time.Now().UTC()))
 						assert.True(t, dnsCh.getValidated().After(time.Now().UTC().Add(-1*time.Second)))
+						cleared, err := unmarshalChallenge(clearAttemptTimes(t, newval))
+						assert.FatalError(t, err)
+						assert.Equals(t, []*ValidationAttempt{newLocalAttempt(nil, "foo\n"+expected)}, cleared.clone().Attempts)
 
 						baseClone.Validated = dnsCh.getValidated()
 
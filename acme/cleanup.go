@@ -0,0 +1,111 @@
+package acme
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/nosql"
+)
+
+// cleanupStats contains the number of objects deleted by a cleanup.
+type cleanupStats struct {
+	Orders     int
+	Authzs     int
+	Challenges int
+}
+
+// deleteExpiredOrders deletes from the database the orders and authorizations
+// that expired before the given time, the challenges that belong to them, and
+// the references to the orders in the orders-by-account index. The records
+// that cannot be decoded are logged and skipped, so they do not stop the
+// cleanup of the rest.
+func deleteExpiredOrders(db nosql.DB, before time.Time) (*cleanupStats, error) {
+	stats := new(cleanupStats)
+
+	entries, err := db.List(orderTable)
+	if err != nil {
+		return stats, ServerInternalErr(errors.Wrap(err, "error listing orders"))
+	}
+
+	// Authorizations of orders that are not deleted must be kept even if
+	// they have expired.
+	retained := make(map[string]bool)
+	deleted := make(map[string][]string)
+	for _, e := range entries {
+		var o order
+		if err := json.Unmarshal(e.Value, &o); err != nil {
+			log.Printf("error unmarshaling acme order %s: %v", e.Key, err)
+			continue
+		}
+		if !o.expiry().Before(before) {
+			for _, azID := range o.Authorizations {
+				retained[azID] = true
+			}
+			continue
+		}
+		for _, azID := range o.Authorizations {
+			if err := deleteAuthz(db, azID, stats); err != nil {
+				return stats, err
+			}
+		}
+		if err := db.Del(orderTable, []byte(o.ID)); err != nil && !nosql.IsErrNotFound(err) {
+			return stats, ServerInternalErr(errors.Wrapf(err, "error deleting order %s", o.ID))
+		}
+		stats.Orders++
+		deleted[o.AccountID] = append(deleted[o.AccountID], o.ID)
+	}
+
+	for accID, oids := range deleted {
+		if err := removeOrderIDs(db, accID, oids); err != nil {
+			return stats, err
+		}
+	}
+
+	// Delete the expired authorizations that do not belong to any order.
+	if entries, err = db.List(authzTable); err != nil {
+		return stats, ServerInternalErr(errors.Wrap(err, "error listing authzs"))
+	}
+	for _, e := range entries {
+		az, err := unmarshalAuthz(e.Value)
+		if err != nil {
+			log.Printf("error unmarshaling acme authz %s: %v", e.Key, err)
+			continue
+		}
+		if retained[az.getID()] || !az.getExpiry().Before(before) {
+			continue
+		}
+		if err := deleteAuthz(db, az.getID(), stats); err != nil {
+			return stats, err
+		}
+	}
+
+	return stats, nil
+}
+
+// deleteAuthz deletes the authorization with the given id and its challenges.
+func deleteAuthz(db nosql.DB, id string, stats *cleanupStats) error {
+	b, err := db.Get(authzTable, []byte(id))
+	if nosql.IsErrNotFound(err) {
+		return nil
+	} else if err != nil {
+		return ServerInternalErr(errors.Wrapf(err, "error loading authz %s", id))
+	}
+	az, err := unmarshalAuthz(b)
+	if err != nil {
+		log.Printf("error unmarshaling acme authz %s: %v", id, err)
+		return nil
+	}
+	for _, chID := range az.getChallenges() {
+		if err := db.Del(challengeTable, []byte(chID)); err != nil && !nosql.IsErrNotFound(err) {
+			return ServerInternalErr(errors.Wrapf(err, "error deleting challenge %s", chID))
+		}
+		stats.Challenges++
+	}
+	if err := db.Del(authzTable, []byte(id)); err != nil && !nosql.IsErrNotFound(err) {
+		return ServerInternalErr(errors.Wrapf(err, "error deleting authz %s", id))
+	}
+	stats.Authzs++
+	return nil
+}
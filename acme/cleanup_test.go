@@ -0,0 +1,193 @@
+package acme
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/nosql"
+	"github.com/smallstep/nosql/database"
+)
+
+// newCleanupDB returns a mock database backed by a map with the given tables.
+func newCleanupDB(tables map[string]map[string][]byte) *db.MockNoSQLDB {
+	return &db.MockNoSQLDB{
+		MGet: func(bucket, key []byte) ([]byte, error) {
+			if b, ok := tables[string(bucket)][string(key)]; ok {
+				return b, nil
+			}
+			return nil, database.ErrNotFound
+		},
+		MList: func(bucket []byte) ([]*database.Entry, error) {
+			var entries []*database.Entry
+			for k, v := range tables[string(bucket)] {
+				entries = append(entries, &database.Entry{Bucket: bucket, Key: []byte(k), Value: v})
+			}
+			return entries, nil
+		},
+		MSet: func(bucket, key, value []byte) error {
+			tables[string(bucket)][string(key)] = value
+			return nil
+		},
+		MDel: func(bucket, key []byte) error {
+			delete(tables[string(bucket)], string(key))
+			return nil
+		},
+		MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+			if b := tables[string(bucket)][string(key)]; !bytes.Equal(b, old) {
+				return b, false, nil
+			}
+			tables[string(bucket)][string(key)] = newval
+			return newval, true, nil
+		},
+		MUpdate: func(tx *database.Tx) error {
+			for _, op := range tx.Operations {
+				switch op.Cmd {
+				case database.Set:
+					tables[string(op.Bucket)][string(op.Key)] = op.Value
+				case database.Delete:
+					delete(tables[string(op.Bucket)], string(op.Key))
+				case database.CmpAndSwap:
+					op.Result = tables[string(op.Bucket)][string(op.Key)]
+					if op.Swapped = bytes.Equal(op.Result, op.CmpValue); op.Swapped {
+						tables[string(op.Bucket)][string(op.Key)] = op.Value
+						op.Result = op.Value
+					}
+				default:
+					return database.ErrOpNotSupported
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func TestDeleteExpiredOrders(t *testing.T) {
+	now := clock.Now()
+	before := now.Add(-time.Hour)
+	marshal := func(v interface{}) []byte {
+		b, err := json.Marshal(v)
+		assert.FatalError(t, err)
+		return b
+	}
+	newOrder := func(id, accID string, expires time.Time, azs ...string) []byte {
+		return marshal(&order{ID: id, AccountID: accID, Expires: expires, Authorizations: azs})
+	}
+	newAuthz := func(id string, expires time.Time, chs ...string) []byte {
+		return marshal(&baseAuthz{ID: id, Identifier: Identifier{Type: "dns", Value: "example.com"}, Expires: expires, Challenges: chs})
+	}
+	keys := func(m map[string][]byte) []string {
+		var ret []string
+		for k := range m {
+			ret = append(ret, k)
+		}
+		sort.Strings(ret)
+		return ret
+	}
+	type test struct {
+		db     nosql.DB
+		tables map[string]map[string][]byte
+		stats  *cleanupStats
+		err    *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/list-orders-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MList: func(bucket []byte) ([]*database.Entry, error) {
+						assert.Equals(t, bucket, orderTable)
+						return nil, errors.New("force")
+					},
+				},
+				err: ServerInternalErr(errors.New("error listing orders: force")),
+			}
+		},
+		"fail/delete-order-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MList: func(bucket []byte) ([]*database.Entry, error) {
+						return []*database.Entry{{Bucket: orderTable, Key: []byte("foo"), Value: newOrder("foo", "acc", before.Add(-time.Minute))}}, nil
+					},
+					MDel: func(bucket, key []byte) error {
+						return errors.New("force")
+					},
+				},
+				err: ServerInternalErr(errors.New("error deleting order foo: force")),
+			}
+		},
+		"fail/list-authzs-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MList: func(bucket []byte) ([]*database.Entry, error) {
+						if bytes.Equal(bucket, authzTable) {
+							return nil, errors.New("force")
+						}
+						return nil, nil
+					},
+				},
+				err: ServerInternalErr(errors.New("error listing authzs: force")),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			tables := map[string]map[string][]byte{
+				string(orderTable): {
+					"o1": newOrder("o1", "acc1", before.Add(-time.Minute), "az1"),
+					"o2": newOrder("o2", "acc1", now, "az2"),
+					"o3": newOrder("o3", "acc2", before.Add(-time.Hour), "az3", "missing", "az6"),
+					// Undecodable records are skipped.
+					"bad": []byte("{"),
+				},
+				string(authzTable): {
+					"az1": newAuthz("az1", before.Add(-time.Minute), "ch1", "ch2"),
+					// Expired but referenced by an order that is not expired.
+					"az2": newAuthz("az2", before.Add(-time.Second), "ch3"),
+					"az3": newAuthz("az3", before.Add(-time.Hour), "ch4"),
+					"az4": newAuthz("az4", before.Add(-time.Hour), "ch5"),
+					"az5": newAuthz("az5", now, "ch6"),
+					"az6": []byte("{"),
+					"bad": []byte("{"),
+				},
+				string(challengeTable): {
+					"ch1": []byte("{}"), "ch2": []byte("{}"), "ch3": []byte("{}"),
+					"ch4": []byte("{}"), "ch5": []byte("{}"), "ch6": []byte("{}"),
+				},
+				string(ordersByAccountIDTable): {
+					"acc1": marshal([]string{"o1", "o2"}),
+					"acc2": marshal([]string{"o3"}),
+				},
+			}
+			return test{
+				db:     newCleanupDB(tables),
+				tables: tables,
+				stats:  &cleanupStats{Orders: 2, Authzs: 3, Challenges: 4},
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			stats, err := deleteExpiredOrders(tc.db, before)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, tc.stats, stats)
+				assert.Equals(t, []string{"bad", "o2"}, keys(tc.tables[string(orderTable)]))
+				assert.Equals(t, []string{"az2", "az5", "az6", "bad"}, keys(tc.tables[string(authzTable)]))
+				assert.Equals(t, []string{"ch3", "ch6"}, keys(tc.tables[string(challengeTable)]))
+				assert.Equals(t, marshal([]string{"o2"}), tc.tables[string(ordersByAccountIDTable)]["acc1"])
+				assert.Equals(t, marshal([]string{}), tc.tables[string(ordersByAccountIDTable)]["acc2"])
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package acme
 
 import (
+	"context"
 	"crypto/x509"
 	"net/url"
 	"time"
@@ -16,6 +17,33 @@ type SignAuthority interface {
 	LoadProvisionerByID(string) (provisioner.Interface, error)
 }
 
+// rootsAuthority is implemented by the sign authorities that provide their
+// root certificates, they are used to add the root to the certificate chains.
+type rootsAuthority interface {
+	GetRootCertificates() []*x509.Certificate
+}
+
+// revokeAuthority is implemented by the sign authorities that can revoke
+// certificates, it's used by revokeCert requests and to revoke the
+// certificates of deactivated accounts.
+type revokeAuthority interface {
+	IsRevoked(serial string) (bool, error)
+	RevokeCertificate(crt *x509.Certificate, reasonCode int, reason string) error
+}
+
+// cryptoPolicyAuthority is implemented by the sign authorities with a crypto
+// policy, it's used to reject the CSRs of the orders before they are
+// finalized.
+type cryptoPolicyAuthority interface {
+	CheckCertificateRequest(csr *x509.CertificateRequest) error
+}
+
+// contextSignAuthority is implemented by the sign authorities that trace the
+// signing of the certificates as part of the span in the context.
+type contextSignAuthority interface {
+	SignWithContext(ctx context.Context, cr *x509.CertificateRequest, opts provisioner.Options, signOpts ...provisioner.SignOption) ([]*x509.Certificate, error)
+}
+
 // Identifier encodes the type that an order pertains to.
 type Identifier struct {
 	Type  string `json:"type"`
@@ -33,9 +61,11 @@ var (
 	StatusDeactivated = "deactivated"
 	// StatusReady -- ready; e.g. for an Order that is ready to be finalized.
 	StatusReady = "ready"
+	// StatusProcessing -- processing; e.g. for a Challenge that is being
+	// validated.
+	StatusProcessing = "processing"
 	//statusExpired     = "expired"
 	//statusActive      = "active"
-	//statusProcessing  = "processing"
 )
 
 var idLen = 32
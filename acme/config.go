@@ -0,0 +1,642 @@
+package acme
+
+import (
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/admin"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db/redis"
+)
+
+// Config represents the configuration of the ACME authority and it's mapped to
+// the acme property in the CA configuration.
+type Config struct {
+	HTTP01       *HTTP01Config       `json:"http01,omitempty"`
+	DNS01        *DNS01Config        `json:"dns01,omitempty"`
+	Retry        *RetryConfig        `json:"retry,omitempty"`
+	Nonce        *NonceConfig        `json:"nonce,omitempty"`
+	Cleanup      *CleanupConfig      `json:"cleanup,omitempty"`
+	AutoRenewal  *AutoRenewalConfig  `json:"autoRenewal,omitempty"`
+	Contacts     *ContactsConfig     `json:"contacts,omitempty"`
+	Admin        *admin.Config       `json:"admin,omitempty"`
+	Perspectives *PerspectivesConfig `json:"perspectives,omitempty"`
+	Webhooks     []*WebhookConfig    `json:"webhooks,omitempty"`
+	CSR          *CSRConfig          `json:"csr,omitempty"`
+	AccountCache *AccountCacheConfig `json:"accountCache,omitempty"`
+	Redis        *redis.Options      `json:"redis,omitempty"`
+}
+
+// Validate checks the fields in the Config.
+func (c *Config) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if err := c.HTTP01.Validate(); err != nil {
+		return err
+	}
+	if err := c.DNS01.Validate(); err != nil {
+		return err
+	}
+	if err := c.Retry.Validate(); err != nil {
+		return err
+	}
+	if err := c.Nonce.Validate(); err != nil {
+		return err
+	}
+	if err := c.Cleanup.Validate(); err != nil {
+		return err
+	}
+	if err := c.AutoRenewal.Validate(); err != nil {
+		return err
+	}
+	if err := c.Contacts.Validate(); err != nil {
+		return err
+	}
+	if err := c.Admin.Validate(); err != nil {
+		return errors.Wrap(err, "acme.admin")
+	}
+	if err := c.Perspectives.Validate(); err != nil {
+		return err
+	}
+	for i, w := range c.Webhooks {
+		if err := w.Validate(); err != nil {
+			return errors.Wrapf(err, "acme.webhooks[%d]", i)
+		}
+	}
+	if err := c.CSR.Validate(); err != nil {
+		return err
+	}
+	if err := c.AccountCache.Validate(); err != nil {
+		return err
+	}
+	if err := c.Redis.Validate(); err != nil {
+		return errors.Wrap(err, "acme")
+	}
+	if c.Redis == nil && (c.Nonce.GetStore() == NonceStoreRedis || c.AccountCache.GetStore() == AccountCacheStoreRedis) {
+		return errors.New("acme.redis is required by the redis stores")
+	}
+	return nil
+}
+
+// HTTP01Config contains the options used to connect to the targets of http-01
+// challenges. Port replaces the default port 80, BindAddress is the local IP
+// address used to open the connections, and DialTimeout is the maximum time
+// to wait for a connection to be established. Proxy is the proxy used to reach
+// the targets, it can be replaced by the provisioners, and if it's not set the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are used.
+//
+// The defaults of the redirect and IP family options match the behavior of
+// Let's Encrypt. MaxRedirects is the maximum number of redirects followed, 10
+// by default, and a negative value disables the redirects. Redirects can go
+// to other hosts unless DisableCrossHostRedirects is set, but only to the
+// ports 80 and 443 unless AllowAnyRedirectPort is set. IPv6 addresses are
+// preferred over IPv4 ones unless PreferIPv4 is set, and if the connection to
+// the preferred address fails the other family is tried unless
+// DisableIPFallback is set.
+type HTTP01Config struct {
+	Port                      int                        `json:"port,omitempty"`
+	BindAddress               string                     `json:"bindAddress,omitempty"`
+	DialTimeout               *provisioner.Duration      `json:"dialTimeout,omitempty"`
+	Proxy                     *provisioner.ACMEHTTPProxy `json:"proxy,omitempty"`
+	MaxRedirects              int                        `json:"maxRedirects,omitempty"`
+	DisableCrossHostRedirects bool                       `json:"disableCrossHostRedirects,omitempty"`
+	AllowAnyRedirectPort      bool                       `json:"allowAnyRedirectPort,omitempty"`
+	PreferIPv4                bool                       `json:"preferIPv4,omitempty"`
+	DisableIPFallback         bool                       `json:"disableIPFallback,omitempty"`
+}
+
+// Validate checks the fields in the HTTP01Config.
+func (c *HTTP01Config) Validate() error {
+	switch {
+	case c == nil:
+		return nil
+	case c.Port < 0 || c.Port > 65535:
+		return errors.Errorf("acme.http01.port %d is not a valid port", c.Port)
+	case c.BindAddress != "" && net.ParseIP(c.BindAddress) == nil:
+		return errors.Errorf("acme.http01.bindAddress %s is not a valid IP address", c.BindAddress)
+	case c.DialTimeout != nil && c.DialTimeout.Duration < 0:
+		return errors.New("acme.http01.dialTimeout cannot be less than 0")
+	default:
+		return errors.Wrap(c.Proxy.Validate(), "acme.http01.proxy")
+	}
+}
+
+// DNS01Config contains the options used to validate dns-01 challenges.
+// Resolvers is the list of DNS servers used exclusively for the TXT lookups,
+// plain DNS servers are defined as host:port, DNS over TLS servers as
+// tls://host:port and DNS over HTTPS servers as https://host/path. If empty,
+// the system resolver is used.
+type DNS01Config struct {
+	Resolvers []string `json:"resolvers,omitempty"`
+}
+
+// Validate checks the fields in the DNS01Config.
+func (c *DNS01Config) Validate() error {
+	if c == nil {
+		return nil
+	}
+	for _, s := range c.Resolvers {
+		if _, err := parseResolver(s); err != nil {
+			return errors.Wrap(err, "acme.dns01.resolvers is not valid")
+		}
+	}
+	return nil
+}
+
+var (
+	defaultRetryMaxAttempts = 5
+	defaultRetryBackoff     = 2 * time.Second
+	defaultRetryMaxBackoff  = time.Minute
+)
+
+// RetryConfig contains the options used in the background validation of
+// challenges. A challenge is validated up to MaxAttempts times, waiting
+// Backoff after the first failure and doubling the wait after each following
+// failure up to MaxBackoff.
+type RetryConfig struct {
+	MaxAttempts int                   `json:"maxAttempts,omitempty"`
+	Backoff     *provisioner.Duration `json:"backoff,omitempty"`
+	MaxBackoff  *provisioner.Duration `json:"maxBackoff,omitempty"`
+}
+
+// Validate checks the fields in the RetryConfig.
+func (c *RetryConfig) Validate() error {
+	switch {
+	case c == nil:
+		return nil
+	case c.MaxAttempts < 0:
+		return errors.New("acme.retry.maxAttempts cannot be less than 0")
+	case c.Backoff != nil && c.Backoff.Duration < 0:
+		return errors.New("acme.retry.backoff cannot be less than 0")
+	case c.MaxBackoff != nil && c.MaxBackoff.Duration < 0:
+		return errors.New("acme.retry.maxBackoff cannot be less than 0")
+	default:
+		return nil
+	}
+}
+
+// GetMaxAttempts returns the maximum number of validation attempts.
+func (c *RetryConfig) GetMaxAttempts() int {
+	if c == nil || c.MaxAttempts == 0 {
+		return defaultRetryMaxAttempts
+	}
+	return c.MaxAttempts
+}
+
+// GetBackoff returns the time to wait after the first failed validation.
+func (c *RetryConfig) GetBackoff() time.Duration {
+	if c == nil || c.Backoff == nil {
+		return defaultRetryBackoff
+	}
+	return c.Backoff.Duration
+}
+
+// GetMaxBackoff returns the maximum time to wait between validations.
+func (c *RetryConfig) GetMaxBackoff() time.Duration {
+	if c == nil || c.MaxBackoff == nil {
+		return defaultRetryMaxBackoff
+	}
+	return c.MaxBackoff.Duration
+}
+
+// Nonce stores.
+const (
+	// NonceStoreDB stores the nonces in the database.
+	NonceStoreDB = "db"
+	// NonceStoreMemory stores the nonces in memory.
+	NonceStoreMemory = "memory"
+	// NonceStoreRedis stores the nonces in Redis.
+	NonceStoreRedis = "redis"
+)
+
+var (
+	defaultNonceMaxAge     = time.Hour
+	defaultNonceGCInterval = 15 * time.Minute
+	defaultNonceMaxNonces  = 100000
+)
+
+// NonceConfig contains the options used to manage the ACME nonces. Unused
+// nonces older than MaxAge are rejected, and they are deleted every
+// GCInterval. Store selects where the nonces are kept, "db" (the default),
+// "memory" or "redis". The redis store shares the nonces between the
+// instances of the CA using the acme.redis server, and they expire after
+// MaxAge without the need of a garbage collection. The memory store keeps up to MaxNonces nonces, discarding the
+// oldest ones, and if PersistInterval is set, the changes are written to the
+// database in the background every PersistInterval, and the nonces are loaded
+// from the database on start. The memory store must not be used if multiple
+// instances of the CA share the database.
+type NonceConfig struct {
+	MaxAge          *provisioner.Duration `json:"maxAge,omitempty"`
+	GCInterval      *provisioner.Duration `json:"gcInterval,omitempty"`
+	Store           string                `json:"store,omitempty"`
+	MaxNonces       int                   `json:"maxNonces,omitempty"`
+	PersistInterval *provisioner.Duration `json:"persistInterval,omitempty"`
+}
+
+// Validate checks the fields in the NonceConfig.
+func (c *NonceConfig) Validate() error {
+	switch {
+	case c == nil:
+		return nil
+	case c.MaxAge != nil && c.MaxAge.Duration <= 0:
+		return errors.New("acme.nonce.maxAge must be greater than 0")
+	case c.GCInterval != nil && c.GCInterval.Duration <= 0:
+		return errors.New("acme.nonce.gcInterval must be greater than 0")
+	case c.Store != "" && c.Store != NonceStoreDB && c.Store != NonceStoreMemory && c.Store != NonceStoreRedis:
+		return errors.Errorf("acme.nonce.store %s is not supported", c.Store)
+	case c.MaxNonces < 0:
+		return errors.New("acme.nonce.maxNonces cannot be less than 0")
+	case c.PersistInterval != nil && c.PersistInterval.Duration <= 0:
+		return errors.New("acme.nonce.persistInterval must be greater than 0")
+	case c.GetStore() != NonceStoreMemory && (c.MaxNonces != 0 || c.PersistInterval != nil):
+		return errors.New("acme.nonce.maxNonces and acme.nonce.persistInterval require the memory store")
+	default:
+		return nil
+	}
+}
+
+// GetMaxAge returns the time after which an unused nonce is rejected.
+func (c *NonceConfig) GetMaxAge() time.Duration {
+	if c == nil || c.MaxAge == nil {
+		return defaultNonceMaxAge
+	}
+	return c.MaxAge.Duration
+}
+
+// GetGCInterval returns the interval between the removal of expired nonces.
+func (c *NonceConfig) GetGCInterval() time.Duration {
+	if c == nil || c.GCInterval == nil {
+		return defaultNonceGCInterval
+	}
+	return c.GCInterval.Duration
+}
+
+// GetStore returns the store used to keep the nonces.
+func (c *NonceConfig) GetStore() string {
+	if c == nil || c.Store == "" {
+		return NonceStoreDB
+	}
+	return c.Store
+}
+
+// GetMaxNonces returns the maximum number of nonces in the memory store.
+func (c *NonceConfig) GetMaxNonces() int {
+	if c == nil || c.MaxNonces == 0 {
+		return defaultNonceMaxNonces
+	}
+	return c.MaxNonces
+}
+
+// GetPersistInterval returns the interval between the writes of the memory
+// store to the database, 0 if the persistence is disabled.
+func (c *NonceConfig) GetPersistInterval() time.Duration {
+	if c == nil || c.PersistInterval == nil {
+		return 0
+	}
+	return c.PersistInterval.Duration
+}
+
+var (
+	defaultCleanupRetention = 7 * 24 * time.Hour
+	defaultCleanupInterval  = time.Hour
+)
+
+// CleanupConfig enables the removal of expired orders, authorizations and
+// challenges from the database. Objects are deleted once they have been
+// expired for longer than Retention, and the cleanup runs every Interval. If
+// the cleanup is not configured, expired objects are kept forever.
+type CleanupConfig struct {
+	Retention *provisioner.Duration `json:"retention,omitempty"`
+	Interval  *provisioner.Duration `json:"interval,omitempty"`
+}
+
+// Validate checks the fields in the CleanupConfig.
+func (c *CleanupConfig) Validate() error {
+	switch {
+	case c == nil:
+		return nil
+	case c.Retention != nil && c.Retention.Duration < 0:
+		return errors.New("acme.cleanup.retention cannot be less than 0")
+	case c.Interval != nil && c.Interval.Duration <= 0:
+		return errors.New("acme.cleanup.interval must be greater than 0")
+	default:
+		return nil
+	}
+}
+
+// IsEnabled returns true if the cleanup of expired objects is configured.
+func (c *CleanupConfig) IsEnabled() bool {
+	return c != nil
+}
+
+// GetRetention returns the time expired objects are kept in the database.
+func (c *CleanupConfig) GetRetention() time.Duration {
+	if c == nil || c.Retention == nil {
+		return defaultCleanupRetention
+	}
+	return c.Retention.Duration
+}
+
+// GetInterval returns the interval between the removal of expired objects.
+func (c *CleanupConfig) GetInterval() time.Duration {
+	if c == nil || c.Interval == nil {
+		return defaultCleanupInterval
+	}
+	return c.Interval.Duration
+}
+
+var defaultAutoRenewalInterval = 5 * time.Minute
+
+// AutoRenewalConfig contains the options used to renew the certificates of
+// STAR orders. Interval is the time between the checks for orders that must
+// be renewed.
+type AutoRenewalConfig struct {
+	Interval *provisioner.Duration `json:"interval,omitempty"`
+}
+
+// Validate checks the fields in the AutoRenewalConfig.
+func (c *AutoRenewalConfig) Validate() error {
+	switch {
+	case c == nil:
+		return nil
+	case c.Interval != nil && c.Interval.Duration <= 0:
+		return errors.New("acme.autoRenewal.interval must be greater than 0")
+	default:
+		return nil
+	}
+}
+
+// GetInterval returns the interval between the renewals of STAR orders.
+func (c *AutoRenewalConfig) GetInterval() time.Duration {
+	if c == nil || c.Interval == nil {
+		return defaultAutoRenewalInterval
+	}
+	return c.Interval.Duration
+}
+
+// ContactsConfig enables the validation of the contacts of ACME accounts. If
+// it's configured, contacts must be mailto URLs with a single valid email
+// address, and MaxContacts, if set, limits the number of contacts of an
+// account. If it's not configured, any non-empty contact is accepted.
+type ContactsConfig struct {
+	MaxContacts int `json:"maxContacts,omitempty"`
+}
+
+// Validate checks the fields in the ContactsConfig.
+func (c *ContactsConfig) Validate() error {
+	switch {
+	case c == nil:
+		return nil
+	case c.MaxContacts < 0:
+		return errors.New("acme.contacts.maxContacts cannot be less than 0")
+	default:
+		return nil
+	}
+}
+
+// IsEnabled returns true if the validation of contacts is configured.
+func (c *ContactsConfig) IsEnabled() bool {
+	return c != nil
+}
+
+var defaultPerspectivesTimeout = 30 * time.Second
+
+// PerspectivesConfig enables the validation of http-01 and dns-01 challenges
+// from multiple network perspectives. Once a challenge has been validated
+// locally, the same validation is requested to the remote agents in Agents,
+// and the challenge only becomes valid if at least Quorum of them succeed.
+// Quorum defaults to the number of agents. The agents are reached over mTLS
+// using the client certificate and key in Certificate and Key, and their
+// certificates are verified with the roots in Root, or with the system roots
+// if it's not set. Timeout is the maximum time to wait for the agents.
+type PerspectivesConfig struct {
+	Agents      []string              `json:"agents"`
+	Quorum      int                   `json:"quorum,omitempty"`
+	Root        string                `json:"root,omitempty"`
+	Certificate string                `json:"crt"`
+	Key         string                `json:"key"`
+	Timeout     *provisioner.Duration `json:"timeout,omitempty"`
+}
+
+// Validate checks the fields in the PerspectivesConfig.
+func (c *PerspectivesConfig) Validate() error {
+	switch {
+	case c == nil:
+		return nil
+	case len(c.Agents) == 0:
+		return errors.New("acme.perspectives.agents cannot be empty")
+	case c.Quorum < 0 || c.Quorum > len(c.Agents):
+		return errors.Errorf("acme.perspectives.quorum must be between 0 and %d", len(c.Agents))
+	case c.Certificate == "":
+		return errors.New("acme.perspectives.crt cannot be empty")
+	case c.Key == "":
+		return errors.New("acme.perspectives.key cannot be empty")
+	case c.Timeout != nil && c.Timeout.Duration <= 0:
+		return errors.New("acme.perspectives.timeout must be greater than 0")
+	default:
+		for _, s := range c.Agents {
+			u, err := url.Parse(s)
+			if err != nil || u.Scheme != "https" || u.Host == "" {
+				return errors.Errorf("acme.perspectives.agents %s is not a valid https URL", s)
+			}
+		}
+		return nil
+	}
+}
+
+// IsEnabled returns true if the multi-perspective validation is configured.
+func (c *PerspectivesConfig) IsEnabled() bool {
+	return c != nil
+}
+
+// GetQuorum returns the number of agents that must validate a challenge.
+func (c *PerspectivesConfig) GetQuorum() int {
+	if c == nil {
+		return 0
+	}
+	if c.Quorum == 0 {
+		return len(c.Agents)
+	}
+	return c.Quorum
+}
+
+// GetTimeout returns the maximum time to wait for the agents.
+func (c *PerspectivesConfig) GetTimeout() time.Duration {
+	if c == nil || c.Timeout == nil {
+		return defaultPerspectivesTimeout
+	}
+	return c.Timeout.Duration
+}
+
+var (
+	defaultWebhookMaxAttempts = 3
+	defaultWebhookTimeout     = 10 * time.Second
+)
+
+// WebhookConfig configures a webhook that receives the events of the ACME
+// authority. Events are sent as JSON POST requests to URL, and the request
+// body is signed with HMAC-SHA256 using Secret. Events is the list of event
+// types sent to the webhook, if empty all the events are sent. Failed
+// deliveries are attempted up to MaxAttempts times with an exponential
+// backoff, and Timeout is the maximum time to wait for each attempt.
+type WebhookConfig struct {
+	URL         string                `json:"url"`
+	Secret      string                `json:"secret"`
+	Events      []string              `json:"events,omitempty"`
+	MaxAttempts int                   `json:"maxAttempts,omitempty"`
+	Timeout     *provisioner.Duration `json:"timeout,omitempty"`
+}
+
+// Validate checks the fields in the WebhookConfig.
+func (c *WebhookConfig) Validate() error {
+	switch {
+	case c == nil:
+		return errors.New("webhook cannot be empty")
+	case c.URL == "":
+		return errors.New("url cannot be empty")
+	case c.Secret == "":
+		return errors.New("secret cannot be empty")
+	case c.MaxAttempts < 0:
+		return errors.New("maxAttempts cannot be less than 0")
+	case c.Timeout != nil && c.Timeout.Duration <= 0:
+		return errors.New("timeout must be greater than 0")
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.Errorf("url %s is not a valid http or https URL", c.URL)
+	}
+	for _, e := range c.Events {
+		if !isWebhookEvent(e) {
+			return errors.Errorf("events contains an unsupported value %s", e)
+		}
+	}
+	return nil
+}
+
+// IsSubscribed returns true if the given event type must be sent to the
+// webhook.
+func (c *WebhookConfig) IsSubscribed(event string) bool {
+	if len(c.Events) == 0 {
+		return true
+	}
+	for _, e := range c.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// GetMaxAttempts returns the maximum number of delivery attempts.
+func (c *WebhookConfig) GetMaxAttempts() int {
+	if c == nil || c.MaxAttempts == 0 {
+		return defaultWebhookMaxAttempts
+	}
+	return c.MaxAttempts
+}
+
+// GetTimeout returns the maximum time to wait for a delivery attempt.
+func (c *WebhookConfig) GetTimeout() time.Duration {
+	if c == nil || c.Timeout == nil {
+		return defaultWebhookTimeout
+	}
+	return c.Timeout.Duration
+}
+
+// CSRConfig contains the additional checks performed on the CSRs used to
+// finalize orders. ForbiddenExtensions is the list of OIDs of the extensions
+// that cannot be requested in the CSR. If StrictNames is set, the CSR cannot
+// contain IP addresses, email addresses or URIs, only the identifiers of the
+// order. Empty values disable the corresponding check. The public keys and
+// signature algorithms are checked by the crypto policy of the authority.
+type CSRConfig struct {
+	ForbiddenExtensions []string `json:"forbiddenExtensions,omitempty"`
+	StrictNames         bool     `json:"strictNames,omitempty"`
+}
+
+// Validate checks the fields in the CSRConfig.
+func (c *CSRConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	for _, oid := range c.ForbiddenExtensions {
+		if _, err := parseOID(oid); err != nil {
+			return errors.Wrap(err, "acme.csr.forbiddenExtensions is not valid")
+		}
+	}
+	return nil
+}
+
+var (
+	defaultAccountCacheMaxAccounts = 10000
+	defaultAccountCacheTTL         = time.Minute
+)
+
+// Account cache stores.
+const (
+	// AccountCacheStoreMemory caches the accounts in memory.
+	AccountCacheStoreMemory = "memory"
+	// AccountCacheStoreRedis caches the accounts in Redis.
+	AccountCacheStoreRedis = "redis"
+)
+
+// AccountCacheConfig enables a cache of the ACME accounts used to
+// authenticate the requests. Store selects where the accounts are cached,
+// "memory" (the default) or "redis". MaxAccounts is the maximum number of
+// accounts in the memory cache, 10000 by default, and TTL is the time an
+// account is kept in the cache, 1 minute by default. The cache is invalidated
+// when an account is updated or deactivated. The memory cache is only
+// invalidated in the instance that makes the change; if multiple instances of
+// the CA share the database, the TTL limits the time the others can use a
+// stale account. The redis cache is shared by all the instances using the
+// acme.redis server.
+type AccountCacheConfig struct {
+	Store       string                `json:"store,omitempty"`
+	MaxAccounts int                   `json:"maxAccounts,omitempty"`
+	TTL         *provisioner.Duration `json:"ttl,omitempty"`
+}
+
+// Validate checks the fields in the AccountCacheConfig.
+func (c *AccountCacheConfig) Validate() error {
+	switch {
+	case c == nil:
+		return nil
+	case c.Store != "" && c.Store != AccountCacheStoreMemory && c.Store != AccountCacheStoreRedis:
+		return errors.Errorf("acme.accountCache.store %s is not supported", c.Store)
+	case c.MaxAccounts < 0:
+		return errors.New("acme.accountCache.maxAccounts cannot be less than 0")
+	case c.TTL != nil && c.TTL.Duration <= 0:
+		return errors.New("acme.accountCache.ttl must be greater than 0")
+	case c.GetStore() != AccountCacheStoreMemory && c.MaxAccounts != 0:
+		return errors.New("acme.accountCache.maxAccounts requires the memory store")
+	default:
+		return nil
+	}
+}
+
+// GetStore returns the store used to cache the accounts.
+func (c *AccountCacheConfig) GetStore() string {
+	if c == nil || c.Store == "" {
+		return AccountCacheStoreMemory
+	}
+	return c.Store
+}
+
+// GetMaxAccounts returns the maximum number of cached accounts.
+func (c *AccountCacheConfig) GetMaxAccounts() int {
+	if c == nil || c.MaxAccounts == 0 {
+		return defaultAccountCacheMaxAccounts
+	}
+	return c.MaxAccounts
+}
+
+// GetTTL returns the time an account is kept in the cache.
+func (c *AccountCacheConfig) GetTTL() time.Duration {
+	if c == nil || c.TTL == nil {
+		return defaultAccountCacheTTL
+	}
+	return c.TTL.Duration
+}
@@ -0,0 +1,261 @@
+package acme
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/admin"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db/redis"
+)
+
+func TestConfigValidate(t *testing.T) {
+	type test struct {
+		config *Config
+		err    error
+	}
+	tests := map[string]test{
+		"ok/nil":        {config: nil},
+		"ok/empty":      {config: &Config{}},
+		"ok/nil-http01": {config: &Config{HTTP01: nil}},
+		"ok/http01": {config: &Config{HTTP01: &HTTP01Config{
+			Port:                      8080,
+			BindAddress:               "10.0.0.1",
+			DialTimeout:               &provisioner.Duration{Duration: 10 * time.Second},
+			Proxy:                     &provisioner.ACMEHTTPProxy{URL: "http://proxy.internal:3128"},
+			MaxRedirects:              -1,
+			DisableCrossHostRedirects: true,
+			AllowAnyRedirectPort:      true,
+			PreferIPv4:                true,
+			DisableIPFallback:         true,
+		}}},
+		"ok/dns01": {config: &Config{DNS01: &DNS01Config{
+			Resolvers: []string{"10.0.0.53:53", "tls://dns.internal", "https://dns.internal/dns-query"},
+		}}},
+		"fail/dns01": {
+			config: &Config{DNS01: &DNS01Config{Resolvers: []string{"quic://dns.internal"}}},
+			err:    errors.New("acme.dns01.resolvers is not valid: error parsing resolver quic://dns.internal: unsupported scheme quic"),
+		},
+		"ok/nonce": {config: &Config{Nonce: &NonceConfig{
+			MaxAge:     &provisioner.Duration{Duration: time.Hour},
+			GCInterval: &provisioner.Duration{Duration: time.Minute},
+		}}},
+		"fail/nonce-maxAge": {
+			config: &Config{Nonce: &NonceConfig{MaxAge: &provisioner.Duration{Duration: 0}}},
+			err:    errors.New("acme.nonce.maxAge must be greater than 0"),
+		},
+		"fail/nonce-gcInterval": {
+			config: &Config{Nonce: &NonceConfig{GCInterval: &provisioner.Duration{Duration: -time.Minute}}},
+			err:    errors.New("acme.nonce.gcInterval must be greater than 0"),
+		},
+		"ok/nonce-memory": {config: &Config{Nonce: &NonceConfig{
+			Store:           NonceStoreMemory,
+			MaxNonces:       1000,
+			PersistInterval: &provisioner.Duration{Duration: time.Second},
+		}}},
+		"fail/nonce-store": {
+			config: &Config{Nonce: &NonceConfig{Store: "foo"}},
+			err:    errors.New("acme.nonce.store foo is not supported"),
+		},
+		"ok/nonce-redis": {config: &Config{
+			Nonce: &NonceConfig{Store: NonceStoreRedis},
+			Redis: &redis.Options{Address: "localhost:6379"},
+		}},
+		"fail/nonce-redis": {
+			config: &Config{Nonce: &NonceConfig{Store: NonceStoreRedis}},
+			err:    errors.New("acme.redis is required by the redis stores"),
+		},
+		"fail/redis": {
+			config: &Config{Redis: &redis.Options{}},
+			err:    errors.New("acme: redis.address cannot be empty"),
+		},
+		"fail/nonce-maxNonces": {
+			config: &Config{Nonce: &NonceConfig{Store: NonceStoreMemory, MaxNonces: -1}},
+			err:    errors.New("acme.nonce.maxNonces cannot be less than 0"),
+		},
+		"fail/nonce-persistInterval": {
+			config: &Config{Nonce: &NonceConfig{Store: NonceStoreMemory, PersistInterval: &provisioner.Duration{}}},
+			err:    errors.New("acme.nonce.persistInterval must be greater than 0"),
+		},
+		"fail/nonce-db-maxNonces": {
+			config: &Config{Nonce: &NonceConfig{Store: NonceStoreDB, MaxNonces: 10}},
+			err:    errors.New("acme.nonce.maxNonces and acme.nonce.persistInterval require the memory store"),
+		},
+		"ok/cleanup": {config: &Config{Cleanup: &CleanupConfig{
+			Retention: &provisioner.Duration{Duration: 0},
+			Interval:  &provisioner.Duration{Duration: time.Hour},
+		}}},
+		"fail/cleanup-retention": {
+			config: &Config{Cleanup: &CleanupConfig{Retention: &provisioner.Duration{Duration: -time.Hour}}},
+			err:    errors.New("acme.cleanup.retention cannot be less than 0"),
+		},
+		"fail/cleanup-interval": {
+			config: &Config{Cleanup: &CleanupConfig{Interval: &provisioner.Duration{Duration: 0}}},
+			err:    errors.New("acme.cleanup.interval must be greater than 0"),
+		},
+		"fail/autoRenewal-interval": {
+			config: &Config{AutoRenewal: &AutoRenewalConfig{Interval: &provisioner.Duration{Duration: 0}}},
+			err:    errors.New("acme.autoRenewal.interval must be greater than 0"),
+		},
+		"fail/contacts-maxContacts": {
+			config: &Config{Contacts: &ContactsConfig{MaxContacts: -1}},
+			err:    errors.New("acme.contacts.maxContacts cannot be less than 0"),
+		},
+		"ok/admin": {config: &Config{Admin: &admin.Config{Admins: []*admin.Admin{{Subject: "admin@example.com", Provisioner: "admin", ProvisionerType: "JWK"}}}}},
+		"fail/admin-empty": {
+			config: &Config{Admin: &admin.Config{}},
+			err:    errors.New("acme.admin: admins cannot be empty"),
+		},
+		"fail/admin-subject": {
+			config: &Config{Admin: &admin.Config{Admins: []*admin.Admin{{Subject: "admin@example.com"}}}},
+			err:    errors.New("acme.admin: admins[0]: provisioner is required with subject"),
+		},
+		"ok/perspectives": {config: &Config{Perspectives: &PerspectivesConfig{
+			Agents:      []string{"https://agent1.internal/validate", "https://agent2.internal/validate"},
+			Quorum:      1,
+			Certificate: "client.crt",
+			Key:         "client.key",
+			Timeout:     &provisioner.Duration{Duration: 10 * time.Second},
+		}}},
+		"fail/perspectives-agents": {
+			config: &Config{Perspectives: &PerspectivesConfig{Certificate: "client.crt", Key: "client.key"}},
+			err:    errors.New("acme.perspectives.agents cannot be empty"),
+		},
+		"fail/perspectives-agent-url": {
+			config: &Config{Perspectives: &PerspectivesConfig{
+				Agents: []string{"http://agent.internal"}, Certificate: "client.crt", Key: "client.key",
+			}},
+			err: errors.New("acme.perspectives.agents http://agent.internal is not a valid https URL"),
+		},
+		"fail/perspectives-quorum": {
+			config: &Config{Perspectives: &PerspectivesConfig{
+				Agents: []string{"https://agent.internal"}, Quorum: 2, Certificate: "client.crt", Key: "client.key",
+			}},
+			err: errors.New("acme.perspectives.quorum must be between 0 and 1"),
+		},
+		"fail/perspectives-crt": {
+			config: &Config{Perspectives: &PerspectivesConfig{Agents: []string{"https://agent.internal"}, Key: "client.key"}},
+			err:    errors.New("acme.perspectives.crt cannot be empty"),
+		},
+		"fail/perspectives-key": {
+			config: &Config{Perspectives: &PerspectivesConfig{Agents: []string{"https://agent.internal"}, Certificate: "client.crt"}},
+			err:    errors.New("acme.perspectives.key cannot be empty"),
+		},
+		"fail/perspectives-timeout": {
+			config: &Config{Perspectives: &PerspectivesConfig{
+				Agents: []string{"https://agent.internal"}, Certificate: "client.crt", Key: "client.key",
+				Timeout: &provisioner.Duration{Duration: 0},
+			}},
+			err: errors.New("acme.perspectives.timeout must be greater than 0"),
+		},
+		"ok/webhooks": {config: &Config{Webhooks: []*WebhookConfig{
+			{URL: "https://cmdb.internal/acme", Secret: "secret"},
+			{URL: "http://siem.internal/events", Secret: "secret", Events: []string{WebhookOrderFinalized, WebhookCertificateDownloaded},
+				MaxAttempts: 5, Timeout: &provisioner.Duration{Duration: time.Second}},
+		}}},
+		"ok/csr": {config: &Config{CSR: &CSRConfig{
+			ForbiddenExtensions: []string{"2.5.29.19"},
+			StrictNames:         true,
+		}}},
+		"fail/csr-forbiddenExtensions": {
+			config: &Config{CSR: &CSRConfig{ForbiddenExtensions: []string{"2.5.x"}}},
+			err:    errors.New("acme.csr.forbiddenExtensions is not valid: error parsing OID 2.5.x"),
+		},
+		"ok/accountCache": {config: &Config{AccountCache: &AccountCacheConfig{
+			MaxAccounts: 1000,
+			TTL:         &provisioner.Duration{Duration: 5 * time.Minute},
+		}}},
+		"fail/accountCache-maxAccounts": {
+			config: &Config{AccountCache: &AccountCacheConfig{MaxAccounts: -1}},
+			err:    errors.New("acme.accountCache.maxAccounts cannot be less than 0"),
+		},
+		"ok/accountCache-redis": {config: &Config{
+			AccountCache: &AccountCacheConfig{Store: AccountCacheStoreRedis},
+			Redis:        &redis.Options{Address: "localhost:6379"},
+		}},
+		"fail/accountCache-store": {
+			config: &Config{AccountCache: &AccountCacheConfig{Store: "foo"}},
+			err:    errors.New("acme.accountCache.store foo is not supported"),
+		},
+		"fail/accountCache-redis": {
+			config: &Config{AccountCache: &AccountCacheConfig{Store: AccountCacheStoreRedis}},
+			err:    errors.New("acme.redis is required by the redis stores"),
+		},
+		"fail/accountCache-redis-maxAccounts": {
+			config: &Config{
+				AccountCache: &AccountCacheConfig{Store: AccountCacheStoreRedis, MaxAccounts: 10},
+				Redis:        &redis.Options{Address: "localhost:6379"},
+			},
+			err: errors.New("acme.accountCache.maxAccounts requires the memory store"),
+		},
+		"fail/accountCache-ttl": {
+			config: &Config{AccountCache: &AccountCacheConfig{TTL: &provisioner.Duration{}}},
+			err:    errors.New("acme.accountCache.ttl must be greater than 0"),
+		},
+		"fail/webhooks-nil": {
+			config: &Config{Webhooks: []*WebhookConfig{nil}},
+			err:    errors.New("acme.webhooks[0]: webhook cannot be empty"),
+		},
+		"fail/webhooks-url": {
+			config: &Config{Webhooks: []*WebhookConfig{{Secret: "secret"}}},
+			err:    errors.New("acme.webhooks[0]: url cannot be empty"),
+		},
+		"fail/webhooks-url-scheme": {
+			config: &Config{Webhooks: []*WebhookConfig{{URL: "ftp://cmdb.internal", Secret: "secret"}}},
+			err:    errors.New("acme.webhooks[0]: url ftp://cmdb.internal is not a valid http or https URL"),
+		},
+		"fail/webhooks-secret": {
+			config: &Config{Webhooks: []*WebhookConfig{{URL: "https://cmdb.internal"}}},
+			err:    errors.New("acme.webhooks[0]: secret cannot be empty"),
+		},
+		"fail/webhooks-events": {
+			config: &Config{Webhooks: []*WebhookConfig{{URL: "https://cmdb.internal", Secret: "secret", Events: []string{"order.deleted"}}}},
+			err:    errors.New("acme.webhooks[0]: events contains an unsupported value order.deleted"),
+		},
+		"fail/webhooks-maxAttempts": {
+			config: &Config{Webhooks: []*WebhookConfig{{URL: "https://cmdb.internal", Secret: "secret", MaxAttempts: -1}}},
+			err:    errors.New("acme.webhooks[0]: maxAttempts cannot be less than 0"),
+		},
+		"fail/webhooks-timeout": {
+			config: &Config{Webhooks: []*WebhookConfig{{URL: "https://cmdb.internal", Secret: "secret", Timeout: &provisioner.Duration{}}}},
+			err:    errors.New("acme.webhooks[0]: timeout must be greater than 0"),
+		},
+		"fail/retry-maxAttempts": {
+			config: &Config{Retry: &RetryConfig{MaxAttempts: -1}},
+			err:    errors.New("acme.retry.maxAttempts cannot be less than 0"),
+		},
+		"fail/port": {
+			config: &Config{HTTP01: &HTTP01Config{Port: 65536}},
+			err:    errors.New("acme.http01.port 65536 is not a valid port"),
+		},
+		"fail/negative-port": {
+			config: &Config{HTTP01: &HTTP01Config{Port: -1}},
+			err:    errors.New("acme.http01.port -1 is not a valid port"),
+		},
+		"fail/bindAddress": {
+			config: &Config{HTTP01: &HTTP01Config{BindAddress: "eth0"}},
+			err:    errors.New("acme.http01.bindAddress eth0 is not a valid IP address"),
+		},
+		"fail/proxy": {
+			config: &Config{HTTP01: &HTTP01Config{Proxy: &provisioner.ACMEHTTPProxy{URL: "proxy.internal:3128"}}},
+			err:    errors.New("acme.http01.proxy: url proxy.internal:3128 must use the http, https or socks5 scheme"),
+		},
+		"fail/dialTimeout": {
+			config: &Config{HTTP01: &HTTP01Config{DialTimeout: &provisioner.Duration{Duration: -time.Second}}},
+			err:    errors.New("acme.http01.dialTimeout cannot be less than 0"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tc.config.Validate(); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package acme
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// parseOID parses an object identifier in dotted notation.
+func parseOID(s string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 {
+		return nil, errors.Errorf("error parsing OID %s", s)
+	}
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, errors.Errorf("error parsing OID %s", s)
+		}
+		oid[i] = n
+	}
+	return oid, nil
+}
+
+// checkCSR checks the CSR used to finalize an order against the configured
+// policy. A nil policy accepts all the CSRs. The public key and signature
+// algorithm are checked by the crypto policy of the authority.
+func (c *CSRConfig) checkCSR(csr *x509.CertificateRequest) error {
+	if c == nil {
+		return nil
+	}
+	for _, ext := range csr.Extensions {
+		if containsString(c.ForbiddenExtensions, ext.Id.String()) {
+			return BadCSRErr(errors.Errorf("CSR cannot request the extension %s", ext.Id))
+		}
+	}
+	if c.StrictNames {
+		switch {
+		case len(csr.IPAddresses) > 0:
+			return BadCSRErr(errors.Errorf("CSR cannot contain IP addresses: %v", csr.IPAddresses))
+		case len(csr.EmailAddresses) > 0:
+			return BadCSRErr(errors.Errorf("CSR cannot contain email addresses: %v", csr.EmailAddresses))
+		case len(csr.URIs) > 0:
+			return BadCSRErr(errors.Errorf("CSR cannot contain URIs: %v", csr.URIs))
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+)
+
+func TestCSRConfigCheckCSR(t *testing.T) {
+	newCSR := func(t *testing.T, key interface{}, tmpl *x509.CertificateRequest) *x509.CertificateRequest {
+		t.Helper()
+		if tmpl == nil {
+			tmpl = &x509.CertificateRequest{}
+		}
+		tmpl.Subject = pkix.Name{CommonName: "acme.example.com"}
+		tmpl.DNSNames = []string{"acme.example.com"}
+		der, err := x509.CreateCertificateRequest(rand.Reader, tmpl, key)
+		assert.FatalError(t, err)
+		csr, err := x509.ParseCertificateRequest(der)
+		assert.FatalError(t, err)
+		return csr
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.FatalError(t, err)
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.FatalError(t, err)
+
+	type test struct {
+		config *CSRConfig
+		csr    *x509.CertificateRequest
+		err    *Error
+	}
+	tests := map[string]test{
+		"ok/nil": {
+			csr: newCSR(t, rsaKey, nil),
+		},
+		"ok/empty": {
+			config: &CSRConfig{},
+			csr:    newCSR(t, p256, &x509.CertificateRequest{IPAddresses: []net.IP{net.ParseIP("10.0.0.1")}}),
+		},
+		"ok/all": {
+			config: &CSRConfig{
+				ForbiddenExtensions: []string{"2.5.29.19"},
+				StrictNames:         true,
+			},
+			csr: newCSR(t, edKey, nil),
+		},
+		"fail/forbidden-extension": {
+			config: &CSRConfig{ForbiddenExtensions: []string{"2.5.29.19"}},
+			csr: newCSR(t, p256, &x509.CertificateRequest{ExtraExtensions: []pkix.Extension{
+				{Id: asn1.ObjectIdentifier{2, 5, 29, 19}, Critical: true, Value: []byte{0x30, 0x03, 0x01, 0x01, 0xff}},
+			}}),
+			err: BadCSRErr(errors.New("CSR cannot request the extension 2.5.29.19")),
+		},
+		"fail/strict-ips": {
+			config: &CSRConfig{StrictNames: true},
+			csr:    newCSR(t, p256, &x509.CertificateRequest{IPAddresses: []net.IP{net.ParseIP("10.0.0.1")}}),
+			err:    BadCSRErr(errors.New("CSR cannot contain IP addresses: [10.0.0.1]")),
+		},
+		"fail/strict-emails": {
+			config: &CSRConfig{StrictNames: true},
+			csr:    newCSR(t, p256, &x509.CertificateRequest{EmailAddresses: []string{"admin@example.com"}}),
+			err:    BadCSRErr(errors.New("CSR cannot contain email addresses: [admin@example.com]")),
+		},
+		"fail/strict-uris": {
+			config: &CSRConfig{StrictNames: true},
+			csr: newCSR(t, p256, &x509.CertificateRequest{URIs: []*url.URL{
+				{Scheme: "spiffe", Host: "example.com", Path: "/acme"},
+			}}),
+			err: BadCSRErr(errors.New("CSR cannot contain URIs: [spiffe://example.com/acme]")),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.config.checkCSR(tc.csr)
+			if tc.err == nil {
+				assert.Nil(t, err)
+			} else {
+				assertAcmeError(t, tc.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,353 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/tpm"
+	"github.com/smallstep/cli/jose"
+	"github.com/smallstep/nosql"
+)
+
+var (
+	oidAppleSerialNumber           = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 8, 9, 1}
+	oidAppleUniqueDeviceIdentifier = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 8, 9, 2}
+	oidAppleNonce                  = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 8, 11, 1}
+	oidYubicoSerialNumber          = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 7}
+)
+
+// attestationOptions contains the data used to validate a device-attest-01
+// challenge: the payload of the client request, the roots used to verify the
+// attestation certificates, and the function that checks if a statement
+// format is enabled.
+type attestationOptions struct {
+	payload         []byte
+	roots           *x509.CertPool
+	isFormatEnabled func(string) bool
+}
+
+// deviceAttest01Challenge represents a device-attest-01 acme challenge.
+type deviceAttest01Challenge struct {
+	*baseChallenge
+}
+
+// newDeviceAttest01Challenge returns a new acme device-attest-01 challenge.
+func newDeviceAttest01Challenge(db nosql.DB, ops ChallengeOptions) (challenge, error) {
+	bc, err := newBaseChallenge(ops.AccountID, ops.AuthzID)
+	if err != nil {
+		return nil, err
+	}
+	bc.Type = "device-attest-01"
+	bc.Value = ops.Identifier.Value
+
+	dc := &deviceAttest01Challenge{bc}
+	if err := dc.save(db, nil); err != nil {
+		return nil, err
+	}
+	return dc, nil
+}
+
+// validate verifies the attestation statement sent by the client. Unlike the
+// other challenge types, the validation is not retried, a statement that
+// cannot be verified makes the challenge invalid.
+func (dc *deviceAttest01Challenge) validate(db nosql.DB, jwk *jose.JSONWebKey, vo validateOptions) (challenge, error) {
+	// If already valid or invalid then return without performing validation.
+	if dc.getStatus() == StatusValid || dc.getStatus() == StatusInvalid {
+		return dc, nil
+	}
+	if vo.attestation == nil {
+		return nil, ServerInternalErr(errors.New("attestation options are required to validate device-attest-01 challenges"))
+	}
+
+	var payload struct {
+		AttObj string `json:"attObj"`
+	}
+	if err := json.Unmarshal(vo.attestation.payload, &payload); err != nil || payload.AttObj == "" {
+		return dc.fail(db, MalformedErr(errors.New("payload must contain an attObj")))
+	}
+	attObj, err := base64.RawURLEncoding.DecodeString(payload.AttObj)
+	if err != nil {
+		return dc.fail(db, MalformedErr(errors.Wrap(err, "error base64url decoding attObj")))
+	}
+
+	keyAuth, err := KeyAuthorization(dc.Token, jwk)
+	if err != nil {
+		return nil, err
+	}
+	att, err := verifyAttestation(attObj, dc.Token, keyAuth, vo.attestation)
+	if err != nil {
+		return dc.fail(db, BadAttestationStatementErr(err))
+	}
+	fingerprint, err := attestedKeyFingerprint(att.key)
+	if err != nil {
+		return dc.fail(db, BadAttestationStatementErr(err))
+	}
+	ids := att.ids
+	var found bool
+	for _, id := range ids {
+		if id == dc.Value {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return dc.fail(db, RejectedIdentifierErr(errors.Errorf("permanent identifier does not match; "+
+			"expected %s, but got %v", dc.Value, ids)))
+	}
+
+	// Update and store the challenge with the fingerprint of the attested
+	// key, the CSR of the order must use the same key.
+	upd := &deviceAttest01Challenge{dc.baseChallenge.clone()}
+	upd.Status = StatusValid
+	upd.Error = nil
+	upd.Validated = clock.Now()
+	upd.Fingerprint = fingerprint
+
+	if err := upd.save(db, dc); err != nil {
+		return nil, err
+	}
+	return upd, nil
+}
+
+// attestedKeyFingerprint returns the hex encoded SHA-256 of the
+// SubjectPublicKeyInfo of the attested key.
+func attestedKeyFingerprint(key crypto.PublicKey) (string, error) {
+	spki, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", errors.Wrap(err, "error marshaling attested key")
+	}
+	return string(keyFingerprint(spki)), nil
+}
+
+// fail marks the challenge as invalid with the given error.
+func (dc *deviceAttest01Challenge) fail(db nosql.DB, err *Error) (challenge, error) {
+	upd := &deviceAttest01Challenge{dc.baseChallenge.clone()}
+	upd.Status = StatusInvalid
+	upd.Error = err.ToACME()
+	if err := upd.save(db, dc); err != nil {
+		return nil, err
+	}
+	return upd, nil
+}
+
+// attestationObject is the CBOR attestation object sent by the client, as in
+// the WebAuthn attestation objects.
+type attestationObject struct {
+	Format       string                 `cbor:"fmt"`
+	AttStatement map[string]interface{} `cbor:"attStmt"`
+}
+
+// attestedKey is the result of a verified attestation statement: the
+// permanent identifiers of the device and the attested public key.
+type attestedKey struct {
+	ids []string
+	key crypto.PublicKey
+}
+
+// verifyAttestation verifies the given CBOR attestation object and returns
+// the permanent identifiers of the attested device and the attested key.
+func verifyAttestation(attObj []byte, token, keyAuth string, ao *attestationOptions) (*attestedKey, error) {
+	var obj attestationObject
+	if err := cbor.Unmarshal(attObj, &obj); err != nil {
+		return nil, errors.Wrap(err, "error decoding attestation object")
+	}
+	if obj.Format == "" {
+		return nil, errors.New("attestation object does not contain a valid fmt")
+	}
+	if obj.AttStatement == nil {
+		return nil, errors.New("attestation object does not contain a valid attStmt")
+	}
+	if !ao.isFormatEnabled(obj.Format) {
+		return nil, errors.Errorf("attestation format %s is not enabled", obj.Format)
+	}
+
+	switch obj.Format {
+	case "apple":
+		return verifyAppleAttestation(obj.AttStatement, token, ao.roots)
+	case "step":
+		return verifyStepAttestation(obj.AttStatement, keyAuth, ao.roots)
+	case "tpm":
+		return verifyTPMAttestation(obj.AttStatement, keyAuth, ao.roots)
+	default:
+		return nil, errors.Errorf("unsupported attestation format %s", obj.Format)
+	}
+}
+
+// attestationAlg returns the COSE algorithm of the statement. CBOR decodes
+// the negative integers as int64 and the positive ones as uint64.
+func attestationAlg(attStmt map[string]interface{}) int64 {
+	switch v := attStmt["alg"].(type) {
+	case int64:
+		return v
+	case uint64:
+		if v <= 1<<63-1 {
+			return int64(v)
+		}
+	}
+	return 0
+}
+
+// verifyAppleAttestation verifies an attestation statement of an Apple
+// device. The nonce in the leaf certificate must be the SHA-256 of the
+// challenge token.
+func verifyAppleAttestation(attStmt map[string]interface{}, token string, roots *x509.CertPool) (*attestedKey, error) {
+	leaf, err := verifyAttestationChain(attStmt, roots)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	var nonce []byte
+	for _, ext := range leaf.Extensions {
+		switch {
+		case ext.Id.Equal(oidAppleSerialNumber), ext.Id.Equal(oidAppleUniqueDeviceIdentifier):
+			ids = append(ids, string(ext.Value))
+		case ext.Id.Equal(oidAppleNonce):
+			nonce = ext.Value
+		}
+	}
+	sum := sha256.Sum256([]byte(token))
+	if subtle.ConstantTimeCompare(nonce, sum[:]) != 1 {
+		return nil, errors.New("apple attestation nonce does not match")
+	}
+	return &attestedKey{ids: ids, key: leaf.PublicKey}, nil
+}
+
+// verifyStepAttestation verifies an attestation statement of a YubiKey. The
+// attested key must sign the key authorization.
+func verifyStepAttestation(attStmt map[string]interface{}, keyAuth string, roots *x509.CertPool) (*attestedKey, error) {
+	leaf, err := verifyAttestationChain(attStmt, roots)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyAttestationSignature(attStmt, leaf, []byte(keyAuth)); err != nil {
+		return nil, err
+	}
+	for _, ext := range leaf.Extensions {
+		if ext.Id.Equal(oidYubicoSerialNumber) {
+			var serial int64
+			if _, err := asn1.Unmarshal(ext.Value, &serial); err != nil {
+				return nil, errors.Wrap(err, "error parsing yubikey serial number")
+			}
+			return &attestedKey{ids: []string{strconv.FormatInt(serial, 10)}, key: leaf.PublicKey}, nil
+		}
+	}
+	return nil, errors.New("step attestation certificate does not contain a serial number")
+}
+
+// verifyTPMAttestation verifies an attestation statement of a TPM 2.0. The
+// certInfo structure must be signed by the attestation key, certify the key
+// in pubArea, and contain the SHA-256 of the key authorization.
+func verifyTPMAttestation(attStmt map[string]interface{}, keyAuth string, roots *x509.CertPool) (*attestedKey, error) {
+	stmt := &tpm.AttestationStatement{}
+	stmt.Version, _ = attStmt["ver"].(string)
+	stmt.Alg = attestationAlg(attStmt)
+	x5c, ok := attStmt["x5c"].([]interface{})
+	if !ok {
+		return nil, errors.New("attestation statement does not contain a valid x5c")
+	}
+	for _, v := range x5c {
+		der, ok := v.([]byte)
+		if !ok {
+			return nil, errors.New("attestation statement does not contain a valid x5c")
+		}
+		stmt.X5C = append(stmt.X5C, der)
+	}
+	if stmt.CertInfo, ok = attStmt["certInfo"].([]byte); !ok {
+		return nil, errors.New("tpm attestation does not contain a valid certInfo")
+	}
+	if stmt.PubArea, ok = attStmt["pubArea"].([]byte); !ok {
+		return nil, errors.New("tpm attestation does not contain a valid pubArea")
+	}
+	if stmt.Sig, ok = attStmt["sig"].([]byte); !ok {
+		return nil, errors.New("attestation statement does not contain a valid sig")
+	}
+
+	sum := sha256.Sum256([]byte(keyAuth))
+	att, err := stmt.Verify(tpm.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: clock.Now(),
+		ExtraData:   sum[:],
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(att.PermanentIdentifiers) == 0 {
+		return nil, errors.New("tpm attestation certificate does not contain a permanent identifier")
+	}
+	key, err := tpm.ParsePublic(att.PubArea)
+	if err != nil {
+		return nil, err
+	}
+	return &attestedKey{ids: att.PermanentIdentifiers, key: key}, nil
+}
+
+// verifyAttestationChain parses the x5c certificates of the statement and
+// verifies them against the roots. It returns the leaf certificate.
+func verifyAttestationChain(attStmt map[string]interface{}, roots *x509.CertPool) (*x509.Certificate, error) {
+	x5c, ok := attStmt["x5c"].([]interface{})
+	if !ok || len(x5c) == 0 {
+		return nil, errors.New("attestation statement does not contain a valid x5c")
+	}
+	certs := make([]*x509.Certificate, len(x5c))
+	for i, v := range x5c {
+		der, ok := v.([]byte)
+		if !ok {
+			return nil, errors.New("attestation statement does not contain a valid x5c")
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing x5c certificate")
+		}
+		certs[i] = cert
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   clock.Now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, errors.Wrap(err, "error verifying x5c certificate chain")
+	}
+	return certs[0], nil
+}
+
+// verifyAttestationSignature verifies the sig of the statement over the given
+// data using the alg of the statement and the key of the leaf certificate.
+func verifyAttestationSignature(attStmt map[string]interface{}, leaf *x509.Certificate, data []byte) error {
+	sig, ok := attStmt["sig"].([]byte)
+	if !ok {
+		return errors.New("attestation statement does not contain a valid sig")
+	}
+	alg := attestationAlg(attStmt)
+	var algo x509.SignatureAlgorithm
+	// COSE algorithm identifiers, RFC 8152.
+	switch alg {
+	case -7:
+		algo = x509.ECDSAWithSHA256
+	case -35:
+		algo = x509.ECDSAWithSHA384
+	case -257:
+		algo = x509.SHA256WithRSA
+	case -8:
+		algo = x509.PureEd25519
+	default:
+		return errors.Errorf("attestation statement alg %d is not supported", alg)
+	}
+	if err := leaf.CheckSignature(algo, data, sig); err != nil {
+		return errors.Wrap(err, "error verifying attestation signature")
+	}
+	return nil
+}
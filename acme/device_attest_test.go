@@ -0,0 +1,420 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/cli/jose"
+)
+
+var (
+	oidSubjectAltName      = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidPermanentIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 8, 3}
+)
+
+type attestationCA struct {
+	root *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newAttestationCA(t *testing.T) *attestationCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Attestation Root CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	assert.FatalError(t, err)
+	root, err := x509.ParseCertificate(der)
+	assert.FatalError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(root)
+	return &attestationCA{root: root, key: key, pool: pool}
+}
+
+// leaf returns a new attestation certificate with the given extensions and
+// the key of the certificate.
+func (ca *attestationCA) leaf(t *testing.T, exts ...pkix.Extension) ([]byte, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		Subject:         pkix.Name{CommonName: "Attestation Leaf"},
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtraExtensions: exts,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.root, key.Public(), ca.key)
+	assert.FatalError(t, err)
+	return der, key
+}
+
+func cborMarshal(t *testing.T, v interface{}) []byte {
+	b, err := cbor.Marshal(v)
+	assert.FatalError(t, err)
+	return b
+}
+
+// marshalTPMPublic returns the TPMT_PUBLIC structure of an ECDSA P-256 key.
+func marshalTPMPublic(pub *ecdsa.PublicKey) []byte {
+	b := []byte{
+		0x00, 0x23, // type: ECC
+		0x00, 0x0b, // nameAlg: SHA-256
+		0x00, 0x04, 0x00, 0x72, // objectAttributes
+		0x00, 0x00, // authPolicy
+		0x00, 0x10, // symmetric: null
+		0x00, 0x18, 0x00, 0x0b, // scheme: ECDSA with SHA-256
+		0x00, 0x03, // curve: NIST P-256
+		0x00, 0x10, // kdf: null
+	}
+	for _, v := range []*big.Int{pub.X, pub.Y} {
+		b = append(b, 0x00, 0x20)
+		b = append(b, v.FillBytes(make([]byte, 32))...)
+	}
+	return b
+}
+
+func signAttestation(t *testing.T, key crypto.Signer, data []byte) []byte {
+	sum := sha256.Sum256(data)
+	sig, err := key.Sign(rand.Reader, sum[:], crypto.SHA256)
+	assert.FatalError(t, err)
+	return sig
+}
+
+func newAppleAttestation(t *testing.T, ca *attestationCA, token, serial string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	leaf, _ := ca.leaf(t,
+		pkix.Extension{Id: oidAppleSerialNumber, Value: []byte(serial)},
+		pkix.Extension{Id: oidAppleUniqueDeviceIdentifier, Value: []byte("udid")},
+		pkix.Extension{Id: oidAppleNonce, Value: sum[:]},
+	)
+	return cborMarshal(t, map[string]interface{}{
+		"fmt": "apple",
+		"attStmt": map[string]interface{}{
+			"x5c": []interface{}{leaf},
+		},
+	})
+}
+
+func newStepAttestation(t *testing.T, ca *attestationCA, keyAuth string, serial int64) []byte {
+	value, err := asn1.Marshal(serial)
+	assert.FatalError(t, err)
+	leaf, key := ca.leaf(t, pkix.Extension{Id: oidYubicoSerialNumber, Value: value})
+	return cborMarshal(t, map[string]interface{}{
+		"fmt": "step",
+		"attStmt": map[string]interface{}{
+			"alg": -7,
+			"sig": signAttestation(t, key, []byte(keyAuth)),
+			"x5c": []interface{}{leaf},
+		},
+	})
+}
+
+func newTPMAttestation(t *testing.T, ca *attestationCA, keyAuth, permanentIdentifier string) []byte {
+	pi, err := asn1.Marshal(struct {
+		IdentifierValue string `asn1:"utf8"`
+	}{permanentIdentifier})
+	assert.FatalError(t, err)
+	otherName, err := asn1.Marshal(struct {
+		ID    asn1.ObjectIdentifier
+		Value asn1.RawValue
+	}{oidPermanentIdentifier, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: pi}})
+	assert.FatalError(t, err)
+	// otherName is an implicitly tagged [0] sequence.
+	otherName[0] = 0xa0
+	san, err := asn1.Marshal([]asn1.RawValue{{FullBytes: otherName}})
+	assert.FatalError(t, err)
+	leaf, key := ca.leaf(t, pkix.Extension{Id: oidSubjectAltName, Value: san})
+
+	certified, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	pubArea := marshalTPMPublic(&certified.PublicKey)
+	pubSum := sha256.Sum256(pubArea)
+	name := append([]byte{0x00, 0x0b}, pubSum[:]...)
+	extraData := sha256.Sum256([]byte(keyAuth))
+
+	var certInfo []byte
+	certInfo = append(certInfo, 0xff, 0x54, 0x43, 0x47, 0x80, 0x17)
+	certInfo = append(certInfo, 0x00, 0x00) // qualifiedSigner
+	certInfo = append(certInfo, 0x00, 0x20)
+	certInfo = append(certInfo, extraData[:]...)
+	certInfo = append(certInfo, make([]byte, 17+8)...)
+	size := make([]byte, 2)
+	binary.BigEndian.PutUint16(size, uint16(len(name)))
+	certInfo = append(certInfo, size...)
+	certInfo = append(certInfo, name...)
+	certInfo = append(certInfo, 0x00, 0x00) // qualifiedName
+
+	return cborMarshal(t, map[string]interface{}{
+		"fmt": "tpm",
+		"attStmt": map[string]interface{}{
+			"ver":      "2.0",
+			"alg":      -7,
+			"sig":      signAttestation(t, key, certInfo),
+			"x5c":      []interface{}{leaf},
+			"certInfo": certInfo,
+			"pubArea":  pubArea,
+		},
+	})
+}
+
+func TestVerifyAttestation(t *testing.T) {
+	ca := newAttestationCA(t)
+	other := newAttestationCA(t)
+	token, keyAuth := "token", "token.thumbprint"
+	allFormats := func(string) bool { return true }
+	type test struct {
+		attObj []byte
+		ao     *attestationOptions
+		ids    []string
+		err    string
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/cbor": func(t *testing.T) test {
+			return test{
+				attObj: []byte{0x5f},
+				err:    "error decoding attestation object",
+			}
+		},
+		"fail/not-map": func(t *testing.T) test {
+			return test{
+				attObj: cborMarshal(t, "foo"),
+				err:    "error decoding attestation object",
+			}
+		},
+		"fail/no-fmt": func(t *testing.T) test {
+			return test{
+				attObj: cborMarshal(t, map[string]interface{}{"attStmt": map[string]interface{}{}}),
+				err:    "attestation object does not contain a valid fmt",
+			}
+		},
+		"fail/no-attStmt": func(t *testing.T) test {
+			return test{
+				attObj: cborMarshal(t, map[string]interface{}{"fmt": "step"}),
+				err:    "attestation object does not contain a valid attStmt",
+			}
+		},
+		"fail/format-disabled": func(t *testing.T) test {
+			return test{
+				attObj: newStepAttestation(t, ca, keyAuth, 12345678),
+				ao: &attestationOptions{roots: ca.pool, isFormatEnabled: func(f string) bool {
+					return f == "tpm"
+				}},
+				err: "attestation format step is not enabled",
+			}
+		},
+		"fail/unknown-format": func(t *testing.T) test {
+			return test{
+				attObj: cborMarshal(t, map[string]interface{}{"fmt": "foo", "attStmt": map[string]interface{}{}}),
+				err:    "unsupported attestation format foo",
+			}
+		},
+		"fail/untrusted-root": func(t *testing.T) test {
+			return test{
+				attObj: newStepAttestation(t, ca, keyAuth, 12345678),
+				ao:     &attestationOptions{roots: other.pool, isFormatEnabled: allFormats},
+				err:    "error verifying x5c certificate chain",
+			}
+		},
+		"fail/apple-nonce": func(t *testing.T) test {
+			return test{
+				attObj: newAppleAttestation(t, ca, "other-token", "serial"),
+				err:    "apple attestation nonce does not match",
+			}
+		},
+		"fail/step-signature": func(t *testing.T) test {
+			return test{
+				attObj: newStepAttestation(t, ca, "other.thumbprint", 12345678),
+				err:    "error verifying attestation signature",
+			}
+		},
+		"fail/tpm-extra-data": func(t *testing.T) test {
+			return test{
+				attObj: newTPMAttestation(t, ca, "other.thumbprint", "device-id"),
+				err:    "tpm attestation extraData does not match",
+			}
+		},
+		"ok/apple": func(t *testing.T) test {
+			return test{
+				attObj: newAppleAttestation(t, ca, token, "serial"),
+				ids:    []string{"serial", "udid"},
+			}
+		},
+		"ok/step": func(t *testing.T) test {
+			return test{
+				attObj: newStepAttestation(t, ca, keyAuth, 12345678),
+				ids:    []string{"12345678"},
+			}
+		},
+		"ok/tpm": func(t *testing.T) test {
+			return test{
+				attObj: newTPMAttestation(t, ca, keyAuth, "device-id"),
+				ids:    []string{"device-id"},
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			if tc.ao == nil {
+				tc.ao = &attestationOptions{roots: ca.pool, isFormatEnabled: allFormats}
+			}
+			att, err := verifyAttestation(tc.attObj, token, keyAuth, tc.ao)
+			if err != nil {
+				if assert.NotEquals(t, "", tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err)
+				}
+			} else if assert.Equals(t, "", tc.err) {
+				assert.Equals(t, tc.ids, att.ids)
+				assert.NotNil(t, att.key)
+			}
+		})
+	}
+}
+
+func TestDeviceAttest01Validate(t *testing.T) {
+	ca := newAttestationCA(t)
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	ops := testOps
+	ops.Identifier = Identifier{Type: "permanent-identifier", Value: "12345678"}
+	newCh := func() challenge {
+		ch, err := newDeviceAttest01Challenge(&db.MockNoSQLDB{
+			MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				return []byte("foo"), true, nil
+			},
+		}, ops)
+		assert.FatalError(t, err)
+		return ch
+	}
+	newPayload := func(attObj []byte) []byte {
+		b, err := json.Marshal(map[string]string{"attObj": base64.RawURLEncoding.EncodeToString(attObj)})
+		assert.FatalError(t, err)
+		return b
+	}
+	type test struct {
+		ch      challenge
+		vo      validateOptions
+		status  string
+		errType string
+		err     *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"ok/status-already-valid": func(t *testing.T) test {
+			ch := newCh()
+			ch.(*deviceAttest01Challenge).Status = StatusValid
+			return test{ch: ch, status: StatusValid}
+		},
+		"fail/no-attestation-options": func(t *testing.T) test {
+			return test{
+				ch:  newCh(),
+				err: ServerInternalErr(errors.New("attestation options are required to validate device-attest-01 challenges")),
+			}
+		},
+		"ok/missing-attObj": func(t *testing.T) test {
+			return test{
+				ch:      newCh(),
+				vo:      validateOptions{attestation: &attestationOptions{payload: []byte("{}")}},
+				status:  StatusInvalid,
+				errType: "urn:ietf:params:acme:error:" + malformedErr.String(),
+			}
+		},
+		"ok/bad-statement": func(t *testing.T) test {
+			return test{
+				ch: newCh(),
+				vo: validateOptions{attestation: &attestationOptions{
+					payload:         newPayload([]byte{0x01}),
+					roots:           ca.pool,
+					isFormatEnabled: func(string) bool { return true },
+				}},
+				status:  StatusInvalid,
+				errType: "urn:ietf:params:acme:error:" + badAttestationStatementErr.String(),
+			}
+		},
+		"ok/identifier-mismatch": func(t *testing.T) test {
+			ch := newCh()
+			keyAuth, err := KeyAuthorization(ch.getToken(), jwk)
+			assert.FatalError(t, err)
+			return test{
+				ch: ch,
+				vo: validateOptions{attestation: &attestationOptions{
+					payload:         newPayload(newStepAttestation(t, ca, keyAuth, 87654321)),
+					roots:           ca.pool,
+					isFormatEnabled: func(string) bool { return true },
+				}},
+				status:  StatusInvalid,
+				errType: "urn:ietf:params:acme:error:" + rejectedIdentifierErr.String(),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			ch := newCh()
+			keyAuth, err := KeyAuthorization(ch.getToken(), jwk)
+			assert.FatalError(t, err)
+			return test{
+				ch: ch,
+				vo: validateOptions{attestation: &attestationOptions{
+					payload:         newPayload(newStepAttestation(t, ca, keyAuth, 12345678)),
+					roots:           ca.pool,
+					isFormatEnabled: func(string) bool { return true },
+				}},
+				status: StatusValid,
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			mockdb := &db.MockNoSQLDB{
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					assert.Equals(t, challengeTable, bucket)
+					return nil, true, nil
+				},
+			}
+			ch, err := tc.ch.validate(mockdb, jwk, tc.vo)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, tc.status, ch.getStatus())
+				if tc.errType != "" {
+					if assert.NotNil(t, ch.getError()) {
+						assert.Equals(t, tc.errType, ch.getError().Type)
+					}
+				} else {
+					assert.Nil(t, ch.getError())
+				}
+				if name == "ok" {
+					assert.Equals(t, 64, len(ch.(*deviceAttest01Challenge).Fingerprint))
+				}
+			}
+		})
+	}
+}
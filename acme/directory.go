@@ -1,8 +1,10 @@
 package acme
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net/url"
 
 	"github.com/pkg/errors"
 )
@@ -15,6 +17,24 @@ type Directory struct {
 	NewAuthz   string `json:"newAuthz,omitempty"`
 	RevokeCert string `json:"revokeCert,omitempty"`
 	KeyChange  string `json:"keyChange,omitempty"`
+	Meta       *Meta  `json:"meta,omitempty"`
+}
+
+// Meta contains the optional metadata of the ACME directory. Profiles maps the
+// names of the certificate profiles that can be requested in new orders to
+// their descriptions.
+type Meta struct {
+	TermsOfService string            `json:"termsOfService,omitempty"`
+	AutoRenewal    *MetaAutoRenewal  `json:"auto-renewal,omitempty"`
+	Profiles       map[string]string `json:"profiles,omitempty"`
+}
+
+// MetaAutoRenewal contains the limits of STAR orders as defined in RFC 8739.
+// MinLifetime and MaxDuration are in seconds.
+type MetaAutoRenewal struct {
+	MinLifetime         int64 `json:"min-lifetime"`
+	MaxDuration         int64 `json:"max-duration"`
+	AllowCertificateGet bool  `json:"allow-certificate-get,omitempty"`
 }
 
 // ToLog enables response logging for the Directory type.
@@ -30,6 +50,22 @@ type directory struct {
 	prefix, dns string
 }
 
+type baseURLContextKey struct{}
+
+// NewContextWithBaseURL returns a copy of the context with the scheme and host
+// used in the absolute links of the responses. It's used when the CA is behind
+// a proxy and the links must use the address the clients connect to.
+func NewContextWithBaseURL(ctx context.Context, u *url.URL) context.Context {
+	return context.WithValue(ctx, baseURLContextKey{}, u)
+}
+
+// BaseURLFromContext returns the base URL in the context, or nil if there's
+// none.
+func BaseURLFromContext(ctx context.Context) *url.URL {
+	u, _ := ctx.Value(baseURLContextKey{}).(*url.URL)
+	return u
+}
+
 // newDirectory returns a new Directory type.
 func newDirectory(dns, prefix string) *directory {
 	return &directory{prefix: prefix, dns: dns}
@@ -67,6 +103,8 @@ const (
 	RevokeCertLink
 	// KeyChangeLink key rollover
 	KeyChangeLink
+	// StarCertificateLink latest certificate of a STAR order
+	StarCertificateLink
 )
 
 func (l Link) String() string {
@@ -95,18 +133,21 @@ func (l Link) String() string {
 		return "revoke-cert"
 	case KeyChangeLink:
 		return "key-change"
+	case StarCertificateLink:
+		return "star-certificate"
 	default:
 		return "unexpected"
 	}
 }
 
-// getLink returns an absolute or partial path to the given resource.
-func (d *directory) getLink(typ Link, provisionerName string, abs bool, inputs ...string) string {
+// getLink returns an absolute or partial path to the given resource. Absolute
+// links use the base URL in the context if there's one.
+func (d *directory) getLink(ctx context.Context, typ Link, provisionerName string, abs bool, inputs ...string) string {
 	var link string
 	switch typ {
 	case NewNonceLink, NewAccountLink, NewOrderLink, NewAuthzLink, DirectoryLink, KeyChangeLink, RevokeCertLink:
 		link = fmt.Sprintf("/%s/%s", provisionerName, typ.String())
-	case AccountLink, OrderLink, AuthzLink, ChallengeLink, CertificateLink:
+	case AccountLink, OrderLink, AuthzLink, ChallengeLink, CertificateLink, StarCertificateLink:
 		link = fmt.Sprintf("/%s/%s/%s", provisionerName, typ.String(), inputs[0])
 	case OrdersByAccountLink:
 		link = fmt.Sprintf("/%s/%s/%s/orders", provisionerName, AccountLink.String(), inputs[0])
@@ -114,7 +155,11 @@ func (d *directory) getLink(typ Link, provisionerName string, abs bool, inputs .
 		link = fmt.Sprintf("/%s/%s/%s/finalize", provisionerName, OrderLink.String(), inputs[0])
 	}
 	if abs {
-		return fmt.Sprintf("https://%s/%s%s", d.dns, d.prefix, link)
+		scheme, host := "https", d.dns
+		if u := BaseURLFromContext(ctx); u != nil {
+			scheme, host = u.Scheme, u.Host
+		}
+		return fmt.Sprintf("%s://%s/%s%s", scheme, host, d.prefix, link)
 	}
 	return link
 }
@@ -1,7 +1,9 @@
 package acme
 
 import (
+	"context"
 	"fmt"
+	"net/url"
 	"testing"
 
 	"github.com/smallstep/assert"
@@ -15,46 +17,61 @@ func TestDirectoryGetLink(t *testing.T) {
 
 	prov := newProv()
 	provID := URLSafeProvisionerName(prov)
+	ctx := context.Background()
 
-	assert.Equals(t, dir.getLink(NewNonceLink, provID, true), fmt.Sprintf("https://ca.smallstep.com/acme/%s/new-nonce", provID))
-	assert.Equals(t, dir.getLink(NewNonceLink, provID, false), fmt.Sprintf("/%s/new-nonce", provID))
+	assert.Equals(t, dir.getLink(ctx, NewNonceLink, provID, true), fmt.Sprintf("https://ca.smallstep.com/acme/%s/new-nonce", provID))
+	assert.Equals(t, dir.getLink(ctx, NewNonceLink, provID, false), fmt.Sprintf("/%s/new-nonce", provID))
 
-	assert.Equals(t, dir.getLink(NewAccountLink, provID, true), fmt.Sprintf("https://ca.smallstep.com/acme/%s/new-account", provID))
-	assert.Equals(t, dir.getLink(NewAccountLink, provID, false), fmt.Sprintf("/%s/new-account", provID))
+	assert.Equals(t, dir.getLink(ctx, NewAccountLink, provID, true), fmt.Sprintf("https://ca.smallstep.com/acme/%s/new-account", provID))
+	assert.Equals(t, dir.getLink(ctx, NewAccountLink, provID, false), fmt.Sprintf("/%s/new-account", provID))
 
-	assert.Equals(t, dir.getLink(AccountLink, provID, true, id), fmt.Sprintf("https://ca.smallstep.com/acme/%s/account/1234", provID))
-	assert.Equals(t, dir.getLink(AccountLink, provID, false, id), fmt.Sprintf("/%s/account/1234", provID))
+	assert.Equals(t, dir.getLink(ctx, AccountLink, provID, true, id), fmt.Sprintf("https://ca.smallstep.com/acme/%s/account/1234", provID))
+	assert.Equals(t, dir.getLink(ctx, AccountLink, provID, false, id), fmt.Sprintf("/%s/account/1234", provID))
 
-	assert.Equals(t, dir.getLink(NewOrderLink, provID, true), fmt.Sprintf("https://ca.smallstep.com/acme/%s/new-order", provID))
-	assert.Equals(t, dir.getLink(NewOrderLink, provID, false), fmt.Sprintf("/%s/new-order", provID))
+	assert.Equals(t, dir.getLink(ctx, NewOrderLink, provID, true), fmt.Sprintf("https://ca.smallstep.com/acme/%s/new-order", provID))
+	assert.Equals(t, dir.getLink(ctx, NewOrderLink, provID, false), fmt.Sprintf("/%s/new-order", provID))
 
-	assert.Equals(t, dir.getLink(OrderLink, provID, true, id), fmt.Sprintf("https://ca.smallstep.com/acme/%s/order/1234", provID))
-	assert.Equals(t, dir.getLink(OrderLink, provID, false, id), fmt.Sprintf("/%s/order/1234", provID))
+	assert.Equals(t, dir.getLink(ctx, OrderLink, provID, true, id), fmt.Sprintf("https://ca.smallstep.com/acme/%s/order/1234", provID))
+	assert.Equals(t, dir.getLink(ctx, OrderLink, provID, false, id), fmt.Sprintf("/%s/order/1234", provID))
 
-	assert.Equals(t, dir.getLink(OrdersByAccountLink, provID, true, id), fmt.Sprintf("https://ca.smallstep.com/acme/%s/account/1234/orders", provID))
-	assert.Equals(t, dir.getLink(OrdersByAccountLink, provID, false, id), fmt.Sprintf("/%s/account/1234/orders", provID))
+	assert.Equals(t, dir.getLink(ctx, OrdersByAccountLink, provID, true, id), fmt.Sprintf("https://ca.smallstep.com/acme/%s/account/1234/orders", provID))
+	assert.Equals(t, dir.getLink(ctx, OrdersByAccountLink, provID, false, id), fmt.Sprintf("/%s/account/1234/orders", provID))
 
-	assert.Equals(t, dir.getLink(FinalizeLink, provID, true, id), fmt.Sprintf("https://ca.smallstep.com/acme/%s/order/1234/finalize", provID))
-	assert.Equals(t, dir.getLink(FinalizeLink, provID, false, id), fmt.Sprintf("/%s/order/1234/finalize", provID))
+	assert.Equals(t, dir.getLink(ctx, FinalizeLink, provID, true, id), fmt.Sprintf("https://ca.smallstep.com/acme/%s/order/1234/finalize", provID))
+	assert.Equals(t, dir.getLink(ctx, FinalizeLink, provID, false, id), fmt.Sprintf("/%s/order/1234/finalize", provID))
 
-	assert.Equals(t, dir.getLink(NewAuthzLink, provID, true), fmt.Sprintf("https://ca.smallstep.com/acme/%s/new-authz", provID))
-	assert.Equals(t, dir.getLink(NewAuthzLink, provID, false), fmt.Sprintf("/%s/new-authz", provID))
+	assert.Equals(t, dir.getLink(ctx, NewAuthzLink, provID, true), fmt.Sprintf("https://ca.smallstep.com/acme/%s/new-authz", provID))
+	assert.Equals(t, dir.getLink(ctx, NewAuthzLink, provID, false), fmt.Sprintf("/%s/new-authz", provID))
 
-	assert.Equals(t, dir.getLink(AuthzLink, provID, true, id), fmt.Sprintf("https://ca.smallstep.com/acme/%s/authz/1234", provID))
-	assert.Equals(t, dir.getLink(AuthzLink, provID, false, id), fmt.Sprintf("/%s/authz/1234", provID))
+	assert.Equals(t, dir.getLink(ctx, AuthzLink, provID, true, id), fmt.Sprintf("https://ca.smallstep.com/acme/%s/authz/1234", provID))
+	assert.Equals(t, dir.getLink(ctx, AuthzLink, provID, false, id), fmt.Sprintf("/%s/authz/1234", provID))
 
-	assert.Equals(t, dir.getLink(DirectoryLink, provID, true), fmt.Sprintf("https://ca.smallstep.com/acme/%s/directory", provID))
-	assert.Equals(t, dir.getLink(DirectoryLink, provID, false), fmt.Sprintf("/%s/directory", provID))
+	assert.Equals(t, dir.getLink(ctx, DirectoryLink, provID, true), fmt.Sprintf("https://ca.smallstep.com/acme/%s/directory", provID))
+	assert.Equals(t, dir.getLink(ctx, DirectoryLink, provID, false), fmt.Sprintf("/%s/directory", provID))
 
-	assert.Equals(t, dir.getLink(RevokeCertLink, provID, true, id), fmt.Sprintf("https://ca.smallstep.com/acme/%s/revoke-cert", provID))
-	assert.Equals(t, dir.getLink(RevokeCertLink, provID, false), fmt.Sprintf("/%s/revoke-cert", provID))
+	assert.Equals(t, dir.getLink(ctx, RevokeCertLink, provID, true, id), fmt.Sprintf("https://ca.smallstep.com/acme/%s/revoke-cert", provID))
+	assert.Equals(t, dir.getLink(ctx, RevokeCertLink, provID, false), fmt.Sprintf("/%s/revoke-cert", provID))
 
-	assert.Equals(t, dir.getLink(KeyChangeLink, provID, true), fmt.Sprintf("https://ca.smallstep.com/acme/%s/key-change", provID))
-	assert.Equals(t, dir.getLink(KeyChangeLink, provID, false), fmt.Sprintf("/%s/key-change", provID))
+	assert.Equals(t, dir.getLink(ctx, KeyChangeLink, provID, true), fmt.Sprintf("https://ca.smallstep.com/acme/%s/key-change", provID))
+	assert.Equals(t, dir.getLink(ctx, KeyChangeLink, provID, false), fmt.Sprintf("/%s/key-change", provID))
 
-	assert.Equals(t, dir.getLink(ChallengeLink, provID, true, id), fmt.Sprintf("https://ca.smallstep.com/acme/%s/challenge/1234", provID))
-	assert.Equals(t, dir.getLink(ChallengeLink, provID, false, id), fmt.Sprintf("/%s/challenge/1234", provID))
+	assert.Equals(t, dir.getLink(ctx, ChallengeLink, provID, true, id), fmt.Sprintf("https://ca.smallstep.com/acme/%s/challenge/1234", provID))
+	assert.Equals(t, dir.getLink(ctx, ChallengeLink, provID, false, id), fmt.Sprintf("/%s/challenge/1234", provID))
 
-	assert.Equals(t, dir.getLink(CertificateLink, provID, true, id), fmt.Sprintf("https://ca.smallstep.com/acme/%s/certificate/1234", provID))
-	assert.Equals(t, dir.getLink(CertificateLink, provID, false, id), fmt.Sprintf("/%s/certificate/1234", provID))
+	assert.Equals(t, dir.getLink(ctx, CertificateLink, provID, true, id), fmt.Sprintf("https://ca.smallstep.com/acme/%s/certificate/1234", provID))
+	assert.Equals(t, dir.getLink(ctx, CertificateLink, provID, false, id), fmt.Sprintf("/%s/certificate/1234", provID))
+}
+
+func TestDirectoryGetLink_baseURL(t *testing.T) {
+	dir := newDirectory("ca.smallstep.com:9000", "acme")
+	ctx := NewContextWithBaseURL(context.Background(), &url.URL{Scheme: "https", Host: "acme.example.com"})
+
+	assert.Equals(t, "https://acme.example.com/acme/my-acme/order/1234", dir.getLink(ctx, OrderLink, "my-acme", true, "1234"))
+	assert.Equals(t, "/my-acme/order/1234", dir.getLink(ctx, OrderLink, "my-acme", false, "1234"))
+
+	ctx = NewContextWithBaseURL(context.Background(), &url.URL{Scheme: "http", Host: "localhost:8080"})
+	assert.Equals(t, "http://localhost:8080/acme/my-acme/new-nonce", dir.getLink(ctx, NewNonceLink, "my-acme", true))
+
+	// Without base URL the links use the dns of the directory.
+	assert.Equals(t, "https://ca.smallstep.com:9000/acme/my-acme/new-nonce", dir.getLink(context.Background(), NewNonceLink, "my-acme", true))
 }
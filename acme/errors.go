@@ -1,6 +1,9 @@
 package acme
 
 import (
+	"strings"
+	"time"
+
 	"github.com/pkg/errors"
 )
 
@@ -24,6 +27,16 @@ func AlreadyRevokedErr(err error) *Error {
 	}
 }
 
+// BadAttestationStatementErr returns a new acme error.
+func BadAttestationStatementErr(err error) *Error {
+	return &Error{
+		Type:   badAttestationStatementErr,
+		Detail: "The attestation statement cannot be verified",
+		Status: 400,
+		Err:    err,
+	}
+}
+
 // BadCSRErr returns a new acme error.
 func BadCSRErr(err error) *Error {
 	return &Error{
@@ -144,6 +157,16 @@ func InvalidContactErr(err error) *Error {
 	}
 }
 
+// InvalidProfileErr returns a new acme error.
+func InvalidProfileErr(err error) *Error {
+	return &Error{
+		Type:   invalidProfileErr,
+		Detail: "The requested certificate profile is not valid",
+		Status: 400,
+		Err:    err,
+	}
+}
+
 // MalformedErr returns a new acme error.
 func MalformedErr(err error) *Error {
 	return &Error{
@@ -169,7 +192,7 @@ func RateLimitedErr(err error) *Error {
 	return &Error{
 		Type:   rateLimitedErr,
 		Detail: "The request exceeds a rate limit",
-		Status: 400,
+		Status: 429,
 		Err:    err,
 	}
 }
@@ -194,6 +217,25 @@ func ServerInternalErr(err error) *Error {
 	}
 }
 
+// SubproblemsErr returns the error of a request that failed for one or more
+// identifiers. A single problem is returned as is, and multiple problems are
+// returned as the subproblems of a compound error.
+func SubproblemsErr(subs []*Error) *Error {
+	switch len(subs) {
+	case 0:
+		return nil
+	case 1:
+		return subs[0]
+	}
+	msgs := make([]string, len(subs))
+	for i, sub := range subs {
+		msgs[i] = sub.Error()
+	}
+	e := CompoundErr(errors.Errorf("%d identifiers have errors: %s", len(subs), strings.Join(msgs, "; ")))
+	e.Sub = subs
+	return e
+}
+
 // TLSErr returns a new acme error.
 func TLSErr(err error) *Error {
 	return &Error{
@@ -296,6 +338,10 @@ const (
 	unsupportedIdentifierErr
 	// Visit the “instance” URL and take actions specified there
 	userActionRequiredErr
+	// The attestation statement of a device-attest-01 challenge is not valid
+	badAttestationStatementErr
+	// The requested certificate profile is not supported by the server
+	invalidProfileErr
 )
 
 // String returns the string representation of the acme problem type,
@@ -350,12 +396,19 @@ func (ap ProbType) String() string {
 		return "unsupportedIdentifier"
 	case userActionRequiredErr:
 		return "userActionRequired"
+	case badAttestationStatementErr:
+		return "badAttestationStatement"
+	case invalidProfileErr:
+		return "invalidProfile"
 	default:
 		return "unsupported type"
 	}
 }
 
-// Error is an ACME error type complete with problem document.
+// Error is an ACME error type complete with problem document. RetryAfter is
+// the time a client must wait before retrying a rate limited request.
+// Algorithms is the list of JWS algorithms supported by the server, it's
+// returned with badSignatureAlgorithm errors.
 type Error struct {
 	Type       ProbType
 	Detail     string
@@ -363,6 +416,8 @@ type Error struct {
 	Status     int
 	Sub        []*Error
 	Identifier *Identifier
+	RetryAfter time.Duration
+	Algorithms []string
 }
 
 // Wrap attempts to wrap the internal error.
@@ -382,6 +437,13 @@ func Wrap(err error, wrap string) *Error {
 	}
 }
 
+// WithIdentifier sets the identifier the problem refers to, and returns the
+// error.
+func (e *Error) WithIdentifier(id Identifier) *Error {
+	e.Identifier = &id
+	return e
+}
+
 // Error implements the error interface.
 func (e *Error) Error() string {
 	if e.Err == nil {
@@ -401,9 +463,10 @@ func (e *Error) Cause() error {
 // ToACME returns an acme representation of the problem type.
 func (e *Error) ToACME() *AError {
 	ae := &AError{
-		Type:   "urn:ietf:params:acme:error:" + e.Type.String(),
-		Detail: e.Error(),
-		Status: e.Status,
+		Type:       "urn:ietf:params:acme:error:" + e.Type.String(),
+		Detail:     e.Error(),
+		Status:     e.Status,
+		Algorithms: e.Algorithms,
 	}
 	if e.Identifier != nil {
 		ae.Identifier = *e.Identifier
@@ -419,13 +482,17 @@ func (e *Error) StatusCode() int {
 	return e.Status
 }
 
-// AError is the error type as seen in acme request/responses.
+// AError is the error type as seen in acme request/responses. Attempts is
+// only set in the error of a challenge, and it contains the validation
+// attempts of the challenge.
 type AError struct {
-	Type        string        `json:"type"`
-	Detail      string        `json:"detail"`
-	Identifier  interface{}   `json:"identifier,omitempty"`
-	Subproblems []interface{} `json:"subproblems,omitempty"`
-	Status      int           `json:"-"`
+	Type        string               `json:"type"`
+	Detail      string               `json:"detail"`
+	Identifier  interface{}          `json:"identifier,omitempty"`
+	Subproblems []interface{}        `json:"subproblems,omitempty"`
+	Algorithms  []string             `json:"algorithms,omitempty"`
+	Attempts    []*ValidationAttempt `json:"attempts,omitempty"`
+	Status      int                  `json:"-"`
 }
 
 // Error allows AError to implement the error interface.
@@ -0,0 +1,119 @@
+package acme
+
+import (
+	"time"
+
+	cadb "github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/metrics"
+	"github.com/smallstep/nosql"
+	"github.com/smallstep/nosql/database"
+)
+
+var (
+	ordersCreatedTotal = metrics.NewCounter("acme_orders_created_total",
+		"Number of ACME orders created.", "provisioner")
+	challengesTotal = metrics.NewCounter("acme_challenges_total",
+		"Number of ACME challenges that have become valid or invalid.", "type", "status")
+	finalizeDuration = metrics.NewHistogram("acme_finalize_duration_seconds",
+		"Time spent finalizing ACME orders.", metrics.DefaultBuckets, "provisioner", "result")
+	noncesCreatedTotal = metrics.NewCounter("acme_nonces_created_total",
+		"Number of ACME nonces created.")
+	noncesUsedTotal = metrics.NewCounter("acme_nonces_used_total",
+		"Number of ACME nonces used, by result.", "result")
+	dbErrorsTotal = metrics.NewCounter("acme_db_errors_total",
+		"Number of errors returned by the ACME database.", "operation")
+)
+
+// resultLabel returns the value of the result label for the given error.
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// metricsDB is a nosql.DB that counts the errors returned by the database.
+// Records not found and failed swaps are not counted as errors.
+type metricsDB struct {
+	nosql.DB
+}
+
+func (db *metricsDB) count(op string, err error) {
+	if err != nil && !nosql.IsErrNotFound(err) {
+		dbErrorsTotal.Inc(op)
+	}
+}
+
+// Get implements the nosql.DB interface.
+func (db *metricsDB) Get(bucket, key []byte) ([]byte, error) {
+	b, err := db.DB.Get(bucket, key)
+	db.count("get", err)
+	return b, err
+}
+
+// Set implements the nosql.DB interface.
+func (db *metricsDB) Set(bucket, key, value []byte) error {
+	err := db.DB.Set(bucket, key, value)
+	db.count("set", err)
+	return err
+}
+
+// CmpAndSwap implements the nosql.DB interface.
+func (db *metricsDB) CmpAndSwap(bucket, key, oldValue, newValue []byte) ([]byte, bool, error) {
+	b, swapped, err := db.DB.CmpAndSwap(bucket, key, oldValue, newValue)
+	db.count("cmpAndSwap", err)
+	return b, swapped, err
+}
+
+// SetWithTTL implements the db.TTLDB interface. The value is stored without an
+// expiration if the underlying database does not support it.
+func (db *metricsDB) SetWithTTL(bucket, key, value []byte, ttl time.Duration) error {
+	err := cadb.SetWithTTL(db.DB, bucket, key, value, ttl)
+	db.count("set", err)
+	return err
+}
+
+// CmpAndSwapWithTTL implements the db.TTLDB interface. The value is stored
+// without an expiration if the underlying database does not support it.
+func (db *metricsDB) CmpAndSwapWithTTL(bucket, key, oldValue, newValue []byte, ttl time.Duration) ([]byte, bool, error) {
+	b, swapped, err := cadb.CmpAndSwapWithTTL(db.DB, bucket, key, oldValue, newValue, ttl)
+	db.count("cmpAndSwap", err)
+	return b, swapped, err
+}
+
+// Del implements the nosql.DB interface.
+func (db *metricsDB) Del(bucket, key []byte) error {
+	err := db.DB.Del(bucket, key)
+	db.count("del", err)
+	return err
+}
+
+// List implements the nosql.DB interface.
+func (db *metricsDB) List(bucket []byte) ([]*database.Entry, error) {
+	entries, err := db.DB.List(bucket)
+	db.count("list", err)
+	return entries, err
+}
+
+// BatchGet implements the db.BatchDB interface. The keys are read with
+// concurrent Get calls if the underlying database does not support it.
+func (db *metricsDB) BatchGet(bucket []byte, keys [][]byte) ([][]byte, error) {
+	values, err := cadb.BatchGet(db.DB, bucket, keys)
+	db.count("batchGet", err)
+	return values, err
+}
+
+// Update implements the nosql.DB interface.
+func (db *metricsDB) Update(tx *database.Tx) error {
+	err := db.DB.Update(tx)
+	db.count("update", err)
+	return err
+}
+
+// UpdateWithTTL implements the db.TTLDB interface. The values are stored
+// without an expiration if the underlying database does not support it.
+func (db *metricsDB) UpdateWithTTL(tx *database.Tx, ttl time.Duration) error {
+	err := cadb.UpdateWithTTL(db.DB, tx, ttl)
+	db.count("update", err)
+	return err
+}
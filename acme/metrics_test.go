@@ -0,0 +1,69 @@
+package acme
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/nosql/database"
+)
+
+func TestMetricsDB(t *testing.T) {
+	mdb := &metricsDB{&db.MockNoSQLDB{
+		MGet: func(bucket, key []byte) ([]byte, error) {
+			if string(key) == "missing" {
+				return nil, database.ErrNotFound
+			}
+			return nil, errors.New("force")
+		},
+		MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+			return nil, false, nil
+		},
+		MDel: func(bucket, key []byte) error {
+			return errors.New("force")
+		},
+	}}
+
+	get := dbErrorsTotal.Value("get")
+	cmpAndSwap := dbErrorsTotal.Value("cmpAndSwap")
+	del := dbErrorsTotal.Value("del")
+
+	_, err := mdb.Get(nonceTable, []byte("missing"))
+	assert.True(t, database.IsErrNotFound(err))
+	assert.Equals(t, get, dbErrorsTotal.Value("get"))
+
+	_, err = mdb.Get(nonceTable, []byte("foo"))
+	assert.Equals(t, "force", err.Error())
+	assert.Equals(t, get+1, dbErrorsTotal.Value("get"))
+
+	_, swapped, err := mdb.CmpAndSwap(nonceTable, []byte("foo"), nil, nil)
+	assert.FatalError(t, err)
+	assert.False(t, swapped)
+	assert.Equals(t, cmpAndSwap, dbErrorsTotal.Value("cmpAndSwap"))
+
+	assert.NotNil(t, mdb.Del(nonceTable, []byte("foo")))
+	assert.Equals(t, del+1, dbErrorsTotal.Value("del"))
+}
+
+func TestAuthorityNonceMetrics(t *testing.T) {
+	auth, err := NewAuthority(&db.MockNoSQLDB{
+		MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+			return nil, true, nil
+		},
+		MUpdate: func(tx *database.Tx) error {
+			return database.ErrNotFound
+		},
+	}, "ca.smallstep.com", "acme", nil)
+	assert.FatalError(t, err)
+
+	created := noncesCreatedTotal.Value()
+	failed := noncesUsedTotal.Value("error")
+
+	_, err = auth.NewNonce()
+	assert.FatalError(t, err)
+	assert.Equals(t, created+1, noncesCreatedTotal.Value())
+
+	assert.NotNil(t, auth.UseNonce("foo"))
+	assert.Equals(t, failed+1, noncesUsedTotal.Value("error"))
+}
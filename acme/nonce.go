@@ -1,11 +1,16 @@
 package acme
 
 import (
+	"container/list"
 	"encoding/base64"
 	"encoding/json"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
+	cadb "github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/db/redis"
 	"github.com/smallstep/nosql"
 	"github.com/smallstep/nosql/database"
 )
@@ -16,23 +21,32 @@ type nonce struct {
 	Created time.Time
 }
 
-// newNonce creates, stores, and returns an ACME replay-nonce.
-func newNonce(db nosql.DB) (*nonce, error) {
+// createNonce returns a new ACME replay-nonce without storing it.
+func createNonce() (*nonce, error) {
 	_id, err := randID()
 	if err != nil {
 		return nil, err
 	}
-
-	id := base64.RawURLEncoding.EncodeToString([]byte(_id))
-	n := &nonce{
-		ID:      id,
+	return &nonce{
+		ID:      base64.RawURLEncoding.EncodeToString([]byte(_id)),
 		Created: clock.Now(),
+	}, nil
+}
+
+// newNonce creates, stores, and returns an ACME replay-nonce. If maxAge is
+// greater than 0, the nonce expires from the database after it, if the
+// database supports it.
+func newNonce(db nosql.DB, maxAge time.Duration) (*nonce, error) {
+	n, err := createNonce()
+	if err != nil {
+		return nil, err
 	}
+	id := n.ID
 	b, err := json.Marshal(n)
 	if err != nil {
 		return nil, ServerInternalErr(errors.Wrap(err, "error marshaling nonce"))
 	}
-	_, swapped, err := db.CmpAndSwap(nonceTable, []byte(id), nil, b)
+	_, swapped, err := cadb.CmpAndSwapWithTTL(db, nonceTable, []byte(id), nil, b, n.ttl(maxAge))
 	switch {
 	case err != nil:
 		return nil, ServerInternalErr(errors.Wrap(err, "error storing nonce"))
@@ -45,29 +59,349 @@ func newNonce(db nosql.DB) (*nonce, error) {
 }
 
 // useNonce verifies that the nonce is valid (by checking if it exists),
-// and if so, consumes the nonce resource by deleting it from the database.
-func useNonce(db nosql.DB, nonce string) error {
-	err := db.Update(&database.Tx{
+// and if so, consumes the nonce resource by deleting it from the database. If
+// maxAge is greater than 0, nonces older than maxAge are consumed but
+// rejected.
+func useNonce(db nosql.DB, id string, maxAge time.Duration) error {
+	tx := &database.Tx{
 		Operations: []*database.TxEntry{
 			{
 				Bucket: nonceTable,
-				Key:    []byte(nonce),
+				Key:    []byte(id),
 				Cmd:    database.Get,
 			},
 			{
 				Bucket: nonceTable,
-				Key:    []byte(nonce),
+				Key:    []byte(id),
 				Cmd:    database.Delete,
 			},
 		},
-	})
+	}
+	err := db.Update(tx)
 
 	switch {
 	case nosql.IsErrNotFound(err):
 		return BadNonceErr(nil)
 	case err != nil:
-		return ServerInternalErr(errors.Wrapf(err, "error deleting nonce %s", nonce))
+		return ServerInternalErr(errors.Wrapf(err, "error deleting nonce %s", id))
+	}
+
+	if b := tx.Operations[0].Result; maxAge > 0 && len(b) > 0 {
+		var n nonce
+		if err := json.Unmarshal(b, &n); err != nil {
+			return ServerInternalErr(errors.Wrapf(err, "error unmarshaling nonce %s", id))
+		}
+		return n.checkAge(maxAge)
+	}
+	return nil
+}
+
+// checkAge returns an error if maxAge is greater than 0 and the nonce is
+// older than maxAge.
+func (n *nonce) checkAge(maxAge time.Duration) error {
+	if maxAge > 0 && clock.Now().Sub(n.Created) > maxAge {
+		return BadNonceErr(errors.Errorf("nonce %s has expired", n.ID))
+	}
+	return nil
+}
+
+// ttl returns the time the nonce must be kept in the database, as it's
+// rejected once it's older than maxAge. It returns 0 if maxAge is not greater
+// than 0.
+func (n *nonce) ttl(maxAge time.Duration) time.Duration {
+	if maxAge <= 0 {
+		return 0
+	}
+	if d := n.Created.Add(maxAge).Sub(clock.Now()); d > 0 {
+		return d
+	}
+	return time.Second
+}
+
+// deleteExpiredNonces deletes from the database the nonces created before the
+// given time. It returns the number of nonces deleted.
+func deleteExpiredNonces(db nosql.DB, before time.Time) (int, error) {
+	entries, err := db.List(nonceTable)
+	if err != nil {
+		return 0, ServerInternalErr(errors.Wrap(err, "error listing nonces"))
+	}
+	var count int
+	for _, e := range entries {
+		var n nonce
+		if err := json.Unmarshal(e.Value, &n); err != nil {
+			return count, ServerInternalErr(errors.Wrapf(err, "error unmarshaling nonce %s", e.Key))
+		}
+		if !n.Created.Before(before) {
+			continue
+		}
+		if err := db.Del(nonceTable, e.Key); err != nil && !nosql.IsErrNotFound(err) {
+			return count, ServerInternalErr(errors.Wrapf(err, "error deleting nonce %s", e.Key))
+		}
+		count++
+	}
+	return count, nil
+}
+
+// nonceStore is the interface used by the authority to manage the nonces.
+type nonceStore interface {
+	newNonce() (*nonce, error)
+	useNonce(id string, maxAge time.Duration) error
+	deleteExpired(before time.Time) (int, error)
+	flush() error
+}
+
+// newNonceStore returns the nonce store selected in the given configuration.
+// The redis client is only used by the redis store.
+func newNonceStore(db nosql.DB, client *redis.Client, c *NonceConfig) (nonceStore, error) {
+	switch c.GetStore() {
+	case NonceStoreMemory:
+		return newMemoryNonceStore(db, c.GetMaxNonces(), c.GetMaxAge(), c.GetPersistInterval() > 0)
+	case NonceStoreRedis:
+		if client == nil {
+			return nil, errors.New("the redis nonce store requires a redis client")
+		}
+		return &redisNonceStore{client, c.GetMaxAge()}, nil
 	default:
+		return &dbNonceStore{db, c.GetMaxAge()}, nil
+	}
+}
+
+// dbNonceStore is the nonceStore that keeps the nonces in the database. The
+// nonces are stored with maxAge as their time to live.
+type dbNonceStore struct {
+	db     nosql.DB
+	maxAge time.Duration
+}
+
+func (s *dbNonceStore) newNonce() (*nonce, error) {
+	return newNonce(s.db, s.maxAge)
+}
+
+func (s *dbNonceStore) useNonce(id string, maxAge time.Duration) error {
+	return useNonce(s.db, id, maxAge)
+}
+
+func (s *dbNonceStore) deleteExpired(before time.Time) (int, error) {
+	return deleteExpiredNonces(s.db, before)
+}
+
+func (s *dbNonceStore) flush() error {
+	return nil
+}
+
+// memoryNonceStore is the nonceStore that keeps up to max nonces in memory,
+// discarding the oldest ones. If persistence is enabled, the created and used
+// nonces are recorded and written to the database by flush, a nonce that is
+// used before a flush never reaches the database. Persisted nonces expire
+// from the database once they are older than maxAge.
+type memoryNonceStore struct {
+	mu      sync.Mutex
+	max     int
+	maxAge  time.Duration
+	list    *list.List
+	nonces  map[string]*list.Element
+	db      nosql.DB
+	pending map[string]*nonce
+}
+
+// newMemoryNonceStore returns a new memoryNonceStore. If persist is true, the
+// nonces in the database are loaded in the store.
+func newMemoryNonceStore(db nosql.DB, max int, maxAge time.Duration, persist bool) (*memoryNonceStore, error) {
+	s := &memoryNonceStore{
+		max:    max,
+		maxAge: maxAge,
+		list:   list.New(),
+		nonces: make(map[string]*list.Element),
+	}
+	if !persist {
+		return s, nil
+	}
+	s.db = db
+	s.pending = make(map[string]*nonce)
+	entries, err := db.List(nonceTable)
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing nonces")
+	}
+	nonces := make([]*nonce, 0, len(entries))
+	for _, e := range entries {
+		n := new(nonce)
+		if err := json.Unmarshal(e.Value, n); err != nil {
+			return nil, errors.Wrapf(err, "error unmarshaling nonce %s", e.Key)
+		}
+		nonces = append(nonces, n)
+	}
+	sort.Slice(nonces, func(i, j int) bool {
+		return nonces[i].Created.Before(nonces[j].Created)
+	})
+	for _, n := range nonces {
+		s.add(n)
+	}
+	return s, nil
+}
+
+// add adds a nonce to the front of the list, removing the oldest nonces if
+// the store is full. It must be called with the lock held.
+func (s *memoryNonceStore) add(n *nonce) {
+	s.nonces[n.ID] = s.list.PushFront(n)
+	for s.list.Len() > s.max {
+		s.remove(s.list.Back())
+	}
+}
+
+// remove removes a nonce from the store, and records its deletion if the
+// persistence is enabled. It must be called with the lock held.
+func (s *memoryNonceStore) remove(e *list.Element) *nonce {
+	n := s.list.Remove(e).(*nonce)
+	delete(s.nonces, n.ID)
+	if s.pending != nil {
+		if p, ok := s.pending[n.ID]; ok && p != nil {
+			delete(s.pending, n.ID)
+		} else {
+			s.pending[n.ID] = nil
+		}
+	}
+	return n
+}
+
+func (s *memoryNonceStore) newNonce() (*nonce, error) {
+	n, err := createNonce()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.add(n)
+	if s.pending != nil {
+		s.pending[n.ID] = n
+	}
+	return n, nil
+}
+
+func (s *memoryNonceStore) useNonce(id string, maxAge time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.nonces[id]
+	if !ok {
+		return BadNonceErr(nil)
+	}
+	return s.remove(e).checkAge(maxAge)
+}
+
+func (s *memoryNonceStore) deleteExpired(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var count int
+	for e := s.list.Back(); e != nil && e.Value.(*nonce).Created.Before(before); e = s.list.Back() {
+		s.remove(e)
+		count++
+	}
+	return count, nil
+}
+
+// flush writes the nonces created and deletes the nonces used since the last
+// flush. The changes that fail are retried in the next flush.
+func (s *memoryNonceStore) flush() error {
+	if s.db == nil {
+		return nil
+	}
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = make(map[string]*nonce)
+	s.mu.Unlock()
+
+	// Write as many changes as possible, returning the first error.
+	var firstErr error
+	failed := make(map[string]*nonce)
+	for id, n := range pending {
+		if err := s.write(id, n); err != nil {
+			failed[id] = n
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if len(failed) == 0 {
 		return nil
 	}
+
+	// Re-queue the failed changes, unless the nonce has changed since.
+	s.mu.Lock()
+	for id, n := range failed {
+		if _, ok := s.pending[id]; !ok {
+			s.pending[id] = n
+		}
+	}
+	s.mu.Unlock()
+	return firstErr
+}
+
+// write stores the given nonce, or deletes it if it's nil.
+func (s *memoryNonceStore) write(id string, n *nonce) error {
+	if n == nil {
+		if err := s.db.Del(nonceTable, []byte(id)); err != nil && !nosql.IsErrNotFound(err) {
+			return errors.Wrapf(err, "error deleting nonce %s", id)
+		}
+		return nil
+	}
+	b, err := json.Marshal(n)
+	if err != nil {
+		return errors.Wrapf(err, "error marshaling nonce %s", id)
+	}
+	return errors.Wrapf(cadb.SetWithTTL(s.db, nonceTable, []byte(id), b, n.ttl(s.maxAge)), "error storing nonce %s", id)
+}
+
+// redisNonceStore is the nonceStore that keeps the nonces in Redis, so they
+// can be shared by multiple instances of the CA with a low latency. The
+// nonces are stored with maxAge as their time to live, so Redis removes the
+// expired ones.
+type redisNonceStore struct {
+	client *redis.Client
+	maxAge time.Duration
+}
+
+func (s *redisNonceStore) key(id string) string {
+	return s.client.Key(string(nonceTable), id)
+}
+
+func (s *redisNonceStore) newNonce() (*nonce, error) {
+	n, err := createNonce()
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(n)
+	if err != nil {
+		return nil, ServerInternalErr(errors.Wrap(err, "error marshaling nonce"))
+	}
+	stored, err := s.client.SetNX(s.key(n.ID), b, n.ttl(s.maxAge))
+	switch {
+	case err != nil:
+		return nil, ServerInternalErr(errors.Wrap(err, "error storing nonce"))
+	case !stored:
+		return nil, ServerInternalErr(errors.New("error storing nonce; " +
+			"value has changed since last read"))
+	default:
+		return n, nil
+	}
+}
+
+// useNonce consumes the nonce deleting it from Redis. A nonce older than
+// maxAge has already expired in Redis, so its age is not checked.
+func (s *redisNonceStore) useNonce(id string, maxAge time.Duration) error {
+	deleted, err := s.client.Del(s.key(id))
+	switch {
+	case err != nil:
+		return ServerInternalErr(errors.Wrapf(err, "error deleting nonce %s", id))
+	case !deleted:
+		return BadNonceErr(nil)
+	default:
+		return nil
+	}
+}
+
+func (s *redisNonceStore) deleteExpired(before time.Time) (int, error) {
+	return 0, nil
+}
+
+func (s *redisNonceStore) flush() error {
+	return nil
 }
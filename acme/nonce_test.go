@@ -1,12 +1,16 @@
 package acme
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/db/redis"
 	"github.com/smallstep/nosql"
 	"github.com/smallstep/nosql/database"
 )
@@ -61,7 +65,7 @@ func TestNewNonce(t *testing.T) {
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := run(t)
-			if n, err := newNonce(tc.db); err != nil {
+			if n, err := newNonce(tc.db, 0); err != nil {
 				if assert.NotNil(t, tc.err) {
 					ae, ok := err.(*Error)
 					assert.True(t, ok)
@@ -83,9 +87,10 @@ func TestNewNonce(t *testing.T) {
 
 func TestUseNonce(t *testing.T) {
 	type test struct {
-		id  string
-		db  nosql.DB
-		err *Error
+		id     string
+		db     nosql.DB
+		maxAge time.Duration
+		err    *Error
 	}
 	tests := map[string]func(t *testing.T) test{
 		"fail/update-not-found": func(t *testing.T) test {
@@ -126,6 +131,37 @@ func TestUseNonce(t *testing.T) {
 				err: ServerInternalErr(errors.Errorf("error deleting nonce %s: force", id)),
 			}
 		},
+		"fail/expired": func(t *testing.T) test {
+			id := "foo"
+			b, err := json.Marshal(&nonce{ID: id, Created: clock.Now().Add(-2 * time.Hour)})
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MUpdate: func(tx *database.Tx) error {
+						tx.Operations[0].Result = b
+						return nil
+					},
+				},
+				id:     id,
+				maxAge: time.Hour,
+				err:    BadNonceErr(errors.Errorf("nonce %s has expired", id)),
+			}
+		},
+		"ok/not-expired": func(t *testing.T) test {
+			id := "foo"
+			b, err := json.Marshal(&nonce{ID: id, Created: clock.Now().Add(-time.Minute)})
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MUpdate: func(tx *database.Tx) error {
+						tx.Operations[0].Result = b
+						return nil
+					},
+				},
+				id:     id,
+				maxAge: time.Hour,
+			}
+		},
 		"ok": func(t *testing.T) test {
 			id := "foo"
 			return test{
@@ -149,7 +185,7 @@ func TestUseNonce(t *testing.T) {
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := run(t)
-			if err := useNonce(tc.db, tc.id); err != nil {
+			if err := useNonce(tc.db, tc.id, tc.maxAge); err != nil {
 				if assert.NotNil(t, tc.err) {
 					ae, ok := err.(*Error)
 					assert.True(t, ok)
@@ -157,7 +193,307 @@ func TestUseNonce(t *testing.T) {
 					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
 					assert.Equals(t, ae.Type, tc.err.Type)
 				}
+			} else {
+				assert.Nil(t, tc.err)
 			}
 		})
 	}
 }
+
+func TestDeleteExpiredNonces(t *testing.T) {
+	now := clock.Now()
+	newEntry := func(id string, created time.Time) *database.Entry {
+		b, err := json.Marshal(&nonce{ID: id, Created: created})
+		assert.FatalError(t, err)
+		return &database.Entry{Bucket: nonceTable, Key: []byte(id), Value: b}
+	}
+	type test struct {
+		db    nosql.DB
+		count int
+		err   *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/list-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MList: func(bucket []byte) ([]*database.Entry, error) {
+						assert.Equals(t, bucket, nonceTable)
+						return nil, errors.New("force")
+					},
+				},
+				err: ServerInternalErr(errors.New("error listing nonces: force")),
+			}
+		},
+		"fail/unmarshal-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MList: func(bucket []byte) ([]*database.Entry, error) {
+						return []*database.Entry{{Bucket: nonceTable, Key: []byte("foo"), Value: []byte("{")}}, nil
+					},
+				},
+				err: ServerInternalErr(errors.New("error unmarshaling nonce foo")),
+			}
+		},
+		"fail/delete-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MList: func(bucket []byte) ([]*database.Entry, error) {
+						return []*database.Entry{newEntry("foo", now.Add(-2*time.Hour))}, nil
+					},
+					MDel: func(bucket, key []byte) error {
+						return errors.New("force")
+					},
+				},
+				err: ServerInternalErr(errors.New("error deleting nonce foo: force")),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MList: func(bucket []byte) ([]*database.Entry, error) {
+						return []*database.Entry{
+							newEntry("foo", now.Add(-2*time.Hour)),
+							newEntry("bar", now),
+							newEntry("zap", now.Add(-90*time.Minute)),
+						}, nil
+					},
+					MDel: func(bucket, key []byte) error {
+						assert.Equals(t, bucket, nonceTable)
+						assert.NotEquals(t, key, []byte("bar"))
+						return nil
+					},
+				},
+				count: 2,
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			count, err := deleteExpiredNonces(tc.db, now.Add(-time.Hour))
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.count, count)
+			}
+		})
+	}
+}
+
+func TestMemoryNonceStore(t *testing.T) {
+	s, err := newMemoryNonceStore(nil, 2, 0, false)
+	assert.FatalError(t, err)
+
+	n1, err := s.newNonce()
+	assert.FatalError(t, err)
+	n2, err := s.newNonce()
+	assert.FatalError(t, err)
+	n3, err := s.newNonce()
+	assert.FatalError(t, err)
+
+	// The oldest nonce is discarded.
+	assertAcmeError(t, BadNonceErr(nil), s.useNonce(n1.ID, 0))
+	assert.FatalError(t, s.useNonce(n2.ID, time.Hour))
+	assertAcmeError(t, BadNonceErr(nil), s.useNonce(n2.ID, time.Hour))
+
+	// Expired nonces are consumed but rejected.
+	n3.Created = clock.Now().Add(-2 * time.Hour)
+	assertAcmeError(t, BadNonceErr(errors.Errorf("nonce %s has expired", n3.ID)), s.useNonce(n3.ID, time.Hour))
+	assertAcmeError(t, BadNonceErr(nil), s.useNonce(n3.ID, time.Hour))
+
+	// deleteExpired removes the nonces created before the given time.
+	n4, err := s.newNonce()
+	assert.FatalError(t, err)
+	n4.Created = clock.Now().Add(-2 * time.Hour)
+	n5, err := s.newNonce()
+	assert.FatalError(t, err)
+	count, err := s.deleteExpired(clock.Now().Add(-time.Hour))
+	assert.FatalError(t, err)
+	assert.Equals(t, 1, count)
+	assertAcmeError(t, BadNonceErr(nil), s.useNonce(n4.ID, 0))
+	assert.FatalError(t, s.useNonce(n5.ID, 0))
+
+	// Without persistence flush does nothing.
+	assert.FatalError(t, s.flush())
+}
+
+func TestMemoryNonceStorePersistence(t *testing.T) {
+	stored, err := json.Marshal(&nonce{ID: "stored", Created: clock.Now().Add(-time.Minute)})
+	assert.FatalError(t, err)
+	tables := map[string]map[string][]byte{
+		string(nonceTable): {"stored": stored},
+	}
+	mdb := newCleanupDB(tables)
+	mdb.MSet = func(bucket, key, value []byte) error {
+		tables[string(bucket)][string(key)] = value
+		return nil
+	}
+
+	s, err := newMemoryNonceStore(mdb, 10, time.Hour, true)
+	assert.FatalError(t, err)
+
+	n1, err := s.newNonce()
+	assert.FatalError(t, err)
+	n2, err := s.newNonce()
+	assert.FatalError(t, err)
+	assert.Equals(t, 1, len(tables[string(nonceTable)]))
+
+	// A nonce used before the flush never reaches the database.
+	assert.FatalError(t, s.useNonce(n2.ID, 0))
+	assert.FatalError(t, s.flush())
+	assert.Equals(t, 2, len(tables[string(nonceTable)]))
+	assert.NotNil(t, tables[string(nonceTable)][n1.ID])
+	assert.Nil(t, tables[string(nonceTable)][n2.ID])
+
+	// The nonces in the database are loaded by a new store.
+	s, err = newMemoryNonceStore(mdb, 10, time.Hour, true)
+	assert.FatalError(t, err)
+	assert.FatalError(t, s.useNonce("stored", time.Hour))
+	assert.FatalError(t, s.flush())
+	assert.Equals(t, 1, len(tables[string(nonceTable)]))
+	assert.FatalError(t, s.useNonce(n1.ID, time.Hour))
+	assert.FatalError(t, s.flush())
+	assert.Equals(t, 0, len(tables[string(nonceTable)]))
+
+	// Errors are returned after writing the rest of the changes.
+	n3, err := s.newNonce()
+	assert.FatalError(t, err)
+	mdb.MSet = func(bucket, key, value []byte) error {
+		return errors.New("force")
+	}
+	err = s.flush()
+	if assert.NotNil(t, err) {
+		assert.Equals(t, "error storing nonce "+n3.ID+": force", err.Error())
+	}
+
+	// The failed changes are retried in the next flush.
+	mdb.MSet = func(bucket, key, value []byte) error {
+		tables[string(bucket)][string(key)] = value
+		return nil
+	}
+	assert.FatalError(t, s.flush())
+	assert.NotNil(t, tables[string(nonceTable)][n3.ID])
+
+	del := mdb.MDel
+	mdb.MDel = func(bucket, key []byte) error {
+		return errors.New("force")
+	}
+	assert.FatalError(t, s.useNonce(n3.ID, time.Hour))
+	err = s.flush()
+	if assert.NotNil(t, err) {
+		assert.Equals(t, "error deleting nonce "+n3.ID+": force", err.Error())
+	}
+	mdb.MDel = del
+	assert.FatalError(t, s.flush())
+	assert.Equals(t, 0, len(tables[string(nonceTable)]))
+
+	// A nonce used after a failed write never reaches the database.
+	n4, err := s.newNonce()
+	assert.FatalError(t, err)
+	mdb.MSet = func(bucket, key, value []byte) error {
+		return errors.New("force")
+	}
+	assert.NotNil(t, s.flush())
+	assert.FatalError(t, s.useNonce(n4.ID, time.Hour))
+	assert.FatalError(t, s.flush())
+	assert.Equals(t, 0, len(tables[string(nonceTable)]))
+
+	mdb.MList = func(bucket []byte) ([]*database.Entry, error) {
+		return nil, errors.New("force")
+	}
+	_, err = newMemoryNonceStore(mdb, 10, time.Hour, true)
+	if assert.NotNil(t, err) {
+		assert.Equals(t, "error listing nonces: force", err.Error())
+	}
+}
+
+func TestAuthorityMemoryNonceStore(t *testing.T) {
+	auth, err := NewAuthority(&db.MockNoSQLDB{}, "ca.smallstep.com", "acme", nil, WithConfig(&Config{
+		Nonce: &NonceConfig{Store: NonceStoreMemory},
+	}))
+	assert.FatalError(t, err)
+
+	n, err := auth.NewNonce()
+	assert.FatalError(t, err)
+	assert.FatalError(t, auth.UseNonce(n))
+	assertAcmeError(t, BadNonceErr(nil), auth.UseNonce(n))
+}
+
+func TestRedisNonceStore(t *testing.T) {
+	m, err := miniredis.Run()
+	assert.FatalError(t, err)
+	defer m.Close()
+	client, err := redis.New(&redis.Options{Address: m.Addr()})
+	assert.FatalError(t, err)
+	defer client.Close()
+
+	_, err = newNonceStore(nil, nil, &NonceConfig{Store: NonceStoreRedis})
+	assert.Equals(t, "the redis nonce store requires a redis client", err.Error())
+	s, err := newNonceStore(nil, client, &NonceConfig{Store: NonceStoreRedis, MaxAge: &provisioner.Duration{Duration: time.Minute}})
+	assert.FatalError(t, err)
+
+	n, err := s.newNonce()
+	assert.FatalError(t, err)
+	v, err := m.Get("step-ca:nonces:" + n.ID)
+	assert.FatalError(t, err)
+	b, err := json.Marshal(n)
+	assert.FatalError(t, err)
+	assert.Equals(t, string(b), v)
+	ttl := m.TTL("step-ca:nonces:" + n.ID)
+	assert.True(t, ttl <= time.Minute && ttl > 59*time.Second)
+
+	// Nonces can be used only once.
+	assert.FatalError(t, s.useNonce(n.ID, time.Minute))
+	assertAcmeError(t, BadNonceErr(nil), s.useNonce(n.ID, time.Minute))
+
+	// Redis removes the expired nonces.
+	count, err := s.deleteExpired(clock.Now())
+	assert.FatalError(t, err)
+	assert.Equals(t, 0, count)
+	assert.FatalError(t, s.flush())
+
+	m.Close()
+	_, err = s.newNonce()
+	assert.Equals(t, ServerInternalErr(nil).Type, err.(*Error).Type)
+	assert.Equals(t, ServerInternalErr(nil).Type, s.useNonce("foo", 0).(*Error).Type)
+}
+
+func TestNonceTTL(t *testing.T) {
+	now := clock.Now()
+	assert.Equals(t, time.Duration(0), (&nonce{Created: now}).ttl(0))
+	assert.Equals(t, time.Second, (&nonce{Created: now.Add(-2 * time.Hour)}).ttl(time.Hour))
+	ttl := (&nonce{Created: now.Add(-time.Minute)}).ttl(time.Hour)
+	assert.True(t, ttl <= 59*time.Minute && ttl > 58*time.Minute)
+
+	var got time.Duration
+	mdb := &ttlMockDB{
+		MockNoSQLDB: &db.MockNoSQLDB{
+			MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				return newval, true, nil
+			},
+			MSet: func(bucket, key, value []byte) error {
+				return nil
+			},
+		},
+		ttl: &got,
+	}
+	_, err := (&dbNonceStore{&metricsDB{mdb}, time.Hour}).newNonce()
+	assert.FatalError(t, err)
+	assert.True(t, got <= time.Hour && got > 59*time.Minute)
+
+	got = 0
+	s, err := newMemoryNonceStore(nil, 10, 30*time.Minute, false)
+	assert.FatalError(t, err)
+	s.db, s.pending = &metricsDB{mdb}, make(map[string]*nonce)
+	_, err = s.newNonce()
+	assert.FatalError(t, err)
+	assert.FatalError(t, s.flush())
+	assert.True(t, got <= 30*time.Minute && got > 29*time.Minute)
+}
@@ -0,0 +1,35 @@
+package acme
+
+import "github.com/smallstep/certificates/audit"
+
+// Option sets options to the ACME Authority.
+type Option func(*Authority) error
+
+// WithConfig sets the configuration of the ACME authority. No validation is
+// performed in the given value.
+func WithConfig(config *Config) Option {
+	return func(a *Authority) error {
+		a.config = config
+		return nil
+	}
+}
+
+// WithAuditLogger sets the logger used to record the lifecycle of the ACME
+// accounts in the audit log.
+func WithAuditLogger(l *audit.Logger) Option {
+	return func(a *Authority) error {
+		a.audit = l
+		return nil
+	}
+}
+
+// WithPrevious makes the ACME authority continue with the in-memory state of
+// the given authority, it's used when the configuration of the CA is reloaded.
+// The rate limits are kept, and if the nonce configuration has not changed,
+// the nonces issued by the previous authority are still valid.
+func WithPrevious(prev *Authority) Option {
+	return func(a *Authority) error {
+		a.previous = prev
+		return nil
+	}
+}
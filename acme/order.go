@@ -1,9 +1,11 @@
 package acme
 
 import (
+	"bytes"
 	"context"
 	"crypto/x509"
 	"encoding/json"
+	"net/http"
 	"sort"
 	"strings"
 	"time"
@@ -17,16 +19,19 @@ var defaultOrderExpiry = time.Hour * 24
 
 // Order contains order metadata for the ACME protocol order type.
 type Order struct {
-	Status         string       `json:"status"`
-	Expires        string       `json:"expires,omitempty"`
-	Identifiers    []Identifier `json:"identifiers"`
-	NotBefore      string       `json:"notBefore,omitempty"`
-	NotAfter       string       `json:"notAfter,omitempty"`
-	Error          interface{}  `json:"error,omitempty"`
-	Authorizations []string     `json:"authorizations"`
-	Finalize       string       `json:"finalize"`
-	Certificate    string       `json:"certificate,omitempty"`
-	ID             string       `json:"-"`
+	Status          string       `json:"status"`
+	Expires         string       `json:"expires,omitempty"`
+	Identifiers     []Identifier `json:"identifiers"`
+	NotBefore       string       `json:"notBefore,omitempty"`
+	NotAfter        string       `json:"notAfter,omitempty"`
+	Error           interface{}  `json:"error,omitempty"`
+	Authorizations  []string     `json:"authorizations"`
+	Finalize        string       `json:"finalize"`
+	Certificate     string       `json:"certificate,omitempty"`
+	AutoRenewal     *AutoRenewal `json:"auto-renewal,omitempty"`
+	StarCertificate string       `json:"star-certificate,omitempty"`
+	Profile         string       `json:"profile,omitempty"`
+	ID              string       `json:"-"`
 }
 
 // ToLog enables response logging.
@@ -45,10 +50,14 @@ func (o *Order) GetID() string {
 
 // OrderOptions options with which to create a new Order.
 type OrderOptions struct {
-	AccountID   string       `json:"accID"`
-	Identifiers []Identifier `json:"identifiers"`
-	NotBefore   time.Time    `json:"notBefore"`
-	NotAfter    time.Time    `json:"notAfter"`
+	AccountID      string       `json:"accID"`
+	Identifiers    []Identifier `json:"identifiers"`
+	NotBefore      time.Time    `json:"notBefore"`
+	NotAfter       time.Time    `json:"notAfter"`
+	AutoRenewal    *AutoRenewal `json:"autoRenewal,omitempty"`
+	Profile        string       `json:"profile,omitempty"`
+	ProvisionerID  string       `json:"provisionerID,omitempty"`
+	ChallengeTypes []string     `json:"challengeTypes,omitempty"`
 }
 
 type order struct {
@@ -60,21 +69,114 @@ type order struct {
 	Identifiers    []Identifier `json:"identifiers"`
 	NotBefore      time.Time    `json:"notBefore,omitempty"`
 	NotAfter       time.Time    `json:"notAfter,omitempty"`
-	Error          *Error       `json:"error,omitempty"`
+	Error          *AError      `json:"error,omitempty"`
 	Authorizations []string     `json:"authorizations"`
 	Certificate    string       `json:"certificate,omitempty"`
+	ProvisionerID  string       `json:"provisionerID,omitempty"`
+	AutoRenewal    *AutoRenewal `json:"autoRenewal,omitempty"`
+	Profile        string       `json:"profile,omitempty"`
+	CSR            []byte       `json:"csr,omitempty"`
+	RenewalSlot    time.Time    `json:"renewalSlot,omitempty"`
+}
+
+// authorizeIdentifiers checks that the identifiers of a new order can be
+// requested using the given provisioner. The problems of each rejected
+// identifier are returned as subproblems.
+func authorizeIdentifiers(p provisioner.Interface, ids []Identifier) error {
+	var subs []*Error
+	acmeProv, ok := p.(*provisioner.ACME)
+	for _, id := range ids {
+		switch {
+		case id.Type == "permanent-identifier":
+			var hasRoots bool
+			if ok {
+				_, hasRoots = acmeProv.GetAttestationRoots()
+			}
+			if !hasRoots {
+				subs = append(subs, RejectedIdentifierErr(errors.Errorf("provisioner %s does not support permanent-identifier identifiers", p.GetName())).WithIdentifier(id))
+			}
+		case id.Type == "TNAuthList":
+			var hasRoots bool
+			if ok {
+				_, hasRoots = acmeProv.GetTokenAuthorityRoots()
+			}
+			if !hasRoots {
+				subs = append(subs, RejectedIdentifierErr(errors.Errorf("provisioner %s does not support TNAuthList identifiers", p.GetName())).WithIdentifier(id))
+			} else if _, err := decodeTNAuthList(id.Value); err != nil {
+				subs = append(subs, MalformedErr(err).WithIdentifier(id))
+			}
+		case id.Type == "dns" && ok:
+			if err := acmeProv.AuthorizeDomain(id.Value); err != nil {
+				subs = append(subs, RejectedIdentifierErr(err).WithIdentifier(id))
+			} else if strings.HasPrefix(id.Value, "*.") && !acmeProv.IsChallengeEnabled("dns-01") {
+				subs = append(subs, RejectedIdentifierErr(errors.Errorf("wildcard domain %s requires the dns-01 challenge, "+
+					"which is not enabled by provisioner %s", id.Value, p.GetName())).WithIdentifier(id))
+			}
+		}
+	}
+	if err := SubproblemsErr(subs); err != nil {
+		return err
+	}
+	return nil
+}
+
+// getChallengeTypes returns the challenge types enabled by the provisioner,
+// or nil if all of them are enabled.
+func getChallengeTypes(p provisioner.Interface) []string {
+	if acmeProv, ok := p.(*provisioner.ACME); ok {
+		return acmeProv.ChallengeTypes
+	}
+	return nil
+}
+
+// authorizeValidity checks that the validity period requested by a new order
+// is allowed by the provisioner.
+func authorizeValidity(p provisioner.Interface, profile string, notBefore, notAfter time.Time) error {
+	if notBefore.IsZero() && notAfter.IsZero() {
+		return nil
+	}
+	acmeProv, ok := p.(*provisioner.ACME)
+	if !ok {
+		return nil
+	}
+	if err := acmeProv.AuthorizeValidity(profile, notBefore, notAfter); err != nil {
+		return MalformedErr(errors.Wrap(err, "invalid notBefore or notAfter"))
+	}
+	return nil
 }
 
-// newOrder returns a new Order type.
-func newOrder(db nosql.DB, ops OrderOptions) (*order, error) {
+// authorizeProfile returns the name of the certificate profile used by a new
+// order that requests the given profile.
+func authorizeProfile(p provisioner.Interface, name string) (string, error) {
+	acmeProv, ok := p.(*provisioner.ACME)
+	if !ok {
+		if name != "" {
+			return "", InvalidProfileErr(errors.Errorf("provisioner %s does not support profiles", p.GetName()))
+		}
+		return "", nil
+	}
+	profile, err := acmeProv.AuthorizeProfile(name)
+	if err != nil {
+		return "", InvalidProfileErr(err)
+	}
+	return profile, nil
+}
+
+// newOrder returns a new Order type. If the cleanup of expired objects is
+// enabled, the order is stored with a time to live, so databases that support
+// it remove the order once the cleanup would have deleted it.
+func newOrder(db nosql.DB, ops OrderOptions, cleanup *CleanupConfig) (*order, error) {
 	id, err := randID()
 	if err != nil {
 		return nil, err
 	}
 
+	// The order, its authorizations and challenges, and its entry in the
+	// "order IDs by account ID" index are written in a single transaction.
+	tx := newTxDB(db)
 	authzs := make([]string, len(ops.Identifiers))
 	for i, identifier := range ops.Identifiers {
-		az, err := newAuthz(db, ops.AccountID, identifier)
+		az, err := newAuthz(tx, ops.AccountID, identifier, ops.ChallengeTypes)
 		if err != nil {
 			return nil, err
 		}
@@ -92,53 +194,109 @@ func newOrder(db nosql.DB, ops OrderOptions) (*order, error) {
 		NotBefore:      ops.NotBefore,
 		NotAfter:       ops.NotAfter,
 		Authorizations: authzs,
+		ProvisionerID:  ops.ProvisionerID,
+		AutoRenewal:    ops.AutoRenewal,
+		Profile:        ops.Profile,
+	}
+	if cleanup.IsEnabled() {
+		tx.ttl = o.expiry().Add(cleanup.GetRetention()).Sub(now)
 	}
-	if err := o.save(db, nil); err != nil {
+	if err := o.save(tx, nil); err != nil {
 		return nil, err
 	}
 
-	// Update the "order IDs by account ID" index //
-	oids, err := getOrderIDsByAccount(db, ops.AccountID)
+	// The sequence of the index entry is reserved outside the transaction. If
+	// the commit fails, the entry is written as a tombstone, so the index can
+	// still be compacted past it.
+	seq, err := reserveOrderIndex(db, o.AccountID)
 	if err != nil {
 		return nil, err
 	}
-	newOids := append(oids, o.ID)
-	if err = orderIDs(newOids).save(db, oids, o.AccountID); err != nil {
-		db.Del(orderTable, []byte(o.ID))
+	key := orderIndexKey(o.AccountID, seq)
+	if err := tx.Set(accountOrdersTable, key, []byte(o.ID)); err != nil {
+		db.Set(accountOrdersTable, key, []byte(orderIndexTombstone))
+		return nil, ServerInternalErr(errors.Wrapf(err, "error storing order %s in the index of account %s", o.ID, o.AccountID))
+	}
+	if err := tx.commit(); err != nil {
+		db.Set(accountOrdersTable, key, []byte(orderIndexTombstone))
 		return nil, err
 	}
 	return o, nil
 }
 
-type orderIDs []string
+// orderReuseLookback is the number of recent orders of an account checked
+// for an order that can be returned again by a new order request.
+const orderReuseLookback = 20
 
-func (oids orderIDs) save(db nosql.DB, old orderIDs, accID string) error {
-	var (
-		err  error
-		oldb []byte
-	)
-	if len(old) == 0 {
-		oldb = nil
-	} else {
-		oldb, err = json.Marshal(old)
-		if err != nil {
-			return ServerInternalErr(errors.Wrap(err, "error marshaling old order IDs slice"))
-		}
+// findReusableOrder returns the most recent pending or ready order of the
+// account that was created with the same options, or nil if there is none.
+// Retried new order requests get this order instead of a new one. STAR
+// orders are never reused.
+func findReusableOrder(db nosql.DB, ops OrderOptions) (*order, error) {
+	if ops.AutoRenewal != nil {
+		return nil, nil
 	}
-	newb, err := json.Marshal(oids)
+	oids, err := getRecentOrderIDsByAccount(db, ops.AccountID, orderReuseLookback)
 	if err != nil {
-		return ServerInternalErr(errors.Wrap(err, "error marshaling new order IDs slice"))
+		return nil, err
 	}
-	_, swapped, err := db.CmpAndSwap(ordersByAccountIDTable, []byte(accID), oldb, newb)
-	switch {
-	case err != nil:
-		return ServerInternalErr(errors.Wrapf(err, "error storing order IDs for account %s", accID))
-	case !swapped:
-		return ServerInternalErr(errors.Errorf("error storing order IDs "+
-			"for account %s; order IDs changed since last read", accID))
-	default:
-		return nil
+	orders, err := getOrders(db, oids)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(orders) - 1; i >= 0; i-- {
+		o := orders[i]
+		if !o.isReusableFor(ops) {
+			continue
+		}
+		// The order or its authorizations may have expired or failed.
+		if o, err = o.updateStatus(db); err != nil {
+			return nil, err
+		}
+		if o.Status == StatusPending || o.Status == StatusReady {
+			return o, nil
+		}
+	}
+	return nil, nil
+}
+
+// isReusableFor returns true if the order is pending or ready, and it was
+// created with the given options.
+func (o *order) isReusableFor(ops OrderOptions) bool {
+	return (o.Status == StatusPending || o.Status == StatusReady) &&
+		o.AutoRenewal == nil &&
+		o.ProvisionerID == ops.ProvisionerID &&
+		o.Profile == ops.Profile &&
+		o.NotBefore.Equal(ops.NotBefore) &&
+		o.NotAfter.Equal(ops.NotAfter) &&
+		sameIdentifiers(o.Identifiers, ops.Identifiers)
+}
+
+// sameIdentifiers returns true if both lists contain the same identifiers in
+// any order. The values of dns identifiers are case insensitive.
+func sameIdentifiers(a, b []Identifier) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	keys := func(ids []Identifier) []string {
+		ret := make([]string, len(ids))
+		for i, id := range ids {
+			if id.Type == "dns" {
+				ret[i] = id.Type + ":" + strings.ToLower(id.Value)
+			} else {
+				ret[i] = id.Type + ":" + id.Value
+			}
+		}
+		sort.Strings(ret)
+		return ret
 	}
+	ka, kb := keys(a), keys(b)
+	for i := range ka {
+		if ka[i] != kb[i] {
+			return false
+		}
+	}
+	return true
 }
 
 func (o *order) save(db nosql.DB, old *order) error {
@@ -186,7 +344,7 @@ func (o *order) updateStatus(db nosql.DB) (*order, error) {
 		// check expiry
 		if now.After(o.Expires) {
 			newOrder.Status = StatusInvalid
-			newOrder.Error = MalformedErr(errors.New("order has expired"))
+			newOrder.Error = MalformedErr(errors.New("order has expired")).ToACME()
 			break
 		}
 		return o, nil
@@ -194,29 +352,43 @@ func (o *order) updateStatus(db nosql.DB) (*order, error) {
 		// check expiry
 		if now.After(o.Expires) {
 			newOrder.Status = StatusInvalid
-			newOrder.Error = MalformedErr(errors.New("order has expired"))
+			newOrder.Error = MalformedErr(errors.New("order has expired")).ToACME()
 			break
 		}
 
+		var invalid []authz
 		var count = map[string]int{
 			StatusValid:   0,
 			StatusInvalid: 0,
 			StatusPending: 0,
 		}
-		for _, azID := range o.Authorizations {
-			az, err := getAuthz(db, azID)
-			if err != nil {
-				return nil, err
-			}
-			if az, err = az.updateStatus(db); err != nil {
-				return nil, err
-			}
+		azs, err := getAuthzs(db, o.Authorizations)
+		if err != nil {
+			return nil, err
+		}
+		// The status of each authz may require reading its challenges, so
+		// they are updated concurrently.
+		if err := forEach(len(azs), func(i int) (err error) {
+			azs[i], err = azs[i].updateStatus(db)
+			return
+		}); err != nil {
+			return nil, err
+		}
+		for _, az := range azs {
 			st := az.getStatus()
 			count[st]++
+			if st == StatusInvalid {
+				invalid = append(invalid, az)
+			}
 		}
 		switch {
 		case count[StatusInvalid] > 0:
 			newOrder.Status = StatusInvalid
+			aerr, err := invalidAuthzsErr(db, invalid)
+			if err != nil {
+				return nil, err
+			}
+			newOrder.Error = aerr
 		case count[StatusPending] > 0:
 			break
 		case count[StatusValid] == len(o.Authorizations):
@@ -235,8 +407,9 @@ func (o *order) updateStatus(db nosql.DB) (*order, error) {
 }
 
 // finalize signs a certificate if the necessary conditions for Order completion
-// have been met.
-func (o *order) finalize(db nosql.DB, csr *x509.CertificateRequest, auth SignAuthority, p provisioner.Interface) (*order, error) {
+// have been met. The CSR must also satisfy the given policy, if any, and the
+// crypto policy of the authority.
+func (o *order) finalize(ctx context.Context, db nosql.DB, csr *x509.CertificateRequest, policy *CSRConfig, auth SignAuthority, p provisioner.Interface) (*order, error) {
 	var err error
 	if o, err = o.updateStatus(db); err != nil {
 		return nil, err
@@ -254,6 +427,86 @@ func (o *order) finalize(db nosql.DB, csr *x509.CertificateRequest, auth SignAut
 		return nil, ServerInternalErr(errors.Errorf("unexpected status %s for order %s", o.Status, o.ID))
 	}
 
+	if err := o.validateCSR(db, csr); err != nil {
+		return nil, err
+	}
+	if err := policy.checkCSR(csr); err != nil {
+		return nil, err
+	}
+	if ca, ok := auth.(cryptoPolicyAuthority); ok {
+		if err := ca.CheckCertificateRequest(csr); err != nil {
+			return nil, BadCSRErr(err)
+		}
+	}
+	if err := checkCompromisedKey(db, csr); err != nil {
+		return nil, err
+	}
+
+	// STAR orders keep the CSR to issue the following certificates.
+	if o.AutoRenewal != nil {
+		slot := o.AutoRenewal.StartDate
+		if now := clock.Now(); slot.Before(now) {
+			slot = now
+		}
+		return o.renew(db, csr, auth, p, slot)
+	}
+
+	cert, err := o.issue(ctx, db, csr, auth, p, provisioner.Options{
+		NotBefore: provisioner.NewTimeDuration(o.NotBefore),
+		NotAfter:  provisioner.NewTimeDuration(o.NotAfter),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_newOrder := *o
+	newOrder := &_newOrder
+	newOrder.Certificate = cert.ID
+	newOrder.Status = StatusValid
+	if err := newOrder.save(db, o); err != nil {
+		return nil, err
+	}
+	return newOrder, nil
+}
+
+// validateCSR checks that the CSR requests the identifiers of the order. The
+// CSR of a permanent-identifier order must only contain the identifier in the
+// common name and use the attested key, and the CSR of a TNAuthList order must
+// request the TNAuthList extension with the value of the identifier.
+func (o *order) validateCSR(db nosql.DB, csr *x509.CertificateRequest) error {
+	if len(o.Identifiers) == 1 && o.Identifiers[0].Type == "TNAuthList" {
+		tnAuthList, err := decodeTNAuthList(o.Identifiers[0].Value)
+		if err != nil {
+			return ServerInternalErr(err)
+		}
+		var found bool
+		for _, ext := range csr.Extensions {
+			if ext.Id.Equal(oidTNAuthList) {
+				if !bytes.Equal(ext.Value, tnAuthList) {
+					return BadCSRErr(errors.New("CSR TNAuthList extension does not match the TNAuthList identifier"))
+				}
+				found = true
+			}
+		}
+		if !found {
+			return BadCSRErr(errors.New("CSR of a TNAuthList order must contain the TNAuthList extension"))
+		}
+		if len(csr.DNSNames) > 0 || len(csr.IPAddresses) > 0 || len(csr.EmailAddresses) > 0 || len(csr.URIs) > 0 {
+			return BadCSRErr(errors.New("CSR of a TNAuthList order cannot contain subject alternative names"))
+		}
+		return nil
+	}
+	if len(o.Identifiers) == 1 && o.Identifiers[0].Type == "permanent-identifier" {
+		if csr.Subject.CommonName != o.Identifiers[0].Value {
+			return BadCSRErr(errors.Errorf("CSR common name does not match the permanent identifier: "+
+				"CSR common name = %s, permanent identifier = %s", csr.Subject.CommonName, o.Identifiers[0].Value))
+		}
+		if len(csr.DNSNames) > 0 || len(csr.IPAddresses) > 0 || len(csr.EmailAddresses) > 0 || len(csr.URIs) > 0 {
+			return BadCSRErr(errors.New("CSR of a permanent-identifier order cannot contain subject alternative names"))
+		}
+		return o.validateAttestedKey(db, csr)
+	}
+
 	// RFC8555: The CSR MUST indicate the exact same set of requested
 	// identifiers as the initial newOrder request. Identifiers of type "dns"
 	// MUST appear either in the commonName portion of the requested subject
@@ -271,48 +524,133 @@ func (o *order) finalize(db nosql.DB, csr *x509.CertificateRequest, auth SignAut
 
 	// Validate identifier names against CSR alternative names.
 	if len(csr.DNSNames) != len(orderNames) {
-		return nil, BadCSRErr(errors.Errorf("CSR names do not match identifiers exactly: CSR names = %v, Order names = %v", csr.DNSNames, orderNames))
+		return BadCSRErr(errors.Errorf("CSR names do not match identifiers exactly: CSR names = %v, Order names = %v", csr.DNSNames, orderNames))
 	}
 	for i := range csr.DNSNames {
 		if csr.DNSNames[i] != orderNames[i] {
-			return nil, BadCSRErr(errors.Errorf("CSR names do not match identifiers exactly: CSR names = %v, Order names = %v", csr.DNSNames, orderNames))
+			return BadCSRErr(errors.Errorf("CSR names do not match identifiers exactly: CSR names = %v, Order names = %v", csr.DNSNames, orderNames))
+		}
+	}
+	return nil
+}
+
+// validateAttestedKey checks that the key of the CSR is the key attested by
+// the device-attest-01 challenge of the order.
+func (o *order) validateAttestedKey(db nosql.DB, csr *x509.CertificateRequest) error {
+	fingerprint, err := attestedKeyFingerprint(csr.PublicKey)
+	if err != nil {
+		return BadCSRErr(err)
+	}
+	for _, azID := range o.Authorizations {
+		az, err := getAuthz(db, azID)
+		if err != nil {
+			return err
+		}
+		for _, chID := range az.getChallenges() {
+			ch, err := getChallenge(db, chID)
+			if err != nil {
+				return err
+			}
+			dc, ok := ch.(*deviceAttest01Challenge)
+			if ok && dc.Status == StatusValid && dc.Fingerprint != "" && dc.Fingerprint == fingerprint {
+				return nil
+			}
 		}
 	}
+	return BadCSRErr(errors.New("CSR public key does not match the attested key"))
+}
 
+// issue signs and stores a new certificate for the order.
+func (o *order) issue(ctx context.Context, db nosql.DB, csr *x509.CertificateRequest, auth SignAuthority, p provisioner.Interface, opts provisioner.Options) (*certificate, error) {
 	// Get authorizations from the ACME provisioner.
-	ctx := provisioner.NewContextWithMethod(context.Background(), provisioner.SignMethod)
-	signOps, err := p.AuthorizeSign(ctx, "")
+	var signOps []provisioner.SignOption
+	var err error
+	if acmeProv, ok := p.(*provisioner.ACME); ok {
+		signOps, err = acmeProv.AuthorizeProfileSign(o.Profile)
+	} else {
+		ctx := provisioner.NewContextWithMethod(context.Background(), provisioner.SignMethod)
+		signOps, err = p.AuthorizeSign(ctx, "")
+	}
 	if err != nil {
 		return nil, ServerInternalErr(errors.Wrapf(err, "error retrieving authorization options from ACME provisioner"))
 	}
 
+	// The TNAuthList extension is not copied from the CSR by default.
+	if len(o.Identifiers) == 1 && o.Identifiers[0].Type == "TNAuthList" {
+		tnAuthList, err := decodeTNAuthList(o.Identifiers[0].Value)
+		if err != nil {
+			return nil, ServerInternalErr(err)
+		}
+		signOps = append(signOps, tnAuthListExtension(tnAuthList))
+	}
+
 	// Create and store a new certificate.
-	certChain, err := auth.Sign(csr, provisioner.Options{
-		NotBefore: provisioner.NewTimeDuration(o.NotBefore),
-		NotAfter:  provisioner.NewTimeDuration(o.NotAfter),
-	}, signOps...)
+	var certChain []*x509.Certificate
+	if ca, ok := auth.(contextSignAuthority); ok {
+		certChain, err = ca.SignWithContext(ctx, csr, opts, signOps...)
+	} else {
+		certChain, err = auth.Sign(csr, opts, signOps...)
+	}
 	if err != nil {
+		// Names rejected by a name policy are reported as forbidden, and CSRs
+		// rejected by the crypto policy as bad requests.
+		if sc, ok := err.(interface{ StatusCode() int }); ok {
+			switch sc.StatusCode() {
+			case http.StatusForbidden:
+				return nil, RejectedIdentifierErr(errors.Wrapf(err, "error generating certificate for order %s", o.ID))
+			case http.StatusBadRequest:
+				return nil, BadCSRErr(errors.Wrapf(err, "error generating certificate for order %s", o.ID))
+			}
+		}
 		return nil, ServerInternalErr(errors.Wrapf(err, "error generating certificate for order %s", o.ID))
 	}
 
-	cert, err := newCert(db, CertOptions{
+	return newCert(db, CertOptions{
 		AccountID:     o.AccountID,
 		OrderID:       o.ID,
 		Leaf:          certChain[0],
 		Intermediates: certChain[1:],
 	})
-	if err != nil {
-		return nil, err
+}
+
+// expiry returns the time after which the order is no longer used. STAR
+// orders are used until the end of the auto renewal period.
+func (o *order) expiry() time.Time {
+	if o.AutoRenewal != nil && o.AutoRenewal.EndDate.After(o.Expires) {
+		return o.AutoRenewal.EndDate
 	}
+	return o.Expires
+}
 
-	_newOrder := *o
-	newOrder := &_newOrder
-	newOrder.Certificate = cert.ID
-	newOrder.Status = StatusValid
-	if err := newOrder.save(db, o); err != nil {
-		return nil, err
+// invalidAuthzsErr returns the error of an order with invalid authorizations.
+// The error of each authorization is the error of its failed challenge, and
+// multiple authorizations are reported as subproblems of a compound error.
+func invalidAuthzsErr(db nosql.DB, azs []authz) (*AError, error) {
+	var subs []*AError
+	for _, az := range azs {
+		sub := RejectedIdentifierErr(errors.Errorf("authorization for %s is invalid", az.getIdentifier().Value)).ToACME()
+		for _, chID := range az.getChallenges() {
+			ch, err := getChallenge(db, chID)
+			if err != nil {
+				return nil, err
+			}
+			if chErr := ch.getError(); chErr != nil {
+				_sub := *chErr
+				sub = &_sub
+				break
+			}
+		}
+		sub.Identifier = az.getIdentifier()
+		subs = append(subs, sub)
 	}
-	return newOrder, nil
+	if len(subs) == 1 {
+		return subs[0], nil
+	}
+	aerr := CompoundErr(errors.Errorf("%d authorizations are invalid", len(subs))).ToACME()
+	for _, sub := range subs {
+		aerr.Subproblems = append(aerr.Subproblems, sub)
+	}
+	return aerr, nil
 }
 
 // getOrder retrieves and unmarshals an ACME Order type from the database.
@@ -330,26 +668,62 @@ func getOrder(db nosql.DB, id string) (*order, error) {
 	return &o, nil
 }
 
+// getOrders retrieves and unmarshals the ACME orders with the given ids using
+// a single batched read.
+func getOrders(db nosql.DB, ids []string) ([]*order, error) {
+	values, err := batchGet(db, orderTable, "order", ids)
+	if err != nil {
+		return nil, ServerInternalErr(err)
+	}
+	orders := make([]*order, len(ids))
+	for i, b := range values {
+		if b == nil {
+			return nil, MalformedErr(errors.Errorf("order %s not found", ids[i]))
+		}
+		var o order
+		if err := json.Unmarshal(b, &o); err != nil {
+			return nil, ServerInternalErr(errors.Wrap(err, "error unmarshaling order"))
+		}
+		orders[i] = &o
+	}
+	return orders, nil
+}
+
 // toACME converts the internal Order type into the public acmeOrder type for
 // presentation in the ACME protocol.
-func (o *order) toACME(db nosql.DB, dir *directory, p provisioner.Interface) (*Order, error) {
+func (o *order) toACME(ctx context.Context, db nosql.DB, dir *directory, p provisioner.Interface) (*Order, error) {
 	azs := make([]string, len(o.Authorizations))
 	for i, aid := range o.Authorizations {
-		azs[i] = dir.getLink(AuthzLink, URLSafeProvisionerName(p), true, aid)
+		azs[i] = dir.getLink(ctx, AuthzLink, URLSafeProvisionerName(p), true, aid)
 	}
 	ao := &Order{
 		Status:         o.Status,
 		Expires:        o.Expires.Format(time.RFC3339),
 		Identifiers:    o.Identifiers,
-		NotBefore:      o.NotBefore.Format(time.RFC3339),
-		NotAfter:       o.NotAfter.Format(time.RFC3339),
 		Authorizations: azs,
-		Finalize:       dir.getLink(FinalizeLink, URLSafeProvisionerName(p), true, o.ID),
+		Finalize:       dir.getLink(ctx, FinalizeLink, URLSafeProvisionerName(p), true, o.ID),
+		Profile:        o.Profile,
 		ID:             o.ID,
 	}
 
-	if o.Certificate != "" {
-		ao.Certificate = dir.getLink(CertificateLink, URLSafeProvisionerName(p), true, o.Certificate)
+	if o.Error != nil {
+		ao.Error = o.Error
+	}
+	if !o.NotBefore.IsZero() {
+		ao.NotBefore = o.NotBefore.Format(time.RFC3339)
+	}
+	if !o.NotAfter.IsZero() {
+		ao.NotAfter = o.NotAfter.Format(time.RFC3339)
+	}
+
+	switch {
+	case o.AutoRenewal != nil:
+		ao.AutoRenewal = o.AutoRenewal
+		if o.Certificate != "" {
+			ao.StarCertificate = dir.getLink(ctx, StarCertificateLink, URLSafeProvisionerName(p), true, o.ID)
+		}
+	case o.Certificate != "":
+		ao.Certificate = dir.getLink(ctx, CertificateLink, URLSafeProvisionerName(p), true, o.Certificate)
 	}
 	return ao, nil
 }
@@ -0,0 +1,396 @@
+package acme
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/nosql"
+)
+
+// The orders-by-account index maps an account to the ids of its orders. Every
+// order id is stored in its own entry of the accountOrdersTable, with the key
+// "<accountID>/<sequence>", where the sequence is a zero-padded hex number
+// that sorts the entries of an account by creation time. The entry with the
+// account id as key is the head of the index, it contains the sequences of the
+// first entry that is not removed and of the next entry, encoded in the same
+// way. Appending an order atomically increments the next sequence, and the
+// entries of an account are read by their range of sequences, so the index
+// is never rewritten as a whole.
+//
+// Removing an order replaces its entry with a tombstone. Once the first
+// entries of the index are tombstones, the first sequence in the head moves
+// past them and they are deleted, so reading the index only costs the entries
+// from the oldest order that is kept. An entry that is missing, instead of
+// removed, belongs to an order that is being created, so the first sequence
+// never moves past it.
+//
+// Previous versions stored the index as a JSON array in the
+// ordersByAccountIDTable. These arrays are still read, and their ids come
+// before the ones in the new index, but new orders are never added to them.
+//
+// The certificates-by-account index uses the same format in the
+// accountCertsTable. Certificates are never removed, so it has no tombstones
+// other than the ones of the certificates that failed to be stored.
+
+// orderIndexRetries is the maximum number of attempts to update the head of
+// the index if it's modified concurrently.
+const orderIndexRetries = 10
+
+// orderIndexTombstone is the value of a removed entry of the index. It can't
+// be an order id.
+const orderIndexTombstone = "-"
+
+// accountIndex is an index of the objects of an account, stored in the given
+// table. The name of the objects is used in the errors.
+type accountIndex struct {
+	table []byte
+	name  string
+}
+
+var (
+	orderIndex = accountIndex{table: accountOrdersTable, name: "order"}
+	certIndex  = accountIndex{table: accountCertsTable, name: "certificate"}
+)
+
+// orderIndexHead is the head of the index of an account.
+type orderIndexHead struct {
+	first, next uint64
+}
+
+// parseOrderIndexHead parses the head of an index as stored in the database.
+func parseOrderIndexHead(b []byte) (orderIndexHead, error) {
+	parts := strings.Split(string(b), ":")
+	if len(parts) != 2 {
+		return orderIndexHead{}, errors.Errorf("invalid index head %q", b)
+	}
+	first, err := strconv.ParseUint(parts[0], 16, 64)
+	if err != nil {
+		return orderIndexHead{}, err
+	}
+	next, err := strconv.ParseUint(parts[1], 16, 64)
+	if err != nil {
+		return orderIndexHead{}, err
+	}
+	if first > next {
+		return orderIndexHead{}, errors.Errorf("invalid index head %q", b)
+	}
+	return orderIndexHead{first: first, next: next}, nil
+}
+
+// bytes returns the head as stored in the database.
+func (h orderIndexHead) bytes() []byte {
+	return []byte(fmt.Sprintf("%016x:%016x", h.first, h.next))
+}
+
+// orderIndexEntry is an entry of the index of an account, the orderID is the
+// id of the indexed object.
+type orderIndexEntry struct {
+	seq     uint64
+	orderID string
+}
+
+func (e orderIndexEntry) removed() bool {
+	return e.orderID == orderIndexTombstone
+}
+
+// orderIndexKey returns the key of the index entry of the account with the
+// given sequence.
+func orderIndexKey(accID string, seq uint64) []byte {
+	return []byte(fmt.Sprintf("%s/%016x", accID, seq))
+}
+
+// getOrderIndexHead returns the head of the orders-by-account index of the
+// account as stored in the database, and parsed.
+func getOrderIndexHead(db nosql.DB, accID string) ([]byte, orderIndexHead, error) {
+	return orderIndex.getHead(db, accID)
+}
+
+// reserveOrderIndex reserves the next entry of the orders-by-account index of
+// the account and returns its sequence.
+func reserveOrderIndex(db nosql.DB, accID string) (uint64, error) {
+	return orderIndex.reserve(db, accID)
+}
+
+// listOrderIndex returns the entries of the orders-by-account index of the
+// account with a sequence in the range [from, to).
+func listOrderIndex(db nosql.DB, accID string, from, to uint64) ([]orderIndexEntry, error) {
+	return orderIndex.list(db, accID, from, to)
+}
+
+// getHead returns the head of the index of the account as stored in the
+// database, and parsed.
+func (idx accountIndex) getHead(db nosql.DB, accID string) ([]byte, orderIndexHead, error) {
+	b, err := db.Get(idx.table, []byte(accID))
+	switch {
+	case nosql.IsErrNotFound(err):
+		return nil, orderIndexHead{}, nil
+	case err != nil:
+		return nil, orderIndexHead{}, ServerInternalErr(errors.Wrapf(err, "error loading %s index for account %s", idx.name, accID))
+	}
+	head, err := parseOrderIndexHead(b)
+	if err != nil {
+		return nil, orderIndexHead{}, ServerInternalErr(errors.Wrapf(err, "error parsing %s index for account %s", idx.name, accID))
+	}
+	return b, head, nil
+}
+
+// reserve reserves the next entry of the index of the account and returns its
+// sequence. The entry must be written with the id of the object, or with a
+// tombstone if the object is not created; while it's not written, the index
+// has a gap that is skipped when it's read.
+func (idx accountIndex) reserve(db nosql.DB, accID string) (uint64, error) {
+	for i := 0; i < orderIndexRetries; i++ {
+		old, head, err := idx.getHead(db, accID)
+		if err != nil {
+			return 0, err
+		}
+		seq := head.next
+		head.next++
+		_, swapped, err := db.CmpAndSwap(idx.table, []byte(accID), old, head.bytes())
+		if err != nil {
+			return 0, ServerInternalErr(errors.Wrapf(err, "error storing %s index for account %s", idx.name, accID))
+		}
+		if swapped {
+			return seq, nil
+		}
+	}
+	return 0, ServerInternalErr(errors.Errorf("error storing %s index for account %s; "+
+		"too many concurrent updates", idx.name, accID))
+}
+
+// list returns the entries of the index of the account with a sequence in the
+// range [from, to), including the tombstones of the removed ones. Missing
+// entries are skipped.
+func (idx accountIndex) list(db nosql.DB, accID string, from, to uint64) ([]orderIndexEntry, error) {
+	if from >= to {
+		return nil, nil
+	}
+	keys := make([]string, 0, to-from)
+	for seq := from; seq < to; seq++ {
+		keys = append(keys, string(orderIndexKey(accID, seq)))
+	}
+	values, err := batchGet(db, idx.table, idx.name+" index entry", keys)
+	if err != nil {
+		return nil, ServerInternalErr(err)
+	}
+	var entries []orderIndexEntry
+	for i, b := range values {
+		if b != nil {
+			entries = append(entries, orderIndexEntry{seq: from + uint64(i), orderID: string(b)})
+		}
+	}
+	return entries, nil
+}
+
+// listAll returns the ids of the objects in the index of the account, sorted
+// by creation time.
+func (idx accountIndex) listAll(db nosql.DB, accID string) ([]string, error) {
+	_, head, err := idx.getHead(db, accID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := idx.list(db, accID, head.first, head.next)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.removed() {
+			ids = append(ids, e.orderID)
+		}
+	}
+	return ids, nil
+}
+
+// getOrderIDsByAccount retrieves a list of Order IDs that were created by the
+// account, sorted by creation time.
+func getOrderIDsByAccount(db nosql.DB, id string) ([]string, error) {
+	return getRecentOrderIDsByAccount(db, id, -1)
+}
+
+// getRecentOrderIDsByAccount retrieves the ids of the n most recent orders of
+// the account, sorted by creation time. A negative n returns all of them.
+func getRecentOrderIDsByAccount(db nosql.DB, id string, n int) ([]string, error) {
+	_, head, err := getOrderIndexHead(db, id)
+	if err != nil {
+		return nil, err
+	}
+	from := head.first
+	if n >= 0 && head.next-from > uint64(n) {
+		from = head.next - uint64(n)
+	}
+	entries, err := listOrderIndex(db, id, from, head.next)
+	if err != nil {
+		return nil, err
+	}
+	oids := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.removed() {
+			oids = append(oids, e.orderID)
+		}
+	}
+	if n >= 0 && len(oids) >= n {
+		return oids, nil
+	}
+
+	legacy, err := getLegacyOrderIDsByAccount(db, id)
+	if err != nil {
+		return nil, err
+	}
+	if n >= 0 && len(legacy)+len(oids) > n {
+		legacy = legacy[len(legacy)+len(oids)-n:]
+	}
+	return append(legacy, oids...), nil
+}
+
+// getLegacyOrderIDsByAccount retrieves the list of Order IDs of the account
+// stored in the JSON array used by previous versions of the index.
+func getLegacyOrderIDsByAccount(db nosql.DB, id string) ([]string, error) {
+	b, err := db.Get(ordersByAccountIDTable, []byte(id))
+	if err != nil {
+		if nosql.IsErrNotFound(err) {
+			return []string{}, nil
+		}
+		return nil, ServerInternalErr(errors.Wrapf(err, "error loading orderIDs for account %s", id))
+	}
+	var orderIDs []string
+	if err := json.Unmarshal(b, &orderIDs); err != nil {
+		return nil, ServerInternalErr(errors.Wrapf(err, "error unmarshaling orderIDs for account %s", id))
+	}
+	return orderIDs, nil
+}
+
+// removeOrderIDs removes the given order IDs from the orders-by-account index,
+// and compacts the index if its first entries are removed.
+func removeOrderIDs(db nosql.DB, accID string, remove []string) error {
+	if len(remove) == 0 {
+		return nil
+	}
+	skip := make(map[string]bool, len(remove))
+	for _, id := range remove {
+		skip[id] = true
+	}
+
+	_, head, err := getOrderIndexHead(db, accID)
+	if err != nil {
+		return err
+	}
+	entries, err := listOrderIndex(db, accID, head.first, head.next)
+	if err != nil {
+		return err
+	}
+	for i, e := range entries {
+		if !skip[e.orderID] {
+			continue
+		}
+		if err := db.Set(accountOrdersTable, orderIndexKey(accID, e.seq), []byte(orderIndexTombstone)); err != nil {
+			return ServerInternalErr(errors.Wrapf(err, "error deleting order %s from the index of account %s", e.orderID, accID))
+		}
+		entries[i].orderID = orderIndexTombstone
+	}
+	first := head.first
+	for _, e := range entries {
+		if e.seq != first || !e.removed() {
+			break
+		}
+		first++
+	}
+	if err := compactOrderIndex(db, accID, first); err != nil {
+		return err
+	}
+	return removeLegacyOrderIDs(db, accID, skip)
+}
+
+// compactOrderIndex moves the first sequence of the index of the account to
+// the given one, and deletes the tombstones before it. All the entries before
+// the sequence must be tombstones.
+func compactOrderIndex(db nosql.DB, accID string, first uint64) error {
+	for i := 0; i < orderIndexRetries; i++ {
+		old, head, err := getOrderIndexHead(db, accID)
+		if err != nil {
+			return err
+		}
+		if head.first >= first {
+			return nil
+		}
+		from := head.first
+		head.first = first
+		_, swapped, err := db.CmpAndSwap(accountOrdersTable, []byte(accID), old, head.bytes())
+		if err != nil {
+			return ServerInternalErr(errors.Wrapf(err, "error storing order index for account %s", accID))
+		}
+		if !swapped {
+			continue
+		}
+		for seq := from; seq < first; seq++ {
+			if err := db.Del(accountOrdersTable, orderIndexKey(accID, seq)); err != nil && !nosql.IsErrNotFound(err) {
+				return ServerInternalErr(errors.Wrapf(err, "error compacting order index for account %s", accID))
+			}
+		}
+		return nil
+	}
+	return ServerInternalErr(errors.Errorf("error storing order index for account %s; "+
+		"too many concurrent updates", accID))
+}
+
+// removeLegacyOrderIDs removes the given order IDs from the JSON array used by
+// previous versions of the index. The update is retried if the array is
+// modified concurrently.
+func removeLegacyOrderIDs(db nosql.DB, accID string, skip map[string]bool) error {
+	var err error
+	for i := 0; i < 3; i++ {
+		var oids []string
+		if oids, err = getLegacyOrderIDsByAccount(db, accID); err != nil {
+			return err
+		}
+		newOids := []string{}
+		for _, id := range oids {
+			if !skip[id] {
+				newOids = append(newOids, id)
+			}
+		}
+		if len(newOids) == len(oids) {
+			return nil
+		}
+		if err = orderIDs(newOids).save(db, oids, accID); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// orderIDs is the JSON array of order ids used by previous versions of the
+// index.
+type orderIDs []string
+
+func (oids orderIDs) save(db nosql.DB, old orderIDs, accID string) error {
+	var (
+		err  error
+		oldb []byte
+	)
+	if len(old) == 0 {
+		oldb = nil
+	} else {
+		oldb, err = json.Marshal(old)
+		if err != nil {
+			return ServerInternalErr(errors.Wrap(err, "error marshaling old order IDs slice"))
+		}
+	}
+	newb, err := json.Marshal(oids)
+	if err != nil {
+		return ServerInternalErr(errors.Wrap(err, "error marshaling new order IDs slice"))
+	}
+	_, swapped, err := db.CmpAndSwap(ordersByAccountIDTable, []byte(accID), oldb, newb)
+	switch {
+	case err != nil:
+		return ServerInternalErr(errors.Wrapf(err, "error storing order IDs for account %s", accID))
+	case !swapped:
+		return ServerInternalErr(errors.Errorf("error storing order IDs "+
+			"for account %s; order IDs changed since last read", accID))
+	default:
+		return nil
+	}
+}
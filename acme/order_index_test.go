@@ -0,0 +1,368 @@
+package acme
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/nosql"
+	"github.com/smallstep/nosql/database"
+)
+
+// newOrderIndexTables returns the tables of an orders-by-account index of the
+// account with the given legacy and new order ids.
+func newOrderIndexTables(accID string, legacy []string, oids ...string) map[string]map[string][]byte {
+	tables := map[string]map[string][]byte{
+		string(ordersByAccountIDTable): {},
+		string(accountOrdersTable):     {},
+	}
+	if legacy != nil {
+		b, err := json.Marshal(legacy)
+		if err != nil {
+			panic(err)
+		}
+		tables[string(ordersByAccountIDTable)][accID] = b
+	}
+	if len(oids) > 0 {
+		tables[string(accountOrdersTable)][accID] = orderIndexHead{next: uint64(len(oids))}.bytes()
+		for i, id := range oids {
+			tables[string(accountOrdersTable)][string(orderIndexKey(accID, uint64(i)))] = []byte(id)
+		}
+	}
+	return tables
+}
+
+func TestOrderIndexKey(t *testing.T) {
+	assert.Equals(t, []byte("acc/0000000000000000"), orderIndexKey("acc", 0))
+	assert.Equals(t, []byte("acc/00000000000000ff"), orderIndexKey("acc", 255))
+	// Keys of an account sort by sequence.
+	assert.True(t, bytes.Compare(orderIndexKey("acc", 9), orderIndexKey("acc", 10)) < 0)
+}
+
+func TestReserveOrderIndex(t *testing.T) {
+	type test struct {
+		db  nosql.DB
+		res []string
+		err *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/get-head-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, bucket, accountOrdersTable)
+						assert.Equals(t, key, []byte("acc"))
+						return nil, errors.New("force")
+					},
+				},
+				err: ServerInternalErr(errors.New("error loading order index for account acc: force")),
+			}
+		},
+		"fail/parse-head-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return []byte(`["o1"]`), nil
+					},
+				},
+				err: ServerInternalErr(errors.New("error parsing order index for account acc")),
+			}
+		},
+		"fail/cmpAndSwap-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, database.ErrNotFound
+					},
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						return nil, false, errors.New("force")
+					},
+				},
+				err: ServerInternalErr(errors.New("error storing order index for account acc: force")),
+			}
+		},
+		"fail/too-many-concurrent-updates": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return orderIndexHead{next: 1}.bytes(), nil
+					},
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						return orderIndexHead{next: 2}.bytes(), false, nil
+					},
+				},
+				err: ServerInternalErr(errors.New("error storing order index for account acc; too many concurrent updates")),
+			}
+		},
+		"ok/new": func(t *testing.T) test {
+			tables := newOrderIndexTables("acc", nil)
+			return test{
+				db:  newCleanupDB(tables),
+				res: []string{"o1"},
+			}
+		},
+		"ok/append": func(t *testing.T) test {
+			tables := newOrderIndexTables("acc", []string{"l1"}, "o0")
+			return test{
+				db:  newCleanupDB(tables),
+				res: []string{"l1", "o0", "o1"},
+			}
+		},
+		"ok/concurrent-update": func(t *testing.T) test {
+			tables := newOrderIndexTables("acc", nil, "o0")
+			mockdb := newCleanupDB(tables)
+			cmpAndSwap := mockdb.MCmpAndSwap
+			swaps := 0
+			mockdb.MCmpAndSwap = func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				swaps++
+				if swaps == 1 {
+					// Simulate an order added after the head was read.
+					tables[string(bucket)][string(key)] = orderIndexHead{next: 2}.bytes()
+					tables[string(bucket)][string(orderIndexKey("acc", 1))] = []byte("other")
+				}
+				return cmpAndSwap(bucket, key, old, newval)
+			}
+			return test{
+				db:  mockdb,
+				res: []string{"o0", "other", "o1"},
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			seq, err := reserveOrderIndex(tc.db, "acc")
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.FatalError(t, tc.db.Set(accountOrdersTable, orderIndexKey("acc", seq), []byte("o1")))
+				oids, err := getOrderIDsByAccount(tc.db, "acc")
+				assert.FatalError(t, err)
+				assert.Equals(t, tc.res, oids)
+			}
+		})
+	}
+}
+
+func TestGetOrderIDsByAccount(t *testing.T) {
+	type test struct {
+		db  nosql.DB
+		n   int
+		res []string
+		err *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"ok/not-found": func(t *testing.T) test {
+			return test{
+				db:  newCleanupDB(newOrderIndexTables("acc", nil)),
+				n:   -1,
+				res: []string{},
+			}
+		},
+		"fail/get-head-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, errors.New("force")
+					},
+				},
+				n:   -1,
+				err: ServerInternalErr(errors.New("error loading order index for account acc: force")),
+			}
+		},
+		"fail/get-entry-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, bucket, accountOrdersTable)
+						if string(key) == "acc" {
+							return orderIndexHead{next: 1}.bytes(), nil
+						}
+						return nil, errors.New("force")
+					},
+				},
+				n:   -1,
+				err: ServerInternalErr(errors.New("error loading order index entry batch: error getting acme_account_orders/acc/0000000000000000: force")),
+			}
+		},
+		"fail/get-legacy-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						if bytes.Equal(bucket, ordersByAccountIDTable) {
+							return nil, errors.New("force")
+						}
+						return nil, database.ErrNotFound
+					},
+				},
+				n:   -1,
+				err: ServerInternalErr(errors.New("error loading orderIDs for account acc: force")),
+			}
+		},
+		"fail/unmarshal-legacy-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						if bytes.Equal(bucket, ordersByAccountIDTable) {
+							return nil, nil
+						}
+						return nil, database.ErrNotFound
+					},
+				},
+				n:   -1,
+				err: ServerInternalErr(errors.New("error unmarshaling orderIDs for account acc: unexpected end of JSON input")),
+			}
+		},
+		"ok/legacy": func(t *testing.T) test {
+			return test{
+				db:  newCleanupDB(newOrderIndexTables("acc", []string{"foo", "bar", "baz"})),
+				n:   -1,
+				res: []string{"foo", "bar", "baz"},
+			}
+		},
+		"ok/all": func(t *testing.T) test {
+			tables := newOrderIndexTables("acc", []string{"l1", "l2"}, "o0", "o1", "o2")
+			delete(tables[string(accountOrdersTable)], string(orderIndexKey("acc", 1)))
+			return test{
+				db:  newCleanupDB(tables),
+				n:   -1,
+				res: []string{"l1", "l2", "o0", "o2"},
+			}
+		},
+		"ok/recent": func(t *testing.T) test {
+			return test{
+				db:  newCleanupDB(newOrderIndexTables("acc", []string{"l1", "l2"}, "o0", "o1", "o2")),
+				n:   2,
+				res: []string{"o1", "o2"},
+			}
+		},
+		"ok/recent-with-legacy": func(t *testing.T) test {
+			return test{
+				db:  newCleanupDB(newOrderIndexTables("acc", []string{"l1", "l2"}, "o0", "o1", "o2")),
+				n:   4,
+				res: []string{"l2", "o0", "o1", "o2"},
+			}
+		},
+		"ok/recent-with-removed": func(t *testing.T) test {
+			tables := newOrderIndexTables("acc", []string{"l1", "l2"}, "o0", "o1", "o2")
+			delete(tables[string(accountOrdersTable)], string(orderIndexKey("acc", 2)))
+			return test{
+				db:  newCleanupDB(tables),
+				n:   2,
+				res: []string{"l2", "o1"},
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			var (
+				oids []string
+				err  error
+			)
+			if tc.n < 0 {
+				oids, err = getOrderIDsByAccount(tc.db, "acc")
+			} else {
+				oids, err = getRecentOrderIDsByAccount(tc.db, "acc", tc.n)
+			}
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, tc.res, oids)
+			}
+		})
+	}
+}
+
+func TestRemoveOrderIDs(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		tables := newOrderIndexTables("acc", []string{"l1", "l2"}, "o0", "o1", "o2")
+		mockdb := newCleanupDB(tables)
+		assert.FatalError(t, removeOrderIDs(mockdb, "acc", []string{"l1", "o0", "o2"}))
+		oids, err := getOrderIDsByAccount(mockdb, "acc")
+		assert.FatalError(t, err)
+		assert.Equals(t, []string{"l2", "o1"}, oids)
+		// The index is compacted up to the first order that is kept.
+		assert.Equals(t, map[string][]byte{
+			"acc":                  orderIndexHead{first: 1, next: 3}.bytes(),
+			"acc/0000000000000001": []byte("o1"),
+			"acc/0000000000000002": []byte(orderIndexTombstone),
+		}, tables[string(accountOrdersTable)])
+
+		assert.FatalError(t, removeOrderIDs(mockdb, "acc", []string{"o1"}))
+		assert.Equals(t, map[string][]byte{
+			"acc": orderIndexHead{first: 3, next: 3}.bytes(),
+		}, tables[string(accountOrdersTable)])
+	})
+	t.Run("ok/missing-entry", func(t *testing.T) {
+		// The order of the missing entry may still be being created.
+		tables := newOrderIndexTables("acc", nil, "o0", "o1", "o2")
+		delete(tables[string(accountOrdersTable)], string(orderIndexKey("acc", 1)))
+		mockdb := newCleanupDB(tables)
+		assert.FatalError(t, removeOrderIDs(mockdb, "acc", []string{"o0", "o2"}))
+		assert.Equals(t, map[string][]byte{
+			"acc":                  orderIndexHead{first: 1, next: 3}.bytes(),
+			"acc/0000000000000002": []byte(orderIndexTombstone),
+		}, tables[string(accountOrdersTable)])
+	})
+	t.Run("fail/set-error", func(t *testing.T) {
+		mockdb := newCleanupDB(newOrderIndexTables("acc", nil, "o0"))
+		mockdb.MSet = func(bucket, key, value []byte) error {
+			return errors.New("force")
+		}
+		err := removeOrderIDs(mockdb, "acc", []string{"o0"})
+		assert.HasPrefix(t, err.Error(), "error deleting order o0 from the index of account acc: force")
+	})
+	t.Run("fail/delete-error", func(t *testing.T) {
+		mockdb := newCleanupDB(newOrderIndexTables("acc", nil, "o0"))
+		mockdb.MDel = func(bucket, key []byte) error {
+			return errors.New("force")
+		}
+		err := removeOrderIDs(mockdb, "acc", []string{"o0"})
+		assert.HasPrefix(t, err.Error(), "error compacting order index for account acc: force")
+	})
+	t.Run("ok/concurrent-update", func(t *testing.T) {
+		tables := newOrderIndexTables("acc", []string{"o1", "o2"})
+		mockdb := newCleanupDB(tables)
+		cmpAndSwap := mockdb.MCmpAndSwap
+		swaps := 0
+		mockdb.MCmpAndSwap = func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+			swaps++
+			if swaps == 1 {
+				// Simulate a new order added after the index was read.
+				tables[string(bucket)][string(key)] = []byte(`["o1","o2","o3"]`)
+			}
+			return cmpAndSwap(bucket, key, old, newval)
+		}
+		assert.FatalError(t, removeOrderIDs(mockdb, "acc", []string{"o1"}))
+		assert.Equals(t, 2, swaps)
+		assert.Equals(t, []byte(`["o2","o3"]`), tables[string(ordersByAccountIDTable)]["acc"])
+	})
+	t.Run("ok/nothing-to-remove", func(t *testing.T) {
+		mockdb := newCleanupDB(map[string]map[string][]byte{})
+		mockdb.MCmpAndSwap = func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+			t.Fatal("unexpected call to CmpAndSwap")
+			return nil, false, nil
+		}
+		mockdb.MDel = func(bucket, key []byte) error {
+			t.Fatal("unexpected call to Del")
+			return nil
+		}
+		assert.FatalError(t, removeOrderIDs(mockdb, "acc", []string{"o1"}))
+	})
+}
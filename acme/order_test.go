@@ -2,10 +2,16 @@ package acme
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"net"
+	"net/http"
 	"testing"
 	"time"
 
@@ -13,6 +19,7 @@ import (
 	"github.com/smallstep/assert"
 	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
 	"github.com/smallstep/nosql"
 	"github.com/smallstep/nosql/database"
 )
@@ -37,14 +44,22 @@ func newO() (*order, error) {
 			return []byte("foo"), true, nil
 		},
 		MGet: func(bucket, key []byte) ([]byte, error) {
-			b, err := json.Marshal([]string{"1", "2"})
-			if err != nil {
-				return nil, err
-			}
-			return b, nil
+			return orderIndexHead{next: 2}.bytes(), nil
 		},
+		MUpdate: mockUpdate,
 	}
-	return newOrder(mockdb, defaultOrderOps())
+	return newOrder(mockdb, defaultOrderOps(), nil)
+}
+
+// mockUpdate is an Update function for mock databases that swaps all the
+// compare-and-swap operations of the transaction.
+func mockUpdate(tx *database.Tx) error {
+	for _, op := range tx.Operations {
+		if op.Cmd == database.CmpAndSwap {
+			op.Result, op.Swapped = op.Value, true
+		}
+	}
+	return nil
 }
 
 func TestGetOrder(t *testing.T) {
@@ -168,11 +183,18 @@ func TestOrderToACME(t *testing.T) {
 			o.Certificate = "cert-id"
 			return test{o: o}
 		},
+		"ok/no-validity": func(t *testing.T) test {
+			o, err := newO()
+			assert.FatalError(t, err)
+			o.NotBefore = time.Time{}
+			o.NotAfter = time.Time{}
+			return test{o: o}
+		},
 	}
 	for name, run := range tests {
 		tc := run(t)
 		t.Run(name, func(t *testing.T) {
-			acmeOrder, err := tc.o.toACME(nil, dir, prov)
+			acmeOrder, err := tc.o.toACME(context.Background(), nil, dir, prov)
 			if err != nil {
 				if assert.NotNil(t, tc.err) {
 					ae, ok := err.(*Error)
@@ -194,12 +216,20 @@ func TestOrderToACME(t *testing.T) {
 					expiry, err := time.Parse(time.RFC3339, acmeOrder.Expires)
 					assert.FatalError(t, err)
 					assert.Equals(t, expiry.String(), tc.o.Expires.String())
-					nbf, err := time.Parse(time.RFC3339, acmeOrder.NotBefore)
-					assert.FatalError(t, err)
-					assert.Equals(t, nbf.String(), tc.o.NotBefore.String())
-					naf, err := time.Parse(time.RFC3339, acmeOrder.NotAfter)
-					assert.FatalError(t, err)
-					assert.Equals(t, naf.String(), tc.o.NotAfter.String())
+					if tc.o.NotBefore.IsZero() {
+						assert.Equals(t, "", acmeOrder.NotBefore)
+					} else {
+						nbf, err := time.Parse(time.RFC3339, acmeOrder.NotBefore)
+						assert.FatalError(t, err)
+						assert.Equals(t, nbf.String(), tc.o.NotBefore.String())
+					}
+					if tc.o.NotAfter.IsZero() {
+						assert.Equals(t, "", acmeOrder.NotAfter)
+					} else {
+						naf, err := time.Parse(time.RFC3339, acmeOrder.NotAfter)
+						assert.FatalError(t, err)
+						assert.Equals(t, naf.String(), tc.o.NotAfter.String())
+					}
 				}
 			}
 		})
@@ -305,10 +335,12 @@ func TestOrderSave(t *testing.T) {
 
 func TestNewOrder(t *testing.T) {
 	type test struct {
-		ops    OrderOptions
-		db     nosql.DB
-		err    *Error
-		authzs *([]string)
+		ops     OrderOptions
+		db      nosql.DB
+		cleanup *CleanupConfig
+		err     *Error
+		authzs  *([]string)
+		ttl     *time.Duration
 	}
 	tests := map[string]func(t *testing.T) test{
 		"fail/unexpected-identifier-type": func(t *testing.T) test {
@@ -319,122 +351,143 @@ func TestNewOrder(t *testing.T) {
 				err: MalformedErr(errors.New("unexpected authz type foo")),
 			}
 		},
-		"fail/save-order-error": func(t *testing.T) test {
-			count := 0
+		"fail/get-order-index-error": func(t *testing.T) test {
+			ops := defaultOrderOps()
 			return test{
-				ops: defaultOrderOps(),
+				ops: ops,
 				db: &db.MockNoSQLDB{
-					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
-						if count >= 8 {
-							return nil, false, errors.New("force")
-						}
-						count++
-						return nil, true, nil
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, errors.New("force")
 					},
 				},
-				err: ServerInternalErr(errors.New("error storing order: force")),
+				err: ServerInternalErr(errors.Errorf("error loading order index for account %s: force", ops.AccountID)),
 			}
 		},
-		"fail/get-orderIDs-error": func(t *testing.T) test {
-			count := 0
+		"fail/save-order-index-error": func(t *testing.T) test {
 			ops := defaultOrderOps()
 			return test{
 				ops: ops,
 				db: &db.MockNoSQLDB{
 					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
-						if count >= 9 {
-							return nil, false, errors.New("force")
-						}
-						count++
-						return nil, true, nil
+						assert.Equals(t, bucket, accountOrdersTable)
+						assert.Equals(t, key, []byte(ops.AccountID))
+						return nil, false, errors.New("force")
 					},
 					MGet: func(bucket, key []byte) ([]byte, error) {
-						return nil, errors.New("force")
+						return orderIndexHead{next: 3}.bytes(), nil
 					},
 				},
-				err: ServerInternalErr(errors.Errorf("error loading orderIDs for account %s: force", ops.AccountID)),
+				err: ServerInternalErr(errors.Errorf("error storing order index for account %s: force", ops.AccountID)),
 			}
 		},
-		"fail/save-orderIDs-error": func(t *testing.T) test {
-			count := 0
-			oids := []string{"1", "2", "3"}
-			oidsB, err := json.Marshal(oids)
-			assert.FatalError(t, err)
-			var (
-				_oid = ""
-				oid  = &_oid
-			)
-			ops := defaultOrderOps()
+		"fail/commit-error": func(t *testing.T) test {
 			return test{
-				ops: ops,
+				ops: defaultOrderOps(),
 				db: &db.MockNoSQLDB{
 					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
-						if count >= 9 {
-							assert.Equals(t, bucket, ordersByAccountIDTable)
-							assert.Equals(t, key, []byte(ops.AccountID))
-							return nil, false, errors.New("force")
-						} else if count == 8 {
-							*oid = string(key)
-						}
-						count++
-						return nil, true, nil
+						return newval, true, nil
 					},
 					MGet: func(bucket, key []byte) ([]byte, error) {
-						return oidsB, nil
+						return orderIndexHead{next: 3}.bytes(), nil
 					},
-					MDel: func(bucket, key []byte) error {
-						assert.Equals(t, bucket, orderTable)
-						assert.Equals(t, key, []byte(*oid))
+					MUpdate: func(tx *database.Tx) error {
+						return errors.New("force")
+					},
+				},
+				err: ServerInternalErr(errors.New("error committing transaction: force")),
+			}
+		},
+		"fail/commit-not-swapped": func(t *testing.T) test {
+			return test{
+				ops: defaultOrderOps(),
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						return newval, true, nil
+					},
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return orderIndexHead{next: 3}.bytes(), nil
+					},
+					MUpdate: func(tx *database.Tx) error {
 						return nil
 					},
 				},
-				err: ServerInternalErr(errors.Errorf("error storing order IDs for account %s: force", ops.AccountID)),
+				err: ServerInternalErr(errors.New("error committing transaction; acme_challenges/")),
 			}
 		},
 		"ok": func(t *testing.T) test {
-			count := 0
-			oids := []string{"1", "2", "3"}
-			oidsB, err := json.Marshal(oids)
-			assert.FatalError(t, err)
 			authzs := &([]string{})
-			var (
-				_oid = ""
-				oid  = &_oid
-			)
 			ops := defaultOrderOps()
 			return test{
 				ops: ops,
 				db: &db.MockNoSQLDB{
 					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
-						if count >= 9 {
-							assert.Equals(t, bucket, ordersByAccountIDTable)
-							assert.Equals(t, key, []byte(ops.AccountID))
-							assert.Equals(t, old, oidsB)
-							newB, err := json.Marshal(append(oids, *oid))
-							assert.FatalError(t, err)
-							assert.Equals(t, newval, newB)
-						} else if count == 8 {
-							*oid = string(key)
-						} else if count == 7 {
-							*authzs = append(*authzs, string(key))
-						} else if count == 3 {
-							*authzs = []string{string(key)}
-						}
-						count++
-						return nil, true, nil
+						assert.Equals(t, bucket, accountOrdersTable)
+						assert.Equals(t, key, []byte(ops.AccountID))
+						assert.Equals(t, old, orderIndexHead{next: 3}.bytes())
+						assert.Equals(t, newval, orderIndexHead{next: 4}.bytes())
+						return newval, true, nil
 					},
 					MGet: func(bucket, key []byte) ([]byte, error) {
-						return oidsB, nil
+						return orderIndexHead{next: 3}.bytes(), nil
+					},
+					MUpdate: func(tx *database.Tx) error {
+						assert.Equals(t, 10, len(tx.Operations))
+						var oid []byte
+						for i, op := range tx.Operations {
+							switch i {
+							case 3, 7:
+								assert.Equals(t, op.Bucket, authzTable)
+								*authzs = append(*authzs, string(op.Key))
+							case 8:
+								assert.Equals(t, op.Bucket, orderTable)
+								oid = op.Key
+							case 9:
+								assert.Equals(t, op.Cmd, database.Set)
+								assert.Equals(t, op.Bucket, accountOrdersTable)
+								assert.Equals(t, op.Key, orderIndexKey(ops.AccountID, 3))
+								assert.Equals(t, op.Value, oid)
+							default:
+								assert.Equals(t, op.Bucket, challengeTable)
+							}
+						}
+						return mockUpdate(tx)
 					},
 				},
 				authzs: authzs,
 			}
 		},
+		"ok/ttl": func(t *testing.T) test {
+			var ttl time.Duration
+			mockdb := &ttlMockDB{
+				MockNoSQLDB: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						return newval, true, nil
+					},
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return orderIndexHead{next: 3}.bytes(), nil
+					},
+				},
+				ttl: &ttl,
+			}
+			authzs := &([]string{})
+			mockdb.MUpdate = func(tx *database.Tx) error {
+				assert.Equals(t, 10, len(tx.Operations))
+				*authzs = []string{string(tx.Operations[3].Key), string(tx.Operations[7].Key)}
+				return mockUpdate(tx)
+			}
+			return test{
+				ops:     defaultOrderOps(),
+				db:      &metricsDB{mockdb},
+				cleanup: &CleanupConfig{Retention: &provisioner.Duration{Duration: time.Hour}},
+				authzs:  authzs,
+				ttl:     &ttl,
+			}
+		},
 	}
 	for name, run := range tests {
 		tc := run(t)
 		t.Run(name, func(t *testing.T) {
-			o, err := newOrder(tc.db, tc.ops)
+			o, err := newOrder(tc.db, tc.ops, tc.cleanup)
 			if err != nil {
 				if assert.NotNil(t, tc.err) {
 					ae, ok := err.(*Error)
@@ -461,6 +514,11 @@ func TestNewOrder(t *testing.T) {
 
 					assert.Equals(t, o.NotBefore, tc.ops.NotBefore)
 					assert.Equals(t, o.NotAfter, tc.ops.NotAfter)
+
+					if tc.ttl != nil {
+						ttl := defaultOrderExpiry + tc.cleanup.GetRetention()
+						assert.True(t, *tc.ttl <= ttl && *tc.ttl > ttl-time.Minute)
+					}
 				}
 			}
 		})
@@ -561,6 +619,137 @@ func TestOrderIDsSave(t *testing.T) {
 	}
 }
 
+func TestSameIdentifiers(t *testing.T) {
+	foo := Identifier{Type: "dns", Value: "foo.example.com"}
+	bar := Identifier{Type: "dns", Value: "bar.example.com"}
+	ip := Identifier{Type: "ip", Value: "10.0.0.1"}
+	tests := map[string]struct {
+		a, b []Identifier
+		ok   bool
+	}{
+		"ok/empty":        {ok: true},
+		"ok/same":         {a: []Identifier{foo, bar}, b: []Identifier{foo, bar}, ok: true},
+		"ok/order":        {a: []Identifier{foo, bar, ip}, b: []Identifier{ip, bar, foo}, ok: true},
+		"ok/dns-case":     {a: []Identifier{foo}, b: []Identifier{{Type: "dns", Value: "FOO.example.com"}}, ok: true},
+		"fail/length":     {a: []Identifier{foo, bar}, b: []Identifier{foo}},
+		"fail/value":      {a: []Identifier{foo, bar}, b: []Identifier{foo, ip}},
+		"fail/type":       {a: []Identifier{foo}, b: []Identifier{{Type: "ip", Value: foo.Value}}},
+		"fail/duplicates": {a: []Identifier{foo, foo}, b: []Identifier{foo, bar}},
+		"fail/other-case": {a: []Identifier{{Type: "TNAuthList", Value: "abc="}}, b: []Identifier{{Type: "TNAuthList", Value: "ABC="}}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equals(t, tc.ok, sameIdentifiers(tc.a, tc.b))
+			assert.Equals(t, tc.ok, sameIdentifiers(tc.b, tc.a))
+		})
+	}
+}
+
+func TestFindReusableOrder(t *testing.T) {
+	ops := defaultOrderOps()
+	newOrder := func(status string) *order {
+		o, err := newO()
+		assert.FatalError(t, err)
+		o.Status = status
+		o.Identifiers = ops.Identifiers
+		o.NotBefore, o.NotAfter = ops.NotBefore, ops.NotAfter
+		return o
+	}
+	ready := newOrder(StatusReady)
+	orders := []*order{ready}
+	for i := 0; i < orderReuseLookback; i++ {
+		orders = append(orders, newOrder(StatusInvalid))
+	}
+	values := map[string][]byte{}
+	oids := make([]string, len(orders))
+	for i, o := range orders {
+		b, err := json.Marshal(o)
+		assert.FatalError(t, err)
+		values[o.ID] = b
+		oids[i] = o.ID
+	}
+	mockdb := &db.MockNoSQLDB{
+		MGet: func(bucket, key []byte) ([]byte, error) {
+			switch {
+			case string(bucket) == string(ordersByAccountIDTable):
+				return nil, database.ErrNotFound
+			case string(bucket) != string(accountOrdersTable):
+				return values[string(key)], nil
+			case string(key) == ops.AccountID:
+				return orderIndexHead{next: uint64(len(oids))}.bytes(), nil
+			}
+			for i, id := range oids {
+				if string(key) == string(orderIndexKey(ops.AccountID, uint64(i))) {
+					return []byte(id), nil
+				}
+			}
+			return nil, database.ErrNotFound
+		},
+		MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+			return nil, true, nil
+		},
+	}
+
+	// The ready order is older than the lookback.
+	o, err := findReusableOrder(mockdb, ops)
+	assert.FatalError(t, err)
+	assert.Nil(t, o)
+
+	oids = oids[:orderReuseLookback]
+	o, err = findReusableOrder(mockdb, ops)
+	assert.FatalError(t, err)
+	if assert.NotNil(t, o) {
+		assert.Equals(t, ready.ID, o.ID)
+	}
+
+	// STAR orders and orders with other options are not reused.
+	starOps := ops
+	starOps.AutoRenewal = &AutoRenewal{}
+	o, err = findReusableOrder(mockdb, starOps)
+	assert.FatalError(t, err)
+	assert.Nil(t, o)
+
+	otherOps := ops
+	otherOps.NotAfter = ops.NotAfter.Add(time.Hour)
+	o, err = findReusableOrder(mockdb, otherOps)
+	assert.FatalError(t, err)
+	assert.Nil(t, o)
+}
+
+// newAuthzsDB returns a mock database that contains the given authzs. All
+// the challenges in the database are pending.
+func newAuthzsDB(t *testing.T, azs ...authz) *db.MockNoSQLDB {
+	values := map[string][]byte{}
+	for _, az := range azs {
+		b, err := json.Marshal(az)
+		assert.FatalError(t, err)
+		values[az.getID()] = b
+	}
+	ch, err := newHTTPCh()
+	assert.FatalError(t, err)
+	chb, err := json.Marshal(ch)
+	assert.FatalError(t, err)
+	return &db.MockNoSQLDB{
+		MGet: func(bucket, key []byte) ([]byte, error) {
+			switch string(bucket) {
+			case string(authzTable):
+				b, ok := values[string(key)]
+				if !ok {
+					return nil, database.ErrNotFound
+				}
+				return b, nil
+			case string(challengeTable):
+				return chb, nil
+			default:
+				return nil, errors.Errorf("unexpected bucket %s", bucket)
+			}
+		},
+		MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+			return nil, true, nil
+		},
+	}
+}
+
 func TestOrderUpdateStatus(t *testing.T) {
 	type test struct {
 		o, res *order
@@ -618,7 +807,7 @@ func TestOrderUpdateStatus(t *testing.T) {
 
 			_o := *o
 			clone := &_o
-			clone.Error = MalformedErr(errors.New("order has expired"))
+			clone.Error = MalformedErr(errors.New("order has expired")).ToACME()
 			clone.Status = StatusInvalid
 			return test{
 				o:   o,
@@ -652,20 +841,6 @@ func TestOrderUpdateStatus(t *testing.T) {
 			az3, err := newAz()
 			assert.FatalError(t, err)
 
-			ch1, err := newHTTPCh()
-			assert.FatalError(t, err)
-			ch2, err := newTLSALPNCh()
-			assert.FatalError(t, err)
-			ch3, err := newDNSCh()
-			assert.FatalError(t, err)
-
-			ch1b, err := json.Marshal(ch1)
-			assert.FatalError(t, err)
-			ch2b, err := json.Marshal(ch2)
-			assert.FatalError(t, err)
-			ch3b, err := json.Marshal(ch3)
-			assert.FatalError(t, err)
-
 			o, err := newO()
 			assert.FatalError(t, err)
 			o.Authorizations = []string{az1.getID(), az2.getID(), az3.getID()}
@@ -674,49 +849,10 @@ func TestOrderUpdateStatus(t *testing.T) {
 			assert.Fatal(t, ok)
 			_az3.baseAuthz.Status = StatusValid
 
-			b1, err := json.Marshal(az1)
-			assert.FatalError(t, err)
-			b2, err := json.Marshal(az2)
-			assert.FatalError(t, err)
-			b3, err := json.Marshal(az3)
-			assert.FatalError(t, err)
-
-			count := 0
 			return test{
 				o:   o,
 				res: o,
-				db: &db.MockNoSQLDB{
-					MGet: func(bucket, key []byte) ([]byte, error) {
-						var ret []byte
-						switch count {
-						case 0:
-							ret = b1
-						case 1:
-							ret = ch1b
-						case 2:
-							ret = ch2b
-						case 3:
-							ret = ch3b
-						case 4:
-							ret = b2
-						case 5:
-							ret = ch1b
-						case 6:
-							ret = ch2b
-						case 7:
-							ret = ch3b
-						case 8:
-							ret = b3
-						default:
-							return nil, errors.New("unexpected count")
-						}
-						count++
-						return ret, nil
-					},
-					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
-						return nil, true, nil
-					},
-				},
+				db:  newAuthzsDB(t, az1, az2, az3),
 			}
 		},
 		"ok/invalid": func(t *testing.T) test {
@@ -727,20 +863,6 @@ func TestOrderUpdateStatus(t *testing.T) {
 			az3, err := newAz()
 			assert.FatalError(t, err)
 
-			ch1, err := newHTTPCh()
-			assert.FatalError(t, err)
-			ch2, err := newTLSALPNCh()
-			assert.FatalError(t, err)
-			ch3, err := newDNSCh()
-			assert.FatalError(t, err)
-
-			ch1b, err := json.Marshal(ch1)
-			assert.FatalError(t, err)
-			ch2b, err := json.Marshal(ch2)
-			assert.FatalError(t, err)
-			ch3b, err := json.Marshal(ch3)
-			assert.FatalError(t, err)
-
 			o, err := newO()
 			assert.FatalError(t, err)
 			o.Authorizations = []string{az1.getID(), az2.getID(), az3.getID()}
@@ -749,53 +871,16 @@ func TestOrderUpdateStatus(t *testing.T) {
 			assert.Fatal(t, ok)
 			_az3.baseAuthz.Status = StatusInvalid
 
-			b1, err := json.Marshal(az1)
-			assert.FatalError(t, err)
-			b2, err := json.Marshal(az2)
-			assert.FatalError(t, err)
-			b3, err := json.Marshal(az3)
-			assert.FatalError(t, err)
-
 			_o := *o
 			clone := &_o
 			clone.Status = StatusInvalid
+			clone.Error = RejectedIdentifierErr(errors.Errorf("authorization for %s is invalid", az3.getIdentifier().Value)).ToACME()
+			clone.Error.Identifier = az3.getIdentifier()
 
-			count := 0
 			return test{
 				o:   o,
 				res: clone,
-				db: &db.MockNoSQLDB{
-					MGet: func(bucket, key []byte) ([]byte, error) {
-						var ret []byte
-						switch count {
-						case 0:
-							ret = b1
-						case 1:
-							ret = ch1b
-						case 2:
-							ret = ch2b
-						case 3:
-							ret = ch3b
-						case 4:
-							ret = b2
-						case 5:
-							ret = ch1b
-						case 6:
-							ret = ch2b
-						case 7:
-							ret = ch3b
-						case 8:
-							ret = b3
-						default:
-							return nil, errors.New("unexpected count")
-						}
-						count++
-						return ret, nil
-					},
-					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
-						return nil, true, nil
-					},
-				},
+				db:  newAuthzsDB(t, az1, az2, az3),
 			}
 		},
 	}
@@ -847,6 +932,16 @@ func (m *mockSignAuth) LoadProvisionerByID(id string) (provisioner.Interface, er
 	return m.ret1.(provisioner.Interface), m.err
 }
 
+// mockPolicySignAuth is a mockSignAuth with a crypto policy.
+type mockPolicySignAuth struct {
+	mockSignAuth
+	checkCertificateRequest func(csr *x509.CertificateRequest) error
+}
+
+func (m *mockPolicySignAuth) CheckCertificateRequest(csr *x509.CertificateRequest) error {
+	return m.checkCertificateRequest(csr)
+}
+
 func TestOrderFinalize(t *testing.T) {
 	prov := newProv()
 	type test struct {
@@ -854,6 +949,7 @@ func TestOrderFinalize(t *testing.T) {
 		err    *Error
 		db     nosql.DB
 		csr    *x509.CertificateRequest
+		policy *CSRConfig
 		sa     SignAuthority
 		prov   provisioner.Interface
 	}
@@ -885,20 +981,6 @@ func TestOrderFinalize(t *testing.T) {
 			az3, err := newAz()
 			assert.FatalError(t, err)
 
-			ch1, err := newHTTPCh()
-			assert.FatalError(t, err)
-			ch2, err := newTLSALPNCh()
-			assert.FatalError(t, err)
-			ch3, err := newDNSCh()
-			assert.FatalError(t, err)
-
-			ch1b, err := json.Marshal(ch1)
-			assert.FatalError(t, err)
-			ch2b, err := json.Marshal(ch2)
-			assert.FatalError(t, err)
-			ch3b, err := json.Marshal(ch3)
-			assert.FatalError(t, err)
-
 			o, err := newO()
 			assert.FatalError(t, err)
 			o.Authorizations = []string{az1.getID(), az2.getID(), az3.getID()}
@@ -907,49 +989,10 @@ func TestOrderFinalize(t *testing.T) {
 			assert.Fatal(t, ok)
 			_az3.baseAuthz.Status = StatusValid
 
-			b1, err := json.Marshal(az1)
-			assert.FatalError(t, err)
-			b2, err := json.Marshal(az2)
-			assert.FatalError(t, err)
-			b3, err := json.Marshal(az3)
-			assert.FatalError(t, err)
-
-			count := 0
 			return test{
 				o:   o,
 				res: o,
-				db: &db.MockNoSQLDB{
-					MGet: func(bucket, key []byte) ([]byte, error) {
-						var ret []byte
-						switch count {
-						case 0:
-							ret = b1
-						case 1:
-							ret = ch1b
-						case 2:
-							ret = ch2b
-						case 3:
-							ret = ch3b
-						case 4:
-							ret = b2
-						case 5:
-							ret = ch1b
-						case 6:
-							ret = ch2b
-						case 7:
-							ret = ch3b
-						case 8:
-							ret = b3
-						default:
-							return nil, errors.New("unexpected count")
-						}
-						count++
-						return ret, nil
-					},
-					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
-						return nil, true, nil
-					},
-				},
+				db:  newAuthzsDB(t, az1, az2, az3),
 				err: OrderNotReadyErr(errors.Errorf("order %s is not ready", o.ID)),
 			}
 		},
@@ -987,6 +1030,49 @@ func TestOrderFinalize(t *testing.T) {
 				err: BadCSRErr(errors.Errorf("CSR names do not match identifiers exactly")),
 			}
 		},
+		"fail/ready/csr-policy-error": func(t *testing.T) test {
+			o, err := newO()
+			assert.FatalError(t, err)
+			o.Status = StatusReady
+
+			csr := &x509.CertificateRequest{
+				Subject: pkix.Name{
+					CommonName: "acme.example.com",
+				},
+				DNSNames:    []string{"step.example.com", "acme.example.com"},
+				IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+			}
+			return test{
+				o:      o,
+				csr:    csr,
+				policy: &CSRConfig{StrictNames: true},
+				err:    BadCSRErr(errors.New("CSR cannot contain IP addresses: [10.0.0.1]")),
+			}
+		},
+		"fail/ready/crypto-policy-error": func(t *testing.T) test {
+			o, err := newO()
+			assert.FatalError(t, err)
+			o.Status = StatusReady
+
+			csr := &x509.CertificateRequest{
+				Subject: pkix.Name{
+					CommonName: "acme.example.com",
+				},
+				DNSNames:  []string{"step.example.com", "acme.example.com"},
+				PublicKey: &ecdsa.PublicKey{Curve: elliptic.P256()},
+			}
+			return test{
+				o:   o,
+				csr: csr,
+				sa: &mockPolicySignAuth{
+					checkCertificateRequest: func(cr *x509.CertificateRequest) error {
+						assert.Equals(t, csr, cr)
+						return errors.New("key type EC is not allowed; allowed types are [RSA]")
+					},
+				},
+				err: BadCSRErr(errors.New("key type EC is not allowed; allowed types are [RSA]")),
+			}
+		},
 		"fail/ready/provisioner-auth-sign-error": func(t *testing.T) test {
 			o, err := newO()
 			assert.FatalError(t, err)
@@ -1009,6 +1095,71 @@ func TestOrderFinalize(t *testing.T) {
 				},
 			}
 		},
+		"fail/ready/compromised-key": func(t *testing.T) test {
+			o, err := newO()
+			assert.FatalError(t, err)
+			o.Status = StatusReady
+
+			csr := &x509.CertificateRequest{
+				Subject: pkix.Name{
+					CommonName: "acme.example.com",
+				},
+				DNSNames: []string{"step.example.com", "acme.example.com"},
+			}
+			return test{
+				o:   o,
+				csr: csr,
+				err: BadPublicKeyErr(errors.New("public key has been revoked due to key compromise")),
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, bucket, compromisedKeyTable)
+						return []byte("1234"), nil
+					},
+				},
+			}
+		},
+		"fail/ready/sign-cert-forbidden": func(t *testing.T) test {
+			o, err := newO()
+			assert.FatalError(t, err)
+			o.Status = StatusReady
+
+			csr := &x509.CertificateRequest{
+				Subject: pkix.Name{
+					CommonName: "acme.example.com",
+				},
+				DNSNames: []string{"step.example.com", "acme.example.com"},
+			}
+			return test{
+				o:   o,
+				csr: csr,
+				err: RejectedIdentifierErr(errors.Errorf("error generating certificate for order %s: authority.Sign: "+
+					"dns name step.example.com is not allowed by the policy", o.ID)),
+				sa: &mockSignAuth{
+					err: errs.Wrap(http.StatusForbidden, errors.New("dns name step.example.com is not allowed by the policy"), "authority.Sign"),
+				},
+			}
+		},
+		"fail/ready/sign-cert-crypto-policy": func(t *testing.T) test {
+			o, err := newO()
+			assert.FatalError(t, err)
+			o.Status = StatusReady
+
+			csr := &x509.CertificateRequest{
+				Subject: pkix.Name{
+					CommonName: "acme.example.com",
+				},
+				DNSNames: []string{"step.example.com", "acme.example.com"},
+			}
+			return test{
+				o:   o,
+				csr: csr,
+				err: BadCSRErr(errors.Errorf("error generating certificate for order %s: authority.Sign: "+
+					"RSA key size 1024 is smaller than the minimum 2048", o.ID)),
+				sa: &mockSignAuth{
+					err: errs.Wrap(http.StatusBadRequest, errors.New("RSA key size 1024 is smaller than the minimum 2048"), "authority.Sign"),
+				},
+			}
+		},
 		"fail/ready/sign-cert-error": func(t *testing.T) test {
 			o, err := newO()
 			assert.FatalError(t, err)
@@ -1137,7 +1288,7 @@ func TestOrderFinalize(t *testing.T) {
 				csr: csr,
 				sa: &mockSignAuth{
 					sign: func(csr *x509.CertificateRequest, pops provisioner.Options, signOps ...provisioner.SignOption) ([]*x509.Certificate, error) {
-						assert.Equals(t, len(signOps), 4)
+						assert.Equals(t, len(signOps), 5)
 						return []*x509.Certificate{crt, inter}, nil
 					},
 				},
@@ -1186,7 +1337,7 @@ func TestOrderFinalize(t *testing.T) {
 				csr: csr,
 				sa: &mockSignAuth{
 					sign: func(csr *x509.CertificateRequest, pops provisioner.Options, signOps ...provisioner.SignOption) ([]*x509.Certificate, error) {
-						assert.Equals(t, len(signOps), 4)
+						assert.Equals(t, len(signOps), 5)
 						return []*x509.Certificate{crt, inter}, nil
 					},
 				},
@@ -1233,7 +1384,127 @@ func TestOrderFinalize(t *testing.T) {
 				csr: csr,
 				sa: &mockSignAuth{
 					sign: func(csr *x509.CertificateRequest, pops provisioner.Options, signOps ...provisioner.SignOption) ([]*x509.Certificate, error) {
-						assert.Equals(t, len(signOps), 4)
+						assert.Equals(t, len(signOps), 5)
+						return []*x509.Certificate{crt, inter}, nil
+					},
+				},
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						if count == 0 {
+							clone.Certificate = string(key)
+						}
+						count++
+						return nil, true, nil
+					},
+				},
+			}
+		},
+		"ok/ready/profile": func(t *testing.T) test {
+			o, err := newO()
+			assert.FatalError(t, err)
+			o.Status = StatusReady
+			o.Profile = "client"
+
+			profProv := &provisioner.ACME{
+				Type:     "ACME",
+				Name:     "test@acme-provisioner.com",
+				Profiles: provisioner.ACMEProfiles{"client": {ExtKeyUsage: []string{"clientAuth"}}},
+			}
+			assert.FatalError(t, profProv.Init(provisioner.Config{Claims: globalProvisionerClaims}))
+
+			csr := &x509.CertificateRequest{
+				DNSNames: []string{"acme.example.com", "step.example.com"},
+			}
+			crt := &x509.Certificate{
+				Subject: pkix.Name{
+					CommonName: "acme.example.com",
+				},
+			}
+			inter := &x509.Certificate{
+				Subject: pkix.Name{
+					CommonName: "intermediate",
+				},
+			}
+
+			clone := *o
+			clone.Status = StatusValid
+			count := 0
+			return test{
+				o:    o,
+				res:  &clone,
+				csr:  csr,
+				prov: profProv,
+				sa: &mockSignAuth{
+					sign: func(csr *x509.CertificateRequest, pops provisioner.Options, signOps ...provisioner.SignOption) ([]*x509.Certificate, error) {
+						// The profile adds the extended key usages.
+						assert.Equals(t, len(signOps), 6)
+						return []*x509.Certificate{crt, inter}, nil
+					},
+				},
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						if count == 0 {
+							clone.Certificate = string(key)
+						}
+						count++
+						return nil, true, nil
+					},
+				},
+			}
+		},
+		"fail/ready/unknown-profile": func(t *testing.T) test {
+			o, err := newO()
+			assert.FatalError(t, err)
+			o.Status = StatusReady
+			o.Profile = "client"
+			return test{
+				o: o,
+				csr: &x509.CertificateRequest{
+					DNSNames: []string{"acme.example.com", "step.example.com"},
+				},
+				err: ServerInternalErr(errors.New("error retrieving authorization options from ACME provisioner")),
+			}
+		},
+		"ok/ready/star": func(t *testing.T) test {
+			o, err := newO()
+			assert.FatalError(t, err)
+			o.Status = StatusReady
+			start := clock.Now().Add(time.Hour)
+			o.AutoRenewal = &AutoRenewal{
+				StartDate:      start,
+				EndDate:        start.Add(72 * time.Hour),
+				Lifetime:       86400,
+				LifetimeAdjust: 60,
+			}
+
+			csr := &x509.CertificateRequest{
+				Raw:      []byte("csr"),
+				DNSNames: []string{"acme.example.com", "step.example.com"},
+			}
+			crt := &x509.Certificate{
+				Subject: pkix.Name{
+					CommonName: "acme.example.com",
+				},
+			}
+			inter := &x509.Certificate{
+				Subject: pkix.Name{
+					CommonName: "intermediate",
+				},
+			}
+
+			clone := *o
+			clone.Status = StatusValid
+			clone.CSR = csr.Raw
+			clone.RenewalSlot = start
+			count := 0
+			return test{
+				o:   o,
+				res: &clone,
+				csr: csr,
+				sa: &mockSignAuth{
+					sign: func(csr *x509.CertificateRequest, pops provisioner.Options, signOps ...provisioner.SignOption) ([]*x509.Certificate, error) {
+						assert.Equals(t, start.Add(-time.Minute), pops.NotBefore.Time())
+						assert.Equals(t, start.Add(24*time.Hour), pops.NotAfter.Time())
 						return []*x509.Certificate{crt, inter}, nil
 					},
 				},
@@ -1256,7 +1527,42 @@ func TestOrderFinalize(t *testing.T) {
 			if p == nil {
 				p = prov
 			}
-			o, err := tc.o.finalize(tc.db, tc.csr, tc.sa, p)
+			// The key of the csr is not in the compromised keys table.
+			mdb, _ := tc.db.(*db.MockNoSQLDB)
+			if mdb == nil {
+				mdb = &db.MockNoSQLDB{}
+				tc.db = mdb
+			}
+			if mdb.MGet == nil {
+				mdb.MGet = func(bucket, key []byte) ([]byte, error) {
+					assert.Equals(t, bucket, compromisedKeyTable)
+					return nil, database.ErrNotFound
+				}
+			}
+			// The certificates-by-account index is empty.
+			get, set, cmpAndSwap := mdb.MGet, mdb.MSet, mdb.MCmpAndSwap
+			mdb.MGet = func(bucket, key []byte) ([]byte, error) {
+				if string(bucket) == string(accountCertsTable) {
+					return nil, database.ErrNotFound
+				}
+				return get(bucket, key)
+			}
+			mdb.MSet = func(bucket, key, value []byte) error {
+				if string(bucket) == string(accountCertsTable) || set == nil {
+					return nil
+				}
+				return set(bucket, key, value)
+			}
+			mdb.MCmpAndSwap = func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				if string(bucket) == string(accountCertsTable) {
+					return newval, true, nil
+				}
+				if cmpAndSwap == nil {
+					return nil, false, nil
+				}
+				return cmpAndSwap(bucket, key, old, newval)
+			}
+			o, err := tc.o.finalize(context.Background(), tc.db, tc.csr, tc.policy, tc.sa, p)
 			if err != nil {
 				if assert.NotNil(t, tc.err) {
 					ae, ok := err.(*Error)
@@ -1277,3 +1583,248 @@ func TestOrderFinalize(t *testing.T) {
 		})
 	}
 }
+
+func TestOrderValidateCSR(t *testing.T) {
+	attested, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	fingerprint, err := attestedKeyFingerprint(attested.Public())
+	assert.FatalError(t, err)
+	marshal := func(v interface{}) []byte {
+		b, err := json.Marshal(v)
+		assert.FatalError(t, err)
+		return b
+	}
+	tables := map[string]map[string][]byte{
+		string(authzTable): {
+			"az":      marshal(&baseAuthz{ID: "az", Identifier: Identifier{Type: "permanent-identifier", Value: "12345678"}, Challenges: []string{"ch"}}),
+			"pending": marshal(&baseAuthz{ID: "pending", Identifier: Identifier{Type: "permanent-identifier", Value: "12345678"}, Challenges: []string{"pending"}}),
+		},
+		string(challengeTable): {
+			"ch":      marshal(&baseChallenge{ID: "ch", Type: "device-attest-01", Status: StatusValid, Fingerprint: fingerprint}),
+			"pending": marshal(&baseChallenge{ID: "pending", Type: "device-attest-01", Status: StatusPending}),
+		},
+	}
+	mockdb := newCleanupDB(tables)
+	device := &order{Identifiers: []Identifier{{Type: "permanent-identifier", Value: "12345678"}}, Authorizations: []string{"az"}}
+	notValidated := &order{Identifiers: []Identifier{{Type: "permanent-identifier", Value: "12345678"}}, Authorizations: []string{"pending"}}
+	shaken := &order{Identifiers: []Identifier{{Type: "TNAuthList", Value: testTNAuthList}}}
+	tnAuthList, err := decodeTNAuthList(testTNAuthList)
+	assert.FatalError(t, err)
+	tnAuthListExt := pkix.Extension{Id: oidTNAuthList, Value: tnAuthList}
+	type test struct {
+		o   *order
+		csr *x509.CertificateRequest
+		err *Error
+	}
+	tests := map[string]test{
+		"fail/dns-names": {
+			o:   &order{Identifiers: []Identifier{{Type: "dns", Value: "acme.example.com"}}},
+			csr: &x509.CertificateRequest{DNSNames: []string{"foo.example.com"}},
+			err: BadCSRErr(errors.New("CSR names do not match identifiers exactly")),
+		},
+		"fail/permanent-identifier-common-name": {
+			o:   device,
+			csr: &x509.CertificateRequest{Subject: pkix.Name{CommonName: "87654321"}},
+			err: BadCSRErr(errors.New("CSR common name does not match the permanent identifier")),
+		},
+		"fail/permanent-identifier-sans": {
+			o:   device,
+			csr: &x509.CertificateRequest{Subject: pkix.Name{CommonName: "12345678"}, DNSNames: []string{"12345678"}},
+			err: BadCSRErr(errors.New("CSR of a permanent-identifier order cannot contain subject alternative names")),
+		},
+		"fail/permanent-identifier-key": {
+			o:   device,
+			csr: &x509.CertificateRequest{Subject: pkix.Name{CommonName: "12345678"}, PublicKey: other.Public()},
+			err: BadCSRErr(errors.New("CSR public key does not match the attested key")),
+		},
+		"fail/permanent-identifier-not-validated": {
+			o:   notValidated,
+			csr: &x509.CertificateRequest{Subject: pkix.Name{CommonName: "12345678"}, PublicKey: attested.Public()},
+			err: BadCSRErr(errors.New("CSR public key does not match the attested key")),
+		},
+		"fail/tnauthlist-missing": {
+			o:   shaken,
+			csr: &x509.CertificateRequest{Subject: pkix.Name{CommonName: "SHAKEN 1234"}},
+			err: BadCSRErr(errors.New("CSR of a TNAuthList order must contain the TNAuthList extension")),
+		},
+		"fail/tnauthlist-mismatch": {
+			o: shaken,
+			csr: &x509.CertificateRequest{Extensions: []pkix.Extension{
+				{Id: oidTNAuthList, Value: []byte{0x30, 0x03, 0x16, 0x01, 0x41}},
+			}},
+			err: BadCSRErr(errors.New("CSR TNAuthList extension does not match the TNAuthList identifier")),
+		},
+		"fail/tnauthlist-sans": {
+			o:   shaken,
+			csr: &x509.CertificateRequest{Extensions: []pkix.Extension{tnAuthListExt}, DNSNames: []string{"acme.example.com"}},
+			err: BadCSRErr(errors.New("CSR of a TNAuthList order cannot contain subject alternative names")),
+		},
+		"ok/tnauthlist": {
+			o:   shaken,
+			csr: &x509.CertificateRequest{Subject: pkix.Name{CommonName: "SHAKEN 1234"}, Extensions: []pkix.Extension{tnAuthListExt}},
+		},
+		"ok/dns": {
+			o:   &order{Identifiers: []Identifier{{Type: "dns", Value: "acme.example.com"}}},
+			csr: &x509.CertificateRequest{Subject: pkix.Name{CommonName: "acme.example.com"}},
+		},
+		"ok/permanent-identifier": {
+			o:   device,
+			csr: &x509.CertificateRequest{Subject: pkix.Name{CommonName: "12345678"}, PublicKey: attested.Public()},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tc.o.validateCSR(mockdb, tc.csr); err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestAuthorizeProfile(t *testing.T) {
+	prov := &provisioner.ACME{
+		Name:           "acme",
+		Profiles:       provisioner.ACMEProfiles{"server": {}, "shortlived": {}},
+		DefaultProfile: "server",
+	}
+	type test struct {
+		p    provisioner.Interface
+		name string
+		want string
+		err  *Error
+	}
+	tests := map[string]test{
+		"fail/unknown": {p: prov, name: "client", err: InvalidProfileErr(errors.New("profile client is not supported by provisioner acme"))},
+		"fail/not-acme": {
+			p:    &provisioner.JWK{Name: "jwk"},
+			name: "server",
+			err:  InvalidProfileErr(errors.New("provisioner jwk does not support profiles")),
+		},
+		"ok/not-acme": {p: &provisioner.JWK{Name: "jwk"}},
+		"ok/default":  {p: prov, want: "server"},
+		"ok/profile":  {p: prov, name: "shortlived", want: "shortlived"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := authorizeProfile(tc.p, tc.name)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestAuthorizeIdentifiers(t *testing.T) {
+	p := &provisioner.ACME{
+		Type:          "ACME",
+		Name:          "team@acme-provisioner.com",
+		Domains:       []string{"*.team.example.com"},
+		DeniedDomains: []string{"admin.team.example.com"},
+	}
+	assert.FatalError(t, p.Init(provisioner.Config{Claims: globalProvisionerClaims}))
+	httpOnly := &provisioner.ACME{
+		Type:           "ACME",
+		Name:           "http@acme-provisioner.com",
+		ChallengeTypes: []string{"http-01", "tls-alpn-01"},
+	}
+	assert.FatalError(t, httpOnly.Init(provisioner.Config{Claims: globalProvisionerClaims}))
+	shaken := &provisioner.ACME{
+		Type:                "ACME",
+		Name:                "shaken@acme-provisioner.com",
+		TokenAuthorityRoots: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: newAttestationCA(t).root.Raw}),
+	}
+	assert.FatalError(t, shaken.Init(provisioner.Config{Claims: globalProvisionerClaims}))
+	type test struct {
+		prov *provisioner.ACME
+		ids  []Identifier
+		err  *Error
+	}
+	tests := map[string]test{
+		"fail/wildcard-without-dns-01": {
+			prov: httpOnly,
+			ids:  []Identifier{{Type: "dns", Value: "www.example.com"}, {Type: "dns", Value: "*.example.com"}},
+			err: RejectedIdentifierErr(errors.New("wildcard domain *.example.com requires the dns-01 challenge, " +
+				"which is not enabled by provisioner http@acme-provisioner.com")),
+		},
+		"fail/not-allowed": {
+			ids: []Identifier{{Type: "dns", Value: "www.team.example.com"}, {Type: "dns", Value: "example.com"}},
+			err: RejectedIdentifierErr(errors.New("domain example.com is not allowed by provisioner team@acme-provisioner.com: dns name example.com is not allowed by the policy")),
+		},
+		"fail/denied": {
+			ids: []Identifier{{Type: "dns", Value: "admin.team.example.com"}},
+			err: RejectedIdentifierErr(errors.New("domain admin.team.example.com is not allowed by provisioner team@acme-provisioner.com: dns name admin.team.example.com is denied by the policy")),
+		},
+		"fail/tnauthlist": {
+			ids: []Identifier{{Type: "TNAuthList", Value: testTNAuthList}},
+			err: RejectedIdentifierErr(errors.New("provisioner team@acme-provisioner.com does not support TNAuthList identifiers")),
+		},
+		"fail/tnauthlist-value": {
+			prov: shaken,
+			ids:  []Identifier{{Type: "TNAuthList", Value: "MAA="}},
+			err:  MalformedErr(errors.New("error parsing TNAuthList: value is not a non-empty sequence")),
+		},
+		"ok/tnauthlist": {
+			prov: shaken,
+			ids:  []Identifier{{Type: "TNAuthList", Value: testTNAuthList}},
+		},
+		"fail/permanent-identifier": {
+			ids: []Identifier{{Type: "permanent-identifier", Value: "12345678"}},
+			err: RejectedIdentifierErr(errors.New("provisioner team@acme-provisioner.com does not support permanent-identifier identifiers")),
+		},
+		"fail/subproblems": {
+			ids: []Identifier{{Type: "dns", Value: "example.com"}, {Type: "dns", Value: "www.team.example.com"}, {Type: "dns", Value: "admin.team.example.com"}},
+			err: CompoundErr(errors.New("2 identifiers have errors: domain example.com is not allowed by provisioner team@acme-provisioner.com: dns name example.com is not allowed by the policy; " +
+				"domain admin.team.example.com is not allowed by provisioner team@acme-provisioner.com: dns name admin.team.example.com is denied by the policy")),
+		},
+		"ok": {
+			ids: []Identifier{{Type: "dns", Value: "www.team.example.com"}, {Type: "dns", Value: "*.api.team.example.com"}},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			prov := p
+			if tc.prov != nil {
+				prov = tc.prov
+			}
+			if err := authorizeIdentifiers(prov, tc.ids); err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+					// Each rejected identifier is reported in a subproblem.
+					aerr := ae.ToACME()
+					if ae.Type == compoundErr {
+						assert.Equals(t, 2, len(aerr.Subproblems))
+						for i, sub := range aerr.Subproblems {
+							assert.Equals(t, sub.(*AError).Identifier, Identifier{Type: "dns", Value: []string{"example.com", "admin.team.example.com"}[i]})
+						}
+					} else {
+						assert.NotNil(t, aerr.Identifier)
+					}
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package acme
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// PerspectiveRequest is the request sent to a remote perspective agent to
+// validate an http-01 or dns-01 challenge. KeyAuthorization is the key
+// authorization expected by the ACME server.
+type PerspectiveRequest struct {
+	Type             string `json:"type"`
+	Identifier       string `json:"identifier"`
+	Token            string `json:"token"`
+	KeyAuthorization string `json:"keyAuthorization"`
+}
+
+// PerspectiveResponse is the response of a remote perspective agent. If the
+// challenge is not valid, Error contains the reason. Attempt is the record of
+// the validation performed by the agent.
+type PerspectiveResponse struct {
+	Valid   bool               `json:"valid"`
+	Error   *AError            `json:"error,omitempty"`
+	Attempt *ValidationAttempt `json:"attempt,omitempty"`
+}
+
+// perspectiveClient requests the validation of challenges to the remote
+// perspective agents.
+type perspectiveClient struct {
+	client *http.Client
+	agents []string
+	quorum int
+}
+
+// newPerspectiveClient returns the client used to reach the remote perspective
+// agents, or nil if the multi-perspective validation is not configured.
+func newPerspectiveClient(c *PerspectivesConfig) (*perspectiveClient, error) {
+	if !c.IsEnabled() {
+		return nil, nil
+	}
+	crt, err := tls.LoadX509KeyPair(c.Certificate, c.Key)
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading perspectives certificate")
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{crt},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if c.Root != "" {
+		b, err := ioutil.ReadFile(c.Root)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading %s", c.Root)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, errors.Errorf("error parsing %s: no certificates found", c.Root)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &perspectiveClient{
+		client: &http.Client{
+			Timeout:   c.GetTimeout(),
+			Transport: transport,
+		},
+		agents: c.Agents,
+		quorum: c.GetQuorum(),
+	}, nil
+}
+
+// corroborate requests the validation of a challenge to all the agents
+// concurrently. It returns the attempts of the agents, sorted by vantage, and
+// an error if less than the quorum of agents validate the challenge.
+func (pc *perspectiveClient) corroborate(req PerspectiveRequest) ([]*ValidationAttempt, *Error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, ServerInternalErr(errors.Wrap(err, "error marshaling perspective request"))
+	}
+	results := make(chan *ValidationAttempt, len(pc.agents))
+	for _, agent := range pc.agents {
+		go func(agent string) {
+			results <- pc.validate(agent, body)
+		}(agent)
+	}
+	var valid int
+	var failures []string
+	attempts := make([]*ValidationAttempt, 0, len(pc.agents))
+	for range pc.agents {
+		att := <-results
+		if att.Error != nil {
+			failures = append(failures, att.Error.Detail)
+		} else {
+			valid++
+		}
+		attempts = append(attempts, att)
+	}
+	sort.Slice(attempts, func(i, j int) bool {
+		return attempts[i].Vantage < attempts[j].Vantage
+	})
+	if valid >= pc.quorum {
+		return attempts, nil
+	}
+	sort.Strings(failures)
+	return attempts, IncorrectResponseErr(errors.Errorf("challenge validated by %d of %d "+
+		"remote perspectives, %d required: %s", valid, len(pc.agents), pc.quorum,
+		strings.Join(failures, "; ")))
+}
+
+// validate sends the validation request to the given agent and returns the
+// attempt of the agent.
+func (pc *perspectiveClient) validate(agent string, body []byte) *ValidationAttempt {
+	var pr PerspectiveResponse
+	if err := pc.post(agent, body, &pr); err != nil {
+		return newValidationAttempt(agent).fail(ConnectionErr(err))
+	}
+	att := pr.Attempt
+	if att == nil {
+		att = newValidationAttempt(agent)
+	}
+	att.Vantage = agent
+	switch {
+	case pr.Valid:
+		att.Error = nil
+	case pr.Error != nil:
+		att.fail(IncorrectResponseErr(errors.Errorf("%s: %s", agent, pr.Error.Detail)))
+	default:
+		att.fail(IncorrectResponseErr(errors.Errorf("%s: challenge is not valid", agent)))
+	}
+	return att
+}
+
+// post sends the validation request to the given agent and decodes its
+// response.
+func (pc *perspectiveClient) post(agent string, body []byte, pr *PerspectiveResponse) error {
+	resp, err := pc.client.Post(agent, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrapf(err, "error doing http POST for url %s", agent)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("error doing http POST for url %s with status code %d", agent, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(pr); err != nil {
+		return errors.Wrapf(err, "error decoding response of %s", agent)
+	}
+	return nil
+}
+
+// PerspectiveAgent is the http.Handler of a remote perspective agent. It
+// validates the http-01 and dns-01 challenges requested by an ACME server from
+// its own network location. The agent must be served over mTLS, accepting
+// only the client certificates of the ACME servers, see NewPerspectiveServer.
+type PerspectiveAgent struct {
+	httpGet   httpGetter
+	lookupTxt lookupTxt
+}
+
+// NewPerspectiveAgent returns a new PerspectiveAgent that validates http-01
+// challenges and dns-01 challenges using the given configurations.
+func NewPerspectiveAgent(http01 *HTTP01Config, dns01 *DNS01Config) (*PerspectiveAgent, error) {
+	if err := http01.Validate(); err != nil {
+		return nil, err
+	}
+	if err := dns01.Validate(); err != nil {
+		return nil, err
+	}
+	lookupTxt, err := newDNS01LookupTxt(dns01)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating dns-01 resolver")
+	}
+	return &PerspectiveAgent{
+		httpGet:   newHTTP01Client(http01, nil).Do,
+		lookupTxt: lookupTxt,
+	}, nil
+}
+
+// NewPerspectiveServer returns the server of a remote perspective agent
+// listening on the given address with the given certificate. Clients must
+// present a certificate that chains to one of the roots in clientCAs.
+func NewPerspectiveServer(addr string, agent *PerspectiveAgent, crt tls.Certificate, clientCAs *x509.CertPool) *http.Server {
+	return &http.Server{
+		Addr:    addr,
+		Handler: agent,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{crt},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    clientCAs,
+			MinVersion:   tls.VersionTLS12,
+		},
+		ReadHeaderTimeout: 15 * time.Second,
+	}
+}
+
+// ServeHTTP implements the http.Handler interface. Requests without a verified
+// client certificate are rejected.
+func (pa *PerspectiveAgent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	var req PerspectiveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "error decoding request", http.StatusBadRequest)
+		return
+	}
+	att := newValidationAttempt(localVantage)
+	resp := PerspectiveResponse{Valid: true, Attempt: att}
+	if err := pa.validate(req, att); err != nil {
+		att.fail(err)
+		resp = PerspectiveResponse{Error: att.Error, Attempt: att}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, "error encoding response", http.StatusInternalServerError)
+	}
+}
+
+// validate validates the requested challenge, recording the target and the
+// response in the given attempt. It returns the reason if the challenge is not
+// valid.
+func (pa *PerspectiveAgent) validate(req PerspectiveRequest, att *ValidationAttempt) *Error {
+	switch req.Type {
+	case "http-01":
+		keyAuth, verr, err := http01KeyAuthorization(pa.httpGet, req.Identifier, req.Token, att)
+		if err != nil {
+			return Wrap(err, "error validating http-01 challenge")
+		}
+		if verr != nil {
+			return verr
+		}
+		if keyAuth != req.KeyAuthorization {
+			return RejectedIdentifierErr(errors.Errorf("keyAuthorization does not match; "+
+				"expected %s, but got %s", req.KeyAuthorization, keyAuth))
+		}
+		return nil
+	case "dns-01":
+		txtRecords, verr := dns01TXTRecords(pa.lookupTxt, req.Identifier, att)
+		if verr != nil {
+			return verr
+		}
+		if !dns01Match(txtRecords, req.KeyAuthorization) {
+			return RejectedIdentifierErr(errors.Errorf("keyAuthorization "+
+				"does not match; expected %s, but got %s", req.KeyAuthorization, txtRecords))
+		}
+		return nil
+	default:
+		return MalformedErr(errors.Errorf("unsupported challenge type %s", req.Type))
+	}
+}
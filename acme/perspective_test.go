@@ -0,0 +1,259 @@
+package acme
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+)
+
+func TestNewPerspectiveClient(t *testing.T) {
+	pc, err := newPerspectiveClient(nil)
+	assert.FatalError(t, err)
+	assert.Nil(t, pc)
+
+	_, err = newPerspectiveClient(&PerspectivesConfig{
+		Agents:      []string{"https://agent.internal"},
+		Certificate: "testdata/missing.crt",
+		Key:         "testdata/missing.key",
+	})
+	if assert.NotNil(t, err) {
+		assert.HasPrefix(t, err.Error(), "error loading perspectives certificate")
+	}
+}
+
+func TestPerspectiveClientCorroborate(t *testing.T) {
+	req := PerspectiveRequest{Type: "http-01", Identifier: "zap.internal", Token: "token", KeyAuthorization: "token.thumbprint"}
+	newAgent := func(resp *PerspectiveResponse) *httptest.Server {
+		return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var pr PerspectiveRequest
+			assert.FatalError(t, json.NewDecoder(r.Body).Decode(&pr))
+			assert.Equals(t, pr, req)
+			if resp == nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			assert.FatalError(t, json.NewEncoder(w).Encode(resp))
+		}))
+	}
+	valid := newAgent(&PerspectiveResponse{Valid: true, Attempt: &ValidationAttempt{Vantage: "local", TargetIP: "192.0.2.1"}})
+	defer valid.Close()
+	invalid := newAgent(&PerspectiveResponse{Error: &AError{Detail: "keyAuthorization does not match"}})
+	defer invalid.Close()
+	broken := newAgent(nil)
+	defer broken.Close()
+
+	type test struct {
+		agents   []string
+		quorum   int
+		valid    int
+		err      *Error
+		failures []string
+	}
+	tests := map[string]test{
+		"ok/all": {
+			agents: []string{valid.URL, valid.URL},
+			quorum: 2,
+			valid:  2,
+		},
+		"ok/quorum": {
+			agents: []string{valid.URL, invalid.URL, broken.URL},
+			quorum: 1,
+			valid:  1,
+		},
+		"fail/quorum": {
+			agents: []string{valid.URL, invalid.URL, broken.URL},
+			quorum: 2,
+			valid:  1,
+			err:    IncorrectResponseErr(errors.New("challenge validated by 1 of 3 remote perspectives, 2 required: ")),
+			failures: []string{
+				invalid.URL + ": keyAuthorization does not match",
+				"error doing http POST for url " + broken.URL + " with status code 500",
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			pc := &perspectiveClient{client: valid.Client(), agents: tc.agents, quorum: tc.quorum}
+			attempts, err := pc.corroborate(req)
+			if assert.Equals(t, len(tc.agents), len(attempts)) {
+				var n int
+				for i, att := range attempts {
+					if i > 0 {
+						assert.True(t, attempts[i-1].Vantage <= att.Vantage)
+					}
+					if att.Error == nil {
+						assert.Equals(t, valid.URL, att.Vantage)
+						assert.Equals(t, "192.0.2.1", att.TargetIP)
+						n++
+					}
+				}
+				assert.Equals(t, tc.valid, n)
+			}
+			if tc.err == nil {
+				assert.Nil(t, err)
+			} else if assert.NotNil(t, err) {
+				assert.Equals(t, err.Type, tc.err.Type)
+				assert.HasPrefix(t, err.Error(), tc.err.Error())
+				for _, s := range tc.failures {
+					assert.True(t, strings.Contains(err.Error(), s))
+				}
+			}
+		})
+	}
+}
+
+func TestPerspectiveAgent(t *testing.T) {
+	h := sha256.Sum256([]byte("token.thumbprint"))
+	digest := base64.RawURLEncoding.EncodeToString(h[:])
+	pa := &PerspectiveAgent{
+		httpGet: func(req *http.Request) (*http.Response, error) {
+			url := req.URL.String()
+			if url == "http://bad.internal/.well-known/acme-challenge/token" {
+				return nil, errors.New("force")
+			}
+			assert.Equals(t, url, "http://zap.internal/.well-known/acme-challenge/token")
+			return &http.Response{Body: ioutil.NopCloser(bytes.NewBufferString("token.thumbprint\n"))}, nil
+		},
+		lookupTxt: func(name string) ([]string, error) {
+			if name == "_acme-challenge.bad.internal" {
+				return nil, errors.New("force")
+			}
+			assert.Equals(t, name, "_acme-challenge.zap.internal")
+			return []string{"foo", digest}, nil
+		},
+	}
+
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+
+	type test struct {
+		method     string
+		body       string
+		tls        *tls.ConnectionState
+		statusCode int
+		resp       *PerspectiveResponse
+		response   string
+	}
+	tests := map[string]test{
+		"fail/no-tls": {
+			method:     "POST",
+			body:       `{"type":"http-01","identifier":"zap.internal","token":"token","keyAuthorization":"token.thumbprint"}`,
+			statusCode: 401,
+		},
+		"fail/unverified": {
+			method:     "POST",
+			body:       `{"type":"http-01","identifier":"zap.internal","token":"token","keyAuthorization":"token.thumbprint"}`,
+			tls:        &tls.ConnectionState{},
+			statusCode: 401,
+		},
+		"fail/method": {
+			method:     "GET",
+			tls:        verified,
+			statusCode: 405,
+		},
+		"fail/body": {
+			method:     "POST",
+			tls:        verified,
+			body:       "{",
+			statusCode: 400,
+		},
+		"ok/http-01": {
+			method:     "POST",
+			tls:        verified,
+			body:       `{"type":"http-01","identifier":"zap.internal","token":"token","keyAuthorization":"token.thumbprint"}`,
+			statusCode: 200,
+			resp:       &PerspectiveResponse{Valid: true},
+			response:   "token.thumbprint\n",
+		},
+		"ok/http-01-mismatch": {
+			method:     "POST",
+			tls:        verified,
+			body:       `{"type":"http-01","identifier":"zap.internal","token":"token","keyAuthorization":"token.foo"}`,
+			statusCode: 200,
+			response:   "token.thumbprint\n",
+			resp: &PerspectiveResponse{Error: RejectedIdentifierErr(errors.New("keyAuthorization does not match; " +
+				"expected token.foo, but got token.thumbprint")).ToACME()},
+		},
+		"ok/http-01-connection": {
+			method:     "POST",
+			tls:        verified,
+			body:       `{"type":"http-01","identifier":"bad.internal","token":"token","keyAuthorization":"token.thumbprint"}`,
+			statusCode: 200,
+			resp: &PerspectiveResponse{Error: ConnectionErr(errors.New("error doing http GET for url " +
+				"http://bad.internal/.well-known/acme-challenge/token: force")).ToACME()},
+		},
+		"ok/dns-01": {
+			method:     "POST",
+			tls:        verified,
+			body:       `{"type":"dns-01","identifier":"*.zap.internal","token":"token","keyAuthorization":"token.thumbprint"}`,
+			statusCode: 200,
+			resp:       &PerspectiveResponse{Valid: true},
+			response:   "foo\n" + digest,
+		},
+		"ok/dns-01-lookup": {
+			method:     "POST",
+			tls:        verified,
+			body:       `{"type":"dns-01","identifier":"bad.internal","token":"token","keyAuthorization":"token.thumbprint"}`,
+			statusCode: 200,
+			resp: &PerspectiveResponse{Error: DNSErr(errors.New("error looking up TXT records for " +
+				"domain bad.internal: force")).ToACME()},
+		},
+		"ok/unsupported-type": {
+			method:     "POST",
+			tls:        verified,
+			body:       `{"type":"tls-alpn-01","identifier":"zap.internal","token":"token","keyAuthorization":"token.thumbprint"}`,
+			statusCode: 200,
+			resp:       &PerspectiveResponse{Error: MalformedErr(errors.New("unsupported challenge type tls-alpn-01")).ToACME()},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "https://agent.internal", strings.NewReader(tc.body))
+			req.TLS = tc.tls
+			w := httptest.NewRecorder()
+			pa.ServeHTTP(w, req)
+			res := w.Result()
+			assert.Equals(t, res.StatusCode, tc.statusCode)
+			if tc.resp != nil {
+				var resp PerspectiveResponse
+				assert.FatalError(t, json.NewDecoder(res.Body).Decode(&resp))
+				assert.Equals(t, resp.Valid, tc.resp.Valid)
+				if tc.resp.Error == nil {
+					assert.Nil(t, resp.Error)
+				} else if assert.NotNil(t, resp.Error) {
+					assert.Equals(t, resp.Error.Type, tc.resp.Error.Type)
+					assert.Equals(t, resp.Error.Detail, tc.resp.Error.Detail)
+				}
+				if assert.NotNil(t, resp.Attempt) {
+					assert.Equals(t, "local", resp.Attempt.Vantage)
+					assert.Equals(t, resp.Error, resp.Attempt.Error)
+					if tc.response != "" {
+						sum := sha256.Sum256([]byte(tc.response))
+						assert.Equals(t, hex.EncodeToString(sum[:]), resp.Attempt.ResponseDigest)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestNewPerspectiveServer(t *testing.T) {
+	pool := x509.NewCertPool()
+	pa := &PerspectiveAgent{}
+	srv := NewPerspectiveServer(":8443", pa, tls.Certificate{}, pool)
+	assert.Equals(t, ":8443", srv.Addr)
+	assert.Equals(t, http.Handler(pa), srv.Handler)
+	assert.Equals(t, tls.RequireAndVerifyClientCert, srv.TLSConfig.ClientAuth)
+	assert.True(t, srv.TLSConfig.ClientCAs == pool)
+}
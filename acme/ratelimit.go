@@ -0,0 +1,153 @@
+package acme
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"golang.org/x/net/publicsuffix"
+)
+
+const (
+	ordersPerAccountWindow               = time.Hour
+	certificatesPerDomainWindow          = 7 * 24 * time.Hour
+	failedValidationsPerIdentifierWindow = time.Hour
+)
+
+// rateLimiter counts the events that happened in a sliding window for a given
+// key. The counters are kept in memory, so each instance of the CA enforces
+// its own limits.
+type rateLimiter struct {
+	mu     sync.Mutex
+	events map[string][]time.Time
+	lastGC time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		events: make(map[string][]time.Time),
+	}
+}
+
+// reservation is an event recorded by reserve. It must be canceled if the
+// operation it counts does not happen.
+type reservation struct {
+	limiter *rateLimiter
+	key     string
+	time    time.Time
+}
+
+// reserve records a new event for the given key if the number of events in
+// the window is below the limit, otherwise it returns a rateLimited error. The
+// check and the record are atomic, so concurrent requests cannot exceed the
+// limit. A limit less or equal to 0 disables the check, and no event is
+// recorded.
+func (r *rateLimiter) reserve(key string, limit int, window time.Duration) (*reservation, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := clock.Now()
+	events := r.prune(key, now.Add(-window))
+	if len(events) >= limit {
+		err := RateLimitedErr(errors.Errorf("rate limit of %d per %s exceeded for %s", limit, window, key))
+		err.RetryAfter = events[len(events)-limit].Add(window).Sub(now)
+		return nil, err
+	}
+	r.events[key] = append(events, now)
+	r.gc(now)
+	return &reservation{limiter: r, key: key, time: now}, nil
+}
+
+// add records a new event for the given key.
+func (r *rateLimiter) add(key string, window time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := clock.Now()
+	r.events[key] = append(r.prune(key, now.Add(-window)), now)
+	r.gc(now)
+}
+
+// cancel removes the event recorded by the reservation. A nil reservation is
+// a no-op.
+func (res *reservation) cancel() {
+	if res == nil {
+		return
+	}
+	r := res.limiter
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := r.events[res.key]
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].Equal(res.time) {
+			events = append(events[:i], events[i+1:]...)
+			break
+		}
+	}
+	if len(events) == 0 {
+		delete(r.events, res.key)
+	} else {
+		r.events[res.key] = events
+	}
+}
+
+// gc periodically drops the keys without recent events. It must be called
+// with the lock held.
+func (r *rateLimiter) gc(now time.Time) {
+	if now.Sub(r.lastGC) > time.Hour {
+		for k := range r.events {
+			r.prune(k, now.Add(-certificatesPerDomainWindow))
+		}
+		r.lastGC = now
+	}
+}
+
+// prune removes the events of the given key that happened before the given
+// time and returns the remaining ones. It must be called with the lock held.
+func (r *rateLimiter) prune(key string, before time.Time) []time.Time {
+	events := r.events[key]
+	i := 0
+	for i < len(events) && events[i].Before(before) {
+		i++
+	}
+	if events = events[i:]; len(events) == 0 {
+		delete(r.events, key)
+		return nil
+	}
+	r.events[key] = events
+	return events
+}
+
+// getRateLimits returns the rate limits configured in the provisioner.
+func getRateLimits(p provisioner.Interface) *provisioner.ACMERateLimits {
+	if acmeProv, ok := p.(*provisioner.ACME); ok && acmeProv.RateLimits != nil {
+		return acmeProv.RateLimits
+	}
+	return &provisioner.ACMERateLimits{}
+}
+
+// registeredDomain returns the domain registered under a public suffix, e.g.
+// example.com for www.example.com. If it cannot be calculated the name is
+// returned.
+func registeredDomain(name string) string {
+	name = strings.TrimPrefix(strings.ToLower(name), "*.")
+	if domain, err := publicsuffix.EffectiveTLDPlusOne(name); err == nil {
+		return domain
+	}
+	return name
+}
+
+func ordersPerAccountKey(p provisioner.Interface, accID string) string {
+	return p.GetID() + "/orders/" + accID
+}
+
+func certificatesPerDomainKey(p provisioner.Interface, domain string) string {
+	return p.GetID() + "/certificates/" + domain
+}
+
+func failedValidationsKey(p provisioner.Interface, identifier string) string {
+	return p.GetID() + "/failed-validations/" + identifier
+}
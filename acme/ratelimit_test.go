@@ -0,0 +1,129 @@
+package acme
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/nosql/database"
+)
+
+func TestRateLimiter(t *testing.T) {
+	r := newRateLimiter()
+
+	// A limit of 0 disables the check and records nothing.
+	res, err := r.reserve("foo", 0, time.Hour)
+	assert.FatalError(t, err)
+	assert.Nil(t, res)
+	res.cancel()
+	assert.Len(t, 0, r.events)
+
+	for i := 0; i < 2; i++ {
+		_, err := r.reserve("foo", 2, time.Hour)
+		assert.FatalError(t, err)
+	}
+	_, err = r.reserve("foo", 2, time.Hour)
+	if assert.NotNil(t, err) {
+		ae, ok := err.(*Error)
+		assert.Fatal(t, ok)
+		assert.Equals(t, ae.Type, rateLimitedErr)
+		assert.Equals(t, ae.StatusCode(), 429)
+		assert.True(t, ae.RetryAfter > 0 && ae.RetryAfter <= time.Hour)
+	}
+	assert.Len(t, 2, r.events["foo"])
+
+	// Other keys are not affected.
+	res, err = r.reserve("bar", 2, time.Hour)
+	assert.FatalError(t, err)
+
+	// A canceled reservation is not counted.
+	res.cancel()
+	_, ok := r.events["bar"]
+	assert.False(t, ok)
+
+	// Events outside the window are not counted.
+	r.events["foo"][0] = clock.Now().Add(-2 * time.Hour)
+	_, err = r.reserve("foo", 2, time.Hour)
+	assert.FatalError(t, err)
+	assert.Len(t, 2, r.events["foo"])
+
+	r.events["foo"][0] = clock.Now().Add(-2 * time.Hour)
+	r.events["foo"][1] = clock.Now().Add(-2 * time.Hour)
+	r.add("baz", time.Hour)
+	r.prune("foo", clock.Now().Add(-time.Hour))
+	_, ok = r.events["foo"]
+	assert.False(t, ok)
+	assert.Len(t, 1, r.events["baz"])
+}
+
+func TestRateLimiterConcurrent(t *testing.T) {
+	r := newRateLimiter()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var granted int
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.reserve("foo", 5, time.Hour); err == nil {
+				mu.Lock()
+				granted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equals(t, 5, granted)
+}
+
+func TestRegisteredDomain(t *testing.T) {
+	tests := map[string]string{
+		"example.com":         "example.com",
+		"www.example.com":     "example.com",
+		"*.www.example.com":   "example.com",
+		"WWW.Example.co.uk":   "example.co.uk",
+		"foo.bar.example.net": "example.net",
+		"com":                 "com",
+		"localhost":           "localhost",
+	}
+	for name, want := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equals(t, want, registeredDomain(name))
+		})
+	}
+}
+
+func TestAuthorityNewOrderRateLimited(t *testing.T) {
+	prov := &provisioner.ACME{
+		Type:       "ACME",
+		Name:       "test@acme-provisioner.com",
+		RateLimits: &provisioner.ACMERateLimits{OrdersPerAccount: 1},
+	}
+	assert.FatalError(t, prov.Init(provisioner.Config{Claims: globalProvisionerClaims}))
+
+	auth, err := NewAuthority(&db.MockNoSQLDB{
+		MGet: func(bucket, key []byte) ([]byte, error) {
+			return nil, database.ErrNotFound
+		},
+	}, "ca.smallstep.com", "acme", nil)
+	assert.FatalError(t, err)
+	auth.limiter.add(ordersPerAccountKey(prov, "accID"), ordersPerAccountWindow)
+
+	_, err = auth.NewOrder(context.Background(), prov, OrderOptions{
+		AccountID:   "accID",
+		Identifiers: []Identifier{{Type: "dns", Value: "example.com"}},
+	})
+	if assert.NotNil(t, err) {
+		ae, ok := err.(*Error)
+		assert.Fatal(t, ok)
+		assert.Equals(t, ae.Type, rateLimitedErr)
+	}
+
+	// Other accounts are not limited.
+	_, err = auth.limiter.reserve(ordersPerAccountKey(prov, "otherID"), 1, ordersPerAccountWindow)
+	assert.FatalError(t, err)
+}
@@ -0,0 +1,189 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// dialFunc is the function used by a net.Resolver to connect to a DNS server.
+type dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// newDNS01LookupTxt returns the function used to look up the TXT records of
+// dns-01 challenges. If no resolvers are configured the system resolver is
+// used. Otherwise, the resolvers are tried in order until one of them returns
+// an answer.
+func newDNS01LookupTxt(c *DNS01Config) (lookupTxt, error) {
+	if c == nil || len(c.Resolvers) == 0 {
+		return net.LookupTXT, nil
+	}
+
+	resolvers := make([]*net.Resolver, len(c.Resolvers))
+	for i, s := range c.Resolvers {
+		dial, err := parseResolver(s)
+		if err != nil {
+			return nil, err
+		}
+		resolvers[i] = &net.Resolver{
+			PreferGo: true,
+			Dial:     dial,
+		}
+	}
+
+	return func(name string) ([]string, error) {
+		// Use a rooted name to avoid the search domains in resolv.conf.
+		if !strings.HasSuffix(name, ".") {
+			name += "."
+		}
+		var err error
+		for _, r := range resolvers {
+			var txt []string
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			txt, err = r.LookupTXT(ctx, name)
+			cancel()
+			if err == nil {
+				return txt, nil
+			}
+			// Do not try the next resolver if the record does not exist.
+			if e, ok := err.(*net.DNSError); ok && e.IsNotFound {
+				return nil, err
+			}
+		}
+		return nil, err
+	}, nil
+}
+
+// parseResolver returns the dial function used to connect to the given
+// resolver. The supported formats are:
+//
+//   - host[:port] and udp://host[:port] for plain DNS on port 53.
+//   - tcp://host[:port] for plain DNS over TCP on port 53.
+//   - tls://host[:port] for DNS over TLS on port 853.
+//   - https://host[:port]/path for DNS over HTTPS.
+func parseResolver(s string) (dialFunc, error) {
+	if !strings.Contains(s, "://") {
+		s = "udp://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing resolver %s", s)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.Errorf("error parsing resolver %s: host cannot be empty", s)
+	}
+
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+	}
+	withPort := func(port string) string {
+		if u.Port() != "" {
+			return u.Host
+		}
+		return net.JoinHostPort(u.Hostname(), port)
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "udp":
+		addr := withPort("53")
+		return func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		}, nil
+	case "tcp":
+		addr := withPort("53")
+		return func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "tcp", addr)
+		}, nil
+	case "tls":
+		addr := withPort("853")
+		config := &tls.Config{
+			ServerName: u.Hostname(),
+			MinVersion: tls.VersionTLS12,
+		}
+		return func(ctx context.Context, _, _ string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, "tcp", addr)
+			if err != nil {
+				return nil, err
+			}
+			tlsConn := tls.Client(conn, config)
+			if err := tlsConn.Handshake(); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return tlsConn, nil
+		}, nil
+	case "https":
+		endpoint := u.String()
+		client := &http.Client{
+			Timeout: 10 * time.Second,
+		}
+		return func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return &dohConn{ctx: ctx, endpoint: endpoint, client: client}, nil
+		}, nil
+	default:
+		return nil, errors.Errorf("error parsing resolver %s: unsupported scheme %s", s, u.Scheme)
+	}
+}
+
+// dohConn is a net.Conn that sends DNS queries using DNS over HTTPS (RFC
+// 8484). The Go resolver treats a connection that is not a net.PacketConn as
+// a stream, so queries and responses are prefixed with their length.
+type dohConn struct {
+	ctx      context.Context
+	endpoint string
+	client   *http.Client
+	resp     bytes.Buffer
+}
+
+// Write sends the given length-prefixed DNS query to the DoH endpoint and
+// stores the response.
+func (c *dohConn) Write(b []byte) (int, error) {
+	if len(b) < 2 {
+		return 0, errors.New("error writing dns query: message too short")
+	}
+	req, err := http.NewRequest("POST", c.endpoint, bytes.NewReader(b[2:]))
+	if err != nil {
+		return 0, errors.Wrap(err, "error creating dns-over-https request")
+	}
+	req = req.WithContext(c.ctx)
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, errors.Wrap(err, "error doing dns-over-https request")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.Errorf("error doing dns-over-https request: status code %d", resp.StatusCode)
+	}
+	msg, err := ioutil.ReadAll(io.LimitReader(resp.Body, 65535))
+	if err != nil {
+		return 0, errors.Wrap(err, "error reading dns-over-https response")
+	}
+
+	c.resp.Reset()
+	c.resp.Write([]byte{byte(len(msg) >> 8), byte(len(msg))})
+	c.resp.Write(msg)
+	return len(b), nil
+}
+
+// Read reads the length-prefixed DNS response.
+func (c *dohConn) Read(b []byte) (int, error) {
+	return c.resp.Read(b)
+}
+
+func (c *dohConn) Close() error                       { return nil }
+func (c *dohConn) LocalAddr() net.Addr                { return &net.TCPAddr{} }
+func (c *dohConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
+func (c *dohConn) SetDeadline(t time.Time) error      { return nil }
+func (c *dohConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *dohConn) SetWriteDeadline(t time.Time) error { return nil }
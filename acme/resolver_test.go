@@ -0,0 +1,120 @@
+package acme
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestParseResolver(t *testing.T) {
+	tests := map[string]struct {
+		resolver string
+		err      error
+	}{
+		"ok/host":       {resolver: "10.0.0.53"},
+		"ok/host-port":  {resolver: "10.0.0.53:5353"},
+		"ok/ipv6":       {resolver: "[::1]:53"},
+		"ok/udp":        {resolver: "udp://10.0.0.53"},
+		"ok/tcp":        {resolver: "tcp://10.0.0.53:53"},
+		"ok/tls":        {resolver: "tls://dns.internal"},
+		"ok/https":      {resolver: "https://dns.internal/dns-query"},
+		"fail/scheme":   {resolver: "quic://dns.internal", err: errors.New("error parsing resolver quic://dns.internal: unsupported scheme quic")},
+		"fail/no-host":  {resolver: "tls://", err: errors.New("error parsing resolver tls://: host cannot be empty")},
+		"fail/bad-host": {resolver: "https://dns internal/", err: errors.New("error parsing resolver https://dns internal/")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			dial, err := parseResolver(tc.resolver)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.NotNil(t, dial)
+			}
+		})
+	}
+}
+
+func TestNewDNS01LookupTxt(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var p dnsmessage.Parser
+		h, err := p.Start(b)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		q, err := p.Question()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		msg := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: h.ID, Response: true, Authoritative: true},
+			Questions: []dnsmessage.Question{q},
+		}
+		if q.Name.String() == "_acme-challenge.example.com." && q.Type == dnsmessage.TypeTXT {
+			msg.Answers = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET, TTL: 60},
+				Body:   &dnsmessage.TXTResource{TXT: []string{"keyauth"}},
+			}}
+		} else {
+			msg.Header.RCode = dnsmessage.RCodeNameError
+		}
+		resp, err := msg.Pack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(resp)
+	}))
+	defer srv.Close()
+
+	// Use the test server certificate in the default transport.
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = srv.Client().Transport
+	defer func() {
+		http.DefaultTransport = defaultTransport
+	}()
+
+	lookup, err := newDNS01LookupTxt(&DNS01Config{
+		Resolvers: []string{srv.URL + "/dns-query"},
+	})
+	assert.FatalError(t, err)
+
+	txt, err := lookup("_acme-challenge.example.com")
+	assert.FatalError(t, err)
+	assert.Equals(t, []string{"keyauth"}, txt)
+
+	_, err = lookup("_acme-challenge.missing.example.com")
+	if assert.NotNil(t, err) {
+		dnsErr, ok := err.(*net.DNSError)
+		if assert.True(t, ok) {
+			assert.True(t, dnsErr.IsNotFound)
+		}
+	}
+
+	// Without resolvers the system resolver is used.
+	_, err = newDNS01LookupTxt(nil)
+	assert.FatalError(t, err)
+	_, err = newDNS01LookupTxt(&DNS01Config{Resolvers: []string{"quic://dns.internal"}})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,141 @@
+package acme
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/nosql"
+)
+
+// Reason used to revoke the certificates of a deactivated account. The code
+// is privilegeWithdrawn as defined in RFC 5280, section 5.3.1.
+const (
+	deactivatedAccountReasonCode = 9
+	deactivatedAccountReason     = "ACME account deactivated"
+)
+
+// Reason codes accepted in revokeCert requests, as defined in RFC 5280,
+// section 5.3.1. The keyCompromise code also prevents new certificates for the
+// same key.
+const (
+	keyCompromiseReasonCode = 1
+	revokeCertReason        = "ACME revokeCert request"
+)
+
+var allowedRevocationReasons = map[int]bool{
+	0: true, // unspecified
+	1: true, // keyCompromise
+	3: true, // affiliationChanged
+	4: true, // superseded
+	5: true, // cessationOfOperation
+	9: true, // privilegeWithdrawn
+}
+
+// revokeOnDeactivation returns true if the certificates of the accounts
+// deactivated using the given provisioner must be revoked.
+func revokeOnDeactivation(p provisioner.Interface) bool {
+	acmeProv, ok := p.(*provisioner.ACME)
+	return ok && acmeProv.RevokeOnDeactivation
+}
+
+// revokeAccountCertificates revokes the certificates issued to the account
+// that have not expired yet.
+func (a *Authority) revokeAccountCertificates(accID string) error {
+	ra, ok := a.signAuth.(revokeAuthority)
+	if !ok {
+		return ServerInternalErr(errors.New("certificate authority does not support revocation"))
+	}
+	certs, err := getCertsByAccount(a.db, accID)
+	if err != nil {
+		return err
+	}
+	now := clock.Now()
+	for _, cert := range certs {
+		crt, err := cert.parseLeaf()
+		if err != nil {
+			return err
+		}
+		if now.After(crt.NotAfter) {
+			continue
+		}
+		if err := ra.RevokeCertificate(crt, deactivatedAccountReasonCode, deactivatedAccountReason); err != nil {
+			return ServerInternalErr(errors.Wrapf(err, "error revoking certificate %s of account %s", cert.ID, accID))
+		}
+	}
+	return nil
+}
+
+// RevokeCertificate revokes a certificate issued to the given account. If the
+// reason is keyCompromise the key of the certificate cannot be used in new
+// orders.
+func (a *Authority) RevokeCertificate(p provisioner.Interface, accID string, crt *x509.Certificate, reasonCode int) error {
+	ra, ok := a.signAuth.(revokeAuthority)
+	if !ok {
+		return ServerInternalErr(errors.New("certificate authority does not support revocation"))
+	}
+	if !allowedRevocationReasons[reasonCode] {
+		return BadRevocationReasonErr(errors.Errorf("reason code %d is not allowed", reasonCode))
+	}
+
+	certs, err := getCertsByAccount(a.db, accID)
+	if err != nil {
+		return err
+	}
+	var found bool
+	for _, cert := range certs {
+		leaf, err := cert.parseLeaf()
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(leaf.Raw, crt.Raw) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return UnauthorizedErr(errors.New("account does not own certificate"))
+	}
+
+	revoked, err := ra.IsRevoked(crt.SerialNumber.String())
+	if err != nil {
+		return ServerInternalErr(errors.Wrap(err, "error checking revocation status"))
+	}
+	if revoked {
+		return AlreadyRevokedErr(errors.Errorf("certificate %s has already been revoked", crt.SerialNumber))
+	}
+	if err := ra.RevokeCertificate(crt, reasonCode, revokeCertReason); err != nil {
+		return ServerInternalErr(errors.Wrapf(err, "error revoking certificate %s", crt.SerialNumber))
+	}
+
+	if reasonCode == keyCompromiseReasonCode {
+		if err := a.db.Set(compromisedKeyTable, keyFingerprint(crt.RawSubjectPublicKeyInfo), []byte(crt.SerialNumber.String())); err != nil {
+			return ServerInternalErr(errors.Wrap(err, "error storing compromised key"))
+		}
+	}
+	return nil
+}
+
+// keyFingerprint returns the key used to store a public key in the
+// compromised keys table.
+func keyFingerprint(spki []byte) []byte {
+	sum := sha256.Sum256(spki)
+	return []byte(hex.EncodeToString(sum[:]))
+}
+
+// checkCompromisedKey returns an error if the public key of the request has
+// been revoked with the keyCompromise reason.
+func checkCompromisedKey(db nosql.DB, csr *x509.CertificateRequest) error {
+	_, err := db.Get(compromisedKeyTable, keyFingerprint(csr.RawSubjectPublicKeyInfo))
+	switch {
+	case nosql.IsErrNotFound(err):
+		return nil
+	case err != nil:
+		return ServerInternalErr(errors.Wrap(err, "error checking compromised keys"))
+	default:
+		return BadPublicKeyErr(errors.New("public key has been revoked due to key compromise"))
+	}
+}
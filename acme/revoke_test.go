@@ -0,0 +1,307 @@
+package acme
+
+import (
+	"crypto/x509"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/cli/crypto/pemutil"
+)
+
+type mockRevokeAuthority struct {
+	mockSignAuth
+	isRevoked func(serial string) (bool, error)
+	revoke    func(crt *x509.Certificate, reasonCode int, reason string) error
+}
+
+func (m *mockRevokeAuthority) IsRevoked(serial string) (bool, error) {
+	if m.isRevoked != nil {
+		return m.isRevoked(serial)
+	}
+	return false, nil
+}
+
+func (m *mockRevokeAuthority) RevokeCertificate(crt *x509.Certificate, reasonCode int, reason string) error {
+	return m.revoke(crt, reasonCode, reason)
+}
+
+func TestAuthorityRevokeAccountCertificates(t *testing.T) {
+	expired, err := pemutil.ReadCertificate("../authority/testdata/certs/foo.crt")
+	assert.FatalError(t, err)
+	valid, err := pemutil.ReadCertificate("../authority/testdata/certs/intermediate_ca.crt")
+	assert.FatalError(t, err)
+	tables := map[string]map[string][]byte{
+		string(certTable):         {},
+		string(accountCertsTable): {},
+	}
+	newCertificate := func(accID string, leaf *x509.Certificate) string {
+		cert, err := newCert(newCleanupDB(tables), CertOptions{AccountID: accID, OrderID: "ordID", Leaf: leaf})
+		assert.FatalError(t, err)
+		return cert.ID
+	}
+	id1 := newCertificate("acc", valid)
+	newCertificate("acc", expired)
+	newCertificate("other", valid)
+	// A certificate that is being stored is skipped.
+	seq, err := certIndex.reserve(newCleanupDB(tables), "acc")
+	assert.FatalError(t, err)
+	tables[string(accountCertsTable)][string(orderIndexKey("acc", seq))] = []byte("pending")
+
+	type test struct {
+		signAuth SignAuthority
+		db       *db.MockNoSQLDB
+		revoked  []*x509.Certificate
+		err      *Error
+	}
+	tests := map[string]func(t *testing.T) *test{
+		"fail/not-supported": func(t *testing.T) *test {
+			return &test{
+				signAuth: &mockSignAuth{},
+				db:       newCleanupDB(tables),
+				err:      ServerInternalErr(errors.New("certificate authority does not support revocation")),
+			}
+		},
+		"fail/index-error": func(t *testing.T) *test {
+			return &test{
+				signAuth: &mockRevokeAuthority{},
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, bucket, accountCertsTable)
+						return nil, errors.New("force")
+					},
+				},
+				err: ServerInternalErr(errors.New("error loading certificate index for account acc: force")),
+			}
+		},
+		"fail/unmarshal-error": func(t *testing.T) *test {
+			return &test{
+				signAuth: &mockRevokeAuthority{},
+				db: newCleanupDB(map[string]map[string][]byte{
+					string(certTable): {"foo": []byte("{")},
+					string(accountCertsTable): {
+						"acc":                  orderIndexHead{next: 1}.bytes(),
+						"acc/0000000000000000": []byte("foo"),
+					},
+				}),
+				err: ServerInternalErr(errors.New("error unmarshaling certificate foo")),
+			}
+		},
+		"fail/revoke-error": func(t *testing.T) *test {
+			return &test{
+				signAuth: &mockRevokeAuthority{
+					revoke: func(crt *x509.Certificate, reasonCode int, reason string) error {
+						return errors.New("force")
+					},
+				},
+				db:  newCleanupDB(tables),
+				err: ServerInternalErr(errors.Errorf("error revoking certificate %s of account acc: force", id1)),
+			}
+		},
+		"ok": func(t *testing.T) *test {
+			tc := &test{
+				db: newCleanupDB(tables),
+			}
+			tc.signAuth = &mockRevokeAuthority{
+				revoke: func(crt *x509.Certificate, reasonCode int, reason string) error {
+					assert.Equals(t, 9, reasonCode)
+					assert.Equals(t, "ACME account deactivated", reason)
+					tc.revoked = append(tc.revoked, crt)
+					return nil
+				},
+			}
+			return tc
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			auth, err := NewAuthority(tc.db, "ca.smallstep.com", "acme", tc.signAuth)
+			assert.FatalError(t, err)
+			if err := auth.revokeAccountCertificates("acc"); err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else if assert.Nil(t, tc.err) {
+				// Expired certificates and the ones of other accounts are
+				// not revoked.
+				assert.Equals(t, []*x509.Certificate{valid}, tc.revoked)
+			}
+		})
+	}
+}
+
+func TestRevokeOnDeactivation(t *testing.T) {
+	prov := newProv()
+	assert.False(t, revokeOnDeactivation(prov))
+	prov.(*provisioner.ACME).RevokeOnDeactivation = true
+	assert.True(t, revokeOnDeactivation(prov))
+	assert.False(t, revokeOnDeactivation(&provisioner.JWK{}))
+}
+
+func TestAuthorityRevokeCertificate(t *testing.T) {
+	expired, err := pemutil.ReadCertificate("../authority/testdata/certs/foo.crt")
+	assert.FatalError(t, err)
+	valid, err := pemutil.ReadCertificate("../authority/testdata/certs/intermediate_ca.crt")
+	assert.FatalError(t, err)
+	newTables := func() map[string]map[string][]byte {
+		tables := map[string]map[string][]byte{
+			string(certTable):           {},
+			string(accountCertsTable):   {},
+			string(compromisedKeyTable): {},
+		}
+		for accID, leaf := range map[string]*x509.Certificate{"acc": valid, "other": expired} {
+			_, err := newCert(newCleanupDB(tables), CertOptions{AccountID: accID, OrderID: "ordID", Leaf: leaf})
+			assert.FatalError(t, err)
+		}
+		return tables
+	}
+
+	type test struct {
+		signAuth   SignAuthority
+		tables     map[string]map[string][]byte
+		crt        *x509.Certificate
+		reasonCode int
+		err        *Error
+	}
+	tests := map[string]func(t *testing.T) *test{
+		"fail/not-supported": func(t *testing.T) *test {
+			return &test{
+				signAuth: &mockSignAuth{},
+				tables:   newTables(),
+				crt:      valid,
+				err:      ServerInternalErr(errors.New("certificate authority does not support revocation")),
+			}
+		},
+		"fail/reason": func(t *testing.T) *test {
+			return &test{
+				signAuth:   &mockRevokeAuthority{},
+				tables:     newTables(),
+				crt:        valid,
+				reasonCode: 2,
+				err:        BadRevocationReasonErr(errors.New("reason code 2 is not allowed")),
+			}
+		},
+		"fail/not-owner": func(t *testing.T) *test {
+			return &test{
+				signAuth: &mockRevokeAuthority{},
+				tables:   newTables(),
+				crt:      expired,
+				err:      UnauthorizedErr(errors.New("account does not own certificate")),
+			}
+		},
+		"fail/is-revoked-error": func(t *testing.T) *test {
+			return &test{
+				signAuth: &mockRevokeAuthority{
+					isRevoked: func(serial string) (bool, error) {
+						return false, errors.New("force")
+					},
+				},
+				tables: newTables(),
+				crt:    valid,
+				err:    ServerInternalErr(errors.New("error checking revocation status: force")),
+			}
+		},
+		"fail/already-revoked": func(t *testing.T) *test {
+			return &test{
+				signAuth: &mockRevokeAuthority{
+					isRevoked: func(serial string) (bool, error) {
+						assert.Equals(t, valid.SerialNumber.String(), serial)
+						return true, nil
+					},
+				},
+				tables: newTables(),
+				crt:    valid,
+				err:    AlreadyRevokedErr(errors.Errorf("certificate %s has already been revoked", valid.SerialNumber)),
+			}
+		},
+		"fail/revoke-error": func(t *testing.T) *test {
+			return &test{
+				signAuth: &mockRevokeAuthority{
+					revoke: func(crt *x509.Certificate, reasonCode int, reason string) error {
+						return errors.New("force")
+					},
+				},
+				tables: newTables(),
+				crt:    valid,
+				err:    ServerInternalErr(errors.Errorf("error revoking certificate %s: force", valid.SerialNumber)),
+			}
+		},
+		"ok": func(t *testing.T) *test {
+			return &test{
+				signAuth: &mockRevokeAuthority{
+					revoke: func(crt *x509.Certificate, reasonCode int, reason string) error {
+						assert.Equals(t, valid, crt)
+						assert.Equals(t, 0, reasonCode)
+						assert.Equals(t, "ACME revokeCert request", reason)
+						return nil
+					},
+				},
+				tables: newTables(),
+				crt:    valid,
+			}
+		},
+		"ok/key-compromise": func(t *testing.T) *test {
+			return &test{
+				signAuth: &mockRevokeAuthority{
+					revoke: func(crt *x509.Certificate, reasonCode int, reason string) error {
+						assert.Equals(t, 1, reasonCode)
+						return nil
+					},
+				},
+				tables:     newTables(),
+				crt:        valid,
+				reasonCode: 1,
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			auth, err := NewAuthority(newCleanupDB(tc.tables), "ca.smallstep.com", "acme", tc.signAuth)
+			assert.FatalError(t, err)
+			if err := auth.RevokeCertificate(newProv(), "acc", tc.crt, tc.reasonCode); err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else if assert.Nil(t, tc.err) {
+				_, compromised := tc.tables[string(compromisedKeyTable)][string(keyFingerprint(tc.crt.RawSubjectPublicKeyInfo))]
+				assert.Equals(t, tc.reasonCode == keyCompromiseReasonCode, compromised)
+			}
+		})
+	}
+}
+
+func TestCheckCompromisedKey(t *testing.T) {
+	crt, err := pemutil.ReadCertificate("../authority/testdata/certs/intermediate_ca.crt")
+	assert.FatalError(t, err)
+	csr := &x509.CertificateRequest{RawSubjectPublicKeyInfo: crt.RawSubjectPublicKeyInfo}
+
+	tables := map[string]map[string][]byte{string(compromisedKeyTable): {}}
+	assert.Nil(t, checkCompromisedKey(newCleanupDB(tables), csr))
+
+	tables[string(compromisedKeyTable)][string(keyFingerprint(crt.RawSubjectPublicKeyInfo))] = []byte("1")
+	err = checkCompromisedKey(newCleanupDB(tables), csr)
+	if assert.NotNil(t, err) {
+		assert.Equals(t, BadPublicKeyErr(nil).Type, err.(*Error).Type)
+	}
+
+	err = checkCompromisedKey(&db.MockNoSQLDB{
+		MGet: func(bucket, key []byte) ([]byte, error) {
+			return nil, errors.New("force")
+		},
+	}, csr)
+	if assert.NotNil(t, err) {
+		assert.HasPrefix(t, err.Error(), "error checking compromised keys: force")
+	}
+}
@@ -0,0 +1,107 @@
+package acme
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	cadb "github.com/smallstep/certificates/db"
+	"github.com/smallstep/nosql"
+	"github.com/smallstep/nosql/database"
+)
+
+// SchemaComponent is the name used to store the schema version of the ACME
+// objects.
+const SchemaComponent = "acme"
+
+// SchemaMigrations returns the migrations of the ACME objects.
+func SchemaMigrations() []cadb.SchemaMigration {
+	return []cadb.SchemaMigration{
+		{Version: 1, Description: "move the orders of the accounts to the new order index", Migrate: migrateOrderIndex},
+		{Version: 2, Description: "index the certificates by account", Migrate: migrateCertIndex},
+	}
+}
+
+// migrateOrderIndex moves the order ids in the JSON arrays used by previous
+// versions of the orders-by-account index to the new index. Only the accounts
+// without a new index are migrated, so the ids keep their order; the others
+// are still read from both indexes.
+func migrateOrderIndex(db nosql.DB) error {
+	entries, err := db.List(ordersByAccountIDTable)
+	switch {
+	case nosql.IsErrNotFound(err):
+		return nil
+	case err != nil:
+		return errors.Wrap(err, "error listing orderIDs")
+	}
+	for _, e := range entries {
+		var oids []string
+		if err := json.Unmarshal(e.Value, &oids); err != nil {
+			return errors.Wrapf(err, "error unmarshaling orderIDs for account %s", e.Key)
+		}
+		if len(oids) > 0 {
+			// Reserve the sequences of the ids. While the entries are not
+			// written they are skipped, and the ids are read from the array.
+			_, swapped, err := db.CmpAndSwap(accountOrdersTable, e.Key, nil, orderIndexHead{next: uint64(len(oids))}.bytes())
+			if err != nil {
+				return errors.Wrapf(err, "error storing order index for account %s", e.Key)
+			}
+			if !swapped {
+				continue
+			}
+		}
+		tx := new(database.Tx)
+		for i, id := range oids {
+			tx.Set(accountOrdersTable, orderIndexKey(string(e.Key), uint64(i)), []byte(id))
+		}
+		tx.Del(ordersByAccountIDTable, e.Key)
+		if err := db.Update(tx); err != nil {
+			return errors.Wrapf(err, "error storing order index for account %s", e.Key)
+		}
+	}
+	return nil
+}
+
+// migrateCertIndex adds the certificates stored by previous versions to the
+// certificates-by-account index. The certificates of an account that are
+// already in its index are skipped, so the migration can run again, or
+// concurrently with the creation of new certificates.
+func migrateCertIndex(db nosql.DB) error {
+	entries, err := db.List(certTable)
+	switch {
+	case nosql.IsErrNotFound(err):
+		return nil
+	case err != nil:
+		return errors.Wrap(err, "error listing certificates")
+	}
+	indexed := make(map[string]map[string]bool)
+	for _, e := range entries {
+		var cert certificate
+		if err := json.Unmarshal(e.Value, &cert); err != nil {
+			return errors.Wrapf(err, "error unmarshaling certificate %s", e.Key)
+		}
+		ids, ok := indexed[cert.AccountID]
+		if !ok {
+			list, err := certIndex.listAll(db, cert.AccountID)
+			if err != nil {
+				return err
+			}
+			ids = make(map[string]bool, len(list))
+			for _, id := range list {
+				ids[id] = true
+			}
+			indexed[cert.AccountID] = ids
+		}
+		if ids[cert.ID] {
+			continue
+		}
+		seq, err := certIndex.reserve(db, cert.AccountID)
+		if err != nil {
+			return err
+		}
+		if err := db.Set(accountCertsTable, orderIndexKey(cert.AccountID, seq), []byte(cert.ID)); err != nil {
+			return errors.Wrapf(err, "error storing certificate index for account %s", cert.AccountID)
+		}
+		ids[cert.ID] = true
+	}
+	return nil
+}
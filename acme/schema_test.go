@@ -0,0 +1,82 @@
+package acme
+
+import (
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+func TestMigrateOrderIndex(t *testing.T) {
+	tables := map[string]map[string][]byte{
+		string(ordersByAccountIDTable): {
+			"acc1": []byte(`["o1","o2"]`),
+			"acc2": []byte(`["o3"]`),
+			"acc3": []byte(`[]`),
+		},
+		string(accountOrdersTable): {},
+	}
+	db := newCleanupDB(tables)
+
+	// acc2 has already used the new index.
+	seq, err := reserveOrderIndex(db, "acc2")
+	assert.FatalError(t, err)
+	tables[string(accountOrdersTable)][string(orderIndexKey("acc2", seq))] = []byte("o4")
+
+	assert.FatalError(t, migrateOrderIndex(db))
+	assert.Equals(t, map[string][]byte{
+		"acc2": []byte(`["o3"]`),
+	}, tables[string(ordersByAccountIDTable)])
+	assert.Equals(t, map[string][]byte{
+		"acc1":                  orderIndexHead{next: 2}.bytes(),
+		"acc1/0000000000000000": []byte("o1"),
+		"acc1/0000000000000001": []byte("o2"),
+		"acc2":                  orderIndexHead{next: 1}.bytes(),
+		"acc2/0000000000000000": []byte("o4"),
+	}, tables[string(accountOrdersTable)])
+
+	// The orders are read in the same order.
+	for acc, want := range map[string][]string{"acc1": {"o1", "o2"}, "acc2": {"o3", "o4"}, "acc3": {}} {
+		oids, err := getOrderIDsByAccount(db, acc)
+		assert.FatalError(t, err)
+		assert.Equals(t, want, oids)
+	}
+
+	// The migration can run again.
+	assert.FatalError(t, migrateOrderIndex(db))
+	assert.Equals(t, 1, len(tables[string(ordersByAccountIDTable)]))
+}
+
+func TestMigrateCertIndex(t *testing.T) {
+	tables := map[string]map[string][]byte{
+		string(certTable): {
+			"c1": []byte(`{"id":"c1","accountID":"acc1"}`),
+			"c2": []byte(`{"id":"c2","accountID":"acc2"}`),
+		},
+		string(accountCertsTable): {},
+	}
+	db := newCleanupDB(tables)
+
+	// acc2 has a new certificate in the index.
+	ops, err := defaultCertOps()
+	assert.FatalError(t, err)
+	ops.AccountID = "acc2"
+	cert, err := newCert(db, *ops)
+	assert.FatalError(t, err)
+
+	assert.FatalError(t, migrateCertIndex(db))
+	for acc, want := range map[string][]string{"acc1": {"c1"}, "acc2": {cert.ID, "c2"}, "acc3": {}} {
+		ids, err := certIndex.listAll(db, acc)
+		assert.FatalError(t, err)
+		assert.Equals(t, want, ids)
+	}
+
+	// The migration can run again.
+	assert.FatalError(t, migrateCertIndex(db))
+	assert.Equals(t, 5, len(tables[string(accountCertsTable)]))
+
+	tables[string(certTable)]["bad"] = []byte("{")
+	err = migrateCertIndex(db)
+	if assert.NotNil(t, err) {
+		assert.HasPrefix(t, err.Error(), "error unmarshaling certificate bad")
+	}
+}
@@ -0,0 +1,207 @@
+package acme
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/nosql"
+)
+
+// AutoRenewal contains the auto-renewal fields of a STAR order as defined in
+// RFC 8739. Lifetime and LifetimeAdjust are in seconds.
+type AutoRenewal struct {
+	StartDate           time.Time `json:"start-date"`
+	EndDate             time.Time `json:"end-date"`
+	Lifetime            int64     `json:"lifetime"`
+	LifetimeAdjust      int64     `json:"lifetime-adjust,omitempty"`
+	AllowCertificateGet bool      `json:"allow-certificate-get,omitempty"`
+}
+
+func (ar *AutoRenewal) lifetime() time.Duration {
+	return time.Duration(ar.Lifetime) * time.Second
+}
+
+func (ar *AutoRenewal) lifetimeAdjust() time.Duration {
+	return time.Duration(ar.LifetimeAdjust) * time.Second
+}
+
+// certOptions returns the validity of the certificate that starts at the
+// given slot. The certificates never expire after the end date of the order.
+func (ar *AutoRenewal) certOptions(slot time.Time) provisioner.Options {
+	notAfter := slot.Add(ar.lifetime())
+	if notAfter.After(ar.EndDate) {
+		notAfter = ar.EndDate
+	}
+	return provisioner.Options{
+		NotBefore: provisioner.NewTimeDuration(slot.Add(-ar.lifetimeAdjust())),
+		NotAfter:  provisioner.NewTimeDuration(notAfter),
+	}
+}
+
+// newMetaAutoRenewal returns the auto-renewal metadata of the directory for
+// the given provisioner, or nil if the provisioner does not support STAR
+// orders.
+func newMetaAutoRenewal(p provisioner.Interface) *MetaAutoRenewal {
+	acmeProv, ok := p.(*provisioner.ACME)
+	if !ok || acmeProv.AutoRenewal == nil {
+		return nil
+	}
+	return &MetaAutoRenewal{
+		MinLifetime:         int64(acmeProv.AutoRenewal.GetMinLifetime().Seconds()),
+		MaxDuration:         int64(acmeProv.AutoRenewal.GetMaxDuration().Seconds()),
+		AllowCertificateGet: acmeProv.AutoRenewal.AllowCertificateGet,
+	}
+}
+
+// validateAutoRenewal validates the auto-renewal fields of a new order against
+// the limits of the provisioner, and the validity of the certificates against
+// the claims of the provisioner and the given profile. It returns a copy of
+// the fields with the start date set.
+func validateAutoRenewal(p provisioner.Interface, profile string, ar *AutoRenewal) (*AutoRenewal, error) {
+	acmeProv, ok := p.(*provisioner.ACME)
+	if !ok || acmeProv.AutoRenewal == nil {
+		return nil, MalformedErr(errors.Errorf("provisioner %s does not support auto-renewal", p.GetName()))
+	}
+	limits := acmeProv.AutoRenewal
+
+	now := clock.Now()
+	v := *ar
+	if v.StartDate.IsZero() {
+		v.StartDate = now
+	}
+	// The end date cannot be further than the maximum duration from the
+	// earlier of the start date and the current time.
+	from := v.StartDate
+	if now.Before(from) {
+		from = now
+	}
+	switch {
+	case v.EndDate.IsZero():
+		return nil, MalformedErr(errors.New("auto-renewal end-date cannot be empty"))
+	case !v.EndDate.After(v.StartDate) || !v.EndDate.After(now):
+		return nil, MalformedErr(errors.New("auto-renewal end-date must be after start-date and the current time"))
+	case v.EndDate.Sub(from) > limits.GetMaxDuration():
+		return nil, MalformedErr(errors.Errorf("auto-renewal end-date cannot be more than %s after the start-date or the current time", limits.GetMaxDuration()))
+	case v.lifetime() < limits.GetMinLifetime():
+		return nil, MalformedErr(errors.Errorf("auto-renewal lifetime cannot be less than %s", limits.GetMinLifetime()))
+	case v.LifetimeAdjust < 0:
+		return nil, MalformedErr(errors.New("auto-renewal lifetime-adjust cannot be less than 0"))
+	case v.AllowCertificateGet && !limits.AllowCertificateGet:
+		return nil, MalformedErr(errors.New("auto-renewal allow-certificate-get is not supported"))
+	}
+	if err := acmeProv.AuthorizeLifetime(profile, v.lifetime()+v.lifetimeAdjust()); err != nil {
+		return nil, MalformedErr(errors.Wrap(err, "invalid auto-renewal lifetime"))
+	}
+	return &v, nil
+}
+
+// renew issues the certificate of a STAR order that starts at the given slot
+// and stores it as the latest certificate of the order.
+func (o *order) renew(db nosql.DB, csr *x509.CertificateRequest, auth SignAuthority, p provisioner.Interface, slot time.Time) (*order, error) {
+	cert, err := o.issue(context.Background(), db, csr, auth, p, o.AutoRenewal.certOptions(slot))
+	if err != nil {
+		return nil, err
+	}
+
+	_newOrder := *o
+	newOrder := &_newOrder
+	newOrder.Certificate = cert.ID
+	newOrder.Status = StatusValid
+	newOrder.CSR = csr.Raw
+	newOrder.RenewalSlot = slot
+	if err := newOrder.save(db, o); err != nil {
+		return nil, err
+	}
+	return newOrder, nil
+}
+
+// nextRenewal returns the slot of the next certificate of a STAR order and
+// the time the certificate must be issued. The next certificate is issued
+// halfway through the lifetime of the current one. It returns false if there
+// are no more certificates to issue.
+func (o *order) nextRenewal() (slot, renewAt time.Time, ok bool) {
+	if o.AutoRenewal == nil || o.Status != StatusValid || o.RenewalSlot.IsZero() {
+		return
+	}
+	lifetime := o.AutoRenewal.lifetime()
+	slot = o.RenewalSlot.Add(lifetime)
+	if !slot.Before(o.AutoRenewal.EndDate) {
+		return time.Time{}, time.Time{}, false
+	}
+	return slot, slot.Add(-lifetime / 2), true
+}
+
+// renewStarOrders issues the next certificate of the STAR orders that are due
+// for renewal. It returns the number of certificates issued, errors renewing
+// an order are logged so they do not prevent the renewal of the others.
+func renewStarOrders(db nosql.DB, auth SignAuthority) (int, error) {
+	entries, err := db.List(orderTable)
+	if err != nil {
+		return 0, ServerInternalErr(errors.Wrap(err, "error listing orders"))
+	}
+
+	var count int
+	now := clock.Now()
+	for _, e := range entries {
+		var o order
+		if err := json.Unmarshal(e.Value, &o); err != nil {
+			log.Printf("error unmarshaling acme order %s: %v", e.Key, err)
+			continue
+		}
+		slot, renewAt, ok := o.nextRenewal()
+		if !ok || now.Before(renewAt) {
+			continue
+		}
+		// Do not issue certificates in the past if the renewal was delayed.
+		if slot.Before(now) {
+			slot = now
+		}
+		if err := renewStarOrder(db, auth, &o, slot); err != nil {
+			log.Printf("error renewing acme order %s: %v", o.ID, err)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+// renewStarOrder issues the certificate of the STAR order for the given slot
+// using the CSR and provisioner of the order.
+func renewStarOrder(db nosql.DB, auth SignAuthority, o *order, slot time.Time) error {
+	csr, err := x509.ParseCertificateRequest(o.CSR)
+	if err != nil {
+		return ServerInternalErr(errors.Wrap(err, "error parsing csr"))
+	}
+	p, err := auth.LoadProvisionerByID(o.ProvisionerID)
+	if err != nil {
+		return ServerInternalErr(errors.Wrapf(err, "error loading provisioner %s", o.ProvisionerID))
+	}
+	_, err = o.renew(db, csr, auth, p, slot)
+	return err
+}
+
+// getStarCertificate returns the latest certificate of a STAR order. If accID
+// is empty the request is not authenticated, and the certificate is only
+// returned if the order allows unauthenticated GET requests.
+func getStarCertificate(db nosql.DB, accID, orderID string) (*certificate, error) {
+	o, err := getOrder(db, orderID)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case o.AutoRenewal == nil:
+		return nil, MalformedErr(errors.Errorf("order %s is not a STAR order", orderID))
+	case accID == "" && !o.AutoRenewal.AllowCertificateGet:
+		return nil, UnauthorizedErr(errors.Errorf("order %s does not allow certificate get", orderID))
+	case accID != "" && accID != o.AccountID:
+		return nil, UnauthorizedErr(errors.New("account does not own order"))
+	case o.Certificate == "":
+		return nil, OrderNotReadyErr(errors.Errorf("order %s does not have a certificate", orderID))
+	}
+	return getCert(db, o.Certificate)
+}
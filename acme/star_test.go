@@ -0,0 +1,298 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/nosql"
+	"github.com/smallstep/nosql/database"
+)
+
+func newStarProv(ar *provisioner.ACMEAutoRenewal) provisioner.Interface {
+	p := &provisioner.ACME{
+		Type:        "ACME",
+		Name:        "star@acme-provisioner.com",
+		AutoRenewal: ar,
+	}
+	if err := p.Init(provisioner.Config{Claims: globalProvisionerClaims}); err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func mustCSR(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		DNSNames: []string{"acme.example.com"},
+	}, key)
+	assert.FatalError(t, err)
+	return der
+}
+
+func TestValidateAutoRenewal(t *testing.T) {
+	now := clock.Now()
+	prov := newStarProv(&provisioner.ACMEAutoRenewal{
+		MinLifetime: &provisioner.Duration{Duration: time.Hour},
+		MaxDuration: &provisioner.Duration{Duration: 30 * 24 * time.Hour},
+	})
+	type test struct {
+		prov provisioner.Interface
+		ar   *AutoRenewal
+		err  *Error
+	}
+	tests := map[string]test{
+		"fail/not-supported": {
+			prov: newProv(),
+			ar:   &AutoRenewal{EndDate: now.Add(24 * time.Hour), Lifetime: 3600},
+			err:  MalformedErr(errors.New("provisioner test@acme-provisioner.com does not support auto-renewal")),
+		},
+		"fail/no-end-date": {
+			prov: prov,
+			ar:   &AutoRenewal{Lifetime: 3600},
+			err:  MalformedErr(errors.New("auto-renewal end-date cannot be empty")),
+		},
+		"fail/end-before-start": {
+			prov: prov,
+			ar:   &AutoRenewal{StartDate: now.Add(48 * time.Hour), EndDate: now.Add(24 * time.Hour), Lifetime: 3600},
+			err:  MalformedErr(errors.New("auto-renewal end-date must be after start-date and the current time")),
+		},
+		"fail/max-duration": {
+			prov: prov,
+			ar:   &AutoRenewal{EndDate: now.Add(31 * 24 * time.Hour), Lifetime: 3600},
+			err:  MalformedErr(errors.New("auto-renewal end-date cannot be more than 720h0m0s after the start-date or the current time")),
+		},
+		"fail/max-duration-from-now": {
+			prov: prov,
+			ar:   &AutoRenewal{StartDate: now.Add(10 * 24 * time.Hour), EndDate: now.Add(35 * 24 * time.Hour), Lifetime: 3600},
+			err:  MalformedErr(errors.New("auto-renewal end-date cannot be more than 720h0m0s after the start-date or the current time")),
+		},
+		"fail/max-tls-duration": {
+			prov: prov,
+			ar:   &AutoRenewal{EndDate: now.Add(72 * time.Hour), Lifetime: 25 * 3600},
+			err:  MalformedErr(errors.New("invalid auto-renewal lifetime: requested duration of 25h0m0s is more than the authorized maximum certificate duration of 24h0m0s")),
+		},
+		"fail/max-tls-duration-adjust": {
+			prov: prov,
+			ar:   &AutoRenewal{EndDate: now.Add(72 * time.Hour), Lifetime: 24 * 3600, LifetimeAdjust: 60},
+			err:  MalformedErr(errors.New("invalid auto-renewal lifetime: requested duration of 24h1m0s is more than the authorized maximum certificate duration of 24h0m0s")),
+		},
+		"fail/min-lifetime": {
+			prov: prov,
+			ar:   &AutoRenewal{EndDate: now.Add(24 * time.Hour), Lifetime: 60},
+			err:  MalformedErr(errors.New("auto-renewal lifetime cannot be less than 1h0m0s")),
+		},
+		"fail/lifetime-adjust": {
+			prov: prov,
+			ar:   &AutoRenewal{EndDate: now.Add(24 * time.Hour), Lifetime: 3600, LifetimeAdjust: -1},
+			err:  MalformedErr(errors.New("auto-renewal lifetime-adjust cannot be less than 0")),
+		},
+		"fail/allow-certificate-get": {
+			prov: prov,
+			ar:   &AutoRenewal{EndDate: now.Add(24 * time.Hour), Lifetime: 3600, AllowCertificateGet: true},
+			err:  MalformedErr(errors.New("auto-renewal allow-certificate-get is not supported")),
+		},
+		"ok": {
+			prov: newStarProv(&provisioner.ACMEAutoRenewal{AllowCertificateGet: true}),
+			ar:   &AutoRenewal{EndDate: now.Add(24 * time.Hour), Lifetime: 3600, AllowCertificateGet: true},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ar, err := validateAutoRenewal(tc.prov, "", tc.ar)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.False(t, ar.StartDate.IsZero())
+				assert.True(t, tc.ar.StartDate.IsZero())
+				assert.Equals(t, tc.ar.EndDate, ar.EndDate)
+			}
+		})
+	}
+}
+
+func TestOrderNextRenewal(t *testing.T) {
+	slot := clock.Now()
+	ar := &AutoRenewal{StartDate: slot, EndDate: slot.Add(72 * time.Hour), Lifetime: 86400}
+
+	o := &order{Status: StatusValid, AutoRenewal: ar, RenewalSlot: slot}
+	next, renewAt, ok := o.nextRenewal()
+	assert.True(t, ok)
+	assert.Equals(t, slot.Add(24*time.Hour), next)
+	assert.Equals(t, slot.Add(12*time.Hour), renewAt)
+
+	// The last certificate covers the end of the order.
+	o.RenewalSlot = slot.Add(48 * time.Hour)
+	_, _, ok = o.nextRenewal()
+	assert.False(t, ok)
+
+	// Orders that are not finalized are not renewed.
+	o = &order{Status: StatusReady, AutoRenewal: ar}
+	_, _, ok = o.nextRenewal()
+	assert.False(t, ok)
+
+	// The validity of the certificates is limited by the end date.
+	opts := ar.certOptions(slot.Add(60 * time.Hour))
+	assert.Equals(t, ar.EndDate, opts.NotAfter.Time())
+}
+
+func TestRenewStarOrders(t *testing.T) {
+	now := clock.Now()
+	prov := newStarProv(&provisioner.ACMEAutoRenewal{})
+	newEntry := func(o *order) *database.Entry {
+		b, err := json.Marshal(o)
+		assert.FatalError(t, err)
+		return &database.Entry{Bucket: orderTable, Key: []byte(o.ID), Value: b}
+	}
+	ar := &AutoRenewal{StartDate: now.Add(-20 * time.Hour), EndDate: now.Add(72 * time.Hour), Lifetime: 86400}
+	due := &order{ID: "due", Status: StatusValid, AutoRenewal: ar, ProvisionerID: prov.GetID(),
+		RenewalSlot: now.Add(-20 * time.Hour), CSR: []byte("bad")}
+	notDue := &order{ID: "not-due", Status: StatusValid, AutoRenewal: ar, ProvisionerID: prov.GetID(),
+		RenewalSlot: now.Add(-time.Hour)}
+	regular := &order{ID: "regular", Status: StatusValid}
+
+	t.Run("fail/list-error", func(t *testing.T) {
+		_, err := renewStarOrders(&db.MockNoSQLDB{
+			MList: func(bucket []byte) ([]*database.Entry, error) {
+				return nil, errors.New("force")
+			},
+		}, &mockSignAuth{})
+		if assert.NotNil(t, err) {
+			assert.HasPrefix(t, err.Error(), "error listing orders: force")
+		}
+	})
+
+	t.Run("ok/bad-csr", func(t *testing.T) {
+		count, err := renewStarOrders(&db.MockNoSQLDB{
+			MList: func(bucket []byte) ([]*database.Entry, error) {
+				return []*database.Entry{newEntry(due), newEntry(notDue), newEntry(regular)}, nil
+			},
+		}, &mockSignAuth{})
+		assert.FatalError(t, err)
+		assert.Equals(t, 0, count)
+	})
+
+	t.Run("ok", func(t *testing.T) {
+		crt := &x509.Certificate{Subject: pkix.Name{CommonName: "acme.example.com"}}
+		inter := &x509.Certificate{Subject: pkix.Name{CommonName: "intermediate"}}
+
+		o := *due
+		o.CSR = mustCSR(t)
+		var saved *order
+		count, err := renewStarOrders(&db.MockNoSQLDB{
+			MList: func(bucket []byte) ([]*database.Entry, error) {
+				return []*database.Entry{newEntry(&o), newEntry(notDue), newEntry(regular)}, nil
+			},
+			MGet: func(bucket, key []byte) ([]byte, error) {
+				assert.Equals(t, bucket, accountCertsTable)
+				return nil, database.ErrNotFound
+			},
+			MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				if string(bucket) == string(orderTable) {
+					saved = new(order)
+					assert.FatalError(t, json.Unmarshal(newval, saved))
+				}
+				return nil, true, nil
+			},
+		}, &mockSignAuth{
+			loadProvisionerByID: func(id string) (provisioner.Interface, error) {
+				assert.Equals(t, prov.GetID(), id)
+				return prov, nil
+			},
+			sign: func(cr *x509.CertificateRequest, pops provisioner.Options, signOps ...provisioner.SignOption) ([]*x509.Certificate, error) {
+				assert.Equals(t, o.RenewalSlot.Add(24*time.Hour), pops.NotBefore.Time())
+				return []*x509.Certificate{crt, inter}, nil
+			},
+		})
+		assert.FatalError(t, err)
+		assert.Equals(t, 1, count)
+		if assert.NotNil(t, saved) {
+			assert.Equals(t, "due", saved.ID)
+			assert.NotEquals(t, "", saved.Certificate)
+			assert.True(t, saved.RenewalSlot.Equal(o.RenewalSlot.Add(24*time.Hour)))
+		}
+	})
+}
+
+func TestGetStarCertificate(t *testing.T) {
+	starOrder := &order{ID: "star", AccountID: "accID", Certificate: "certID",
+		AutoRenewal: &AutoRenewal{AllowCertificateGet: false}}
+	type test struct {
+		o     *order
+		accID string
+		err   *Error
+	}
+	tests := map[string]test{
+		"fail/not-star": {
+			o:     &order{ID: "regular", AccountID: "accID", Certificate: "certID"},
+			accID: "accID",
+			err:   MalformedErr(errors.New("order regular is not a STAR order")),
+		},
+		"fail/get-not-allowed": {
+			o:   starOrder,
+			err: UnauthorizedErr(errors.New("order star does not allow certificate get")),
+		},
+		"fail/unauthorized": {
+			o:     starOrder,
+			accID: "otherID",
+			err:   UnauthorizedErr(errors.New("account does not own order")),
+		},
+		"fail/not-ready": {
+			o:     &order{ID: "star", AccountID: "accID", AutoRenewal: &AutoRenewal{}},
+			accID: "accID",
+			err:   OrderNotReadyErr(errors.New("order star does not have a certificate")),
+		},
+		"ok/post-as-get": {
+			o:     starOrder,
+			accID: "accID",
+		},
+		"ok/get": {
+			o: &order{ID: "star", AccountID: "accID", Certificate: "certID",
+				AutoRenewal: &AutoRenewal{AllowCertificateGet: true}},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var mockdb nosql.DB = &db.MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					switch string(bucket) {
+					case string(orderTable):
+						return json.Marshal(tc.o)
+					case string(certTable):
+						return json.Marshal(&certificate{ID: string(key), Leaf: []byte("leaf")})
+					default:
+						return nil, errors.New("unexpected bucket")
+					}
+				},
+			}
+			cert, err := getStarCertificate(mockdb, tc.accID, tc.o.ID)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, "certID", cert.ID)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/cli/crypto/x509util"
+	"github.com/smallstep/cli/jose"
+	"github.com/smallstep/nosql"
+)
+
+// oidTNAuthList is the OID of the TNAuthList certificate extension defined in
+// RFC 8226.
+var oidTNAuthList = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 26}
+
+// decodeTNAuthList decodes the value of a TNAuthList identifier, the base64
+// encoded DER of a TNAuthorizationList (RFC 9448).
+func decodeTNAuthList(value string) ([]byte, error) {
+	der, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "error base64 decoding TNAuthList")
+	}
+	var seq asn1.RawValue
+	rest, err := asn1.Unmarshal(der, &seq)
+	switch {
+	case err != nil:
+		return nil, errors.Wrap(err, "error parsing TNAuthList")
+	case len(rest) > 0:
+		return nil, errors.New("error parsing TNAuthList: trailing data")
+	case seq.Class != asn1.ClassUniversal || seq.Tag != asn1.TagSequence || len(seq.Bytes) == 0:
+		return nil, errors.New("error parsing TNAuthList: value is not a non-empty sequence")
+	}
+	return der, nil
+}
+
+// tnAuthListExtension is the sign option that adds the TNAuthList extension
+// of a TNAuthList order to the certificate.
+type tnAuthListExtension []byte
+
+func (e tnAuthListExtension) Option(provisioner.Options) x509util.WithOption {
+	return func(p x509util.Profile) error {
+		crt := p.Subject()
+		crt.ExtraExtensions = append(crt.ExtraExtensions, pkix.Extension{
+			Id:    oidTNAuthList,
+			Value: []byte(e),
+		})
+		return nil
+	}
+}
+
+// tkauthOptions contains the data used to validate a tkauth-01 challenge:
+// the payload of the client request and the roots used to verify the
+// authority tokens.
+type tkauthOptions struct {
+	payload []byte
+	roots   *x509.CertPool
+}
+
+// atcClaims are the claims of a TNAuthList authority token (RFC 9448).
+type atcClaims struct {
+	jose.Claims
+	ATC *struct {
+		TKType      string `json:"tktype"`
+		TKValue     string `json:"tkvalue"`
+		CA          bool   `json:"ca"`
+		Fingerprint string `json:"fingerprint"`
+	} `json:"atc"`
+}
+
+// tkAuth01Challenge represents a tkauth-01 acme challenge (RFC 9447) using
+// TNAuthList authority tokens.
+type tkAuth01Challenge struct {
+	*baseChallenge
+}
+
+// newTKAuth01Challenge returns a new acme tkauth-01 challenge.
+func newTKAuth01Challenge(db nosql.DB, ops ChallengeOptions) (challenge, error) {
+	bc, err := newBaseChallenge(ops.AccountID, ops.AuthzID)
+	if err != nil {
+		return nil, err
+	}
+	bc.Type = "tkauth-01"
+	bc.Value = ops.Identifier.Value
+
+	tc := &tkAuth01Challenge{bc}
+	if err := tc.save(db, nil); err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
+
+// toACME adds the token type and the token authority of the provisioner to
+// the challenge.
+func (tc *tkAuth01Challenge) toACME(ctx context.Context, db nosql.DB, dir *directory, p provisioner.Interface) (*Challenge, error) {
+	ac, err := tc.baseChallenge.toACME(ctx, db, dir, p)
+	if err != nil {
+		return nil, err
+	}
+	ac.TKAuthType = "atc"
+	if acmeProv, ok := p.(*provisioner.ACME); ok {
+		ac.TokenAuthority = acmeProv.TokenAuthorityURL
+	}
+	return ac, nil
+}
+
+// validate verifies the authority token sent by the client. Like
+// device-attest-01 challenges, the validation is not retried, a token that
+// cannot be verified makes the challenge invalid.
+func (tc *tkAuth01Challenge) validate(db nosql.DB, jwk *jose.JSONWebKey, vo validateOptions) (challenge, error) {
+	// If already valid or invalid then return without performing validation.
+	if tc.getStatus() == StatusValid || tc.getStatus() == StatusInvalid {
+		return tc, nil
+	}
+	if vo.tkauth == nil {
+		return nil, ServerInternalErr(errors.New("token authority options are required to validate tkauth-01 challenges"))
+	}
+
+	var payload struct {
+		TKAuth string `json:"tkauth"`
+	}
+	if err := json.Unmarshal(vo.tkauth.payload, &payload); err != nil || payload.TKAuth == "" {
+		return tc.fail(db, MalformedErr(errors.New("payload must contain a tkauth")))
+	}
+	expected, err := decodeTNAuthList(tc.Value)
+	if err != nil {
+		return nil, ServerInternalErr(err)
+	}
+	fingerprint, err := atcFingerprint(jwk)
+	if err != nil {
+		return nil, err
+	}
+	tkvalue, err := verifyAuthorityToken(payload.TKAuth, fingerprint, vo.tkauth.roots)
+	if err != nil {
+		return tc.fail(db, UnauthorizedErr(err))
+	}
+	if !bytes.Equal(tkvalue, expected) {
+		return tc.fail(db, RejectedIdentifierErr(errors.New("authority token tkvalue does not match the TNAuthList identifier")))
+	}
+
+	// Update and store the challenge.
+	upd := &tkAuth01Challenge{tc.baseChallenge.clone()}
+	upd.Status = StatusValid
+	upd.Error = nil
+	upd.Validated = clock.Now()
+
+	if err := upd.save(db, tc); err != nil {
+		return nil, err
+	}
+	return upd, nil
+}
+
+// fail marks the challenge as invalid with the given error.
+func (tc *tkAuth01Challenge) fail(db nosql.DB, err *Error) (challenge, error) {
+	upd := &tkAuth01Challenge{tc.baseChallenge.clone()}
+	upd.Status = StatusInvalid
+	upd.Error = err.ToACME()
+	if err := upd.save(db, tc); err != nil {
+		return nil, err
+	}
+	return upd, nil
+}
+
+// verifyAuthorityToken verifies a TNAuthList authority token and returns the
+// DER of its TNAuthList. The token must be signed by a certificate in its x5c
+// header chaining to the given roots, and it must contain the fingerprint of
+// the account key.
+func verifyAuthorityToken(token, fingerprint string, roots *x509.CertPool) ([]byte, error) {
+	jwt, err := jose.ParseSigned(token)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing authority token")
+	}
+	chains, err := jwt.Headers[0].Certificates(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error verifying authority token certificate chain")
+	}
+	var claims atcClaims
+	if err := jwt.Claims(chains[0][0].PublicKey, &claims); err != nil {
+		return nil, errors.Wrap(err, "error verifying authority token signature")
+	}
+	if claims.Expiry == nil {
+		return nil, errors.New("authority token must contain an exp claim")
+	}
+	if err := claims.ValidateWithLeeway(jose.Expected{Time: clock.Now()}, time.Minute); err != nil {
+		return nil, errors.Wrap(err, "error validating authority token claims")
+	}
+
+	atc := claims.ATC
+	switch {
+	case atc == nil:
+		return nil, errors.New("authority token must contain an atc claim")
+	case atc.TKType != "TNAuthList":
+		return nil, errors.Errorf("authority token tktype %s is not supported", atc.TKType)
+	case atc.CA:
+		return nil, errors.New("authority token cannot authorize a CA certificate")
+	}
+	if atc.Fingerprint != fingerprint {
+		return nil, errors.Errorf("authority token fingerprint does not match the account key; "+
+			"expected %s, but got %s", fingerprint, atc.Fingerprint)
+	}
+	tkvalue, err := decodeTNAuthList(atc.TKValue)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding authority token tkvalue")
+	}
+	return tkvalue, nil
+}
+
+// atcFingerprint returns the fingerprint of the account key in the format
+// used by authority tokens, the SHA-256 JWK thumbprint of the key as colon
+// separated hex, e.g. "SHA256 56:3E:5F:...".
+func atcFingerprint(jwk *jose.JSONWebKey) (string, error) {
+	thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", ServerInternalErr(errors.Wrap(err, "error generating JWK thumbprint"))
+	}
+	parts := make([]string, len(thumbprint))
+	for i, b := range thumbprint {
+		parts[i] = strings.ToUpper(hex.EncodeToString([]byte{b}))
+	}
+	return "SHA256 " + strings.Join(parts, ":"), nil
+}
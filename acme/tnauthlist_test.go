@@ -0,0 +1,304 @@
+package acme
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/cli/crypto/x509util"
+	"github.com/smallstep/cli/jose"
+)
+
+// testTNAuthList is a TNAuthList with the service provider code 1234.
+const testTNAuthList = "MAigBhYEMTIzNA=="
+
+type testATC struct {
+	TKType      string `json:"tktype"`
+	TKValue     string `json:"tkvalue"`
+	CA          bool   `json:"ca"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// newAuthorityToken returns an authority token signed by a leaf of the given
+// CA. A nil atc or a zero exp create a token without the atc or exp claims.
+func newAuthorityToken(t *testing.T, ca *attestationCA, atc *testATC, exp time.Time) string {
+	der, key := ca.leaf(t)
+	so := new(jose.SignerOptions)
+	so.WithType("JWT")
+	so.WithHeader("x5c", []string{base64.StdEncoding.EncodeToString(der)})
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, so)
+	assert.FatalError(t, err)
+	claims := map[string]interface{}{
+		"jti": "foo",
+	}
+	if !exp.IsZero() {
+		claims["exp"] = exp.Unix()
+	}
+	if atc != nil {
+		claims["atc"] = atc
+	}
+	tok, err := jose.Signed(signer).Claims(claims).CompactSerialize()
+	assert.FatalError(t, err)
+	return tok
+}
+
+func TestDecodeTNAuthList(t *testing.T) {
+	tests := map[string]struct {
+		value string
+		err   error
+	}{
+		"ok":             {value: testTNAuthList},
+		"fail/base64":    {value: "MAigBhYEMTIzNA", err: errors.New("error base64 decoding TNAuthList")},
+		"fail/asn1":      {value: "MAig", err: errors.New("error parsing TNAuthList")},
+		"fail/trailing":  {value: "MAMWAUEA", err: errors.New("error parsing TNAuthList: trailing data")},
+		"fail/not-seq":   {value: "FgQxMjM0", err: errors.New("error parsing TNAuthList: value is not a non-empty sequence")},
+		"fail/empty-seq": {value: "MAA=", err: errors.New("error parsing TNAuthList: value is not a non-empty sequence")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			der, err := decodeTNAuthList(tc.value)
+			if tc.err != nil {
+				if assert.NotNil(t, err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.FatalError(t, err)
+				assert.Equals(t, base64.StdEncoding.EncodeToString(der), tc.value)
+			}
+		})
+	}
+}
+
+func TestTNAuthListExtension(t *testing.T) {
+	der, err := decodeTNAuthList(testTNAuthList)
+	assert.FatalError(t, err)
+	crt := &x509.Certificate{}
+	prof := &x509util.Leaf{}
+	prof.SetSubject(crt)
+	assert.FatalError(t, tnAuthListExtension(der).Option(provisioner.Options{})(prof))
+	assert.Equals(t, []pkix.Extension{{Id: oidTNAuthList, Value: der}}, crt.ExtraExtensions)
+}
+
+func TestTKAuth01ToACME(t *testing.T) {
+	ops := testOps
+	ops.Identifier = Identifier{Type: "TNAuthList", Value: testTNAuthList}
+	ch, err := newTKAuth01Challenge(&db.MockNoSQLDB{
+		MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+			return nil, true, nil
+		},
+	}, ops)
+	assert.FatalError(t, err)
+
+	prov := newProv()
+	prov.(*provisioner.ACME).TokenAuthorityURL = "https://authority.example.org"
+	ach, err := ch.toACME(context.Background(), nil, newDirectory("ca.smallstep.com", "acme"), prov)
+	assert.FatalError(t, err)
+	assert.Equals(t, ach.Type, "tkauth-01")
+	assert.Equals(t, ach.TKAuthType, "atc")
+	assert.Equals(t, ach.TokenAuthority, "https://authority.example.org")
+	assert.Equals(t, ach.Token, ch.getToken())
+}
+
+func TestTKAuth01Validate(t *testing.T) {
+	ca := newAttestationCA(t)
+	other := newAttestationCA(t)
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	fingerprint, err := atcFingerprint(jwk)
+	assert.FatalError(t, err)
+	ops := testOps
+	ops.Identifier = Identifier{Type: "TNAuthList", Value: testTNAuthList}
+	newCh := func() challenge {
+		ch, err := newTKAuth01Challenge(&db.MockNoSQLDB{
+			MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				return []byte("foo"), true, nil
+			},
+		}, ops)
+		assert.FatalError(t, err)
+		return ch
+	}
+	newPayload := func(tok string) []byte {
+		b, err := json.Marshal(map[string]string{"tkauth": tok})
+		assert.FatalError(t, err)
+		return b
+	}
+	exp := time.Now().Add(time.Hour)
+	type test struct {
+		ch      challenge
+		vo      validateOptions
+		status  string
+		errType string
+		err     *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"ok/status-already-valid": func(t *testing.T) test {
+			ch := newCh()
+			ch.(*tkAuth01Challenge).Status = StatusValid
+			return test{ch: ch, status: StatusValid}
+		},
+		"fail/no-tkauth-options": func(t *testing.T) test {
+			return test{
+				ch:  newCh(),
+				err: ServerInternalErr(errors.New("token authority options are required to validate tkauth-01 challenges")),
+			}
+		},
+		"ok/missing-tkauth": func(t *testing.T) test {
+			return test{
+				ch:      newCh(),
+				vo:      validateOptions{tkauth: &tkauthOptions{payload: []byte("{}")}},
+				status:  StatusInvalid,
+				errType: "urn:ietf:params:acme:error:" + malformedErr.String(),
+			}
+		},
+		"ok/bad-token": func(t *testing.T) test {
+			return test{
+				ch:      newCh(),
+				vo:      validateOptions{tkauth: &tkauthOptions{payload: newPayload("foo"), roots: ca.pool}},
+				status:  StatusInvalid,
+				errType: "urn:ietf:params:acme:error:" + unauthorizedErr.String(),
+			}
+		},
+		"ok/untrusted-token": func(t *testing.T) test {
+			tok := newAuthorityToken(t, other, &testATC{TKType: "TNAuthList", TKValue: testTNAuthList, Fingerprint: fingerprint}, exp)
+			return test{
+				ch:      newCh(),
+				vo:      validateOptions{tkauth: &tkauthOptions{payload: newPayload(tok), roots: ca.pool}},
+				status:  StatusInvalid,
+				errType: "urn:ietf:params:acme:error:" + unauthorizedErr.String(),
+			}
+		},
+		"ok/fingerprint-mismatch": func(t *testing.T) test {
+			tok := newAuthorityToken(t, ca, &testATC{TKType: "TNAuthList", TKValue: testTNAuthList, Fingerprint: "SHA256 00"}, exp)
+			return test{
+				ch:      newCh(),
+				vo:      validateOptions{tkauth: &tkauthOptions{payload: newPayload(tok), roots: ca.pool}},
+				status:  StatusInvalid,
+				errType: "urn:ietf:params:acme:error:" + unauthorizedErr.String(),
+			}
+		},
+		"ok/tkvalue-mismatch": func(t *testing.T) test {
+			tok := newAuthorityToken(t, ca, &testATC{TKType: "TNAuthList", TKValue: "MAigBhYENTY3OA==", Fingerprint: fingerprint}, exp)
+			return test{
+				ch:      newCh(),
+				vo:      validateOptions{tkauth: &tkauthOptions{payload: newPayload(tok), roots: ca.pool}},
+				status:  StatusInvalid,
+				errType: "urn:ietf:params:acme:error:" + rejectedIdentifierErr.String(),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			tok := newAuthorityToken(t, ca, &testATC{TKType: "TNAuthList", TKValue: testTNAuthList, Fingerprint: fingerprint}, exp)
+			return test{
+				ch:     newCh(),
+				vo:     validateOptions{tkauth: &tkauthOptions{payload: newPayload(tok), roots: ca.pool}},
+				status: StatusValid,
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			mockdb := &db.MockNoSQLDB{
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					assert.Equals(t, challengeTable, bucket)
+					return nil, true, nil
+				},
+			}
+			ch, err := tc.ch.validate(mockdb, jwk, tc.vo)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assertAcmeError(t, tc.err, err)
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, tc.status, ch.getStatus())
+				if tc.errType != "" {
+					if assert.NotNil(t, ch.getError()) {
+						assert.Equals(t, tc.errType, ch.getError().Type)
+					}
+				} else {
+					assert.Nil(t, ch.getError())
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyAuthorityToken(t *testing.T) {
+	ca := newAttestationCA(t)
+	fingerprint := "SHA256 AA:BB"
+	exp := time.Now().Add(time.Hour)
+	tests := map[string]struct {
+		token string
+		err   error
+	}{
+		"fail/no-exp": {
+			token: newAuthorityToken(t, ca, &testATC{TKType: "TNAuthList", TKValue: testTNAuthList, Fingerprint: fingerprint}, time.Time{}),
+			err:   errors.New("authority token must contain an exp claim"),
+		},
+		"fail/expired": {
+			token: newAuthorityToken(t, ca, &testATC{TKType: "TNAuthList", TKValue: testTNAuthList, Fingerprint: fingerprint}, time.Now().Add(-time.Hour)),
+			err:   errors.New("error validating authority token claims"),
+		},
+		"fail/no-atc": {
+			token: newAuthorityToken(t, ca, nil, exp),
+			err:   errors.New("authority token must contain an atc claim"),
+		},
+		"fail/tktype": {
+			token: newAuthorityToken(t, ca, &testATC{TKType: "JWTClaimConstraints", TKValue: testTNAuthList, Fingerprint: fingerprint}, exp),
+			err:   errors.New("authority token tktype JWTClaimConstraints is not supported"),
+		},
+		"fail/ca": {
+			token: newAuthorityToken(t, ca, &testATC{TKType: "TNAuthList", TKValue: testTNAuthList, CA: true, Fingerprint: fingerprint}, exp),
+			err:   errors.New("authority token cannot authorize a CA certificate"),
+		},
+		"fail/fingerprint": {
+			token: newAuthorityToken(t, ca, &testATC{TKType: "TNAuthList", TKValue: testTNAuthList, Fingerprint: "SHA256 CC:DD"}, exp),
+			err:   errors.New("authority token fingerprint does not match the account key; expected SHA256 AA:BB, but got SHA256 CC:DD"),
+		},
+		"fail/tkvalue": {
+			token: newAuthorityToken(t, ca, &testATC{TKType: "TNAuthList", TKValue: "foo", Fingerprint: fingerprint}, exp),
+			err:   errors.New("error decoding authority token tkvalue"),
+		},
+		"ok": {
+			token: newAuthorityToken(t, ca, &testATC{TKType: "TNAuthList", TKValue: testTNAuthList, Fingerprint: fingerprint}, exp),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			tkvalue, err := verifyAuthorityToken(tc.token, fingerprint, ca.pool)
+			if tc.err != nil {
+				if assert.NotNil(t, err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.FatalError(t, err)
+				assert.Equals(t, base64.StdEncoding.EncodeToString(tkvalue), testTNAuthList)
+			}
+		})
+	}
+}
+
+func TestATCFingerprint(t *testing.T) {
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+	assert.FatalError(t, err)
+	fp, err := atcFingerprint(jwk)
+	assert.FatalError(t, err)
+	parts := make([]string, len(thumbprint))
+	for i, b := range thumbprint {
+		parts[i] = strings.ToUpper(hex.EncodeToString([]byte{b}))
+	}
+	assert.Equals(t, "SHA256 "+strings.Join(parts, ":"), fp)
+	assert.Equals(t, len("SHA256 ")+32*3-1, len(fp))
+}
@@ -0,0 +1,86 @@
+package acme
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	cadb "github.com/smallstep/certificates/db"
+	"github.com/smallstep/nosql"
+	"github.com/smallstep/nosql/database"
+)
+
+// txDB is a nosql.DB that records the writes of new objects in a transaction
+// instead of sending them to the database, so an object graph, like an order
+// with its authorizations and challenges, can be stored atomically with a
+// single call to commit. Reads are sent to the underlying database. If ttl is
+// greater than 0, the values written expire after it, if the database supports
+// it.
+//
+// Only the creation of new keys is supported, any other compare-and-swap
+// fails because its result would not be known until the commit.
+type txDB struct {
+	nosql.DB
+	tx  *database.Tx
+	ttl time.Duration
+}
+
+// newTxDB returns a txDB that writes to the given database on commit.
+func newTxDB(db nosql.DB) *txDB {
+	return &txDB{DB: db, tx: new(database.Tx)}
+}
+
+// CmpAndSwap records the creation of the key if old is nil.
+func (db *txDB) CmpAndSwap(bucket, key, old, newval []byte) ([]byte, bool, error) {
+	if old != nil {
+		return nil, false, errors.Errorf("cannot update %s/%s in a transaction", bucket, key)
+	}
+	db.tx.Operations = append(db.tx.Operations, &database.TxEntry{
+		Bucket: bucket,
+		Key:    key,
+		Value:  newval,
+		Cmd:    database.CmpAndSwap,
+	})
+	return newval, true, nil
+}
+
+// Set records the write of the key.
+func (db *txDB) Set(bucket, key, value []byte) error {
+	db.tx.Set(bucket, key, value)
+	return nil
+}
+
+// Del records the deletion of the key.
+func (db *txDB) Del(bucket, key []byte) error {
+	db.tx.Del(bucket, key)
+	return nil
+}
+
+// BatchGet reads the keys from the underlying database.
+func (db *txDB) BatchGet(bucket []byte, keys [][]byte) ([][]byte, error) {
+	return cadb.BatchGet(db.DB, bucket, keys)
+}
+
+// Update fails, the results of a nested transaction would not be known until
+// the commit.
+func (db *txDB) Update(tx *database.Tx) error {
+	return errors.New("nested transactions are not supported")
+}
+
+// commit writes all the recorded operations in a single transaction. Some
+// databases do not roll back a transaction if a compare-and-swap fails, so an
+// error is also returned if one of the new keys already existed.
+func (db *txDB) commit() error {
+	if len(db.tx.Operations) == 0 {
+		return nil
+	}
+	if err := cadb.UpdateWithTTL(db.DB, db.tx, db.ttl); err != nil {
+		return ServerInternalErr(errors.Wrap(err, "error committing transaction"))
+	}
+	for _, op := range db.tx.Operations {
+		if op.Cmd == database.CmpAndSwap && !op.Swapped {
+			return ServerInternalErr(errors.Errorf("error committing transaction; "+
+				"%s/%s already exists", op.Bucket, op.Key))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package acme
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/nosql/database"
+)
+
+// ttlMockDB is a MockNoSQLDB that implements the db.TTLDB interface, it
+// records the ttl of the last write.
+type ttlMockDB struct {
+	*db.MockNoSQLDB
+	ttl *time.Duration
+}
+
+func (m *ttlMockDB) SetWithTTL(bucket, key, value []byte, ttl time.Duration) error {
+	*m.ttl = ttl
+	return m.Set(bucket, key, value)
+}
+
+func (m *ttlMockDB) CmpAndSwapWithTTL(bucket, key, oldValue, newValue []byte, ttl time.Duration) ([]byte, bool, error) {
+	*m.ttl = ttl
+	return m.CmpAndSwap(bucket, key, oldValue, newValue)
+}
+
+func (m *ttlMockDB) UpdateWithTTL(tx *database.Tx, ttl time.Duration) error {
+	*m.ttl = ttl
+	return m.Update(tx)
+}
+
+func TestTxDB(t *testing.T) {
+	tables := map[string]map[string][]byte{
+		string(orderTable): {"o0": []byte("old")},
+		string(authzTable): {},
+	}
+	tx := newTxDB(newCleanupDB(tables))
+
+	v, swapped, err := tx.CmpAndSwap(orderTable, []byte("o1"), nil, []byte("new"))
+	assert.FatalError(t, err)
+	assert.True(t, swapped)
+	assert.Equals(t, []byte("new"), v)
+	_, _, err = tx.CmpAndSwap(orderTable, []byte("o0"), []byte("old"), []byte("new"))
+	assert.HasPrefix(t, err.Error(), "cannot update acme_orders/o0 in a transaction")
+	assert.FatalError(t, tx.Set(authzTable, []byte("az1"), []byte("authz")))
+	assert.FatalError(t, tx.Del(orderTable, []byte("o0")))
+	assert.Equals(t, "nested transactions are not supported", tx.Update(new(database.Tx)).Error())
+
+	// Nothing is written before the commit, but reads are not buffered.
+	assert.Equals(t, map[string][]byte{"o0": []byte("old")}, tables[string(orderTable)])
+	b, err := tx.Get(orderTable, []byte("o0"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("old"), b)
+
+	assert.FatalError(t, tx.commit())
+	assert.Equals(t, map[string][]byte{"o1": []byte("new")}, tables[string(orderTable)])
+	assert.Equals(t, map[string][]byte{"az1": []byte("authz")}, tables[string(authzTable)])
+
+	// An empty transaction is not sent to the database.
+	assert.FatalError(t, newTxDB(&db.MockNoSQLDB{
+		MUpdate: func(tx *database.Tx) error {
+			return errors.New("force")
+		},
+	}).commit())
+}
+
+func TestTxDB_commit(t *testing.T) {
+	tests := map[string]struct {
+		update func(tx *database.Tx) error
+		err    *Error
+	}{
+		"fail/update-error": {
+			update: func(tx *database.Tx) error {
+				return errors.New("force")
+			},
+			err: ServerInternalErr(errors.New("error committing transaction: force")),
+		},
+		"fail/not-swapped": {
+			update: func(tx *database.Tx) error {
+				return nil
+			},
+			err: ServerInternalErr(errors.New("error committing transaction; acme_orders/o1 already exists")),
+		},
+		"ok": {
+			update: mockUpdate,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			tx := newTxDB(&db.MockNoSQLDB{MUpdate: tc.update})
+			_, _, err := tx.CmpAndSwap(orderTable, []byte("o1"), nil, []byte("new"))
+			assert.FatalError(t, err)
+			if err := tx.commit(); err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
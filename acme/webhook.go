@@ -0,0 +1,200 @@
+package acme
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+// Webhook event types.
+const (
+	// WebhookOrderCreated is sent when a new order is created, the data is an
+	// AdminOrder.
+	WebhookOrderCreated = "order.created"
+	// WebhookChallengeValid is sent when a challenge becomes valid, the data
+	// is a WebhookChallenge.
+	WebhookChallengeValid = "challenge.valid"
+	// WebhookChallengeInvalid is sent when the validation of a challenge
+	// fails for the last time, the data is a WebhookChallenge.
+	WebhookChallengeInvalid = "challenge.invalid"
+	// WebhookOrderFinalized is sent when an order is finalized, the data is
+	// an AdminOrder.
+	WebhookOrderFinalized = "order.finalized"
+	// WebhookCertificateDownloaded is sent when a certificate is downloaded,
+	// the data is an AdminCertificate.
+	WebhookCertificateDownloaded = "certificate.downloaded"
+)
+
+// Webhook request headers.
+const (
+	// WebhookIDHeader contains the id of the event, it's the same in all the
+	// delivery attempts.
+	WebhookIDHeader = "X-Acme-Webhook-Id"
+	// WebhookSignatureHeader contains the hex encoded HMAC-SHA256 of the
+	// request body prefixed by "sha256=".
+	WebhookSignatureHeader = "X-Acme-Webhook-Signature"
+)
+
+func isWebhookEvent(s string) bool {
+	switch s {
+	case WebhookOrderCreated, WebhookChallengeValid, WebhookChallengeInvalid,
+		WebhookOrderFinalized, WebhookCertificateDownloaded:
+		return true
+	default:
+		return false
+	}
+}
+
+// WebhookEvent is the body of the requests sent to the webhooks.
+type WebhookEvent struct {
+	ID          string      `json:"id"`
+	Type        string      `json:"type"`
+	Created     time.Time   `json:"created"`
+	Provisioner string      `json:"provisioner,omitempty"`
+	AccountID   string      `json:"accountID"`
+	Data        interface{} `json:"data"`
+}
+
+// WebhookChallenge is the representation of a challenge in the webhook
+// events.
+type WebhookChallenge struct {
+	ID         string  `json:"id"`
+	AuthzID    string  `json:"authzID"`
+	Type       string  `json:"type"`
+	Status     string  `json:"status"`
+	Identifier string  `json:"identifier"`
+	Error      *AError `json:"error,omitempty"`
+}
+
+func toWebhookChallenge(ch challenge) *WebhookChallenge {
+	return &WebhookChallenge{
+		ID:         ch.getID(),
+		AuthzID:    ch.getAuthzID(),
+		Type:       ch.getType(),
+		Status:     ch.getStatus(),
+		Identifier: ch.getValue(),
+		Error:      ch.getError(),
+	}
+}
+
+// SignWebhook returns the value of the signature header of a webhook request
+// with the given body.
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type webhook struct {
+	config *WebhookConfig
+	client *http.Client
+}
+
+// webhookNotifier sends the events of the ACME authority to the configured
+// webhooks. The deliveries are performed in the background.
+type webhookNotifier struct {
+	webhooks []webhook
+	backoff  time.Duration
+	workers  *workers
+}
+
+// newWebhookNotifier returns the notifier for the given webhooks, or nil if
+// there are no webhooks.
+func newWebhookNotifier(configs []*WebhookConfig) *webhookNotifier {
+	if len(configs) == 0 {
+		return nil
+	}
+	n := &webhookNotifier{backoff: time.Second}
+	for _, c := range configs {
+		n.webhooks = append(n.webhooks, webhook{
+			config: c,
+			client: &http.Client{Timeout: c.GetTimeout()},
+		})
+	}
+	return n
+}
+
+// notify creates an event with the given type and data and sends it to the
+// subscribed webhooks. The provisioner can be nil.
+func (n *webhookNotifier) notify(typ string, p provisioner.Interface, accID string, data interface{}) {
+	if n == nil {
+		return
+	}
+	id, err := randID()
+	if err != nil {
+		log.Printf("error creating acme webhook event: %v", err)
+		return
+	}
+	ev := &WebhookEvent{
+		ID:        id,
+		Type:      typ,
+		Created:   clock.Now(),
+		AccountID: accID,
+		Data:      data,
+	}
+	if p != nil {
+		ev.Provisioner = p.GetName()
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("error marshaling acme webhook event %s: %v", id, err)
+		return
+	}
+	for _, wh := range n.webhooks {
+		if wh.config.IsSubscribed(typ) {
+			wh := wh
+			n.workers.run(func() {
+				n.deliver(wh, id, body)
+			})
+		}
+	}
+}
+
+// deliver sends the event to the webhook, retrying with an exponential
+// backoff until it succeeds or the maximum number of attempts is reached.
+func (n *webhookNotifier) deliver(wh webhook, id string, body []byte) {
+	backoff := n.backoff
+	for attempt := 1; ; attempt++ {
+		err := wh.send(id, body)
+		if err == nil {
+			return
+		}
+		if attempt >= wh.config.GetMaxAttempts() {
+			log.Printf("error sending acme webhook event %s: %v", id, err)
+			return
+		}
+		if !n.workers.sleep(backoff) {
+			log.Printf("error sending acme webhook event %s: %v", id, err)
+			return
+		}
+		backoff *= 2
+	}
+}
+
+// send performs one delivery attempt.
+func (wh webhook) send(id string, body []byte) error {
+	req, err := http.NewRequest("POST", wh.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrapf(err, "error creating request for url %s", wh.config.URL)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookIDHeader, id)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(wh.config.Secret, body))
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "error doing http POST for url %s", wh.config.URL)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("error doing http POST for url %s with status code %d", wh.config.URL, resp.StatusCode)
+	}
+	return nil
+}
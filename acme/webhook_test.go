@@ -0,0 +1,182 @@
+package acme
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/db"
+)
+
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+func newWebhookServer(t *testing.T, failures int32) (*httptest.Server, chan webhookRequest) {
+	ch := make(chan webhookRequest, 10)
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equals(t, r.Method, "POST")
+		if atomic.AddInt32(&attempts, 1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		assert.FatalError(t, err)
+		ch <- webhookRequest{header: r.Header, body: body}
+	}))
+	return srv, ch
+}
+
+func receiveWebhook(t *testing.T, ch chan webhookRequest) webhookRequest {
+	select {
+	case req := <-ch:
+		return req
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for webhook")
+		return webhookRequest{}
+	}
+}
+
+func TestSignWebhook(t *testing.T) {
+	body := []byte(`{"id":"foo"}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	assert.Equals(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), SignWebhook("secret", body))
+	assert.NotEquals(t, SignWebhook("secret", body), SignWebhook("other", body))
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	all, allCh := newWebhookServer(t, 2)
+	defer all.Close()
+	finalized, finalizedCh := newWebhookServer(t, 0)
+	defer finalized.Close()
+
+	n := newWebhookNotifier([]*WebhookConfig{
+		{URL: all.URL, Secret: "secret"},
+		{URL: finalized.URL, Secret: "other", Events: []string{WebhookOrderFinalized}},
+	})
+	n.backoff = time.Millisecond
+
+	prov := newProv()
+	o := &AdminOrder{ID: "ordID", Status: StatusPending}
+	n.notify(WebhookOrderCreated, prov, "accID", o)
+
+	// The first webhook succeeds after two failed attempts.
+	req := receiveWebhook(t, allCh)
+	assert.Equals(t, req.header.Get("Content-Type"), "application/json")
+	assert.Equals(t, req.header.Get(WebhookSignatureHeader), SignWebhook("secret", req.body))
+
+	type orderEvent struct {
+		WebhookEvent
+		Data *AdminOrder `json:"data"`
+	}
+	var ev orderEvent
+	assert.FatalError(t, json.Unmarshal(req.body, &ev))
+	assert.Equals(t, req.header.Get(WebhookIDHeader), ev.ID)
+	assert.Equals(t, ev.Type, WebhookOrderCreated)
+	assert.Equals(t, ev.Provisioner, prov.GetName())
+	assert.Equals(t, ev.AccountID, "accID")
+	assert.Equals(t, ev.Data.ID, o.ID)
+	assert.Equals(t, ev.Data.Status, o.Status)
+
+	// The second webhook is only subscribed to finalized orders.
+	n.notify(WebhookOrderFinalized, nil, "accID", o)
+	req = receiveWebhook(t, finalizedCh)
+	assert.Equals(t, req.header.Get(WebhookSignatureHeader), SignWebhook("other", req.body))
+	ev = orderEvent{}
+	assert.FatalError(t, json.Unmarshal(req.body, &ev))
+	assert.Equals(t, ev.Type, WebhookOrderFinalized)
+	assert.Equals(t, ev.Provisioner, "")
+	receiveWebhook(t, allCh)
+
+	select {
+	case <-finalizedCh:
+		t.Fatal("unexpected webhook")
+	default:
+	}
+
+	// A nil notifier does nothing.
+	var nilNotifier *webhookNotifier
+	nilNotifier.notify(WebhookOrderCreated, prov, "accID", o)
+}
+
+func TestWebhookNotifierMaxAttempts(t *testing.T) {
+	srv, ch := newWebhookServer(t, 2)
+	defer srv.Close()
+
+	n := newWebhookNotifier([]*WebhookConfig{{URL: srv.URL, Secret: "secret", MaxAttempts: 2}})
+	n.backoff = time.Millisecond
+	// Both attempts of the first event fail.
+	n.notify(WebhookOrderCreated, nil, "accID", nil)
+	select {
+	case <-ch:
+		t.Fatal("unexpected webhook")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	n.notify(WebhookOrderCreated, nil, "accID", nil)
+	receiveWebhook(t, ch)
+}
+
+func TestAuthorityWebhooks(t *testing.T) {
+	srv, ch := newWebhookServer(t, 0)
+	defer srv.Close()
+
+	cert, err := newcert()
+	assert.FatalError(t, err)
+	b, err := json.Marshal(cert)
+	assert.FatalError(t, err)
+	auth, err := NewAuthority(&db.MockNoSQLDB{
+		MGet: func(bucket, key []byte) ([]byte, error) {
+			return b, nil
+		},
+	}, "ca.smallstep.com", "acme", nil, WithConfig(&Config{
+		Webhooks: []*WebhookConfig{{URL: srv.URL, Secret: "secret"}},
+	}))
+	assert.FatalError(t, err)
+
+	_, err = auth.GetCertificate(newProv(), cert.AccountID, cert.ID)
+	assert.FatalError(t, err)
+
+	req := receiveWebhook(t, ch)
+	var ev struct {
+		WebhookEvent
+		Data *AdminCertificate `json:"data"`
+	}
+	assert.FatalError(t, json.Unmarshal(req.body, &ev))
+	assert.Equals(t, ev.Type, WebhookCertificateDownloaded)
+	assert.Equals(t, ev.AccountID, cert.AccountID)
+	exp, err := cert.toAdmin()
+	assert.FatalError(t, err)
+	assert.Equals(t, ev.Data.ID, exp.ID)
+	assert.Equals(t, ev.Data.SerialNumber, exp.SerialNumber)
+
+	ch2, err := newHTTPCh()
+	assert.FatalError(t, err)
+	upd := ch2.clone()
+	upd.Status = StatusInvalid
+	upd.Error = ConnectionErr(nil).ToACME()
+	auth.reportChallenge(nil, upd)
+
+	req = receiveWebhook(t, ch)
+	var chEv struct {
+		WebhookEvent
+		Data *WebhookChallenge `json:"data"`
+	}
+	assert.FatalError(t, json.Unmarshal(req.body, &chEv))
+	assert.Equals(t, chEv.Type, WebhookChallengeInvalid)
+	assert.Equals(t, chEv.Data.ID, upd.ID)
+	assert.Equals(t, chEv.Data.Type, "http-01")
+	assert.Equals(t, chEv.Data.Status, StatusInvalid)
+	assert.Equals(t, chEv.Data.Error.Type, upd.Error.Type)
+}
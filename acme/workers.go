@@ -0,0 +1,103 @@
+package acme
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// workers tracks the goroutines started by the ACME authority, like the
+// challenge validations and the webhook deliveries, so they can be drained
+// when the CA shuts down. The authority created on a reload shares the
+// workers of the previous one.
+type workers struct {
+	wg       sync.WaitGroup
+	done     chan struct{}
+	doneOnce sync.Once
+	mu       sync.Mutex
+	claimed  map[string]bool
+}
+
+func newWorkers() *workers {
+	return &workers{
+		done:    make(chan struct{}),
+		claimed: make(map[string]bool),
+	}
+}
+
+// claim reserves the given id, it returns false if it's already reserved. A
+// nil workers always returns true.
+func (w *workers) claim(id string) bool {
+	if w == nil {
+		return true
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.claimed[id] {
+		return false
+	}
+	w.claimed[id] = true
+	return true
+}
+
+// release frees an id reserved with claim.
+func (w *workers) release(id string) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	delete(w.claimed, id)
+	w.mu.Unlock()
+}
+
+// run runs fn in a new goroutine. A nil workers runs fn without tracking it.
+func (w *workers) run(fn func()) {
+	if w == nil {
+		go fn()
+		return
+	}
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		fn()
+	}()
+}
+
+// sleep waits for the given duration. It returns false if the workers are
+// shut down before, in that case the caller must not start a new attempt.
+func (w *workers) sleep(d time.Duration) bool {
+	if w == nil {
+		time.Sleep(d)
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-w.done:
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// shutdown signals the workers to stop retrying and waits for the in-flight
+// attempts to finish or for the context to be done.
+func (w *workers) shutdown(ctx context.Context) error {
+	if w == nil {
+		return nil
+	}
+	w.doneOnce.Do(func() {
+		close(w.done)
+	})
+	finished := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,40 @@
+package acme
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+)
+
+func TestWorkers(t *testing.T) {
+	w := newWorkers()
+	started, release := make(chan struct{}), make(chan struct{})
+	var slept bool
+	w.run(func() {
+		close(started)
+		<-release
+		slept = w.sleep(time.Hour)
+	})
+	<-started
+
+	// The in-flight worker is not finished before the deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equals(t, context.DeadlineExceeded, w.shutdown(ctx))
+
+	// After the shutdown the worker does not wait for the next attempt.
+	close(release)
+	assert.FatalError(t, w.shutdown(context.Background()))
+	assert.False(t, slept)
+	assert.False(t, w.sleep(time.Hour))
+
+	// A nil workers runs the functions without tracking them.
+	var nw *workers
+	done := make(chan struct{})
+	nw.run(func() { close(done) })
+	<-done
+	assert.True(t, nw.sleep(0))
+	assert.FatalError(t, nw.shutdown(context.Background()))
+}
@@ -0,0 +1,453 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/certificates/audit"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/authority/policy"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/certificates/logging"
+)
+
+// AdminAuthority is the interface implemented by a CA authority that supports
+// the management of provisioners and policies with the admin API.
+type AdminAuthority interface {
+	AuthorizeAdmin(crt *x509.Certificate) error
+	GetProvisioners(cursor string, limit int) (provisioner.List, string, error)
+	LoadProvisionerByID(string) (provisioner.Interface, error)
+	StoreProvisioner(p provisioner.Interface) error
+	UpdateProvisioner(id string, p provisioner.Interface) error
+	RemoveProvisioner(id string) error
+	GetAuthorityPolicy() (*policy.Options, error)
+	UpdateAuthorityPolicy(o *policy.Options) error
+	RemoveAuthorityPolicy() error
+	GetProvisionerPolicy(id string) (*policy.Options, error)
+	UpdateProvisionerPolicy(id string, o *policy.Options) error
+	EvaluatePolicy(req *authority.PolicyEvaluationRequest) (*authority.PolicyEvaluationResponse, error)
+	ExportDatabase(w io.Writer) (int, error)
+	GetMigrationStatus() (*db.MigrationStatus, error)
+	RotateIntermediateWithKey(crt *x509.Certificate, key string) error
+	RetireIntermediate(fingerprint string) error
+	GetAuditLogger() *audit.Logger
+}
+
+// Reloader is the interface implemented by the CA to reload its configuration
+// with the admin API. ScheduleReload must validate the new configuration
+// before returning, and replace the server in the background.
+type Reloader interface {
+	ScheduleReload() error
+}
+
+// AdminOption is the type of the options passed to NewAdmin.
+type AdminOption func(h *adminHandler)
+
+// WithReloader enables the reload of the configuration with the admin API.
+func WithReloader(r Reloader) AdminOption {
+	return func(h *adminHandler) {
+		h.Reloader = r
+	}
+}
+
+// NewAdmin returns a new router for the provisioners and policies admin API.
+func NewAdmin(auth AdminAuthority, opts ...AdminOption) RouterHandler {
+	h := &adminHandler{
+		Authority: auth,
+	}
+	for _, fn := range opts {
+		fn(h)
+	}
+	return h
+}
+
+// adminHandler is the provisioners and policies admin API request handler. All the
+// requests must be authenticated with a client certificate of an admin.
+type adminHandler struct {
+	Authority AdminAuthority
+	Reloader  Reloader
+}
+
+// Route traffic and implement the Router interface.
+func (h *adminHandler) Route(r Router) {
+	r.MethodFunc("GET", "/provisioners", h.authorize(h.ListProvisioners))
+	r.MethodFunc("POST", "/provisioners", h.authorize(h.CreateProvisioner))
+	r.MethodFunc("GET", "/provisioners/*", h.authorize(h.GetProvisioner))
+	r.MethodFunc("PUT", "/provisioners/*", h.authorize(h.UpdateProvisioner))
+	r.MethodFunc("DELETE", "/provisioners/*", h.authorize(h.DeleteProvisioner))
+	r.MethodFunc("GET", "/policy", h.authorize(h.GetAuthorityPolicy))
+	r.MethodFunc("PUT", "/policy", h.authorize(h.UpdateAuthorityPolicy))
+	r.MethodFunc("DELETE", "/policy", h.authorize(h.DeleteAuthorityPolicy))
+	r.MethodFunc("POST", "/policy/evaluate", h.authorize(h.EvaluatePolicy))
+	r.MethodFunc("GET", "/policy/provisioners/*", h.authorize(h.GetProvisionerPolicy))
+	r.MethodFunc("PUT", "/policy/provisioners/*", h.authorize(h.UpdateProvisionerPolicy))
+	r.MethodFunc("DELETE", "/policy/provisioners/*", h.authorize(h.DeleteProvisionerPolicy))
+	r.MethodFunc("POST", "/reload", h.authorize(h.Reload))
+	r.MethodFunc("GET", "/export", h.authorize(h.ExportDatabase))
+	r.MethodFunc("GET", "/migration", h.authorize(h.GetMigrationStatus))
+	r.MethodFunc("POST", "/intermediates", h.authorize(h.RotateIntermediate))
+	r.MethodFunc("DELETE", "/intermediates/{fingerprint}", h.authorize(h.RetireIntermediate))
+}
+
+// authorize is a middleware that checks that the request has been made over
+// mTLS with the certificate of an admin.
+func (h *adminHandler) authorize(next http.HandlerFunc) http.HandlerFunc {
+	return AuthorizeAdmin(h.Authority.AuthorizeAdmin, next)
+}
+
+// AuthorizeAdmin is a middleware that checks that the request has been made
+// over mTLS with a client certificate accepted by the given authorize
+// function. If the request does not have a verified client certificate,
+// authorize is called with nil and must return an error. The certificate is
+// available to next with AdminCertificateFromContext.
+func AuthorizeAdmin(authorize func(crt *x509.Certificate) error, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var crt *x509.Certificate
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+			crt = r.TLS.VerifiedChains[0][0]
+		}
+		if err := authorize(crt); err != nil {
+			WriteError(w, err)
+			return
+		}
+		if rl, ok := w.(logging.ResponseLogger); ok {
+			rl.WithFields(map[string]interface{}{
+				"admin-subject": crt.Subject.CommonName,
+				"admin-serial":  crt.SerialNumber.String(),
+			})
+		}
+		ctx := context.WithValue(r.Context(), adminCertificateKey{}, crt)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+type adminCertificateKey struct{}
+
+// AdminCertificateFromContext returns the client certificate of the admin
+// authorized with AuthorizeAdmin.
+func AdminCertificateFromContext(ctx context.Context) (*x509.Certificate, bool) {
+	crt, ok := ctx.Value(adminCertificateKey{}).(*x509.Certificate)
+	return crt, ok
+}
+
+// audit records a change made by the admin of the request in the audit log.
+func (h *adminHandler) audit(r *http.Request, typ, subject string, details map[string]string) {
+	e := &audit.Event{
+		Type:     typ,
+		ClientIP: audit.ClientIPFromContext(r.Context()),
+		Subject:  subject,
+		Details:  details,
+	}
+	if crt, ok := AdminCertificateFromContext(r.Context()); ok {
+		e.Actor = crt.Subject.CommonName
+	}
+	h.Authority.GetAuditLogger().Log(e)
+}
+
+// ListProvisioners returns the list of provisioners in the authority,
+// including the ones created with the admin API.
+func (h *adminHandler) ListProvisioners(w http.ResponseWriter, r *http.Request) {
+	cursor, limit, err := parseCursor(r)
+	if err != nil {
+		WriteError(w, errs.BadRequestErr(err))
+		return
+	}
+
+	p, next, err := h.Authority.GetProvisioners(cursor, limit)
+	if err != nil {
+		WriteError(w, errs.InternalServerErr(err))
+		return
+	}
+	JSON(w, &ProvisionersResponse{
+		Provisioners: p,
+		NextCursor:   next,
+	})
+}
+
+// GetProvisioner returns the provisioner with the id in the path.
+func (h *adminHandler) GetProvisioner(w http.ResponseWriter, r *http.Request) {
+	p, err := h.Authority.LoadProvisionerByID(chi.URLParam(r, "*"))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, p)
+}
+
+// CreateProvisioner adds the provisioner in the request body to the
+// authority.
+func (h *adminHandler) CreateProvisioner(w http.ResponseWriter, r *http.Request) {
+	p, err := readProvisioner(r)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	if err := h.Authority.StoreProvisioner(p); err != nil {
+		WriteError(w, err)
+		return
+	}
+	h.audit(r, audit.ProvisionerCreated, p.GetID(), nil)
+	JSONStatus(w, p, http.StatusCreated)
+}
+
+// UpdateProvisioner replaces the provisioner with the id in the path with the
+// provisioner in the request body.
+func (h *adminHandler) UpdateProvisioner(w http.ResponseWriter, r *http.Request) {
+	p, err := readProvisioner(r)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	id := chi.URLParam(r, "*")
+	if err := h.Authority.UpdateProvisioner(id, p); err != nil {
+		WriteError(w, err)
+		return
+	}
+	h.audit(r, audit.ProvisionerUpdated, id, nil)
+	JSON(w, p)
+}
+
+// DeleteProvisioner removes the provisioner with the id in the path.
+func (h *adminHandler) DeleteProvisioner(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "*")
+	if err := h.Authority.RemoveProvisioner(id); err != nil {
+		WriteError(w, err)
+		return
+	}
+	h.audit(r, audit.ProvisionerDeleted, id, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetAuthorityPolicy returns the name policy of the authority.
+func (h *adminHandler) GetAuthorityPolicy(w http.ResponseWriter, r *http.Request) {
+	o, err := h.Authority.GetAuthorityPolicy()
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, o)
+}
+
+// UpdateAuthorityPolicy replaces the name policy of the authority with the
+// policy in the request body.
+func (h *adminHandler) UpdateAuthorityPolicy(w http.ResponseWriter, r *http.Request) {
+	var o policy.Options
+	if err := ReadJSON(r.Body, &o); err != nil {
+		WriteError(w, err)
+		return
+	}
+	if err := h.Authority.UpdateAuthorityPolicy(&o); err != nil {
+		WriteError(w, err)
+		return
+	}
+	h.audit(r, audit.PolicyUpdated, "", map[string]string{"scope": authority.PolicyScopeAuthority})
+	JSON(w, &o)
+}
+
+// DeleteAuthorityPolicy removes the name policy of the authority set with the
+// admin API.
+func (h *adminHandler) DeleteAuthorityPolicy(w http.ResponseWriter, r *http.Request) {
+	if err := h.Authority.RemoveAuthorityPolicy(); err != nil {
+		WriteError(w, err)
+		return
+	}
+	h.audit(r, audit.PolicyDeleted, "", map[string]string{"scope": authority.PolicyScopeAuthority})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetProvisionerPolicy returns the name policy of the provisioner with the id
+// in the path.
+func (h *adminHandler) GetProvisionerPolicy(w http.ResponseWriter, r *http.Request) {
+	o, err := h.Authority.GetProvisionerPolicy(chi.URLParam(r, "*"))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, o)
+}
+
+// UpdateProvisionerPolicy replaces the name policy of the provisioner with the
+// id in the path with the policy in the request body.
+func (h *adminHandler) UpdateProvisionerPolicy(w http.ResponseWriter, r *http.Request) {
+	var o policy.Options
+	if err := ReadJSON(r.Body, &o); err != nil {
+		WriteError(w, err)
+		return
+	}
+	id := chi.URLParam(r, "*")
+	if err := h.Authority.UpdateProvisionerPolicy(id, &o); err != nil {
+		WriteError(w, err)
+		return
+	}
+	h.audit(r, audit.PolicyUpdated, id, map[string]string{"scope": authority.PolicyScopeProvisioner})
+	JSON(w, &o)
+}
+
+// DeleteProvisionerPolicy removes the name policy of the provisioner with the
+// id in the path.
+func (h *adminHandler) DeleteProvisionerPolicy(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "*")
+	if err := h.Authority.UpdateProvisionerPolicy(id, nil); err != nil {
+		WriteError(w, err)
+		return
+	}
+	h.audit(r, audit.PolicyDeleted, id, map[string]string{"scope": authority.PolicyScopeProvisioner})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// EvaluatePolicy checks the names in the request body against the authority
+// policy, and optionally against a provisioner policy, without signing a
+// certificate.
+func (h *adminHandler) EvaluatePolicy(w http.ResponseWriter, r *http.Request) {
+	var body authority.PolicyEvaluationRequest
+	if err := ReadJSON(r.Body, &body); err != nil {
+		WriteError(w, err)
+		return
+	}
+	res, err := h.Authority.EvaluatePolicy(&body)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, res)
+}
+
+// readProvisioner reads the JSON representation of a provisioner from the
+// request body.
+func readProvisioner(r *http.Request) (provisioner.Interface, error) {
+	var body json.RawMessage
+	if err := ReadJSON(r.Body, &body); err != nil {
+		return nil, errs.Wrap(http.StatusBadRequest, err, "error reading request body")
+	}
+	p, err := provisioner.UnmarshalProvisioner(body)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusBadRequest, err, "error reading request body")
+	}
+	return p, nil
+}
+
+// Reload reloads the configuration file of the CA. The response is sent once
+// the new configuration is loaded, and the server is replaced after the
+// in-flight requests complete.
+func (h *adminHandler) Reload(w http.ResponseWriter, r *http.Request) {
+	if h.Reloader == nil {
+		WriteError(w, errs.NotImplemented("reload is not supported"))
+		return
+	}
+	if err := h.Reloader.ScheduleReload(); err != nil {
+		WriteError(w, errs.BadRequestErr(err))
+		return
+	}
+	h.audit(r, audit.ConfigReloaded, "", nil)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// RotateIntermediateRequest is the request body used to replace the
+// intermediate that signs new certificates. Crt is the PEM encoded
+// certificate, and Key the path or KMS URI of its private key.
+type RotateIntermediateRequest struct {
+	Crt Certificate `json:"crt"`
+	Key string      `json:"key"`
+}
+
+// RotateIntermediate replaces the intermediate used to sign new certificates
+// with the one in the request body. The old intermediate is kept to verify
+// the certificates it issued until it is retired.
+func (h *adminHandler) RotateIntermediate(w http.ResponseWriter, r *http.Request) {
+	var body RotateIntermediateRequest
+	if err := ReadJSON(r.Body, &body); err != nil {
+		WriteError(w, err)
+		return
+	}
+	crt := body.Crt.Certificate
+	if crt == nil {
+		WriteError(w, errs.BadRequest("crt cannot be empty"))
+		return
+	}
+	if err := h.Authority.RotateIntermediateWithKey(crt, body.Key); err != nil {
+		WriteError(w, err)
+		return
+	}
+	sum := sha256.Sum256(crt.Raw)
+	h.audit(r, audit.IntermediateRotated, hex.EncodeToString(sum[:]), map[string]string{
+		"subject": crt.Subject.CommonName,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RetireIntermediate removes the previous intermediate with the SHA-256
+// fingerprint in the path.
+func (h *adminHandler) RetireIntermediate(w http.ResponseWriter, r *http.Request) {
+	fingerprint := strings.ToLower(chi.URLParam(r, "fingerprint"))
+	if err := h.Authority.RetireIntermediate(fingerprint); err != nil {
+		WriteError(w, err)
+		return
+	}
+	h.audit(r, audit.IntermediateRetired, fingerprint, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetMigrationStatus returns the progress of the database migration.
+func (h *adminHandler) GetMigrationStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := h.Authority.GetMigrationStatus()
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, status)
+}
+
+// ExportDatabase writes an archive with the data stored in the database, it
+// can be imported in a new database with the step-ca db import command.
+//
+// The archive is streamed to the client. An error found before the first
+// byte is written is sent as an error response, otherwise the gzip stream is
+// truncated and the error is set in the X-Export-Error trailer. The export is
+// audited once the archive starts to be sent, even if it's incomplete.
+func (h *adminHandler) ExportDatabase(w http.ResponseWriter, r *http.Request) {
+	ew := &exportWriter{w: w}
+	n, err := h.Authority.ExportDatabase(ew)
+	if err != nil && !ew.started {
+		WriteError(w, err)
+		return
+	}
+	details := map[string]string{
+		"keys": strconv.Itoa(n),
+	}
+	if err != nil {
+		w.Header().Set("X-Export-Error", err.Error())
+		details["error"] = err.Error()
+		LogError(w, err)
+	}
+	h.audit(r, audit.DatabaseExported, "", details)
+}
+
+// exportWriter writes the headers of the export response before the first
+// byte of the archive.
+type exportWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (e *exportWriter) Write(p []byte) (int, error) {
+	if !e.started {
+		e.started = true
+		filename := "step-ca-" + time.Now().UTC().Format("20060102T150405Z") + ".json.gz"
+		e.w.Header().Set("Content-Type", "application/gzip")
+		e.w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		e.w.Header().Set("Trailer", "X-Export-Error")
+		e.w.WriteHeader(http.StatusOK)
+	}
+	return e.w.Write(p)
+}
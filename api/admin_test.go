@@ -0,0 +1,772 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/certificates/audit"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/authority/policy"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/certificates/logging"
+)
+
+type mockAdminAuthority struct {
+	authorizeAdmin    func(crt *x509.Certificate) error
+	getProvisioners   func(cursor string, limit int) (provisioner.List, string, error)
+	loadProvisioner   func(id string) (provisioner.Interface, error)
+	storeProvisioner  func(p provisioner.Interface) error
+	updateProvisioner func(id string, p provisioner.Interface) error
+	removeProvisioner func(id string) error
+	getPolicy         func() (*policy.Options, error)
+	updatePolicy      func(o *policy.Options) error
+	removePolicy      func() error
+	getProvPolicy     func(id string) (*policy.Options, error)
+	updateProvPolicy  func(id string, o *policy.Options) error
+	evaluatePolicy    func(req *authority.PolicyEvaluationRequest) (*authority.PolicyEvaluationResponse, error)
+	exportDatabase    func(w io.Writer) (int, error)
+	migrationStatus   *db.MigrationStatus
+	rotate            func(crt *x509.Certificate, key string) error
+	retire            func(fingerprint string) error
+	auditLogger       *audit.Logger
+}
+
+func (m *mockAdminAuthority) AuthorizeAdmin(crt *x509.Certificate) error {
+	if m.authorizeAdmin != nil {
+		return m.authorizeAdmin(crt)
+	}
+	if crt == nil {
+		return errs.Unauthorized("missing client certificate")
+	}
+	return nil
+}
+
+func (m *mockAdminAuthority) GetProvisioners(cursor string, limit int) (provisioner.List, string, error) {
+	if m.getProvisioners != nil {
+		return m.getProvisioners(cursor, limit)
+	}
+	return provisioner.List{}, "", nil
+}
+
+func (m *mockAdminAuthority) LoadProvisionerByID(id string) (provisioner.Interface, error) {
+	if m.loadProvisioner != nil {
+		return m.loadProvisioner(id)
+	}
+	return nil, errs.NotFound("provisioner not found")
+}
+
+func (m *mockAdminAuthority) StoreProvisioner(p provisioner.Interface) error {
+	if m.storeProvisioner != nil {
+		return m.storeProvisioner(p)
+	}
+	return nil
+}
+
+func (m *mockAdminAuthority) UpdateProvisioner(id string, p provisioner.Interface) error {
+	if m.updateProvisioner != nil {
+		return m.updateProvisioner(id, p)
+	}
+	return nil
+}
+
+func (m *mockAdminAuthority) RemoveProvisioner(id string) error {
+	if m.removeProvisioner != nil {
+		return m.removeProvisioner(id)
+	}
+	return nil
+}
+
+func (m *mockAdminAuthority) GetAuthorityPolicy() (*policy.Options, error) {
+	if m.getPolicy != nil {
+		return m.getPolicy()
+	}
+	return nil, errs.NotFound("policy not found")
+}
+
+func (m *mockAdminAuthority) UpdateAuthorityPolicy(o *policy.Options) error {
+	if m.updatePolicy != nil {
+		return m.updatePolicy(o)
+	}
+	return nil
+}
+
+func (m *mockAdminAuthority) RemoveAuthorityPolicy() error {
+	if m.removePolicy != nil {
+		return m.removePolicy()
+	}
+	return nil
+}
+
+func (m *mockAdminAuthority) GetProvisionerPolicy(id string) (*policy.Options, error) {
+	if m.getProvPolicy != nil {
+		return m.getProvPolicy(id)
+	}
+	return nil, errs.NotFound("policy not found")
+}
+
+func (m *mockAdminAuthority) UpdateProvisionerPolicy(id string, o *policy.Options) error {
+	if m.updateProvPolicy != nil {
+		return m.updateProvPolicy(id, o)
+	}
+	return nil
+}
+
+func (m *mockAdminAuthority) EvaluatePolicy(req *authority.PolicyEvaluationRequest) (*authority.PolicyEvaluationResponse, error) {
+	if m.evaluatePolicy != nil {
+		return m.evaluatePolicy(req)
+	}
+	return &authority.PolicyEvaluationResponse{Allowed: true}, nil
+}
+
+func (m *mockAdminAuthority) ExportDatabase(w io.Writer) (int, error) {
+	if m.exportDatabase != nil {
+		return m.exportDatabase(w)
+	}
+	return 0, errs.NotImplemented("not implemented")
+}
+
+func (m *mockAdminAuthority) GetMigrationStatus() (*db.MigrationStatus, error) {
+	if m.migrationStatus != nil {
+		return m.migrationStatus, nil
+	}
+	return nil, errs.NotFound("the database is not being migrated")
+}
+
+func (m *mockAdminAuthority) RotateIntermediateWithKey(crt *x509.Certificate, key string) error {
+	if m.rotate != nil {
+		return m.rotate(crt, key)
+	}
+	return errs.NotImplemented("not implemented")
+}
+
+func (m *mockAdminAuthority) RetireIntermediate(fingerprint string) error {
+	if m.retire != nil {
+		return m.retire(fingerprint)
+	}
+	return errs.NotImplemented("not implemented")
+}
+
+func (m *mockAdminAuthority) GetAuditLogger() *audit.Logger {
+	return m.auditLogger
+}
+
+type mockAuditSink struct {
+	events []*audit.Event
+}
+
+func (s *mockAuditSink) Write(e *audit.Event) error {
+	c := *e
+	s.events = append(s.events, &c)
+	return nil
+}
+
+func (s *mockAuditSink) Close() error {
+	return nil
+}
+
+func Test_adminHandler(t *testing.T) {
+	adminCrt := &x509.Certificate{
+		Subject:      pkix.Name{CommonName: "admin"},
+		SerialNumber: big.NewInt(1),
+	}
+	cs := &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{adminCrt}},
+	}
+	acme := &provisioner.ACME{Type: "ACME", Name: "acme"}
+	opts := &policy.Options{
+		X509: &policy.X509Options{
+			Allow: &policy.X509NameOptions{DNSDomains: []string{"*.example.com"}},
+		},
+	}
+	optsJSON := `{"x509":{"allow":{"dns":["*.example.com"]}}}`
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		tls        *tls.ConnectionState
+		auth       *mockAdminAuthority
+		statusCode int
+		expected   string
+	}{
+		{"fail/no-tls", "GET", "/admin/provisioners", "", nil, &mockAdminAuthority{}, http.StatusUnauthorized, ""},
+		{"fail/no-verified-chains", "GET", "/admin/provisioners", "", &tls.ConnectionState{}, &mockAdminAuthority{}, http.StatusUnauthorized, ""},
+		{"fail/not-admin", "GET", "/admin/provisioners", "", cs, &mockAdminAuthority{
+			authorizeAdmin: func(crt *x509.Certificate) error {
+				return errs.Unauthorized("not an admin")
+			},
+		}, http.StatusUnauthorized, ""},
+		{"ok/list", "GET", "/admin/provisioners?limit=1", "", cs, &mockAdminAuthority{
+			getProvisioners: func(cursor string, limit int) (provisioner.List, string, error) {
+				if limit != 1 {
+					t.Errorf("limit = %d, want 1", limit)
+				}
+				return provisioner.List{acme}, "next", nil
+			},
+		}, http.StatusOK, `{"provisioners":[{"type":"ACME","name":"acme"}],"nextCursor":"next"}`},
+		{"fail/list-cursor", "GET", "/admin/provisioners?limit=abc", "", cs, &mockAdminAuthority{}, http.StatusBadRequest, ""},
+		{"ok/get", "GET", "/admin/provisioners/acme/acme", "", cs, &mockAdminAuthority{
+			loadProvisioner: func(id string) (provisioner.Interface, error) {
+				if id != "acme/acme" {
+					t.Errorf("id = %s, want acme/acme", id)
+				}
+				return acme, nil
+			},
+		}, http.StatusOK, `{"type":"ACME","name":"acme"}`},
+		{"fail/get", "GET", "/admin/provisioners/acme/foo", "", cs, &mockAdminAuthority{}, http.StatusNotFound, ""},
+		{"ok/create", "POST", "/admin/provisioners", `{"type":"ACME","name":"acme"}`, cs, &mockAdminAuthority{
+			storeProvisioner: func(p provisioner.Interface) error {
+				if p.GetName() != "acme" {
+					t.Errorf("name = %s, want acme", p.GetName())
+				}
+				return nil
+			},
+		}, http.StatusCreated, `{"type":"ACME","name":"acme"}`},
+		{"fail/create-json", "POST", "/admin/provisioners", `{`, cs, &mockAdminAuthority{}, http.StatusBadRequest, ""},
+		{"fail/create-type", "POST", "/admin/provisioners", `{"type":"foo","name":"foo"}`, cs, &mockAdminAuthority{}, http.StatusBadRequest, ""},
+		{"fail/create", "POST", "/admin/provisioners", `{"type":"ACME","name":"acme"}`, cs, &mockAdminAuthority{
+			storeProvisioner: func(p provisioner.Interface) error {
+				return errs.BadRequest("already exists")
+			},
+		}, http.StatusBadRequest, ""},
+		{"ok/update", "PUT", "/admin/provisioners/acme/acme", `{"type":"ACME","name":"acme"}`, cs, &mockAdminAuthority{
+			updateProvisioner: func(id string, p provisioner.Interface) error {
+				if id != "acme/acme" {
+					t.Errorf("id = %s, want acme/acme", id)
+				}
+				return nil
+			},
+		}, http.StatusOK, `{"type":"ACME","name":"acme"}`},
+		{"fail/update", "PUT", "/admin/provisioners/sshpop/sshpop", `{"type":"SSHPOP","name":"sshpop"}`, cs, &mockAdminAuthority{
+			updateProvisioner: func(id string, p provisioner.Interface) error {
+				return errs.Forbidden("cannot be modified")
+			},
+		}, http.StatusForbidden, ""},
+		{"ok/delete", "DELETE", "/admin/provisioners/max:abc", "", cs, &mockAdminAuthority{
+			removeProvisioner: func(id string) error {
+				if id != "max:abc" {
+					t.Errorf("id = %s, want max:abc", id)
+				}
+				return nil
+			},
+		}, http.StatusNoContent, ""},
+		{"fail/delete", "DELETE", "/admin/provisioners/acme/foo", "", cs, &mockAdminAuthority{
+			removeProvisioner: func(id string) error {
+				return errs.NotFound("not found")
+			},
+		}, http.StatusNotFound, ""},
+		{"ok/get-policy", "GET", "/admin/policy", "", cs, &mockAdminAuthority{
+			getPolicy: func() (*policy.Options, error) {
+				return opts, nil
+			},
+		}, http.StatusOK, optsJSON},
+		{"fail/get-policy", "GET", "/admin/policy", "", cs, &mockAdminAuthority{}, http.StatusNotFound, ""},
+		{"ok/update-policy", "PUT", "/admin/policy", optsJSON, cs, &mockAdminAuthority{
+			updatePolicy: func(o *policy.Options) error {
+				if o.X509 == nil || o.X509.Allow == nil || len(o.X509.Allow.DNSDomains) != 1 {
+					t.Errorf("policy = %v, want %s", o, optsJSON)
+				}
+				return nil
+			},
+		}, http.StatusOK, optsJSON},
+		{"fail/update-policy-json", "PUT", "/admin/policy", `{`, cs, &mockAdminAuthority{}, http.StatusBadRequest, ""},
+		{"fail/update-policy", "PUT", "/admin/policy", `{"x509":{"allow":{"ip":["foo"]}}}`, cs, &mockAdminAuthority{
+			updatePolicy: func(o *policy.Options) error {
+				return errs.BadRequest("invalid policy")
+			},
+		}, http.StatusBadRequest, ""},
+		{"ok/delete-policy", "DELETE", "/admin/policy", "", cs, &mockAdminAuthority{}, http.StatusNoContent, ""},
+		{"fail/delete-policy", "DELETE", "/admin/policy", "", cs, &mockAdminAuthority{
+			removePolicy: func() error {
+				return errs.NotFound("policy not found")
+			},
+		}, http.StatusNotFound, ""},
+		{"ok/get-provisioner-policy", "GET", "/admin/policy/provisioners/acme/acme", "", cs, &mockAdminAuthority{
+			getProvPolicy: func(id string) (*policy.Options, error) {
+				if id != "acme/acme" {
+					t.Errorf("id = %s, want acme/acme", id)
+				}
+				return opts, nil
+			},
+		}, http.StatusOK, optsJSON},
+		{"fail/get-provisioner-policy", "GET", "/admin/policy/provisioners/acme/foo", "", cs, &mockAdminAuthority{}, http.StatusNotFound, ""},
+		{"ok/update-provisioner-policy", "PUT", "/admin/policy/provisioners/acme/acme", optsJSON, cs, &mockAdminAuthority{
+			updateProvPolicy: func(id string, o *policy.Options) error {
+				if id != "acme/acme" {
+					t.Errorf("id = %s, want acme/acme", id)
+				}
+				if o == nil {
+					t.Error("policy cannot be nil")
+				}
+				return nil
+			},
+		}, http.StatusOK, optsJSON},
+		{"fail/update-provisioner-policy", "PUT", "/admin/policy/provisioners/sshpop/sshpop", optsJSON, cs, &mockAdminAuthority{
+			updateProvPolicy: func(id string, o *policy.Options) error {
+				return errs.Forbidden("cannot be modified")
+			},
+		}, http.StatusForbidden, ""},
+		{"ok/delete-provisioner-policy", "DELETE", "/admin/policy/provisioners/acme/acme", "", cs, &mockAdminAuthority{
+			updateProvPolicy: func(id string, o *policy.Options) error {
+				if o != nil {
+					t.Errorf("policy = %v, want nil", o)
+				}
+				return nil
+			},
+		}, http.StatusNoContent, ""},
+		{"ok/evaluate-allowed", "POST", "/admin/policy/evaluate", `{"dns":["foo.example.com"]}`, cs, &mockAdminAuthority{
+			evaluatePolicy: func(req *authority.PolicyEvaluationRequest) (*authority.PolicyEvaluationResponse, error) {
+				if len(req.DNSNames) != 1 || req.DNSNames[0] != "foo.example.com" {
+					t.Errorf("dns = %v, want [foo.example.com]", req.DNSNames)
+				}
+				return &authority.PolicyEvaluationResponse{Allowed: true}, nil
+			},
+		}, http.StatusOK, `{"allowed":true}`},
+		{"ok/evaluate-denied", "POST", "/admin/policy/evaluate", `{"provisioner":"acme/acme","dns":["foo.internal"]}`, cs, &mockAdminAuthority{
+			evaluatePolicy: func(req *authority.PolicyEvaluationRequest) (*authority.PolicyEvaluationResponse, error) {
+				return &authority.PolicyEvaluationResponse{Scope: authority.PolicyScopeProvisioner, Reason: "denied"}, nil
+			},
+		}, http.StatusOK, `{"allowed":false,"scope":"provisioner","reason":"denied"}`},
+		{"fail/evaluate-json", "POST", "/admin/policy/evaluate", `{`, cs, &mockAdminAuthority{}, http.StatusBadRequest, ""},
+		{"fail/evaluate", "POST", "/admin/policy/evaluate", `{"type":"foo"}`, cs, &mockAdminAuthority{
+			evaluatePolicy: func(req *authority.PolicyEvaluationRequest) (*authority.PolicyEvaluationResponse, error) {
+				return nil, errs.BadRequest("type foo is not valid")
+			},
+		}, http.StatusBadRequest, ""},
+		{"fail/evaluate-not-admin", "POST", "/admin/policy/evaluate", `{}`, cs, &mockAdminAuthority{
+			authorizeAdmin: func(crt *x509.Certificate) error {
+				return errs.Unauthorized("not an admin")
+			},
+		}, http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := chi.NewRouter()
+			mux.Route("/admin", func(r chi.Router) {
+				NewAdmin(tt.auth).Route(r)
+			})
+			req := httptest.NewRequest(tt.method, "http://example.com"+tt.path, strings.NewReader(tt.body))
+			req.TLS = tt.tls
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(logging.NewResponseLogger(w), req)
+			res := w.Result()
+
+			if res.StatusCode != tt.statusCode {
+				t.Errorf("adminHandler StatusCode = %d, wants %d", res.StatusCode, tt.statusCode)
+			}
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil {
+				t.Errorf("adminHandler unexpected error = %v", err)
+			}
+			if tt.expected != "" {
+				if got := strings.TrimSpace(string(body)); got != tt.expected {
+					t.Errorf("adminHandler Body = %s, wants %s", got, tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func Test_adminHandler_audit(t *testing.T) {
+	cs := &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{
+			Subject:      pkix.Name{CommonName: "admin@example.com"},
+			SerialNumber: big.NewInt(1),
+		}}},
+	}
+	failDelete := func(id string) error {
+		return errs.NotFound("not found")
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		auth     *mockAdminAuthority
+		expected *audit.Event
+	}{
+		{"create", "POST", "/admin/provisioners", `{"type":"ACME","name":"acme"}`, &mockAdminAuthority{},
+			&audit.Event{Type: audit.ProvisionerCreated, Actor: "admin@example.com", Subject: "acme/acme"}},
+		{"update", "PUT", "/admin/provisioners/acme/acme", `{"type":"ACME","name":"acme"}`, &mockAdminAuthority{},
+			&audit.Event{Type: audit.ProvisionerUpdated, Actor: "admin@example.com", Subject: "acme/acme"}},
+		{"delete", "DELETE", "/admin/provisioners/acme/acme", "", &mockAdminAuthority{},
+			&audit.Event{Type: audit.ProvisionerDeleted, Actor: "admin@example.com", Subject: "acme/acme"}},
+		{"delete-fail", "DELETE", "/admin/provisioners/acme/acme", "", &mockAdminAuthority{removeProvisioner: failDelete}, nil},
+		{"update-policy", "PUT", "/admin/policy", `{}`, &mockAdminAuthority{},
+			&audit.Event{Type: audit.PolicyUpdated, Actor: "admin@example.com", Details: map[string]string{"scope": "authority"}}},
+		{"delete-policy", "DELETE", "/admin/policy", "", &mockAdminAuthority{},
+			&audit.Event{Type: audit.PolicyDeleted, Actor: "admin@example.com", Details: map[string]string{"scope": "authority"}}},
+		{"update-provisioner-policy", "PUT", "/admin/policy/provisioners/acme/acme", `{}`, &mockAdminAuthority{},
+			&audit.Event{Type: audit.PolicyUpdated, Actor: "admin@example.com", Subject: "acme/acme", Details: map[string]string{"scope": "provisioner"}}},
+		{"delete-provisioner-policy", "DELETE", "/admin/policy/provisioners/acme/acme", "", &mockAdminAuthority{},
+			&audit.Event{Type: audit.PolicyDeleted, Actor: "admin@example.com", Subject: "acme/acme", Details: map[string]string{"scope": "provisioner"}}},
+		{"read-only", "GET", "/admin/provisioners", "", &mockAdminAuthority{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := new(mockAuditSink)
+			tt.auth.auditLogger = audit.New(nil, sink)
+			mux := chi.NewRouter()
+			mux.Route("/admin", func(r chi.Router) {
+				NewAdmin(tt.auth).Route(r)
+			})
+			req := httptest.NewRequest(tt.method, "http://example.com"+tt.path, strings.NewReader(tt.body))
+			req.TLS = cs
+			mux.ServeHTTP(httptest.NewRecorder(), req)
+
+			if tt.expected == nil {
+				if len(sink.events) != 0 {
+					t.Errorf("audit events = %v, want none", sink.events)
+				}
+				return
+			}
+			if len(sink.events) != 1 {
+				t.Fatalf("audit events = %d, want 1", len(sink.events))
+			}
+			e := sink.events[0]
+			if e.Type != tt.expected.Type || e.Actor != tt.expected.Actor || e.Subject != tt.expected.Subject {
+				t.Errorf("audit event = %s %s %s, want %s %s %s", e.Type, e.Actor, e.Subject,
+					tt.expected.Type, tt.expected.Actor, tt.expected.Subject)
+			}
+			if !reflect.DeepEqual(e.Details, tt.expected.Details) {
+				t.Errorf("audit event details = %v, want %v", e.Details, tt.expected.Details)
+			}
+		})
+	}
+}
+
+type mockReloader struct {
+	err   error
+	count int
+}
+
+func (m *mockReloader) ScheduleReload() error {
+	m.count++
+	return m.err
+}
+
+func Test_adminHandler_Reload(t *testing.T) {
+	cs := &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{
+			Subject:      pkix.Name{CommonName: "admin@example.com"},
+			SerialNumber: big.NewInt(1),
+		}}},
+	}
+
+	tests := []struct {
+		name       string
+		reloader   *mockReloader
+		tls        *tls.ConnectionState
+		wantStatus int
+		wantCount  int
+		wantAudit  bool
+	}{
+		{"ok", &mockReloader{}, cs, http.StatusAccepted, 1, true},
+		{"fail/reload", &mockReloader{err: errors.New("error reloading ca: database configuration cannot change")}, cs, http.StatusBadRequest, 1, false},
+		{"fail/unauthorized", &mockReloader{}, nil, http.StatusUnauthorized, 0, false},
+		{"fail/not-implemented", nil, cs, http.StatusNotImplemented, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := new(mockAuditSink)
+			auth := &mockAdminAuthority{auditLogger: audit.New(nil, sink)}
+			var opts []AdminOption
+			if tt.reloader != nil {
+				opts = append(opts, WithReloader(tt.reloader))
+			}
+			mux := chi.NewRouter()
+			mux.Route("/admin", func(r chi.Router) {
+				NewAdmin(auth, opts...).Route(r)
+			})
+			req := httptest.NewRequest("POST", "http://example.com/admin/reload", nil)
+			req.TLS = tt.tls
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("adminHandler.Reload() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.reloader != nil && tt.reloader.count != tt.wantCount {
+				t.Errorf("adminHandler.Reload() reloads = %d, want %d", tt.reloader.count, tt.wantCount)
+			}
+			if tt.wantAudit {
+				if len(sink.events) != 1 || sink.events[0].Type != audit.ConfigReloaded || sink.events[0].Actor != "admin@example.com" {
+					t.Errorf("audit events = %v, want %s", sink.events, audit.ConfigReloaded)
+				}
+			} else if len(sink.events) != 0 {
+				t.Errorf("audit events = %v, want none", sink.events)
+			}
+		})
+	}
+}
+
+func Test_adminHandler_ExportDatabase(t *testing.T) {
+	cs := &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{
+			Subject:      pkix.Name{CommonName: "admin@example.com"},
+			SerialNumber: big.NewInt(1),
+		}}},
+	}
+	export := func(w io.Writer) (int, error) {
+		_, err := w.Write([]byte("archive"))
+		return 2, err
+	}
+
+	tests := []struct {
+		name       string
+		export     func(w io.Writer) (int, error)
+		tls        *tls.ConnectionState
+		wantStatus int
+		wantBody   string
+		wantError  string
+		wantAudit  bool
+	}{
+		{"ok", export, cs, http.StatusOK, "archive", "", true},
+		{"fail/export", func(w io.Writer) (int, error) {
+			return 0, errs.InternalServer("force")
+		}, cs, http.StatusInternalServerError, "", "", false},
+		{"fail/truncated", func(w io.Writer) (int, error) {
+			w.Write([]byte("partial"))
+			return 1, errs.InternalServer("force")
+		}, cs, http.StatusOK, "partial", "force", true},
+		{"fail/not-implemented", nil, cs, http.StatusNotImplemented, "", "", false},
+		{"fail/unauthorized", export, nil, http.StatusUnauthorized, "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := new(mockAuditSink)
+			auth := &mockAdminAuthority{exportDatabase: tt.export, auditLogger: audit.New(nil, sink)}
+			mux := chi.NewRouter()
+			mux.Route("/admin", func(r chi.Router) {
+				NewAdmin(auth).Route(r)
+			})
+			req := httptest.NewRequest("GET", "http://example.com/admin/export", nil)
+			req.TLS = tt.tls
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			res := w.Result()
+
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("adminHandler.ExportDatabase() status = %d, want %d", res.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK {
+				if w.Body.String() != tt.wantBody {
+					t.Errorf("adminHandler.ExportDatabase() body = %s, want %s", w.Body.String(), tt.wantBody)
+				}
+				if ct := res.Header.Get("Content-Type"); ct != "application/gzip" {
+					t.Errorf("adminHandler.ExportDatabase() Content-Type = %s, want application/gzip", ct)
+				}
+				if e := res.Trailer.Get("X-Export-Error"); e != tt.wantError {
+					t.Errorf("adminHandler.ExportDatabase() X-Export-Error = %s, want %s", e, tt.wantError)
+				}
+			}
+			if tt.wantAudit {
+				if len(sink.events) != 1 || sink.events[0].Type != audit.DatabaseExported || sink.events[0].Details["error"] != tt.wantError {
+					t.Errorf("audit events = %v, want %s", sink.events, audit.DatabaseExported)
+				}
+			} else if len(sink.events) != 0 {
+				t.Errorf("audit events = %v, want none", sink.events)
+			}
+		})
+	}
+}
+
+func Test_adminHandler_GetMigrationStatus(t *testing.T) {
+	cs := &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{
+			Subject:      pkix.Name{CommonName: "admin@example.com"},
+			SerialNumber: big.NewInt(1),
+		}}},
+	}
+
+	tests := []struct {
+		name       string
+		status     *db.MigrationStatus
+		tls        *tls.ConnectionState
+		wantStatus int
+		wantBody   string
+	}{
+		{"ok", &db.MigrationStatus{State: db.MigrationComplete, Copied: 10, Repaired: 1}, cs, http.StatusOK, `{"state":"complete","copied":10,"repaired":1}`},
+		{"fail/not-found", nil, cs, http.StatusNotFound, ""},
+		{"fail/unauthorized", &db.MigrationStatus{}, nil, http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := &mockAdminAuthority{migrationStatus: tt.status}
+			mux := chi.NewRouter()
+			mux.Route("/admin", func(r chi.Router) {
+				NewAdmin(auth).Route(r)
+			})
+			req := httptest.NewRequest("GET", "http://example.com/admin/migration", nil)
+			req.TLS = tt.tls
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("adminHandler.GetMigrationStatus() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && strings.TrimSpace(w.Body.String()) != tt.wantBody {
+				t.Errorf("adminHandler.GetMigrationStatus() body = %s, want %s", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func Test_adminHandler_RotateIntermediate(t *testing.T) {
+	cs := &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{
+			Subject:      pkix.Name{CommonName: "admin@example.com"},
+			SerialNumber: big.NewInt(1),
+		}}},
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "Intermediate CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(der)
+	fingerprint := hex.EncodeToString(sum[:])
+	crtJSON, err := json.Marshal(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := `{"crt":` + string(crtJSON) + `,"key":"awskms:key-id=foo"}`
+	rotate := func(crt *x509.Certificate, key string) error {
+		if crt.Subject.CommonName != "Intermediate CA" || key != "awskms:key-id=foo" {
+			t.Errorf("RotateIntermediateWithKey() crt = %s, key = %s", crt.Subject.CommonName, key)
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		rotate     func(crt *x509.Certificate, key string) error
+		tls        *tls.ConnectionState
+		wantStatus int
+		wantAudit  bool
+	}{
+		{"ok", body, rotate, cs, http.StatusNoContent, true},
+		{"fail/json", `{`, rotate, cs, http.StatusBadRequest, false},
+		{"fail/crt", `{"crt":"foo","key":"awskms:key-id=foo"}`, rotate, cs, http.StatusBadRequest, false},
+		{"fail/empty-crt", `{"key":"awskms:key-id=foo"}`, rotate, cs, http.StatusBadRequest, false},
+		{"fail/rotate", body, func(crt *x509.Certificate, key string) error {
+			return errs.BadRequest("certificate public key does not match the signer")
+		}, cs, http.StatusBadRequest, false},
+		{"fail/unauthorized", body, rotate, nil, http.StatusUnauthorized, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := new(mockAuditSink)
+			auth := &mockAdminAuthority{rotate: tt.rotate, auditLogger: audit.New(nil, sink)}
+			mux := chi.NewRouter()
+			mux.Route("/admin", func(r chi.Router) {
+				NewAdmin(auth).Route(r)
+			})
+			req := httptest.NewRequest("POST", "http://example.com/admin/intermediates", strings.NewReader(tt.body))
+			req.TLS = tt.tls
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("adminHandler.RotateIntermediate() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantAudit {
+				if len(sink.events) != 1 || sink.events[0].Type != audit.IntermediateRotated || sink.events[0].Subject != fingerprint || sink.events[0].Actor != "admin@example.com" {
+					t.Errorf("audit events = %v, want %s", sink.events, audit.IntermediateRotated)
+				}
+			} else if len(sink.events) != 0 {
+				t.Errorf("audit events = %v, want none", sink.events)
+			}
+		})
+	}
+}
+
+func Test_adminHandler_RetireIntermediate(t *testing.T) {
+	cs := &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{
+			Subject:      pkix.Name{CommonName: "admin@example.com"},
+			SerialNumber: big.NewInt(1),
+		}}},
+	}
+	retire := func(fingerprint string) error {
+		if fingerprint != "abcd" {
+			return errs.NotFound("intermediate %s was not found", fingerprint)
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		tls        *tls.ConnectionState
+		wantStatus int
+		wantAudit  bool
+	}{
+		{"ok", "/admin/intermediates/abcd", cs, http.StatusNoContent, true},
+		{"ok/upper", "/admin/intermediates/ABCD", cs, http.StatusNoContent, true},
+		{"fail/not-found", "/admin/intermediates/ef01", cs, http.StatusNotFound, false},
+		{"fail/unauthorized", "/admin/intermediates/abcd", nil, http.StatusUnauthorized, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := new(mockAuditSink)
+			auth := &mockAdminAuthority{retire: retire, auditLogger: audit.New(nil, sink)}
+			mux := chi.NewRouter()
+			mux.Route("/admin", func(r chi.Router) {
+				NewAdmin(auth).Route(r)
+			})
+			req := httptest.NewRequest("DELETE", "http://example.com"+tt.path, nil)
+			req.TLS = tt.tls
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("adminHandler.RetireIntermediate() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantAudit {
+				if len(sink.events) != 1 || sink.events[0].Type != audit.IntermediateRetired || sink.events[0].Subject != "abcd" {
+					t.Errorf("audit events = %v, want %s", sink.events, audit.IntermediateRetired)
+				}
+			} else if len(sink.events) != 0 {
+				t.Errorf("audit events = %v, want none", sink.events)
+			}
+		})
+	}
+}
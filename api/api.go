@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/dsa"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/asn1"
@@ -31,10 +32,12 @@ type Authority interface {
 	// context specifies the Authorize[Sign|Revoke|etc.] method.
 	Authorize(ctx context.Context, ott string) ([]provisioner.SignOption, error)
 	AuthorizeSign(ott string) ([]provisioner.SignOption, error)
+	AuthorizeSignBatch(ctx context.Context, ott string) ([]provisioner.SignOption, error)
 	GetTLSOptions() *tlsutil.TLSOptions
 	Root(shasum string) (*x509.Certificate, error)
-	Sign(cr *x509.CertificateRequest, opts provisioner.Options, signOpts ...provisioner.SignOption) ([]*x509.Certificate, error)
-	Renew(peer *x509.Certificate) ([]*x509.Certificate, error)
+	SignWithContext(ctx context.Context, cr *x509.CertificateRequest, opts provisioner.Options, signOpts ...provisioner.SignOption) ([]*x509.Certificate, error)
+	RenewContext(ctx context.Context, peer *x509.Certificate) ([]*x509.Certificate, error)
+	RekeyCSR(ctx context.Context, peer *x509.Certificate, csr *x509.CertificateRequest) ([]*x509.Certificate, error)
 	LoadProvisionerByCertificate(*x509.Certificate) (provisioner.Interface, error)
 	LoadProvisionerByID(string) (provisioner.Interface, error)
 	GetProvisioners(cursor string, limit int) (provisioner.List, string, error)
@@ -42,7 +45,16 @@ type Authority interface {
 	GetEncryptedKey(kid string) (string, error)
 	GetRoots() (federation []*x509.Certificate, err error)
 	GetFederation() ([]*x509.Certificate, error)
+	GetUpcomingRoots() ([]*x509.Certificate, error)
+	AuthorizeRoots(peer *x509.Certificate) error
+	GetIntermediateCertificates() []*x509.Certificate
+	GetCertificateRevocationList(name string) ([]byte, error)
+	GetDeltaCertificateRevocationList(name string) ([]byte, error)
+	GetOCSPResponse(req []byte) ([]byte, error)
+	GetCertificateStatus(serialNumber string) (*authority.CertificateStatus, error)
+	GetCertificateStatusByFingerprint(fingerprint string) (*authority.CertificateStatus, error)
 	Version() authority.Version
+	CheckHealth() *authority.HealthStatus
 }
 
 // TimeDuration is an alias of provisioner.TimeDuration
@@ -194,13 +206,27 @@ type RouterHandler interface {
 // VersionResponse is the response object that returns the version of the
 // server.
 type VersionResponse struct {
-	Version                     string `json:"version"`
-	RequireClientAuthentication bool   `json:"requireClientAuthentication,omitempty"`
+	Version                     string            `json:"version"`
+	Commit                      string            `json:"commit,omitempty"`
+	BuildTime                   string            `json:"buildTime,omitempty"`
+	RequireClientAuthentication bool              `json:"requireClientAuthentication,omitempty"`
+	Features                    *FeaturesResponse `json:"features,omitempty"`
+	KeyTypes                    []string          `json:"keyTypes,omitempty"`
 }
 
-// HealthResponse is the response object that returns the health of the server.
+// FeaturesResponse is the object that lists the subsystems enabled in the
+// server.
+type FeaturesResponse struct {
+	ACME bool `json:"acme"`
+	SSH  bool `json:"ssh"`
+	SCEP bool `json:"scep"`
+}
+
+// HealthResponse is the response object that returns the health of the server
+// and its components.
 type HealthResponse struct {
-	Status string `json:"status"`
+	Status     string                                `json:"status"`
+	Components map[string]*authority.ComponentHealth `json:"components,omitempty"`
 }
 
 // RootResponse is the response object that returns the PEM of a root certificate.
@@ -222,13 +248,25 @@ type ProvisionerKeyResponse struct {
 }
 
 // RootsResponse is the response object of the roots request.
+//
+// Certificates contains the current and the upcoming roots, the ones that
+// clients must trust, and Upcoming only the roots that will replace the
+// current ones in a rotation.
 type RootsResponse struct {
 	Certificates []Certificate `json:"crts"`
+	Upcoming     []Certificate `json:"upcoming,omitempty"`
 }
 
-// FederationResponse is the response object of the federation request.
+// FederationResponse is the response object of the federation request. Like
+// in RootsResponse, Certificates includes the Upcoming roots.
 type FederationResponse struct {
 	Certificates []Certificate `json:"crts"`
+	Upcoming     []Certificate `json:"upcoming,omitempty"`
+}
+
+// IntermediatesResponse is the response object of the intermediates request.
+type IntermediatesResponse struct {
+	Certificates []Certificate `json:"crts"`
 }
 
 // caHandler is the type used to implement the different CA HTTP endpoints.
@@ -246,14 +284,26 @@ func New(authority Authority) RouterHandler {
 func (h *caHandler) Route(r Router) {
 	r.MethodFunc("GET", "/version", h.Version)
 	r.MethodFunc("GET", "/health", h.Health)
+	r.MethodFunc("GET", "/ready", h.Ready)
 	r.MethodFunc("GET", "/root/{sha}", h.Root)
 	r.MethodFunc("POST", "/sign", h.Sign)
+	r.MethodFunc("POST", "/sign/batch", h.SignBatch)
 	r.MethodFunc("POST", "/renew", h.Renew)
+	r.MethodFunc("POST", "/rekey", h.Rekey)
 	r.MethodFunc("POST", "/revoke", h.Revoke)
 	r.MethodFunc("GET", "/provisioners", h.Provisioners)
 	r.MethodFunc("GET", "/provisioners/{kid}/encrypted-key", h.ProvisionerKey)
 	r.MethodFunc("GET", "/roots", h.Roots)
 	r.MethodFunc("GET", "/federation", h.Federation)
+	r.MethodFunc("GET", "/intermediates", h.Intermediates)
+	r.MethodFunc("GET", "/crl", h.CRL)
+	r.MethodFunc("GET", "/crl/delta", h.DeltaCRL)
+	r.MethodFunc("GET", "/intermediates/{name}/crl", h.CRL)
+	r.MethodFunc("GET", "/intermediates/{name}/crl/delta", h.DeltaCRL)
+	r.MethodFunc("GET", "/ocsp/*", h.OCSP)
+	r.MethodFunc("POST", "/ocsp", h.OCSP)
+	r.MethodFunc("GET", "/certificates/{serial}/status", h.CertificateStatus)
+	r.MethodFunc("GET", "/certificates/sha256/{sha}/status", h.CertificateStatusByFingerprint)
 	// SSH CA
 	r.MethodFunc("POST", "/ssh/sign", h.SSHSign)
 	r.MethodFunc("POST", "/ssh/renew", h.SSHRenew)
@@ -266,6 +316,7 @@ func (h *caHandler) Route(r Router) {
 	r.MethodFunc("POST", "/ssh/check-host", h.SSHCheckHost)
 	r.MethodFunc("GET", "/ssh/hosts", h.SSHGetHosts)
 	r.MethodFunc("POST", "/ssh/bastion", h.SSHBastion)
+	r.MethodFunc("GET", "/ssh/krl", h.SSHRevocationList)
 
 	// For compatibility with old code:
 	r.MethodFunc("POST", "/re-sign", h.Renew)
@@ -278,13 +329,35 @@ func (h *caHandler) Version(w http.ResponseWriter, r *http.Request) {
 	v := h.Authority.Version()
 	JSON(w, VersionResponse{
 		Version:                     v.Version,
+		Commit:                      v.Commit,
+		BuildTime:                   v.BuildTime,
 		RequireClientAuthentication: v.RequireClientAuthentication,
+		Features: &FeaturesResponse{
+			ACME: v.Features.ACME,
+			SSH:  v.Features.SSH,
+			SCEP: v.Features.SCEP,
+		},
+		KeyTypes: v.KeyTypes,
 	})
 }
 
-// Health is an HTTP handler that returns the status of the server.
+// Health is an HTTP handler that returns the status of the server and its
+// components. The server is alive even if a component fails, so it always
+// responds with 200 OK.
 func (h *caHandler) Health(w http.ResponseWriter, r *http.Request) {
-	JSON(w, HealthResponse{Status: "ok"})
+	s := h.Authority.CheckHealth()
+	JSON(w, HealthResponse{Status: s.Status, Components: s.Components})
+}
+
+// Ready is an HTTP handler that returns the status of the server and its
+// components, it responds with 503 Service Unavailable if a component fails.
+func (h *caHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	s := h.Authority.CheckHealth()
+	status := http.StatusOK
+	if !s.IsHealthy() {
+		status = http.StatusServiceUnavailable
+	}
+	JSONStatus(w, HealthResponse{Status: s.Status, Components: s.Components}, status)
 }
 
 // Root is an HTTP handler that using the SHA256 from the URL, returns the root
@@ -340,42 +413,90 @@ func (h *caHandler) ProvisionerKey(w http.ResponseWriter, r *http.Request) {
 	JSON(w, &ProvisionerKeyResponse{key})
 }
 
-// Roots returns all the root certificates for the CA.
+// Roots returns all the root certificates for the CA, including the upcoming
+// roots of a rotation.
 func (h *caHandler) Roots(w http.ResponseWriter, r *http.Request) {
+	if err := h.Authority.AuthorizeRoots(verifiedPeerCertificate(r)); err != nil {
+		WriteError(w, errs.UnauthorizedErr(err))
+		return
+	}
+
 	roots, err := h.Authority.GetRoots()
 	if err != nil {
 		WriteError(w, errs.ForbiddenErr(err))
 		return
 	}
-
-	certs := make([]Certificate, len(roots))
-	for i := range roots {
-		certs[i] = Certificate{roots[i]}
+	upcoming, err := h.Authority.GetUpcomingRoots()
+	if err != nil {
+		WriteError(w, errs.ForbiddenErr(err))
+		return
 	}
 
 	JSONStatus(w, &RootsResponse{
-		Certificates: certs,
+		Certificates: append(toCertificates(roots), toCertificates(upcoming)...),
+		Upcoming:     toCertificates(upcoming),
 	}, http.StatusCreated)
 }
 
-// Federation returns all the public certificates in the federation.
+// Federation returns all the public certificates in the federation, including
+// the upcoming roots of a rotation.
 func (h *caHandler) Federation(w http.ResponseWriter, r *http.Request) {
+	if err := h.Authority.AuthorizeRoots(verifiedPeerCertificate(r)); err != nil {
+		WriteError(w, errs.UnauthorizedErr(err))
+		return
+	}
+
 	federated, err := h.Authority.GetFederation()
 	if err != nil {
 		WriteError(w, errs.ForbiddenErr(err))
 		return
 	}
-
-	certs := make([]Certificate, len(federated))
-	for i := range federated {
-		certs[i] = Certificate{federated[i]}
+	upcoming, err := h.Authority.GetUpcomingRoots()
+	if err != nil {
+		WriteError(w, errs.ForbiddenErr(err))
+		return
 	}
 
 	JSONStatus(w, &FederationResponse{
-		Certificates: certs,
+		Certificates: toCertificates(federated),
+		Upcoming:     toCertificates(upcoming),
 	}, http.StatusCreated)
 }
 
+// toCertificates converts a slice of x509 certificates to a slice of the
+// Certificate type used in the responses.
+func toCertificates(crts []*x509.Certificate) []Certificate {
+	certs := make([]Certificate, len(crts))
+	for i := range crts {
+		certs[i] = Certificate{crts[i]}
+	}
+	return certs
+}
+
+// verifiedPeerCertificate returns the client certificate of the request if it
+// has been verified by the TLS server, or nil otherwise.
+func verifiedPeerCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// Intermediates returns the intermediate certificate used to sign new
+// certificates followed by the previous intermediates that are still valid
+// issuers of existing certificates.
+func (h *caHandler) Intermediates(w http.ResponseWriter, r *http.Request) {
+	intermediates := h.Authority.GetIntermediateCertificates()
+	certs := make([]Certificate, len(intermediates))
+	for i := range intermediates {
+		certs[i] = Certificate{intermediates[i]}
+	}
+
+	JSON(w, &IntermediatesResponse{
+		Certificates: certs,
+	})
+}
+
 var oidStepProvisioner = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 37476, 9000, 64, 1}
 
 type stepProvisioner struct {
@@ -430,7 +551,6 @@ func parseCursor(r *http.Request) (cursor string, limit int, err error) {
 	return
 }
 
-// TODO: add support for Ed25519 once it's supported
 func fmtPublicKey(cert *x509.Certificate) string {
 	var params string
 	switch pk := cert.PublicKey.(type) {
@@ -440,6 +560,8 @@ func fmtPublicKey(cert *x509.Certificate) string {
 		params = strconv.Itoa(pk.Size() * 8)
 	case *dsa.PublicKey:
 		params = strconv.Itoa(pk.Q.BitLen() * 8)
+	case ed25519.PublicKey:
+		return cert.PublicKeyAlgorithm.String()
 	default:
 		params = "unknown"
 	}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/dsa"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
@@ -547,9 +548,11 @@ type mockAuthority struct {
 	ret1, ret2                   interface{}
 	err                          error
 	authorizeSign                func(ott string) ([]provisioner.SignOption, error)
+	authorizeSignBatch           func(ott string) ([]provisioner.SignOption, error)
 	getTLSOptions                func() *tlsutil.TLSOptions
 	root                         func(shasum string) (*x509.Certificate, error)
 	sign                         func(cr *x509.CertificateRequest, opts provisioner.Options, signOpts ...provisioner.SignOption) ([]*x509.Certificate, error)
+	rekey                        func(cert *x509.Certificate, csr *x509.CertificateRequest) ([]*x509.Certificate, error)
 	renew                        func(cert *x509.Certificate) ([]*x509.Certificate, error)
 	loadProvisionerByCertificate func(cert *x509.Certificate) (provisioner.Interface, error)
 	loadProvisionerByID          func(provID string) (provisioner.Interface, error)
@@ -558,6 +561,14 @@ type mockAuthority struct {
 	getEncryptedKey              func(kid string) (string, error)
 	getRoots                     func() ([]*x509.Certificate, error)
 	getFederation                func() ([]*x509.Certificate, error)
+	getUpcomingRoots             func() ([]*x509.Certificate, error)
+	authorizeRoots               func(peer *x509.Certificate) error
+	getIntermediates             func() []*x509.Certificate
+	getCRL                       func(name string) ([]byte, error)
+	getDeltaCRL                  func(name string) ([]byte, error)
+	getOCSPResponse              func(req []byte) ([]byte, error)
+	getCertificateStatus         func(serialNumber string) (*authority.CertificateStatus, error)
+	getCertificateStatusByFP     func(fingerprint string) (*authority.CertificateStatus, error)
 	signSSH                      func(ctx context.Context, key ssh.PublicKey, opts provisioner.SSHOptions, signOpts ...provisioner.SignOption) (*ssh.Certificate, error)
 	signSSHAddUser               func(ctx context.Context, key ssh.PublicKey, cert *ssh.Certificate) (*ssh.Certificate, error)
 	renewSSH                     func(ctx context.Context, cert *ssh.Certificate) (*ssh.Certificate, error)
@@ -568,7 +579,9 @@ type mockAuthority struct {
 	getSSHConfig                 func(ctx context.Context, typ string, data map[string]string) ([]templates.Output, error)
 	checkSSHHost                 func(ctx context.Context, principal, token string) (bool, error)
 	getSSHBastion                func(ctx context.Context, user string, hostname string) (*authority.Bastion, error)
+	getSSHRevocationList         func(ctx context.Context) ([]byte, error)
 	version                      func() authority.Version
+	checkHealth                  func() *authority.HealthStatus
 }
 
 // TODO: remove once Authorize is deprecated.
@@ -583,6 +596,13 @@ func (m *mockAuthority) AuthorizeSign(ott string) ([]provisioner.SignOption, err
 	return m.ret1.([]provisioner.SignOption), m.err
 }
 
+func (m *mockAuthority) AuthorizeSignBatch(ctx context.Context, ott string) ([]provisioner.SignOption, error) {
+	if m.authorizeSignBatch != nil {
+		return m.authorizeSignBatch(ott)
+	}
+	return m.ret1.([]provisioner.SignOption), m.err
+}
+
 func (m *mockAuthority) GetTLSOptions() *tlsutil.TLSOptions {
 	if m.getTLSOptions != nil {
 		return m.getTLSOptions()
@@ -597,20 +617,27 @@ func (m *mockAuthority) Root(shasum string) (*x509.Certificate, error) {
 	return m.ret1.(*x509.Certificate), m.err
 }
 
-func (m *mockAuthority) Sign(cr *x509.CertificateRequest, opts provisioner.Options, signOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+func (m *mockAuthority) SignWithContext(ctx context.Context, cr *x509.CertificateRequest, opts provisioner.Options, signOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
 	if m.sign != nil {
 		return m.sign(cr, opts, signOpts...)
 	}
 	return []*x509.Certificate{m.ret1.(*x509.Certificate), m.ret2.(*x509.Certificate)}, m.err
 }
 
-func (m *mockAuthority) Renew(cert *x509.Certificate) ([]*x509.Certificate, error) {
+func (m *mockAuthority) RenewContext(ctx context.Context, cert *x509.Certificate) ([]*x509.Certificate, error) {
 	if m.renew != nil {
 		return m.renew(cert)
 	}
 	return []*x509.Certificate{m.ret1.(*x509.Certificate), m.ret2.(*x509.Certificate)}, m.err
 }
 
+func (m *mockAuthority) RekeyCSR(ctx context.Context, cert *x509.Certificate, csr *x509.CertificateRequest) ([]*x509.Certificate, error) {
+	if m.rekey != nil {
+		return m.rekey(cert, csr)
+	}
+	return []*x509.Certificate{m.ret1.(*x509.Certificate), m.ret2.(*x509.Certificate)}, m.err
+}
+
 func (m *mockAuthority) GetProvisioners(nextCursor string, limit int) (provisioner.List, string, error) {
 	if m.getProvisioners != nil {
 		return m.getProvisioners(nextCursor, limit)
@@ -660,6 +687,27 @@ func (m *mockAuthority) GetFederation() ([]*x509.Certificate, error) {
 	return m.ret1.([]*x509.Certificate), m.err
 }
 
+func (m *mockAuthority) GetUpcomingRoots() ([]*x509.Certificate, error) {
+	if m.getUpcomingRoots != nil {
+		return m.getUpcomingRoots()
+	}
+	return nil, nil
+}
+
+func (m *mockAuthority) AuthorizeRoots(peer *x509.Certificate) error {
+	if m.authorizeRoots != nil {
+		return m.authorizeRoots(peer)
+	}
+	return nil
+}
+
+func (m *mockAuthority) GetIntermediateCertificates() []*x509.Certificate {
+	if m.getIntermediates != nil {
+		return m.getIntermediates()
+	}
+	return m.ret1.([]*x509.Certificate)
+}
+
 func (m *mockAuthority) SignSSH(ctx context.Context, key ssh.PublicKey, opts provisioner.SSHOptions, signOpts ...provisioner.SignOption) (*ssh.Certificate, error) {
 	if m.signSSH != nil {
 		return m.signSSH(ctx, key, opts, signOpts...)
@@ -730,6 +778,48 @@ func (m *mockAuthority) GetSSHBastion(ctx context.Context, user string, hostname
 	return m.ret1.(*authority.Bastion), m.err
 }
 
+func (m *mockAuthority) GetCertificateRevocationList(name string) ([]byte, error) {
+	if m.getCRL != nil {
+		return m.getCRL(name)
+	}
+	return m.ret1.([]byte), m.err
+}
+
+func (m *mockAuthority) GetDeltaCertificateRevocationList(name string) ([]byte, error) {
+	if m.getDeltaCRL != nil {
+		return m.getDeltaCRL(name)
+	}
+	return m.ret1.([]byte), m.err
+}
+
+func (m *mockAuthority) GetOCSPResponse(req []byte) ([]byte, error) {
+	if m.getOCSPResponse != nil {
+		return m.getOCSPResponse(req)
+	}
+	return m.ret1.([]byte), m.err
+}
+
+func (m *mockAuthority) GetCertificateStatus(serialNumber string) (*authority.CertificateStatus, error) {
+	if m.getCertificateStatus != nil {
+		return m.getCertificateStatus(serialNumber)
+	}
+	return m.ret1.(*authority.CertificateStatus), m.err
+}
+
+func (m *mockAuthority) GetCertificateStatusByFingerprint(fingerprint string) (*authority.CertificateStatus, error) {
+	if m.getCertificateStatusByFP != nil {
+		return m.getCertificateStatusByFP(fingerprint)
+	}
+	return m.ret1.(*authority.CertificateStatus), m.err
+}
+
+func (m *mockAuthority) GetSSHRevocationList(ctx context.Context) ([]byte, error) {
+	if m.getSSHRevocationList != nil {
+		return m.getSSHRevocationList(ctx)
+	}
+	return m.ret1.([]byte), m.err
+}
+
 func (m *mockAuthority) Version() authority.Version {
 	if m.version != nil {
 		return m.version()
@@ -737,6 +827,13 @@ func (m *mockAuthority) Version() authority.Version {
 	return m.ret1.(authority.Version)
 }
 
+func (m *mockAuthority) CheckHealth() *authority.HealthStatus {
+	if m.checkHealth != nil {
+		return m.checkHealth()
+	}
+	return &authority.HealthStatus{Status: authority.HealthOK}
+}
+
 func Test_caHandler_Route(t *testing.T) {
 	type fields struct {
 		Authority Authority
@@ -761,6 +858,36 @@ func Test_caHandler_Route(t *testing.T) {
 	}
 }
 
+func Test_caHandler_Version(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com/version", nil)
+	w := httptest.NewRecorder()
+	h := New(&mockAuthority{version: func() authority.Version {
+		return authority.Version{
+			Version:   "1.2.3",
+			Commit:    "abcdef0",
+			BuildTime: "2021-01-01 00:00 UTC",
+			Features:  authority.Features{ACME: true},
+			KeyTypes:  []string{"EC", "RSA", "OKP"},
+		}
+	}}).(*caHandler)
+	h.Version(w, req)
+
+	res := w.Result()
+	if res.StatusCode != 200 {
+		t.Errorf("caHandler.Version StatusCode = %d, wants 200", res.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Errorf("caHandler.Version unexpected error = %v", err)
+	}
+	expected := []byte(`{"version":"1.2.3","commit":"abcdef0","buildTime":"2021-01-01 00:00 UTC","features":{"acme":true,"ssh":false,"scep":false},"keyTypes":["EC","RSA","OKP"]}` + "\n")
+	if !bytes.Equal(body, expected) {
+		t.Errorf("caHandler.Version Body = %s, wants %s", body, expected)
+	}
+}
+
 func Test_caHandler_Health(t *testing.T) {
 	req := httptest.NewRequest("GET", "http://example.com/health", nil)
 	w := httptest.NewRecorder()
@@ -783,6 +910,53 @@ func Test_caHandler_Health(t *testing.T) {
 	}
 }
 
+func Test_caHandler_Ready(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     *authority.HealthStatus
+		statusCode int
+		expected   []byte
+	}{
+		{"ok", &authority.HealthStatus{Status: authority.HealthOK, Components: map[string]*authority.ComponentHealth{
+			"database": {Status: authority.HealthOK},
+			"signer":   {Status: authority.HealthOK},
+		}}, 200, []byte(`{"status":"ok","components":{"database":{"status":"ok"},"signer":{"status":"ok"}}}`)},
+		{"ok/warning", &authority.HealthStatus{Status: authority.HealthOK, Components: map[string]*authority.ComponentHealth{
+			"intermediates": {Status: authority.HealthWarning, Message: "intermediate CA expires soon"},
+		}}, 200, []byte(`{"status":"ok","components":{"intermediates":{"status":"warning","message":"intermediate CA expires soon"}}}`)},
+		{"fail", &authority.HealthStatus{Status: authority.HealthError, Components: map[string]*authority.ComponentHealth{
+			"database": {Status: authority.HealthError, Message: "error writing to the database"},
+		}}, 503, []byte(`{"status":"error","components":{"database":{"status":"error","message":"error writing to the database"}}}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New(&mockAuthority{checkHealth: func() *authority.HealthStatus {
+				return tt.status
+			}}).(*caHandler)
+
+			// Ready fails if a component fails.
+			w := httptest.NewRecorder()
+			h.Ready(w, httptest.NewRequest("GET", "http://example.com/ready", nil))
+			if w.Code != tt.statusCode {
+				t.Errorf("caHandler.Ready StatusCode = %d, wants %d", w.Code, tt.statusCode)
+			}
+			if body := bytes.TrimSpace(w.Body.Bytes()); !bytes.Equal(body, tt.expected) {
+				t.Errorf("caHandler.Ready Body = %s, wants %s", body, tt.expected)
+			}
+
+			// Health always succeeds.
+			w = httptest.NewRecorder()
+			h.Health(w, httptest.NewRequest("GET", "http://example.com/health", nil))
+			if w.Code != 200 {
+				t.Errorf("caHandler.Health StatusCode = %d, wants 200", w.Code)
+			}
+			if body := bytes.TrimSpace(w.Body.Bytes()); !bytes.Equal(body, tt.expected) {
+				t.Errorf("caHandler.Health Body = %s, wants %s", body, tt.expected)
+			}
+		})
+	}
+}
+
 func Test_caHandler_Root(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -1208,6 +1382,104 @@ func Test_caHandler_Federation(t *testing.T) {
 	}
 }
 
+func Test_caHandler_Roots_upcoming(t *testing.T) {
+	root, upcoming := parseCertificate(rootPEM), parseCertificate(certPEM)
+	verified := &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{upcoming},
+		VerifiedChains:   [][]*x509.Certificate{{upcoming, root}},
+	}
+	pem := func(s string) string {
+		return `"` + strings.Replace(s, "\n", `\n`, -1) + `\n"`
+	}
+	tests := []struct {
+		name       string
+		tls        *tls.ConnectionState
+		federation bool
+		statusCode int
+		expected   []byte
+	}{
+		{"ok roots", verified, false, http.StatusCreated, []byte(`{"crts":[` + pem(rootPEM) + `,` + pem(certPEM) + `],"upcoming":[` + pem(certPEM) + `]}`)},
+		{"ok federation", verified, true, http.StatusCreated, []byte(`{"crts":[` + pem(rootPEM) + `],"upcoming":[` + pem(certPEM) + `]}`)},
+		{"fail roots", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{upcoming}}, false, http.StatusUnauthorized, nil},
+		{"fail federation", nil, true, http.StatusUnauthorized, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New(&mockAuthority{
+				ret1: []*x509.Certificate{root},
+				getUpcomingRoots: func() ([]*x509.Certificate, error) {
+					return []*x509.Certificate{upcoming}, nil
+				},
+				authorizeRoots: func(peer *x509.Certificate) error {
+					if peer == nil {
+						return errs.Unauthorized("a valid client certificate is required")
+					}
+					return nil
+				},
+			}).(*caHandler)
+			req := httptest.NewRequest("GET", "http://example.com/roots", nil)
+			req.TLS = tt.tls
+			w := httptest.NewRecorder()
+			if tt.federation {
+				h.Federation(w, req)
+			} else {
+				h.Roots(w, req)
+			}
+			res := w.Result()
+
+			if res.StatusCode != tt.statusCode {
+				t.Errorf("caHandler.Roots StatusCode = %d, wants %d", res.StatusCode, tt.statusCode)
+			}
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil {
+				t.Errorf("caHandler.Roots unexpected error = %v", err)
+			}
+			if tt.statusCode < http.StatusBadRequest {
+				if !bytes.Equal(bytes.TrimSpace(body), tt.expected) {
+					t.Errorf("caHandler.Roots Body = %s, wants %s", body, tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func Test_caHandler_Intermediates(t *testing.T) {
+	tests := []struct {
+		name     string
+		certs    []*x509.Certificate
+		expected []byte
+	}{
+		{"ok", []*x509.Certificate{parseCertificate(rootPEM)}, []byte(`{"crts":["` + strings.Replace(rootPEM, "\n", `\n`, -1) + `\n"]}`)},
+		{"ok multiple", []*x509.Certificate{parseCertificate(rootPEM), parseCertificate(certPEM)},
+			[]byte(`{"crts":["` + strings.Replace(rootPEM, "\n", `\n`, -1) + `\n","` + strings.Replace(certPEM, "\n", `\n`, -1) + `\n"]}`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New(&mockAuthority{ret1: tt.certs}).(*caHandler)
+			req := httptest.NewRequest("GET", "http://example.com/intermediates", nil)
+			w := httptest.NewRecorder()
+			h.Intermediates(w, req)
+			res := w.Result()
+
+			if res.StatusCode != http.StatusOK {
+				t.Errorf("caHandler.Intermediates StatusCode = %d, wants %d", res.StatusCode, http.StatusOK)
+			}
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil {
+				t.Errorf("caHandler.Intermediates unexpected error = %v", err)
+			}
+			if !bytes.Equal(bytes.TrimSpace(body), tt.expected) {
+				t.Errorf("caHandler.Intermediates Body = %s, wants %s", body, tt.expected)
+			}
+		})
+	}
+}
+
 func Test_fmtPublicKey(t *testing.T) {
 	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
@@ -1217,6 +1489,10 @@ func Test_fmtPublicKey(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
 	var dsa2048 dsa.PrivateKey
 	if err := dsa.GenerateParameters(&dsa2048.Parameters, rand.Reader, dsa.L2048N256); err != nil {
 		t.Fatal(err)
@@ -1236,6 +1512,7 @@ func Test_fmtPublicKey(t *testing.T) {
 	}{
 		{"p256", args{p256.Public(), p256, nil}, "ECDSA P-256"},
 		{"rsa1024", args{rsa1024.Public(), rsa1024, nil}, "RSA 1024"},
+		{"ed25519", args{edPub, edPriv, nil}, "Ed25519"},
 		{"dsa2048", args{cert: &x509.Certificate{PublicKeyAlgorithm: x509.DSA, PublicKey: &dsa2048.PublicKey}}, "DSA 2048"},
 		{"unknown", args{cert: &x509.Certificate{PublicKeyAlgorithm: x509.ECDSA, PublicKey: []byte("12345678")}}, "ECDSA unknown"},
 	}
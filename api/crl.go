@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+)
+
+// CRL is an HTTP handler that returns the current certificate revocation list
+// in DER format of the intermediate in the url, or of the default one.
+func (h *caHandler) CRL(w http.ResponseWriter, r *http.Request) {
+	crl, err := h.Authority.GetCertificateRevocationList(chi.URLParam(r, "name"))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	w.Write(crl)
+}
+
+// DeltaCRL is an HTTP handler that returns the current delta certificate
+// revocation list in DER format of the intermediate in the url, or of the
+// default one.
+func (h *caHandler) DeltaCRL(w http.ResponseWriter, r *http.Request) {
+	crl, err := h.Authority.GetDeltaCertificateRevocationList(chi.URLParam(r, "name"))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	w.Write(crl)
+}
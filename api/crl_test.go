@@ -0,0 +1,79 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/certificates/logging"
+)
+
+func Test_caHandler_CRL(t *testing.T) {
+	tests := []struct {
+		name       string
+		delta      bool
+		issuer     string
+		crl        []byte
+		crlErr     error
+		statusCode int
+	}{
+		{"ok", false, "", []byte("crl"), nil, http.StatusOK},
+		{"ok delta", true, "", []byte("delta"), nil, http.StatusOK},
+		{"ok intermediate", false, "bu", []byte("bu crl"), nil, http.StatusOK},
+		{"ok intermediate delta", true, "bu", []byte("bu delta"), nil, http.StatusOK},
+		{"not found", false, "", nil, errs.NotFound("crl is not enabled"), http.StatusNotFound},
+		{"delta not found", true, "", nil, errs.NotFound("delta crl is not enabled"), http.StatusNotFound},
+		{"intermediate not found", false, "foo", nil, errs.NotFound("intermediate foo was not found"), http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn := func(name string) ([]byte, error) {
+				if name != tt.issuer {
+					t.Errorf("caHandler.CRL name = %q, wants %q", name, tt.issuer)
+				}
+				return tt.crl, tt.crlErr
+			}
+			mock := &mockAuthority{getCRL: fn}
+			if tt.delta {
+				mock = &mockAuthority{getDeltaCRL: fn}
+			}
+			h := New(mock).(*caHandler)
+
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add("name", tt.issuer)
+			req := httptest.NewRequest("GET", "http://example.com/crl", nil)
+			req = req.WithContext(context.WithValue(context.Background(), chi.RouteCtxKey, chiCtx))
+
+			w := httptest.NewRecorder()
+			if tt.delta {
+				h.DeltaCRL(logging.NewResponseLogger(w), req)
+			} else {
+				h.CRL(logging.NewResponseLogger(w), req)
+			}
+			res := w.Result()
+
+			if res.StatusCode != tt.statusCode {
+				t.Errorf("caHandler.CRL StatusCode = %d, wants %d", res.StatusCode, tt.statusCode)
+			}
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil {
+				t.Errorf("caHandler.CRL unexpected error = %v", err)
+			}
+			if tt.statusCode < http.StatusBadRequest {
+				if !bytes.Equal(body, tt.crl) {
+					t.Errorf("caHandler.CRL Body = %q, wants %q", body, tt.crl)
+				}
+				if ct := res.Header.Get("Content-Type"); ct != "application/pkix-crl" {
+					t.Errorf("caHandler.CRL Content-Type = %s, wants application/pkix-crl", ct)
+				}
+			}
+		})
+	}
+}
@@ -3,8 +3,10 @@ package api
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
 	"os"
+	"strconv"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/acme"
@@ -17,6 +19,9 @@ func WriteError(w http.ResponseWriter, err error) {
 	switch k := err.(type) {
 	case *acme.Error:
 		w.Header().Set("Content-Type", "application/problem+json")
+		if k.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(k.RetryAfter.Seconds()))))
+		}
 		err = k.ToACME()
 	default:
 		w.Header().Set("Content-Type", "application/json")
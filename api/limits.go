@@ -0,0 +1,122 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// ErrBodyTooLarge is the error returned when reading a request body larger
+// than the limit set by LimitBody.
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// LimitBody is an HTTP middleware that limits the size of the request bodies
+// to maxSize bytes, and the nesting depth of the JSON documents in them to
+// maxDepth.
+func LimitBody(next http.Handler, maxSize int64, maxDepth int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = &limitedBody{
+				ReadCloser: r.Body,
+				remaining:  maxSize,
+				maxDepth:   maxDepth,
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitedBody is a request body that returns ErrBodyTooLarge after reading
+// more than the remaining bytes.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	maxDepth  int
+	err       error
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	// Read one more byte to know if the body is larger than the limit.
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remaining {
+		b.err = ErrBodyTooLarge
+		return int(b.remaining), b.err
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// PeekBody reads up to n bytes of the request body and puts them back, so the
+// handlers can read the whole body again. A body limited by LimitBody keeps
+// its limits, and the bytes peeked are only counted once.
+func PeekBody(r *http.Request, n int64) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, n))
+	if lb, ok := r.Body.(*limitedBody); ok {
+		lb.ReadCloser = readCloser{io.MultiReader(bytes.NewReader(b), lb.ReadCloser), lb.ReadCloser}
+		// A body that exceeded the limit keeps returning ErrBodyTooLarge.
+		lb.remaining += int64(len(b))
+	} else {
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(b), r.Body), r.Body}
+	}
+	return b, err
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// JSONDepthLimit returns the maximum nesting depth of the JSON documents in a
+// request body limited by LimitBody, or 0 if the body is not limited.
+func JSONDepthLimit(body io.Reader) int {
+	if b, ok := body.(*limitedBody); ok {
+		return b.maxDepth
+	}
+	return 0
+}
+
+// CheckJSONDepth returns an error if the objects and arrays in the JSON
+// document are nested deeper than maxDepth. A maxDepth of 0 disables the
+// check. The document is not validated, invalid JSON fails later when it's
+// decoded.
+func CheckJSONDepth(data []byte, maxDepth int) error {
+	if maxDepth <= 0 {
+		return nil
+	}
+	var depth int
+	var inString, escaped bool
+	for _, c := range data {
+		switch {
+		case escaped:
+			escaped = false
+		case inString:
+			switch c {
+			case '\\':
+				escaped = true
+			case '"':
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '{' || c == '[':
+			if depth++; depth > maxDepth {
+				return errors.Errorf("json document exceeds the maximum depth of %d", maxDepth)
+			}
+		case c == '}' || c == ']':
+			depth--
+		}
+	}
+	return nil
+}
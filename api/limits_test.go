@@ -0,0 +1,128 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/errs"
+)
+
+func TestCheckJSONDepth(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		maxDepth int
+		wantErr  bool
+	}{
+		{"ok", `{"a":[1,{"b":2}]}`, 3, false},
+		{"ok disabled", `[[[[[[]]]]]]`, 0, false},
+		{"ok brackets in strings", `{"a":"[[[{{{","b":"\"[[["}`, 1, false},
+		{"ok escaped backslash", `{"a":"\\","b":1}`, 1, false},
+		{"ok not json", `foo`, 1, false},
+		{"fail object", `{"a":{"b":{"c":1}}}`, 2, true},
+		{"fail array", `[[[]]]`, 2, true},
+		{"fail after escaped backslash", `{"a":"\\",{"b":{}}}`, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckJSONDepth([]byte(tt.data), tt.maxDepth); (err != nil) != tt.wantErr {
+				t.Errorf("CheckJSONDepth() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLimitBody(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		maxSize    int64
+		maxDepth   int
+		statusCode int
+	}{
+		{"ok", `{"ott":"token"}`, 15, 1, http.StatusOK},
+		{"fail size", `{"ott":"token"}`, 14, 1, http.StatusRequestEntityTooLarge},
+		{"fail depth", `{"ott":{"a":"b"}}`, 100, 1, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := LimitBody(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var v interface{}
+				if err := ReadJSON(r.Body, &v); err != nil {
+					WriteError(w, errs.Wrap(http.StatusBadRequest, err, "error reading request body"))
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}), tt.maxSize, tt.maxDepth)
+			req := httptest.NewRequest("POST", "/sign", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			assert.Equals(t, tt.statusCode, w.Code)
+		})
+	}
+}
+
+func TestLimitBody_read(t *testing.T) {
+	h := LimitBody(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		assert.Equals(t, ErrBodyTooLarge, err)
+		assert.Equals(t, "aaaa", string(b))
+		assert.Equals(t, 4, JSONDepthLimit(r.Body))
+	}), 4, 4)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/sign", strings.NewReader("aaaaa")))
+	assert.Equals(t, 0, JSONDepthLimit(strings.NewReader("")))
+}
+
+func TestPeekBody(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		maxSize    int64
+		maxDepth   int
+		peek       int64
+		wantPeek   string
+		statusCode int
+	}{
+		{"ok", `{"ott":"token"}`, 15, 1, 100, `{"ott":"token"}`, http.StatusOK},
+		{"ok partial", `{"ott":"token"}`, 15, 1, 7, `{"ott":`, http.StatusOK},
+		{"fail size", `{"ott":"token"}`, 14, 1, 100, `{"ott":"token"`, http.StatusRequestEntityTooLarge},
+		{"fail depth", `{"ott":{"a":"b"}}`, 100, 1, 100, `{"ott":{"a":"b"}}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := LimitBody(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := PeekBody(r, tt.peek)
+				assert.Equals(t, tt.wantPeek, string(b))
+				assert.Equals(t, tt.maxDepth, JSONDepthLimit(r.Body))
+				var v interface{}
+				if err := ReadJSON(r.Body, &v); err != nil {
+					WriteError(w, err)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}), tt.maxSize, tt.maxDepth)
+			req := httptest.NewRequest("POST", "/sign", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			assert.Equals(t, tt.statusCode, w.Code)
+		})
+	}
+
+	// Bodies that are not limited are restored too.
+	req := httptest.NewRequest("POST", "/sign", strings.NewReader("body"))
+	b, err := PeekBody(req, 2)
+	assert.FatalError(t, err)
+	assert.Equals(t, "bo", string(b))
+	b, err = ioutil.ReadAll(req.Body)
+	assert.FatalError(t, err)
+	assert.Equals(t, "body", string(b))
+
+	req = httptest.NewRequest("GET", "/sign", nil)
+	b, err = PeekBody(req, 2)
+	assert.FatalError(t, err)
+	assert.Len(t, 0, b)
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/certificates/errs"
+	"golang.org/x/crypto/ocsp"
+)
+
+// maxOCSPRequestSize is the maximum size of a DER encoded OCSP request.
+const maxOCSPRequestSize = 10 * 1024
+
+// OCSP is an HTTP handler that returns the OCSP response to a DER encoded
+// OCSP request, sent in the body of a POST request or base64 encoded in the
+// path of a GET request, see RFC 6960, appendix A.1.
+//
+// Errors, except if the responder is not enabled, are returned as OCSP error
+// responses.
+func (h *caHandler) OCSP(w http.ResponseWriter, r *http.Request) {
+	var req []byte
+	var err error
+	if r.Method == http.MethodGet {
+		var s string
+		if s, err = url.PathUnescape(chi.URLParam(r, "*")); err == nil {
+			req, err = base64.StdEncoding.DecodeString(s)
+		}
+	} else {
+		req, err = ioutil.ReadAll(io.LimitReader(r.Body, maxOCSPRequestSize))
+	}
+	if err != nil {
+		LogError(w, err)
+		writeOCSPResponse(w, ocsp.MalformedRequestErrorResponse)
+		return
+	}
+
+	resp, err := h.Authority.GetOCSPResponse(req)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if sc, ok := err.(errs.StatusCoder); ok {
+			code = sc.StatusCode()
+		}
+		switch code {
+		case http.StatusNotFound:
+			WriteError(w, err)
+			return
+		case http.StatusBadRequest:
+			resp = ocsp.MalformedRequestErrorResponse
+		case http.StatusUnauthorized:
+			resp = ocsp.UnauthorizedErrorResponse
+		default:
+			resp = ocsp.InternalErrorErrorResponse
+		}
+		LogError(w, err)
+	}
+	writeOCSPResponse(w, resp)
+}
+
+func writeOCSPResponse(w http.ResponseWriter, resp []byte) {
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Write(resp)
+}
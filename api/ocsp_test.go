@@ -0,0 +1,70 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/certificates/errs"
+	"golang.org/x/crypto/ocsp"
+)
+
+func Test_caHandler_OCSP(t *testing.T) {
+	req := []byte("ocsp/request+")
+	get := "/ocsp/" + url.PathEscape(base64.StdEncoding.EncodeToString(req))
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		resp       []byte
+		err        error
+		statusCode int
+		want       []byte
+	}{
+		{"ok post", "POST", "/ocsp", []byte("response"), nil, http.StatusOK, []byte("response")},
+		{"ok get", "GET", get, []byte("response"), nil, http.StatusOK, []byte("response")},
+		{"malformed get", "GET", "/ocsp/%25%25", nil, nil, http.StatusOK, ocsp.MalformedRequestErrorResponse},
+		{"malformed", "POST", "/ocsp", nil, errs.BadRequest("bad request"), http.StatusOK, ocsp.MalformedRequestErrorResponse},
+		{"unauthorized", "POST", "/ocsp", nil, errs.Unauthorized("issuer not found"), http.StatusOK, ocsp.UnauthorizedErrorResponse},
+		{"internal", "POST", "/ocsp", nil, errs.InternalServer("force"), http.StatusOK, ocsp.InternalErrorErrorResponse},
+		{"not found", "POST", "/ocsp", nil, errs.NotFound("ocsp is not enabled"), http.StatusNotFound, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := chi.NewRouter()
+			New(&mockAuthority{getOCSPResponse: func(b []byte) ([]byte, error) {
+				if !bytes.Equal(b, req) {
+					t.Errorf("caHandler.OCSP request = %q, wants %q", b, req)
+				}
+				return tt.resp, tt.err
+			}}).Route(mux)
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(tt.method, "http://example.com"+tt.target, bytes.NewReader(req)))
+			res := w.Result()
+
+			if res.StatusCode != tt.statusCode {
+				t.Errorf("caHandler.OCSP StatusCode = %d, wants %d", res.StatusCode, tt.statusCode)
+			}
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil {
+				t.Errorf("caHandler.OCSP unexpected error = %v", err)
+			}
+			if tt.statusCode < http.StatusBadRequest {
+				if !bytes.Equal(body, tt.want) {
+					t.Errorf("caHandler.OCSP Body = %q, wants %q", body, tt.want)
+				}
+				if ct := res.Header.Get("Content-Type"); ct != "application/ocsp-response" {
+					t.Errorf("caHandler.OCSP Content-Type = %s, wants application/ocsp-response", ct)
+				}
+			}
+		})
+	}
+}
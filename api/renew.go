@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/certificates/tracing"
 )
 
 // Renew uses the information of certificate in the TLS connection to create a
@@ -14,7 +15,10 @@ func (h *caHandler) Renew(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	certChain, err := h.Authority.Renew(r.TLS.PeerCertificates[0])
+	ctx, span := tracing.Start(r.Context(), "authority.Renew")
+	certChain, err := h.Authority.RenewContext(ctx, r.TLS.PeerCertificates[0])
+	span.SetError(err)
+	span.End()
 	if err != nil {
 		WriteError(w, errs.Wrap(http.StatusInternalServerError, err, "cahandler.Renew"))
 		return
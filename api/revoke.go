@@ -1,7 +1,6 @@
 package api
 
 import (
-	"context"
 	"net/http"
 
 	"github.com/smallstep/certificates/authority"
@@ -34,8 +33,9 @@ func (r *RevokeRequest) Validate() (err error) {
 	if r.ReasonCode < ocsp.Unspecified || r.ReasonCode > ocsp.AACompromise {
 		return errs.BadRequest("reasonCode out of bounds")
 	}
-	if !r.Passive {
-		return errs.NotImplemented("non-passive revocation not implemented")
+	// The value 7 is not used, see RFC 5280, section 5.3.1.
+	if r.ReasonCode == 7 {
+		return errs.BadRequest("reasonCode 7 is not valid")
 	}
 
 	return
@@ -43,9 +43,9 @@ func (r *RevokeRequest) Validate() (err error) {
 
 // Revoke supports handful of different methods that revoke a Certificate.
 //
-// NOTE: currently only Passive revocation is supported.
-//
-// TODO: Add CRL and OCSP support.
+// The certificate is revoked by serial number, using a provisioner token or
+// the certificate itself over mTLS. Once revoked, the certificate cannot be
+// renewed, and it's included in the CRL and the OCSP responses of the CA.
 func (h *caHandler) Revoke(w http.ResponseWriter, r *http.Request) {
 	var body RevokeRequest
 	if err := ReadJSON(r.Body, &body); err != nil {
@@ -65,7 +65,7 @@ func (h *caHandler) Revoke(w http.ResponseWriter, r *http.Request) {
 		PassiveOnly: body.Passive,
 	}
 
-	ctx := provisioner.NewContextWithMethod(context.Background(), provisioner.RevokeMethod)
+	ctx := provisioner.NewContextWithMethod(r.Context(), provisioner.RevokeMethod)
 	// A token indicates that we are using the api via a provisioner token,
 	// otherwise it is assumed that the certificate is revoking itself over mTLS.
 	if len(body.OTT) > 0 {
@@ -38,13 +38,19 @@ func TestRevokeRequestValidate(t *testing.T) {
 			},
 			err: &errs.Error{Err: errors.New("reasonCode out of bounds"), Status: http.StatusBadRequest},
 		},
-		"error/non-passive not implemented": {
+		"error/unused reasonCode": {
+			rr: &RevokeRequest{
+				Serial:     "sn",
+				ReasonCode: 7,
+			},
+			err: &errs.Error{Err: errors.New("reasonCode 7 is not valid"), Status: http.StatusBadRequest},
+		},
+		"ok/non-passive": {
 			rr: &RevokeRequest{
 				Serial:     "sn",
 				ReasonCode: 8,
 				Passive:    false,
 			},
-			err: &errs.Error{Err: errors.New("non-passive revocation not implemented"), Status: http.StatusNotImplemented},
 		},
 		"ok": {
 			rr: &RevokeRequest{
@@ -2,19 +2,24 @@ package api
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"net/http"
 
 	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/certificates/tracing"
 	"github.com/smallstep/cli/crypto/tlsutil"
 )
 
 // SignRequest is the request body for a certificate signature request.
+// TemplateData is the data sent to the certificate template of the
+// provisioner, it must be valid against the schema configured in the template.
 type SignRequest struct {
-	CsrPEM    CertificateRequest `json:"csr"`
-	OTT       string             `json:"ott"`
-	NotAfter  TimeDuration       `json:"notAfter"`
-	NotBefore TimeDuration       `json:"notBefore"`
+	CsrPEM       CertificateRequest `json:"csr"`
+	OTT          string             `json:"ott"`
+	NotAfter     TimeDuration       `json:"notAfter"`
+	NotBefore    TimeDuration       `json:"notBefore"`
+	TemplateData json.RawMessage    `json:"templateData,omitempty"`
 }
 
 // Validate checks the fields of the SignRequest and returns nil if they are ok
@@ -59,17 +64,24 @@ func (h *caHandler) Sign(w http.ResponseWriter, r *http.Request) {
 	}
 
 	opts := provisioner.Options{
-		NotBefore: body.NotBefore,
-		NotAfter:  body.NotAfter,
+		NotBefore:    body.NotBefore,
+		NotAfter:     body.NotAfter,
+		TemplateData: body.TemplateData,
 	}
 
-	signOpts, err := h.Authority.AuthorizeSign(body.OTT)
+	ctx, span := tracing.Start(r.Context(), "authority.AuthorizeSign")
+	signOpts, err := h.Authority.Authorize(provisioner.NewContextWithMethod(ctx, provisioner.SignMethod), body.OTT)
+	span.SetError(err)
+	span.End()
 	if err != nil {
 		WriteError(w, errs.UnauthorizedErr(err))
 		return
 	}
 
-	certChain, err := h.Authority.Sign(body.CsrPEM.CertificateRequest, opts, signOpts...)
+	ctx, span = tracing.Start(r.Context(), "authority.Sign")
+	certChain, err := h.Authority.SignWithContext(ctx, body.CsrPEM.CertificateRequest, opts, signOpts...)
+	span.SetError(err)
+	span.End()
 	if err != nil {
 		WriteError(w, errs.ForbiddenErr(err))
 		return
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/certificates/logging"
+	"github.com/smallstep/certificates/tracing"
+	"github.com/smallstep/cli/crypto/tlsutil"
+)
+
+// MaxSignBatchSize is the maximum number of certificate requests in a batch.
+const MaxSignBatchSize = 1000
+
+// SignBatchRequest is the request body of a batch of certificate signature
+// requests authorized by a single one-time-token (ott). The certificate
+// requests must use a subset of the names in the token. The validity and
+// template data are used by the requests that do not set their own.
+type SignBatchRequest struct {
+	OTT          string           `json:"ott"`
+	Requests     []SignBatchEntry `json:"requests"`
+	NotAfter     TimeDuration     `json:"notAfter"`
+	NotBefore    TimeDuration     `json:"notBefore"`
+	TemplateData json.RawMessage  `json:"templateData,omitempty"`
+}
+
+// SignBatchEntry is a certificate signature request in a batch.
+type SignBatchEntry struct {
+	CsrPEM       CertificateRequest `json:"csr"`
+	NotAfter     TimeDuration       `json:"notAfter"`
+	NotBefore    TimeDuration       `json:"notBefore"`
+	TemplateData json.RawMessage    `json:"templateData,omitempty"`
+}
+
+// Validate checks the fields of the SignBatchRequest and returns nil if they
+// are ok or an error if something is wrong. The certificate requests are
+// validated independently when they are signed.
+func (s *SignBatchRequest) Validate() error {
+	switch {
+	case s.OTT == "":
+		return errs.BadRequest("missing ott")
+	case len(s.Requests) == 0:
+		return errs.BadRequest("missing requests")
+	case len(s.Requests) > MaxSignBatchSize:
+		return errs.BadRequest("too many requests, the maximum is %d", MaxSignBatchSize)
+	}
+	return nil
+}
+
+// SignBatchResponse is the response object of a batch of certificate
+// signature requests. It contains the result of each request, in the same
+// order.
+type SignBatchResponse struct {
+	Results    []SignBatchResult   `json:"results"`
+	TLSOptions *tlsutil.TLSOptions `json:"tlsOptions,omitempty"`
+}
+
+// SignBatchResult is the result of a certificate signature request in a
+// batch, it contains the certificate chain or the error.
+type SignBatchResult struct {
+	ServerPEM    *Certificate  `json:"crt,omitempty"`
+	CaPEM        *Certificate  `json:"ca,omitempty"`
+	CertChainPEM []Certificate `json:"certChain,omitempty"`
+	Error        *errs.Error   `json:"error,omitempty"`
+}
+
+// SignBatch is an HTTP handler that reads a batch of certificate requests and
+// an one-time-token (ott) from the body and creates a new certificate for each
+// one of them. The token is validated only once, and the result of each
+// request is returned independently.
+func (h *caHandler) SignBatch(w http.ResponseWriter, r *http.Request) {
+	var body SignBatchRequest
+	if err := ReadJSON(r.Body, &body); err != nil {
+		WriteError(w, errs.Wrap(http.StatusBadRequest, err, "error reading request body"))
+		return
+	}
+
+	logOtt(w, body.OTT)
+	if err := body.Validate(); err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	ctx, span := tracing.Start(r.Context(), "authority.AuthorizeSignBatch")
+	signOpts, err := h.Authority.AuthorizeSignBatch(ctx, body.OTT)
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		WriteError(w, errs.UnauthorizedErr(err))
+		return
+	}
+
+	ctx, span = tracing.Start(r.Context(), "authority.Sign")
+	var failed int
+	results := make([]SignBatchResult, len(body.Requests))
+	for i, req := range body.Requests {
+		if results[i].Error = h.signBatchEntry(ctx, &body, &req, &results[i], signOpts); results[i].Error != nil {
+			failed++
+		}
+	}
+	span.End()
+
+	if rl, ok := w.(logging.ResponseLogger); ok {
+		rl.WithFields(map[string]interface{}{
+			"batch-size":   len(results),
+			"batch-failed": failed,
+		})
+	}
+	JSON(w, &SignBatchResponse{
+		Results:    results,
+		TLSOptions: h.Authority.GetTLSOptions(),
+	})
+}
+
+// signBatchEntry signs a certificate request of a batch and stores the
+// certificate chain in the given result.
+func (h *caHandler) signBatchEntry(ctx context.Context, body *SignBatchRequest, req *SignBatchEntry, res *SignBatchResult, signOpts []provisioner.SignOption) *errs.Error {
+	csr := req.CsrPEM.CertificateRequest
+	if csr == nil {
+		return toErrsError(errs.BadRequest("missing csr"))
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return toErrsError(errs.Wrap(http.StatusBadRequest, err, "invalid csr"))
+	}
+
+	opts := provisioner.Options{
+		NotBefore:    body.NotBefore,
+		NotAfter:     body.NotAfter,
+		TemplateData: body.TemplateData,
+	}
+	if !req.NotBefore.IsZero() {
+		opts.NotBefore = req.NotBefore
+	}
+	if !req.NotAfter.IsZero() {
+		opts.NotAfter = req.NotAfter
+	}
+	if len(req.TemplateData) > 0 {
+		opts.TemplateData = req.TemplateData
+	}
+
+	certChain, err := h.Authority.SignWithContext(ctx, csr, opts, signOpts...)
+	if err != nil {
+		return toErrsError(errs.ForbiddenErr(err))
+	}
+	certChainPEM := certChainToPEM(certChain)
+	res.ServerPEM = &certChainPEM[0]
+	if len(certChainPEM) > 1 {
+		res.CaPEM = &certChainPEM[1]
+	}
+	res.CertChainPEM = certChainPEM
+	return nil
+}
+
+// toErrsError returns the given error as an *errs.Error.
+func toErrsError(err error) *errs.Error {
+	if e, ok := err.(*errs.Error); ok {
+		return e
+	}
+	return &errs.Error{Status: http.StatusInternalServerError, Err: err}
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/logging"
+	"github.com/smallstep/cli/crypto/tlsutil"
+)
+
+func TestSignBatchRequest_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     *SignBatchRequest
+		wantErr string
+	}{
+		{"ok", &SignBatchRequest{OTT: "ott", Requests: make([]SignBatchEntry, 2)}, ""},
+		{"fail ott", &SignBatchRequest{Requests: make([]SignBatchEntry, 2)}, "missing ott"},
+		{"fail requests", &SignBatchRequest{OTT: "ott"}, "missing requests"},
+		{"fail too many", &SignBatchRequest{OTT: "ott", Requests: make([]SignBatchEntry, MaxSignBatchSize+1)}, "too many requests, the maximum is 1000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.Validate()
+			if (err != nil) != (tt.wantErr != "") {
+				t.Fatalf("SignBatchRequest.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && err.Error() != tt.wantErr {
+				t.Errorf("SignBatchRequest.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_caHandler_SignBatch(t *testing.T) {
+	csr, err := json.Marshal(CertificateRequest{parseCertificateRequest(csrPEM)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := `{"ott":"foobarzar","notAfter":"24h","requests":[{"csr":` + string(csr) + `},{"csr":` + string(csr) + `,"notAfter":"1h"},{}]}`
+
+	tests := []struct {
+		name       string
+		input      string
+		autherr    error
+		statusCode int
+		results    int
+	}{
+		{"ok", valid, nil, http.StatusOK, 3},
+		{"json read error", "{", nil, http.StatusBadRequest, 0},
+		{"validate error", `{"ott":"foobarzar"}`, nil, http.StatusBadRequest, 0},
+		{"authorize error", valid, fmt.Errorf("an error"), http.StatusUnauthorized, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var notAfters []string
+			h := New(&mockAuthority{
+				authorizeSignBatch: func(ott string) ([]provisioner.SignOption, error) {
+					if ott != "foobarzar" {
+						t.Errorf("caHandler.SignBatch ott = %s, wants foobarzar", ott)
+					}
+					return nil, tt.autherr
+				},
+				sign: func(cr *x509.CertificateRequest, opts provisioner.Options, signOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+					notAfters = append(notAfters, time.Until(opts.NotAfter.Time()).Round(time.Hour).String())
+					if len(notAfters) > 1 {
+						return nil, fmt.Errorf("an error")
+					}
+					return []*x509.Certificate{parseCertificate(certPEM), parseCertificate(rootPEM)}, nil
+				},
+				getTLSOptions: func() *tlsutil.TLSOptions {
+					return nil
+				},
+			}).(*caHandler)
+			req := httptest.NewRequest("POST", "http://example.com/sign/batch", strings.NewReader(tt.input))
+			w := httptest.NewRecorder()
+			h.SignBatch(logging.NewResponseLogger(w), req)
+			res := w.Result()
+
+			if res.StatusCode != tt.statusCode {
+				t.Errorf("caHandler.SignBatch StatusCode = %d, wants %d", res.StatusCode, tt.statusCode)
+			}
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil {
+				t.Errorf("caHandler.SignBatch unexpected error = %v", err)
+			}
+			if tt.statusCode >= http.StatusBadRequest {
+				return
+			}
+
+			var resp SignBatchResponse
+			if err := json.Unmarshal(body, &resp); err != nil {
+				t.Fatalf("caHandler.SignBatch unexpected error = %v", err)
+			}
+			if len(resp.Results) != tt.results {
+				t.Fatalf("caHandler.SignBatch results = %d, wants %d", len(resp.Results), tt.results)
+			}
+			if got := strings.Join(notAfters, ","); got != "24h0m0s,1h0m0s" {
+				t.Errorf("caHandler.SignBatch notAfter = %s, wants 24h0m0s,1h0m0s", got)
+			}
+			if r := resp.Results[0]; r.Error != nil || r.ServerPEM == nil || r.CaPEM == nil || len(r.CertChainPEM) != 2 {
+				t.Errorf("caHandler.SignBatch result 0 = %+v, wants a certificate", r)
+			}
+			if r := resp.Results[1]; r.Error == nil || r.Error.StatusCode() != http.StatusForbidden || r.ServerPEM != nil {
+				t.Errorf("caHandler.SignBatch result 1 = %+v, wants a forbidden error", r)
+			}
+			if r := resp.Results[2]; r.Error == nil || r.Error.StatusCode() != http.StatusBadRequest {
+				t.Errorf("caHandler.SignBatch result 2 = %+v, wants a bad request error", r)
+			}
+		})
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/smallstep/certificates/authority"
 	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/certificates/logging"
 	"github.com/smallstep/certificates/sshutil"
 	"github.com/smallstep/certificates/templates"
 	"golang.org/x/crypto/ssh"
@@ -29,6 +30,7 @@ type SSHAuthority interface {
 	CheckSSHHost(ctx context.Context, principal string, token string) (bool, error)
 	GetSSHHosts(ctx context.Context, cert *x509.Certificate) ([]sshutil.Host, error)
 	GetSSHBastion(ctx context.Context, user string, hostname string) (*authority.Bastion, error)
+	GetSSHRevocationList(ctx context.Context) ([]byte, error)
 }
 
 // SSHSignRequest is the request body of an SSH certificate request.
@@ -42,6 +44,7 @@ type SSHSignRequest struct {
 	AddUserPublicKey []byte             `json:"addUserPublicKey,omitempty"`
 	KeyID            string             `json:"keyID"`
 	IdentityCSR      CertificateRequest `json:"identityCSR,omitempty"`
+	TemplateData     json.RawMessage    `json:"templateData,omitempty"`
 }
 
 // Validate validates the SSHSignRequest.
@@ -275,11 +278,12 @@ func (h *caHandler) SSHSign(w http.ResponseWriter, r *http.Request) {
 	}
 
 	opts := provisioner.SSHOptions{
-		CertType:    body.CertType,
-		KeyID:       body.KeyID,
-		Principals:  body.Principals,
-		ValidBefore: body.ValidBefore,
-		ValidAfter:  body.ValidAfter,
+		CertType:     body.CertType,
+		KeyID:        body.KeyID,
+		Principals:   body.Principals,
+		ValidBefore:  body.ValidBefore,
+		ValidAfter:   body.ValidAfter,
+		TemplateData: body.TemplateData,
 	}
 
 	ctx := provisioner.NewContextWithMethod(r.Context(), provisioner.SSHSignMethod)
@@ -294,6 +298,7 @@ func (h *caHandler) SSHSign(w http.ResponseWriter, r *http.Request) {
 		WriteError(w, errs.ForbiddenErr(err))
 		return
 	}
+	logSSHCertificate(w, cert)
 
 	var addUserCertificate *SSHCertificate
 	if addUserPublicKey != nil && authority.IsValidForAddUser(cert) == nil {
@@ -322,7 +327,7 @@ func (h *caHandler) SSHSign(w http.ResponseWriter, r *http.Request) {
 			NotAfter:  time.Unix(int64(cert.ValidBefore), 0),
 		})
 
-		certChain, err := h.Authority.Sign(cr, provisioner.Options{}, signOpts...)
+		certChain, err := h.Authority.SignWithContext(ctx, cr, provisioner.Options{}, signOpts...)
 		if err != nil {
 			WriteError(w, errs.ForbiddenErr(err))
 			return
@@ -444,8 +449,9 @@ func (h *caHandler) SSHCheckHost(w http.ResponseWriter, r *http.Request) {
 
 // SSHGetHosts is the HTTP handler that returns a list of valid ssh hosts.
 func (h *caHandler) SSHGetHosts(w http.ResponseWriter, r *http.Request) {
+	// Expired certificates are only accepted by the server to renew them.
 	var cert *x509.Certificate
-	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 && !time.Now().After(r.TLS.PeerCertificates[0].NotAfter) {
 		cert = r.TLS.PeerCertificates[0]
 	}
 
@@ -483,6 +489,21 @@ func (h *caHandler) SSHBastion(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// SSHRevocationList is an HTTP handler that returns the OpenSSH key
+// revocation list (KRL) with the revoked SSH certificates. The list can be
+// used in the RevokedKeys option of sshd.
+func (h *caHandler) SSHRevocationList(w http.ResponseWriter, r *http.Request) {
+	krl, err := h.Authority.GetSSHRevocationList(r.Context())
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="revoked_keys.krl"`)
+	w.Write(krl)
+}
+
 // identityModifier is a custom modifier used to force a fixed duration.
 type identityModifier struct {
 	NotBefore time.Time
@@ -494,3 +515,21 @@ func (m *identityModifier) Enforce(cert *x509.Certificate) error {
 	cert.NotAfter = m.NotAfter
 	return nil
 }
+
+func logSSHCertificate(w http.ResponseWriter, cert *ssh.Certificate) {
+	if rl, ok := w.(logging.ResponseLogger); ok {
+		certType := "user"
+		if cert.CertType == ssh.HostCert {
+			certType = "host"
+		}
+		rl.WithFields(map[string]interface{}{
+			"serial":      cert.Serial,
+			"key-id":      cert.KeyId,
+			"cert-type":   certType,
+			"principals":  cert.ValidPrincipals,
+			"valid-from":  time.Unix(int64(cert.ValidAfter), 0).Format(time.RFC3339),
+			"valid-to":    time.Unix(int64(cert.ValidBefore), 0).Format(time.RFC3339),
+			"certificate": base64.StdEncoding.EncodeToString(cert.Marshal()),
+		})
+	}
+}
@@ -71,6 +71,7 @@ func (h *caHandler) SSHRekey(w http.ResponseWriter, r *http.Request) {
 		WriteError(w, errs.ForbiddenErr(err))
 		return
 	}
+	logSSHCertificate(w, newCert)
 
 	identity, err := h.renewIdentityCertificate(r)
 	if err != nil {
@@ -61,6 +61,7 @@ func (h *caHandler) SSHRenew(w http.ResponseWriter, r *http.Request) {
 		WriteError(w, errs.ForbiddenErr(err))
 		return
 	}
+	logSSHCertificate(w, newCert)
 
 	identity, err := h.renewIdentityCertificate(r)
 	if err != nil {
@@ -80,7 +81,7 @@ func (h *caHandler) renewIdentityCertificate(r *http.Request) ([]Certificate, er
 		return nil, nil
 	}
 
-	certChain, err := h.Authority.Renew(r.TLS.PeerCertificates[0])
+	certChain, err := h.Authority.RenewContext(r.Context(), r.TLS.PeerCertificates[0])
 	if err != nil {
 		return nil, err
 	}
@@ -21,6 +21,7 @@ import (
 	"github.com/smallstep/assert"
 	"github.com/smallstep/certificates/authority"
 	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
 	"github.com/smallstep/certificates/logging"
 	"github.com/smallstep/certificates/sshutil"
 	"github.com/smallstep/certificates/templates"
@@ -674,6 +675,51 @@ func Test_caHandler_SSHBastion(t *testing.T) {
 	}
 }
 
+func Test_caHandler_SSHRevocationList(t *testing.T) {
+	tests := []struct {
+		name       string
+		krl        []byte
+		krlErr     error
+		statusCode int
+	}{
+		{"ok", []byte("SSHKRL\n\x00"), nil, http.StatusOK},
+		{"not found", nil, errs.NotFound("ssh is not configured"), http.StatusNotFound},
+		{"not implemented", nil, errs.NotImplemented("no persistence layer configured"), http.StatusNotImplemented},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New(&mockAuthority{
+				getSSHRevocationList: func(ctx context.Context) ([]byte, error) {
+					return tt.krl, tt.krlErr
+				},
+			}).(*caHandler)
+
+			req := httptest.NewRequest("GET", "http://example.com/ssh/krl", nil)
+			w := httptest.NewRecorder()
+			h.SSHRevocationList(logging.NewResponseLogger(w), req)
+			res := w.Result()
+
+			if res.StatusCode != tt.statusCode {
+				t.Errorf("caHandler.SSHRevocationList StatusCode = %d, wants %d", res.StatusCode, tt.statusCode)
+			}
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil {
+				t.Errorf("caHandler.SSHRevocationList unexpected error = %v", err)
+			}
+			if tt.statusCode < http.StatusBadRequest {
+				if !bytes.Equal(body, tt.krl) {
+					t.Errorf("caHandler.SSHRevocationList Body = %q, wants %q", body, tt.krl)
+				}
+				if ct := res.Header.Get("Content-Type"); ct != "application/octet-stream" {
+					t.Errorf("caHandler.SSHRevocationList Content-Type = %s, wants application/octet-stream", ct)
+				}
+			}
+		})
+	}
+}
+
 func TestSSHPublicKey_MarshalJSON(t *testing.T) {
 	key, err := ssh.NewPublicKey(sshUserKey.Public())
 	assert.FatalError(t, err)
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/certificates/authority"
+)
+
+// CertificateStatusResponse is the response object of the certificate status
+// requests. The certificate fields are empty if the certificate has been
+// revoked by serial number but it's not stored in the CA, and the revocation
+// fields are empty if the certificate has not been revoked.
+type CertificateStatusResponse struct {
+	Status        string       `json:"status"`
+	SerialNumber  string       `json:"serialNumber"`
+	Fingerprint   string       `json:"fingerprint,omitempty"`
+	Subject       string       `json:"subject,omitempty"`
+	NotBefore     *time.Time   `json:"notBefore,omitempty"`
+	NotAfter      *time.Time   `json:"notAfter,omitempty"`
+	Certificate   *Certificate `json:"crt,omitempty"`
+	RevokedAt     *time.Time   `json:"revokedAt,omitempty"`
+	ReasonCode    *int         `json:"reasonCode,omitempty"`
+	Reason        string       `json:"reason,omitempty"`
+	ProvisionerID string       `json:"provisionerID,omitempty"`
+}
+
+// CertificateStatus is an HTTP handler that returns the status of the
+// certificate with the serial number in the URL.
+func (h *caHandler) CertificateStatus(w http.ResponseWriter, r *http.Request) {
+	serial := chi.URLParam(r, "serial")
+	status, err := h.Authority.GetCertificateStatus(serial)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, newCertificateStatusResponse(serial, status))
+}
+
+// CertificateStatusByFingerprint is an HTTP handler that returns the status of
+// the certificate with the SHA-256 fingerprint in the URL.
+func (h *caHandler) CertificateStatusByFingerprint(w http.ResponseWriter, r *http.Request) {
+	sha := chi.URLParam(r, "sha")
+	sum := strings.ToLower(strings.Replace(sha, "-", "", -1))
+	status, err := h.Authority.GetCertificateStatusByFingerprint(sum)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, newCertificateStatusResponse("", status))
+}
+
+func newCertificateStatusResponse(serial string, status *authority.CertificateStatus) *CertificateStatusResponse {
+	res := &CertificateStatusResponse{
+		Status:       status.Status,
+		SerialNumber: serial,
+	}
+	if crt := status.Certificate; crt != nil {
+		sum := sha256.Sum256(crt.Raw)
+		cert := NewCertificate(crt)
+		res.SerialNumber = crt.SerialNumber.String()
+		res.Fingerprint = hex.EncodeToString(sum[:])
+		res.Subject = crt.Subject.String()
+		res.NotBefore = &crt.NotBefore
+		res.NotAfter = &crt.NotAfter
+		res.Certificate = &cert
+	}
+	if rci := status.Revocation; rci != nil {
+		res.SerialNumber = rci.Serial
+		res.RevokedAt = &rci.RevokedAt
+		res.ReasonCode = &rci.ReasonCode
+		res.Reason = rci.Reason
+		res.ProvisionerID = rci.ProvisionerID
+	}
+	return res
+}
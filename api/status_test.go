@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+)
+
+func Test_caHandler_CertificateStatus(t *testing.T) {
+	crt := parseCertificate(certPEM)
+	revokedAt := time.Now().UTC().Truncate(time.Second)
+	rci := &db.RevokedCertificateInfo{
+		Serial:        crt.SerialNumber.String(),
+		ReasonCode:    1,
+		Reason:        "lost key",
+		ProvisionerID: "provID",
+		RevokedAt:     revokedAt,
+	}
+
+	tests := []struct {
+		name        string
+		param       string
+		value       string
+		status      *authority.CertificateStatus
+		err         error
+		statusCode  int
+		wantStatus  string
+		wantCrt     bool
+		wantRevoked bool
+	}{
+		{"ok", "serial", crt.SerialNumber.String(), &authority.CertificateStatus{Status: authority.CertificateStatusIssued, Certificate: crt}, nil, http.StatusOK, "issued", true, false},
+		{"ok revoked", "serial", crt.SerialNumber.String(), &authority.CertificateStatus{Status: authority.CertificateStatusRevoked, Certificate: crt, Revocation: rci}, nil, http.StatusOK, "revoked", true, true},
+		{"ok revoked not stored", "serial", crt.SerialNumber.String(), &authority.CertificateStatus{Status: authority.CertificateStatusRevoked, Revocation: rci}, nil, http.StatusOK, "revoked", false, true},
+		{"ok fingerprint", "sha", "ABCD", &authority.CertificateStatus{Status: authority.CertificateStatusExpired, Certificate: crt}, nil, http.StatusOK, "expired", true, false},
+		{"fail", "serial", "1234", nil, errs.NotFound("not found"), http.StatusNotFound, "", false, false},
+		{"fail fingerprint", "sha", "abcd", nil, errs.NotFound("not found"), http.StatusNotFound, "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New(&mockAuthority{
+				getCertificateStatus: func(serialNumber string) (*authority.CertificateStatus, error) {
+					if serialNumber != tt.value {
+						t.Errorf("caHandler.CertificateStatus serialNumber = %s, wants %s", serialNumber, tt.value)
+					}
+					return tt.status, tt.err
+				},
+				getCertificateStatusByFP: func(fingerprint string) (*authority.CertificateStatus, error) {
+					if fingerprint != "abcd" {
+						t.Errorf("caHandler.CertificateStatusByFingerprint fingerprint = %s, wants abcd", fingerprint)
+					}
+					return tt.status, tt.err
+				},
+			}).(*caHandler)
+
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add(tt.param, tt.value)
+			req := httptest.NewRequest("GET", "http://example.com/certificates", nil)
+			req = req.WithContext(context.WithValue(context.Background(), chi.RouteCtxKey, chiCtx))
+			w := httptest.NewRecorder()
+			if tt.param == "sha" {
+				h.CertificateStatusByFingerprint(w, req)
+			} else {
+				h.CertificateStatus(w, req)
+			}
+			res := w.Result()
+			defer res.Body.Close()
+
+			if res.StatusCode != tt.statusCode {
+				t.Errorf("caHandler.CertificateStatus StatusCode = %d, wants %d", res.StatusCode, tt.statusCode)
+			}
+			if tt.statusCode >= http.StatusBadRequest {
+				return
+			}
+
+			var got CertificateStatusResponse
+			if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+				t.Fatalf("caHandler.CertificateStatus unexpected error = %v", err)
+			}
+			if got.Status != tt.wantStatus {
+				t.Errorf("caHandler.CertificateStatus Status = %s, wants %s", got.Status, tt.wantStatus)
+			}
+			if got.SerialNumber != crt.SerialNumber.String() {
+				t.Errorf("caHandler.CertificateStatus SerialNumber = %s, wants %s", got.SerialNumber, crt.SerialNumber)
+			}
+			if tt.wantCrt {
+				if got.Certificate == nil || got.Certificate.Certificate.SerialNumber.Cmp(crt.SerialNumber) != 0 {
+					t.Errorf("caHandler.CertificateStatus Certificate = %v, wants %v", got.Certificate, crt)
+				}
+				if got.Subject != crt.Subject.String() || got.NotAfter == nil || !got.NotAfter.Equal(crt.NotAfter) {
+					t.Errorf("caHandler.CertificateStatus unexpected certificate details %+v", got)
+				}
+			} else if got.Certificate != nil || got.Fingerprint != "" {
+				t.Errorf("caHandler.CertificateStatus unexpected certificate %+v", got)
+			}
+			if tt.wantRevoked {
+				if got.RevokedAt == nil || !got.RevokedAt.Equal(revokedAt) || got.ReasonCode == nil || *got.ReasonCode != 1 ||
+					got.Reason != "lost key" || got.ProvisionerID != "provID" {
+					t.Errorf("caHandler.CertificateStatus unexpected revocation details %+v", got)
+				}
+			} else if got.RevokedAt != nil || got.ReasonCode != nil {
+				t.Errorf("caHandler.CertificateStatus unexpected revocation %+v", got)
+			}
+		})
+	}
+}
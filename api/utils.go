@@ -1,8 +1,10 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 
@@ -65,9 +67,20 @@ func JSONStatus(w http.ResponseWriter, v interface{}, status int) {
 }
 
 // ReadJSON reads JSON from the request body and stores it in the value
-// pointed by v.
+// pointed by v. If the body is limited by LimitBody, it returns a 413 error if
+// the body is too large, and a 400 error if the JSON is nested too deep.
 func ReadJSON(r io.Reader, v interface{}) error {
-	if err := json.NewDecoder(r).Decode(v); err != nil {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		if err == ErrBodyTooLarge {
+			return errs.NewErr(http.StatusRequestEntityTooLarge, err, errs.WithMessage("The request body is too large."))
+		}
+		return errs.Wrap(http.StatusBadRequest, err, "error reading request body")
+	}
+	if err := CheckJSONDepth(b, JSONDepthLimit(r)); err != nil {
+		return errs.Wrap(http.StatusBadRequest, err, "error decoding json")
+	}
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(v); err != nil {
 		return errs.Wrap(http.StatusBadRequest, err, "error decoding json")
 	}
 	return nil
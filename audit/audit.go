@@ -0,0 +1,247 @@
+// Package audit implements an append-only log of the security-relevant events
+// of the CA. Each event contains the HMAC of the previous one, so removing or
+// modifying an event breaks the chain and can be detected with Verify.
+package audit
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Event types.
+const (
+	// TokenUsed is recorded when a one-time token is accepted.
+	TokenUsed = "token.used"
+	// TokenReused is recorded when a one-time token that has already been
+	// used is presented again.
+	TokenReused = "token.reused"
+	// CertificateIssued is recorded when an X.509 or SSH certificate is
+	// signed, renewed or rekeyed.
+	CertificateIssued = "certificate.issued"
+	// CertificateRevoked is recorded when a certificate is revoked.
+	CertificateRevoked = "certificate.revoked"
+	// ProvisionerCreated is recorded when a provisioner is added with the
+	// admin API.
+	ProvisionerCreated = "admin.provisioner.created"
+	// ProvisionerUpdated is recorded when a provisioner is replaced with the
+	// admin API.
+	ProvisionerUpdated = "admin.provisioner.updated"
+	// ProvisionerDeleted is recorded when a provisioner is deleted with the
+	// admin API.
+	ProvisionerDeleted = "admin.provisioner.deleted"
+	// PolicyUpdated is recorded when the authority or a provisioner policy is
+	// replaced with the admin API.
+	PolicyUpdated = "admin.policy.updated"
+	// PolicyDeleted is recorded when the authority or a provisioner policy is
+	// deleted with the admin API.
+	PolicyDeleted = "admin.policy.deleted"
+	// ConfigReloaded is recorded when the configuration of the CA is reloaded
+	// with the admin API.
+	ConfigReloaded = "admin.config.reloaded"
+	// DatabaseExported is recorded when the database is exported with the
+	// admin API.
+	DatabaseExported = "admin.database.exported"
+	// IntermediateRotated is recorded when the intermediate used to sign new
+	// certificates is replaced with the admin API.
+	IntermediateRotated = "admin.intermediate.rotated"
+	// IntermediateRetired is recorded when a previous intermediate is removed
+	// with the admin API.
+	IntermediateRetired = "admin.intermediate.retired"
+	// AccountCreated is recorded when an ACME account is created.
+	AccountCreated = "acme.account.created"
+	// AccountUpdated is recorded when the contacts of an ACME account are
+	// updated.
+	AccountUpdated = "acme.account.updated"
+	// AccountDeactivated is recorded when an ACME account is deactivated.
+	AccountDeactivated = "acme.account.deactivated"
+)
+
+// Event is an entry in the audit log. Sequence, Time, PrevHash and Hash are
+// set by the Logger.
+type Event struct {
+	Sequence    uint64            `json:"seq"`
+	Time        time.Time         `json:"time"`
+	Type        string            `json:"type"`
+	Actor       string            `json:"actor,omitempty"`
+	ClientIP    string            `json:"clientIP,omitempty"`
+	Provisioner string            `json:"provisioner,omitempty"`
+	Subject     string            `json:"subject,omitempty"`
+	Serial      string            `json:"serial,omitempty"`
+	SANs        []string          `json:"sans,omitempty"`
+	Details     map[string]string `json:"details,omitempty"`
+	PrevHash    string            `json:"prevHash"`
+	Hash        string            `json:"hash"`
+}
+
+type clientIPKey struct{}
+
+// NewContextWithClientIP returns a copy of the context with the IP address of
+// the client of the request, used as the ClientIP of the events.
+func NewContextWithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// ClientIPFromContext returns the IP address of the client in the context, or
+// an empty string if there's none.
+func ClientIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+// computeHash returns the hex encoded HMAC-SHA256, with the given key, of the
+// JSON representation of the event without the Hash field. Without the key
+// the events cannot be modified and hashed again to rebuild the chain.
+func (e *Event) computeHash(key []byte) (string, error) {
+	c := *e
+	c.Hash = ""
+	b, err := json.Marshal(&c)
+	if err != nil {
+		return "", errors.Wrap(err, "error marshaling audit event")
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(b)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Sink is the interface implemented by the destinations of the audit events.
+type Sink interface {
+	Write(e *Event) error
+	Close() error
+}
+
+// lastEventer is implemented by the sinks that can recover the last event
+// written, it's used to continue the hash chain after a restart.
+type lastEventer interface {
+	lastEvent() *Event
+}
+
+// Logger chains and writes the audit events to the sinks. A nil Logger
+// discards all the events.
+type Logger struct {
+	mu       sync.Mutex
+	key      []byte
+	sinks    []Sink
+	sequence uint64
+	prevHash string
+}
+
+// New creates a new Logger that writes to the given sinks and hashes the
+// events with the given key. If one of the sinks contains events from a
+// previous run, the new events continue its chain.
+func New(key []byte, sinks ...Sink) *Logger {
+	l := &Logger{key: key, sinks: sinks}
+	for _, s := range sinks {
+		if le, ok := s.(lastEventer); ok {
+			if e := le.lastEvent(); e != nil {
+				l.sequence = e.Sequence
+				l.prevHash = e.Hash
+				break
+			}
+		}
+	}
+	return l
+}
+
+// Log sets the sequence, time and hashes of the event and writes it to all
+// the sinks. Errors writing to a sink are logged but they are not returned, a
+// failure in the audit log does not interrupt the operation audited.
+func (l *Logger) Log(e *Event) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e.Sequence = l.sequence + 1
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	e.PrevHash = l.prevHash
+	hash, err := e.computeHash(l.key)
+	if err != nil {
+		log.Printf("error creating audit event %s: %v", e.Type, err)
+		return
+	}
+	e.Hash = hash
+	l.sequence = e.Sequence
+	l.prevHash = e.Hash
+
+	for _, s := range l.sinks {
+		if err := s.Write(e); err != nil {
+			log.Printf("error writing audit event %d: %v", e.Sequence, err)
+		}
+	}
+}
+
+// Close closes all the sinks of the logger.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var err error
+	for _, s := range l.sinks {
+		if e := s.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Verify reads an audit log with one JSON event per line and checks that the
+// hash of each event is valid with the given key and that each event is
+// chained to the previous one. The first event must be chained to prevHash,
+// the hash of the last event of the previous log, for example before a
+// rotation, or, if prevHash is empty, it must be the first event of the chain.
+// It returns the number of events read.
+func Verify(r io.Reader, key []byte, prevHash string) (int, error) {
+	prev := &Event{Hash: prevHash}
+	n := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		n++
+		e := new(Event)
+		if err := json.Unmarshal(line, e); err != nil {
+			return n, errors.Wrapf(err, "error parsing audit event in line %d", n)
+		}
+		hash, err := e.computeHash(key)
+		if err != nil {
+			return n, err
+		}
+		if hash != e.Hash {
+			return n, errors.Errorf("audit event %d has an invalid hash", e.Sequence)
+		}
+		switch {
+		case n == 1 && prevHash == "" && (e.Sequence != 1 || e.PrevHash != ""):
+			return n, errors.Errorf("audit event %d is not the first event", e.Sequence)
+		case n == 1 && e.PrevHash != prevHash:
+			return n, errors.Errorf("audit event %d is not chained to %s", e.Sequence, prevHash)
+		case n > 1 && e.PrevHash != prev.Hash:
+			return n, errors.Errorf("audit event %d is not chained to event %d", e.Sequence, prev.Sequence)
+		case n > 1 && e.Sequence != prev.Sequence+1:
+			return n, errors.Errorf("audit event %d does not follow event %d", e.Sequence, prev.Sequence)
+		}
+		prev = e
+	}
+	if err := scanner.Err(); err != nil {
+		return n, errors.Wrap(err, "error reading audit log")
+	}
+	return n, nil
+}
@@ -0,0 +1,271 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+var testKey = []byte("01234567890123456789012345678901")
+
+type memorySink struct {
+	events []*Event
+	err    error
+}
+
+func (s *memorySink) Write(e *Event) error {
+	c := *e
+	s.events = append(s.events, &c)
+	return s.err
+}
+
+func (s *memorySink) Close() error {
+	return nil
+}
+
+func encodeEvents(t *testing.T, events []*Event) []byte {
+	var buf bytes.Buffer
+	for _, e := range events {
+		b, err := json.Marshal(e)
+		assert.FatalError(t, err)
+		buf.Write(append(b, '\n'))
+	}
+	return buf.Bytes()
+}
+
+func TestLogger_Log(t *testing.T) {
+	sink := new(memorySink)
+	failing := &memorySink{err: errors.New("force")}
+	l := New(testKey, sink, failing)
+
+	l.Log(&Event{Type: TokenUsed, Provisioner: "jwk", Subject: "foo"})
+	l.Log(&Event{Type: CertificateIssued, Serial: "1234", SANs: []string{"foo.example.com"}})
+	l.Log(&Event{Type: CertificateRevoked, Serial: "1234"})
+
+	assert.Equals(t, 3, len(sink.events))
+	assert.Equals(t, 3, len(failing.events))
+	for i, e := range sink.events {
+		assert.Equals(t, uint64(i+1), e.Sequence)
+		assert.False(t, e.Time.IsZero())
+		assert.Equals(t, 64, len(e.Hash))
+		if i == 0 {
+			assert.Equals(t, "", e.PrevHash)
+		} else {
+			assert.Equals(t, sink.events[i-1].Hash, e.PrevHash)
+		}
+	}
+
+	n, err := Verify(bytes.NewReader(encodeEvents(t, sink.events)), testKey, "")
+	assert.FatalError(t, err)
+	assert.Equals(t, 3, n)
+
+	// A nil logger discards the events.
+	var nilLogger *Logger
+	nilLogger.Log(&Event{Type: TokenUsed})
+	assert.FatalError(t, nilLogger.Close())
+}
+
+func TestClientIPFromContext(t *testing.T) {
+	assert.Equals(t, "", ClientIPFromContext(context.Background()))
+	ctx := NewContextWithClientIP(context.Background(), "10.0.0.1")
+	assert.Equals(t, "10.0.0.1", ClientIPFromContext(ctx))
+}
+
+func TestVerify(t *testing.T) {
+	sink := new(memorySink)
+	l := New(testKey, sink)
+	for i := 0; i < 4; i++ {
+		l.Log(&Event{Type: CertificateIssued, Subject: "foo", SANs: []string{"foo.example.com"}})
+	}
+	events := sink.events
+
+	tampered := *events[1]
+	tampered.SANs = []string{"bar.example.com"}
+	rehashed := tampered
+	rehashed.Hash, _ = rehashed.computeHash(testKey)
+	// Without the key the events cannot be hashed again.
+	forged := tampered
+	forged.Hash, _ = forged.computeHash([]byte("another key"))
+	first := *events[2]
+	first.Sequence, first.PrevHash = 1, ""
+	first.Hash, _ = first.computeHash([]byte("another key"))
+
+	tests := []struct {
+		name     string
+		data     []byte
+		key      []byte
+		prevHash string
+		want     int
+		errMsg   string
+	}{
+		{"ok", encodeEvents(t, events), testKey, "", 4, ""},
+		{"ok/rotated", encodeEvents(t, events[2:]), testKey, events[1].Hash, 2, ""},
+		{"ok/empty", nil, testKey, "", 0, ""},
+		{"fail/json", []byte("{\n"), testKey, "", 1, "error parsing audit event in line 1"},
+		{"fail/key", encodeEvents(t, events), []byte("another key"), "", 1, "audit event 1 has an invalid hash"},
+		{"fail/modified", encodeEvents(t, []*Event{events[0], &tampered, events[2]}), testKey, "", 2, "audit event 2 has an invalid hash"},
+		{"fail/forged", encodeEvents(t, []*Event{events[0], &forged, events[2]}), testKey, "", 2, "audit event 2 has an invalid hash"},
+		{"fail/forged-first", encodeEvents(t, []*Event{&first, events[3]}), testKey, "", 1, "audit event 1 has an invalid hash"},
+		{"fail/rehashed", encodeEvents(t, []*Event{events[0], &rehashed, events[2]}), testKey, "", 3, "audit event 3 is not chained to event 2"},
+		{"fail/removed", encodeEvents(t, []*Event{events[0], events[2]}), testKey, "", 2, "audit event 3 is not chained to event 1"},
+		{"fail/reordered", encodeEvents(t, []*Event{events[1], events[0]}), testKey, "", 1, "audit event 2 is not the first event"},
+		{"fail/truncated", encodeEvents(t, events[2:]), testKey, "", 1, "audit event 3 is not the first event"},
+		{"fail/rotated", encodeEvents(t, events[2:]), testKey, events[0].Hash, 1, "audit event 3 is not chained to " + events[0].Hash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := Verify(bytes.NewReader(tt.data), tt.key, tt.prevHash)
+			assert.Equals(t, tt.want, n)
+			if tt.errMsg == "" {
+				assert.FatalError(t, err)
+			} else if assert.NotNil(t, err) {
+				assert.HasPrefix(t, err.Error(), tt.errMsg)
+			}
+		})
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "audit.log")
+
+	s, err := NewFileSink(name)
+	assert.FatalError(t, err)
+	l := New(testKey, s)
+	l.Log(&Event{Type: TokenUsed})
+	l.Log(&Event{Type: CertificateIssued, Details: map[string]string{"long": strings.Repeat("a", 5000)}})
+	assert.FatalError(t, l.Close())
+
+	// The chain continues after reopening the file.
+	s, err = NewFileSink(name)
+	assert.FatalError(t, err)
+	assert.Equals(t, uint64(2), s.lastEvent().Sequence)
+	l = New(testKey, s)
+	l.Log(&Event{Type: CertificateRevoked})
+	assert.FatalError(t, l.Close())
+
+	f, err := os.Open(name)
+	assert.FatalError(t, err)
+	defer f.Close()
+	n, err := Verify(f, testKey, "")
+	assert.FatalError(t, err)
+	assert.Equals(t, 3, n)
+
+	fi, err := os.Stat(name)
+	assert.FatalError(t, err)
+	assert.Equals(t, os.FileMode(0600), fi.Mode().Perm())
+
+	// Errors
+	_, err = NewFileSink(filepath.Join(dir, "missing", "audit.log"))
+	assert.NotNil(t, err)
+	assert.FatalError(t, ioutil.WriteFile(name, []byte("not json\n"), 0600))
+	_, err = NewFileSink(name)
+	assert.NotNil(t, err)
+}
+
+func TestWebhookSink(t *testing.T) {
+	var mu sync.Mutex
+	var got []*Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("Authorization header = %s, want Bearer token", r.Header.Get("Authorization"))
+		}
+		e := new(Event)
+		if err := json.NewDecoder(r.Body).Decode(e); err != nil {
+			t.Errorf("error decoding event: %v", err)
+		}
+		mu.Lock()
+		got = append(got, e)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	s := NewWebhookSink(srv.URL, "token", time.Second)
+	l := New(testKey, s)
+	l.Log(&Event{Type: AccountCreated, Subject: "account-id"})
+	l.Log(&Event{Type: AccountDeactivated, Subject: "account-id"})
+	assert.FatalError(t, l.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+	if assert.Equals(t, 2, len(got)) {
+		assert.Equals(t, AccountCreated, got[0].Type)
+		assert.Equals(t, AccountDeactivated, got[1].Type)
+		assert.Equals(t, got[0].Hash, got[1].PrevHash)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(testKey)
+	tests := []struct {
+		name    string
+		config  *Config
+		wantErr bool
+	}{
+		{"ok/nil", nil, false},
+		{"ok/file", &Config{Key: key, File: "audit.log"}, false},
+		{"ok/syslog", &Config{Key: key, Syslog: &SyslogConfig{}}, false},
+		{"ok/webhooks", &Config{Key: key, Webhooks: []*WebhookConfig{
+			{URL: "https://audit.example.com", Timeout: &provisioner.Duration{Duration: time.Second}},
+		}}, false},
+		{"ok/key-reference", &Config{Key: "vault://secret/data/step-ca#audit", File: "audit.log"}, false},
+		{"fail/empty", &Config{Key: key}, true},
+		{"fail/key", &Config{File: "audit.log"}, true},
+		{"fail/key-base64", &Config{Key: "not base64", File: "audit.log"}, true},
+		{"fail/key-size", &Config{Key: base64.StdEncoding.EncodeToString([]byte("short")), File: "audit.log"}, true},
+		{"fail/nil-webhook", &Config{Key: key, Webhooks: []*WebhookConfig{nil}}, true},
+		{"fail/webhook-url", &Config{Key: key, Webhooks: []*WebhookConfig{{URL: "ftp://audit.example.com"}}}, true},
+		{"fail/webhook-timeout", &Config{Key: key, Webhooks: []*WebhookConfig{
+			{URL: "https://audit.example.com", Timeout: &provisioner.Duration{Duration: -time.Second}},
+		}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Config.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	l, err := Open(nil)
+	assert.FatalError(t, err)
+	assert.Nil(t, l)
+
+	dir, err := ioutil.TempDir("", "audit")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+
+	key := base64.StdEncoding.EncodeToString(testKey)
+	l, err = Open(&Config{
+		Key:      key,
+		File:     filepath.Join(dir, "audit.log"),
+		Webhooks: []*WebhookConfig{{URL: "http://127.0.0.1:1"}},
+	})
+	assert.FatalError(t, err)
+	assert.Equals(t, 2, len(l.sinks))
+	assert.Equals(t, testKey, l.key)
+	assert.FatalError(t, l.Close())
+
+	_, err = Open(&Config{Key: key, File: filepath.Join(dir, "missing", "audit.log")})
+	assert.NotNil(t, err)
+	_, err = Open(&Config{Key: "not base64", File: filepath.Join(dir, "audit.log")})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,133 @@
+package audit
+
+import (
+	"context"
+	"encoding/base64"
+	"net/url"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/secrets"
+)
+
+// minKeySize is the minimum size in bytes of the key used to hash the events.
+const minKeySize = 32
+
+// Config is the configuration of the audit log. Events are written to all the
+// configured sinks. Key is the base64 encoded key used to hash the events, or
+// a reference to it in an external secret manager.
+type Config struct {
+	Key      string           `json:"key"`
+	File     string           `json:"file,omitempty"`
+	Syslog   *SyslogConfig    `json:"syslog,omitempty"`
+	Webhooks []*WebhookConfig `json:"webhooks,omitempty"`
+}
+
+// SyslogConfig is the configuration of the syslog sink. If Network and
+// Address are empty the local syslog server is used.
+type SyslogConfig struct {
+	Network string `json:"network,omitempty"`
+	Address string `json:"address,omitempty"`
+	Tag     string `json:"tag,omitempty"`
+}
+
+// WebhookConfig is the configuration of a webhook sink.
+type WebhookConfig struct {
+	URL         string                `json:"url"`
+	BearerToken string                `json:"bearerToken,omitempty"`
+	Timeout     *provisioner.Duration `json:"timeout,omitempty"`
+}
+
+// Validate checks the fields in Config.
+func (c *Config) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.File == "" && c.Syslog == nil && len(c.Webhooks) == 0 {
+		return errors.New("audit must configure at least one of file, syslog or webhooks")
+	}
+	switch {
+	case c.Key == "":
+		return errors.New("audit key cannot be empty")
+	case !secrets.IsReference(c.Key):
+		if _, err := decodeKey(c.Key); err != nil {
+			return err
+		}
+	}
+	for _, w := range c.Webhooks {
+		if w == nil {
+			return errors.New("audit webhook cannot be empty")
+		}
+		u, err := url.Parse(w.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.Errorf("audit webhook url %s is not valid", w.URL)
+		}
+		if w.Timeout != nil && w.Timeout.Duration < 0 {
+			return errors.Errorf("audit webhook %s: timeout cannot be less than 0", w.URL)
+		}
+	}
+	return nil
+}
+
+// Open creates the sinks in the configuration and returns a Logger that writes
+// to them. It returns a nil Logger if the configuration is nil.
+func Open(c *Config) (*Logger, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	secret, err := secrets.Resolve(context.Background(), c.Key)
+	if err != nil {
+		return nil, err
+	}
+	key, err := decodeKey(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	var sinks []Sink
+	closeAll := func() {
+		for _, s := range sinks {
+			s.Close()
+		}
+	}
+	if c.File != "" {
+		s, err := NewFileSink(c.File)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if c.Syslog != nil {
+		tag := c.Syslog.Tag
+		if tag == "" {
+			tag = "step-ca"
+		}
+		s, err := NewSyslogSink(c.Syslog.Network, c.Syslog.Address, tag)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	for _, w := range c.Webhooks {
+		var timeout provisioner.Duration
+		if w.Timeout != nil {
+			timeout = *w.Timeout
+		}
+		sinks = append(sinks, NewWebhookSink(w.URL, w.BearerToken, timeout.Duration))
+	}
+	return New(key, sinks...), nil
+}
+
+// decodeKey decodes the base64 encoded key used to hash the events.
+func decodeKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding audit key")
+	}
+	if len(key) < minKeySize {
+		return nil, errors.Errorf("audit key must be at least %d bytes", minKeySize)
+	}
+	return key, nil
+}
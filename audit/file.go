@@ -0,0 +1,89 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// FileSink writes the audit events to a file, one JSON event per line. The
+// file is only appended to.
+type FileSink struct {
+	f    *os.File
+	last *Event
+}
+
+// NewFileSink opens or creates the file with the given name. If the file
+// already contains events, the last one is used to continue the hash chain.
+func NewFileSink(name string) (*FileSink, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error opening %s", name)
+	}
+	line, err := readLastLine(f)
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "error reading %s", name)
+	}
+	s := &FileSink{f: f}
+	if len(line) > 0 {
+		s.last = new(Event)
+		if err := json.Unmarshal(line, s.last); err != nil {
+			f.Close()
+			return nil, errors.Wrapf(err, "error parsing last event in %s", name)
+		}
+	}
+	return s, nil
+}
+
+// Write appends the event to the file.
+func (s *FileSink) Write(e *Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling audit event")
+	}
+	if _, err := s.f.Write(append(b, '\n')); err != nil {
+		return errors.Wrapf(err, "error writing %s", s.f.Name())
+	}
+	return nil
+}
+
+// Close closes the file.
+func (s *FileSink) Close() error {
+	return s.f.Close()
+}
+
+func (s *FileSink) lastEvent() *Event {
+	return s.last
+}
+
+// readLastLine returns the last non-empty line of the file, reading it
+// backwards in blocks.
+func readLastLine(f *os.File) ([]byte, error) {
+	const blockSize = 4096
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	var buf []byte
+	for offset := size; offset > 0; {
+		n := int64(blockSize)
+		if offset < n {
+			n = offset
+		}
+		offset -= n
+		block := make([]byte, n)
+		if _, err := f.ReadAt(block, offset); err != nil {
+			return nil, err
+		}
+		buf = append(block, buf...)
+		trimmed := bytes.TrimRight(buf, "\n")
+		if i := bytes.LastIndexByte(trimmed, '\n'); i >= 0 {
+			return trimmed[i+1:], nil
+		}
+	}
+	return bytes.TrimRight(buf, "\n"), nil
+}
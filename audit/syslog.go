@@ -0,0 +1,41 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package audit
+
+import (
+	"encoding/json"
+	"log/syslog"
+
+	"github.com/pkg/errors"
+)
+
+// SyslogSink writes the audit events to syslog with the auth facility. The
+// message is the JSON representation of the event.
+type SyslogSink struct {
+	w *syslog.Writer
+}
+
+// NewSyslogSink connects to the syslog server at the given network and
+// address, if both are empty it connects to the local syslog server.
+func NewSyslogSink(network, address, tag string) (*SyslogSink, error) {
+	w, err := syslog.Dial(network, address, syslog.LOG_AUTH|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, errors.Wrap(err, "error connecting to syslog")
+	}
+	return &SyslogSink{w: w}, nil
+}
+
+// Write sends the event to syslog.
+func (s *SyslogSink) Write(e *Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling audit event")
+	}
+	return errors.Wrap(s.w.Info(string(b)), "error writing to syslog")
+}
+
+// Close closes the connection to syslog.
+func (s *SyslogSink) Close() error {
+	return s.w.Close()
+}
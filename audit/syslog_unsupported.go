@@ -0,0 +1,25 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package audit
+
+import "github.com/pkg/errors"
+
+// SyslogSink is not supported on this platform.
+type SyslogSink struct{}
+
+// NewSyslogSink always returns an error, syslog is not supported on this
+// platform.
+func NewSyslogSink(network, address, tag string) (*SyslogSink, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
+
+// Write is not supported on this platform.
+func (s *SyslogSink) Write(e *Event) error {
+	return errors.New("syslog is not supported on this platform")
+}
+
+// Close is not supported on this platform.
+func (s *SyslogSink) Close() error {
+	return nil
+}
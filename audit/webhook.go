@@ -0,0 +1,100 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultWebhookTimeout   = 5 * time.Second
+	defaultWebhookQueueSize = 1024
+)
+
+// WebhookSink sends each audit event as a JSON POST request to a URL. The
+// requests are sent in the background in the same order as the events; if the
+// webhook cannot keep up, new events are dropped and logged. Receivers can
+// detect dropped events using the sequence numbers.
+type WebhookSink struct {
+	url         string
+	bearerToken string
+	client      *http.Client
+	queue       chan []byte
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+// NewWebhookSink creates a new WebhookSink that sends the events to the given
+// URL. If the bearer token is not empty it's sent in the Authorization header.
+// A timeout of 0 uses the default of 5s.
+func NewWebhookSink(url, bearerToken string, timeout time.Duration) *WebhookSink {
+	if timeout == 0 {
+		timeout = defaultWebhookTimeout
+	}
+	s := &WebhookSink{
+		url:         url,
+		bearerToken: bearerToken,
+		client:      &http.Client{Timeout: timeout},
+		queue:       make(chan []byte, defaultWebhookQueueSize),
+		done:        make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Write queues the event to be sent to the webhook.
+func (s *WebhookSink) Write(e *Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling audit event")
+	}
+	select {
+	case s.queue <- b:
+		return nil
+	default:
+		return errors.Errorf("error sending audit event to %s: queue is full", s.url)
+	}
+}
+
+// Close waits until all the queued events have been sent.
+func (s *WebhookSink) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.queue)
+	})
+	<-s.done
+	return nil
+}
+
+func (s *WebhookSink) run() {
+	defer close(s.done)
+	for body := range s.queue {
+		if err := s.send(body); err != nil {
+			log.Printf("error sending audit event: %v", err)
+		}
+	}
+}
+
+func (s *WebhookSink) send(body []byte) error {
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrapf(err, "error creating request for url %s", s.url)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.bearerToken)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "error doing http POST for url %s", s.url)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("error doing http POST for url %s with status code %d", s.url, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package authority
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/nosql"
+)
+
+// AuthorizeAdmin returns an error if the admin API is not enabled or if the
+// given client certificate does not belong to an admin.
+func (a *Authority) AuthorizeAdmin(crt *x509.Certificate) error {
+	switch {
+	case crt == nil:
+		return errs.Unauthorized("authority.AuthorizeAdmin; missing client certificate")
+	case !a.config.AuthorityConfig.Admin.IsEnabled():
+		return errs.Unauthorized("authority.AuthorizeAdmin; admin API is not enabled")
+	case !a.config.AuthorityConfig.Admin.IsAdmin(crt):
+		return errs.Unauthorized("authority.AuthorizeAdmin; client certificate does not belong to an admin")
+	default:
+		return nil
+	}
+}
+
+// StoreProvisioner initializes the given provisioner, stores it in the
+// database and makes it available in the authority.
+func (a *Authority) StoreProvisioner(p provisioner.Interface) error {
+	a.adminMutex.Lock()
+	defer a.adminMutex.Unlock()
+
+	if err := p.Init(a.provisionerConfig); err != nil {
+		return errs.Wrap(http.StatusBadRequest, err, "authority.StoreProvisioner; error initializing provisioner")
+	}
+	id := p.GetID()
+	if _, ok := a.provisioners.Load(id); ok {
+		return errs.BadRequest("authority.StoreProvisioner; provisioner %s already exists", id)
+	}
+	if err := a.storeDBProvisioner(p); err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "authority.StoreProvisioner")
+	}
+	if err := a.provisioners.Store(p); err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "authority.StoreProvisioner")
+	}
+	a.dbProvisioners[id] = true
+	return nil
+}
+
+// UpdateProvisioner replaces the provisioner with the given id with the given
+// provisioner. Only provisioners created with the admin API can be updated.
+func (a *Authority) UpdateProvisioner(id string, p provisioner.Interface) error {
+	a.adminMutex.Lock()
+	defer a.adminMutex.Unlock()
+
+	if err := a.checkDBProvisioner(id); err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "authority.UpdateProvisioner")
+	}
+	if err := p.Init(a.provisionerConfig); err != nil {
+		return errs.Wrap(http.StatusBadRequest, err, "authority.UpdateProvisioner; error initializing provisioner")
+	}
+	newID := p.GetID()
+	if newID != id {
+		if _, ok := a.provisioners.Load(newID); ok {
+			return errs.BadRequest("authority.UpdateProvisioner; provisioner %s already exists", newID)
+		}
+	}
+	if err := a.storeDBProvisioner(p); err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "authority.UpdateProvisioner")
+	}
+	if newID != id {
+		if err := a.db.DeleteProvisioner(id); err != nil {
+			return errs.Wrap(http.StatusInternalServerError, err, "authority.UpdateProvisioner; error deleting provisioner")
+		}
+		delete(a.dbProvisioners, id)
+	}
+	if err := a.provisioners.Remove(id); err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "authority.UpdateProvisioner")
+	}
+	if err := a.provisioners.Store(p); err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "authority.UpdateProvisioner")
+	}
+	a.dbProvisioners[newID] = true
+	return nil
+}
+
+// RemoveProvisioner deletes the provisioner with the given id from the
+// database and the authority. Only provisioners created with the admin API can
+// be removed.
+func (a *Authority) RemoveProvisioner(id string) error {
+	a.adminMutex.Lock()
+	defer a.adminMutex.Unlock()
+
+	if err := a.checkDBProvisioner(id); err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "authority.RemoveProvisioner")
+	}
+	if err := a.db.DeleteProvisioner(id); err != nil && err != db.ErrNotFound {
+		return errs.Wrap(http.StatusInternalServerError, err, "authority.RemoveProvisioner; error deleting provisioner")
+	}
+	if err := a.provisioners.Remove(id); err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "authority.RemoveProvisioner")
+	}
+	delete(a.dbProvisioners, id)
+	return nil
+}
+
+// checkDBProvisioner returns an error if the provisioner with the given id
+// does not exist or if it was not created with the admin API.
+func (a *Authority) checkDBProvisioner(id string) error {
+	if _, ok := a.provisioners.Load(id); !ok {
+		return errs.NotFound("provisioner %s not found", id)
+	}
+	if !a.dbProvisioners[id] {
+		return errs.Forbidden("provisioner %s is defined in the configuration file and cannot be modified", id)
+	}
+	return nil
+}
+
+// storeDBProvisioner stores the JSON representation of the provisioner in the
+// database.
+func (a *Authority) storeDBProvisioner(p provisioner.Interface) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling provisioner")
+	}
+	if err := a.db.StoreProvisioner(p.GetID(), data); err != nil {
+		if err == db.ErrNotImplemented {
+			return errs.NotImplemented("the database does not support storing provisioners")
+		}
+		return errors.Wrap(err, "error storing provisioner")
+	}
+	return nil
+}
+
+// loadDBProvisioners initializes and loads the provisioners stored in the
+// database.
+func (a *Authority) loadDBProvisioners() error {
+	list, err := a.db.GetProvisioners()
+	if err != nil {
+		if err == db.ErrNotImplemented {
+			return nil
+		}
+		return errors.Wrap(err, "error loading provisioners from the database")
+	}
+	for _, data := range list {
+		p, err := provisioner.UnmarshalProvisioner(data)
+		if err != nil {
+			return errors.Wrap(err, "error loading provisioners from the database")
+		}
+		if err := p.Init(a.provisionerConfig); err != nil {
+			return errors.Wrapf(err, "error initializing provisioner %s", p.GetID())
+		}
+		if err := a.provisioners.Store(p); err != nil {
+			return errors.Wrapf(err, "error loading provisioner %s from the database", p.GetID())
+		}
+		a.dbProvisioners[p.GetID()] = true
+	}
+	return nil
+}
+
+// ExportDatabase writes to w an archive with all the CA and ACME data stored in
+// the database, see db.Export. It returns the number of keys exported.
+func (a *Authority) ExportDatabase(w io.Writer) (int, error) {
+	ndb, ok := a.db.(nosql.DB)
+	if !ok {
+		return 0, errs.NotImplemented("authority.ExportDatabase; the database does not support exports")
+	}
+	n, err := db.Export(ndb, w, append(db.Tables(), acme.Tables()...))
+	if err != nil {
+		return n, errs.Wrap(http.StatusInternalServerError, err, "authority.ExportDatabase")
+	}
+	return n, nil
+}
+
+// GetMigrationStatus returns the status of the database migration configured
+// with db.migrateFrom.
+func (a *Authority) GetMigrationStatus() (*db.MigrationStatus, error) {
+	if d, ok := a.db.(*db.DB); ok {
+		if status, ok := d.MigrationStatus(); ok {
+			return &status, nil
+		}
+	}
+	return nil, errs.NotFound("authority.GetMigrationStatus; the database is not being migrated")
+}
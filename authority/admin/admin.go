@@ -0,0 +1,128 @@
+// Package admin implements the authorization of the client certificates used
+// with the admin APIs of the CA and the ACME server.
+package admin
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+// Config enables an admin API. Requests to the admin API must be
+// authenticated with a client certificate issued by the CA that matches one of
+// the Admins. If it's not configured, the admin API is disabled.
+type Config struct {
+	Admins []*Admin `json:"admins"`
+}
+
+// Admin identifies the client certificates of an admin. A certificate belongs
+// to the admin if its SHA-256 fingerprint is Fingerprint, or if it has been
+// issued by the provisioner with the given ProvisionerType and Provisioner
+// name and its common name, or one of its DNS or email SANs, is Subject.
+//
+// The names in a certificate are only meaningful together with the
+// provisioner that issued it, any ACME client can get a certificate for a DNS
+// name it controls, so the Subject is never enough by itself.
+type Admin struct {
+	Subject         string `json:"subject,omitempty"`
+	Provisioner     string `json:"provisioner,omitempty"`
+	ProvisionerType string `json:"provisionerType,omitempty"`
+	Fingerprint     string `json:"fingerprint,omitempty"`
+}
+
+// Validate checks the fields in the Config.
+func (c *Config) Validate() error {
+	switch {
+	case c == nil:
+		return nil
+	case len(c.Admins) == 0:
+		return errors.New("admins cannot be empty")
+	default:
+		for i, a := range c.Admins {
+			if err := a.Validate(); err != nil {
+				return errors.Wrapf(err, "admins[%d]", i)
+			}
+		}
+		return nil
+	}
+}
+
+// IsEnabled returns true if the admin API is configured.
+func (c *Config) IsEnabled() bool {
+	return c != nil
+}
+
+// IsAdmin returns true if the given certificate belongs to one of the Admins.
+func (c *Config) IsAdmin(crt *x509.Certificate) bool {
+	if c == nil || crt == nil {
+		return false
+	}
+	for _, a := range c.Admins {
+		if a.matches(crt) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the fields in the Admin.
+func (a *Admin) Validate() error {
+	switch {
+	case a == nil:
+		return errors.New("admin cannot be empty")
+	case a.Fingerprint != "":
+		if a.Subject != "" || a.Provisioner != "" || a.ProvisionerType != "" {
+			return errors.New("fingerprint cannot be combined with subject or provisioner")
+		}
+		if b, err := hex.DecodeString(a.Fingerprint); err != nil || len(b) != sha256.Size {
+			return errors.Errorf("fingerprint %s is not a hex encoded SHA-256 hash", a.Fingerprint)
+		}
+		return nil
+	case a.Subject == "":
+		return errors.New("subject or fingerprint is required")
+	case a.Provisioner == "":
+		return errors.New("provisioner is required with subject")
+	case parseType(a.ProvisionerType) == 0:
+		return errors.Errorf("provisionerType %q is not valid", a.ProvisionerType)
+	default:
+		return nil
+	}
+}
+
+func (a *Admin) matches(crt *x509.Certificate) bool {
+	if a.Fingerprint != "" {
+		sum := sha256.Sum256(crt.Raw)
+		return strings.EqualFold(a.Fingerprint, hex.EncodeToString(sum[:]))
+	}
+	typ, name, ok := provisioner.CertificateProvisioner(crt)
+	if !ok || typ != parseType(a.ProvisionerType) || name != a.Provisioner {
+		return false
+	}
+	names := append([]string{crt.Subject.CommonName}, crt.DNSNames...)
+	names = append(names, crt.EmailAddresses...)
+	for _, name := range names {
+		if name != "" && strings.EqualFold(a.Subject, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseType returns the provisioner type with the given name, or 0 if it's
+// not valid.
+func parseType(s string) provisioner.Type {
+	for _, typ := range []provisioner.Type{
+		provisioner.TypeJWK, provisioner.TypeOIDC, provisioner.TypeGCP,
+		provisioner.TypeAWS, provisioner.TypeAzure, provisioner.TypeACME,
+		provisioner.TypeX5C, provisioner.TypeK8sSA, provisioner.TypeSSHPOP,
+	} {
+		if strings.EqualFold(s, typ.String()) {
+			return typ
+		}
+	}
+	return 0
+}
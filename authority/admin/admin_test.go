@@ -0,0 +1,100 @@
+package admin
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+// provisionerExtension returns the provisioner extension the CA adds to the
+// certificates issued by the given provisioner.
+func provisionerExtension(t *testing.T, typ provisioner.Type, name string) pkix.Extension {
+	t.Helper()
+	b, err := asn1.Marshal(struct {
+		Type         int
+		Name         []byte
+		CredentialID []byte
+	}{int(typ), []byte(name), nil})
+	assert.FatalError(t, err)
+	return pkix.Extension{
+		Id:    asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 37476, 9000, 64, 1},
+		Value: b,
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	fp := hex.EncodeToString(make([]byte, sha256.Size))
+	tests := map[string]struct {
+		c   *Config
+		err string
+	}{
+		"ok/nil":         {nil, ""},
+		"ok/subject":     {&Config{Admins: []*Admin{{Subject: "admin@example.com", Provisioner: "admin", ProvisionerType: "JWK"}}}, ""},
+		"ok/fingerprint": {&Config{Admins: []*Admin{{Fingerprint: fp}}}, ""},
+		"fail/empty":     {&Config{}, "admins cannot be empty"},
+		"fail/nil-admin": {&Config{Admins: []*Admin{nil}}, "admins[0]: admin cannot be empty"},
+		"fail/no-subject": {&Config{Admins: []*Admin{{Fingerprint: fp}, {Provisioner: "admin", ProvisionerType: "JWK"}}},
+			"admins[1]: subject or fingerprint is required"},
+		"fail/no-provisioner": {&Config{Admins: []*Admin{{Subject: "admin@example.com"}}},
+			"admins[0]: provisioner is required with subject"},
+		"fail/type": {&Config{Admins: []*Admin{{Subject: "admin@example.com", Provisioner: "admin", ProvisionerType: "foo"}}},
+			`admins[0]: provisionerType "foo" is not valid`},
+		"fail/fingerprint": {&Config{Admins: []*Admin{{Fingerprint: "abcd"}}},
+			"admins[0]: fingerprint abcd is not a hex encoded SHA-256 hash"},
+		"fail/fingerprint-and-subject": {&Config{Admins: []*Admin{{Fingerprint: fp, Subject: "admin@example.com"}}},
+			"admins[0]: fingerprint cannot be combined with subject or provisioner"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.c.Validate()
+			if tc.err == "" {
+				assert.Nil(t, err)
+			} else if assert.NotNil(t, err) {
+				assert.Equals(t, tc.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestConfig_IsAdmin(t *testing.T) {
+	jwk := provisionerExtension(t, provisioner.TypeJWK, "admin")
+	acme := provisionerExtension(t, provisioner.TypeACME, "admin")
+	other := provisionerExtension(t, provisioner.TypeJWK, "other")
+	raw := []byte("certificate")
+	sum := sha256.Sum256(raw)
+	c := &Config{Admins: []*Admin{
+		{Subject: "admin", Provisioner: "admin", ProvisionerType: "jwk"},
+		{Subject: "admin.example.com", Provisioner: "admin", ProvisionerType: "JWK"},
+		{Subject: "admin@example.com", Provisioner: "admin", ProvisionerType: "JWK"},
+		{Fingerprint: hex.EncodeToString(sum[:])},
+	}}
+	tests := map[string]struct {
+		c    *Config
+		crt  *x509.Certificate
+		want bool
+	}{
+		"ok/common-name":      {c, &x509.Certificate{Subject: pkix.Name{CommonName: "Admin"}, Extensions: []pkix.Extension{jwk}}, true},
+		"ok/dns":              {c, &x509.Certificate{DNSNames: []string{"foo", "admin.example.com"}, Extensions: []pkix.Extension{jwk}}, true},
+		"ok/email":            {c, &x509.Certificate{EmailAddresses: []string{"admin@example.com"}, Extensions: []pkix.Extension{jwk}}, true},
+		"ok/fingerprint":      {c, &x509.Certificate{Raw: raw}, true},
+		"fail/nil":            {nil, &x509.Certificate{Subject: pkix.Name{CommonName: "admin"}, Extensions: []pkix.Extension{jwk}}, false},
+		"fail/nil-crt":        {c, nil, false},
+		"fail/other":          {c, &x509.Certificate{Subject: pkix.Name{CommonName: "foo"}, Extensions: []pkix.Extension{jwk}}, false},
+		"fail/no-provisioner": {c, &x509.Certificate{DNSNames: []string{"admin.example.com"}}, false},
+		"fail/acme":           {c, &x509.Certificate{DNSNames: []string{"admin.example.com"}, Extensions: []pkix.Extension{acme}}, false},
+		"fail/provisioner":    {c, &x509.Certificate{DNSNames: []string{"admin.example.com"}, Extensions: []pkix.Extension{other}}, false},
+		"fail/two-extensions": {c, &x509.Certificate{DNSNames: []string{"admin.example.com"}, Extensions: []pkix.Extension{jwk, other}}, false},
+		"fail/fingerprint":    {c, &x509.Certificate{Raw: []byte("other")}, false},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equals(t, tc.want, tc.c.IsAdmin(tc.crt))
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package authority
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	"golang.org/x/crypto/ssh"
+)
+
+// Policy scopes used in the PolicyEvaluationResponse.
+const (
+	PolicyScopeAuthority   = "authority"
+	PolicyScopeProvisioner = "provisioner"
+)
+
+// PolicyEvaluationRequest is the set of names evaluated in a policy dry-run.
+// Type is the type of certificate, x509 by default, or the ssh user and host
+// types; x509 certificates use the common name and the SANs, and ssh
+// certificates use the principals. If Provisioner is set, the names are also
+// evaluated against the policy of that provisioner.
+type PolicyEvaluationRequest struct {
+	Provisioner    string   `json:"provisioner,omitempty"`
+	Type           string   `json:"type,omitempty"`
+	CommonName     string   `json:"commonName,omitempty"`
+	DNSNames       []string `json:"dns,omitempty"`
+	IPAddresses    []string `json:"ip,omitempty"`
+	EmailAddresses []string `json:"email,omitempty"`
+	URIs           []string `json:"uri,omitempty"`
+	Principals     []string `json:"principals,omitempty"`
+}
+
+// PolicyEvaluationResponse is the result of a policy dry-run. If the names are
+// not allowed, Scope is the policy that rejected them and Reason the cause.
+type PolicyEvaluationResponse struct {
+	Allowed bool   `json:"allowed"`
+	Scope   string `json:"scope,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// getPolicy returns the authority name policy engine.
+func (a *Authority) getPolicy() *policy.Engine {
+	a.policyMutex.RLock()
+	defer a.policyMutex.RUnlock()
+	return a.policy
+}
+
+// GetAuthorityPolicy returns the current authority policy, the one set with
+// the admin API or the one in the configuration file.
+func (a *Authority) GetAuthorityPolicy() (*policy.Options, error) {
+	a.policyMutex.RLock()
+	defer a.policyMutex.RUnlock()
+	switch {
+	case a.dbPolicy != nil:
+		return a.dbPolicy, nil
+	case a.config.AuthorityConfig.Policy != nil:
+		return a.config.AuthorityConfig.Policy, nil
+	default:
+		return nil, errs.NotFound("authority.GetAuthorityPolicy; policy not found")
+	}
+}
+
+// UpdateAuthorityPolicy validates and stores the given policy in the database,
+// and replaces the current authority policy with it.
+func (a *Authority) UpdateAuthorityPolicy(o *policy.Options) error {
+	if o == nil {
+		return errs.BadRequest("authority.UpdateAuthorityPolicy; policy cannot be empty")
+	}
+	engine, err := policy.New(o)
+	if err != nil {
+		return errs.Wrap(http.StatusBadRequest, err, "authority.UpdateAuthorityPolicy; error validating policy")
+	}
+	data, err := json.Marshal(o)
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "authority.UpdateAuthorityPolicy; error marshaling policy")
+	}
+
+	a.policyMutex.Lock()
+	defer a.policyMutex.Unlock()
+	if err := a.db.StoreAuthorityPolicy(data); err != nil {
+		if err == db.ErrNotImplemented {
+			return errs.NotImplemented("authority.UpdateAuthorityPolicy; the database does not support storing policies")
+		}
+		return errs.Wrap(http.StatusInternalServerError, err, "authority.UpdateAuthorityPolicy; error storing policy")
+	}
+	a.policy = engine
+	a.dbPolicy = o
+	return nil
+}
+
+// RemoveAuthorityPolicy deletes the policy set with the admin API, after this
+// the authority will use the policy in the configuration file.
+func (a *Authority) RemoveAuthorityPolicy() error {
+	a.policyMutex.Lock()
+	defer a.policyMutex.Unlock()
+	if a.dbPolicy == nil {
+		return errs.NotFound("authority.RemoveAuthorityPolicy; policy not found")
+	}
+	engine, err := policy.New(a.config.AuthorityConfig.Policy)
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "authority.RemoveAuthorityPolicy; error initializing policy")
+	}
+	if err := a.db.DeleteAuthorityPolicy(); err != nil && err != db.ErrNotFound {
+		return errs.Wrap(http.StatusInternalServerError, err, "authority.RemoveAuthorityPolicy; error deleting policy")
+	}
+	a.policy = engine
+	a.dbPolicy = nil
+	return nil
+}
+
+// GetProvisionerPolicy returns the policy of the provisioner with the given
+// id.
+func (a *Authority) GetProvisionerPolicy(id string) (*policy.Options, error) {
+	p, ok := a.provisioners.Load(id)
+	if !ok {
+		return nil, errs.NotFound("authority.GetProvisionerPolicy; provisioner %s not found", id)
+	}
+	o, err := provisionerPolicy(p)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetProvisionerPolicy")
+	}
+	if o == nil {
+		return nil, errs.NotFound("authority.GetProvisionerPolicy; policy not found")
+	}
+	return o, nil
+}
+
+// UpdateProvisionerPolicy replaces the policy of the provisioner with the
+// given id, a nil policy removes it. Only provisioners created with the admin
+// API can be updated.
+func (a *Authority) UpdateProvisionerPolicy(id string, o *policy.Options) error {
+	p, ok := a.provisioners.Load(id)
+	if !ok {
+		return errs.NotFound("authority.UpdateProvisionerPolicy; provisioner %s not found", id)
+	}
+	if _, err := policy.New(o); err != nil {
+		return errs.Wrap(http.StatusBadRequest, err, "authority.UpdateProvisionerPolicy; error validating policy")
+	}
+
+	// Set the policy in the JSON representation of the provisioner, this
+	// avoids having to know the concrete type of the provisioner.
+	b, err := json.Marshal(p)
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "authority.UpdateProvisionerPolicy; error marshaling provisioner")
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "authority.UpdateProvisionerPolicy; error unmarshaling provisioner")
+	}
+	if o == nil {
+		delete(m, "policy")
+	} else {
+		m["policy"] = o
+	}
+	if b, err = json.Marshal(m); err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "authority.UpdateProvisionerPolicy; error marshaling provisioner")
+	}
+	newP, err := provisioner.UnmarshalProvisioner(b)
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "authority.UpdateProvisionerPolicy")
+	}
+	if o != nil {
+		if po, err := provisionerPolicy(newP); err != nil || po == nil {
+			return errs.BadRequest("authority.UpdateProvisionerPolicy; provisioner %s does not support policies", id)
+		}
+	}
+	return a.UpdateProvisioner(id, newP)
+}
+
+// EvaluatePolicy checks if the names in the given request are allowed by the
+// authority policy and by the policy of the provisioner in the request. It
+// does not sign any certificate.
+func (a *Authority) EvaluatePolicy(req *PolicyEvaluationRequest) (*PolicyEvaluationResponse, error) {
+	var provisionerEngine *policy.Engine
+	if req.Provisioner != "" {
+		p, ok := a.provisioners.Load(req.Provisioner)
+		if !ok {
+			return nil, errs.NotFound("authority.EvaluatePolicy; provisioner %s not found", req.Provisioner)
+		}
+		o, err := provisionerPolicy(p)
+		if err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.EvaluatePolicy")
+		}
+		if provisionerEngine, err = policy.New(o); err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.EvaluatePolicy; error initializing provisioner policy")
+		}
+	}
+
+	var evaluate func(e *policy.Engine) error
+	switch req.Type {
+	case "", "x509":
+		cert := &x509.Certificate{
+			Subject:        pkix.Name{CommonName: req.CommonName},
+			DNSNames:       req.DNSNames,
+			EmailAddresses: req.EmailAddresses,
+		}
+		for _, s := range req.IPAddresses {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, errs.BadRequest("authority.EvaluatePolicy; ip address %s is not valid", s)
+			}
+			cert.IPAddresses = append(cert.IPAddresses, ip)
+		}
+		for _, s := range req.URIs {
+			u, err := url.Parse(s)
+			if err != nil {
+				return nil, errs.BadRequest("authority.EvaluatePolicy; uri %s is not valid", s)
+			}
+			cert.URIs = append(cert.URIs, u)
+		}
+		evaluate = func(e *policy.Engine) error {
+			return e.IsX509CertificateAllowed(cert)
+		}
+	case provisioner.SSHUserCert, provisioner.SSHHostCert:
+		cert := &ssh.Certificate{
+			CertType:        ssh.UserCert,
+			ValidPrincipals: req.Principals,
+		}
+		if req.Type == provisioner.SSHHostCert {
+			cert.CertType = ssh.HostCert
+		}
+		evaluate = func(e *policy.Engine) error {
+			return e.IsSSHCertificateAllowed(cert)
+		}
+	default:
+		return nil, errs.BadRequest("authority.EvaluatePolicy; type %s is not valid", req.Type)
+	}
+
+	if err := evaluate(a.getPolicy()); err != nil {
+		return &PolicyEvaluationResponse{Scope: PolicyScopeAuthority, Reason: err.Error()}, nil
+	}
+	if err := evaluate(provisionerEngine); err != nil {
+		return &PolicyEvaluationResponse{Scope: PolicyScopeProvisioner, Reason: err.Error()}, nil
+	}
+	return &PolicyEvaluationResponse{Allowed: true}, nil
+}
+
+// loadDBPolicy loads the authority policy stored in the database.
+func (a *Authority) loadDBPolicy() error {
+	data, err := a.db.GetAuthorityPolicy()
+	if err != nil {
+		if err == db.ErrNotFound || err == db.ErrNotImplemented {
+			return nil
+		}
+		return errors.Wrap(err, "error loading policy from the database")
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	o := new(policy.Options)
+	if err := json.Unmarshal(data, o); err != nil {
+		return errors.Wrap(err, "error unmarshaling policy from the database")
+	}
+	engine, err := policy.New(o)
+	if err != nil {
+		return errors.Wrap(err, "error initializing policy from the database")
+	}
+	a.policy = engine
+	a.dbPolicy = o
+	return nil
+}
+
+// provisionerPolicy returns the policy options of a provisioner.
+func provisionerPolicy(p provisioner.Interface) (*policy.Options, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling provisioner")
+	}
+	var v struct {
+		Policy *policy.Options `json:"policy"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, errors.Wrap(err, "error unmarshaling provisioner")
+	}
+	return v.Policy, nil
+}
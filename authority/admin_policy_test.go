@@ -0,0 +1,296 @@
+package authority
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/policy"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+)
+
+func assertStatusError(t *testing.T, err error, msg string, code int) {
+	t.Helper()
+	if assert.NotNil(t, err) {
+		assert.Equals(t, msg, err.Error())
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+		assert.Equals(t, code, sc.StatusCode())
+	}
+}
+
+func TestAuthority_AuthorityPolicy(t *testing.T) {
+	configPolicy := &policy.Options{
+		X509: &policy.X509Options{Allow: &policy.X509NameOptions{DNSDomains: []string{"*.example.com"}}},
+	}
+	dbPolicy := &policy.Options{
+		X509: &policy.X509Options{Deny: &policy.X509NameOptions{DNSDomains: []string{"*.internal.example.com"}}},
+	}
+
+	var stored json.RawMessage
+	mdb := &db.MockAuthDB{
+		MGetAuthorityPolicy: func() (json.RawMessage, error) {
+			if stored == nil {
+				return nil, db.ErrNotFound
+			}
+			return stored, nil
+		},
+		MStoreAuthorityPolicy: func(data json.RawMessage) error {
+			stored = data
+			return nil
+		},
+		MDeleteAuthorityPolicy: func() error {
+			if stored == nil {
+				return db.ErrNotFound
+			}
+			stored = nil
+			return nil
+		},
+	}
+
+	a := testAuthority(t, WithDatabase(mdb))
+
+	// No policy
+	_, err := a.GetAuthorityPolicy()
+	assertStatusError(t, err, "authority.GetAuthorityPolicy; policy not found", http.StatusNotFound)
+	assertStatusError(t, a.RemoveAuthorityPolicy(), "authority.RemoveAuthorityPolicy; policy not found", http.StatusNotFound)
+
+	// Validation errors
+	assertStatusError(t, a.UpdateAuthorityPolicy(nil), "authority.UpdateAuthorityPolicy; policy cannot be empty", http.StatusBadRequest)
+	err = a.UpdateAuthorityPolicy(&policy.Options{
+		X509: &policy.X509Options{Allow: &policy.X509NameOptions{IPRanges: []string{"foo"}}},
+	})
+	assert.NotNil(t, err)
+	sc, ok := err.(errs.StatusCoder)
+	assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+	assert.Equals(t, http.StatusBadRequest, sc.StatusCode())
+
+	// Policy in the configuration
+	a.config.AuthorityConfig.Policy = configPolicy
+	o, err := a.GetAuthorityPolicy()
+	assert.FatalError(t, err)
+	assert.Equals(t, configPolicy, o)
+
+	// Policy in the database
+	assert.FatalError(t, a.UpdateAuthorityPolicy(dbPolicy))
+	o, err = a.GetAuthorityPolicy()
+	assert.FatalError(t, err)
+	assert.Equals(t, dbPolicy, o)
+	assert.NotNil(t, stored)
+	res, err := a.EvaluatePolicy(&PolicyEvaluationRequest{DNSNames: []string{"db.internal.example.com"}})
+	assert.FatalError(t, err)
+	assert.False(t, res.Allowed)
+
+	// A new authority loads the policy from the database
+	a = testAuthority(t, WithDatabase(mdb))
+	o, err = a.GetAuthorityPolicy()
+	assert.FatalError(t, err)
+	assert.Equals(t, dbPolicy, o)
+
+	// Remove the database policy
+	a.config.AuthorityConfig.Policy = configPolicy
+	assert.FatalError(t, a.RemoveAuthorityPolicy())
+	assert.Nil(t, stored)
+	o, err = a.GetAuthorityPolicy()
+	assert.FatalError(t, err)
+	assert.Equals(t, configPolicy, o)
+}
+
+func TestAuthority_UpdateAuthorityPolicy_database(t *testing.T) {
+	o := &policy.Options{
+		X509: &policy.X509Options{Allow: &policy.X509NameOptions{DNSDomains: []string{"*.example.com"}}},
+	}
+
+	a := testAuthority(t)
+	assertStatusError(t, a.UpdateAuthorityPolicy(o), "authority.UpdateAuthorityPolicy; the database does not support storing policies", http.StatusNotImplemented)
+
+	a.db = &db.MockAuthDB{Err: errors.New("force")}
+	assertStatusError(t, a.UpdateAuthorityPolicy(o), "authority.UpdateAuthorityPolicy; error storing policy: force", http.StatusInternalServerError)
+	assert.Nil(t, a.getPolicy())
+}
+
+func TestAuthority_loadDBPolicy(t *testing.T) {
+	tests := map[string]struct {
+		data json.RawMessage
+		err  error
+		want string
+	}{
+		"ok/not-found":       {err: db.ErrNotFound},
+		"ok/not-implemented": {err: db.ErrNotImplemented},
+		"ok":                 {data: json.RawMessage(`{"ssh":{"user":{"deny":{"principal":["root"]}}}}`)},
+		"fail/db":            {err: errors.New("force"), want: "error loading policy from the database: force"},
+		"fail/json":          {data: json.RawMessage(`{`), want: "error unmarshaling policy from the database: unexpected end of JSON input"},
+		"fail/policy":        {data: json.RawMessage(`{"x509":{"allow":{"ip":["foo"]}}}`), want: "error initializing policy from the database: x509: allow: ip: ip address foo is not valid"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			a := testAuthority(t)
+			a.db = &db.MockAuthDB{
+				MGetAuthorityPolicy: func() (json.RawMessage, error) {
+					return tc.data, tc.err
+				},
+			}
+			err := a.loadDBPolicy()
+			if tc.want == "" {
+				assert.Nil(t, err)
+				if tc.data != nil {
+					assert.NotNil(t, a.getPolicy())
+					assert.NotNil(t, a.dbPolicy)
+				}
+			} else if assert.NotNil(t, err) {
+				assert.HasPrefix(t, err.Error(), tc.want)
+			}
+		})
+	}
+}
+
+func TestAuthority_ProvisionerPolicy(t *testing.T) {
+	m := map[string]json.RawMessage{
+		"acme/acme":   json.RawMessage(`{"type":"ACME","name":"acme","policy":{"x509":{"allow":{"dns":["*.example.com"]}}}}`),
+		"sshpop/pop2": json.RawMessage(`{"type":"SSHPOP","name":"pop2"}`),
+	}
+	mdb := mockProvisionersDB(m)
+	mdb.MGetAuthorityPolicy = func() (json.RawMessage, error) {
+		return nil, db.ErrNotFound
+	}
+	a := testAuthority(t, WithDatabase(mdb))
+
+	// Get
+	o, err := a.GetProvisionerPolicy("acme/acme")
+	assert.FatalError(t, err)
+	assert.Equals(t, []string{"*.example.com"}, o.X509.Allow.DNSDomains)
+	_, err = a.GetProvisionerPolicy("acme/foo")
+	assertStatusError(t, err, "authority.GetProvisionerPolicy; provisioner acme/foo not found", http.StatusNotFound)
+	_, err = a.GetProvisionerPolicy("sshpop/sshpop")
+	assertStatusError(t, err, "authority.GetProvisionerPolicy; policy not found", http.StatusNotFound)
+
+	// Update
+	newPolicy := &policy.Options{
+		X509: &policy.X509Options{Allow: &policy.X509NameOptions{DNSDomains: []string{"*.smallstep.com"}}},
+	}
+	assertStatusError(t, a.UpdateProvisionerPolicy("acme/foo", newPolicy), "authority.UpdateProvisionerPolicy; provisioner acme/foo not found", http.StatusNotFound)
+	assertStatusError(t, a.UpdateProvisionerPolicy("sshpop/sshpop", newPolicy), "authority.UpdateProvisionerPolicy; provisioner sshpop/sshpop does not support policies", http.StatusBadRequest)
+	assertStatusError(t, a.UpdateProvisionerPolicy("sshpop/pop2", newPolicy), "authority.UpdateProvisionerPolicy; provisioner sshpop/pop2 does not support policies", http.StatusBadRequest)
+	assertStatusError(t, a.UpdateProvisionerPolicy("sshpop/sshpop", nil), "authority.UpdateProvisioner: provisioner sshpop/sshpop is defined in the configuration file and cannot be modified", http.StatusForbidden)
+	err = a.UpdateProvisionerPolicy("acme/acme", &policy.Options{
+		X509: &policy.X509Options{Allow: &policy.X509NameOptions{IPRanges: []string{"foo"}}},
+	})
+	assert.HasPrefix(t, err.Error(), "authority.UpdateProvisionerPolicy; error validating policy")
+
+	assert.FatalError(t, a.UpdateProvisionerPolicy("acme/acme", newPolicy))
+	o, err = a.GetProvisionerPolicy("acme/acme")
+	assert.FatalError(t, err)
+	assert.Equals(t, newPolicy, o)
+	assert.True(t, len(m["acme/acme"]) > 0)
+
+	res, err := a.EvaluatePolicy(&PolicyEvaluationRequest{Provisioner: "acme/acme", DNSNames: []string{"foo.example.com"}})
+	assert.FatalError(t, err)
+	assert.Equals(t, &PolicyEvaluationResponse{
+		Scope:  PolicyScopeProvisioner,
+		Reason: "dns name foo.example.com is not allowed by the policy",
+	}, res)
+
+	// Remove
+	assert.FatalError(t, a.UpdateProvisionerPolicy("acme/acme", nil))
+	_, err = a.GetProvisionerPolicy("acme/acme")
+	assertStatusError(t, err, "authority.GetProvisionerPolicy; policy not found", http.StatusNotFound)
+}
+
+func TestAuthority_EvaluatePolicy(t *testing.T) {
+	a := testAuthority(t)
+	a.policy, _ = policy.New(&policy.Options{
+		X509: &policy.X509Options{
+			Allow: &policy.X509NameOptions{
+				DNSDomains:     []string{"*.example.com"},
+				IPRanges:       []string{"10.0.0.0/8"},
+				EmailAddresses: []string{"@example.com"},
+				URIDomains:     []string{"*.example.com"},
+			},
+		},
+		SSH: &policy.SSHOptions{
+			User: &policy.SSHUserOptions{Deny: &policy.SSHUserNameOptions{Principals: []string{"root"}}},
+			Host: &policy.SSHHostOptions{Allow: &policy.SSHHostNameOptions{DNSDomains: []string{"*.example.com"}}},
+		},
+	})
+
+	tests := map[string]struct {
+		req  *PolicyEvaluationRequest
+		want *PolicyEvaluationResponse
+		err  string
+		code int
+	}{
+		"ok/x509": {
+			req: &PolicyEvaluationRequest{
+				CommonName:     "www.example.com",
+				DNSNames:       []string{"www.example.com"},
+				IPAddresses:    []string{"10.0.0.1"},
+				EmailAddresses: []string{"jane@example.com"},
+				URIs:           []string{"spiffe://foo.example.com/bar"},
+			},
+			want: &PolicyEvaluationResponse{Allowed: true},
+		},
+		"ok/x509-denied": {
+			req: &PolicyEvaluationRequest{Type: "x509", IPAddresses: []string{"192.168.0.1"}},
+			want: &PolicyEvaluationResponse{
+				Scope:  PolicyScopeAuthority,
+				Reason: "ip address 192.168.0.1 is not allowed by the policy",
+			},
+		},
+		"ok/ssh-user": {
+			req:  &PolicyEvaluationRequest{Type: "user", Principals: []string{"jane"}},
+			want: &PolicyEvaluationResponse{Allowed: true},
+		},
+		"ok/ssh-user-denied": {
+			req: &PolicyEvaluationRequest{Type: "user", Principals: []string{"jane", "root"}},
+			want: &PolicyEvaluationResponse{
+				Scope:  PolicyScopeAuthority,
+				Reason: "principal root is denied by the policy",
+			},
+		},
+		"ok/ssh-host-denied": {
+			req: &PolicyEvaluationRequest{Type: "host", Principals: []string{"host.smallstep.com"}},
+			want: &PolicyEvaluationResponse{
+				Scope:  PolicyScopeAuthority,
+				Reason: "principal host.smallstep.com is not allowed by the policy",
+			},
+		},
+		"ok/provisioner-without-policy": {
+			req:  &PolicyEvaluationRequest{Provisioner: "sshpop/sshpop", DNSNames: []string{"www.example.com"}},
+			want: &PolicyEvaluationResponse{Allowed: true},
+		},
+		"fail/provisioner": {
+			req:  &PolicyEvaluationRequest{Provisioner: "acme/foo"},
+			err:  "authority.EvaluatePolicy; provisioner acme/foo not found",
+			code: http.StatusNotFound,
+		},
+		"fail/type": {
+			req:  &PolicyEvaluationRequest{Type: "foo"},
+			err:  "authority.EvaluatePolicy; type foo is not valid",
+			code: http.StatusBadRequest,
+		},
+		"fail/ip": {
+			req:  &PolicyEvaluationRequest{IPAddresses: []string{"foo"}},
+			err:  "authority.EvaluatePolicy; ip address foo is not valid",
+			code: http.StatusBadRequest,
+		},
+		"fail/uri": {
+			req:  &PolicyEvaluationRequest{URIs: []string{"%"}},
+			err:  "authority.EvaluatePolicy; uri % is not valid",
+			code: http.StatusBadRequest,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := a.EvaluatePolicy(tc.req)
+			if tc.err != "" {
+				assertStatusError(t, err, tc.err, tc.code)
+				return
+			}
+			assert.FatalError(t, err)
+			assert.Equals(t, tc.want, got)
+		})
+	}
+}
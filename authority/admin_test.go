@@ -0,0 +1,287 @@
+package authority
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/admin"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+)
+
+func TestAuthority_AuthorizeAdmin(t *testing.T) {
+	sum := sha256.Sum256([]byte("certificate"))
+	fp := hex.EncodeToString(sum[:])
+	crt := &x509.Certificate{Raw: []byte("certificate"), Subject: pkix.Name{CommonName: "admin"}}
+	tests := map[string]struct {
+		admin *admin.Config
+		err   string
+	}{
+		"fail/disabled":  {nil, "authority.AuthorizeAdmin; admin API is not enabled"},
+		"fail/not-admin": {&admin.Config{Admins: []*admin.Admin{{Subject: "admin", Provisioner: "admin", ProvisionerType: "JWK"}}}, "authority.AuthorizeAdmin; client certificate does not belong to an admin"},
+		"ok":             {&admin.Config{Admins: []*admin.Admin{{Fingerprint: fp}}}, ""},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			a := testAuthority(t)
+			a.config.AuthorityConfig.Admin = tc.admin
+			err := a.AuthorizeAdmin(crt)
+			if tc.err == "" {
+				assert.Nil(t, err)
+			} else if assert.NotNil(t, err) {
+				assert.Equals(t, tc.err, err.Error())
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, http.StatusUnauthorized, sc.StatusCode())
+			}
+		})
+	}
+}
+
+// mockProvisionersDB returns a db.MockAuthDB that stores the provisioners in
+// the given map.
+func mockProvisionersDB(m map[string]json.RawMessage) *db.MockAuthDB {
+	return &db.MockAuthDB{
+		MGetProvisioners: func() ([]json.RawMessage, error) {
+			var list []json.RawMessage
+			for _, v := range m {
+				list = append(list, v)
+			}
+			return list, nil
+		},
+		MStoreProvisioner: func(id string, data json.RawMessage) error {
+			m[id] = data
+			return nil
+		},
+		MDeleteProvisioner: func(id string) error {
+			if _, ok := m[id]; !ok {
+				return db.ErrNotFound
+			}
+			delete(m, id)
+			return nil
+		},
+	}
+}
+
+func TestAuthority_StoreProvisioner(t *testing.T) {
+	tests := map[string]struct {
+		db   db.AuthDB
+		p    provisioner.Interface
+		err  string
+		code int
+	}{
+		"ok": {
+			db: mockProvisionersDB(map[string]json.RawMessage{}),
+			p:  &provisioner.ACME{Type: "ACME", Name: "acme"},
+		},
+		"fail/init": {
+			db:   mockProvisionersDB(map[string]json.RawMessage{}),
+			p:    &provisioner.ACME{Type: "ACME"},
+			err:  "authority.StoreProvisioner; error initializing provisioner: provisioner name cannot be empty",
+			code: http.StatusBadRequest,
+		},
+		"fail/exists": {
+			db:   mockProvisionersDB(map[string]json.RawMessage{}),
+			p:    &provisioner.SSHPOP{Type: "SSHPOP", Name: "sshpop"},
+			err:  "authority.StoreProvisioner; provisioner sshpop/sshpop already exists",
+			code: http.StatusBadRequest,
+		},
+		"fail/not-implemented": {
+			db:   &db.MockAuthDB{MGetProvisioners: func() ([]json.RawMessage, error) { return nil, nil }, MGetAuthorityPolicy: func() (json.RawMessage, error) { return nil, db.ErrNotFound }, Err: db.ErrNotImplemented},
+			p:    &provisioner.ACME{Type: "ACME", Name: "acme"},
+			err:  "authority.StoreProvisioner: the database does not support storing provisioners",
+			code: http.StatusNotImplemented,
+		},
+		"fail/db": {
+			db:   &db.MockAuthDB{MGetProvisioners: func() ([]json.RawMessage, error) { return nil, nil }, MGetAuthorityPolicy: func() (json.RawMessage, error) { return nil, db.ErrNotFound }, Err: errors.New("force")},
+			p:    &provisioner.ACME{Type: "ACME", Name: "acme"},
+			err:  "authority.StoreProvisioner: error storing provisioner: force",
+			code: http.StatusInternalServerError,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			a := testAuthority(t, WithDatabase(tc.db))
+			err := a.StoreProvisioner(tc.p)
+			if tc.err != "" {
+				if assert.NotNil(t, err) {
+					assert.Equals(t, tc.err, err.Error())
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, tc.code, sc.StatusCode())
+				}
+				return
+			}
+			assert.FatalError(t, err)
+			p, err := a.LoadProvisionerByID(tc.p.GetID())
+			assert.FatalError(t, err)
+			assert.Equals(t, tc.p, p)
+
+			// A new authority loads the provisioner from the database.
+			a = testAuthority(t, WithDatabase(tc.db))
+			p, err = a.LoadProvisionerByID(tc.p.GetID())
+			assert.FatalError(t, err)
+			assert.Equals(t, tc.p.GetName(), p.GetName())
+		})
+	}
+}
+
+func TestAuthority_UpdateProvisioner(t *testing.T) {
+	m := map[string]json.RawMessage{
+		"acme/acme": json.RawMessage(`{"type":"ACME","name":"acme"}`),
+	}
+	tests := map[string]struct {
+		id   string
+		p    provisioner.Interface
+		err  string
+		code int
+	}{
+		"fail/not-found": {
+			id:   "acme/foo",
+			p:    &provisioner.ACME{Type: "ACME", Name: "foo"},
+			err:  "authority.UpdateProvisioner: provisioner acme/foo not found",
+			code: http.StatusNotFound,
+		},
+		"fail/config": {
+			id:   "sshpop/sshpop",
+			p:    &provisioner.SSHPOP{Type: "SSHPOP", Name: "sshpop"},
+			err:  "authority.UpdateProvisioner: provisioner sshpop/sshpop is defined in the configuration file and cannot be modified",
+			code: http.StatusForbidden,
+		},
+		"fail/init": {
+			id:   "acme/acme",
+			p:    &provisioner.ACME{Type: "ACME"},
+			err:  "authority.UpdateProvisioner; error initializing provisioner: provisioner name cannot be empty",
+			code: http.StatusBadRequest,
+		},
+		"fail/exists": {
+			id:   "acme/acme",
+			p:    &provisioner.SSHPOP{Type: "SSHPOP", Name: "sshpop"},
+			err:  "authority.UpdateProvisioner; provisioner sshpop/sshpop already exists",
+			code: http.StatusBadRequest,
+		},
+		"ok": {
+			id: "acme/acme",
+			p:  &provisioner.ACME{Type: "ACME", Name: "acme", TermsOfService: "https://example.com/tos"},
+		},
+		"ok/rename": {
+			id: "acme/acme",
+			p:  &provisioner.ACME{Type: "ACME", Name: "renamed"},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			dbProvisioners := map[string]json.RawMessage{}
+			for k, v := range m {
+				dbProvisioners[k] = v
+			}
+			a := testAuthority(t, WithDatabase(mockProvisionersDB(dbProvisioners)))
+			err := a.UpdateProvisioner(tc.id, tc.p)
+			if tc.err != "" {
+				if assert.NotNil(t, err) {
+					assert.Equals(t, tc.err, err.Error())
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, tc.code, sc.StatusCode())
+				}
+				return
+			}
+			assert.FatalError(t, err)
+			p, err := a.LoadProvisionerByID(tc.p.GetID())
+			assert.FatalError(t, err)
+			assert.Equals(t, tc.p, p)
+			if tc.p.GetID() != tc.id {
+				_, err := a.LoadProvisionerByID(tc.id)
+				assert.NotNil(t, err)
+				_, ok := dbProvisioners[tc.id]
+				assert.False(t, ok)
+			}
+			_, ok := dbProvisioners[tc.p.GetID()]
+			assert.True(t, ok)
+		})
+	}
+}
+
+func TestAuthority_RemoveProvisioner(t *testing.T) {
+	m := map[string]json.RawMessage{
+		"acme/acme": json.RawMessage(`{"type":"ACME","name":"acme"}`),
+	}
+	a := testAuthority(t, WithDatabase(mockProvisionersDB(m)))
+
+	tests := map[string]struct {
+		id   string
+		err  string
+		code int
+	}{
+		"fail/config": {
+			id:   "sshpop/sshpop",
+			err:  "authority.RemoveProvisioner: provisioner sshpop/sshpop is defined in the configuration file and cannot be modified",
+			code: http.StatusForbidden,
+		},
+		"ok": {
+			id: "acme/acme",
+		},
+		"fail/not-found": {
+			id:   "acme/acme",
+			err:  "authority.RemoveProvisioner: provisioner acme/acme not found",
+			code: http.StatusNotFound,
+		},
+	}
+	for _, name := range []string{"fail/config", "ok", "fail/not-found"} {
+		tc := tests[name]
+		t.Run(name, func(t *testing.T) {
+			err := a.RemoveProvisioner(tc.id)
+			if tc.err != "" {
+				if assert.NotNil(t, err) {
+					assert.Equals(t, tc.err, err.Error())
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, tc.code, sc.StatusCode())
+				}
+				return
+			}
+			assert.FatalError(t, err)
+			_, err = a.LoadProvisionerByID(tc.id)
+			assert.NotNil(t, err)
+			assert.Equals(t, 0, len(m))
+		})
+	}
+}
+
+func TestAuthority_loadDBProvisioners(t *testing.T) {
+	tests := map[string]struct {
+		list []json.RawMessage
+		err  error
+		want string
+	}{
+		"ok/not-implemented": {err: db.ErrNotImplemented},
+		"fail/db":            {err: errors.New("force"), want: "error loading provisioners from the database: force"},
+		"fail/unmarshal":     {list: []json.RawMessage{json.RawMessage(`{"type":"foo"}`)}, want: "error loading provisioners from the database: unsupported provisioner type \"foo\""},
+		"fail/init":          {list: []json.RawMessage{json.RawMessage(`{"type":"ACME"}`)}, want: "error initializing provisioner acme/: provisioner name cannot be empty"},
+		"fail/duplicated":    {list: []json.RawMessage{json.RawMessage(`{"type":"SSHPOP","name":"sshpop"}`)}, want: "error loading provisioner sshpop/sshpop from the database: cannot add multiple provisioners with the same id"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			a := testAuthority(t)
+			a.db = &db.MockAuthDB{
+				MGetProvisioners: func() ([]json.RawMessage, error) {
+					return tc.list, tc.err
+				},
+			}
+			err := a.loadDBProvisioners()
+			if tc.want == "" {
+				assert.Nil(t, err)
+			} else if assert.NotNil(t, err) {
+				assert.Equals(t, tc.want, err.Error())
+			}
+		})
+	}
+}
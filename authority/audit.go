@@ -0,0 +1,80 @@
+package authority
+
+import (
+	"context"
+	"crypto/x509"
+	"strconv"
+	"time"
+
+	"github.com/smallstep/certificates/audit"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"golang.org/x/crypto/ssh"
+)
+
+// auditToken records the use of a one-time token in the audit log.
+func (a *Authority) auditToken(ctx context.Context, typ string, p provisioner.Interface, subject, tokenID string) {
+	a.audit.Log(&audit.Event{
+		Type:        typ,
+		ClientIP:    audit.ClientIPFromContext(ctx),
+		Provisioner: p.GetName(),
+		Subject:     subject,
+		Details: map[string]string{
+			"tokenID": tokenID,
+		},
+	})
+}
+
+// auditX509Certificate records the issuance of an X.509 certificate in the
+// audit log.
+func (a *Authority) auditX509Certificate(method string, cert *x509.Certificate) {
+	if a.audit == nil {
+		return
+	}
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		sans = append(sans, u.String())
+	}
+	e := &audit.Event{
+		Type:    audit.CertificateIssued,
+		Subject: cert.Subject.CommonName,
+		Serial:  cert.SerialNumber.String(),
+		SANs:    sans,
+		Details: map[string]string{
+			"type":     "x509",
+			"method":   method,
+			"notAfter": cert.NotAfter.UTC().Format(time.RFC3339),
+		},
+	}
+	if p, ok := a.provisioners.LoadByCertificate(cert); ok {
+		e.Provisioner = p.GetName()
+	}
+	a.audit.Log(e)
+}
+
+// auditSSHCertificate records the issuance of an SSH certificate in the audit
+// log.
+func (a *Authority) auditSSHCertificate(method string, cert *ssh.Certificate) {
+	if a.audit == nil {
+		return
+	}
+	certType := "user"
+	if cert.CertType == ssh.HostCert {
+		certType = "host"
+	}
+	a.audit.Log(&audit.Event{
+		Type:    audit.CertificateIssued,
+		Subject: cert.KeyId,
+		Serial:  strconv.FormatUint(cert.Serial, 10),
+		SANs:    cert.ValidPrincipals,
+		Details: map[string]string{
+			"type":     "ssh",
+			"certType": certType,
+			"method":   method,
+		},
+	})
+}
@@ -0,0 +1,112 @@
+package authority
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/audit"
+	"github.com/smallstep/cli/jose"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+type auditSink struct {
+	events []*audit.Event
+}
+
+func (s *auditSink) Write(e *audit.Event) error {
+	c := *e
+	s.events = append(s.events, &c)
+	return nil
+}
+
+func (s *auditSink) Close() error {
+	return nil
+}
+
+func TestAuthority_auditToken(t *testing.T) {
+	sink := new(auditSink)
+	a := testAuthority(t, WithAuditLogger(audit.New(nil, sink)))
+	assert.NotNil(t, a.GetAuditLogger())
+
+	jwk, err := jose.ParseKey("testdata/secrets/step_cli_key_priv.jwk", jose.WithPassword([]byte("pass")))
+	assert.FatalError(t, err)
+	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: jwk.Key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", jwk.KeyID))
+	assert.FatalError(t, err)
+	now := time.Now().UTC()
+	raw, err := jwt.Signed(sig).Claims(jwt.Claims{
+		Subject:   "test.smallstep.com",
+		Issuer:    "step-cli",
+		NotBefore: jwt.NewNumericDate(now),
+		Expiry:    jwt.NewNumericDate(now.Add(time.Minute)),
+		Audience:  []string{"https://example.com/revoke"},
+		ID:        "43",
+	}).CompactSerialize()
+	assert.FatalError(t, err)
+
+	ctx := audit.NewContextWithClientIP(context.Background(), "10.0.0.1")
+	_, err = a.authorizeToken(ctx, raw)
+	assert.FatalError(t, err)
+	_, err = a.authorizeToken(ctx, raw)
+	assert.NotNil(t, err)
+
+	if assert.Equals(t, 2, len(sink.events)) {
+		assert.Equals(t, audit.TokenUsed, sink.events[0].Type)
+		assert.Equals(t, "step-cli", sink.events[0].Provisioner)
+		assert.Equals(t, "test.smallstep.com", sink.events[0].Subject)
+		assert.Equals(t, "10.0.0.1", sink.events[0].ClientIP)
+		assert.Equals(t, audit.TokenReused, sink.events[1].Type)
+		assert.Equals(t, sink.events[0].Details["tokenID"], sink.events[1].Details["tokenID"])
+	}
+}
+
+func TestAuthority_auditCertificate(t *testing.T) {
+	sink := new(auditSink)
+	a := testAuthority(t, WithAuditLogger(audit.New(nil, sink)))
+
+	notAfter := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	a.auditX509Certificate(issueMethodRenew, &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "foo.example.com"},
+		SerialNumber:   big.NewInt(1234),
+		NotAfter:       notAfter,
+		DNSNames:       []string{"foo.example.com"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+		EmailAddresses: []string{"foo@example.com"},
+		URIs:           []*url.URL{{Scheme: "spiffe", Host: "example.com", Path: "/foo"}},
+	})
+	a.auditSSHCertificate(issueMethodSign, &ssh.Certificate{
+		KeyId:           "foo.example.com",
+		Serial:          5678,
+		CertType:        ssh.HostCert,
+		ValidPrincipals: []string{"foo.example.com"},
+	})
+
+	if assert.Equals(t, 2, len(sink.events)) {
+		e := sink.events[0]
+		assert.Equals(t, audit.CertificateIssued, e.Type)
+		assert.Equals(t, "foo.example.com", e.Subject)
+		assert.Equals(t, "1234", e.Serial)
+		assert.Equals(t, []string{"foo.example.com", "10.0.0.1", "foo@example.com", "spiffe://example.com/foo"}, e.SANs)
+		assert.Equals(t, map[string]string{"type": "x509", "method": "renew", "notAfter": "2030-01-01T00:00:00Z"}, e.Details)
+
+		e = sink.events[1]
+		assert.Equals(t, audit.CertificateIssued, e.Type)
+		assert.Equals(t, "5678", e.Serial)
+		assert.Equals(t, []string{"foo.example.com"}, e.SANs)
+		assert.Equals(t, map[string]string{"type": "ssh", "certType": "host", "method": "sign"}, e.Details)
+		assert.Equals(t, sink.events[0].Hash, e.PrevHash)
+	}
+
+	// Without an audit log the events are discarded.
+	a = testAuthority(t)
+	assert.Nil(t, a.GetAuditLogger())
+	a.auditSSHCertificate(issueMethodSign, &ssh.Certificate{})
+}
@@ -6,14 +6,20 @@ import (
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/hex"
+	"log"
 	"sync"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/audit"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/cas"
 	"github.com/smallstep/certificates/db"
 	"github.com/smallstep/certificates/kms"
 	kmsapi "github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/secrets"
 	"github.com/smallstep/certificates/sshutil"
 	"github.com/smallstep/certificates/templates"
 	"github.com/smallstep/cli/crypto/pemutil"
@@ -29,14 +35,31 @@ type Authority struct {
 	config       *Config
 	keyManager   kms.KeyManager
 	provisioners *provisioner.Collection
+	policy       *policy.Engine
+	dbPolicy     *policy.Options
+	policyMutex  sync.RWMutex
 	db           db.AuthDB
+	audit        *audit.Logger
+
+	// Provisioners managed with the admin API
+	provisionerConfig provisioner.Config
+	dbProvisioners    map[string]bool
+	adminMutex        sync.Mutex
 
 	// X509 CA
 	rootX509Certs      []*x509.Certificate
 	federatedX509Certs []*x509.Certificate
+	upcomingX509Certs  []*x509.Certificate
 	x509Signer         crypto.Signer
 	x509Issuer         *x509.Certificate
+	x509Issuers        []*x509Issuer
+	x509Intermediates  []*x509.Certificate
+	x509IssuerMutex    sync.RWMutex
+	x509CAService      cas.CertificateAuthorityService
 	certificates       *sync.Map
+	crl                *crlState
+	usedTokens         *usedTokensState
+	serialNumbers      *serialNumberGenerator
 
 	// SSH CA
 	sshCAUserCertSignKey    ssh.Signer
@@ -50,6 +73,11 @@ type Authority struct {
 	initOnce  bool
 	startTime time.Time
 
+	// Cached health checks
+	health          *HealthStatus
+	healthCheckedAt time.Time
+	healthMutex     sync.Mutex
+
 	// Custom functions
 	sshBastionFunc   func(ctx context.Context, user, hostname string) (*Bastion, error)
 	sshCheckHostFunc func(ctx context.Context, principal string, tok string, roots []*x509.Certificate) (bool, error)
@@ -105,9 +133,9 @@ func NewEmbedded(opts ...Option) (*Authority, error) {
 		return nil, errors.New("cannot create an authority without a configuration")
 	case len(a.rootX509Certs) == 0 && a.config.Root.HasEmpties():
 		return nil, errors.New("cannot create an authority without a root certificate")
-	case a.x509Issuer == nil && a.config.IntermediateCert == "":
+	case a.x509Issuer == nil && a.config.IntermediateCert == "" && a.config.CAS.IsCertificateAuthority():
 		return nil, errors.New("cannot create an authority without an issuer certificate")
-	case a.x509Signer == nil && a.config.IntermediateKey == "":
+	case a.x509Signer == nil && a.config.IntermediateKey == "" && a.config.CAS.IsCertificateAuthority():
 		return nil, errors.New("cannot create an authority without an issuer signer")
 	}
 
@@ -131,12 +159,33 @@ func (a *Authority) init() error {
 
 	var err error
 
+	// Initialize the audit log if it has not been set in the options.
+	if a.audit == nil {
+		if a.audit, err = audit.Open(a.config.AuthorityConfig.Audit); err != nil {
+			return errors.Wrap(err, "error initializing audit log")
+		}
+	}
+
+	// Initialize the generator of X.509 serial numbers.
+	a.serialNumbers = newSerialNumberGenerator(a.config.AuthorityConfig.SerialNumber)
+
+	// Resolve the password if it references an external secret manager. The
+	// configuration keeps the reference.
+	password, err := secrets.Resolve(context.Background(), a.config.Password)
+	if err != nil {
+		return err
+	}
+
 	// Initialize key manager if it has not been set in the options.
 	if a.keyManager == nil {
 		var options kmsapi.Options
 		if a.config.KMS != nil {
 			options = *a.config.KMS
 		}
+		// The HSM pin can also be stored in a secret manager.
+		if options.Pin, err = secrets.Resolve(context.Background(), options.Pin); err != nil {
+			return err
+		}
 		a.keyManager, err = kms.New(context.Background(), options)
 		if err != nil {
 			return err
@@ -146,11 +195,24 @@ func (a *Authority) init() error {
 	// Initialize step-ca Database if it's not already initialized with WithDB.
 	// If a.config.DB is nil then a simple, barebones in memory DB will be used.
 	if a.db == nil {
-		if a.db, err = db.New(a.config.DB); err != nil {
+		dbConfig, err := resolveDBSecrets(a.config.DB)
+		if err != nil {
+			return err
+		}
+		if a.db, err = db.New(dbConfig); err != nil {
+			return err
+		}
+		if err := migrateSchema(a.db); err != nil {
 			return err
 		}
 	}
 
+	// Start copying the data if the database is being migrated. It has no
+	// effect if it was already started before a reload.
+	if d, ok := a.db.(*db.DB); ok {
+		d.StartMigration(append(db.Tables(), acme.Tables()...))
+	}
+
 	// Read root certificates and store them in the certificates map.
 	if len(a.rootX509Certs) == 0 {
 		a.rootX509Certs = make([]*x509.Certificate, len(a.config.Root))
@@ -183,15 +245,42 @@ func (a *Authority) init() error {
 		a.certificates.Store(hex.EncodeToString(sum[:]), crt)
 	}
 
+	// Read the roots that will replace the current ones and store them in the
+	// certificates map, so the clients can trust them before the rotation.
+	if len(a.upcomingX509Certs) == 0 {
+		a.upcomingX509Certs = make([]*x509.Certificate, len(a.config.UpcomingRoots))
+		for i, path := range a.config.UpcomingRoots {
+			crt, err := pemutil.ReadCertificate(path)
+			if err != nil {
+				return err
+			}
+			a.upcomingX509Certs[i] = crt
+		}
+	}
+	for _, crt := range a.upcomingX509Certs {
+		sum := sha256.Sum256(crt.Raw)
+		a.certificates.Store(hex.EncodeToString(sum[:]), crt)
+	}
+
+	// Initialize the certificate authority service, the X.509 certificates
+	// will be signed by an upstream step CA (registration authority mode) or by
+	// a cloud service.
+	if a.x509CAService == nil && !a.config.CAS.IsCertificateAuthority() {
+		a.x509CAService, err = cas.New(context.Background(), *a.config.CAS)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Read intermediate and create X509 signer.
-	if a.x509Signer == nil {
+	if a.x509Signer == nil && a.x509CAService == nil {
 		crt, err := pemutil.ReadCertificate(a.config.IntermediateCert)
 		if err != nil {
 			return err
 		}
 		signer, err := a.keyManager.CreateSigner(&kmsapi.CreateSignerRequest{
 			SigningKey: a.config.IntermediateKey,
-			Password:   []byte(a.config.Password),
+			Password:   []byte(password),
 		})
 		if err != nil {
 			return err
@@ -200,12 +289,51 @@ func (a *Authority) init() error {
 		a.x509Issuer = crt
 	}
 
+	// Read additional intermediates and create their signers.
+	if len(a.x509Issuers) == 0 {
+		for _, ic := range a.config.Intermediates {
+			crt, err := pemutil.ReadCertificate(ic.Crt)
+			if err != nil {
+				return err
+			}
+			signer, err := a.keyManager.CreateSigner(&kmsapi.CreateSignerRequest{
+				SigningKey: ic.Key,
+				Password:   []byte(password),
+			})
+			if err != nil {
+				return err
+			}
+			if err := equalPublicKeys(crt.PublicKey, signer.Public()); err != nil {
+				return errors.Wrapf(err, "intermediate %s", ic.Name)
+			}
+			a.x509Issuers = append(a.x509Issuers, &x509Issuer{
+				name:     ic.Name,
+				keyTypes: ic.KeyTypes,
+				crt:      crt,
+				signer:   signer,
+				config:   ic,
+			})
+		}
+	}
+
+	// Read previous intermediates, they are not used to sign, but they are
+	// still available to build the chains of the certificates they issued.
+	if len(a.x509Intermediates) == 0 {
+		for _, path := range a.config.PreviousIntermediates {
+			crt, err := pemutil.ReadCertificate(path)
+			if err != nil {
+				return err
+			}
+			a.x509Intermediates = append(a.x509Intermediates, crt)
+		}
+	}
+
 	// Decrypt and load SSH keys
 	if a.config.SSH != nil {
 		if a.config.SSH.HostKey != "" {
 			signer, err := a.keyManager.CreateSigner(&kmsapi.CreateSignerRequest{
 				SigningKey: a.config.SSH.HostKey,
-				Password:   []byte(a.config.Password),
+				Password:   []byte(password),
 			})
 			if err != nil {
 				return err
@@ -221,7 +349,7 @@ func (a *Authority) init() error {
 		if a.config.SSH.UserKey != "" {
 			signer, err := a.keyManager.CreateSigner(&kmsapi.CreateSignerRequest{
 				SigningKey: a.config.SSH.UserKey,
-				Password:   []byte(a.config.Password),
+				Password:   []byte(password),
 			})
 			if err != nil {
 				return err
@@ -280,6 +408,7 @@ func (a *Authority) init() error {
 			HostKeys: sshKeys.HostKeys,
 		},
 		GetIdentityFunc: a.getIdentityFunc,
+		Templates:       a.config.AuthorityConfig.CertificateTemplates,
 	}
 	// Store all the provisioners
 	for _, p := range a.config.AuthorityConfig.Provisioners {
@@ -290,6 +419,21 @@ func (a *Authority) init() error {
 			return err
 		}
 	}
+	// Store the provisioners created with the admin API
+	a.provisionerConfig = config
+	a.dbProvisioners = make(map[string]bool)
+	if err := a.loadDBProvisioners(); err != nil {
+		return err
+	}
+
+	// Initialize the global name policy.
+	if a.policy, err = policy.New(a.config.AuthorityConfig.Policy); err != nil {
+		return errors.Wrap(err, "error initializing policy")
+	}
+	// The policy set with the admin API overrides the one in the configuration.
+	if err := a.loadDBPolicy(); err != nil {
+		return err
+	}
 
 	// Configure protected template variables:
 	if t := a.config.Templates; t != nil {
@@ -310,6 +454,14 @@ func (a *Authority) init() error {
 		t.Data["Step"] = vars
 	}
 
+	// Generate the certificate revocation lists if enabled.
+	if err := a.initCRL(); err != nil {
+		return errors.Wrap(err, "error initializing certificate revocation list")
+	}
+
+	// Delete the expired one-time tokens periodically.
+	a.initUsedTokens()
+
 	// JWT numeric dates are seconds.
 	a.startTime = time.Now().Truncate(time.Second)
 	// Set flag indicating that initialization has been completed, and should
@@ -319,13 +471,65 @@ func (a *Authority) init() error {
 	return nil
 }
 
+// migrateSchema upgrades the format of the objects stored by the authority
+// and the ACME server to the format used by this version of the CA.
+func migrateSchema(d db.AuthDB) error {
+	ndb, ok := d.(*db.DB)
+	if !ok {
+		return nil
+	}
+	if err := db.MigrateSchema(ndb, db.SchemaComponent, db.SchemaMigrations()); err != nil {
+		return err
+	}
+	return db.MigrateSchema(ndb, acme.SchemaComponent, acme.SchemaMigrations())
+}
+
+// resolveDBSecrets returns a copy of the database configuration with the
+// secret references in the data sources resolved.
+func resolveDBSecrets(c *db.Config) (*db.Config, error) {
+	if c == nil {
+		return nil, nil
+	}
+	cc := *c
+	if secrets.IsReference(cc.DataSource) {
+		ds, err := secrets.Resolve(context.Background(), cc.DataSource)
+		if err != nil {
+			return nil, err
+		}
+		cc.DataSource = ds
+	}
+	if cc.MigrateFrom != nil {
+		from, err := resolveDBSecrets(cc.MigrateFrom)
+		if err != nil {
+			return nil, err
+		}
+		cc.MigrateFrom = from
+	}
+	return &cc, nil
+}
+
 // GetDatabase returns the authority database. If the configuration does not
 // define a database, GetDatabase will return a db.SimpleDB instance.
 func (a *Authority) GetDatabase() db.AuthDB {
 	return a.db
 }
 
+// GetAuditLogger returns the audit logger of the authority. It returns nil if
+// the audit log is not configured.
+func (a *Authority) GetAuditLogger() *audit.Logger {
+	return a.audit
+}
+
 // Shutdown safely shuts down any clients, databases, etc. held by the Authority.
 func (a *Authority) Shutdown() error {
+	if a.crl != nil {
+		close(a.crl.done)
+	}
+	if a.usedTokens != nil {
+		close(a.usedTokens.done)
+	}
+	if err := a.audit.Close(); err != nil {
+		log.Printf("error closing audit log: %v", err)
+	}
 	return a.db.Shutdown()
 }
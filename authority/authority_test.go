@@ -8,8 +8,12 @@ import (
 	"encoding/hex"
 	"io/ioutil"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
@@ -97,6 +101,40 @@ func TestAuthorityNew(t *testing.T) {
 				config: c,
 			}
 		},
+		"ok with previous intermediates": func(t *testing.T) *newTest {
+			c, err := LoadConfiguration("../ca/testdata/ca.json")
+			assert.FatalError(t, err)
+			c.PreviousIntermediates = []string{"testdata/certs/intermediate_ca.crt"}
+			return &newTest{
+				config: c,
+			}
+		},
+		"ok with intermediates": func(t *testing.T) *newTest {
+			c, err := LoadConfiguration("../ca/testdata/ca.json")
+			assert.FatalError(t, err)
+			c.Intermediates = []*IntermediateConfig{{Name: "copy", Crt: c.IntermediateCert, Key: c.IntermediateKey}}
+			return &newTest{
+				config: c,
+			}
+		},
+		"fail intermediate key mismatch": func(t *testing.T) *newTest {
+			c, err := LoadConfiguration("../ca/testdata/ca.json")
+			assert.FatalError(t, err)
+			c.Intermediates = []*IntermediateConfig{{Name: "bad", Crt: "testdata/certs/foo.crt", Key: c.IntermediateKey}}
+			return &newTest{
+				config: c,
+				err:    errors.New("intermediate bad: certificate public key does not match the signer"),
+			}
+		},
+		"fail bad previous intermediate": func(t *testing.T) *newTest {
+			c, err := LoadConfiguration("../ca/testdata/ca.json")
+			assert.FatalError(t, err)
+			c.PreviousIntermediates = []string{"foo"}
+			return &newTest{
+				config: c,
+				err:    errors.New("open foo failed: no such file or directory"),
+			}
+		},
 		"fail bad root": func(t *testing.T) *newTest {
 			c, err := LoadConfiguration("../ca/testdata/ca.json")
 			assert.FatalError(t, err)
@@ -145,6 +183,8 @@ func TestAuthorityNew(t *testing.T) {
 					assert.True(t, auth.initOnce)
 					assert.NotNil(t, auth.x509Signer)
 					assert.NotNil(t, auth.x509Issuer)
+					assert.Equals(t, len(tc.config.PreviousIntermediates), len(auth.x509Intermediates))
+					assert.Equals(t, len(tc.config.Intermediates), len(auth.x509Issuers))
 					for _, p := range tc.config.AuthorityConfig.Provisioners {
 						var _p provisioner.Interface
 						_p, ok = auth.provisioners.Load(p.GetID())
@@ -167,6 +207,45 @@ func TestAuthorityNew(t *testing.T) {
 	}
 }
 
+func TestAuthorityNew_secrets(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" || r.URL.Path != "/v1/secret/data/step-ca" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"data":{"data":{"password":"password","wrong":"foo"},"metadata":{"version":1}}}`))
+	}))
+	defer srv.Close()
+
+	os.Setenv("VAULT_ADDR", srv.URL)
+	os.Setenv("VAULT_TOKEN", "token")
+	defer func() {
+		os.Unsetenv("VAULT_ADDR")
+		os.Unsetenv("VAULT_TOKEN")
+	}()
+
+	c, err := LoadConfiguration("../ca/testdata/ca.json")
+	assert.FatalError(t, err)
+	c.Password = "vault://secret/data/step-ca#password"
+	auth, err := New(c)
+	assert.FatalError(t, err)
+	assert.NotNil(t, auth.x509Signer)
+	// The configuration keeps the reference.
+	assert.Equals(t, "vault://secret/data/step-ca#password", auth.config.Password)
+
+	c, err = LoadConfiguration("../ca/testdata/ca.json")
+	assert.FatalError(t, err)
+	c.Password = "vault://secret/data/step-ca#wrong"
+	_, err = New(c)
+	assert.Error(t, err)
+
+	c, err = LoadConfiguration("../ca/testdata/ca.json")
+	assert.FatalError(t, err)
+	c.Password = "vault://secret/data/foo#password"
+	_, err = New(c)
+	assert.Error(t, err)
+}
+
 func TestAuthority_GetDatabase(t *testing.T) {
 	auth := testAuthority(t)
 	authWithDatabase, err := New(auth.config, WithDatabase(auth.db))
@@ -307,4 +386,8 @@ func TestNewEmbedded_GetTLSCertificate(t *testing.T) {
 	assert.Equals(t, []string{"localhost"}, cert.Leaf.DNSNames)
 	assert.True(t, cert.Leaf.IPAddresses[0].Equal(net.ParseIP("127.0.0.1")))
 	assert.True(t, cert.Leaf.IPAddresses[1].Equal(net.ParseIP("::1")))
+
+	// The certificate is backdated by the default backdate.
+	assert.True(t, cert.Leaf.NotBefore.Before(time.Now().Add(-defaultBackdate).Add(time.Second)))
+	assert.Equals(t, 24*time.Hour, cert.Leaf.NotAfter.Sub(cert.Leaf.NotBefore))
 }
@@ -6,8 +6,11 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/smallstep/certificates/audit"
 	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
 	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/certificates/tracing"
 	"github.com/smallstep/cli/jose"
 	"golang.org/x/crypto/ssh"
 )
@@ -71,14 +74,20 @@ func (a *Authority) authorizeToken(ctx context.Context, token string) (provision
 	// Store the token to protect against reuse unless it's skipped.
 	if !SkipTokenReuseFromContext(ctx) {
 		if reuseKey, err := p.GetTokenID(token); err == nil {
-			ok, err := a.db.UseToken(reuseKey, token)
+			var ok bool
+			err := traceDB(ctx, "UseToken", func() (err error) {
+				ok, err = a.db.UseToken(reuseKey, token)
+				return
+			})
 			if err != nil {
 				return nil, errs.Wrap(http.StatusInternalServerError, err,
 					"authority.authorizeToken: failed when attempting to store token")
 			}
 			if !ok {
+				a.auditToken(ctx, audit.TokenReused, p, claims.Subject, reuseKey)
 				return nil, errs.Unauthorized("authority.authorizeToken: token already used")
 			}
+			a.auditToken(ctx, audit.TokenUsed, p, claims.Subject, reuseKey)
 		}
 	}
 
@@ -87,10 +96,21 @@ func (a *Authority) authorizeToken(ctx context.Context, token string) (provision
 
 // Authorize grabs the method from the context and authorizes the request by
 // validating the one-time-token.
-func (a *Authority) Authorize(ctx context.Context, token string) ([]provisioner.SignOption, error) {
+func (a *Authority) Authorize(ctx context.Context, token string) (_ []provisioner.SignOption, err error) {
 	var opts = []interface{}{errs.WithKeyVal("token", token)}
 
-	switch m := provisioner.MethodFromContext(ctx); m {
+	m := provisioner.MethodFromContext(ctx)
+	ctx, span := tracing.Start(ctx, "authority.Authorize")
+	span.SetAttribute("method", m.String())
+	defer func() {
+		if err != nil {
+			countTokenFailure(m)
+		}
+		span.SetError(err)
+		span.End()
+	}()
+
+	switch m {
 	case provisioner.SignMethod:
 		signOpts, err := a.authorizeSign(ctx, token)
 		return signOpts, errs.Wrap(http.StatusInternalServerError, err, "authority.Authorize", opts...)
@@ -145,6 +165,21 @@ func (a *Authority) AuthorizeSign(token string) ([]provisioner.SignOption, error
 	return a.Authorize(ctx, token)
 }
 
+// AuthorizeSignBatch authorizes a batch of signature requests with a single
+// token. Each certificate request of the batch must use a subset of the names
+// in the token.
+func (a *Authority) AuthorizeSignBatch(ctx context.Context, token string) ([]provisioner.SignOption, error) {
+	signOpts, err := a.Authorize(provisioner.NewContextWithMethod(ctx, provisioner.SignMethod), token)
+	if err != nil {
+		return nil, err
+	}
+	batchOpts, err := provisioner.BatchSignOptions(signOpts)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "authority.AuthorizeSignBatch")
+	}
+	return batchOpts, nil
+}
+
 // authorizeRevoke locates the provisioner used to generate the authenticating
 // token and then performs the token validation flow.
 func (a *Authority) authorizeRevoke(ctx context.Context, token string) error {
@@ -163,11 +198,15 @@ func (a *Authority) authorizeRevoke(ctx context.Context, token string) error {
 // extra extension cannot be found, authorize the renewal by default.
 //
 // TODO(mariano): should we authorize by default?
-func (a *Authority) authorizeRenew(cert *x509.Certificate) error {
+func (a *Authority) authorizeRenew(ctx context.Context, cert *x509.Certificate) error {
 	var opts = []interface{}{errs.WithKeyVal("serialNumber", cert.SerialNumber.String())}
 
 	// Check the passive revocation table.
-	isRevoked, err := a.db.IsRevoked(cert.SerialNumber.String())
+	var isRevoked bool
+	err := traceDB(ctx, "IsRevoked", func() (err error) {
+		isRevoked, err = a.db.IsRevoked(cert.SerialNumber.String())
+		return
+	})
 	if err != nil {
 		return errs.Wrap(http.StatusInternalServerError, err, "authority.authorizeRenew", opts...)
 	}
@@ -179,7 +218,7 @@ func (a *Authority) authorizeRenew(cert *x509.Certificate) error {
 	if !ok {
 		return errs.Unauthorized("authority.authorizeRenew: provisioner not found", opts...)
 	}
-	if err := p.AuthorizeRenew(context.Background(), cert); err != nil {
+	if err := p.AuthorizeRenew(ctx, cert); err != nil {
 		return errs.Wrap(http.StatusInternalServerError, err, "authority.authorizeRenew", opts...)
 	}
 	return nil
@@ -197,7 +236,7 @@ func (a *Authority) authorizeSSHSign(ctx context.Context, token string) ([]provi
 	if err != nil {
 		return nil, errs.Wrap(http.StatusUnauthorized, err, "authority.authorizeSSHSign")
 	}
-	return signOpts, nil
+	return append(signOpts, provisionerNameOption(p.GetName())), nil
 }
 
 // authorizeSSHRenew authorizes an SSH certificate renewal request, by
@@ -225,7 +264,7 @@ func (a *Authority) authorizeSSHRekey(ctx context.Context, token string) (*ssh.C
 	if err != nil {
 		return nil, nil, errs.Wrap(http.StatusInternalServerError, err, "authority.authorizeSSHRekey")
 	}
-	return cert, signOpts, nil
+	return cert, append(signOpts, provisionerNameOption(p.GetName())), nil
 }
 
 // authorizeSSHRevoke authorizes an SSH certificate revoke request, by
@@ -240,3 +279,15 @@ func (a *Authority) authorizeSSHRevoke(ctx context.Context, token string) error
 	}
 	return nil
 }
+
+// traceDB calls fn, a database operation, in a span named after it. The
+// database errors other than db.ErrNotImplemented mark the span as failed.
+func traceDB(ctx context.Context, name string, fn func() error) error {
+	_, span := tracing.Start(ctx, "db."+name)
+	err := fn()
+	if err != db.ErrNotImplemented {
+		span.SetError(err)
+	}
+	span.End()
+	return err
+}
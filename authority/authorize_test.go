@@ -5,6 +5,7 @@ import (
 	"crypto"
 	"crypto/rand"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
 	"fmt"
 	"net/http"
@@ -17,6 +18,7 @@ import (
 	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/db"
 	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/crypto/keys"
 	"github.com/smallstep/cli/crypto/pemutil"
 	"github.com/smallstep/cli/crypto/randutil"
 	"github.com/smallstep/cli/jose"
@@ -449,13 +451,71 @@ func TestAuthority_authorizeSign(t *testing.T) {
 				}
 			} else {
 				if assert.Nil(t, tc.err) {
-					assert.Len(t, 8, got)
+					assert.Len(t, 9, got)
 				}
 			}
 		})
 	}
 }
 
+func TestAuthority_AuthorizeSignBatch(t *testing.T) {
+	a := testAuthority(t)
+
+	jwk, err := jose.ParseKey("testdata/secrets/step_cli_key_priv.jwk", jose.WithPassword([]byte("pass")))
+	assert.FatalError(t, err)
+
+	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: jwk.Key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", jwk.KeyID))
+	assert.FatalError(t, err)
+
+	now := time.Now().UTC()
+	raw, err := jwt.Signed(sig).Claims(&Claims{
+		Claims: jose.Claims{
+			Subject:   "batch",
+			Issuer:    "step-cli",
+			NotBefore: jwt.NewNumericDate(now),
+			Expiry:    jwt.NewNumericDate(now.Add(time.Minute)),
+			Audience:  []string{"https://example.com/sign"},
+			ID:        "batch-44",
+		},
+		SANs: []string{"foo.smallstep.com", "bar.smallstep.com"},
+	}).CompactSerialize()
+	assert.FatalError(t, err)
+
+	_, err = a.AuthorizeSignBatch(context.Background(), "foo")
+	if assert.NotNil(t, err) {
+		assert.Equals(t, http.StatusUnauthorized, err.(errs.StatusCoder).StatusCode())
+	}
+
+	signOpts, err := a.AuthorizeSignBatch(context.Background(), raw)
+	assert.FatalError(t, err)
+	var validators []provisioner.CertificateRequestValidator
+	for _, o := range signOpts {
+		if v, ok := o.(provisioner.CertificateRequestValidator); ok {
+			validators = append(validators, v)
+		}
+	}
+	valid := func(csr *x509.CertificateRequest) error {
+		for _, v := range validators {
+			if err := v.Valid(csr); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	_, priv, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+	withName := func(name string) func(*x509.CertificateRequest) {
+		return func(csr *x509.CertificateRequest) {
+			csr.Subject = pkix.Name{CommonName: name}
+			csr.DNSNames = []string{name}
+		}
+	}
+	assert.FatalError(t, valid(getCSR(t, priv, withName("foo.smallstep.com"))))
+	assert.FatalError(t, valid(getCSR(t, priv, withName("bar.smallstep.com"))))
+	assert.NotNil(t, valid(getCSR(t, priv, withName("zar.smallstep.com"))))
+}
+
 func TestAuthority_Authorize(t *testing.T) {
 	a := testAuthority(t)
 
@@ -792,7 +852,7 @@ func TestAuthority_authorizeRenew(t *testing.T) {
 				code: http.StatusUnauthorized,
 			}
 		},
-		"ok": func(t *testing.T) *authorizeTest {
+		"fail/expired": func(t *testing.T) *authorizeTest {
 			a := testAuthority(t)
 			a.db = &db.MockAuthDB{
 				MIsRevoked: func(key string) (bool, error) {
@@ -802,6 +862,22 @@ func TestAuthority_authorizeRenew(t *testing.T) {
 			return &authorizeTest{
 				auth: a,
 				cert: fooCrt,
+				err:  errors.New("authority.authorizeRenew: jwk.AuthorizeRenew; certificate expired on 2019-03-23T22:29:29Z"),
+				code: http.StatusUnauthorized,
+			}
+		},
+		"ok": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			a.db = &db.MockAuthDB{
+				MIsRevoked: func(key string) (bool, error) {
+					return false, nil
+				},
+			}
+			crt := *fooCrt
+			crt.NotAfter = time.Now().Add(time.Hour)
+			return &authorizeTest{
+				auth: a,
+				cert: &crt,
 			}
 		},
 	}
@@ -810,7 +886,7 @@ func TestAuthority_authorizeRenew(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			tc := genTestCase(t)
 
-			err := tc.auth.authorizeRenew(tc.cert)
+			err := tc.auth.authorizeRenew(context.Background(), tc.cert)
 			if err != nil {
 				if assert.NotNil(t, tc.err) {
 					sc, ok := err.(errs.StatusCoder)
@@ -961,7 +1037,8 @@ func TestAuthority_authorizeSSHSign(t *testing.T) {
 				}
 			} else {
 				if assert.Nil(t, tc.err) {
-					assert.Len(t, 11, got)
+					assert.Len(t, 13, got)
+					assert.Equals(t, provisionerNameOption("step-cli"), got[12])
 				}
 			}
 		})
@@ -1250,7 +1327,8 @@ func TestAuthority_authorizeSSHRekey(t *testing.T) {
 			} else {
 				if assert.Nil(t, tc.err) {
 					assert.Equals(t, tc.cert.Serial, cert.Serial)
-					assert.Len(t, 3, signOpts)
+					assert.Len(t, 4, signOpts)
+					assert.Equals(t, provisionerNameOption("sshpop"), signOpts[3])
 				}
 			}
 		})
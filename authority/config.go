@@ -3,15 +3,22 @@ package authority
 import (
 	"encoding/json"
 	"fmt"
-	"net"
+	"io/ioutil"
 	"os"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/audit"
+	"github.com/smallstep/certificates/authority/admin"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/authority/provisioner"
+	cas "github.com/smallstep/certificates/cas/apiv1"
 	"github.com/smallstep/certificates/db"
 	kms "github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/ratelimit"
 	"github.com/smallstep/certificates/templates"
+	"github.com/smallstep/certificates/tracing"
 	"github.com/smallstep/cli/crypto/tlsutil"
 	"github.com/smallstep/cli/crypto/x509util"
 )
@@ -49,30 +56,54 @@ var (
 
 // Config represents the CA configuration and it's mapped to a JSON object.
 type Config struct {
-	Root             multiString          `json:"root"`
-	FederatedRoots   []string             `json:"federatedRoots"`
-	IntermediateCert string               `json:"crt"`
-	IntermediateKey  string               `json:"key"`
-	Address          string               `json:"address"`
-	DNSNames         []string             `json:"dnsNames"`
-	KMS              *kms.Options         `json:"kms,omitempty"`
-	SSH              *SSHConfig           `json:"ssh,omitempty"`
-	Logger           json.RawMessage      `json:"logger,omitempty"`
-	DB               *db.Config           `json:"db,omitempty"`
-	Monitoring       json.RawMessage      `json:"monitoring,omitempty"`
-	AuthorityConfig  *AuthConfig          `json:"authority,omitempty"`
-	TLS              *tlsutil.TLSOptions  `json:"tls,omitempty"`
-	Password         string               `json:"password,omitempty"`
-	Templates        *templates.Templates `json:"templates,omitempty"`
+	Root                  multiString           `json:"root"`
+	FederatedRoots        []string              `json:"federatedRoots"`
+	UpcomingRoots         []string              `json:"upcomingRoots,omitempty"`
+	IntermediateCert      string                `json:"crt"`
+	IntermediateKey       string                `json:"key"`
+	Intermediates         []*IntermediateConfig `json:"intermediates,omitempty"`
+	PreviousIntermediates []string              `json:"previousIntermediates,omitempty"`
+	Address               string                `json:"address"`
+	MetricsAddress        string                `json:"metricsAddress,omitempty"`
+	Debug                 *DebugConfig          `json:"debug,omitempty"`
+	UnixSocket            *UnixSocketConfig     `json:"unixSocket,omitempty"`
+	DNSNames              []string              `json:"dnsNames"`
+	KMS                   *kms.Options          `json:"kms,omitempty"`
+	CAS                   *cas.Options          `json:"cas,omitempty"`
+	SSH                   *SSHConfig            `json:"ssh,omitempty"`
+	CRL                   *CRLConfig            `json:"crl,omitempty"`
+	OCSP                  *OCSPConfig           `json:"ocsp,omitempty"`
+	Logger                json.RawMessage       `json:"logger,omitempty"`
+	DB                    *db.Config            `json:"db,omitempty"`
+	Monitoring            json.RawMessage       `json:"monitoring,omitempty"`
+	Tracing               *tracing.Config       `json:"tracing,omitempty"`
+	RateLimit             *ratelimit.Config     `json:"rateLimit,omitempty"`
+	RequestLimits         *RequestLimits        `json:"requestLimits,omitempty"`
+	Proxy                 *ProxyConfig          `json:"proxy,omitempty"`
+	Shutdown              *ShutdownConfig       `json:"shutdown,omitempty"`
+	AuthorityConfig       *AuthConfig           `json:"authority,omitempty"`
+	TLS                   *TLSOptions           `json:"tls,omitempty"`
+	Password              string                `json:"password,omitempty"`
+	Templates             *templates.Templates  `json:"templates,omitempty"`
+	ACME                  *acme.Config          `json:"acme,omitempty"`
+	Health                *HealthConfig         `json:"health,omitempty"`
 }
 
 // AuthConfig represents the configuration options for the authority.
 type AuthConfig struct {
-	Provisioners         provisioner.List      `json:"provisioners"`
-	Template             *x509util.ASN1DN      `json:"template,omitempty"`
-	Claims               *provisioner.Claims   `json:"claims,omitempty"`
-	DisableIssuedAtCheck bool                  `json:"disableIssuedAtCheck,omitempty"`
-	Backdate             *provisioner.Duration `json:"backdate,omitempty"`
+	Provisioners         provisioner.List                  `json:"provisioners"`
+	Template             *x509util.ASN1DN                  `json:"template,omitempty"`
+	Claims               *provisioner.Claims               `json:"claims,omitempty"`
+	DisableIssuedAtCheck bool                              `json:"disableIssuedAtCheck,omitempty"`
+	Backdate             *provisioner.Duration             `json:"backdate,omitempty"`
+	Policy               *policy.Options                   `json:"policy,omitempty"`
+	Webhooks             []*WebhookConfig                  `json:"webhooks,omitempty"`
+	Admin                *admin.Config                     `json:"admin,omitempty"`
+	Audit                *audit.Config                     `json:"audit,omitempty"`
+	SerialNumber         *SerialNumberConfig               `json:"serialNumber,omitempty"`
+	CryptoPolicy         *CryptoPolicy                     `json:"cryptoPolicy,omitempty"`
+	CertificateTemplates *provisioner.CertificateTemplates `json:"certificateTemplates,omitempty"`
+	AuthenticateRoots    bool                              `json:"authenticateRoots,omitempty"`
 }
 
 // init initializes the required fields in the AuthConfig if they are not
@@ -115,20 +146,59 @@ func (c *AuthConfig) Validate(audiences provisioner.Audiences) error {
 		return errors.New("authority.backdate cannot be less than 0")
 	}
 
+	if _, err := policy.New(c.Policy); err != nil {
+		return errors.Wrap(err, "authority.policy")
+	}
+
+	for _, w := range c.Webhooks {
+		if err := w.Validate(); err != nil {
+			return errors.Wrap(err, "authority.webhooks")
+		}
+	}
+
+	if err := c.Admin.Validate(); err != nil {
+		return errors.Wrap(err, "authority.admin")
+	}
+
+	if err := c.Audit.Validate(); err != nil {
+		return errors.Wrap(err, "authority.audit")
+	}
+
+	if err := c.SerialNumber.Validate(); err != nil {
+		return errors.Wrap(err, "authority.serialNumber")
+	}
+
+	if err := c.CryptoPolicy.Validate(); err != nil {
+		return errors.Wrap(err, "authority.cryptoPolicy")
+	}
+
+	if err := c.CertificateTemplates.Init(); err != nil {
+		return errors.Wrap(err, "authority.certificateTemplates")
+	}
+
 	return nil
 }
 
 // LoadConfiguration parses the given filename in JSON format and returns the
-// configuration struct.
-func LoadConfiguration(filename string) (*Config, error) {
-	f, err := os.Open(filename)
+// configuration struct. The values in the file are overridden by the
+// environment variables starting with STEPCA_, and then by the given overrides
+// with the form path=value, e.g. db.dataSource=/data/db.
+func LoadConfiguration(filename string, overrides ...string) (*Config, error) {
+	b, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, errors.Wrapf(err, "error opening %s", filename)
 	}
-	defer f.Close()
+
+	list, err := loadOverrides(overrides)
+	if err != nil {
+		return nil, err
+	}
+	if b, err = applyOverrides(b, list); err != nil {
+		return nil, errors.Wrapf(err, "error parsing %s", filename)
+	}
 
 	var c Config
-	if err := json.NewDecoder(f).Decode(&c); err != nil {
+	if err := json.Unmarshal(b, &c); err != nil {
 		return nil, errors.Wrapf(err, "error parsing %s", filename)
 	}
 
@@ -142,7 +212,7 @@ func (c *Config) init() {
 		c.DNSNames = []string{"localhost", "127.0.0.1", "::1"}
 	}
 	if c.TLS == nil {
-		c.TLS = &DefaultTLSOptions
+		c.TLS = &TLSOptions{TLSOptions: DefaultTLSOptions}
 	}
 	if c.AuthorityConfig == nil {
 		c.AuthorityConfig = &AuthConfig{}
@@ -172,23 +242,46 @@ func (c *Config) Validate() error {
 	case c.Root.HasEmpties():
 		return errors.New("root cannot be empty")
 
-	case c.IntermediateCert == "":
+	case c.IntermediateCert == "" && c.CAS.IsCertificateAuthority():
 		return errors.New("crt cannot be empty")
 
-	case c.IntermediateKey == "":
+	case c.IntermediateKey == "" && c.CAS.IsCertificateAuthority():
 		return errors.New("key cannot be empty")
 
 	case len(c.DNSNames) == 0:
 		return errors.New("dnsNames cannot be empty")
 	}
 
-	// Validate address (a port is required)
-	if _, _, err := net.SplitHostPort(c.Address); err != nil {
+	// Validate address (a port or a unix socket path is required)
+	if !isValidAddress(c.Address) {
 		return errors.Errorf("invalid address %s", c.Address)
 	}
 
+	// Validate the metrics address, it must be different than the address.
+	if c.MetricsAddress != "" {
+		if !isValidAddress(c.MetricsAddress) {
+			return errors.Errorf("invalid metricsAddress %s", c.MetricsAddress)
+		}
+		if c.MetricsAddress == c.Address {
+			return errors.New("metricsAddress cannot be the same as address")
+		}
+	}
+
+	// Validate debug: nil is ok. It must use its own address.
+	if err := c.Debug.Validate(); err != nil {
+		return err
+	}
+	if addr := c.Debug.GetAddress(); addr != "" && (addr == c.Address || addr == c.MetricsAddress) {
+		return errors.New("debug.address cannot be the same as address or metricsAddress")
+	}
+
+	// Validate unix socket: nil is ok
+	if err := c.UnixSocket.Validate(); err != nil {
+		return err
+	}
+
 	if c.TLS == nil {
-		c.TLS = &DefaultTLSOptions
+		c.TLS = &TLSOptions{TLSOptions: DefaultTLSOptions}
 	} else {
 		if len(c.TLS.CipherSuites) == 0 {
 			c.TLS.CipherSuites = DefaultTLSOptions.CipherSuites
@@ -203,6 +296,9 @@ func (c *Config) Validate() error {
 			return errors.New("tls minVersion cannot exceed tls maxVersion")
 		}
 		c.TLS.Renegotiation = c.TLS.Renegotiation || DefaultTLSOptions.Renegotiation
+		if err := c.TLS.Validate(); err != nil {
+			return err
+		}
 	}
 
 	// Validate KMS options, nil is ok.
@@ -210,16 +306,90 @@ func (c *Config) Validate() error {
 		return err
 	}
 
+	// Validate CAS options, nil is ok.
+	if err := c.CAS.Validate(); err != nil {
+		return err
+	}
+
+	// The certificates are signed by the certificate authority service.
+	if !c.CAS.IsCertificateAuthority() {
+		switch {
+		case len(c.Intermediates) > 0 || len(c.PreviousIntermediates) > 0:
+			return errors.Errorf("intermediates cannot be used with cas type %s", c.CAS.Type)
+		case c.CRL.IsEnabled():
+			return errors.Errorf("crl cannot be enabled with cas type %s", c.CAS.Type)
+		case c.OCSP.IsEnabled():
+			return errors.Errorf("ocsp cannot be enabled with cas type %s", c.CAS.Type)
+		}
+	}
+
 	// Validate ssh: nil is ok
 	if err := c.SSH.Validate(); err != nil {
 		return err
 	}
 
+	// Validate intermediates, names must be unique.
+	names := make(map[string]bool, len(c.Intermediates))
+	for _, ic := range c.Intermediates {
+		if err := ic.Validate(); err != nil {
+			return err
+		}
+		if names[ic.Name] {
+			return errors.Errorf("intermediate %s is duplicated", ic.Name)
+		}
+		names[ic.Name] = true
+	}
+
+	// Validate crl: nil is ok
+	if err := c.CRL.Validate(); err != nil {
+		return err
+	}
+
+	// Validate ocsp: nil is ok
+	if err := c.OCSP.Validate(); err != nil {
+		return err
+	}
+
+	// Validate health: nil is ok
+	if err := c.Health.Validate(); err != nil {
+		return err
+	}
+
+	// Validate tracing: nil is ok
+	if err := c.Tracing.Validate(); err != nil {
+		return err
+	}
+
+	// Validate rate limits: nil is ok
+	if err := c.RateLimit.Validate(); err != nil {
+		return err
+	}
+
+	// Validate request limits: nil is ok
+	if err := c.RequestLimits.Validate(); err != nil {
+		return err
+	}
+
+	// Validate proxy: nil is ok
+	if err := c.Proxy.Validate(); err != nil {
+		return err
+	}
+
+	// Validate shutdown: nil is ok
+	if err := c.Shutdown.Validate(); err != nil {
+		return err
+	}
+
 	// Validate templates: nil is ok
 	if err := c.Templates.Validate(); err != nil {
 		return err
 	}
 
+	// Validate acme: nil is ok
+	if err := c.ACME.Validate(); err != nil {
+		return err
+	}
+
 	return c.AuthorityConfig.Validate(c.getAudiences())
 }
 
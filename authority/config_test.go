@@ -6,7 +6,10 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/audit"
 	"github.com/smallstep/certificates/authority/provisioner"
+	cas "github.com/smallstep/certificates/cas/apiv1"
+	"github.com/smallstep/certificates/tracing"
 	"github.com/smallstep/cli/crypto/tlsutil"
 	"github.com/smallstep/cli/crypto/x509util"
 	stepJOSE "github.com/smallstep/cli/jose"
@@ -32,6 +35,18 @@ func TestConfigValidate(t *testing.T) {
 		},
 	}
 
+	raOptions := &cas.Options{
+		Type:                            "stepcas",
+		CertificateAuthority:            "https://ca.smallstep.com",
+		CertificateAuthorityFingerprint: "e7f1a4d0d1d7e5a7c5d0f4e8cbb6e1b2a0e1f1c6b3e1e9f7c9b2d3a4b5c6d7e8",
+		CertificateIssuer: &cas.CertificateIssuer{
+			Type:        "x5c",
+			Provisioner: "ra@smallstep.com",
+			Certificate: "testdata/secrets/ra.crt",
+			Key:         "testdata/secrets/ra.key",
+		},
+	}
+
 	type ConfigValidateTest struct {
 		config *Config
 		err    error
@@ -51,6 +66,102 @@ func TestConfigValidate(t *testing.T) {
 				err: errors.New("address cannot be empty"),
 			}
 		},
+		"invalid-intermediate": func(t *testing.T) ConfigValidateTest {
+			return ConfigValidateTest{
+				config: &Config{
+					Address:          "127.0.0.1:443",
+					Root:             []string{"testdata/secrets/root_ca.crt"},
+					IntermediateCert: "testdata/secrets/intermediate_ca.crt",
+					IntermediateKey:  "testdata/secrets/intermediate_ca_key",
+					Intermediates:    []*IntermediateConfig{{Name: "rsa", Crt: "rsa.crt"}},
+					DNSNames:         []string{"test.smallstep.com"},
+					Password:         "pass",
+					AuthorityConfig:  ac,
+				},
+				err: errors.New("intermediate rsa: key cannot be empty"),
+			}
+		},
+		"duplicated-intermediate": func(t *testing.T) ConfigValidateTest {
+			return ConfigValidateTest{
+				config: &Config{
+					Address:          "127.0.0.1:443",
+					Root:             []string{"testdata/secrets/root_ca.crt"},
+					IntermediateCert: "testdata/secrets/intermediate_ca.crt",
+					IntermediateKey:  "testdata/secrets/intermediate_ca_key",
+					Intermediates: []*IntermediateConfig{
+						{Name: "rsa", Crt: "rsa.crt", Key: "rsa.key"},
+						{Name: "rsa", Crt: "rsa2.crt", Key: "rsa2.key"},
+					},
+					DNSNames:        []string{"test.smallstep.com"},
+					Password:        "pass",
+					AuthorityConfig: ac,
+				},
+				err: errors.New("intermediate rsa is duplicated"),
+			}
+		},
+		"ok-registration-authority": func(t *testing.T) ConfigValidateTest {
+			return ConfigValidateTest{
+				config: &Config{
+					Address:         "127.0.0.1:443",
+					Root:            []string{"testdata/secrets/root_ca.crt"},
+					CAS:             raOptions,
+					DNSNames:        []string{"test.smallstep.com"},
+					AuthorityConfig: ac,
+				},
+				tls: DefaultTLSOptions,
+			}
+		},
+		"invalid-cas": func(t *testing.T) ConfigValidateTest {
+			return ConfigValidateTest{
+				config: &Config{
+					Address:         "127.0.0.1:443",
+					Root:            []string{"testdata/secrets/root_ca.crt"},
+					CAS:             &cas.Options{Type: "stepcas"},
+					DNSNames:        []string{"test.smallstep.com"},
+					AuthorityConfig: ac,
+				},
+				err: errors.New("cas.certificateAuthority cannot be empty"),
+			}
+		},
+		"registration-authority-intermediates": func(t *testing.T) ConfigValidateTest {
+			return ConfigValidateTest{
+				config: &Config{
+					Address:               "127.0.0.1:443",
+					Root:                  []string{"testdata/secrets/root_ca.crt"},
+					PreviousIntermediates: []string{"testdata/secrets/intermediate_ca.crt"},
+					CAS:                   raOptions,
+					DNSNames:              []string{"test.smallstep.com"},
+					AuthorityConfig:       ac,
+				},
+				err: errors.New("intermediates cannot be used with cas type stepcas"),
+			}
+		},
+		"registration-authority-crl": func(t *testing.T) ConfigValidateTest {
+			return ConfigValidateTest{
+				config: &Config{
+					Address:         "127.0.0.1:443",
+					Root:            []string{"testdata/secrets/root_ca.crt"},
+					CAS:             raOptions,
+					CRL:             &CRLConfig{Enabled: true},
+					DNSNames:        []string{"test.smallstep.com"},
+					AuthorityConfig: ac,
+				},
+				err: errors.New("crl cannot be enabled with cas type stepcas"),
+			}
+		},
+		"registration-authority-ocsp": func(t *testing.T) ConfigValidateTest {
+			return ConfigValidateTest{
+				config: &Config{
+					Address:         "127.0.0.1:443",
+					Root:            []string{"testdata/secrets/root_ca.crt"},
+					CAS:             raOptions,
+					OCSP:            &OCSPConfig{Enabled: true},
+					DNSNames:        []string{"test.smallstep.com"},
+					AuthorityConfig: ac,
+				},
+				err: errors.New("ocsp cannot be enabled with cas type stepcas"),
+			}
+		},
 		"invalid-address": func(t *testing.T) ConfigValidateTest {
 			return ConfigValidateTest{
 				config: &Config{
@@ -65,6 +176,67 @@ func TestConfigValidate(t *testing.T) {
 				err: errors.New("invalid address 127.0.0.1"),
 			}
 		},
+		"invalid-metrics-address": func(t *testing.T) ConfigValidateTest {
+			return ConfigValidateTest{
+				config: &Config{
+					Address:          "127.0.0.1:443",
+					MetricsAddress:   "127.0.0.1",
+					Root:             []string{"testdata/secrets/root_ca.crt"},
+					IntermediateCert: "testdata/secrets/intermediate_ca.crt",
+					IntermediateKey:  "testdata/secrets/intermediate_ca_key",
+					DNSNames:         []string{"test.smallstep.com"},
+					Password:         "pass",
+					AuthorityConfig:  ac,
+				},
+				err: errors.New("invalid metricsAddress 127.0.0.1"),
+			}
+		},
+		"same-metrics-address": func(t *testing.T) ConfigValidateTest {
+			return ConfigValidateTest{
+				config: &Config{
+					Address:          "127.0.0.1:443",
+					MetricsAddress:   "127.0.0.1:443",
+					Root:             []string{"testdata/secrets/root_ca.crt"},
+					IntermediateCert: "testdata/secrets/intermediate_ca.crt",
+					IntermediateKey:  "testdata/secrets/intermediate_ca_key",
+					DNSNames:         []string{"test.smallstep.com"},
+					Password:         "pass",
+					AuthorityConfig:  ac,
+				},
+				err: errors.New("metricsAddress cannot be the same as address"),
+			}
+		},
+		"same-debug-address": func(t *testing.T) ConfigValidateTest {
+			return ConfigValidateTest{
+				config: &Config{
+					Address:          "127.0.0.1:443",
+					MetricsAddress:   "127.0.0.1:9000",
+					Debug:            &DebugConfig{Address: "127.0.0.1:9000", BearerToken: "s3cr3t"},
+					Root:             []string{"testdata/secrets/root_ca.crt"},
+					IntermediateCert: "testdata/secrets/intermediate_ca.crt",
+					IntermediateKey:  "testdata/secrets/intermediate_ca_key",
+					DNSNames:         []string{"test.smallstep.com"},
+					Password:         "pass",
+					AuthorityConfig:  ac,
+				},
+				err: errors.New("debug.address cannot be the same as address or metricsAddress"),
+			}
+		},
+		"invalid-tracing": func(t *testing.T) ConfigValidateTest {
+			return ConfigValidateTest{
+				config: &Config{
+					Address:          "127.0.0.1:443",
+					Root:             []string{"testdata/secrets/root_ca.crt"},
+					IntermediateCert: "testdata/secrets/intermediate_ca.crt",
+					IntermediateKey:  "testdata/secrets/intermediate_ca_key",
+					DNSNames:         []string{"test.smallstep.com"},
+					Password:         "pass",
+					AuthorityConfig:  ac,
+					Tracing:          &tracing.Config{Endpoint: "localhost:4318"},
+				},
+				err: errors.New("tracing endpoint localhost:4318 is not valid"),
+			}
+		},
 		"empty-root": func(t *testing.T) ConfigValidateTest {
 			return ConfigValidateTest{
 				config: &Config{
@@ -141,7 +313,7 @@ func TestConfigValidate(t *testing.T) {
 					DNSNames:         []string{"test.smallstep.com"},
 					Password:         "pass",
 					AuthorityConfig:  ac,
-					TLS:              &tlsutil.TLSOptions{},
+					TLS:              &TLSOptions{},
 				},
 				tls: DefaultTLSOptions,
 			}
@@ -156,14 +328,14 @@ func TestConfigValidate(t *testing.T) {
 					DNSNames:         []string{"test.smallstep.com"},
 					Password:         "pass",
 					AuthorityConfig:  ac,
-					TLS: &tlsutil.TLSOptions{
+					TLS: &TLSOptions{TLSOptions: tlsutil.TLSOptions{
 						CipherSuites: x509util.CipherSuites{
 							"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305",
 						},
 						MinVersion:    1.0,
 						MaxVersion:    1.1,
 						Renegotiation: true,
-					},
+					}},
 				},
 				tls: tlsutil.TLSOptions{
 					CipherSuites: x509util.CipherSuites{
@@ -185,18 +357,66 @@ func TestConfigValidate(t *testing.T) {
 					DNSNames:         []string{"test.smallstep.com"},
 					Password:         "pass",
 					AuthorityConfig:  ac,
-					TLS: &tlsutil.TLSOptions{
+					TLS: &TLSOptions{TLSOptions: tlsutil.TLSOptions{
 						CipherSuites: x509util.CipherSuites{
 							"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305",
 						},
 						MinVersion:    1.2,
 						MaxVersion:    1.1,
 						Renegotiation: true,
-					},
+					}},
 				},
 				err: errors.New("tls minVersion cannot exceed tls maxVersion"),
 			}
 		},
+		"tls-curves-and-client-auth": func(t *testing.T) ConfigValidateTest {
+			return ConfigValidateTest{
+				config: &Config{
+					Address:          "127.0.0.1:443",
+					Root:             []string{"testdata/secrets/root_ca.crt"},
+					IntermediateCert: "testdata/secrets/intermediate_ca.crt",
+					IntermediateKey:  "testdata/secrets/intermediate_ca_key",
+					DNSNames:         []string{"test.smallstep.com"},
+					Password:         "pass",
+					AuthorityConfig:  ac,
+					TLS: &TLSOptions{
+						Curves:     []string{"X25519", "P256"},
+						ClientAuth: ClientAuthRequire,
+					},
+				},
+				tls: DefaultTLSOptions,
+			}
+		},
+		"fail-tls-curve": func(t *testing.T) ConfigValidateTest {
+			return ConfigValidateTest{
+				config: &Config{
+					Address:          "127.0.0.1:443",
+					Root:             []string{"testdata/secrets/root_ca.crt"},
+					IntermediateCert: "testdata/secrets/intermediate_ca.crt",
+					IntermediateKey:  "testdata/secrets/intermediate_ca_key",
+					DNSNames:         []string{"test.smallstep.com"},
+					Password:         "pass",
+					AuthorityConfig:  ac,
+					TLS:              &TLSOptions{Curves: []string{"P224"}},
+				},
+				err: errors.New("tls curve P224 is not supported"),
+			}
+		},
+		"fail-tls-client-auth": func(t *testing.T) ConfigValidateTest {
+			return ConfigValidateTest{
+				config: &Config{
+					Address:          "127.0.0.1:443",
+					Root:             []string{"testdata/secrets/root_ca.crt"},
+					IntermediateCert: "testdata/secrets/intermediate_ca.crt",
+					IntermediateKey:  "testdata/secrets/intermediate_ca_key",
+					DNSNames:         []string{"test.smallstep.com"},
+					Password:         "pass",
+					AuthorityConfig:  ac,
+					TLS:              &TLSOptions{ClientAuth: "verify"},
+				},
+				err: errors.New("tls clientAuth verify is not valid, it must be one of request, require or none"),
+			}
+		},
 	}
 
 	for name, get := range tests {
@@ -209,7 +429,7 @@ func TestConfigValidate(t *testing.T) {
 				}
 			} else {
 				if assert.Nil(t, tc.err) {
-					assert.Equals(t, *tc.config.TLS, tc.tls)
+					assert.Equals(t, tc.config.TLS.TLSOptions, tc.tls)
 				}
 			}
 		})
@@ -255,6 +475,46 @@ func TestAuthConfigValidate(t *testing.T) {
 				err: errors.New("authority cannot be undefined"),
 			}
 		},
+		"fail-invalid-webhook": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					Webhooks: []*WebhookConfig{{Name: "opa", URL: "opa.example.com"}},
+				},
+				err: errors.New("authority.webhooks: webhook opa: url opa.example.com is not valid"),
+			}
+		},
+		"fail-invalid-audit": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					Audit: &audit.Config{},
+				},
+				err: errors.New("authority.audit: audit must configure at least one of file, syslog or webhooks"),
+			}
+		},
+		"fail-invalid-serial-number": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					SerialNumber: &SerialNumberConfig{Bits: 32},
+				},
+				err: errors.New("authority.serialNumber: bits cannot be less than 64"),
+			}
+		},
+		"fail-invalid-crypto-policy": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					CryptoPolicy: &CryptoPolicy{Curves: []string{"P-224"}},
+				},
+				err: errors.New("authority.cryptoPolicy: curves contains an unsupported value P-224"),
+			}
+		},
+		"fail-invalid-certificate-templates": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					CertificateTemplates: &provisioner.CertificateTemplates{DefaultX509: "leaf"},
+				},
+				err: errors.New("authority.certificateTemplates: defaultX509 template leaf is not defined"),
+			}
+		},
 		"ok-empty-provisioners": func(t *testing.T) AuthConfigValidateTest {
 			return AuthConfigValidateTest{
 				ac:     &AuthConfig{},
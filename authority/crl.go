@@ -0,0 +1,486 @@
+package authority
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+)
+
+const defaultCRLCacheDuration = 24 * time.Hour
+
+var (
+	oidExtensionReasonCode        = asn1.ObjectIdentifier{2, 5, 29, 21}
+	oidExtensionDeltaCRLIndicator = asn1.ObjectIdentifier{2, 5, 29, 27}
+	oidExtensionFreshestCRL       = asn1.ObjectIdentifier{2, 5, 29, 46}
+)
+
+// CRLConfig represents the configuration of the certificate revocation lists
+// (CRL) of the CA.
+//
+// If Enabled is true, the CA generates a CRL signed by the intermediate every
+// CacheDuration (24h by default). If DeltaCacheDuration is also set, a delta
+// CRL with the certificates revoked after the last full CRL is generated with
+// that frequency. The nextUpdate of a list is set to twice its period, so a
+// relying party always has a valid list while it fetches a new one.
+//
+// DistributionPoints, IssuingCertificateURLs and DeltaDistributionPoints are
+// added to the issued certificates as the CRL distribution points, the
+// authority information access CA issuers and the freshest CRL extensions.
+// They can be set even if the CRL is generated by another service.
+type CRLConfig struct {
+	Enabled                 bool                  `json:"enabled"`
+	CacheDuration           *provisioner.Duration `json:"cacheDuration,omitempty"`
+	DeltaCacheDuration      *provisioner.Duration `json:"deltaCacheDuration,omitempty"`
+	DistributionPoints      []string              `json:"distributionPoints,omitempty"`
+	DeltaDistributionPoints []string              `json:"deltaDistributionPoints,omitempty"`
+	IssuingCertificateURLs  []string              `json:"issuingCertificateURLs,omitempty"`
+}
+
+// IsEnabled returns true if the CA must generate a CRL.
+func (c *CRLConfig) IsEnabled() bool {
+	return c != nil && c.Enabled
+}
+
+// Validate checks the fields in CRLConfig.
+func (c *CRLConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	switch {
+	case c.CacheDuration != nil && c.CacheDuration.Duration < 0:
+		return errors.New("crl.cacheDuration cannot be less than 0")
+	case c.DeltaCacheDuration != nil && c.DeltaCacheDuration.Duration < 0:
+		return errors.New("crl.deltaCacheDuration cannot be less than 0")
+	case c.deltaCacheDuration() >= c.cacheDuration():
+		return errors.New("crl.deltaCacheDuration must be less than crl.cacheDuration")
+	}
+	return nil
+}
+
+func (c *CRLConfig) cacheDuration() time.Duration {
+	if c.CacheDuration == nil || c.CacheDuration.Duration == 0 {
+		return defaultCRLCacheDuration
+	}
+	return c.CacheDuration.Duration
+}
+
+func (c *CRLConfig) deltaCacheDuration() time.Duration {
+	if c.DeltaCacheDuration == nil {
+		return 0
+	}
+	return c.DeltaCacheDuration.Duration
+}
+
+// apply adds the distribution points and issuing certificate urls to the
+// given certificate. The distribution points are the ones of the given
+// additional intermediate, or the configured ones if it's nil. Existing values
+// are kept if they are not configured.
+func (c *CRLConfig) apply(cert *x509.Certificate, ic *IntermediateConfig) error {
+	var dps, deltas []string
+	switch {
+	case ic != nil:
+		dps, deltas = ic.CRLDistributionPoints, ic.DeltaCRLDistributionPoints
+	case c != nil:
+		dps, deltas = c.DistributionPoints, c.DeltaDistributionPoints
+	}
+	if len(dps) > 0 {
+		cert.CRLDistributionPoints = dps
+	}
+	if c != nil && len(c.IssuingCertificateURLs) > 0 {
+		cert.IssuingCertificateURL = c.IssuingCertificateURLs
+	}
+	if len(deltas) > 0 {
+		ext, err := freshestCRLExtension(deltas)
+		if err != nil {
+			return err
+		}
+		exts := []pkix.Extension{ext}
+		for _, e := range cert.ExtraExtensions {
+			if !e.Id.Equal(oidExtensionFreshestCRL) {
+				exts = append(exts, e)
+			}
+		}
+		cert.ExtraExtensions = exts
+	}
+	return nil
+}
+
+// distributionPoint is the ASN.1 structure of a DistributionPoint with only
+// a full name, see RFC 5280, section 4.2.1.13.
+type distributionPoint struct {
+	DistributionPoint distributionPointName `asn1:"optional,tag:0"`
+}
+
+type distributionPointName struct {
+	FullName []asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+// freshestCRLExtension returns the freshest CRL extension with the given
+// delta CRL urls.
+func freshestCRLExtension(urls []string) (pkix.Extension, error) {
+	dps := make([]distributionPoint, len(urls))
+	for i, u := range urls {
+		dps[i].DistributionPoint.FullName = []asn1.RawValue{
+			{Tag: 6, Class: asn1.ClassContextSpecific, Bytes: []byte(u)},
+		}
+	}
+	b, err := asn1.Marshal(dps)
+	if err != nil {
+		return pkix.Extension{}, errors.Wrap(err, "error marshaling freshest crl extension")
+	}
+	return pkix.Extension{Id: oidExtensionFreshestCRL, Value: b}, nil
+}
+
+// crlState holds the last generated lists of every intermediate. The
+// refresh mutex serializes the generation of the lists, refreshed is the
+// time of the last one.
+type crlState struct {
+	sync.RWMutex
+	refresh    sync.Mutex
+	refreshed  time.Time
+	lists      map[string]*crlList
+	thisUpdate time.Time
+	done       chan struct{}
+}
+
+// crlList holds the last lists signed by an intermediate.
+type crlList struct {
+	full    []byte
+	delta   []byte
+	number  *big.Int
+	serials map[string]bool
+}
+
+// crlIssuer is an intermediate that signs a CRL. The default intermediate has
+// an empty name and config.
+type crlIssuer struct {
+	name   string
+	crt    *x509.Certificate
+	signer crypto.Signer
+	config *IntermediateConfig
+}
+
+// crlIssuers returns the default intermediate followed by the additional
+// intermediates.
+func (a *Authority) crlIssuers() []*crlIssuer {
+	crt, signer := a.getX509Issuer()
+	issuers := []*crlIssuer{{crt: crt, signer: signer}}
+	for _, iss := range a.x509Issuers {
+		issuers = append(issuers, &crlIssuer{
+			name:   iss.name,
+			crt:    iss.crt,
+			signer: iss.signer,
+			config: iss.config,
+		})
+	}
+	return issuers
+}
+
+// deltaDistributionPoints returns the delta CRL urls of the intermediate.
+func (iss *crlIssuer) deltaDistributionPoints(c *CRLConfig) []string {
+	if iss.config != nil {
+		return iss.config.DeltaCRLDistributionPoints
+	}
+	return c.DeltaDistributionPoints
+}
+
+// revokedByIssuer groups the revoked certificates by the name of the
+// intermediate that issued them. The issuer of a certificate revoked by
+// previous versions, without an authority key id, is read from the stored
+// certificate; if it's not found the certificate is added to all the lists.
+// Certificates of other intermediates, e.g. the previous ones, are added to
+// the list of the default intermediate.
+func (a *Authority) revokedByIssuer(issuers []*crlIssuer, revoked []*db.RevokedCertificateInfo) map[string][]*db.RevokedCertificateInfo {
+	groups := make(map[string][]*db.RevokedCertificateInfo, len(issuers))
+	for _, rci := range revoked {
+		aki := rci.AuthorityKeyID
+		if len(aki) == 0 {
+			if crt, err := a.db.GetCertificate(rci.Serial); err == nil {
+				aki = crt.AuthorityKeyId
+			}
+		}
+		if len(aki) == 0 {
+			for _, iss := range issuers {
+				groups[iss.name] = append(groups[iss.name], rci)
+			}
+			continue
+		}
+		var name string
+		for _, iss := range issuers[1:] {
+			if bytes.Equal(aki, iss.crt.SubjectKeyId) {
+				name = iss.name
+				break
+			}
+		}
+		groups[name] = append(groups[name], rci)
+	}
+	return groups
+}
+
+// initCRL generates the first lists and starts the goroutine that renews
+// them.
+func (a *Authority) initCRL() error {
+	if !a.config.CRL.IsEnabled() {
+		return nil
+	}
+	a.crl = &crlState{done: make(chan struct{})}
+	if err := a.refreshCRL(time.Now()); err != nil {
+		return err
+	}
+
+	period := a.config.CRL.cacheDuration()
+	if d := a.config.CRL.deltaCacheDuration(); d > 0 {
+		period = d
+	}
+	go func(done chan struct{}) {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if err := a.refreshCRL(now); err != nil {
+					log.Printf("error generating certificate revocation list: %v", err)
+				}
+			}
+		}
+	}(a.crl.done)
+	return nil
+}
+
+// refreshCRL generates new full CRLs if the current ones are older than the
+// cache duration, or new delta CRLs otherwise.
+//
+// The refreshes run one at a time, so the lists are always replaced by others
+// generated from a later read of the revoked certificates. A refresh started
+// before the last one, e.g. a tick that waited for the refresh of a
+// revocation, is skipped, the lists already include its revocations.
+func (a *Authority) refreshCRL(now time.Time) error {
+	a.crl.refresh.Lock()
+	defer a.crl.refresh.Unlock()
+	switch {
+	case now.Before(a.crl.refreshed):
+		return nil
+	case now.Equal(a.crl.refreshed):
+		// The numbers of the lists must increase.
+		now = now.Add(time.Nanosecond)
+	}
+
+	a.crl.RLock()
+	thisUpdate := a.crl.thisUpdate
+	a.crl.RUnlock()
+
+	c := a.config.CRL
+	if thisUpdate.IsZero() || c.deltaCacheDuration() == 0 || now.Sub(thisUpdate) >= c.cacheDuration() {
+		if err := a.generateCRL(now); err != nil {
+			return err
+		}
+	}
+	if c.deltaCacheDuration() > 0 {
+		if err := a.generateDeltaCRL(now); err != nil {
+			return err
+		}
+	}
+	a.crl.refreshed = now
+	return nil
+}
+
+// generateCRL creates a full CRL for every intermediate with all the
+// certificates it issued that have been revoked.
+func (a *Authority) generateCRL(now time.Time) error {
+	revoked, err := a.db.GetRevokedCertificates()
+	if err != nil {
+		return errors.Wrap(err, "error getting revoked certificates")
+	}
+
+	c := a.config.CRL
+	number := big.NewInt(now.UnixNano())
+	issuers := a.crlIssuers()
+	groups := a.revokedByIssuer(issuers, revoked)
+	lists := make(map[string]*crlList, len(issuers))
+	for _, iss := range issuers {
+		entries, err := revokedCertificateEntries(groups[iss.name], nil)
+		if err != nil {
+			return err
+		}
+		tmpl := &x509.RevocationList{
+			RevokedCertificates: entries,
+			Number:              number,
+			ThisUpdate:          now,
+			NextUpdate:          now.Add(2 * c.cacheDuration()),
+		}
+		if dps := iss.deltaDistributionPoints(c); len(dps) > 0 {
+			ext, err := freshestCRLExtension(dps)
+			if err != nil {
+				return err
+			}
+			tmpl.ExtraExtensions = append(tmpl.ExtraExtensions, ext)
+		}
+		b, err := x509.CreateRevocationList(rand.Reader, tmpl, iss.crt, iss.signer)
+		if err != nil {
+			return errors.Wrapf(err, "error creating certificate revocation list%s", issuerSuffix(iss.name))
+		}
+
+		serials := make(map[string]bool, len(groups[iss.name]))
+		for _, rci := range groups[iss.name] {
+			serials[rci.Serial] = true
+		}
+		lists[iss.name] = &crlList{full: b, number: number, serials: serials}
+	}
+
+	a.crl.Lock()
+	a.crl.lists = lists
+	a.crl.thisUpdate = now
+	a.crl.Unlock()
+	return nil
+}
+
+// generateDeltaCRL creates a delta CRL for every intermediate with the
+// certificates revoked after its last full CRL.
+func (a *Authority) generateDeltaCRL(now time.Time) error {
+	a.crl.RLock()
+	lists := a.crl.lists
+	a.crl.RUnlock()
+
+	revoked, err := a.db.GetRevokedCertificates()
+	if err != nil {
+		return errors.Wrap(err, "error getting revoked certificates")
+	}
+
+	issuers := a.crlIssuers()
+	groups := a.revokedByIssuer(issuers, revoked)
+	deltas := make(map[string][]byte, len(issuers))
+	for _, iss := range issuers {
+		l, ok := lists[iss.name]
+		if !ok {
+			continue
+		}
+		entries, err := revokedCertificateEntries(groups[iss.name], l.serials)
+		if err != nil {
+			return err
+		}
+		indicator, err := asn1.Marshal(l.number)
+		if err != nil {
+			return errors.Wrap(err, "error marshaling delta crl indicator")
+		}
+		tmpl := &x509.RevocationList{
+			RevokedCertificates: entries,
+			Number:              big.NewInt(now.UnixNano()),
+			ThisUpdate:          now,
+			NextUpdate:          now.Add(2 * a.config.CRL.deltaCacheDuration()),
+			ExtraExtensions: []pkix.Extension{
+				{Id: oidExtensionDeltaCRLIndicator, Critical: true, Value: indicator},
+			},
+		}
+		b, err := x509.CreateRevocationList(rand.Reader, tmpl, iss.crt, iss.signer)
+		if err != nil {
+			return errors.Wrapf(err, "error creating delta certificate revocation list%s", issuerSuffix(iss.name))
+		}
+		deltas[iss.name] = b
+	}
+
+	a.crl.Lock()
+	for name, b := range deltas {
+		lists[name].delta = b
+	}
+	a.crl.Unlock()
+	return nil
+}
+
+// issuerSuffix returns the text used in the errors to identify an additional
+// intermediate.
+func issuerSuffix(name string) string {
+	if name == "" {
+		return ""
+	}
+	return " of intermediate " + name
+}
+
+// revokedCertificateEntries converts the revoked certificates, except the
+// ones in the skip set, to CRL entries.
+func revokedCertificateEntries(revoked []*db.RevokedCertificateInfo, skip map[string]bool) ([]pkix.RevokedCertificate, error) {
+	var entries []pkix.RevokedCertificate
+	for _, rci := range revoked {
+		if skip[rci.Serial] {
+			continue
+		}
+		sn, ok := new(big.Int).SetString(rci.Serial, 10)
+		if !ok {
+			return nil, errors.Errorf("error parsing serial number %s", rci.Serial)
+		}
+		entry := pkix.RevokedCertificate{
+			SerialNumber:   sn,
+			RevocationTime: rci.RevokedAt.UTC(),
+		}
+		if rci.ReasonCode > 0 {
+			b, err := asn1.Marshal(asn1.Enumerated(rci.ReasonCode))
+			if err != nil {
+				return nil, errors.Wrap(err, "error marshaling reason code")
+			}
+			entry.Extensions = []pkix.Extension{{Id: oidExtensionReasonCode, Value: b}}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// GetCertificateRevocationList returns the last full CRL in DER format of the
+// additional intermediate with the given name, or of the default intermediate
+// if the name is empty.
+func (a *Authority) GetCertificateRevocationList(name string) ([]byte, error) {
+	if a.crl == nil {
+		return nil, errs.NotFound("getCertificateRevocationList: crl is not enabled")
+	}
+	a.crl.RLock()
+	defer a.crl.RUnlock()
+	l, err := a.crl.list(name)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusNotFound, err, "getCertificateRevocationList")
+	}
+	if l == nil || l.full == nil {
+		return nil, errs.Errorf(http.StatusServiceUnavailable, "getCertificateRevocationList: crl is not available")
+	}
+	return l.full, nil
+}
+
+// GetDeltaCertificateRevocationList returns the last delta CRL in DER format
+// of the additional intermediate with the given name, or of the default
+// intermediate if the name is empty.
+func (a *Authority) GetDeltaCertificateRevocationList(name string) ([]byte, error) {
+	if a.crl == nil || a.config.CRL.deltaCacheDuration() == 0 {
+		return nil, errs.NotFound("getDeltaCertificateRevocationList: delta crl is not enabled")
+	}
+	a.crl.RLock()
+	defer a.crl.RUnlock()
+	l, err := a.crl.list(name)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusNotFound, err, "getDeltaCertificateRevocationList")
+	}
+	if l == nil || l.delta == nil {
+		return nil, errs.Errorf(http.StatusServiceUnavailable, "getDeltaCertificateRevocationList: delta crl is not available")
+	}
+	return l.delta, nil
+}
+
+// list returns the lists of the intermediate with the given name, it returns
+// nil if the lists are not generated yet. The lock must be held.
+func (s *crlState) list(name string) (*crlList, error) {
+	if l, ok := s.lists[name]; ok || s.lists == nil || name == "" {
+		return l, nil
+	}
+	return nil, errors.Errorf("intermediate %s was not found", name)
+}
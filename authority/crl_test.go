@@ -0,0 +1,421 @@
+package authority
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/crypto/pemutil"
+)
+
+func TestCRLConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		config *CRLConfig
+		err    error
+	}{
+		"ok/nil":   {nil, nil},
+		"ok/empty": {&CRLConfig{}, nil},
+		"ok/delta": {&CRLConfig{Enabled: true, DeltaCacheDuration: &provisioner.Duration{Duration: time.Hour}}, nil},
+		"fail/cacheDuration": {
+			&CRLConfig{CacheDuration: &provisioner.Duration{Duration: -time.Hour}},
+			errors.New("crl.cacheDuration cannot be less than 0"),
+		},
+		"fail/deltaCacheDuration": {
+			&CRLConfig{DeltaCacheDuration: &provisioner.Duration{Duration: -time.Hour}},
+			errors.New("crl.deltaCacheDuration cannot be less than 0"),
+		},
+		"fail/delta-too-long": {
+			&CRLConfig{
+				CacheDuration:      &provisioner.Duration{Duration: time.Hour},
+				DeltaCacheDuration: &provisioner.Duration{Duration: time.Hour},
+			},
+			errors.New("crl.deltaCacheDuration must be less than crl.cacheDuration"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.config.Validate()
+			if tc.err != nil {
+				if assert.NotNil(t, err) {
+					assert.Equals(t, tc.err.Error(), err.Error())
+				}
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestCRLConfig_apply(t *testing.T) {
+	var c *CRLConfig
+	cert := &x509.Certificate{CRLDistributionPoints: []string{"http://old/crl"}}
+	assert.FatalError(t, c.apply(cert, nil))
+	assert.Equals(t, []string{"http://old/crl"}, cert.CRLDistributionPoints)
+
+	c = &CRLConfig{
+		DistributionPoints:      []string{"http://ca/crl"},
+		DeltaDistributionPoints: []string{"http://ca/crl/delta"},
+		IssuingCertificateURLs:  []string{"http://ca/intermediate.crt"},
+	}
+	old, err := freshestCRLExtension([]string{"http://old/crl/delta"})
+	assert.FatalError(t, err)
+	other := pkix.Extension{Id: asn1.ObjectIdentifier{1, 2, 3, 4}, Value: []byte{0x05, 0x00}}
+	cert.ExtraExtensions = []pkix.Extension{old, other}
+	assert.FatalError(t, c.apply(cert, nil))
+	assert.Equals(t, c.DistributionPoints, cert.CRLDistributionPoints)
+	assert.Equals(t, c.IssuingCertificateURLs, cert.IssuingCertificateURL)
+
+	ext, err := freshestCRLExtension(c.DeltaDistributionPoints)
+	assert.FatalError(t, err)
+	assert.Equals(t, []pkix.Extension{ext, other}, cert.ExtraExtensions)
+
+	var dps []distributionPoint
+	_, err = asn1.Unmarshal(ext.Value, &dps)
+	assert.FatalError(t, err)
+	if assert.Len(t, 1, dps) && assert.Len(t, 1, dps[0].DistributionPoint.FullName) {
+		assert.Equals(t, "http://ca/crl/delta", string(dps[0].DistributionPoint.FullName[0].Bytes))
+	}
+
+	// The distribution points of an additional intermediate replace the
+	// configured ones.
+	ic := &IntermediateConfig{
+		Name:                       "bu",
+		CRLDistributionPoints:      []string{"http://ca/intermediates/bu/crl"},
+		DeltaCRLDistributionPoints: []string{"http://ca/intermediates/bu/crl/delta"},
+	}
+	assert.FatalError(t, c.apply(cert, ic))
+	assert.Equals(t, ic.CRLDistributionPoints, cert.CRLDistributionPoints)
+	assert.Equals(t, c.IssuingCertificateURLs, cert.IssuingCertificateURL)
+	ext, err = freshestCRLExtension(ic.DeltaCRLDistributionPoints)
+	assert.FatalError(t, err)
+	assert.Equals(t, []pkix.Extension{ext, other}, cert.ExtraExtensions)
+}
+
+func TestAuthority_GetCertificateRevocationList(t *testing.T) {
+	revokedAt := time.Now().Add(-time.Hour).Truncate(time.Second).UTC()
+	revoked := []*db.RevokedCertificateInfo{
+		{Serial: "1234", ReasonCode: 1, RevokedAt: revokedAt},
+		{Serial: "5678", RevokedAt: revokedAt},
+	}
+
+	t.Run("fail/not-enabled", func(t *testing.T) {
+		a := testAuthority(t)
+		_, err := a.GetCertificateRevocationList("")
+		if assert.NotNil(t, err) {
+			assert.Equals(t, http.StatusNotFound, err.(errs.StatusCoder).StatusCode())
+		}
+		_, err = a.GetDeltaCertificateRevocationList("")
+		if assert.NotNil(t, err) {
+			assert.Equals(t, http.StatusNotFound, err.(errs.StatusCoder).StatusCode())
+		}
+	})
+
+	t.Run("fail/db", func(t *testing.T) {
+		a := testAuthority(t)
+		a.config.CRL = &CRLConfig{Enabled: true}
+		a.db = &db.MockAuthDB{Err: db.ErrNotImplemented}
+		err := a.initCRL()
+		if assert.NotNil(t, err) {
+			assert.HasPrefix(t, err.Error(), "error getting revoked certificates")
+		}
+	})
+
+	t.Run("fail/serial", func(t *testing.T) {
+		a := testAuthority(t)
+		a.config.CRL = &CRLConfig{Enabled: true}
+		a.db = &db.MockAuthDB{
+			MGetRevokedCertificates: func() ([]*db.RevokedCertificateInfo, error) {
+				return []*db.RevokedCertificateInfo{{Serial: "foo", AuthorityKeyID: []byte{1}}}, nil
+			},
+		}
+		err := a.initCRL()
+		if assert.NotNil(t, err) {
+			assert.Equals(t, "error parsing serial number foo", err.Error())
+		}
+	})
+
+	t.Run("ok", func(t *testing.T) {
+		a := testAuthority(t)
+		a.config.CRL = &CRLConfig{
+			Enabled:                 true,
+			DeltaCacheDuration:      &provisioner.Duration{Duration: time.Hour},
+			DeltaDistributionPoints: []string{"http://ca/crl/delta"},
+		}
+		current := revoked[:1]
+		a.db = &db.MockAuthDB{
+			MGetRevokedCertificates: func() ([]*db.RevokedCertificateInfo, error) {
+				return current, nil
+			},
+			MGetCertificate: func(serial string) (*x509.Certificate, error) {
+				return nil, db.ErrNotFound
+			},
+		}
+		assert.FatalError(t, a.initCRL())
+		defer close(a.crl.done)
+
+		b, err := a.GetCertificateRevocationList("")
+		assert.FatalError(t, err)
+		crl, err := x509.ParseRevocationList(b)
+		assert.FatalError(t, err)
+		assert.FatalError(t, crl.CheckSignatureFrom(a.x509Issuer))
+		if assert.Len(t, 1, crl.RevokedCertificateEntries) {
+			entry := crl.RevokedCertificateEntries[0]
+			assert.Equals(t, big.NewInt(1234), entry.SerialNumber)
+			assert.Equals(t, revokedAt, entry.RevocationTime)
+			assert.Equals(t, 1, entry.ReasonCode)
+		}
+		assert.Equals(t, 48*time.Hour, crl.NextUpdate.Sub(crl.ThisUpdate))
+		var hasFreshest bool
+		for _, ext := range crl.Extensions {
+			hasFreshest = hasFreshest || ext.Id.Equal(oidExtensionFreshestCRL)
+		}
+		assert.True(t, hasFreshest)
+
+		// A new revocation is only in the delta CRL.
+		current = revoked
+		assert.FatalError(t, a.refreshCRL(time.Now()))
+		b2, err := a.GetCertificateRevocationList("")
+		assert.FatalError(t, err)
+		assert.Equals(t, b, b2)
+
+		b, err = a.GetDeltaCertificateRevocationList("")
+		assert.FatalError(t, err)
+		delta, err := x509.ParseRevocationList(b)
+		assert.FatalError(t, err)
+		if assert.Len(t, 1, delta.RevokedCertificateEntries) {
+			assert.Equals(t, big.NewInt(5678), delta.RevokedCertificateEntries[0].SerialNumber)
+		}
+		assert.True(t, delta.Number.Cmp(crl.Number) > 0)
+		var indicator *big.Int
+		for _, ext := range delta.Extensions {
+			if ext.Id.Equal(oidExtensionDeltaCRLIndicator) {
+				assert.True(t, ext.Critical)
+				_, err := asn1.Unmarshal(ext.Value, &indicator)
+				assert.FatalError(t, err)
+			}
+		}
+		assert.Equals(t, crl.Number, indicator)
+
+		// A full CRL is generated after the cache duration.
+		assert.FatalError(t, a.refreshCRL(time.Now().Add(25*time.Hour)))
+		b, err = a.GetCertificateRevocationList("")
+		assert.FatalError(t, err)
+		crl, err = x509.ParseRevocationList(b)
+		assert.FatalError(t, err)
+		assert.Len(t, 2, crl.RevokedCertificateEntries)
+		b, err = a.GetDeltaCertificateRevocationList("")
+		assert.FatalError(t, err)
+		delta, err = x509.ParseRevocationList(b)
+		assert.FatalError(t, err)
+		assert.Len(t, 0, delta.RevokedCertificateEntries)
+	})
+	t.Run("ok/intermediates", func(t *testing.T) {
+		rootKey := mustSigner(t)
+		root := mustCertificate(t, "CRL Root", true, rootKey.Public(), nil, rootKey)
+		buKey := mustSigner(t)
+		buIntermediate := mustCertificate(t, "BU Intermediate", true, buKey.Public(), root, rootKey)
+
+		a := testAuthority(t)
+		a.x509Issuers = []*x509Issuer{{name: "bu", crt: buIntermediate, signer: buKey}}
+		defaultIssuer, _ := a.getX509Issuer()
+		a.config.CRL = &CRLConfig{Enabled: true}
+		a.db = &db.MockAuthDB{
+			MGetRevokedCertificates: func() ([]*db.RevokedCertificateInfo, error) {
+				return []*db.RevokedCertificateInfo{
+					{Serial: "1234", RevokedAt: revokedAt, AuthorityKeyID: defaultIssuer.SubjectKeyId},
+					{Serial: "5678", RevokedAt: revokedAt, AuthorityKeyID: buIntermediate.SubjectKeyId},
+					{Serial: "9012", RevokedAt: revokedAt},
+				}, nil
+			},
+			MGetCertificate: func(serial string) (*x509.Certificate, error) {
+				if serial == "9012" {
+					return &x509.Certificate{AuthorityKeyId: buIntermediate.SubjectKeyId}, nil
+				}
+				return nil, db.ErrNotFound
+			},
+		}
+		assert.FatalError(t, a.initCRL())
+		defer close(a.crl.done)
+
+		serials := func(crl *x509.RevocationList) []string {
+			var s []string
+			for _, e := range crl.RevokedCertificateEntries {
+				s = append(s, e.SerialNumber.String())
+			}
+			return s
+		}
+
+		b, err := a.GetCertificateRevocationList("")
+		assert.FatalError(t, err)
+		crl, err := x509.ParseRevocationList(b)
+		assert.FatalError(t, err)
+		assert.FatalError(t, crl.CheckSignatureFrom(defaultIssuer))
+		assert.Equals(t, []string{"1234"}, serials(crl))
+
+		b, err = a.GetCertificateRevocationList("bu")
+		assert.FatalError(t, err)
+		crl, err = x509.ParseRevocationList(b)
+		assert.FatalError(t, err)
+		assert.FatalError(t, crl.CheckSignatureFrom(buIntermediate))
+		assert.Equals(t, []string{"5678", "9012"}, serials(crl))
+
+		_, err = a.GetCertificateRevocationList("foo")
+		if assert.NotNil(t, err) {
+			assert.Equals(t, http.StatusNotFound, err.(errs.StatusCoder).StatusCode())
+		}
+	})
+	t.Run("ok/revoke", func(t *testing.T) {
+		crt, err := pemutil.ReadCertificate("./testdata/certs/foo.crt")
+		assert.FatalError(t, err)
+		var stored []*db.RevokedCertificateInfo
+		a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+			MRevoke: func(rci *db.RevokedCertificateInfo) error {
+				stored = append(stored, rci)
+				return nil
+			},
+			MGetRevokedCertificates: func() ([]*db.RevokedCertificateInfo, error) {
+				return stored, nil
+			},
+		}))
+		a.config.CRL = &CRLConfig{Enabled: true}
+		assert.FatalError(t, a.initCRL())
+		defer close(a.crl.done)
+
+		// The CRL is generated again as soon as a certificate is revoked.
+		ctx := provisioner.NewContextWithMethod(context.Background(), provisioner.RevokeMethod)
+		assert.FatalError(t, a.Revoke(ctx, &RevokeOptions{
+			Crt:    crt,
+			Serial: crt.SerialNumber.String(),
+			MTLS:   true,
+		}))
+		b, err := a.GetCertificateRevocationList("")
+		assert.FatalError(t, err)
+		crl, err := x509.ParseRevocationList(b)
+		assert.FatalError(t, err)
+		if assert.Len(t, 1, crl.RevokedCertificateEntries) {
+			assert.Equals(t, crt.SerialNumber, crl.RevokedCertificateEntries[0].SerialNumber)
+		}
+	})
+}
+
+func TestAuthority_refreshCRL_concurrent(t *testing.T) {
+	revokedAt := time.Now().Add(-time.Hour).Truncate(time.Second).UTC()
+	var mu sync.Mutex
+	var stored []*db.RevokedCertificateInfo
+	revoke := func(serial string) {
+		mu.Lock()
+		stored = append(stored, &db.RevokedCertificateInfo{Serial: serial, RevokedAt: revokedAt})
+		mu.Unlock()
+	}
+	// The first read of a blocked refresh waits until it's released.
+	var block, read, release chan struct{}
+	a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+		MGetRevokedCertificates: func() ([]*db.RevokedCertificateInfo, error) {
+			mu.Lock()
+			revoked := append([]*db.RevokedCertificateInfo{}, stored...)
+			b := block
+			block = nil
+			mu.Unlock()
+			if b != nil {
+				close(read)
+				<-release
+			}
+			return revoked, nil
+		},
+		MGetCertificate: func(serial string) (*x509.Certificate, error) {
+			return nil, db.ErrNotFound
+		},
+	}))
+	a.config.CRL = &CRLConfig{
+		Enabled:            true,
+		DeltaCacheDuration: &provisioner.Duration{Duration: time.Hour},
+	}
+	assert.FatalError(t, a.initCRL())
+	defer close(a.crl.done)
+
+	serials := func(full bool) map[string]bool {
+		get := a.GetDeltaCertificateRevocationList
+		if full {
+			get = a.GetCertificateRevocationList
+		}
+		b, err := get("")
+		assert.FatalError(t, err)
+		crl, err := x509.ParseRevocationList(b)
+		assert.FatalError(t, err)
+		m := make(map[string]bool)
+		for _, e := range crl.RevokedCertificateEntries {
+			m[e.SerialNumber.String()] = true
+		}
+		return m
+	}
+
+	// A tick reads the revoked certificates before a revocation, and it would
+	// finish after the refresh of the revocation.
+	mu.Lock()
+	block, read, release = make(chan struct{}), make(chan struct{}), make(chan struct{})
+	mu.Unlock()
+	tick := make(chan error)
+	go func() {
+		tick <- a.refreshCRL(time.Now())
+	}()
+	<-read
+	revoke("1234")
+	revoked := make(chan error)
+	go func() {
+		revoked <- a.refreshCRL(time.Now())
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	assert.FatalError(t, <-tick)
+	assert.FatalError(t, <-revoked)
+	assert.Equals(t, map[string]bool{"1234": true}, serials(false))
+
+	// A refresh older than the last one is skipped.
+	delta := serials(false)
+	revoke("5678")
+	assert.FatalError(t, a.refreshCRL(time.Now().Add(-time.Minute)))
+	assert.Equals(t, delta, serials(false))
+
+	// Concurrent revocations and refreshes, some of them generating full
+	// lists, end with all the certificates in the lists.
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			now := time.Now()
+			if i%5 == 0 {
+				now = now.Add(25 * time.Hour)
+			} else {
+				revoke(strconv.Itoa(10000 + i))
+			}
+			if err := a.refreshCRL(now); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.FatalError(t, a.refreshCRL(time.Now().Add(26*time.Hour)))
+	got := serials(true)
+	for k := range serials(false) {
+		got[k] = true
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, len(stored), got)
+	for _, rci := range stored {
+		assert.True(t, got[rci.Serial])
+	}
+}
@@ -0,0 +1,138 @@
+package authority
+
+import (
+	"crypto"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/errs"
+)
+
+// CryptoPolicy represents the policy of the subject public keys and the
+// signature algorithms of the certificate requests accepted by the CA. It
+// applies to all the X.509 certificates signed, renewed or rekeyed.
+//
+// KeyTypes is the list of allowed key types, "RSA", "EC" or "Ed25519", all of
+// them by default. MinRSAKeySize is the minimum size in bits of the RSA keys,
+// and Curves the list of allowed elliptic curves, "P-256", "P-384" or "P-521".
+// SignatureAlgorithms is the list of allowed signature algorithms of the
+// certificate requests, e.g. "SHA256-RSA", "ECDSA-SHA256" or "Ed25519". DSA
+// keys are always rejected.
+type CryptoPolicy struct {
+	KeyTypes            []string `json:"keyTypes,omitempty"`
+	MinRSAKeySize       int      `json:"minRSAKeySize,omitempty"`
+	Curves              []string `json:"curves,omitempty"`
+	SignatureAlgorithms []string `json:"signatureAlgorithms,omitempty"`
+}
+
+// Validate checks the fields in CryptoPolicy.
+func (p *CryptoPolicy) Validate() error {
+	if p == nil {
+		return nil
+	}
+	for _, kt := range p.KeyTypes {
+		if kt != "RSA" && kt != "EC" && kt != "Ed25519" {
+			return errors.Errorf("keyTypes contains an unsupported value %s", kt)
+		}
+	}
+	if p.MinRSAKeySize < 0 {
+		return errors.New("minRSAKeySize cannot be less than 0")
+	}
+	for _, crv := range p.Curves {
+		if crv != "P-256" && crv != "P-384" && crv != "P-521" {
+			return errors.Errorf("curves contains an unsupported value %s", crv)
+		}
+	}
+	for _, alg := range p.SignatureAlgorithms {
+		if parseSignatureAlgorithm(alg) == x509.UnknownSignatureAlgorithm {
+			return errors.Errorf("signatureAlgorithms contains an unsupported value %s", alg)
+		}
+	}
+	return nil
+}
+
+// checkCertificateRequest checks the public key and the signature algorithm
+// of a certificate request. A nil policy accepts all the requests.
+func (p *CryptoPolicy) checkCertificateRequest(csr *x509.CertificateRequest) error {
+	if p == nil {
+		return nil
+	}
+	if err := p.checkPublicKey(csr.PublicKey); err != nil {
+		return err
+	}
+	if len(p.SignatureAlgorithms) > 0 && !containsString(p.SignatureAlgorithms, csr.SignatureAlgorithm.String()) {
+		return errors.Errorf("certificate request signature algorithm %s is not allowed; allowed algorithms are %v",
+			csr.SignatureAlgorithm, p.SignatureAlgorithms)
+	}
+	return nil
+}
+
+// CheckCertificateRequest checks the public key and the signature algorithm of
+// a certificate request against the crypto policy of the authority, e.g. to
+// reject an ACME CSR before the order is finalized.
+func (a *Authority) CheckCertificateRequest(csr *x509.CertificateRequest) error {
+	return a.config.AuthorityConfig.CryptoPolicy.checkCertificateRequest(csr)
+}
+
+// checkPublicKey checks the type and size of a subject public key. A nil
+// policy accepts all the keys.
+func (p *CryptoPolicy) checkPublicKey(pub crypto.PublicKey) error {
+	if p == nil {
+		return nil
+	}
+	var keyType string
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		keyType = "RSA"
+		if size := k.N.BitLen(); p.MinRSAKeySize > 0 && size < p.MinRSAKeySize {
+			return errors.Errorf("RSA key size %d is smaller than the minimum %d", size, p.MinRSAKeySize)
+		}
+	case *ecdsa.PublicKey:
+		keyType = "EC"
+		if crv := k.Curve.Params().Name; len(p.Curves) > 0 && !containsString(p.Curves, crv) {
+			return errors.Errorf("EC curve %s is not allowed; allowed curves are %v", crv, p.Curves)
+		}
+	case ed25519.PublicKey:
+		keyType = "Ed25519"
+	case *dsa.PublicKey:
+		return errors.New("DSA keys are not allowed")
+	default:
+		return errors.Errorf("public key type %T is not supported", k)
+	}
+	if len(p.KeyTypes) > 0 && !containsString(p.KeyTypes, keyType) {
+		return errors.Errorf("key type %s is not allowed; allowed types are %v", keyType, p.KeyTypes)
+	}
+	return nil
+}
+
+// cryptoPolicyError returns the error of a request rejected by the crypto
+// policy, the reason is also the message returned to the client.
+func cryptoPolicyError(err error, method string, opts ...interface{}) error {
+	opts = append(opts, errs.WithMessage("%s", "the request was rejected by the crypto policy: "+err.Error()))
+	return errs.Wrap(http.StatusBadRequest, err, method, opts...)
+}
+
+// parseSignatureAlgorithm returns the x509.SignatureAlgorithm with the given
+// name, or x509.UnknownSignatureAlgorithm if it's not supported.
+func parseSignatureAlgorithm(name string) x509.SignatureAlgorithm {
+	for alg := x509.MD2WithRSA; alg <= x509.PureEd25519; alg++ {
+		if alg.String() == name {
+			return alg
+		}
+	}
+	return x509.UnknownSignatureAlgorithm
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+package authority
+
+import (
+	"crypto"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
+)
+
+func TestCryptoPolicy_Validate(t *testing.T) {
+	tests := map[string]struct {
+		policy *CryptoPolicy
+		err    string
+	}{
+		"ok/nil":   {nil, ""},
+		"ok/empty": {&CryptoPolicy{}, ""},
+		"ok": {&CryptoPolicy{
+			KeyTypes:            []string{"RSA", "EC", "Ed25519"},
+			MinRSAKeySize:       3072,
+			Curves:              []string{"P-256", "P-384", "P-521"},
+			SignatureAlgorithms: []string{"SHA256-RSA", "SHA384-RSAPSS", "ECDSA-SHA256", "Ed25519"},
+		}, ""},
+		"fail/keyTypes":            {&CryptoPolicy{KeyTypes: []string{"OKP"}}, "keyTypes contains an unsupported value OKP"},
+		"fail/minRSAKeySize":       {&CryptoPolicy{MinRSAKeySize: -1}, "minRSAKeySize cannot be less than 0"},
+		"fail/curves":              {&CryptoPolicy{Curves: []string{"P-224"}}, "curves contains an unsupported value P-224"},
+		"fail/signatureAlgorithms": {&CryptoPolicy{SignatureAlgorithms: []string{"SHA256-ECDSA"}}, "signatureAlgorithms contains an unsupported value SHA256-ECDSA"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.policy.Validate()
+			if tc.err == "" {
+				assert.FatalError(t, err)
+			} else if assert.NotNil(t, err) {
+				assert.Equals(t, tc.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestCryptoPolicy_checkPublicKey(t *testing.T) {
+	rsa1024, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.FatalError(t, err)
+	rsa2048, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.FatalError(t, err)
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	p224, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	assert.FatalError(t, err)
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.FatalError(t, err)
+	dsaKey := &dsa.PublicKey{}
+
+	policy := &CryptoPolicy{
+		KeyTypes:      []string{"RSA", "EC"},
+		MinRSAKeySize: 2048,
+		Curves:        []string{"P-256", "P-384"},
+	}
+
+	tests := map[string]struct {
+		policy *CryptoPolicy
+		pub    crypto.PublicKey
+		err    string
+	}{
+		"ok/nil":           {nil, rsa1024.Public(), ""},
+		"ok/nil-dsa":       {nil, dsaKey, ""},
+		"ok/empty":         {&CryptoPolicy{}, p224.Public(), ""},
+		"ok/rsa":           {policy, rsa2048.Public(), ""},
+		"ok/ec":            {policy, p256.Public(), ""},
+		"fail/rsa-size":    {policy, rsa1024.Public(), "RSA key size 1024 is smaller than the minimum 2048"},
+		"fail/ec-curve":    {policy, p224.Public(), "EC curve P-224 is not allowed; allowed curves are [P-256 P-384]"},
+		"fail/ed25519":     {policy, edPub, "key type Ed25519 is not allowed; allowed types are [RSA EC]"},
+		"fail/dsa":         {&CryptoPolicy{}, dsaKey, "DSA keys are not allowed"},
+		"fail/unsupported": {&CryptoPolicy{}, []byte("foo"), "public key type []uint8 is not supported"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.policy.checkPublicKey(tc.pub)
+			if tc.err == "" {
+				assert.FatalError(t, err)
+			} else if assert.NotNil(t, err) {
+				assert.Equals(t, tc.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestCryptoPolicy_checkCertificateRequest(t *testing.T) {
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	csr := getCSR(t, p256)
+
+	tests := map[string]struct {
+		policy *CryptoPolicy
+		err    string
+	}{
+		"ok/nil":                  {nil, ""},
+		"ok/signatureAlgorithm":   {&CryptoPolicy{SignatureAlgorithms: []string{"ECDSA-SHA256"}}, ""},
+		"fail/signatureAlgorithm": {&CryptoPolicy{SignatureAlgorithms: []string{"ECDSA-SHA384"}}, "certificate request signature algorithm ECDSA-SHA256 is not allowed; allowed algorithms are [ECDSA-SHA384]"},
+		"fail/keyType":            {&CryptoPolicy{KeyTypes: []string{"RSA"}}, "key type EC is not allowed; allowed types are [RSA]"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.policy.checkCertificateRequest(csr)
+			if tc.err == "" {
+				assert.FatalError(t, err)
+			} else if assert.NotNil(t, err) {
+				assert.Equals(t, tc.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestAuthority_cryptoPolicy(t *testing.T) {
+	a := testAuthority(t)
+
+	now := time.Now()
+	signOpts := provisioner.Options{
+		NotBefore: provisioner.NewTimeDuration(now),
+		NotAfter:  provisioner.NewTimeDuration(now.Add(time.Hour)),
+	}
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.FatalError(t, err)
+
+	chain, err := a.Sign(getCSR(t, p256), signOpts)
+	assert.FatalError(t, err)
+	cert := chain[0]
+
+	assertPolicyError := func(t *testing.T, err error, msg string) {
+		t.Helper()
+		if assert.NotNil(t, err) {
+			e, ok := err.(*errs.Error)
+			assert.Fatal(t, ok, "error is not of type *errs.Error")
+			assert.Equals(t, http.StatusBadRequest, e.StatusCode())
+			assert.Equals(t, "the request was rejected by the crypto policy: "+msg, e.Message())
+		}
+	}
+
+	a.config.AuthorityConfig.CryptoPolicy = &CryptoPolicy{Curves: []string{"P-384"}}
+
+	_, err = a.Sign(getCSR(t, p256), signOpts)
+	assertPolicyError(t, err, "EC curve P-256 is not allowed; allowed curves are [P-384]")
+	_, err = a.Renew(cert)
+	assertPolicyError(t, err, "EC curve P-256 is not allowed; allowed curves are [P-384]")
+	_, err = a.Rekey(cert, p256.Public())
+	assertPolicyError(t, err, "EC curve P-256 is not allowed; allowed curves are [P-384]")
+
+	chain, err = a.Sign(getCSR(t, p384), signOpts)
+	assert.FatalError(t, err)
+	assert.Equals(t, p384.Public(), chain[0].PublicKey)
+	chain, err = a.Rekey(cert, p384.Public())
+	assert.FatalError(t, err)
+	assert.Equals(t, p384.Public(), chain[0].PublicKey)
+}
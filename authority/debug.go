@@ -0,0 +1,34 @@
+package authority
+
+import "github.com/pkg/errors"
+
+// DebugConfig configures an optional listener with the Go profiling and
+// runtime endpoints: pprof, expvar and the goroutine dumps. The requests to
+// the listener must have the bearer token in the Authorization header.
+type DebugConfig struct {
+	Address     string `json:"address"`
+	BearerToken string `json:"bearerToken"`
+}
+
+// Validate checks the fields in DebugConfig.
+func (c *DebugConfig) Validate() error {
+	switch {
+	case c == nil:
+		return nil
+	case c.BearerToken == "":
+		return errors.New("debug.bearerToken cannot be empty")
+	case !isValidAddress(c.Address):
+		return errors.Errorf("invalid debug.address %s", c.Address)
+	default:
+		return nil
+	}
+}
+
+// GetAddress returns the address of the debug listener, or an empty string
+// if it's not enabled.
+func (c *DebugConfig) GetAddress() string {
+	if c == nil {
+		return ""
+	}
+	return c.Address
+}
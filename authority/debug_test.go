@@ -0,0 +1,30 @@
+package authority
+
+import (
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+func TestDebugConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *DebugConfig
+		want    string
+		wantErr bool
+	}{
+		{"nil", nil, "", false},
+		{"ok", &DebugConfig{Address: "127.0.0.1:9001", BearerToken: "s3cr3t"}, "127.0.0.1:9001", false},
+		{"ok port", &DebugConfig{Address: ":9001", BearerToken: "s3cr3t"}, ":9001", false},
+		{"fail token", &DebugConfig{Address: ":9001"}, ":9001", true},
+		{"fail address", &DebugConfig{Address: "localhost", BearerToken: "s3cr3t"}, "localhost", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("DebugConfig.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equals(t, tt.want, tt.config.GetAddress())
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package authority
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"golang.org/x/crypto/ssh"
+)
+
+// Health statuses.
+const (
+	HealthOK      = "ok"
+	HealthWarning = "warning"
+	HealthError   = "error"
+)
+
+// defaultExpiryThreshold is the remaining validity of an intermediate under
+// which its status is a warning.
+const defaultExpiryThreshold = 30 * 24 * time.Hour
+
+// healthCacheDuration is the time the result of the health checks is reused,
+// so frequent probes do not send a signature to the KMS on every request.
+var healthCacheDuration = 5 * time.Second
+
+// HealthConfig represents the configuration of the health checks. If an
+// intermediate expires in less than ExpiryThreshold, 30 days by default, its
+// status is a warning.
+type HealthConfig struct {
+	ExpiryThreshold *provisioner.Duration `json:"expiryThreshold,omitempty"`
+}
+
+// Validate checks the fields in HealthConfig.
+func (c *HealthConfig) Validate() error {
+	if c != nil && c.ExpiryThreshold != nil && c.ExpiryThreshold.Duration < 0 {
+		return errors.New("health.expiryThreshold cannot be less than 0")
+	}
+	return nil
+}
+
+func (c *HealthConfig) expiryThreshold() time.Duration {
+	if c == nil || c.ExpiryThreshold == nil || c.ExpiryThreshold.Duration == 0 {
+		return defaultExpiryThreshold
+	}
+	return c.ExpiryThreshold.Duration
+}
+
+// ComponentHealth is the health of a component of the CA.
+type ComponentHealth struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// HealthStatus is the result of the health checks. Status is HealthError if
+// any component has an error, HealthOK otherwise.
+type HealthStatus struct {
+	Status     string                      `json:"status"`
+	Components map[string]*ComponentHealth `json:"components"`
+}
+
+// IsHealthy returns true if no component has an error.
+func (s *HealthStatus) IsHealthy() bool {
+	return s.Status != HealthError
+}
+
+// pinger is the interface implemented by the databases that can check their
+// availability.
+type pinger interface {
+	Ping() error
+}
+
+// CheckHealth checks the database, the signers and the validity of the
+// intermediates. The result is cached for a few seconds.
+func (a *Authority) CheckHealth() *HealthStatus {
+	a.healthMutex.Lock()
+	defer a.healthMutex.Unlock()
+	if a.health != nil && time.Since(a.healthCheckedAt) < healthCacheDuration {
+		return a.health
+	}
+
+	components := map[string]*ComponentHealth{}
+	if p, ok := a.db.(pinger); ok {
+		components["database"] = componentHealth(p.Ping())
+	}
+	if err := a.checkSigners(); err != errNoSigners {
+		components["signer"] = componentHealth(err)
+	}
+	if c := a.checkIntermediates(time.Now()); c != nil {
+		components["intermediates"] = c
+	}
+
+	status := &HealthStatus{Status: HealthOK, Components: components}
+	for _, c := range components {
+		if c.Status == HealthError {
+			status.Status = HealthError
+		}
+	}
+	a.health, a.healthCheckedAt = status, time.Now()
+	return status
+}
+
+func componentHealth(err error) *ComponentHealth {
+	if err != nil {
+		return &ComponentHealth{Status: HealthError, Message: err.Error()}
+	}
+	return &ComponentHealth{Status: HealthOK}
+}
+
+var errNoSigners = errors.New("no signers")
+
+// checkSigners signs a digest with the X.509 and SSH signers of the
+// authority. It returns errNoSigners if the authority does not have any, e.g.
+// if it uses a certificate authority service.
+func (a *Authority) checkSigners() error {
+	data := []byte("step-ca health check")
+	_, signer := a.getX509Issuer()
+	signers := map[string]crypto.Signer{}
+	if signer != nil {
+		signers["intermediate"] = signer
+	}
+	for _, iss := range a.x509Issuers {
+		signers["intermediate "+iss.name] = iss.signer
+	}
+	sshSigners := map[string]ssh.Signer{}
+	if a.sshCAUserCertSignKey != nil {
+		sshSigners["ssh user"] = a.sshCAUserCertSignKey
+	}
+	if a.sshCAHostCertSignKey != nil {
+		sshSigners["ssh host"] = a.sshCAHostCertSignKey
+	}
+	if len(signers) == 0 && len(sshSigners) == 0 {
+		return errNoSigners
+	}
+
+	for name, s := range signers {
+		digest, opts := signerInput(s, data)
+		if _, err := s.Sign(rand.Reader, digest, opts); err != nil {
+			return errors.Wrapf(err, "error signing with the %s key", name)
+		}
+	}
+	for name, s := range sshSigners {
+		if _, err := s.Sign(rand.Reader, data); err != nil {
+			return errors.Wrapf(err, "error signing with the %s key", name)
+		}
+	}
+	return nil
+}
+
+// signerInput returns the data to sign and the signer options for the type of
+// key of the signer.
+func signerInput(s crypto.Signer, data []byte) ([]byte, crypto.SignerOpts) {
+	if _, ok := s.Public().(ed25519.PublicKey); ok {
+		return data, crypto.Hash(0)
+	}
+	sum := sha256.Sum256(data)
+	return sum[:], crypto.SHA256
+}
+
+// checkIntermediates returns an error if an intermediate used to sign
+// certificates has expired, and a warning if it expires before the expiry
+// threshold. It returns nil if there are no intermediates.
+func (a *Authority) checkIntermediates(now time.Time) *ComponentHealth {
+	var crts []*x509.Certificate
+	if crt, _ := a.getX509Issuer(); crt != nil {
+		crts = append(crts, crt)
+	}
+	for _, iss := range a.x509Issuers {
+		crts = append(crts, iss.crt)
+	}
+	if len(crts) == 0 {
+		return nil
+	}
+
+	var hc *HealthConfig
+	if a.config != nil {
+		hc = a.config.Health
+	}
+	threshold := hc.expiryThreshold()
+	c := &ComponentHealth{Status: HealthOK}
+	for _, crt := range crts {
+		switch left := crt.NotAfter.Sub(now); {
+		case left <= 0:
+			return &ComponentHealth{
+				Status:  HealthError,
+				Message: fmt.Sprintf("intermediate %s expired at %s", crt.Subject.CommonName, crt.NotAfter.UTC().Format(time.RFC3339)),
+			}
+		case left < threshold && c.Status == HealthOK:
+			c.Status = HealthWarning
+			c.Message = fmt.Sprintf("intermediate %s expires at %s", crt.Subject.CommonName, crt.NotAfter.UTC().Format(time.RFC3339))
+		}
+	}
+	return c
+}
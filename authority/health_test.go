@@ -0,0 +1,189 @@
+package authority
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+)
+
+// pingDB is a MockAuthDB that implements the pinger interface.
+type pingDB struct {
+	db.MockAuthDB
+	err error
+}
+
+func (m *pingDB) Ping() error {
+	return m.err
+}
+
+// failSigner is a crypto.Signer that always fails.
+type failSigner struct {
+	crypto.Signer
+}
+
+func (s failSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return nil, errors.New("kms is not available")
+}
+
+func TestHealthConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		config *HealthConfig
+		err    error
+	}{
+		"ok/nil":   {nil, nil},
+		"ok/empty": {&HealthConfig{}, nil},
+		"ok":       {&HealthConfig{ExpiryThreshold: &provisioner.Duration{Duration: time.Hour}}, nil},
+		"fail/negative": {&HealthConfig{ExpiryThreshold: &provisioner.Duration{Duration: -time.Hour}},
+			errors.New("health.expiryThreshold cannot be less than 0")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tc.config.Validate(); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.Equals(t, tc.err.Error(), err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestAuthority_CheckHealth(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	now := time.Now()
+	intermediate := func(notAfter time.Time) *x509.Certificate {
+		return &x509.Certificate{Subject: pkix.Name{CommonName: "Intermediate CA"}, NotAfter: notAfter}
+	}
+	expires := now.Add(10 * 24 * time.Hour).UTC().Format(time.RFC3339)
+
+	tests := map[string]struct {
+		db       db.AuthDB
+		crt      *x509.Certificate
+		signer   crypto.Signer
+		config   *Config
+		expected *HealthStatus
+	}{
+		"ok": {
+			db:     &pingDB{},
+			crt:    intermediate(now.Add(365 * 24 * time.Hour)),
+			signer: key,
+			config: &Config{},
+			expected: &HealthStatus{Status: HealthOK, Components: map[string]*ComponentHealth{
+				"database":      {Status: HealthOK},
+				"signer":        {Status: HealthOK},
+				"intermediates": {Status: HealthOK},
+			}},
+		},
+		"ok/no-ping": {
+			db:     &db.MockAuthDB{},
+			crt:    intermediate(now.Add(365 * 24 * time.Hour)),
+			signer: key,
+			expected: &HealthStatus{Status: HealthOK, Components: map[string]*ComponentHealth{
+				"signer":        {Status: HealthOK},
+				"intermediates": {Status: HealthOK},
+			}},
+		},
+		"ok/no-signers": {
+			db: &pingDB{},
+			expected: &HealthStatus{Status: HealthOK, Components: map[string]*ComponentHealth{
+				"database": {Status: HealthOK},
+			}},
+		},
+		"warning/expiry": {
+			db:     &pingDB{},
+			crt:    intermediate(now.Add(10 * 24 * time.Hour)),
+			signer: key,
+			config: &Config{},
+			expected: &HealthStatus{Status: HealthOK, Components: map[string]*ComponentHealth{
+				"database":      {Status: HealthOK},
+				"signer":        {Status: HealthOK},
+				"intermediates": {Status: HealthWarning, Message: "intermediate Intermediate CA expires at " + expires},
+			}},
+		},
+		"ok/threshold": {
+			db:     &pingDB{},
+			crt:    intermediate(now.Add(10 * 24 * time.Hour)),
+			signer: key,
+			config: &Config{Health: &HealthConfig{ExpiryThreshold: &provisioner.Duration{Duration: 7 * 24 * time.Hour}}},
+			expected: &HealthStatus{Status: HealthOK, Components: map[string]*ComponentHealth{
+				"database":      {Status: HealthOK},
+				"signer":        {Status: HealthOK},
+				"intermediates": {Status: HealthOK},
+			}},
+		},
+		"fail/expired": {
+			db:     &pingDB{},
+			crt:    intermediate(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)),
+			signer: key,
+			expected: &HealthStatus{Status: HealthError, Components: map[string]*ComponentHealth{
+				"database":      {Status: HealthOK},
+				"signer":        {Status: HealthOK},
+				"intermediates": {Status: HealthError, Message: "intermediate Intermediate CA expired at 2020-01-01T00:00:00Z"},
+			}},
+		},
+		"fail/database": {
+			db:     &pingDB{err: errors.New("error writing to the database: force")},
+			crt:    intermediate(now.Add(365 * 24 * time.Hour)),
+			signer: key,
+			expected: &HealthStatus{Status: HealthError, Components: map[string]*ComponentHealth{
+				"database":      {Status: HealthError, Message: "error writing to the database: force"},
+				"signer":        {Status: HealthOK},
+				"intermediates": {Status: HealthOK},
+			}},
+		},
+		"fail/signer": {
+			db:     &pingDB{},
+			crt:    intermediate(now.Add(365 * 24 * time.Hour)),
+			signer: failSigner{key},
+			expected: &HealthStatus{Status: HealthError, Components: map[string]*ComponentHealth{
+				"database":      {Status: HealthOK},
+				"signer":        {Status: HealthError, Message: "error signing with the intermediate key: kms is not available"},
+				"intermediates": {Status: HealthOK},
+			}},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			a := &Authority{
+				config:     tc.config,
+				db:         tc.db,
+				x509Issuer: tc.crt,
+				x509Signer: tc.signer,
+			}
+			status := a.CheckHealth()
+			assert.Equals(t, tc.expected, status)
+			assert.Equals(t, status.Status != HealthError, status.IsHealthy())
+		})
+	}
+}
+
+func TestAuthority_CheckHealth_cache(t *testing.T) {
+	tmp := healthCacheDuration
+	defer func() {
+		healthCacheDuration = tmp
+	}()
+
+	mdb := &pingDB{}
+	a := &Authority{db: mdb}
+	assert.Equals(t, HealthOK, a.CheckHealth().Status)
+
+	// The result is cached.
+	mdb.err = errors.New("force")
+	assert.Equals(t, HealthOK, a.CheckHealth().Status)
+
+	healthCacheDuration = 0
+	assert.Equals(t, HealthError, a.CheckHealth().Status)
+}
@@ -0,0 +1,279 @@
+package authority
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/errs"
+	kmsapi "github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/secrets"
+)
+
+// IntermediateConfig represents an additional intermediate that can sign
+// X.509 certificates.
+//
+// An intermediate is selected by Name with the issuer attribute of the x509
+// options of a provisioner, or with the issuer returned by an X.509
+// template. If a certificate does not select an intermediate, it's signed by
+// the first one whose KeyTypes (RSA, EC or OKP) include the type of the
+// certificate key, or by the default intermediate, crt and key.
+//
+// If the CRL is enabled, the intermediate signs its own CRL, with the
+// certificates it issued. CRLDistributionPoints and DeltaCRLDistributionPoints
+// are added to the certificates it signs instead of the ones in the CRL
+// configuration.
+type IntermediateConfig struct {
+	Name                       string   `json:"name"`
+	Crt                        string   `json:"crt"`
+	Key                        string   `json:"key"`
+	KeyTypes                   []string `json:"keyTypes,omitempty"`
+	CRLDistributionPoints      []string `json:"crlDistributionPoints,omitempty"`
+	DeltaCRLDistributionPoints []string `json:"deltaCRLDistributionPoints,omitempty"`
+}
+
+// Validate checks the fields in IntermediateConfig.
+func (c *IntermediateConfig) Validate() error {
+	switch {
+	case c == nil:
+		return errors.New("intermediate cannot be empty")
+	case c.Name == "":
+		return errors.New("intermediate name cannot be empty")
+	case c.Crt == "":
+		return errors.Errorf("intermediate %s: crt cannot be empty", c.Name)
+	case c.Key == "":
+		return errors.Errorf("intermediate %s: key cannot be empty", c.Name)
+	}
+	for _, kt := range c.KeyTypes {
+		switch kt {
+		case "RSA", "EC", "OKP":
+		default:
+			return errors.Errorf("intermediate %s: key type %s is not valid", c.Name, kt)
+		}
+	}
+	return nil
+}
+
+// x509Issuer is an additional intermediate and its signer.
+type x509Issuer struct {
+	name     string
+	keyTypes []string
+	crt      *x509.Certificate
+	signer   crypto.Signer
+	config   *IntermediateConfig
+}
+
+// publicKeyType returns the JWK key type of the given public key.
+func publicKeyType(pub crypto.PublicKey) string {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return "RSA"
+	case *ecdsa.PublicKey:
+		return "EC"
+	case ed25519.PublicKey:
+		return "OKP"
+	default:
+		return ""
+	}
+}
+
+// selectX509Issuer returns the intermediate and signer with the given name,
+// or, if the name is empty, the first intermediate configured for the type of
+// the given public key or the default one.
+func (a *Authority) selectX509Issuer(name string, pub crypto.PublicKey) (*x509.Certificate, crypto.Signer, error) {
+	if name != "" {
+		for _, iss := range a.x509Issuers {
+			if iss.name == name {
+				return iss.crt, iss.signer, nil
+			}
+		}
+		return nil, nil, errors.Errorf("intermediate %s was not found", name)
+	}
+	if kt := publicKeyType(pub); kt != "" {
+		for _, iss := range a.x509Issuers {
+			for _, t := range iss.keyTypes {
+				if t == kt {
+					return iss.crt, iss.signer, nil
+				}
+			}
+		}
+	}
+	crt, signer := a.getX509Issuer()
+	return crt, signer, nil
+}
+
+// getX509IssuerFor returns the intermediate that issued the given
+// certificate, or the default one if it was not issued by any of the
+// additional intermediates.
+func (a *Authority) getX509IssuerFor(cert *x509.Certificate) (*x509.Certificate, crypto.Signer) {
+	if len(cert.AuthorityKeyId) > 0 {
+		for _, iss := range a.x509Issuers {
+			if bytes.Equal(cert.AuthorityKeyId, iss.crt.SubjectKeyId) {
+				return iss.crt, iss.signer
+			}
+		}
+	}
+	return a.getX509Issuer()
+}
+
+// intermediateConfig returns the configuration of the additional
+// intermediate with the given certificate, or nil if it's not one of them.
+func (a *Authority) intermediateConfig(crt *x509.Certificate) *IntermediateConfig {
+	if crt == nil {
+		return nil
+	}
+	for _, iss := range a.x509Issuers {
+		if iss.crt.Equal(crt) {
+			return iss.config
+		}
+	}
+	return nil
+}
+
+// getX509Issuer returns the intermediate certificate and the signer used to
+// sign new X.509 certificates.
+func (a *Authority) getX509Issuer() (*x509.Certificate, crypto.Signer) {
+	a.x509IssuerMutex.RLock()
+	defer a.x509IssuerMutex.RUnlock()
+	return a.x509Issuer, a.x509Signer
+}
+
+// GetIntermediateCertificates returns the default intermediate certificate
+// followed by the additional intermediates and the previous intermediates
+// that are still available to verify the certificates they issued. A
+// certificate authority service, e.g. a registration authority, does not
+// have intermediates.
+func (a *Authority) GetIntermediateCertificates() []*x509.Certificate {
+	a.x509IssuerMutex.RLock()
+	defer a.x509IssuerMutex.RUnlock()
+	var certs []*x509.Certificate
+	if a.x509Issuer != nil {
+		certs = append(certs, a.x509Issuer)
+	}
+	for _, iss := range a.x509Issuers {
+		certs = append(certs, iss.crt)
+	}
+	return append(certs, a.x509Intermediates...)
+}
+
+// RotateIntermediate replaces the intermediate certificate and signer used to
+// sign new certificates. The new certificate must match the signer and chain
+// to one of the roots of the authority. The old intermediate is kept as a
+// previous intermediate until it is retired with RetireIntermediate.
+func (a *Authority) RotateIntermediate(crt *x509.Certificate, signer crypto.Signer) error {
+	if a.x509CAService != nil {
+		return errs.NotImplemented("authority.RotateIntermediate; not supported with a certificate authority service")
+	}
+	if crt == nil || signer == nil {
+		return errs.BadRequest("authority.RotateIntermediate; certificate and signer cannot be nil")
+	}
+	if !crt.IsCA {
+		return errs.BadRequest("authority.RotateIntermediate; certificate is not a CA certificate")
+	}
+	if err := equalPublicKeys(crt.PublicKey, signer.Public()); err != nil {
+		return errs.Wrap(http.StatusBadRequest, err, "authority.RotateIntermediate")
+	}
+	if err := a.verifyIntermediate(crt); err != nil {
+		return errs.Wrap(http.StatusBadRequest, err, "authority.RotateIntermediate; error verifying certificate")
+	}
+
+	a.x509IssuerMutex.Lock()
+	defer a.x509IssuerMutex.Unlock()
+	if a.x509Issuer != nil && !a.x509Issuer.Equal(crt) {
+		intermediates := []*x509.Certificate{a.x509Issuer}
+		for _, c := range a.x509Intermediates {
+			if !c.Equal(crt) {
+				intermediates = append(intermediates, c)
+			}
+		}
+		a.x509Intermediates = intermediates
+	}
+	a.x509Issuer = crt
+	a.x509Signer = signer
+	return nil
+}
+
+// RotateIntermediateWithKey loads the signer for the given key with the key
+// manager of the authority and calls RotateIntermediate. The key is a path
+// or a KMS URI, like the intermediate key in the configuration.
+func (a *Authority) RotateIntermediateWithKey(crt *x509.Certificate, key string) error {
+	if key == "" {
+		return errs.BadRequest("authority.RotateIntermediateWithKey; key cannot be empty")
+	}
+	password, err := secrets.Resolve(context.Background(), a.config.Password)
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "authority.RotateIntermediateWithKey")
+	}
+	signer, err := a.keyManager.CreateSigner(&kmsapi.CreateSignerRequest{
+		SigningKey: key,
+		Password:   []byte(password),
+	})
+	if err != nil {
+		return errs.Wrap(http.StatusBadRequest, err, "authority.RotateIntermediateWithKey; error loading key")
+	}
+	return a.RotateIntermediate(crt, signer)
+}
+
+// RetireIntermediate removes the previous intermediate with the given SHA-256
+// fingerprint. The intermediate used to sign new certificates cannot be
+// retired.
+func (a *Authority) RetireIntermediate(fingerprint string) error {
+	fingerprint = strings.ToLower(fingerprint)
+
+	a.x509IssuerMutex.Lock()
+	defer a.x509IssuerMutex.Unlock()
+	if a.x509Issuer != nil && fingerprint == certificateFingerprint(a.x509Issuer) {
+		return errs.BadRequest("authority.RetireIntermediate; the current intermediate cannot be retired")
+	}
+	for i, c := range a.x509Intermediates {
+		if fingerprint == certificateFingerprint(c) {
+			intermediates := append([]*x509.Certificate{}, a.x509Intermediates[:i]...)
+			a.x509Intermediates = append(intermediates, a.x509Intermediates[i+1:]...)
+			return nil
+		}
+	}
+	return errs.NotFound("authority.RetireIntermediate; intermediate %s was not found", fingerprint)
+}
+
+// verifyIntermediate checks that the given intermediate chains to one of the
+// roots of the authority.
+func (a *Authority) verifyIntermediate(crt *x509.Certificate) error {
+	roots := x509.NewCertPool()
+	for _, root := range a.rootX509Certs {
+		roots.AddCert(root)
+	}
+	_, err := crt.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+func certificateFingerprint(crt *x509.Certificate) string {
+	sum := sha256.Sum256(crt.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func equalPublicKeys(a, b crypto.PublicKey) error {
+	ab, err := x509.MarshalPKIXPublicKey(a)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling public key")
+	}
+	bb, err := x509.MarshalPKIXPublicKey(b)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling public key")
+	}
+	if !bytes.Equal(ab, bb) {
+		return errors.New("certificate public key does not match the signer")
+	}
+	return nil
+}
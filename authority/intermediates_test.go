@@ -0,0 +1,277 @@
+package authority
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/crypto/keys"
+	"github.com/smallstep/cli/crypto/pemutil"
+)
+
+func mustCertificate(t *testing.T, cn string, isCA bool, pub crypto.PublicKey, parent *x509.Certificate, signer crypto.Signer) *x509.Certificate {
+	t.Helper()
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if parent == nil {
+		parent = tmpl
+	}
+	b, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, signer)
+	assert.FatalError(t, err)
+	crt, err := x509.ParseCertificate(b)
+	assert.FatalError(t, err)
+	return crt
+}
+
+func mustSigner(t *testing.T) crypto.Signer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	return key
+}
+
+func TestAuthority_RotateIntermediate(t *testing.T) {
+	rootKey := mustSigner(t)
+	root := mustCertificate(t, "Rotation Root", true, rootKey.Public(), nil, rootKey)
+	key := mustSigner(t)
+	intermediate := mustCertificate(t, "Rotation Intermediate", true, key.Public(), root, rootKey)
+
+	otherKey := mustSigner(t)
+	untrusted := mustCertificate(t, "Untrusted Intermediate", true, otherKey.Public(), nil, otherKey)
+	leaf := mustCertificate(t, "Leaf", false, key.Public(), root, rootKey)
+
+	type test struct {
+		crt    *x509.Certificate
+		signer crypto.Signer
+		code   int
+		err    string
+	}
+	tests := map[string]test{
+		"fail/nil":       {nil, key, http.StatusBadRequest, "authority.RotateIntermediate; certificate and signer cannot be nil"},
+		"fail/not-ca":    {leaf, key, http.StatusBadRequest, "authority.RotateIntermediate; certificate is not a CA certificate"},
+		"fail/key":       {intermediate, otherKey, http.StatusBadRequest, "authority.RotateIntermediate: certificate public key does not match the signer"},
+		"fail/untrusted": {untrusted, otherKey, http.StatusBadRequest, "authority.RotateIntermediate; error verifying certificate"},
+		"ok":             {intermediate, key, 0, ""},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			a := testAuthority(t)
+			a.rootX509Certs = append(a.rootX509Certs, root)
+			old, _ := a.getX509Issuer()
+
+			err := a.RotateIntermediate(tc.crt, tc.signer)
+			if tc.err != "" {
+				if assert.NotNil(t, err) {
+					assert.Equals(t, tc.code, err.(errs.StatusCoder).StatusCode())
+					assert.HasPrefix(t, err.Error(), tc.err)
+				}
+				assert.Equals(t, []*x509.Certificate{old}, a.GetIntermediateCertificates())
+				return
+			}
+
+			assert.FatalError(t, err)
+			issuer, signer := a.getX509Issuer()
+			assert.Equals(t, intermediate, issuer)
+			assert.Equals(t, key, signer)
+			assert.Equals(t, []*x509.Certificate{intermediate, old}, a.GetIntermediateCertificates())
+
+			// New certificates are signed by the new intermediate.
+			csr := getCSR(t, mustSigner(t))
+			now := time.Now()
+			chain, err := a.Sign(csr, provisioner.Options{
+				NotBefore: provisioner.NewTimeDuration(now),
+				NotAfter:  provisioner.NewTimeDuration(now.Add(5 * time.Minute)),
+			})
+			assert.FatalError(t, err)
+			assert.Equals(t, intermediate, chain[1])
+			assert.FatalError(t, chain[0].CheckSignatureFrom(intermediate))
+
+			// Rotating to the same intermediate does not change the list.
+			assert.FatalError(t, a.RotateIntermediate(intermediate, key))
+			assert.Equals(t, []*x509.Certificate{intermediate, old}, a.GetIntermediateCertificates())
+		})
+	}
+}
+
+func TestAuthority_RotateIntermediateWithKey(t *testing.T) {
+	intermediate, err := pemutil.ReadCertificate("testdata/certs/intermediate_ca.crt")
+	assert.FatalError(t, err)
+	root, err := pemutil.ReadCertificate("testdata/certs/root_ca.crt")
+	assert.FatalError(t, err)
+
+	type test struct {
+		crt  *x509.Certificate
+		key  string
+		code int
+		err  string
+	}
+	tests := map[string]test{
+		"fail/empty":   {intermediate, "", http.StatusBadRequest, "authority.RotateIntermediateWithKey; key cannot be empty"},
+		"fail/missing": {intermediate, "testdata/secrets/missing_key", http.StatusBadRequest, "authority.RotateIntermediateWithKey; error loading key"},
+		"fail/key":     {root, "testdata/secrets/intermediate_ca_key", http.StatusBadRequest, "authority.RotateIntermediate: certificate public key does not match the signer"},
+		"ok":           {intermediate, "testdata/secrets/intermediate_ca_key", 0, ""},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			a := testAuthority(t)
+			err := a.RotateIntermediateWithKey(tc.crt, tc.key)
+			if tc.err != "" {
+				if assert.NotNil(t, err) {
+					assert.Equals(t, tc.code, err.(errs.StatusCoder).StatusCode())
+					assert.HasPrefix(t, err.Error(), tc.err)
+				}
+				return
+			}
+			assert.FatalError(t, err)
+			issuer, signer := a.getX509Issuer()
+			assert.Equals(t, intermediate, issuer)
+			assert.FatalError(t, equalPublicKeys(intermediate.PublicKey, signer.Public()))
+		})
+	}
+}
+
+func TestAuthority_RetireIntermediate(t *testing.T) {
+	rootKey := mustSigner(t)
+	root := mustCertificate(t, "Rotation Root", true, rootKey.Public(), nil, rootKey)
+	key := mustSigner(t)
+	intermediate := mustCertificate(t, "Rotation Intermediate", true, key.Public(), root, rootKey)
+
+	a := testAuthority(t)
+	a.rootX509Certs = append(a.rootX509Certs, root)
+	old, _ := a.getX509Issuer()
+	assert.FatalError(t, a.RotateIntermediate(intermediate, key))
+
+	err := a.RetireIntermediate(certificateFingerprint(intermediate))
+	if assert.NotNil(t, err) {
+		assert.Equals(t, http.StatusBadRequest, err.(errs.StatusCoder).StatusCode())
+		assert.Equals(t, "authority.RetireIntermediate; the current intermediate cannot be retired", err.Error())
+	}
+
+	err = a.RetireIntermediate("abcd")
+	if assert.NotNil(t, err) {
+		assert.Equals(t, http.StatusNotFound, err.(errs.StatusCoder).StatusCode())
+		assert.Equals(t, "authority.RetireIntermediate; intermediate abcd was not found", err.Error())
+	}
+
+	assert.FatalError(t, a.RetireIntermediate(certificateFingerprint(old)))
+	assert.Equals(t, []*x509.Certificate{intermediate}, a.GetIntermediateCertificates())
+}
+
+func TestIntermediateConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		c   *IntermediateConfig
+		err string
+	}{
+		"ok":           {&IntermediateConfig{Name: "rsa", Crt: "rsa.crt", Key: "rsa.key", KeyTypes: []string{"RSA"}}, ""},
+		"fail/nil":     {nil, "intermediate cannot be empty"},
+		"fail/name":    {&IntermediateConfig{Crt: "rsa.crt", Key: "rsa.key"}, "intermediate name cannot be empty"},
+		"fail/crt":     {&IntermediateConfig{Name: "rsa", Key: "rsa.key"}, "intermediate rsa: crt cannot be empty"},
+		"fail/key":     {&IntermediateConfig{Name: "rsa", Crt: "rsa.crt"}, "intermediate rsa: key cannot be empty"},
+		"fail/keyType": {&IntermediateConfig{Name: "rsa", Crt: "rsa.crt", Key: "rsa.key", KeyTypes: []string{"DSA"}}, "intermediate rsa: key type DSA is not valid"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.c.Validate()
+			if tc.err != "" {
+				if assert.NotNil(t, err) {
+					assert.Equals(t, tc.err, err.Error())
+				}
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+type testIssuerSelector string
+
+func (s testIssuerSelector) Issuer() string {
+	return string(s)
+}
+
+func TestAuthority_Sign_selectIssuer(t *testing.T) {
+	rootKey := mustSigner(t)
+	root := mustCertificate(t, "Selection Root", true, rootKey.Public(), nil, rootKey)
+	ecKey := mustSigner(t)
+	ecIntermediate := mustCertificate(t, "EC Intermediate", true, ecKey.Public(), root, rootKey)
+	buKey := mustSigner(t)
+	buIntermediate := mustCertificate(t, "BU Intermediate", true, buKey.Public(), root, rootKey)
+
+	a := testAuthority(t)
+	a.x509Issuers = []*x509Issuer{
+		{name: "ec", keyTypes: []string{"EC"}, crt: ecIntermediate, signer: ecKey},
+		{name: "bu", crt: buIntermediate, signer: buKey, config: &IntermediateConfig{
+			Name:                  "bu",
+			CRLDistributionPoints: []string{"http://ca/intermediates/bu/crl"},
+		}},
+	}
+	defaultIssuer, _ := a.getX509Issuer()
+
+	now := time.Now()
+	signOpts := provisioner.Options{
+		NotBefore: provisioner.NewTimeDuration(now),
+		NotAfter:  provisioner.NewTimeDuration(now.Add(5 * time.Minute)),
+	}
+	_, rsaKey, err := keys.GenerateKeyPair("RSA", "", 2048)
+	assert.FatalError(t, err)
+
+	tests := map[string]struct {
+		key       interface{}
+		extraOpts []provisioner.SignOption
+		want      *x509.Certificate
+		err       string
+	}{
+		"ok/default":          {rsaKey, nil, defaultIssuer, ""},
+		"ok/key-type":         {mustSigner(t), nil, ecIntermediate, ""},
+		"ok/name":             {mustSigner(t), []provisioner.SignOption{testIssuerSelector("bu")}, buIntermediate, ""},
+		"ok/empty-name":       {mustSigner(t), []provisioner.SignOption{testIssuerSelector("")}, ecIntermediate, ""},
+		"ok/last-name-wins":   {rsaKey, []provisioner.SignOption{testIssuerSelector("ec"), testIssuerSelector("bu")}, buIntermediate, ""},
+		"fail/unknown-issuer": {rsaKey, []provisioner.SignOption{testIssuerSelector("foo")}, nil, "authority.Sign: intermediate foo was not found"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			chain, err := a.Sign(getCSR(t, tc.key), signOpts, tc.extraOpts...)
+			if tc.err != "" {
+				if assert.NotNil(t, err) {
+					assert.Equals(t, http.StatusInternalServerError, err.(errs.StatusCoder).StatusCode())
+					assert.HasPrefix(t, err.Error(), tc.err)
+				}
+				return
+			}
+			assert.FatalError(t, err)
+			assert.Equals(t, tc.want, chain[1])
+			assert.Equals(t, tc.want.Subject, chain[0].Issuer)
+			assert.FatalError(t, chain[0].CheckSignatureFrom(tc.want))
+			if tc.want == buIntermediate {
+				assert.Equals(t, []string{"http://ca/intermediates/bu/crl"}, chain[0].CRLDistributionPoints)
+			} else {
+				assert.Len(t, 0, chain[0].CRLDistributionPoints)
+			}
+
+			// Renewals are signed by the same intermediate.
+			renewed, err := a.Renew(chain[0])
+			assert.FatalError(t, err)
+			assert.Equals(t, tc.want, renewed[1])
+			assert.FatalError(t, renewed[0].CheckSignatureFrom(tc.want))
+		})
+	}
+
+	assert.Equals(t, []*x509.Certificate{defaultIssuer, ecIntermediate, buIntermediate}, a.GetIntermediateCertificates())
+}
@@ -0,0 +1,51 @@
+package authority
+
+import "github.com/pkg/errors"
+
+const (
+	// DefaultMaxBodySize is the default maximum size in bytes of the body of
+	// the requests.
+	DefaultMaxBodySize = 1 << 20
+	// DefaultMaxJSONDepth is the default maximum nesting depth of the JSON
+	// documents in the requests, including the payload of the ACME JWS.
+	DefaultMaxJSONDepth = 32
+)
+
+// RequestLimits defines the limits of the requests to the CA, the zero values
+// use the defaults.
+type RequestLimits struct {
+	MaxBodySize  int64 `json:"maxBodySize,omitempty"`
+	MaxJSONDepth int   `json:"maxJSONDepth,omitempty"`
+}
+
+// Validate checks the fields in RequestLimits.
+func (l *RequestLimits) Validate() error {
+	switch {
+	case l == nil:
+		return nil
+	case l.MaxBodySize < 0:
+		return errors.New("requestLimits.maxBodySize cannot be less than 0")
+	case l.MaxJSONDepth < 0:
+		return errors.New("requestLimits.maxJSONDepth cannot be less than 0")
+	default:
+		return nil
+	}
+}
+
+// GetMaxBodySize returns the maximum size in bytes of the body of the
+// requests.
+func (l *RequestLimits) GetMaxBodySize() int64 {
+	if l == nil || l.MaxBodySize == 0 {
+		return DefaultMaxBodySize
+	}
+	return l.MaxBodySize
+}
+
+// GetMaxJSONDepth returns the maximum nesting depth of the JSON documents in
+// the requests.
+func (l *RequestLimits) GetMaxJSONDepth() int {
+	if l == nil || l.MaxJSONDepth == 0 {
+		return DefaultMaxJSONDepth
+	}
+	return l.MaxJSONDepth
+}
@@ -0,0 +1,72 @@
+package authority
+
+import (
+	"crypto/x509"
+	"strings"
+	"time"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/metrics"
+	"golang.org/x/crypto/ssh"
+)
+
+// Methods used to issue a certificate, they are used in the certificate.issued
+// audit events and in the metrics.
+const (
+	issueMethodSign  = "sign"
+	issueMethodRenew = "renew"
+	issueMethodRekey = "rekey"
+)
+
+var (
+	certificatesIssuedTotal = metrics.NewCounter("ca_certificates_issued_total",
+		"Number of certificates issued, by provisioner, certificate type and method.", "provisioner", "type", "method")
+	signDuration = metrics.NewHistogram("ca_sign_duration_seconds",
+		"Time spent signing certificates, by certificate type, method and result.", metrics.DefaultBuckets, "type", "method", "result")
+	tokenFailuresTotal = metrics.NewCounter("ca_token_failures_total",
+		"Number of one-time tokens that failed verification, by method.", "method")
+)
+
+// resultLabel returns the value of the result label for the given error.
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// observeSign records the duration of a sign operation that started at the
+// given time.
+func observeSign(typ, method string, start time.Time, err error) {
+	signDuration.Observe(time.Since(start).Seconds(), typ, method, resultLabel(err))
+}
+
+// countTokenFailure records a one-time token that failed verification in the
+// given method.
+func countTokenFailure(m provisioner.Method) {
+	tokenFailuresTotal.Inc(strings.TrimSuffix(m.String(), "-method"))
+}
+
+// countX509Certificate records the issuance of an X.509 certificate.
+func (a *Authority) countX509Certificate(method string, cert *x509.Certificate) {
+	var name string
+	if p, ok := a.provisioners.LoadByCertificate(cert); ok {
+		name = p.GetName()
+	}
+	certificatesIssuedTotal.Inc(name, "x509", method)
+}
+
+// countSSHCertificate records the issuance of an SSH certificate by the given
+// provisioner, the provisioner name is empty if it's not known.
+func countSSHCertificate(method, name string, cert *ssh.Certificate) {
+	typ := "ssh-user"
+	if cert.CertType == ssh.HostCert {
+		typ = "ssh-host"
+	}
+	certificatesIssuedTotal.Inc(name, typ, method)
+}
+
+// provisionerNameOption is a SignOption added by the authority to the SSH sign
+// options with the name of the provisioner that authorized the request. It
+// is only used in the metrics.
+type provisionerNameOption string
@@ -0,0 +1,51 @@
+package authority
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAuthority_metrics(t *testing.T) {
+	a := testAuthority(t)
+
+	// Token failures are counted by method.
+	failures := tokenFailuresTotal.Value("sign")
+	_, err := a.AuthorizeSign("not-a-token")
+	assert.NotNil(t, err)
+	assert.Equals(t, failures+1, tokenFailuresTotal.Value("sign"))
+
+	failures = tokenFailuresTotal.Value("revoke")
+	ctx := provisioner.NewContextWithMethod(context.Background(), provisioner.RevokeMethod)
+	_, err = a.Authorize(ctx, "not-a-token")
+	assert.NotNil(t, err)
+	assert.Equals(t, failures+1, tokenFailuresTotal.Value("revoke"))
+
+	// Issued certificates are counted by provisioner, type and method, an
+	// X.509 certificate without the provisioner extension uses noop.
+	x509Total := certificatesIssuedTotal.Value("noop", "x509", issueMethodRenew)
+	a.countX509Certificate(issueMethodRenew, &x509.Certificate{})
+	assert.Equals(t, x509Total+1, certificatesIssuedTotal.Value("noop", "x509", issueMethodRenew))
+
+	userTotal := certificatesIssuedTotal.Value("step-cli", "ssh-user", issueMethodSign)
+	hostTotal := certificatesIssuedTotal.Value("step-cli", "ssh-host", issueMethodSign)
+	countSSHCertificate(issueMethodSign, "step-cli", &ssh.Certificate{CertType: ssh.UserCert})
+	countSSHCertificate(issueMethodSign, "step-cli", &ssh.Certificate{CertType: ssh.HostCert})
+	countSSHCertificate(issueMethodSign, "step-cli", &ssh.Certificate{CertType: ssh.HostCert})
+	assert.Equals(t, userTotal+1, certificatesIssuedTotal.Value("step-cli", "ssh-user", issueMethodSign))
+	assert.Equals(t, hostTotal+2, certificatesIssuedTotal.Value("step-cli", "ssh-host", issueMethodSign))
+
+	// Sign latency is observed by type, method and result.
+	success := signDuration.Count("x509", issueMethodRekey, "success")
+	failed := signDuration.Count("x509", issueMethodRekey, "error")
+	observeSign("x509", issueMethodRekey, time.Now(), nil)
+	observeSign("x509", issueMethodRekey, time.Now(), errors.New("force"))
+	assert.Equals(t, success+1, signDuration.Count("x509", issueMethodRekey, "success"))
+	assert.Equals(t, failed+1, signDuration.Count("x509", issueMethodRekey, "error"))
+}
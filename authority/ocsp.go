@@ -0,0 +1,157 @@
+package authority
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
+	"golang.org/x/crypto/ocsp"
+)
+
+const defaultOCSPCacheDuration = time.Hour
+
+// OCSPConfig represents the configuration of the online certificate status
+// protocol (OCSP) responder of the CA.
+//
+// If Enabled is true, the CA answers the OCSP requests for the certificates
+// issued by its intermediates. The responses are signed by the intermediate
+// when they are requested, so a revocation is reflected immediately, and
+// their nextUpdate is set to CacheDuration (1h by default).
+//
+// URLs are added to the issued certificates as the authority information
+// access OCSP servers. They can be set even if the responder is another
+// service.
+type OCSPConfig struct {
+	Enabled       bool                  `json:"enabled"`
+	CacheDuration *provisioner.Duration `json:"cacheDuration,omitempty"`
+	URLs          []string              `json:"urls,omitempty"`
+}
+
+// IsEnabled returns true if the CA must answer OCSP requests.
+func (c *OCSPConfig) IsEnabled() bool {
+	return c != nil && c.Enabled
+}
+
+// Validate checks the fields in OCSPConfig.
+func (c *OCSPConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.CacheDuration != nil && c.CacheDuration.Duration < 0 {
+		return errors.New("ocsp.cacheDuration cannot be less than 0")
+	}
+	return nil
+}
+
+func (c *OCSPConfig) cacheDuration() time.Duration {
+	if c.CacheDuration == nil || c.CacheDuration.Duration == 0 {
+		return defaultOCSPCacheDuration
+	}
+	return c.CacheDuration.Duration
+}
+
+// apply adds the configured OCSP servers to the given certificate. Existing
+// values are kept if they are not configured.
+func (c *OCSPConfig) apply(cert *x509.Certificate) {
+	if c != nil && len(c.URLs) > 0 {
+		cert.OCSPServer = c.URLs
+	}
+}
+
+// GetOCSPResponse returns the DER encoded OCSP response to the given DER
+// encoded OCSP request. The status of the certificate is read from the
+// database, and the response is signed by the intermediate that issued it.
+// Certificates that are not in the database, or that have been issued by
+// another intermediate, have an unknown status.
+func (a *Authority) GetOCSPResponse(req []byte) ([]byte, error) {
+	if !a.config.OCSP.IsEnabled() {
+		return nil, errs.NotFound("authority.GetOCSPResponse; ocsp is not enabled")
+	}
+	r, err := ocsp.ParseRequest(req)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusBadRequest, err, "authority.GetOCSPResponse; error parsing request")
+	}
+	issuer, signer, err := a.getOCSPIssuer(r)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetOCSPResponse")
+	}
+	if issuer == nil {
+		return nil, errs.Unauthorized("authority.GetOCSPResponse; issuer not found",
+			errs.WithKeyVal("serialNumber", r.SerialNumber.String()))
+	}
+
+	now := time.Now().Truncate(time.Minute)
+	tmpl := ocsp.Response{
+		Status:       ocsp.Unknown,
+		SerialNumber: r.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(a.config.OCSP.cacheDuration()),
+	}
+	status, err := a.GetCertificateStatus(r.SerialNumber.String())
+	switch {
+	case err != nil && !isNotFound(err):
+		return nil, err
+	case err != nil:
+	case status.Certificate != nil && !bytes.Equal(status.Certificate.RawIssuer, issuer.RawSubject):
+	case status.Revocation != nil:
+		tmpl.Status = ocsp.Revoked
+		tmpl.RevokedAt = status.Revocation.RevokedAt
+		tmpl.RevocationReason = status.Revocation.ReasonCode
+	default:
+		tmpl.Status = ocsp.Good
+	}
+
+	b, err := ocsp.CreateResponse(issuer, issuer, tmpl, signer)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetOCSPResponse; error creating response")
+	}
+	return b, nil
+}
+
+// isNotFound returns true if the given error has the not found status code.
+func isNotFound(err error) bool {
+	sc, ok := err.(errs.StatusCoder)
+	return ok && sc.StatusCode() == http.StatusNotFound
+}
+
+// getOCSPIssuer returns the intermediate, and its signer, with the name and
+// key hashes in the given request. It returns a nil certificate if the
+// intermediate is not found.
+func (a *Authority) getOCSPIssuer(r *ocsp.Request) (*x509.Certificate, crypto.Signer, error) {
+	if !r.HashAlgorithm.Available() {
+		return nil, nil, nil
+	}
+	issuer, signer := a.getX509Issuer()
+	candidates := []*x509Issuer{{crt: issuer, signer: signer}}
+	candidates = append(candidates, a.x509Issuers...)
+	for _, iss := range candidates {
+		if iss.crt == nil {
+			continue
+		}
+		var spki struct {
+			Algorithm pkix.AlgorithmIdentifier
+			PublicKey asn1.BitString
+		}
+		if _, err := asn1.Unmarshal(iss.crt.RawSubjectPublicKeyInfo, &spki); err != nil {
+			return nil, nil, errors.Wrap(err, "error parsing issuer public key")
+		}
+		h := r.HashAlgorithm.New()
+		h.Write(spki.PublicKey.RightAlign())
+		if !bytes.Equal(h.Sum(nil), r.IssuerKeyHash) {
+			continue
+		}
+		h.Reset()
+		h.Write(iss.crt.RawSubject)
+		if bytes.Equal(h.Sum(nil), r.IssuerNameHash) {
+			return iss.crt, iss.signer, nil
+		}
+	}
+	return nil, nil, nil
+}
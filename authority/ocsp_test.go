@@ -0,0 +1,124 @@
+package authority
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestOCSPConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		c   *OCSPConfig
+		err error
+	}{
+		"ok/nil":     {nil, nil},
+		"ok/empty":   {&OCSPConfig{}, nil},
+		"ok/enabled": {&OCSPConfig{Enabled: true, CacheDuration: &provisioner.Duration{Duration: time.Minute}}, nil},
+		"fail/cacheDuration": {
+			&OCSPConfig{CacheDuration: &provisioner.Duration{Duration: -time.Minute}},
+			errors.New("ocsp.cacheDuration cannot be less than 0"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.c.Validate()
+			if tc.err == nil {
+				assert.Nil(t, err)
+			} else if assert.NotNil(t, err) {
+				assert.Equals(t, tc.err.Error(), err.Error())
+			}
+		})
+	}
+}
+
+func TestOCSPConfig_apply(t *testing.T) {
+	cert := &x509.Certificate{OCSPServer: []string{"http://old/ocsp"}}
+	(*OCSPConfig)(nil).apply(cert)
+	assert.Equals(t, []string{"http://old/ocsp"}, cert.OCSPServer)
+	(&OCSPConfig{URLs: []string{"http://ca/ocsp"}}).apply(cert)
+	assert.Equals(t, []string{"http://ca/ocsp"}, cert.OCSPServer)
+}
+
+func TestAuthority_GetOCSPResponse(t *testing.T) {
+	a := testAuthority(t)
+	issuer := a.x509Issuer
+	revokedAt := time.Now().Add(-time.Hour).Truncate(time.Second).UTC()
+	certs := map[string]*x509.Certificate{
+		"1": {SerialNumber: big.NewInt(1), RawIssuer: issuer.RawSubject},
+		"2": {SerialNumber: big.NewInt(2), RawIssuer: issuer.RawSubject},
+		"4": {SerialNumber: big.NewInt(4), RawIssuer: []byte("other")},
+	}
+	a.db = &db.MockAuthDB{
+		MGetCertificate: func(sn string) (*x509.Certificate, error) {
+			if sn == "5" {
+				return nil, errors.New("force")
+			}
+			if crt, ok := certs[sn]; ok {
+				return crt, nil
+			}
+			return nil, db.ErrNotFound
+		},
+		MGetRevokedCertificate: func(sn string) (*db.RevokedCertificateInfo, error) {
+			if sn == "2" {
+				return &db.RevokedCertificateInfo{Serial: sn, ReasonCode: ocsp.KeyCompromise, RevokedAt: revokedAt}, nil
+			}
+			return nil, db.ErrNotFound
+		},
+	}
+
+	request := func(sn int64, iss *x509.Certificate) []byte {
+		b, err := ocsp.CreateRequest(&x509.Certificate{SerialNumber: big.NewInt(sn)}, iss, &ocsp.RequestOptions{Hash: crypto.SHA256})
+		assert.FatalError(t, err)
+		return b
+	}
+
+	_, err := a.GetOCSPResponse(request(1, issuer))
+	if assert.NotNil(t, err) {
+		assert.Equals(t, http.StatusNotFound, err.(errs.StatusCoder).StatusCode())
+	}
+
+	a.config.OCSP = &OCSPConfig{Enabled: true}
+	tests := map[string]struct {
+		req    []byte
+		status int
+		code   int
+	}{
+		"ok/good":          {request(1, issuer), ocsp.Good, 0},
+		"ok/revoked":       {request(2, issuer), ocsp.Revoked, 0},
+		"ok/unknown":       {request(3, issuer), ocsp.Unknown, 0},
+		"ok/other-issuer":  {request(4, issuer), ocsp.Unknown, 0},
+		"fail/db":          {request(5, issuer), 0, http.StatusInternalServerError},
+		"fail/malformed":   {[]byte("foo"), 0, http.StatusBadRequest},
+		"fail/unknown-iss": {request(1, a.GetRootCertificates()[0]), 0, http.StatusUnauthorized},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			b, err := a.GetOCSPResponse(tc.req)
+			if tc.code != 0 {
+				if assert.NotNil(t, err) {
+					assert.Equals(t, tc.code, err.(errs.StatusCoder).StatusCode())
+				}
+				return
+			}
+			assert.FatalError(t, err)
+			resp, err := ocsp.ParseResponse(b, issuer)
+			assert.FatalError(t, err)
+			assert.Equals(t, tc.status, resp.Status)
+			assert.Equals(t, time.Hour, resp.NextUpdate.Sub(resp.ThisUpdate))
+			if tc.status == ocsp.Revoked {
+				assert.Equals(t, revokedAt, resp.RevokedAt)
+				assert.Equals(t, ocsp.KeyCompromise, resp.RevocationReason)
+			}
+		})
+	}
+}
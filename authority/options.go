@@ -7,6 +7,7 @@ import (
 	"encoding/pem"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/audit"
 	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/db"
 	"github.com/smallstep/certificates/kms"
@@ -44,6 +45,15 @@ func WithDatabase(db db.AuthDB) Option {
 	}
 }
 
+// WithAuditLogger sets the audit logger of the authority. If set, the audit
+// configuration in the authority is ignored.
+func WithAuditLogger(l *audit.Logger) Option {
+	return func(a *Authority) error {
+		a.audit = l
+		return nil
+	}
+}
+
 // WithGetIdentityFunc sets a custom function to retrieve the identity from
 // an external resource.
 func WithGetIdentityFunc(fn func(ctx context.Context, p provisioner.Interface, email string) (*provisioner.Identity, error)) Option {
@@ -151,6 +161,16 @@ func WithX509FederatedCerts(certs ...*x509.Certificate) Option {
 	}
 }
 
+// WithX509UpcomingRootCerts is an option that allows to define the list of
+// roots that will replace the current ones. This option will replace any
+// upcoming root defined before.
+func WithX509UpcomingRootCerts(certs ...*x509.Certificate) Option {
+	return func(a *Authority) error {
+		a.upcomingX509Certs = certs
+		return nil
+	}
+}
+
 // WithX509RootBundle is an option that allows to define the list of root
 // certificates. This option will replace any root certificate defined before.
 func WithX509RootBundle(pemCerts []byte) Option {
@@ -0,0 +1,207 @@
+package authority
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// EnvPrefix is the prefix of the environment variables that override the
+// values in the configuration file. The rest of the name is the path of the
+// field in upper case, with the keys separated by underscores, e.g.
+// STEPCA_DB_DATASOURCE overrides db.dataSource.
+const EnvPrefix = "STEPCA_"
+
+// envAliases are shorter names for some of the environment variables.
+var envAliases = map[string]string{
+	"DNS": "dnsNames",
+}
+
+// override is the new value of the field in the given path.
+type override struct {
+	source string
+	path   []string
+	value  string
+}
+
+// envOverrides returns the overrides in the given environment, a list of
+// strings with the form key=value.
+func envOverrides(environ []string) []override {
+	var list []override
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, EnvPrefix) {
+			continue
+		}
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name := strings.TrimPrefix(parts[0], EnvPrefix)
+		path := strings.Split(name, "_")
+		if alias, ok := envAliases[name]; ok {
+			path = strings.Split(alias, ".")
+		}
+		list = append(list, override{
+			source: parts[0],
+			path:   path,
+			value:  parts[1],
+		})
+	}
+	return list
+}
+
+// flagOverrides parses the given overrides with the form path=value, where path
+// is the dot separated path of the field, e.g. db.dataSource=/data/db.
+func flagOverrides(values []string) ([]override, error) {
+	list := make([]override, len(values))
+	for i, s := range values {
+		parts := strings.SplitN(s, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.Errorf("override '%s' is not valid: it must have the format path=value", s)
+		}
+		list[i] = override{
+			source: s,
+			path:   strings.Split(parts[0], "."),
+			value:  parts[1],
+		}
+	}
+	return list, nil
+}
+
+// applyOverrides sets the values of the overrides in the given JSON
+// configuration and returns the new one.
+func applyOverrides(data []byte, overrides []override) ([]byte, error) {
+	if len(overrides) == 0 {
+		return data, nil
+	}
+
+	var m map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	}
+	if m == nil {
+		m = make(map[string]interface{})
+	}
+
+	for _, o := range overrides {
+		keys, typ, err := lookupField(reflect.TypeOf(Config{}), o.path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error overriding configuration with %s", o.source)
+		}
+		setValue(m, keys, overrideValue(typ, o.value))
+	}
+
+	return json.Marshal(m)
+}
+
+// lookupField returns the JSON keys of the field in the given path, and the
+// type of the field. The path is matched with the keys without considering the
+// case.
+func lookupField(typ reflect.Type, path []string) ([]string, reflect.Type, error) {
+	keys := make([]string, len(path))
+	for i, name := range path {
+		for typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		if typ.Kind() != reflect.Struct {
+			return nil, nil, errors.Errorf("%s does not have a field %s", strings.Join(keys[:i], "."), name)
+		}
+		field, key, ok := fieldByJSONKey(typ, name)
+		if !ok {
+			if i == 0 {
+				return nil, nil, errors.Errorf("unknown field %s", name)
+			}
+			return nil, nil, errors.Errorf("%s does not have a field %s", strings.Join(keys[:i], "."), name)
+		}
+		keys[i], typ = key, field.Type
+	}
+	return keys, typ, nil
+}
+
+// fieldByJSONKey returns the field with the given JSON key. The fields of
+// embedded structs without a JSON key are promoted like encoding/json does.
+func fieldByJSONKey(typ reflect.Type, name string) (reflect.StructField, string, bool) {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && field.Tag.Get("json") == "" {
+			if f, key, ok := fieldByJSONKey(field.Type, name); ok {
+				return f, key, true
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		key := strings.Split(field.Tag.Get("json"), ",")[0]
+		switch key {
+		case "-":
+			continue
+		case "":
+			key = field.Name
+		}
+		if strings.EqualFold(key, name) {
+			return field, key, true
+		}
+	}
+	return reflect.StructField{}, "", false
+}
+
+// overrideValue returns the JSON value of the given string for a field of the
+// given type. String fields use the string as it is, and lists of strings also
+// accept comma separated values. Other fields accept a JSON value, anything
+// else is used as a string, e.g. durations like 5m.
+func overrideValue(typ reflect.Type, s string) interface{} {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch {
+	case typ.Kind() == reflect.String:
+		return s
+	case typ.Kind() == reflect.Slice && typ.Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(s), "["):
+		var list []string
+		for _, v := range strings.Split(s, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				list = append(list, v)
+			}
+		}
+		return list
+	}
+
+	var v interface{}
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return s
+	}
+	return v
+}
+
+// setValue sets the value in the given keys, creating the intermediate objects
+// if they do not exist.
+func setValue(m map[string]interface{}, keys []string, value interface{}) {
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := m[key].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			m[key] = child
+		}
+		m = child
+	}
+	m[keys[len(keys)-1]] = value
+}
+
+// loadOverrides returns the overrides in the environment and the given ones,
+// the latter take precedence.
+func loadOverrides(values []string) ([]override, error) {
+	list, err := flagOverrides(values)
+	if err != nil {
+		return nil, err
+	}
+	return append(envOverrides(os.Environ()), list...), nil
+}
@@ -0,0 +1,114 @@
+package authority
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/db"
+)
+
+func TestLoadConfiguration_overrides(t *testing.T) {
+	os.Setenv("STEPCA_DB_DATASOURCE", "/data/db")
+	os.Setenv("STEPCA_DB_TYPE", "badger")
+	os.Setenv("STEPCA_DNS", "ca.example.com, 10.0.0.1")
+	os.Setenv("STEPCA_ADDRESS", ":8443")
+	defer func() {
+		os.Unsetenv("STEPCA_DB_DATASOURCE")
+		os.Unsetenv("STEPCA_DB_TYPE")
+		os.Unsetenv("STEPCA_DNS")
+		os.Unsetenv("STEPCA_ADDRESS")
+	}()
+
+	c, err := LoadConfiguration("../ca/testdata/ca.json",
+		"address=:443", "authority.backdate=1m", "tls.renegotiation=true")
+	assert.FatalError(t, err)
+	assert.Equals(t, &db.Config{Type: "badger", DataSource: "/data/db"}, c.DB)
+	assert.Equals(t, []string{"ca.example.com", "10.0.0.1"}, c.DNSNames)
+	assert.Equals(t, ":443", c.Address)
+	assert.Equals(t, time.Minute, c.AuthorityConfig.Backdate.Duration)
+	assert.True(t, c.TLS.Renegotiation)
+	assert.Equals(t, "password", c.Password)
+	assert.Equals(t, 5, len(c.AuthorityConfig.Provisioners))
+
+	_, err = LoadConfiguration("../ca/testdata/ca.json", "address")
+	assert.Error(t, err)
+	_, err = LoadConfiguration("../ca/testdata/ca.json", "foo.bar=baz")
+	assert.Error(t, err)
+}
+
+func Test_applyOverrides(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		overrides []override
+		want      string
+		wantErr   bool
+	}{
+		{"ok/empty", `{"address":":443"}`, nil, `{"address":":443"}`, false},
+		{"ok/string", `{"address":":443"}`, []override{
+			{path: []string{"ADDRESS"}, value: "127.0.0.1:8443"},
+		}, `{"address":"127.0.0.1:8443"}`, false},
+		{"ok/string-number", `{}`, []override{
+			{path: []string{"password"}, value: "1234"},
+		}, `{"password":"1234"}`, false},
+		{"ok/new-object", `{"address":":443"}`, []override{
+			{path: []string{"DB", "DATASOURCE"}, value: "/data/db"},
+		}, `{"address":":443","db":{"dataSource":"/data/db"}}`, false},
+		{"ok/list", `{"dnsNames":["localhost"]}`, []override{
+			{path: []string{"dnsNames"}, value: "ca.example.com,10.0.0.1"},
+		}, `{"dnsNames":["ca.example.com","10.0.0.1"]}`, false},
+		{"ok/json-list", `{}`, []override{
+			{path: []string{"dnsNames"}, value: `["ca.example.com"]`},
+		}, `{"dnsNames":["ca.example.com"]}`, false},
+		{"ok/json", `{"tls":{"minVersion":1.2}}`, []override{
+			{path: []string{"tls", "maxVersion"}, value: "1.3"},
+			{path: []string{"logger"}, value: `{"format":"json"}`},
+		}, `{"logger":{"format":"json"},"tls":{"maxVersion":1.3,"minVersion":1.2}}`, false},
+		{"ok/duration", `{}`, []override{
+			{path: []string{"authority", "backdate"}, value: "1m"},
+		}, `{"authority":{"backdate":"1m"}}`, false},
+		{"ok/precedence", `{"address":":443"}`, []override{
+			{path: []string{"address"}, value: ":8443"},
+			{path: []string{"address"}, value: ":9443"},
+		}, `{"address":":9443"}`, false},
+		{"fail/json", `{`, []override{
+			{path: []string{"address"}, value: ":443"},
+		}, "", true},
+		{"fail/unknown", `{}`, []override{
+			{path: []string{"foo"}, value: "bar"},
+		}, "", true},
+		{"fail/unknown-child", `{}`, []override{
+			{path: []string{"db", "foo"}, value: "bar"},
+		}, "", true},
+		{"fail/not-object", `{}`, []override{
+			{path: []string{"address", "foo"}, value: "bar"},
+		}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyOverrides([]byte(tt.data), tt.overrides)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyOverrides() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				assert.Equals(t, tt.want, string(got))
+			}
+		})
+	}
+}
+
+func Test_envOverrides(t *testing.T) {
+	got := envOverrides([]string{
+		"HOME=/root",
+		"STEPCA_DB_DATASOURCE=/data/db",
+		"STEPCA_DNS=ca.example.com",
+		"STEPCA_PASSWORD=pass=word",
+	})
+	assert.Equals(t, []override{
+		{source: "STEPCA_DB_DATASOURCE", path: []string{"DB", "DATASOURCE"}, value: "/data/db"},
+		{source: "STEPCA_DNS", path: []string{"dnsNames"}, value: "ca.example.com"},
+		{source: "STEPCA_PASSWORD", path: []string{"PASSWORD"}, value: "pass=word"},
+	}, got)
+}
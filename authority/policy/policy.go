@@ -0,0 +1,481 @@
+// Package policy implements the name policies that restrict the names that
+// can be included in the X.509 and SSH certificates signed by the CA.
+package policy
+
+import (
+	"crypto/x509"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// Options contains the name policies of X.509 and SSH certificates.
+//
+// A name is rejected if it matches a deny rule. If any allow rule is
+// configured, a name is also rejected if it does not match an allow rule of
+// its type, so configuring only allowed dns domains rejects all the ip
+// addresses, emails and uris.
+type Options struct {
+	X509 *X509Options `json:"x509,omitempty"`
+	SSH  *SSHOptions  `json:"ssh,omitempty"`
+}
+
+// X509Options contains the allow and deny rules of the subject alternative
+// names of X.509 certificates. The common name is also checked if it's a dns
+// name, an ip address or an email.
+type X509Options struct {
+	Allow *X509NameOptions `json:"allow,omitempty"`
+	Deny  *X509NameOptions `json:"deny,omitempty"`
+}
+
+// X509NameOptions contains the rules for each type of name in an X.509
+// certificate.
+//
+// DNSDomains contains domains, like example.com, or wildcard domains, like
+// *.example.com, that match any subdomain. IPRanges contains ip addresses or
+// CIDR ranges. EmailAddresses contains full addresses or domains, like
+// example.com or @example.com, that match any mailbox in the domain.
+// URIDomains contains domains matched against the host of a uri, uris with an
+// ip address as host are matched against IPRanges.
+type X509NameOptions struct {
+	DNSDomains     []string `json:"dns,omitempty"`
+	IPRanges       []string `json:"ip,omitempty"`
+	EmailAddresses []string `json:"email,omitempty"`
+	URIDomains     []string `json:"uri,omitempty"`
+}
+
+// SSHOptions contains the name policies of SSH user and host certificates.
+type SSHOptions struct {
+	User *SSHUserOptions `json:"user,omitempty"`
+	Host *SSHHostOptions `json:"host,omitempty"`
+}
+
+// SSHUserOptions contains the allow and deny rules of the principals of SSH
+// user certificates.
+type SSHUserOptions struct {
+	Allow *SSHUserNameOptions `json:"allow,omitempty"`
+	Deny  *SSHUserNameOptions `json:"deny,omitempty"`
+}
+
+// SSHUserNameOptions contains the rules of the principals of SSH user
+// certificates. Principals contains exact principal names, or "*" to match
+// all of them, and EmailAddresses are matched against principals that are
+// emails, with the same format as in X509NameOptions.
+type SSHUserNameOptions struct {
+	EmailAddresses []string `json:"email,omitempty"`
+	Principals     []string `json:"principal,omitempty"`
+}
+
+// SSHHostOptions contains the allow and deny rules of the principals of SSH
+// host certificates.
+type SSHHostOptions struct {
+	Allow *SSHHostNameOptions `json:"allow,omitempty"`
+	Deny  *SSHHostNameOptions `json:"deny,omitempty"`
+}
+
+// SSHHostNameOptions contains the rules of the principals of SSH host
+// certificates. DNSDomains and IPRanges have the same format as in
+// X509NameOptions, and Principals contains exact principal names, or "*" to
+// match all of them.
+type SSHHostNameOptions struct {
+	DNSDomains []string `json:"dns,omitempty"`
+	IPRanges   []string `json:"ip,omitempty"`
+	Principals []string `json:"principal,omitempty"`
+}
+
+// Engine evaluates the name policies of the certificates. A nil engine allows
+// all the names.
+type Engine struct {
+	x509    *namePolicy
+	sshUser *namePolicy
+	sshHost *namePolicy
+}
+
+// New creates the engine that evaluates the given policies. It returns a nil
+// engine if there are no policies.
+func New(o *Options) (*Engine, error) {
+	if o == nil {
+		return nil, nil
+	}
+
+	var err error
+	e := new(Engine)
+	if x := o.X509; x != nil {
+		if e.x509, err = newNamePolicy(x.Allow.rules(), x.Deny.rules()); err != nil {
+			return nil, errors.Wrap(err, "x509")
+		}
+	}
+	if s := o.SSH; s != nil {
+		if u := s.User; u != nil {
+			if e.sshUser, err = newNamePolicy(u.Allow.rules(), u.Deny.rules()); err != nil {
+				return nil, errors.Wrap(err, "ssh user")
+			}
+		}
+		if h := s.Host; h != nil {
+			if e.sshHost, err = newNamePolicy(h.Allow.rules(), h.Deny.rules()); err != nil {
+				return nil, errors.Wrap(err, "ssh host")
+			}
+		}
+	}
+	if e.x509 == nil && e.sshUser == nil && e.sshHost == nil {
+		return nil, nil
+	}
+	return e, nil
+}
+
+// IsX509CertificateAllowed returns an error if a name in the given certificate
+// is not allowed by the policy.
+func (e *Engine) IsX509CertificateAllowed(cert *x509.Certificate) error {
+	if e == nil || e.x509 == nil {
+		return nil
+	}
+	p := e.x509
+	for _, name := range cert.DNSNames {
+		if err := p.check("dns name", name, (*nameRules).matchDNS); err != nil {
+			return err
+		}
+	}
+	for _, ip := range cert.IPAddresses {
+		if err := p.check("ip address", ip.String(), (*nameRules).matchIP); err != nil {
+			return err
+		}
+	}
+	for _, email := range cert.EmailAddresses {
+		if err := p.check("email address", email, (*nameRules).matchEmail); err != nil {
+			return err
+		}
+	}
+	for _, u := range cert.URIs {
+		if err := p.check("uri", u.String(), (*nameRules).matchURI); err != nil {
+			return err
+		}
+	}
+
+	cn := cert.Subject.CommonName
+	switch {
+	case cn == "":
+		return nil
+	case net.ParseIP(cn) != nil:
+		return p.check("common name", cn, (*nameRules).matchIP)
+	case strings.Contains(cn, "@"):
+		return p.check("common name", cn, (*nameRules).matchEmail)
+	case isDNSName(cn):
+		return p.check("common name", cn, (*nameRules).matchDNS)
+	default:
+		return nil
+	}
+}
+
+// IsSSHCertificateAllowed returns an error if a principal in the given
+// certificate is not allowed by the policy.
+func (e *Engine) IsSSHCertificateAllowed(cert *ssh.Certificate) error {
+	if e == nil {
+		return nil
+	}
+	var (
+		p     *namePolicy
+		match func(*nameRules, string, bool) bool
+	)
+	switch cert.CertType {
+	case ssh.UserCert:
+		p, match = e.sshUser, (*nameRules).matchUserPrincipal
+	case ssh.HostCert:
+		p, match = e.sshHost, (*nameRules).matchHostPrincipal
+	default:
+		return errors.Errorf("unexpected ssh certificate type %d", cert.CertType)
+	}
+	if p == nil {
+		return nil
+	}
+	for _, principal := range cert.ValidPrincipals {
+		if err := p.check("principal", principal, match); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// namePolicy is a set of allow and deny rules. A nil allow means that all the
+// names that are not denied are allowed.
+type namePolicy struct {
+	allow *nameRules
+	deny  *nameRules
+}
+
+func newNamePolicy(allow, deny *nameRules) (*namePolicy, error) {
+	if err := allow.init(); err != nil {
+		return nil, errors.Wrap(err, "allow")
+	}
+	if err := deny.init(); err != nil {
+		return nil, errors.Wrap(err, "deny")
+	}
+	if allow.isEmpty() {
+		allow = nil
+	}
+	if deny.isEmpty() {
+		deny = nil
+	}
+	if allow == nil && deny == nil {
+		return nil, nil
+	}
+	return &namePolicy{allow: allow, deny: deny}, nil
+}
+
+// check returns an error if the name is matched by a deny rule, or if it's not
+// matched by an allow rule. Deny rules also match wildcard names that overlap
+// with them.
+func (p *namePolicy) check(typ, name string, match func(*nameRules, string, bool) bool) error {
+	if p.deny != nil && match(p.deny, name, true) {
+		return errors.Errorf("%s %s is denied by the policy", typ, name)
+	}
+	if p.allow != nil && !match(p.allow, name, false) {
+		return errors.Errorf("%s %s is not allowed by the policy", typ, name)
+	}
+	return nil
+}
+
+// nameRules are the rules of an allow or deny list.
+type nameRules struct {
+	dnsDomains []string
+	ipRanges   []string
+	emails     []string
+	uriDomains []string
+	principals []string
+	ipNets     []*net.IPNet
+}
+
+func (o *X509NameOptions) rules() *nameRules {
+	if o == nil {
+		return nil
+	}
+	return &nameRules{
+		dnsDomains: o.DNSDomains,
+		ipRanges:   o.IPRanges,
+		emails:     o.EmailAddresses,
+		uriDomains: o.URIDomains,
+	}
+}
+
+func (o *SSHUserNameOptions) rules() *nameRules {
+	if o == nil {
+		return nil
+	}
+	return &nameRules{
+		emails:     o.EmailAddresses,
+		principals: o.Principals,
+	}
+}
+
+func (o *SSHHostNameOptions) rules() *nameRules {
+	if o == nil {
+		return nil
+	}
+	return &nameRules{
+		dnsDomains: o.DNSDomains,
+		ipRanges:   o.IPRanges,
+		principals: o.Principals,
+	}
+}
+
+// init validates the rules and parses the ip ranges.
+func (r *nameRules) init() error {
+	if r == nil {
+		return nil
+	}
+	for _, d := range r.dnsDomains {
+		if err := validateDomain(d); err != nil {
+			return errors.Wrap(err, "dns")
+		}
+	}
+	for _, s := range r.ipRanges {
+		ipNet, err := parseIPRange(s)
+		if err != nil {
+			return errors.Wrap(err, "ip")
+		}
+		r.ipNets = append(r.ipNets, ipNet)
+	}
+	for _, e := range r.emails {
+		i := strings.LastIndex(e, "@")
+		if err := validateDomain(e[i+1:]); err != nil {
+			return errors.Wrap(err, "email")
+		}
+	}
+	for _, d := range r.uriDomains {
+		if err := validateDomain(d); err != nil {
+			return errors.Wrap(err, "uri")
+		}
+	}
+	for _, p := range r.principals {
+		if p == "" {
+			return errors.New("principal: principal cannot be empty")
+		}
+	}
+	return nil
+}
+
+func (r *nameRules) isEmpty() bool {
+	return r == nil || len(r.dnsDomains) == 0 && len(r.ipNets) == 0 && len(r.emails) == 0 &&
+		len(r.uriDomains) == 0 && len(r.principals) == 0
+}
+
+func (r *nameRules) matchDNS(name string, overlap bool) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	return matchDomain(r.dnsDomains, name, overlap)
+}
+
+func (r *nameRules) matchIP(name string, overlap bool) bool {
+	ip := net.ParseIP(name)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range r.ipNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *nameRules) matchEmail(name string, overlap bool) bool {
+	i := strings.LastIndex(name, "@")
+	if i <= 0 {
+		return false
+	}
+	name = strings.ToLower(name)
+	domain := name[i+1:]
+	for _, e := range r.emails {
+		switch j := strings.LastIndex(e, "@"); {
+		case j > 0:
+			if name == strings.ToLower(e) {
+				return true
+			}
+		case domainPatternCovers(e[j+1:], domain):
+			return true
+		}
+	}
+	return false
+}
+
+func (r *nameRules) matchURI(name string, overlap bool) bool {
+	u, err := url.Parse(name)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if net.ParseIP(host) != nil {
+		return r.matchIP(host, overlap)
+	}
+	return matchDomain(r.uriDomains, host, overlap)
+}
+
+func (r *nameRules) matchPrincipal(name string) bool {
+	for _, p := range r.principals {
+		if p == "*" || p == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *nameRules) matchUserPrincipal(name string, overlap bool) bool {
+	if r.matchPrincipal(name) {
+		return true
+	}
+	return strings.Contains(name, "@") && r.matchEmail(name, overlap)
+}
+
+func (r *nameRules) matchHostPrincipal(name string, overlap bool) bool {
+	if r.matchPrincipal(name) {
+		return true
+	}
+	if net.ParseIP(name) != nil {
+		return r.matchIP(name, overlap)
+	}
+	return r.matchDNS(name, overlap)
+}
+
+// matchDomain returns true if the name is covered by one of the domain
+// patterns, or if overlap is true, if it shares a name with one of them.
+func matchDomain(domains []string, name string, overlap bool) bool {
+	for _, d := range domains {
+		if domainPatternCovers(d, name) || (overlap && domainPatternCovers(name, d)) {
+			return true
+		}
+	}
+	return false
+}
+
+// domainPatternCovers returns true if all the names matched by name are also
+// matched by pattern. Both can be wildcard names.
+func domainPatternCovers(pattern, name string) bool {
+	if !strings.HasPrefix(pattern, "*.") {
+		return pattern == name
+	}
+	domain := pattern[2:]
+	if strings.HasPrefix(name, "*.") {
+		name = name[2:]
+		return name == domain || strings.HasSuffix(name, "."+domain)
+	}
+	return strings.HasSuffix(name, "."+domain)
+}
+
+// validateDomain validates a domain rule.
+func validateDomain(pattern string) error {
+	name := strings.TrimPrefix(pattern, "*.")
+	switch {
+	case name == "":
+		return errors.New("domain cannot be empty")
+	case name != strings.ToLower(name):
+		return errors.Errorf("domain %s must be lowercase", pattern)
+	case strings.Contains(name, "*"):
+		return errors.Errorf("domain %s can only contain a wildcard in the leftmost label", pattern)
+	case !isDNSName(name):
+		return errors.Errorf("domain %s is not valid", pattern)
+	default:
+		return nil
+	}
+}
+
+// parseIPRange parses an ip address or a CIDR range.
+func parseIPRange(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, errors.Errorf("ip range %s is not valid", s)
+		}
+		return ipNet, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, errors.Errorf("ip address %s is not valid", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// isDNSName returns true if s is a dns name, optionally with a wildcard in
+// the leftmost label.
+func isDNSName(s string) bool {
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "*."), ".")
+	if s == "" {
+		return false
+	}
+	for _, label := range strings.Split(s, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			switch {
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,212 @@
+package policy
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func mustURL(t *testing.T, s string) *url.URL {
+	u, err := url.Parse(s)
+	assert.FatalError(t, err)
+	return u
+}
+
+func TestNew(t *testing.T) {
+	tests := map[string]struct {
+		opts    *Options
+		wantNil bool
+		err     string
+	}{
+		"ok/nil":   {nil, true, ""},
+		"ok/empty": {&Options{X509: &X509Options{Allow: &X509NameOptions{}}, SSH: &SSHOptions{}}, true, ""},
+		"ok": {&Options{
+			X509: &X509Options{
+				Allow: &X509NameOptions{
+					DNSDomains:     []string{"example.com", "*.example.com"},
+					IPRanges:       []string{"10.0.0.0/8", "::1"},
+					EmailAddresses: []string{"@example.com", "example.org", "jane@example.net"},
+					URIDomains:     []string{"*.example.com"},
+				},
+			},
+			SSH: &SSHOptions{
+				User: &SSHUserOptions{Deny: &SSHUserNameOptions{Principals: []string{"root"}}},
+				Host: &SSHHostOptions{Allow: &SSHHostNameOptions{DNSDomains: []string{"*.internal"}}},
+			},
+		}, false, ""},
+		"fail/dns": {&Options{X509: &X509Options{Allow: &X509NameOptions{DNSDomains: []string{"foo.*.com"}}}}, true,
+			"x509: allow: dns: domain foo.*.com can only contain a wildcard in the leftmost label"},
+		"fail/dns-uppercase": {&Options{X509: &X509Options{Deny: &X509NameOptions{DNSDomains: []string{"Example.com"}}}}, true,
+			"x509: deny: dns: domain Example.com must be lowercase"},
+		"fail/ip": {&Options{X509: &X509Options{Allow: &X509NameOptions{IPRanges: []string{"10.0.0.0/33"}}}}, true,
+			"x509: allow: ip: ip range 10.0.0.0/33 is not valid"},
+		"fail/ip-address": {&Options{SSH: &SSHOptions{Host: &SSHHostOptions{Deny: &SSHHostNameOptions{IPRanges: []string{"foo"}}}}}, true,
+			"ssh host: deny: ip: ip address foo is not valid"},
+		"fail/email": {&Options{SSH: &SSHOptions{User: &SSHUserOptions{Allow: &SSHUserNameOptions{EmailAddresses: []string{"jane@"}}}}}, true,
+			"ssh user: allow: email: domain cannot be empty"},
+		"fail/uri": {&Options{X509: &X509Options{Allow: &X509NameOptions{URIDomains: []string{"foo bar"}}}}, true,
+			"x509: allow: uri: domain foo bar is not valid"},
+		"fail/principal": {&Options{SSH: &SSHOptions{User: &SSHUserOptions{Allow: &SSHUserNameOptions{Principals: []string{""}}}}}, true,
+			"ssh user: allow: principal: principal cannot be empty"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := New(tc.opts)
+			if tc.err != "" {
+				if assert.NotNil(t, err) {
+					assert.Equals(t, tc.err, err.Error())
+				}
+			} else {
+				assert.FatalError(t, err)
+			}
+			assert.Equals(t, tc.wantNil, e == nil)
+		})
+	}
+}
+
+func TestEngine_IsX509CertificateAllowed(t *testing.T) {
+	e, err := New(&Options{
+		X509: &X509Options{
+			Allow: &X509NameOptions{
+				DNSDomains:     []string{"example.com", "*.example.com"},
+				IPRanges:       []string{"10.0.0.0/8", "2001:db8::/32"},
+				EmailAddresses: []string{"@example.com", "jane@example.org"},
+				URIDomains:     []string{"*.example.com"},
+			},
+			Deny: &X509NameOptions{
+				DNSDomains:     []string{"*.internal.example.com"},
+				IPRanges:       []string{"10.0.0.1"},
+				EmailAddresses: []string{"root@example.com"},
+			},
+		},
+	})
+	assert.FatalError(t, err)
+
+	tests := map[string]struct {
+		cert *x509.Certificate
+		err  string
+	}{
+		"ok/empty":    {&x509.Certificate{}, ""},
+		"ok/dns":      {&x509.Certificate{DNSNames: []string{"example.com", "www.example.com", "a.b.example.com", "WWW.Example.com."}}, ""},
+		"ok/wildcard": {&x509.Certificate{DNSNames: []string{"*.foo.example.com"}}, ""},
+		"ok/ip":       {&x509.Certificate{IPAddresses: []net.IP{net.ParseIP("10.1.2.3"), net.ParseIP("2001:db8::1")}}, ""},
+		"ok/email":    {&x509.Certificate{EmailAddresses: []string{"john@example.com", "Jane@Example.org"}}, ""},
+		"ok/uri":      {&x509.Certificate{URIs: []*url.URL{mustURL(t, "spiffe://foo.example.com/bar"), mustURL(t, "https://10.0.0.2/")}}, ""},
+		"ok/cn":       {&x509.Certificate{Subject: pkix.Name{CommonName: "www.example.com"}}, ""},
+		"ok/cn-name":  {&x509.Certificate{Subject: pkix.Name{CommonName: "John Doe"}}, ""},
+		"fail/dns": {&x509.Certificate{DNSNames: []string{"example.com", "example.net"}},
+			"dns name example.net is not allowed by the policy"},
+		"fail/dns-parent": {&x509.Certificate{DNSNames: []string{"foo.com.example.net"}},
+			"dns name foo.com.example.net is not allowed by the policy"},
+		"fail/dns-denied": {&x509.Certificate{DNSNames: []string{"db.internal.example.com"}},
+			"dns name db.internal.example.com is denied by the policy"},
+		"fail/wildcard-denied": {&x509.Certificate{DNSNames: []string{"*.example.com"}, Subject: pkix.Name{CommonName: "*.example.com"}},
+			"dns name *.example.com is denied by the policy"},
+		"fail/ip": {&x509.Certificate{IPAddresses: []net.IP{net.ParseIP("192.168.1.1")}},
+			"ip address 192.168.1.1 is not allowed by the policy"},
+		"fail/ip-denied": {&x509.Certificate{IPAddresses: []net.IP{net.ParseIP("10.0.0.1")}},
+			"ip address 10.0.0.1 is denied by the policy"},
+		"fail/email": {&x509.Certificate{EmailAddresses: []string{"john@example.org"}},
+			"email address john@example.org is not allowed by the policy"},
+		"fail/email-denied": {&x509.Certificate{EmailAddresses: []string{"root@example.com"}},
+			"email address root@example.com is denied by the policy"},
+		"fail/uri": {&x509.Certificate{URIs: []*url.URL{mustURL(t, "spiffe://example.net/foo")}},
+			"uri spiffe://example.net/foo is not allowed by the policy"},
+		"fail/uri-no-host": {&x509.Certificate{URIs: []*url.URL{mustURL(t, "urn:uuid:1234")}},
+			"uri urn:uuid:1234 is not allowed by the policy"},
+		"fail/cn": {&x509.Certificate{Subject: pkix.Name{CommonName: "example.net"}},
+			"common name example.net is not allowed by the policy"},
+		"fail/cn-ip": {&x509.Certificate{Subject: pkix.Name{CommonName: "127.0.0.1"}},
+			"common name 127.0.0.1 is not allowed by the policy"},
+		"fail/cn-email": {&x509.Certificate{Subject: pkix.Name{CommonName: "root@example.com"}},
+			"common name root@example.com is denied by the policy"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := e.IsX509CertificateAllowed(tc.cert)
+			if tc.err != "" {
+				if assert.NotNil(t, err) {
+					assert.Equals(t, tc.err, err.Error())
+				}
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+
+	t.Run("ok/nil", func(t *testing.T) {
+		var e *Engine
+		assert.Nil(t, e.IsX509CertificateAllowed(&x509.Certificate{DNSNames: []string{"example.net"}}))
+	})
+
+	t.Run("ok/deny-only", func(t *testing.T) {
+		e, err := New(&Options{X509: &X509Options{Deny: &X509NameOptions{DNSDomains: []string{"example.net"}}}})
+		assert.FatalError(t, err)
+		assert.Nil(t, e.IsX509CertificateAllowed(&x509.Certificate{
+			DNSNames:    []string{"example.com"},
+			IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		}))
+		assert.NotNil(t, e.IsX509CertificateAllowed(&x509.Certificate{DNSNames: []string{"example.net"}}))
+	})
+}
+
+func TestEngine_IsSSHCertificateAllowed(t *testing.T) {
+	e, err := New(&Options{
+		SSH: &SSHOptions{
+			User: &SSHUserOptions{
+				Allow: &SSHUserNameOptions{Principals: []string{"*"}},
+				Deny:  &SSHUserNameOptions{Principals: []string{"root"}, EmailAddresses: []string{"@example.net"}},
+			},
+			Host: &SSHHostOptions{
+				Allow: &SSHHostNameOptions{
+					DNSDomains: []string{"*.internal"},
+					IPRanges:   []string{"10.0.0.0/8"},
+					Principals: []string{"bastion"},
+				},
+			},
+		},
+	})
+	assert.FatalError(t, err)
+
+	tests := map[string]struct {
+		cert *ssh.Certificate
+		err  string
+	}{
+		"ok/user":  {&ssh.Certificate{CertType: ssh.UserCert, ValidPrincipals: []string{"jane", "jane@example.com"}}, ""},
+		"ok/host":  {&ssh.Certificate{CertType: ssh.HostCert, ValidPrincipals: []string{"db.internal", "10.0.0.1", "bastion"}}, ""},
+		"ok/empty": {&ssh.Certificate{CertType: ssh.HostCert}, ""},
+		"fail/user-denied": {&ssh.Certificate{CertType: ssh.UserCert, ValidPrincipals: []string{"jane", "root"}},
+			"principal root is denied by the policy"},
+		"fail/user-email-denied": {&ssh.Certificate{CertType: ssh.UserCert, ValidPrincipals: []string{"jane@example.net"}},
+			"principal jane@example.net is denied by the policy"},
+		"fail/host": {&ssh.Certificate{CertType: ssh.HostCert, ValidPrincipals: []string{"db.example.com"}},
+			"principal db.example.com is not allowed by the policy"},
+		"fail/host-ip": {&ssh.Certificate{CertType: ssh.HostCert, ValidPrincipals: []string{"192.168.0.1"}},
+			"principal 192.168.0.1 is not allowed by the policy"},
+		"fail/type": {&ssh.Certificate{CertType: 3},
+			"unexpected ssh certificate type 3"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := e.IsSSHCertificateAllowed(tc.cert)
+			if tc.err != "" {
+				if assert.NotNil(t, err) {
+					assert.Equals(t, tc.err, err.Error())
+				}
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+
+	t.Run("ok/no-host-policy", func(t *testing.T) {
+		e, err := New(&Options{SSH: &SSHOptions{User: &SSHUserOptions{Deny: &SSHUserNameOptions{Principals: []string{"root"}}}}})
+		assert.FatalError(t, err)
+		assert.Nil(t, e.IsSSHCertificateAllowed(&ssh.Certificate{CertType: ssh.HostCert, ValidPrincipals: []string{"root"}}))
+	})
+}
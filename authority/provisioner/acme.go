@@ -3,19 +3,292 @@ package provisioner
 import (
 	"context"
 	"crypto/x509"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/errs"
+	"golang.org/x/net/http/httpproxy"
 )
 
 // ACME is the acme provisioner type, an entity that can authorize the ACME
 // provisioning flow.
+//
+// If RequireTermsOfServiceAgreed is set, new accounts must agree to the terms
+// of service document in TermsOfService.
+//
+// RateLimits limits the number of requests that ACME clients can make to the
+// provisioner.
+//
+// If AutoRenewal is set, clients can create STAR orders (RFC 8739), orders
+// with short-term certificates that are automatically renewed by the CA.
+//
+// AttestationRoots is a PEM bundle with the roots used to verify the
+// attestation statements of device-attest-01 challenges, clients can only
+// request permanent-identifier identifiers if it's set. AttestationFormats
+// limits the accepted statement formats, apple, step and tpm, all of them are
+// accepted by default.
+//
+// TokenAuthorityRoots is a PEM bundle with the roots used to verify the
+// authority tokens of tkauth-01 challenges, clients can only request
+// TNAuthList identifiers if it's set. TokenAuthorityURL is the URL of the
+// token authority advertised in the challenges.
+//
+// Domains and DeniedDomains restrict the dns identifiers that can be ordered
+// with the provisioner. An entry like "example.com" only matches that name,
+// and an entry like "*.example.com" matches all the subdomains of
+// example.com. They are evaluated as the allowed and denied dns domains of a
+// name policy: if Domains is set, all the identifiers must match one of its
+// entries, and identifiers matching an entry in DeniedDomains are always
+// rejected.
+//
+// MaxIdentifiersPerOrder limits the number of identifiers in a new order, if
+// it's 0 there is no limit.
+//
+// HTTP01Proxy replaces the proxy configured in the ACME authority for the
+// validation of the http-01 challenges of the provisioner.
+//
+// ChallengeTypes limits the challenges offered in the authorizations of dns
+// identifiers, the supported values are http-01, dns-01 and tls-alpn-01, all
+// of them are offered by default. Wildcard identifiers can only be ordered if
+// dns-01 is enabled.
+//
+// Profiles are the certificate profiles that clients can select in new
+// orders, they are advertised in the directory. Orders that do not select a
+// profile use DefaultProfile, or the provisioner defaults if it's empty.
+//
+// CertificateChain sets the contents of the certificate chains downloaded by
+// the clients: leaf for only the leaf certificate, intermediates for the leaf
+// and the intermediates, the default, or root to also include the root
+// certificate.
+//
+// RevokeOnDeactivation revokes all the unexpired certificates issued to an
+// account when the account is deactivated by its client.
+//
+// X509 configures the template applied to the certificates issued in the
+// finalization of the orders.
 type ACME struct {
 	*base
-	Type    string  `json:"type"`
-	Name    string  `json:"name"`
-	Claims  *Claims `json:"claims,omitempty"`
-	claimer *Claimer
+	Type                        string           `json:"type"`
+	Name                        string           `json:"name"`
+	TermsOfService              string           `json:"termsOfService,omitempty"`
+	RequireTermsOfServiceAgreed bool             `json:"requireTermsOfServiceAgreed,omitempty"`
+	RateLimits                  *ACMERateLimits  `json:"rateLimits,omitempty"`
+	AutoRenewal                 *ACMEAutoRenewal `json:"autoRenewal,omitempty"`
+	AttestationRoots            []byte           `json:"attestationRoots,omitempty"`
+	AttestationFormats          []string         `json:"attestationFormats,omitempty"`
+	TokenAuthorityRoots         []byte           `json:"tokenAuthorityRoots,omitempty"`
+	TokenAuthorityURL           string           `json:"tokenAuthorityURL,omitempty"`
+	Domains                     []string         `json:"domains,omitempty"`
+	DeniedDomains               []string         `json:"deniedDomains,omitempty"`
+	MaxIdentifiersPerOrder      int              `json:"maxIdentifiersPerOrder,omitempty"`
+	HTTP01Proxy                 *ACMEHTTPProxy   `json:"http01Proxy,omitempty"`
+	ChallengeTypes              []string         `json:"challengeTypes,omitempty"`
+	Profiles                    ACMEProfiles     `json:"profiles,omitempty"`
+	DefaultProfile              string           `json:"defaultProfile,omitempty"`
+	CertificateChain            string           `json:"certificateChain,omitempty"`
+	RevokeOnDeactivation        bool             `json:"revokeOnDeactivation,omitempty"`
+	Claims                      *Claims          `json:"claims,omitempty"`
+	X509                        *X509Options     `json:"x509,omitempty"`
+	Policy                      *policy.Options  `json:"policy,omitempty"`
+	x509Template                *X509Options
+	claimer                     *Claimer
+	policy                      *policy.Engine
+	domainPolicy                *policy.Engine
+	attestationRootPool         *x509.CertPool
+	tokenAuthorityRootPool      *x509.CertPool
+}
+
+var (
+	defaultAutoRenewalMinLifetime = time.Hour
+	defaultAutoRenewalMaxDuration = 365 * 24 * time.Hour
+)
+
+// ACMEAutoRenewal contains the options of the STAR orders of an ACME
+// provisioner. MinLifetime is the minimum lifetime of the certificates, and
+// MaxDuration is the maximum time between the start and end of an order.
+// AllowCertificateGet allows clients to download the certificates using
+// unauthenticated GET requests.
+type ACMEAutoRenewal struct {
+	MinLifetime         *Duration `json:"minLifetime,omitempty"`
+	MaxDuration         *Duration `json:"maxDuration,omitempty"`
+	AllowCertificateGet bool      `json:"allowCertificateGet,omitempty"`
+}
+
+// Validate validates the auto renewal options.
+func (r *ACMEAutoRenewal) Validate() error {
+	switch {
+	case r == nil:
+		return nil
+	case r.MinLifetime != nil && r.MinLifetime.Duration <= 0:
+		return errors.New("provisioner autoRenewal.minLifetime must be greater than 0")
+	case r.MaxDuration != nil && r.MaxDuration.Duration <= 0:
+		return errors.New("provisioner autoRenewal.maxDuration must be greater than 0")
+	default:
+		return nil
+	}
+}
+
+// GetMinLifetime returns the minimum lifetime of the certificates.
+func (r *ACMEAutoRenewal) GetMinLifetime() time.Duration {
+	if r == nil || r.MinLifetime == nil {
+		return defaultAutoRenewalMinLifetime
+	}
+	return r.MinLifetime.Duration
+}
+
+// GetMaxDuration returns the maximum duration of an order.
+func (r *ACMEAutoRenewal) GetMaxDuration() time.Duration {
+	if r == nil || r.MaxDuration == nil {
+		return defaultAutoRenewalMaxDuration
+	}
+	return r.MaxDuration.Duration
+}
+
+// ACMERateLimits contains the rate limits of an ACME provisioner.
+// OrdersPerAccount is the maximum number of orders an account can create per
+// hour, CertificatesPerDomain the maximum number of certificates issued per
+// registered domain per week, and FailedValidationsPerIdentifier the maximum
+// number of failed validations per identifier per hour. A zero value disables
+// the limit.
+type ACMERateLimits struct {
+	OrdersPerAccount               int `json:"ordersPerAccount,omitempty"`
+	CertificatesPerDomain          int `json:"certificatesPerDomain,omitempty"`
+	FailedValidationsPerIdentifier int `json:"failedValidationsPerIdentifier,omitempty"`
+}
+
+// Validate validates the rate limits.
+func (l *ACMERateLimits) Validate() error {
+	switch {
+	case l == nil:
+		return nil
+	case l.OrdersPerAccount < 0:
+		return errors.New("provisioner rateLimits.ordersPerAccount cannot be less than 0")
+	case l.CertificatesPerDomain < 0:
+		return errors.New("provisioner rateLimits.certificatesPerDomain cannot be less than 0")
+	case l.FailedValidationsPerIdentifier < 0:
+		return errors.New("provisioner rateLimits.failedValidationsPerIdentifier cannot be less than 0")
+	default:
+		return nil
+	}
+}
+
+// The contents of the certificate chains of an ACME provisioner.
+const (
+	ACMEChainLeaf          = "leaf"
+	ACMEChainIntermediates = "intermediates"
+	ACMEChainRoot          = "root"
+)
+
+// ACMEProfile is a certificate profile of an ACME provisioner. Description is
+// shown to the clients in the directory. ExtKeyUsage replaces the default
+// extended key usages of the certificates, the supported values are
+// serverAuth, clientAuth, codeSigning, emailProtection, timeStamping and
+// ocspSigning. DefaultDuration and MaxDuration replace the default and maximum
+// TLS certificate durations of the provisioner claims, they must be within the
+// limits of the claims. X509 replaces the x509 options of the provisioner, like
+// the certificate template, in the certificates issued with the profile.
+type ACMEProfile struct {
+	Description     string       `json:"description,omitempty"`
+	ExtKeyUsage     []string     `json:"extKeyUsage,omitempty"`
+	DefaultDuration *Duration    `json:"defaultDuration,omitempty"`
+	MaxDuration     *Duration    `json:"maxDuration,omitempty"`
+	X509            *X509Options `json:"x509,omitempty"`
+	x509Template    *X509Options
+}
+
+// ACMEProfiles is the set of certificate profiles of an ACME provisioner
+// indexed by name.
+type ACMEProfiles map[string]*ACMEProfile
+
+var extKeyUsageNames = map[string]x509.ExtKeyUsage{
+	"serverAuth":      x509.ExtKeyUsageServerAuth,
+	"clientAuth":      x509.ExtKeyUsageClientAuth,
+	"codeSigning":     x509.ExtKeyUsageCodeSigning,
+	"emailProtection": x509.ExtKeyUsageEmailProtection,
+	"timeStamping":    x509.ExtKeyUsageTimeStamping,
+	"ocspSigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// Validate validates the profiles using the limits of the given claimer.
+func (ps ACMEProfiles) Validate(claimer *Claimer) error {
+	for name, prof := range ps {
+		switch {
+		case name == "":
+			return errors.New("provisioner profiles cannot contain an empty name")
+		case prof == nil:
+			return errors.Errorf("provisioner profiles.%s cannot be empty", name)
+		}
+		for _, eku := range prof.ExtKeyUsage {
+			if _, ok := extKeyUsageNames[eku]; !ok {
+				return errors.Errorf("provisioner profiles.%s.extKeyUsage contains an unsupported value %s", name, eku)
+			}
+		}
+		min, max := claimer.MinTLSCertDuration(), claimer.MaxTLSCertDuration()
+		if prof.MaxDuration != nil {
+			if d := prof.MaxDuration.Duration; d < min || d > max {
+				return errors.Errorf("provisioner profiles.%s.maxDuration must be between %v and %v", name, min, max)
+			}
+			max = prof.MaxDuration.Duration
+		}
+		if prof.DefaultDuration != nil {
+			if d := prof.DefaultDuration.Duration; d < min || d > max {
+				return errors.Errorf("provisioner profiles.%s.defaultDuration must be between %v and %v", name, min, max)
+			}
+		}
+	}
+	return nil
+}
+
+// ACMEHTTPProxy contains the proxy used to connect to the targets of http-01
+// challenges. URL is the address of an http, https or socks5 proxy, if it's
+// empty the connections are made directly. NoProxy is a list of hosts, domains
+// like ".example.com", IP addresses and CIDRs that are always reached
+// directly. Connections to localhost and loopback addresses never use the
+// proxy.
+type ACMEHTTPProxy struct {
+	URL     string   `json:"url,omitempty"`
+	NoProxy []string `json:"noProxy,omitempty"`
+}
+
+// Validate validates the proxy options.
+func (c *ACMEHTTPProxy) Validate() error {
+	if c == nil || c.URL == "" {
+		return nil
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return errors.Errorf("url %s is not valid", c.URL)
+	}
+	switch {
+	case u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5":
+		return errors.Errorf("url %s must use the http, https or socks5 scheme", c.URL)
+	case u.Host == "":
+		return errors.Errorf("url %s must contain a host", c.URL)
+	default:
+		return nil
+	}
+}
+
+// ProxyFunc returns a function that returns the proxy to use for a given
+// request, it can be used as the Proxy of an http.Transport.
+func (c *ACMEHTTPProxy) ProxyFunc() func(*http.Request) (*url.URL, error) {
+	if c == nil || c.URL == "" {
+		return func(*http.Request) (*url.URL, error) {
+			return nil, nil
+		}
+	}
+	proxy := (&httpproxy.Config{
+		HTTPProxy:  c.URL,
+		HTTPSProxy: c.URL,
+		NoProxy:    strings.Join(c.NoProxy, ","),
+	}).ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}
 }
 
 // GetID returns the provisioner unique identifier.
@@ -50,28 +323,280 @@ func (p *ACME) Init(config Config) (err error) {
 		return errors.New("provisioner type cannot be empty")
 	case p.Name == "":
 		return errors.New("provisioner name cannot be empty")
+	case p.RequireTermsOfServiceAgreed && p.TermsOfService == "":
+		return errors.New("provisioner termsOfService cannot be empty if requireTermsOfServiceAgreed is set")
+	}
+	if p.MaxIdentifiersPerOrder < 0 {
+		return errors.New("provisioner maxIdentifiersPerOrder cannot be less than 0")
+	}
+	if err := p.RateLimits.Validate(); err != nil {
+		return err
+	}
+	if err := p.AutoRenewal.Validate(); err != nil {
+		return err
+	}
+	if err := p.HTTP01Proxy.Validate(); err != nil {
+		return errors.Wrap(err, "provisioner http01Proxy")
+	}
+	for _, ct := range p.ChallengeTypes {
+		switch ct {
+		case "http-01", "dns-01", "tls-alpn-01":
+		default:
+			return errors.Errorf("provisioner challengeTypes contains an unsupported type %s", ct)
+		}
+	}
+	switch p.CertificateChain {
+	case "", ACMEChainLeaf, ACMEChainIntermediates, ACMEChainRoot:
+	default:
+		return errors.Errorf("provisioner certificateChain %s is not supported", p.CertificateChain)
+	}
+	for _, f := range p.AttestationFormats {
+		switch f {
+		case "apple", "step", "tpm":
+		default:
+			return errors.Errorf("provisioner attestationFormats contains an unsupported format %s", f)
+		}
+	}
+	if p.domainPolicy, err = newDomainPolicy(p.Domains, p.DeniedDomains); err != nil {
+		return err
+	}
+	if len(p.AttestationRoots) > 0 {
+		p.attestationRootPool = x509.NewCertPool()
+		if !p.attestationRootPool.AppendCertsFromPEM(p.AttestationRoots) {
+			return errors.New("provisioner attestationRoots does not contain any valid certificate")
+		}
+	}
+	if len(p.TokenAuthorityRoots) > 0 {
+		p.tokenAuthorityRootPool = x509.NewCertPool()
+		if !p.tokenAuthorityRootPool.AppendCertsFromPEM(p.TokenAuthorityRoots) {
+			return errors.New("provisioner tokenAuthorityRoots does not contain any valid certificate")
+		}
 	}
 
 	// Update claims with global ones
 	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
 		return err
 	}
+	if err := p.X509.Init(); err != nil {
+		return errors.Wrap(err, "provisioner x509")
+	}
+	if p.x509Template, err = config.Templates.x509Template(p.X509); err != nil {
+		return errors.Wrap(err, "provisioner x509")
+	}
+
+	engine, err := policy.New(p.Policy)
+	if err != nil {
+		return errors.Wrap(err, "provisioner policy")
+	}
+	p.policy = engine
+
+	if err := p.Profiles.Validate(p.claimer); err != nil {
+		return err
+	}
+	for name, prof := range p.Profiles {
+		if prof.X509 == nil {
+			continue
+		}
+		if err := prof.X509.Init(); err != nil {
+			return errors.Wrapf(err, "provisioner profiles.%s.x509", name)
+		}
+		if prof.x509Template, err = config.Templates.x509Template(prof.X509); err != nil {
+			return errors.Wrapf(err, "provisioner profiles.%s.x509", name)
+		}
+	}
+	if _, ok := p.Profiles[p.DefaultProfile]; p.DefaultProfile != "" && !ok {
+		return errors.Errorf("provisioner defaultProfile %s is not in profiles", p.DefaultProfile)
+	}
 
 	return err
 }
 
+// GetAttestationRoots returns the pool with the roots used to verify device
+// attestation statements, and false if they are not configured.
+func (p *ACME) GetAttestationRoots() (*x509.CertPool, bool) {
+	return p.attestationRootPool, p.attestationRootPool != nil
+}
+
+// GetTokenAuthorityRoots returns the pool with the roots used to verify
+// authority tokens, and false if they are not configured.
+func (p *ACME) GetTokenAuthorityRoots() (*x509.CertPool, bool) {
+	return p.tokenAuthorityRootPool, p.tokenAuthorityRootPool != nil
+}
+
+// IsAttestationFormatEnabled returns true if the given attestation statement
+// format is accepted by the provisioner.
+func (p *ACME) IsAttestationFormatEnabled(format string) bool {
+	if len(p.AttestationFormats) == 0 {
+		return format == "apple" || format == "step" || format == "tpm"
+	}
+	for _, f := range p.AttestationFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// IsChallengeEnabled returns true if challenges of the given type are offered
+// in the authorizations of dns identifiers.
+func (p *ACME) IsChallengeEnabled(typ string) bool {
+	if len(p.ChallengeTypes) == 0 {
+		return typ == "http-01" || typ == "dns-01" || typ == "tls-alpn-01"
+	}
+	for _, ct := range p.ChallengeTypes {
+		if ct == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// GetCertificateChain returns the contents of the certificate chains of the
+// provisioner, leaf, intermediates or root.
+func (p *ACME) GetCertificateChain() string {
+	if p.CertificateChain == "" {
+		return ACMEChainIntermediates
+	}
+	return p.CertificateChain
+}
+
+// AuthorizeProfile returns the name of the profile used by a new order that
+// requests the given profile, orders that do not request one use the default
+// profile. It returns an error if the profile does not exist.
+func (p *ACME) AuthorizeProfile(name string) (string, error) {
+	if name == "" {
+		return p.DefaultProfile, nil
+	}
+	if _, ok := p.Profiles[name]; !ok {
+		return "", errors.Errorf("profile %s is not supported by provisioner %s", name, p.Name)
+	}
+	return name, nil
+}
+
+// getProfileDurations returns the default, minimum and maximum certificate
+// durations of the given profile.
+func (p *ACME) getProfileDurations(name string) (def, min, max time.Duration) {
+	def = p.claimer.DefaultTLSCertDuration()
+	min = p.claimer.MinTLSCertDuration()
+	max = p.claimer.MaxTLSCertDuration()
+	if prof, ok := p.Profiles[name]; ok {
+		if prof.MaxDuration != nil {
+			max = prof.MaxDuration.Duration
+			if def > max {
+				def = max
+			}
+		}
+		if prof.DefaultDuration != nil {
+			def = prof.DefaultDuration.Duration
+		}
+	}
+	return
+}
+
+// AuthorizeValidity returns an error if the validity period requested by a
+// new order using the given profile is not allowed by the provisioner claims
+// or the profile. A zero notBefore means the certificate starts when it's
+// issued, and a zero notAfter means it uses the default duration.
+func (p *ACME) AuthorizeValidity(profile string, notBefore, notAfter time.Time) error {
+	def, _, _ := p.getProfileDurations(profile)
+	current := now()
+	if notBefore.IsZero() {
+		notBefore = current
+	}
+	if notAfter.IsZero() {
+		notAfter = notBefore.Add(def)
+	}
+	switch {
+	case notAfter.Before(current):
+		return errors.Errorf("notAfter cannot be in the past; na=%v", notAfter)
+	case !notAfter.After(notBefore):
+		return errors.Errorf("notAfter must be after notBefore; na=%v, nb=%v", notAfter, notBefore)
+	default:
+		return p.AuthorizeLifetime(profile, notAfter.Sub(notBefore))
+	}
+}
+
+// AuthorizeLifetime returns an error if certificates with the given duration
+// are not allowed by the provisioner claims or the given profile. It's used to
+// validate the lifetime of the certificates of STAR orders.
+func (p *ACME) AuthorizeLifetime(profile string, d time.Duration) error {
+	_, min, max := p.getProfileDurations(profile)
+	switch {
+	case d < min:
+		return errors.Errorf("requested duration of %v is less than the authorized minimum certificate duration of %v",
+			d, min)
+	case d > max:
+		return errors.Errorf("requested duration of %v is more than the authorized maximum certificate duration of %v",
+			d, max)
+	default:
+		return nil
+	}
+}
+
+// AuthorizeDomain returns an error if the given dns name, that can be a
+// wildcard name, cannot be ordered using the provisioner.
+func (p *ACME) AuthorizeDomain(name string) error {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	cert := &x509.Certificate{DNSNames: []string{name}}
+	if err := p.domainPolicy.IsX509CertificateAllowed(cert); err != nil {
+		return errors.Wrapf(err, "domain %s is not allowed by provisioner %s", name, p.Name)
+	}
+	if err := p.policy.IsX509CertificateAllowed(cert); err != nil {
+		return errors.Wrapf(err, "domain %s is not allowed by provisioner %s", name, p.Name)
+	}
+	return nil
+}
+
+// newDomainPolicy returns the name policy with the domains and deniedDomains
+// lists as the allowed and denied dns domains.
+func newDomainPolicy(domains, deniedDomains []string) (*policy.Engine, error) {
+	engine, err := policy.New(&policy.Options{
+		X509: &policy.X509Options{
+			Allow: &policy.X509NameOptions{DNSDomains: domains},
+			Deny:  &policy.X509NameOptions{DNSDomains: deniedDomains},
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "provisioner domains")
+	}
+	return engine, nil
+}
+
 // AuthorizeSign does not do any validation, because all validation is handled
 // in the ACME protocol. This method returns a list of modifiers / constraints
-// on the resulting certificate.
+// on the resulting certificate, using the default profile.
 func (p *ACME) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
-	return []SignOption{
+	return p.AuthorizeProfileSign(p.DefaultProfile)
+}
+
+// AuthorizeProfileSign returns the options used to sign a certificate with
+// the given profile, an empty name uses the provisioner defaults.
+func (p *ACME) AuthorizeProfileSign(name string) ([]SignOption, error) {
+	prof, ok := p.Profiles[name]
+	if name != "" && !ok {
+		return nil, errs.BadRequest("acme.AuthorizeProfileSign; profile %s is not supported by provisioner %s", name, p.GetID())
+	}
+	def, min, max := p.getProfileDurations(name)
+	opts := []SignOption{
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeACME, p.Name, ""),
-		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
+		profileDefaultDuration(def),
 		// validators
 		defaultPublicKeyValidator{},
-		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
-	}, nil
+		newValidityValidator(min, max),
+		newX509NamePolicyValidator(p.policy),
+	}
+	if prof != nil && len(prof.ExtKeyUsage) > 0 {
+		ekus := make(profileExtKeyUsage, len(prof.ExtKeyUsage))
+		for i, eku := range prof.ExtKeyUsage {
+			ekus[i] = extKeyUsageNames[eku]
+		}
+		opts = append(opts, ekus)
+	}
+	if prof != nil && prof.X509 != nil {
+		return prof.x509Template.appendTemplateOption(opts, ""), nil
+	}
+	return p.x509Template.appendTemplateOption(opts, ""), nil
 }
 
 // AuthorizeRenew returns an error if the renewal is disabled.
@@ -82,5 +607,8 @@ func (p *ACME) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) error
 	if p.claimer.IsDisableRenewal() {
 		return errs.Unauthorized("acme.AuthorizeRenew; renew is disabled for acme provisioner %s", p.GetID())
 	}
+	if !p.claimer.IsRenewable(cert) {
+		return errs.Unauthorized("acme.AuthorizeRenew; certificate expired on %s", cert.NotAfter.Format(time.RFC3339))
+	}
 	return nil
 }
@@ -3,12 +3,15 @@ package provisioner
 import (
 	"context"
 	"crypto/x509"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"testing"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/errs"
 )
 
@@ -64,11 +67,160 @@ func TestACME_Init(t *testing.T) {
 				err: errors.New("claims: DefaultTLSCertDuration must be greater than 0"),
 			}
 		},
+		"fail-empty-terms-of-service": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", RequireTermsOfServiceAgreed: true},
+				err: errors.New("provisioner termsOfService cannot be empty if requireTermsOfServiceAgreed is set"),
+			}
+		},
+		"fail-max-identifiers-per-order": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", MaxIdentifiersPerOrder: -1},
+				err: errors.New("provisioner maxIdentifiersPerOrder cannot be less than 0"),
+			}
+		},
+		"fail-rate-limits": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", RateLimits: &ACMERateLimits{CertificatesPerDomain: -1}},
+				err: errors.New("provisioner rateLimits.certificatesPerDomain cannot be less than 0"),
+			}
+		},
+		"fail-auto-renewal": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", AutoRenewal: &ACMEAutoRenewal{MinLifetime: &Duration{0}}},
+				err: errors.New("provisioner autoRenewal.minLifetime must be greater than 0"),
+			}
+		},
+		"fail-attestation-formats": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", AttestationFormats: []string{"apple", "foo"}},
+				err: errors.New("provisioner attestationFormats contains an unsupported format foo"),
+			}
+		},
+		"fail-challenge-types": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", ChallengeTypes: []string{"dns-01", "device-attest-01"}},
+				err: errors.New("provisioner challengeTypes contains an unsupported type device-attest-01"),
+			}
+		},
+		"fail-certificate-chain": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", CertificateChain: "full"},
+				err: errors.New("provisioner certificateChain full is not supported"),
+			}
+		},
+		"fail-attestation-roots": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", AttestationRoots: []byte("foo")},
+				err: errors.New("provisioner attestationRoots does not contain any valid certificate"),
+			}
+		},
+		"fail-token-authority-roots": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", TokenAuthorityRoots: []byte("foo")},
+				err: errors.New("provisioner tokenAuthorityRoots does not contain any valid certificate"),
+			}
+		},
+		"fail-domains": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", Domains: []string{"example.com", "foo.*.example.com"}},
+				err: errors.New("provisioner domains: x509: allow: dns: domain foo.*.example.com can only contain a wildcard in the leftmost label"),
+			}
+		},
+		"fail-denied-domains": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", DeniedDomains: []string{"Example.com"}},
+				err: errors.New("provisioner domains: x509: deny: dns: domain Example.com must be lowercase"),
+			}
+		},
+		"fail-http01-proxy": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", HTTP01Proxy: &ACMEHTTPProxy{URL: "ftp://proxy.internal"}},
+				err: errors.New("provisioner http01Proxy: url ftp://proxy.internal must use the http, https or socks5 scheme"),
+			}
+		},
+		"fail-profiles-empty-name": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", Profiles: ACMEProfiles{"": {}}},
+				err: errors.New("provisioner profiles cannot contain an empty name"),
+			}
+		},
+		"fail-profiles-nil": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", Profiles: ACMEProfiles{"server": nil}},
+				err: errors.New("provisioner profiles.server cannot be empty"),
+			}
+		},
+		"fail-profiles-ext-key-usage": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", Profiles: ACMEProfiles{"server": {ExtKeyUsage: []string{"serverAuth", "any"}}}},
+				err: errors.New("provisioner profiles.server.extKeyUsage contains an unsupported value any"),
+			}
+		},
+		"fail-profiles-max-duration": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", Profiles: ACMEProfiles{"long": {MaxDuration: &Duration{48 * time.Hour}}}},
+				err: errors.New("provisioner profiles.long.maxDuration must be between 5m0s and 24h0m0s"),
+			}
+		},
+		"fail-profiles-default-duration": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", Profiles: ACMEProfiles{"short": {DefaultDuration: &Duration{2 * time.Hour}, MaxDuration: &Duration{time.Hour}}}},
+				err: errors.New("provisioner profiles.short.defaultDuration must be between 5m0s and 1h0m0s"),
+			}
+		},
+		"fail-default-profile": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", Profiles: ACMEProfiles{"server": {}}, DefaultProfile: "client"},
+				err: errors.New("provisioner defaultProfile client is not in profiles"),
+			}
+		},
 		"ok": func(t *testing.T) ProvisionerValidateTest {
 			return ProvisionerValidateTest{
 				p: &ACME{Name: "foo", Type: "bar"},
 			}
 		},
+		"ok/profiles": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p: &ACME{Name: "foo", Type: "bar", DefaultProfile: "server", Profiles: ACMEProfiles{
+					"server":     {Description: "TLS server certificates", ExtKeyUsage: []string{"serverAuth"}},
+					"client":     {ExtKeyUsage: []string{"clientAuth"}},
+					"shortlived": {DefaultDuration: &Duration{time.Hour}, MaxDuration: &Duration{time.Hour}},
+				}},
+			}
+		},
+		"ok/http01-proxy": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p: &ACME{Name: "foo", Type: "bar", HTTP01Proxy: &ACMEHTTPProxy{URL: "http://proxy.internal:3128", NoProxy: []string{".internal"}}},
+			}
+		},
+		"ok/terms-of-service": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p: &ACME{Name: "foo", Type: "bar", TermsOfService: "https://ca.smallstep.com/tos", RequireTermsOfServiceAgreed: true},
+			}
+		},
+		"ok/rate-limits": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p: &ACME{Name: "foo", Type: "bar", RateLimits: &ACMERateLimits{OrdersPerAccount: 300, CertificatesPerDomain: 50, FailedValidationsPerIdentifier: 5}},
+			}
+		},
+		"ok/auto-renewal": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p: &ACME{Name: "foo", Type: "bar", AutoRenewal: &ACMEAutoRenewal{MinLifetime: &Duration{time.Hour}, AllowCertificateGet: true}},
+			}
+		},
+		"ok/domains": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p: &ACME{Name: "foo", Type: "bar", Domains: []string{"*.example.com", "example.com"}, DeniedDomains: []string{"secret.example.com"}},
+			}
+		},
+		"ok/attestation": func(t *testing.T) ProvisionerValidateTest {
+			roots, err := ioutil.ReadFile("./testdata/certs/root_ca.crt")
+			assert.FatalError(t, err)
+			return ProvisionerValidateTest{
+				p: &ACME{Name: "foo", Type: "bar", AttestationRoots: roots, AttestationFormats: []string{"step", "tpm"}},
+			}
+		},
 	}
 
 	config := Config{
@@ -90,6 +242,191 @@ func TestACME_Init(t *testing.T) {
 	}
 }
 
+func TestACME_Attestation(t *testing.T) {
+	p, err := generateACME()
+	assert.FatalError(t, err)
+	_, ok := p.GetAttestationRoots()
+	assert.False(t, ok)
+	assert.True(t, p.IsAttestationFormatEnabled("apple"))
+	assert.True(t, p.IsAttestationFormatEnabled("step"))
+	assert.True(t, p.IsAttestationFormatEnabled("tpm"))
+	assert.False(t, p.IsAttestationFormatEnabled("foo"))
+
+	roots, err := ioutil.ReadFile("./testdata/certs/root_ca.crt")
+	assert.FatalError(t, err)
+	p.AttestationRoots = roots
+	p.AttestationFormats = []string{"tpm"}
+	assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims, Audiences: testAudiences}))
+	pool, ok := p.GetAttestationRoots()
+	assert.True(t, ok)
+	assert.NotNil(t, pool)
+	assert.False(t, p.IsAttestationFormatEnabled("apple"))
+	assert.True(t, p.IsAttestationFormatEnabled("tpm"))
+
+	_, ok = p.GetTokenAuthorityRoots()
+	assert.False(t, ok)
+	p.TokenAuthorityRoots = roots
+	assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims, Audiences: testAudiences}))
+	pool, ok = p.GetTokenAuthorityRoots()
+	assert.True(t, ok)
+	assert.NotNil(t, pool)
+}
+
+func TestACME_IsChallengeEnabled(t *testing.T) {
+	p, err := generateACME()
+	assert.FatalError(t, err)
+	assert.True(t, p.IsChallengeEnabled("http-01"))
+	assert.True(t, p.IsChallengeEnabled("dns-01"))
+	assert.True(t, p.IsChallengeEnabled("tls-alpn-01"))
+	assert.False(t, p.IsChallengeEnabled("device-attest-01"))
+
+	p.ChallengeTypes = []string{"dns-01"}
+	assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims, Audiences: testAudiences}))
+	assert.False(t, p.IsChallengeEnabled("http-01"))
+	assert.True(t, p.IsChallengeEnabled("dns-01"))
+	assert.False(t, p.IsChallengeEnabled("tls-alpn-01"))
+}
+
+func TestACME_GetCertificateChain(t *testing.T) {
+	p, err := generateACME()
+	assert.FatalError(t, err)
+	assert.Equals(t, ACMEChainIntermediates, p.GetCertificateChain())
+
+	p.CertificateChain = ACMEChainRoot
+	assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims, Audiences: testAudiences}))
+	assert.Equals(t, ACMEChainRoot, p.GetCertificateChain())
+}
+
+func TestACME_AuthorizeValidity(t *testing.T) {
+	p, err := generateACME()
+	assert.FatalError(t, err)
+	p.Profiles = ACMEProfiles{
+		"shortlived": {MaxDuration: &Duration{time.Hour}},
+	}
+	now := time.Now()
+	type test struct {
+		profile  string
+		nbf, naf time.Time
+		err      string
+	}
+	tests := map[string]test{
+		"fail/naf-in-the-past":      {naf: now.Add(-time.Hour), err: "notAfter cannot be in the past"},
+		"fail/naf-before-nbf":       {nbf: now.Add(2 * time.Hour), naf: now.Add(time.Hour), err: "notAfter must be after notBefore"},
+		"fail/too-short":            {nbf: now, naf: now.Add(time.Minute), err: "requested duration of 1m0s is less than the authorized minimum certificate duration of 5m0s"},
+		"fail/too-long":             {naf: now.Add(48 * time.Hour), err: "requested duration of"},
+		"fail/profile-too-long":     {profile: "shortlived", nbf: now, naf: now.Add(2 * time.Hour), err: "requested duration of 2h0m0s is more than the authorized maximum certificate duration of 1h0m0s"},
+		"ok/default":                {},
+		"ok/nbf":                    {nbf: now.Add(time.Hour)},
+		"ok/naf":                    {naf: now.Add(time.Hour)},
+		"ok/nbf-naf":                {nbf: now.Add(time.Hour), naf: now.Add(2 * time.Hour)},
+		"ok/profile":                {profile: "shortlived", naf: now.Add(30 * time.Minute)},
+		"ok/profile-default-capped": {profile: "shortlived", nbf: now.Add(time.Minute)},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := p.AuthorizeValidity(tc.profile, tc.nbf, tc.naf); err != nil {
+				if assert.NotEquals(t, "", tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err)
+				}
+			} else {
+				assert.Equals(t, "", tc.err)
+			}
+		})
+	}
+}
+
+func TestACME_AuthorizeLifetime(t *testing.T) {
+	p, err := generateACME()
+	assert.FatalError(t, err)
+	p.Profiles = ACMEProfiles{
+		"shortlived": {MaxDuration: &Duration{time.Hour}},
+	}
+	assert.FatalError(t, p.AuthorizeLifetime("", 24*time.Hour))
+	assert.FatalError(t, p.AuthorizeLifetime("shortlived", time.Hour))
+	assert.Error(t, p.AuthorizeLifetime("", time.Minute))
+	assert.Error(t, p.AuthorizeLifetime("", 48*time.Hour))
+	assert.Error(t, p.AuthorizeLifetime("shortlived", 2*time.Hour))
+}
+
+func TestACME_AuthorizeProfile(t *testing.T) {
+	p := &ACME{Name: "acme", Profiles: ACMEProfiles{"server": {}, "client": {}}}
+	name, err := p.AuthorizeProfile("client")
+	assert.FatalError(t, err)
+	assert.Equals(t, "client", name)
+
+	name, err = p.AuthorizeProfile("")
+	assert.FatalError(t, err)
+	assert.Equals(t, "", name)
+
+	p.DefaultProfile = "server"
+	name, err = p.AuthorizeProfile("")
+	assert.FatalError(t, err)
+	assert.Equals(t, "server", name)
+
+	_, err = p.AuthorizeProfile("shortlived")
+	if assert.NotNil(t, err) {
+		assert.Equals(t, "profile shortlived is not supported by provisioner acme", err.Error())
+	}
+}
+
+func TestACME_AuthorizeDomain(t *testing.T) {
+	domainPolicy, err := newDomainPolicy(
+		[]string{"example.com", "*.example.com", "foo.internal"},
+		[]string{"secret.example.com", "*.private.example.com"},
+	)
+	assert.FatalError(t, err)
+	p := &ACME{Name: "acme", domainPolicy: domainPolicy}
+	tests := map[string]bool{
+		"example.com":                    true,
+		"Example.COM.":                   true,
+		"www.example.com":                true,
+		"a.b.example.com":                true,
+		"*.example.com":                  false,
+		"*.www.example.com":              true,
+		"foo.internal":                   true,
+		"private.example.com":            true,
+		"bar.foo.internal":               false,
+		"*.foo.internal":                 false,
+		"example.org":                    false,
+		"badexample.com":                 false,
+		"secret.example.com":             false,
+		"a.private.example.com":          false,
+		"*.private.example.com":          false,
+		"*.a.private.example.com":        false,
+		"*.secret.example.com":           true,
+		"secret.private.example.com.":    false,
+		"www.secret.example.com":         true,
+		"www.secret.private.example.com": false,
+	}
+	for name, ok := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := p.AuthorizeDomain(name)
+			if ok {
+				assert.FatalError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+
+	// Wildcard names that include a denied name are rejected.
+	domainPolicy, err = newDomainPolicy(nil, []string{"secret.example.com"})
+	assert.FatalError(t, err)
+	p = &ACME{Name: "acme", domainPolicy: domainPolicy}
+	assert.Error(t, p.AuthorizeDomain("*.example.com"))
+	assert.FatalError(t, p.AuthorizeDomain("www.example.com"))
+
+	// Names are also checked against the name policy.
+	engine, err := policy.New(&policy.Options{
+		X509: &policy.X509Options{Deny: &policy.X509NameOptions{DNSDomains: []string{"*.internal"}}},
+	})
+	assert.FatalError(t, err)
+	p = &ACME{Name: "acme", policy: engine}
+	assert.Error(t, p.AuthorizeDomain("db.internal"))
+	assert.Error(t, p.AuthorizeDomain("*.internal"))
+	assert.FatalError(t, p.AuthorizeDomain("www.example.com"))
+}
+
 func TestACME_AuthorizeRenew(t *testing.T) {
 	type test struct {
 		p    *ACME
@@ -108,17 +445,27 @@ func TestACME_AuthorizeRenew(t *testing.T) {
 			assert.FatalError(t, err)
 			return test{
 				p:    p,
-				cert: &x509.Certificate{},
+				cert: &x509.Certificate{NotAfter: time.Now().Add(time.Hour)},
 				code: http.StatusUnauthorized,
 				err:  errors.Errorf("acme.AuthorizeRenew; renew is disabled for acme provisioner %s", p.GetID()),
 			}
 		},
+		"fail/expired": func(t *testing.T) test {
+			p, err := generateACME()
+			assert.FatalError(t, err)
+			return test{
+				p:    p,
+				cert: &x509.Certificate{NotAfter: time.Now().Add(-time.Hour)},
+				code: http.StatusUnauthorized,
+				err:  errors.New("acme.AuthorizeRenew; certificate expired on"),
+			}
+		},
 		"ok": func(t *testing.T) test {
 			p, err := generateACME()
 			assert.FatalError(t, err)
 			return test{
 				p:    p,
-				cert: &x509.Certificate{},
+				cert: &x509.Certificate{NotAfter: time.Now().Add(time.Hour)},
 			}
 		},
 	}
@@ -168,7 +515,7 @@ func TestACME_AuthorizeSign(t *testing.T) {
 				}
 			} else {
 				if assert.Nil(t, tc.err) && assert.NotNil(t, opts) {
-					assert.Len(t, 4, opts)
+					assert.Len(t, 5, opts)
 					for _, o := range opts {
 						switch v := o.(type) {
 						case *provisionerExtensionOption:
@@ -182,6 +529,7 @@ func TestACME_AuthorizeSign(t *testing.T) {
 						case *validityValidator:
 							assert.Equals(t, v.min, tc.p.claimer.MinTLSCertDuration())
 							assert.Equals(t, v.max, tc.p.claimer.MaxTLSCertDuration())
+						case *x509NamePolicyValidator:
 						default:
 							assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
 						}
@@ -191,3 +539,146 @@ func TestACME_AuthorizeSign(t *testing.T) {
 		})
 	}
 }
+
+func TestACME_AuthorizeProfileSign(t *testing.T) {
+	p, err := generateACME()
+	assert.FatalError(t, err)
+	p.Profiles = ACMEProfiles{
+		"client":     {ExtKeyUsage: []string{"clientAuth", "emailProtection"}},
+		"shortlived": {DefaultDuration: &Duration{30 * time.Minute}, MaxDuration: &Duration{time.Hour}},
+	}
+	type test struct {
+		profile string
+		def     time.Duration
+		max     time.Duration
+		ekus    profileExtKeyUsage
+		err     string
+	}
+	tests := map[string]test{
+		"fail/unknown":  {profile: "server", err: "acme.AuthorizeProfileSign; profile server is not supported by provisioner acme/test@acme-provisioner.com"},
+		"ok/none":       {def: 24 * time.Hour, max: 24 * time.Hour},
+		"ok/client":     {profile: "client", def: 24 * time.Hour, max: 24 * time.Hour, ekus: profileExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageEmailProtection}},
+		"ok/shortlived": {profile: "shortlived", def: 30 * time.Minute, max: time.Hour},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			opts, err := p.AuthorizeProfileSign(tc.profile)
+			if err != nil {
+				if assert.NotEquals(t, "", tc.err) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, sc.StatusCode(), http.StatusBadRequest)
+					assert.Equals(t, tc.err, err.Error())
+				}
+				return
+			}
+			assert.Equals(t, "", tc.err)
+			var ekus profileExtKeyUsage
+			for _, o := range opts {
+				switch v := o.(type) {
+				case profileDefaultDuration:
+					assert.Equals(t, tc.def, time.Duration(v))
+				case *validityValidator:
+					assert.Equals(t, p.claimer.MinTLSCertDuration(), v.min)
+					assert.Equals(t, tc.max, v.max)
+				case profileExtKeyUsage:
+					ekus = v
+				}
+			}
+			assert.Equals(t, tc.ekus, ekus)
+		})
+	}
+}
+
+func TestACME_AuthorizeProfileSign_x509(t *testing.T) {
+	p, err := generateACME()
+	assert.FatalError(t, err)
+	p.X509 = &X509Options{Template: `{"subject": {"commonName": "default"}}`}
+	p.Profiles = ACMEProfiles{
+		"server":  {X509: &X509Options{Template: `{"subject": {"commonName": "server"}, "keyUsage": ["digitalSignature"]}`}},
+		"client":  {X509: &X509Options{Template: `{"subject": {"commonName": "client"}, "extKeyUsage": ["clientAuth"]}`}},
+		"default": {Description: "Uses the provisioner template"},
+	}
+	assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims}))
+
+	sign := func(profile string) *x509.Certificate {
+		t.Helper()
+		opts, err := p.AuthorizeProfileSign(profile)
+		assert.FatalError(t, err)
+		cert := &x509.Certificate{}
+		for _, o := range opts {
+			if m, ok := o.(CertificateModifier); ok {
+				assert.FatalError(t, m.Modify(cert, &x509.CertificateRequest{}, Options{}))
+			}
+		}
+		return cert
+	}
+	server, client := sign("server"), sign("client")
+	assert.Equals(t, "server", server.Subject.CommonName)
+	assert.Equals(t, x509.KeyUsageDigitalSignature, server.KeyUsage)
+	assert.Len(t, 0, server.ExtKeyUsage)
+	assert.Equals(t, "client", client.Subject.CommonName)
+	assert.Equals(t, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, client.ExtKeyUsage)
+	assert.Equals(t, "default", sign("default").Subject.CommonName)
+	assert.Equals(t, "default", sign("").Subject.CommonName)
+
+	p.Profiles = ACMEProfiles{"bad": {X509: &X509Options{Template: `{{ .Subject`}}}
+	err = p.Init(Config{Claims: globalProvisionerClaims})
+	if assert.Error(t, err) {
+		assert.HasPrefix(t, err.Error(), "provisioner profiles.bad.x509: error parsing template")
+	}
+}
+
+func TestACMEHTTPProxy_Validate(t *testing.T) {
+	tests := map[string]struct {
+		proxy *ACMEHTTPProxy
+		err   error
+	}{
+		"ok/nil":       {proxy: nil},
+		"ok/empty":     {proxy: &ACMEHTTPProxy{}},
+		"ok/http":      {proxy: &ACMEHTTPProxy{URL: "http://proxy.internal:3128"}},
+		"ok/socks5":    {proxy: &ACMEHTTPProxy{URL: "socks5://proxy.internal:1080"}},
+		"fail/parse":   {proxy: &ACMEHTTPProxy{URL: "http://proxy internal:%zz"}, err: errors.New("url http://proxy internal:%zz is not valid")},
+		"fail/scheme":  {proxy: &ACMEHTTPProxy{URL: "proxy.internal:3128"}, err: errors.New("url proxy.internal:3128 must use the http, https or socks5 scheme")},
+		"fail/no-host": {proxy: &ACMEHTTPProxy{URL: "http:///path"}, err: errors.New("url http:///path must contain a host")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tc.proxy.Validate(); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.Equals(t, tc.err.Error(), err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestACMEHTTPProxy_ProxyFunc(t *testing.T) {
+	proxyURL, err := url.Parse("http://proxy.internal:3128")
+	assert.FatalError(t, err)
+	proxy := &ACMEHTTPProxy{URL: proxyURL.String(), NoProxy: []string{".example.com", "10.0.0.0/8"}}
+	tests := map[string]struct {
+		proxy  *ACMEHTTPProxy
+		target string
+		want   *url.URL
+	}{
+		"nil":         {proxy: nil, target: "http://foo.internal", want: nil},
+		"empty":       {proxy: &ACMEHTTPProxy{NoProxy: []string{"foo"}}, target: "http://foo.internal", want: nil},
+		"proxied":     {proxy: proxy, target: "http://foo.internal/.well-known/acme-challenge/token", want: proxyURL},
+		"proxied-tls": {proxy: proxy, target: "https://foo.internal/", want: proxyURL},
+		"no-proxy":    {proxy: proxy, target: "http://foo.example.com/", want: nil},
+		"no-proxy-ip": {proxy: proxy, target: "http://10.1.2.3/", want: nil},
+		"localhost":   {proxy: proxy, target: "http://localhost/", want: nil},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", tc.target, nil)
+			assert.FatalError(t, err)
+			got, err := tc.proxy.ProxyFunc()(req)
+			assert.FatalError(t, err)
+			assert.Equals(t, tc.want, got)
+		})
+	}
+}
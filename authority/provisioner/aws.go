@@ -16,6 +16,7 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/errs"
 	"github.com/smallstep/cli/jose"
 )
@@ -121,18 +122,28 @@ type awsInstanceIdentityDocument struct {
 // If InstanceAge is set, only the instances with a pendingTime within the given
 // period will be accepted.
 //
+// If Regions is set, only the instances running in one of the given regions
+// will be accepted.
+//
 // Amazon Identity docs are available at
 // https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/instance-identity-documents.html
 type AWS struct {
 	*base
-	Type                   string   `json:"type"`
-	Name                   string   `json:"name"`
-	Accounts               []string `json:"accounts"`
-	DisableCustomSANs      bool     `json:"disableCustomSANs"`
-	DisableTrustOnFirstUse bool     `json:"disableTrustOnFirstUse"`
-	InstanceAge            Duration `json:"instanceAge,omitempty"`
-	Claims                 *Claims  `json:"claims,omitempty"`
+	Type                   string              `json:"type"`
+	Name                   string              `json:"name"`
+	Accounts               []string            `json:"accounts"`
+	Regions                []string            `json:"regions,omitempty"`
+	DisableCustomSANs      bool                `json:"disableCustomSANs"`
+	DisableTrustOnFirstUse bool                `json:"disableTrustOnFirstUse"`
+	InstanceAge            Duration            `json:"instanceAge,omitempty"`
+	Claims                 *Claims             `json:"claims,omitempty"`
+	X509                   *X509Options        `json:"x509,omitempty"`
+	SSH                    *SSHTemplateOptions `json:"ssh,omitempty"`
+	Policy                 *policy.Options     `json:"policy,omitempty"`
+	x509Template           *X509Options
+	sshTemplate            *SSHTemplateOptions
 	claimer                *Claimer
+	policy                 *policy.Engine
 	config                 *awsConfig
 	audiences              Audiences
 }
@@ -259,6 +270,25 @@ func (p *AWS) Init(config Config) (err error) {
 	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
 		return err
 	}
+	if err := p.X509.Init(); err != nil {
+		return errors.Wrap(err, "provisioner x509")
+	}
+	if p.x509Template, err = config.Templates.x509Template(p.X509); err != nil {
+		return errors.Wrap(err, "provisioner x509")
+	}
+	if err := p.SSH.Init(); err != nil {
+		return errors.Wrap(err, "provisioner ssh")
+	}
+	if p.sshTemplate, err = config.Templates.sshTemplate(p.SSH); err != nil {
+		return errors.Wrap(err, "provisioner ssh")
+	}
+
+	engine, err := policy.New(p.Policy)
+	if err != nil {
+		return errors.Wrap(err, "provisioner policy")
+	}
+	p.policy = engine
+
 	// Add default config
 	if p.config, err = newAWSConfig(); err != nil {
 		return err
@@ -289,7 +319,7 @@ func (p *AWS) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 		}))
 	}
 
-	return append(so,
+	so = append(so,
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeAWS, p.Name, doc.AccountID, "InstanceID", doc.InstanceID),
 		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
@@ -297,7 +327,9 @@ func (p *AWS) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 		defaultPublicKeyValidator{},
 		commonNameValidator(payload.Claims.Subject),
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
-	), nil
+		newX509NamePolicyValidator(p.policy),
+	)
+	return p.x509Template.appendTemplateOption(so, token), nil
 }
 
 // AuthorizeRenew returns an error if the renewal is disabled.
@@ -308,6 +340,9 @@ func (p *AWS) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) error
 	if p.claimer.IsDisableRenewal() {
 		return errs.Unauthorized("aws.AuthorizeRenew; renew is disabled for aws provisioner %s", p.GetID())
 	}
+	if !p.claimer.IsRenewable(cert) {
+		return errs.Unauthorized("aws.AuthorizeRenew; certificate expired on %s", cert.NotAfter.Format(time.RFC3339))
+	}
 	return nil
 }
 
@@ -425,6 +460,20 @@ func (p *AWS) authorizeToken(token string) (*awsPayload, error) {
 		}
 	}
 
+	// validate regions
+	if len(p.Regions) > 0 {
+		var found bool
+		for _, r := range p.Regions {
+			if r == doc.Region {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errs.Unauthorized("aws.authorizeToken; invalid aws identity document - region is not valid")
+		}
+	}
+
 	// validate instance age
 	if d := p.InstanceAge.Value(); d > 0 {
 		if now.Sub(doc.PendingTime) > d {
@@ -473,7 +522,7 @@ func (p *AWS) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	// Set defaults if not given as user options
 	signOptions = append(signOptions, sshCertDefaultsModifier(defaults))
 
-	return append(signOptions,
+	signOptions = append(signOptions,
 		// Set the default extensions.
 		&sshDefaultExtensionModifier{},
 		// Set the validity bounds if not set.
@@ -482,7 +531,10 @@ func (p *AWS) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 		&sshDefaultPublicKeyValidator{},
 		// Validate the validity period.
 		&sshCertValidityValidator{p.claimer},
+		// Validate the principals against the name policy.
+		&sshNamePolicyValidator{p.policy},
 		// Require all the fields in the SSH certificate
 		&sshCertDefaultValidator{},
-	), nil
+	)
+	return p.sshTemplate.appendTemplateOption(signOptions, token), nil
 }
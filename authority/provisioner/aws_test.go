@@ -386,6 +386,21 @@ func TestAWS_authorizeToken(t *testing.T) {
 				err:   errors.New("aws.authorizeToken; invalid aws identity document - accountId is not valid"),
 			}
 		},
+		"fail/invalid-region": func(t *testing.T) test {
+			p, err := generateAWS()
+			assert.FatalError(t, err)
+			p.Regions = []string{"us-east-1", "eu-west-1"}
+			tok, err := generateAWSToken(
+				"instance-id", awsIssuer, p.GetID(), p.Accounts[0], "instance-id",
+				"127.0.0.1", "us-west-1", time.Now(), key)
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+				code:  http.StatusUnauthorized,
+				err:   errors.New("aws.authorizeToken; invalid aws identity document - region is not valid"),
+			}
+		},
 		"fail/instance-age": func(t *testing.T) test {
 			p, err := generateAWS()
 			assert.FatalError(t, err)
@@ -401,6 +416,19 @@ func TestAWS_authorizeToken(t *testing.T) {
 				err:   errors.New("aws.authorizeToken; aws identity document pendingTime is too old"),
 			}
 		},
+		"ok/region": func(t *testing.T) test {
+			p, err := generateAWS()
+			assert.FatalError(t, err)
+			p.Regions = []string{"us-east-1", "us-west-1"}
+			tok, err := generateAWSToken(
+				"instance-id", awsIssuer, p.GetID(), p.Accounts[0], "instance-id",
+				"127.0.0.1", "us-west-1", time.Now(), key)
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+			}
+		},
 		"ok": func(t *testing.T) test {
 			p, err := generateAWS()
 			assert.FatalError(t, err)
@@ -539,11 +567,11 @@ func TestAWS_AuthorizeSign(t *testing.T) {
 		code    int
 		wantErr bool
 	}{
-		{"ok", p1, args{t1}, 5, http.StatusOK, false},
-		{"ok", p2, args{t2}, 7, http.StatusOK, false},
-		{"ok", p2, args{t2Hostname}, 7, http.StatusOK, false},
-		{"ok", p2, args{t2PrivateIP}, 7, http.StatusOK, false},
-		{"ok", p1, args{t4}, 5, http.StatusOK, false},
+		{"ok", p1, args{t1}, 6, http.StatusOK, false},
+		{"ok", p2, args{t2}, 8, http.StatusOK, false},
+		{"ok", p2, args{t2Hostname}, 8, http.StatusOK, false},
+		{"ok", p2, args{t2PrivateIP}, 8, http.StatusOK, false},
+		{"ok", p1, args{t4}, 6, http.StatusOK, false},
 		{"fail account", p3, args{t3}, 0, http.StatusUnauthorized, true},
 		{"fail token", p1, args{"token"}, 0, http.StatusUnauthorized, true},
 		{"fail subject", p1, args{failSubject}, 0, http.StatusUnauthorized, true},
@@ -704,6 +732,9 @@ func TestAWS_AuthorizeRenew(t *testing.T) {
 	p2.claimer, err = NewClaimer(p2.Claims, globalProvisionerClaims)
 	assert.FatalError(t, err)
 
+	cert := &x509.Certificate{NotAfter: time.Now().Add(time.Hour)}
+	expired := &x509.Certificate{NotAfter: time.Now().Add(-time.Hour)}
+
 	type args struct {
 		cert *x509.Certificate
 	}
@@ -714,8 +745,9 @@ func TestAWS_AuthorizeRenew(t *testing.T) {
 		code    int
 		wantErr bool
 	}{
-		{"ok", p1, args{nil}, http.StatusOK, false},
-		{"fail/renew-disabled", p2, args{nil}, http.StatusUnauthorized, true},
+		{"ok", p1, args{cert}, http.StatusOK, false},
+		{"fail/expired", p1, args{expired}, http.StatusUnauthorized, true},
+		{"fail/renew-disabled", p2, args{cert}, http.StatusUnauthorized, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
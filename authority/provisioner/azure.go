@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/errs"
 	"github.com/smallstep/cli/jose"
 )
@@ -82,15 +83,21 @@ type azurePayload struct {
 // and https://docs.microsoft.com/en-us/azure/virtual-machines/windows/instance-metadata-service
 type Azure struct {
 	*base
-	Type                   string   `json:"type"`
-	Name                   string   `json:"name"`
-	TenantID               string   `json:"tenantID"`
-	ResourceGroups         []string `json:"resourceGroups"`
-	Audience               string   `json:"audience,omitempty"`
-	DisableCustomSANs      bool     `json:"disableCustomSANs"`
-	DisableTrustOnFirstUse bool     `json:"disableTrustOnFirstUse"`
-	Claims                 *Claims  `json:"claims,omitempty"`
+	Type                   string              `json:"type"`
+	Name                   string              `json:"name"`
+	TenantID               string              `json:"tenantID"`
+	ResourceGroups         []string            `json:"resourceGroups"`
+	Audience               string              `json:"audience,omitempty"`
+	DisableCustomSANs      bool                `json:"disableCustomSANs"`
+	DisableTrustOnFirstUse bool                `json:"disableTrustOnFirstUse"`
+	Claims                 *Claims             `json:"claims,omitempty"`
+	X509                   *X509Options        `json:"x509,omitempty"`
+	SSH                    *SSHTemplateOptions `json:"ssh,omitempty"`
+	Policy                 *policy.Options     `json:"policy,omitempty"`
+	x509Template           *X509Options
+	sshTemplate            *SSHTemplateOptions
 	claimer                *Claimer
+	policy                 *policy.Engine
 	config                 *azureConfig
 	oidcConfig             openIDConfiguration
 	keyStore               *keyStore
@@ -194,6 +201,24 @@ func (p *Azure) Init(config Config) (err error) {
 	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
 		return err
 	}
+	if err := p.X509.Init(); err != nil {
+		return errors.Wrap(err, "provisioner x509")
+	}
+	if p.x509Template, err = config.Templates.x509Template(p.X509); err != nil {
+		return errors.Wrap(err, "provisioner x509")
+	}
+	if err := p.SSH.Init(); err != nil {
+		return errors.Wrap(err, "provisioner ssh")
+	}
+	if p.sshTemplate, err = config.Templates.sshTemplate(p.SSH); err != nil {
+		return errors.Wrap(err, "provisioner ssh")
+	}
+
+	engine, err := policy.New(p.Policy)
+	if err != nil {
+		return errors.Wrap(err, "provisioner policy")
+	}
+	p.policy = engine
 
 	// Decode and validate openid-configuration endpoint
 	if err := getAndDecode(p.config.oidcDiscoveryURL, &p.oidcConfig); err != nil {
@@ -286,14 +311,16 @@ func (p *Azure) AuthorizeSign(ctx context.Context, token string) ([]SignOption,
 		so = append(so, dnsNamesValidator([]string{name}))
 	}
 
-	return append(so,
+	so = append(so,
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeAzure, p.Name, p.TenantID),
 		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
 		// validators
 		defaultPublicKeyValidator{},
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
-	), nil
+		newX509NamePolicyValidator(p.policy),
+	)
+	return p.x509Template.appendTemplateOption(so, token), nil
 }
 
 // AuthorizeRenew returns an error if the renewal is disabled.
@@ -304,6 +331,9 @@ func (p *Azure) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) erro
 	if p.claimer.IsDisableRenewal() {
 		return errs.Unauthorized("azure.AuthorizeRenew; renew is disabled for azure provisioner %s", p.GetID())
 	}
+	if !p.claimer.IsRenewable(cert) {
+		return errs.Unauthorized("azure.AuthorizeRenew; certificate expired on %s", cert.NotAfter.Format(time.RFC3339))
+	}
 	return nil
 }
 
@@ -338,7 +368,7 @@ func (p *Azure) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOptio
 	// Set defaults if not given as user options
 	signOptions = append(signOptions, sshCertDefaultsModifier(defaults))
 
-	return append(signOptions,
+	signOptions = append(signOptions,
 		// Set the default extensions.
 		&sshDefaultExtensionModifier{},
 		// Set the validity bounds if not set.
@@ -347,9 +377,12 @@ func (p *Azure) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOptio
 		&sshDefaultPublicKeyValidator{},
 		// Validate the validity period.
 		&sshCertValidityValidator{p.claimer},
+		// Validate the principals against the name policy.
+		&sshNamePolicyValidator{p.policy},
 		// Require all the fields in the SSH certificate
 		&sshCertDefaultValidator{},
-	), nil
+	)
+	return p.sshTemplate.appendTemplateOption(signOptions, token), nil
 }
 
 // assertConfig initializes the config if it has not been initialized
@@ -431,9 +431,9 @@ func TestAzure_AuthorizeSign(t *testing.T) {
 		code    int
 		wantErr bool
 	}{
-		{"ok", p1, args{t1}, 4, http.StatusOK, false},
-		{"ok", p2, args{t2}, 6, http.StatusOK, false},
-		{"ok", p1, args{t11}, 4, http.StatusOK, false},
+		{"ok", p1, args{t1}, 5, http.StatusOK, false},
+		{"ok", p2, args{t2}, 7, http.StatusOK, false},
+		{"ok", p1, args{t11}, 5, http.StatusOK, false},
 		{"fail tenant", p3, args{t3}, 0, http.StatusUnauthorized, true},
 		{"fail resource group", p4, args{t4}, 0, http.StatusUnauthorized, true},
 		{"fail token", p1, args{"token"}, 0, http.StatusUnauthorized, true},
@@ -473,6 +473,9 @@ func TestAzure_AuthorizeRenew(t *testing.T) {
 	p2.claimer, err = NewClaimer(p2.Claims, globalProvisionerClaims)
 	assert.FatalError(t, err)
 
+	cert := &x509.Certificate{NotAfter: time.Now().Add(time.Hour)}
+	expired := &x509.Certificate{NotAfter: time.Now().Add(-time.Hour)}
+
 	type args struct {
 		cert *x509.Certificate
 	}
@@ -483,8 +486,9 @@ func TestAzure_AuthorizeRenew(t *testing.T) {
 		code    int
 		wantErr bool
 	}{
-		{"ok", p1, args{nil}, http.StatusOK, false},
-		{"fail/renew-disabled", p2, args{nil}, http.StatusUnauthorized, true},
+		{"ok", p1, args{cert}, http.StatusOK, false},
+		{"fail/expired", p1, args{expired}, http.StatusUnauthorized, true},
+		{"fail/renew-disabled", p2, args{cert}, http.StatusUnauthorized, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
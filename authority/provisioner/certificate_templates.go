@@ -0,0 +1,131 @@
+package provisioner
+
+import (
+	"github.com/pkg/errors"
+)
+
+// CertificateTemplates contains the named X.509 and SSH certificate templates
+// that can be assigned to the provisioners using the templateName of their
+// x509 and ssh options. DefaultX509 and DefaultSSH are the names of the
+// templates used by the provisioners that do not configure one.
+type CertificateTemplates struct {
+	X509        map[string]*X509Options        `json:"x509,omitempty"`
+	SSH         map[string]*SSHTemplateOptions `json:"ssh,omitempty"`
+	DefaultX509 string                         `json:"defaultX509,omitempty"`
+	DefaultSSH  string                         `json:"defaultSSH,omitempty"`
+}
+
+// Init validates, loads and parses the templates.
+func (t *CertificateTemplates) Init() error {
+	if t == nil {
+		return nil
+	}
+	for name, o := range t.X509 {
+		switch {
+		case o == nil:
+			return errors.Errorf("x509 template %s cannot be empty", name)
+		case o.TemplateName != "":
+			return errors.Errorf("x509 template %s cannot have a templateName", name)
+		}
+		if err := o.Init(); err != nil {
+			return errors.Wrapf(err, "x509 template %s", name)
+		}
+	}
+	for name, o := range t.SSH {
+		switch {
+		case o == nil:
+			return errors.Errorf("ssh template %s cannot be empty", name)
+		case o.TemplateName != "":
+			return errors.Errorf("ssh template %s cannot have a templateName", name)
+		}
+		if err := o.Init(); err != nil {
+			return errors.Wrapf(err, "ssh template %s", name)
+		}
+	}
+	if _, ok := t.X509[t.DefaultX509]; t.DefaultX509 != "" && !ok {
+		return errors.Errorf("defaultX509 template %s is not defined", t.DefaultX509)
+	}
+	if _, ok := t.SSH[t.DefaultSSH]; t.DefaultSSH != "" && !ok {
+		return errors.Errorf("defaultSSH template %s is not defined", t.DefaultSSH)
+	}
+	return nil
+}
+
+// x509Template returns the X.509 options used to sign the certificates of a
+// provisioner with the given options. These are the given options if they
+// have a template, or the named template assigned to the provisioner, or the
+// default one. The options must be already initialized.
+func (t *CertificateTemplates) x509Template(o *X509Options) (*X509Options, error) {
+	var name string
+	switch {
+	case o != nil && o.TemplateName != "":
+		name = o.TemplateName
+	case o != nil && o.template != nil:
+		return o, nil
+	case t != nil:
+		name = t.DefaultX509
+	}
+	if name == "" {
+		return o, nil
+	}
+	if t == nil || t.X509[name] == nil {
+		return nil, errors.Errorf("x509 template %s is not defined", name)
+	}
+	named := *t.X509[name]
+	if o != nil {
+		named.TemplateData = mergeTemplateData(named.TemplateData, o.TemplateData)
+		if o.UserDataSchema != nil {
+			named.UserDataSchema = o.UserDataSchema
+		}
+		if o.Issuer != "" {
+			named.Issuer = o.Issuer
+		}
+	}
+	return &named, nil
+}
+
+// sshTemplate returns the SSH options used to sign the certificates of a
+// provisioner with the given options. These are the given options if they
+// have a template, or the named template assigned to the provisioner, or the
+// default one. The options must be already initialized.
+func (t *CertificateTemplates) sshTemplate(o *SSHTemplateOptions) (*SSHTemplateOptions, error) {
+	var name string
+	switch {
+	case o != nil && o.TemplateName != "":
+		name = o.TemplateName
+	case o != nil:
+		return o, nil
+	case t != nil:
+		name = t.DefaultSSH
+	}
+	if name == "" {
+		return o, nil
+	}
+	if t == nil || t.SSH[name] == nil {
+		return nil, errors.Errorf("ssh template %s is not defined", name)
+	}
+	named := *t.SSH[name]
+	if o != nil {
+		named.TemplateData = mergeTemplateData(named.TemplateData, o.TemplateData)
+		if o.UserDataSchema != nil {
+			named.UserDataSchema = o.UserDataSchema
+		}
+	}
+	return &named, nil
+}
+
+// mergeTemplateData returns the merge of the given template data, the values
+// in data override the ones in base.
+func mergeTemplateData(base, data map[string]interface{}) map[string]interface{} {
+	if len(data) == 0 {
+		return base
+	}
+	merged := make(map[string]interface{}, len(base)+len(data))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range data {
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,162 @@
+package provisioner
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+)
+
+func TestCertificateTemplates_Init(t *testing.T) {
+	tests := map[string]struct {
+		templates *CertificateTemplates
+		err       error
+	}{
+		"ok/nil":   {nil, nil},
+		"ok/empty": {&CertificateTemplates{}, nil},
+		"ok": {&CertificateTemplates{
+			X509:        map[string]*X509Options{"leaf": {Template: `{}`}},
+			SSH:         map[string]*SSHTemplateOptions{"user": {Template: `{}`}},
+			DefaultX509: "leaf",
+			DefaultSSH:  "user",
+		}, nil},
+		"fail/x509-nil": {&CertificateTemplates{
+			X509: map[string]*X509Options{"leaf": nil},
+		}, errors.New("x509 template leaf cannot be empty")},
+		"fail/x509-templateName": {&CertificateTemplates{
+			X509: map[string]*X509Options{"leaf": {TemplateName: "other"}},
+		}, errors.New("x509 template leaf cannot have a templateName")},
+		"fail/x509-init": {&CertificateTemplates{
+			X509: map[string]*X509Options{"leaf": {Template: `{{ .Subject`}},
+		}, errors.New("x509 template leaf: error parsing template")},
+		"fail/ssh-nil": {&CertificateTemplates{
+			SSH: map[string]*SSHTemplateOptions{"user": nil},
+		}, errors.New("ssh template user cannot be empty")},
+		"fail/ssh-templateName": {&CertificateTemplates{
+			SSH: map[string]*SSHTemplateOptions{"user": {TemplateName: "other"}},
+		}, errors.New("ssh template user cannot have a templateName")},
+		"fail/ssh-init": {&CertificateTemplates{
+			SSH: map[string]*SSHTemplateOptions{"user": {}},
+		}, errors.New("ssh template user: template or templateFile cannot be empty")},
+		"fail/defaultX509": {&CertificateTemplates{
+			X509:        map[string]*X509Options{"leaf": {Template: `{}`}},
+			DefaultX509: "missing",
+		}, errors.New("defaultX509 template missing is not defined")},
+		"fail/defaultSSH": {&CertificateTemplates{
+			SSH:        map[string]*SSHTemplateOptions{"user": {Template: `{}`}},
+			DefaultSSH: "missing",
+		}, errors.New("defaultSSH template missing is not defined")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.templates.Init()
+			if tc.err != nil {
+				if assert.NotNil(t, err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.FatalError(t, err)
+			}
+		})
+	}
+}
+
+func TestCertificateTemplates_x509Template(t *testing.T) {
+	schema := &TemplateDataSchema{Type: "object"}
+	templates := &CertificateTemplates{
+		X509: map[string]*X509Options{
+			"leaf":   {Template: `{}`, TemplateData: map[string]interface{}{"OU": "Engineering", "C": "US"}, Issuer: "ec"},
+			"client": {Template: `{}`},
+		},
+		DefaultX509: "client",
+	}
+	assert.FatalError(t, templates.Init())
+	own := &X509Options{Template: `{}`}
+	assert.FatalError(t, own.Init())
+	noDefault := &CertificateTemplates{X509: templates.X509}
+
+	tests := map[string]struct {
+		templates *CertificateTemplates
+		opts      *X509Options
+		want      *X509Options
+		err       error
+	}{
+		"ok/nil":        {nil, nil, nil, nil},
+		"ok/no-default": {noDefault, nil, nil, nil},
+		"ok/issuer":     {noDefault, &X509Options{Issuer: "rsa"}, &X509Options{Issuer: "rsa"}, nil},
+		"ok/own":        {templates, own, own, nil},
+		"ok/default":    {templates, nil, templates.X509["client"], nil},
+		"ok/default-issuer": {templates, &X509Options{Issuer: "rsa"}, &X509Options{
+			Template: `{}`, Issuer: "rsa", template: templates.X509["client"].template,
+		}, nil},
+		"ok/named": {templates, &X509Options{TemplateName: "leaf"}, templates.X509["leaf"], nil},
+		"ok/named-override": {templates, &X509Options{
+			TemplateName: "leaf", TemplateData: map[string]interface{}{"OU": "Sales"}, UserDataSchema: schema, Issuer: "rsa",
+		}, &X509Options{
+			Template: `{}`, TemplateData: map[string]interface{}{"OU": "Sales", "C": "US"}, UserDataSchema: schema,
+			Issuer: "rsa", template: templates.X509["leaf"].template,
+		}, nil},
+		"fail/nil":     {nil, &X509Options{TemplateName: "leaf"}, nil, errors.New("x509 template leaf is not defined")},
+		"fail/missing": {templates, &X509Options{TemplateName: "missing"}, nil, errors.New("x509 template missing is not defined")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.templates.x509Template(tc.opts)
+			if tc.err != nil {
+				if assert.NotNil(t, err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.FatalError(t, err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCertificateTemplates_sshTemplate(t *testing.T) {
+	templates := &CertificateTemplates{
+		SSH: map[string]*SSHTemplateOptions{
+			"user":  {Template: `{}`, TemplateData: map[string]interface{}{"Domain": "smallstep.com"}},
+			"admin": {Template: `{}`},
+		},
+		DefaultSSH: "user",
+	}
+	assert.FatalError(t, templates.Init())
+	own := &SSHTemplateOptions{Template: `{}`}
+	assert.FatalError(t, own.Init())
+
+	tests := map[string]struct {
+		templates *CertificateTemplates
+		opts      *SSHTemplateOptions
+		want      *SSHTemplateOptions
+		err       error
+	}{
+		"ok/nil":        {nil, nil, nil, nil},
+		"ok/no-default": {&CertificateTemplates{SSH: templates.SSH}, nil, nil, nil},
+		"ok/own":        {templates, own, own, nil},
+		"ok/default":    {templates, nil, templates.SSH["user"], nil},
+		"ok/named":      {templates, &SSHTemplateOptions{TemplateName: "admin"}, templates.SSH["admin"], nil},
+		"ok/named-override": {templates, &SSHTemplateOptions{
+			TemplateName: "user", TemplateData: map[string]interface{}{"Domain": "example.com"},
+		}, &SSHTemplateOptions{
+			Template: `{}`, TemplateData: map[string]interface{}{"Domain": "example.com"},
+			template: templates.SSH["user"].template,
+		}, nil},
+		"fail/nil":     {nil, &SSHTemplateOptions{TemplateName: "user"}, nil, errors.New("ssh template user is not defined")},
+		"fail/missing": {templates, &SSHTemplateOptions{TemplateName: "missing"}, nil, errors.New("ssh template missing is not defined")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.templates.sshTemplate(tc.opts)
+			if tc.err != nil {
+				if assert.NotNil(t, err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.FatalError(t, err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
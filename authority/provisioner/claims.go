@@ -1,6 +1,7 @@
 package provisioner
 
 import (
+	"crypto/x509"
 	"time"
 
 	"github.com/pkg/errors"
@@ -14,7 +15,11 @@ type Claims struct {
 	MaxTLSDur      *Duration `json:"maxTLSCertDuration,omitempty"`
 	DefaultTLSDur  *Duration `json:"defaultTLSCertDuration,omitempty"`
 	DisableRenewal *bool     `json:"disableRenewal,omitempty"`
-	// SSH CA properties
+	// RenewAfterExpiry is the time after the expiration of a certificate in
+	// which it can still be renewed.
+	RenewAfterExpiry *Duration `json:"renewAfterExpiry,omitempty"`
+	// SSH CA properties, these are configured independently from the TLS
+	// ones.
 	MinUserSSHDur     *Duration `json:"minUserSSHCertDuration,omitempty"`
 	MaxUserSSHDur     *Duration `json:"maxUserSSHCertDuration,omitempty"`
 	DefaultUserSSHDur *Duration `json:"defaultUserSSHCertDuration,omitempty"`
@@ -22,6 +27,9 @@ type Claims struct {
 	MaxHostSSHDur     *Duration `json:"maxHostSSHCertDuration,omitempty"`
 	DefaultHostSSHDur *Duration `json:"defaultHostSSHCertDuration,omitempty"`
 	EnableSSHCA       *bool     `json:"enableSSHCA,omitempty"`
+	// DisableSSHRenewal disables the renewal of SSH certificates. If it is not
+	// set, the value of DisableRenewal is used.
+	DisableSSHRenewal *bool `json:"disableSSHRenewal,omitempty"`
 }
 
 // Claimer is the type that controls claims. It provides an interface around the
@@ -41,11 +49,13 @@ func NewClaimer(claims *Claims, global Claims) (*Claimer, error) {
 func (c *Claimer) Claims() Claims {
 	disableRenewal := c.IsDisableRenewal()
 	enableSSHCA := c.IsSSHCAEnabled()
+	disableSSHRenewal := c.IsDisableSSHRenewal()
 	return Claims{
 		MinTLSDur:         &Duration{c.MinTLSCertDuration()},
 		MaxTLSDur:         &Duration{c.MaxTLSCertDuration()},
 		DefaultTLSDur:     &Duration{c.DefaultTLSCertDuration()},
 		DisableRenewal:    &disableRenewal,
+		RenewAfterExpiry:  &Duration{c.RenewAfterExpiry()},
 		MinUserSSHDur:     &Duration{c.MinUserSSHCertDuration()},
 		MaxUserSSHDur:     &Duration{c.MaxUserSSHCertDuration()},
 		DefaultUserSSHDur: &Duration{c.DefaultUserSSHCertDuration()},
@@ -53,6 +63,7 @@ func (c *Claimer) Claims() Claims {
 		MaxHostSSHDur:     &Duration{c.MaxHostSSHCertDuration()},
 		DefaultHostSSHDur: &Duration{c.DefaultHostSSHCertDuration()},
 		EnableSSHCA:       &enableSSHCA,
+		DisableSSHRenewal: &disableSSHRenewal,
 	}
 }
 
@@ -96,6 +107,27 @@ func (c *Claimer) IsDisableRenewal() bool {
 	return *c.claims.DisableRenewal
 }
 
+// RenewAfterExpiry returns the time after the expiration of a certificate in
+// which the certificate can still be renewed. If the property is not set
+// within the provisioner, then the global value from the authority
+// configuration will be used.
+func (c *Claimer) RenewAfterExpiry() time.Duration {
+	if c.claims == nil || c.claims.RenewAfterExpiry == nil {
+		if c.global.RenewAfterExpiry == nil {
+			return 0
+		}
+		return c.global.RenewAfterExpiry.Duration
+	}
+	return c.claims.RenewAfterExpiry.Duration
+}
+
+// IsRenewable returns if the given certificate can be renewed at the current
+// time, a certificate is renewable until its expiration plus the
+// RenewAfterExpiry grace period.
+func (c *Claimer) IsRenewable(cert *x509.Certificate) bool {
+	return !time.Now().After(cert.NotAfter.Add(c.RenewAfterExpiry()))
+}
+
 // DefaultSSHCertDuration returns the default SSH certificate duration for the
 // given certificate type.
 func (c *Claimer) DefaultSSHCertDuration(certType uint32) (time.Duration, error) {
@@ -181,12 +213,29 @@ func (c *Claimer) IsSSHCAEnabled() bool {
 	return *c.claims.EnableSSHCA
 }
 
+// IsDisableSSHRenewal returns if the renewal flow of SSH certificates is
+// disabled for the provisioner. If the property is not set within the
+// provisioner, then the global value from the authority configuration will be
+// used. If it is not set in either of them, the value of IsDisableRenewal is
+// returned.
+func (c *Claimer) IsDisableSSHRenewal() bool {
+	switch {
+	case c.claims != nil && c.claims.DisableSSHRenewal != nil:
+		return *c.claims.DisableSSHRenewal
+	case c.global.DisableSSHRenewal != nil:
+		return *c.global.DisableSSHRenewal
+	default:
+		return c.IsDisableRenewal()
+	}
+}
+
 // Validate validates and modifies the Claims with default values.
 func (c *Claimer) Validate() error {
 	var (
 		min = c.MinTLSCertDuration()
 		max = c.MaxTLSCertDuration()
 		def = c.DefaultTLSCertDuration()
+		rae = c.RenewAfterExpiry()
 	)
 	switch {
 	case min <= 0:
@@ -202,6 +251,38 @@ func (c *Claimer) Validate() error {
 		return errors.Errorf("claims: DefaultCertDuration cannot be less than MinCertDuration: DefaultCertDuration - %v, MinCertDuration - %v", def, min)
 	case max < def:
 		return errors.Errorf("claims: MaxCertDuration cannot be less than DefaultCertDuration: MaxCertDuration - %v, DefaultCertDuration - %v", max, def)
+	case rae < 0:
+		return errors.Errorf("claims: RenewAfterExpiry cannot be less than 0: RenewAfterExpiry - %v", rae)
+	}
+	// SSH durations are only validated if the global ones are configured.
+	g := c.global
+	if g.MinUserSSHDur != nil && g.MaxUserSSHDur != nil && g.DefaultUserSSHDur != nil {
+		if err := validateSSHDurations("User", c.MinUserSSHCertDuration(), c.MaxUserSSHCertDuration(), c.DefaultUserSSHCertDuration()); err != nil {
+			return err
+		}
+	}
+	if g.MinHostSSHDur != nil && g.MaxHostSSHDur != nil && g.DefaultHostSSHDur != nil {
+		return validateSSHDurations("Host", c.MinHostSSHCertDuration(), c.MaxHostSSHCertDuration(), c.DefaultHostSSHCertDuration())
+	}
+	return nil
+}
+
+// validateSSHDurations validates the minimum, maximum and default durations of
+// the SSH certificates of the given kind, User or Host.
+func validateSSHDurations(kind string, min, max, def time.Duration) error {
+	switch {
+	case min <= 0:
+		return errors.Errorf("claims: Min%sSSHCertDuration must be greater than 0", kind)
+	case max <= 0:
+		return errors.Errorf("claims: Max%sSSHCertDuration must be greater than 0", kind)
+	case def <= 0:
+		return errors.Errorf("claims: Default%sSSHCertDuration must be greater than 0", kind)
+	case max < min:
+		return errors.Errorf("claims: Max%[1]sSSHCertDuration cannot be less than Min%[1]sSSHCertDuration: Max%[1]sSSHCertDuration - %[2]v, Min%[1]sSSHCertDuration - %[3]v", kind, max, min)
+	case def < min:
+		return errors.Errorf("claims: Default%[1]sSSHCertDuration cannot be less than Min%[1]sSSHCertDuration: Default%[1]sSSHCertDuration - %[2]v, Min%[1]sSSHCertDuration - %[3]v", kind, def, min)
+	case max < def:
+		return errors.Errorf("claims: Max%[1]sSSHCertDuration cannot be less than Default%[1]sSSHCertDuration: Max%[1]sSSHCertDuration - %[2]v, Default%[1]sSSHCertDuration - %[3]v", kind, max, def)
 	default:
 		return nil
 	}
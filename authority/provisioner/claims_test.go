@@ -1,6 +1,7 @@
 package provisioner
 
 import (
+	"crypto/x509"
 	"testing"
 	"time"
 
@@ -49,3 +50,113 @@ func TestClaimer_DefaultSSHCertDuration(t *testing.T) {
 		})
 	}
 }
+
+func TestClaimer_IsRenewable(t *testing.T) {
+	grace := Duration{Duration: 72 * time.Hour}
+	globalGrace := globalProvisionerClaims
+	globalGrace.RenewAfterExpiry = &Duration{Duration: time.Hour}
+	now := time.Now()
+	type fields struct {
+		global Claims
+		claims *Claims
+	}
+	tests := []struct {
+		name   string
+		fields fields
+		cert   *x509.Certificate
+		want   bool
+	}{
+		{"ok", fields{globalProvisionerClaims, nil}, &x509.Certificate{NotAfter: now.Add(time.Minute)}, true},
+		{"ok renewAfterExpiry", fields{globalProvisionerClaims, &Claims{RenewAfterExpiry: &grace}}, &x509.Certificate{NotAfter: now.Add(-71 * time.Hour)}, true},
+		{"ok global renewAfterExpiry", fields{globalGrace, nil}, &x509.Certificate{NotAfter: now.Add(-time.Minute)}, true},
+		{"expired", fields{globalProvisionerClaims, nil}, &x509.Certificate{NotAfter: now.Add(-time.Minute)}, false},
+		{"expired renewAfterExpiry", fields{globalProvisionerClaims, &Claims{RenewAfterExpiry: &grace}}, &x509.Certificate{NotAfter: now.Add(-73 * time.Hour)}, false},
+		{"expired global renewAfterExpiry", fields{globalGrace, nil}, &x509.Certificate{NotAfter: now.Add(-2 * time.Hour)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Claimer{
+				global: tt.fields.global,
+				claims: tt.fields.claims,
+			}
+			if got := c.IsRenewable(tt.cert); got != tt.want {
+				t.Errorf("Claimer.IsRenewable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClaimer_Validate_renewAfterExpiry(t *testing.T) {
+	if _, err := NewClaimer(&Claims{RenewAfterExpiry: &Duration{Duration: 72 * time.Hour}}, globalProvisionerClaims); err != nil {
+		t.Errorf("NewClaimer() error = %v", err)
+	}
+	if _, err := NewClaimer(&Claims{RenewAfterExpiry: &Duration{Duration: -time.Hour}}, globalProvisionerClaims); err == nil {
+		t.Error("NewClaimer() error = nil, wants error")
+	}
+}
+
+func TestClaimer_IsDisableSSHRenewal(t *testing.T) {
+	yes, no := true, false
+	globalDisabled := globalProvisionerClaims
+	globalDisabled.DisableSSHRenewal = &yes
+	type fields struct {
+		global Claims
+		claims *Claims
+	}
+	tests := []struct {
+		name   string
+		fields fields
+		want   bool
+	}{
+		{"global", fields{globalProvisionerClaims, nil}, false},
+		{"global disabled", fields{globalDisabled, nil}, true},
+		{"disabled", fields{globalProvisionerClaims, &Claims{DisableSSHRenewal: &yes}}, true},
+		{"enabled", fields{globalDisabled, &Claims{DisableSSHRenewal: &no}}, false},
+		{"renewal disabled", fields{globalProvisionerClaims, &Claims{DisableRenewal: &yes}}, true},
+		{"renewal disabled ssh enabled", fields{globalProvisionerClaims, &Claims{DisableRenewal: &yes, DisableSSHRenewal: &no}}, false},
+		{"renewal enabled ssh disabled", fields{globalProvisionerClaims, &Claims{DisableRenewal: &no, DisableSSHRenewal: &yes}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Claimer{
+				global: tt.fields.global,
+				claims: tt.fields.claims,
+			}
+			if got := c.IsDisableSSHRenewal(); got != tt.want {
+				t.Errorf("Claimer.IsDisableSSHRenewal() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClaimer_Validate_sshDurations(t *testing.T) {
+	d := func(v time.Duration) *Duration {
+		return &Duration{Duration: v}
+	}
+	tests := []struct {
+		name    string
+		claims  *Claims
+		wantErr bool
+	}{
+		{"ok", nil, false},
+		{"ok user", &Claims{MinUserSSHDur: d(time.Minute), MaxUserSSHDur: d(time.Hour), DefaultUserSSHDur: d(time.Hour)}, false},
+		{"ok host", &Claims{MinHostSSHDur: d(time.Minute), MaxHostSSHDur: d(time.Hour), DefaultHostSSHDur: d(time.Minute)}, false},
+		{"ok tls independent", &Claims{MaxTLSDur: d(time.Hour), DefaultTLSDur: d(time.Hour), MaxUserSSHDur: d(48 * time.Hour), DefaultUserSSHDur: d(48 * time.Hour)}, false},
+		{"fail min user", &Claims{MinUserSSHDur: d(0)}, true},
+		{"fail max user", &Claims{MaxUserSSHDur: d(-time.Minute)}, true},
+		{"fail default user", &Claims{DefaultUserSSHDur: d(0)}, true},
+		{"fail max less than min user", &Claims{MinUserSSHDur: d(time.Hour), MaxUserSSHDur: d(time.Minute)}, true},
+		{"fail default less than min user", &Claims{MinUserSSHDur: d(time.Hour), DefaultUserSSHDur: d(time.Minute)}, true},
+		{"fail max less than default user", &Claims{MaxUserSSHDur: d(time.Hour), DefaultUserSSHDur: d(2 * time.Hour)}, true},
+		{"fail min host", &Claims{MinHostSSHDur: d(0)}, true},
+		{"fail max less than min host", &Claims{MinHostSSHDur: d(time.Hour), MaxHostSSHDur: d(time.Minute)}, true},
+		{"fail max less than default host", &Claims{MaxHostSSHDur: d(time.Hour), DefaultHostSSHDur: d(2 * time.Hour)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewClaimer(tt.claims, globalProvisionerClaims); (err != nil) != tt.wantErr {
+				t.Errorf("NewClaimer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -47,7 +47,9 @@ type Collection struct {
 	byID      *sync.Map
 	byKey     *sync.Map
 	sorted    provisionerSlice
+	counter   uint32
 	audiences Audiences
+	mutex     sync.RWMutex
 }
 
 // NewCollection initializes a collection of provisioners. The given list of
@@ -170,6 +172,9 @@ func (c *Collection) LoadEncryptedKey(keyID string) (string, bool) {
 // Store adds a provisioner to the collection and enforces the uniqueness of
 // provisioner IDs.
 func (c *Collection) Store(p Interface) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	// Store provisioner always in byID. ID must be unique.
 	if _, loaded := c.byID.LoadOrStore(p.GetID(), p); loaded {
 		return errors.New("cannot add multiple provisioners with the same id")
@@ -186,13 +191,36 @@ func (c *Collection) Store(p Interface) error {
 	// 0x00000000, 0x00000001, 0x00000002, ...
 	bi := make([]byte, 4)
 	sum := provisionerSum(p)
-	binary.BigEndian.PutUint32(bi, uint32(c.sorted.Len()))
+	binary.BigEndian.PutUint32(bi, c.counter)
 	sum[0], sum[1], sum[2], sum[3] = bi[0], bi[1], bi[2], bi[3]
 	c.sorted = append(c.sorted, uidProvisioner{
 		provisioner: p,
 		uid:         hex.EncodeToString(sum),
 	})
 	sort.Sort(c.sorted)
+	c.counter++
+	return nil
+}
+
+// Remove deletes the provisioner with the given id from the collection.
+func (c *Collection) Remove(id string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	p, ok := loadProvisioner(c.byID, id)
+	if !ok {
+		return errors.Errorf("provisioner %s not found", id)
+	}
+	c.byID.Delete(id)
+	if kid, _, ok := p.GetEncryptedKey(); ok {
+		c.byKey.Delete(kid)
+	}
+	for i, up := range c.sorted {
+		if up.provisioner.GetID() == id {
+			c.sorted = append(c.sorted[:i], c.sorted[i+1:]...)
+			break
+		}
+	}
 	return nil
 }
 
@@ -205,6 +233,9 @@ func (c *Collection) Find(cursor string, limit int) (List, string) {
 		limit = DefaultProvisionersMax
 	}
 
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
 	n := c.sorted.Len()
 	cursor = fmt.Sprintf("%040s", cursor)
 	i := sort.Search(n, func(i int) bool { return c.sorted[i].uid >= cursor })
@@ -414,3 +414,48 @@ func Test_stripPort(t *testing.T) {
 		})
 	}
 }
+
+func TestCollection_Remove(t *testing.T) {
+	c := NewCollection(testAudiences)
+	p1, err := generateJWK()
+	assert.FatalError(t, err)
+	p2, err := generateJWK()
+	assert.FatalError(t, err)
+	assert.FatalError(t, c.Store(p1))
+	assert.FatalError(t, c.Store(p2))
+
+	kid, _, ok := p1.GetEncryptedKey()
+	assert.Fatal(t, ok)
+
+	tests := []struct {
+		name    string
+		id      string
+		wantErr bool
+	}{
+		{"ok", p1.GetID(), false},
+		{"fail", p1.GetID(), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := c.Remove(tt.id); (err != nil) != tt.wantErr {
+				t.Errorf("Collection.Remove() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, ok := c.Load(p1.GetID()); ok {
+		t.Error("Collection.Load() found a removed provisioner")
+	}
+	if _, ok := c.LoadEncryptedKey(kid); ok {
+		t.Error("Collection.LoadEncryptedKey() found a removed provisioner")
+	}
+	if got, _ := c.Find("", 0); !reflect.DeepEqual(got, List{p2}) {
+		t.Errorf("Collection.Find() = %v, want %v", got, List{p2})
+	}
+
+	// A provisioner with the same id can be added again.
+	assert.FatalError(t, c.Store(p1))
+	if got, _ := c.Find("", 0); len(got) != 2 {
+		t.Errorf("Collection.Find() len = %d, want 2", len(got))
+	}
+}
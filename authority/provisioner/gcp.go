@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/errs"
 	"github.com/smallstep/cli/jose"
 )
@@ -72,19 +73,28 @@ func newGCPConfig() *gcpConfig {
 // If InstanceAge is set, only the instances with an instance_creation_timestamp
 // within the given period will be accepted.
 //
+// If ServiceAccounts is set, the token subject or its verified email must be
+// one of the given service accounts.
+//
 // Google Identity docs are available at
 // https://cloud.google.com/compute/docs/instances/verifying-instance-identity
 type GCP struct {
 	*base
-	Type                   string   `json:"type"`
-	Name                   string   `json:"name"`
-	ServiceAccounts        []string `json:"serviceAccounts"`
-	ProjectIDs             []string `json:"projectIDs"`
-	DisableCustomSANs      bool     `json:"disableCustomSANs"`
-	DisableTrustOnFirstUse bool     `json:"disableTrustOnFirstUse"`
-	InstanceAge            Duration `json:"instanceAge,omitempty"`
-	Claims                 *Claims  `json:"claims,omitempty"`
+	Type                   string              `json:"type"`
+	Name                   string              `json:"name"`
+	ServiceAccounts        []string            `json:"serviceAccounts"`
+	ProjectIDs             []string            `json:"projectIDs"`
+	DisableCustomSANs      bool                `json:"disableCustomSANs"`
+	DisableTrustOnFirstUse bool                `json:"disableTrustOnFirstUse"`
+	InstanceAge            Duration            `json:"instanceAge,omitempty"`
+	Claims                 *Claims             `json:"claims,omitempty"`
+	X509                   *X509Options        `json:"x509,omitempty"`
+	SSH                    *SSHTemplateOptions `json:"ssh,omitempty"`
+	Policy                 *policy.Options     `json:"policy,omitempty"`
+	x509Template           *X509Options
+	sshTemplate            *SSHTemplateOptions
 	claimer                *Claimer
+	policy                 *policy.Engine
 	config                 *gcpConfig
 	keyStore               *keyStore
 	audiences              Audiences
@@ -196,6 +206,25 @@ func (p *GCP) Init(config Config) error {
 	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
 		return err
 	}
+	if err := p.X509.Init(); err != nil {
+		return errors.Wrap(err, "provisioner x509")
+	}
+	if p.x509Template, err = config.Templates.x509Template(p.X509); err != nil {
+		return errors.Wrap(err, "provisioner x509")
+	}
+	if err := p.SSH.Init(); err != nil {
+		return errors.Wrap(err, "provisioner ssh")
+	}
+	if p.sshTemplate, err = config.Templates.sshTemplate(p.SSH); err != nil {
+		return errors.Wrap(err, "provisioner ssh")
+	}
+
+	engine, err := policy.New(p.Policy)
+	if err != nil {
+		return errors.Wrap(err, "provisioner policy")
+	}
+	p.policy = engine
+
 	// Initialize key store
 	p.keyStore, err = newKeyStore(p.config.CertsURL)
 	if err != nil {
@@ -230,14 +259,16 @@ func (p *GCP) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 		}))
 	}
 
-	return append(so,
+	so = append(so,
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeGCP, p.Name, claims.Subject, "InstanceID", ce.InstanceID, "InstanceName", ce.InstanceName),
 		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
 		// validators
 		defaultPublicKeyValidator{},
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
-	), nil
+		newX509NamePolicyValidator(p.policy),
+	)
+	return p.x509Template.appendTemplateOption(so, token), nil
 }
 
 // AuthorizeRenew returns an error if the renewal is disabled.
@@ -245,6 +276,9 @@ func (p *GCP) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) error
 	if p.claimer.IsDisableRenewal() {
 		return errs.Unauthorized("gcp.AuthorizeRenew; renew is disabled for gcp provisioner %s", p.GetID())
 	}
+	if !p.claimer.IsRenewable(cert) {
+		return errs.Unauthorized("gcp.AuthorizeRenew; certificate expired on %s", cert.NotAfter.Format(time.RFC3339))
+	}
 	return nil
 }
 
@@ -300,7 +334,7 @@ func (p *GCP) authorizeToken(token string) (*gcpPayload, error) {
 	if len(p.ServiceAccounts) > 0 {
 		var found bool
 		for _, sa := range p.ServiceAccounts {
-			if sa == claims.Subject || sa == claims.Email {
+			if sa == claims.Subject || (claims.EmailVerified && sa == claims.Email) {
 				found = true
 				break
 			}
@@ -381,7 +415,7 @@ func (p *GCP) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	// Set defaults if not given as user options
 	signOptions = append(signOptions, sshCertDefaultsModifier(defaults))
 
-	return append(signOptions,
+	signOptions = append(signOptions,
 		// Set the default extensions
 		&sshDefaultExtensionModifier{},
 		// Set the validity bounds if not set.
@@ -390,7 +424,10 @@ func (p *GCP) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 		&sshDefaultPublicKeyValidator{},
 		// Validate the validity period.
 		&sshCertValidityValidator{p.claimer},
+		// Validate the principals against the name policy.
+		&sshNamePolicyValidator{p.policy},
 		// Require all the fields in the SSH certificate
 		&sshCertDefaultValidator{},
-	), nil
+	)
+	return p.sshTemplate.appendTemplateOption(signOptions, token), nil
 }
@@ -279,6 +279,39 @@ func TestGCP_authorizeToken(t *testing.T) {
 				err:   errors.New("gcp.authorizeToken; invalid gcp token - invalid subject claim"),
 			}
 		},
+		"fail/unverified-email": func(t *testing.T) test {
+			p, err := generateGCP()
+			assert.FatalError(t, err)
+			p.ServiceAccounts = []string{"foo@developer.gserviceaccount.com"}
+			jwk := &p.keyStore.keySet.Keys[0]
+			sig, err := jose.NewSigner(
+				jose.SigningKey{Algorithm: jose.ES256, Key: jwk.Key},
+				new(jose.SignerOptions).WithType("JWT").WithHeader("kid", jwk.KeyID),
+			)
+			assert.FatalError(t, err)
+			aud, err := generateSignAudience("https://ca.smallstep.com", p.GetID())
+			assert.FatalError(t, err)
+			now := time.Now()
+			tok, err := jose.Signed(sig).Claims(gcpPayload{
+				Claims: jose.Claims{
+					Subject:   "foo",
+					Issuer:    "https://accounts.google.com",
+					IssuedAt:  jose.NewNumericDate(now),
+					NotBefore: jose.NewNumericDate(now),
+					Expiry:    jose.NewNumericDate(now.Add(5 * time.Minute)),
+					Audience:  []string{aud},
+				},
+				Email:         "foo@developer.gserviceaccount.com",
+				EmailVerified: false,
+			}).CompactSerialize()
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+				code:  http.StatusUnauthorized,
+				err:   errors.New("gcp.authorizeToken; invalid gcp token - invalid subject claim"),
+			}
+		},
 		"fail/invalid-projectID": func(t *testing.T) test {
 			p, err := generateGCP()
 			assert.FatalError(t, err)
@@ -515,9 +548,9 @@ func TestGCP_AuthorizeSign(t *testing.T) {
 		code    int
 		wantErr bool
 	}{
-		{"ok", p1, args{t1}, 4, http.StatusOK, false},
-		{"ok", p2, args{t2}, 6, http.StatusOK, false},
-		{"ok", p3, args{t3}, 4, http.StatusOK, false},
+		{"ok", p1, args{t1}, 5, http.StatusOK, false},
+		{"ok", p2, args{t2}, 7, http.StatusOK, false},
+		{"ok", p3, args{t3}, 5, http.StatusOK, false},
 		{"fail token", p1, args{"token"}, 0, http.StatusUnauthorized, true},
 		{"fail key", p1, args{failKey}, 0, http.StatusUnauthorized, true},
 		{"fail iss", p1, args{failIss}, 0, http.StatusUnauthorized, true},
@@ -681,6 +714,9 @@ func TestGCP_AuthorizeRenew(t *testing.T) {
 	p2.claimer, err = NewClaimer(p2.Claims, globalProvisionerClaims)
 	assert.FatalError(t, err)
 
+	cert := &x509.Certificate{NotAfter: time.Now().Add(time.Hour)}
+	expired := &x509.Certificate{NotAfter: time.Now().Add(-time.Hour)}
+
 	type args struct {
 		cert *x509.Certificate
 	}
@@ -691,8 +727,9 @@ func TestGCP_AuthorizeRenew(t *testing.T) {
 		code    int
 		wantErr bool
 	}{
-		{"ok", p1, args{nil}, http.StatusOK, false},
-		{"fail/renewal-disabled", p2, args{nil}, http.StatusUnauthorized, true},
+		{"ok", p1, args{cert}, http.StatusOK, false},
+		{"fail/expired", p1, args{expired}, http.StatusUnauthorized, true},
+		{"fail/renewal-disabled", p2, args{cert}, http.StatusUnauthorized, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/errs"
 	"github.com/smallstep/cli/crypto/x509util"
 	"github.com/smallstep/cli/jose"
@@ -27,12 +28,18 @@ type stepPayload struct {
 // signature requests.
 type JWK struct {
 	*base
-	Type         string           `json:"type"`
-	Name         string           `json:"name"`
-	Key          *jose.JSONWebKey `json:"key"`
-	EncryptedKey string           `json:"encryptedKey,omitempty"`
-	Claims       *Claims          `json:"claims,omitempty"`
+	Type         string              `json:"type"`
+	Name         string              `json:"name"`
+	Key          *jose.JSONWebKey    `json:"key"`
+	EncryptedKey string              `json:"encryptedKey,omitempty"`
+	Claims       *Claims             `json:"claims,omitempty"`
+	X509         *X509Options        `json:"x509,omitempty"`
+	SSH          *SSHTemplateOptions `json:"ssh,omitempty"`
+	Policy       *policy.Options     `json:"policy,omitempty"`
+	x509Template *X509Options
+	sshTemplate  *SSHTemplateOptions
 	claimer      *Claimer
+	policy       *policy.Engine
 	audiences    Audiences
 }
 
@@ -91,6 +98,25 @@ func (p *JWK) Init(config Config) (err error) {
 		return err
 	}
 
+	if err := p.X509.Init(); err != nil {
+		return errors.Wrap(err, "provisioner x509")
+	}
+	if p.x509Template, err = config.Templates.x509Template(p.X509); err != nil {
+		return errors.Wrap(err, "provisioner x509")
+	}
+	if err := p.SSH.Init(); err != nil {
+		return errors.Wrap(err, "provisioner ssh")
+	}
+	if p.sshTemplate, err = config.Templates.sshTemplate(p.SSH); err != nil {
+		return errors.Wrap(err, "provisioner ssh")
+	}
+
+	engine, err := policy.New(p.Policy)
+	if err != nil {
+		return errors.Wrap(err, "provisioner policy")
+	}
+	p.policy = engine
+
 	p.audiences = config.Audiences
 	return err
 }
@@ -153,7 +179,7 @@ func (p *JWK) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 	}
 
 	dnsNames, ips, emails := x509util.SplitSANs(claims.SANs)
-	return []SignOption{
+	so := []SignOption{
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeJWK, p.Name, p.Key.KeyID),
 		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
@@ -164,7 +190,9 @@ func (p *JWK) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 		emailAddressesValidator(emails),
 		ipAddressesValidator(ips),
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
-	}, nil
+		newX509NamePolicyValidator(p.policy),
+	}
+	return p.x509Template.appendTemplateOption(so, token), nil
 }
 
 // AuthorizeRenew returns an error if the renewal is disabled.
@@ -175,6 +203,9 @@ func (p *JWK) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) error
 	if p.claimer.IsDisableRenewal() {
 		return errs.Unauthorized("jwk.AuthorizeRenew; renew is disabled for jwk provisioner %s", p.GetID())
 	}
+	if !p.claimer.IsRenewable(cert) {
+		return errs.Unauthorized("jwk.AuthorizeRenew; certificate expired on %s", cert.NotAfter.Format(time.RFC3339))
+	}
 	return nil
 }
 
@@ -221,7 +252,7 @@ func (p *JWK) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	// Default to a user certificate with no principals if not set
 	signOptions = append(signOptions, sshCertDefaultsModifier{CertType: SSHUserCert})
 
-	return append(signOptions,
+	signOptions = append(signOptions,
 		// Set the default extensions.
 		&sshDefaultExtensionModifier{},
 		// Set the validity bounds if not set.
@@ -232,9 +263,12 @@ func (p *JWK) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 		&sshDefaultPublicKeyValidator{},
 		// Validate the validity period.
 		&sshCertValidityValidator{p.claimer},
+		// Validate the principals against the name policy.
+		&sshNamePolicyValidator{p.policy},
 		// Require and validate all the default fields in the SSH certificate.
 		&sshCertDefaultValidator{},
-	), nil
+	)
+	return p.sshTemplate.appendTemplateOption(signOptions, token), nil
 }
 
 // AuthorizeSSHRevoke returns nil if the token is valid, false otherwise.
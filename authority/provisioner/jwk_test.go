@@ -14,6 +14,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/errs"
 	"github.com/smallstep/cli/jose"
 )
@@ -81,6 +82,14 @@ func TestJWK_Init(t *testing.T) {
 				err: errors.New("claims: DefaultTLSCertDuration must be greater than 0"),
 			}
 		},
+		"fail-bad-policy": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p: &JWK{Name: "foo", Type: "bar", Key: &jose.JSONWebKey{}, audiences: testAudiences, Policy: &policy.Options{
+					X509: &policy.X509Options{Allow: &policy.X509NameOptions{IPRanges: []string{"foo"}}},
+				}},
+				err: errors.New("provisioner policy: x509: allow: ip: ip address foo is not valid"),
+			}
+		},
 		"ok": func(t *testing.T) ProvisionerValidateTest {
 			return ProvisionerValidateTest{
 				p: &JWK{Name: "foo", Type: "bar", Key: &jose.JSONWebKey{}, audiences: testAudiences},
@@ -278,7 +287,7 @@ func TestJWK_AuthorizeSign(t *testing.T) {
 				}
 			} else {
 				if assert.NotNil(t, got) {
-					assert.Len(t, 8, got)
+					assert.Len(t, 9, got)
 					for _, o := range got {
 						switch v := o.(type) {
 						case *provisionerExtensionOption:
@@ -300,6 +309,7 @@ func TestJWK_AuthorizeSign(t *testing.T) {
 						case *validityValidator:
 							assert.Equals(t, v.min, tt.prov.claimer.MinTLSCertDuration())
 							assert.Equals(t, v.max, tt.prov.claimer.MaxTLSCertDuration())
+						case *x509NamePolicyValidator:
 						default:
 							assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
 						}
@@ -322,6 +332,16 @@ func TestJWK_AuthorizeRenew(t *testing.T) {
 	p2.claimer, err = NewClaimer(p2.Claims, globalProvisionerClaims)
 	assert.FatalError(t, err)
 
+	// allow renewals after expiry
+	p3, err := generateJWK()
+	assert.FatalError(t, err)
+	p3.Claims = &Claims{RenewAfterExpiry: &Duration{72 * time.Hour}}
+	p3.claimer, err = NewClaimer(p3.Claims, globalProvisionerClaims)
+	assert.FatalError(t, err)
+
+	cert := &x509.Certificate{NotAfter: time.Now().Add(time.Hour)}
+	expired := &x509.Certificate{NotAfter: time.Now().Add(-time.Hour)}
+
 	type args struct {
 		cert *x509.Certificate
 	}
@@ -332,8 +352,11 @@ func TestJWK_AuthorizeRenew(t *testing.T) {
 		code    int
 		wantErr bool
 	}{
-		{"ok", p1, args{nil}, http.StatusOK, false},
-		{"fail/renew-disabled", p2, args{nil}, http.StatusUnauthorized, true},
+		{"ok", p1, args{cert}, http.StatusOK, false},
+		{"ok/renew-after-expiry", p3, args{expired}, http.StatusOK, false},
+		{"fail/expired", p1, args{expired}, http.StatusUnauthorized, true},
+		{"fail/renew-after-expiry", p3, args{&x509.Certificate{NotAfter: time.Now().Add(-73 * time.Hour)}}, http.StatusUnauthorized, true},
+		{"fail/renew-disabled", p2, args{cert}, http.StatusUnauthorized, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -7,8 +7,10 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"net/http"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/errs"
 	"github.com/smallstep/cli/crypto/pemutil"
 	"github.com/smallstep/cli/jose"
@@ -40,12 +42,18 @@ type k8sSAPayload struct {
 // entity trusted to make signature requests.
 type K8sSA struct {
 	*base
-	Type      string  `json:"type"`
-	Name      string  `json:"name"`
-	Claims    *Claims `json:"claims,omitempty"`
-	PubKeys   []byte  `json:"publicKeys,omitempty"`
-	claimer   *Claimer
-	audiences Audiences
+	Type         string              `json:"type"`
+	Name         string              `json:"name"`
+	Claims       *Claims             `json:"claims,omitempty"`
+	X509         *X509Options        `json:"x509,omitempty"`
+	SSH          *SSHTemplateOptions `json:"ssh,omitempty"`
+	Policy       *policy.Options     `json:"policy,omitempty"`
+	PubKeys      []byte              `json:"publicKeys,omitempty"`
+	x509Template *X509Options
+	sshTemplate  *SSHTemplateOptions
+	claimer      *Claimer
+	policy       *policy.Engine
+	audiences    Audiences
 	//kauthn    kauthn.AuthenticationV1Interface
 	pubKeys []interface{}
 }
@@ -130,6 +138,25 @@ func (p *K8sSA) Init(config Config) (err error) {
 		return err
 	}
 
+	if err := p.X509.Init(); err != nil {
+		return errors.Wrap(err, "provisioner x509")
+	}
+	if p.x509Template, err = config.Templates.x509Template(p.X509); err != nil {
+		return errors.Wrap(err, "provisioner x509")
+	}
+	if err := p.SSH.Init(); err != nil {
+		return errors.Wrap(err, "provisioner ssh")
+	}
+	if p.sshTemplate, err = config.Templates.sshTemplate(p.SSH); err != nil {
+		return errors.Wrap(err, "provisioner ssh")
+	}
+
+	engine, err := policy.New(p.Policy)
+	if err != nil {
+		return errors.Wrap(err, "provisioner policy")
+	}
+	p.policy = engine
+
 	p.audiences = config.Audiences
 	return err
 }
@@ -208,14 +235,16 @@ func (p *K8sSA) AuthorizeSign(ctx context.Context, token string) ([]SignOption,
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "k8ssa.AuthorizeSign")
 	}
 
-	return []SignOption{
+	so := []SignOption{
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeK8sSA, p.Name, ""),
 		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
 		// validators
 		defaultPublicKeyValidator{},
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
-	}, nil
+		newX509NamePolicyValidator(p.policy),
+	}
+	return p.x509Template.appendTemplateOption(so, token), nil
 }
 
 // AuthorizeRenew returns an error if the renewal is disabled.
@@ -223,6 +252,9 @@ func (p *K8sSA) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) erro
 	if p.claimer.IsDisableRenewal() {
 		return errs.Unauthorized("k8ssa.AuthorizeRenew; renew is disabled for k8sSA provisioner %s", p.GetID())
 	}
+	if !p.claimer.IsRenewable(cert) {
+		return errs.Unauthorized("k8ssa.AuthorizeRenew; certificate expired on %s", cert.NotAfter.Format(time.RFC3339))
+	}
 	return nil
 }
 
@@ -238,7 +270,7 @@ func (p *K8sSA) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOptio
 	// Default to a user certificate with no principals if not set
 	signOptions := []SignOption{sshCertDefaultsModifier{CertType: SSHUserCert}}
 
-	return append(signOptions,
+	signOptions = append(signOptions,
 		// Set the default extensions.
 		&sshDefaultExtensionModifier{},
 		// Set the validity bounds if not set.
@@ -247,9 +279,12 @@ func (p *K8sSA) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOptio
 		&sshDefaultPublicKeyValidator{},
 		// Validate the validity period.
 		&sshCertValidityValidator{p.claimer},
+		// Validate the principals against the name policy.
+		&sshNamePolicyValidator{p.policy},
 		// Require and validate all the default fields in the SSH certificate.
 		&sshCertDefaultValidator{},
-	), nil
+	)
+	return p.sshTemplate.appendTemplateOption(signOptions, token), nil
 }
 
 /*
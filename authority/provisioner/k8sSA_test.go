@@ -196,17 +196,27 @@ func TestK8sSA_AuthorizeRenew(t *testing.T) {
 			assert.FatalError(t, err)
 			return test{
 				p:    p,
-				cert: &x509.Certificate{},
+				cert: &x509.Certificate{NotAfter: time.Now().Add(time.Hour)},
 				code: http.StatusUnauthorized,
 				err:  errors.Errorf("k8ssa.AuthorizeRenew; renew is disabled for k8sSA provisioner %s", p.GetID()),
 			}
 		},
+		"fail/expired": func(t *testing.T) test {
+			p, err := generateK8sSA(nil)
+			assert.FatalError(t, err)
+			return test{
+				p:    p,
+				cert: &x509.Certificate{NotAfter: time.Now().Add(-time.Hour)},
+				code: http.StatusUnauthorized,
+				err:  errors.New("k8ssa.AuthorizeRenew; certificate expired on"),
+			}
+		},
 		"ok": func(t *testing.T) test {
 			p, err := generateK8sSA(nil)
 			assert.FatalError(t, err)
 			return test{
 				p:    p,
-				cert: &x509.Certificate{},
+				cert: &x509.Certificate{NotAfter: time.Now().Add(time.Hour)},
 			}
 		},
 	}
@@ -285,12 +295,13 @@ func TestK8sSA_AuthorizeSign(t *testing.T) {
 							case *validityValidator:
 								assert.Equals(t, v.min, tc.p.claimer.MinTLSCertDuration())
 								assert.Equals(t, v.max, tc.p.claimer.MaxTLSCertDuration())
+							case *x509NamePolicyValidator:
 							default:
 								assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
 							}
 							tot++
 						}
-						assert.Equals(t, tot, 4)
+						assert.Equals(t, tot, 5)
 					}
 				}
 			}
@@ -369,12 +380,13 @@ func TestK8sSA_AuthorizeSSHSign(t *testing.T) {
 							case *sshCertDefaultValidator:
 							case *sshDefaultDuration:
 								assert.Equals(t, v.Claimer, tc.p.claimer)
+							case *sshNamePolicyValidator:
 							default:
 								assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
 							}
 							tot++
 						}
-						assert.Equals(t, tot, 6)
+						assert.Equals(t, tot, 7)
 					}
 				}
 			}
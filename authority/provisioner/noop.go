@@ -3,7 +3,9 @@ package provisioner
 import (
 	"context"
 	"crypto/x509"
+	"time"
 
+	"github.com/smallstep/certificates/errs"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -37,7 +39,12 @@ func (p *noop) AuthorizeSign(ctx context.Context, token string) ([]SignOption, e
 	return []SignOption{}, nil
 }
 
+// AuthorizeRenew accepts the renewal of any certificate that has not expired,
+// without a provisioner there is no renewAfterExpiry grace period.
 func (p *noop) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) error {
+	if time.Now().After(cert.NotAfter) {
+		return errs.Unauthorized("noop.AuthorizeRenew; certificate expired on %s", cert.NotAfter.Format(time.RFC3339))
+	}
 	return nil
 }
 
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/x509"
 	"testing"
+	"time"
 
 	"github.com/smallstep/assert"
 )
@@ -14,7 +15,8 @@ func Test_noop(t *testing.T) {
 	assert.Equals(t, "noop", p.GetName())
 	assert.Equals(t, noopType, p.GetType())
 	assert.Equals(t, nil, p.Init(Config{}))
-	assert.Equals(t, nil, p.AuthorizeRenew(context.Background(), &x509.Certificate{}))
+	assert.Equals(t, nil, p.AuthorizeRenew(context.Background(), &x509.Certificate{NotAfter: time.Now().Add(time.Hour)}))
+	assert.NotNil(t, p.AuthorizeRenew(context.Background(), &x509.Certificate{NotAfter: time.Now().Add(-time.Minute)}))
 	assert.Equals(t, nil, p.AuthorizeRevoke(context.Background(), "foo"))
 
 	kid, key, ok := p.GetEncryptedKey()
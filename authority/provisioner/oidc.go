@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/errs"
 	"github.com/smallstep/cli/jose"
 )
@@ -50,22 +51,33 @@ type openIDPayload struct {
 // OIDC represents an OAuth 2.0 OpenID Connect provider.
 //
 // ClientSecret is mandatory, but it can be an empty string.
+//
+// GroupPrincipals adds the groups in the groups claim of the token to the
+// principals of the SSH user certificates. If Groups is set only the allowed
+// groups are added, and groups that are not valid usernames are ignored.
 type OIDC struct {
 	*base
-	Type                  string   `json:"type"`
-	Name                  string   `json:"name"`
-	ClientID              string   `json:"clientID"`
-	ClientSecret          string   `json:"clientSecret"`
-	ConfigurationEndpoint string   `json:"configurationEndpoint"`
-	TenantID              string   `json:"tenantID,omitempty"`
-	Admins                []string `json:"admins,omitempty"`
-	Domains               []string `json:"domains,omitempty"`
-	Groups                []string `json:"groups,omitempty"`
-	ListenAddress         string   `json:"listenAddress,omitempty"`
-	Claims                *Claims  `json:"claims,omitempty"`
+	Type                  string              `json:"type"`
+	Name                  string              `json:"name"`
+	ClientID              string              `json:"clientID"`
+	ClientSecret          string              `json:"clientSecret"`
+	ConfigurationEndpoint string              `json:"configurationEndpoint"`
+	TenantID              string              `json:"tenantID,omitempty"`
+	Admins                []string            `json:"admins,omitempty"`
+	Domains               []string            `json:"domains,omitempty"`
+	Groups                []string            `json:"groups,omitempty"`
+	GroupPrincipals       bool                `json:"groupPrincipals,omitempty"`
+	ListenAddress         string              `json:"listenAddress,omitempty"`
+	Claims                *Claims             `json:"claims,omitempty"`
+	X509                  *X509Options        `json:"x509,omitempty"`
+	SSH                   *SSHTemplateOptions `json:"ssh,omitempty"`
+	Policy                *policy.Options     `json:"policy,omitempty"`
 	configuration         openIDConfiguration
 	keyStore              *keyStore
+	x509Template          *X509Options
+	sshTemplate           *SSHTemplateOptions
 	claimer               *Claimer
+	policy                *policy.Engine
 	getIdentityFunc       GetIdentityFunc
 }
 
@@ -152,6 +164,24 @@ func (o *OIDC) Init(config Config) (err error) {
 	if o.claimer, err = NewClaimer(o.Claims, config.Claims); err != nil {
 		return err
 	}
+	if err := o.X509.Init(); err != nil {
+		return errors.Wrap(err, "provisioner x509")
+	}
+	if o.x509Template, err = config.Templates.x509Template(o.X509); err != nil {
+		return errors.Wrap(err, "provisioner x509")
+	}
+	if err := o.SSH.Init(); err != nil {
+		return errors.Wrap(err, "provisioner ssh")
+	}
+	if o.sshTemplate, err = config.Templates.sshTemplate(o.SSH); err != nil {
+		return errors.Wrap(err, "provisioner ssh")
+	}
+
+	engine, err := policy.New(o.Policy)
+	if err != nil {
+		return errors.Wrap(err, "provisioner policy")
+	}
+	o.policy = engine
 
 	// Decode and validate openid-configuration endpoint
 	u, err := url.Parse(o.ConfigurationEndpoint)
@@ -308,7 +338,9 @@ func (o *OIDC) AuthorizeSign(ctx context.Context, token string) ([]SignOption, e
 		// validators
 		defaultPublicKeyValidator{},
 		newValidityValidator(o.claimer.MinTLSCertDuration(), o.claimer.MaxTLSCertDuration()),
+		newX509NamePolicyValidator(o.policy),
 	}
+	so = o.x509Template.appendTemplateOption(so, token)
 	// Admins should be able to authorize any SAN
 	if o.IsAdmin(claims.Email) {
 		return so, nil
@@ -325,6 +357,9 @@ func (o *OIDC) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) error
 	if o.claimer.IsDisableRenewal() {
 		return errs.Unauthorized("oidc.AuthorizeRenew; renew is disabled for oidc provisioner %s", o.GetID())
 	}
+	if !o.claimer.IsRenewable(cert) {
+		return errs.Unauthorized("oidc.AuthorizeRenew; certificate expired on %s", cert.NotAfter.Format(time.RFC3339))
+	}
 	return nil
 }
 
@@ -352,6 +387,9 @@ func (o *OIDC) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption
 		CertType:   SSHUserCert,
 		Principals: iden.Usernames,
 	}
+	if o.GroupPrincipals {
+		defaults.Principals = append(defaults.Principals, o.groupPrincipals(claims.Groups, defaults.Principals)...)
+	}
 
 	// Admin users can use any principal, and can sign user and host certificates.
 	// Non-admin users can only use principals returned by the identityFunc, and
@@ -364,7 +402,7 @@ func (o *OIDC) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption
 	// are not set.
 	signOptions = append(signOptions, sshCertDefaultsModifier(defaults))
 
-	return append(signOptions,
+	signOptions = append(signOptions,
 		// Set the default extensions
 		&sshDefaultExtensionModifier{},
 		// Set the validity bounds if not set.
@@ -373,9 +411,12 @@ func (o *OIDC) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption
 		&sshDefaultPublicKeyValidator{},
 		// Validate the validity period.
 		&sshCertValidityValidator{o.claimer},
+		// Validate the principals against the name policy.
+		&sshNamePolicyValidator{o.policy},
 		// Require all the fields in the SSH certificate
 		&sshCertDefaultValidator{},
-	), nil
+	)
+	return o.sshTemplate.appendTemplateOption(signOptions, token), nil
 }
 
 // AuthorizeSSHRevoke returns nil if the token is valid, false otherwise.
@@ -392,6 +433,27 @@ func (o *OIDC) AuthorizeSSHRevoke(ctx context.Context, token string) error {
 	return nil
 }
 
+// groupPrincipals returns the given groups that can be used as principals of
+// an SSH user certificate and are not in the given principals.
+func (o *OIDC) groupPrincipals(groups, principals []string) []string {
+	seen := make(map[string]bool, len(principals))
+	for _, p := range principals {
+		seen[p] = true
+	}
+	var ret []string
+	for _, g := range groups {
+		if seen[g] || !sshUserRegex.MatchString(g) {
+			continue
+		}
+		if len(o.Groups) > 0 && !containsAllMembers(o.Groups, []string{g}) {
+			continue
+		}
+		seen[g] = true
+		ret = append(ret, g)
+	}
+	return ret
+}
+
 func getAndDecode(uri string, v interface{}) error {
 	resp, err := http.Get(uri)
 	if err != nil {
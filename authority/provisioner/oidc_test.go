@@ -323,9 +323,9 @@ func TestOIDC_AuthorizeSign(t *testing.T) {
 			} else {
 				if assert.NotNil(t, got) {
 					if tt.name == "admin" {
-						assert.Len(t, 4, got)
-					} else {
 						assert.Len(t, 5, got)
+					} else {
+						assert.Len(t, 6, got)
 					}
 					for _, o := range got {
 						switch v := o.(type) {
@@ -342,6 +342,7 @@ func TestOIDC_AuthorizeSign(t *testing.T) {
 							assert.Equals(t, v.max, tt.prov.claimer.MaxTLSCertDuration())
 						case emailOnlyIdentity:
 							assert.Equals(t, string(v), "name@smallstep.com")
+						case *x509NamePolicyValidator:
 						default:
 							assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
 						}
@@ -426,6 +427,9 @@ func TestOIDC_AuthorizeRenew(t *testing.T) {
 	p2.claimer, err = NewClaimer(p2.Claims, globalProvisionerClaims)
 	assert.FatalError(t, err)
 
+	cert := &x509.Certificate{NotAfter: time.Now().Add(time.Hour)}
+	expired := &x509.Certificate{NotAfter: time.Now().Add(-time.Hour)}
+
 	type args struct {
 		cert *x509.Certificate
 	}
@@ -436,8 +440,9 @@ func TestOIDC_AuthorizeRenew(t *testing.T) {
 		code    int
 		wantErr bool
 	}{
-		{"ok", p1, args{nil}, http.StatusOK, false},
-		{"fail/renew-disabled", p2, args{nil}, http.StatusUnauthorized, true},
+		{"ok", p1, args{cert}, http.StatusOK, false},
+		{"fail/expired", p1, args{expired}, http.StatusUnauthorized, true},
+		{"fail/renew-disabled", p2, args{cert}, http.StatusUnauthorized, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -694,3 +699,71 @@ func Test_sanitizeEmail(t *testing.T) {
 		})
 	}
 }
+
+func TestOIDC_groupPrincipals(t *testing.T) {
+	tests := []struct {
+		name       string
+		allowed    []string
+		groups     []string
+		principals []string
+		want       []string
+	}{
+		{"ok", nil, []string{"admins", "dev-ops"}, []string{"name"}, []string{"admins", "dev-ops"}},
+		{"ok/allowed", []string{"admins", "other"}, []string{"admins", "dev-ops"}, nil, []string{"admins"}},
+		{"ok/invalid", nil, []string{"Admins", "dev ops", "1st", "dev"}, nil, []string{"dev"}},
+		{"ok/duplicates", nil, []string{"name", "dev", "dev"}, []string{"name"}, []string{"dev"}},
+		{"ok/empty", nil, nil, []string{"name"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &OIDC{Groups: tt.allowed}
+			assert.Equals(t, tt.want, o.groupPrincipals(tt.groups, tt.principals))
+		})
+	}
+}
+
+func TestOIDC_AuthorizeSSHSign_groupPrincipals(t *testing.T) {
+	srv := generateJWKServer(2)
+	defer srv.Close()
+
+	var keys jose.JSONWebKeySet
+	assert.FatalError(t, getAndDecode(srv.URL+"/private", &keys))
+
+	p, err := generateOIDC()
+	assert.FatalError(t, err)
+	p.ConfigurationEndpoint = srv.URL + "/.well-known/openid-configuration"
+	p.GroupPrincipals = true
+	assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims}))
+
+	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: keys.Keys[0].Key},
+		new(jose.SignerOptions).WithType("JWT").WithHeader("kid", keys.Keys[0].KeyID))
+	assert.FatalError(t, err)
+	now := time.Now()
+	token, err := jose.Signed(sig).Claims(openIDPayload{
+		Claims: jose.Claims{
+			Subject:   "subject",
+			Issuer:    "the-issuer",
+			Audience:  []string{p.ClientID},
+			IssuedAt:  jose.NewNumericDate(now),
+			NotBefore: jose.NewNumericDate(now),
+			Expiry:    jose.NewNumericDate(now.Add(5 * time.Minute)),
+		},
+		Email:  "name@smallstep.com",
+		Groups: []string{"admins", "Invalid Group"},
+	}).CompactSerialize()
+	assert.FatalError(t, err)
+
+	so, err := p.AuthorizeSSHSign(context.Background(), token)
+	assert.FatalError(t, err)
+	var found bool
+	for _, o := range so {
+		switch v := o.(type) {
+		case sshCertOptionsValidator:
+			assert.Equals(t, []string{"name", "name@smallstep.com", "admins"}, v.Principals)
+			found = true
+		case sshCertDefaultsModifier:
+			assert.Equals(t, []string{"name", "name@smallstep.com", "admins"}, v.Principals)
+		}
+	}
+	assert.True(t, found)
+}
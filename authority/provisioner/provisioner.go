@@ -189,6 +189,9 @@ type Config struct {
 	// GetIdentityFunc is a function that returns an identity that will be
 	// used by the provisioner to populate certificate attributes.
 	GetIdentityFunc GetIdentityFunc
+	// Templates are the named certificate templates that can be assigned to
+	// the provisioners.
+	Templates *CertificateTemplates
 }
 
 type provisioner struct {
@@ -208,51 +211,81 @@ func (l *List) UnmarshalJSON(data []byte) error {
 
 	*l = List{}
 	for _, data := range ps {
-		var typ provisioner
-		if err := json.Unmarshal(data, &typ); err != nil {
-			return errors.Errorf("error unmarshaling provisioner")
+		p, err := unmarshalProvisioner(data)
+		if err != nil {
+			return err
 		}
-		var p Interface
-		switch strings.ToLower(typ.Type) {
-		case "jwk":
-			p = &JWK{}
-		case "oidc":
-			p = &OIDC{}
-		case "gcp":
-			p = &GCP{}
-		case "aws":
-			p = &AWS{}
-		case "azure":
-			p = &Azure{}
-		case "acme":
-			p = &ACME{}
-		case "x5c":
-			p = &X5C{}
-		case "k8ssa":
-			p = &K8sSA{}
-		case "sshpop":
-			p = &SSHPOP{}
-		default:
-			// Skip unsupported provisioners. A client using this method may be
-			// compiled with a version of smallstep/certificates that does not
-			// support a specific provisioner type. If we don't skip unknown
-			// provisioners, a client encountering an unknown provisioner will
-			// break. Rather than break the client, we skip the provisioner.
-			// TODO: accept a pluggable logger (depending on client) that can
-			// warn the user that an unknown provisioner was found and suggest
-			// that the user update their client's dependency on
-			// step/certificates and recompile.
+		// Skip unsupported provisioners. A client using this method may be
+		// compiled with a version of smallstep/certificates that does not
+		// support a specific provisioner type. If we don't skip unknown
+		// provisioners, a client encountering an unknown provisioner will
+		// break. Rather than break the client, we skip the provisioner.
+		// TODO: accept a pluggable logger (depending on client) that can
+		// warn the user that an unknown provisioner was found and suggest
+		// that the user update their client's dependency on
+		// step/certificates and recompile.
+		if p == nil {
 			continue
 		}
-		if err := json.Unmarshal(data, p); err != nil {
-			return errors.Errorf("error unmarshaling provisioner")
-		}
 		*l = append(*l, p)
 	}
 
 	return nil
 }
 
+// UnmarshalProvisioner unmarshals the JSON representation of a single
+// provisioner into the right type.
+func UnmarshalProvisioner(data []byte) (Interface, error) {
+	var typ provisioner
+	if err := json.Unmarshal(data, &typ); err != nil {
+		return nil, errors.Errorf("error unmarshaling provisioner")
+	}
+	p, err := unmarshalProvisioner(data)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, errors.Errorf("unsupported provisioner type %q", typ.Type)
+	}
+	return p, nil
+}
+
+// unmarshalProvisioner unmarshals a provisioner into the right type, it
+// returns a nil provisioner if the type is not supported.
+func unmarshalProvisioner(data []byte) (Interface, error) {
+	var typ provisioner
+	if err := json.Unmarshal(data, &typ); err != nil {
+		return nil, errors.Errorf("error unmarshaling provisioner")
+	}
+	var p Interface
+	switch strings.ToLower(typ.Type) {
+	case "jwk":
+		p = &JWK{}
+	case "oidc":
+		p = &OIDC{}
+	case "gcp":
+		p = &GCP{}
+	case "aws":
+		p = &AWS{}
+	case "azure":
+		p = &Azure{}
+	case "acme":
+		p = &ACME{}
+	case "x5c":
+		p = &X5C{}
+	case "k8ssa":
+		p = &K8sSA{}
+	case "sshpop":
+		p = &SSHPOP{}
+	default:
+		return nil, nil
+	}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, errors.Errorf("error unmarshaling provisioner")
+	}
+	return p, nil
+}
+
 var sshUserRegex = regexp.MustCompile("^[a-z][-a-z0-9_]*$")
 
 // SanitizeSSHUserPrincipal grabs an email or a string with the format
@@ -3,6 +3,7 @@ package provisioner
 import (
 	"context"
 	"net/http"
+	"reflect"
 	"testing"
 
 	"github.com/pkg/errors"
@@ -195,3 +196,30 @@ func TestUnimplementedMethods(t *testing.T) {
 		})
 	}
 }
+
+func TestUnmarshalProvisioner(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    Interface
+		wantErr bool
+	}{
+		{"ok/acme", `{"type":"ACME","name":"acme"}`, &ACME{Type: "ACME", Name: "acme"}, false},
+		{"ok/sshpop", `{"type":"sshpop","name":"sshpop"}`, &SSHPOP{Type: "sshpop", Name: "sshpop"}, false},
+		{"fail/json", `{`, nil, true},
+		{"fail/type", `{"type":"foo","name":"foo"}`, nil, true},
+		{"fail/value", `{"type":"ACME","name":1}`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := UnmarshalProvisioner([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("UnmarshalProvisioner() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("UnmarshalProvisioner() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
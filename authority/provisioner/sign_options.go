@@ -6,21 +6,25 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
+	"encoding/json"
 	"net"
 	"reflect"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/cli/crypto/x509util"
 	"golang.org/x/crypto/ed25519"
 )
 
 // Options contains the options that can be passed to the Sign method. Backdate
-// is automatically filled and can only be configured in the CA.
+// is automatically filled and can only be configured in the CA. TemplateData
+// is the data sent by the caller to the certificate template.
 type Options struct {
-	NotAfter  TimeDuration  `json:"notAfter"`
-	NotBefore TimeDuration  `json:"notBefore"`
-	Backdate  time.Duration `json:"-"`
+	NotAfter     TimeDuration    `json:"notAfter"`
+	NotBefore    TimeDuration    `json:"notBefore"`
+	TemplateData json.RawMessage `json:"templateData,omitempty"`
+	Backdate     time.Duration   `json:"-"`
 }
 
 // SignOption is the interface used to collect all extra options used in the
@@ -47,6 +51,14 @@ type ProfileModifier interface {
 	Option(o Options) x509util.WithOption
 }
 
+// CertificateModifier is the interface used to modify a certificate using the
+// certificate request it was created from and the sign options. The
+// modifications are applied before validation.
+type CertificateModifier interface {
+	SignOption
+	Modify(cert *x509.Certificate, req *x509.CertificateRequest, o Options) error
+}
+
 // CertificateEnforcer is the interface used to modify a certificate after
 // validation.
 type CertificateEnforcer interface {
@@ -54,6 +66,23 @@ type CertificateEnforcer interface {
 	Enforce(cert *x509.Certificate) error
 }
 
+// CertificateIssuerSelector is the interface used to select the intermediate
+// that signs a certificate. Issuer is called after the certificate modifiers
+// and returns the name of the intermediate, or an empty string to use the
+// default selection.
+type CertificateIssuerSelector interface {
+	SignOption
+	Issuer() string
+}
+
+// issuerSelector is a CertificateIssuerSelector that selects the intermediate
+// with the given name.
+type issuerSelector string
+
+func (s issuerSelector) Issuer() string {
+	return string(s)
+}
+
 // profileWithOption is a wrapper against x509util.WithOption to conform the
 // interface.
 type profileWithOption x509util.WithOption
@@ -195,6 +224,90 @@ func (v emailAddressesValidator) Valid(req *x509.CertificateRequest) error {
 	return nil
 }
 
+// BatchSignOptions returns the sign options used to sign each one of the
+// certificate requests of a batch authorized by a single token. The validators
+// that require the common name and SANs of a request to be the ones in the
+// token are replaced by a validator that only requires them to be in the set
+// of names of the token, so every request can use a subset of them. It fails
+// if the options do not limit the names of the certificates.
+func BatchSignOptions(opts []SignOption) ([]SignOption, error) {
+	v := &batchNamesValidator{
+		commonNames: make(map[string]bool),
+		dnsNames:    make(map[string]bool),
+		ips:         make(map[string]bool),
+		emails:      make(map[string]bool),
+	}
+	add := func(m map[string]bool, names ...string) {
+		for _, name := range names {
+			m[name] = true
+			v.commonNames[name] = true
+		}
+	}
+	var ret []SignOption
+	for _, o := range opts {
+		switch o := o.(type) {
+		case commonNameValidator:
+			v.commonNames[string(o)] = true
+		case commonNameSliceValidator:
+			add(v.commonNames, o...)
+		case dnsNamesValidator:
+			add(v.dnsNames, o...)
+		case emailAddressesValidator:
+			add(v.emails, o...)
+		case ipAddressesValidator:
+			for _, ip := range o {
+				add(v.ips, ip.String())
+			}
+		default:
+			ret = append(ret, o)
+		}
+	}
+	if len(v.commonNames) == 0 {
+		return nil, errors.New("batch signing requires a token with a set of names")
+	}
+	return append(ret, v), nil
+}
+
+// batchNamesValidator validates that the common name and SANs of a
+// certificate request are in the set of names of a token.
+type batchNamesValidator struct {
+	commonNames map[string]bool
+	dnsNames    map[string]bool
+	ips         map[string]bool
+	emails      map[string]bool
+}
+
+// Valid checks that the certificate request has at least one name, and that
+// all of them are in the set. The common name can be any of the names.
+func (v *batchNamesValidator) Valid(req *x509.CertificateRequest) error {
+	if req.Subject.CommonName == "" && len(req.DNSNames) == 0 && len(req.IPAddresses) == 0 &&
+		len(req.EmailAddresses) == 0 && len(req.URIs) == 0 {
+		return errors.New("certificate request does not contain any name")
+	}
+	if cn := req.Subject.CommonName; cn != "" && !v.commonNames[cn] {
+		return errors.Errorf("certificate request common name %s is not allowed by the token", cn)
+	}
+	for _, s := range req.DNSNames {
+		if !v.dnsNames[s] {
+			return errors.Errorf("certificate request DNS name %s is not allowed by the token", s)
+		}
+	}
+	for _, ip := range req.IPAddresses {
+		if !v.ips[ip.String()] {
+			return errors.Errorf("certificate request IP address %s is not allowed by the token", ip)
+		}
+	}
+	for _, s := range req.EmailAddresses {
+		if !v.emails[s] {
+			return errors.Errorf("certificate request email address %s is not allowed by the token", s)
+		}
+	}
+	if len(req.URIs) > 0 {
+		return errors.Errorf("certificate request URI %s is not allowed by the token", req.URIs[0])
+	}
+	return nil
+}
+
 // profileDefaultDuration is a wrapper against x509util.WithOption to conform
 // the SignOption interface.
 type profileDefaultDuration time.Duration
@@ -218,6 +331,18 @@ func (v profileDefaultDuration) Option(so Options) x509util.WithOption {
 	}
 }
 
+// profileExtKeyUsage is an x509 profile option that replaces the extended key
+// usages of a certificate.
+type profileExtKeyUsage []x509.ExtKeyUsage
+
+func (v profileExtKeyUsage) Option(Options) x509util.WithOption {
+	return func(p x509util.Profile) error {
+		crt := p.Subject()
+		crt.ExtKeyUsage = append([]x509.ExtKeyUsage{}, v...)
+		return nil
+	}
+}
+
 // profileLimitDuration is an x509 profile option that modifies an x509 validity
 // period according to an imposed expiration time.
 type profileLimitDuration struct {
@@ -261,6 +386,23 @@ func (v profileLimitDuration) Option(so Options) x509util.WithOption {
 	}
 }
 
+// x509NamePolicyValidator validates that the names in a certificate are
+// allowed by the name policy of the provisioner.
+type x509NamePolicyValidator struct {
+	engine *policy.Engine
+}
+
+// newX509NamePolicyValidator returns a new name policy validator.
+func newX509NamePolicyValidator(engine *policy.Engine) *x509NamePolicyValidator {
+	return &x509NamePolicyValidator{engine: engine}
+}
+
+// Valid returns an error if a name in the certificate is not allowed by the
+// name policy.
+func (v *x509NamePolicyValidator) Valid(cert *x509.Certificate, o Options) error {
+	return v.engine.IsX509CertificateAllowed(cert)
+}
+
 // validityValidator validates the certificate validity settings.
 type validityValidator struct {
 	min time.Duration
@@ -316,6 +458,28 @@ type stepProvisionerASN1 struct {
 	KeyValuePairs []string `asn1:"optional,omitempty"`
 }
 
+// CertificateProvisioner returns the type and name of the provisioner that
+// issued the given certificate, read from the provisioner extension added by
+// the CA. It returns false if the certificate does not have exactly one
+// provisioner extension, as it's not possible to know which one was added by
+// the CA if a template has added another.
+func CertificateProvisioner(crt *x509.Certificate) (Type, string, bool) {
+	var found []pkix.Extension
+	for _, e := range crt.Extensions {
+		if e.Id.Equal(stepOIDProvisioner) {
+			found = append(found, e)
+		}
+	}
+	if len(found) != 1 {
+		return 0, "", false
+	}
+	var p stepProvisionerASN1
+	if rest, err := asn1.Unmarshal(found[0].Value, &p); err != nil || len(rest) > 0 {
+		return 0, "", false
+	}
+	return Type(p.Type), string(p.Name), true
+}
+
 type provisionerExtensionOption struct {
 	Type          int
 	Name          string
@@ -12,6 +12,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/cli/crypto/pemutil"
 	"github.com/smallstep/cli/crypto/x509util"
 )
@@ -246,6 +247,83 @@ func Test_ipAddressesValidator_Valid(t *testing.T) {
 	}
 }
 
+func TestBatchSignOptions(t *testing.T) {
+	ip := net.IPv4(10, 3, 2, 1)
+	opts, err := BatchSignOptions([]SignOption{
+		profileDefaultDuration(time.Hour),
+		commonNameValidator("batch"),
+		defaultPublicKeyValidator{},
+		dnsNamesValidator([]string{"foo.internal", "bar.internal"}),
+		emailAddressesValidator([]string{"ops@internal"}),
+		ipAddressesValidator([]net.IP{ip}),
+	})
+	assert.FatalError(t, err)
+	assert.Equals(t, 3, len(opts))
+	assert.Equals(t, profileDefaultDuration(time.Hour), opts[0])
+	assert.Equals(t, defaultPublicKeyValidator{}, opts[1])
+	v, ok := opts[2].(*batchNamesValidator)
+	assert.Fatal(t, ok, "unexpected option type")
+
+	uri, err := url.Parse("spiffe://internal/foo")
+	assert.FatalError(t, err)
+	tests := []struct {
+		name    string
+		req     *x509.CertificateRequest
+		wantErr bool
+	}{
+		{"ok subset", &x509.CertificateRequest{Subject: pkix.Name{CommonName: "foo.internal"}, DNSNames: []string{"foo.internal"}}, false},
+		{"ok token subject", &x509.CertificateRequest{Subject: pkix.Name{CommonName: "batch"}, DNSNames: []string{"foo.internal", "bar.internal"}}, false},
+		{"ok ip", &x509.CertificateRequest{Subject: pkix.Name{CommonName: "10.3.2.1"}, IPAddresses: []net.IP{ip}}, false},
+		{"ok email", &x509.CertificateRequest{EmailAddresses: []string{"ops@internal"}}, false},
+		{"fail empty", &x509.CertificateRequest{}, true},
+		{"fail common name", &x509.CertificateRequest{Subject: pkix.Name{CommonName: "zar.internal"}}, true},
+		{"fail dns", &x509.CertificateRequest{DNSNames: []string{"foo.internal", "zar.internal"}}, true},
+		{"fail dns as ip", &x509.CertificateRequest{IPAddresses: []net.IP{net.IPv4(10, 3, 2, 2)}}, true},
+		{"fail email", &x509.CertificateRequest{EmailAddresses: []string{"foo.internal"}}, true},
+		{"fail uri", &x509.CertificateRequest{DNSNames: []string{"foo.internal"}, URIs: []*url.URL{uri}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := v.Valid(tt.req); (err != nil) != tt.wantErr {
+				t.Errorf("batchNamesValidator.Valid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	_, err = BatchSignOptions([]SignOption{defaultPublicKeyValidator{}, emailOnlyIdentity("ops@internal")})
+	if assert.NotNil(t, err) {
+		assert.Equals(t, "batch signing requires a token with a set of names", err.Error())
+	}
+}
+
+func Test_x509NamePolicyValidator_Valid(t *testing.T) {
+	engine, err := policy.New(&policy.Options{
+		X509: &policy.X509Options{
+			Allow: &policy.X509NameOptions{DNSDomains: []string{"*.smallstep.com"}},
+		},
+	})
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name    string
+		v       *x509NamePolicyValidator
+		cert    *x509.Certificate
+		wantErr bool
+	}{
+		{"ok", newX509NamePolicyValidator(engine), &x509.Certificate{DNSNames: []string{"ca.smallstep.com"}}, false},
+		{"ok nil", newX509NamePolicyValidator(nil), &x509.Certificate{DNSNames: []string{"smallstep.com"}}, false},
+		{"fail", newX509NamePolicyValidator(engine), &x509.Certificate{DNSNames: []string{"ca.smallstep.com", "smallstep.com"}}, true},
+		{"fail ip", newX509NamePolicyValidator(engine), &x509.Certificate{IPAddresses: []net.IP{net.IPv4(10, 3, 2, 1)}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.v.Valid(tt.cert, Options{}); (err != nil) != tt.wantErr {
+				t.Errorf("x509NamePolicyValidator.Valid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func Test_validityValidator_Valid(t *testing.T) {
 	type test struct {
 		cert *x509.Certificate
@@ -436,6 +514,16 @@ func Test_profileDefaultDuration_Option(t *testing.T) {
 	}
 }
 
+func Test_profileExtKeyUsage_Option(t *testing.T) {
+	ekus := profileExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	prof := &x509util.Leaf{}
+	prof.SetSubject(&x509.Certificate{
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	})
+	assert.FatalError(t, ekus.Option(Options{})(prof), "unexpected error")
+	assert.Equals(t, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, prof.Subject().ExtKeyUsage)
+}
+
 func Test_profileLimitDuration_Option(t *testing.T) {
 	n, fn := mockNow()
 	defer fn()
@@ -543,3 +631,29 @@ func Test_profileLimitDuration_Option(t *testing.T) {
 		})
 	}
 }
+
+func TestCertificateProvisioner(t *testing.T) {
+	ext, err := createProvisionerExtension(int(TypeJWK), "admin", "kid")
+	assert.FatalError(t, err)
+	other, err := createProvisionerExtension(int(TypeACME), "acme", "")
+	assert.FatalError(t, err)
+	tests := map[string]struct {
+		exts     []pkix.Extension
+		wantType Type
+		wantName string
+		wantOK   bool
+	}{
+		"ok":         {[]pkix.Extension{ext}, TypeJWK, "admin", true},
+		"fail/none":  {nil, 0, "", false},
+		"fail/two":   {[]pkix.Extension{other, ext}, 0, "", false},
+		"fail/value": {[]pkix.Extension{{Id: stepOIDProvisioner, Value: []byte("foobar")}}, 0, "", false},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			typ, name, ok := CertificateProvisioner(&x509.Certificate{Extensions: tc.exts})
+			assert.Equals(t, tc.wantType, typ)
+			assert.Equals(t, tc.wantName, name)
+			assert.Equals(t, tc.wantOK, ok)
+		})
+	}
+}
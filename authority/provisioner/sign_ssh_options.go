@@ -3,10 +3,13 @@ package provisioner
 import (
 	"crypto/rsa"
 	"encoding/binary"
+	"encoding/json"
 	"math/big"
 	"time"
+	"unicode"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/cli/crypto/keys"
 	"golang.org/x/crypto/ssh"
 )
@@ -55,13 +58,15 @@ func (f sshModifierFunc) Modify(cert *ssh.Certificate) error {
 }
 
 // SSHOptions contains the options that can be passed to the SignSSH method.
+// TemplateData is the data sent by the caller to the certificate template.
 type SSHOptions struct {
-	CertType    string        `json:"certType"`
-	KeyID       string        `json:"keyID"`
-	Principals  []string      `json:"principals"`
-	ValidAfter  TimeDuration  `json:"validAfter,omitempty"`
-	ValidBefore TimeDuration  `json:"validBefore,omitempty"`
-	Backdate    time.Duration `json:"-"`
+	CertType     string          `json:"certType"`
+	KeyID        string          `json:"keyID"`
+	Principals   []string        `json:"principals"`
+	ValidAfter   TimeDuration    `json:"validAfter,omitempty"`
+	ValidBefore  TimeDuration    `json:"validBefore,omitempty"`
+	TemplateData json.RawMessage `json:"templateData,omitempty"`
+	Backdate     time.Duration   `json:"-"`
 }
 
 // Type returns the uint32 representation of the CertType.
@@ -350,6 +355,18 @@ func (v *sshCertValidityValidator) Valid(cert *ssh.Certificate, opts SSHOptions)
 	}
 }
 
+// sshNamePolicyValidator validates that the principals in an SSH certificate
+// are allowed by the name policy of the provisioner.
+type sshNamePolicyValidator struct {
+	engine *policy.Engine
+}
+
+// Valid returns an error if a principal in the certificate is not allowed by
+// the name policy.
+func (v *sshNamePolicyValidator) Valid(cert *ssh.Certificate, o SSHOptions) error {
+	return v.engine.IsSSHCertificateAllowed(cert)
+}
+
 // sshCertDefaultValidator implements a simple validator for all the
 // fields in the SSH certificate.
 type sshCertDefaultValidator struct{}
@@ -382,8 +399,26 @@ func (v *sshCertDefaultValidator) Valid(cert *ssh.Certificate, o SSHOptions) err
 	case cert.Signature == nil:
 		return errors.New("ssh certificate signature cannot be nil")
 	default:
-		return nil
+		return validateSSHPrincipals(cert.ValidPrincipals)
+	}
+}
+
+// validateSSHPrincipals returns an error if one of the principals is empty or
+// contains a comma, a space or a control character. OpenSSH uses comma
+// separated lists of principals in the authorized keys and principals files,
+// so a principal like "foo,root" could be accepted as root.
+func validateSSHPrincipals(principals []string) error {
+	for _, p := range principals {
+		if p == "" {
+			return errors.New("ssh certificate valid principals cannot contain an empty principal")
+		}
+		for _, r := range p {
+			if r == ',' || unicode.IsSpace(r) || unicode.IsControl(r) {
+				return errors.Errorf("ssh certificate valid principal %q contains an invalid character", p)
+			}
+		}
 	}
+	return nil
 }
 
 // sshDefaultPublicKeyValidator implements a validator for the certificate key.
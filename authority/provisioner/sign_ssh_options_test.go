@@ -8,6 +8,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/cli/crypto/keys"
 	"golang.org/x/crypto/ssh"
 )
@@ -640,6 +641,57 @@ func Test_sshCertDefaultValidator_Valid(t *testing.T) {
 			},
 			nil,
 		},
+		{
+			"fail/empty-principal",
+			&ssh.Certificate{
+				Nonce:           []byte("foo"),
+				Key:             sshPub,
+				Serial:          1,
+				CertType:        2,
+				KeyId:           "foo",
+				ValidPrincipals: []string{"foo", ""},
+				ValidAfter:      uint64(time.Now().Unix()),
+				ValidBefore:     uint64(time.Now().Add(10 * time.Minute).Unix()),
+				SignatureKey:    sshPub,
+				Signature:       &ssh.Signature{},
+			},
+			errors.New("ssh certificate valid principals cannot contain an empty principal"),
+		},
+		{
+			"fail/comma-principal",
+			&ssh.Certificate{
+				Nonce:           []byte("foo"),
+				Key:             sshPub,
+				Serial:          1,
+				CertType:        1,
+				KeyId:           "foo",
+				ValidPrincipals: []string{"foo,root"},
+				ValidAfter:      uint64(time.Now().Unix()),
+				ValidBefore:     uint64(time.Now().Add(10 * time.Minute).Unix()),
+				Permissions: ssh.Permissions{
+					Extensions: map[string]string{"foo": "bar"},
+				},
+				SignatureKey: sshPub,
+				Signature:    &ssh.Signature{},
+			},
+			errors.New(`ssh certificate valid principal "foo,root" contains an invalid character`),
+		},
+		{
+			"fail/space-principal",
+			&ssh.Certificate{
+				Nonce:           []byte("foo"),
+				Key:             sshPub,
+				Serial:          1,
+				CertType:        2,
+				KeyId:           "foo",
+				ValidPrincipals: []string{"foo bar"},
+				ValidAfter:      uint64(time.Now().Unix()),
+				ValidBefore:     uint64(time.Now().Add(10 * time.Minute).Unix()),
+				SignatureKey:    sshPub,
+				Signature:       &ssh.Signature{},
+			},
+			errors.New(`ssh certificate valid principal "foo bar" contains an invalid character`),
+		},
 		{
 			"ok/hostCert",
 			&ssh.Certificate{
@@ -670,6 +722,36 @@ func Test_sshCertDefaultValidator_Valid(t *testing.T) {
 	}
 }
 
+func Test_sshNamePolicyValidator_Valid(t *testing.T) {
+	engine, err := policy.New(&policy.Options{
+		SSH: &policy.SSHOptions{
+			User: &policy.SSHUserOptions{
+				Deny: &policy.SSHUserNameOptions{Principals: []string{"root"}},
+			},
+		},
+	})
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name    string
+		v       *sshNamePolicyValidator
+		cert    *ssh.Certificate
+		wantErr bool
+	}{
+		{"ok", &sshNamePolicyValidator{engine}, &ssh.Certificate{CertType: ssh.UserCert, ValidPrincipals: []string{"mariano"}}, false},
+		{"ok host", &sshNamePolicyValidator{engine}, &ssh.Certificate{CertType: ssh.HostCert, ValidPrincipals: []string{"root"}}, false},
+		{"ok nil", &sshNamePolicyValidator{nil}, &ssh.Certificate{CertType: ssh.UserCert, ValidPrincipals: []string{"root"}}, false},
+		{"fail", &sshNamePolicyValidator{engine}, &ssh.Certificate{CertType: ssh.UserCert, ValidPrincipals: []string{"mariano", "root"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.v.Valid(tt.cert, SSHOptions{}); (err != nil) != tt.wantErr {
+				t.Errorf("sshNamePolicyValidator.Valid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func Test_sshCertValidityValidator(t *testing.T) {
 	p, err := generateX5C(nil)
 	assert.FatalError(t, err)
@@ -0,0 +1,178 @@
+package provisioner
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/errs"
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHTemplateOptions contains the options to customize the SSH certificates
+// signed by a provisioner.
+//
+// Template is a Go text/template, with the sprig functions, that renders an
+// SSHTemplate in JSON. TemplateFile is the path of a file with the template,
+// it's only used if Template is empty. TemplateData is a map of additional
+// values available in the template.
+//
+// The template is executed with the keys of TemplateData, and .Type, .KeyID
+// and .Principals, the values of the certificate before applying the
+// template, .Token, the claims of the token that authorized the request, and
+// .Insecure.User, the template data sent by the caller. The template data sent
+// by the caller is only accepted if UserDataSchema, the schema of that data,
+// is set, and it must be valid against it.
+//
+// TemplateName is the name of one of the certificate templates configured in
+// the authority, it cannot be used with Template or TemplateFile. The
+// TemplateData of the provisioner overrides the one of the named template. If
+// a provisioner does not have a template, the default one configured in the
+// authority, if any, is used.
+type SSHTemplateOptions struct {
+	Template       string                 `json:"template,omitempty"`
+	TemplateFile   string                 `json:"templateFile,omitempty"`
+	TemplateData   map[string]interface{} `json:"templateData,omitempty"`
+	TemplateName   string                 `json:"templateName,omitempty"`
+	UserDataSchema *TemplateDataSchema    `json:"userDataSchema,omitempty"`
+	template       *template.Template
+}
+
+// SSHTemplate is the result of an SSH certificate template. Type, if not
+// empty, must be user or host. The key id and principals of the certificate
+// are replaced by the ones in the template. Extensions and CriticalOptions
+// replace the ones in the certificate if they are not null.
+type SSHTemplate struct {
+	Type            string            `json:"type,omitempty"`
+	KeyID           string            `json:"keyId"`
+	Principals      []string          `json:"principals"`
+	Extensions      map[string]string `json:"extensions,omitempty"`
+	CriticalOptions map[string]string `json:"criticalOptions,omitempty"`
+}
+
+// Init loads and parses the template.
+func (o *SSHTemplateOptions) Init() error {
+	if o == nil {
+		return nil
+	}
+	if err := o.UserDataSchema.Init(); err != nil {
+		return errors.Wrap(err, "error initializing userDataSchema")
+	}
+	text := o.Template
+	if o.TemplateName != "" {
+		if text != "" || o.TemplateFile != "" {
+			return errors.New("templateName cannot be used with template or templateFile")
+		}
+		return nil
+	}
+	if text == "" && o.TemplateFile != "" {
+		b, err := ioutil.ReadFile(o.TemplateFile)
+		if err != nil {
+			return errors.Wrapf(err, "error reading %s", o.TemplateFile)
+		}
+		text = string(b)
+	}
+	if text == "" {
+		return errors.New("template or templateFile cannot be empty")
+	}
+	for _, k := range []string{"Type", "KeyID", "Principals", "Token", "Insecure"} {
+		if _, ok := o.TemplateData[k]; ok {
+			return errors.Errorf("templateData cannot contain the reserved key %s", k)
+		}
+	}
+	tmpl, err := template.New("ssh").Funcs(sprig.TxtFuncMap()).Parse(text)
+	if err != nil {
+		return errors.Wrap(err, "error parsing template")
+	}
+	o.template = tmpl
+	return nil
+}
+
+// appendTemplateOption appends the option that applies the template to the
+// given sign options if the template is configured. The claims of the given
+// token are available in the template.
+func (o *SSHTemplateOptions) appendTemplateOption(opts []SignOption, token string) []SignOption {
+	if o == nil || o.template == nil {
+		return opts
+	}
+	return append(opts, &sshTemplateModifier{
+		options: o,
+		claims:  tokenClaims(token),
+	})
+}
+
+// sshTemplateModifier is the SSHCertOptionModifier that applies an SSH
+// certificate template.
+type sshTemplateModifier struct {
+	options *SSHTemplateOptions
+	claims  map[string]interface{}
+}
+
+// Option returns the SSHCertModifier that renders the template with the
+// template data in the given options and applies the result to the
+// certificate.
+func (m *sshTemplateModifier) Option(o SSHOptions) SSHCertModifier {
+	return sshModifierFunc(func(cert *ssh.Certificate) error {
+		return m.modify(cert, o)
+	})
+}
+
+func (m *sshTemplateModifier) modify(cert *ssh.Certificate, o SSHOptions) error {
+	user, err := m.options.UserDataSchema.Validate(o.TemplateData)
+	if err != nil {
+		return errs.BadRequestErr(err, errs.WithMessage("invalid template data: %s", err))
+	}
+
+	var certType string
+	switch cert.CertType {
+	case ssh.UserCert:
+		certType = SSHUserCert
+	case ssh.HostCert:
+		certType = SSHHostCert
+	}
+
+	data := make(map[string]interface{}, len(m.options.TemplateData)+5)
+	for k, v := range m.options.TemplateData {
+		data[k] = v
+	}
+	data["Type"] = certType
+	data["KeyID"] = cert.KeyId
+	data["Principals"] = cert.ValidPrincipals
+	data["Token"] = m.claims
+	data["Insecure"] = map[string]interface{}{"User": user}
+
+	var buf bytes.Buffer
+	if err := m.options.template.Execute(&buf, data); err != nil {
+		return errors.Wrap(err, "error executing ssh template")
+	}
+	var t SSHTemplate
+	if err := json.Unmarshal(buf.Bytes(), &t); err != nil {
+		return errors.Wrap(err, "error unmarshaling ssh template result")
+	}
+	return t.apply(cert)
+}
+
+// apply sets the values of the template in the given certificate.
+func (t *SSHTemplate) apply(cert *ssh.Certificate) error {
+	switch t.Type {
+	case "":
+	case SSHUserCert:
+		cert.CertType = ssh.UserCert
+	case SSHHostCert:
+		cert.CertType = ssh.HostCert
+	default:
+		return errors.Errorf("ssh template contains an unknown type %s", t.Type)
+	}
+	cert.KeyId = t.KeyID
+	cert.ValidPrincipals = t.Principals
+	if t.Extensions != nil {
+		cert.Extensions = t.Extensions
+	}
+	if t.CriticalOptions != nil {
+		cert.CriticalOptions = t.CriticalOptions
+	}
+	return nil
+}
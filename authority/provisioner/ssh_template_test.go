@@ -0,0 +1,237 @@
+package provisioner
+
+import (
+	"context"
+	"crypto"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSSHTemplateOptions_Init(t *testing.T) {
+	f, err := ioutil.TempFile("", "ssh.tpl")
+	assert.FatalError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`{"keyId": {{ toJson .KeyID }}}`)
+	assert.FatalError(t, err)
+	assert.FatalError(t, f.Close())
+
+	tests := map[string]struct {
+		opts *SSHTemplateOptions
+		err  error
+	}{
+		"ok/nil":          {nil, nil},
+		"ok/template":     {&SSHTemplateOptions{Template: `{"keyId": {{ toJson .KeyID }}}`}, nil},
+		"ok/file":         {&SSHTemplateOptions{TemplateFile: f.Name()}, nil},
+		"ok/templateName": {&SSHTemplateOptions{TemplateName: "user"}, nil},
+		"fail/empty":      {&SSHTemplateOptions{}, errors.New("template or templateFile cannot be empty")},
+		"fail/file":       {&SSHTemplateOptions{TemplateFile: "testdata/missing.tpl"}, errors.New("error reading testdata/missing.tpl")},
+		"fail/parse":      {&SSHTemplateOptions{Template: `{{ .KeyID`}, errors.New("error parsing template")},
+		"fail/reserved": {
+			&SSHTemplateOptions{Template: `{}`, TemplateData: map[string]interface{}{"Principals": "foo"}},
+			errors.New("templateData cannot contain the reserved key Principals"),
+		},
+		"fail/templateName": {
+			&SSHTemplateOptions{TemplateFile: f.Name(), TemplateName: "user"},
+			errors.New("templateName cannot be used with template or templateFile"),
+		},
+		"fail/userDataSchema": {
+			&SSHTemplateOptions{Template: `{}`, UserDataSchema: &TemplateDataSchema{Pattern: "["}},
+			errors.New("error initializing userDataSchema: error compiling pattern ["),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.opts.Init()
+			if tc.err != nil {
+				if assert.NotNil(t, err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+				return
+			}
+			assert.FatalError(t, err)
+			if tc.opts != nil && tc.opts.TemplateName == "" {
+				assert.NotNil(t, tc.opts.template)
+			}
+		})
+	}
+}
+
+func TestSSHTemplateOptions_appendTemplateOption(t *testing.T) {
+	var opts *SSHTemplateOptions
+	assert.Len(t, 1, opts.appendTemplateOption([]SignOption{&sshDefaultExtensionModifier{}}, ""))
+	assert.Len(t, 1, (&SSHTemplateOptions{TemplateName: "user"}).appendTemplateOption([]SignOption{&sshDefaultExtensionModifier{}}, ""))
+
+	opts = &SSHTemplateOptions{Template: `{}`}
+	assert.FatalError(t, opts.Init())
+	jwk, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	token, err := generateSimpleSSHUserToken("issuer", "audience", jwk)
+	assert.FatalError(t, err)
+
+	so := opts.appendTemplateOption([]SignOption{&sshDefaultExtensionModifier{}}, token)
+	if assert.Len(t, 2, so) {
+		m, ok := so[1].(*sshTemplateModifier)
+		if assert.True(t, ok) {
+			assert.Equals(t, opts, m.options)
+			assert.Equals(t, "subject@localhost", m.claims["sub"])
+		}
+		_, ok = so[1].(SSHCertOptionModifier)
+		assert.True(t, ok)
+	}
+}
+
+func TestSSHTemplateModifier_Option(t *testing.T) {
+	newCert := func() *ssh.Certificate {
+		return &ssh.Certificate{
+			CertType:        ssh.UserCert,
+			KeyId:           "jane@smallstep.com",
+			ValidPrincipals: []string{"jane"},
+			Permissions: ssh.Permissions{
+				Extensions: map[string]string{"permit-pty": ""},
+			},
+		}
+	}
+	no := false
+	schema := &TemplateDataSchema{
+		Type:                 "object",
+		AdditionalProperties: &no,
+		Properties: map[string]*TemplateDataSchema{
+			"principals": {Type: "array", Items: &TemplateDataSchema{Type: "string", Pattern: "^[a-z]+$"}},
+		},
+	}
+
+	tests := map[string]struct {
+		template string
+		data     map[string]interface{}
+		claims   map[string]interface{}
+		schema   *TemplateDataSchema
+		user     string
+		want     *ssh.Certificate
+		err      error
+	}{
+		"ok/passthrough": {
+			template: `{"type": {{ toJson .Type }}, "keyId": {{ toJson .KeyID }}, "principals": {{ toJson .Principals }}}`,
+			want:     newCert(),
+		},
+		"ok/token-and-data": {
+			template: `{
+				"type": "host",
+				"keyId": {{ toJson .Token.sub }},
+				"principals": [{{ toJson .Host }}],
+				"extensions": {},
+				"criticalOptions": {"force-command": "/bin/true"}
+			}`,
+			data:   map[string]interface{}{"Host": "foo.smallstep.com"},
+			claims: map[string]interface{}{"sub": "foo"},
+			want: &ssh.Certificate{
+				CertType:        ssh.HostCert,
+				KeyId:           "foo",
+				ValidPrincipals: []string{"foo.smallstep.com"},
+				Permissions: ssh.Permissions{
+					Extensions:      map[string]string{},
+					CriticalOptions: map[string]string{"force-command": "/bin/true"},
+				},
+			},
+		},
+		"ok/user": {
+			template: `{"keyId": {{ toJson .KeyID }}, "principals": {{ toJson (concat .Principals .Insecure.User.principals) }}}`,
+			schema:   schema,
+			user:     `{"principals": ["root"]}`,
+			want: &ssh.Certificate{
+				CertType:        ssh.UserCert,
+				KeyId:           "jane@smallstep.com",
+				ValidPrincipals: []string{"jane", "root"},
+				Permissions: ssh.Permissions{
+					Extensions: map[string]string{"permit-pty": ""},
+				},
+			},
+		},
+		"fail/user-not-allowed": {
+			template: `{}`,
+			user:     `{"principals": ["root"]}`,
+			err:      errors.New("template data is not allowed"),
+		},
+		"fail/user-schema": {
+			template: `{}`,
+			schema:   schema,
+			user:     `{"principals": ["Root"]}`,
+			err:      errors.New("templateData.principals[0] does not match the pattern ^[a-z]+$"),
+		},
+		"fail/execute": {
+			template: `{{ fail "principal not allowed" }}`,
+			err:      errors.New("error executing ssh template"),
+		},
+		"fail/json": {
+			template: `{`,
+			err:      errors.New("error unmarshaling ssh template result"),
+		},
+		"fail/type": {
+			template: `{"type": "foo"}`,
+			err:      errors.New("ssh template contains an unknown type foo"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			opts := &SSHTemplateOptions{Template: tc.template, TemplateData: tc.data, UserDataSchema: tc.schema}
+			assert.FatalError(t, opts.Init())
+			cert := newCert()
+			m := &sshTemplateModifier{options: opts, claims: tc.claims}
+			var o SSHOptions
+			if tc.user != "" {
+				o.TemplateData = []byte(tc.user)
+			}
+			if err := m.Option(o).Modify(cert); err != nil {
+				if assert.NotNil(t, tc.err, err.Error()) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, tc.want, cert)
+			}
+		})
+	}
+}
+
+func TestJWK_AuthorizeSSHSign_sshTemplate(t *testing.T) {
+	p, err := generateJWK()
+	assert.FatalError(t, err)
+	jwk, err := decryptJSONWebKey(p.EncryptedKey)
+	assert.FatalError(t, err)
+	templates := &CertificateTemplates{
+		SSH: map[string]*SSHTemplateOptions{
+			"admins": {
+				Template:       `{"keyId": {{ toJson .KeyID }}, "principals": {{ toJson (append .Principals .Insecure.User.role) }}}`,
+				UserDataSchema: &TemplateDataSchema{Type: "object", Required: []string{"role"}},
+			},
+		},
+	}
+	assert.FatalError(t, templates.Init())
+	p.SSH = &SSHTemplateOptions{TemplateName: "admins"}
+	assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims, Audiences: testAudiences, Templates: templates}))
+
+	token, err := generateSimpleSSHUserToken(p.Name, testAudiences.SSHSign[0], jwk)
+	assert.FatalError(t, err)
+	so, err := p.AuthorizeSSHSign(context.Background(), token)
+	assert.FatalError(t, err)
+
+	key, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	signer, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+
+	opts := SSHOptions{CertType: "user", Principals: []string{"name"}, TemplateData: []byte(`{"role": "admin"}`)}
+	cert, err := signSSHCertificate(key.Public().Key, opts, so, signer.Key.(crypto.Signer))
+	assert.FatalError(t, err)
+	assert.Equals(t, "subject@localhost", cert.KeyId)
+	assert.Equals(t, []string{"name", "admin"}, cert.ValidPrincipals)
+
+	opts.TemplateData = []byte(`{"team": "eng"}`)
+	_, err = signSSHCertificate(key.Public().Key, opts, so, signer.Key.(crypto.Signer))
+	if assert.NotNil(t, err) {
+		assert.Equals(t, "templateData.role is required", err.Error())
+	}
+}
@@ -206,6 +206,9 @@ func (p *SSHPOP) AuthorizeSSHRenew(ctx context.Context, token string) (*ssh.Cert
 	if claims.sshCert.CertType != ssh.HostCert {
 		return nil, errs.BadRequest("sshpop.AuthorizeSSHRenew; sshpop certificate must be a host ssh certificate")
 	}
+	if p.claimer.IsDisableSSHRenewal() {
+		return nil, errs.Unauthorized("sshpop.AuthorizeSSHRenew; renew is disabled for sshpop provisioner %s", p.GetID())
+	}
 
 	return claims.sshCert, nil
 
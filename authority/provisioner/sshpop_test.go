@@ -436,6 +436,77 @@ func TestSSHPOP_AuthorizeSSHRenew(t *testing.T) {
 				err:   errors.New("sshpop.AuthorizeSSHRenew; sshpop certificate must be a host ssh certificate"),
 			}
 		},
+		"fail/renew-disabled": func(t *testing.T) test {
+			p, err := generateSSHPOP()
+			assert.FatalError(t, err)
+			disable := true
+			p.Claims = &Claims{DisableRenewal: &disable}
+			p.claimer, err = NewClaimer(p.Claims, globalProvisionerClaims)
+			assert.FatalError(t, err)
+			p.db = &db.MockAuthDB{
+				MIsSSHRevoked: func(sn string) (bool, error) {
+					return false, nil
+				},
+			}
+			cert, jwk, err := createSSHCert(&ssh.Certificate{Serial: 123455, CertType: ssh.HostCert}, sshHostSigner)
+			assert.FatalError(t, err)
+			tok, err := generateToken("123455", p.GetName(), testAudiences.SSHRenew[0], "",
+				[]string{"test.smallstep.com"}, time.Now(), jwk, withSSHPOPFile(cert))
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+				code:  http.StatusUnauthorized,
+				err:   errors.Errorf("sshpop.AuthorizeSSHRenew; renew is disabled for sshpop provisioner %s", p.GetID()),
+			}
+		},
+		"fail/ssh-renew-disabled": func(t *testing.T) test {
+			p, err := generateSSHPOP()
+			assert.FatalError(t, err)
+			disable := true
+			p.Claims = &Claims{DisableSSHRenewal: &disable}
+			p.claimer, err = NewClaimer(p.Claims, globalProvisionerClaims)
+			assert.FatalError(t, err)
+			p.db = &db.MockAuthDB{
+				MIsSSHRevoked: func(sn string) (bool, error) {
+					return false, nil
+				},
+			}
+			cert, jwk, err := createSSHCert(&ssh.Certificate{Serial: 123455, CertType: ssh.HostCert}, sshHostSigner)
+			assert.FatalError(t, err)
+			tok, err := generateToken("123455", p.GetName(), testAudiences.SSHRenew[0], "",
+				[]string{"test.smallstep.com"}, time.Now(), jwk, withSSHPOPFile(cert))
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+				code:  http.StatusUnauthorized,
+				err:   errors.Errorf("sshpop.AuthorizeSSHRenew; renew is disabled for sshpop provisioner %s", p.GetID()),
+			}
+		},
+		"ok/tls-renew-disabled": func(t *testing.T) test {
+			p, err := generateSSHPOP()
+			assert.FatalError(t, err)
+			disable, enable := true, false
+			p.Claims = &Claims{DisableRenewal: &disable, DisableSSHRenewal: &enable}
+			p.claimer, err = NewClaimer(p.Claims, globalProvisionerClaims)
+			assert.FatalError(t, err)
+			p.db = &db.MockAuthDB{
+				MIsSSHRevoked: func(sn string) (bool, error) {
+					return false, nil
+				},
+			}
+			cert, jwk, err := createSSHCert(&ssh.Certificate{Serial: 123455, CertType: ssh.HostCert}, sshHostSigner)
+			assert.FatalError(t, err)
+			tok, err := generateToken("123455", p.GetName(), testAudiences.SSHRenew[0], "",
+				[]string{"test.smallstep.com"}, time.Now(), jwk, withSSHPOPFile(cert))
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+				cert:  cert,
+			}
+		},
 		"ok": func(t *testing.T) test {
 			p, err := generateSSHPOP()
 			assert.FatalError(t, err)
@@ -0,0 +1,161 @@
+package provisioner
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// TemplateDataSchema is the subset of JSON Schema used to validate the
+// template data sent by the callers of the sign APIs. The supported keywords
+// are type, properties, required, additionalProperties, items, enum, pattern
+// and maxLength. Like in JSON Schema, additional properties are allowed
+// unless additionalProperties is false.
+type TemplateDataSchema struct {
+	Type                 string                         `json:"type,omitempty"`
+	Properties           map[string]*TemplateDataSchema `json:"properties,omitempty"`
+	Required             []string                       `json:"required,omitempty"`
+	AdditionalProperties *bool                          `json:"additionalProperties,omitempty"`
+	Items                *TemplateDataSchema            `json:"items,omitempty"`
+	Enum                 []interface{}                  `json:"enum,omitempty"`
+	Pattern              string                         `json:"pattern,omitempty"`
+	MaxLength            int                            `json:"maxLength,omitempty"`
+	pattern              *regexp.Regexp
+}
+
+// Init validates the schema and compiles the patterns in it.
+func (s *TemplateDataSchema) Init() error {
+	if s == nil {
+		return nil
+	}
+	switch s.Type {
+	case "", "object", "array", "string", "number", "integer", "boolean", "null":
+	default:
+		return errors.Errorf("type %s is not supported", s.Type)
+	}
+	if s.MaxLength < 0 {
+		return errors.New("maxLength cannot be negative")
+	}
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return errors.Wrapf(err, "error compiling pattern %s", s.Pattern)
+		}
+		s.pattern = re
+	}
+	for name, p := range s.Properties {
+		if err := p.Init(); err != nil {
+			return errors.Wrapf(err, "property %s", name)
+		}
+	}
+	return errors.Wrap(s.Items.Init(), "items")
+}
+
+// Validate decodes the given JSON template data and validates it against the
+// schema. It returns the decoded data.
+func (s *TemplateDataSchema) Validate(data json.RawMessage) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if s == nil {
+		return nil, errors.New("template data is not allowed")
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, errors.Wrap(err, "error unmarshaling template data")
+	}
+	if err := s.validate(v, "templateData"); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (s *TemplateDataSchema) validate(v interface{}, path string) error {
+	if s == nil {
+		return nil
+	}
+	if t := jsonType(v); s.Type != "" && t != s.Type && !(s.Type == "number" && t == "integer") {
+		return errors.Errorf("%s must be of type %s", path, s.Type)
+	}
+	if len(s.Enum) > 0 {
+		var found bool
+		for _, e := range s.Enum {
+			if reflect.DeepEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.Errorf("%s is not one of the allowed values", path)
+		}
+	}
+
+	switch v := v.(type) {
+	case string:
+		if s.MaxLength > 0 && len([]rune(v)) > s.MaxLength {
+			return errors.Errorf("%s cannot be longer than %d characters", path, s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			return errors.Errorf("%s does not match the pattern %s", path, s.Pattern)
+		}
+	case []interface{}:
+		for i, item := range v {
+			if err := s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return errors.Errorf("%s.%s is required", path, name)
+			}
+		}
+		// Sort the keys to always return the same error.
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p, ok := s.Properties[k]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return errors.Errorf("%s.%s is not allowed", path, k)
+				}
+				continue
+			}
+			if err := p.validate(v[k], path+"."+k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// jsonType returns the JSON Schema type of a decoded JSON value.
+func jsonType(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
@@ -0,0 +1,101 @@
+package provisioner
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+)
+
+func TestTemplateDataSchema_Init(t *testing.T) {
+	tests := map[string]struct {
+		schema *TemplateDataSchema
+		err    error
+	}{
+		"ok/nil":   {nil, nil},
+		"ok/empty": {&TemplateDataSchema{}, nil},
+		"ok/nested": {&TemplateDataSchema{Type: "object", Properties: map[string]*TemplateDataSchema{
+			"names": {Type: "array", Items: &TemplateDataSchema{Type: "string", Pattern: "^[a-z]+$"}},
+		}}, nil},
+		"fail/type":      {&TemplateDataSchema{Type: "foo"}, errors.New("type foo is not supported")},
+		"fail/maxLength": {&TemplateDataSchema{MaxLength: -1}, errors.New("maxLength cannot be negative")},
+		"fail/pattern":   {&TemplateDataSchema{Pattern: "["}, errors.New("error compiling pattern [")},
+		"fail/property": {&TemplateDataSchema{Properties: map[string]*TemplateDataSchema{
+			"name": {Type: "foo"},
+		}}, errors.New("property name: type foo is not supported")},
+		"fail/items": {&TemplateDataSchema{Items: &TemplateDataSchema{Pattern: "["}}, errors.New("items: error compiling pattern [")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.schema.Init()
+			if tc.err != nil {
+				if assert.NotNil(t, err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.FatalError(t, err)
+			}
+		})
+	}
+}
+
+func TestTemplateDataSchema_Validate(t *testing.T) {
+	no := false
+	schema := &TemplateDataSchema{
+		Type:                 "object",
+		Required:             []string{"name"},
+		AdditionalProperties: &no,
+		Properties: map[string]*TemplateDataSchema{
+			"name":  {Type: "string", Pattern: "^[a-z]+$", MaxLength: 8},
+			"team":  {Type: "string", Enum: []interface{}{"eng", "ops"}},
+			"level": {Type: "integer"},
+			"ratio": {Type: "number"},
+			"admin": {Type: "boolean"},
+			"tags":  {Type: "array", Items: &TemplateDataSchema{Type: "string"}},
+			"extra": {Type: "object"},
+		},
+	}
+	assert.FatalError(t, schema.Init())
+
+	tests := map[string]struct {
+		schema *TemplateDataSchema
+		data   string
+		want   interface{}
+		err    error
+	}{
+		"ok/empty":  {schema, "", nil, nil},
+		"ok/name":   {schema, `{"name":"jane"}`, map[string]interface{}{"name": "jane"}, nil},
+		"ok/number": {schema, `{"name":"jane","ratio":1}`, map[string]interface{}{"name": "jane", "ratio": float64(1)}, nil},
+		"ok/all": {schema, `{"name":"jane","team":"ops","level":2,"ratio":0.5,"admin":true,"tags":["a"],"extra":{"foo":"bar"}}`, map[string]interface{}{
+			"name": "jane", "team": "ops", "level": float64(2), "ratio": 0.5, "admin": true,
+			"tags": []interface{}{"a"}, "extra": map[string]interface{}{"foo": "bar"},
+		}, nil},
+		"ok/no-type":        {&TemplateDataSchema{}, `"foo"`, "foo", nil},
+		"fail/not-allowed":  {nil, `{"name":"jane"}`, nil, errors.New("template data is not allowed")},
+		"fail/json":         {schema, `{`, nil, errors.New("error unmarshaling template data")},
+		"fail/type":         {schema, `[]`, nil, errors.New("templateData must be of type object")},
+		"fail/required":     {schema, `{"team":"eng"}`, nil, errors.New("templateData.name is required")},
+		"fail/additional":   {schema, `{"name":"jane","foo":"bar"}`, nil, errors.New("templateData.foo is not allowed")},
+		"fail/pattern":      {schema, `{"name":"Jane"}`, nil, errors.New("templateData.name does not match the pattern ^[a-z]+$")},
+		"fail/maxLength":    {schema, `{"name":"janejanejane"}`, nil, errors.New("templateData.name cannot be longer than 8 characters")},
+		"fail/enum":         {schema, `{"name":"jane","team":"sales"}`, nil, errors.New("templateData.team is not one of the allowed values")},
+		"fail/integer":      {schema, `{"name":"jane","level":1.5}`, nil, errors.New("templateData.level must be of type integer")},
+		"fail/boolean":      {schema, `{"name":"jane","admin":"true"}`, nil, errors.New("templateData.admin must be of type boolean")},
+		"fail/items":        {schema, `{"name":"jane","tags":["a",1]}`, nil, errors.New("templateData.tags[1] must be of type string")},
+		"fail/nested-type":  {schema, `{"name":"jane","extra":null}`, nil, errors.New("templateData.extra must be of type object")},
+		"fail/string-array": {schema, `{"name":["jane"]}`, nil, errors.New("templateData.name must be of type string")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.schema.Validate([]byte(tc.data))
+			if tc.err != nil {
+				if assert.NotNil(t, err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.FatalError(t, err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,354 @@
+package provisioner
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/jose"
+)
+
+// X509Options contains the options to customize the X.509 certificates signed
+// by a provisioner.
+//
+// Template is a Go text/template, with the sprig functions, that renders an
+// X509Template in JSON. TemplateFile is the path of a file with the template,
+// it's only used if Template is empty. TemplateData is a map of additional
+// values available in the template.
+//
+// The template is executed with the keys of TemplateData, and .Subject and
+// .SANs, the subject and subject alternative names in the certificate request,
+// .Token, the claims of the token that authorized the request, if any, and
+// .Insecure.CR, the certificate request, and .Insecure.User, the template data
+// sent by the caller. The certificate request is not validated by the
+// provisioner, the template must validate the values taken from it. The
+// template data sent by the caller is only accepted if UserDataSchema, the
+// schema of that data, is set, and it must be valid against it.
+//
+// TemplateName is the name of one of the certificate templates configured in
+// the authority, it cannot be used with Template or TemplateFile. The
+// TemplateData and Issuer of the provisioner override the ones of the named
+// template. If a provisioner does not have a template, the default one
+// configured in the authority, if any, is used.
+//
+// Issuer is the name of the intermediate that signs the certificates of the
+// provisioner, it can be overridden by the issuer returned by the template.
+type X509Options struct {
+	Template       string                 `json:"template,omitempty"`
+	TemplateFile   string                 `json:"templateFile,omitempty"`
+	TemplateData   map[string]interface{} `json:"templateData,omitempty"`
+	TemplateName   string                 `json:"templateName,omitempty"`
+	UserDataSchema *TemplateDataSchema    `json:"userDataSchema,omitempty"`
+	Issuer         string                 `json:"issuer,omitempty"`
+	template       *template.Template
+}
+
+// X509Template is the result of an X.509 certificate template. The subject
+// and subject alternative names of the certificate are replaced by the ones
+// in the template. KeyUsage and ExtKeyUsage replace the defaults if they are
+// not empty, and Extensions are added to the certificate. Issuer, if not
+// empty, is the name of the intermediate that signs the certificate.
+type X509Template struct {
+	Subject     X509Subject     `json:"subject"`
+	SANs        []X509SAN       `json:"sans"`
+	KeyUsage    []string        `json:"keyUsage,omitempty"`
+	ExtKeyUsage []string        `json:"extKeyUsage,omitempty"`
+	Extensions  []X509Extension `json:"extensions,omitempty"`
+	Issuer      string          `json:"issuer,omitempty"`
+}
+
+// X509Subject is the subject of an X.509 certificate template.
+type X509Subject struct {
+	CommonName         string   `json:"commonName,omitempty"`
+	Country            []string `json:"country,omitempty"`
+	Organization       []string `json:"organization,omitempty"`
+	OrganizationalUnit []string `json:"organizationalUnit,omitempty"`
+	Locality           []string `json:"locality,omitempty"`
+	Province           []string `json:"province,omitempty"`
+	StreetAddress      []string `json:"streetAddress,omitempty"`
+	PostalCode         []string `json:"postalCode,omitempty"`
+	SerialNumber       string   `json:"serialNumber,omitempty"`
+}
+
+// X509SAN is a subject alternative name of an X.509 certificate template. The
+// supported types are dns, email, ip and uri.
+type X509SAN struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// X509Extension is an extension of an X.509 certificate template. ID is the
+// object identifier in dot notation and Value is the base64 encoded DER value.
+type X509Extension struct {
+	ID       string `json:"id"`
+	Critical bool   `json:"critical,omitempty"`
+	Value    []byte `json:"value"`
+}
+
+var keyUsageNames = map[string]x509.KeyUsage{
+	"digitalSignature":  x509.KeyUsageDigitalSignature,
+	"contentCommitment": x509.KeyUsageContentCommitment,
+	"keyEncipherment":   x509.KeyUsageKeyEncipherment,
+	"dataEncipherment":  x509.KeyUsageDataEncipherment,
+	"keyAgreement":      x509.KeyUsageKeyAgreement,
+	"certSign":          x509.KeyUsageCertSign,
+	"crlSign":           x509.KeyUsageCRLSign,
+	"encipherOnly":      x509.KeyUsageEncipherOnly,
+	"decipherOnly":      x509.KeyUsageDecipherOnly,
+}
+
+// Init loads and parses the template.
+func (o *X509Options) Init() error {
+	if o == nil {
+		return nil
+	}
+	if err := o.UserDataSchema.Init(); err != nil {
+		return errors.Wrap(err, "error initializing userDataSchema")
+	}
+	text := o.Template
+	if o.TemplateName != "" {
+		if text != "" || o.TemplateFile != "" {
+			return errors.New("templateName cannot be used with template or templateFile")
+		}
+		return nil
+	}
+	if text == "" && o.TemplateFile != "" {
+		b, err := ioutil.ReadFile(o.TemplateFile)
+		if err != nil {
+			return errors.Wrapf(err, "error reading %s", o.TemplateFile)
+		}
+		text = string(b)
+	}
+	if text == "" {
+		if o.Issuer != "" {
+			return nil
+		}
+		return errors.New("template or templateFile cannot be empty")
+	}
+	for _, k := range []string{"Subject", "SANs", "Token", "Insecure"} {
+		if _, ok := o.TemplateData[k]; ok {
+			return errors.Errorf("templateData cannot contain the reserved key %s", k)
+		}
+	}
+	tmpl, err := template.New("x509").Funcs(sprig.TxtFuncMap()).Parse(text)
+	if err != nil {
+		return errors.Wrap(err, "error parsing template")
+	}
+	o.template = tmpl
+	return nil
+}
+
+// appendTemplateOption appends the option that applies the template to the
+// given sign options, if the template is configured, or the option that
+// selects the configured issuer. The claims of the given token are available
+// in the template.
+func (o *X509Options) appendTemplateOption(opts []SignOption, token string) []SignOption {
+	switch {
+	case o == nil:
+		return opts
+	case o.template != nil:
+		return append(opts, &x509TemplateModifier{
+			options: o,
+			claims:  tokenClaims(token),
+		})
+	case o.Issuer != "":
+		return append(opts, issuerSelector(o.Issuer))
+	default:
+		return opts
+	}
+}
+
+// tokenClaims returns the claims of the given token without validation, the
+// token must be already validated by the provisioner.
+func tokenClaims(token string) map[string]interface{} {
+	jwt, err := jose.ParseSigned(token)
+	if err != nil {
+		return nil
+	}
+	var claims map[string]interface{}
+	if err := jwt.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return nil
+	}
+	return claims
+}
+
+// x509TemplateModifier is the CertificateModifier that applies an X.509
+// certificate template. It's also the CertificateIssuerSelector that selects
+// the issuer of the template or the provisioner.
+type x509TemplateModifier struct {
+	options *X509Options
+	claims  map[string]interface{}
+	issuer  string
+}
+
+// Issuer returns the issuer in the template after Modify, or the issuer of
+// the provisioner.
+func (m *x509TemplateModifier) Issuer() string {
+	if m.issuer != "" {
+		return m.issuer
+	}
+	return m.options.Issuer
+}
+
+// Modify renders the template and applies the result to the certificate.
+func (m *x509TemplateModifier) Modify(cert *x509.Certificate, req *x509.CertificateRequest, o Options) error {
+	user, err := m.options.UserDataSchema.Validate(o.TemplateData)
+	if err != nil {
+		return errs.BadRequestErr(err, errs.WithMessage("invalid template data: %s", err))
+	}
+
+	data := make(map[string]interface{}, len(m.options.TemplateData)+4)
+	for k, v := range m.options.TemplateData {
+		data[k] = v
+	}
+	data["Subject"] = newX509Subject(req.Subject)
+	data["SANs"] = newX509SANs(req)
+	data["Token"] = m.claims
+	data["Insecure"] = map[string]interface{}{"CR": req, "User": user}
+
+	var buf bytes.Buffer
+	if err := m.options.template.Execute(&buf, data); err != nil {
+		return errors.Wrap(err, "error executing x509 template")
+	}
+	var t X509Template
+	if err := json.Unmarshal(buf.Bytes(), &t); err != nil {
+		return errors.Wrap(err, "error unmarshaling x509 template result")
+	}
+	m.issuer = t.Issuer
+	return t.apply(cert)
+}
+
+func newX509Subject(n pkix.Name) X509Subject {
+	return X509Subject{
+		CommonName:         n.CommonName,
+		Country:            n.Country,
+		Organization:       n.Organization,
+		OrganizationalUnit: n.OrganizationalUnit,
+		Locality:           n.Locality,
+		Province:           n.Province,
+		StreetAddress:      n.StreetAddress,
+		PostalCode:         n.PostalCode,
+		SerialNumber:       n.SerialNumber,
+	}
+}
+
+func newX509SANs(req *x509.CertificateRequest) []X509SAN {
+	sans := []X509SAN{}
+	for _, s := range req.DNSNames {
+		sans = append(sans, X509SAN{Type: "dns", Value: s})
+	}
+	for _, s := range req.EmailAddresses {
+		sans = append(sans, X509SAN{Type: "email", Value: s})
+	}
+	for _, ip := range req.IPAddresses {
+		sans = append(sans, X509SAN{Type: "ip", Value: ip.String()})
+	}
+	for _, u := range req.URIs {
+		sans = append(sans, X509SAN{Type: "uri", Value: u.String()})
+	}
+	return sans
+}
+
+// apply sets the values of the template in the given certificate.
+func (t *X509Template) apply(cert *x509.Certificate) error {
+	cert.Subject = pkix.Name{
+		CommonName:         t.Subject.CommonName,
+		Country:            t.Subject.Country,
+		Organization:       t.Subject.Organization,
+		OrganizationalUnit: t.Subject.OrganizationalUnit,
+		Locality:           t.Subject.Locality,
+		Province:           t.Subject.Province,
+		StreetAddress:      t.Subject.StreetAddress,
+		PostalCode:         t.Subject.PostalCode,
+		SerialNumber:       t.Subject.SerialNumber,
+	}
+
+	cert.DNSNames, cert.EmailAddresses, cert.IPAddresses, cert.URIs = nil, nil, nil, nil
+	for _, san := range t.SANs {
+		switch strings.ToLower(san.Type) {
+		case "dns":
+			cert.DNSNames = append(cert.DNSNames, san.Value)
+		case "email":
+			cert.EmailAddresses = append(cert.EmailAddresses, san.Value)
+		case "ip":
+			ip := net.ParseIP(san.Value)
+			if ip == nil {
+				return errors.Errorf("x509 template contains an invalid ip %s", san.Value)
+			}
+			cert.IPAddresses = append(cert.IPAddresses, ip)
+		case "uri":
+			u, err := url.Parse(san.Value)
+			if err != nil {
+				return errors.Wrapf(err, "x509 template contains an invalid uri %s", san.Value)
+			}
+			cert.URIs = append(cert.URIs, u)
+		default:
+			return errors.Errorf("x509 template contains an unsupported san type %s", san.Type)
+		}
+	}
+
+	if len(t.KeyUsage) > 0 {
+		var ku x509.KeyUsage
+		for _, name := range t.KeyUsage {
+			v, ok := keyUsageNames[name]
+			if !ok {
+				return errors.Errorf("x509 template contains an unsupported keyUsage %s", name)
+			}
+			ku |= v
+		}
+		cert.KeyUsage = ku
+	}
+
+	if len(t.ExtKeyUsage) > 0 {
+		ekus := make([]x509.ExtKeyUsage, len(t.ExtKeyUsage))
+		for i, name := range t.ExtKeyUsage {
+			v, ok := extKeyUsageNames[name]
+			if !ok {
+				return errors.Errorf("x509 template contains an unsupported extKeyUsage %s", name)
+			}
+			ekus[i] = v
+		}
+		cert.ExtKeyUsage = ekus
+	}
+
+	for _, e := range t.Extensions {
+		oid, err := parseObjectIdentifier(e.ID)
+		if err != nil {
+			return errors.Wrapf(err, "x509 template contains an invalid extension id %s", e.ID)
+		}
+		cert.ExtraExtensions = append(cert.ExtraExtensions, pkix.Extension{
+			Id:       oid,
+			Critical: e.Critical,
+			Value:    e.Value,
+		})
+	}
+	return nil
+}
+
+// parseObjectIdentifier parses an object identifier in dot notation.
+func parseObjectIdentifier(s string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 {
+		return nil, errors.New("object identifier must have at least two components")
+	}
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, errors.Errorf("object identifier component %s is not valid", p)
+		}
+		oid[i] = n
+	}
+	return oid, nil
+}
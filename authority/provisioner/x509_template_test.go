@@ -0,0 +1,290 @@
+package provisioner
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+)
+
+func TestX509Options_Init(t *testing.T) {
+	f, err := ioutil.TempFile("", "x509.tpl")
+	assert.FatalError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`{"subject": {{ toJson .Subject }}}`)
+	assert.FatalError(t, err)
+	assert.FatalError(t, f.Close())
+
+	tests := map[string]struct {
+		opts *X509Options
+		err  error
+	}{
+		"ok/nil":      {nil, nil},
+		"ok/template": {&X509Options{Template: `{"subject": {{ toJson .Subject }}}`}, nil},
+		"ok/file":     {&X509Options{TemplateFile: f.Name()}, nil},
+		"ok/issuer":   {&X509Options{Issuer: "rsa"}, nil},
+		"fail/empty":  {&X509Options{}, errors.New("template or templateFile cannot be empty")},
+		"fail/file":   {&X509Options{TemplateFile: "testdata/missing.tpl"}, errors.New("error reading testdata/missing.tpl")},
+		"fail/parse":  {&X509Options{Template: `{{ .Subject`}, errors.New("error parsing template")},
+		"fail/reserved": {
+			&X509Options{Template: `{}`, TemplateData: map[string]interface{}{"Token": "foo"}},
+			errors.New("templateData cannot contain the reserved key Token"),
+		},
+		"ok/templateName": {&X509Options{TemplateName: "leaf"}, nil},
+		"fail/templateName": {
+			&X509Options{Template: `{}`, TemplateName: "leaf"},
+			errors.New("templateName cannot be used with template or templateFile"),
+		},
+		"fail/userDataSchema": {
+			&X509Options{Template: `{}`, UserDataSchema: &TemplateDataSchema{Type: "foo"}},
+			errors.New("error initializing userDataSchema: type foo is not supported"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.opts.Init()
+			if tc.err != nil {
+				if assert.NotNil(t, err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+				return
+			}
+			assert.FatalError(t, err)
+			if tc.opts != nil && tc.opts.Issuer == "" && tc.opts.TemplateName == "" {
+				assert.NotNil(t, tc.opts.template)
+			}
+		})
+	}
+}
+
+func TestX509Options_appendTemplateOption(t *testing.T) {
+	var opts *X509Options
+	assert.Len(t, 1, opts.appendTemplateOption([]SignOption{defaultPublicKeyValidator{}}, ""))
+	assert.Len(t, 1, (&X509Options{}).appendTemplateOption([]SignOption{defaultPublicKeyValidator{}}, ""))
+
+	opts = &X509Options{Template: `{}`}
+	assert.FatalError(t, opts.Init())
+	jwk, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	token, err := generateToken("subject", "issuer", "audience", "name@smallstep.com", nil, time.Now(), jwk)
+	assert.FatalError(t, err)
+
+	so := opts.appendTemplateOption([]SignOption{defaultPublicKeyValidator{}}, token)
+	if assert.Len(t, 2, so) {
+		m, ok := so[1].(*x509TemplateModifier)
+		if assert.True(t, ok) {
+			assert.Equals(t, opts, m.options)
+			assert.Equals(t, "subject", m.claims["sub"])
+			assert.Equals(t, "name@smallstep.com", m.claims["email"])
+		}
+	}
+	so = opts.appendTemplateOption(nil, "")
+	if assert.Len(t, 1, so) {
+		assert.Nil(t, so[0].(*x509TemplateModifier).claims)
+	}
+
+	opts = &X509Options{Issuer: "rsa"}
+	assert.FatalError(t, opts.Init())
+	so = opts.appendTemplateOption(nil, "")
+	if assert.Len(t, 1, so) {
+		assert.Equals(t, issuerSelector("rsa"), so[0])
+		assert.Equals(t, "rsa", so[0].(CertificateIssuerSelector).Issuer())
+	}
+}
+
+func TestX509TemplateModifier_Issuer(t *testing.T) {
+	req := &x509.CertificateRequest{Subject: pkix.Name{CommonName: "foo.smallstep.com"}}
+	tests := map[string]struct {
+		template string
+		issuer   string
+		want     string
+	}{
+		"empty":       {`{"subject": {{ toJson .Subject }}}`, "", ""},
+		"provisioner": {`{"subject": {{ toJson .Subject }}}`, "ec", "ec"},
+		"template":    {`{"subject": {{ toJson .Subject }}, "issuer": "rsa"}`, "ec", "rsa"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			opts := &X509Options{Template: tc.template, Issuer: tc.issuer}
+			assert.FatalError(t, opts.Init())
+			m := &x509TemplateModifier{options: opts}
+			assert.FatalError(t, m.Modify(&x509.Certificate{}, req, Options{}))
+			assert.Equals(t, tc.want, m.Issuer())
+		})
+	}
+}
+
+func TestX509TemplateModifier_Modify(t *testing.T) {
+	u, err := url.Parse("spiffe://smallstep.com/foo")
+	assert.FatalError(t, err)
+	req := &x509.CertificateRequest{
+		Subject:        pkix.Name{CommonName: "foo.smallstep.com", Organization: []string{"Smallstep"}},
+		DNSNames:       []string{"foo.smallstep.com"},
+		EmailAddresses: []string{"foo@smallstep.com"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+		URIs:           []*url.URL{u},
+	}
+	newCert := func() *x509.Certificate {
+		return &x509.Certificate{
+			Subject:     req.Subject,
+			DNSNames:    req.DNSNames,
+			KeyUsage:    x509.KeyUsageDigitalSignature,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+	}
+
+	tests := map[string]struct {
+		template string
+		data     map[string]interface{}
+		claims   map[string]interface{}
+		schema   *TemplateDataSchema
+		user     string
+		want     *x509.Certificate
+		err      error
+	}{
+		"ok/passthrough": {
+			template: `{"subject": {{ toJson .Subject }}, "sans": {{ toJson .SANs }}}`,
+			want: &x509.Certificate{
+				Subject:        pkix.Name{CommonName: "foo.smallstep.com", Organization: []string{"Smallstep"}},
+				DNSNames:       []string{"foo.smallstep.com"},
+				EmailAddresses: []string{"foo@smallstep.com"},
+				IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+				URIs:           []*url.URL{u},
+				KeyUsage:       x509.KeyUsageDigitalSignature,
+				ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			},
+		},
+		"ok/token-and-data": {
+			template: `{
+				"subject": {"commonName": {{ toJson .Token.email }}, "organizationalUnit": [{{ toJson .OU }}]},
+				"sans": [{"type": "email", "value": {{ toJson .Token.email }}}],
+				"keyUsage": ["digitalSignature", "keyEncipherment"],
+				"extKeyUsage": ["clientAuth"],
+				"extensions": [{"id": "1.2.3.4", "critical": true, "value": "MAA="}]
+			}`,
+			data:   map[string]interface{}{"OU": "Engineering"},
+			claims: map[string]interface{}{"email": "jane@smallstep.com"},
+			want: &x509.Certificate{
+				Subject:        pkix.Name{CommonName: "jane@smallstep.com", OrganizationalUnit: []string{"Engineering"}},
+				EmailAddresses: []string{"jane@smallstep.com"},
+				KeyUsage:       x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+				ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+				ExtraExtensions: []pkix.Extension{
+					{Id: asn1.ObjectIdentifier{1, 2, 3, 4}, Critical: true, Value: []byte{0x30, 0x00}},
+				},
+			},
+		},
+		"ok/insecure": {
+			template: `{"subject": {"commonName": {{ toJson (index .Insecure.CR.DNSNames 0) }}}, "sans": []}`,
+			want: &x509.Certificate{
+				Subject:     pkix.Name{CommonName: "foo.smallstep.com"},
+				KeyUsage:    x509.KeyUsageDigitalSignature,
+				ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			},
+		},
+		"ok/user": {
+			template: `{"subject": {"commonName": {{ toJson .Insecure.User.name }}}, "sans": []}`,
+			schema:   &TemplateDataSchema{Type: "object", Required: []string{"name"}},
+			user:     `{"name": "jane"}`,
+			want: &x509.Certificate{
+				Subject:     pkix.Name{CommonName: "jane"},
+				KeyUsage:    x509.KeyUsageDigitalSignature,
+				ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			},
+		},
+		"fail/user-not-allowed": {
+			template: `{"subject": {{ toJson .Subject }}}`,
+			user:     `{"name": "jane"}`,
+			err:      errors.New("template data is not allowed"),
+		},
+		"fail/user-schema": {
+			template: `{"subject": {{ toJson .Subject }}}`,
+			schema:   &TemplateDataSchema{Type: "object", Required: []string{"name"}},
+			user:     `{"email": "jane@smallstep.com"}`,
+			err:      errors.New("templateData.name is required"),
+		},
+		"fail/execute": {
+			template: `{{ fail "name not allowed" }}`,
+			err:      errors.New("error executing x509 template"),
+		},
+		"fail/json": {
+			template: `{`,
+			err:      errors.New("error unmarshaling x509 template result"),
+		},
+		"fail/san-type": {
+			template: `{"sans": [{"type": "foo", "value": "bar"}]}`,
+			err:      errors.New("x509 template contains an unsupported san type foo"),
+		},
+		"fail/ip": {
+			template: `{"sans": [{"type": "ip", "value": "foo"}]}`,
+			err:      errors.New("x509 template contains an invalid ip foo"),
+		},
+		"fail/uri": {
+			template: `{"sans": [{"type": "uri", "value": "%"}]}`,
+			err:      errors.New("x509 template contains an invalid uri %"),
+		},
+		"fail/keyUsage": {
+			template: `{"keyUsage": ["foo"]}`,
+			err:      errors.New("x509 template contains an unsupported keyUsage foo"),
+		},
+		"fail/extKeyUsage": {
+			template: `{"extKeyUsage": ["foo"]}`,
+			err:      errors.New("x509 template contains an unsupported extKeyUsage foo"),
+		},
+		"fail/extension": {
+			template: `{"extensions": [{"id": "1.foo", "value": "MAA="}]}`,
+			err:      errors.New("x509 template contains an invalid extension id 1.foo"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			opts := &X509Options{Template: tc.template, TemplateData: tc.data, UserDataSchema: tc.schema}
+			assert.FatalError(t, opts.Init())
+			cert := newCert()
+			m := &x509TemplateModifier{options: opts, claims: tc.claims}
+			var o Options
+			if tc.user != "" {
+				o.TemplateData = []byte(tc.user)
+			}
+			if err := m.Modify(cert, req, o); err != nil {
+				if assert.NotNil(t, tc.err, err.Error()) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, tc.want, cert)
+			}
+		})
+	}
+}
+
+func TestJWK_AuthorizeSign_x509Template(t *testing.T) {
+	p, err := generateJWK()
+	assert.FatalError(t, err)
+	key, err := decryptJSONWebKey(p.EncryptedKey)
+	assert.FatalError(t, err)
+	p.X509 = &X509Options{Template: `{"subject": {"commonName": {{ toJson .Token.sub }}}}`}
+	assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims, Audiences: testAudiences}))
+
+	token, err := generateToken("subject", p.Name, testAudiences.Sign[0], "name@smallstep.com", []string{"foo"}, time.Now(), key)
+	assert.FatalError(t, err)
+	ctx := NewContextWithMethod(context.Background(), SignMethod)
+	so, err := p.AuthorizeSign(ctx, token)
+	assert.FatalError(t, err)
+	if assert.Len(t, 10, so) {
+		m, ok := so[9].(*x509TemplateModifier)
+		if assert.True(t, ok) {
+			cert := &x509.Certificate{}
+			assert.FatalError(t, m.Modify(cert, &x509.CertificateRequest{}, Options{}))
+			assert.Equals(t, "subject", cert.Subject.CommonName)
+		}
+	}
+}
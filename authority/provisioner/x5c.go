@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/errs"
 	"github.com/smallstep/cli/crypto/x509util"
 	"github.com/smallstep/cli/jose"
@@ -21,17 +22,28 @@ type x5cPayload struct {
 	chains [][]*x509.Certificate
 }
 
-// X5C is the default provisioner, an entity that can sign tokens necessary for
-// signature requests.
+// X5C is the provisioner that accepts tokens signed by the key of a
+// certificate, with the certificate chain in the x5c header of the token. The
+// chain must verify against the configured roots, which allows delegating
+// issuance to the holders of certificates from another internal CA.
+//
+// The leaf certificate must have the digital signature key usage; any
+// extended key usage is accepted.
 type X5C struct {
 	*base
-	Type      string  `json:"type"`
-	Name      string  `json:"name"`
-	Roots     []byte  `json:"roots"`
-	Claims    *Claims `json:"claims,omitempty"`
-	claimer   *Claimer
-	audiences Audiences
-	rootPool  *x509.CertPool
+	Type         string              `json:"type"`
+	Name         string              `json:"name"`
+	Roots        []byte              `json:"roots"`
+	Claims       *Claims             `json:"claims,omitempty"`
+	X509         *X509Options        `json:"x509,omitempty"`
+	SSH          *SSHTemplateOptions `json:"ssh,omitempty"`
+	Policy       *policy.Options     `json:"policy,omitempty"`
+	x509Template *X509Options
+	sshTemplate  *SSHTemplateOptions
+	claimer      *Claimer
+	policy       *policy.Engine
+	audiences    Audiences
+	rootPool     *x509.CertPool
 }
 
 // GetID returns the provisioner unique identifier. The name and credential id
@@ -113,6 +125,25 @@ func (p *X5C) Init(config Config) error {
 		return err
 	}
 
+	if err := p.X509.Init(); err != nil {
+		return errors.Wrap(err, "provisioner x509")
+	}
+	if p.x509Template, err = config.Templates.x509Template(p.X509); err != nil {
+		return errors.Wrap(err, "provisioner x509")
+	}
+	if err := p.SSH.Init(); err != nil {
+		return errors.Wrap(err, "provisioner ssh")
+	}
+	if p.sshTemplate, err = config.Templates.sshTemplate(p.SSH); err != nil {
+		return errors.Wrap(err, "provisioner ssh")
+	}
+
+	engine, err := policy.New(p.Policy)
+	if err != nil {
+		return errors.Wrap(err, "provisioner policy")
+	}
+	p.policy = engine
+
 	p.audiences = config.Audiences.WithFragment(p.GetID())
 	return nil
 }
@@ -127,7 +158,8 @@ func (p *X5C) authorizeToken(token string, audiences []string) (*x5cPayload, err
 	}
 
 	verifiedChains, err := jwt.Headers[0].Certificates(x509.VerifyOptions{
-		Roots: p.rootPool,
+		Roots:     p.rootPool,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
 	})
 	if err != nil {
 		return nil, errs.Wrap(http.StatusUnauthorized, err,
@@ -196,7 +228,7 @@ func (p *X5C) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 
 	dnsNames, ips, emails := x509util.SplitSANs(claims.SANs)
 
-	return []SignOption{
+	so := []SignOption{
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeX5C, p.Name, ""),
 		profileLimitDuration{p.claimer.DefaultTLSCertDuration(), claims.chains[0][0].NotAfter},
@@ -207,7 +239,9 @@ func (p *X5C) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 		emailAddressesValidator(emails),
 		ipAddressesValidator(ips),
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
-	}, nil
+		newX509NamePolicyValidator(p.policy),
+	}
+	return p.x509Template.appendTemplateOption(so, token), nil
 }
 
 // AuthorizeRenew returns an error if the renewal is disabled.
@@ -215,6 +249,9 @@ func (p *X5C) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) error
 	if p.claimer.IsDisableRenewal() {
 		return errs.Unauthorized("x5c.AuthorizeRenew; renew is disabled for x5c provisioner %s", p.GetID())
 	}
+	if !p.claimer.IsRenewable(cert) {
+		return errs.Unauthorized("x5c.AuthorizeRenew; certificate expired on %s", cert.NotAfter.Format(time.RFC3339))
+	}
 	return nil
 }
 
@@ -262,7 +299,7 @@ func (p *X5C) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	// Default to a user certificate with no principals if not set
 	signOptions = append(signOptions, sshCertDefaultsModifier{CertType: SSHUserCert})
 
-	return append(signOptions,
+	signOptions = append(signOptions,
 		// Set the default extensions.
 		&sshDefaultExtensionModifier{},
 		// Checks the validity bounds, and set the validity if has not been set.
@@ -273,7 +310,10 @@ func (p *X5C) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 		&sshDefaultPublicKeyValidator{},
 		// Validate the validity period.
 		&sshCertValidityValidator{p.claimer},
+		// Validate the principals against the name policy.
+		&sshNamePolicyValidator{p.policy},
 		// Require all the fields in the SSH certificate
 		&sshCertDefaultValidator{},
-	), nil
+	)
+	return p.sshTemplate.appendTemplateOption(signOptions, token), nil
 }
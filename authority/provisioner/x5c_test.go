@@ -2,6 +2,13 @@ package provisioner
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
 	"net"
 	"net/http"
 	"testing"
@@ -379,6 +386,48 @@ lgsqsR63is+0YQ==
 				token: tok,
 			}
 		},
+		"ok/client-auth-only": func(t *testing.T) test {
+			rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			assert.FatalError(t, err)
+			rootTmpl := &x509.Certificate{
+				SerialNumber:          big.NewInt(1),
+				Subject:               pkix.Name{CommonName: "other-root"},
+				NotBefore:             time.Now().Add(-time.Minute),
+				NotAfter:              time.Now().Add(time.Hour),
+				KeyUsage:              x509.KeyUsageCertSign,
+				BasicConstraintsValid: true,
+				IsCA:                  true,
+			}
+			rootDer, err := x509.CreateCertificate(rand.Reader, rootTmpl, rootTmpl, rootKey.Public(), rootKey)
+			assert.FatalError(t, err)
+			root, err := x509.ParseCertificate(rootDer)
+			assert.FatalError(t, err)
+
+			leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			assert.FatalError(t, err)
+			leafDer, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+				SerialNumber: big.NewInt(2),
+				Subject:      pkix.Name{CommonName: "client"},
+				NotBefore:    time.Now().Add(-time.Minute),
+				NotAfter:     time.Now().Add(time.Hour),
+				KeyUsage:     x509.KeyUsageDigitalSignature,
+				ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			}, root, leafKey.Public(), rootKey)
+			assert.FatalError(t, err)
+			leaf, err := x509.ParseCertificate(leafDer)
+			assert.FatalError(t, err)
+
+			p, err := generateX5C(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootDer}))
+			assert.FatalError(t, err)
+			tok, err := generateToken("foo", p.GetName(), testAudiences.Sign[0], "",
+				[]string{"test.smallstep.com"}, time.Now(), &jose.JSONWebKey{Key: leafKey},
+				withX5CHdr([]*x509.Certificate{leaf}))
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+			}
+		},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -496,12 +545,13 @@ func TestX5C_AuthorizeSign(t *testing.T) {
 							case *validityValidator:
 								assert.Equals(t, v.min, tc.p.claimer.MinTLSCertDuration())
 								assert.Equals(t, v.max, tc.p.claimer.MaxTLSCertDuration())
+							case *x509NamePolicyValidator:
 							default:
 								assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
 							}
 							tot++
 						}
-						assert.Equals(t, tot, 8)
+						assert.Equals(t, tot, 9)
 					}
 				}
 			}
@@ -594,7 +644,7 @@ func TestX5C_AuthorizeRenew(t *testing.T) {
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := tt(t)
-			if err := tc.p.AuthorizeRenew(context.Background(), nil); err != nil {
+			if err := tc.p.AuthorizeRenew(context.Background(), &x509.Certificate{NotAfter: time.Now().Add(time.Hour)}); err != nil {
 				if assert.NotNil(t, tc.err) {
 					sc, ok := err.(errs.StatusCoder)
 					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
@@ -793,15 +843,16 @@ func TestX5C_AuthorizeSSHSign(t *testing.T) {
 								*sshCertDefaultValidator:
 							case sshCertKeyIDValidator:
 								assert.Equals(t, string(v), "foo")
+							case *sshNamePolicyValidator:
 							default:
 								assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
 							}
 							tot++
 						}
 						if len(tc.claims.Step.SSH.CertType) > 0 {
-							assert.Equals(t, tot, 13)
+							assert.Equals(t, tot, 14)
 						} else {
-							assert.Equals(t, tot, 9)
+							assert.Equals(t, tot, 10)
 						}
 					}
 				}
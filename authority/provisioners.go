@@ -2,9 +2,11 @@ package authority
 
 import (
 	"crypto/x509"
+	"net/http"
 
 	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/jose"
 )
 
 // GetEncryptedKey returns the JWE key corresponding to the given kid argument.
@@ -33,6 +35,24 @@ func (a *Authority) LoadProvisionerByCertificate(crt *x509.Certificate) (provisi
 	return p, nil
 }
 
+// LoadProvisionerByToken returns an interface to the provisioner that
+// generated the token. The token is not verified.
+func (a *Authority) LoadProvisionerByToken(token string) (provisioner.Interface, error) {
+	tok, err := jose.ParseSigned(token)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "authority.LoadProvisionerByToken: error parsing token")
+	}
+	var claims jose.Claims
+	if err := tok.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "authority.LoadProvisionerByToken")
+	}
+	p, ok := a.provisioners.LoadByToken(tok, &claims)
+	if !ok {
+		return nil, errs.NotFound("provisioner not found")
+	}
+	return p, nil
+}
+
 // LoadProvisionerByID returns an interface to the provisioner with the given ID.
 func (a *Authority) LoadProvisionerByID(id string) (provisioner.Interface, error) {
 	p, ok := a.provisioners.Load(id)
@@ -0,0 +1,64 @@
+package authority
+
+import (
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ProxyConfig configures a CA that runs behind a reverse proxy like Envoy or
+// nginx. When it's set, the X-Forwarded-Proto and X-Forwarded-Host headers
+// set by the trusted proxies are used to build the absolute URLs in the ACME
+// responses.
+type ProxyConfig struct {
+	// InsecurePlainHTTP serves the CA over plain HTTP, the proxy in front of
+	// the CA must terminate TLS. The endpoints that require a client
+	// certificate, like renew or rekey, cannot be used in this mode.
+	InsecurePlainHTTP bool `json:"insecurePlainHTTP,omitempty"`
+	// TrustedProxies is the list of IP addresses and CIDRs of the proxies in
+	// front of the CA. The X-Forwarded-For, X-Real-IP, X-Forwarded-Proto and
+	// X-Forwarded-Host headers are only used in requests from these proxies,
+	// or from the peers of a unix socket.
+	TrustedProxies []string `json:"trustedProxies,omitempty"`
+}
+
+// Validate checks the fields in ProxyConfig.
+func (c *ProxyConfig) Validate() error {
+	_, err := c.TrustedNetworks()
+	return err
+}
+
+// IsPlainHTTP returns true if the CA must be served over plain HTTP.
+func (c *ProxyConfig) IsPlainHTTP() bool {
+	return c != nil && c.InsecurePlainHTTP
+}
+
+// TrustedNetworks returns the networks of the trusted proxies. IP addresses
+// are returned as networks with a single address.
+func (c *ProxyConfig) TrustedNetworks() ([]*net.IPNet, error) {
+	if c == nil {
+		return nil, nil
+	}
+	var nets []*net.IPNet
+	for _, s := range c.TrustedProxies {
+		if strings.Contains(s, "/") {
+			_, ipNet, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, errors.Errorf("proxy.trustedProxies %s is not a valid CIDR", s)
+			}
+			nets = append(nets, ipNet)
+			continue
+		}
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, errors.Errorf("proxy.trustedProxies %s is not a valid IP address", s)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return nets, nil
+}
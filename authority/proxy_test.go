@@ -0,0 +1,38 @@
+package authority
+
+import (
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+func TestProxyConfig_TrustedNetworks(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *ProxyConfig
+		want    []string
+		wantErr bool
+	}{
+		{"nil", nil, nil, false},
+		{"empty", &ProxyConfig{InsecurePlainHTTP: true}, nil, false},
+		{"ok", &ProxyConfig{TrustedProxies: []string{"10.0.0.0/8", "192.168.1.10", "fd00::/8", "::1"}}, []string{"10.0.0.0/8", "192.168.1.10/32", "fd00::/8", "::1/128"}, false},
+		{"fail cidr", &ProxyConfig{TrustedProxies: []string{"10.0.0.0/33"}}, nil, true},
+		{"fail ip", &ProxyConfig{TrustedProxies: []string{"proxy.internal"}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nets, err := tt.config.TrustedNetworks()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ProxyConfig.TrustedNetworks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("ProxyConfig.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, n := range nets {
+				got = append(got, n.String())
+			}
+			assert.Equals(t, tt.want, got)
+		})
+	}
+}
@@ -2,6 +2,7 @@ package authority
 
 import (
 	"crypto/x509"
+	"net/http"
 
 	"github.com/smallstep/certificates/errs"
 )
@@ -42,6 +43,36 @@ func (a *Authority) GetRoots() ([]*x509.Certificate, error) {
 	return a.rootX509Certs, nil
 }
 
+// GetUpcomingRoots returns the root certificates that will replace the
+// current ones. They are not used to issue certificates yet, but they are
+// distributed in advance so the clients trust them when the rotation happens.
+func (a *Authority) GetUpcomingRoots() ([]*x509.Certificate, error) {
+	return a.upcomingX509Certs, nil
+}
+
+// AuthorizeRoots returns an error if the roots and federation endpoints
+// require a client certificate and the given one, already verified by the TLS
+// server, is missing or revoked.
+func (a *Authority) AuthorizeRoots(peer *x509.Certificate) error {
+	if !a.config.AuthorityConfig.AuthenticateRoots {
+		return nil
+	}
+	if peer == nil {
+		return errs.Unauthorized("authority.AuthorizeRoots; a valid client certificate is required")
+	}
+	serial := peer.SerialNumber.String()
+	isRevoked, err := a.db.IsRevoked(serial)
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "authority.AuthorizeRoots",
+			errs.WithKeyVal("serialNumber", serial))
+	}
+	if isRevoked {
+		return errs.Unauthorized("authority.AuthorizeRoots; certificate has been revoked",
+			errs.WithKeyVal("serialNumber", serial))
+	}
+	return nil
+}
+
 // GetFederation returns all the root certificates in the federation.
 // This method implements the Authority interface.
 func (a *Authority) GetFederation() (federation []*x509.Certificate, err error) {
@@ -1,13 +1,16 @@
 package authority
 
 import (
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/hex"
 	"net/http"
 	"reflect"
 	"testing"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/db"
 	"github.com/smallstep/certificates/errs"
 	"github.com/smallstep/cli/crypto/pemutil"
 )
@@ -151,3 +154,63 @@ func TestAuthority_GetFederation(t *testing.T) {
 		})
 	}
 }
+
+func TestAuthority_GetUpcomingRoots(t *testing.T) {
+	root, err := pemutil.ReadCertificate("testdata/certs/root_ca.crt")
+	assert.FatalError(t, err)
+	upcoming, err := pemutil.ReadCertificate("testdata/certs/intermediate_ca.crt")
+	assert.FatalError(t, err)
+
+	a := testAuthority(t)
+	roots, err := a.GetUpcomingRoots()
+	assert.FatalError(t, err)
+	assert.Len(t, 0, roots)
+
+	a = testAuthority(t, WithX509UpcomingRootCerts(upcoming))
+	roots, err = a.GetUpcomingRoots()
+	assert.FatalError(t, err)
+	assert.Equals(t, []*x509.Certificate{upcoming}, roots)
+	roots, err = a.GetRoots()
+	assert.FatalError(t, err)
+	assert.Equals(t, []*x509.Certificate{root}, roots)
+
+	// The upcoming roots are also in the federation.
+	federation, err := a.GetFederation()
+	assert.FatalError(t, err)
+	assert.Len(t, 2, federation)
+	sum := sha256.Sum256(upcoming.Raw)
+	crt, err := a.Root(hex.EncodeToString(sum[:]))
+	assert.FatalError(t, err)
+	assert.Equals(t, upcoming, crt)
+}
+
+func TestAuthority_AuthorizeRoots(t *testing.T) {
+	crt, err := pemutil.ReadCertificate("testdata/certs/foo.crt")
+	assert.FatalError(t, err)
+
+	a := testAuthority(t)
+	assert.FatalError(t, a.AuthorizeRoots(nil))
+
+	tests := map[string]struct {
+		peer *x509.Certificate
+		db   *db.MockAuthDB
+		code int
+	}{
+		"ok":           {crt, &db.MockAuthDB{Ret1: false}, 0},
+		"fail/missing": {nil, &db.MockAuthDB{}, http.StatusUnauthorized},
+		"fail/revoked": {crt, &db.MockAuthDB{MIsRevoked: func(string) (bool, error) { return true, nil }}, http.StatusUnauthorized},
+		"fail/db":      {crt, &db.MockAuthDB{MIsRevoked: func(string) (bool, error) { return false, errors.New("force") }}, http.StatusInternalServerError},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			a.config.AuthorityConfig.AuthenticateRoots = true
+			a.db = tc.db
+			err := a.AuthorizeRoots(tc.peer)
+			if tc.code == 0 {
+				assert.FatalError(t, err)
+			} else if assert.NotNil(t, err) {
+				assert.Equals(t, tc.code, err.(errs.StatusCoder).StatusCode())
+			}
+		})
+	}
+}
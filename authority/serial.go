@@ -0,0 +1,127 @@
+package authority
+
+import (
+	"crypto/rand"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/cli/crypto/x509util"
+)
+
+const (
+	// defaultSerialNumberBits is the default number of random bits in the
+	// serial numbers.
+	defaultSerialNumberBits = 128
+	// minSerialNumberBits is the minimum number of random bits in the serial
+	// numbers, the CA/Browser Forum requires 64 bits of CSPRNG output.
+	minSerialNumberBits = 64
+	// maxSerialNumberBits is the maximum size of a serial number, RFC 5280
+	// limits them to 20 octets and they must be positive.
+	maxSerialNumberBits = 159
+	// timestampBits is the size of the timestamp of monotonic serial numbers.
+	timestampBits = 64
+)
+
+// SerialNumberConfig represents the configuration of the serial numbers of the
+// X.509 certificates.
+//
+// Bits is the number of random bits generated with a CSPRNG, 128 by default
+// and at least 64. If Monotonic is true, the random bits are preceded by a
+// 64-bit timestamp in nanoseconds that increases with every certificate, so
+// serial numbers are unique and ordered by issuance, and Bits cannot be
+// greater than 95.
+type SerialNumberConfig struct {
+	Bits      int  `json:"bits,omitempty"`
+	Monotonic bool `json:"monotonic,omitempty"`
+}
+
+// Validate checks the fields in SerialNumberConfig.
+func (c *SerialNumberConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	switch {
+	case c.Bits != 0 && c.Bits < minSerialNumberBits:
+		return errors.Errorf("bits cannot be less than %d", minSerialNumberBits)
+	case c.Monotonic && c.bits() > maxSerialNumberBits-timestampBits:
+		return errors.Errorf("bits cannot be greater than %d with monotonic serial numbers", maxSerialNumberBits-timestampBits)
+	case c.bits() > maxSerialNumberBits:
+		return errors.Errorf("bits cannot be greater than %d", maxSerialNumberBits)
+	}
+	return nil
+}
+
+func (c *SerialNumberConfig) bits() int {
+	if c == nil || c.Bits == 0 {
+		if c != nil && c.Monotonic {
+			return minSerialNumberBits
+		}
+		return defaultSerialNumberBits
+	}
+	return c.Bits
+}
+
+// serialNumberGenerator generates the serial numbers of the X.509
+// certificates. A nil generator generates 128-bit random serial numbers.
+type serialNumberGenerator struct {
+	bits      int
+	monotonic bool
+	mu        sync.Mutex
+	last      uint64
+}
+
+func newSerialNumberGenerator(c *SerialNumberConfig) *serialNumberGenerator {
+	return &serialNumberGenerator{
+		bits:      c.bits(),
+		monotonic: c != nil && c.Monotonic,
+	}
+}
+
+// Next returns a new positive serial number.
+func (g *serialNumberGenerator) Next() (*big.Int, error) {
+	bits := defaultSerialNumberBits
+	if g != nil {
+		bits = g.bits
+	}
+	limit := new(big.Int).Lsh(big.NewInt(1), uint(bits))
+	sn, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "error generating serial number")
+	}
+	if g == nil || !g.monotonic {
+		if sn.Sign() == 0 {
+			return g.Next()
+		}
+		return sn, nil
+	}
+
+	ts := new(big.Int).SetUint64(g.timestamp())
+	return sn.Or(sn, ts.Lsh(ts, uint(bits))), nil
+}
+
+// timestamp returns the current time in nanoseconds, or the last value plus
+// one if the clock has not advanced or went backwards.
+func (g *serialNumberGenerator) timestamp() uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ts := uint64(time.Now().UnixNano())
+	if ts <= g.last {
+		ts = g.last + 1
+	}
+	g.last = ts
+	return ts
+}
+
+// withSerialNumber sets a new serial number in the certificate.
+func withSerialNumber(g *serialNumberGenerator) x509util.WithOption {
+	return func(p x509util.Profile) error {
+		sn, err := g.Next()
+		if err != nil {
+			return err
+		}
+		p.Subject().SerialNumber = sn
+		return nil
+	}
+}
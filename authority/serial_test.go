@@ -0,0 +1,105 @@
+package authority
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+func TestSerialNumberConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		config *SerialNumberConfig
+		err    string
+	}{
+		"ok/nil":              {nil, ""},
+		"ok/empty":            {&SerialNumberConfig{}, ""},
+		"ok/bits":             {&SerialNumberConfig{Bits: 64}, ""},
+		"ok/max":              {&SerialNumberConfig{Bits: 159}, ""},
+		"ok/monotonic":        {&SerialNumberConfig{Monotonic: true}, ""},
+		"ok/monotonic-max":    {&SerialNumberConfig{Bits: 95, Monotonic: true}, ""},
+		"fail/min":            {&SerialNumberConfig{Bits: 63}, "bits cannot be less than 64"},
+		"fail/negative":       {&SerialNumberConfig{Bits: -1}, "bits cannot be less than 64"},
+		"fail/max":            {&SerialNumberConfig{Bits: 160}, "bits cannot be greater than 159"},
+		"fail/monotonic-max":  {&SerialNumberConfig{Bits: 96, Monotonic: true}, "bits cannot be greater than 95 with monotonic serial numbers"},
+		"fail/monotonic-bits": {&SerialNumberConfig{Bits: 128, Monotonic: true}, "bits cannot be greater than 95 with monotonic serial numbers"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.config.Validate()
+			if tc.err == "" {
+				assert.FatalError(t, err)
+			} else if assert.NotNil(t, err) {
+				assert.Equals(t, tc.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestSerialNumberGenerator_Next(t *testing.T) {
+	tests := map[string]struct {
+		g       *serialNumberGenerator
+		maxBits int
+	}{
+		"nil":          {nil, 128},
+		"default":      {newSerialNumberGenerator(nil), 128},
+		"bits":         {newSerialNumberGenerator(&SerialNumberConfig{Bits: 64}), 64},
+		"bits-159":     {newSerialNumberGenerator(&SerialNumberConfig{Bits: 159}), 159},
+		"monotonic":    {newSerialNumberGenerator(&SerialNumberConfig{Monotonic: true}), 128},
+		"monotonic-95": {newSerialNumberGenerator(&SerialNumberConfig{Bits: 95, Monotonic: true}), 159},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			seen := map[string]bool{}
+			for i := 0; i < 100; i++ {
+				sn, err := tc.g.Next()
+				assert.FatalError(t, err)
+				assert.Equals(t, 1, sn.Sign())
+				assert.True(t, sn.BitLen() <= tc.maxBits, "serial number is too large")
+				assert.False(t, seen[sn.String()], "serial number is repeated")
+				seen[sn.String()] = true
+			}
+		})
+	}
+}
+
+func TestSerialNumberGenerator_monotonic(t *testing.T) {
+	g := newSerialNumberGenerator(&SerialNumberConfig{Monotonic: true})
+	start := uint64(time.Now().UnixNano())
+
+	// A clock that goes backwards must not repeat timestamps.
+	g.last = start + uint64(time.Hour)
+	prev, err := g.Next()
+	assert.FatalError(t, err)
+	for i := 0; i < 100; i++ {
+		sn, err := g.Next()
+		assert.FatalError(t, err)
+		assert.Equals(t, 1, sn.Cmp(prev))
+		ts := new(big.Int).Rsh(sn, minSerialNumberBits)
+		assert.Equals(t, start+uint64(time.Hour)+uint64(i)+2, ts.Uint64())
+		prev = sn
+	}
+}
+
+func TestAuthority_Sign_serialNumber(t *testing.T) {
+	a := testAuthority(t)
+	a.serialNumbers = newSerialNumberGenerator(&SerialNumberConfig{Bits: 64, Monotonic: true})
+
+	now := time.Now()
+	signOpts := provisioner.Options{
+		NotBefore: provisioner.NewTimeDuration(now),
+		NotAfter:  provisioner.NewTimeDuration(now.Add(time.Hour)),
+	}
+	key := mustSigner(t)
+
+	chain, err := a.Sign(getCSR(t, key), signOpts)
+	assert.FatalError(t, err)
+	first := chain[0].SerialNumber
+	assert.True(t, first.BitLen() > 64, "serial number does not include the timestamp")
+
+	chain, err = a.Renew(chain[0])
+	assert.FatalError(t, err)
+	assert.Equals(t, 1, chain[0].SerialNumber.Cmp(first))
+}
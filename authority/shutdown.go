@@ -0,0 +1,37 @@
+package authority
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+// DefaultDrainTimeout is the default time to wait for the in-flight requests
+// and background workers to finish when the CA is stopped.
+const DefaultDrainTimeout = 60 * time.Second
+
+// ShutdownConfig configures how the CA is stopped on SIGINT or SIGTERM. The
+// CA stops accepting new connections, waits for the in-flight requests and
+// ACME validations to finish up to the drain timeout, and then closes the
+// database.
+type ShutdownConfig struct {
+	DrainTimeout *provisioner.Duration `json:"drainTimeout,omitempty"`
+}
+
+// Validate checks the fields in ShutdownConfig.
+func (c *ShutdownConfig) Validate() error {
+	if c != nil && c.DrainTimeout != nil && c.DrainTimeout.Duration <= 0 {
+		return errors.New("shutdown.drainTimeout must be greater than 0")
+	}
+	return nil
+}
+
+// GetDrainTimeout returns the time to wait for the in-flight requests and
+// background workers to finish.
+func (c *ShutdownConfig) GetDrainTimeout() time.Duration {
+	if c == nil || c.DrainTimeout == nil {
+		return DefaultDrainTimeout
+	}
+	return c.DrainTimeout.Duration
+}
@@ -0,0 +1,32 @@
+package authority
+
+import (
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+func TestShutdownConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *ShutdownConfig
+		want    time.Duration
+		wantErr bool
+	}{
+		{"nil", nil, DefaultDrainTimeout, false},
+		{"empty", &ShutdownConfig{}, DefaultDrainTimeout, false},
+		{"ok", &ShutdownConfig{DrainTimeout: &provisioner.Duration{Duration: 5 * time.Minute}}, 5 * time.Minute, false},
+		{"fail zero", &ShutdownConfig{DrainTimeout: &provisioner.Duration{}}, 0, true},
+		{"fail negative", &ShutdownConfig{DrainTimeout: &provisioner.Duration{Duration: -time.Second}}, -time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("ShutdownConfig.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equals(t, tt.want, tt.config.GetDrainTimeout())
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/x509"
 	"encoding/binary"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,6 +16,7 @@ import (
 	"github.com/smallstep/certificates/errs"
 	"github.com/smallstep/certificates/sshutil"
 	"github.com/smallstep/certificates/templates"
+	"github.com/smallstep/certificates/tracing"
 	"github.com/smallstep/cli/crypto/randutil"
 	"github.com/smallstep/cli/jose"
 	"golang.org/x/crypto/ssh"
@@ -119,6 +121,48 @@ func (a *Authority) GetSSHFederation(context.Context) (*SSHKeys, error) {
 	}, nil
 }
 
+// GetSSHRevocationList returns an OpenSSH key revocation list (KRL) with all
+// the revoked SSH certificates. The serial numbers are revoked for all the
+// user and host keys of the CA, and the version of the list is the time of the
+// last revocation.
+func (a *Authority) GetSSHRevocationList(context.Context) ([]byte, error) {
+	if a.sshCAUserCertSignKey == nil && a.sshCAHostCertSignKey == nil {
+		return nil, errs.NotFound("getSSHRevocationList: ssh is not configured")
+	}
+
+	revoked, err := a.db.GetRevokedSSHCertificates()
+	switch {
+	case err == db.ErrNotImplemented:
+		return nil, errs.NotImplemented("getSSHRevocationList: no persistence layer configured")
+	case err != nil:
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "getSSHRevocationList")
+	}
+
+	krl := &sshutil.KRL{
+		GeneratedAt: time.Now(),
+		Comment:     "step-ca",
+	}
+	for _, rci := range revoked {
+		sn, err := strconv.ParseUint(rci.Serial, 10, 64)
+		if err != nil {
+			return nil, errs.Wrapf(http.StatusInternalServerError, err,
+				"getSSHRevocationList: error parsing serial number %s", rci.Serial)
+		}
+		krl.Serials = append(krl.Serials, sn)
+		if v := uint64(rci.RevokedAt.Unix()); v > krl.Version {
+			krl.Version = v
+		}
+	}
+	seen := make(map[string]bool)
+	for _, key := range append(append([]ssh.PublicKey{}, a.sshCAUserCerts...), a.sshCAHostCerts...) {
+		if k := string(key.Marshal()); !seen[k] {
+			seen[k] = true
+			krl.CAKeys = append(krl.CAKeys, key)
+		}
+	}
+	return krl.Marshal(), nil
+}
+
 // GetSSHConfig returns rendered templates for clients (user) or servers (host).
 func (a *Authority) GetSSHConfig(ctx context.Context, typ string, data map[string]string) ([]templates.Output, error) {
 	if a.sshCAUserCertSignKey == nil && a.sshCAHostCertSignKey == nil {
@@ -181,9 +225,18 @@ func (a *Authority) GetSSHBastion(ctx context.Context, user string, hostname str
 }
 
 // SignSSH creates a signed SSH certificate with the given public key and options.
-func (a *Authority) SignSSH(ctx context.Context, key ssh.PublicKey, opts provisioner.SSHOptions, signOpts ...provisioner.SignOption) (*ssh.Certificate, error) {
+func (a *Authority) SignSSH(ctx context.Context, key ssh.PublicKey, opts provisioner.SSHOptions, signOpts ...provisioner.SignOption) (_ *ssh.Certificate, err error) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "authority.SignSSH")
+	defer func() {
+		observeSign("ssh", issueMethodSign, start, err)
+		span.SetError(err)
+		span.End()
+	}()
+
 	var mods []provisioner.SSHCertModifier
 	var validators []provisioner.SSHCertValidator
+	var provisionerName string
 
 	// Set backdate with the configured value
 	opts.Backdate = a.config.AuthorityConfig.Backdate.Duration
@@ -204,6 +257,8 @@ func (a *Authority) SignSSH(ctx context.Context, key ssh.PublicKey, opts provisi
 			if err := o.Valid(opts); err != nil {
 				return nil, errs.Wrap(http.StatusForbidden, err, "signSSH")
 			}
+		case provisionerNameOption:
+			provisionerName = string(o)
 		default:
 			return nil, errs.InternalServer("signSSH: invalid extra option type %T", o)
 		}
@@ -274,15 +329,37 @@ func (a *Authority) SignSSH(ctx context.Context, key ssh.PublicKey, opts provisi
 		}
 	}
 
-	if err = a.db.StoreSSHCertificate(cert); err != nil && err != db.ErrNotImplemented {
+	// Global name policy
+	if err := a.getPolicy().IsSSHCertificateAllowed(cert); err != nil {
+		return nil, errs.Wrap(http.StatusForbidden, err, "signSSH")
+	}
+
+	// Pre-sign authorization webhooks
+	if err := a.callWebhooks(newSSHWebhookRequest(cert)); err != nil {
+		return nil, errs.Wrap(http.StatusForbidden, err, "signSSH")
+	}
+
+	if err = traceDB(ctx, "StoreSSHCertificate", func() error {
+		return a.db.StoreSSHCertificate(cert)
+	}); err != nil && err != db.ErrNotImplemented {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "signSSH: error storing certificate in db")
 	}
 
+	countSSHCertificate(issueMethodSign, provisionerName, cert)
+	a.auditSSHCertificate(issueMethodSign, cert)
 	return cert, nil
 }
 
 // RenewSSH creates a signed SSH certificate using the old SSH certificate as a template.
-func (a *Authority) RenewSSH(ctx context.Context, oldCert *ssh.Certificate) (*ssh.Certificate, error) {
+func (a *Authority) RenewSSH(ctx context.Context, oldCert *ssh.Certificate) (_ *ssh.Certificate, err error) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "authority.RenewSSH")
+	defer func() {
+		observeSign("ssh", issueMethodRenew, start, err)
+		span.SetError(err)
+		span.End()
+	}()
+
 	nonce, err := randutil.ASCII(32)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "renewSSH")
@@ -345,22 +422,37 @@ func (a *Authority) RenewSSH(ctx context.Context, oldCert *ssh.Certificate) (*ss
 	}
 	cert.Signature = sig
 
-	if err = a.db.StoreSSHCertificate(cert); err != nil && err != db.ErrNotImplemented {
+	if err = traceDB(ctx, "StoreSSHCertificate", func() error {
+		return a.db.StoreSSHCertificate(cert)
+	}); err != nil && err != db.ErrNotImplemented {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "renewSSH: error storing certificate in db")
 	}
 
+	countSSHCertificate(issueMethodRenew, "", cert)
+	a.auditSSHCertificate(issueMethodRenew, cert)
 	return cert, nil
 }
 
 // RekeySSH creates a signed SSH certificate using the old SSH certificate as a template.
-func (a *Authority) RekeySSH(ctx context.Context, oldCert *ssh.Certificate, pub ssh.PublicKey, signOpts ...provisioner.SignOption) (*ssh.Certificate, error) {
+func (a *Authority) RekeySSH(ctx context.Context, oldCert *ssh.Certificate, pub ssh.PublicKey, signOpts ...provisioner.SignOption) (_ *ssh.Certificate, err error) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "authority.RekeySSH")
+	defer func() {
+		observeSign("ssh", issueMethodRekey, start, err)
+		span.SetError(err)
+		span.End()
+	}()
+
 	var validators []provisioner.SSHCertValidator
+	var provisionerName string
 
 	for _, op := range signOpts {
 		switch o := op.(type) {
 		// validate the ssh.Certificate
 		case provisioner.SSHCertValidator:
 			validators = append(validators, o)
+		case provisionerNameOption:
+			provisionerName = string(o)
 		default:
 			return nil, errs.InternalServer("rekeySSH; invalid extra option type %T", o)
 		}
@@ -435,10 +527,14 @@ func (a *Authority) RekeySSH(ctx context.Context, oldCert *ssh.Certificate, pub
 		}
 	}
 
-	if err = a.db.StoreSSHCertificate(cert); err != nil && err != db.ErrNotImplemented {
+	if err = traceDB(ctx, "StoreSSHCertificate", func() error {
+		return a.db.StoreSSHCertificate(cert)
+	}); err != nil && err != db.ErrNotImplemented {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "rekeySSH; error storing certificate in db")
 	}
 
+	countSSHCertificate(issueMethodRekey, provisionerName, cert)
+	a.auditSSHCertificate(issueMethodRekey, cert)
 	return cert, nil
 }
 
@@ -467,7 +563,15 @@ func IsValidForAddUser(cert *ssh.Certificate) error {
 }
 
 // SignSSHAddUser signs a certificate that provisions a new user in a server.
-func (a *Authority) SignSSHAddUser(ctx context.Context, key ssh.PublicKey, subject *ssh.Certificate) (*ssh.Certificate, error) {
+func (a *Authority) SignSSHAddUser(ctx context.Context, key ssh.PublicKey, subject *ssh.Certificate) (_ *ssh.Certificate, err error) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "authority.SignSSHAddUser")
+	defer func() {
+		observeSign("ssh", issueMethodSign, start, err)
+		span.SetError(err)
+		span.End()
+	}()
+
 	if a.sshCAUserCertSignKey == nil {
 		return nil, errs.NotImplemented("signSSHAddUser: user certificate signing is not enabled")
 	}
@@ -517,10 +621,14 @@ func (a *Authority) SignSSHAddUser(ctx context.Context, key ssh.PublicKey, subje
 	}
 	cert.Signature = sig
 
-	if err = a.db.StoreSSHCertificate(cert); err != nil && err != db.ErrNotImplemented {
+	if err = traceDB(ctx, "StoreSSHCertificate", func() error {
+		return a.db.StoreSSHCertificate(cert)
+	}); err != nil && err != db.ErrNotImplemented {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "signSSHAddUser: error storing certificate in db")
 	}
 
+	countSSHCertificate(issueMethodSign, "", cert)
+	a.auditSSHCertificate(issueMethodSign, cert)
 	return cert, nil
 }
 
@@ -534,7 +642,11 @@ func (a *Authority) CheckSSHHost(ctx context.Context, principal string, token st
 		}
 		return exists, nil
 	}
-	exists, err := a.db.IsSSHHost(principal)
+	var exists bool
+	err := traceDB(ctx, "IsSSHHost", func() (err error) {
+		exists, err = a.db.IsSSHHost(principal)
+		return
+	})
 	if err != nil {
 		if err == db.ErrNotImplemented {
 			return false, errs.Wrap(http.StatusNotImplemented, err,
@@ -553,7 +665,11 @@ func (a *Authority) GetSSHHosts(ctx context.Context, cert *x509.Certificate) ([]
 		hosts, err := a.sshGetHostsFunc(ctx, cert)
 		return hosts, errs.Wrap(http.StatusInternalServerError, err, "getSSHHosts")
 	}
-	hostnames, err := a.db.GetSSHHostPrincipals()
+	var hostnames []string
+	err := traceDB(ctx, "GetSSHHostPrincipals", func() (err error) {
+		hostnames, err = a.db.GetSSHHostPrincipals()
+		return
+	})
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "getSSHHosts")
 	}
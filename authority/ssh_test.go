@@ -7,6 +7,7 @@ import (
 	"crypto/rand"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/binary"
 	"fmt"
 	"net/http"
 	"reflect"
@@ -349,6 +350,85 @@ func TestAuthority_GetSSHFederation(t *testing.T) {
 	}
 }
 
+func TestAuthority_GetSSHRevocationList(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	assert.FatalError(t, err)
+	user := signer.PublicKey()
+
+	key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	host, err := ssh.NewPublicKey(key.Public())
+	assert.FatalError(t, err)
+
+	revokedAt := time.Unix(1600000000, 0)
+	revoked := []*db.RevokedCertificateInfo{
+		{Serial: "10", RevokedAt: revokedAt},
+		{Serial: "2", RevokedAt: revokedAt.Add(-time.Hour)},
+	}
+
+	type test struct {
+		signer ssh.Signer
+		db     *db.MockAuthDB
+		code   int
+	}
+	tests := map[string]test{
+		"fail/not-configured": {
+			db:   &db.MockAuthDB{},
+			code: http.StatusNotFound,
+		},
+		"fail/not-implemented": {
+			signer: signer,
+			db:     &db.MockAuthDB{Err: db.ErrNotImplemented},
+			code:   http.StatusNotImplemented,
+		},
+		"fail/db": {
+			signer: signer,
+			db:     &db.MockAuthDB{Err: errors.New("force")},
+			code:   http.StatusInternalServerError,
+		},
+		"fail/serial": {
+			signer: signer,
+			db:     &db.MockAuthDB{Ret1: []*db.RevokedCertificateInfo{{Serial: "foo"}}},
+			code:   http.StatusInternalServerError,
+		},
+		"ok": {
+			signer: signer,
+			db:     &db.MockAuthDB{Ret1: revoked},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			a := testAuthority(t)
+			a.db = tc.db
+			a.sshCAUserCertSignKey = tc.signer
+			a.sshCAHostCertSignKey = nil
+			a.sshCAUserCerts = []ssh.PublicKey{user}
+			a.sshCAHostCerts = []ssh.PublicKey{host, user}
+
+			got, err := a.GetSSHRevocationList(context.Background())
+			if tc.code != 0 {
+				if assert.NotNil(t, err) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, tc.code, sc.StatusCode())
+				}
+				return
+			}
+			assert.FatalError(t, err)
+			want := (&sshutil.KRL{
+				Version:     uint64(revokedAt.Unix()),
+				GeneratedAt: time.Unix(int64(binary.BigEndian.Uint64(got[20:28])), 0),
+				Comment:     "step-ca",
+				CAKeys:      []ssh.PublicKey{user, host},
+				Serials:     []uint64{2, 10},
+			}).Marshal()
+			assert.Equals(t, want, got)
+		})
+	}
+}
+
 func TestAuthority_GetSSHConfig(t *testing.T) {
 	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	assert.FatalError(t, err)
@@ -0,0 +1,92 @@
+package authority
+
+import (
+	"crypto/x509"
+	"net/http"
+	"time"
+
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+)
+
+// Status values of an issued certificate.
+const (
+	// CertificateStatusIssued is the status of a certificate that has been
+	// issued by the CA and it's still valid.
+	CertificateStatusIssued = "issued"
+	// CertificateStatusRevoked is the status of a revoked certificate.
+	CertificateStatusRevoked = "revoked"
+	// CertificateStatusExpired is the status of a certificate that is not
+	// revoked but its validity period has ended.
+	CertificateStatusExpired = "expired"
+)
+
+// CertificateStatus contains the status of a certificate issued by the CA.
+//
+// Certificate is nil if the certificate has been revoked by serial number but
+// it has not been stored, and Revocation is nil if the certificate has not
+// been revoked.
+type CertificateStatus struct {
+	Status      string
+	Certificate *x509.Certificate
+	Revocation  *db.RevokedCertificateInfo
+}
+
+// GetCertificateStatus returns the status of the certificate with the given
+// serial number.
+func (a *Authority) GetCertificateStatus(serialNumber string) (*CertificateStatus, error) {
+	crt, err := a.db.GetCertificate(serialNumber)
+	switch {
+	case err == db.ErrNotFound:
+		crt = nil
+	case err != nil:
+		return nil, certificateStatusError(err, "authority.GetCertificateStatus; error getting certificate",
+			errs.WithKeyVal("serialNumber", serialNumber))
+	}
+	return a.certificateStatus(serialNumber, crt)
+}
+
+// GetCertificateStatusByFingerprint returns the status of the certificate with
+// the given hex encoded SHA-256 fingerprint.
+func (a *Authority) GetCertificateStatusByFingerprint(fingerprint string) (*CertificateStatus, error) {
+	crt, err := a.db.GetCertificateByFingerprint(fingerprint)
+	if err != nil {
+		return nil, certificateStatusError(err, "authority.GetCertificateStatusByFingerprint; error getting certificate",
+			errs.WithKeyVal("fingerprint", fingerprint))
+	}
+	return a.certificateStatus(crt.SerialNumber.String(), crt)
+}
+
+func (a *Authority) certificateStatus(serialNumber string, crt *x509.Certificate) (*CertificateStatus, error) {
+	rci, err := a.db.GetRevokedCertificate(serialNumber)
+	switch {
+	case err == db.ErrNotFound:
+		rci = nil
+	case err != nil:
+		return nil, certificateStatusError(err, "authority.GetCertificateStatus; error getting revocation information",
+			errs.WithKeyVal("serialNumber", serialNumber))
+	}
+
+	switch {
+	case rci != nil:
+		return &CertificateStatus{Status: CertificateStatusRevoked, Certificate: crt, Revocation: rci}, nil
+	case crt == nil:
+		return nil, errs.NotFound("authority.GetCertificateStatus; certificate not found",
+			errs.WithKeyVal("serialNumber", serialNumber))
+	case time.Now().After(crt.NotAfter):
+		return &CertificateStatus{Status: CertificateStatusExpired, Certificate: crt}, nil
+	default:
+		return &CertificateStatus{Status: CertificateStatusIssued, Certificate: crt}, nil
+	}
+}
+
+func certificateStatusError(err error, msg string, args ...interface{}) error {
+	switch err {
+	case db.ErrNotFound:
+		return errs.Wrap(http.StatusNotFound, err, msg, args...)
+	case db.ErrNotImplemented:
+		return errs.Wrap(http.StatusNotImplemented, err, msg, args...)
+	default:
+		return errs.Wrap(http.StatusInternalServerError, err, msg, args...)
+	}
+}
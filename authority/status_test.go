@@ -0,0 +1,143 @@
+package authority
+
+import (
+	"crypto/x509"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+)
+
+func TestAuthority_GetCertificateStatus(t *testing.T) {
+	valid := &x509.Certificate{SerialNumber: big.NewInt(1234), NotAfter: time.Now().Add(time.Hour)}
+	expired := &x509.Certificate{SerialNumber: big.NewInt(1234), NotAfter: time.Now().Add(-time.Hour)}
+	rci := &db.RevokedCertificateInfo{Serial: "1234", ReasonCode: 1, Reason: "lost key"}
+
+	type test struct {
+		db         db.AuthDB
+		want       *CertificateStatus
+		statusCode int
+		err        error
+	}
+	tests := map[string]test{
+		"fail/not-implemented": {
+			db:         &db.MockAuthDB{Err: db.ErrNotImplemented},
+			statusCode: http.StatusNotImplemented,
+			err:        errors.New("authority.GetCertificateStatus; error getting certificate: not implemented"),
+		},
+		"fail/get-certificate": {
+			db:         &db.MockAuthDB{Err: errors.New("force")},
+			statusCode: http.StatusInternalServerError,
+			err:        errors.New("authority.GetCertificateStatus; error getting certificate: force"),
+		},
+		"fail/get-revoked-certificate": {
+			db: &db.MockAuthDB{
+				MGetCertificate: func(sn string) (*x509.Certificate, error) {
+					return valid, nil
+				},
+				MGetRevokedCertificate: func(sn string) (*db.RevokedCertificateInfo, error) {
+					return nil, errors.New("force")
+				},
+			},
+			statusCode: http.StatusInternalServerError,
+			err:        errors.New("authority.GetCertificateStatus; error getting revocation information: force"),
+		},
+		"fail/not-found": {
+			db:         &db.MockAuthDB{Err: db.ErrNotFound},
+			statusCode: http.StatusNotFound,
+			err:        errors.New("authority.GetCertificateStatus; certificate not found"),
+		},
+		"ok/issued": {
+			db: &db.MockAuthDB{
+				MGetCertificate: func(sn string) (*x509.Certificate, error) {
+					assert.Equals(t, "1234", sn)
+					return valid, nil
+				},
+				MGetRevokedCertificate: func(sn string) (*db.RevokedCertificateInfo, error) {
+					return nil, db.ErrNotFound
+				},
+			},
+			want: &CertificateStatus{Status: CertificateStatusIssued, Certificate: valid},
+		},
+		"ok/expired": {
+			db: &db.MockAuthDB{
+				MGetCertificate: func(sn string) (*x509.Certificate, error) {
+					return expired, nil
+				},
+				MGetRevokedCertificate: func(sn string) (*db.RevokedCertificateInfo, error) {
+					return nil, db.ErrNotFound
+				},
+			},
+			want: &CertificateStatus{Status: CertificateStatusExpired, Certificate: expired},
+		},
+		"ok/revoked": {
+			db: &db.MockAuthDB{
+				MGetCertificate: func(sn string) (*x509.Certificate, error) {
+					return expired, nil
+				},
+				MGetRevokedCertificate: func(sn string) (*db.RevokedCertificateInfo, error) {
+					assert.Equals(t, "1234", sn)
+					return rci, nil
+				},
+			},
+			want: &CertificateStatus{Status: CertificateStatusRevoked, Certificate: expired, Revocation: rci},
+		},
+		"ok/revoked-not-stored": {
+			db: &db.MockAuthDB{
+				MGetCertificate: func(sn string) (*x509.Certificate, error) {
+					return nil, db.ErrNotFound
+				},
+				MGetRevokedCertificate: func(sn string) (*db.RevokedCertificateInfo, error) {
+					return rci, nil
+				},
+			},
+			want: &CertificateStatus{Status: CertificateStatusRevoked, Revocation: rci},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			a := testAuthority(t)
+			a.db = tc.db
+			got, err := a.GetCertificateStatus("1234")
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.Equals(t, tc.statusCode, err.(errs.StatusCoder).StatusCode())
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestAuthority_GetCertificateStatusByFingerprint(t *testing.T) {
+	crt := &x509.Certificate{SerialNumber: big.NewInt(1234), NotAfter: time.Now().Add(time.Hour)}
+
+	a := testAuthority(t)
+	a.db = &db.MockAuthDB{Err: db.ErrNotFound}
+	_, err := a.GetCertificateStatusByFingerprint("abcd")
+	if assert.NotNil(t, err) {
+		assert.Equals(t, http.StatusNotFound, err.(errs.StatusCoder).StatusCode())
+		assert.HasPrefix(t, err.Error(), "authority.GetCertificateStatusByFingerprint; error getting certificate: not found")
+	}
+
+	a.db = &db.MockAuthDB{
+		MGetCertificateByFingerprint: func(fp string) (*x509.Certificate, error) {
+			assert.Equals(t, "abcd", fp)
+			return crt, nil
+		},
+		MGetRevokedCertificate: func(sn string) (*db.RevokedCertificateInfo, error) {
+			assert.Equals(t, "1234", sn)
+			return nil, db.ErrNotFound
+		},
+	}
+	got, err := a.GetCertificateStatusByFingerprint("abcd")
+	assert.FatalError(t, err)
+	assert.Equals(t, &CertificateStatus{Status: CertificateStatusIssued, Certificate: crt}, got)
+}
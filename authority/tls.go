@@ -2,19 +2,28 @@ package authority
 
 import (
 	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/asn1"
 	"encoding/base64"
 	"encoding/pem"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/audit"
 	"github.com/smallstep/certificates/authority/provisioner"
+	casapi "github.com/smallstep/certificates/cas/apiv1"
 	"github.com/smallstep/certificates/db"
 	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/crypto/keys"
 	"github.com/smallstep/cli/crypto/pemutil"
 	"github.com/smallstep/cli/crypto/tlsutil"
 	"github.com/smallstep/cli/crypto/x509util"
@@ -23,10 +32,17 @@ import (
 
 // GetTLSOptions returns the tls options configured.
 func (a *Authority) GetTLSOptions() *tlsutil.TLSOptions {
-	return a.config.TLS
+	if a.config.TLS == nil {
+		return nil
+	}
+	return &a.config.TLS.TLSOptions
 }
 
-var oidAuthorityKeyIdentifier = asn1.ObjectIdentifier{2, 5, 29, 35}
+var (
+	oidSubjectKeyIdentifier   = asn1.ObjectIdentifier{2, 5, 29, 14}
+	oidKeyUsage               = asn1.ObjectIdentifier{2, 5, 29, 15}
+	oidAuthorityKeyIdentifier = asn1.ObjectIdentifier{2, 5, 29, 35}
+)
 
 func withDefaultASN1DN(def *x509util.ASN1DN) x509util.WithOption {
 	return func(p x509util.Profile) error {
@@ -58,13 +74,55 @@ func withDefaultASN1DN(def *x509util.ASN1DN) x509util.WithOption {
 	}
 }
 
+// withDefaultKeyUsage removes from the default key usage the usages that are
+// not compatible with the given public key.
+func withDefaultKeyUsage(pub crypto.PublicKey) x509util.WithOption {
+	return func(p x509util.Profile) error {
+		crt := p.Subject()
+		crt.KeyUsage = keyUsageFor(pub, crt.KeyUsage)
+		return nil
+	}
+}
+
+// keyUsageFor returns the given key usage without the usages that are not
+// compatible with the public key. Ed25519 keys can only be used to sign, as
+// defined in RFC 8410, so the encipherment and key agreement usages are
+// removed.
+func keyUsageFor(pub crypto.PublicKey, ku x509.KeyUsage) x509.KeyUsage {
+	if _, ok := pub.(ed25519.PublicKey); ok {
+		ku &^= x509.KeyUsageKeyEncipherment | x509.KeyUsageDataEncipherment |
+			x509.KeyUsageKeyAgreement | x509.KeyUsageEncipherOnly | x509.KeyUsageDecipherOnly
+	}
+	return ku
+}
+
+// withOCSPServers adds the configured OCSP servers to the certificate.
+func withOCSPServers(c *OCSPConfig) x509util.WithOption {
+	return func(p x509util.Profile) error {
+		c.apply(p.Subject())
+		return nil
+	}
+}
+
 // Sign creates a signed certificate from a certificate signing request.
 func (a *Authority) Sign(csr *x509.CertificateRequest, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+	return a.SignWithContext(context.Background(), csr, signOpts, extraOpts...)
+}
+
+// SignWithContext is like Sign but the database operations are traced as
+// part of the span in the context.
+func (a *Authority) SignWithContext(ctx context.Context, csr *x509.CertificateRequest, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) (_ []*x509.Certificate, err error) {
+	start := time.Now()
+	defer func() {
+		observeSign("x509", issueMethodSign, start, err)
+	}()
+
 	var (
 		opts            = []interface{}{errs.WithKeyVal("csr", csr), errs.WithKeyVal("signOptions", signOpts)}
-		mods            = []x509util.WithOption{withDefaultASN1DN(a.config.AuthorityConfig.Template)}
+		mods            = []x509util.WithOption{withDefaultASN1DN(a.config.AuthorityConfig.Template), withDefaultKeyUsage(csr.PublicKey), withOCSPServers(a.config.OCSP), withSerialNumber(a.serialNumbers)}
 		certValidators  = []provisioner.CertificateValidator{}
 		forcedModifiers = []provisioner.CertificateEnforcer{}
+		certModifiers   = []provisioner.CertificateModifier{}
 	)
 
 	// Set backdate with the configured value
@@ -80,8 +138,12 @@ func (a *Authority) Sign(csr *x509.CertificateRequest, signOpts provisioner.Opti
 			}
 		case provisioner.ProfileModifier:
 			mods = append(mods, k.Option(signOpts))
+		case provisioner.CertificateModifier:
+			certModifiers = append(certModifiers, k)
 		case provisioner.CertificateEnforcer:
 			forcedModifiers = append(forcedModifiers, k)
+		case provisioner.CertificateIssuerSelector:
+			// The issuer is selected after applying the certificate modifiers.
 		default:
 			return nil, errs.InternalServer("authority.Sign; invalid extra option type %T", append([]interface{}{k}, opts...)...)
 		}
@@ -91,11 +153,53 @@ func (a *Authority) Sign(csr *x509.CertificateRequest, signOpts provisioner.Opti
 		return nil, errs.Wrap(http.StatusBadRequest, err, "authority.Sign; invalid certificate request", opts...)
 	}
 
-	leaf, err := x509util.NewLeafProfileWithCSR(csr, a.x509Issuer, a.x509Signer, mods...)
+	// Crypto policy
+	if err := a.config.AuthorityConfig.CryptoPolicy.checkCertificateRequest(csr); err != nil {
+		return nil, cryptoPolicyError(err, "authority.Sign", opts...)
+	}
+
+	issuer, signer := a.getX509Issuer()
+	if a.x509CAService != nil {
+		// The issuer is set by the certificate authority service.
+		issuer = &x509.Certificate{}
+	}
+	leaf, err := x509util.NewLeafProfileWithCSR(csr, issuer, signer, mods...)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.Sign", opts...)
 	}
 
+	// Certificate templates
+	for _, m := range certModifiers {
+		if err := m.Modify(leaf.Subject(), csr, signOpts); err != nil {
+			return nil, errs.Wrap(http.StatusBadRequest, err,
+				"authority.Sign; error applying certificate template", opts...)
+		}
+	}
+
+	// Select the intermediate, the certificate templates can change it
+	var issuerName string
+	for _, op := range extraOpts {
+		if s, ok := op.(provisioner.CertificateIssuerSelector); ok {
+			if name := s.Issuer(); name != "" {
+				issuerName = name
+			}
+		}
+	}
+	if a.x509CAService == nil {
+		issuer, signer, err = a.selectX509Issuer(issuerName, csr.PublicKey)
+		if err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.Sign", opts...)
+		}
+		leaf.SetIssuer(issuer)
+		leaf.SetIssuerPrivateKey(signer)
+		leaf.Subject().Issuer = issuer.Subject
+	}
+
+	// The CRL distribution points depend on the intermediate
+	if err := a.config.CRL.apply(leaf.Subject(), a.intermediateConfig(issuer)); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.Sign", opts...)
+	}
+
 	// Certificate validation
 	for _, v := range certValidators {
 		if err := v.Valid(leaf.Subject(), signOpts); err != nil {
@@ -103,6 +207,16 @@ func (a *Authority) Sign(csr *x509.CertificateRequest, signOpts provisioner.Opti
 		}
 	}
 
+	// Global name policy
+	if err := a.getPolicy().IsX509CertificateAllowed(leaf.Subject()); err != nil {
+		return nil, errs.Wrap(http.StatusForbidden, err, "authority.Sign", opts...)
+	}
+
+	// Pre-sign authorization webhooks
+	if err := a.callWebhooks(newX509WebhookRequest(csr, leaf.Subject())); err != nil {
+		return nil, errs.Wrap(http.StatusForbidden, err, "authority.Sign", opts...)
+	}
+
 	// Certificate modifier after validation
 	for _, m := range forcedModifiers {
 		if err := m.Enforce(leaf.Subject()); err != nil {
@@ -110,36 +224,109 @@ func (a *Authority) Sign(csr *x509.CertificateRequest, signOpts provisioner.Opti
 		}
 	}
 
-	crtBytes, err := leaf.CreateCertificate()
-	if err != nil {
-		return nil, errs.Wrap(http.StatusInternalServerError, err,
-			"authority.Sign; error creating new leaf certificate", opts...)
-	}
+	var chain []*x509.Certificate
+	if a.x509CAService != nil {
+		// The certificate authority service signs the certificate.
+		tmpl := leaf.Subject()
+		resp, err := a.x509CAService.CreateCertificate(&casapi.CreateCertificateRequest{
+			Template: tmpl,
+			CSR:      csr,
+			Lifetime: tmpl.NotAfter.Sub(tmpl.NotBefore),
+		})
+		if err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err,
+				"authority.Sign; error creating new leaf certificate", opts...)
+		}
+		chain = append([]*x509.Certificate{resp.Certificate}, resp.CertificateChain...)
+	} else {
+		crtBytes, err := leaf.CreateCertificate()
+		if err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err,
+				"authority.Sign; error creating new leaf certificate", opts...)
+		}
 
-	serverCert, err := x509.ParseCertificate(crtBytes)
-	if err != nil {
-		return nil, errs.Wrap(http.StatusInternalServerError, err,
-			"authority.Sign; error parsing new leaf certificate", opts...)
+		serverCert, err := x509.ParseCertificate(crtBytes)
+		if err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err,
+				"authority.Sign; error parsing new leaf certificate", opts...)
+		}
+		chain = []*x509.Certificate{serverCert, issuer}
 	}
 
-	if err = a.db.StoreCertificate(serverCert); err != nil {
+	if err = traceDB(ctx, "StoreCertificate", func() error {
+		return a.db.StoreCertificate(chain[0])
+	}); err != nil {
 		if err != db.ErrNotImplemented {
 			return nil, errs.Wrap(http.StatusInternalServerError, err,
 				"authority.Sign; error storing certificate in db", opts...)
 		}
 	}
 
-	return []*x509.Certificate{serverCert, a.x509Issuer}, nil
+	a.countX509Certificate(issueMethodSign, chain[0])
+	a.auditX509Certificate(issueMethodSign, chain[0])
+	return chain, nil
 }
 
 // Renew creates a new Certificate identical to the old certificate, except
 // with a validity window that begins 'now'.
 func (a *Authority) Renew(oldCert *x509.Certificate) ([]*x509.Certificate, error) {
+	return a.RenewContext(context.Background(), oldCert)
+}
+
+// RenewContext is like Renew but the database operations are traced as part
+// of the span in the context.
+func (a *Authority) RenewContext(ctx context.Context, oldCert *x509.Certificate) ([]*x509.Certificate, error) {
+	return a.renew(ctx, oldCert, nil, nil, "authority.Renew")
+}
+
+// Rekey creates a new Certificate identical to the old certificate, except
+// with a validity window that begins 'now' and the given public key.
+func (a *Authority) Rekey(oldCert *x509.Certificate, pk crypto.PublicKey) ([]*x509.Certificate, error) {
+	if pk == nil {
+		return nil, errs.BadRequest("authority.Rekey; public key cannot be nil")
+	}
+	return a.renew(context.Background(), oldCert, pk, nil, "authority.Rekey")
+}
+
+// RekeyCSR is like Rekey but uses the public key in the given certificate
+// request. The request is sent to the certificate authority service, if any,
+// as the proof of possession of the new key.
+func (a *Authority) RekeyCSR(ctx context.Context, oldCert *x509.Certificate, csr *x509.CertificateRequest) ([]*x509.Certificate, error) {
+	if csr == nil || csr.PublicKey == nil {
+		return nil, errs.BadRequest("authority.Rekey; public key cannot be nil")
+	}
+	return a.renew(ctx, oldCert, csr.PublicKey, csr, "authority.Rekey")
+}
+
+// renew creates a new certificate from the old one. If pk is not nil the new
+// certificate will use it instead of the public key in the old certificate,
+// and csr, if given, is the certificate request with that key.
+func (a *Authority) renew(ctx context.Context, oldCert *x509.Certificate, pk crypto.PublicKey, csr *x509.CertificateRequest, method string) (_ []*x509.Certificate, err error) {
+	issueMethod := issueMethodRenew
+	if pk != nil {
+		issueMethod = issueMethodRekey
+	}
+	start := time.Now()
+	defer func() {
+		observeSign("x509", issueMethod, start, err)
+	}()
+
 	opts := []interface{}{errs.WithKeyVal("serialNumber", oldCert.SerialNumber.String())}
 
 	// Check step provisioner extensions
-	if err := a.authorizeRenew(oldCert); err != nil {
-		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.Renew", opts...)
+	if err := a.authorizeRenew(ctx, oldCert); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, method, opts...)
+	}
+
+	isRekey := pk != nil
+	if !isRekey {
+		pk = oldCert.PublicKey
+	}
+
+	// Crypto policy, the key of the old certificate might not be allowed
+	// anymore.
+	if err := a.config.AuthorityConfig.CryptoPolicy.checkPublicKey(pk); err != nil {
+		return nil, cryptoPolicyError(err, method, opts...)
 	}
 
 	// Durations
@@ -147,13 +334,19 @@ func (a *Authority) Renew(oldCert *x509.Certificate) ([]*x509.Certificate, error
 	duration := oldCert.NotAfter.Sub(oldCert.NotBefore)
 	now := time.Now().UTC()
 
+	// Renew with the intermediate that issued the old certificate
+	issuer, signer := a.getX509IssuerFor(oldCert)
+	if a.x509CAService != nil {
+		// The issuer is set by the certificate authority service.
+		issuer = &x509.Certificate{}
+	}
 	newCert := &x509.Certificate{
-		PublicKey:                   oldCert.PublicKey,
-		Issuer:                      a.x509Issuer.Subject,
+		PublicKey:                   pk,
+		Issuer:                      issuer.Subject,
 		Subject:                     oldCert.Subject,
 		NotBefore:                   now.Add(-1 * backdate),
 		NotAfter:                    now.Add(duration - backdate),
-		KeyUsage:                    oldCert.KeyUsage,
+		KeyUsage:                    keyUsageFor(pk, oldCert.KeyUsage),
 		UnhandledCriticalExtensions: oldCert.UnhandledCriticalExtensions,
 		ExtKeyUsage:                 oldCert.ExtKeyUsage,
 		UnknownExtKeyUsage:          oldCert.UnknownExtKeyUsage,
@@ -182,36 +375,75 @@ func (a *Authority) Renew(oldCert *x509.Certificate) ([]*x509.Certificate, error
 
 	// Copy all extensions except for Authority Key Identifier. This one might
 	// be different if we rotate the intermediate certificate and it will cause
-	// a TLS bad certificate error.
+	// a TLS bad certificate error. On a rekey the Subject Key Identifier is
+	// also skipped, it will be generated from the new public key, and so is
+	// the Key Usage if it's not compatible with the new public key.
 	for _, ext := range oldCert.Extensions {
-		if !ext.Id.Equal(oidAuthorityKeyIdentifier) {
-			newCert.ExtraExtensions = append(newCert.ExtraExtensions, ext)
+		if ext.Id.Equal(oidAuthorityKeyIdentifier) {
+			continue
 		}
+		if ext.Id.Equal(oidKeyUsage) && newCert.KeyUsage != oldCert.KeyUsage {
+			continue
+		}
+		if isRekey && ext.Id.Equal(oidSubjectKeyIdentifier) {
+			continue
+		}
+		newCert.ExtraExtensions = append(newCert.ExtraExtensions, ext)
 	}
 
-	leaf, err := x509util.NewLeafProfileWithTemplate(newCert, a.x509Issuer, a.x509Signer)
-	if err != nil {
-		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.Renew", opts...)
-	}
-	crtBytes, err := leaf.CreateCertificate()
-	if err != nil {
-		return nil, errs.Wrap(http.StatusInternalServerError, err,
-			"authority.Renew; error renewing certificate from existing server certificate", opts...)
+	// Update the CRL distribution points and OCSP servers with the current
+	// configuration.
+	if err := a.config.CRL.apply(newCert, a.intermediateConfig(issuer)); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, method, opts...)
 	}
+	a.config.OCSP.apply(newCert)
+
+	var chain []*x509.Certificate
+	if a.x509CAService != nil {
+		// The certificate authority service renews the certificate.
+		resp, err := a.x509CAService.RenewCertificate(&casapi.RenewCertificateRequest{
+			Template: newCert,
+			CSR:      csr,
+			Lifetime: duration,
+		})
+		if err != nil {
+			if _, ok := err.(casapi.ErrNotImplemented); ok {
+				return nil, errs.NotImplemented(method+"; renewal is not supported by the certificate authority service", opts...)
+			}
+			return nil, errs.Wrap(http.StatusInternalServerError, err,
+				method+"; error renewing certificate from existing server certificate", opts...)
+		}
+		chain = append([]*x509.Certificate{resp.Certificate}, resp.CertificateChain...)
+	} else {
+		leaf, err := x509util.NewLeafProfileWithTemplate(newCert, issuer, signer, withSerialNumber(a.serialNumbers))
+		if err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, method, opts...)
+		}
+		crtBytes, err := leaf.CreateCertificate()
+		if err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err,
+				method+"; error renewing certificate from existing server certificate", opts...)
+		}
 
-	serverCert, err := x509.ParseCertificate(crtBytes)
-	if err != nil {
-		return nil, errs.Wrap(http.StatusInternalServerError, err,
-			"authority.Renew; error parsing new server certificate", opts...)
+		serverCert, err := x509.ParseCertificate(crtBytes)
+		if err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err,
+				method+"; error parsing new server certificate", opts...)
+		}
+		chain = []*x509.Certificate{serverCert, issuer}
 	}
 
-	if err = a.db.StoreCertificate(serverCert); err != nil {
+	if err := traceDB(ctx, "StoreCertificate", func() error {
+		return a.db.StoreCertificate(chain[0])
+	}); err != nil {
 		if err != db.ErrNotImplemented {
-			return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.Renew; error storing certificate in db", opts...)
+			return nil, errs.Wrap(http.StatusInternalServerError, err, method+"; error storing certificate in db", opts...)
 		}
 	}
 
-	return []*x509.Certificate{serverCert, a.x509Issuer}, nil
+	a.countX509Certificate(issueMethod, chain[0])
+	a.auditX509Certificate(issueMethod, chain[0])
+	return chain, nil
 }
 
 // RevokeOptions are the options for the Revoke API.
@@ -225,12 +457,9 @@ type RevokeOptions struct {
 	OTT         string
 }
 
-// Revoke revokes a certificate.
-//
-// NOTE: Only supports passive revocation - prevent existing certificates from
-// being renewed.
-//
-// TODO: Add OCSP and CRL support.
+// Revoke revokes a certificate. Revoked certificates cannot be renewed, and
+// if the CRL is enabled a new list is generated right away; the OCSP responses
+// reflect the revocation as soon as it's stored.
 func (a *Authority) Revoke(ctx context.Context, revokeOpts *RevokeOptions) error {
 	opts := []interface{}{
 		errs.WithKeyVal("serialNumber", revokeOpts.Serial),
@@ -255,8 +484,9 @@ func (a *Authority) Revoke(ctx context.Context, revokeOpts *RevokeOptions) error
 	}
 
 	var (
-		p   provisioner.Interface
-		err error
+		p     provisioner.Interface
+		actor string
+		err   error
 	)
 	// If not mTLS then get the TokenID of the token.
 	if !revokeOpts.MTLS {
@@ -284,6 +514,7 @@ func (a *Authority) Revoke(ctx context.Context, revokeOpts *RevokeOptions) error
 				"authority.Revoke; could not get ID for token")
 		}
 		opts = append(opts, errs.WithKeyVal("tokenID", rci.TokenID))
+		actor = claims.Subject
 	} else {
 		// Load the Certificate provisioner if one exists.
 		p, err = a.LoadProvisionerByCertificate(revokeOpts.Crt)
@@ -291,32 +522,137 @@ func (a *Authority) Revoke(ctx context.Context, revokeOpts *RevokeOptions) error
 			return errs.Wrap(http.StatusUnauthorized, err,
 				"authority.Revoke: unable to load certificate provisioner", opts...)
 		}
+		actor = revokeOpts.Crt.Subject.CommonName
 	}
 	rci.ProvisionerID = p.GetID()
 	opts = append(opts, errs.WithKeyVal("provisionerID", rci.ProvisionerID))
 
+	// Some services require the certificate to revoke it, and the CRL that
+	// contains it is the one of the intermediate with its authority key id.
+	var crt *x509.Certificate
+	if provisioner.MethodFromContext(ctx) != provisioner.SSHRevokeMethod {
+		if crt = revokeOpts.Crt; crt == nil {
+			traceDB(ctx, "GetCertificate", func() (err error) {
+				crt, err = a.db.GetCertificate(rci.Serial)
+				return
+			})
+		}
+		if crt != nil {
+			rci.AuthorityKeyID = crt.AuthorityKeyId
+		}
+	}
+
+	// Revoke the certificate in the certificate authority service.
+	forward := a.x509CAService != nil && provisioner.MethodFromContext(ctx) != provisioner.SSHRevokeMethod
+	if forward {
+		if _, err := a.x509CAService.RevokeCertificate(&casapi.RevokeCertificateRequest{
+			Certificate:  crt,
+			SerialNumber: rci.Serial,
+			Reason:       rci.Reason,
+			ReasonCode:   rci.ReasonCode,
+		}); err != nil {
+			return errs.Wrap(http.StatusInternalServerError, err,
+				"authority.Revoke; error revoking certificate in the certificate authority service", opts...)
+		}
+	}
+
 	if provisioner.MethodFromContext(ctx) == provisioner.SSHRevokeMethod {
-		err = a.db.RevokeSSH(rci)
+		err = traceDB(ctx, "RevokeSSH", func() error {
+			return a.db.RevokeSSH(rci)
+		})
 	} else { // default to revoke x509
-		err = a.db.Revoke(rci)
+		err = traceDB(ctx, "Revoke", func() error {
+			return a.db.Revoke(rci)
+		})
 	}
 	switch err {
 	case nil:
-		return nil
 	case db.ErrNotImplemented:
-		return errs.NotImplemented("authority.Revoke; no persistence layer configured", opts...)
+		if !forward {
+			return errs.NotImplemented("authority.Revoke; no persistence layer configured", opts...)
+		}
 	case db.ErrAlreadyExists:
 		return errs.BadRequest("authority.Revoke; certificate with serial "+
 			"number %s has already been revoked", append([]interface{}{rci.Serial}, opts...)...)
 	default:
 		return errs.Wrap(http.StatusInternalServerError, err, "authority.Revoke", opts...)
 	}
+
+	certType := "x509"
+	if provisioner.MethodFromContext(ctx) == provisioner.SSHRevokeMethod {
+		certType = "ssh"
+	} else if a.crl != nil {
+		// The revocation is already stored, a failure is fixed by the next
+		// scheduled refresh.
+		if err := a.refreshCRL(time.Now()); err != nil {
+			log.Printf("error generating certificate revocation list: %v", err)
+		}
+	}
+	a.audit.Log(&audit.Event{
+		Type:        audit.CertificateRevoked,
+		Actor:       actor,
+		ClientIP:    audit.ClientIPFromContext(ctx),
+		Provisioner: p.GetName(),
+		Serial:      rci.Serial,
+		Details: map[string]string{
+			"type":       certType,
+			"reason":     rci.Reason,
+			"reasonCode": strconv.Itoa(rci.ReasonCode),
+		},
+	})
+	return nil
+}
+
+// IsRevoked returns true if the certificate with the given serial number has
+// been revoked.
+func (a *Authority) IsRevoked(serial string) (bool, error) {
+	revoked, err := a.db.IsRevoked(serial)
+	if err != nil {
+		return false, errs.Wrap(http.StatusInternalServerError, err,
+			"authority.IsRevoked; error checking revocation status", errs.WithKeyVal("serialNumber", serial))
+	}
+	return revoked, nil
+}
+
+// RevokeCertificate passively revokes a certificate issued by the CA, it's
+// used by the ACME authority on revokeCert requests and to revoke the
+// certificates of deactivated accounts. Certificates that are already revoked
+// are ignored.
+func (a *Authority) RevokeCertificate(crt *x509.Certificate, reasonCode int, reason string) error {
+	serial := crt.SerialNumber.String()
+	revoked, err := a.db.IsRevoked(serial)
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err,
+			"authority.RevokeCertificate; error checking revocation status", errs.WithKeyVal("serialNumber", serial))
+	}
+	if revoked {
+		return nil
+	}
+	ctx := provisioner.NewContextWithMethod(context.Background(), provisioner.RevokeMethod)
+	return a.Revoke(ctx, &RevokeOptions{
+		Serial:      serial,
+		Reason:      reason,
+		ReasonCode:  reasonCode,
+		PassiveOnly: true,
+		MTLS:        true,
+		Crt:         crt,
+	})
 }
 
 // GetTLSCertificate creates a new leaf certificate to be used by the CA HTTPS server.
 func (a *Authority) GetTLSCertificate() (*tls.Certificate, error) {
-	profile, err := x509util.NewLeafProfile("Step Online CA", a.x509Issuer, a.x509Signer,
-		x509util.WithHosts(strings.Join(a.config.DNSNames, ",")))
+	if a.x509CAService != nil {
+		return a.getTLSCertificateFromCAS()
+	}
+
+	// Backdate the certificate like the ones signed by the authority.
+	notBefore := time.Now().Add(-1 * a.config.AuthorityConfig.Backdate.Duration)
+
+	issuer, signer := a.getX509Issuer()
+	profile, err := x509util.NewLeafProfile("Step Online CA", issuer, signer,
+		x509util.WithHosts(strings.Join(a.config.DNSNames, ",")),
+		x509util.WithNotBeforeAfterDuration(notBefore, time.Time{}, 0),
+		withSerialNumber(a.serialNumbers))
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetTLSCertificate")
 	}
@@ -338,7 +674,7 @@ func (a *Authority) GetTLSCertificate() (*tls.Certificate, error) {
 
 	// Load the x509 key pair (combining server and intermediate blocks)
 	// to a tls.Certificate.
-	intermediatePEM, err := pemutil.Serialize(a.x509Issuer)
+	intermediatePEM, err := pemutil.Serialize(issuer)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetTLSCertificate")
 	}
@@ -360,3 +696,62 @@ func (a *Authority) GetTLSCertificate() (*tls.Certificate, error) {
 
 	return &tlsCrt, nil
 }
+
+// getTLSCertificateFromCAS creates a new leaf certificate to be used by the CA
+// HTTPS server signed by the certificate authority service.
+func (a *Authority) getTLSCertificateFromCAS() (*tls.Certificate, error) {
+	key, err := keys.GenerateDefaultKey()
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetTLSCertificate")
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errs.InternalServer("authority.GetTLSCertificate; key is not a crypto.Signer")
+	}
+
+	dnsNames, ips, emails := x509util.SplitSANs(a.config.DNSNames)
+	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:        pkix.Name{CommonName: "Step Online CA"},
+		DNSNames:       dnsNames,
+		IPAddresses:    ips,
+		EmailAddresses: emails,
+	}, signer)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetTLSCertificate")
+	}
+	csr, err := x509.ParseCertificateRequest(csrBytes)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetTLSCertificate")
+	}
+
+	lifetime := 24 * time.Hour
+	notBefore := time.Now().Add(-1 * a.config.AuthorityConfig.Backdate.Duration)
+	resp, err := a.x509CAService.CreateCertificate(&casapi.CreateCertificateRequest{
+		Template: &x509.Certificate{
+			Subject:        csr.Subject,
+			DNSNames:       csr.DNSNames,
+			IPAddresses:    csr.IPAddresses,
+			EmailAddresses: csr.EmailAddresses,
+			NotBefore:      notBefore,
+			NotAfter:       notBefore.Add(lifetime),
+			KeyUsage:       x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		},
+		CSR:      csr,
+		Lifetime: lifetime,
+	})
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err,
+			"authority.GetTLSCertificate; error creating tls certificate")
+	}
+
+	tlsCrt := &tls.Certificate{
+		Certificate: [][]byte{resp.Certificate.Raw},
+		PrivateKey:  signer,
+		Leaf:        resp.Certificate,
+	}
+	for _, crt := range resp.CertificateChain {
+		tlsCrt.Certificate = append(tlsCrt.Certificate, crt.Raw)
+	}
+	return tlsCrt, nil
+}
@@ -0,0 +1,88 @@
+package authority
+
+import (
+	"crypto/tls"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/cli/crypto/tlsutil"
+)
+
+// Client authentication policies of the CA listener.
+const (
+	// ClientAuthRequest requests a client certificate, but it's not required.
+	// This is the default, it allows to renew certificates using mutual TLS.
+	ClientAuthRequest = "request"
+	// ClientAuthRequire requires a client certificate in all the connections.
+	ClientAuthRequire = "require"
+	// ClientAuthNone does not request a client certificate, the renewals
+	// using mutual TLS are not available.
+	ClientAuthNone = "none"
+)
+
+// tlsCurves maps the names of the supported curves to their identifiers.
+var tlsCurves = map[string]tls.CurveID{
+	"x25519": tls.X25519,
+	"p256":   tls.CurveP256,
+	"p384":   tls.CurveP384,
+	"p521":   tls.CurveP521,
+}
+
+// TLSOptions are the TLS options of the CA. The options in tlsutil.TLSOptions
+// are also sent to the clients in the sign and renew responses, Curves and
+// ClientAuth only apply to the CA listener.
+type TLSOptions struct {
+	tlsutil.TLSOptions
+	Curves     []string `json:"curves,omitempty"`
+	ClientAuth string   `json:"clientAuth,omitempty"`
+}
+
+// Validate checks the curves and the client authentication policy.
+func (o *TLSOptions) Validate() error {
+	if o == nil {
+		return nil
+	}
+	for _, name := range o.Curves {
+		if _, ok := tlsCurves[strings.ToLower(name)]; !ok {
+			return errors.Errorf("tls curve %s is not supported", name)
+		}
+	}
+	switch o.ClientAuth {
+	case "", ClientAuthRequest, ClientAuthRequire, ClientAuthNone:
+		return nil
+	default:
+		return errors.Errorf("tls clientAuth %s is not valid, it must be one of request, require or none", o.ClientAuth)
+	}
+}
+
+// CurvePreferences returns the curves in the preference order configured, or
+// nil to use the Go defaults.
+func (o *TLSOptions) CurvePreferences() []tls.CurveID {
+	if o == nil || len(o.Curves) == 0 {
+		return nil
+	}
+	curves := make([]tls.CurveID, 0, len(o.Curves))
+	for _, name := range o.Curves {
+		if id, ok := tlsCurves[strings.ToLower(name)]; ok {
+			curves = append(curves, id)
+		}
+	}
+	return curves
+}
+
+// ClientAuthType returns the tls.ClientAuthType of the configured policy.
+// Client certificates are always verified by the CA, so the types returned
+// do not verify them.
+func (o *TLSOptions) ClientAuthType() tls.ClientAuthType {
+	if o == nil {
+		return tls.RequestClientCert
+	}
+	switch o.ClientAuth {
+	case ClientAuthRequire:
+		return tls.RequireAnyClientCert
+	case ClientAuthNone:
+		return tls.NoClientCert
+	default:
+		return tls.RequestClientCert
+	}
+}
@@ -3,21 +3,26 @@ package authority
 import (
 	"context"
 	"crypto"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/sha1"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/authority/provisioner"
+	casapi "github.com/smallstep/certificates/cas/apiv1"
 	"github.com/smallstep/certificates/db"
 	"github.com/smallstep/certificates/errs"
 	"github.com/smallstep/cli/crypto/keys"
@@ -52,6 +57,12 @@ func (m *certificateDurationEnforcer) Enforce(cert *x509.Certificate) error {
 	return nil
 }
 
+type certificateModifierFunc func(cert *x509.Certificate, req *x509.CertificateRequest) error
+
+func (fn certificateModifierFunc) Modify(cert *x509.Certificate, req *x509.CertificateRequest, o provisioner.Options) error {
+	return fn(cert, req)
+}
+
 func withProvisionerOID(name, kid string) x509util.WithOption {
 	return func(p x509util.Profile) error {
 		crt := p.Subject()
@@ -120,6 +131,11 @@ func TestAuthority_Sign(t *testing.T) {
 	extraOpts, err := a.Authorize(ctx, token)
 	assert.FatalError(t, err)
 
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"allow":false,"reason":"denied by opa"}`))
+	}))
+	defer srv.Close()
+
 	type signTest struct {
 		auth      *Authority
 		csr       *x509.CertificateRequest
@@ -235,6 +251,51 @@ ZYtQ9Ot36qc=
 				code:      http.StatusUnauthorized,
 			}
 		},
+		"fail certificate template": func(t *testing.T) *signTest {
+			csr := getCSR(t, priv)
+			return &signTest{
+				auth: a,
+				csr:  csr,
+				extraOpts: append(extraOpts, certificateModifierFunc(func(cert *x509.Certificate, req *x509.CertificateRequest) error {
+					return errors.New("force")
+				})),
+				signOpts: signOpts,
+				err:      errors.New("authority.Sign; error applying certificate template: force"),
+				code:     http.StatusBadRequest,
+			}
+		},
+		"fail name policy": func(t *testing.T) *signTest {
+			csr := getCSR(t, priv)
+			_a := testAuthority(t)
+			_a.config.AuthorityConfig.Template = a.config.AuthorityConfig.Template
+			engine, err := policy.New(&policy.Options{
+				X509: &policy.X509Options{Deny: &policy.X509NameOptions{DNSDomains: []string{"*.smallstep.com"}}},
+			})
+			assert.FatalError(t, err)
+			_a.policy = engine
+			return &signTest{
+				auth:      _a,
+				csr:       csr,
+				extraOpts: extraOpts,
+				signOpts:  signOpts,
+				err:       errors.New("authority.Sign: dns name test.smallstep.com is denied by the policy"),
+				code:      http.StatusForbidden,
+			}
+		},
+		"fail webhook": func(t *testing.T) *signTest {
+			csr := getCSR(t, priv)
+			_a := testAuthority(t)
+			_a.config.AuthorityConfig.Template = a.config.AuthorityConfig.Template
+			_a.config.AuthorityConfig.Webhooks = []*WebhookConfig{{Name: "opa", URL: srv.URL}}
+			return &signTest{
+				auth:      _a,
+				csr:       csr,
+				extraOpts: extraOpts,
+				signOpts:  signOpts,
+				err:       errors.New("authority.Sign: webhook opa denied the request: denied by opa"),
+				code:      http.StatusForbidden,
+			}
+		},
 		"fail store cert in db": func(t *testing.T) *signTest {
 			csr := getCSR(t, priv)
 			_a := testAuthority(t)
@@ -270,6 +331,46 @@ ZYtQ9Ot36qc=
 				notAfter:  signOpts.NotAfter.Time().Truncate(time.Second),
 			}
 		},
+		"ok with certificate modifier": func(t *testing.T) *signTest {
+			csr := getCSR(t, priv)
+			return &signTest{
+				auth: a,
+				csr:  csr,
+				extraOpts: append(extraOpts, certificateModifierFunc(func(cert *x509.Certificate, req *x509.CertificateRequest) error {
+					assert.Equals(t, req, csr)
+					assert.Equals(t, cert.Subject.CommonName, "smallstep test")
+					cert.EmailAddresses = []string{"test@smallstep.com"}
+					return nil
+				})),
+				signOpts:  signOpts,
+				notBefore: signOpts.NotBefore.Time().Truncate(time.Second),
+				notAfter:  signOpts.NotAfter.Time().Truncate(time.Second),
+			}
+		},
+		"ok with crl distribution points": func(t *testing.T) *signTest {
+			csr := getCSR(t, priv)
+			_a := testAuthority(t)
+			_a.config.AuthorityConfig.Template = a.config.AuthorityConfig.Template
+			_a.config.CRL = &CRLConfig{
+				DistributionPoints:     []string{"http://ca.smallstep.com/crl"},
+				IssuingCertificateURLs: []string{"http://ca.smallstep.com/intermediate.crt"},
+			}
+			_a.db = &db.MockAuthDB{
+				MStoreCertificate: func(crt *x509.Certificate) error {
+					assert.Equals(t, crt.CRLDistributionPoints, []string{"http://ca.smallstep.com/crl"})
+					assert.Equals(t, crt.IssuingCertificateURL, []string{"http://ca.smallstep.com/intermediate.crt"})
+					return nil
+				},
+			}
+			return &signTest{
+				auth:      _a,
+				csr:       csr,
+				extraOpts: extraOpts,
+				signOpts:  signOpts,
+				notBefore: signOpts.NotBefore.Time().Truncate(time.Second),
+				notAfter:  signOpts.NotAfter.Time().Truncate(time.Second),
+			}
+		},
 		"ok with enforced modifier": func(t *testing.T) *signTest {
 			csr := getCSR(t, priv)
 			now := time.Now().UTC()
@@ -386,7 +487,7 @@ func TestAuthority_Renew(t *testing.T) {
 
 	now := time.Now().UTC()
 	nb1 := now.Add(-time.Minute * 7)
-	na1 := now
+	na1 := now.Add(time.Minute)
 	so := &provisioner.Options{
 		NotBefore: provisioner.NewTimeDuration(nb1),
 		NotAfter:  provisioner.NewTimeDuration(na1),
@@ -581,6 +682,94 @@ func TestAuthority_Renew(t *testing.T) {
 	}
 }
 
+func TestAuthority_Rekey(t *testing.T) {
+	pub, _, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+	newPub, _, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+
+	a := testAuthority(t)
+	now := time.Now().UTC()
+	nb1 := now.Add(-time.Minute * 7)
+	na1 := now.Add(time.Minute)
+
+	newCert := func(name string, p provisioner.Interface) *x509.Certificate {
+		jwk := p.(*provisioner.JWK)
+		leaf, err := x509util.NewLeafProfile(name, a.x509Issuer, a.x509Signer,
+			x509util.WithNotBeforeAfterDuration(nb1, na1, 0),
+			x509util.WithPublicKey(pub), x509util.WithHosts("test.smallstep.com,test"),
+			withProvisionerOID(jwk.Name, jwk.Key.KeyID))
+		assert.FatalError(t, err)
+		certBytes, err := leaf.CreateCertificate()
+		assert.FatalError(t, err)
+		cert, err := x509.ParseCertificate(certBytes)
+		assert.FatalError(t, err)
+		return cert
+	}
+	cert := newCert("rekey", a.config.AuthorityConfig.Provisioners[0])
+	certNoRenew := newCert("norekey", a.config.AuthorityConfig.Provisioners[2])
+
+	type rekeyTest struct {
+		cert *x509.Certificate
+		pk   crypto.PublicKey
+		err  error
+		code int
+	}
+	tests := map[string]rekeyTest{
+		"fail-nil-key": {
+			cert: cert,
+			err:  errors.New("authority.Rekey; public key cannot be nil"),
+			code: http.StatusBadRequest,
+		},
+		"fail-unauthorized": {
+			cert: certNoRenew,
+			pk:   newPub,
+			err:  errors.New("authority.Rekey: authority.authorizeRenew: jwk.AuthorizeRenew; renew is disabled for jwk provisioner dev:IMi94WBNI6gP5cNHXlZYNUzvMjGdHyBRmFoo-lCEaqk"),
+			code: http.StatusUnauthorized,
+		},
+		"ok": {
+			cert: cert,
+			pk:   newPub,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			certChain, err := a.Rekey(tc.cert, tc.pk)
+			if err != nil {
+				if assert.NotNil(t, tc.err, fmt.Sprintf("unexpected error: %s", err)) {
+					assert.Nil(t, certChain)
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, sc.StatusCode(), tc.code)
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+				return
+			}
+			if assert.Nil(t, tc.err) {
+				leaf := certChain[0]
+				assert.Equals(t, leaf.PublicKey, tc.pk)
+				assert.Equals(t, leaf.Subject.CommonName, tc.cert.Subject.CommonName)
+				assert.Equals(t, leaf.DNSNames, tc.cert.DNSNames)
+				assert.Equals(t, leaf.NotAfter.Sub(leaf.NotBefore), tc.cert.NotAfter.Sub(tc.cert.NotBefore))
+
+				pubBytes, err := x509.MarshalPKIXPublicKey(tc.pk)
+				assert.FatalError(t, err)
+				hash := sha1.Sum(pubBytes)
+				assert.Equals(t, leaf.SubjectKeyId, hash[:])
+
+				// The provisioner extension must be kept.
+				var found bool
+				for _, ext := range leaf.Extensions {
+					if ext.Id.Equal(stepOIDProvisioner) {
+						found = true
+					}
+				}
+				assert.True(t, found, "provisioner extension not found in rekeyed certificate")
+			}
+		})
+	}
+}
+
 func TestAuthority_GetTLSOptions(t *testing.T) {
 	type renewTest struct {
 		auth *Authority
@@ -593,7 +782,7 @@ func TestAuthority_GetTLSOptions(t *testing.T) {
 		},
 		"non-default": func() (*renewTest, error) {
 			a := testAuthority(t)
-			a.config.TLS = &tlsutil.TLSOptions{
+			a.config.TLS = &TLSOptions{TLSOptions: tlsutil.TLSOptions{
 				CipherSuites: x509util.CipherSuites{
 					"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305",
 					"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
@@ -601,8 +790,8 @@ func TestAuthority_GetTLSOptions(t *testing.T) {
 				MinVersion:    1.0,
 				MaxVersion:    1.1,
 				Renegotiation: true,
-			}
-			return &renewTest{auth: a, opts: a.config.TLS}, nil
+			}}
+			return &renewTest{auth: a, opts: &a.config.TLS.TLSOptions}, nil
 		},
 	}
 
@@ -688,6 +877,12 @@ func TestAuthority_Revoke(t *testing.T) {
 				MUseToken: func(id, tok string) (bool, error) {
 					return true, nil
 				},
+				MGetProvisioners: func() ([]json.RawMessage, error) {
+					return nil, nil
+				},
+				MGetAuthorityPolicy: func() (json.RawMessage, error) {
+					return nil, db.ErrNotFound
+				},
 				Err: errors.New("force"),
 			}))
 
@@ -724,6 +919,12 @@ func TestAuthority_Revoke(t *testing.T) {
 				MUseToken: func(id, tok string) (bool, error) {
 					return true, nil
 				},
+				MGetProvisioners: func() ([]json.RawMessage, error) {
+					return nil, nil
+				},
+				MGetAuthorityPolicy: func() (json.RawMessage, error) {
+					return nil, db.ErrNotFound
+				},
 				Err: db.ErrAlreadyExists,
 			}))
 
@@ -829,3 +1030,341 @@ func TestAuthority_Revoke(t *testing.T) {
 		})
 	}
 }
+
+func TestAuthority_IsRevoked(t *testing.T) {
+	a := testAuthority(t)
+	a.db = &db.MockAuthDB{
+		MIsRevoked: func(sn string) (bool, error) {
+			return sn == "1234", nil
+		},
+	}
+	revoked, err := a.IsRevoked("1234")
+	assert.FatalError(t, err)
+	assert.True(t, revoked)
+	revoked, err = a.IsRevoked("5678")
+	assert.FatalError(t, err)
+	assert.False(t, revoked)
+
+	a.db = &db.MockAuthDB{
+		MIsRevoked: func(sn string) (bool, error) {
+			return false, errors.New("force")
+		},
+	}
+	_, err = a.IsRevoked("1234")
+	if assert.NotNil(t, err) {
+		assert.Equals(t, http.StatusInternalServerError, err.(errs.StatusCoder).StatusCode())
+		assert.HasPrefix(t, err.Error(), "authority.IsRevoked; error checking revocation status: force")
+	}
+}
+
+func TestAuthority_RevokeCertificate(t *testing.T) {
+	crt, err := pemutil.ReadCertificate("./testdata/certs/foo.crt")
+	assert.FatalError(t, err)
+	serial := crt.SerialNumber.String()
+
+	type test struct {
+		db      *db.MockAuthDB
+		revoked bool
+		err     error
+	}
+	tests := map[string]func(t *testing.T) *test{
+		"fail/isRevoked-error": func(t *testing.T) *test {
+			return &test{
+				db: &db.MockAuthDB{
+					MIsRevoked: func(sn string) (bool, error) {
+						return false, errors.New("force")
+					},
+				},
+				err: errors.New("authority.RevokeCertificate; error checking revocation status: force"),
+			}
+		},
+		"fail/revoke-error": func(t *testing.T) *test {
+			return &test{
+				db: &db.MockAuthDB{
+					MIsRevoked: func(sn string) (bool, error) {
+						return false, nil
+					},
+					MRevoke: func(rci *db.RevokedCertificateInfo) error {
+						return errors.New("force")
+					},
+				},
+				err: errors.New("authority.Revoke: force"),
+			}
+		},
+		"ok": func(t *testing.T) *test {
+			tc := new(test)
+			tc.db = &db.MockAuthDB{
+				MIsRevoked: func(sn string) (bool, error) {
+					assert.Equals(t, serial, sn)
+					return false, nil
+				},
+				MRevoke: func(rci *db.RevokedCertificateInfo) error {
+					assert.Equals(t, serial, rci.Serial)
+					assert.Equals(t, 9, rci.ReasonCode)
+					assert.Equals(t, "account deactivated", rci.Reason)
+					assert.True(t, rci.MTLS)
+					tc.revoked = true
+					return nil
+				},
+			}
+			return tc
+		},
+		"ok/already-revoked": func(t *testing.T) *test {
+			return &test{
+				db: &db.MockAuthDB{
+					MIsRevoked: func(sn string) (bool, error) {
+						return true, nil
+					},
+					MRevoke: func(rci *db.RevokedCertificateInfo) error {
+						t.Fatal("unexpected call to Revoke")
+						return nil
+					},
+				},
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			a := testAuthority(t, WithDatabase(tc.db))
+			if err := a.RevokeCertificate(crt, 9, "account deactivated"); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, name == "ok", tc.revoked)
+			}
+		})
+	}
+}
+
+type testCertValidator string
+
+func (v testCertValidator) Valid(crt *x509.Certificate, opts provisioner.Options) error {
+	if v == "" {
+		return nil
+	}
+	return fmt.Errorf(string(v))
+}
+
+type mockCAS struct {
+	create func(req *casapi.CreateCertificateRequest) (*casapi.CreateCertificateResponse, error)
+	renew  func(req *casapi.RenewCertificateRequest) (*casapi.RenewCertificateResponse, error)
+	revoke func(req *casapi.RevokeCertificateRequest) (*casapi.RevokeCertificateResponse, error)
+}
+
+func (m *mockCAS) CreateCertificate(req *casapi.CreateCertificateRequest) (*casapi.CreateCertificateResponse, error) {
+	return m.create(req)
+}
+
+func (m *mockCAS) RenewCertificate(req *casapi.RenewCertificateRequest) (*casapi.RenewCertificateResponse, error) {
+	return m.renew(req)
+}
+
+func (m *mockCAS) RevokeCertificate(req *casapi.RevokeCertificateRequest) (*casapi.RevokeCertificateResponse, error) {
+	return m.revoke(req)
+}
+
+func TestAuthority_registrationAuthority(t *testing.T) {
+	rootKey := mustSigner(t)
+	root := mustCertificate(t, "Upstream Root", true, rootKey.Public(), nil, rootKey)
+	intKey := mustSigner(t)
+	intermediate := mustCertificate(t, "Upstream Intermediate", true, intKey.Public(), root, rootKey)
+
+	var templates []*x509.Certificate
+	var revoked []*casapi.RevokeCertificateRequest
+	cas := &mockCAS{
+		create: func(req *casapi.CreateCertificateRequest) (*casapi.CreateCertificateResponse, error) {
+			if req.CSR.Subject.CommonName == "fail" {
+				return nil, errors.New("force")
+			}
+			templates = append(templates, req.Template)
+			crt := mustCertificate(t, req.CSR.Subject.CommonName, false, req.CSR.PublicKey, intermediate, intKey)
+			return &casapi.CreateCertificateResponse{
+				Certificate:      crt,
+				CertificateChain: []*x509.Certificate{intermediate},
+			}, nil
+		},
+		renew: func(req *casapi.RenewCertificateRequest) (*casapi.RenewCertificateResponse, error) {
+			return nil, casapi.ErrNotImplemented{}
+		},
+		revoke: func(req *casapi.RevokeCertificateRequest) (*casapi.RevokeCertificateResponse, error) {
+			revoked = append(revoked, req)
+			return &casapi.RevokeCertificateResponse{}, nil
+		},
+	}
+
+	var stored []*x509.Certificate
+	a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+		MStoreCertificate: func(crt *x509.Certificate) error {
+			stored = append(stored, crt)
+			return nil
+		},
+		MIsRevoked: func(sn string) (bool, error) {
+			return false, nil
+		},
+		MRevoke: func(rci *db.RevokedCertificateInfo) error {
+			return nil
+		},
+	}))
+	a.x509CAService = cas
+
+	// Sign is validated locally and signed by the upstream CA.
+	now := time.Now()
+	signOpts := provisioner.Options{
+		NotBefore: provisioner.NewTimeDuration(now),
+		NotAfter:  provisioner.NewTimeDuration(now.Add(5 * time.Minute)),
+	}
+	chain, err := a.Sign(getCSR(t, mustSigner(t)), signOpts, testCertValidator(""))
+	assert.FatalError(t, err)
+	assert.Equals(t, []*x509.Certificate{chain[0], intermediate}, chain)
+	assert.Equals(t, []*x509.Certificate{chain[0]}, stored)
+	assert.Equals(t, "smallstep test", templates[0].Subject.CommonName)
+	assert.Equals(t, []string{"test.smallstep.com"}, templates[0].DNSNames)
+
+	_, err = a.Sign(getCSR(t, mustSigner(t)), signOpts, testCertValidator("force"))
+	if assert.NotNil(t, err) {
+		assert.Equals(t, http.StatusUnauthorized, err.(errs.StatusCoder).StatusCode())
+	}
+	assert.Len(t, 1, templates)
+
+	_, err = a.Sign(getCSR(t, mustSigner(t), func(csr *x509.CertificateRequest) {
+		csr.Subject.CommonName = "fail"
+	}), signOpts)
+	if assert.NotNil(t, err) {
+		assert.Equals(t, http.StatusInternalServerError, err.(errs.StatusCoder).StatusCode())
+		assert.HasPrefix(t, err.Error(), "authority.Sign; error creating new leaf certificate: force")
+	}
+
+	// Renewals are not supported by the upstream CA.
+	_, err = a.Renew(chain[0])
+	if assert.NotNil(t, err) {
+		assert.Equals(t, http.StatusNotImplemented, err.(errs.StatusCoder).StatusCode())
+	}
+
+	// Revocations are forwarded to the upstream CA.
+	crt, err := pemutil.ReadCertificate("./testdata/certs/foo.crt")
+	assert.FatalError(t, err)
+	assert.FatalError(t, a.RevokeCertificate(crt, 9, "account deactivated"))
+	if assert.Len(t, 1, revoked) {
+		assert.Equals(t, crt.SerialNumber.String(), revoked[0].SerialNumber)
+		assert.Equals(t, 9, revoked[0].ReasonCode)
+		assert.Equals(t, "account deactivated", revoked[0].Reason)
+	}
+
+	// The certificate of the HTTPS server is signed by the upstream CA.
+	tlsCrt, err := a.GetTLSCertificate()
+	assert.FatalError(t, err)
+	assert.Equals(t, "Step Online CA", tlsCrt.Leaf.Subject.CommonName)
+	assert.Equals(t, [][]byte{tlsCrt.Leaf.Raw, intermediate.Raw}, tlsCrt.Certificate)
+	assert.Equals(t, a.config.DNSNames, templates[len(templates)-1].DNSNames)
+	assert.Equals(t, 24*time.Hour, templates[len(templates)-1].NotAfter.Sub(templates[len(templates)-1].NotBefore))
+	assert.True(t, templates[len(templates)-1].NotBefore.Before(time.Now().Add(-a.config.AuthorityConfig.Backdate.Duration).Add(time.Second)))
+}
+
+func TestAuthority_Renew_certificateAuthorityService(t *testing.T) {
+	rootKey := mustSigner(t)
+	root := mustCertificate(t, "CAS Root", true, rootKey.Public(), nil, rootKey)
+	intKey := mustSigner(t)
+	intermediate := mustCertificate(t, "CAS Intermediate", true, intKey.Public(), root, rootKey)
+	key := mustSigner(t)
+	oldCert := mustCertificate(t, "test.smallstep.com", false, key.Public(), intermediate, intKey)
+
+	a := testAuthority(t)
+	a.x509CAService = &mockCAS{
+		renew: func(req *casapi.RenewCertificateRequest) (*casapi.RenewCertificateResponse, error) {
+			assert.Equals(t, oldCert.PublicKey, req.Template.PublicKey)
+			assert.Equals(t, oldCert.Subject, req.Template.Subject)
+			assert.Equals(t, oldCert.NotAfter.Sub(oldCert.NotBefore), req.Lifetime)
+			crt := mustCertificate(t, req.Template.Subject.CommonName, false, req.Template.PublicKey, intermediate, intKey)
+			return &casapi.RenewCertificateResponse{
+				Certificate:      crt,
+				CertificateChain: []*x509.Certificate{intermediate},
+			}, nil
+		},
+	}
+
+	chain, err := a.Renew(oldCert)
+	assert.FatalError(t, err)
+	assert.Len(t, 2, chain)
+	assert.Equals(t, oldCert.PublicKey, chain[0].PublicKey)
+	assert.Equals(t, intermediate, chain[1])
+}
+
+func TestAuthority_Ed25519(t *testing.T) {
+	rootKey := mustSigner(t)
+	root := mustCertificate(t, "Ed25519 Root", true, rootKey.Public(), nil, rootKey)
+	intPub, intKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.FatalError(t, err)
+	intermediate := mustCertificate(t, "Ed25519 Intermediate", true, intPub, root, rootKey)
+
+	a := testAuthority(t)
+	a.x509Issuer, a.x509Signer = intermediate, intKey
+
+	now := time.Now()
+	signOpts := provisioner.Options{
+		NotBefore: provisioner.NewTimeDuration(now),
+		NotAfter:  provisioner.NewTimeDuration(now.Add(time.Hour)),
+	}
+	checkLeaf := func(t *testing.T, chain []*x509.Certificate, pub crypto.PublicKey, ku x509.KeyUsage) {
+		t.Helper()
+		assert.Len(t, 2, chain)
+		leaf := chain[0]
+		assert.Equals(t, x509.PureEd25519, leaf.SignatureAlgorithm)
+		assert.Equals(t, pub, leaf.PublicKey)
+		assert.Equals(t, ku, leaf.KeyUsage)
+		assert.Equals(t, intermediate, chain[1])
+		assert.FatalError(t, leaf.CheckSignatureFrom(intermediate))
+	}
+
+	// Ed25519 subject key
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.FatalError(t, err)
+	chain, err := a.Sign(getCSR(t, priv), signOpts)
+	assert.FatalError(t, err)
+	checkLeaf(t, chain, pub, x509.KeyUsageDigitalSignature)
+	assert.Equals(t, x509.Ed25519, chain[0].PublicKeyAlgorithm)
+
+	chain, err = a.Renew(chain[0])
+	assert.FatalError(t, err)
+	checkLeaf(t, chain, pub, x509.KeyUsageDigitalSignature)
+
+	// ECDSA subject key rekeyed to Ed25519
+	ecKey := mustSigner(t)
+	chain, err = a.Sign(getCSR(t, ecKey), signOpts)
+	assert.FatalError(t, err)
+	checkLeaf(t, chain, ecKey.Public(), x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment)
+
+	newPub, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.FatalError(t, err)
+	chain, err = a.Rekey(chain[0], newPub)
+	assert.FatalError(t, err)
+	checkLeaf(t, chain, newPub, x509.KeyUsageDigitalSignature)
+}
+
+func Test_keyUsageFor(t *testing.T) {
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.FatalError(t, err)
+	ecKey := mustSigner(t)
+
+	all := x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment | x509.KeyUsageKeyEncipherment |
+		x509.KeyUsageDataEncipherment | x509.KeyUsageKeyAgreement | x509.KeyUsageCertSign |
+		x509.KeyUsageCRLSign | x509.KeyUsageEncipherOnly | x509.KeyUsageDecipherOnly
+
+	tests := map[string]struct {
+		pub  crypto.PublicKey
+		ku   x509.KeyUsage
+		want x509.KeyUsage
+	}{
+		"ok/ecdsa":           {ecKey.Public(), x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment, x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment},
+		"ok/ed25519-default": {edPub, x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment, x509.KeyUsageDigitalSignature},
+		"ok/ed25519-all":     {edPub, all, x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment | x509.KeyUsageCertSign | x509.KeyUsageCRLSign},
+		"ok/ed25519-empty":   {edPub, 0, 0},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equals(t, tc.want, keyUsageFor(tc.pub, tc.ku))
+		})
+	}
+}
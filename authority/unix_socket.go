@@ -0,0 +1,51 @@
+package authority
+
+import (
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/server"
+)
+
+// UnixSocketConfig configures the unix domain sockets of the CA. The address,
+// metricsAddress and debug.address can be a unix socket with the unix: prefix,
+// e.g. unix:/run/step-ca/ca.sock.
+type UnixSocketConfig struct {
+	// Mode is the octal permissions of the socket files, 0600 by default.
+	Mode string `json:"mode,omitempty"`
+}
+
+// Validate checks the fields in UnixSocketConfig.
+func (c *UnixSocketConfig) Validate() error {
+	if c == nil || c.Mode == "" {
+		return nil
+	}
+	if m, err := strconv.ParseUint(c.Mode, 8, 32); err != nil || m > 0777 {
+		return errors.Errorf("unixSocket.mode %s is not a valid file mode", c.Mode)
+	}
+	return nil
+}
+
+// GetMode returns the permissions of the socket files.
+func (c *UnixSocketConfig) GetMode() os.FileMode {
+	if c == nil || c.Mode == "" {
+		return server.DefaultSocketMode
+	}
+	m, err := strconv.ParseUint(c.Mode, 8, 32)
+	if err != nil {
+		return server.DefaultSocketMode
+	}
+	return os.FileMode(m)
+}
+
+// isValidAddress returns true if addr is a host and port, or the path of a
+// unix socket.
+func isValidAddress(addr string) bool {
+	if path, ok := server.UnixSocketPath(addr); ok {
+		return path != ""
+	}
+	_, _, err := net.SplitHostPort(addr)
+	return err == nil
+}
@@ -0,0 +1,42 @@
+package authority
+
+import (
+	"os"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/server"
+)
+
+func TestUnixSocketConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *UnixSocketConfig
+		want    os.FileMode
+		wantErr bool
+	}{
+		{"nil", nil, server.DefaultSocketMode, false},
+		{"empty", &UnixSocketConfig{}, server.DefaultSocketMode, false},
+		{"ok", &UnixSocketConfig{Mode: "0660"}, 0660, false},
+		{"ok no zero", &UnixSocketConfig{Mode: "666"}, 0666, false},
+		{"fail octal", &UnixSocketConfig{Mode: "0980"}, server.DefaultSocketMode, true},
+		{"fail bits", &UnixSocketConfig{Mode: "4755"}, 04755, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("UnixSocketConfig.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equals(t, tt.want, tt.config.GetMode())
+		})
+	}
+}
+
+func Test_isValidAddress(t *testing.T) {
+	assert.True(t, isValidAddress("127.0.0.1:443"))
+	assert.True(t, isValidAddress(":443"))
+	assert.True(t, isValidAddress("unix:/run/step-ca/ca.sock"))
+	assert.True(t, isValidAddress("unix:ca.sock"))
+	assert.False(t, isValidAddress("127.0.0.1"))
+	assert.False(t, isValidAddress("unix:"))
+}
@@ -0,0 +1,53 @@
+package authority
+
+import (
+	"log"
+	"time"
+
+	"github.com/smallstep/certificates/db"
+)
+
+const (
+	// usedTokensPurgeInterval is the frequency of the deletion of the expired
+	// one-time tokens from the database.
+	usedTokensPurgeInterval = time.Hour
+	// usedTokensPurgeLeeway is the time an expired token is kept in the
+	// database, it must be greater than the leeway used to validate tokens.
+	usedTokensPurgeLeeway = 10 * time.Minute
+)
+
+// usedTokensState holds the state of the goroutine that purges the expired
+// one-time tokens.
+type usedTokensState struct {
+	done chan struct{}
+}
+
+// initUsedTokens starts the goroutine that deletes from the database the
+// one-time tokens that have already expired. Expired tokens are rejected by
+// the provisioners, so they don't need to be kept to prevent a replay.
+func (a *Authority) initUsedTokens() {
+	if a.db == nil {
+		return
+	}
+	a.usedTokens = &usedTokensState{done: make(chan struct{})}
+	go func(authDB db.AuthDB, done chan struct{}) {
+		ticker := time.NewTicker(usedTokensPurgeInterval)
+		defer ticker.Stop()
+		purgeUsedTokens(authDB, time.Now())
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				purgeUsedTokens(authDB, now)
+			}
+		}
+	}(a.db, a.usedTokens.done)
+}
+
+// purgeUsedTokens deletes the tokens that expired before the leeway.
+func purgeUsedTokens(authDB db.AuthDB, now time.Time) {
+	if _, err := authDB.PurgeUsedTokens(now.Add(-usedTokensPurgeLeeway)); err != nil {
+		log.Printf("error purging used tokens: %v", err)
+	}
+}
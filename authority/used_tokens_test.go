@@ -0,0 +1,47 @@
+package authority
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/db"
+)
+
+func TestAuthority_initUsedTokens(t *testing.T) {
+	a := &Authority{}
+	a.initUsedTokens()
+	assert.Nil(t, a.usedTokens)
+
+	purged := make(chan time.Time, 1)
+	a.db = &db.MockAuthDB{
+		MPurgeUsedTokens: func(before time.Time) (int, error) {
+			purged <- before
+			return 0, nil
+		},
+	}
+	now := time.Now()
+	a.initUsedTokens()
+	if assert.NotNil(t, a.usedTokens) {
+		defer close(a.usedTokens.done)
+	}
+	select {
+	case before := <-purged:
+		assert.True(t, before.Before(now.Add(-usedTokensPurgeLeeway+time.Second)))
+	case <-time.After(5 * time.Second):
+		t.Fatal("used tokens were not purged")
+	}
+}
+
+func TestPurgeUsedTokens(t *testing.T) {
+	now := time.Now()
+	var got time.Time
+	purgeUsedTokens(&db.MockAuthDB{
+		MPurgeUsedTokens: func(before time.Time) (int, error) {
+			got = before
+			return 0, errors.New("force")
+		},
+	}, now)
+	assert.Equals(t, now.Add(-usedTokensPurgeLeeway), got)
+}
@@ -1,17 +1,64 @@
 package authority
 
+import "github.com/smallstep/certificates/authority/provisioner"
+
 // GlobalVersion stores the version information of the server.
 var GlobalVersion = Version{
-	Version: "0.0.0",
+	Version:   "0.0.0",
+	Commit:    "N/A",
+	BuildTime: "N/A",
 }
 
-// Version defines the
+// SupportedKeyTypes are the key types accepted in certificate requests.
+var SupportedKeyTypes = []string{"EC", "RSA", "OKP"}
+
+// Version defines the version and build information of the server, and the
+// capabilities of the running authority.
 type Version struct {
 	Version                     string
+	Commit                      string
+	BuildTime                   string
 	RequireClientAuthentication bool
+	Features                    Features
+	KeyTypes                    []string
+}
+
+// Features defines the subsystems enabled in the running authority.
+type Features struct {
+	ACME bool
+	SSH  bool
+	// SCEP is not supported by this version of the CA and it's always false.
+	SCEP bool
 }
 
 // Version returns the version information of the server.
 func (a *Authority) Version() Version {
-	return GlobalVersion
+	v := GlobalVersion
+	v.Features = Features{
+		ACME: a.hasProvisionerType(provisioner.TypeACME),
+		SSH:  a.sshCAUserCertSignKey != nil || a.sshCAHostCertSignKey != nil,
+	}
+	v.KeyTypes = append([]string(nil), SupportedKeyTypes...)
+	return v
+}
+
+// hasProvisionerType returns true if the authority has at least one
+// provisioner of the given type.
+func (a *Authority) hasProvisionerType(typ provisioner.Type) bool {
+	if a.provisioners == nil {
+		return false
+	}
+	var cursor string
+	for {
+		list, next := a.provisioners.Find(cursor, provisioner.DefaultProvisionersMax)
+		for _, p := range list {
+			if p.GetType() == typ {
+				return true
+			}
+		}
+		if next == "" || len(list) == 0 {
+			return false
+		}
+		cursor = next
+	}
 }
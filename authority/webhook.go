@@ -0,0 +1,228 @@
+package authority
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"golang.org/x/crypto/ssh"
+)
+
+const defaultWebhookTimeout = 5 * time.Second
+
+// Webhook certificate types.
+const (
+	WebhookCertTypeX509 = "x509"
+	WebhookCertTypeSSH  = "ssh"
+)
+
+// Webhook failure policies.
+const (
+	WebhookFailurePolicyDeny  = "deny"
+	WebhookFailurePolicyAllow = "allow"
+)
+
+// WebhookConfig represents the configuration of a pre-sign authorization
+// webhook.
+//
+// Before signing a certificate the CA sends a POST request with a JSON
+// WebhookRequest to the URL, and the webhook must respond with a JSON
+// WebhookResponse allowing or denying the request. CertType restricts the
+// webhook to x509 or ssh certificates, by default it's called for both.
+//
+// If the webhook cannot be reached in Timeout (5s by default), or it does
+// not respond with a 2xx status and a valid body, the request is denied,
+// unless FailurePolicy is set to allow.
+type WebhookConfig struct {
+	Name          string                `json:"name"`
+	URL           string                `json:"url"`
+	BearerToken   string                `json:"bearerToken,omitempty"`
+	CertType      string                `json:"certType,omitempty"`
+	Timeout       *provisioner.Duration `json:"timeout,omitempty"`
+	FailurePolicy string                `json:"failurePolicy,omitempty"`
+}
+
+// Validate checks the fields in WebhookConfig.
+func (w *WebhookConfig) Validate() error {
+	if w == nil {
+		return errors.New("webhook cannot be empty")
+	}
+	if w.Name == "" {
+		return errors.New("webhook name cannot be empty")
+	}
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.Errorf("webhook %s: url %s is not valid", w.Name, w.URL)
+	}
+	switch w.CertType {
+	case "", WebhookCertTypeX509, WebhookCertTypeSSH:
+	default:
+		return errors.Errorf("webhook %s: certType %s is not valid", w.Name, w.CertType)
+	}
+	switch w.FailurePolicy {
+	case "", WebhookFailurePolicyDeny, WebhookFailurePolicyAllow:
+	default:
+		return errors.Errorf("webhook %s: failurePolicy %s is not valid", w.Name, w.FailurePolicy)
+	}
+	if w.Timeout != nil && w.Timeout.Duration < 0 {
+		return errors.Errorf("webhook %s: timeout cannot be less than 0", w.Name)
+	}
+	return nil
+}
+
+func (w *WebhookConfig) timeout() time.Duration {
+	if w.Timeout == nil || w.Timeout.Duration == 0 {
+		return defaultWebhookTimeout
+	}
+	return w.Timeout.Duration
+}
+
+// call sends the given body to the webhook and returns its response.
+func (w *WebhookConfig) call(body []byte) (*WebhookResponse, error) {
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+w.BearerToken)
+	}
+
+	client := &http.Client{Timeout: w.timeout()}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error requesting %s", w.URL)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, errors.Errorf("error requesting %s: status code %d", w.URL, resp.StatusCode)
+	}
+
+	var res WebhookResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, errors.Wrapf(err, "error decoding response from %s", w.URL)
+	}
+	return &res, nil
+}
+
+// WebhookRequest is the body of the requests sent to the webhooks. Only the
+// field of the given Type is set.
+type WebhookRequest struct {
+	Type            string                  `json:"type"`
+	X509Certificate *WebhookX509Certificate `json:"x509Certificate,omitempty"`
+	SSHCertificate  *WebhookSSHCertificate  `json:"sshCertificate,omitempty"`
+}
+
+// WebhookX509Certificate contains the certificate request and the attributes
+// of the X.509 certificate that is going to be signed.
+type WebhookX509Certificate struct {
+	CSR            string    `json:"csr"`
+	Subject        string    `json:"subject"`
+	CommonName     string    `json:"commonName"`
+	DNSNames       []string  `json:"dnsNames,omitempty"`
+	IPAddresses    []string  `json:"ipAddresses,omitempty"`
+	EmailAddresses []string  `json:"emailAddresses,omitempty"`
+	URIs           []string  `json:"uris,omitempty"`
+	NotBefore      time.Time `json:"notBefore"`
+	NotAfter       time.Time `json:"notAfter"`
+}
+
+// WebhookSSHCertificate contains the attributes of the SSH certificate that is
+// going to be signed.
+type WebhookSSHCertificate struct {
+	PublicKey   string    `json:"publicKey"`
+	Type        string    `json:"type"`
+	KeyID       string    `json:"keyID"`
+	Principals  []string  `json:"principals"`
+	ValidAfter  time.Time `json:"validAfter"`
+	ValidBefore time.Time `json:"validBefore"`
+}
+
+// WebhookResponse is the body of the responses of the webhooks. Reason is an
+// optional message added to the error if the request is denied.
+type WebhookResponse struct {
+	Allow  bool   `json:"allow"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func newX509WebhookRequest(csr *x509.CertificateRequest, cert *x509.Certificate) *WebhookRequest {
+	c := &WebhookX509Certificate{
+		CSR: string(pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE REQUEST",
+			Bytes: csr.Raw,
+		})),
+		Subject:        cert.Subject.String(),
+		CommonName:     cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		NotBefore:      cert.NotBefore,
+		NotAfter:       cert.NotAfter,
+	}
+	for _, ip := range cert.IPAddresses {
+		c.IPAddresses = append(c.IPAddresses, ip.String())
+	}
+	for _, u := range cert.URIs {
+		c.URIs = append(c.URIs, u.String())
+	}
+	return &WebhookRequest{
+		Type:            WebhookCertTypeX509,
+		X509Certificate: c,
+	}
+}
+
+func newSSHWebhookRequest(cert *ssh.Certificate) *WebhookRequest {
+	certType := "user"
+	if cert.CertType == ssh.HostCert {
+		certType = "host"
+	}
+	return &WebhookRequest{
+		Type: WebhookCertTypeSSH,
+		SSHCertificate: &WebhookSSHCertificate{
+			PublicKey:   string(bytes.TrimSpace(ssh.MarshalAuthorizedKey(cert.Key))),
+			Type:        certType,
+			KeyID:       cert.KeyId,
+			Principals:  cert.ValidPrincipals,
+			ValidAfter:  time.Unix(int64(cert.ValidAfter), 0).UTC(),
+			ValidBefore: time.Unix(int64(cert.ValidBefore), 0).UTC(),
+		},
+	}
+}
+
+// callWebhooks sends the request to all the webhooks configured for its type,
+// and returns an error if any of them denies it.
+func (a *Authority) callWebhooks(req *WebhookRequest) error {
+	var body []byte
+	for _, w := range a.config.AuthorityConfig.Webhooks {
+		if w.CertType != "" && w.CertType != req.Type {
+			continue
+		}
+		if body == nil {
+			var err error
+			if body, err = json.Marshal(req); err != nil {
+				return errors.Wrap(err, "error marshaling webhook request")
+			}
+		}
+		res, err := w.call(body)
+		if err != nil {
+			if w.FailurePolicy == WebhookFailurePolicyAllow {
+				log.Printf("error calling webhook %s: %v", w.Name, err)
+				continue
+			}
+			return errors.Wrapf(err, "webhook %s failed", w.Name)
+		}
+		if !res.Allow {
+			if res.Reason != "" {
+				return errors.Errorf("webhook %s denied the request: %s", w.Name, res.Reason)
+			}
+			return errors.Errorf("webhook %s denied the request", w.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package authority
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestWebhookConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		w   *WebhookConfig
+		err string
+	}{
+		"ok":            {&WebhookConfig{Name: "opa", URL: "https://opa.example.com/v1/data/ca/allow"}, ""},
+		"ok/all-fields": {&WebhookConfig{Name: "opa", URL: "http://localhost:8181", BearerToken: "token", CertType: "ssh", Timeout: &provisioner.Duration{Duration: time.Second}, FailurePolicy: "allow"}, ""},
+		"fail/nil":      {nil, "webhook cannot be empty"},
+		"fail/name":     {&WebhookConfig{URL: "https://opa.example.com"}, "webhook name cannot be empty"},
+		"fail/url":      {&WebhookConfig{Name: "opa", URL: "opa.example.com"}, "webhook opa: url opa.example.com is not valid"},
+		"fail/scheme":   {&WebhookConfig{Name: "opa", URL: "ftp://opa.example.com"}, "webhook opa: url ftp://opa.example.com is not valid"},
+		"fail/certType": {&WebhookConfig{Name: "opa", URL: "https://opa.example.com", CertType: "pgp"}, "webhook opa: certType pgp is not valid"},
+		"fail/failurePolicy": {&WebhookConfig{Name: "opa", URL: "https://opa.example.com", FailurePolicy: "ignore"},
+			"webhook opa: failurePolicy ignore is not valid"},
+		"fail/timeout": {&WebhookConfig{Name: "opa", URL: "https://opa.example.com", Timeout: &provisioner.Duration{Duration: -time.Second}},
+			"webhook opa: timeout cannot be less than 0"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.w.Validate()
+			if tc.err != "" {
+				if assert.NotNil(t, err) {
+					assert.Equals(t, tc.err, err.Error())
+				}
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestAuthority_callWebhooks(t *testing.T) {
+	var got WebhookRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/allow":
+			fmt.Fprint(w, `{"allow":true}`)
+		case "/deny":
+			fmt.Fprint(w, `{"allow":false}`)
+		case "/deny-reason":
+			fmt.Fprint(w, `{"allow":false,"reason":"not today"}`)
+		case "/token":
+			fmt.Fprintf(w, `{"allow":%v}`, r.Header.Get("Authorization") == "Bearer secret")
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+			fmt.Fprint(w, `{"allow":true}`)
+		case "/bad-json":
+			fmt.Fprint(w, `{"allow":`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	csr := &x509.CertificateRequest{Raw: []byte("csr")}
+	x509Req := newX509WebhookRequest(csr, &x509.Certificate{
+		DNSNames:    []string{"test.smallstep.com"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	})
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.FatalError(t, err)
+	key, err := ssh.NewPublicKey(pub)
+	assert.FatalError(t, err)
+	sshReq := newSSHWebhookRequest(&ssh.Certificate{
+		Key:             key,
+		CertType:        ssh.HostCert,
+		ValidPrincipals: []string{"test.smallstep.com"},
+	})
+	assert.Equals(t, "host", sshReq.SSHCertificate.Type)
+	assert.Equals(t, []string{"127.0.0.1"}, x509Req.X509Certificate.IPAddresses)
+
+	hook := func(name, path string) *WebhookConfig {
+		return &WebhookConfig{Name: name, URL: srv.URL + path}
+	}
+	tests := map[string]struct {
+		webhooks []*WebhookConfig
+		req      *WebhookRequest
+		err      string
+	}{
+		"ok/none":     {nil, x509Req, ""},
+		"ok/allow":    {[]*WebhookConfig{hook("a", "/allow"), hook("b", "/allow")}, x509Req, ""},
+		"ok/ssh":      {[]*WebhookConfig{hook("a", "/allow")}, sshReq, ""},
+		"ok/token":    {[]*WebhookConfig{{Name: "a", URL: srv.URL + "/token", BearerToken: "secret"}}, x509Req, ""},
+		"ok/certType": {[]*WebhookConfig{{Name: "a", URL: srv.URL + "/deny", CertType: "ssh"}}, x509Req, ""},
+		"ok/failurePolicy": {[]*WebhookConfig{{Name: "a", URL: srv.URL + "/missing", FailurePolicy: "allow"}, hook("b", "/allow")},
+			x509Req, ""},
+		"fail/deny":        {[]*WebhookConfig{hook("a", "/allow"), hook("b", "/deny")}, x509Req, "webhook b denied the request"},
+		"fail/deny-reason": {[]*WebhookConfig{hook("a", "/deny-reason")}, sshReq, "webhook a denied the request: not today"},
+		"fail/token":       {[]*WebhookConfig{hook("a", "/token")}, x509Req, "webhook a denied the request"},
+		"fail/certType":    {[]*WebhookConfig{{Name: "a", URL: srv.URL + "/deny", CertType: "x509"}}, x509Req, "webhook a denied the request"},
+		"fail/status": {[]*WebhookConfig{hook("a", "/missing")}, x509Req,
+			"webhook a failed: error requesting " + srv.URL + "/missing: status code 404"},
+		"fail/bad-json": {[]*WebhookConfig{hook("a", "/bad-json")}, x509Req,
+			"webhook a failed: error decoding response from " + srv.URL + "/bad-json: unexpected EOF"},
+		"fail/timeout": {[]*WebhookConfig{{Name: "a", URL: srv.URL + "/slow", Timeout: &provisioner.Duration{Duration: 50 * time.Millisecond}}},
+			x509Req, "webhook a failed: error requesting " + srv.URL + "/slow"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got = WebhookRequest{}
+			a := testAuthority(t)
+			a.config.AuthorityConfig.Webhooks = tc.webhooks
+			err := a.callWebhooks(tc.req)
+			if tc.err != "" {
+				if assert.NotNil(t, err) {
+					assert.HasPrefix(t, err.Error(), tc.err)
+				}
+			} else if assert.Nil(t, err) && len(tc.webhooks) > 0 && tc.webhooks[0].CertType == "" {
+				assert.Equals(t, *tc.req, got)
+			}
+		})
+	}
+}
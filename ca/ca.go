@@ -1,31 +1,43 @@
 package ca
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"reflect"
+	"sync"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/acme"
 	acmeAPI "github.com/smallstep/certificates/acme/api"
 	"github.com/smallstep/certificates/api"
+	"github.com/smallstep/certificates/audit"
 	"github.com/smallstep/certificates/authority"
 	"github.com/smallstep/certificates/db"
 	"github.com/smallstep/certificates/logging"
+	"github.com/smallstep/certificates/metrics"
 	"github.com/smallstep/certificates/monitoring"
+	"github.com/smallstep/certificates/ratelimit"
 	"github.com/smallstep/certificates/server"
+	"github.com/smallstep/certificates/tracing"
 	"github.com/smallstep/nosql"
 )
 
 type options struct {
 	configFile string
+	overrides  []string
 	password   []byte
 	database   db.AuthDB
+	audit      *audit.Logger
+	tracer     *tracing.Tracer
+	logger     *logging.Logger
 }
 
 func (o *options) apply(opts []Option) {
@@ -45,6 +57,14 @@ func WithConfigFile(name string) Option {
 	}
 }
 
+// WithConfigOverrides sets the overrides, with the form path=value, applied
+// to the configuration file when the CA is reloaded.
+func WithConfigOverrides(overrides []string) Option {
+	return func(o *options) {
+		o.overrides = overrides
+	}
+}
+
 // WithPassword sets the given password as the configured password in the CA
 // options.
 func WithPassword(password []byte) Option {
@@ -60,21 +80,51 @@ func WithDatabase(db db.AuthDB) Option {
 	}
 }
 
+// WithAuditLogger sets the given audit logger to the CA options.
+func WithAuditLogger(l *audit.Logger) Option {
+	return func(o *options) {
+		o.audit = l
+	}
+}
+
+// WithTracer sets the given tracer to the CA options.
+func WithTracer(t *tracing.Tracer) Option {
+	return func(o *options) {
+		o.tracer = t
+	}
+}
+
+// WithLogger sets the logger used to log the requests to the CA, it takes
+// precedence over the logger in the configuration. It allows applications
+// embedding the CA to use their own logger.
+func WithLogger(l *logging.Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
 // CA is the type used to build the complete certificate authority. It builds
 // the HTTP server, set ups the middlewares and the HTTP handlers.
 type CA struct {
-	auth    *authority.Authority
-	config  *authority.Config
-	srv     *server.Server
-	opts    *options
-	renewer *TLSRenewer
+	auth     *authority.Authority
+	acmeAuth *acme.Authority
+	config   *authority.Config
+	srv      *server.Server
+	metrics  *server.Server
+	debug    *server.Server
+	tracer   *tracing.Tracer
+	opts     *options
+	renewer  *TLSRenewer
+	reloadMu sync.Mutex
+	stopped  chan struct{}
 }
 
 // New creates and initializes the CA with the given configuration and options.
 func New(config *authority.Config, opts ...Option) (*CA, error) {
 	ca := &CA{
-		config: config,
-		opts:   new(options),
+		config:  config,
+		opts:    new(options),
+		stopped: make(chan struct{}),
 	}
 	ca.opts.apply(opts)
 	return ca.Init(config)
@@ -82,6 +132,13 @@ func New(config *authority.Config, opts ...Option) (*CA, error) {
 
 // Init initializes the CA with the given configuration.
 func (ca *CA) Init(config *authority.Config) (*CA, error) {
+	return ca.init(config, nil)
+}
+
+// init initializes the CA with the given configuration. On reloads, prev is
+// the running CA, the new CA continues with the ACME state of prev and the
+// admin API reloads prev.
+func (ca *CA) init(config *authority.Config, prev *CA) (*CA, error) {
 	if l := len(ca.opts.password); l > 0 {
 		ca.config.Password = string(ca.opts.password)
 	}
@@ -90,6 +147,9 @@ func (ca *CA) Init(config *authority.Config) (*CA, error) {
 	if ca.opts.database != nil {
 		opts = append(opts, authority.WithDatabase(ca.opts.database))
 	}
+	if ca.opts.audit != nil {
+		opts = append(opts, authority.WithAuditLogger(ca.opts.audit))
+	}
 
 	auth, err := authority.New(config, opts...)
 	if err != nil {
@@ -103,7 +163,7 @@ func (ca *CA) Init(config *authority.Config) (*CA, error) {
 
 	// Using chi as the main router
 	mux := chi.NewRouter()
-	handler := http.Handler(mux)
+	handler := verifiedChainsMiddleware(tlsConfig.ClientCAs, mux)
 
 	// Add regular CA api endpoints in / and /1.0
 	routerHandler := api.New(auth)
@@ -113,18 +173,30 @@ func (ca *CA) Init(config *authority.Config) (*CA, error) {
 	})
 
 	//Add ACME api endpoints in /acme and /1.0/acme
+	// The links use the port of the address, a unix socket does not have one.
 	dns := config.DNSNames[0]
-	u, err := url.Parse("https://" + config.Address)
-	if err != nil {
-		return nil, err
-	}
-	port := u.Port()
-	if port != "" && port != "443" {
-		dns = fmt.Sprintf("%s:%s", dns, port)
+	if _, ok := server.UnixSocketPath(config.Address); !ok {
+		u, err := url.Parse("https://" + config.Address)
+		if err != nil {
+			return nil, err
+		}
+		port := u.Port()
+		if port != "" && port != "443" {
+			dns = fmt.Sprintf("%s:%s", dns, port)
+		}
 	}
 
 	prefix := "acme"
-	acmeAuth, err := acme.NewAuthority(auth.GetDatabase().(nosql.DB), dns, prefix, auth)
+	acmeOpts := []acme.Option{
+		acme.WithConfig(config.ACME),
+		acme.WithAuditLogger(auth.GetAuditLogger()),
+	}
+	reloader := ca
+	if prev != nil {
+		acmeOpts = append(acmeOpts, acme.WithPrevious(prev.acmeAuth))
+		reloader = prev
+	}
+	acmeAuth, err := acme.NewAuthority(auth.GetDatabase().(nosql.DB), dns, prefix, auth, acmeOpts...)
 	if err != nil {
 		return nil, errors.Wrap(err, "error creating ACME authority")
 	}
@@ -137,6 +209,26 @@ func (ca *CA) Init(config *authority.Config) (*CA, error) {
 	mux.Route("/2.0/"+prefix, func(r chi.Router) {
 		acmeRouterHandler.Route(r)
 	})
+	// Add the ACME admin api endpoints in /admin/acme
+	acmeAdminHandler := acmeAPI.NewAdmin(acmeAuth)
+	mux.Route("/admin/"+prefix, func(r chi.Router) {
+		acmeAdminHandler.Route(r)
+	})
+	// Add the provisioners admin api endpoints in /admin/provisioners
+	adminHandler := api.NewAdmin(auth, api.WithReloader(reloader))
+	mux.Route("/admin", func(r chi.Router) {
+		adminHandler.Route(r)
+	})
+
+	// Add the metrics in the Prometheus format in /metrics of a separate HTTP
+	// server if metricsAddress is configured. They are never served in the
+	// public address of the CA.
+	if config.MetricsAddress != "" {
+		metricsMux := chi.NewRouter()
+		metricsMux.Method("GET", "/metrics", metrics.Handler())
+		ca.metrics = server.New(config.MetricsAddress, metricsMux, nil)
+		ca.metrics.SocketMode = config.UnixSocket.GetMode()
+	}
 
 	/*
 		// helpful routine for logging all routes //
@@ -149,6 +241,18 @@ func (ca *CA) Init(config *authority.Config) (*CA, error) {
 		}
 	*/
 
+	// Add rate limits if configured
+	limiter, err := ratelimit.New(config.RateLimit, ratelimit.WithProvisionerFunc(rateLimitProvisioner(auth)))
+	if err != nil {
+		return nil, err
+	}
+	handler = limiter.Middleware(handler)
+
+	// Limit the size of the request bodies and the depth of the JSON
+	// documents, it wraps the limiter so the token peeked by it is also
+	// limited.
+	handler = api.LimitBody(handler, config.RequestLimits.GetMaxBodySize(), config.RequestLimits.GetMaxJSONDepth())
+
 	// Add monitoring if configured
 	if len(config.Monitoring) > 0 {
 		m, err := monitoring.New(config.Monitoring)
@@ -158,80 +262,304 @@ func (ca *CA) Init(config *authority.Config) (*CA, error) {
 		handler = m.Middleware(handler)
 	}
 
-	// Add logger if configured
-	if len(config.Logger) > 0 {
-		logger, err := logging.New("ca", config.Logger)
-		if err != nil {
+	logger := ca.opts.logger
+	if logger == nil && len(config.Logger) > 0 {
+		if logger, err = logging.New("ca", config.Logger); err != nil {
 			return nil, err
 		}
+	}
+
+	// Add tracing if configured, the tracer is kept on reloads. The logger
+	// wraps the tracing middleware so the spans have the request id.
+	tracer := ca.opts.tracer
+	if tracer == nil {
+		if tracer, err = tracing.New(config.Tracing); err != nil {
+			return nil, err
+		}
+	}
+	handler = tracer.Middleware(handler)
+
+	// Add logger if configured
+	if logger != nil {
 		handler = logger.Middleware(handler)
 	}
 
+	// Handle the headers set by the proxies in front of the CA
+	if handler, err = proxyMiddleware(config.Proxy, handler); err != nil {
+		return nil, err
+	}
+
+	// Start the background jobs of the ACME authority.
+	acmeAuth.Run()
+
 	ca.auth = auth
+	ca.acmeAuth = acmeAuth
+	ca.tracer = tracer
+	// Serve plain HTTP if TLS is terminated by a proxy.
+	if config.Proxy.IsPlainHTTP() {
+		log.Printf("Warning: serving plain HTTP on %s, TLS must be terminated by a proxy", config.Address)
+		tlsConfig = nil
+	}
 	ca.srv = server.New(config.Address, handler, tlsConfig)
+	ca.srv.SocketMode = config.UnixSocket.GetMode()
+	// Serve the profiling endpoints if configured, with the TLS configuration
+	// of the CA.
+	if ca.debug = newDebugServer(config.Debug, tlsConfig); ca.debug != nil {
+		ca.debug.SocketMode = config.UnixSocket.GetMode()
+	}
 	return ca, nil
 }
 
-// Run starts the CA calling to the server ListenAndServe method.
+// Run starts the CA calling to the server ListenAndServe method. If
+// configured, the metrics and debug servers are started in the background.
 func (ca *CA) Run() error {
-	return ca.srv.ListenAndServe()
+	// Use the sockets passed by systemd socket activation, if any. Each
+	// socket is used by the server with the same port.
+	listeners, err := server.ActivationListeners()
+	if err != nil {
+		return err
+	}
+
+	if ca.metrics != nil {
+		if err := serveBackground(ca.metrics, &listeners, "metrics"); err != nil {
+			return err
+		}
+	}
+	if ca.debug != nil {
+		if err := serveBackground(ca.debug, &listeners, "debug"); err != nil {
+			return err
+		}
+	}
+
+	ln := takeListener(&listeners, ca.srv.Addr)
+	for _, l := range listeners {
+		log.Printf("Closing unused socket activation listener on %s", l.Addr())
+		l.Close()
+	}
+	if ln == nil {
+		err = ca.srv.ListenAndServe()
+	} else {
+		err = ca.srv.Serve(ln)
+	}
+	// The server is closed by Stop, wait until the background workers are
+	// drained and the database is closed.
+	if err == http.ErrServerClosed {
+		<-ca.stopped
+	}
+	return err
 }
 
-// Stop stops the CA calling to the server Shutdown method.
+// serveBackground starts the given server in the background, using the
+// socket activation listener with its port if there's one.
+func serveBackground(srv *server.Server, listeners *[]net.Listener, name string) error {
+	ln := takeListener(listeners, srv.Addr)
+	if ln == nil {
+		var err error
+		if ln, err = server.Listen(srv.Addr, srv.SocketMode); err != nil {
+			return errors.Wrapf(err, "error starting %s server", name)
+		}
+	}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Printf("error serving %s: %v", name, err)
+		}
+	}()
+	return nil
+}
+
+// takeListener removes from the list and returns the listener with the port
+// of the given address, or with the same path if it's a unix socket, or nil if
+// there's none.
+func takeListener(listeners *[]net.Listener, addr string) net.Listener {
+	match := func(ln net.Listener) bool {
+		if path, ok := server.UnixSocketPath(addr); ok {
+			return ln.Addr().Network() == "unix" && ln.Addr().String() == path
+		}
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil || ln.Addr().Network() != "tcp" {
+			return false
+		}
+		_, p, err := net.SplitHostPort(ln.Addr().String())
+		return err == nil && p == port
+	}
+	for i, ln := range *listeners {
+		if match(ln) {
+			*listeners = append((*listeners)[:i], (*listeners)[i+1:]...)
+			return ln
+		}
+	}
+	return nil
+}
+
+// Stop gracefully stops the CA. The server stops accepting new connections
+// and the in-flight requests, ACME validations and webhook deliveries are
+// drained up to the configured drain timeout. Then the database is closed, so
+// the ACME state is never written after the database is closed.
 func (ca *CA) Stop() error {
+	defer close(ca.stopped)
+
+	ctx, cancel := context.WithTimeout(context.Background(), ca.config.Shutdown.GetDrainTimeout())
+	defer cancel()
+
+	err := ca.srv.ShutdownContext(ctx)
+	if ca.metrics != nil {
+		if err := ca.metrics.ShutdownContext(ctx); err != nil {
+			log.Printf("error stopping metrics server: %v", err)
+		}
+	}
+	if ca.debug != nil {
+		if err := ca.debug.ShutdownContext(ctx); err != nil {
+			log.Printf("error stopping debug server: %v", err)
+		}
+	}
 	ca.renewer.Stop()
+	if err := ca.acmeAuth.Shutdown(ctx); err != nil {
+		log.Printf("error draining acme workers: %v", err)
+	}
 	if err := ca.auth.Shutdown(); err != nil {
 		log.Printf("error stopping ca.Authority: %+v\n", err)
 	}
-	return ca.srv.Shutdown()
+	if err := ca.tracer.Shutdown(); err != nil {
+		log.Printf("error stopping tracer: %v", err)
+	}
+	return err
 }
 
 // Reload reloads the configuration of the CA and calls to the server Reload
-// method.
+// method. The in-flight requests are completed before replacing the server.
 func (ca *CA) Reload() error {
-	config, err := authority.LoadConfiguration(ca.opts.configFile)
+	ca.reloadMu.Lock()
+	defer ca.reloadMu.Unlock()
+
+	newCA, err := ca.prepareReload()
 	if err != nil {
-		return errors.Wrap(err, "error reloading ca configuration")
+		return err
 	}
+	return ca.applyReload(newCA)
+}
 
-	logContinue := func(reason string) {
-		log.Println(reason)
-		log.Println("Continuing to run with the original configuration.")
-		log.Println("You can force a restart by sending a SIGTERM signal and then restarting the step-ca.")
+// ScheduleReload loads and initializes the new configuration of the CA, and
+// then replaces the server in the background. It's used to reload the CA from
+// one of its own requests, as the server waits for them to complete before
+// being replaced.
+func (ca *CA) ScheduleReload() error {
+	ca.reloadMu.Lock()
+	newCA, err := ca.prepareReload()
+	if err != nil {
+		ca.reloadMu.Unlock()
+		return err
+	}
+	go func() {
+		defer ca.reloadMu.Unlock()
+		if err := ca.applyReload(newCA); err != nil {
+			log.Printf("error reloading ca: %+v", err)
+		}
+	}()
+	return nil
+}
+
+func logContinue(reason string) {
+	log.Println(reason)
+	log.Println("Continuing to run with the original configuration.")
+	log.Println("You can force a restart by sending a SIGTERM signal and then restarting the step-ca.")
+}
+
+// prepareReload loads the configuration file and initializes a new CA with
+// it. It must be called with the reload lock held.
+func (ca *CA) prepareReload() (*CA, error) {
+	config, err := authority.LoadConfiguration(ca.opts.configFile, ca.opts.overrides...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reloading ca configuration")
 	}
 
 	// Do not allow reload if the database configuration has changed.
 	if !reflect.DeepEqual(ca.config.DB, config.DB) {
 		logContinue("Reload failed because the database configuration has changed.")
-		return errors.New("error reloading ca: database configuration cannot change")
+		return nil, errors.New("error reloading ca: database configuration cannot change")
+	}
+
+	// Do not allow reload if the metrics address has changed.
+	if ca.config.MetricsAddress != config.MetricsAddress {
+		logContinue("Reload failed because the metrics address has changed.")
+		return nil, errors.New("error reloading ca: metricsAddress cannot change")
 	}
 
-	newCA, err := New(config,
+	// Do not allow reload if the debug address has changed.
+	if ca.config.Debug.GetAddress() != config.Debug.GetAddress() {
+		logContinue("Reload failed because the debug address has changed.")
+		return nil, errors.New("error reloading ca: debug.address cannot change")
+	}
+
+	// Do not allow reload if the audit configuration has changed.
+	if !reflect.DeepEqual(auditConfig(ca.config), auditConfig(config)) {
+		logContinue("Reload failed because the audit configuration has changed.")
+		return nil, errors.New("error reloading ca: audit configuration cannot change")
+	}
+
+	// Do not allow reload if the tracing configuration has changed.
+	if !reflect.DeepEqual(ca.config.Tracing, config.Tracing) {
+		logContinue("Reload failed because the tracing configuration has changed.")
+		return nil, errors.New("error reloading ca: tracing configuration cannot change")
+	}
+
+	newCA := &CA{
+		config: config,
+		opts:   new(options),
+	}
+	newCA.opts.apply([]Option{
 		WithPassword(ca.opts.password),
 		WithConfigFile(ca.opts.configFile),
+		WithConfigOverrides(ca.opts.overrides),
 		WithDatabase(ca.auth.GetDatabase()),
-	)
-	if err != nil {
+		WithAuditLogger(ca.auth.GetAuditLogger()),
+		WithTracer(ca.tracer),
+		WithLogger(ca.opts.logger),
+	})
+	if _, err := newCA.init(config, ca); err != nil {
 		logContinue("Reload failed because the CA with new configuration could not be initialized.")
-		return errors.Wrap(err, "error reloading ca")
+		return nil, errors.Wrap(err, "error reloading ca")
 	}
+	return newCA, nil
+}
 
-	if err = ca.srv.Reload(newCA.srv); err != nil {
+// applyReload replaces the server and the properties of the CA with the ones
+// in the new CA. It must be called with the reload lock held.
+func (ca *CA) applyReload(newCA *CA) error {
+	if err := ca.srv.Reload(newCA.srv); err != nil {
+		newCA.renewer.Stop()
+		newCA.acmeAuth.Stop()
 		logContinue("Reload failed because server could not be replaced.")
 		return errors.Wrap(err, "error reloading server")
 	}
 
-	// 1. Stop previous renewer
+	// The debug server uses the new token and TLS configuration.
+	if ca.debug != nil {
+		if err := ca.debug.Reload(newCA.debug); err != nil {
+			log.Printf("error reloading debug server: %v", err)
+		}
+	}
+
+	// 1. Stop previous renewer and ACME jobs
 	// 2. Replace ca properties
 	// Do not replace ca.srv
 	ca.renewer.Stop()
+	ca.acmeAuth.Stop()
 	ca.auth = newCA.auth
+	ca.acmeAuth = newCA.acmeAuth
 	ca.config = newCA.config
 	ca.opts = newCA.opts
 	ca.renewer = newCA.renewer
 	return nil
 }
 
+// auditConfig returns the audit configuration in the given configuration.
+func auditConfig(c *authority.Config) *audit.Config {
+	if c.AuthorityConfig == nil {
+		return nil
+	}
+	return c.AuthorityConfig.Audit
+}
+
 // getTLSConfig returns a TLSConfig for the CA server with a self-renewing
 // server certificate.
 func (ca *CA) getTLSConfig(auth *authority.Authority) (*tls.Config, error) {
@@ -256,6 +584,7 @@ func (ca *CA) getTLSConfig(auth *authority.Authority) (*tls.Config, error) {
 	var tlsConfig *tls.Config
 	if ca.config.TLS != nil {
 		tlsConfig = ca.config.TLS.TLSConfig()
+		tlsConfig.CurvePreferences = ca.config.TLS.CurvePreferences()
 	} else {
 		tlsConfig = &tls.Config{
 			MinVersion: tls.VersionTLS12,
@@ -277,12 +606,76 @@ func (ca *CA) getTLSConfig(auth *authority.Authority) (*tls.Config, error) {
 	tlsConfig.Certificates = []tls.Certificate{}
 	tlsConfig.GetCertificate = ca.renewer.GetCertificateForCA
 
-	// Add support for mutual tls to renew certificates
-	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	// Add support for mutual tls to renew certificates. Client certificates
+	// are verified by verifyClientCertificate instead of
+	// tls.VerifyClientCertIfGiven, to allow the renewal of expired
+	// certificates. The client authentication policy is configurable.
+	tlsConfig.ClientAuth = ca.config.TLS.ClientAuthType()
 	tlsConfig.ClientCAs = certPool
+	tlsConfig.VerifyPeerCertificate = verifyClientCertificate(certPool)
 
 	// Use server's most preferred ciphersuite
 	tlsConfig.PreferServerCipherSuites = true
 
 	return tlsConfig, nil
 }
+
+// verifyClientCertificate returns a function that verifies the client
+// certificates against the given roots. It behaves like
+// tls.VerifyClientCertIfGiven, but certificates that have expired are also
+// accepted if their chain is valid, so they can be renewed if their
+// provisioner allows renewals after expiry. The authority, and the handlers
+// using the verified chains, will reject them for any other use.
+func verifyClientCertificate(roots *x509.CertPool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return nil
+		}
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, asn1Data := range rawCerts {
+			crt, err := x509.ParseCertificate(asn1Data)
+			if err != nil {
+				return errors.Wrap(err, "error parsing client certificate")
+			}
+			certs[i] = crt
+		}
+		opts := clientVerifyOptions(roots, certs)
+		if time.Now().After(certs[0].NotAfter) {
+			opts.CurrentTime = certs[0].NotAfter
+		}
+		if _, err := certs[0].Verify(opts); err != nil {
+			return errors.Wrap(err, "error verifying client certificate")
+		}
+		return nil
+	}
+}
+
+// verifiedChainsMiddleware sets the verified chains of the requests made with
+// a client certificate that is currently valid. Requests with an expired
+// client certificate will have the peer certificates but not the verified
+// chains.
+func verifiedChainsMiddleware(roots *x509.CertPool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 && len(r.TLS.VerifiedChains) == 0 {
+			certs := r.TLS.PeerCertificates
+			if chains, err := certs[0].Verify(clientVerifyOptions(roots, certs)); err == nil {
+				state := *r.TLS
+				state.VerifiedChains = chains
+				r.TLS = &state
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func clientVerifyOptions(roots *x509.CertPool, certs []*x509.Certificate) x509.VerifyOptions {
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, crt := range certs[1:] {
+		opts.Intermediates.AddCert(crt)
+	}
+	return opts
+}
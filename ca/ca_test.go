@@ -10,9 +10,12 @@ import (
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -530,13 +533,46 @@ func TestCAHealth(t *testing.T) {
 				if rr.Code < http.StatusBadRequest {
 					var health api.HealthResponse
 					assert.FatalError(t, readJSON(body, &health))
-					assert.Equals(t, health, api.HealthResponse{Status: "ok"})
+					assert.Equals(t, "ok", health.Status)
+					for name, c := range health.Components {
+						assert.Equals(t, "ok", c.Status, name)
+					}
 				}
 			}
 		})
 	}
 }
 
+func TestCA_getTLSConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		curves     []string
+		clientAuth string
+		wantCurves []tls.CurveID
+		wantAuth   tls.ClientAuthType
+	}{
+		{"default", nil, "", nil, tls.RequestClientCert},
+		{"require", []string{"X25519", "p256"}, authority.ClientAuthRequire, []tls.CurveID{tls.X25519, tls.CurveP256}, tls.RequireAnyClientCert},
+		{"none", []string{"P384"}, authority.ClientAuthNone, []tls.CurveID{tls.CurveP384}, tls.NoClientCert},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := authority.LoadConfiguration("testdata/ca.json")
+			assert.FatalError(t, err)
+			config.TLS.Curves = tt.curves
+			config.TLS.ClientAuth = tt.clientAuth
+			ca, err := New(config)
+			assert.FatalError(t, err)
+			defer ca.renewer.Stop()
+
+			tlsConfig := ca.srv.TLSConfig
+			assert.Equals(t, tt.wantCurves, tlsConfig.CurvePreferences)
+			assert.Equals(t, tt.wantAuth, tlsConfig.ClientAuth)
+			assert.Equals(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+		})
+	}
+}
+
 func TestCARenew(t *testing.T) {
 	pub, _, err := keys.GenerateDefaultKeyPair()
 	assert.FatalError(t, err)
@@ -664,3 +700,104 @@ func TestCARenew(t *testing.T) {
 		})
 	}
 }
+
+func Test_verifyClientCertificate(t *testing.T) {
+	rootCrt, err := pemutil.ReadCertificate("testdata/secrets/root_ca.crt")
+	assert.FatalError(t, err)
+	intermediateIdentity, err := x509util.LoadIdentityFromDisk("testdata/secrets/intermediate_ca.crt",
+		"testdata/secrets/intermediate_ca_key", pemutil.WithPassword([]byte("password")))
+	assert.FatalError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(rootCrt)
+
+	pub, _, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+	newLeaf := func(notBefore, notAfter time.Time) *x509.Certificate {
+		profile, err := x509util.NewLeafProfile("test", intermediateIdentity.Crt,
+			intermediateIdentity.Key, x509util.WithPublicKey(pub),
+			x509util.WithNotBeforeAfterDuration(notBefore, notAfter, 0), x509util.WithHosts("test"))
+		assert.FatalError(t, err)
+		b, err := profile.CreateCertificate()
+		assert.FatalError(t, err)
+		crt, err := x509.ParseCertificate(b)
+		assert.FatalError(t, err)
+		return crt
+	}
+
+	now := time.Now()
+	valid := newLeaf(now.Add(-time.Minute), now.Add(time.Hour))
+	expired := newLeaf(now.Add(-2*time.Hour), now.Add(-time.Hour))
+	notYetValid := newLeaf(now.Add(time.Hour), now.Add(2*time.Hour))
+	intermediate := intermediateIdentity.Crt
+
+	verify := verifyClientCertificate(roots)
+	tests := []struct {
+		name     string
+		rawCerts [][]byte
+		wantErr  bool
+	}{
+		{"ok no certificate", nil, false},
+		{"ok", [][]byte{valid.Raw, intermediate.Raw}, false},
+		{"ok expired", [][]byte{expired.Raw, intermediate.Raw}, false},
+		{"fail not yet valid", [][]byte{notYetValid.Raw, intermediate.Raw}, true},
+		{"fail no intermediate", [][]byte{valid.Raw}, true},
+		{"fail parse", [][]byte{[]byte("foo")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verify(tt.rawCerts, nil); (err != nil) != tt.wantErr {
+				t.Errorf("verifyClientCertificate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Only valid certificates get verified chains.
+	var chains [][]*x509.Certificate
+	handler := verifiedChainsMiddleware(roots, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chains = r.TLS.VerifiedChains
+	}))
+	for _, crt := range []*x509.Certificate{valid, expired} {
+		rq, err := http.NewRequest("POST", "/renew", http.NoBody)
+		assert.FatalError(t, err)
+		rq.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{crt, intermediate}}
+		handler.ServeHTTP(httptest.NewRecorder(), rq)
+		if crt == valid {
+			assert.Len(t, 1, chains)
+		} else {
+			assert.Len(t, 0, chains)
+		}
+	}
+}
+
+func Test_takeListener(t *testing.T) {
+	newListener := func() net.Listener {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.FatalError(t, err)
+		return ln
+	}
+	ln1, ln2 := newListener(), newListener()
+	defer ln1.Close()
+	defer ln2.Close()
+	_, port2, err := net.SplitHostPort(ln2.Addr().String())
+	assert.FatalError(t, err)
+
+	listeners := []net.Listener{ln1, ln2}
+	assert.Nil(t, takeListener(&listeners, "bad-address"))
+	assert.Nil(t, takeListener(&listeners, ":1"))
+	assert.Equals(t, ln2, takeListener(&listeners, ":"+port2))
+	assert.Equals(t, []net.Listener{ln1}, listeners)
+	assert.Nil(t, takeListener(&listeners, ":"+port2))
+
+	// Unix sockets are matched by path.
+	dir, err := ioutil.TempDir("", "takeListener")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ca.sock")
+	ln3, err := net.Listen("unix", path)
+	assert.FatalError(t, err)
+	defer ln3.Close()
+	listeners = append(listeners, ln3)
+	assert.Nil(t, takeListener(&listeners, "unix:"+path+".other"))
+	assert.Equals(t, ln3, takeListener(&listeners, "unix:"+path))
+	assert.Equals(t, []net.Listener{ln1}, listeners)
+}
@@ -465,6 +465,83 @@ func TestClient_Revoke(t *testing.T) {
 	}
 }
 
+func TestClient_SignBatch(t *testing.T) {
+	ok := &api.SignBatchResponse{
+		Results: []api.SignBatchResult{
+			{
+				ServerPEM: &api.Certificate{Certificate: parseCertificate(certPEM)},
+				CaPEM:     &api.Certificate{Certificate: parseCertificate(rootPEM)},
+				CertChainPEM: []api.Certificate{
+					{Certificate: parseCertificate(certPEM)},
+					{Certificate: parseCertificate(rootPEM)},
+				},
+			},
+			{Error: &errs.Error{Status: http.StatusForbidden, Err: errors.New(errs.ForbiddenDefaultMsg)}},
+		},
+	}
+	request := &api.SignBatchRequest{
+		OTT: "the-ott",
+		Requests: []api.SignBatchEntry{
+			{CsrPEM: api.CertificateRequest{CertificateRequest: parseCertificateRequest(csrPEM)}},
+			{CsrPEM: api.CertificateRequest{CertificateRequest: parseCertificateRequest(csrPEM)}},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		response     interface{}
+		responseCode int
+		wantErr      bool
+	}{
+		{"ok", ok, 200, false},
+		{"unauthorized", errs.Unauthorized("force"), 401, true},
+	}
+
+	srv := httptest.NewServer(nil)
+	defer srv.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewClient(srv.URL, WithTransport(http.DefaultTransport))
+			if err != nil {
+				t.Errorf("NewClient() error = %v", err)
+				return
+			}
+
+			srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				body := new(api.SignBatchRequest)
+				if err := api.ReadJSON(req.Body, body); err != nil {
+					t.Errorf("Client.SignBatch() unexpected error = %v", err)
+				} else if !equalJSON(t, body, request) {
+					t.Errorf("Client.SignBatch() request = %v, wants %v", body, request)
+				}
+				api.JSONStatus(w, tt.response, tt.responseCode)
+			})
+
+			got, err := c.SignBatch(request)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Client.SignBatch() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			switch {
+			case err != nil:
+				if got != nil {
+					t.Errorf("Client.SignBatch() = %v, want nil", got)
+				}
+			case len(got.Results) != 2:
+				t.Errorf("Client.SignBatch() results = %d, want 2", len(got.Results))
+			default:
+				if !reflect.DeepEqual(got.Results[0].ServerPEM, ok.Results[0].ServerPEM) {
+					t.Errorf("Client.SignBatch() crt = %v, want %v", got.Results[0].ServerPEM, ok.Results[0].ServerPEM)
+				}
+				if e := got.Results[1].Error; e == nil || e.StatusCode() != http.StatusForbidden {
+					t.Errorf("Client.SignBatch() error = %v, want forbidden", e)
+				}
+			}
+		})
+	}
+}
+
 func TestClient_Renew(t *testing.T) {
 	ok := &api.SignResponse{
 		ServerPEM: api.Certificate{Certificate: parseCertificate(certPEM)},
@@ -529,6 +606,80 @@ func TestClient_Renew(t *testing.T) {
 	}
 }
 
+func TestClient_Rekey(t *testing.T) {
+	ok := &api.SignResponse{
+		ServerPEM: api.Certificate{Certificate: parseCertificate(certPEM)},
+		CaPEM:     api.Certificate{Certificate: parseCertificate(rootPEM)},
+		CertChainPEM: []api.Certificate{
+			{Certificate: parseCertificate(certPEM)},
+			{Certificate: parseCertificate(rootPEM)},
+		},
+	}
+	request := &api.RekeyRequest{
+		CsrPEM: api.CertificateRequest{CertificateRequest: parseCertificateRequest(csrPEM)},
+	}
+
+	tests := []struct {
+		name         string
+		request      *api.RekeyRequest
+		response     interface{}
+		responseCode int
+		wantErr      bool
+		err          error
+	}{
+		{"ok", request, ok, 200, false, nil},
+		{"unauthorized", request, errs.Unauthorized("force"), 401, true, errors.New(errs.UnauthorizedDefaultMsg)},
+		{"empty request", &api.RekeyRequest{}, errs.BadRequest("force"), 400, true, errors.New(errs.BadRequestDefaultMsg)},
+	}
+
+	srv := httptest.NewServer(nil)
+	defer srv.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewClient(srv.URL, WithTransport(http.DefaultTransport))
+			if err != nil {
+				t.Errorf("NewClient() error = %v", err)
+				return
+			}
+
+			srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if req.URL.Path != "/rekey" {
+					t.Errorf("Client.Rekey() path = %s, want /rekey", req.URL.Path)
+				}
+				body := new(api.RekeyRequest)
+				if err := api.ReadJSON(req.Body, body); err != nil {
+					api.WriteError(w, errs.BadRequest("force"))
+					return
+				}
+				api.JSONStatus(w, tt.response, tt.responseCode)
+			})
+
+			got, err := c.Rekey(tt.request, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Client.Rekey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			switch {
+			case err != nil:
+				if got != nil {
+					t.Errorf("Client.Rekey() = %v, want nil", got)
+				}
+
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, sc.StatusCode(), tt.responseCode)
+				assert.HasPrefix(t, tt.err.Error(), err.Error())
+			default:
+				if !reflect.DeepEqual(got, tt.response) {
+					t.Errorf("Client.Rekey() = %v, want %v", got, tt.response)
+				}
+			}
+		})
+	}
+}
+
 func TestClient_Provisioners(t *testing.T) {
 	ok := &api.ProvisionersResponse{
 		Provisioners: provisioner.List{},
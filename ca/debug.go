@@ -0,0 +1,52 @@
+package ca
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/server"
+)
+
+// newDebugServer returns the server with the pprof, expvar and goroutine dump
+// endpoints, or nil if the debug listener is not configured. It uses the TLS
+// configuration of the CA, nil if the CA serves plain HTTP.
+func newDebugServer(c *authority.DebugConfig, tlsConfig *tls.Config) *server.Server {
+	if c == nil {
+		return nil
+	}
+	mux := chi.NewRouter()
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	// Index also serves the named profiles, the goroutine dumps are in
+	// /debug/pprof/goroutine?debug=2.
+	mux.HandleFunc("/debug/pprof/*", pprof.Index)
+	mux.Method("GET", "/debug/vars", expvar.Handler())
+
+	srv := server.New(c.Address, debugAuth(c.BearerToken, mux), tlsConfig)
+	// CPU profiles and traces take longer than the default write timeout.
+	srv.WriteTimeout = 0
+	return srv
+}
+
+// debugAuth is a middleware that only allows the requests with the given
+// bearer token.
+func debugAuth(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="debug"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,49 @@
+package ca
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority"
+)
+
+func Test_newDebugServer(t *testing.T) {
+	assert.Nil(t, newDebugServer(nil, nil))
+
+	srv := newDebugServer(&authority.DebugConfig{Address: "127.0.0.1:0", BearerToken: "s3cr3t"}, nil)
+	assert.Equals(t, "127.0.0.1:0", srv.Addr)
+	assert.Equals(t, 0, int(srv.WriteTimeout))
+
+	tests := []struct {
+		name       string
+		path       string
+		auth       string
+		wantStatus int
+	}{
+		{"ok index", "/debug/pprof/", "Bearer s3cr3t", http.StatusOK},
+		{"ok goroutine", "/debug/pprof/goroutine?debug=2", "Bearer s3cr3t", http.StatusOK},
+		{"ok heap", "/debug/pprof/heap", "Bearer s3cr3t", http.StatusOK},
+		{"ok cmdline", "/debug/pprof/cmdline", "Bearer s3cr3t", http.StatusOK},
+		{"ok vars", "/debug/vars", "Bearer s3cr3t", http.StatusOK},
+		{"not found", "/metrics", "Bearer s3cr3t", http.StatusNotFound},
+		{"fail no token", "/debug/pprof/", "", http.StatusUnauthorized},
+		{"fail token", "/debug/pprof/goroutine", "Bearer foo", http.StatusUnauthorized},
+		{"fail scheme", "/debug/vars", "Basic s3cr3t", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			srv.Handler.ServeHTTP(w, req)
+			assert.Equals(t, tt.wantStatus, w.Code)
+			if tt.wantStatus == http.StatusUnauthorized {
+				assert.Equals(t, `Bearer realm="debug"`, w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
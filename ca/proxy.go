@@ -0,0 +1,155 @@
+package ca
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/audit"
+	"github.com/smallstep/certificates/authority"
+)
+
+// proxyMiddleware handles the headers set by the proxies in front of the CA.
+// It must wrap all the other middlewares so the logger, the rate limits and
+// the handlers see the real client address.
+//
+// The RemoteAddr of the requests from a trusted proxy is replaced by the
+// client address in the X-Forwarded-For or X-Real-IP headers, and the client
+// IP is added to the context for the audit log. The absolute links in the ACME
+// responses to a trusted proxy use the X-Forwarded-Proto and X-Forwarded-Host
+// headers. The headers are never used in requests from other addresses, they
+// can be set by anyone. The peers of a unix socket are local processes, with a
+// proxy configuration they are trusted as the proxy of the CA.
+func proxyMiddleware(c *authority.ProxyConfig, next http.Handler) (http.Handler, error) {
+	trusted, err := c.TrustedNetworks()
+	if err != nil {
+		return nil, err
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		remoteIP := hostIP(r.RemoteAddr)
+		fromTrusted := isTrustedIP(trusted, remoteIP)
+		if isUnixSocket(r) {
+			remoteIP, fromTrusted = "", c != nil
+		}
+		if fromTrusted {
+			if ip := forwardedClientIP(r.Header, trusted); ip != "" {
+				remoteIP = ip
+			}
+		}
+		if fromTrusted {
+			if u := forwardedBaseURL(r); u != nil {
+				ctx = acme.NewContextWithBaseURL(ctx, u)
+			}
+		}
+		if remoteIP != "" {
+			ctx = audit.NewContextWithClientIP(ctx, remoteIP)
+		}
+		r = r.WithContext(ctx)
+		if fromTrusted && remoteIP != "" {
+			r.RemoteAddr = remoteIP
+		}
+		next.ServeHTTP(w, r)
+	}), nil
+}
+
+// isUnixSocket returns true if the request was received on a unix socket.
+func isUnixSocket(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
+
+// forwardedBaseURL returns the base URL of the absolute links in the ACME
+// responses using the X-Forwarded-Proto and X-Forwarded-Host headers. Without
+// the headers the links use https and the host of the request.
+func forwardedBaseURL(r *http.Request) *url.URL {
+	u := &url.URL{Scheme: "https", Host: r.Host}
+	if proto := strings.ToLower(forwardedValue(r.Header, "X-Forwarded-Proto")); proto == "http" || proto == "https" {
+		u.Scheme = proto
+	}
+	if host := strings.ToLower(forwardedValue(r.Header, "X-Forwarded-Host")); isValidHost(host) {
+		u.Host = host
+	}
+	if u.Host == "" {
+		return nil
+	}
+	return u
+}
+
+// forwardedClientIP returns the address of the client in the X-Forwarded-For
+// header, the first one from the right that is not a trusted proxy, or the
+// one in X-Real-IP if the first header is not present.
+func forwardedClientIP(h http.Header, trusted []*net.IPNet) string {
+	if xff := h["X-Forwarded-For"]; len(xff) > 0 {
+		addrs := strings.Split(strings.Join(xff, ","), ",")
+		var ip string
+		for i := len(addrs) - 1; i >= 0; i-- {
+			if ip = parseIP(addrs[i]); ip == "" || !isTrustedIP(trusted, ip) {
+				break
+			}
+		}
+		return ip
+	}
+	return parseIP(h.Get("X-Real-IP"))
+}
+
+// forwardedValue returns the last value of a X-Forwarded header. Proxies
+// append their values to the list, the last one is the one added by the
+// trusted proxy that sent the request, the others can come from the client.
+func forwardedValue(h http.Header, key string) string {
+	values := h[http.CanonicalHeaderKey(key)]
+	if len(values) == 0 {
+		return ""
+	}
+	v := values[len(values)-1]
+	if i := strings.LastIndex(v, ","); i >= 0 {
+		v = v[i+1:]
+	}
+	return strings.TrimSpace(v)
+}
+
+// isValidHost returns true if the host is a host name or IP address with an
+// optional port, and nothing else.
+func isValidHost(host string) bool {
+	if host == "" {
+		return false
+	}
+	u, err := url.Parse("//" + host)
+	return err == nil && u.Host == host
+}
+
+// isTrustedIP returns true if the ip is in one of the trusted networks.
+func isTrustedIP(trusted []*net.IPNet, ip string) bool {
+	if len(trusted) == 0 {
+		return false
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostIP returns the host part of an address with or without port.
+func hostIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// parseIP returns the normalized IP address in s, or an empty string if s is
+// not an IP address.
+func parseIP(s string) string {
+	if ip := net.ParseIP(strings.TrimSpace(s)); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
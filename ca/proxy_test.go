@@ -0,0 +1,137 @@
+package ca
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/audit"
+	"github.com/smallstep/certificates/authority"
+)
+
+type proxyResult struct {
+	remoteAddr string
+	clientIP   string
+	baseURL    string
+}
+
+func serveProxy(t *testing.T, c *authority.ProxyConfig, remoteAddr, host string, headers map[string]string) proxyResult {
+	t.Helper()
+	return serveProxyRequest(t, c, httptest.NewRequest("GET", "/acme/acme/directory", nil), remoteAddr, host, headers)
+}
+
+func serveProxyRequest(t *testing.T, c *authority.ProxyConfig, req *http.Request, remoteAddr, host string, headers map[string]string) proxyResult {
+	t.Helper()
+	var res proxyResult
+	h, err := proxyMiddleware(c, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res.remoteAddr = r.RemoteAddr
+		res.clientIP = audit.ClientIPFromContext(r.Context())
+		if u := acme.BaseURLFromContext(r.Context()); u != nil {
+			res.baseURL = u.String()
+		}
+	}))
+	assert.FatalError(t, err)
+	req.RemoteAddr = remoteAddr
+	req.Host = host
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	return res
+}
+
+func Test_proxyMiddleware_baseURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		host    string
+		headers map[string]string
+		want    string
+	}{
+		{"no headers", "ca.example.com:8080", nil, "https://ca.example.com:8080"},
+		{"proto", "ca.example.com", map[string]string{"X-Forwarded-Proto": "http"}, "http://ca.example.com"},
+		{"host", "10.0.0.1:8080", map[string]string{"X-Forwarded-Host": "acme.example.com"}, "https://acme.example.com"},
+		{"both", "10.0.0.1:8080", map[string]string{"X-Forwarded-Proto": "HTTPS", "X-Forwarded-Host": "acme.example.com:8443"}, "https://acme.example.com:8443"},
+		{"last value", "10.0.0.1:8080", map[string]string{"X-Forwarded-Proto": "http, https", "X-Forwarded-Host": "evil.com, acme.example.com"}, "https://acme.example.com"},
+		{"bad proto", "ca.example.com", map[string]string{"X-Forwarded-Proto": "ftp"}, "https://ca.example.com"},
+		{"bad host", "ca.example.com", map[string]string{"X-Forwarded-Host": "evil.com/path"}, "https://ca.example.com"},
+		{"bad host user", "ca.example.com", map[string]string{"X-Forwarded-Host": "user@evil.com"}, "https://ca.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := serveProxy(t, &authority.ProxyConfig{TrustedProxies: []string{"192.0.2.1"}}, "192.0.2.1:1234", tt.host, tt.headers)
+			assert.Equals(t, tt.want, res.baseURL)
+		})
+	}
+
+	// Without proxy configuration, or without trusted proxies, the links use
+	// the configured DNS name.
+	res := serveProxy(t, nil, "192.0.2.1:1234", "ca.example.com", map[string]string{"X-Forwarded-Host": "acme.example.com"})
+	assert.Equals(t, "", res.baseURL)
+	res = serveProxy(t, &authority.ProxyConfig{}, "192.0.2.1:1234", "ca.example.com", map[string]string{"X-Forwarded-Host": "acme.example.com"})
+	assert.Equals(t, "", res.baseURL)
+}
+
+func Test_proxyMiddleware_trustedProxies(t *testing.T) {
+	c := &authority.ProxyConfig{TrustedProxies: []string{"10.0.0.0/8", "192.168.0.10"}}
+	tests := []struct {
+		name           string
+		config         *authority.ProxyConfig
+		remoteAddr     string
+		headers        map[string]string
+		wantRemoteAddr string
+		wantClientIP   string
+		wantBaseURL    string
+	}{
+		{"no proxy", nil, "192.0.2.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "192.0.2.1:1234", "192.0.2.1", ""},
+		{"no trusted proxies", &authority.ProxyConfig{}, "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Forwarded-Host": "acme.example.com"}, "10.0.0.1:1234", "10.0.0.1", ""},
+		{"trusted xff", c, "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Forwarded-Host": "acme.example.com"}, "198.51.100.1", "198.51.100.1", "https://acme.example.com"},
+		{"trusted xff chain", c, "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.7, 198.51.100.1, 192.168.0.10"}, "198.51.100.1", "198.51.100.1", "https://ca.example.com"},
+		{"trusted xff all trusted", c, "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "10.1.1.1, 192.168.0.10"}, "10.1.1.1", "10.1.1.1", "https://ca.example.com"},
+		{"trusted xff invalid", c, "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "unknown"}, "10.0.0.1", "10.0.0.1", "https://ca.example.com"},
+		{"trusted real ip", c, "192.168.0.10:1234", map[string]string{"X-Real-IP": "2001:db8::1"}, "2001:db8::1", "2001:db8::1", "https://ca.example.com"},
+		{"untrusted", c, "192.0.2.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Real-IP": "198.51.100.1", "X-Forwarded-Host": "acme.example.com"}, "192.0.2.1:1234", "192.0.2.1", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := serveProxy(t, tt.config, tt.remoteAddr, "ca.example.com", tt.headers)
+			assert.Equals(t, tt.wantRemoteAddr, res.remoteAddr)
+			assert.Equals(t, tt.wantClientIP, res.clientIP)
+			assert.Equals(t, tt.wantBaseURL, res.baseURL)
+		})
+	}
+
+	_, err := proxyMiddleware(&authority.ProxyConfig{TrustedProxies: []string{"foo"}}, http.NotFoundHandler())
+	assert.Error(t, err)
+}
+
+func Test_proxyMiddleware_unixSocket(t *testing.T) {
+	c := &authority.ProxyConfig{TrustedProxies: []string{"10.0.0.0/8"}}
+	headers := map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Forwarded-Host": "acme.example.com"}
+	tests := []struct {
+		name           string
+		config         *authority.ProxyConfig
+		headers        map[string]string
+		wantRemoteAddr string
+		wantClientIP   string
+		wantBaseURL    string
+	}{
+		{"no proxy", nil, headers, "@", "", ""},
+		{"proxy", &authority.ProxyConfig{}, headers, "198.51.100.1", "198.51.100.1", "https://acme.example.com"},
+		{"trusted proxies", c, headers, "198.51.100.1", "198.51.100.1", "https://acme.example.com"},
+		{"no headers", c, nil, "@", "", "https://ca.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/acme/acme/directory", nil)
+			req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, &net.UnixAddr{Name: "/run/step-ca/ca.sock", Net: "unix"}))
+			res := serveProxyRequest(t, tt.config, req, "@", "ca.example.com", tt.headers)
+			assert.Equals(t, tt.wantRemoteAddr, res.remoteAddr)
+			assert.Equals(t, tt.wantClientIP, res.clientIP)
+			assert.Equals(t, tt.wantBaseURL, res.baseURL)
+		})
+	}
+}
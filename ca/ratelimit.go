@@ -0,0 +1,84 @@
+package ca
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/api"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/ratelimit"
+)
+
+// maxTokenPeekSize is the maximum number of bytes of the body read to look
+// for the token of a request.
+const maxTokenPeekSize = 1 << 20
+
+// rateLimitProvisioner returns the function used by the rate limiter to get
+// the provisioner of a request. The provisioner is the one that authorizes
+// the one-time token in the body, or the one of the client certificate,
+// verified in the TLS handshake, on renewals. Requests that are not
+// authenticated by a provisioner only count against the global and per-IP
+// limits.
+//
+// The token is verified but not stored, the handlers will verify and store
+// it later.
+func rateLimitProvisioner(auth *authority.Authority) ratelimit.ProvisionerFunc {
+	return func(r *http.Request) string {
+		if token := peekToken(r); token != "" {
+			p, err := auth.LoadProvisionerByToken(token)
+			if err != nil || authorizeRateLimitToken(r, p, token) != nil {
+				return ""
+			}
+			return p.GetID()
+		}
+		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			if p, err := auth.LoadProvisionerByCertificate(r.TLS.PeerCertificates[0]); err == nil {
+				return p.GetID()
+			}
+		}
+		return ""
+	}
+}
+
+// authorizeRateLimitToken verifies the token with the provisioner method used
+// by the endpoint of the request.
+func authorizeRateLimitToken(r *http.Request, p provisioner.Interface, token string) error {
+	var err error
+	ctx := r.Context()
+	switch strings.TrimPrefix(r.URL.Path, "/1.0") {
+	case "/sign", "/sign/batch":
+		_, err = p.AuthorizeSign(provisioner.NewContextWithMethod(ctx, provisioner.SignMethod), token)
+	case "/ssh/sign", "/sign-ssh":
+		_, err = p.AuthorizeSSHSign(provisioner.NewContextWithMethod(ctx, provisioner.SSHSignMethod), token)
+	case "/ssh/renew":
+		_, err = p.AuthorizeSSHRenew(provisioner.NewContextWithMethod(ctx, provisioner.SSHRenewMethod), token)
+	case "/ssh/rekey":
+		_, _, err = p.AuthorizeSSHRekey(provisioner.NewContextWithMethod(ctx, provisioner.SSHRekeyMethod), token)
+	default:
+		err = errors.Errorf("token is not supported in %s", r.URL.Path)
+	}
+	return err
+}
+
+// peekToken returns the ott in the JSON body of the request. The body is
+// restored so the handler can read it again, and it's bounded by the request
+// limits if LimitBody has been applied.
+func peekToken(r *http.Request) string {
+	if r.Method != http.MethodPost {
+		return ""
+	}
+	b, err := api.PeekBody(r, maxTokenPeekSize)
+	if err != nil {
+		return ""
+	}
+	var body struct {
+		OTT string `json:"ott"`
+	}
+	if err := json.Unmarshal(b, &body); err != nil {
+		return ""
+	}
+	return body.OTT
+}
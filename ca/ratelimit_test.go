@@ -0,0 +1,98 @@
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority"
+	stepJOSE "github.com/smallstep/cli/jose"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func Test_peekToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		want   string
+	}{
+		{"ok", "POST", `{"csr":"csr","ott":"token"}`, "token"},
+		{"no ott", "POST", `{"csr":"csr"}`, ""},
+		{"not json", "POST", `not json`, ""},
+		{"get", "GET", `{"ott":"token"}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/sign", strings.NewReader(tt.body))
+			assert.Equals(t, tt.want, peekToken(req))
+			// The body can be read again.
+			b, err := ioutil.ReadAll(req.Body)
+			assert.FatalError(t, err)
+			assert.Equals(t, tt.body, string(b))
+		})
+	}
+}
+
+func Test_rateLimitProvisioner(t *testing.T) {
+	config, err := authority.LoadConfiguration("testdata/ca.json")
+	assert.FatalError(t, err)
+	auth, err := authority.New(config)
+	assert.FatalError(t, err)
+	jwk, err := stepJOSE.ParseKey("testdata/secrets/ott_mariano_priv.jwk", stepJOSE.WithPassword([]byte("password")))
+	assert.FatalError(t, err)
+	p, err := auth.LoadProvisionerByID("mariano:" + jwk.KeyID)
+	assert.FatalError(t, err)
+	forged, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	token := func(key interface{}) string {
+		sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key},
+			new(jose.SignerOptions).WithType("JWT").WithHeader("kid", jwk.KeyID))
+		assert.FatalError(t, err)
+		now := time.Now()
+		raw, err := jwt.Signed(sig).Claims(struct {
+			jwt.Claims
+			SANS []string `json:"sans"`
+		}{
+			Claims: jwt.Claims{
+				ID:        "the-jti",
+				Subject:   "test.smallstep.com",
+				Issuer:    "mariano",
+				NotBefore: jwt.NewNumericDate(now),
+				Expiry:    jwt.NewNumericDate(now.Add(time.Minute)),
+				Audience:  []string{"https://127.0.0.1:0/sign"},
+			},
+			SANS: []string{"test.smallstep.com"},
+		}).CompactSerialize()
+		assert.FatalError(t, err)
+		return raw
+	}
+
+	fn := rateLimitProvisioner(auth)
+	tests := []struct {
+		name string
+		path string
+		ott  string
+		want string
+	}{
+		{"ok", "/sign", token(jwk.Key), p.GetID()},
+		{"ok 1.0", "/1.0/sign", token(jwk.Key), p.GetID()},
+		{"forged", "/sign", token(forged), ""},
+		{"other endpoint", "/ssh/sign", token(jwk.Key), ""},
+		{"not a token", "/sign", "foo", ""},
+		{"no token", "/sign", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.path, strings.NewReader(`{"ott":"`+tt.ott+`"}`))
+			assert.Equals(t, tt.want, fn(req))
+		})
+	}
+}
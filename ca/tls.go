@@ -44,7 +44,9 @@ func (c *Client) getClientTLSConfig(ctx context.Context, sign *api.SignResponse,
 	tlsConfig.GetClientCertificate = renewer.GetClientCertificate
 	tlsConfig.PreferServerCipherSuites = true
 
-	// Apply options and initialize mutable tls.Config
+	// Apply options and initialize mutable tls.Config. The roots and
+	// federation requests of the options can require a client certificate.
+	c.setClientCertificate(renewer.GetClientCertificate)
 	tlsCtx := newTLSOptionCtx(c, tlsConfig, sign)
 	if err := tlsCtx.apply(options); err != nil {
 		return nil, nil, err
@@ -91,7 +93,9 @@ func (c *Client) GetServerTLSConfig(ctx context.Context, sign *api.SignResponse,
 	tlsConfig.PreferServerCipherSuites = true
 	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
 
-	// Apply options and initialize mutable tls.Config
+	// Apply options and initialize mutable tls.Config. The roots and
+	// federation requests of the options can require a client certificate.
+	c.setClientCertificate(renewer.GetClientCertificate)
 	tlsCtx := newTLSOptionCtx(c, tlsConfig, sign)
 	if err := tlsCtx.apply(options); err != nil {
 		return nil, err
@@ -238,6 +242,26 @@ func getDefaultTLSConfig(sign *api.SignResponse) *tls.Config {
 	}
 }
 
+// setClientCertificate replaces the transport of the client with a new one
+// that uses the same TLS configuration and the given function to get the
+// client certificate. A new transport is required so the idle connections
+// without a client certificate are not reused. It does nothing if the
+// transport is not an *http.Transport.
+func (c *Client) setClientCertificate(fn func(*tls.CertificateRequestInfo) (*tls.Certificate, error)) {
+	tr, ok := c.client.Client.Transport.(*http.Transport)
+	if !ok {
+		return
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if tr.TLSClientConfig != nil {
+		tlsConfig = tr.TLSClientConfig.Clone()
+	}
+	tlsConfig.GetClientCertificate = fn
+	if tr, err := getDefaultTransport(tlsConfig); err == nil {
+		c.SetTransport(tr)
+	}
+}
+
 // getDefaultTransport returns an http.Transport with the same parameters than
 // http.DefaultTransport, but adds the given tls.Config and configures the
 // transport for HTTP/2.
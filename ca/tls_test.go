@@ -522,3 +522,43 @@ func TestRootCertificateCertificate(t *testing.T) {
 		})
 	}
 }
+
+func TestClient_setClientCertificate(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version := "no-cert"
+		if len(r.TLS.PeerCertificates) > 0 {
+			version = "with-cert"
+		}
+		api.JSON(w, api.VersionResponse{Version: version})
+	}))
+	srv.EnableHTTP2 = true
+	srv.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	tr, err := getDefaultTransport(&tls.Config{RootCAs: pool})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewClient(srv.URL, WithTransport(tr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The idle connection without a client certificate must not be reused.
+	for _, want := range []string{"no-cert", "with-cert"} {
+		resp, err := c.Version()
+		if err != nil {
+			t.Fatalf("Client.Version() error = %v", err)
+		}
+		if resp.Version != want {
+			t.Errorf("Client.Version() = %s, want %s", resp.Version, want)
+		}
+		cert := tls.Certificate{Certificate: [][]byte{srv.Certificate().Raw}, PrivateKey: srv.TLS.Certificates[0].PrivateKey}
+		c.setClientCertificate(func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &cert, nil
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package apiv1
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotImplemented is the type of error returned if an operation is not
+// implemented.
+type ErrNotImplemented struct {
+	msg string
+}
+
+func (e ErrNotImplemented) Error() string {
+	if e.msg != "" {
+		return e.msg
+	}
+	return "not implemented"
+}
+
+// Type represents the CAS type used.
+type Type string
+
+const (
+	// DefaultCAS signs the certificates with the keys of the authority.
+	DefaultCAS Type = ""
+	// SoftCAS signs the certificates with the keys of the authority.
+	SoftCAS Type = "softcas"
+	// StepCAS forwards the certificate requests to an upstream step CA. The
+	// authority works as a registration authority (RA).
+	StepCAS Type = "stepcas"
+	// CloudCAS is a CAS implementation using Google's Certificate Authority
+	// Service.
+	CloudCAS Type = "cloudcas"
+	// AWSPCA is a CAS implementation using Amazon AWS Private CA.
+	AWSPCA Type = "awspca"
+)
+
+// Options represents the configuration of the certificate authority service
+// (CAS) that signs the X.509 certificates.
+type Options struct {
+	// The type of the CAS to use.
+	Type string `json:"type"`
+
+	// CertificateAuthority is the URL of the upstream step CA, or the resource
+	// name of the CA pool or certificate authority in Google's Certificate
+	// Authority Service, e.g.
+	// projects/<project>/locations/<location>/caPools/<pool>.
+	CertificateAuthority string `json:"certificateAuthority,omitempty"`
+
+	// CertificateAuthorityFingerprint is the SHA-256 fingerprint of the root
+	// certificate of the upstream step CA.
+	CertificateAuthorityFingerprint string `json:"certificateAuthorityFingerprint,omitempty"`
+
+	// CertificateIssuer contains the credentials used to authorize the
+	// requests to the upstream step CA.
+	CertificateIssuer *CertificateIssuer `json:"certificateIssuer,omitempty"`
+
+	// CredentialsFile is the path to a Google Cloud credentials file, or to an
+	// AWS shared credentials file. If empty the default credentials are used.
+	CredentialsFile string `json:"credentialsFile,omitempty"`
+
+	// Profile is the profile in the AWS shared credentials file.
+	Profile string `json:"profile,omitempty"`
+
+	// TemplateARN is the ARN of the AWS Private CA template used to issue the
+	// certificates. APIPassthrough templates receive the subject and
+	// extensions authorized by the authority.
+	TemplateARN string `json:"templateArn,omitempty"`
+
+	// SigningAlgorithm is the AWS Private CA signing algorithm, e.g.
+	// SHA256WITHECDSA. If empty the one of the certificate authority is used.
+	SigningAlgorithm string `json:"signingAlgorithm,omitempty"`
+}
+
+// CertificateIssuer contains the credentials of a provisioner of the upstream
+// step CA. Only the x5c provisioner is supported, the certificate and key are
+// used to sign the tokens and as the TLS client certificate.
+type CertificateIssuer struct {
+	Type        string `json:"type"`
+	Provisioner string `json:"provisioner"`
+	Certificate string `json:"crt"`
+	Key         string `json:"key"`
+	Password    string `json:"password,omitempty"`
+}
+
+// Validate checks the fields in Options.
+func (o *Options) Validate() error {
+	if o == nil {
+		return nil
+	}
+
+	switch Type(strings.ToLower(o.Type)) {
+	case DefaultCAS, SoftCAS:
+	case StepCAS:
+		switch {
+		case o.CertificateAuthority == "":
+			return errors.New("cas.certificateAuthority cannot be empty")
+		case o.CertificateAuthorityFingerprint == "":
+			return errors.New("cas.certificateAuthorityFingerprint cannot be empty")
+		case o.CertificateIssuer == nil:
+			return errors.New("cas.certificateIssuer cannot be empty")
+		}
+		return o.CertificateIssuer.Validate()
+	case CloudCAS:
+		if !strings.HasPrefix(o.CertificateAuthority, "projects/") || !strings.Contains(o.CertificateAuthority, "/caPools/") {
+			return errors.Errorf("cas.certificateAuthority %s is not a valid CA pool or certificate authority name", o.CertificateAuthority)
+		}
+	case AWSPCA:
+		if !strings.HasPrefix(o.CertificateAuthority, "arn:") || !strings.Contains(o.CertificateAuthority, ":certificate-authority/") {
+			return errors.Errorf("cas.certificateAuthority %s is not a valid certificate authority arn", o.CertificateAuthority)
+		}
+		if o.TemplateARN != "" && !strings.HasPrefix(o.TemplateARN, "arn:") {
+			return errors.Errorf("cas.templateArn %s is not a valid template arn", o.TemplateARN)
+		}
+	default:
+		return errors.Errorf("unsupported cas type %s", o.Type)
+	}
+
+	return nil
+}
+
+// Validate checks the fields in CertificateIssuer.
+func (i *CertificateIssuer) Validate() error {
+	switch {
+	case strings.ToLower(i.Type) != "x5c":
+		return errors.Errorf("unsupported cas.certificateIssuer type %s", i.Type)
+	case i.Provisioner == "":
+		return errors.New("cas.certificateIssuer.provisioner cannot be empty")
+	case i.Certificate == "":
+		return errors.New("cas.certificateIssuer.crt cannot be empty")
+	case i.Key == "":
+		return errors.New("cas.certificateIssuer.key cannot be empty")
+	}
+	return nil
+}
+
+// IsCertificateAuthority returns true if the authority signs the certificates
+// with its own keys.
+func (o *Options) IsCertificateAuthority() bool {
+	if o == nil {
+		return true
+	}
+	t := Type(strings.ToLower(o.Type))
+	return t == DefaultCAS || t == SoftCAS
+}
@@ -0,0 +1,86 @@
+package apiv1
+
+import (
+	"testing"
+)
+
+func TestOptions_Validate(t *testing.T) {
+	issuer := &CertificateIssuer{Type: "x5c", Provisioner: "ra@smallstep.com", Certificate: "ra.crt", Key: "ra.key"}
+	tests := []struct {
+		name    string
+		options *Options
+		wantErr bool
+	}{
+		{"nil", nil, false},
+		{"default", &Options{}, false},
+		{"softcas", &Options{Type: "softcas"}, false},
+		{"stepcas", &Options{Type: "stepcas", CertificateAuthority: "https://ca.smallstep.com", CertificateAuthorityFingerprint: "abcd", CertificateIssuer: issuer}, false},
+		{"stepcas no url", &Options{Type: "stepcas", CertificateAuthorityFingerprint: "abcd", CertificateIssuer: issuer}, true},
+		{"stepcas no fingerprint", &Options{Type: "stepcas", CertificateAuthority: "https://ca.smallstep.com", CertificateIssuer: issuer}, true},
+		{"stepcas no issuer", &Options{Type: "stepcas", CertificateAuthority: "https://ca.smallstep.com", CertificateAuthorityFingerprint: "abcd"}, true},
+		{"stepcas issuer type", &Options{Type: "stepcas", CertificateAuthority: "https://ca.smallstep.com", CertificateAuthorityFingerprint: "abcd", CertificateIssuer: &CertificateIssuer{Type: "jwk", Provisioner: "ra@smallstep.com", Certificate: "ra.crt", Key: "ra.key"}}, true},
+		{"stepcas issuer provisioner", &Options{Type: "stepcas", CertificateAuthority: "https://ca.smallstep.com", CertificateAuthorityFingerprint: "abcd", CertificateIssuer: &CertificateIssuer{Type: "x5c", Certificate: "ra.crt", Key: "ra.key"}}, true},
+		{"stepcas issuer crt", &Options{Type: "stepcas", CertificateAuthority: "https://ca.smallstep.com", CertificateAuthorityFingerprint: "abcd", CertificateIssuer: &CertificateIssuer{Type: "x5c", Provisioner: "ra@smallstep.com", Key: "ra.key"}}, true},
+		{"stepcas issuer key", &Options{Type: "stepcas", CertificateAuthority: "https://ca.smallstep.com", CertificateAuthorityFingerprint: "abcd", CertificateIssuer: &CertificateIssuer{Type: "x5c", Provisioner: "ra@smallstep.com", Certificate: "ra.crt"}}, true},
+		{"cloudcas", &Options{Type: "cloudcas", CertificateAuthority: "projects/smallstep/locations/us-west1/caPools/pool"}, false},
+		{"cloudcas authority", &Options{Type: "cloudcas", CertificateAuthority: "projects/smallstep/locations/us-west1/caPools/pool/certificateAuthorities/ca", CredentialsFile: "credentials.json"}, false},
+		{"cloudcas no authority", &Options{Type: "cloudcas"}, true},
+		{"cloudcas invalid authority", &Options{Type: "cloudcas", CertificateAuthority: "https://ca.smallstep.com"}, true},
+		{"awspca", &Options{Type: "awspca", CertificateAuthority: "arn:aws:acm-pca:us-east-1:123456789012:certificate-authority/12345678-1234-1234-1234-123456789012"}, false},
+		{"awspca template", &Options{Type: "awspca", CertificateAuthority: "arn:aws:acm-pca:us-east-1:123456789012:certificate-authority/12345678-1234-1234-1234-123456789012", TemplateARN: "arn:aws:acm-pca:::template/EndEntityCertificate_APIPassthrough/V1"}, false},
+		{"awspca no authority", &Options{Type: "awspca"}, true},
+		{"awspca invalid template", &Options{Type: "awspca", CertificateAuthority: "arn:aws:acm-pca:us-east-1:123456789012:certificate-authority/12345678-1234-1234-1234-123456789012", TemplateARN: "EndEntityCertificate"}, true},
+		{"unsupported", &Options{Type: "unsupported"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.options.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Options.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOptions_IsCertificateAuthority(t *testing.T) {
+	tests := []struct {
+		name    string
+		options *Options
+		want    bool
+	}{
+		{"nil", nil, true},
+		{"default", &Options{}, true},
+		{"softcas", &Options{Type: "SoftCAS"}, true},
+		{"stepcas", &Options{Type: "stepcas"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.options.IsCertificateAuthority(); got != tt.want {
+				t.Errorf("Options.IsCertificateAuthority() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrNotImplemented_Error(t *testing.T) {
+	type fields struct {
+		msg string
+	}
+	tests := []struct {
+		name   string
+		fields fields
+		want   string
+	}{
+		{"default", fields{}, "not implemented"},
+		{"custom", fields{"custom message: not implemented"}, "custom message: not implemented"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := ErrNotImplemented{
+				msg: tt.fields.msg,
+			}
+			if got := e.Error(); got != tt.want {
+				t.Errorf("ErrNotImplemented.Error() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package apiv1
+
+import (
+	"crypto/x509"
+	"time"
+)
+
+// CreateCertificateRequest is the request used to sign a new certificate.
+// Template contains the attributes of the certificate validated by the
+// authority, CSR is the certificate request with the key of the certificate.
+type CreateCertificateRequest struct {
+	Template *x509.Certificate
+	CSR      *x509.CertificateRequest
+	Lifetime time.Duration
+}
+
+// CreateCertificateResponse is the response to a create certificate request.
+type CreateCertificateResponse struct {
+	Certificate      *x509.Certificate
+	CertificateChain []*x509.Certificate
+}
+
+// RenewCertificateRequest is the request used to renew a certificate.
+type RenewCertificateRequest struct {
+	Template *x509.Certificate
+	CSR      *x509.CertificateRequest
+	Lifetime time.Duration
+}
+
+// RenewCertificateResponse is the response to a renew certificate request.
+type RenewCertificateResponse struct {
+	Certificate      *x509.Certificate
+	CertificateChain []*x509.Certificate
+}
+
+// RevokeCertificateRequest is the request used to revoke a certificate.
+type RevokeCertificateRequest struct {
+	Certificate  *x509.Certificate
+	SerialNumber string
+	Reason       string
+	ReasonCode   int
+}
+
+// RevokeCertificateResponse is the response to a revoke certificate request.
+type RevokeCertificateResponse struct {
+	Certificate      *x509.Certificate
+	CertificateChain []*x509.Certificate
+}
@@ -0,0 +1,375 @@
+package awspca
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/cas/apiv1"
+	"github.com/smallstep/certificates/internal/awsutil"
+	"github.com/smallstep/cli/crypto/randutil"
+)
+
+// defaultTemplateARN is the template used by AWS Private CA if none is
+// configured.
+const defaultTemplateARN = "arn:aws:acm-pca:::template/EndEntityCertificate/V1"
+
+// pollInterval is the time between GetCertificate requests while the
+// certificate is being issued.
+var pollInterval = time.Second
+
+// revocationReasons maps RFC 5280 reason codes to the revocation reasons of
+// AWS Private CA.
+var revocationReasons = map[int]string{
+	0:  "UNSPECIFIED",
+	1:  "KEY_COMPROMISE",
+	2:  "CERTIFICATE_AUTHORITY_COMPROMISE",
+	3:  "AFFILIATION_CHANGED",
+	4:  "SUPERSEDED",
+	5:  "CESSATION_OF_OPERATION",
+	9:  "PRIVILEGE_WITHDRAWN",
+	10: "A_A_COMPROMISE",
+}
+
+// Extensions sent as part of the APIPassthrough extensions instead of custom
+// extensions, or managed by AWS Private CA.
+var managedExtensions = []asn1.ObjectIdentifier{
+	{2, 5, 29, 14}, // subject key identifier
+	{2, 5, 29, 15}, // key usage
+	{2, 5, 29, 17}, // subject alternative name
+	{2, 5, 29, 19}, // basic constraints
+	{2, 5, 29, 35}, // authority key identifier
+	{2, 5, 29, 37}, // extended key usage
+}
+
+// AWSPCA implements a CertificateAuthorityService using AWS Private CA. The
+// signing keys never leave AWS, the authority keeps validating and
+// authorizing the requests with its provisioners.
+type AWSPCA struct {
+	client           PrivateCAClient
+	arn              string
+	templateARN      string
+	signingAlgorithm string
+}
+
+// New creates a new AWSPCA configured with a new client.
+func New(ctx context.Context, opts apiv1.Options) (*AWSPCA, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	creds, err := awsutil.NewCredentials(opts.CredentialsFile, opts.Profile)
+	if err != nil {
+		return nil, err
+	}
+	// arn:partition:acm-pca:region:account:certificate-authority/id
+	parts := strings.SplitN(opts.CertificateAuthority, ":", 6)
+	if len(parts) != 6 || parts[3] == "" {
+		return nil, errors.Errorf("cas.certificateAuthority %s does not contain a region", opts.CertificateAuthority)
+	}
+	client := &jsonClient{
+		region: parts[3],
+		client: &awsutil.Client{
+			Service:     serviceName,
+			Target:      "ACMPrivateCA",
+			Credentials: creds,
+			HTTPClient:  &http.Client{Timeout: 15 * time.Second},
+		},
+	}
+
+	signingAlgorithm := opts.SigningAlgorithm
+	if signingAlgorithm == "" {
+		if signingAlgorithm, err = client.DescribeCertificateAuthority(ctx, opts.CertificateAuthority); err != nil {
+			return nil, errors.Wrap(err, "awsPCA DescribeCertificateAuthority failed")
+		}
+	}
+
+	return NewAWSPCA(client, opts.CertificateAuthority, opts.TemplateARN, signingAlgorithm), nil
+}
+
+// NewAWSPCA creates an AWSPCA with a given client. If the templateARN is empty
+// the EndEntityCertificate template is used.
+func NewAWSPCA(client PrivateCAClient, arn, templateARN, signingAlgorithm string) *AWSPCA {
+	if templateARN == "" {
+		templateARN = defaultTemplateARN
+	}
+	return &AWSPCA{
+		client:           client,
+		arn:              arn,
+		templateARN:      templateARN,
+		signingAlgorithm: signingAlgorithm,
+	}
+}
+
+// CreateCertificate signs a new certificate using AWS Private CA. The subject
+// and extensions of the template are only sent with APIPassthrough templates,
+// the rest of the templates use the ones in the CSR.
+func (c *AWSPCA) CreateCertificate(req *apiv1.CreateCertificateRequest) (*apiv1.CreateCertificateResponse, error) {
+	switch {
+	case req.Template == nil:
+		return nil, errors.New("createCertificateRequest `template` cannot be nil")
+	case req.CSR == nil:
+		return nil, errors.New("createCertificateRequest `csr` cannot be nil")
+	case req.Lifetime == 0:
+		return nil, errors.New("createCertificateRequest `lifetime` cannot be 0")
+	}
+
+	tmpl := req.Template
+	notAfter := tmpl.NotAfter
+	if notAfter.IsZero() {
+		notAfter = time.Now().Add(req.Lifetime)
+	}
+
+	token, err := randutil.Alphanumeric(32)
+	if err != nil {
+		return nil, err
+	}
+	in := &IssueCertificateInput{
+		CertificateAuthorityArn: c.arn,
+		Csr:                     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: req.CSR.Raw}),
+		SigningAlgorithm:        c.signingAlgorithm,
+		TemplateArn:             c.templateARN,
+		Validity:                &Validity{Type: "ABSOLUTE", Value: notAfter.Unix()},
+		IdempotencyToken:        token,
+	}
+	if !tmpl.NotBefore.IsZero() {
+		in.ValidityNotBefore = &Validity{Type: "ABSOLUTE", Value: tmpl.NotBefore.Unix()}
+	}
+	if strings.Contains(c.templateARN, "APIPassthrough") {
+		in.APIPassthrough = createAPIPassthrough(tmpl)
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	certificateArn, err := c.client.IssueCertificate(ctx, in)
+	if err != nil {
+		return nil, errors.Wrap(err, "awsPCA IssueCertificate failed")
+	}
+	crt, chain, err := c.getCertificate(ctx, certificateArn)
+	if err != nil {
+		return nil, err
+	}
+	return &apiv1.CreateCertificateResponse{
+		Certificate:      crt,
+		CertificateChain: chain,
+	}, nil
+}
+
+// RenewCertificate is not implemented, AWS Private CA requires a new CSR to
+// issue a certificate.
+func (c *AWSPCA) RenewCertificate(req *apiv1.RenewCertificateRequest) (*apiv1.RenewCertificateResponse, error) {
+	return nil, apiv1.ErrNotImplemented{}
+}
+
+// RevokeCertificate revokes a certificate using AWS Private CA. The serial
+// number is read from the certificate if present.
+func (c *AWSPCA) RevokeCertificate(req *apiv1.RevokeCertificateRequest) (*apiv1.RevokeCertificateResponse, error) {
+	reason, ok := revocationReasons[req.ReasonCode]
+	if !ok {
+		return nil, errors.Errorf("revokeCertificate 'reasonCode=%d' is invalid or not supported", req.ReasonCode)
+	}
+
+	var sn *big.Int
+	switch {
+	case req.Certificate != nil:
+		sn = req.Certificate.SerialNumber
+	case req.SerialNumber != "":
+		if sn, ok = new(big.Int).SetString(req.SerialNumber, 10); !ok {
+			return nil, errors.Errorf("revokeCertificateRequest `serialNumber` %s is not a valid serial number", req.SerialNumber)
+		}
+	default:
+		return nil, errors.New("revokeCertificateRequest `certificate` or `serialNumber` is required")
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	if err := c.client.RevokeCertificate(ctx, &RevokeCertificateInput{
+		CertificateAuthorityArn: c.arn,
+		CertificateSerial:       formatSerialNumber(sn),
+		RevocationReason:        reason,
+	}); err != nil {
+		return nil, errors.Wrap(err, "awsPCA RevokeCertificate failed")
+	}
+
+	return &apiv1.RevokeCertificateResponse{
+		Certificate: req.Certificate,
+	}, nil
+}
+
+// getCertificate returns the issued certificate and its chain. Certificates
+// are issued asynchronously, so it retries while the request is in progress.
+func (c *AWSPCA) getCertificate(ctx context.Context, certificateArn string) (*x509.Certificate, []*x509.Certificate, error) {
+	var out *GetCertificateOutput
+	for {
+		var err error
+		if out, err = c.client.GetCertificate(ctx, c.arn, certificateArn); err == nil {
+			break
+		}
+		if e, ok := err.(*Error); !ok || e.Type != "RequestInProgressException" {
+			return nil, nil, errors.Wrap(err, "awsPCA GetCertificate failed")
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, errors.Wrap(ctx.Err(), "awsPCA GetCertificate failed")
+		case <-time.After(pollInterval):
+		}
+	}
+
+	crts, err := parseCertificates(out.Certificate)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(crts) == 0 {
+		return nil, nil, errors.New("awsPCA GetCertificate did not return a certificate")
+	}
+	certs, err := parseCertificates(out.CertificateChain)
+	if err != nil {
+		return nil, nil, err
+	}
+	var chain []*x509.Certificate
+	for _, c := range certs {
+		// Root certificates are not part of the chain.
+		if c.CheckSignatureFrom(c) == nil {
+			continue
+		}
+		chain = append(chain, c)
+	}
+	return crts[0], chain, nil
+}
+
+func createAPIPassthrough(tmpl *x509.Certificate) *APIPassthrough {
+	first := func(s []string) string {
+		if len(s) > 0 {
+			return s[0]
+		}
+		return ""
+	}
+	name := tmpl.Subject
+	ext := &Extensions{
+		KeyUsage: &KeyUsage{
+			DigitalSignature: tmpl.KeyUsage&x509.KeyUsageDigitalSignature != 0,
+			NonRepudiation:   tmpl.KeyUsage&x509.KeyUsageContentCommitment != 0,
+			KeyEncipherment:  tmpl.KeyUsage&x509.KeyUsageKeyEncipherment != 0,
+			DataEncipherment: tmpl.KeyUsage&x509.KeyUsageDataEncipherment != 0,
+			KeyAgreement:     tmpl.KeyUsage&x509.KeyUsageKeyAgreement != 0,
+			KeyCertSign:      tmpl.KeyUsage&x509.KeyUsageCertSign != 0,
+			CRLSign:          tmpl.KeyUsage&x509.KeyUsageCRLSign != 0,
+			EncipherOnly:     tmpl.KeyUsage&x509.KeyUsageEncipherOnly != 0,
+			DecipherOnly:     tmpl.KeyUsage&x509.KeyUsageDecipherOnly != 0,
+		},
+	}
+	for _, s := range tmpl.DNSNames {
+		ext.SubjectAlternativeNames = append(ext.SubjectAlternativeNames, &GeneralName{DNSName: s})
+	}
+	for _, ip := range tmpl.IPAddresses {
+		ext.SubjectAlternativeNames = append(ext.SubjectAlternativeNames, &GeneralName{IPAddress: ip.String()})
+	}
+	for _, s := range tmpl.EmailAddresses {
+		ext.SubjectAlternativeNames = append(ext.SubjectAlternativeNames, &GeneralName{Rfc822Name: s})
+	}
+	for _, u := range tmpl.URIs {
+		ext.SubjectAlternativeNames = append(ext.SubjectAlternativeNames, &GeneralName{UniformResourceIdentifier: u.String()})
+	}
+	for _, eku := range tmpl.ExtKeyUsage {
+		var typ string
+		switch eku {
+		case x509.ExtKeyUsageServerAuth:
+			typ = "SERVER_AUTH"
+		case x509.ExtKeyUsageClientAuth:
+			typ = "CLIENT_AUTH"
+		case x509.ExtKeyUsageCodeSigning:
+			typ = "CODE_SIGNING"
+		case x509.ExtKeyUsageEmailProtection:
+			typ = "EMAIL_PROTECTION"
+		case x509.ExtKeyUsageTimeStamping:
+			typ = "TIME_STAMPING"
+		case x509.ExtKeyUsageOCSPSigning:
+			typ = "OCSP_SIGNING"
+		default:
+			continue
+		}
+		ext.ExtendedKeyUsage = append(ext.ExtendedKeyUsage, &ExtendedKeyUsage{ExtendedKeyUsageType: typ})
+	}
+	for _, oid := range tmpl.UnknownExtKeyUsage {
+		ext.ExtendedKeyUsage = append(ext.ExtendedKeyUsage, &ExtendedKeyUsage{ExtendedKeyUsageObjectIdentifier: oid.String()})
+	}
+	for _, e := range tmpl.ExtraExtensions {
+		if isManagedExtension(e.Id) {
+			continue
+		}
+		ext.CustomExtensions = append(ext.CustomExtensions, &CustomExtension{
+			ObjectIdentifier: e.Id.String(),
+			Value:            e.Value,
+			Critical:         e.Critical,
+		})
+	}
+
+	return &APIPassthrough{
+		Subject: &ASN1Subject{
+			CommonName:         name.CommonName,
+			Country:            first(name.Country),
+			Organization:       first(name.Organization),
+			OrganizationalUnit: first(name.OrganizationalUnit),
+			Locality:           first(name.Locality),
+			State:              first(name.Province),
+			SerialNumber:       name.SerialNumber,
+		},
+		Extensions: ext,
+	}
+}
+
+func isManagedExtension(oid asn1.ObjectIdentifier) bool {
+	for _, id := range managedExtensions {
+		if id.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
+
+// formatSerialNumber returns the serial number in the colon separated
+// hexadecimal format used by AWS Private CA.
+func formatSerialNumber(sn *big.Int) string {
+	b := sn.Bytes()
+	if len(b) == 0 {
+		b = []byte{0}
+	}
+	s := make([]string, len(b))
+	for i := range b {
+		s[i] = fmt.Sprintf("%02x", b[i])
+	}
+	return strings.Join(s, ":")
+}
+
+func parseCertificates(s string) ([]*x509.Certificate, error) {
+	var crts []*x509.Certificate
+	rest := []byte(s)
+	for {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, errors.New("error decoding certificate: invalid PEM")
+		}
+		crt, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing certificate")
+		}
+		crts = append(crts, crt)
+	}
+	return crts, nil
+}
+
+func defaultContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 30*time.Second)
+}
@@ -0,0 +1,380 @@
+package awspca
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/cas/apiv1"
+	"github.com/smallstep/certificates/internal/awsutil"
+)
+
+const (
+	testARN            = "arn:aws:acm-pca:us-east-1:123456789012:certificate-authority/12345678-1234-1234-1234-123456789012"
+	testPassthroughARN = "arn:aws:acm-pca:::template/EndEntityCertificate_APIPassthrough/V1"
+)
+
+func mustKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func mustCertificate(t *testing.T, tmpl, parent *x509.Certificate, pub crypto.PublicKey, signer crypto.Signer) *x509.Certificate {
+	t.Helper()
+	if tmpl.SerialNumber == nil {
+		tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	}
+	tmpl.NotBefore = time.Now().Add(-time.Minute)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent = tmpl
+	}
+	b, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt, err := x509.ParseCertificate(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crt
+}
+
+func mustCSR(t *testing.T, cn string, key crypto.Signer) *x509.CertificateRequest {
+	t.Helper()
+	b, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: cn}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.ParseCertificateRequest(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return csr
+}
+
+func encodeCertificate(crt *x509.Certificate) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: crt.Raw}))
+}
+
+// testPCA signs the certificate requests like AWS Private CA would do.
+type testPCA struct {
+	t            *testing.T
+	root         *x509.Certificate
+	intermediate *x509.Certificate
+	key          crypto.Signer
+	pending      int
+	input        *IssueCertificateInput
+	issued       map[string]*x509.Certificate
+	revoked      *RevokeCertificateInput
+}
+
+func (m *testPCA) client() *MockClient {
+	return &MockClient{
+		describeCertificateAuthority: func(ctx context.Context, arn string) (string, error) {
+			return "SHA256WITHECDSA", nil
+		},
+		issueCertificate: func(ctx context.Context, in *IssueCertificateInput) (string, error) {
+			block, _ := pem.Decode(in.Csr)
+			csr, err := x509.ParseCertificateRequest(block.Bytes)
+			if err != nil {
+				return "", err
+			}
+			if csr.Subject.CommonName == "fail" {
+				return "", &Error{Type: "ValidationException", Message: "force"}
+			}
+			m.input = in
+			if m.issued == nil {
+				m.issued = make(map[string]*x509.Certificate)
+			}
+			arn := in.CertificateAuthorityArn + "/certificate/" + csr.Subject.CommonName
+			m.issued[arn] = mustCertificate(m.t, &x509.Certificate{
+				Subject:  csr.Subject,
+				KeyUsage: x509.KeyUsageDigitalSignature,
+			}, m.intermediate, csr.PublicKey, m.key)
+			return arn, nil
+		},
+		getCertificate: func(ctx context.Context, caArn, certificateArn string) (*GetCertificateOutput, error) {
+			if m.pending > 0 {
+				m.pending--
+				return nil, &Error{Type: "RequestInProgressException", Message: "in progress"}
+			}
+			crt, ok := m.issued[certificateArn]
+			if !ok {
+				return nil, &Error{Type: "ResourceNotFoundException", Message: "not found"}
+			}
+			return &GetCertificateOutput{
+				Certificate:      encodeCertificate(crt),
+				CertificateChain: encodeCertificate(m.intermediate) + encodeCertificate(m.root),
+			}, nil
+		},
+		revokeCertificate: func(ctx context.Context, in *RevokeCertificateInput) error {
+			m.revoked = in
+			return nil
+		},
+	}
+}
+
+func newTestPCA(t *testing.T) *testPCA {
+	rootKey := mustKey(t)
+	root := mustCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "PCA Root"},
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil, rootKey.Public(), rootKey)
+	key := mustKey(t)
+	intermediate := mustCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "PCA Intermediate"},
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, root, key.Public(), rootKey)
+	return &testPCA{t: t, root: root, intermediate: intermediate, key: key}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    apiv1.Options
+		wantErr bool
+	}{
+		{"fail validation", apiv1.Options{Type: "awspca"}, true},
+		{"fail credentials", apiv1.Options{Type: "awspca", CertificateAuthority: testARN, CredentialsFile: "testdata/missing"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(context.Background(), tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewAWSPCA(t *testing.T) {
+	client := &MockClient{}
+	tests := []struct {
+		name        string
+		templateARN string
+		want        *AWSPCA
+	}{
+		{"default", "", &AWSPCA{client: client, arn: testARN, templateARN: defaultTemplateARN, signingAlgorithm: "SHA256WITHECDSA"}},
+		{"template", testPassthroughARN, &AWSPCA{client: client, arn: testARN, templateARN: testPassthroughARN, signingAlgorithm: "SHA256WITHECDSA"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewAWSPCA(client, testARN, tt.templateARN, "SHA256WITHECDSA"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewAWSPCA() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAWSPCA_CreateCertificate(t *testing.T) {
+	defer func(d time.Duration) { pollInterval = d }(pollInterval)
+	pollInterval = time.Millisecond
+
+	key := mustKey(t)
+	csr := mustCSR(t, "test.smallstep.com", key)
+	tmpl := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "test.smallstep.com", Organization: []string{"Smallstep"}},
+		DNSNames:    []string{"test.smallstep.com"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		ExtraExtensions: []pkix.Extension{
+			{Id: []int{1, 3, 6, 1, 4, 1, 37476, 9000, 64, 1}, Value: []byte{0x30, 0x00}},
+			{Id: []int{2, 5, 29, 17}, Value: []byte{0x30, 0x00}},
+		},
+		NotBefore: time.Unix(1600000000, 0),
+		NotAfter:  time.Unix(1600003600, 0),
+	}
+
+	tests := []struct {
+		name            string
+		templateARN     string
+		pending         int
+		req             *apiv1.CreateCertificateRequest
+		wantPassthrough *APIPassthrough
+		wantErr         bool
+	}{
+		{"ok", "", 0, &apiv1.CreateCertificateRequest{Template: tmpl, CSR: csr, Lifetime: time.Hour}, nil, false},
+		{"ok in progress", "", 2, &apiv1.CreateCertificateRequest{Template: tmpl, CSR: csr, Lifetime: time.Hour}, nil, false},
+		{"ok passthrough", testPassthroughARN, 0, &apiv1.CreateCertificateRequest{Template: tmpl, CSR: csr, Lifetime: time.Hour}, &APIPassthrough{
+			Subject: &ASN1Subject{CommonName: "test.smallstep.com", Organization: "Smallstep"},
+			Extensions: &Extensions{
+				SubjectAlternativeNames: []*GeneralName{{DNSName: "test.smallstep.com"}, {IPAddress: "127.0.0.1"}},
+				KeyUsage:                &KeyUsage{DigitalSignature: true, KeyEncipherment: true},
+				ExtendedKeyUsage:        []*ExtendedKeyUsage{{ExtendedKeyUsageType: "SERVER_AUTH"}, {ExtendedKeyUsageType: "CLIENT_AUTH"}},
+				CustomExtensions:        []*CustomExtension{{ObjectIdentifier: "1.3.6.1.4.1.37476.9000.64.1", Value: []byte{0x30, 0x00}}},
+			},
+		}, false},
+		{"fail template", "", 0, &apiv1.CreateCertificateRequest{CSR: csr, Lifetime: time.Hour}, nil, true},
+		{"fail csr", "", 0, &apiv1.CreateCertificateRequest{Template: tmpl, Lifetime: time.Hour}, nil, true},
+		{"fail lifetime", "", 0, &apiv1.CreateCertificateRequest{Template: tmpl, CSR: csr}, nil, true},
+		{"fail issue", "", 0, &apiv1.CreateCertificateRequest{Template: tmpl, CSR: mustCSR(t, "fail", key), Lifetime: time.Hour}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestPCA(t)
+			m.pending = tt.pending
+			c := NewAWSPCA(m.client(), testARN, tt.templateARN, "SHA256WITHECDSA")
+			got, err := c.CreateCertificate(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("AWSPCA.CreateCertificate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Certificate.Subject.CommonName != "test.smallstep.com" {
+				t.Errorf("AWSPCA.CreateCertificate() common name = %s", got.Certificate.Subject.CommonName)
+			}
+			if !reflect.DeepEqual(got.CertificateChain, []*x509.Certificate{m.intermediate}) {
+				t.Errorf("AWSPCA.CreateCertificate() chain = %v, want the intermediate", got.CertificateChain)
+			}
+
+			in := m.input
+			if in.CertificateAuthorityArn != testARN || in.SigningAlgorithm != "SHA256WITHECDSA" || in.IdempotencyToken == "" {
+				t.Errorf("AWSPCA.CreateCertificate() input = %v", in)
+			}
+			if !reflect.DeepEqual(in.Validity, &Validity{Type: "ABSOLUTE", Value: 1600003600}) ||
+				!reflect.DeepEqual(in.ValidityNotBefore, &Validity{Type: "ABSOLUTE", Value: 1600000000}) {
+				t.Errorf("AWSPCA.CreateCertificate() validity = %v, %v", in.Validity, in.ValidityNotBefore)
+			}
+			if !reflect.DeepEqual(in.APIPassthrough, tt.wantPassthrough) {
+				b, _ := json.Marshal(in.APIPassthrough)
+				t.Errorf("AWSPCA.CreateCertificate() passthrough = %s", b)
+			}
+		})
+	}
+}
+
+func TestAWSPCA_RenewCertificate(t *testing.T) {
+	c := NewAWSPCA(&MockClient{}, testARN, "", "SHA256WITHECDSA")
+	_, err := c.RenewCertificate(&apiv1.RenewCertificateRequest{Template: &x509.Certificate{}, Lifetime: time.Hour})
+	if _, ok := err.(apiv1.ErrNotImplemented); !ok {
+		t.Errorf("AWSPCA.RenewCertificate() error = %v, want ErrNotImplemented", err)
+	}
+}
+
+func TestAWSPCA_RevokeCertificate(t *testing.T) {
+	m := newTestPCA(t)
+	c := NewAWSPCA(m.client(), testARN, "", "SHA256WITHECDSA")
+	key := mustKey(t)
+	crt := mustCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(0x1a2b3c),
+		Subject:      pkix.Name{CommonName: "test"},
+	}, m.intermediate, key.Public(), m.key)
+
+	tests := []struct {
+		name    string
+		req     *apiv1.RevokeCertificateRequest
+		want    *RevokeCertificateInput
+		wantErr bool
+	}{
+		{"ok certificate", &apiv1.RevokeCertificateRequest{Certificate: crt, ReasonCode: 1}, &RevokeCertificateInput{
+			CertificateAuthorityArn: testARN, CertificateSerial: "1a:2b:3c", RevocationReason: "KEY_COMPROMISE",
+		}, false},
+		{"ok serial number", &apiv1.RevokeCertificateRequest{SerialNumber: "1715004", ReasonCode: 10}, &RevokeCertificateInput{
+			CertificateAuthorityArn: testARN, CertificateSerial: "1a:2b:3c", RevocationReason: "A_A_COMPROMISE",
+		}, false},
+		{"fail reason", &apiv1.RevokeCertificateRequest{Certificate: crt, ReasonCode: 6}, nil, true},
+		{"fail serial number", &apiv1.RevokeCertificateRequest{SerialNumber: "0x1a"}, nil, true},
+		{"fail empty", &apiv1.RevokeCertificateRequest{}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m.revoked = nil
+			_, err := c.RevokeCertificate(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("AWSPCA.RevokeCertificate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(m.revoked, tt.want) {
+				t.Errorf("AWSPCA.RevokeCertificate() input = %v, want %v", m.revoked, tt.want)
+			}
+		})
+	}
+}
+
+func Test_jsonClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") ||
+			r.Header.Get("Content-Type") != "application/x-amz-json-1.1" {
+			http.Error(w, `{"__type":"AccessDeniedException","message":"denied"}`, http.StatusForbidden)
+			return
+		}
+		var in map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in["CertificateAuthorityArn"] != testARN {
+			http.Error(w, `{"__type":"ValidationException","message":"bad request"}`, http.StatusBadRequest)
+			return
+		}
+		switch r.Header.Get("X-Amz-Target") {
+		case "ACMPrivateCA.DescribeCertificateAuthority":
+			fmt.Fprint(w, `{"CertificateAuthority":{"CertificateAuthorityConfiguration":{"SigningAlgorithm":"SHA384WITHECDSA"}}}`)
+		case "ACMPrivateCA.IssueCertificate":
+			fmt.Fprintf(w, `{"CertificateArn":%q}`, testARN+"/certificate/foo")
+		case "ACMPrivateCA.GetCertificate":
+			http.Error(w, `{"__type":"RequestInProgressException","message":"in progress"}`, http.StatusBadRequest)
+		case "ACMPrivateCA.RevokeCertificate":
+			w.WriteHeader(http.StatusOK)
+		default:
+			http.Error(w, "", http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c := &jsonClient{
+		region: "us-east-1",
+		client: &awsutil.Client{
+			Service:     serviceName,
+			Target:      "ACMPrivateCA",
+			Credentials: credentials.NewStaticCredentials("AKIDEXAMPLE", "secret", ""),
+			Endpoint:    srv.URL + "/",
+			HTTPClient:  srv.Client(),
+		},
+	}
+	ctx := context.Background()
+
+	alg, err := c.DescribeCertificateAuthority(ctx, testARN)
+	if err != nil || alg != "SHA384WITHECDSA" {
+		t.Errorf("jsonClient.DescribeCertificateAuthority() = %s, %v", alg, err)
+	}
+	arn, err := c.IssueCertificate(ctx, &IssueCertificateInput{CertificateAuthorityArn: testARN})
+	if err != nil || arn != testARN+"/certificate/foo" {
+		t.Errorf("jsonClient.IssueCertificate() = %s, %v", arn, err)
+	}
+	_, err = c.GetCertificate(ctx, testARN, arn)
+	if e, ok := errors.Cause(err).(*Error); !ok || e.Type != "RequestInProgressException" {
+		t.Errorf("jsonClient.GetCertificate() error = %v", err)
+	}
+	if err := c.RevokeCertificate(ctx, &RevokeCertificateInput{CertificateAuthorityArn: testARN}); err != nil {
+		t.Errorf("jsonClient.RevokeCertificate() error = %v", err)
+	}
+	if err := c.RevokeCertificate(ctx, &RevokeCertificateInput{}); err == nil || err.Error() != "ValidationException: bad request" {
+		t.Errorf("jsonClient.RevokeCertificate() error = %v", err)
+	}
+}
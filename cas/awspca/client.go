@@ -0,0 +1,177 @@
+package awspca
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/internal/awsutil"
+)
+
+const serviceName = "acm-pca"
+
+// Validity is the validity of a certificate, with the ABSOLUTE type the value
+// is a unix timestamp.
+type Validity struct {
+	Type  string `json:"Type"`
+	Value int64  `json:"Value"`
+}
+
+// IssueCertificateInput is the input of the IssueCertificate action.
+type IssueCertificateInput struct {
+	CertificateAuthorityArn string          `json:"CertificateAuthorityArn"`
+	Csr                     []byte          `json:"Csr"`
+	SigningAlgorithm        string          `json:"SigningAlgorithm"`
+	TemplateArn             string          `json:"TemplateArn,omitempty"`
+	Validity                *Validity       `json:"Validity"`
+	ValidityNotBefore       *Validity       `json:"ValidityNotBefore,omitempty"`
+	IdempotencyToken        string          `json:"IdempotencyToken,omitempty"`
+	APIPassthrough          *APIPassthrough `json:"ApiPassthrough,omitempty"`
+}
+
+// APIPassthrough contains the subject and extensions used by the
+// APIPassthrough templates.
+type APIPassthrough struct {
+	Subject    *ASN1Subject `json:"Subject,omitempty"`
+	Extensions *Extensions  `json:"Extensions,omitempty"`
+}
+
+// ASN1Subject is the subject of a certificate.
+type ASN1Subject struct {
+	CommonName         string `json:"CommonName,omitempty"`
+	Country            string `json:"Country,omitempty"`
+	Organization       string `json:"Organization,omitempty"`
+	OrganizationalUnit string `json:"OrganizationalUnit,omitempty"`
+	Locality           string `json:"Locality,omitempty"`
+	State              string `json:"State,omitempty"`
+	SerialNumber       string `json:"SerialNumber,omitempty"`
+}
+
+// Extensions are the extensions of a certificate.
+type Extensions struct {
+	SubjectAlternativeNames []*GeneralName      `json:"SubjectAlternativeNames,omitempty"`
+	KeyUsage                *KeyUsage           `json:"KeyUsage,omitempty"`
+	ExtendedKeyUsage        []*ExtendedKeyUsage `json:"ExtendedKeyUsage,omitempty"`
+	CustomExtensions        []*CustomExtension  `json:"CustomExtensions,omitempty"`
+}
+
+// GeneralName is a subject alternative name, only one field must be set.
+type GeneralName struct {
+	DNSName                   string `json:"DnsName,omitempty"`
+	IPAddress                 string `json:"IpAddress,omitempty"`
+	Rfc822Name                string `json:"Rfc822Name,omitempty"`
+	UniformResourceIdentifier string `json:"UniformResourceIdentifier,omitempty"`
+}
+
+// KeyUsage represents the key usage extension.
+type KeyUsage struct {
+	DigitalSignature bool `json:"DigitalSignature,omitempty"`
+	NonRepudiation   bool `json:"NonRepudiation,omitempty"`
+	KeyEncipherment  bool `json:"KeyEncipherment,omitempty"`
+	DataEncipherment bool `json:"DataEncipherment,omitempty"`
+	KeyAgreement     bool `json:"KeyAgreement,omitempty"`
+	KeyCertSign      bool `json:"KeyCertSign,omitempty"`
+	CRLSign          bool `json:"CRLSign,omitempty"`
+	EncipherOnly     bool `json:"EncipherOnly,omitempty"`
+	DecipherOnly     bool `json:"DecipherOnly,omitempty"`
+}
+
+// ExtendedKeyUsage is an extended key usage, by type or object identifier.
+type ExtendedKeyUsage struct {
+	ExtendedKeyUsageType             string `json:"ExtendedKeyUsageType,omitempty"`
+	ExtendedKeyUsageObjectIdentifier string `json:"ExtendedKeyUsageObjectIdentifier,omitempty"`
+}
+
+// CustomExtension is an additional extension, the value is the DER encoded
+// value of the extension.
+type CustomExtension struct {
+	ObjectIdentifier string `json:"ObjectIdentifier"`
+	Value            []byte `json:"Value"`
+	Critical         bool   `json:"Critical,omitempty"`
+}
+
+// GetCertificateOutput is the output of the GetCertificate action.
+type GetCertificateOutput struct {
+	Certificate      string `json:"Certificate"`
+	CertificateChain string `json:"CertificateChain"`
+}
+
+// RevokeCertificateInput is the input of the RevokeCertificate action.
+type RevokeCertificateInput struct {
+	CertificateAuthorityArn string `json:"CertificateAuthorityArn"`
+	CertificateSerial       string `json:"CertificateSerial"`
+	RevocationReason        string `json:"RevocationReason"`
+}
+
+// Error is an error returned by the AWS Private CA API.
+type Error = awsutil.Error
+
+// PrivateCAClient defines the methods of the AWS Private CA API that AWSPCA
+// uses. This interface will be used for unit testing.
+type PrivateCAClient interface {
+	DescribeCertificateAuthority(ctx context.Context, arn string) (signingAlgorithm string, err error)
+	IssueCertificate(ctx context.Context, in *IssueCertificateInput) (certificateArn string, err error)
+	GetCertificate(ctx context.Context, caArn, certificateArn string) (*GetCertificateOutput, error)
+	RevokeCertificate(ctx context.Context, in *RevokeCertificateInput) error
+}
+
+// jsonClient implements PrivateCAClient using the JSON API.
+type jsonClient struct {
+	region string
+	client *awsutil.Client
+}
+
+// DescribeCertificateAuthority returns the signing algorithm of the
+// certificate authority.
+func (c *jsonClient) DescribeCertificateAuthority(ctx context.Context, arn string) (string, error) {
+	var out struct {
+		CertificateAuthority struct {
+			CertificateAuthorityConfiguration struct {
+				SigningAlgorithm string `json:"SigningAlgorithm"`
+			} `json:"CertificateAuthorityConfiguration"`
+		} `json:"CertificateAuthority"`
+	}
+	if err := c.do(ctx, "DescribeCertificateAuthority", map[string]string{
+		"CertificateAuthorityArn": arn,
+	}, &out); err != nil {
+		return "", err
+	}
+	return out.CertificateAuthority.CertificateAuthorityConfiguration.SigningAlgorithm, nil
+}
+
+// IssueCertificate requests a new certificate and returns its arn.
+func (c *jsonClient) IssueCertificate(ctx context.Context, in *IssueCertificateInput) (string, error) {
+	var out struct {
+		CertificateArn string `json:"CertificateArn"`
+	}
+	if err := c.do(ctx, "IssueCertificate", in, &out); err != nil {
+		return "", err
+	}
+	return out.CertificateArn, nil
+}
+
+// GetCertificate returns an issued certificate and its chain.
+func (c *jsonClient) GetCertificate(ctx context.Context, caArn, certificateArn string) (*GetCertificateOutput, error) {
+	var out GetCertificateOutput
+	if err := c.do(ctx, "GetCertificate", map[string]string{
+		"CertificateAuthorityArn": caArn,
+		"CertificateArn":          certificateArn,
+	}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// RevokeCertificate revokes a certificate.
+func (c *jsonClient) RevokeCertificate(ctx context.Context, in *RevokeCertificateInput) error {
+	return c.do(ctx, "RevokeCertificate", in, nil)
+}
+
+func (c *jsonClient) do(ctx context.Context, action string, in, out interface{}) error {
+	if err := c.client.Do(ctx, c.region, action, in, out); err != nil {
+		if _, ok := err.(*Error); ok {
+			return err
+		}
+		return errors.Wrapf(err, "error requesting %s", action)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package awspca
+
+import (
+	"context"
+)
+
+type MockClient struct {
+	describeCertificateAuthority func(ctx context.Context, arn string) (string, error)
+	issueCertificate             func(ctx context.Context, in *IssueCertificateInput) (string, error)
+	getCertificate               func(ctx context.Context, caArn, certificateArn string) (*GetCertificateOutput, error)
+	revokeCertificate            func(ctx context.Context, in *RevokeCertificateInput) error
+}
+
+func (m *MockClient) DescribeCertificateAuthority(ctx context.Context, arn string) (string, error) {
+	return m.describeCertificateAuthority(ctx, arn)
+}
+
+func (m *MockClient) IssueCertificate(ctx context.Context, in *IssueCertificateInput) (string, error) {
+	return m.issueCertificate(ctx, in)
+}
+
+func (m *MockClient) GetCertificate(ctx context.Context, caArn, certificateArn string) (*GetCertificateOutput, error) {
+	return m.getCertificate(ctx, caArn, certificateArn)
+}
+
+func (m *MockClient) RevokeCertificate(ctx context.Context, in *RevokeCertificateInput) error {
+	return m.revokeCertificate(ctx, in)
+}
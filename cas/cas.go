@@ -0,0 +1,52 @@
+package cas
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/cas/apiv1"
+	"github.com/smallstep/certificates/cas/awspca"
+	"github.com/smallstep/certificates/cas/cloudcas"
+	"github.com/smallstep/certificates/cas/stepcas"
+)
+
+// CertificateAuthorityService is the interface implemented by the services
+// that sign X.509 certificates on behalf of the authority.
+type CertificateAuthorityService interface {
+	CreateCertificate(req *apiv1.CreateCertificateRequest) (*apiv1.CreateCertificateResponse, error)
+	RenewCertificate(req *apiv1.RenewCertificateRequest) (*apiv1.RenewCertificateResponse, error)
+	RevokeCertificate(req *apiv1.RevokeCertificateRequest) (*apiv1.RevokeCertificateResponse, error)
+}
+
+// New initializes a new CAS from the given type. The default and softcas
+// types sign the certificates in the authority and do not require a
+// CertificateAuthorityService.
+func New(ctx context.Context, opts apiv1.Options) (CertificateAuthorityService, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch apiv1.Type(strings.ToLower(opts.Type)) {
+	case apiv1.StepCAS:
+		s, err := stepcas.New(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case apiv1.CloudCAS:
+		c, err := cloudcas.New(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	case apiv1.AWSPCA:
+		c, err := awspca.New(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	default:
+		return nil, errors.Errorf("unsupported cas type '%s'", opts.Type)
+	}
+}
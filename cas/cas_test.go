@@ -0,0 +1,43 @@
+package cas
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/smallstep/certificates/cas/apiv1"
+)
+
+func TestNew(t *testing.T) {
+	ctx := context.Background()
+
+	type args struct {
+		ctx  context.Context
+		opts apiv1.Options
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    CertificateAuthorityService
+		wantErr bool
+	}{
+		{"default", args{ctx, apiv1.Options{}}, nil, true},                  // signed by the authority
+		{"softcas", args{ctx, apiv1.Options{Type: "softcas"}}, nil, true},   // signed by the authority
+		{"stepcas", args{ctx, apiv1.Options{Type: "stepcas"}}, nil, true},   // fails validation
+		{"cloudcas", args{ctx, apiv1.Options{Type: "cloudcas"}}, nil, true}, // fails validation
+		{"awspca", args{ctx, apiv1.Options{Type: "awspca"}}, nil, true},     // fails validation
+		{"fail validation", args{ctx, apiv1.Options{Type: "foobar"}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(tt.args.ctx, tt.args.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if reflect.TypeOf(got) != reflect.TypeOf(tt.want) {
+				t.Errorf("New() = %T, want %T", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package cloudcas
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+)
+
+const (
+	defaultEndpoint    = "https://privateca.googleapis.com/v1/"
+	cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+)
+
+// Certificate is the certificate resource of the Certificate Authority
+// Service API. Only the fields used by CloudCAS are defined.
+type Certificate struct {
+	Name                string             `json:"name,omitempty"`
+	Config              *CertificateConfig `json:"config,omitempty"`
+	Lifetime            string             `json:"lifetime,omitempty"`
+	PemCertificate      string             `json:"pemCertificate,omitempty"`
+	PemCertificateChain []string           `json:"pemCertificateChain,omitempty"`
+}
+
+// CertificateConfig contains the attributes of a certificate.
+type CertificateConfig struct {
+	SubjectConfig *SubjectConfig  `json:"subjectConfig"`
+	X509Config    *X509Parameters `json:"x509Config"`
+	PublicKey     *PublicKey      `json:"publicKey"`
+}
+
+// SubjectConfig contains the subject and subject alternative names of a
+// certificate.
+type SubjectConfig struct {
+	Subject        *Subject         `json:"subject"`
+	SubjectAltName *SubjectAltNames `json:"subjectAltName,omitempty"`
+}
+
+// Subject contains the attributes of the subject of a certificate.
+type Subject struct {
+	CommonName         string `json:"commonName,omitempty"`
+	CountryCode        string `json:"countryCode,omitempty"`
+	Organization       string `json:"organization,omitempty"`
+	OrganizationalUnit string `json:"organizationalUnit,omitempty"`
+	Locality           string `json:"locality,omitempty"`
+	Province           string `json:"province,omitempty"`
+	StreetAddress      string `json:"streetAddress,omitempty"`
+	PostalCode         string `json:"postalCode,omitempty"`
+}
+
+// SubjectAltNames contains the subject alternative names of a certificate.
+type SubjectAltNames struct {
+	DNSNames       []string `json:"dnsNames,omitempty"`
+	URIs           []string `json:"uris,omitempty"`
+	EmailAddresses []string `json:"emailAddresses,omitempty"`
+	IPAddresses    []string `json:"ipAddresses,omitempty"`
+}
+
+// X509Parameters contains the X.509 extensions of a certificate.
+type X509Parameters struct {
+	KeyUsage             *KeyUsage        `json:"keyUsage,omitempty"`
+	CAOptions            *CAOptions       `json:"caOptions,omitempty"`
+	AdditionalExtensions []*X509Extension `json:"additionalExtensions,omitempty"`
+}
+
+// KeyUsage contains the key usage and extended key usage of a certificate.
+type KeyUsage struct {
+	BaseKeyUsage             *BaseKeyUsage     `json:"baseKeyUsage,omitempty"`
+	ExtendedKeyUsage         *ExtendedKeyUsage `json:"extendedKeyUsage,omitempty"`
+	UnknownExtendedKeyUsages []*ObjectID       `json:"unknownExtendedKeyUsages,omitempty"`
+}
+
+// BaseKeyUsage represents the key usage extension.
+type BaseKeyUsage struct {
+	DigitalSignature  bool `json:"digitalSignature,omitempty"`
+	ContentCommitment bool `json:"contentCommitment,omitempty"`
+	KeyEncipherment   bool `json:"keyEncipherment,omitempty"`
+	DataEncipherment  bool `json:"dataEncipherment,omitempty"`
+	KeyAgreement      bool `json:"keyAgreement,omitempty"`
+	CertSign          bool `json:"certSign,omitempty"`
+	CRLSign           bool `json:"crlSign,omitempty"`
+	EncipherOnly      bool `json:"encipherOnly,omitempty"`
+	DecipherOnly      bool `json:"decipherOnly,omitempty"`
+}
+
+// ExtendedKeyUsage represents the common extended key usages.
+type ExtendedKeyUsage struct {
+	ServerAuth      bool `json:"serverAuth,omitempty"`
+	ClientAuth      bool `json:"clientAuth,omitempty"`
+	CodeSigning     bool `json:"codeSigning,omitempty"`
+	EmailProtection bool `json:"emailProtection,omitempty"`
+	TimeStamping    bool `json:"timeStamping,omitempty"`
+	OCSPSigning     bool `json:"ocspSigning,omitempty"`
+}
+
+// CAOptions represents the basic constraints extension.
+type CAOptions struct {
+	IsCA bool `json:"isCa"`
+}
+
+// ObjectID represents an ASN.1 object identifier.
+type ObjectID struct {
+	ObjectIDPath []int `json:"objectIdPath"`
+}
+
+// X509Extension represents an additional X.509 extension, the value is the
+// DER encoded value of the extension.
+type X509Extension struct {
+	ObjectID *ObjectID `json:"objectId"`
+	Critical bool      `json:"critical,omitempty"`
+	Value    []byte    `json:"value"`
+}
+
+// PublicKey is the public key of a certificate.
+type PublicKey struct {
+	Key    []byte `json:"key"`
+	Format string `json:"format"`
+}
+
+// RevokeCertificateRequest is the body of a revoke request.
+type RevokeCertificateRequest struct {
+	Reason    string `json:"reason"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// CertificateAuthorityClient defines the methods of the Certificate
+// Authority Service API that CloudCAS uses. This interface will be used for
+// unit testing.
+type CertificateAuthorityClient interface {
+	CreateCertificate(ctx context.Context, parent, certificateID, issuingCertificateAuthorityID string, crt *Certificate) (*Certificate, error)
+	RevokeCertificate(ctx context.Context, name string, req *RevokeCertificateRequest) (*Certificate, error)
+}
+
+// restClient implements CertificateAuthorityClient using the REST API.
+type restClient struct {
+	endpoint string
+	client   *http.Client
+}
+
+func newRESTClient(ctx context.Context, opts ...option.ClientOption) (*restClient, error) {
+	opts = append([]option.ClientOption{option.WithScopes(cloudPlatformScope)}, opts...)
+	client, endpoint, err := htransport.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating certificate authority service client")
+	}
+	if endpoint == "" {
+		endpoint = defaultEndpoint
+	}
+	return &restClient{
+		endpoint: endpoint,
+		client:   client,
+	}, nil
+}
+
+// CreateCertificate creates a new certificate in the given CA pool.
+func (c *restClient) CreateCertificate(ctx context.Context, parent, certificateID, issuingCertificateAuthorityID string, crt *Certificate) (*Certificate, error) {
+	q := url.Values{}
+	q.Set("certificateId", certificateID)
+	if issuingCertificateAuthorityID != "" {
+		q.Set("issuingCertificateAuthorityId", issuingCertificateAuthorityID)
+	}
+	var res Certificate
+	if err := c.do(ctx, parent+"/certificates?"+q.Encode(), crt, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// RevokeCertificate revokes the certificate with the given name.
+func (c *restClient) RevokeCertificate(ctx context.Context, name string, req *RevokeCertificateRequest) (*Certificate, error) {
+	var res Certificate
+	if err := c.do(ctx, name+":revoke", req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (c *restClient) do(ctx context.Context, path string, body, v interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling request")
+	}
+	req, err := http.NewRequest("POST", c.endpoint+path, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrap(err, "error creating request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "error requesting %s", c.endpoint+path)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		if err := json.Unmarshal(b, &e); err == nil && e.Error.Message != "" {
+			return errors.Errorf("error requesting %s: status code %d: %s", c.endpoint+path, resp.StatusCode, e.Error.Message)
+		}
+		return errors.Errorf("error requesting %s: status code %d", c.endpoint+path, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return errors.Wrapf(err, "error decoding response from %s", c.endpoint+path)
+	}
+	return nil
+}
@@ -0,0 +1,380 @@
+package cloudcas
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/cas/apiv1"
+	"github.com/smallstep/cli/crypto/randutil"
+	"google.golang.org/api/option"
+)
+
+// oidStepCertificateAuthority is the OID of the extension that contains the
+// name of the certificate in the Certificate Authority Service.
+var oidStepCertificateAuthority = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 37476, 9000, 64, 3}
+
+// stepCertificateAuthority is the value of the oidStepCertificateAuthority
+// extension.
+type stepCertificateAuthority struct {
+	Type          string
+	CertificateID string
+}
+
+// Extensions managed by the Certificate Authority Service that are not sent
+// as additional extensions.
+var managedExtensions = []asn1.ObjectIdentifier{
+	{2, 5, 29, 14}, // subject key identifier
+	{2, 5, 29, 15}, // key usage
+	{2, 5, 29, 17}, // subject alternative name
+	{2, 5, 29, 19}, // basic constraints
+	{2, 5, 29, 35}, // authority key identifier
+	{2, 5, 29, 37}, // extended key usage
+}
+
+// revocationReasons maps RFC 5280 reason codes to the revocation reasons of
+// the Certificate Authority Service.
+var revocationReasons = map[int]string{
+	0:  "REVOCATION_REASON_UNSPECIFIED",
+	1:  "KEY_COMPROMISE",
+	2:  "CERTIFICATE_AUTHORITY_COMPROMISE",
+	3:  "AFFILIATION_CHANGED",
+	4:  "SUPERSEDED",
+	5:  "CESSATION_OF_OPERATION",
+	6:  "CERTIFICATE_HOLD",
+	9:  "PRIVILEGE_WITHDRAWN",
+	10: "ATTRIBUTE_AUTHORITY_COMPROMISE",
+}
+
+// CloudCAS implements a CertificateAuthorityService using Google's Certificate
+// Authority Service. The signing keys never leave Google Cloud, the authority
+// keeps validating and authorizing the requests with its provisioners.
+type CloudCAS struct {
+	client                        CertificateAuthorityClient
+	caPool                        string
+	issuingCertificateAuthorityID string
+}
+
+// New creates a new CloudCAS configured with a new client.
+func New(ctx context.Context, opts apiv1.Options) (*CloudCAS, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	var cloudOpts []option.ClientOption
+	if opts.CredentialsFile != "" {
+		cloudOpts = append(cloudOpts, option.WithCredentialsFile(opts.CredentialsFile))
+	}
+	client, err := newRESTClient(ctx, cloudOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCloudCAS(client, opts.CertificateAuthority), nil
+}
+
+// NewCloudCAS creates a CloudCAS with a given client. The name can be a CA
+// pool or a certificate authority in a CA pool.
+func NewCloudCAS(client CertificateAuthorityClient, name string) *CloudCAS {
+	c := &CloudCAS{
+		client: client,
+		caPool: name,
+	}
+	if i := strings.Index(name, "/certificateAuthorities/"); i > 0 {
+		c.caPool = name[:i]
+		c.issuingCertificateAuthorityID = name[i+len("/certificateAuthorities/"):]
+	}
+	return c
+}
+
+// CreateCertificate signs a new certificate using Google's Certificate
+// Authority Service.
+func (c *CloudCAS) CreateCertificate(req *apiv1.CreateCertificateRequest) (*apiv1.CreateCertificateResponse, error) {
+	switch {
+	case req.Template == nil:
+		return nil, errors.New("createCertificateRequest `template` cannot be nil")
+	case req.Lifetime == 0:
+		return nil, errors.New("createCertificateRequest `lifetime` cannot be 0")
+	}
+
+	tmpl := req.Template
+	if tmpl.PublicKey == nil && req.CSR != nil {
+		tmpl.PublicKey = req.CSR.PublicKey
+	}
+	crt, chain, err := c.createCertificate(tmpl, req.Lifetime)
+	if err != nil {
+		return nil, err
+	}
+	return &apiv1.CreateCertificateResponse{
+		Certificate:      crt,
+		CertificateChain: chain,
+	}, nil
+}
+
+// RenewCertificate renews the given certificate using Google's Certificate
+// Authority Service. The Certificate Authority Service does not have a renew
+// method, a new certificate with the same attributes is created.
+func (c *CloudCAS) RenewCertificate(req *apiv1.RenewCertificateRequest) (*apiv1.RenewCertificateResponse, error) {
+	switch {
+	case req.Template == nil:
+		return nil, errors.New("renewCertificateRequest `template` cannot be nil")
+	case req.Lifetime == 0:
+		return nil, errors.New("renewCertificateRequest `lifetime` cannot be 0")
+	}
+
+	crt, chain, err := c.createCertificate(req.Template, req.Lifetime)
+	if err != nil {
+		return nil, err
+	}
+	return &apiv1.RenewCertificateResponse{
+		Certificate:      crt,
+		CertificateChain: chain,
+	}, nil
+}
+
+// RevokeCertificate revokes a certificate using Google's Certificate Authority
+// Service. The name of the certificate is read from the certificate.
+func (c *CloudCAS) RevokeCertificate(req *apiv1.RevokeCertificateRequest) (*apiv1.RevokeCertificateResponse, error) {
+	reason, ok := revocationReasons[req.ReasonCode]
+	switch {
+	case !ok:
+		return nil, errors.Errorf("revokeCertificate 'reasonCode=%d' is invalid or not supported", req.ReasonCode)
+	case req.Certificate == nil:
+		return nil, errors.New("revokeCertificateRequest `certificate` cannot be nil")
+	}
+
+	name, err := certificateName(req.Certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	requestID, err := randutil.Hex(32)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.client.RevokeCertificate(ctx, name, &RevokeCertificateRequest{
+		Reason:    reason,
+		RequestID: requestID,
+	}); err != nil {
+		return nil, errors.Wrap(err, "cloudCAS RevokeCertificate failed")
+	}
+
+	return &apiv1.RevokeCertificateResponse{
+		Certificate: req.Certificate,
+	}, nil
+}
+
+func (c *CloudCAS) createCertificate(tmpl *x509.Certificate, lifetime time.Duration) (*x509.Certificate, []*x509.Certificate, error) {
+	certificateID, err := randutil.Alphanumeric(32)
+	if err != nil {
+		return nil, nil, err
+	}
+	name := c.caPool + "/certificates/" + certificateID
+
+	config, err := createCertificateConfig(tmpl, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	cert, err := c.client.CreateCertificate(ctx, c.caPool, certificateID, c.issuingCertificateAuthorityID, &Certificate{
+		Config:   config,
+		Lifetime: fmt.Sprintf("%ds", int64(lifetime.Seconds())),
+	})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "cloudCAS CreateCertificate failed")
+	}
+
+	crt, err := parseCertificate(cert.PemCertificate)
+	if err != nil {
+		return nil, nil, err
+	}
+	var chain []*x509.Certificate
+	for _, s := range cert.PemCertificateChain {
+		c, err := parseCertificate(s)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Root certificates are not part of the chain.
+		if c.CheckSignatureFrom(c) == nil {
+			continue
+		}
+		chain = append(chain, c)
+	}
+	return crt, chain, nil
+}
+
+func createCertificateConfig(tmpl *x509.Certificate, name string) (*CertificateConfig, error) {
+	pub, err := x509.MarshalPKIXPublicKey(tmpl.PublicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling public key")
+	}
+	ext, err := asn1.Marshal(stepCertificateAuthority{
+		Type:          string(apiv1.CloudCAS),
+		CertificateID: name,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling extension")
+	}
+
+	config := &CertificateConfig{
+		SubjectConfig: &SubjectConfig{
+			Subject: createSubject(tmpl.Subject),
+		},
+		X509Config: &X509Parameters{
+			KeyUsage: createKeyUsage(tmpl),
+			AdditionalExtensions: []*X509Extension{{
+				ObjectID: createObjectID(oidStepCertificateAuthority),
+				Value:    ext,
+			}},
+		},
+		PublicKey: &PublicKey{
+			Key:    pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}),
+			Format: "PEM",
+		},
+	}
+	if tmpl.BasicConstraintsValid {
+		config.X509Config.CAOptions = &CAOptions{IsCA: tmpl.IsCA}
+	}
+
+	sans := &SubjectAltNames{
+		DNSNames:       tmpl.DNSNames,
+		EmailAddresses: tmpl.EmailAddresses,
+	}
+	for _, ip := range tmpl.IPAddresses {
+		sans.IPAddresses = append(sans.IPAddresses, ip.String())
+	}
+	for _, u := range tmpl.URIs {
+		sans.URIs = append(sans.URIs, u.String())
+	}
+	if len(sans.DNSNames)+len(sans.EmailAddresses)+len(sans.IPAddresses)+len(sans.URIs) > 0 {
+		config.SubjectConfig.SubjectAltName = sans
+	}
+
+	for _, e := range tmpl.ExtraExtensions {
+		if isManagedExtension(e.Id) || e.Id.Equal(oidStepCertificateAuthority) {
+			continue
+		}
+		config.X509Config.AdditionalExtensions = append(config.X509Config.AdditionalExtensions, &X509Extension{
+			ObjectID: createObjectID(e.Id),
+			Critical: e.Critical,
+			Value:    e.Value,
+		})
+	}
+
+	return config, nil
+}
+
+func createSubject(name pkix.Name) *Subject {
+	first := func(s []string) string {
+		if len(s) > 0 {
+			return s[0]
+		}
+		return ""
+	}
+	return &Subject{
+		CommonName:         name.CommonName,
+		CountryCode:        first(name.Country),
+		Organization:       first(name.Organization),
+		OrganizationalUnit: first(name.OrganizationalUnit),
+		Locality:           first(name.Locality),
+		Province:           first(name.Province),
+		StreetAddress:      first(name.StreetAddress),
+		PostalCode:         first(name.PostalCode),
+	}
+}
+
+func createKeyUsage(tmpl *x509.Certificate) *KeyUsage {
+	ku := &KeyUsage{
+		BaseKeyUsage: &BaseKeyUsage{
+			DigitalSignature:  tmpl.KeyUsage&x509.KeyUsageDigitalSignature != 0,
+			ContentCommitment: tmpl.KeyUsage&x509.KeyUsageContentCommitment != 0,
+			KeyEncipherment:   tmpl.KeyUsage&x509.KeyUsageKeyEncipherment != 0,
+			DataEncipherment:  tmpl.KeyUsage&x509.KeyUsageDataEncipherment != 0,
+			KeyAgreement:      tmpl.KeyUsage&x509.KeyUsageKeyAgreement != 0,
+			CertSign:          tmpl.KeyUsage&x509.KeyUsageCertSign != 0,
+			CRLSign:           tmpl.KeyUsage&x509.KeyUsageCRLSign != 0,
+			EncipherOnly:      tmpl.KeyUsage&x509.KeyUsageEncipherOnly != 0,
+			DecipherOnly:      tmpl.KeyUsage&x509.KeyUsageDecipherOnly != 0,
+		},
+		ExtendedKeyUsage: &ExtendedKeyUsage{},
+	}
+	for _, eku := range tmpl.ExtKeyUsage {
+		switch eku {
+		case x509.ExtKeyUsageServerAuth:
+			ku.ExtendedKeyUsage.ServerAuth = true
+		case x509.ExtKeyUsageClientAuth:
+			ku.ExtendedKeyUsage.ClientAuth = true
+		case x509.ExtKeyUsageCodeSigning:
+			ku.ExtendedKeyUsage.CodeSigning = true
+		case x509.ExtKeyUsageEmailProtection:
+			ku.ExtendedKeyUsage.EmailProtection = true
+		case x509.ExtKeyUsageTimeStamping:
+			ku.ExtendedKeyUsage.TimeStamping = true
+		case x509.ExtKeyUsageOCSPSigning:
+			ku.ExtendedKeyUsage.OCSPSigning = true
+		}
+	}
+	for _, oid := range tmpl.UnknownExtKeyUsage {
+		ku.UnknownExtendedKeyUsages = append(ku.UnknownExtendedKeyUsages, createObjectID(oid))
+	}
+	return ku
+}
+
+func createObjectID(oid asn1.ObjectIdentifier) *ObjectID {
+	return &ObjectID{ObjectIDPath: append([]int{}, oid...)}
+}
+
+func isManagedExtension(oid asn1.ObjectIdentifier) bool {
+	for _, id := range managedExtensions {
+		if id.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
+
+// certificateName returns the name of the certificate in the Certificate
+// Authority Service stored in the oidStepCertificateAuthority extension.
+func certificateName(crt *x509.Certificate) (string, error) {
+	for _, e := range crt.Extensions {
+		if e.Id.Equal(oidStepCertificateAuthority) {
+			var ext stepCertificateAuthority
+			if _, err := asn1.Unmarshal(e.Value, &ext); err != nil {
+				return "", errors.Wrap(err, "error unmarshaling step certificate authority extension")
+			}
+			if ext.Type != string(apiv1.CloudCAS) || ext.CertificateID == "" {
+				return "", errors.New("certificate was not issued by cloudCAS")
+			}
+			return ext.CertificateID, nil
+		}
+	}
+	return "", errors.New("certificate does not contain the step certificate authority extension")
+}
+
+func parseCertificate(s string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("error decoding certificate: invalid PEM")
+	}
+	crt, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing certificate")
+	}
+	return crt, nil
+}
+
+func defaultContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 15*time.Second)
+}
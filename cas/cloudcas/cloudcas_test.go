@@ -0,0 +1,381 @@
+package cloudcas
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/cas/apiv1"
+)
+
+const testCAPool = "projects/smallstep/locations/us-west1/caPools/pool"
+
+func mustKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func mustCertificate(t *testing.T, tmpl, parent *x509.Certificate, pub crypto.PublicKey, signer crypto.Signer) *x509.Certificate {
+	t.Helper()
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore = time.Now().Add(-time.Minute)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent = tmpl
+	}
+	b, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt, err := x509.ParseCertificate(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crt
+}
+
+func encodeCertificate(crt *x509.Certificate) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: crt.Raw}))
+}
+
+// testCAS signs the certificate configs like the Certificate Authority
+// Service would do.
+type testCAS struct {
+	t            *testing.T
+	root         *x509.Certificate
+	intermediate *x509.Certificate
+	key          crypto.Signer
+	parent       string
+	issuingCA    string
+	config       *CertificateConfig
+	revoked      map[string]string
+}
+
+func (m *testCAS) sign(crt *Certificate) (*Certificate, error) {
+	block, _ := pem.Decode(crt.Config.PublicKey.Key)
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: crt.Config.SubjectConfig.Subject.CommonName},
+		KeyUsage: x509.KeyUsageDigitalSignature,
+	}
+	if sans := crt.Config.SubjectConfig.SubjectAltName; sans != nil {
+		tmpl.DNSNames = sans.DNSNames
+	}
+	for _, e := range crt.Config.X509Config.AdditionalExtensions {
+		tmpl.ExtraExtensions = append(tmpl.ExtraExtensions, pkix.Extension{
+			Id:       asn1.ObjectIdentifier(e.ObjectID.ObjectIDPath),
+			Critical: e.Critical,
+			Value:    e.Value,
+		})
+	}
+	leaf := mustCertificate(m.t, tmpl, m.intermediate, pub, m.key)
+	return &Certificate{
+		PemCertificate:      encodeCertificate(leaf),
+		PemCertificateChain: []string{encodeCertificate(m.intermediate), encodeCertificate(m.root)},
+	}, nil
+}
+
+func (m *testCAS) client() *MockClient {
+	return &MockClient{
+		createCertificate: func(ctx context.Context, parent, certificateID, issuingCertificateAuthorityID string, crt *Certificate) (*Certificate, error) {
+			if crt.Config.SubjectConfig.Subject.CommonName == "fail" {
+				return nil, errors.New("force")
+			}
+			if crt.Lifetime != "3600s" {
+				return nil, errors.Errorf("unexpected lifetime %s", crt.Lifetime)
+			}
+			m.parent = parent
+			m.issuingCA = issuingCertificateAuthorityID
+			m.config = crt.Config
+			return m.sign(crt)
+		},
+		revokeCertificate: func(ctx context.Context, name string, req *RevokeCertificateRequest) (*Certificate, error) {
+			if m.revoked == nil {
+				m.revoked = make(map[string]string)
+			}
+			m.revoked[name] = req.Reason
+			return &Certificate{Name: name}, nil
+		},
+	}
+}
+
+func newTestCAS(t *testing.T) *testCAS {
+	rootKey := mustKey(t)
+	root := mustCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "CAS Root"},
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil, rootKey.Public(), rootKey)
+	key := mustKey(t)
+	intermediate := mustCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "CAS Intermediate"},
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, root, key.Public(), rootKey)
+	return &testCAS{t: t, root: root, intermediate: intermediate, key: key}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    apiv1.Options
+		wantErr bool
+	}{
+		{"fail validation", apiv1.Options{Type: "cloudcas"}, true},
+		{"fail credentials", apiv1.Options{Type: "cloudcas", CertificateAuthority: testCAPool, CredentialsFile: "testdata/missing.json"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(context.Background(), tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewCloudCAS(t *testing.T) {
+	client := &MockClient{}
+	tests := []struct {
+		name string
+		want *CloudCAS
+	}{
+		{testCAPool, &CloudCAS{client: client, caPool: testCAPool}},
+		{testCAPool + "/certificateAuthorities/ca", &CloudCAS{client: client, caPool: testCAPool, issuingCertificateAuthorityID: "ca"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewCloudCAS(client, tt.name); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewCloudCAS() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCloudCAS_CreateCertificate(t *testing.T) {
+	m := newTestCAS(t)
+	c := NewCloudCAS(m.client(), testCAPool+"/certificateAuthorities/ca")
+
+	key := mustKey(t)
+	provisionerExt := pkix.Extension{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 37476, 9000, 64, 1}, Value: []byte{0x30, 0x00}}
+	tmpl := &x509.Certificate{
+		Subject:         pkix.Name{CommonName: "test.smallstep.com", Organization: []string{"Smallstep"}},
+		DNSNames:        []string{"test.smallstep.com"},
+		IPAddresses:     []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:        x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		ExtraExtensions: []pkix.Extension{provisionerExt, {Id: asn1.ObjectIdentifier{2, 5, 29, 17}, Value: []byte{0x30, 0x00}}},
+	}
+	b, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: tmpl.Subject}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.ParseCertificateRequest(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		req     *apiv1.CreateCertificateRequest
+		wantErr bool
+	}{
+		{"ok", &apiv1.CreateCertificateRequest{Template: tmpl, CSR: csr, Lifetime: time.Hour}, false},
+		{"fail template", &apiv1.CreateCertificateRequest{CSR: csr, Lifetime: time.Hour}, true},
+		{"fail lifetime", &apiv1.CreateCertificateRequest{Template: tmpl, CSR: csr}, true},
+		{"fail create", &apiv1.CreateCertificateRequest{Template: &x509.Certificate{Subject: pkix.Name{CommonName: "fail"}}, CSR: csr, Lifetime: time.Hour}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.CreateCertificate(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CloudCAS.CreateCertificate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if m.parent != testCAPool || m.issuingCA != "ca" {
+				t.Errorf("CloudCAS.CreateCertificate() parent = %s, issuing CA = %s", m.parent, m.issuingCA)
+			}
+			if got.Certificate.Subject.CommonName != "test.smallstep.com" {
+				t.Errorf("CloudCAS.CreateCertificate() common name = %s", got.Certificate.Subject.CommonName)
+			}
+			if !reflect.DeepEqual(got.CertificateChain, []*x509.Certificate{m.intermediate}) {
+				t.Errorf("CloudCAS.CreateCertificate() chain = %v, want the intermediate", got.CertificateChain)
+			}
+
+			cfg := m.config
+			if cfg.SubjectConfig.Subject.Organization != "Smallstep" {
+				t.Errorf("CloudCAS.CreateCertificate() subject = %v", cfg.SubjectConfig.Subject)
+			}
+			if !reflect.DeepEqual(cfg.SubjectConfig.SubjectAltName, &SubjectAltNames{DNSNames: []string{"test.smallstep.com"}, IPAddresses: []string{"127.0.0.1"}}) {
+				t.Errorf("CloudCAS.CreateCertificate() sans = %v", cfg.SubjectConfig.SubjectAltName)
+			}
+			if !reflect.DeepEqual(cfg.X509Config.KeyUsage.BaseKeyUsage, &BaseKeyUsage{DigitalSignature: true, KeyEncipherment: true}) ||
+				!reflect.DeepEqual(cfg.X509Config.KeyUsage.ExtendedKeyUsage, &ExtendedKeyUsage{ServerAuth: true, ClientAuth: true}) {
+				t.Errorf("CloudCAS.CreateCertificate() key usage = %v", cfg.X509Config.KeyUsage)
+			}
+			// The certificate name and the provisioner extension are added,
+			// the subject alternative names are managed by the service.
+			if len(cfg.X509Config.AdditionalExtensions) != 2 ||
+				!reflect.DeepEqual(cfg.X509Config.AdditionalExtensions[1], &X509Extension{ObjectID: createObjectID(provisionerExt.Id), Value: provisionerExt.Value}) {
+				t.Errorf("CloudCAS.CreateCertificate() extensions = %v", cfg.X509Config.AdditionalExtensions)
+			}
+			name, err := certificateName(got.Certificate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(name, testCAPool+"/certificates/") {
+				t.Errorf("CloudCAS.CreateCertificate() certificate name = %s", name)
+			}
+		})
+	}
+}
+
+func TestCloudCAS_RenewCertificate(t *testing.T) {
+	m := newTestCAS(t)
+	c := NewCloudCAS(m.client(), testCAPool)
+	key := mustKey(t)
+
+	tests := []struct {
+		name    string
+		req     *apiv1.RenewCertificateRequest
+		wantErr bool
+	}{
+		{"ok", &apiv1.RenewCertificateRequest{Template: &x509.Certificate{Subject: pkix.Name{CommonName: "test"}, PublicKey: key.Public()}, Lifetime: time.Hour}, false},
+		{"fail template", &apiv1.RenewCertificateRequest{Lifetime: time.Hour}, true},
+		{"fail lifetime", &apiv1.RenewCertificateRequest{Template: &x509.Certificate{PublicKey: key.Public()}}, true},
+		{"fail public key", &apiv1.RenewCertificateRequest{Template: &x509.Certificate{}, Lifetime: time.Hour}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.RenewCertificate(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CloudCAS.RenewCertificate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got.Certificate.PublicKey, key.Public()) {
+				t.Errorf("CloudCAS.RenewCertificate() public key = %v", got.Certificate.PublicKey)
+			}
+		})
+	}
+}
+
+func TestCloudCAS_RevokeCertificate(t *testing.T) {
+	m := newTestCAS(t)
+	c := NewCloudCAS(m.client(), testCAPool)
+	key := mustKey(t)
+
+	resp, err := c.CreateCertificate(&apiv1.CreateCertificateRequest{
+		Template: &x509.Certificate{Subject: pkix.Name{CommonName: "test"}, PublicKey: key.Public()},
+		Lifetime: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := certificateName(resp.Certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := mustCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "other"}}, m.intermediate, key.Public(), m.key)
+
+	tests := []struct {
+		name    string
+		req     *apiv1.RevokeCertificateRequest
+		wantErr bool
+	}{
+		{"ok", &apiv1.RevokeCertificateRequest{Certificate: resp.Certificate, ReasonCode: 1}, false},
+		{"fail reason", &apiv1.RevokeCertificateRequest{Certificate: resp.Certificate, ReasonCode: 8}, true},
+		{"fail certificate", &apiv1.RevokeCertificateRequest{SerialNumber: "1234"}, true},
+		{"fail extension", &apiv1.RevokeCertificateRequest{Certificate: other}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := c.RevokeCertificate(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CloudCAS.RevokeCertificate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && m.revoked[name] != "KEY_COMPROMISE" {
+				t.Errorf("CloudCAS.RevokeCertificate() revoked = %v", m.revoked)
+			}
+		})
+	}
+}
+
+func Test_restClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method != "POST":
+			http.Error(w, "{}", http.StatusMethodNotAllowed)
+		case r.URL.Path == "/"+testCAPool+"/certificates":
+			var crt Certificate
+			if err := json.NewDecoder(r.Body).Decode(&crt); err != nil {
+				http.Error(w, `{"error":{"message":"bad request"}}`, http.StatusBadRequest)
+				return
+			}
+			fmt.Fprintf(w, `{"name":%q,"lifetime":%q}`, testCAPool+"/certificates/"+r.URL.Query().Get("certificateId")+"/"+r.URL.Query().Get("issuingCertificateAuthorityId"), crt.Lifetime)
+		case r.URL.Path == "/"+testCAPool+"/certificates/foo:revoke":
+			var req RevokeCertificateRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Reason != "SUPERSEDED" {
+				http.Error(w, `{"error":{"message":"bad request"}}`, http.StatusBadRequest)
+				return
+			}
+			fmt.Fprintf(w, `{"name":%q}`, testCAPool+"/certificates/foo")
+		default:
+			http.Error(w, `{"error":{"message":"not found"}}`, http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c := &restClient{endpoint: srv.URL + "/", client: srv.Client()}
+	ctx := context.Background()
+
+	crt, err := c.CreateCertificate(ctx, testCAPool, "foo", "ca", &Certificate{Lifetime: "60s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crt.Name != testCAPool+"/certificates/foo/ca" || crt.Lifetime != "60s" {
+		t.Errorf("restClient.CreateCertificate() = %v", crt)
+	}
+
+	crt, err = c.RevokeCertificate(ctx, testCAPool+"/certificates/foo", &RevokeCertificateRequest{Reason: "SUPERSEDED"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crt.Name != testCAPool+"/certificates/foo" {
+		t.Errorf("restClient.RevokeCertificate() = %v", crt)
+	}
+
+	_, err = c.RevokeCertificate(ctx, testCAPool+"/certificates/bar", &RevokeCertificateRequest{Reason: "SUPERSEDED"})
+	if err == nil || !strings.HasSuffix(err.Error(), "status code 404: not found") {
+		t.Errorf("restClient.RevokeCertificate() error = %v", err)
+	}
+}
@@ -0,0 +1,18 @@
+package cloudcas
+
+import (
+	"context"
+)
+
+type MockClient struct {
+	createCertificate func(ctx context.Context, parent, certificateID, issuingCertificateAuthorityID string, crt *Certificate) (*Certificate, error)
+	revokeCertificate func(ctx context.Context, name string, req *RevokeCertificateRequest) (*Certificate, error)
+}
+
+func (m *MockClient) CreateCertificate(ctx context.Context, parent, certificateID, issuingCertificateAuthorityID string, crt *Certificate) (*Certificate, error) {
+	return m.createCertificate(ctx, parent, certificateID, issuingCertificateAuthorityID, crt)
+}
+
+func (m *MockClient) RevokeCertificate(ctx context.Context, name string, req *RevokeCertificateRequest) (*Certificate, error) {
+	return m.revokeCertificate(ctx, name, req)
+}
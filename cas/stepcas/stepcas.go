@@ -0,0 +1,422 @@
+package stepcas
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/cas/apiv1"
+	"github.com/smallstep/cli/crypto/pemutil"
+	"github.com/smallstep/cli/crypto/randutil"
+	"github.com/smallstep/cli/jose"
+	"github.com/smallstep/cli/token"
+)
+
+// tokenLifetime is the validity of the tokens sent to the upstream CA.
+const tokenLifetime = 5 * time.Minute
+
+// StepCAS implements a registration authority that forwards the certificate
+// requests to an upstream step CA. The requests are authorized with tokens
+// signed by an x5c provisioner of the upstream CA, and the same certificate
+// is used as the TLS client certificate.
+type StepCAS struct {
+	caURL       string
+	provisioner string
+	certFile    string
+	signer      crypto.Signer
+	client      *http.Client
+}
+
+// New creates a new StepCAS from the given options. The root certificate of
+// the upstream CA is downloaded and verified with the configured fingerprint.
+func New(ctx context.Context, opts apiv1.Options) (*StepCAS, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	iss := opts.CertificateIssuer
+
+	certs, err := pemutil.ReadCertificateBundle(iss.Certificate)
+	if err != nil {
+		return nil, err
+	}
+	var pemOpts []pemutil.Options
+	if iss.Password != "" {
+		pemOpts = append(pemOpts, pemutil.WithPassword([]byte(iss.Password)))
+	}
+	key, err := pemutil.Read(iss.Key, pemOpts...)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.Errorf("key %s is not a crypto.Signer", iss.Key)
+	}
+
+	caURL := strings.TrimSuffix(opts.CertificateAuthority, "/")
+	root, err := getRootCertificate(caURL, opts.CertificateAuthorityFingerprint)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(root)
+
+	tlsCert := tls.Certificate{PrivateKey: signer, Leaf: certs[0]}
+	for _, crt := range certs {
+		tlsCert.Certificate = append(tlsCert.Certificate, crt.Raw)
+	}
+
+	return &StepCAS{
+		caURL:       caURL,
+		provisioner: iss.Provisioner,
+		certFile:    iss.Certificate,
+		signer:      signer,
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{
+					RootCAs:      pool,
+					Certificates: []tls.Certificate{tlsCert},
+				},
+			},
+		},
+	}, nil
+}
+
+// CreateCertificate signs a new certificate using the upstream CA.
+func (s *StepCAS) CreateCertificate(req *apiv1.CreateCertificateRequest) (*apiv1.CreateCertificateResponse, error) {
+	switch {
+	case req.CSR == nil:
+		return nil, errors.New("createCertificateRequest `csr` cannot be nil")
+	case req.Template == nil:
+		return nil, errors.New("createCertificateRequest `template` cannot be nil")
+	}
+
+	// The upstream CA issues the certificate with the subject and SANs of the
+	// CSR, and its x5c provisioner requires them to match the token. The
+	// registration authority cannot change the CSR, so the names set in the
+	// template by the provisioner must be the same.
+	tmpl := req.Template
+	if err := checkTemplate(tmpl, req.CSR); err != nil {
+		return nil, err
+	}
+	sans := append([]string{}, tmpl.DNSNames...)
+	for _, ip := range tmpl.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, tmpl.EmailAddresses...)
+	subject := tmpl.Subject.CommonName
+	if subject == "" && len(sans) > 0 {
+		subject = sans[0]
+	}
+
+	ott, err := s.token("/1.0/sign", subject, sans)
+	if err != nil {
+		return nil, err
+	}
+
+	body := map[string]interface{}{
+		"csr": string(pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE REQUEST",
+			Bytes: req.CSR.Raw,
+		})),
+		"ott": ott,
+	}
+	if !tmpl.NotBefore.IsZero() {
+		body["notBefore"] = tmpl.NotBefore
+	}
+	switch {
+	case !tmpl.NotAfter.IsZero():
+		body["notAfter"] = tmpl.NotAfter
+	case req.Lifetime > 0:
+		body["notAfter"] = req.Lifetime.String()
+	}
+
+	var resp struct {
+		Certificate      string   `json:"crt"`
+		CertificateChain []string `json:"certChain"`
+		CA               string   `json:"ca"`
+	}
+	if err := s.post("/1.0/sign", body, &resp); err != nil {
+		return nil, err
+	}
+
+	chain := resp.CertificateChain
+	if len(chain) == 0 {
+		chain = []string{resp.Certificate, resp.CA}
+	}
+	certs, err := parseCertificates(chain)
+	if err != nil {
+		return nil, err
+	}
+	return &apiv1.CreateCertificateResponse{
+		Certificate:      certs[0],
+		CertificateChain: certs[1:],
+	}, nil
+}
+
+// RenewCertificate signs a new certificate using the upstream CA if the
+// request has a CSR, like in a rekey. A renewal without a CSR is not
+// implemented, the upstream CA renews certificates using mTLS, and the
+// registration authority does not have the keys of the certificates.
+func (s *StepCAS) RenewCertificate(req *apiv1.RenewCertificateRequest) (*apiv1.RenewCertificateResponse, error) {
+	if req.CSR == nil {
+		return nil, apiv1.ErrNotImplemented{}
+	}
+	resp, err := s.CreateCertificate(&apiv1.CreateCertificateRequest{
+		Template: req.Template,
+		CSR:      req.CSR,
+		Lifetime: req.Lifetime,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &apiv1.RenewCertificateResponse{
+		Certificate:      resp.Certificate,
+		CertificateChain: resp.CertificateChain,
+	}, nil
+}
+
+// RevokeCertificate passively revokes a certificate in the upstream CA.
+func (s *StepCAS) RevokeCertificate(req *apiv1.RevokeCertificateRequest) (*apiv1.RevokeCertificateResponse, error) {
+	serial := req.SerialNumber
+	if serial == "" && req.Certificate != nil {
+		serial = req.Certificate.SerialNumber.String()
+	}
+	if serial == "" {
+		return nil, errors.New("revokeCertificateRequest `serialNumber` or `certificate` are required")
+	}
+
+	ott, err := s.token("/1.0/revoke", serial, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Status string `json:"status"`
+	}
+	if err := s.post("/1.0/revoke", map[string]interface{}{
+		"serial":     serial,
+		"ott":        ott,
+		"reasonCode": req.ReasonCode,
+		"reason":     req.Reason,
+		"passive":    true,
+	}, &resp); err != nil {
+		return nil, err
+	}
+
+	return &apiv1.RevokeCertificateResponse{
+		Certificate: req.Certificate,
+	}, nil
+}
+
+// token generates a new token for the given path of the upstream CA.
+func (s *StepCAS) token(path, subject string, sans []string) (string, error) {
+	jwtID, err := randutil.Hex(64)
+	if err != nil {
+		return "", err
+	}
+	alg, err := signatureAlgorithm(s.signer.Public())
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	opts := []token.Options{
+		token.WithJWTID(jwtID),
+		token.WithIssuer(s.provisioner),
+		token.WithAudience(s.caURL + path),
+		token.WithSubject(subject),
+		token.WithValidity(now, now.Add(tokenLifetime)),
+		token.WithX5CFile(s.certFile, s.signer),
+	}
+	if len(sans) > 0 {
+		opts = append(opts, token.WithSANS(sans))
+	}
+	claims, err := token.NewClaims(opts...)
+	if err != nil {
+		return "", errors.Wrap(err, "error creating token")
+	}
+	return claims.Sign(alg, s.signer)
+}
+
+// post sends the given body to the upstream CA and decodes the response in v.
+func (s *StepCAS) post(path string, body, v interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling request")
+	}
+	resp, err := s.client.Post(s.caURL+path, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrapf(err, "error requesting %s", s.caURL+path)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return readError(s.caURL+path, resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return errors.Wrapf(err, "error decoding response from %s", s.caURL+path)
+	}
+	return nil
+}
+
+// getRootCertificate downloads the root certificate with the given fingerprint
+// from the upstream CA. The connection is not verified, the root certificate
+// is verified with the fingerprint.
+func getRootCertificate(caURL, fingerprint string) (*x509.Certificate, error) {
+	fingerprint = strings.ToLower(strings.Replace(fingerprint, ":", "", -1))
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{
+				// The root certificate is verified with the fingerprint.
+				InsecureSkipVerify: true, // nolint:gosec
+			},
+		},
+	}
+
+	u := caURL + "/root/" + fingerprint
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error requesting %s", u)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, readError(u, resp)
+	}
+
+	var root struct {
+		RootPEM string `json:"ca"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&root); err != nil {
+		return nil, errors.Wrapf(err, "error decoding response from %s", u)
+	}
+	certs, err := parseCertificates([]string{root.RootPEM})
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(certs[0].Raw)
+	if hex.EncodeToString(sum[:]) != fingerprint {
+		return nil, errors.Errorf("root certificate from %s does not match the fingerprint %s", caURL, fingerprint)
+	}
+	return certs[0], nil
+}
+
+// checkTemplate returns an error if the subject or the SANs in the template
+// are not the ones in the CSR.
+func checkTemplate(tmpl *x509.Certificate, csr *x509.CertificateRequest) error {
+	ips := func(v []net.IP) []string {
+		s := make([]string, len(v))
+		for i, ip := range v {
+			s[i] = ip.String()
+		}
+		return s
+	}
+	uris := func(v []*url.URL) []string {
+		s := make([]string, len(v))
+		for i, u := range v {
+			s[i] = u.String()
+		}
+		return s
+	}
+	switch {
+	case tmpl.Subject.CommonName != csr.Subject.CommonName:
+		return errors.Errorf("template common name %q does not match the CSR", tmpl.Subject.CommonName)
+	case !equalNames(tmpl.DNSNames, csr.DNSNames):
+		return errors.Errorf("template DNS names %v do not match the CSR", tmpl.DNSNames)
+	case !equalNames(ips(tmpl.IPAddresses), ips(csr.IPAddresses)):
+		return errors.Errorf("template IP addresses %v do not match the CSR", tmpl.IPAddresses)
+	case !equalNames(tmpl.EmailAddresses, csr.EmailAddresses):
+		return errors.Errorf("template email addresses %v do not match the CSR", tmpl.EmailAddresses)
+	case !equalNames(uris(tmpl.URIs), uris(csr.URIs)):
+		return errors.Errorf("template URIs %v do not match the CSR", tmpl.URIs)
+	default:
+		return nil
+	}
+}
+
+// equalNames returns true if a and b have the same names in any order.
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	m := make(map[string]int, len(a))
+	for _, s := range a {
+		m[strings.ToLower(s)]++
+	}
+	for _, s := range b {
+		k := strings.ToLower(s)
+		if m[k] == 0 {
+			return false
+		}
+		m[k]--
+	}
+	return true
+}
+
+// readError returns an error with the message of the upstream CA.
+func readError(u string, resp *http.Response) error {
+	var msg struct {
+		Message string `json:"message"`
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	if err := json.Unmarshal(b, &msg); err == nil && msg.Message != "" {
+		return errors.Errorf("error requesting %s: status code %d: %s", u, resp.StatusCode, msg.Message)
+	}
+	return errors.Errorf("error requesting %s: status code %d", u, resp.StatusCode)
+}
+
+func parseCertificates(pems []string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for _, s := range pems {
+		block, _ := pem.Decode([]byte(s))
+		if block == nil || block.Type != "CERTIFICATE" {
+			return nil, errors.New("error decoding certificate: invalid PEM")
+		}
+		crt, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing certificate")
+		}
+		certs = append(certs, crt)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("error parsing certificate: response does not contain certificates")
+	}
+	return certs, nil
+}
+
+// signatureAlgorithm returns the JWS algorithm used with the given key.
+func signatureAlgorithm(pub crypto.PublicKey) (jose.SignatureAlgorithm, error) {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		switch k.Curve.Params().BitSize {
+		case 256:
+			return jose.ES256, nil
+		case 384:
+			return jose.ES384, nil
+		case 521:
+			return jose.ES512, nil
+		}
+	case *rsa.PublicKey:
+		return jose.RS256, nil
+	case ed25519.PublicKey:
+		return jose.EdDSA, nil
+	}
+	return "", errors.Errorf("unsupported key type %T", pub)
+}
@@ -0,0 +1,452 @@
+package stepcas
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smallstep/certificates/cas/apiv1"
+	"github.com/smallstep/cli/jose"
+)
+
+func mustKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func mustCertificate(t *testing.T, tmpl, parent *x509.Certificate, pub crypto.PublicKey, signer crypto.Signer) *x509.Certificate {
+	t.Helper()
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore = time.Now().Add(-time.Minute)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent = tmpl
+	}
+	b, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt, err := x509.ParseCertificate(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crt
+}
+
+func encodeCertificate(crt *x509.Certificate) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: crt.Raw}))
+}
+
+// testUpstream is a minimal step CA that signs the requests authorized by
+// x5c tokens signed by the registration authority.
+type testUpstream struct {
+	t                *testing.T
+	root             *x509.Certificate
+	intermediate     *x509.Certificate
+	intermediateKey  crypto.Signer
+	revoked          []string
+	lastToken        map[string]interface{}
+	lastClientCommon string
+}
+
+func (u *testUpstream) verifyToken(w http.ResponseWriter, r *http.Request, ott string) bool {
+	tok, err := jose.ParseSigned(ott)
+	if err != nil {
+		http.Error(w, `{"message":"bad token"}`, http.StatusUnauthorized)
+		return false
+	}
+	chains, err := tok.Headers[0].Certificates(x509.VerifyOptions{
+		Roots:     u.pool(),
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		http.Error(w, `{"message":"bad x5c"}`, http.StatusUnauthorized)
+		return false
+	}
+	claims := make(map[string]interface{})
+	if err := tok.Claims(chains[0][0].PublicKey, &claims); err != nil {
+		http.Error(w, `{"message":"bad signature"}`, http.StatusUnauthorized)
+		return false
+	}
+	u.lastToken = claims
+	if len(r.TLS.PeerCertificates) > 0 {
+		u.lastClientCommon = r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	return true
+}
+
+func (u *testUpstream) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(u.root)
+	return pool
+}
+
+func (u *testUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sum := sha256.Sum256(u.root.Raw)
+	switch r.URL.Path {
+	case "/root/" + hex.EncodeToString(sum[:]):
+		json.NewEncoder(w).Encode(map[string]string{"ca": encodeCertificate(u.root)})
+	case "/1.0/sign":
+		var req struct {
+			CSR       string `json:"csr"`
+			OTT       string `json:"ott"`
+			NotAfter  string `json:"notAfter"`
+			NotBefore string `json:"notBefore"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, `{"message":"bad request"}`, http.StatusBadRequest)
+			return
+		}
+		if !u.verifyToken(w, r, req.OTT) {
+			return
+		}
+		block, _ := pem.Decode([]byte(req.CSR))
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil {
+			http.Error(w, `{"message":"bad csr"}`, http.StatusBadRequest)
+			return
+		}
+		crt := mustCertificate(u.t, &x509.Certificate{
+			Subject:  csr.Subject,
+			DNSNames: csr.DNSNames,
+		}, u.intermediate, csr.PublicKey, u.intermediateKey)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"crt":       encodeCertificate(crt),
+			"ca":        encodeCertificate(u.intermediate),
+			"certChain": []string{encodeCertificate(crt), encodeCertificate(u.intermediate)},
+		})
+	case "/1.0/revoke":
+		var req struct {
+			Serial  string `json:"serial"`
+			OTT     string `json:"ott"`
+			Passive bool   `json:"passive"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !req.Passive {
+			http.Error(w, `{"message":"bad request"}`, http.StatusBadRequest)
+			return
+		}
+		if !u.verifyToken(w, r, req.OTT) {
+			return
+		}
+		u.revoked = append(u.revoked, req.Serial)
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	default:
+		http.Error(w, `{"message":"not found"}`, http.StatusNotFound)
+	}
+}
+
+func testUpstreamServer(t *testing.T, dir string) (*httptest.Server, *testUpstream, apiv1.Options) {
+	t.Helper()
+	rootKey := mustKey(t)
+	root := mustCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Upstream Root"},
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil, rootKey.Public(), rootKey)
+	intKey := mustKey(t)
+	intermediate := mustCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Upstream Intermediate"},
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, root, intKey.Public(), rootKey)
+	srvKey := mustKey(t)
+	srvCert := mustCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, intermediate, srvKey.Public(), intKey)
+	raKey := mustKey(t)
+	raCert := mustCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "Registration Authority"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, intermediate, raKey.Public(), intKey)
+
+	crtFile := filepath.Join(dir, "ra.crt")
+	keyFile := filepath.Join(dir, "ra.key")
+	keyBytes, err := x509.MarshalECPrivateKey(raKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(crtFile, []byte(encodeCertificate(raCert)+encodeCertificate(intermediate)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	upstream := &testUpstream{
+		t:               t,
+		root:            root,
+		intermediate:    intermediate,
+		intermediateKey: intKey,
+	}
+	srv := httptest.NewUnstartedServer(upstream)
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{srvCert.Raw, intermediate.Raw},
+			PrivateKey:  srvKey,
+		}},
+		ClientAuth: tls.RequestClientCert,
+	}
+	srv.StartTLS()
+
+	sum := sha256.Sum256(root.Raw)
+	return srv, upstream, apiv1.Options{
+		Type:                            "stepcas",
+		CertificateAuthority:            srv.URL,
+		CertificateAuthorityFingerprint: hex.EncodeToString(sum[:]),
+		CertificateIssuer: &apiv1.CertificateIssuer{
+			Type:        "x5c",
+			Provisioner: "ra@smallstep.com",
+			Certificate: crtFile,
+			Key:         keyFile,
+		},
+	}
+}
+
+func TestNew(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stepcas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srv, _, opts := testUpstreamServer(t, dir)
+	defer srv.Close()
+
+	badFingerprint := opts
+	badFingerprint.CertificateAuthorityFingerprint = "0000000000000000000000000000000000000000000000000000000000000000"
+	badKey := opts
+	badKey.CertificateIssuer = &apiv1.CertificateIssuer{Type: "x5c", Provisioner: "ra", Certificate: opts.CertificateIssuer.Certificate, Key: filepath.Join(dir, "missing.key")}
+
+	tests := []struct {
+		name    string
+		opts    apiv1.Options
+		wantErr bool
+	}{
+		{"ok", opts, false},
+		{"fail validation", apiv1.Options{Type: "stepcas"}, true},
+		{"fail fingerprint", badFingerprint, true},
+		{"fail key", badKey, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(context.Background(), tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got.caURL != srv.URL {
+				t.Errorf("New() caURL = %v, want %v", got.caURL, srv.URL)
+			}
+		})
+	}
+}
+
+func TestStepCAS_CreateCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stepcas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srv, upstream, opts := testUpstreamServer(t, dir)
+	defer srv.Close()
+
+	s, err := New(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := mustKey(t)
+	b, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "test.smallstep.com"},
+		DNSNames: []string{"test.smallstep.com", "www.smallstep.com"},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.ParseCertificateRequest(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "test.smallstep.com"},
+		DNSNames: []string{"test.smallstep.com", "www.smallstep.com"},
+	}
+
+	tests := []struct {
+		name    string
+		req     *apiv1.CreateCertificateRequest
+		wantErr bool
+	}{
+		{"ok", &apiv1.CreateCertificateRequest{Template: tmpl, CSR: csr, Lifetime: time.Hour}, false},
+		{"ok names in other order", &apiv1.CreateCertificateRequest{Template: &x509.Certificate{
+			Subject:  pkix.Name{CommonName: "test.smallstep.com"},
+			DNSNames: []string{"www.smallstep.com", "test.smallstep.com"},
+		}, CSR: csr, Lifetime: time.Hour}, false},
+		{"fail csr", &apiv1.CreateCertificateRequest{Template: tmpl}, true},
+		{"fail template", &apiv1.CreateCertificateRequest{CSR: csr}, true},
+		{"fail template common name", &apiv1.CreateCertificateRequest{Template: &x509.Certificate{
+			Subject:  pkix.Name{CommonName: "other.smallstep.com"},
+			DNSNames: tmpl.DNSNames,
+		}, CSR: csr}, true},
+		{"fail template dns names", &apiv1.CreateCertificateRequest{Template: &x509.Certificate{
+			Subject:  tmpl.Subject,
+			DNSNames: []string{"test.smallstep.com"},
+		}, CSR: csr}, true},
+		{"fail template emails", &apiv1.CreateCertificateRequest{Template: &x509.Certificate{
+			Subject:        tmpl.Subject,
+			DNSNames:       tmpl.DNSNames,
+			EmailAddresses: []string{"admin@smallstep.com"},
+		}, CSR: csr}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.CreateCertificate(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("StepCAS.CreateCertificate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Certificate.Subject.CommonName != "test.smallstep.com" {
+				t.Errorf("StepCAS.CreateCertificate() common name = %v", got.Certificate.Subject.CommonName)
+			}
+			if len(got.CertificateChain) != 1 || !got.CertificateChain[0].Equal(upstream.intermediate) {
+				t.Errorf("StepCAS.CreateCertificate() chain = %v", got.CertificateChain)
+			}
+			if upstream.lastToken["iss"] != "ra@smallstep.com" || upstream.lastToken["sub"] != "test.smallstep.com" ||
+				upstream.lastToken["aud"] != srv.URL+"/1.0/sign" {
+				t.Errorf("StepCAS.CreateCertificate() token = %v", upstream.lastToken)
+			}
+			if upstream.lastClientCommon != "Registration Authority" {
+				t.Errorf("StepCAS.CreateCertificate() client certificate = %v", upstream.lastClientCommon)
+			}
+		})
+	}
+}
+
+func TestStepCAS_RenewCertificate(t *testing.T) {
+	s := &StepCAS{}
+	if _, err := s.RenewCertificate(&apiv1.RenewCertificateRequest{}); err == nil {
+		t.Error("StepCAS.RenewCertificate() error = nil, want ErrNotImplemented")
+	} else if _, ok := err.(apiv1.ErrNotImplemented); !ok {
+		t.Errorf("StepCAS.RenewCertificate() error = %T, want ErrNotImplemented", err)
+	}
+
+	// A rekey is sent as a new certificate request.
+	dir, err := ioutil.TempDir("", "stepcas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srv, upstream, opts := testUpstreamServer(t, dir)
+	defer srv.Close()
+
+	s, err = New(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := mustKey(t)
+	b, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "test.smallstep.com"},
+		DNSNames: []string{"test.smallstep.com"},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.ParseCertificateRequest(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.RenewCertificate(&apiv1.RenewCertificateRequest{
+		Template: &x509.Certificate{
+			Subject:  pkix.Name{CommonName: "test.smallstep.com"},
+			DNSNames: []string{"test.smallstep.com"},
+		},
+		CSR:      csr,
+		Lifetime: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("StepCAS.RenewCertificate() error = %v", err)
+	}
+	if got.Certificate.Subject.CommonName != "test.smallstep.com" {
+		t.Errorf("StepCAS.RenewCertificate() common name = %v", got.Certificate.Subject.CommonName)
+	}
+	if upstream.lastToken["aud"] != srv.URL+"/1.0/sign" {
+		t.Errorf("StepCAS.RenewCertificate() token = %v", upstream.lastToken)
+	}
+}
+
+func TestStepCAS_RevokeCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stepcas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srv, upstream, opts := testUpstreamServer(t, dir)
+	defer srv.Close()
+
+	s, err := New(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		req     *apiv1.RevokeCertificateRequest
+		want    string
+		wantErr bool
+	}{
+		{"ok serial", &apiv1.RevokeCertificateRequest{SerialNumber: "1234", ReasonCode: 1}, "1234", false},
+		{"ok certificate", &apiv1.RevokeCertificateRequest{Certificate: &x509.Certificate{SerialNumber: big.NewInt(5678)}}, "5678", false},
+		{"fail empty", &apiv1.RevokeCertificateRequest{}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.RevokeCertificate(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("StepCAS.RevokeCertificate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := upstream.revoked[len(upstream.revoked)-1]; got != tt.want {
+				t.Errorf("StepCAS.RevokeCertificate() serial = %v, want %v", got, tt.want)
+			}
+			if upstream.lastToken["sub"] != tt.want || upstream.lastToken["aud"] != srv.URL+"/1.0/revoke" {
+				t.Errorf("StepCAS.RevokeCertificate() token = %v", upstream.lastToken)
+			}
+		})
+	}
+}
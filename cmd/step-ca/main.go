@@ -4,18 +4,13 @@ import (
 	"flag"
 	"fmt"
 	"html"
-	"log"
 	"math/rand"
-	"net/http"
 	"os"
 	"reflect"
 	"regexp"
 	"strconv"
 	"time"
 
-	// Server profiler
-	_ "net/http/pprof"
-
 	"github.com/smallstep/certificates/authority"
 	"github.com/smallstep/certificates/commands"
 	"github.com/smallstep/cli/command"
@@ -28,12 +23,15 @@ import (
 // commit and buildTime are filled in during build by the Makefile
 var (
 	BuildTime = "N/A"
+	Commit    = "N/A"
 	Version   = "N/A"
 )
 
 func init() {
 	config.Set("Smallstep CA", Version, BuildTime)
 	authority.GlobalVersion.Version = Version
+	authority.GlobalVersion.Commit = Commit
+	authority.GlobalVersion.BuildTime = BuildTime
 	rand.Seed(time.Now().UnixNano())
 }
 
@@ -123,15 +121,6 @@ $ step-ca $STEPPATH/config/ca.json --password-file ./password.txt
 	app.ErrWriter = os.Stderr
 	app.Commands = command.Retrieve()
 
-	// Start the golang debug logger if environment variable is set.
-	// See https://golang.org/pkg/net/http/pprof/
-	debugProfAddr := os.Getenv("STEP_PROF_ADDR")
-	if debugProfAddr != "" {
-		go func() {
-			log.Println(http.ListenAndServe(debugProfAddr, nil))
-		}()
-	}
-
 	app.Action = func(_ *cli.Context) error {
 		// Hack to be able to run a the top action as a subcommand
 		set := flag.NewFlagSet(app.Name, flag.ContinueOnError)
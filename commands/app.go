@@ -23,7 +23,8 @@ var AppCommand = cli.Command{
 	Action: appAction,
 	UsageText: `**step-ca** <config>
 	[**--password-file**=<file>]
-	[**--resolver**=<addr>]`,
+	[**--resolver**=<addr>]
+	[**--set**=<path=value>]`,
 	Flags: []cli.Flag{
 		cli.StringFlag{
 			Name: "password-file",
@@ -34,6 +35,14 @@ intermediate private key.`,
 			Name:  "resolver",
 			Usage: "address of a DNS resolver to be used instead of the default.",
 		},
+		cli.StringSliceFlag{
+			Name: "set",
+			Usage: `override the value in the <path> of the configuration, e.g.
+**--set db.dataSource=/data/db**. Use the flag multiple times to override
+multiple values. Lists of strings accept comma separated values, and other
+non-string values use JSON. Overrides take precedence over the STEPCA_
+environment variables, e.g. STEPCA_DB_DATASOURCE.`,
+		},
 	},
 }
 
@@ -41,6 +50,7 @@ intermediate private key.`,
 func appAction(ctx *cli.Context) error {
 	passFile := ctx.String("password-file")
 	resolver := ctx.String("resolver")
+	overrides := ctx.StringSlice("set")
 
 	// If zero cmd line args show help, if >1 cmd line args show error.
 	if ctx.NArg() == 0 {
@@ -51,7 +61,7 @@ func appAction(ctx *cli.Context) error {
 	}
 
 	configFile := ctx.Args().Get(0)
-	config, err := authority.LoadConfiguration(configFile)
+	config, err := authority.LoadConfiguration(configFile, overrides...)
 	if err != nil {
 		fatal(err)
 	}
@@ -72,7 +82,7 @@ func appAction(ctx *cli.Context) error {
 		}
 	}
 
-	srv, err := ca.New(config, ca.WithConfigFile(configFile), ca.WithPassword(password))
+	srv, err := ca.New(config, ca.WithConfigFile(configFile), ca.WithConfigOverrides(overrides), ca.WithPassword(password))
 	if err != nil {
 		fatal(err)
 	}
@@ -0,0 +1,143 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/cli/command"
+	"github.com/smallstep/cli/errs"
+	"github.com/smallstep/nosql"
+	"github.com/urfave/cli"
+)
+
+func init() {
+	command.Register(cli.Command{
+		Name:      "db",
+		Usage:     "export and import the database of the CA",
+		UsageText: "**step-ca db** <subcommand> [arguments]",
+		Description: `**step-ca db** command group provides commands to back up the database of
+the CA, or to move it to a database of a different type.
+
+The archives contain the certificates, revocations, used tokens, provisioners
+and policies created with the admin API, and the ACME accounts, orders and
+certificates. They do not contain the configuration file or the keys of the CA.
+If the database is encrypted, the values in the archive are not.
+
+The database must not be in use by a running CA, an archive of a running CA
+can be downloaded from the admin API at /admin/export.
+
+## EXAMPLES
+
+Export the database to a file:
+'''
+$ step-ca db export $(step path)/config/ca.json --output backup.json.gz
+'''
+
+Import the archive in the database of a new CA:
+'''
+$ step-ca db import new-ca.json backup.json.gz
+'''`,
+		Subcommands: cli.Commands{
+			{
+				Name:      "export",
+				Usage:     "export the database to an archive",
+				UsageText: "**step-ca db export** <config> [**--output**=<file>] [**--set**=<path=value>]",
+				Action:    dbExportAction,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "output",
+						Usage: "write the archive to <file> instead of the standard output.",
+					},
+					dbSetFlag,
+				},
+			},
+			{
+				Name:      "import",
+				Usage:     "import an archive in the database",
+				UsageText: "**step-ca db import** <config> <file> [**--set**=<path=value>]",
+				Action:    dbImportAction,
+				Flags:     []cli.Flag{dbSetFlag},
+			},
+		},
+	})
+}
+
+var dbSetFlag = cli.StringSliceFlag{
+	Name: "set",
+	Usage: `override the value in the <path> of the configuration, e.g.
+**--set db.dataSource=/data/db**.`,
+}
+
+func dbExportAction(ctx *cli.Context) error {
+	if err := errs.NumberOfArguments(ctx, 1); err != nil {
+		return err
+	}
+	ndb, err := openDatabase(ctx.Args().Get(0), ctx.StringSlice("set"))
+	if err != nil {
+		return err
+	}
+	defer ndb.Close()
+
+	var w io.Writer = os.Stdout
+	if fn := ctx.String("output"); fn != "" {
+		f, err := os.OpenFile(fn, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return errors.Wrapf(err, "error creating %s", fn)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	n, err := db.Export(ndb, w, append(db.Tables(), acme.Tables()...))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d keys exported\n", n)
+	return nil
+}
+
+func dbImportAction(ctx *cli.Context) error {
+	if err := errs.NumberOfArguments(ctx, 2); err != nil {
+		return err
+	}
+	fn := ctx.Args().Get(1)
+	f, err := os.Open(fn)
+	if err != nil {
+		return errors.Wrapf(err, "error opening %s", fn)
+	}
+	defer f.Close()
+
+	ndb, err := openDatabase(ctx.Args().Get(0), ctx.StringSlice("set"))
+	if err != nil {
+		return err
+	}
+	defer ndb.Close()
+
+	n, err := db.Import(ndb, f)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d keys imported\n", n)
+	return nil
+}
+
+// openDatabase opens the database in the given configuration file.
+func openDatabase(configFile string, overrides []string) (nosql.DB, error) {
+	config, err := authority.LoadConfiguration(configFile, overrides...)
+	if err != nil {
+		return nil, err
+	}
+	if config.DB == nil {
+		return nil, errors.Errorf("%s does not define a database", configFile)
+	}
+	adb, err := db.New(config.DB)
+	if err != nil {
+		return nil, err
+	}
+	return adb.(nosql.DB), nil
+}
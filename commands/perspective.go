@@ -0,0 +1,110 @@
+package commands
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/cli/command"
+	"github.com/smallstep/cli/errs"
+	"github.com/urfave/cli"
+)
+
+func init() {
+	command.Register(cli.Command{
+		Name:  "perspective",
+		Usage: "run a remote perspective agent for the ACME challenge validations",
+		UsageText: `**step-ca perspective** **--crt**=<file> **--key**=<file> **--client-root**=<file>
+	[**--address**=<address>] [**--resolver**=<address>]`,
+		Description: `**step-ca perspective** runs a remote perspective agent. ACME servers
+configured with **acme.perspectives** ask the agents to validate the http-01 and
+dns-01 challenges from their own network location, and a challenge is only
+valid if a quorum of the agents validate it too.
+
+The agent is served over mTLS with the certificate and key in **--crt** and
+**--key**. Only clients with a certificate that chains to the roots in
+**--client-root**, the certificates of the ACME servers in
+**acme.perspectives.crt**, can request validations.
+
+## EXAMPLES
+
+Run an agent on port 8443:
+'''
+$ step-ca perspective --address :8443 \
+  --crt agent.crt --key agent.key --client-root root_ca.crt
+'''
+
+Validate dns-01 challenges using a public resolver:
+'''
+$ step-ca perspective --crt agent.crt --key agent.key --client-root root_ca.crt \
+  --resolver 1.1.1.1:53
+'''`,
+		Action: perspectiveAction,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "address",
+				Usage: "the <address> where the agent listens.",
+				Value: ":8443",
+			},
+			cli.StringFlag{
+				Name:  "crt",
+				Usage: "path to the <file> with the certificate of the agent.",
+			},
+			cli.StringFlag{
+				Name:  "key",
+				Usage: "path to the <file> with the private key of the agent.",
+			},
+			cli.StringFlag{
+				Name:  "client-root",
+				Usage: "path to the <file> with the roots used to verify the client certificates.",
+			},
+			cli.StringSliceFlag{
+				Name: "resolver",
+				Usage: `<address> of a DNS resolver used to validate the dns-01 challenges.
+Use the flag multiple times to use multiple resolvers.`,
+			},
+		},
+	})
+}
+
+func perspectiveAction(ctx *cli.Context) error {
+	if err := errs.NumberOfArguments(ctx, 0); err != nil {
+		return err
+	}
+	for _, name := range []string{"crt", "key", "client-root"} {
+		if ctx.String(name) == "" {
+			return errs.RequiredFlag(ctx, name)
+		}
+	}
+
+	crt, err := tls.LoadX509KeyPair(ctx.String("crt"), ctx.String("key"))
+	if err != nil {
+		return errors.Wrap(err, "error loading agent certificate")
+	}
+	fn := ctx.String("client-root")
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return errors.Wrapf(err, "error reading %s", fn)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return errors.Errorf("error parsing %s: no certificates found", fn)
+	}
+
+	agent, err := acme.NewPerspectiveAgent(nil, &acme.DNS01Config{
+		Resolvers: ctx.StringSlice("resolver"),
+	})
+	if err != nil {
+		return err
+	}
+	srv := acme.NewPerspectiveServer(ctx.String("address"), agent, crt, pool)
+	log.Printf("Serving perspective agent on %s ...", srv.Addr)
+	if err := srv.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
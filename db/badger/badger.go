@@ -0,0 +1,566 @@
+// Package badger implements the nosql database interface on top of Badger v1
+// and v2, using the same keys as the nosql implementation. Unlike the nosql
+// one it allows to tune the Badger options, and it runs periodically the
+// garbage collection of the value log.
+package badger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/nosql/database"
+)
+
+const (
+	defaultValueLogGCInterval     = time.Hour
+	defaultValueLogGCDiscardRatio = 0.5
+	// deleteBatchSize is the number of keys deleted on each transaction when
+	// a table is deleted.
+	deleteBatchSize = 1000
+)
+
+// Options are the Badger options that can be tuned.
+//
+// ValueLogGCInterval is the frequency of the value log garbage collection, it
+// defaults to 1h and 0 disables it. ValueLogGCDiscardRatio is the fraction of
+// a value log file that must be discardable to rewrite it, it defaults to 0.5.
+// Compression is the compression of the tables, none, snappy or zstd, and it
+// is only supported by Badger v2. NumMemtables, MaxTableSize and
+// ValueLogFileSize override the Badger defaults if they are set.
+type Options struct {
+	ValueLogGCInterval     string  `json:"valueLogGCInterval,omitempty"`
+	ValueLogGCDiscardRatio float64 `json:"valueLogGCDiscardRatio,omitempty"`
+	Compression            string  `json:"compression,omitempty"`
+	NumMemtables           int     `json:"numMemtables,omitempty"`
+	MaxTableSize           int64   `json:"maxTableSize,omitempty"`
+	ValueLogFileSize       int64   `json:"valueLogFileSize,omitempty"`
+}
+
+// Validate checks the options.
+func (o *Options) Validate() error {
+	if o == nil {
+		return nil
+	}
+	if _, err := o.valueLogGCInterval(); err != nil {
+		return err
+	}
+	switch {
+	case o.ValueLogGCDiscardRatio < 0:
+		return errors.New("badger valueLogGCDiscardRatio cannot be negative")
+	case o.ValueLogGCDiscardRatio >= 1:
+		return errors.New("badger valueLogGCDiscardRatio must be less than 1")
+	case o.NumMemtables < 0:
+		return errors.New("badger numMemtables cannot be negative")
+	case o.MaxTableSize < 0:
+		return errors.New("badger maxTableSize cannot be negative")
+	case o.ValueLogFileSize < 0:
+		return errors.New("badger valueLogFileSize cannot be negative")
+	}
+	switch strings.ToLower(o.Compression) {
+	case "", "none", "snappy", "zstd":
+		return nil
+	default:
+		return errors.Errorf("badger compression %s is not supported", o.Compression)
+	}
+}
+
+func (o *Options) valueLogGCInterval() (time.Duration, error) {
+	if o == nil || o.ValueLogGCInterval == "" {
+		return defaultValueLogGCInterval, nil
+	}
+	d, err := time.ParseDuration(o.ValueLogGCInterval)
+	switch {
+	case err != nil:
+		return 0, errors.Wrapf(err, "error parsing badger valueLogGCInterval")
+	case d < 0:
+		return 0, errors.New("badger valueLogGCInterval cannot be negative")
+	default:
+		return d, nil
+	}
+}
+
+func (o *Options) valueLogGCDiscardRatio() float64 {
+	if o == nil || o.ValueLogGCDiscardRatio == 0 {
+		return defaultValueLogGCDiscardRatio
+	}
+	return o.ValueLogGCDiscardRatio
+}
+
+// store is the interface implemented by the Badger v1 and v2 databases.
+type store interface {
+	view(fn func(t txn) error) error
+	update(fn func(t txn) error) error
+	// runValueLogGC rewrites a value log file and returns true if there was
+	// one with the given discard ratio.
+	runValueLogGC(discardRatio float64) (bool, error)
+	close() error
+}
+
+// txn is the interface implemented by the Badger v1 and v2 transactions.
+type txn interface {
+	// get returns the value of the key and its expiration in seconds since
+	// the epoch, 0 if it does not expire.
+	get(key []byte) ([]byte, uint64, error)
+	// set stores the value of the key, a 0 expiresAt means that the key does
+	// not expire.
+	set(key, value []byte, expiresAt uint64) error
+	delete(key []byte) error
+	// iterate calls fn with the keys with the given prefix, values are only
+	// read if withValues is true.
+	iterate(prefix []byte, withValues bool, fn func(key, value []byte) error) error
+}
+
+// DB is the Badger implementation of the nosql database interface. Version
+// is the major version of Badger, 1 (the default) or 2.
+type DB struct {
+	Version int
+	Options *Options
+	db      store
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// Open opens or creates a Badger database in the given directory, and starts
+// the value log garbage collection.
+func (db *DB) Open(dir string, opt ...database.Option) (err error) {
+	opts := &database.Options{}
+	for _, o := range opt {
+		if err := o(opts); err != nil {
+			return err
+		}
+	}
+	if err := db.Options.Validate(); err != nil {
+		return err
+	}
+	o := db.Options
+	if o == nil {
+		o = &Options{}
+	}
+	switch db.Version {
+	case 0, 1:
+		db.db, err = openV1(dir, opts, o)
+	case 2:
+		db.db, err = openV2(dir, opts, o)
+	default:
+		return errors.Errorf("badger version %d is not supported", db.Version)
+	}
+	if err != nil {
+		return errors.Wrap(err, "error opening Badger database")
+	}
+
+	interval, _ := o.valueLogGCInterval()
+	db.done = make(chan struct{})
+	if interval > 0 {
+		db.wg.Add(1)
+		go db.runValueLogGC(interval, o.valueLogGCDiscardRatio())
+	}
+	return nil
+}
+
+// runValueLogGC runs the value log garbage collection on every tick until
+// the database is closed.
+func (db *DB) runValueLogGC(interval time.Duration, discardRatio float64) {
+	defer db.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.done:
+			return
+		case <-ticker.C:
+			if err := db.valueLogGC(discardRatio); err != nil {
+				log.Printf("error running badger value log garbage collection: %v", err)
+			}
+		}
+	}
+}
+
+// valueLogGC rewrites value log files until there are no more files with the
+// given discard ratio.
+func (db *DB) valueLogGC(discardRatio float64) error {
+	for {
+		select {
+		case <-db.done:
+			return nil
+		default:
+		}
+		rewritten, err := db.db.runValueLogGC(discardRatio)
+		if err != nil || !rewritten {
+			return err
+		}
+	}
+}
+
+// Close stops the value log garbage collection and closes the database.
+func (db *DB) Close() error {
+	if db.done != nil {
+		close(db.done)
+		db.wg.Wait()
+	}
+	return errors.Wrap(db.db.close(), "error closing Badger database")
+}
+
+// CreateTable creates a token element with the 'bucket' prefix so that such
+// that their appears to be a table.
+func (db *DB) CreateTable(bucket []byte) error {
+	bk, err := badgerEncode(bucket)
+	if err != nil {
+		return err
+	}
+	return db.db.update(func(t txn) error {
+		return errors.Wrapf(t.set(bk, []byte{}, 0), "failed to create %s/", bucket)
+	})
+}
+
+// DeleteTable deletes a root or embedded bucket. Returns an error if the
+// bucket cannot be found.
+func (db *DB) DeleteTable(bucket []byte) error {
+	prefix, err := badgerEncode(bucket)
+	if err != nil {
+		return err
+	}
+	var keys [][]byte
+	if err := db.db.view(func(t txn) error {
+		return t.iterate(prefix, false, func(key, value []byte) error {
+			keys = append(keys, key)
+			return nil
+		})
+	}); err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return errors.Wrapf(database.ErrNotFound, "table %s does not exist", bucket)
+	}
+	for len(keys) > 0 {
+		n := deleteBatchSize
+		if n > len(keys) {
+			n = len(keys)
+		}
+		if err := db.db.update(func(t txn) error {
+			for _, key := range keys[:n] {
+				if err := t.delete(key); err != nil {
+					return errors.Wrapf(err, "error deleting key %s", key)
+				}
+			}
+			return nil
+		}); err != nil {
+			return errors.Wrap(err, "update failed")
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+// Get returns the value stored in the given bucked and key.
+func (db *DB) Get(bucket, key []byte) (ret []byte, err error) {
+	bk, err := toBadgerKey(bucket, key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error converting %s/%s to badgerKey", bucket, key)
+	}
+	err = db.db.view(func(t txn) error {
+		ret, _, err = t.get(bk)
+		return err
+	})
+	return
+}
+
+// BatchGet returns the values of the given keys in the bucket, read in a
+// single transaction. Keys not found have a nil value.
+func (db *DB) BatchGet(bucket []byte, keys [][]byte) ([][]byte, error) {
+	bks := make([][]byte, len(keys))
+	for i, key := range keys {
+		bk, err := toBadgerKey(bucket, key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error converting %s/%s to badgerKey", bucket, key)
+		}
+		bks[i] = bk
+	}
+	values := make([][]byte, len(keys))
+	err := db.db.view(func(t txn) error {
+		for i, bk := range bks {
+			v, _, err := t.get(bk)
+			switch {
+			case database.IsErrNotFound(err):
+			case err != nil:
+				return err
+			default:
+				values[i] = v
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// Set stores the given value on bucket and key.
+func (db *DB) Set(bucket, key, value []byte) error {
+	return db.set(bucket, key, value, 0)
+}
+
+// SetWithTTL stores the given value on bucket and key, Badger removes it once
+// the given time to live has elapsed.
+func (db *DB) SetWithTTL(bucket, key, value []byte, ttl time.Duration) error {
+	return db.set(bucket, key, value, expiresAt(ttl))
+}
+
+func (db *DB) set(bucket, key, value []byte, expiresAt uint64) error {
+	bk, err := toBadgerKey(bucket, key)
+	if err != nil {
+		return errors.Wrapf(err, "error converting %s/%s to badgerKey", bucket, key)
+	}
+	return db.db.update(func(t txn) error {
+		return errors.Wrapf(t.set(bk, value, expiresAt), "failed to set %s/%s", bucket, key)
+	})
+}
+
+// Del deletes the value stored in the given bucked and key.
+func (db *DB) Del(bucket, key []byte) error {
+	bk, err := toBadgerKey(bucket, key)
+	if err != nil {
+		return errors.Wrapf(err, "error converting %s/%s to badgerKey", bucket, key)
+	}
+	return db.db.update(func(t txn) error {
+		return errors.Wrapf(t.delete(bk), "failed to delete %s/%s", bucket, key)
+	})
+}
+
+// List returns the full list of entries in a bucket.
+func (db *DB) List(bucket []byte) ([]*database.Entry, error) {
+	prefix, err := badgerEncode(bucket)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		entries     []*database.Entry
+		tableExists bool
+	)
+	err = db.db.view(func(t txn) error {
+		return t.iterate(prefix, true, func(bk, value []byte) error {
+			tableExists = true
+			if isBadgerTable(bk) {
+				return nil
+			}
+			_bucket, key, err := fromBadgerKey(bk)
+			if err != nil {
+				return errors.Wrapf(err, "error converting from badgerKey %s", bk)
+			}
+			if !bytes.Equal(_bucket, bucket) {
+				return errors.Errorf("bucket names do not match; want %v, but got %v",
+					bucket, _bucket)
+			}
+			entries = append(entries, &database.Entry{
+				Bucket: _bucket,
+				Key:    key,
+				Value:  value,
+			})
+			return nil
+		})
+	})
+	if err == nil && !tableExists {
+		return nil, errors.Wrapf(database.ErrNotFound, "bucket %s not found", bucket)
+	}
+	return entries, err
+}
+
+// CmpAndSwap modifies the value at the given bucket and key (to newValue)
+// only if the existing (current) value matches oldValue. The expiration of
+// the existing value, if any, is kept.
+func (db *DB) CmpAndSwap(bucket, key, oldValue, newValue []byte) ([]byte, bool, error) {
+	return db.cmpAndSwap(bucket, key, oldValue, newValue, 0)
+}
+
+// CmpAndSwapWithTTL is like CmpAndSwap, but the new value is removed once the
+// given time to live has elapsed.
+func (db *DB) CmpAndSwapWithTTL(bucket, key, oldValue, newValue []byte, ttl time.Duration) ([]byte, bool, error) {
+	return db.cmpAndSwap(bucket, key, oldValue, newValue, expiresAt(ttl))
+}
+
+func (db *DB) cmpAndSwap(bucket, key, oldValue, newValue []byte, expiresAt uint64) ([]byte, bool, error) {
+	bk, err := toBadgerKey(bucket, key)
+	if err != nil {
+		return nil, false, err
+	}
+	var (
+		val     []byte
+		swapped bool
+	)
+	err = db.db.update(func(t txn) (err error) {
+		val, swapped, err = cmpAndSwap(t, bk, oldValue, newValue, expiresAt)
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return val, swapped, nil
+}
+
+// cmpAndSwap swaps the value of the key in the given transaction. If
+// expiresAt is 0, the new value keeps the expiration of the current one.
+func cmpAndSwap(t txn, bk, oldValue, newValue []byte, expiresAt uint64) ([]byte, bool, error) {
+	current, currentExpiresAt, err := t.get(bk)
+	// If value does not exist but expected is not nil, then return w/out swapping.
+	if err != nil && !database.IsErrNotFound(err) {
+		return nil, false, err
+	}
+	if !bytes.Equal(current, oldValue) {
+		return current, false, nil
+	}
+
+	if expiresAt == 0 {
+		expiresAt = currentExpiresAt
+	}
+	if err := t.set(bk, newValue, expiresAt); err != nil {
+		return current, false, errors.Wrapf(err, "failed to set %s", bk)
+	}
+	return newValue, true, nil
+}
+
+// Update performs multiple commands on one read-write transaction.
+func (db *DB) Update(tx *database.Tx) error {
+	return db.update(tx, 0)
+}
+
+// UpdateWithTTL is like Update, but the values written by the transaction are
+// removed once the given time to live has elapsed.
+func (db *DB) UpdateWithTTL(tx *database.Tx, ttl time.Duration) error {
+	return db.update(tx, expiresAt(ttl))
+}
+
+func (db *DB) update(tx *database.Tx, expiresAt uint64) error {
+	return db.db.update(func(t txn) (err error) {
+		for _, q := range tx.Operations {
+			switch q.Cmd {
+			case database.CreateTable:
+				if err = db.CreateTable(q.Bucket); err != nil {
+					return err
+				}
+				continue
+			case database.DeleteTable:
+				if err = db.DeleteTable(q.Bucket); err != nil {
+					return err
+				}
+				continue
+			}
+			bk, err := toBadgerKey(q.Bucket, q.Key)
+			if err != nil {
+				return err
+			}
+			switch q.Cmd {
+			case database.Get:
+				if q.Result, _, err = t.get(bk); err != nil {
+					return errors.Wrapf(err, "failed to get %s/%s", q.Bucket, q.Key)
+				}
+			case database.Set:
+				if err := t.set(bk, q.Value, expiresAt); err != nil {
+					return errors.Wrapf(err, "failed to set %s/%s", q.Bucket, q.Key)
+				}
+			case database.Delete:
+				if err = t.delete(bk); err != nil {
+					return errors.Wrapf(err, "failed to delete %s/%s", q.Bucket, q.Key)
+				}
+			case database.CmpAndSwap:
+				q.Result, q.Swapped, err = cmpAndSwap(t, bk, q.CmpValue, q.Value, expiresAt)
+				if err != nil {
+					return errors.Wrapf(err, "failed to CmpAndSwap %s/%s", q.Bucket, q.Key)
+				}
+			default:
+				return database.ErrOpNotSupported
+			}
+		}
+		return nil
+	})
+}
+
+// expiresAt returns the expiration in seconds since the epoch of a key with
+// the given time to live, or 0 if the ttl is not greater than 0. Badger
+// expirations have a resolution of seconds, so the ttl is rounded up.
+func expiresAt(ttl time.Duration) uint64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return uint64(time.Now().Add(ttl + time.Second - 1).Unix())
+}
+
+// toBadgerKey returns the Badger database key using the following algorithm:
+// First 2 bytes are the length of the bucket/table name in little endian format,
+// followed by the bucket/table name,
+// followed by 2 bytes representing the length of the key in little endian format,
+// followed by the key.
+func toBadgerKey(bucket, key []byte) ([]byte, error) {
+	first, err := badgerEncode(bucket)
+	if err != nil {
+		return nil, err
+	}
+	second, err := badgerEncode(key)
+	if err != nil {
+		return nil, err
+	}
+	return append(first, second...), nil
+}
+
+// isBadgerTable returns True if the slice is a badgerTable token, false otherwise.
+// badgerTable means that the slice contains only the [size|value] of one section
+// of a badgerKey and no remainder. A badgerKey is [buket|key], while a badgerTable
+// is only the bucket section.
+func isBadgerTable(bk []byte) bool {
+	if k, rest := parseBadgerEncode(bk); len(k) > 0 && len(rest) == 0 {
+		return true
+	}
+	return false
+}
+
+// fromBadgerKey returns the bucket and key encoded in a BadgerKey.
+// See documentation for toBadgerKey.
+func fromBadgerKey(bk []byte) ([]byte, []byte, error) {
+	bucket, rest := parseBadgerEncode(bk)
+	if len(bucket) == 0 || len(rest) == 0 {
+		return nil, nil, errors.Errorf("invalid badger key: %v", bk)
+	}
+
+	key, rest2 := parseBadgerEncode(rest)
+	if len(key) == 0 || len(rest2) != 0 {
+		return nil, nil, errors.Errorf("invalid badger key: %v", bk)
+	}
+
+	return bucket, key, nil
+}
+
+// badgerEncode encodes a byte slice into a section of a BadgerKey.
+// See documentation for toBadgerKey.
+func badgerEncode(val []byte) ([]byte, error) {
+	l := len(val)
+	switch {
+	case l == 0:
+		return nil, errors.Errorf("input cannot be empty")
+	case l > 65535:
+		return nil, errors.Errorf("length of input cannot be greater than 65535")
+	default:
+		b := make([]byte, 2, 2+l)
+		binary.LittleEndian.PutUint16(b, uint16(l))
+		return append(b, val...), nil
+	}
+}
+
+func parseBadgerEncode(bk []byte) (value, rest []byte) {
+	if len(bk) < 2 {
+		return nil, bk
+	}
+	// First 2 bytes stores the length of the value.
+	end := 2 + int(binary.LittleEndian.Uint16(bk[:2]))
+	switch {
+	case len(bk) < end:
+		return nil, bk
+	case len(bk) == end:
+		return bk[2:end], nil
+	default:
+		return bk[2:end], bk[end:]
+	}
+}
@@ -0,0 +1,277 @@
+package badger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/nosql/database"
+)
+
+func TestOptions_Validate(t *testing.T) {
+	tests := map[string]struct {
+		options *Options
+		err     string
+	}{
+		"ok/nil":   {nil, ""},
+		"ok/empty": {&Options{}, ""},
+		"ok": {&Options{
+			ValueLogGCInterval: "10m", ValueLogGCDiscardRatio: 0.7, Compression: "ZSTD",
+			NumMemtables: 2, MaxTableSize: 16 << 20, ValueLogFileSize: 64 << 20,
+		}, ""},
+		"ok/gc-disabled":         {&Options{ValueLogGCInterval: "0s"}, ""},
+		"fail/interval":          {&Options{ValueLogGCInterval: "foo"}, "error parsing badger valueLogGCInterval"},
+		"fail/interval-negative": {&Options{ValueLogGCInterval: "-1m"}, "badger valueLogGCInterval cannot be negative"},
+		"fail/ratio-negative":    {&Options{ValueLogGCDiscardRatio: -0.5}, "badger valueLogGCDiscardRatio cannot be negative"},
+		"fail/ratio":             {&Options{ValueLogGCDiscardRatio: 1}, "badger valueLogGCDiscardRatio must be less than 1"},
+		"fail/numMemtables":      {&Options{NumMemtables: -1}, "badger numMemtables cannot be negative"},
+		"fail/maxTableSize":      {&Options{MaxTableSize: -1}, "badger maxTableSize cannot be negative"},
+		"fail/valueLogFileSize":  {&Options{ValueLogFileSize: -1}, "badger valueLogFileSize cannot be negative"},
+		"fail/compression":       {&Options{Compression: "lz4"}, "badger compression lz4 is not supported"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.options.Validate()
+			if tc.err != "" {
+				if assert.NotNil(t, err) {
+					assert.HasPrefix(t, err.Error(), tc.err)
+				}
+			} else {
+				assert.FatalError(t, err)
+			}
+		})
+	}
+}
+
+func TestDB_Open(t *testing.T) {
+	tests := map[string]struct {
+		db  *DB
+		opt []database.Option
+		err string
+	}{
+		"ok/v1":             {&DB{}, nil, ""},
+		"ok/v2":             {&DB{Version: 2}, nil, ""},
+		"ok/v1-fileio":      {&DB{Version: 1}, []database.Option{database.WithBadgerFileLoadingMode("FileIO")}, ""},
+		"ok/v2-compression": {&DB{Version: 2, Options: &Options{Compression: "snappy", ValueLogGCInterval: "0s"}}, nil, ""},
+		"fail/version":      {&DB{Version: 3}, nil, "badger version 3 is not supported"},
+		"fail/options":      {&DB{Options: &Options{NumMemtables: -1}}, nil, "badger numMemtables cannot be negative"},
+		"fail/v1-compression": {&DB{Options: &Options{Compression: "zstd"}}, nil,
+			"error opening Badger database: badger compression zstd requires badgerv2"},
+		"fail/loading-mode": {&DB{Version: 2}, []database.Option{database.WithBadgerFileLoadingMode("foo")},
+			"error opening Badger database: Invalid ValueLogLoadingMode"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "badger")
+			assert.FatalError(t, err)
+			defer os.RemoveAll(dir)
+
+			err = tc.db.Open(dir, tc.opt...)
+			if tc.err != "" {
+				if assert.NotNil(t, err) {
+					assert.HasPrefix(t, err.Error(), tc.err)
+				}
+				return
+			}
+			assert.FatalError(t, err)
+			assert.FatalError(t, tc.db.Close())
+		})
+	}
+}
+
+func TestDB(t *testing.T) {
+	for _, version := range []int{1, 2} {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "badger")
+			assert.FatalError(t, err)
+			defer os.RemoveAll(dir)
+
+			db := &DB{Version: version}
+			assert.FatalError(t, db.Open(dir))
+			testDB(t, db)
+			assert.FatalError(t, db.valueLogGC(0.5))
+			assert.FatalError(t, db.Close())
+
+			// Data is kept after a restart.
+			db = &DB{Version: version}
+			assert.FatalError(t, db.Open(dir))
+			v, err := db.Get([]byte("bucket"), []byte("a"))
+			assert.FatalError(t, err)
+			assert.Equals(t, []byte("10"), v)
+			assert.FatalError(t, db.Close())
+		})
+	}
+}
+
+func testDB(t *testing.T, db *DB) {
+	bucket := []byte("bucket")
+	_, err := db.List(bucket)
+	assert.True(t, database.IsErrNotFound(err))
+	assert.True(t, database.IsErrNotFound(db.DeleteTable(bucket)))
+
+	assert.FatalError(t, db.CreateTable(bucket))
+	entries, err := db.List(bucket)
+	assert.FatalError(t, err)
+	assert.Len(t, 0, entries)
+
+	_, err = db.Get(bucket, []byte("a"))
+	assert.True(t, database.IsErrNotFound(err))
+	_, err = db.Get(bucket, nil)
+	assert.NotNil(t, err)
+
+	assert.FatalError(t, db.Set(bucket, []byte("a"), []byte("1")))
+	assert.FatalError(t, db.Set(bucket, []byte("b"), []byte("2")))
+	v, err := db.Get(bucket, []byte("a"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("1"), v)
+
+	assert.FatalError(t, db.Del(bucket, []byte("b")))
+	_, err = db.Get(bucket, []byte("b"))
+	assert.True(t, database.IsErrNotFound(err))
+
+	v, swapped, err := db.CmpAndSwap(bucket, []byte("a"), []byte("2"), []byte("3"))
+	assert.FatalError(t, err)
+	assert.False(t, swapped)
+	assert.Equals(t, []byte("1"), v)
+	v, swapped, err = db.CmpAndSwap(bucket, []byte("a"), []byte("1"), []byte("3"))
+	assert.FatalError(t, err)
+	assert.True(t, swapped)
+	assert.Equals(t, []byte("3"), v)
+	v, swapped, err = db.CmpAndSwap(bucket, []byte("c"), nil, []byte("4"))
+	assert.FatalError(t, err)
+	assert.True(t, swapped)
+	assert.Equals(t, []byte("4"), v)
+
+	tx := new(database.Tx)
+	tx.Get(bucket, []byte("a"))
+	tx.Set(bucket, []byte("d"), []byte("5"))
+	tx.Del(bucket, []byte("c"))
+	tx.Operations = append(tx.Operations, &database.TxEntry{
+		Bucket: bucket, Key: []byte("a"), CmpValue: []byte("3"), Value: []byte("10"), Cmd: database.CmpAndSwap,
+	})
+	assert.FatalError(t, db.Update(tx))
+	assert.Equals(t, []byte("3"), tx.Operations[0].Result)
+	assert.True(t, tx.Operations[3].Swapped)
+
+	tx = new(database.Tx)
+	tx.Cmp(bucket, []byte("a"), []byte("10"))
+	assert.Equals(t, database.ErrOpNotSupported, db.Update(tx))
+
+	entries, err = db.List(bucket)
+	assert.FatalError(t, err)
+	assert.Equals(t, []*database.Entry{
+		{Bucket: bucket, Key: []byte("a"), Value: []byte("10")},
+		{Bucket: bucket, Key: []byte("d"), Value: []byte("5")},
+	}, entries)
+
+	values, err := db.BatchGet(bucket, [][]byte{[]byte("d"), []byte("c"), []byte("a")})
+	assert.FatalError(t, err)
+	assert.Equals(t, [][]byte{[]byte("5"), nil, []byte("10")}, values)
+	_, err = db.BatchGet(bucket, [][]byte{[]byte("a"), nil})
+	assert.NotNil(t, err)
+
+	other := []byte("other")
+	assert.FatalError(t, db.CreateTable(other))
+	for i := 0; i < deleteBatchSize+10; i++ {
+		assert.FatalError(t, db.Set(other, []byte(fmt.Sprint(i)), []byte("value")))
+	}
+	assert.FatalError(t, db.DeleteTable(other))
+	_, err = db.List(other)
+	assert.True(t, database.IsErrNotFound(err))
+}
+
+func TestDB_TTL(t *testing.T) {
+	for _, version := range []int{1, 2} {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "badger")
+			assert.FatalError(t, err)
+			defer os.RemoveAll(dir)
+
+			db := &DB{Version: version}
+			assert.FatalError(t, db.Open(dir))
+			defer db.Close()
+			testTTL(t, db)
+		})
+	}
+}
+
+func testTTL(t *testing.T, db *DB) {
+	bucket := []byte("bucket")
+	assert.FatalError(t, db.CreateTable(bucket))
+	expiry := func(key string) uint64 {
+		bk, err := toBadgerKey(bucket, []byte(key))
+		assert.FatalError(t, err)
+		var exp uint64
+		assert.FatalError(t, db.db.view(func(t txn) (err error) {
+			_, exp, err = t.get(bk)
+			return
+		}))
+		return exp
+	}
+	hour := expiresAt(time.Hour)
+
+	assert.FatalError(t, db.SetWithTTL(bucket, []byte("a"), []byte("1"), time.Hour))
+	assert.True(t, expiry("a") >= hour)
+	assert.FatalError(t, db.SetWithTTL(bucket, []byte("b"), []byte("1"), 0))
+	assert.Equals(t, uint64(0), expiry("b"))
+
+	// A swap keeps the expiration unless a new one is given.
+	_, swapped, err := db.CmpAndSwap(bucket, []byte("a"), []byte("1"), []byte("2"))
+	assert.FatalError(t, err)
+	assert.True(t, swapped)
+	assert.True(t, expiry("a") >= hour)
+	_, swapped, err = db.CmpAndSwapWithTTL(bucket, []byte("b"), []byte("1"), []byte("2"), 2*time.Hour)
+	assert.FatalError(t, err)
+	assert.True(t, swapped)
+	assert.True(t, expiry("b") > hour)
+
+	tx := new(database.Tx)
+	tx.Set(bucket, []byte("c"), []byte("1"))
+	tx.Operations = append(tx.Operations, &database.TxEntry{
+		Bucket: bucket, Key: []byte("d"), Value: []byte("1"), Cmd: database.CmpAndSwap,
+	})
+	assert.FatalError(t, db.UpdateWithTTL(tx, time.Hour))
+	assert.True(t, tx.Operations[1].Swapped)
+	assert.True(t, expiry("c") >= hour)
+	assert.True(t, expiry("d") >= hour)
+
+	// A set without a ttl removes the expiration.
+	assert.FatalError(t, db.Set(bucket, []byte("a"), []byte("3")))
+	assert.Equals(t, uint64(0), expiry("a"))
+
+	// Expired keys are not returned.
+	bk, err := toBadgerKey(bucket, []byte("e"))
+	assert.FatalError(t, err)
+	assert.FatalError(t, db.db.update(func(t txn) error {
+		return t.set(bk, []byte("1"), uint64(time.Now().Add(-time.Minute).Unix()))
+	}))
+	_, err = db.Get(bucket, []byte("e"))
+	assert.True(t, database.IsErrNotFound(err))
+	entries, err := db.List(bucket)
+	assert.FatalError(t, err)
+	assert.Len(t, 4, entries)
+}
+
+func TestBadgerKey(t *testing.T) {
+	bk, err := toBadgerKey([]byte("bucket"), []byte("key"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("\x06\x00bucket\x03\x00key"), bk)
+	assert.False(t, isBadgerTable(bk))
+
+	bucket, key, err := fromBadgerKey(bk)
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("bucket"), bucket)
+	assert.Equals(t, []byte("key"), key)
+
+	table, err := badgerEncode([]byte("bucket"))
+	assert.FatalError(t, err)
+	assert.True(t, isBadgerTable(table))
+	_, _, err = fromBadgerKey(table)
+	assert.NotNil(t, err)
+
+	_, err = badgerEncode(make([]byte, 65536))
+	assert.NotNil(t, err)
+}
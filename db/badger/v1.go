@@ -0,0 +1,136 @@
+package badger
+
+import (
+	"strings"
+
+	badgerv1 "github.com/dgraph-io/badger"
+	"github.com/dgraph-io/badger/options"
+	"github.com/pkg/errors"
+	"github.com/smallstep/nosql/database"
+)
+
+// storeV1 is the store implemented with Badger v1.
+type storeV1 struct {
+	db *badgerv1.DB
+}
+
+func openV1(dir string, opts *database.Options, o *Options) (store, error) {
+	bo := badgerv1.DefaultOptions
+	bo.Dir = dir
+	bo.ValueDir = dir
+	if opts.ValueDir != "" {
+		bo.ValueDir = opts.ValueDir
+	}
+
+	// Set the Table and Value LoadingMode - default is MemoryMap. Low memory/RAM
+	// systems may want to use FileIO.
+	switch strings.ToLower(opts.BadgerFileLoadingMode) {
+	case "", database.BadgerMemoryMap, "memorymap":
+		bo.TableLoadingMode = options.MemoryMap
+		bo.ValueLogLoadingMode = options.MemoryMap
+	case database.BadgerFileIO:
+		bo.TableLoadingMode = options.FileIO
+		bo.ValueLogLoadingMode = options.FileIO
+	default:
+		return nil, badgerv1.ErrInvalidLoadingMode
+	}
+
+	switch strings.ToLower(o.Compression) {
+	case "", "none":
+	default:
+		return nil, errors.Errorf("badger compression %s requires badgerv2", o.Compression)
+	}
+	if o.NumMemtables > 0 {
+		bo.NumMemtables = o.NumMemtables
+	}
+	if o.MaxTableSize > 0 {
+		bo.MaxTableSize = o.MaxTableSize
+	}
+	if o.ValueLogFileSize > 0 {
+		bo.ValueLogFileSize = o.ValueLogFileSize
+	}
+
+	db, err := badgerv1.Open(bo)
+	if err != nil {
+		return nil, err
+	}
+	return &storeV1{db: db}, nil
+}
+
+func (s *storeV1) view(fn func(t txn) error) error {
+	return s.db.View(func(t *badgerv1.Txn) error {
+		return fn(&txnV1{t})
+	})
+}
+
+func (s *storeV1) update(fn func(t txn) error) error {
+	return s.db.Update(func(t *badgerv1.Txn) error {
+		return fn(&txnV1{t})
+	})
+}
+
+func (s *storeV1) runValueLogGC(discardRatio float64) (bool, error) {
+	switch err := s.db.RunValueLogGC(discardRatio); err {
+	case nil:
+		return true, nil
+	case badgerv1.ErrNoRewrite:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func (s *storeV1) close() error {
+	return s.db.Close()
+}
+
+// txnV1 is the txn implemented with Badger v1.
+type txnV1 struct {
+	txn *badgerv1.Txn
+}
+
+func (t *txnV1) get(key []byte) ([]byte, uint64, error) {
+	item, err := t.txn.Get(key)
+	switch {
+	case err == badgerv1.ErrKeyNotFound:
+		return nil, 0, errors.Wrapf(database.ErrNotFound, "key %s not found", key)
+	case err != nil:
+		return nil, 0, errors.Wrapf(err, "failed to get key %s", key)
+	default:
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "error accessing value returned by database")
+		}
+		return val, item.ExpiresAt(), nil
+	}
+}
+
+func (t *txnV1) set(key, value []byte, expiresAt uint64) error {
+	return t.txn.SetEntry(&badgerv1.Entry{Key: key, Value: value, ExpiresAt: expiresAt})
+}
+
+func (t *txnV1) delete(key []byte) error {
+	return t.txn.Delete(key)
+}
+
+func (t *txnV1) iterate(prefix []byte, withValues bool, fn func(key, value []byte) error) error {
+	opts := badgerv1.DefaultIteratorOptions
+	opts.PrefetchValues = withValues
+	it := t.txn.NewIterator(opts)
+	defer it.Close()
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+		var value []byte
+		if withValues {
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return errors.Wrap(err, "error retrieving contents from database value")
+			}
+			value = v
+		}
+		if err := fn(item.KeyCopy(nil), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
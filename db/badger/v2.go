@@ -0,0 +1,140 @@
+package badger
+
+import (
+	"strings"
+
+	badgerv2 "github.com/dgraph-io/badger/v2"
+	"github.com/dgraph-io/badger/v2/options"
+	"github.com/pkg/errors"
+	"github.com/smallstep/nosql/database"
+)
+
+// storeV2 is the store implemented with Badger v2.
+type storeV2 struct {
+	db *badgerv2.DB
+}
+
+func openV2(dir string, opts *database.Options, o *Options) (store, error) {
+	bo := badgerv2.DefaultOptions(dir)
+	if opts.ValueDir != "" {
+		bo.ValueDir = opts.ValueDir
+	}
+
+	// Set the ValueLogLoadingMode - default is MemoryMap. Low memory/RAM
+	// systems may want to use FileIO.
+	switch strings.ToLower(opts.BadgerFileLoadingMode) {
+	case "", database.BadgerMemoryMap, "memorymap":
+		bo.ValueLogLoadingMode = options.MemoryMap
+	case database.BadgerFileIO:
+		bo.ValueLogLoadingMode = options.FileIO
+	default:
+		return nil, badgerv2.ErrInvalidLoadingMode
+	}
+
+	switch strings.ToLower(o.Compression) {
+	case "":
+	case "none":
+		bo.Compression = options.None
+	case "snappy":
+		bo.Compression = options.Snappy
+	case "zstd":
+		bo.Compression = options.ZSTD
+	default:
+		return nil, errors.Errorf("badger compression %s is not supported", o.Compression)
+	}
+	if o.NumMemtables > 0 {
+		bo.NumMemtables = o.NumMemtables
+	}
+	if o.MaxTableSize > 0 {
+		bo.MaxTableSize = o.MaxTableSize
+	}
+	if o.ValueLogFileSize > 0 {
+		bo.ValueLogFileSize = o.ValueLogFileSize
+	}
+
+	db, err := badgerv2.Open(bo)
+	if err != nil {
+		return nil, err
+	}
+	return &storeV2{db: db}, nil
+}
+
+func (s *storeV2) view(fn func(t txn) error) error {
+	return s.db.View(func(t *badgerv2.Txn) error {
+		return fn(&txnV2{t})
+	})
+}
+
+func (s *storeV2) update(fn func(t txn) error) error {
+	return s.db.Update(func(t *badgerv2.Txn) error {
+		return fn(&txnV2{t})
+	})
+}
+
+func (s *storeV2) runValueLogGC(discardRatio float64) (bool, error) {
+	switch err := s.db.RunValueLogGC(discardRatio); err {
+	case nil:
+		return true, nil
+	case badgerv2.ErrNoRewrite:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func (s *storeV2) close() error {
+	return s.db.Close()
+}
+
+// txnV2 is the txn implemented with Badger v2.
+type txnV2 struct {
+	txn *badgerv2.Txn
+}
+
+func (t *txnV2) get(key []byte) ([]byte, uint64, error) {
+	item, err := t.txn.Get(key)
+	switch {
+	case err == badgerv2.ErrKeyNotFound:
+		return nil, 0, errors.Wrapf(database.ErrNotFound, "key %s not found", key)
+	case err != nil:
+		return nil, 0, errors.Wrapf(err, "failed to get key %s", key)
+	default:
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "error accessing value returned by database")
+		}
+		return val, item.ExpiresAt(), nil
+	}
+}
+
+func (t *txnV2) set(key, value []byte, expiresAt uint64) error {
+	e := badgerv2.NewEntry(key, value)
+	e.ExpiresAt = expiresAt
+	return t.txn.SetEntry(e)
+}
+
+func (t *txnV2) delete(key []byte) error {
+	return t.txn.Delete(key)
+}
+
+func (t *txnV2) iterate(prefix []byte, withValues bool, fn func(key, value []byte) error) error {
+	opts := badgerv2.DefaultIteratorOptions
+	opts.PrefetchValues = withValues
+	it := t.txn.NewIterator(opts)
+	defer it.Close()
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+		var value []byte
+		if withValues {
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return errors.Wrap(err, "error retrieving contents from database value")
+			}
+			value = v
+		}
+		if err := fn(item.KeyCopy(nil), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
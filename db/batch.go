@@ -0,0 +1,67 @@
+package db
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/nosql"
+)
+
+// maxConcurrentReads is the maximum number of concurrent reads used by
+// BatchGet with the databases that do not implement BatchDB.
+const maxConcurrentReads = 8
+
+// BatchDB is an optional interface implemented by the databases that can read
+// multiple keys of a bucket in a single operation. BatchGet returns the values
+// in the same order as the keys, with a nil value for the keys not found.
+type BatchDB interface {
+	BatchGet(bucket []byte, keys [][]byte) ([][]byte, error)
+}
+
+// BatchGet returns the values of the given keys in the bucket, with a nil
+// value for the keys not found. If the database does not implement BatchDB,
+// the keys are read with concurrent Get calls.
+func BatchGet(db nosql.DB, bucket []byte, keys [][]byte) ([][]byte, error) {
+	if b, ok := db.(BatchDB); ok {
+		values, err := b.BatchGet(bucket, keys)
+		if err == nil && len(values) != len(keys) {
+			return nil, errors.Errorf("got %d values, expected %d", len(values), len(keys))
+		}
+		return values, err
+	}
+
+	values := make([][]byte, len(keys))
+	errs := make([]error, len(keys))
+	sem := make(chan struct{}, maxConcurrentReads)
+	var wg sync.WaitGroup
+	for i := range keys {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			b, err := db.Get(bucket, keys[i])
+			switch {
+			case nosql.IsErrNotFound(err):
+			case err != nil:
+				errs[i] = errors.Wrapf(err, "error getting %s/%s", bucket, keys[i])
+			default:
+				values[i] = b
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// BatchGet implements the BatchDB interface.
+func (db *DB) BatchGet(bucket []byte, keys [][]byte) ([][]byte, error) {
+	return BatchGet(db.DB, bucket, keys)
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/nosql/database"
+)
+
+// mockBatchDB is a MockNoSQLDB that implements the BatchDB interface.
+type mockBatchDB struct {
+	MockNoSQLDB
+	batchGet func(bucket []byte, keys [][]byte) ([][]byte, error)
+}
+
+func (m *mockBatchDB) BatchGet(bucket []byte, keys [][]byte) ([][]byte, error) {
+	return m.batchGet(bucket, keys)
+}
+
+func TestBatchGet(t *testing.T) {
+	bucket := []byte("bucket")
+	keys := [][]byte{[]byte("foo"), []byte("bar"), []byte("baz")}
+	want := [][]byte{[]byte("foo"), nil, []byte("baz")}
+	get := func(b, key []byte) ([]byte, error) {
+		assert.Equals(t, bucket, b)
+		if string(key) == "bar" {
+			return nil, database.ErrNotFound
+		}
+		return key, nil
+	}
+
+	// Databases without BatchDB fall back to Get.
+	values, err := BatchGet(&MockNoSQLDB{MGet: get}, bucket, keys)
+	assert.FatalError(t, err)
+	assert.Equals(t, want, values)
+
+	_, err = BatchGet(&MockNoSQLDB{MGet: func(b, key []byte) ([]byte, error) {
+		if string(key) == "baz" {
+			return nil, errors.New("force")
+		}
+		return key, nil
+	}}, bucket, keys)
+	assert.Equals(t, "error getting bucket/baz: force", err.Error())
+
+	var batches int
+	bdb := &mockBatchDB{batchGet: func(b []byte, k [][]byte) ([][]byte, error) {
+		batches++
+		assert.Equals(t, bucket, b)
+		assert.Equals(t, keys, k)
+		return want, nil
+	}}
+	values, err = BatchGet(bdb, bucket, keys)
+	assert.FatalError(t, err)
+	assert.Equals(t, want, values)
+	assert.Equals(t, 1, batches)
+
+	// The wrappers use the BatchGet of the database.
+	values, err = BatchGet(&DB{&metricsDB{DB: newMigrationDB(bdb, nil)}, true}, bucket, keys)
+	assert.FatalError(t, err)
+	assert.Equals(t, want, values)
+	assert.Equals(t, 2, batches)
+
+	bdb.batchGet = func(b []byte, k [][]byte) ([][]byte, error) {
+		return k[:1], nil
+	}
+	_, err = BatchGet(bdb, bucket, keys)
+	assert.Equals(t, "got 1 values, expected 3", err.Error())
+}
+
+func TestBatchGet_encrypted(t *testing.T) {
+	bucket := []byte("bucket")
+	kv := map[string][]byte{}
+	edb, err := newEncryptedDBWithKey(&MockNoSQLDB{
+		MSet: func(b, key, value []byte) error {
+			kv[string(key)] = value
+			return nil
+		},
+		MGet: func(b, key []byte) ([]byte, error) {
+			if v, ok := kv[string(key)]; ok {
+				return v, nil
+			}
+			return nil, database.ErrNotFound
+		},
+	}, bytes.Repeat([]byte{1}, 32))
+	assert.FatalError(t, err)
+	assert.FatalError(t, edb.Set(bucket, []byte("foo"), []byte("secret")))
+	assert.False(t, bytes.Equal([]byte("secret"), kv["foo"]))
+
+	values, err := edb.BatchGet(bucket, [][]byte{[]byte("foo"), []byte("bar")})
+	assert.FatalError(t, err)
+	assert.Equals(t, [][]byte{[]byte("secret"), nil}, values)
+}
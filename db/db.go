@@ -1,13 +1,18 @@
 package db
 
 import (
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/db/badger"
+	"github.com/smallstep/certificates/db/etcd"
+	"github.com/smallstep/cli/jose"
 	"github.com/smallstep/nosql"
 	"github.com/smallstep/nosql/database"
 	"golang.org/x/crypto/ssh"
@@ -22,12 +27,20 @@ var (
 	sshHostsTable          = []byte("ssh_hosts")
 	sshUsersTable          = []byte("ssh_users")
 	sshHostPrincipalsTable = []byte("ssh_host_principals")
+	provisionersTable      = []byte("provisioners")
+	policiesTable          = []byte("policies")
 )
 
+// authorityPolicyKey is the key used to store the authority policy.
+var authorityPolicyKey = []byte("authority")
+
 // ErrAlreadyExists can be returned if the DB attempts to set a key that has
 // been previously set.
 var ErrAlreadyExists = errors.New("already exists")
 
+// ErrNotFound is returned if the requested key does not exist in the DB.
+var ErrNotFound = errors.New("not found")
+
 // Config represents the JSON attributes used for configuring a step-ca DB.
 type Config struct {
 	Type       string `json:"type"`
@@ -39,6 +52,27 @@ type Config struct {
 	// 'MemoryMap') to avoid memory-mapping log files. This can be useful
 	// in environments with low RAM
 	BadgerFileLoadingMode string `json:"badgerFileLoadingMode"`
+
+	// Badger contains the tuning options of a Badger database, including the
+	// frequency of the value log garbage collection.
+	Badger *badger.Options `json:"badger,omitempty"`
+
+	// Etcd contains the TLS options used to connect to an etcd database.
+	Etcd *etcd.Options `json:"etcd,omitempty"`
+
+	// Encryption enables the encryption of the stored values with a data key
+	// wrapped by a KMS.
+	Encryption *EncryptionConfig `json:"encryption,omitempty"`
+
+	// MigrateFrom is the database the CA is migrating from. While it's set,
+	// the CA reads from it and writes to both databases, and copies its data
+	// to this database in the background.
+	MigrateFrom *Config `json:"migrateFrom,omitempty"`
+
+	// SlowOperationThreshold is the duration, e.g. 100ms, after which a
+	// database operation is logged as slow. Slow operations are not logged if
+	// it's not set.
+	SlowOperationThreshold string `json:"slowOperationThreshold,omitempty"`
 }
 
 // AuthDB is an interface over an Authority DB client that implements a nosql.DB interface.
@@ -48,10 +82,22 @@ type AuthDB interface {
 	Revoke(rci *RevokedCertificateInfo) error
 	RevokeSSH(rci *RevokedCertificateInfo) error
 	StoreCertificate(crt *x509.Certificate) error
+	GetCertificate(serialNumber string) (*x509.Certificate, error)
+	GetCertificateByFingerprint(fingerprint string) (*x509.Certificate, error)
+	GetRevokedCertificate(serialNumber string) (*RevokedCertificateInfo, error)
 	UseToken(id, tok string) (bool, error)
+	PurgeUsedTokens(before time.Time) (int, error)
 	IsSSHHost(name string) (bool, error)
 	StoreSSHCertificate(crt *ssh.Certificate) error
 	GetSSHHostPrincipals() ([]string, error)
+	GetRevokedCertificates() ([]*RevokedCertificateInfo, error)
+	GetRevokedSSHCertificates() ([]*RevokedCertificateInfo, error)
+	GetProvisioners() ([]json.RawMessage, error)
+	StoreProvisioner(id string, data json.RawMessage) error
+	DeleteProvisioner(id string) error
+	GetAuthorityPolicy() (json.RawMessage, error)
+	StoreAuthorityPolicy(data json.RawMessage) error
+	DeleteAuthorityPolicy() error
 	Shutdown() error
 }
 
@@ -66,6 +112,37 @@ func New(c *Config) (AuthDB, error) {
 	if c == nil {
 		return newSimpleDB(c)
 	}
+	slowThreshold, err := parseSlowOperationThreshold(c)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := openConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	if c.MigrateFrom != nil {
+		src, err := openConfig(c.MigrateFrom)
+		if err == nil && c.MigrateFrom.MigrateFrom != nil {
+			src.Close()
+			err = errors.New("it cannot define another migration")
+		}
+		if err != nil {
+			db.Close()
+			return nil, errors.Wrap(err, "error opening the database to migrate from")
+		}
+		db = newMigrationDB(src, db)
+	}
+
+	return &DB{&metricsDB{DB: db, slowThreshold: slowThreshold}, true}, nil
+}
+
+// openConfig opens the database in the given configuration and creates the
+// tables of the CA.
+func openConfig(c *Config) (nosql.DB, error) {
+	if err := c.Encryption.Validate(); err != nil {
+		return nil, err
+	}
 
 	opts := []nosql.Option{nosql.WithDatabase(c.Database),
 		nosql.WithValueDir(c.ValueDir)}
@@ -73,24 +150,47 @@ func New(c *Config) (AuthDB, error) {
 		opts = append(opts, nosql.WithBadgerFileLoadingMode(c.BadgerFileLoadingMode))
 	}
 
-	db, err := nosql.New(c.Type, c.DataSource, opts...)
+	db, err := open(c, opts...)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Error opening database of Type %s with source %s", c.Type, c.DataSource)
 	}
 
-	tables := [][]byte{
-		revokedCertsTable, certsTable, usedOTTTable,
-		sshCertsTable, sshHostsTable, sshHostPrincipalsTable, sshUsersTable,
-		revokedSSHCertsTable,
-	}
-	for _, b := range tables {
+	for _, b := range append(Tables(), healthTable) {
 		if err := db.CreateTable(b); err != nil {
 			return nil, errors.Wrapf(err, "error creating table %s",
 				string(b))
 		}
 	}
 
-	return &DB{db, true}, nil
+	if c.Encryption != nil {
+		edb, err := newEncryptedDB(db, c.Encryption)
+		if err != nil {
+			db.Close()
+			return nil, errors.Wrap(err, "error initializing database encryption")
+		}
+		db = edb
+	}
+	return db, nil
+}
+
+// open returns the nosql database of the given type. The Badger and etcd
+// databases are implemented by this package, the rest are provided by nosql.
+func open(c *Config, opts ...nosql.Option) (nosql.DB, error) {
+	var db nosql.DB
+	switch strings.ToLower(c.Type) {
+	case nosql.BadgerDriver, nosql.BadgerV1Driver:
+		db = &badger.DB{Version: 1, Options: c.Badger}
+	case nosql.BadgerV2Driver:
+		db = &badger.DB{Version: 2, Options: c.Badger}
+	case etcd.DriverName:
+		db = &etcd.DB{Options: c.Etcd}
+	default:
+		return nosql.New(c.Type, c.DataSource, opts...)
+	}
+	if err := db.Open(c.DataSource, opts...); err != nil {
+		return nil, err
+	}
+	return db, nil
 }
 
 // RevokedCertificateInfo contains information regarding the certificate
@@ -103,6 +203,9 @@ type RevokedCertificateInfo struct {
 	RevokedAt     time.Time
 	TokenID       string
 	MTLS          bool
+	// AuthorityKeyID is the authority key identifier of the certificate, if
+	// it was known when it was revoked.
+	AuthorityKeyID []byte `json:",omitempty"`
 }
 
 // IsRevoked returns whether or not a certificate with the given identifier
@@ -195,10 +298,136 @@ func (db *DB) StoreCertificate(crt *x509.Certificate) error {
 	return nil
 }
 
+// GetCertificate returns the stored certificate with the given serial number.
+// It returns ErrNotFound if the certificate does not exist.
+func (db *DB) GetCertificate(serialNumber string) (*x509.Certificate, error) {
+	b, err := db.Get(certsTable, []byte(serialNumber))
+	if err != nil {
+		if nosql.IsErrNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "database Get error")
+	}
+	crt, err := x509.ParseCertificate(b)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing certificate %s", serialNumber)
+	}
+	return crt, nil
+}
+
+// GetCertificateByFingerprint returns the stored certificate with the given
+// hex encoded SHA-256 fingerprint. Certificates are indexed by serial number,
+// so this method iterates over all of them. It returns ErrNotFound if the
+// certificate does not exist.
+func (db *DB) GetCertificateByFingerprint(fingerprint string) (*x509.Certificate, error) {
+	entries, err := db.List(certsTable)
+	if err != nil {
+		return nil, errors.Wrap(err, "database List error")
+	}
+	fingerprint = strings.ToLower(fingerprint)
+	for _, e := range entries {
+		sum := sha256.Sum256(e.Value)
+		if hex.EncodeToString(sum[:]) == fingerprint {
+			crt, err := x509.ParseCertificate(e.Value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error parsing certificate %s", e.Key)
+			}
+			return crt, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// GetRevokedCertificate returns the revocation information of the certificate
+// with the given serial number. It returns ErrNotFound if the certificate has
+// not been revoked.
+func (db *DB) GetRevokedCertificate(serialNumber string) (*RevokedCertificateInfo, error) {
+	b, err := db.Get(revokedCertsTable, []byte(serialNumber))
+	if err != nil {
+		if nosql.IsErrNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "database Get error")
+	}
+	rci := new(RevokedCertificateInfo)
+	if err := json.Unmarshal(b, rci); err != nil {
+		return nil, errors.Wrapf(err, "error unmarshaling revoked certificate info %s", serialNumber)
+	}
+	return rci, nil
+}
+
+// usedToken is the value stored for every used token. ExpiresAt is the
+// expiration of the token in seconds since the epoch, or 0 if it is not
+// known. The entries of expired tokens can be deleted with PurgeUsedTokens.
+type usedToken struct {
+	UsedAt    int64 `json:"ua,omitempty"`
+	ExpiresAt int64 `json:"exp,omitempty"`
+}
+
+func newUsedToken(tok string) *usedToken {
+	return &usedToken{
+		UsedAt:    time.Now().Unix(),
+		ExpiresAt: tokenExpiry(tok),
+	}
+}
+
+// expired returns if the token expired before the given time.
+func (t *usedToken) expired(before time.Time) bool {
+	return t.ExpiresAt > 0 && t.ExpiresAt < before.Unix()
+}
+
+// ttl returns the time the token must be kept in the database, or 0 if it
+// must be kept forever.
+func (t *usedToken) ttl() time.Duration {
+	if t.ExpiresAt == 0 {
+		return 0
+	}
+	if d := time.Until(time.Unix(t.ExpiresAt, 0)) + usedTokenLeeway; d > 0 {
+		return d
+	}
+	return time.Second
+}
+
+// tokenExpiry returns the expiration of the given token in seconds since the
+// epoch, or 0 if the token is not a JWT or it does not expire. The token is
+// already validated by the provisioner.
+func tokenExpiry(tok string) int64 {
+	jwt, err := jose.ParseSigned(tok)
+	if err != nil {
+		return 0
+	}
+	var claims jose.Claims
+	if err := jwt.UnsafeClaimsWithoutVerification(&claims); err != nil || claims.Expiry == nil {
+		return 0
+	}
+	return claims.Expiry.Time().Unix()
+}
+
+// parseUsedToken parses the value of the used tokens table. Old versions
+// stored the token itself instead of the JSON representation of a usedToken.
+func parseUsedToken(b []byte) *usedToken {
+	t := new(usedToken)
+	if err := json.Unmarshal(b, t); err != nil {
+		return &usedToken{ExpiresAt: tokenExpiry(string(b))}
+	}
+	return t
+}
+
+// usedTokenLeeway is the time a used token is kept in the database after its
+// expiration, it covers the clock skew allowed when tokens are validated.
+const usedTokenLeeway = 10 * time.Minute
+
 // UseToken returns true if we were able to successfully store the token for
-// for the first time, false otherwise.
+// for the first time, false otherwise. The token is stored with its
+// expiration so it cannot be reused after a restart, until it is purged. If
+// the database supports it, the token also expires from the database.
 func (db *DB) UseToken(id, tok string) (bool, error) {
-	_, swapped, err := db.CmpAndSwap(usedOTTTable, []byte(id), nil, []byte(tok))
+	ut := newUsedToken(tok)
+	b, err := json.Marshal(ut)
+	if err != nil {
+		return false, errors.Wrap(err, "error marshaling used token")
+	}
+	_, swapped, err := CmpAndSwapWithTTL(db.DB, usedOTTTable, []byte(id), nil, b, ut.ttl())
 	if err != nil {
 		return false, errors.Wrapf(err, "error storing used token %s/%s",
 			string(usedOTTTable), id)
@@ -206,6 +435,28 @@ func (db *DB) UseToken(id, tok string) (bool, error) {
 	return swapped, nil
 }
 
+// PurgeUsedTokens deletes the used tokens that expired before the given time,
+// and returns the number of deleted tokens. Tokens without an expiration are
+// never deleted.
+func (db *DB) PurgeUsedTokens(before time.Time) (int, error) {
+	entries, err := db.List(usedOTTTable)
+	if err != nil {
+		return 0, errors.Wrap(err, "database List error")
+	}
+	var n int
+	for _, e := range entries {
+		if !parseUsedToken(e.Value).expired(before) {
+			continue
+		}
+		if err := db.Del(usedOTTTable, e.Key); err != nil {
+			return n, errors.Wrapf(err, "error deleting used token %s/%s",
+				string(usedOTTTable), e.Key)
+		}
+		n++
+	}
+	return n, nil
+}
+
 // IsSSHHost returns if a principal is present in the ssh hosts table.
 func (db *DB) IsSSHHost(principal string) (bool, error) {
 	if _, err := db.Get(sshHostsTable, []byte(strings.ToLower(principal))); err != nil {
@@ -269,6 +520,109 @@ func (db *DB) GetSSHHostPrincipals() ([]string, error) {
 	return principals, nil
 }
 
+// GetRevokedCertificates returns the information of all the revoked X.509
+// certificates.
+func (db *DB) GetRevokedCertificates() ([]*RevokedCertificateInfo, error) {
+	return db.listRevoked(revokedCertsTable)
+}
+
+// GetRevokedSSHCertificates returns the information of all the revoked SSH
+// certificates.
+func (db *DB) GetRevokedSSHCertificates() ([]*RevokedCertificateInfo, error) {
+	return db.listRevoked(revokedSSHCertsTable)
+}
+
+func (db *DB) listRevoked(table []byte) ([]*RevokedCertificateInfo, error) {
+	entries, err := db.List(table)
+	if err != nil {
+		return nil, errors.Wrap(err, "database List error")
+	}
+	revoked := make([]*RevokedCertificateInfo, 0, len(entries))
+	for _, e := range entries {
+		rci := new(RevokedCertificateInfo)
+		if err := json.Unmarshal(e.Value, rci); err != nil {
+			return nil, errors.Wrapf(err, "error unmarshaling revoked certificate info %s", e.Key)
+		}
+		revoked = append(revoked, rci)
+	}
+	return revoked, nil
+}
+
+// GetProvisioners returns the JSON representation of all the provisioners
+// stored in the database.
+func (db *DB) GetProvisioners() ([]json.RawMessage, error) {
+	entries, err := db.List(provisionersTable)
+	if err != nil {
+		return nil, errors.Wrap(err, "database List error")
+	}
+	provisioners := make([]json.RawMessage, 0, len(entries))
+	for _, e := range entries {
+		provisioners = append(provisioners, json.RawMessage(e.Value))
+	}
+	return provisioners, nil
+}
+
+// StoreProvisioner stores the JSON representation of a provisioner with the
+// given id. If the provisioner already exists it will be replaced.
+func (db *DB) StoreProvisioner(id string, data json.RawMessage) error {
+	if err := db.Set(provisionersTable, []byte(id), data); err != nil {
+		return errors.Wrap(err, "database Set error")
+	}
+	return nil
+}
+
+// DeleteProvisioner removes the provisioner with the given id from the
+// database. It returns ErrNotFound if the provisioner does not exist.
+func (db *DB) DeleteProvisioner(id string) error {
+	if _, err := db.Get(provisionersTable, []byte(id)); err != nil {
+		if nosql.IsErrNotFound(err) {
+			return ErrNotFound
+		}
+		return errors.Wrap(err, "database Get error")
+	}
+	if err := db.Del(provisionersTable, []byte(id)); err != nil {
+		return errors.Wrap(err, "database Del error")
+	}
+	return nil
+}
+
+// GetAuthorityPolicy returns the JSON representation of the authority policy
+// stored in the database. It returns ErrNotFound if the policy does not exist.
+func (db *DB) GetAuthorityPolicy() (json.RawMessage, error) {
+	b, err := db.Get(policiesTable, authorityPolicyKey)
+	if err != nil {
+		if nosql.IsErrNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "database Get error")
+	}
+	return json.RawMessage(b), nil
+}
+
+// StoreAuthorityPolicy stores the JSON representation of the authority policy.
+// If the policy already exists it will be replaced.
+func (db *DB) StoreAuthorityPolicy(data json.RawMessage) error {
+	if err := db.Set(policiesTable, authorityPolicyKey, data); err != nil {
+		return errors.Wrap(err, "database Set error")
+	}
+	return nil
+}
+
+// DeleteAuthorityPolicy removes the authority policy from the database. It
+// returns ErrNotFound if the policy does not exist.
+func (db *DB) DeleteAuthorityPolicy() error {
+	if _, err := db.Get(policiesTable, authorityPolicyKey); err != nil {
+		if nosql.IsErrNotFound(err) {
+			return ErrNotFound
+		}
+		return errors.Wrap(err, "database Get error")
+	}
+	if err := db.Del(policiesTable, authorityPolicyKey); err != nil {
+		return errors.Wrap(err, "database Del error")
+	}
+	return nil
+}
+
 // Shutdown sends a shutdown message to the database.
 func (db *DB) Shutdown() error {
 	if db.isUp {
@@ -282,18 +636,30 @@ func (db *DB) Shutdown() error {
 
 // MockAuthDB mocks the AuthDB interface. //
 type MockAuthDB struct {
-	Err                   error
-	Ret1                  interface{}
-	MIsRevoked            func(string) (bool, error)
-	MIsSSHRevoked         func(string) (bool, error)
-	MRevoke               func(rci *RevokedCertificateInfo) error
-	MRevokeSSH            func(rci *RevokedCertificateInfo) error
-	MStoreCertificate     func(crt *x509.Certificate) error
-	MUseToken             func(id, tok string) (bool, error)
-	MIsSSHHost            func(principal string) (bool, error)
-	MStoreSSHCertificate  func(crt *ssh.Certificate) error
-	MGetSSHHostPrincipals func() ([]string, error)
-	MShutdown             func() error
+	Err                          error
+	Ret1                         interface{}
+	MIsRevoked                   func(string) (bool, error)
+	MIsSSHRevoked                func(string) (bool, error)
+	MRevoke                      func(rci *RevokedCertificateInfo) error
+	MRevokeSSH                   func(rci *RevokedCertificateInfo) error
+	MStoreCertificate            func(crt *x509.Certificate) error
+	MGetCertificate              func(serialNumber string) (*x509.Certificate, error)
+	MGetCertificateByFingerprint func(fingerprint string) (*x509.Certificate, error)
+	MGetRevokedCertificate       func(serialNumber string) (*RevokedCertificateInfo, error)
+	MUseToken                    func(id, tok string) (bool, error)
+	MPurgeUsedTokens             func(before time.Time) (int, error)
+	MIsSSHHost                   func(principal string) (bool, error)
+	MStoreSSHCertificate         func(crt *ssh.Certificate) error
+	MGetSSHHostPrincipals        func() ([]string, error)
+	MGetRevokedCertificates      func() ([]*RevokedCertificateInfo, error)
+	MGetRevokedSSHCertificates   func() ([]*RevokedCertificateInfo, error)
+	MGetProvisioners             func() ([]json.RawMessage, error)
+	MStoreProvisioner            func(id string, data json.RawMessage) error
+	MDeleteProvisioner           func(id string) error
+	MGetAuthorityPolicy          func() (json.RawMessage, error)
+	MStoreAuthorityPolicy        func(data json.RawMessage) error
+	MDeleteAuthorityPolicy       func() error
+	MShutdown                    func() error
 }
 
 // IsRevoked mock.
@@ -323,6 +689,17 @@ func (m *MockAuthDB) UseToken(id, tok string) (bool, error) {
 	return m.Ret1.(bool), m.Err
 }
 
+// PurgeUsedTokens mock.
+func (m *MockAuthDB) PurgeUsedTokens(before time.Time) (int, error) {
+	if m.MPurgeUsedTokens != nil {
+		return m.MPurgeUsedTokens(before)
+	}
+	if m.Ret1 == nil {
+		return 0, m.Err
+	}
+	return m.Ret1.(int), m.Err
+}
+
 // Revoke mock.
 func (m *MockAuthDB) Revoke(rci *RevokedCertificateInfo) error {
 	if m.MRevoke != nil {
@@ -347,6 +724,39 @@ func (m *MockAuthDB) StoreCertificate(crt *x509.Certificate) error {
 	return m.Err
 }
 
+// GetCertificate mock.
+func (m *MockAuthDB) GetCertificate(serialNumber string) (*x509.Certificate, error) {
+	if m.MGetCertificate != nil {
+		return m.MGetCertificate(serialNumber)
+	}
+	if m.Ret1 == nil {
+		return nil, m.Err
+	}
+	return m.Ret1.(*x509.Certificate), m.Err
+}
+
+// GetCertificateByFingerprint mock.
+func (m *MockAuthDB) GetCertificateByFingerprint(fingerprint string) (*x509.Certificate, error) {
+	if m.MGetCertificateByFingerprint != nil {
+		return m.MGetCertificateByFingerprint(fingerprint)
+	}
+	if m.Ret1 == nil {
+		return nil, m.Err
+	}
+	return m.Ret1.(*x509.Certificate), m.Err
+}
+
+// GetRevokedCertificate mock.
+func (m *MockAuthDB) GetRevokedCertificate(serialNumber string) (*RevokedCertificateInfo, error) {
+	if m.MGetRevokedCertificate != nil {
+		return m.MGetRevokedCertificate(serialNumber)
+	}
+	if m.Ret1 == nil {
+		return nil, m.Err
+	}
+	return m.Ret1.(*RevokedCertificateInfo), m.Err
+}
+
 // IsSSHHost mock.
 func (m *MockAuthDB) IsSSHHost(principal string) (bool, error) {
 	if m.MIsSSHHost != nil {
@@ -371,6 +781,82 @@ func (m *MockAuthDB) GetSSHHostPrincipals() ([]string, error) {
 	return m.Ret1.([]string), m.Err
 }
 
+// GetRevokedCertificates mock.
+func (m *MockAuthDB) GetRevokedCertificates() ([]*RevokedCertificateInfo, error) {
+	if m.MGetRevokedCertificates != nil {
+		return m.MGetRevokedCertificates()
+	}
+	if m.Ret1 == nil {
+		return nil, m.Err
+	}
+	return m.Ret1.([]*RevokedCertificateInfo), m.Err
+}
+
+// GetRevokedSSHCertificates mock.
+func (m *MockAuthDB) GetRevokedSSHCertificates() ([]*RevokedCertificateInfo, error) {
+	if m.MGetRevokedSSHCertificates != nil {
+		return m.MGetRevokedSSHCertificates()
+	}
+	if m.Ret1 == nil {
+		return nil, m.Err
+	}
+	return m.Ret1.([]*RevokedCertificateInfo), m.Err
+}
+
+// GetProvisioners mock.
+func (m *MockAuthDB) GetProvisioners() ([]json.RawMessage, error) {
+	if m.MGetProvisioners != nil {
+		return m.MGetProvisioners()
+	}
+	if m.Ret1 == nil {
+		return nil, m.Err
+	}
+	return m.Ret1.([]json.RawMessage), m.Err
+}
+
+// StoreProvisioner mock.
+func (m *MockAuthDB) StoreProvisioner(id string, data json.RawMessage) error {
+	if m.MStoreProvisioner != nil {
+		return m.MStoreProvisioner(id, data)
+	}
+	return m.Err
+}
+
+// DeleteProvisioner mock.
+func (m *MockAuthDB) DeleteProvisioner(id string) error {
+	if m.MDeleteProvisioner != nil {
+		return m.MDeleteProvisioner(id)
+	}
+	return m.Err
+}
+
+// GetAuthorityPolicy mock.
+func (m *MockAuthDB) GetAuthorityPolicy() (json.RawMessage, error) {
+	if m.MGetAuthorityPolicy != nil {
+		return m.MGetAuthorityPolicy()
+	}
+	if m.Ret1 == nil {
+		return nil, m.Err
+	}
+	return m.Ret1.(json.RawMessage), m.Err
+}
+
+// StoreAuthorityPolicy mock.
+func (m *MockAuthDB) StoreAuthorityPolicy(data json.RawMessage) error {
+	if m.MStoreAuthorityPolicy != nil {
+		return m.MStoreAuthorityPolicy(data)
+	}
+	return m.Err
+}
+
+// DeleteAuthorityPolicy mock.
+func (m *MockAuthDB) DeleteAuthorityPolicy() error {
+	if m.MDeleteAuthorityPolicy != nil {
+		return m.MDeleteAuthorityPolicy()
+	}
+	return m.Err
+}
+
 // Shutdown mock.
 func (m *MockAuthDB) Shutdown() error {
 	if m.MShutdown != nil {
@@ -1,10 +1,17 @@
 package db
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/smallstep/assert"
+	"github.com/smallstep/cli/crypto/pemutil"
+	"github.com/smallstep/cli/jose"
 	"github.com/smallstep/nosql/database"
 )
 
@@ -94,6 +101,480 @@ func TestRevoke(t *testing.T) {
 	}
 }
 
+func TestGetRevokedCertificates(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want []*RevokedCertificateInfo
+		err  error
+	}{
+		"error/list": {
+			db: &DB{&MockNoSQLDB{
+				MList: func(bucket []byte) ([]*database.Entry, error) {
+					return nil, errors.New("force")
+				},
+			}, true},
+			err: errors.New("database List error: force"),
+		},
+		"error/unmarshal": {
+			db: &DB{&MockNoSQLDB{
+				MList: func(bucket []byte) ([]*database.Entry, error) {
+					return []*database.Entry{{Key: []byte("sn"), Value: []byte("foo")}}, nil
+				},
+			}, true},
+			err: errors.New("error unmarshaling revoked certificate info sn"),
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{
+				MList: func(bucket []byte) ([]*database.Entry, error) {
+					assert.Equals(t, revokedCertsTable, bucket)
+					return []*database.Entry{
+						{Key: []byte("1"), Value: []byte(`{"Serial":"1","ReasonCode":1}`)},
+						{Key: []byte("2"), Value: []byte(`{"Serial":"2"}`)},
+					}, nil
+				},
+			}, true},
+			want: []*RevokedCertificateInfo{{Serial: "1", ReasonCode: 1}, {Serial: "2"}},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetRevokedCertificates()
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestGetProvisioners(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want []json.RawMessage
+		err  error
+	}{
+		"error/list": {
+			db: &DB{&MockNoSQLDB{
+				MList: func(bucket []byte) ([]*database.Entry, error) {
+					return nil, errors.New("force")
+				},
+			}, true},
+			err: errors.New("database List error: force"),
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{
+				MList: func(bucket []byte) ([]*database.Entry, error) {
+					assert.Equals(t, provisionersTable, bucket)
+					return []*database.Entry{
+						{Key: []byte("acme/foo"), Value: []byte(`{"type":"ACME","name":"foo"}`)},
+						{Key: []byte("acme/bar"), Value: []byte(`{"type":"ACME","name":"bar"}`)},
+					}, nil
+				},
+			}, true},
+			want: []json.RawMessage{
+				json.RawMessage(`{"type":"ACME","name":"foo"}`),
+				json.RawMessage(`{"type":"ACME","name":"bar"}`),
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetProvisioners()
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestStoreProvisioner(t *testing.T) {
+	tests := map[string]struct {
+		db  *DB
+		err error
+	}{
+		"error/set": {
+			db: &DB{&MockNoSQLDB{
+				MSet: func(bucket, key, value []byte) error {
+					return errors.New("force")
+				},
+			}, true},
+			err: errors.New("database Set error: force"),
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{
+				MSet: func(bucket, key, value []byte) error {
+					assert.Equals(t, provisionersTable, bucket)
+					assert.Equals(t, []byte("acme/foo"), key)
+					assert.Equals(t, []byte(`{"type":"ACME","name":"foo"}`), value)
+					return nil
+				},
+			}, true},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.db.StoreProvisioner("acme/foo", json.RawMessage(`{"type":"ACME","name":"foo"}`))
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestDeleteProvisioner(t *testing.T) {
+	tests := map[string]struct {
+		db  *DB
+		err error
+	}{
+		"error/not-found": {
+			db: &DB{&MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					return nil, database.ErrNotFound
+				},
+			}, true},
+			err: ErrNotFound,
+		},
+		"error/get": {
+			db: &DB{&MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					return nil, errors.New("force")
+				},
+			}, true},
+			err: errors.New("database Get error: force"),
+		},
+		"error/del": {
+			db: &DB{&MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					return []byte("{}"), nil
+				},
+				MDel: func(bucket, key []byte) error {
+					return errors.New("force")
+				},
+			}, true},
+			err: errors.New("database Del error: force"),
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					return []byte("{}"), nil
+				},
+				MDel: func(bucket, key []byte) error {
+					assert.Equals(t, provisionersTable, bucket)
+					assert.Equals(t, []byte("acme/foo"), key)
+					return nil
+				},
+			}, true},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.db.DeleteProvisioner("acme/foo")
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestAuthorityPolicy(t *testing.T) {
+	data := []byte(`{"x509":{"allow":{"dns":["*.example.com"]}}}`)
+	t.Run("get", func(t *testing.T) {
+		tests := map[string]struct {
+			db   *DB
+			want json.RawMessage
+			err  error
+		}{
+			"error/not-found": {
+				db: &DB{&MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, database.ErrNotFound
+					},
+				}, true},
+				err: ErrNotFound,
+			},
+			"error/get": {
+				db: &DB{&MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, errors.New("force")
+					},
+				}, true},
+				err: errors.New("database Get error: force"),
+			},
+			"ok": {
+				db: &DB{&MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, policiesTable, bucket)
+						assert.Equals(t, authorityPolicyKey, key)
+						return data, nil
+					},
+				}, true},
+				want: json.RawMessage(data),
+			},
+		}
+		for name, tc := range tests {
+			t.Run(name, func(t *testing.T) {
+				got, err := tc.db.GetAuthorityPolicy()
+				if err != nil {
+					if assert.NotNil(t, tc.err) {
+						assert.HasPrefix(t, err.Error(), tc.err.Error())
+					}
+				} else if assert.Nil(t, tc.err) {
+					assert.Equals(t, tc.want, got)
+				}
+			})
+		}
+	})
+
+	t.Run("store", func(t *testing.T) {
+		tests := map[string]struct {
+			db  *DB
+			err error
+		}{
+			"error/set": {
+				db: &DB{&MockNoSQLDB{
+					MSet: func(bucket, key, value []byte) error {
+						return errors.New("force")
+					},
+				}, true},
+				err: errors.New("database Set error: force"),
+			},
+			"ok": {
+				db: &DB{&MockNoSQLDB{
+					MSet: func(bucket, key, value []byte) error {
+						assert.Equals(t, policiesTable, bucket)
+						assert.Equals(t, authorityPolicyKey, key)
+						assert.Equals(t, data, value)
+						return nil
+					},
+				}, true},
+			},
+		}
+		for name, tc := range tests {
+			t.Run(name, func(t *testing.T) {
+				err := tc.db.StoreAuthorityPolicy(data)
+				if err != nil {
+					if assert.NotNil(t, tc.err) {
+						assert.HasPrefix(t, err.Error(), tc.err.Error())
+					}
+				} else {
+					assert.Nil(t, tc.err)
+				}
+			})
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		tests := map[string]struct {
+			db  *DB
+			err error
+		}{
+			"error/not-found": {
+				db: &DB{&MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, database.ErrNotFound
+					},
+				}, true},
+				err: ErrNotFound,
+			},
+			"error/del": {
+				db: &DB{&MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return data, nil
+					},
+					MDel: func(bucket, key []byte) error {
+						return errors.New("force")
+					},
+				}, true},
+				err: errors.New("database Del error: force"),
+			},
+			"ok": {
+				db: &DB{&MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return data, nil
+					},
+					MDel: func(bucket, key []byte) error {
+						assert.Equals(t, policiesTable, bucket)
+						assert.Equals(t, authorityPolicyKey, key)
+						return nil
+					},
+				}, true},
+			},
+		}
+		for name, tc := range tests {
+			t.Run(name, func(t *testing.T) {
+				err := tc.db.DeleteAuthorityPolicy()
+				if err != nil {
+					if assert.NotNil(t, tc.err) {
+						assert.HasPrefix(t, err.Error(), tc.err.Error())
+					}
+				} else {
+					assert.Nil(t, tc.err)
+				}
+			})
+		}
+	})
+}
+
+func TestGetCertificate(t *testing.T) {
+	crt, err := pemutil.ReadCertificate("../authority/testdata/certs/foo.crt")
+	assert.FatalError(t, err)
+	sum := sha256.Sum256(crt.Raw)
+	fingerprint := hex.EncodeToString(sum[:])
+
+	list := func(bucket []byte) ([]*database.Entry, error) {
+		assert.Equals(t, certsTable, bucket)
+		return []*database.Entry{
+			{Key: []byte("1"), Value: []byte("foo")},
+			{Key: []byte(crt.SerialNumber.String()), Value: crt.Raw},
+		}, nil
+	}
+	tests := map[string]struct {
+		db          *DB
+		serial      string
+		fingerprint string
+		want        error
+	}{
+		"error/get": {
+			db: &DB{&MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					return nil, errors.New("force")
+				},
+				MList: func(bucket []byte) ([]*database.Entry, error) {
+					return nil, errors.New("force")
+				},
+			}, true},
+			serial: "1", fingerprint: fingerprint,
+			want: errors.New("database"),
+		},
+		"error/not-found": {
+			db: &DB{&MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					return nil, database.ErrNotFound
+				},
+				MList: func(bucket []byte) ([]*database.Entry, error) {
+					return nil, nil
+				},
+			}, true},
+			serial: "1", fingerprint: fingerprint,
+			want: ErrNotFound,
+		},
+		"error/parse": {
+			db: &DB{&MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					return []byte("foo"), nil
+				},
+				MList: func(bucket []byte) ([]*database.Entry, error) {
+					return []*database.Entry{{Key: []byte("1"), Value: []byte("foo")}}, nil
+				},
+			}, true},
+			serial: "1", fingerprint: "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+			want: errors.New("error parsing certificate 1"),
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					assert.Equals(t, certsTable, bucket)
+					assert.Equals(t, crt.SerialNumber.String(), string(key))
+					return crt.Raw, nil
+				},
+				MList: list,
+			}, true},
+			serial: crt.SerialNumber.String(), fingerprint: fingerprint,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetCertificate(tc.serial)
+			if err != nil {
+				if assert.NotNil(t, tc.want) {
+					assert.HasPrefix(t, err.Error(), tc.want.Error())
+				}
+			} else if assert.Nil(t, tc.want) {
+				assert.Equals(t, crt, got)
+			}
+
+			got, err = tc.db.GetCertificateByFingerprint(tc.fingerprint)
+			if err != nil {
+				if assert.NotNil(t, tc.want) {
+					assert.HasPrefix(t, err.Error(), tc.want.Error())
+				}
+			} else if assert.Nil(t, tc.want) {
+				assert.Equals(t, crt, got)
+			}
+		})
+	}
+}
+
+func TestGetRevokedCertificate(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want *RevokedCertificateInfo
+		err  error
+	}{
+		"error/get": {
+			db: &DB{&MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					return nil, errors.New("force")
+				},
+			}, true},
+			err: errors.New("database Get error: force"),
+		},
+		"error/not-found": {
+			db: &DB{&MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					return nil, database.ErrNotFound
+				},
+			}, true},
+			err: ErrNotFound,
+		},
+		"error/unmarshal": {
+			db: &DB{&MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					return []byte("foo"), nil
+				},
+			}, true},
+			err: errors.New("error unmarshaling revoked certificate info sn"),
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					assert.Equals(t, revokedCertsTable, bucket)
+					assert.Equals(t, "sn", string(key))
+					return []byte(`{"Serial":"sn","ReasonCode":1,"Reason":"lost key"}`), nil
+				},
+			}, true},
+			want: &RevokedCertificateInfo{Serial: "sn", ReasonCode: 1, Reason: "lost key"},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetRevokedCertificate("sn")
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
 func TestUseToken(t *testing.T) {
 	type result struct {
 		err error
@@ -158,3 +639,116 @@ func TestUseToken(t *testing.T) {
 		})
 	}
 }
+
+func newTestToken(t *testing.T, exp time.Time) string {
+	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: []byte("secret")}, nil)
+	assert.FatalError(t, err)
+	tok, err := jose.Signed(sig).Claims(jose.Claims{
+		Subject: "subject",
+		Expiry:  jose.NewNumericDate(exp),
+	}).CompactSerialize()
+	assert.FatalError(t, err)
+	return tok
+}
+
+func TestUseToken_value(t *testing.T) {
+	exp := time.Now().Add(5 * time.Minute).Truncate(time.Second)
+	tok := newTestToken(t, exp)
+	db := &DB{&MockNoSQLDB{
+		MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+			assert.Equals(t, usedOTTTable, bucket)
+			assert.Equals(t, []byte("id"), key)
+			assert.Nil(t, old)
+			assert.Equals(t, exp.Unix(), parseUsedToken(newval).ExpiresAt)
+			assert.False(t, strings.Contains(string(newval), tok))
+			return nil, true, nil
+		},
+	}, true}
+	ok, err := db.UseToken("id", tok)
+	assert.FatalError(t, err)
+	assert.True(t, ok)
+}
+
+func TestPurgeUsedTokens(t *testing.T) {
+	now := time.Now()
+	expired, err := json.Marshal(&usedToken{UsedAt: now.Add(-time.Hour).Unix(), ExpiresAt: now.Add(-time.Minute).Unix()})
+	assert.FatalError(t, err)
+	valid, err := json.Marshal(&usedToken{UsedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()})
+	assert.FatalError(t, err)
+	noExpiry, err := json.Marshal(&usedToken{UsedAt: now.Unix()})
+	assert.FatalError(t, err)
+	entries := []*database.Entry{
+		{Bucket: usedOTTTable, Key: []byte("expired"), Value: expired},
+		{Bucket: usedOTTTable, Key: []byte("valid"), Value: valid},
+		{Bucket: usedOTTTable, Key: []byte("no-expiry"), Value: noExpiry},
+		{Bucket: usedOTTTable, Key: []byte("legacy-expired"), Value: []byte(newTestToken(t, now.Add(-time.Minute)))},
+		{Bucket: usedOTTTable, Key: []byte("legacy-valid"), Value: []byte(newTestToken(t, now.Add(time.Minute)))},
+		{Bucket: usedOTTTable, Key: []byte("legacy-opaque"), Value: []byte("token")},
+	}
+
+	type test struct {
+		db      *DB
+		want    int
+		deleted *[]string
+		wantDel []string
+		err     error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/list-error": func(t *testing.T) test {
+			return test{
+				db: &DB{&MockNoSQLDB{
+					MList: func(bucket []byte) ([]*database.Entry, error) {
+						return nil, errors.New("force")
+					},
+				}, true},
+				err: errors.New("database List error: force"),
+			}
+		},
+		"fail/del-error": func(t *testing.T) test {
+			return test{
+				db: &DB{&MockNoSQLDB{
+					MList: func(bucket []byte) ([]*database.Entry, error) {
+						return entries, nil
+					},
+					MDel: func(bucket, key []byte) error {
+						return errors.New("force")
+					},
+				}, true},
+				err: errors.New("error deleting used token used_ott/expired: force"),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			var deleted []string
+			return test{
+				db: &DB{&MockNoSQLDB{
+					MList: func(bucket []byte) ([]*database.Entry, error) {
+						assert.Equals(t, usedOTTTable, bucket)
+						return entries, nil
+					},
+					MDel: func(bucket, key []byte) error {
+						deleted = append(deleted, string(key))
+						return nil
+					},
+				}, true},
+				want:    2,
+				deleted: &deleted,
+				wantDel: []string{"expired", "legacy-expired"},
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			n, err := tc.db.PurgeUsedTokens(now)
+			if tc.err != nil {
+				if assert.NotNil(t, err) {
+					assert.Equals(t, tc.err.Error(), err.Error())
+				}
+				return
+			}
+			assert.FatalError(t, err)
+			assert.Equals(t, tc.want, n)
+			assert.Equals(t, tc.wantDel, *tc.deleted)
+		})
+	}
+}
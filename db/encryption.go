@@ -0,0 +1,333 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms"
+	kmsapi "github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/nosql"
+	"github.com/smallstep/nosql/database"
+)
+
+var (
+	encryptionTable = []byte("encryption")
+	dataKeyID       = []byte("dataKey")
+)
+
+// encryptedValuePrefix is the prefix of all the encrypted values, the values
+// without it were stored before the encryption was enabled.
+var encryptedValuePrefix = []byte{0x00, 'e', 'n', 'c', 0x01}
+
+// EncryptionConfig enables the encryption of the values stored in the
+// database. The values are encrypted with AES-256-GCM using a random data key
+// that is stored in the database wrapped with the RSA key Key of the KMS.
+type EncryptionConfig struct {
+	KMS *kmsapi.Options `json:"kms,omitempty"`
+	Key string          `json:"key"`
+}
+
+// Validate validates the encryption configuration.
+func (c *EncryptionConfig) Validate() error {
+	switch {
+	case c == nil:
+		return nil
+	case c.Key == "":
+		return errors.New("encryption key cannot be empty")
+	default:
+		return c.KMS.Validate()
+	}
+}
+
+// encryptedDB is a nosql.DB that encrypts the values before sending them to
+// the database and decrypts them after reading them.
+//
+// The nonce of a value is derived from its bucket, key and plaintext, so the
+// same value is always encrypted to the same ciphertext and the
+// compare-and-swap operations can compare ciphertexts. The bucket and key are
+// also authenticated so an encrypted value cannot be moved to another key.
+type encryptedDB struct {
+	nosql.DB
+	aead     cipher.AEAD
+	nonceKey []byte
+}
+
+// newEncryptedDB returns an encryptedDB using the data key stored in db. The
+// data key is created the first time.
+func newEncryptedDB(db nosql.DB, c *EncryptionConfig) (*encryptedDB, error) {
+	var opts kmsapi.Options
+	if c.KMS != nil {
+		opts = *c.KMS
+	}
+	km, err := kms.New(context.Background(), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer km.Close()
+
+	kd, ok := km.(kms.Decrypter)
+	if !ok {
+		return nil, errors.Errorf("kms %s does not support decryption", opts.Type)
+	}
+	decrypter, err := kd.CreateDecrypter(&kmsapi.CreateDecrypterRequest{
+		DecryptionKey: c.Key,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating decrypter")
+	}
+
+	dataKey, err := loadDataKey(db, decrypter)
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptedDBWithKey(db, dataKey)
+}
+
+// newEncryptedDBWithKey returns an encryptedDB using the given data key.
+func newEncryptedDBWithKey(db nosql.DB, dataKey []byte) (*encryptedDB, error) {
+	block, err := aes.NewCipher(deriveKey(dataKey, "value"))
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating cipher")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating cipher")
+	}
+	return &encryptedDB{
+		DB:       db,
+		aead:     aead,
+		nonceKey: deriveKey(dataKey, "nonce"),
+	}, nil
+}
+
+// loadDataKey returns the data key stored in the database, creating it if it
+// does not exist yet.
+func loadDataKey(db nosql.DB, decrypter crypto.Decrypter) ([]byte, error) {
+	if err := db.CreateTable(encryptionTable); err != nil {
+		return nil, errors.Wrapf(err, "error creating table %s", string(encryptionTable))
+	}
+
+	wrapped, err := db.Get(encryptionTable, dataKeyID)
+	switch {
+	case nosql.IsErrNotFound(err):
+		if wrapped, err = createDataKey(db, decrypter); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, errors.Wrap(err, "error loading data key")
+	}
+
+	dataKey, err := decrypter.Decrypt(rand.Reader, wrapped, &rsa.OAEPOptions{
+		Hash: crypto.SHA256,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error decrypting data key")
+	}
+	if len(dataKey) != 32 {
+		return nil, errors.New("error decrypting data key: invalid key size")
+	}
+	return dataKey, nil
+}
+
+// createDataKey generates a new data key and stores it wrapped with the public
+// key of the decrypter. If another instance created the key first, the stored
+// one is returned.
+func createDataKey(db nosql.DB, decrypter crypto.Decrypter) ([]byte, error) {
+	pub, ok := decrypter.Public().(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("encryption key is not an RSA key")
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, errors.Wrap(err, "error generating data key")
+	}
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, dataKey, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error encrypting data key")
+	}
+	stored, _, err := db.CmpAndSwap(encryptionTable, dataKeyID, nil, wrapped)
+	if err != nil {
+		return nil, errors.Wrap(err, "error storing data key")
+	}
+	return stored, nil
+}
+
+// deriveKey derives a 32 bytes key for the given purpose from the data key.
+func deriveKey(dataKey []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, dataKey)
+	mac.Write([]byte("step-ca database " + purpose))
+	return mac.Sum(nil)
+}
+
+// additionalData returns the length-prefixed bucket and key.
+func additionalData(bucket, key []byte) []byte {
+	b := make([]byte, 0, 8+len(bucket)+len(key))
+	b = appendLengthPrefixed(b, bucket)
+	return appendLengthPrefixed(b, key)
+}
+
+func appendLengthPrefixed(b, v []byte) []byte {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(v)))
+	return append(append(b, n[:]...), v...)
+}
+
+// encrypt encrypts the value stored in the given bucket and key. A nil value
+// is not encrypted, in a compare-and-swap it means that the key does not
+// exist.
+func (db *encryptedDB) encrypt(bucket, key, value []byte) []byte {
+	if value == nil {
+		return nil
+	}
+	ad := additionalData(bucket, key)
+	mac := hmac.New(sha256.New, db.nonceKey)
+	mac.Write(ad)
+	mac.Write(value)
+	nonce := mac.Sum(nil)[:db.aead.NonceSize()]
+
+	b := make([]byte, 0, len(encryptedValuePrefix)+len(nonce)+len(value)+db.aead.Overhead())
+	b = append(append(b, encryptedValuePrefix...), nonce...)
+	return db.aead.Seal(b, nonce, value, ad)
+}
+
+// decrypt decrypts the value stored in the given bucket and key. Values stored
+// before the encryption was enabled are returned as they are.
+func (db *encryptedDB) decrypt(bucket, key, value []byte) ([]byte, error) {
+	if !bytes.HasPrefix(value, encryptedValuePrefix) {
+		return value, nil
+	}
+	b := value[len(encryptedValuePrefix):]
+	if len(b) < db.aead.NonceSize() {
+		return nil, errors.Errorf("error decrypting %s/%s: value too short", bucket, key)
+	}
+	nonce, ciphertext := b[:db.aead.NonceSize()], b[db.aead.NonceSize():]
+	plaintext, err := db.aead.Open(nil, nonce, ciphertext, additionalData(bucket, key))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error decrypting %s/%s", bucket, key)
+	}
+	return plaintext, nil
+}
+
+// Get implements the nosql.DB interface.
+func (db *encryptedDB) Get(bucket, key []byte) ([]byte, error) {
+	b, err := db.DB.Get(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return db.decrypt(bucket, key, b)
+}
+
+// BatchGet implements the BatchDB interface.
+func (db *encryptedDB) BatchGet(bucket []byte, keys [][]byte) ([][]byte, error) {
+	values, err := BatchGet(db.DB, bucket, keys)
+	if err != nil {
+		return nil, err
+	}
+	for i, b := range values {
+		if b == nil {
+			continue
+		}
+		if values[i], err = db.decrypt(bucket, keys[i], b); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// Set implements the nosql.DB interface.
+func (db *encryptedDB) Set(bucket, key, value []byte) error {
+	return db.DB.Set(bucket, key, db.encrypt(bucket, key, value))
+}
+
+// SetWithTTL implements the TTLDB interface.
+func (db *encryptedDB) SetWithTTL(bucket, key, value []byte, ttl time.Duration) error {
+	return SetWithTTL(db.DB, bucket, key, db.encrypt(bucket, key, value), ttl)
+}
+
+// CmpAndSwap implements the nosql.DB interface.
+func (db *encryptedDB) CmpAndSwap(bucket, key, oldValue, newValue []byte) ([]byte, bool, error) {
+	return db.cmpAndSwap(bucket, key, oldValue, newValue, 0)
+}
+
+// CmpAndSwapWithTTL implements the TTLDB interface.
+func (db *encryptedDB) CmpAndSwapWithTTL(bucket, key, oldValue, newValue []byte, ttl time.Duration) ([]byte, bool, error) {
+	return db.cmpAndSwap(bucket, key, oldValue, newValue, ttl)
+}
+
+// cmpAndSwap compares the encrypted values. If the current value was stored
+// before the encryption was enabled, the plaintext is compared.
+func (db *encryptedDB) cmpAndSwap(bucket, key, oldValue, newValue []byte, ttl time.Duration) ([]byte, bool, error) {
+	encNew := db.encrypt(bucket, key, newValue)
+	b, swapped, err := CmpAndSwapWithTTL(db.DB, bucket, key, db.encrypt(bucket, key, oldValue), encNew, ttl)
+	if err != nil {
+		return nil, false, err
+	}
+	if !swapped && oldValue != nil && bytes.Equal(b, oldValue) {
+		if b, swapped, err = CmpAndSwapWithTTL(db.DB, bucket, key, oldValue, encNew, ttl); err != nil {
+			return nil, false, err
+		}
+	}
+	if b, err = db.decrypt(bucket, key, b); err != nil {
+		return nil, false, err
+	}
+	return b, swapped, nil
+}
+
+// List implements the nosql.DB interface.
+func (db *encryptedDB) List(bucket []byte) ([]*database.Entry, error) {
+	entries, err := db.DB.List(bucket)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Value, err = db.decrypt(e.Bucket, e.Key, e.Value); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// Update implements the nosql.DB interface. The compare-and-swap operations in
+// a transaction only match encrypted values.
+func (db *encryptedDB) Update(tx *database.Tx) error {
+	return db.UpdateWithTTL(tx, 0)
+}
+
+// UpdateWithTTL implements the TTLDB interface.
+func (db *encryptedDB) UpdateWithTTL(tx *database.Tx, ttl time.Duration) error {
+	// Encrypt the values in place and restore them after the update, the
+	// caller owns the transaction.
+	type values struct{ value, cmpValue []byte }
+	plain := make([]values, len(tx.Operations))
+	for i, op := range tx.Operations {
+		plain[i] = values{op.Value, op.CmpValue}
+		op.Value = db.encrypt(op.Bucket, op.Key, op.Value)
+		op.CmpValue = db.encrypt(op.Bucket, op.Key, op.CmpValue)
+	}
+	defer func() {
+		for i, op := range tx.Operations {
+			op.Value, op.CmpValue = plain[i].value, plain[i].cmpValue
+		}
+	}()
+
+	if err := UpdateWithTTL(db.DB, tx, ttl); err != nil {
+		return err
+	}
+	for _, op := range tx.Operations {
+		var err error
+		if op.Result, err = db.decrypt(op.Bucket, op.Key, op.Result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
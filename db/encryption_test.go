@@ -0,0 +1,213 @@
+package db
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/smallstep/assert"
+	kmsapi "github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/cli/crypto/pemutil"
+	"github.com/smallstep/nosql"
+	"github.com/smallstep/nosql/database"
+)
+
+func writeRSAKey(t *testing.T, dir, name string) string {
+	pk, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.FatalError(t, err)
+	block, err := pemutil.Serialize(pk)
+	assert.FatalError(t, err)
+	fn := filepath.Join(dir, name)
+	assert.FatalError(t, ioutil.WriteFile(fn, pem.EncodeToMemory(block), 0600))
+	return fn
+}
+
+func TestEncryptionConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		config *EncryptionConfig
+		err    error
+	}{
+		"ok/nil":     {},
+		"ok/default": {config: &EncryptionConfig{Key: "key.pem"}},
+		"ok/kms":     {config: &EncryptionConfig{KMS: &kmsapi.Options{Type: "awskms"}, Key: "key-id"}},
+		"fail/key":   {config: &EncryptionConfig{}, err: errors.New("encryption key cannot be empty")},
+		"fail/kms": {
+			config: &EncryptionConfig{KMS: &kmsapi.Options{Type: "foo"}, Key: "key.pem"},
+			err:    errors.New("unsupported kms type foo"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tc.config.Validate(); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestEncryptedDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encryption")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := &Config{
+		Type:       "badgerv2",
+		DataSource: filepath.Join(dir, "db"),
+		Encryption: &EncryptionConfig{Key: writeRSAKey(t, dir, "key.pem")},
+	}
+	adb, err := New(config)
+	assert.FatalError(t, err)
+	edb := adb.(*DB).DB.(*metricsDB).DB.(*encryptedDB)
+	raw := edb.DB
+
+	// Values are encrypted at rest.
+	assert.FatalError(t, adb.(*DB).Set(certsTable, []byte("1"), []byte("certificate")))
+	b, err := raw.Get(certsTable, []byte("1"))
+	assert.FatalError(t, err)
+	assert.True(t, bytes.HasPrefix(b, encryptedValuePrefix))
+	assert.False(t, bytes.Contains(b, []byte("certificate")))
+	b, err = edb.Get(certsTable, []byte("1"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("certificate"), b)
+
+	// An encrypted value cannot be moved to another key.
+	ciphertext, err := raw.Get(certsTable, []byte("1"))
+	assert.FatalError(t, err)
+	assert.FatalError(t, raw.Set(certsTable, []byte("2"), ciphertext))
+	_, err = edb.Get(certsTable, []byte("2"))
+	assert.HasPrefix(t, err.Error(), "error decrypting x509_certs/2")
+
+	// Compare-and-swap compares the plaintext.
+	_, swapped, err := edb.CmpAndSwap(certsTable, []byte("1"), []byte("other"), []byte("new"))
+	assert.FatalError(t, err)
+	assert.False(t, swapped)
+	b, swapped, err = edb.CmpAndSwap(certsTable, []byte("1"), []byte("certificate"), []byte("new"))
+	assert.FatalError(t, err)
+	assert.True(t, swapped)
+	assert.Equals(t, []byte("new"), b)
+
+	// Values stored before the encryption was enabled are still readable and
+	// encrypted when they are replaced.
+	assert.FatalError(t, raw.Set(certsTable, []byte("3"), []byte("legacy")))
+	b, err = edb.Get(certsTable, []byte("3"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("legacy"), b)
+	b, swapped, err = edb.CmpAndSwap(certsTable, []byte("3"), []byte("legacy"), []byte("updated"))
+	assert.FatalError(t, err)
+	assert.True(t, swapped)
+	assert.Equals(t, []byte("updated"), b)
+	b, err = raw.Get(certsTable, []byte("3"))
+	assert.FatalError(t, err)
+	assert.True(t, bytes.HasPrefix(b, encryptedValuePrefix))
+
+	// Transactions are encrypted and the caller values are kept.
+	tx := new(database.Tx)
+	tx.Set(certsTable, []byte("4"), []byte("tx-value"))
+	tx.Operations = append(tx.Operations, &database.TxEntry{
+		Bucket: certsTable, Key: []byte("1"), CmpValue: []byte("new"), Value: []byte("tx-new"),
+		Cmd: database.CmpAndSwap,
+	})
+	assert.FatalError(t, edb.Update(tx))
+	assert.True(t, tx.Operations[1].Swapped)
+	assert.Equals(t, []byte("tx-new"), tx.Operations[1].Result)
+	assert.Equals(t, []byte("tx-value"), tx.Operations[0].Value)
+	b, err = raw.Get(certsTable, []byte("4"))
+	assert.FatalError(t, err)
+	assert.True(t, bytes.HasPrefix(b, encryptedValuePrefix))
+
+	assert.FatalError(t, raw.Del(certsTable, []byte("2")))
+	entries, err := edb.List(certsTable)
+	assert.FatalError(t, err)
+	values := map[string]string{}
+	for _, e := range entries {
+		values[string(e.Key)] = string(e.Value)
+	}
+	assert.Equals(t, map[string]string{"1": "tx-new", "3": "updated", "4": "tx-value"}, values)
+	assert.FatalError(t, adb.Shutdown())
+
+	// The data key is stored in the database.
+	adb, err = New(config)
+	assert.FatalError(t, err)
+	b, err = adb.(*DB).Get(certsTable, []byte("4"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("tx-value"), b)
+	assert.FatalError(t, adb.Shutdown())
+
+	// And it cannot be decrypted with other key.
+	config.Encryption.Key = writeRSAKey(t, dir, "other.pem")
+	_, err = New(config)
+	assert.HasPrefix(t, err.Error(), "error initializing database encryption: error decrypting data key")
+}
+
+func TestLoadDataKey(t *testing.T) {
+	pk, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.FatalError(t, err)
+
+	tests := map[string]struct {
+		db  nosql.DB
+		err error
+	}{
+		"fail/create-table": {
+			db:  &MockNoSQLDB{MCreateTable: func(bucket []byte) error { return errors.New("force") }},
+			err: errors.New("error creating table encryption: force"),
+		},
+		"fail/get": {
+			db: &MockNoSQLDB{
+				MCreateTable: func(bucket []byte) error { return nil },
+				MGet:         func(bucket, key []byte) ([]byte, error) { return nil, errors.New("force") },
+			},
+			err: errors.New("error loading data key: force"),
+		},
+		"fail/store": {
+			db: &MockNoSQLDB{
+				MCreateTable: func(bucket []byte) error { return nil },
+				MGet:         func(bucket, key []byte) ([]byte, error) { return nil, database.ErrNotFound },
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					return nil, false, errors.New("force")
+				},
+			},
+			err: errors.New("error storing data key: force"),
+		},
+		"fail/decrypt": {
+			db: &MockNoSQLDB{
+				MCreateTable: func(bucket []byte) error { return nil },
+				MGet:         func(bucket, key []byte) ([]byte, error) { return []byte("garbage"), nil },
+			},
+			err: errors.New("error decrypting data key"),
+		},
+		"ok/create": {
+			db: &MockNoSQLDB{
+				MCreateTable: func(bucket []byte) error { return nil },
+				MGet:         func(bucket, key []byte) ([]byte, error) { return nil, database.ErrNotFound },
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					assert.Equals(t, encryptionTable, bucket)
+					assert.Equals(t, dataKeyID, key)
+					assert.Nil(t, old)
+					return newval, true, nil
+				},
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			key, err := loadDataKey(tc.db, pk)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Len(t, 32, key)
+			}
+		})
+	}
+}
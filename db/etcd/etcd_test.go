@@ -0,0 +1,620 @@
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/nosql/database"
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+type fakeKeyValue struct {
+	value  []byte
+	create int64
+	mod    int64
+	lease  int64
+}
+
+// fakeEtcd is an in-memory implementation of the etcd KV, lease and auth
+// services.
+type fakeEtcd struct {
+	pb.UnimplementedKVServer
+	pb.UnimplementedLeaseServer
+	pb.UnimplementedAuthServer
+	mu       sync.Mutex
+	kvs      map[string]*fakeKeyValue
+	revision int64
+	leases   int64
+	password string
+	token    string
+	// err, if set, is returned by all the KV requests.
+	err error
+	// beforeCommit is called before a transaction with compares is applied.
+	beforeCommit func(f *fakeEtcd)
+	// rangeRevisions are the revisions requested by the ranges of the
+	// transactions. The fake always reads the last revision.
+	rangeRevisions []int64
+}
+
+func newFakeEtcd() *fakeEtcd {
+	return &fakeEtcd{kvs: make(map[string]*fakeKeyValue)}
+}
+
+// serve starts a gRPC server with the fake services and returns its endpoint
+// and the function that stops it.
+func (f *fakeEtcd) serve(t *testing.T) (string, func()) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.FatalError(t, err)
+	srv := grpc.NewServer(grpc.UnaryInterceptor(f.authorize))
+	pb.RegisterKVServer(srv, f)
+	pb.RegisterLeaseServer(srv, f)
+	pb.RegisterAuthServer(srv, f)
+	go srv.Serve(l)
+	return "http://" + l.Addr().String(), srv.Stop
+}
+
+// authorize checks the token of the requests if the authentication is
+// enabled.
+func (f *fakeEtcd) authorize(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	f.mu.Lock()
+	password, token := f.password, f.token
+	f.mu.Unlock()
+	if password != "" && !strings.HasSuffix(info.FullMethod, "/Authenticate") {
+		md, _ := metadata.FromIncomingContext(ctx)
+		switch v := md.Get(rpctypes.TokenFieldNameGRPC); {
+		case len(v) == 0:
+			return nil, rpctypes.ErrGRPCUserEmpty
+		case v[0] != token:
+			return nil, rpctypes.ErrGRPCInvalidAuthToken
+		}
+	}
+	return handler(ctx, req)
+}
+
+func (f *fakeEtcd) Authenticate(ctx context.Context, req *pb.AuthenticateRequest) (*pb.AuthenticateResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if req.Name != "root" || req.Password != f.password {
+		return nil, rpctypes.ErrGRPCAuthFailed
+	}
+	f.token = fmt.Sprintf("token-%d", f.revision)
+	return &pb.AuthenticateResponse{Header: f.header(), Token: f.token}, nil
+}
+
+func (f *fakeEtcd) header() *pb.ResponseHeader {
+	return &pb.ResponseHeader{Revision: f.revision}
+}
+
+func (f *fakeEtcd) Range(ctx context.Context, req *pb.RangeRequest) (*pb.RangeResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.doRange(req), nil
+}
+
+func (f *fakeEtcd) Put(ctx context.Context, req *pb.PutRequest) (*pb.PutResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.doPut(req)
+	return &pb.PutResponse{Header: f.header()}, nil
+}
+
+func (f *fakeEtcd) DeleteRange(ctx context.Context, req *pb.DeleteRangeRequest) (*pb.DeleteRangeResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.doDeleteRange(req), nil
+}
+
+func (f *fakeEtcd) Txn(ctx context.Context, req *pb.TxnRequest) (*pb.TxnResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	if len(req.Compare) > 0 && f.beforeCommit != nil {
+		f.beforeCommit(f)
+	}
+	return f.doTxn(req), nil
+}
+
+func (f *fakeEtcd) LeaseGrant(ctx context.Context, req *pb.LeaseGrantRequest) (*pb.LeaseGrantResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.leases++
+	return &pb.LeaseGrantResponse{Header: f.header(), ID: f.leases, TTL: req.TTL}, nil
+}
+
+func (f *fakeEtcd) inRange(k string, key, rangeEnd []byte) bool {
+	if len(rangeEnd) == 0 {
+		return k == string(key)
+	}
+	return k >= string(key) && k < string(rangeEnd)
+}
+
+func (f *fakeEtcd) doRange(req *pb.RangeRequest) *pb.RangeResponse {
+	resp := &pb.RangeResponse{Header: f.header()}
+	var keys []string
+	for k := range f.kvs {
+		if f.inRange(k, req.Key, req.RangeEnd) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	resp.Count = int64(len(keys))
+	if req.CountOnly {
+		return resp
+	}
+	for _, k := range keys {
+		kv := f.kvs[k]
+		resp.Kvs = append(resp.Kvs, &mvccpb.KeyValue{
+			Key:            []byte(k),
+			Value:          kv.value,
+			CreateRevision: kv.create,
+			ModRevision:    kv.mod,
+			Lease:          kv.lease,
+		})
+	}
+	return resp
+}
+
+func (f *fakeEtcd) doPut(req *pb.PutRequest) {
+	f.revision++
+	kv, ok := f.kvs[string(req.Key)]
+	if !ok {
+		kv = &fakeKeyValue{create: f.revision}
+		f.kvs[string(req.Key)] = kv
+	}
+	kv.value = req.Value
+	kv.mod = f.revision
+	if !req.IgnoreLease {
+		kv.lease = req.Lease
+	}
+}
+
+func (f *fakeEtcd) doDeleteRange(req *pb.DeleteRangeRequest) *pb.DeleteRangeResponse {
+	var deleted int64
+	for k := range f.kvs {
+		if f.inRange(k, req.Key, req.RangeEnd) {
+			delete(f.kvs, k)
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		f.revision++
+	}
+	return &pb.DeleteRangeResponse{Header: f.header(), Deleted: deleted}
+}
+
+func (f *fakeEtcd) compare(c *pb.Compare) bool {
+	kv := f.kvs[string(c.Key)]
+	if kv == nil {
+		kv = &fakeKeyValue{}
+	}
+	switch c.Target {
+	case pb.Compare_VALUE:
+		return bytes.Equal(kv.value, c.GetValue())
+	case pb.Compare_CREATE:
+		return kv.create == c.GetCreateRevision()
+	case pb.Compare_MOD:
+		return kv.mod == c.GetModRevision()
+	default:
+		return false
+	}
+}
+
+func (f *fakeEtcd) doTxn(req *pb.TxnRequest) *pb.TxnResponse {
+	succeeded := true
+	for _, c := range req.Compare {
+		if c.Result != pb.Compare_EQUAL || !f.compare(c) {
+			succeeded = false
+		}
+	}
+	ops := req.Success
+	if !succeeded {
+		ops = req.Failure
+	}
+	resp := &pb.TxnResponse{Succeeded: succeeded}
+	for _, op := range ops {
+		switch {
+		case op.GetRequestRange() != nil:
+			f.rangeRevisions = append(f.rangeRevisions, op.GetRequestRange().Revision)
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{
+				Response: &pb.ResponseOp_ResponseRange{ResponseRange: f.doRange(op.GetRequestRange())},
+			})
+		case op.GetRequestPut() != nil:
+			f.doPut(op.GetRequestPut())
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{
+				Response: &pb.ResponseOp_ResponsePut{ResponsePut: &pb.PutResponse{Header: f.header()}},
+			})
+		case op.GetRequestDeleteRange() != nil:
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{
+				Response: &pb.ResponseOp_ResponseDeleteRange{ResponseDeleteRange: f.doDeleteRange(op.GetRequestDeleteRange())},
+			})
+		}
+	}
+	resp.Header = f.header()
+	return resp
+}
+
+func newTestDB(t *testing.T, dataSource string) *DB {
+	db := &DB{}
+	assert.FatalError(t, db.Open(dataSource, database.WithDatabase("test")))
+	return db
+}
+
+func TestDB_Open(t *testing.T) {
+	defer func(d time.Duration) { dialTimeout = d }(dialTimeout)
+	dialTimeout = time.Second
+
+	f := newFakeEtcd()
+	srv, stop := f.serve(t)
+	defer stop()
+	down, stopDown := newFakeEtcd().serve(t)
+	stopDown()
+
+	authEtcd := newFakeEtcd()
+	authEtcd.password = "pass"
+	authSrv, stopAuth := authEtcd.serve(t)
+	defer stopAuth()
+
+	tests := map[string]struct {
+		dataSource string
+		options    *Options
+		err        string
+	}{
+		"ok":              {srv, nil, ""},
+		"ok/failover":     {down + ", " + srv, nil, ""},
+		"ok/auth":         {strings.Replace(authSrv, "http://", "http://root:pass@", 1), nil, ""},
+		"fail/empty":      {" ", nil, "etcd dataSource cannot be empty"},
+		"fail/scheme":     {"unix://etcd:2379", nil, "etcd endpoint etcd:2379 must use http or https"},
+		"fail/down":       {down, nil, "error connecting to etcd: context deadline exceeded"},
+		"fail/auth":       {strings.Replace(authSrv, "http://", "http://root:foo@", 1), nil, "error connecting to etcd: etcdserver: authentication failed"},
+		"fail/no-auth":    {authSrv, nil, "error connecting to etcd: etcdserver: user name is empty"},
+		"fail/caFile":     {srv, &Options{CAFile: "testdata/missing.crt"}, "error reading testdata/missing.crt"},
+		"fail/certFile":   {srv, &Options{CertFile: "testdata/missing.crt"}, "etcd certFile and keyFile must be used together"},
+		"fail/clientCert": {srv, &Options{CertFile: "testdata/missing.crt", KeyFile: "testdata/missing.key"}, "error loading etcd client certificate"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := &DB{Options: tc.options}
+			err := db.Open(tc.dataSource)
+			if tc.err != "" {
+				if assert.NotNil(t, err) {
+					assert.HasPrefix(t, err.Error(), tc.err)
+				}
+				return
+			}
+			assert.FatalError(t, err)
+			assert.Equals(t, []byte("step-ca/"), db.prefix)
+			assert.FatalError(t, db.Close())
+		})
+	}
+}
+
+func TestDB_Open_caFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "etcd-ca")
+	assert.FatalError(t, err)
+	defer os.Remove(f.Name())
+	assert.FatalError(t, f.Close())
+
+	err = (&DB{Options: &Options{CAFile: f.Name()}}).Open("https://localhost:2379")
+	if assert.NotNil(t, err) {
+		assert.Equals(t, "error parsing "+f.Name()+": no certificates found", err.Error())
+	}
+}
+
+func TestDB_CRUD(t *testing.T) {
+	f := newFakeEtcd()
+	srv, stop := f.serve(t)
+	defer stop()
+	db := newTestDB(t, srv)
+	defer db.Close()
+
+	bucket := []byte("bucket")
+	assert.FatalError(t, db.CreateTable(bucket))
+
+	_, err := db.Get(bucket, []byte("foo"))
+	assert.True(t, database.IsErrNotFound(err))
+
+	assert.FatalError(t, db.Set(bucket, []byte("foo"), []byte("bar")))
+	assert.FatalError(t, db.Set(bucket, []byte("zar"), []byte("zap")))
+	assert.FatalError(t, db.Set([]byte("bucket2"), []byte("foo"), []byte("other")))
+	assert.Equals(t, []byte("bar"), f.kvs["test/bucket/foo"].value)
+
+	v, err := db.Get(bucket, []byte("foo"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("bar"), v)
+
+	entries, err := db.List(bucket)
+	assert.FatalError(t, err)
+	assert.Equals(t, []*database.Entry{
+		{Bucket: bucket, Key: []byte("foo"), Value: []byte("bar")},
+		{Bucket: bucket, Key: []byte("zar"), Value: []byte("zap")},
+	}, entries)
+
+	assert.FatalError(t, db.Del(bucket, []byte("foo")))
+	_, err = db.Get(bucket, []byte("foo"))
+	assert.True(t, database.IsErrNotFound(err))
+	assert.FatalError(t, db.Del(bucket, []byte("foo")))
+
+	assert.FatalError(t, db.DeleteTable(bucket))
+	entries, err = db.List(bucket)
+	assert.FatalError(t, err)
+	assert.Len(t, 0, entries)
+	v, err = db.Get([]byte("bucket2"), []byte("foo"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("other"), v)
+}
+
+func TestDB_BatchGet(t *testing.T) {
+	f := newFakeEtcd()
+	srv, stop := f.serve(t)
+	defer stop()
+	db := newTestDB(t, srv)
+	defer db.Close()
+
+	bucket := []byte("bucket")
+	var keys, want [][]byte
+	for i := 0; i < maxTxnOps+2; i++ {
+		key := []byte(fmt.Sprintf("key-%03d", i))
+		keys = append(keys, key)
+		if i%2 == 0 {
+			assert.FatalError(t, db.Set(bucket, key, key))
+			want = append(want, key)
+		} else {
+			want = append(want, nil)
+		}
+	}
+	rev := f.revision
+	values, err := db.BatchGet(bucket, keys)
+	assert.FatalError(t, err)
+	assert.Equals(t, want, values)
+
+	// The keys after the first transaction are read at the same revision.
+	if assert.Len(t, maxTxnOps+2, f.rangeRevisions) {
+		assert.Equals(t, int64(0), f.rangeRevisions[0])
+		assert.Equals(t, rev, f.rangeRevisions[maxTxnOps])
+		assert.Equals(t, rev, f.rangeRevisions[maxTxnOps+1])
+	}
+
+	f.err = rpctypes.ErrGRPCNoSpace
+	_, err = db.BatchGet(bucket, keys)
+	assert.Equals(t, "failed to get bucket batch: etcdserver: mvcc: database space exceeded", err.Error())
+}
+
+func TestDB_CmpAndSwap(t *testing.T) {
+	f := newFakeEtcd()
+	srv, stop := f.serve(t)
+	defer stop()
+	db := newTestDB(t, srv)
+	defer db.Close()
+
+	bucket, key := []byte("bucket"), []byte("key")
+	v, swapped, err := db.CmpAndSwap(bucket, key, nil, []byte("one"))
+	assert.FatalError(t, err)
+	assert.True(t, swapped)
+	assert.Equals(t, []byte("one"), v)
+
+	v, swapped, err = db.CmpAndSwap(bucket, key, nil, []byte("two"))
+	assert.FatalError(t, err)
+	assert.False(t, swapped)
+	assert.Equals(t, []byte("one"), v)
+
+	v, swapped, err = db.CmpAndSwap(bucket, key, []byte("foo"), []byte("two"))
+	assert.FatalError(t, err)
+	assert.False(t, swapped)
+	assert.Equals(t, []byte("one"), v)
+
+	v, swapped, err = db.CmpAndSwap(bucket, key, []byte("one"), []byte("two"))
+	assert.FatalError(t, err)
+	assert.True(t, swapped)
+	assert.Equals(t, []byte("two"), v)
+
+	v, swapped, err = db.CmpAndSwap(bucket, []byte("missing"), []byte("one"), []byte("two"))
+	assert.FatalError(t, err)
+	assert.False(t, swapped)
+	assert.Nil(t, v)
+}
+
+func TestDB_SetWithTTL(t *testing.T) {
+	f := newFakeEtcd()
+	srv, stop := f.serve(t)
+	defer stop()
+	db := newTestDB(t, srv)
+	defer db.Close()
+
+	assert.FatalError(t, db.SetWithTTL([]byte("nonces"), []byte("foo"), []byte("bar"), 1500*time.Millisecond))
+	assert.Equals(t, int64(1), f.kvs["test/nonces/foo"].lease)
+
+	err := db.SetWithTTL([]byte("nonces"), []byte("foo"), []byte("bar"), 0)
+	if assert.NotNil(t, err) {
+		assert.Equals(t, "failed to create lease for nonces/foo: ttl must be greater than 0", err.Error())
+	}
+
+	// Swaps keep the lease unless a new one is given.
+	_, swapped, err := db.CmpAndSwap([]byte("nonces"), []byte("foo"), []byte("bar"), []byte("baz"))
+	assert.FatalError(t, err)
+	assert.True(t, swapped)
+	assert.Equals(t, int64(1), f.kvs["test/nonces/foo"].lease)
+	_, swapped, err = db.CmpAndSwapWithTTL([]byte("nonces"), []byte("foo"), []byte("baz"), []byte("qux"), time.Minute)
+	assert.FatalError(t, err)
+	assert.True(t, swapped)
+	assert.Equals(t, int64(2), f.kvs["test/nonces/foo"].lease)
+	_, swapped, err = db.CmpAndSwapWithTTL([]byte("nonces"), []byte("new"), nil, []byte("bar"), time.Minute)
+	assert.FatalError(t, err)
+	assert.True(t, swapped)
+	assert.Equals(t, int64(3), f.kvs["test/nonces/new"].lease)
+	_, _, err = db.CmpAndSwapWithTTL([]byte("nonces"), []byte("new"), nil, []byte("bar"), 0)
+	if assert.NotNil(t, err) {
+		assert.Equals(t, "failed to create lease for nonces/new: ttl must be greater than 0", err.Error())
+	}
+
+	tx := new(database.Tx)
+	tx.Set([]byte("orders"), []byte("o1"), []byte("order"))
+	tx.Operations = append(tx.Operations, &database.TxEntry{
+		Bucket: []byte("nonces"), Key: []byte("foo"), CmpValue: []byte("qux"), Value: []byte("quux"), Cmd: database.CmpAndSwap,
+	})
+	assert.FatalError(t, db.UpdateWithTTL(tx, time.Hour))
+	assert.True(t, tx.Operations[1].Swapped)
+	assert.Equals(t, int64(4), f.kvs["test/orders/o1"].lease)
+	assert.Equals(t, int64(4), f.kvs["test/nonces/foo"].lease)
+
+	tx = new(database.Tx)
+	tx.Operations = append(tx.Operations, &database.TxEntry{
+		Bucket: []byte("nonces"), Key: []byte("foo"), CmpValue: []byte("quux"), Value: []byte("bar"), Cmd: database.CmpAndSwap,
+	})
+	assert.FatalError(t, db.Update(tx))
+	assert.True(t, tx.Operations[0].Swapped)
+	assert.Equals(t, int64(4), f.kvs["test/nonces/foo"].lease)
+	assert.Equals(t, "failed to create lease for etcd transaction: ttl must be greater than 0", db.UpdateWithTTL(tx, 0).Error())
+}
+
+func TestDB_Update(t *testing.T) {
+	f := newFakeEtcd()
+	srv, stop := f.serve(t)
+	defer stop()
+	db := newTestDB(t, srv)
+	defer db.Close()
+
+	bucket := []byte("bucket")
+	assert.FatalError(t, db.Set(bucket, []byte("a"), []byte("1")))
+	assert.FatalError(t, db.Set(bucket, []byte("b"), []byte("2")))
+
+	tx := new(database.Tx)
+	tx.Get(bucket, []byte("a"))
+	tx.Set(bucket, []byte("c"), []byte("3"))
+	tx.Get(bucket, []byte("c"))
+	tx.Del(bucket, []byte("b"))
+	tx.Operations = append(tx.Operations,
+		&database.TxEntry{Bucket: bucket, Key: []byte("a"), CmpValue: []byte("1"), Value: []byte("10"), Cmd: database.CmpAndSwap},
+		&database.TxEntry{Bucket: bucket, Key: []byte("b"), CmpValue: []byte("2"), Value: []byte("20"), Cmd: database.CmpAndSwap},
+	)
+	assert.FatalError(t, db.Update(tx))
+	assert.Equals(t, []byte("1"), tx.Operations[0].Result)
+	assert.Equals(t, []byte("3"), tx.Operations[2].Result)
+	assert.Equals(t, []byte("10"), tx.Operations[4].Result)
+	assert.True(t, tx.Operations[4].Swapped)
+	assert.Nil(t, tx.Operations[5].Result)
+	assert.False(t, tx.Operations[5].Swapped)
+
+	entries, err := db.List(bucket)
+	assert.FatalError(t, err)
+	assert.Equals(t, []*database.Entry{
+		{Bucket: bucket, Key: []byte("a"), Value: []byte("10")},
+		{Bucket: bucket, Key: []byte("c"), Value: []byte("3")},
+	}, entries)
+
+	// Rollback
+	tx = new(database.Tx)
+	tx.Set(bucket, []byte("a"), []byte("100"))
+	tx.Cmp(bucket, []byte("c"), []byte("4"))
+	err = db.Update(tx)
+	if assert.NotNil(t, err) {
+		assert.Equals(t, "failed to compare bucket/c: values do not match", err.Error())
+	}
+	tx = new(database.Tx)
+	tx.Get(bucket, []byte("missing"))
+	err = db.Update(tx)
+	assert.True(t, database.IsErrNotFound(err))
+	v, err := db.Get(bucket, []byte("a"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("10"), v)
+
+	// Conflicts
+	var conflicts int
+	f.beforeCommit = func(f *fakeEtcd) {
+		if conflicts < 2 {
+			conflicts++
+			f.doPut(&pb.PutRequest{Key: []byte("test/bucket/a"), Value: []byte(fmt.Sprint(conflicts))})
+		}
+	}
+	tx = new(database.Tx)
+	tx.Get(bucket, []byte("a"))
+	tx.Set(bucket, []byte("b"), []byte("3"))
+	assert.FatalError(t, db.Update(tx))
+	assert.Equals(t, 2, conflicts)
+	assert.Equals(t, []byte("2"), tx.Operations[0].Result)
+	v, err = db.Get(bucket, []byte("b"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("3"), v)
+
+	f.beforeCommit = func(f *fakeEtcd) {
+		f.doPut(&pb.PutRequest{Key: []byte("test/bucket/a"), Value: []byte("foo")})
+	}
+	tx = new(database.Tx)
+	tx.Get(bucket, []byte("a"))
+	tx.Set(bucket, []byte("a"), []byte("bar"))
+	err = db.Update(tx)
+	if assert.NotNil(t, err) {
+		assert.Equals(t, "failed to commit etcd transaction: too many conflicts", err.Error())
+	}
+
+	// Tables
+	f.beforeCommit = nil
+	tx = new(database.Tx)
+	tx.CreateTable([]byte("other"))
+	tx.Set([]byte("other"), []byte("a"), []byte("1"))
+	tx.DeleteTable(bucket)
+	assert.FatalError(t, db.Update(tx))
+	entries, err = db.List(bucket)
+	assert.FatalError(t, err)
+	assert.Len(t, 0, entries)
+	v, err = db.Get([]byte("other"), []byte("a"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("1"), v)
+}
+
+func TestDB_reauthenticate(t *testing.T) {
+	f := newFakeEtcd()
+	f.password = "pass"
+	srv, stop := f.serve(t)
+	defer stop()
+	db := newTestDB(t, strings.Replace(srv, "http://", "http://root:pass@", 1))
+	defer db.Close()
+
+	f.mu.Lock()
+	f.revision, f.token = 100, "expired"
+	f.mu.Unlock()
+	assert.FatalError(t, db.Set([]byte("bucket"), []byte("foo"), []byte("bar")))
+	assert.Equals(t, "token-100", f.token)
+}
+
+func TestDB_error(t *testing.T) {
+	f := newFakeEtcd()
+	srv, stop := f.serve(t)
+	defer stop()
+	db := newTestDB(t, srv)
+	defer db.Close()
+
+	f.mu.Lock()
+	f.err = rpctypes.ErrGRPCNoSpace
+	f.mu.Unlock()
+	_, err := db.Get([]byte("bucket"), []byte("foo"))
+	if assert.NotNil(t, err) {
+		assert.Equals(t, "failed to get bucket/foo: etcdserver: mvcc: database space exceeded", err.Error())
+		assert.False(t, database.IsErrNotFound(err))
+	}
+}
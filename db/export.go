@@ -0,0 +1,132 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/nosql"
+)
+
+// archiveVersion is the version of the format written by Export.
+const archiveVersion = 1
+
+// ArchiveHeader is the first record of an archive created by Export.
+type ArchiveHeader struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	Tables    []string  `json:"tables"`
+}
+
+// ArchiveEntry is a key and value stored in an archive created by Export.
+type ArchiveEntry struct {
+	Table string `json:"table"`
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// Tables returns the tables used by the CA, the ACME tables are defined in the
+// acme package.
+func Tables() [][]byte {
+	return [][]byte{
+		revokedCertsTable, certsTable, usedOTTTable,
+		sshCertsTable, sshHostsTable, sshHostPrincipalsTable, sshUsersTable,
+		revokedSSHCertsTable, provisionersTable, policiesTable, schemaTable,
+	}
+}
+
+// Export writes all the keys in the given tables to w. The archive is a gzip
+// compressed stream of JSON records, an ArchiveHeader followed by an
+// ArchiveEntry for each key, so it can be imported in a database of any type.
+// Values are written as they are returned by db, if the database is encrypted
+// they are written in plain text. It returns the number of keys written.
+func Export(db nosql.DB, w io.Writer, tables [][]byte) (int, error) {
+	header := &ArchiveHeader{
+		Version:   archiveVersion,
+		CreatedAt: time.Now().UTC(),
+	}
+	for _, t := range tables {
+		header.Tables = append(header.Tables, string(t))
+	}
+
+	zw := gzip.NewWriter(w)
+	enc := json.NewEncoder(zw)
+	if err := enc.Encode(header); err != nil {
+		return 0, errors.Wrap(err, "error writing archive")
+	}
+
+	var n int
+	for _, t := range tables {
+		entries, err := db.List(t)
+		switch {
+		case nosql.IsErrNotFound(err):
+			continue
+		case err != nil:
+			return n, errors.Wrapf(err, "error listing table %s", string(t))
+		}
+		for _, e := range entries {
+			if err := enc.Encode(&ArchiveEntry{
+				Table: string(t),
+				Key:   e.Key,
+				Value: e.Value,
+			}); err != nil {
+				return n, errors.Wrap(err, "error writing archive")
+			}
+			n++
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return n, errors.Wrap(err, "error writing archive")
+	}
+	return n, nil
+}
+
+// Import stores in db the keys in an archive created by Export. Keys are only
+// created, if a key already exists with a different value Import fails, so
+// archives must be imported in a new database. Importing the same archive
+// twice is not an error. It returns the number of keys created.
+func Import(db nosql.DB, r io.Reader) (int, error) {
+	zr, err := gzip.NewReader(bufio.NewReader(r))
+	if err != nil {
+		return 0, errors.Wrap(err, "error reading archive")
+	}
+	defer zr.Close()
+	dec := json.NewDecoder(zr)
+
+	var header ArchiveHeader
+	if err := dec.Decode(&header); err != nil {
+		return 0, errors.Wrap(err, "error reading archive")
+	}
+	if header.Version != archiveVersion {
+		return 0, errors.Errorf("unsupported archive version %d", header.Version)
+	}
+	for _, t := range header.Tables {
+		if err := db.CreateTable([]byte(t)); err != nil {
+			return 0, errors.Wrapf(err, "error creating table %s", t)
+		}
+	}
+
+	var n int
+	for {
+		var e ArchiveEntry
+		if err := dec.Decode(&e); err != nil {
+			if err == io.EOF {
+				return n, nil
+			}
+			return n, errors.Wrap(err, "error reading archive")
+		}
+		b, swapped, err := db.CmpAndSwap([]byte(e.Table), e.Key, nil, e.Value)
+		switch {
+		case err != nil:
+			return n, errors.Wrapf(err, "error storing %s/%s", e.Table, e.Key)
+		case swapped:
+			n++
+		case !bytes.Equal(b, e.Value):
+			return n, errors.Errorf("error storing %s/%s: key already exists", e.Table, e.Key)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package db
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/nosql/database"
+)
+
+// newMapDB returns a MockNoSQLDB that stores the values in the given map.
+func newMapDB(tables map[string]map[string][]byte) *MockNoSQLDB {
+	return &MockNoSQLDB{
+		MCreateTable: func(bucket []byte) error {
+			if _, ok := tables[string(bucket)]; !ok {
+				tables[string(bucket)] = map[string][]byte{}
+			}
+			return nil
+		},
+		MList: func(bucket []byte) ([]*database.Entry, error) {
+			t, ok := tables[string(bucket)]
+			if !ok {
+				return nil, database.ErrNotFound
+			}
+			var keys []string
+			for k := range t {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			var entries []*database.Entry
+			for _, k := range keys {
+				entries = append(entries, &database.Entry{Bucket: bucket, Key: []byte(k), Value: t[k]})
+			}
+			return entries, nil
+		},
+		MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+			t := tables[string(bucket)]
+			if v, ok := t[string(key)]; ok {
+				return v, false, nil
+			}
+			t[string(key)] = newval
+			return newval, true, nil
+		},
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	src := map[string]map[string][]byte{
+		"x509_certs":    {"1": []byte("cert1"), "2": []byte("cert2")},
+		"acme_accounts": {"a": []byte(`{"id":"a"}`)},
+		"nonces":        {"n": []byte("nonce")},
+	}
+	var buf bytes.Buffer
+	n, err := Export(newMapDB(src), &buf, [][]byte{[]byte("x509_certs"), []byte("acme_accounts"), []byte("ssh_certs")})
+	assert.FatalError(t, err)
+	assert.Equals(t, 3, n)
+	archive := buf.Bytes()
+
+	dst := map[string]map[string][]byte{}
+	n, err = Import(newMapDB(dst), bytes.NewReader(archive))
+	assert.FatalError(t, err)
+	assert.Equals(t, 3, n)
+	assert.Equals(t, map[string]map[string][]byte{
+		"x509_certs":    {"1": []byte("cert1"), "2": []byte("cert2")},
+		"acme_accounts": {"a": []byte(`{"id":"a"}`)},
+		"ssh_certs":     {},
+	}, dst)
+
+	// Importing the same archive again does not create keys.
+	n, err = Import(newMapDB(dst), bytes.NewReader(archive))
+	assert.FatalError(t, err)
+	assert.Equals(t, 0, n)
+
+	// But existing keys are not replaced.
+	dst["x509_certs"]["2"] = []byte("other")
+	_, err = Import(newMapDB(dst), bytes.NewReader(archive))
+	assert.Equals(t, "error storing x509_certs/2: key already exists", err.Error())
+}
+
+func TestExport_error(t *testing.T) {
+	db := &MockNoSQLDB{
+		MList: func(bucket []byte) ([]*database.Entry, error) {
+			return nil, errors.New("force")
+		},
+	}
+	_, err := Export(db, new(bytes.Buffer), [][]byte{certsTable})
+	assert.Equals(t, "error listing table x509_certs: force", err.Error())
+}
+
+func TestImport_error(t *testing.T) {
+	archive := func(records ...interface{}) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		enc := json.NewEncoder(zw)
+		for _, r := range records {
+			assert.FatalError(t, enc.Encode(r))
+		}
+		assert.FatalError(t, zw.Close())
+		return buf.Bytes()
+	}
+	header := &ArchiveHeader{Version: 1, Tables: []string{"x509_certs"}}
+	entry := &ArchiveEntry{Table: "x509_certs", Key: []byte("1"), Value: []byte("cert")}
+
+	tests := map[string]struct {
+		db      *MockNoSQLDB
+		archive []byte
+		err     error
+	}{
+		"fail/gzip": {
+			db:      newMapDB(map[string]map[string][]byte{}),
+			archive: []byte("not an archive"),
+			err:     errors.New("error reading archive"),
+		},
+		"fail/version": {
+			db:      newMapDB(map[string]map[string][]byte{}),
+			archive: archive(&ArchiveHeader{Version: 2}),
+			err:     errors.New("unsupported archive version 2"),
+		},
+		"fail/entry": {
+			db:      newMapDB(map[string]map[string][]byte{}),
+			archive: archive(header, "entry"),
+			err:     errors.New("error reading archive"),
+		},
+		"fail/create-table": {
+			db: &MockNoSQLDB{
+				MCreateTable: func(bucket []byte) error { return errors.New("force") },
+			},
+			archive: archive(header, entry),
+			err:     errors.New("error creating table x509_certs: force"),
+		},
+		"fail/cmpAndSwap": {
+			db: &MockNoSQLDB{
+				MCreateTable: func(bucket []byte) error { return nil },
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					return nil, false, errors.New("force")
+				},
+			},
+			archive: archive(header, entry),
+			err:     errors.New("error storing x509_certs/1: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Import(tc.db, bytes.NewReader(tc.archive))
+			if assert.NotNil(t, err) {
+				assert.HasPrefix(t, err.Error(), tc.err.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package db
+
+import (
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/metrics"
+	"github.com/smallstep/nosql"
+	"github.com/smallstep/nosql/database"
+)
+
+var (
+	dbDuration = metrics.NewHistogram("ca_db_duration_seconds",
+		"Duration of the database operations, by operation, table and result.",
+		[]float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}, "operation", "table", "result")
+	dbSlowOperations = metrics.NewCounter("ca_db_slow_operations_total",
+		"Number of database operations slower than the slowOperationThreshold, by operation and table.",
+		"operation", "table")
+)
+
+// multipleTables is the table label of the transactions that use more than one
+// table.
+const multipleTables = "multiple"
+
+// parseSlowOperationThreshold parses the slowOperationThreshold in the
+// configuration, 0 disables the log of slow operations.
+func parseSlowOperationThreshold(c *Config) (time.Duration, error) {
+	if c.SlowOperationThreshold == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(c.SlowOperationThreshold)
+	switch {
+	case err != nil:
+		return 0, errors.Wrap(err, "error parsing db slowOperationThreshold")
+	case d < 0:
+		return 0, errors.New("db slowOperationThreshold cannot be negative")
+	default:
+		return d, nil
+	}
+}
+
+// metricsDB is a nosql.DB that records the duration of the database
+// operations by table. Records not found are not considered errors. If
+// slowThreshold is greater than 0, the operations that take longer are logged.
+type metricsDB struct {
+	nosql.DB
+	slowThreshold time.Duration
+}
+
+func (db *metricsDB) observe(op string, table []byte, start time.Time, err error) {
+	d := time.Since(start)
+	result := "success"
+	if err != nil && !nosql.IsErrNotFound(err) {
+		result = "error"
+	}
+	dbDuration.Observe(d.Seconds(), op, string(table), result)
+	if db.slowThreshold > 0 && d >= db.slowThreshold {
+		dbSlowOperations.Inc(op, string(table))
+		log.Printf("slow database operation: %s on table %s took %s", op, table, d)
+	}
+}
+
+// txTable returns the table used by the transaction, or multipleTables if it
+// uses more than one.
+func txTable(tx *database.Tx) []byte {
+	var table []byte
+	for i, op := range tx.Operations {
+		if i > 0 && string(op.Bucket) != string(table) {
+			return []byte(multipleTables)
+		}
+		table = op.Bucket
+	}
+	return table
+}
+
+// Get implements the nosql.DB interface.
+func (db *metricsDB) Get(bucket, key []byte) ([]byte, error) {
+	start := time.Now()
+	b, err := db.DB.Get(bucket, key)
+	db.observe("get", bucket, start, err)
+	return b, err
+}
+
+// Set implements the nosql.DB interface.
+func (db *metricsDB) Set(bucket, key, value []byte) error {
+	start := time.Now()
+	err := db.DB.Set(bucket, key, value)
+	db.observe("set", bucket, start, err)
+	return err
+}
+
+// CmpAndSwap implements the nosql.DB interface.
+func (db *metricsDB) CmpAndSwap(bucket, key, oldValue, newValue []byte) ([]byte, bool, error) {
+	start := time.Now()
+	b, swapped, err := db.DB.CmpAndSwap(bucket, key, oldValue, newValue)
+	db.observe("cmpAndSwap", bucket, start, err)
+	return b, swapped, err
+}
+
+// Del implements the nosql.DB interface.
+func (db *metricsDB) Del(bucket, key []byte) error {
+	start := time.Now()
+	err := db.DB.Del(bucket, key)
+	db.observe("del", bucket, start, err)
+	return err
+}
+
+// List implements the nosql.DB interface.
+func (db *metricsDB) List(bucket []byte) ([]*database.Entry, error) {
+	start := time.Now()
+	entries, err := db.DB.List(bucket)
+	db.observe("list", bucket, start, err)
+	return entries, err
+}
+
+// BatchGet implements the BatchDB interface.
+func (db *metricsDB) BatchGet(bucket []byte, keys [][]byte) ([][]byte, error) {
+	start := time.Now()
+	values, err := BatchGet(db.DB, bucket, keys)
+	db.observe("batchGet", bucket, start, err)
+	return values, err
+}
+
+// Update implements the nosql.DB interface.
+func (db *metricsDB) Update(tx *database.Tx) error {
+	start := time.Now()
+	err := db.DB.Update(tx)
+	db.observe("update", txTable(tx), start, err)
+	return err
+}
+
+// SetWithTTL implements the TTLDB interface.
+func (db *metricsDB) SetWithTTL(bucket, key, value []byte, ttl time.Duration) error {
+	start := time.Now()
+	err := SetWithTTL(db.DB, bucket, key, value, ttl)
+	db.observe("set", bucket, start, err)
+	return err
+}
+
+// CmpAndSwapWithTTL implements the TTLDB interface.
+func (db *metricsDB) CmpAndSwapWithTTL(bucket, key, oldValue, newValue []byte, ttl time.Duration) ([]byte, bool, error) {
+	start := time.Now()
+	b, swapped, err := CmpAndSwapWithTTL(db.DB, bucket, key, oldValue, newValue, ttl)
+	db.observe("cmpAndSwap", bucket, start, err)
+	return b, swapped, err
+}
+
+// UpdateWithTTL implements the TTLDB interface.
+func (db *metricsDB) UpdateWithTTL(tx *database.Tx, ttl time.Duration) error {
+	start := time.Now()
+	err := UpdateWithTTL(db.DB, tx, ttl)
+	db.observe("update", txTable(tx), start, err)
+	return err
+}
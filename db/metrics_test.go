@@ -0,0 +1,119 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/nosql/database"
+)
+
+func TestMetricsDB(t *testing.T) {
+	db := &metricsDB{DB: &MockNoSQLDB{
+		MGet: func(bucket, key []byte) ([]byte, error) {
+			if string(key) == "missing" {
+				return nil, database.ErrNotFound
+			}
+			return []byte("value"), nil
+		},
+		MSet: func(bucket, key, value []byte) error {
+			return errors.New("force")
+		},
+		MUpdate: func(tx *database.Tx) error {
+			return nil
+		},
+	}}
+
+	getSuccess := dbDuration.Count("get", "bucket", "success")
+	setError := dbDuration.Count("set", "bucket", "error")
+	updateSuccess := dbDuration.Count("update", "multiple", "success")
+
+	b, err := db.Get([]byte("bucket"), []byte("key"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("value"), b)
+	_, err = db.Get([]byte("bucket"), []byte("missing"))
+	assert.True(t, database.IsErrNotFound(err))
+	assert.Equals(t, getSuccess+2, dbDuration.Count("get", "bucket", "success"))
+
+	assert.NotNil(t, db.Set([]byte("bucket"), []byte("key"), []byte("value")))
+	assert.Equals(t, setError+1, dbDuration.Count("set", "bucket", "error"))
+
+	tx := new(database.Tx)
+	tx.Set([]byte("bucket"), []byte("key"), []byte("value"))
+	tx.Set([]byte("other"), []byte("key"), []byte("value"))
+	assert.FatalError(t, db.Update(tx))
+	assert.Equals(t, updateSuccess+1, dbDuration.Count("update", "multiple", "success"))
+}
+
+func TestMetricsDB_slowOperations(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	mock := &MockNoSQLDB{
+		MGet: func(bucket, key []byte) ([]byte, error) {
+			if string(key) == "slow" {
+				time.Sleep(20 * time.Millisecond)
+			}
+			return []byte("value"), nil
+		},
+	}
+	slow := dbSlowOperations.Value("get", "slow_table")
+
+	db := &metricsDB{DB: mock, slowThreshold: 10 * time.Millisecond}
+	_, err := db.Get([]byte("slow_table"), []byte("fast"))
+	assert.FatalError(t, err)
+	assert.Equals(t, "", buf.String())
+	_, err = db.Get([]byte("slow_table"), []byte("slow"))
+	assert.FatalError(t, err)
+	assert.True(t, strings.Contains(buf.String(), "slow database operation: get on table slow_table took"))
+	assert.Equals(t, slow+1, dbSlowOperations.Value("get", "slow_table"))
+
+	// Slow operations are not logged without a threshold.
+	buf.Reset()
+	db = &metricsDB{DB: mock}
+	_, err = db.Get([]byte("slow_table"), []byte("slow"))
+	assert.FatalError(t, err)
+	assert.Equals(t, "", buf.String())
+	assert.Equals(t, slow+1, dbSlowOperations.Value("get", "slow_table"))
+}
+
+func TestTxTable(t *testing.T) {
+	tx := new(database.Tx)
+	assert.Equals(t, []byte(nil), txTable(tx))
+	tx.Set([]byte("a"), []byte("1"), []byte("v"))
+	tx.Del([]byte("a"), []byte("2"))
+	assert.Equals(t, []byte("a"), txTable(tx))
+	tx.Set([]byte("b"), []byte("1"), []byte("v"))
+	assert.Equals(t, []byte("multiple"), txTable(tx))
+}
+
+func TestParseSlowOperationThreshold(t *testing.T) {
+	tests := map[string]struct {
+		value string
+		want  time.Duration
+		err   error
+	}{
+		"ok/empty":      {"", 0, nil},
+		"ok":            {"250ms", 250 * time.Millisecond, nil},
+		"fail/parse":    {"foo", 0, errors.New("error parsing db slowOperationThreshold")},
+		"fail/negative": {"-1s", 0, errors.New("db slowOperationThreshold cannot be negative")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			d, err := parseSlowOperationThreshold(&Config{SlowOperationThreshold: tc.value})
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, tc.want, d)
+			}
+		})
+	}
+}
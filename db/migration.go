@@ -0,0 +1,426 @@
+package db
+
+import (
+	"bytes"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/metrics"
+	"github.com/smallstep/nosql"
+	"github.com/smallstep/nosql/database"
+)
+
+var migrationKeys = metrics.NewCounter("ca_db_migration_keys_total",
+	"Number of keys written by the database migration, by result.", "result")
+
+// migrationRetryInterval is the time between the verifications of a migration
+// that found differences.
+var migrationRetryInterval = 10 * time.Second
+
+// Migration states.
+const (
+	MigrationPending   = "pending"
+	MigrationCopying   = "copying"
+	MigrationVerifying = "verifying"
+	MigrationComplete  = "complete"
+)
+
+// MigrationStatus is the progress of an online database migration.
+type MigrationStatus struct {
+	State    string `json:"state"`
+	Copied   int    `json:"copied"`
+	Repaired int    `json:"repaired"`
+	Error    string `json:"error,omitempty"`
+}
+
+// migrationDB is a nosql.DB that migrates the data from one database to
+// another while the CA is running. The source database is still the one used
+// by the CA, all the reads are sent to it, and the writes that succeed on it
+// are repeated on the target database.
+//
+// Once started, the existing data is copied to the target in the background,
+// without replacing the keys already written by the CA. Then the tables in both
+// databases are compared and the differences are fixed with the current value
+// in the source, until a comparison does not find any difference.
+type migrationDB struct {
+	nosql.DB
+	target  nosql.DB
+	once    sync.Once
+	done    chan struct{}
+	recheck chan struct{}
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	status  MigrationStatus
+}
+
+func newMigrationDB(source, target nosql.DB) *migrationDB {
+	return &migrationDB{
+		DB:      source,
+		target:  target,
+		done:    make(chan struct{}),
+		recheck: make(chan struct{}, 1),
+		status:  MigrationStatus{State: MigrationPending},
+	}
+}
+
+// StartMigration starts the migration of the given tables if the database is
+// configured with migrateFrom. It returns false otherwise.
+func (db *DB) StartMigration(tables [][]byte) bool {
+	if m, ok := db.migrationDB(); ok {
+		m.StartMigration(tables)
+		return true
+	}
+	return false
+}
+
+// MigrationStatus returns the status of the migration, and false if the
+// database is not configured with migrateFrom.
+func (db *DB) MigrationStatus() (MigrationStatus, bool) {
+	if m, ok := db.migrationDB(); ok {
+		return m.MigrationStatus(), true
+	}
+	return MigrationStatus{}, false
+}
+
+func (db *DB) migrationDB() (*migrationDB, bool) {
+	d := db.DB
+	if m, ok := d.(*metricsDB); ok {
+		d = m.DB
+	}
+	m, ok := d.(*migrationDB)
+	return m, ok
+}
+
+// StartMigration starts the copy of the given tables in the background. Only
+// the first call has any effect.
+func (db *migrationDB) StartMigration(tables [][]byte) {
+	db.once.Do(func() {
+		db.wg.Add(1)
+		go db.run(tables)
+	})
+}
+
+// MigrationStatus returns the current status of the migration.
+func (db *migrationDB) MigrationStatus() MigrationStatus {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.status
+}
+
+func (db *migrationDB) setState(state string, err error) {
+	db.mu.Lock()
+	db.status.State = state
+	db.status.Error = ""
+	if err != nil {
+		db.status.Error = err.Error()
+	}
+	db.mu.Unlock()
+}
+
+func (db *migrationDB) count(copied, repaired int) {
+	db.mu.Lock()
+	db.status.Copied += copied
+	db.status.Repaired += repaired
+	db.mu.Unlock()
+	if copied > 0 {
+		migrationKeys.Add(float64(copied), "copied")
+	}
+	if repaired > 0 {
+		migrationKeys.Add(float64(repaired), "repaired")
+	}
+}
+
+// run copies the tables and verifies them until there are no differences. If
+// a write to the target fails later, the tables are verified again.
+func (db *migrationDB) run(tables [][]byte) {
+	defer db.wg.Done()
+
+	db.setState(MigrationCopying, nil)
+	for {
+		err := db.copy(tables)
+		if db.closed() {
+			return
+		}
+		if err == nil {
+			break
+		}
+		log.Printf("error copying database: %v", err)
+		db.setState(MigrationCopying, err)
+		if !db.wait(migrationRetryInterval) {
+			return
+		}
+	}
+
+	for {
+		db.setState(MigrationVerifying, nil)
+		repaired, err := db.verify(tables)
+		if db.closed() {
+			return
+		}
+		switch {
+		case err != nil:
+			log.Printf("error verifying database migration: %v", err)
+			db.setState(MigrationVerifying, err)
+		case repaired > 0:
+			log.Printf("database migration verification fixed %d keys", repaired)
+		default:
+			status := db.MigrationStatus()
+			log.Printf("database migration complete: %d keys copied, %d keys fixed", status.Copied, status.Repaired)
+			db.setState(MigrationComplete, nil)
+			select {
+			case <-db.done:
+				return
+			case <-db.recheck:
+			}
+			continue
+		}
+		if !db.wait(migrationRetryInterval) {
+			return
+		}
+	}
+}
+
+// wait waits for the given time and returns false if the database has been
+// closed.
+func (db *migrationDB) wait(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-db.done:
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// copy creates in the target all the keys in the source tables that do not
+// exist in the target.
+func (db *migrationDB) copy(tables [][]byte) error {
+	for _, t := range tables {
+		if err := db.target.CreateTable(t); err != nil {
+			return errors.Wrapf(err, "error creating table %s", string(t))
+		}
+		entries, err := db.DB.List(t)
+		switch {
+		case nosql.IsErrNotFound(err):
+			continue
+		case err != nil:
+			return errors.Wrapf(err, "error listing table %s", string(t))
+		}
+		var copied int
+		for _, e := range entries {
+			if db.closed() {
+				return nil
+			}
+			_, swapped, err := db.target.CmpAndSwap(t, e.Key, nil, e.Value)
+			if err != nil {
+				return errors.Wrapf(err, "error copying %s/%s", string(t), e.Key)
+			}
+			if swapped {
+				copied++
+			}
+		}
+		db.count(copied, 0)
+	}
+	return nil
+}
+
+// verify compares the tables in the source and the target, and fixes the
+// differences with the current value in the source. It returns the number of
+// keys fixed.
+func (db *migrationDB) verify(tables [][]byte) (repaired int, err error) {
+	defer func() {
+		db.count(0, repaired)
+	}()
+	for _, t := range tables {
+		src, err := listMap(db.DB, t)
+		if err != nil {
+			return repaired, err
+		}
+		dst, err := listMap(db.target, t)
+		if err != nil {
+			return repaired, err
+		}
+		var keys []string
+		for k, v := range src {
+			if w, ok := dst[k]; !ok || !bytes.Equal(v, w) {
+				keys = append(keys, k)
+			}
+		}
+		for k := range dst {
+			if _, ok := src[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		for _, k := range keys {
+			if db.closed() {
+				return repaired, nil
+			}
+			if err := db.repair(t, []byte(k)); err != nil {
+				return repaired, err
+			}
+			repaired++
+		}
+	}
+	return repaired, nil
+}
+
+// repair replaces the key in the target with the current value in the source.
+func (db *migrationDB) repair(bucket, key []byte) error {
+	v, err := db.DB.Get(bucket, key)
+	switch {
+	case nosql.IsErrNotFound(err):
+		err = db.target.Del(bucket, key)
+	case err == nil:
+		err = db.target.Set(bucket, key, v)
+	}
+	return errors.Wrapf(err, "error fixing %s/%s", bucket, key)
+}
+
+func (db *migrationDB) closed() bool {
+	select {
+	case <-db.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// listMap returns the keys and values in a table.
+func listMap(db nosql.DB, bucket []byte) (map[string][]byte, error) {
+	entries, err := db.List(bucket)
+	switch {
+	case nosql.IsErrNotFound(err):
+		return map[string][]byte{}, nil
+	case err != nil:
+		return nil, errors.Wrapf(err, "error listing table %s", string(bucket))
+	}
+	m := make(map[string][]byte, len(entries))
+	for _, e := range entries {
+		m[string(e.Key)] = e.Value
+	}
+	return m, nil
+}
+
+// mirror logs the error of a write in the target and schedules a new
+// verification. The write already succeeded in the source, so the error is
+// not returned.
+func (db *migrationDB) mirror(err error) {
+	if err == nil {
+		return
+	}
+	log.Printf("error writing in the database migration target: %v", err)
+	migrationKeys.Inc("error")
+	select {
+	case db.recheck <- struct{}{}:
+	default:
+	}
+}
+
+// BatchGet implements the BatchDB interface. Like Get, it reads from the
+// source.
+func (db *migrationDB) BatchGet(bucket []byte, keys [][]byte) ([][]byte, error) {
+	return BatchGet(db.DB, bucket, keys)
+}
+
+// Set implements the nosql.DB interface.
+func (db *migrationDB) Set(bucket, key, value []byte) error {
+	return db.SetWithTTL(bucket, key, value, 0)
+}
+
+// SetWithTTL implements the TTLDB interface.
+func (db *migrationDB) SetWithTTL(bucket, key, value []byte, ttl time.Duration) error {
+	if err := SetWithTTL(db.DB, bucket, key, value, ttl); err != nil {
+		return err
+	}
+	db.mirror(errors.Wrapf(SetWithTTL(db.target, bucket, key, value, ttl), "error setting %s/%s", bucket, key))
+	return nil
+}
+
+// CmpAndSwap implements the nosql.DB interface.
+func (db *migrationDB) CmpAndSwap(bucket, key, oldValue, newValue []byte) ([]byte, bool, error) {
+	return db.CmpAndSwapWithTTL(bucket, key, oldValue, newValue, 0)
+}
+
+// CmpAndSwapWithTTL implements the TTLDB interface. If the value is swapped
+// in the source, it's set in the target.
+func (db *migrationDB) CmpAndSwapWithTTL(bucket, key, oldValue, newValue []byte, ttl time.Duration) ([]byte, bool, error) {
+	b, swapped, err := CmpAndSwapWithTTL(db.DB, bucket, key, oldValue, newValue, ttl)
+	if err != nil || !swapped {
+		return b, swapped, err
+	}
+	db.mirror(errors.Wrapf(SetWithTTL(db.target, bucket, key, newValue, ttl), "error setting %s/%s", bucket, key))
+	return b, swapped, nil
+}
+
+// Del implements the nosql.DB interface.
+func (db *migrationDB) Del(bucket, key []byte) error {
+	if err := db.DB.Del(bucket, key); err != nil {
+		return err
+	}
+	db.mirror(errors.Wrapf(db.target.Del(bucket, key), "error deleting %s/%s", bucket, key))
+	return nil
+}
+
+// Update implements the nosql.DB interface.
+func (db *migrationDB) Update(tx *database.Tx) error {
+	return db.UpdateWithTTL(tx, 0)
+}
+
+// UpdateWithTTL implements the TTLDB interface. The writes done by the
+// transaction in the source are done in a transaction in the target.
+func (db *migrationDB) UpdateWithTTL(tx *database.Tx, ttl time.Duration) error {
+	if err := UpdateWithTTL(db.DB, tx, ttl); err != nil {
+		return err
+	}
+	mirror := new(database.Tx)
+	for _, op := range tx.Operations {
+		switch {
+		case op.Cmd == database.Set, op.Cmd == database.CmpAndSwap && op.Swapped:
+			mirror.Set(op.Bucket, op.Key, op.Value)
+		case op.Cmd == database.Delete:
+			mirror.Del(op.Bucket, op.Key)
+		case op.Cmd == database.CreateTable:
+			mirror.CreateTable(op.Bucket)
+		case op.Cmd == database.DeleteTable:
+			mirror.DeleteTable(op.Bucket)
+		}
+	}
+	if len(mirror.Operations) > 0 {
+		db.mirror(errors.Wrap(UpdateWithTTL(db.target, mirror, ttl), "error updating transaction"))
+	}
+	return nil
+}
+
+// CreateTable implements the nosql.DB interface.
+func (db *migrationDB) CreateTable(bucket []byte) error {
+	if err := db.DB.CreateTable(bucket); err != nil {
+		return err
+	}
+	return db.target.CreateTable(bucket)
+}
+
+// DeleteTable implements the nosql.DB interface.
+func (db *migrationDB) DeleteTable(bucket []byte) error {
+	if err := db.DB.DeleteTable(bucket); err != nil {
+		return err
+	}
+	return db.target.DeleteTable(bucket)
+}
+
+// Close stops the migration and closes both databases.
+func (db *migrationDB) Close() error {
+	select {
+	case <-db.done:
+	default:
+		close(db.done)
+	}
+	db.wg.Wait()
+	err := db.DB.Close()
+	if terr := db.target.Close(); err == nil {
+		err = terr
+	}
+	return err
+}
@@ -0,0 +1,164 @@
+package db
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/nosql"
+	"github.com/smallstep/nosql/database"
+)
+
+func waitMigration(t *testing.T, d *DB) MigrationStatus {
+	for i := 0; i < 100; i++ {
+		status, ok := d.MigrationStatus()
+		assert.True(t, ok)
+		if status.State == MigrationComplete {
+			return status
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("migration did not complete")
+	return MigrationStatus{}
+}
+
+func TestMigrationDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migration")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+
+	source := &Config{Type: "badgerv2", DataSource: filepath.Join(dir, "source")}
+	adb, err := New(source)
+	assert.FatalError(t, err)
+	acmeTable := []byte("acme_accounts")
+	assert.FatalError(t, adb.(*DB).CreateTable(acmeTable))
+	assert.FatalError(t, adb.(*DB).Set(certsTable, []byte("1"), []byte("cert1")))
+	assert.FatalError(t, adb.(*DB).Set(certsTable, []byte("2"), []byte("cert2")))
+	assert.FatalError(t, adb.(*DB).Set(acmeTable, []byte("a"), []byte("account")))
+	assert.FatalError(t, adb.Shutdown())
+
+	adb, err = New(&Config{
+		Type:        "badgerv2",
+		DataSource:  filepath.Join(dir, "target"),
+		MigrateFrom: source,
+	})
+	assert.FatalError(t, err)
+	defer adb.Shutdown()
+	d := adb.(*DB)
+	m, ok := d.migrationDB()
+	assert.True(t, ok)
+	status, ok := d.MigrationStatus()
+	assert.True(t, ok)
+	assert.Equals(t, MigrationPending, status.State)
+
+	// Writes are sent to both databases before and during the migration.
+	assert.FatalError(t, d.Set(certsTable, []byte("3"), []byte("cert3")))
+	b, err := m.target.Get(certsTable, []byte("3"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("cert3"), b)
+	_, err = m.target.Get(certsTable, []byte("1"))
+	assert.True(t, nosql.IsErrNotFound(err))
+
+	assert.True(t, d.StartMigration(append(Tables(), acmeTable)))
+	assert.True(t, d.StartMigration(append(Tables(), acmeTable)))
+	status = waitMigration(t, d)
+	assert.Equals(t, 3, status.Copied)
+	assert.Equals(t, 0, status.Repaired)
+	for _, k := range []string{"1", "2", "3"} {
+		b, err := m.target.Get(certsTable, []byte(k))
+		assert.FatalError(t, err)
+		assert.Equals(t, []byte("cert"+k), b)
+	}
+	b, err = m.target.Get(acmeTable, []byte("a"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("account"), b)
+
+	// Swaps, deletes and transactions are mirrored.
+	_, swapped, err := d.CmpAndSwap(certsTable, []byte("1"), []byte("cert1"), []byte("new1"))
+	assert.FatalError(t, err)
+	assert.True(t, swapped)
+	_, swapped, err = d.CmpAndSwap(certsTable, []byte("2"), []byte("other"), []byte("new2"))
+	assert.FatalError(t, err)
+	assert.False(t, swapped)
+	assert.FatalError(t, d.Del(certsTable, []byte("3")))
+	tx := new(database.Tx)
+	tx.Set(certsTable, []byte("4"), []byte("cert4"))
+	tx.Del(acmeTable, []byte("a"))
+	assert.FatalError(t, d.Update(tx))
+
+	src, err := listMap(m.DB, certsTable)
+	assert.FatalError(t, err)
+	dst, err := listMap(m.target, certsTable)
+	assert.FatalError(t, err)
+	assert.Equals(t, map[string][]byte{"1": []byte("new1"), "2": []byte("cert2"), "4": []byte("cert4")}, src)
+	assert.Equals(t, src, dst)
+	_, err = m.target.Get(acmeTable, []byte("a"))
+	assert.True(t, nosql.IsErrNotFound(err))
+
+	// The verification fixes the differences.
+	assert.FatalError(t, m.target.Set(certsTable, []byte("1"), []byte("stale")))
+	assert.FatalError(t, m.target.Set(certsTable, []byte("5"), []byte("deleted")))
+	assert.FatalError(t, m.target.Del(certsTable, []byte("4")))
+	repaired, err := m.verify([][]byte{certsTable})
+	assert.FatalError(t, err)
+	assert.Equals(t, 3, repaired)
+	dst, err = listMap(m.target, certsTable)
+	assert.FatalError(t, err)
+	assert.Equals(t, src, dst)
+}
+
+func TestMigrationDB_mirrorError(t *testing.T) {
+	source := &MockNoSQLDB{
+		MSet: func(bucket, key, value []byte) error { return nil },
+		MDel: func(bucket, key []byte) error { return nil },
+	}
+	target := &MockNoSQLDB{
+		MSet: func(bucket, key, value []byte) error { return errors.New("force") },
+		MDel: func(bucket, key []byte) error { return errors.New("force") },
+	}
+	m := newMigrationDB(source, target)
+	errors0 := migrationKeys.Value("error")
+
+	// Errors in the target are not returned, but a verification is scheduled.
+	assert.FatalError(t, m.Set(certsTable, []byte("1"), []byte("cert")))
+	assert.FatalError(t, m.Del(certsTable, []byte("1")))
+	assert.Equals(t, errors0+2, migrationKeys.Value("error"))
+	select {
+	case <-m.recheck:
+	default:
+		t.Error("verification not scheduled")
+	}
+
+	// Errors in the source are returned.
+	source.MSet = func(bucket, key, value []byte) error { return errors.New("source") }
+	assert.Equals(t, "source", m.Set(certsTable, []byte("1"), []byte("cert")).Error())
+}
+
+func TestNew_migrateFrom(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migration")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = New(&Config{
+		Type:       "badgerv2",
+		DataSource: filepath.Join(dir, "target"),
+		MigrateFrom: &Config{
+			Type:        "badgerv2",
+			DataSource:  filepath.Join(dir, "source"),
+			MigrateFrom: &Config{Type: "badgerv2", DataSource: filepath.Join(dir, "other")},
+		},
+	})
+	assert.Equals(t, "error opening the database to migrate from: it cannot define another migration", err.Error())
+
+	// Databases without migrateFrom are not migrated.
+	adb, err := New(&Config{Type: "badgerv2", DataSource: filepath.Join(dir, "target")})
+	assert.FatalError(t, err)
+	defer adb.Shutdown()
+	assert.False(t, adb.(*DB).StartMigration(Tables()))
+	_, ok := adb.(*DB).MigrationStatus()
+	assert.False(t, ok)
+}
@@ -0,0 +1,42 @@
+package db
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// healthTable is the table used by Ping, it's not exported or migrated.
+var healthTable = []byte("health")
+
+// pingKey is the key written by Ping. It's different in every process so the
+// replicas of a CA sharing a database do not interfere.
+var pingKey = func() []byte {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return []byte(hex.EncodeToString(b))
+}()
+
+// Ping checks that the database is available writing, reading and deleting a
+// key.
+func (db *DB) Ping() error {
+	value := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+	if err := SetWithTTL(db.DB, healthTable, pingKey, value, time.Minute); err != nil {
+		return errors.Wrap(err, "error writing to the database")
+	}
+	b, err := db.Get(healthTable, pingKey)
+	if err != nil {
+		return errors.Wrap(err, "error reading from the database")
+	}
+	if !bytes.Equal(b, value) {
+		return errors.New("error reading from the database: value does not match")
+	}
+	if err := db.Del(healthTable, pingKey); err != nil {
+		return errors.Wrap(err, "error deleting from the database")
+	}
+	return nil
+}
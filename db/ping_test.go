@@ -0,0 +1,65 @@
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+func TestDB_Ping(t *testing.T) {
+	var stored []byte
+	ok := MockNoSQLDB{
+		MSet: func(bucket, key, value []byte) error {
+			assert.Equals(t, healthTable, bucket)
+			assert.Equals(t, pingKey, key)
+			stored = value
+			return nil
+		},
+		MGet: func(bucket, key []byte) ([]byte, error) {
+			return stored, nil
+		},
+		MDel: func(bucket, key []byte) error {
+			return nil
+		},
+	}
+
+	tests := map[string]struct {
+		db  func() *MockNoSQLDB
+		err error
+	}{
+		"ok": {func() *MockNoSQLDB { m := ok; return &m }, nil},
+		"fail/set": {func() *MockNoSQLDB {
+			m := ok
+			m.MSet = func(bucket, key, value []byte) error { return errors.New("force") }
+			return &m
+		}, errors.New("error writing to the database: force")},
+		"fail/get": {func() *MockNoSQLDB {
+			m := ok
+			m.MGet = func(bucket, key []byte) ([]byte, error) { return nil, errors.New("force") }
+			return &m
+		}, errors.New("error reading from the database: force")},
+		"fail/value": {func() *MockNoSQLDB {
+			m := ok
+			m.MGet = func(bucket, key []byte) ([]byte, error) { return []byte("other"), nil }
+			return &m
+		}, errors.New("error reading from the database: value does not match")},
+		"fail/del": {func() *MockNoSQLDB {
+			m := ok
+			m.MDel = func(bucket, key []byte) error { return errors.New("force") }
+			return &m
+		}, errors.New("error deleting from the database: force")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := &DB{tc.db(), true}
+			if err := db.Ping(); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.Equals(t, tc.err.Error(), err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
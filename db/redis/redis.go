@@ -0,0 +1,174 @@
+// Package redis implements the Redis client used to share short lived state,
+// like the ACME nonces and caches, between multiple replicas of the CA. The
+// durable state is always kept in the database.
+package redis
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/secrets"
+)
+
+// DefaultPrefix is the prefix of all the keys stored by the CA if a prefix
+// is not configured.
+const DefaultPrefix = "step-ca"
+
+const requestTimeout = 5 * time.Second
+
+// ErrNotFound is the error returned if a key does not exist.
+var ErrNotFound = errors.New("redis: key not found")
+
+// Options are the options used to connect to Redis. Address is the host and
+// port of the server. If Username is set, the connection is authenticated
+// using the ACL of Redis 6, otherwise with the Password if it's set; the
+// password can be a reference to a secret in a secret manager. DB is
+// the number of the database, and all the keys are prefixed with Prefix,
+// step-ca by default. If TLS is true, the connection uses TLS and the server
+// is verified with the system roots or the certificates in CAFile.
+type Options struct {
+	Address  string `json:"address"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	DB       int    `json:"db,omitempty"`
+	Prefix   string `json:"prefix,omitempty"`
+	TLS      bool   `json:"tls,omitempty"`
+	CAFile   string `json:"caFile,omitempty"`
+}
+
+// Validate checks the fields in the Options.
+func (o *Options) Validate() error {
+	switch {
+	case o == nil:
+		return nil
+	case o.Address == "":
+		return errors.New("redis.address cannot be empty")
+	case o.DB < 0:
+		return errors.New("redis.db cannot be less than 0")
+	case o.CAFile != "" && !o.TLS:
+		return errors.New("redis.caFile requires redis.tls")
+	default:
+		if _, _, err := net.SplitHostPort(o.Address); err != nil {
+			return errors.Wrapf(err, "redis.address %s is not valid", o.Address)
+		}
+		return nil
+	}
+}
+
+// Client is a Redis client. It's safe for concurrent use, and it keeps a pool
+// of connections that are reused by the following commands.
+type Client struct {
+	client *redis.Client
+	prefix string
+}
+
+// New returns a new client with the given options. The connections are
+// established when the commands are sent.
+func New(o *Options) (*Client, error) {
+	if o == nil {
+		return nil, errors.New("redis options cannot be nil")
+	}
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	password, err := secrets.Resolve(context.Background(), o.Password)
+	if err != nil {
+		return nil, err
+	}
+	opts := &redis.Options{
+		Addr:         o.Address,
+		Username:     o.Username,
+		Password:     password,
+		DB:           o.DB,
+		DialTimeout:  requestTimeout,
+		ReadTimeout:  requestTimeout,
+		WriteTimeout: requestTimeout,
+	}
+	if o.TLS {
+		host, _, _ := net.SplitHostPort(o.Address)
+		opts.TLSConfig = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+		if o.CAFile != "" {
+			b, err := ioutil.ReadFile(o.CAFile)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error reading %s", o.CAFile)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(b) {
+				return nil, errors.Errorf("error parsing %s: no certificates found", o.CAFile)
+			}
+			opts.TLSConfig.RootCAs = pool
+		}
+	}
+	c := &Client{client: redis.NewClient(opts), prefix: o.Prefix}
+	if c.prefix == "" {
+		c.prefix = DefaultPrefix
+	}
+	return c, nil
+}
+
+// Key returns the key with the given parts, joined with colons and prefixed
+// with the configured prefix.
+func (c *Client) Key(parts ...string) string {
+	return c.prefix + ":" + strings.Join(parts, ":")
+}
+
+// Get returns the value of the given key, or ErrNotFound if it does not
+// exist.
+func (c *Client) Get(key string) ([]byte, error) {
+	b, err := c.client.Get(context.Background(), key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	return b, err
+}
+
+// Set stores the value of the given key. If ttl is greater than 0 the key
+// expires after it.
+func (c *Client) Set(key string, value []byte, ttl time.Duration) error {
+	return c.client.Set(context.Background(), key, value, expiration(ttl)).Err()
+}
+
+// SetNX stores the value of the given key only if it does not exist. It
+// returns true if the key was stored. If ttl is greater than 0 the key
+// expires after it.
+func (c *Client) SetNX(key string, value []byte, ttl time.Duration) (bool, error) {
+	return c.client.SetNX(context.Background(), key, value, expiration(ttl)).Result()
+}
+
+// expiration returns the expiration of a key with the given time to live. A
+// positive ttl lower than a millisecond is rounded up, so the key does not
+// live forever.
+func expiration(ttl time.Duration) time.Duration {
+	switch {
+	case ttl <= 0:
+		return 0
+	case ttl < time.Millisecond:
+		return time.Millisecond
+	default:
+		return ttl
+	}
+}
+
+// Del deletes the given key. It returns true if the key existed.
+func (c *Client) Del(key string) (bool, error) {
+	n, err := c.client.Del(context.Background(), key).Result()
+	return n > 0, err
+}
+
+// Ping checks the connection with Redis.
+func (c *Client) Ping() error {
+	return c.client.Ping(context.Background()).Err()
+}
+
+// Close closes the connections in the pool. The client cannot be used after
+// closing it.
+func (c *Client) Close() error {
+	return c.client.Close()
+}
@@ -0,0 +1,119 @@
+package redis
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/smallstep/assert"
+)
+
+func TestOptions_Validate(t *testing.T) {
+	tests := map[string]struct {
+		options *Options
+		err     error
+	}{
+		"ok/nil":        {nil, nil},
+		"ok":            {&Options{Address: "localhost:6379"}, nil},
+		"ok/tls":        {&Options{Address: "localhost:6379", TLS: true, CAFile: "ca.crt"}, nil},
+		"fail/address":  {&Options{}, errors.New("redis.address cannot be empty")},
+		"fail/port":     {&Options{Address: "localhost"}, errors.New("redis.address localhost is not valid")},
+		"fail/db":       {&Options{Address: "localhost:6379", DB: -1}, errors.New("redis.db cannot be less than 0")},
+		"fail/ca-noTLS": {&Options{Address: "localhost:6379", CAFile: "ca.crt"}, errors.New("redis.caFile requires redis.tls")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tc.options.Validate(); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestClient(t *testing.T) {
+	m, err := miniredis.Run()
+	assert.FatalError(t, err)
+	defer m.Close()
+
+	c, err := New(&Options{Address: m.Addr()})
+	assert.FatalError(t, err)
+	defer c.Close()
+	assert.Equals(t, "step-ca:nonces:abc", c.Key("nonces", "abc"))
+	assert.FatalError(t, c.Ping())
+
+	_, err = c.Get("foo")
+	assert.Equals(t, ErrNotFound, err)
+	assert.FatalError(t, c.Set("foo", []byte("bar"), 0))
+	b, err := c.Get("foo")
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("bar"), b)
+	assert.Equals(t, time.Duration(0), m.TTL("foo"))
+
+	ok, err := c.SetNX("foo", []byte("other"), 0)
+	assert.FatalError(t, err)
+	assert.False(t, ok)
+	ok, err = c.SetNX("ttl", []byte("value"), 1500*time.Millisecond)
+	assert.FatalError(t, err)
+	assert.True(t, ok)
+	assert.Equals(t, 1500*time.Millisecond, m.TTL("ttl"))
+	m.FastForward(2 * time.Second)
+	_, err = c.Get("ttl")
+	assert.Equals(t, ErrNotFound, err)
+
+	ok, err = c.Del("foo")
+	assert.FatalError(t, err)
+	assert.True(t, ok)
+	ok, err = c.Del("foo")
+	assert.FatalError(t, err)
+	assert.False(t, ok)
+
+	// Errors are returned as they are.
+	m.SetError("LOADING Redis is loading the dataset in memory")
+	_, err = c.Get("foo")
+	assert.Equals(t, "LOADING Redis is loading the dataset in memory", err.Error())
+	m.SetError("")
+	assert.FatalError(t, c.Ping())
+
+	assert.FatalError(t, c.Close())
+	assert.NotNil(t, c.Ping())
+}
+
+func TestClient_auth(t *testing.T) {
+	m, err := miniredis.Run()
+	assert.FatalError(t, err)
+	defer m.Close()
+	m.RequireUserAuth("step", "secret")
+
+	c, err := New(&Options{Address: m.Addr(), Username: "step", Password: "secret", DB: 2, Prefix: "ca"})
+	assert.FatalError(t, err)
+	defer c.Close()
+	assert.FatalError(t, c.Set(c.Key("foo"), []byte("bar"), time.Minute))
+	v, err := m.DB(2).Get("ca:foo")
+	assert.FatalError(t, err)
+	assert.Equals(t, "bar", v)
+	assert.Equals(t, time.Minute, m.DB(2).TTL("ca:foo"))
+
+	c, err = New(&Options{Address: m.Addr(), Username: "step", Password: "wrong"})
+	assert.FatalError(t, err)
+	defer c.Close()
+	err = c.Ping()
+	if assert.NotNil(t, err) {
+		assert.HasPrefix(t, err.Error(), "WRONGPASS")
+	}
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(nil)
+	assert.Equals(t, "redis options cannot be nil", err.Error())
+	_, err = New(&Options{Address: "localhost:6379", TLS: true, CAFile: "testdata/missing.crt"})
+	assert.HasPrefix(t, err.Error(), "error reading testdata/missing.crt")
+	c, err := New(&Options{Address: "localhost:6379", TLS: true})
+	assert.FatalError(t, err)
+	defer c.Close()
+	assert.Equals(t, "localhost", c.client.Options().TLSConfig.ServerName)
+}
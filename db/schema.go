@@ -0,0 +1,138 @@
+package db
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/nosql"
+)
+
+// schemaTable contains the version of the schema of each component of the CA,
+// indexed by the name of the component.
+var schemaTable = []byte("schema")
+
+// SchemaComponent is the name used to store the schema version of the
+// objects of the authority.
+const SchemaComponent = "ca"
+
+// maxSchemaRetries is the maximum number of attempts to store a new schema
+// version if it's modified concurrently by another instance of the CA.
+const maxSchemaRetries = 10
+
+// SchemaMigration is a change in the format of the stored objects. Migrate
+// upgrades the existing objects to the format of the given Version. It must
+// be idempotent, as it runs again if the CA stops before the new version is
+// stored, or if multiple instances of the CA are upgraded at the same time.
+type SchemaMigration struct {
+	Version     int
+	Description string
+	Migrate     func(db nosql.DB) error
+}
+
+// SchemaMigrations returns the migrations of the objects stored by the
+// authority.
+func SchemaMigrations() []SchemaMigration {
+	return []SchemaMigration{
+		{Version: 1, Description: "store the expiration of the used tokens", Migrate: migrateUsedTokens},
+	}
+}
+
+// SchemaVersion returns the version of the schema of the given component, or
+// 0 if it has never been migrated.
+func SchemaVersion(db nosql.DB, component string) (int, error) {
+	_, v, err := getSchemaVersion(db, component)
+	return v, err
+}
+
+func getSchemaVersion(db nosql.DB, component string) ([]byte, int, error) {
+	b, err := db.Get(schemaTable, []byte(component))
+	switch {
+	case nosql.IsErrNotFound(err):
+		return nil, 0, nil
+	case err != nil:
+		return nil, 0, errors.Wrapf(err, "error loading %s schema version", component)
+	}
+	v, err := strconv.Atoi(string(b))
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "error parsing %s schema version", component)
+	}
+	return b, v, nil
+}
+
+// MigrateSchema runs in order the migrations of the given component with a
+// version greater than the stored one, and stores the new version after each
+// one. It fails if the stored version is greater than the version of the last
+// migration, e.g. if the CA is downgraded after an upgrade, as the objects
+// could not be read correctly.
+func MigrateSchema(db nosql.DB, component string, migrations []SchemaMigration) error {
+	var latest int
+	for _, m := range migrations {
+		if m.Version <= latest {
+			return errors.Errorf("%s schema migration %d is not sorted", component, m.Version)
+		}
+		latest = m.Version
+	}
+
+	// The retries are only counted if the version was modified concurrently.
+	for retries := 0; retries < maxSchemaRetries; {
+		old, version, err := getSchemaVersion(db, component)
+		if err != nil {
+			return err
+		}
+		if version > latest {
+			return errors.Errorf("%s schema version %d is newer than the supported version %d", component, version, latest)
+		}
+		var next *SchemaMigration
+		for j := range migrations {
+			if migrations[j].Version > version {
+				next = &migrations[j]
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+
+		log.Printf("migrating %s schema to version %d: %s", component, next.Version, next.Description)
+		if err := next.Migrate(db); err != nil {
+			return errors.Wrapf(err, "error migrating %s schema to version %d", component, next.Version)
+		}
+		_, swapped, err := db.CmpAndSwap(schemaTable, []byte(component), old, []byte(strconv.Itoa(next.Version)))
+		if err != nil {
+			return errors.Wrapf(err, "error storing %s schema version", component)
+		}
+		if !swapped {
+			retries++
+		}
+	}
+	return errors.Errorf("error storing %s schema version; too many concurrent updates", component)
+}
+
+// migrateUsedTokens rewrites the used tokens stored by old versions, that
+// stored the token itself, with the JSON representation of a usedToken, so
+// they are deleted by PurgeUsedTokens once they expire.
+func migrateUsedTokens(db nosql.DB) error {
+	entries, err := db.List(usedOTTTable)
+	switch {
+	case nosql.IsErrNotFound(err):
+		return nil
+	case err != nil:
+		return errors.Wrap(err, "error listing used tokens")
+	}
+	for _, e := range entries {
+		if err := json.Unmarshal(e.Value, new(usedToken)); err == nil {
+			continue
+		}
+		ut := parseUsedToken(e.Value)
+		b, err := json.Marshal(ut)
+		if err != nil {
+			return errors.Wrap(err, "error marshaling used token")
+		}
+		if _, _, err := CmpAndSwapWithTTL(db, usedOTTTable, e.Key, e.Value, b, ut.ttl()); err != nil {
+			return errors.Wrapf(err, "error storing used token %s", e.Key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/nosql"
+)
+
+func TestMigrateSchema(t *testing.T) {
+	dir, err := ioutil.TempDir("", "schema")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+
+	adb, err := New(&Config{Type: "badgerv2", DataSource: filepath.Join(dir, "db")})
+	assert.FatalError(t, err)
+	defer adb.Shutdown()
+	d := adb.(*DB)
+
+	var calls []int
+	migration := func(v int) SchemaMigration {
+		return SchemaMigration{Version: v, Description: "test", Migrate: func(db nosql.DB) error {
+			calls = append(calls, v)
+			return nil
+		}}
+	}
+
+	v, err := SchemaVersion(d, "test")
+	assert.FatalError(t, err)
+	assert.Equals(t, 0, v)
+
+	// Migrations run in order and only once.
+	assert.FatalError(t, MigrateSchema(d, "test", []SchemaMigration{migration(1), migration(2)}))
+	assert.Equals(t, []int{1, 2}, calls)
+	assert.FatalError(t, MigrateSchema(d, "test", []SchemaMigration{migration(1), migration(2), migration(5)}))
+	assert.Equals(t, []int{1, 2, 5}, calls)
+	assert.FatalError(t, MigrateSchema(d, "test", []SchemaMigration{migration(1), migration(2), migration(5)}))
+	assert.Equals(t, []int{1, 2, 5}, calls)
+	v, err = SchemaVersion(d, "test")
+	assert.FatalError(t, err)
+	assert.Equals(t, 5, v)
+
+	// Components have their own versions.
+	assert.FatalError(t, MigrateSchema(d, "other", []SchemaMigration{migration(1)}))
+	assert.Equals(t, []int{1, 2, 5, 1}, calls)
+
+	// A failed migration is not recorded.
+	fail := SchemaMigration{Version: 6, Description: "fail", Migrate: func(db nosql.DB) error {
+		return errors.New("force")
+	}}
+	err = MigrateSchema(d, "test", []SchemaMigration{migration(5), fail})
+	assert.Equals(t, "error migrating test schema to version 6: force", err.Error())
+	v, err = SchemaVersion(d, "test")
+	assert.FatalError(t, err)
+	assert.Equals(t, 5, v)
+
+	// Databases written by newer versions are rejected.
+	err = MigrateSchema(d, "test", []SchemaMigration{migration(1)})
+	assert.Equals(t, "test schema version 5 is newer than the supported version 1", err.Error())
+	err = MigrateSchema(d, "test", []SchemaMigration{migration(2), migration(1)})
+	assert.Equals(t, "test schema migration 1 is not sorted", err.Error())
+
+	assert.FatalError(t, d.Set(schemaTable, []byte("test"), []byte("foo")))
+	_, err = SchemaVersion(d, "test")
+	assert.HasPrefix(t, err.Error(), "error parsing test schema version")
+}
+
+func TestMigrateUsedTokens(t *testing.T) {
+	dir, err := ioutil.TempDir("", "schema")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+
+	adb, err := New(&Config{Type: "badgerv2", DataSource: filepath.Join(dir, "db")})
+	assert.FatalError(t, err)
+	defer adb.Shutdown()
+	d := adb.(*DB)
+
+	current, err := json.Marshal(&usedToken{UsedAt: 1, ExpiresAt: 4102444800})
+	assert.FatalError(t, err)
+	assert.FatalError(t, d.Set(usedOTTTable, []byte("legacy"), []byte("not-a-jwt")))
+	assert.FatalError(t, d.Set(usedOTTTable, []byte("current"), current))
+
+	assert.FatalError(t, MigrateSchema(d, SchemaComponent, SchemaMigrations()))
+	b, err := d.Get(usedOTTTable, []byte("legacy"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("{}"), b)
+	b, err = d.Get(usedOTTTable, []byte("current"))
+	assert.FatalError(t, err)
+	assert.Equals(t, current, b)
+	v, err := SchemaVersion(d, SchemaComponent)
+	assert.FatalError(t, err)
+	assert.Equals(t, 1, v)
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"crypto/x509"
+	"encoding/json"
 	"sync"
 	"time"
 
@@ -51,17 +52,25 @@ func (s *SimpleDB) StoreCertificate(crt *x509.Certificate) error {
 	return ErrNotImplemented
 }
 
-type usedToken struct {
-	UsedAt int64  `json:"ua,omitempty"`
-	Token  string `json:"tok,omitempty"`
+// GetCertificate returns a "NotImplemented" error.
+func (s *SimpleDB) GetCertificate(serialNumber string) (*x509.Certificate, error) {
+	return nil, ErrNotImplemented
 }
 
-// UseToken returns a "NotImplemented" error.
+// GetCertificateByFingerprint returns a "NotImplemented" error.
+func (s *SimpleDB) GetCertificateByFingerprint(fingerprint string) (*x509.Certificate, error) {
+	return nil, ErrNotImplemented
+}
+
+// GetRevokedCertificate returns a "NotImplemented" error.
+func (s *SimpleDB) GetRevokedCertificate(serialNumber string) (*RevokedCertificateInfo, error) {
+	return nil, ErrNotImplemented
+}
+
+// UseToken stores the token in memory, it returns false if the token was
+// already used.
 func (s *SimpleDB) UseToken(id, tok string) (bool, error) {
-	if _, ok := s.usedTokens.LoadOrStore(id, &usedToken{
-		UsedAt: time.Now().Unix(),
-		Token:  tok,
-	}); ok {
+	if _, ok := s.usedTokens.LoadOrStore(id, newUsedToken(tok)); ok {
 		// Token already exists in DB.
 		return false, nil
 	}
@@ -69,6 +78,20 @@ func (s *SimpleDB) UseToken(id, tok string) (bool, error) {
 	return true, nil
 }
 
+// PurgeUsedTokens deletes from memory the used tokens that expired before the
+// given time.
+func (s *SimpleDB) PurgeUsedTokens(before time.Time) (int, error) {
+	var n int
+	s.usedTokens.Range(func(key, value interface{}) bool {
+		if value.(*usedToken).expired(before) {
+			s.usedTokens.Delete(key)
+			n++
+		}
+		return true
+	})
+	return n, nil
+}
+
 // IsSSHHost returns a "NotImplemented" error.
 func (s *SimpleDB) IsSSHHost(principal string) (bool, error) {
 	return false, ErrNotImplemented
@@ -79,11 +102,51 @@ func (s *SimpleDB) StoreSSHCertificate(crt *ssh.Certificate) error {
 	return ErrNotImplemented
 }
 
+// GetRevokedCertificates returns a "NotImplemented" error.
+func (s *SimpleDB) GetRevokedCertificates() ([]*RevokedCertificateInfo, error) {
+	return nil, ErrNotImplemented
+}
+
+// GetRevokedSSHCertificates returns a "NotImplemented" error.
+func (s *SimpleDB) GetRevokedSSHCertificates() ([]*RevokedCertificateInfo, error) {
+	return nil, ErrNotImplemented
+}
+
 // GetSSHHostPrincipals returns a "NotImplemented" error.
 func (s *SimpleDB) GetSSHHostPrincipals() ([]string, error) {
 	return nil, ErrNotImplemented
 }
 
+// GetProvisioners returns a "NotImplemented" error.
+func (s *SimpleDB) GetProvisioners() ([]json.RawMessage, error) {
+	return nil, ErrNotImplemented
+}
+
+// StoreProvisioner returns a "NotImplemented" error.
+func (s *SimpleDB) StoreProvisioner(id string, data json.RawMessage) error {
+	return ErrNotImplemented
+}
+
+// DeleteProvisioner returns a "NotImplemented" error.
+func (s *SimpleDB) DeleteProvisioner(id string) error {
+	return ErrNotImplemented
+}
+
+// GetAuthorityPolicy returns a "NotImplemented" error.
+func (s *SimpleDB) GetAuthorityPolicy() (json.RawMessage, error) {
+	return nil, ErrNotImplemented
+}
+
+// StoreAuthorityPolicy returns a "NotImplemented" error.
+func (s *SimpleDB) StoreAuthorityPolicy(data json.RawMessage) error {
+	return ErrNotImplemented
+}
+
+// DeleteAuthorityPolicy returns a "NotImplemented" error.
+func (s *SimpleDB) DeleteAuthorityPolicy() error {
+	return ErrNotImplemented
+}
+
 // Shutdown returns nil
 func (s *SimpleDB) Shutdown() error {
 	return nil
@@ -2,6 +2,7 @@ package db
 
 import (
 	"testing"
+	"time"
 
 	"github.com/smallstep/assert"
 )
@@ -29,6 +30,36 @@ func TestSimpleDB(t *testing.T) {
 	assert.False(t, ok)
 	assert.Nil(t, err)
 
+	// PurgeUsedTokens
+	now := time.Now()
+	ok, err = db.UseToken("expired", newTestToken(t, now.Add(-time.Minute)))
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	ok, err = db.UseToken("valid", newTestToken(t, now.Add(time.Minute)))
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	n, err := db.PurgeUsedTokens(now)
+	assert.Equals(t, 1, n)
+	assert.Nil(t, err)
+	ok, err = db.UseToken("expired", "bar")
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	ok, err = db.UseToken("valid", "bar")
+	assert.False(t, ok)
+	assert.Nil(t, err)
+
+	// Provisioners
+	_, err = db.GetProvisioners()
+	assert.Equals(t, ErrNotImplemented, err)
+	assert.Equals(t, ErrNotImplemented, db.StoreProvisioner("foo", nil))
+	assert.Equals(t, ErrNotImplemented, db.DeleteProvisioner("foo"))
+
+	// Policies
+	_, err = db.GetAuthorityPolicy()
+	assert.Equals(t, ErrNotImplemented, err)
+	assert.Equals(t, ErrNotImplemented, db.StoreAuthorityPolicy(nil))
+	assert.Equals(t, ErrNotImplemented, db.DeleteAuthorityPolicy())
+
 	// Shutdown -- verify noop
 	assert.FatalError(t, db.Shutdown())
 	ok, err = db.UseToken("foo", "cat")
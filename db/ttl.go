@@ -0,0 +1,63 @@
+package db
+
+import (
+	"time"
+
+	"github.com/smallstep/nosql"
+	"github.com/smallstep/nosql/database"
+)
+
+// TTLDB is an optional interface implemented by the databases that can expire
+// keys. A key written with a time to live is deleted, or at least never
+// returned again, once it expires. A compare-and-swap without a time to live
+// keeps the expiration of the key it replaces.
+type TTLDB interface {
+	SetWithTTL(bucket, key, value []byte, ttl time.Duration) error
+	CmpAndSwapWithTTL(bucket, key, oldValue, newValue []byte, ttl time.Duration) ([]byte, bool, error)
+	UpdateWithTTL(tx *database.Tx, ttl time.Duration) error
+}
+
+// SetWithTTL stores the value in the given bucket and key with the given time
+// to live. If the database does not implement TTLDB, or the ttl is not
+// greater than 0, the value is stored without an expiration.
+func SetWithTTL(db nosql.DB, bucket, key, value []byte, ttl time.Duration) error {
+	if t, ok := db.(TTLDB); ok && ttl > 0 {
+		return t.SetWithTTL(bucket, key, value, ttl)
+	}
+	return db.Set(bucket, key, value)
+}
+
+// CmpAndSwapWithTTL is like CmpAndSwap, but the new value is stored with the
+// given time to live. If the database does not implement TTLDB, or the ttl is
+// not greater than 0, it's a regular CmpAndSwap.
+func CmpAndSwapWithTTL(db nosql.DB, bucket, key, oldValue, newValue []byte, ttl time.Duration) ([]byte, bool, error) {
+	if t, ok := db.(TTLDB); ok && ttl > 0 {
+		return t.CmpAndSwapWithTTL(bucket, key, oldValue, newValue, ttl)
+	}
+	return db.CmpAndSwap(bucket, key, oldValue, newValue)
+}
+
+// UpdateWithTTL is like Update, but the values written by the transaction are
+// stored with the given time to live. If the database does not implement
+// TTLDB, or the ttl is not greater than 0, it's a regular Update.
+func UpdateWithTTL(db nosql.DB, tx *database.Tx, ttl time.Duration) error {
+	if t, ok := db.(TTLDB); ok && ttl > 0 {
+		return t.UpdateWithTTL(tx, ttl)
+	}
+	return db.Update(tx)
+}
+
+// SetWithTTL implements the TTLDB interface.
+func (db *DB) SetWithTTL(bucket, key, value []byte, ttl time.Duration) error {
+	return SetWithTTL(db.DB, bucket, key, value, ttl)
+}
+
+// CmpAndSwapWithTTL implements the TTLDB interface.
+func (db *DB) CmpAndSwapWithTTL(bucket, key, oldValue, newValue []byte, ttl time.Duration) ([]byte, bool, error) {
+	return CmpAndSwapWithTTL(db.DB, bucket, key, oldValue, newValue, ttl)
+}
+
+// UpdateWithTTL implements the TTLDB interface.
+func (db *DB) UpdateWithTTL(tx *database.Tx, ttl time.Duration) error {
+	return UpdateWithTTL(db.DB, tx, ttl)
+}
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/nosql/database"
+)
+
+// mockTTLDB is a MockNoSQLDB that implements the TTLDB interface recording
+// the ttl used.
+type mockTTLDB struct {
+	MockNoSQLDB
+	ttl time.Duration
+}
+
+func (m *mockTTLDB) SetWithTTL(bucket, key, value []byte, ttl time.Duration) error {
+	m.ttl = ttl
+	return m.Set(bucket, key, value)
+}
+
+func (m *mockTTLDB) CmpAndSwapWithTTL(bucket, key, oldValue, newValue []byte, ttl time.Duration) ([]byte, bool, error) {
+	m.ttl = ttl
+	return m.CmpAndSwap(bucket, key, oldValue, newValue)
+}
+
+func (m *mockTTLDB) UpdateWithTTL(tx *database.Tx, ttl time.Duration) error {
+	m.ttl = ttl
+	return m.Update(tx)
+}
+
+func TestTTL(t *testing.T) {
+	var calls []string
+	mock := MockNoSQLDB{
+		MSet: func(bucket, key, value []byte) error {
+			calls = append(calls, "set")
+			return nil
+		},
+		MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+			calls = append(calls, "cas")
+			return newval, true, nil
+		},
+		MUpdate: func(tx *database.Tx) error {
+			calls = append(calls, "update")
+			return nil
+		},
+	}
+	run := func(db *DB, ttl time.Duration) {
+		assert.FatalError(t, db.SetWithTTL([]byte("b"), []byte("k"), []byte("v"), ttl))
+		_, swapped, err := db.CmpAndSwapWithTTL([]byte("b"), []byte("k"), nil, []byte("v"), ttl)
+		assert.FatalError(t, err)
+		assert.True(t, swapped)
+		assert.FatalError(t, db.UpdateWithTTL(new(database.Tx), ttl))
+		assert.Equals(t, []string{"set", "cas", "update"}, calls)
+		calls = nil
+	}
+
+	// Databases without TTLDB fall back to the regular methods.
+	m := mock
+	run(&DB{&m, true}, time.Minute)
+
+	ttlDB := &mockTTLDB{MockNoSQLDB: mock}
+	run(&DB{ttlDB, true}, time.Minute)
+	assert.Equals(t, time.Minute, ttlDB.ttl)
+
+	// A ttl not greater than 0 does not expire.
+	ttlDB.ttl = 0
+	run(&DB{ttlDB, true}, -time.Minute)
+	assert.Equals(t, time.Duration(0), ttlDB.ttl)
+
+	// The metrics wrapper keeps the ttl.
+	ttlDB.ttl = 0
+	run(&DB{&metricsDB{DB: ttlDB}, true}, time.Minute)
+	assert.Equals(t, time.Minute, ttlDB.ttl)
+}
+
+func TestUseToken_ttl(t *testing.T) {
+	exp := time.Now().Add(5 * time.Minute)
+	db := &mockTTLDB{MockNoSQLDB: MockNoSQLDB{
+		MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+			return newval, true, nil
+		},
+	}}
+	ok, err := (&DB{db, true}).UseToken("id", newTestToken(t, exp))
+	assert.FatalError(t, err)
+	assert.True(t, ok)
+	assert.True(t, db.ttl > 14*time.Minute && db.ttl <= 15*time.Minute)
+
+	// Tokens without an expiration are kept forever.
+	db.ttl = 0
+	ok, err = (&DB{db, true}).UseToken("id", "opaque-token")
+	assert.FatalError(t, err)
+	assert.True(t, ok)
+	assert.Equals(t, time.Duration(0), db.ttl)
+}
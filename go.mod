@@ -3,25 +3,42 @@ module github.com/smallstep/certificates
 go 1.13
 
 require (
-	cloud.google.com/go v0.51.0
+	cloud.google.com/go v0.62.0
 	github.com/Masterminds/sprig/v3 v3.0.0
+	github.com/ThalesIgnite/crypto11 v1.2.4
+	github.com/alicebob/miniredis/v2 v2.14.3
+	github.com/aws/aws-sdk-go v1.44.0
+	github.com/dgraph-io/badger v1.5.3
+	github.com/dgraph-io/badger/v2 v2.0.1-rc1.0.20200413122845-09dd2e1a4195
+	github.com/fxamacker/cbor/v2 v2.4.0
 	github.com/go-chi/chi v4.0.2+incompatible
+	github.com/go-redis/redis/v8 v8.11.4
 	github.com/googleapis/gax-go/v2 v2.0.5
 	github.com/juju/ansiterm v0.0.0-20180109212912-720a0952cc2a // indirect
 	github.com/lunixbochs/vtclean v1.0.0 // indirect
+	github.com/miekg/pkcs11 v1.0.3-0.20190429190417-a667d056470f
 	github.com/newrelic/go-agent v2.15.0+incompatible
-	github.com/pkg/errors v0.8.1
+	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.11.0
+	github.com/prometheus/client_model v0.2.0
+	github.com/prometheus/common v0.26.0
 	github.com/rs/xid v1.2.1
-	github.com/sirupsen/logrus v1.4.2
+	github.com/sirupsen/logrus v1.6.0
 	github.com/smallstep/assert v0.0.0-20200103212524-b99dc1097b15
 	github.com/smallstep/cli v0.14.3
 	github.com/smallstep/nosql v0.3.0
 	github.com/urfave/cli v1.22.2
-	golang.org/x/crypto v0.0.0-20200323165209-0ec3e9974c59
-	golang.org/x/net v0.0.0-20191209160850-c0dbc17a3553
-	google.golang.org/api v0.15.0
-	google.golang.org/genproto v0.0.0-20191230161307-f3c370f40bfb
-	google.golang.org/grpc v1.26.0
+	go.etcd.io/etcd/api/v3 v3.5.1
+	go.etcd.io/etcd/client/v3 v3.5.1
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+	golang.org/x/net v0.0.0-20220127200216-cd36cc0744dd
+	google.golang.org/api v0.30.0
+	google.golang.org/genproto v0.0.0-20210602131652-f16073e35f0c
+	google.golang.org/grpc v1.41.0
 	gopkg.in/square/go-jose.v2 v2.4.0
 )
 
@@ -0,0 +1,151 @@
+// Package awsutil implements the requests to the AWS services that use the
+// JSON protocol, like AWS KMS, Secrets Manager and Private CA. The credentials
+// are loaded, and the requests are signed, using the AWS SDK.
+package awsutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/pkg/errors"
+)
+
+// defaultTimeout is the timeout of the requests if the client does not have
+// an http.Client.
+const defaultTimeout = 15 * time.Second
+
+// NewCredentials returns the credentials used to sign the requests. If a
+// filename or a profile are given, the credentials are the ones of the
+// profile in the shared credentials file, AWS_SHARED_CREDENTIALS_FILE or
+// ~/.aws/credentials by default. Otherwise they are found using the default
+// chain of the AWS SDK: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, the
+// shared credentials and config files, a web identity token, and the roles of
+// the ECS task or the EC2 instance.
+func NewCredentials(filename, profile string) (*credentials.Credentials, error) {
+	opts := session.Options{
+		Profile:           profile,
+		SharedConfigState: session.SharedConfigEnable,
+	}
+	if filename != "" {
+		opts.SharedConfigFiles = []string{filename}
+		// The profile is required to prefer the file to the environment.
+		if opts.Profile == "" {
+			if opts.Profile = os.Getenv("AWS_PROFILE"); opts.Profile == "" {
+				opts.Profile = "default"
+			}
+		}
+	}
+	sess, err := session.NewSessionWithOptions(opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading aws credentials")
+	}
+	if _, err := sess.Config.Credentials.Get(); err != nil {
+		return nil, errors.Wrap(err, "error loading aws credentials")
+	}
+	return sess.Config.Credentials, nil
+}
+
+// DefaultRegion returns the region in AWS_REGION or AWS_DEFAULT_REGION.
+func DefaultRegion() string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	return os.Getenv("AWS_DEFAULT_REGION")
+}
+
+// Error is an error returned by an AWS service.
+type Error struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Type + ": " + e.Message
+}
+
+// Client does the requests to an AWS service that uses the JSON protocol.
+type Client struct {
+	// Service is the endpoint prefix of the service, e.g. "kms".
+	Service string
+	// Target is the prefix of the actions in the X-Amz-Target header, e.g.
+	// "TrentService".
+	Target string
+	// Credentials are the credentials used to sign the requests.
+	Credentials *credentials.Credentials
+	// Endpoint, if set, is used instead of the endpoint of the service in the
+	// region.
+	Endpoint string
+	// HTTPClient is the client used to do the requests.
+	HTTPClient *http.Client
+}
+
+// Do calls the given action in the given region, and decodes the response in
+// out. If the service returns an error, the error is an *Error.
+func (c *Client) Do(ctx context.Context, region, action string, in, out interface{}) error {
+	if region == "" {
+		return errors.New("aws region is not set")
+	}
+	endpoint, signingName := c.Endpoint, c.Service
+	if endpoint == "" {
+		e, err := endpoints.DefaultResolver().EndpointFor(c.Service, region)
+		if err != nil {
+			return errors.Wrapf(err, "error resolving the endpoint of %s in %s", c.Service, region)
+		}
+		endpoint = e.URL
+		if e.SigningName != "" {
+			signingName = e.SigningName
+		}
+	}
+
+	body, err := json.Marshal(in)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling request")
+	}
+	req, err := http.NewRequest("POST", endpoint, nil)
+	if err != nil {
+		return errors.Wrapf(err, "error creating request for url %s", endpoint)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", c.Target+"."+action)
+	if _, err := v4.NewSigner(c.Credentials).Sign(req, bytes.NewReader(body), signingName, region, time.Now()); err != nil {
+		return errors.Wrapf(err, "error signing request for url %s", endpoint)
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: defaultTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "error doing http POST for url %s", endpoint)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "error reading response from url %s", endpoint)
+	}
+	if resp.StatusCode != http.StatusOK {
+		e := new(Error)
+		if json.Unmarshal(b, e) == nil && e.Type != "" {
+			return e
+		}
+		return errors.Errorf("error doing http POST for url %s with status code %d", endpoint, resp.StatusCode)
+	}
+	if out == nil || len(b) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(b, out); err != nil {
+		return errors.Wrapf(err, "error decoding response from url %s", endpoint)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package awsutil
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pkg/errors"
+)
+
+func TestNewCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "awsutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "credentials")
+	if err := ioutil.WriteFile(filename, []byte(`# comment
+[default]
+aws_access_key_id = AKIDDEFAULT
+aws_secret_access_key = secret
+
+[step]
+aws_access_key_id=AKIDSTEP
+aws_secret_access_key=stepsecret
+aws_session_token=token
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "envsecret")
+	defer func() {
+		os.Unsetenv("AWS_ACCESS_KEY_ID")
+		os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+	}()
+
+	tests := []struct {
+		name     string
+		filename string
+		profile  string
+		want     credentials.Value
+		wantErr  bool
+	}{
+		{"env", "", "", credentials.Value{AccessKeyID: "AKIDENV", SecretAccessKey: "envsecret"}, false},
+		{"default", filename, "", credentials.Value{AccessKeyID: "AKIDDEFAULT", SecretAccessKey: "secret"}, false},
+		{"profile", filename, "step", credentials.Value{AccessKeyID: "AKIDSTEP", SecretAccessKey: "stepsecret", SessionToken: "token"}, false},
+		{"fail profile", filename, "missing", credentials.Value{}, true},
+		{"fail file", filepath.Join(dir, "missing"), "", credentials.Value{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			creds, err := NewCredentials(tt.filename, tt.profile)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewCredentials() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got, err := creds.Get()
+			if err != nil {
+				t.Fatalf("Credentials.Get() error = %v", err)
+			}
+			got.ProviderName = ""
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewCredentials() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_Do(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") ||
+			!strings.Contains(auth, "/us-west-2/kms/aws4_request") ||
+			r.Header.Get("X-Amz-Security-Token") != "session" ||
+			r.Header.Get("Content-Type") != "application/x-amz-json-1.1" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var in map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.DescribeKey":
+			json.NewEncoder(w).Encode(map[string]string{"KeyId": in["KeyId"]})
+		case "TrentService.ScheduleKeyDeletion":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"UnsupportedOperationException","message":"not supported"}`))
+		}
+	}))
+	defer srv.Close()
+
+	c := &Client{
+		Service:     "kms",
+		Target:      "TrentService",
+		Credentials: credentials.NewStaticCredentials("AKID", "secret", "session"),
+		Endpoint:    srv.URL,
+		HTTPClient:  srv.Client(),
+	}
+	ctx := context.Background()
+
+	var out struct {
+		KeyID string `json:"KeyId"`
+	}
+	if err := c.Do(ctx, "us-west-2", "DescribeKey", map[string]string{"KeyId": "foo"}, &out); err != nil || out.KeyID != "foo" {
+		t.Errorf("Client.Do() = %v, %v", out, err)
+	}
+	if err := c.Do(ctx, "us-west-2", "ScheduleKeyDeletion", map[string]string{"KeyId": "foo"}, nil); err != nil {
+		t.Errorf("Client.Do() error = %v", err)
+	}
+	err := c.Do(ctx, "us-west-2", "Sign", map[string]string{}, nil)
+	if e, ok := errors.Cause(err).(*Error); !ok || e.Type != "UnsupportedOperationException" {
+		t.Errorf("Client.Do() error = %v", err)
+	}
+	if err := c.Do(ctx, "us-east-1", "DescribeKey", map[string]string{}, nil); err == nil {
+		t.Error("Client.Do() error = nil, want an error with another region")
+	}
+	if err := c.Do(ctx, "", "DescribeKey", map[string]string{}, nil); err == nil {
+		t.Error("Client.Do() error = nil, want an error without region")
+	}
+}
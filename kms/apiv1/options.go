@@ -30,22 +30,46 @@ const (
 	CloudKMS Type = "cloudkms"
 	// AmazonKMS is a KMS implementation using Amazon AWS KMS.
 	AmazonKMS Type = "awskms"
+	// AzureKMS is a KMS implementation using Azure Key Vault or Managed HSM.
+	AzureKMS Type = "azurekms"
 	// PKCS11 is a KMS implementation using the PKCS11 standard.
 	PKCS11 Type = "pkcs11"
+	// TPMKMS is a KMS implementation using a TPM 2.0.
+	TPMKMS Type = "tpmkms"
 )
 
 type Options struct {
 	// The type of the KMS to use.
 	Type string `json:"type"`
 
-	// Path to the credentials file used in CloudKMS.
+	// Path to the credentials file used in CloudKMS and AmazonKMS.
 	CredentialsFile string `json:"credentialsFile"`
 
 	// Path to the module used with PKCS11 KMS.
 	Module string `json:"module"`
 
-	// Pin used to access the PKCS11 module.
+	// Pin used to access the PKCS11 module. In TPMKMS it's the authorization
+	// value of the owner hierarchy.
 	Pin string `json:"pin"`
+
+	// Label of the token used with PKCS11 KMS. If it's empty the first token
+	// in the module is used.
+	TokenLabel string `json:"tokenLabel,omitempty"`
+
+	// Region used by AmazonKMS. If it's empty AWS_REGION or AWS_DEFAULT_REGION
+	// are used.
+	Region string `json:"region,omitempty"`
+
+	// Profile used by AmazonKMS to read the credentials file. If it's empty
+	// AWS_PROFILE or the default profile are used.
+	Profile string `json:"profile,omitempty"`
+
+	// Client id of the user-assigned managed identity used by AzureKMS. If
+	// it's empty AZURE_CLIENT_ID or the system-assigned identity are used.
+	ClientID string `json:"clientId,omitempty"`
+
+	// Device used by TPMKMS. If it's empty /dev/tpmrm0 is used.
+	Device string `json:"device,omitempty"`
 }
 
 // Validate checks the fields in Options.
@@ -55,11 +79,11 @@ func (o *Options) Validate() error {
 	}
 
 	switch Type(strings.ToLower(o.Type)) {
-	case DefaultKMS, SoftKMS, CloudKMS:
-	case AmazonKMS:
-		return ErrNotImplemented{"support for AmazonKMS is not yet implemented"}
+	case DefaultKMS, SoftKMS, CloudKMS, AmazonKMS, AzureKMS, TPMKMS:
 	case PKCS11:
-		return ErrNotImplemented{"support for PKCS11 is not yet implemented"}
+		if o.Module == "" {
+			return errors.New("kms module cannot be empty")
+		}
 	default:
 		return errors.Errorf("unsupported kms type %s", o.Type)
 	}
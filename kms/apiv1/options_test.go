@@ -13,8 +13,11 @@ func TestOptions_Validate(t *testing.T) {
 		{"nil", nil, false},
 		{"softkms", &Options{Type: "softkms"}, false},
 		{"cloudkms", &Options{Type: "cloudkms"}, false},
-		{"awskms", &Options{Type: "awskms"}, true},
-		{"pkcs11", &Options{Type: "pkcs11"}, true},
+		{"awskms", &Options{Type: "awskms"}, false},
+		{"azurekms", &Options{Type: "azurekms"}, false},
+		{"tpmkms", &Options{Type: "tpmkms"}, false},
+		{"pkcs11", &Options{Type: "pkcs11", Module: "/usr/lib/softhsm/libsofthsm2.so"}, false},
+		{"pkcs11 without module", &Options{Type: "pkcs11"}, true},
 		{"unsupported", &Options{Type: "unsupported"}, true},
 	}
 	for _, tt := range tests {
@@ -102,7 +102,7 @@ type CreateKeyRequest struct {
 	Bits               int
 
 	// ProtectionLevel specifies how cryptographic operations are performed.
-	// Used by: cloudkms
+	// Used by: cloudkms, azurekms
 	ProtectionLevel ProtectionLevel
 }
 
@@ -124,3 +124,12 @@ type CreateSignerRequest struct {
 	PublicKeyPEM  []byte
 	Password      []byte
 }
+
+// CreateDecrypterRequest is the parameter used in the kms.CreateDecrypter
+// method.
+type CreateDecrypterRequest struct {
+	Decrypter        crypto.Decrypter
+	DecryptionKey    string
+	DecryptionKeyPEM []byte
+	Password         []byte
+}
@@ -0,0 +1,194 @@
+// Package awskms implements a KMS using the asymmetric keys in AWS Key
+// Management Service. The private keys never leave AWS KMS, all the signatures
+// are done using the Sign operation.
+//
+// The keys are referenced by their id, ARN or alias, e.g.
+// arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab
+// or alias/step-ca-intermediate.
+package awskms
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/internal/awsutil"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+// DefaultRSAKeySize is the default size for RSA keys.
+const DefaultRSAKeySize = 3072
+
+// keySpecMapping maps the step signature algorithms with the AWS KMS key specs.
+// RSA key specs depend on the number of bits.
+//
+// AWS KMS does not support PureEd25519.
+var keySpecMapping = map[apiv1.SignatureAlgorithm]string{
+	apiv1.UnspecifiedSignAlgorithm: "ECC_NIST_P256",
+	apiv1.SHA256WithRSA:            "RSA",
+	apiv1.SHA384WithRSA:            "RSA",
+	apiv1.SHA512WithRSA:            "RSA",
+	apiv1.SHA256WithRSAPSS:         "RSA",
+	apiv1.SHA384WithRSAPSS:         "RSA",
+	apiv1.SHA512WithRSAPSS:         "RSA",
+	apiv1.ECDSAWithSHA256:          "ECC_NIST_P256",
+	apiv1.ECDSAWithSHA384:          "ECC_NIST_P384",
+	apiv1.ECDSAWithSHA512:          "ECC_NIST_P521",
+}
+
+// KMS implements a KMS using AWS Key Management Service.
+type KMS struct {
+	client *client
+}
+
+// New creates a new AWS KMS configured with the region and credentials in the
+// options. If the options do not have a credentials file or a profile, the
+// credentials are found using the default chain of the AWS SDK, that includes
+// the environment and the role of the instance.
+func New(ctx context.Context, opts apiv1.Options) (*KMS, error) {
+	creds, err := awsutil.NewCredentials(opts.CredentialsFile, opts.Profile)
+	if err != nil {
+		return nil, err
+	}
+	return &KMS{
+		client: newClient(opts.Region, creds),
+	}, nil
+}
+
+// GetPublicKey returns the public key of the key with the given id, ARN or
+// alias.
+func (k *KMS) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error) {
+	if req.Name == "" {
+		return nil, errors.New("getPublicKeyRequest 'name' cannot be empty")
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	return k.getPublicKey(ctx, req.Name)
+}
+
+// CreateKey creates in AWS KMS a new asymmetric key for signing. If the name
+// is not empty it is set as the alias of the new key, the name in the response
+// is the ARN of the key.
+func (k *KMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	keySpec, ok := keySpecMapping[req.SignatureAlgorithm]
+	if !ok {
+		return nil, errors.Errorf("awsKMS does not support signature algorithm '%s'", req.SignatureAlgorithm)
+	}
+	if keySpec == "RSA" {
+		switch req.Bits {
+		case 0:
+			keySpec = "RSA_3072"
+		case 2048, 3072, 4096:
+			keySpec = "RSA_" + strconv.Itoa(req.Bits)
+		default:
+			return nil, errors.Errorf("awsKMS does not support signature algorithm '%s' with '%d' bits", req.SignatureAlgorithm, req.Bits)
+		}
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	var out struct {
+		KeyMetadata struct {
+			Arn   string `json:"Arn"`
+			KeyID string `json:"KeyId"`
+		} `json:"KeyMetadata"`
+	}
+	if err := k.client.do(ctx, "", "CreateKey", map[string]interface{}{
+		"Description": "step-ca key " + req.Name,
+		"KeySpec":     keySpec,
+		"KeyUsage":    "SIGN_VERIFY",
+	}, &out); err != nil {
+		return nil, errors.Wrap(err, "awsKMS CreateKey failed")
+	}
+
+	name := out.KeyMetadata.Arn
+	if name == "" {
+		name = out.KeyMetadata.KeyID
+	}
+
+	if req.Name != "" {
+		alias := req.Name
+		if !strings.HasPrefix(alias, "alias/") {
+			alias = "alias/" + alias
+		}
+		if err := k.client.do(ctx, regionOf(name), "CreateAlias", map[string]interface{}{
+			"AliasName":   alias,
+			"TargetKeyId": out.KeyMetadata.KeyID,
+		}, nil); err != nil {
+			return nil, errors.Wrap(err, "awsKMS CreateAlias failed")
+		}
+	}
+
+	pk, err := k.getPublicKey(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &apiv1.CreateKeyResponse{
+		Name:      name,
+		PublicKey: pk,
+		CreateSignerRequest: apiv1.CreateSignerRequest{
+			SigningKey: name,
+		},
+	}, nil
+}
+
+// CreateSigner returns a new signer configured with the given signing key id,
+// ARN or alias.
+func (k *KMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
+	if req.SigningKey == "" {
+		return nil, errors.New("signing key cannot be empty")
+	}
+	return newSigner(k.client, req.SigningKey)
+}
+
+// CreateDecrypter returns a new decrypter configured with the given decryption
+// key id, ARN or alias. The key must be an RSA key with the ENCRYPT_DECRYPT
+// usage.
+func (k *KMS) CreateDecrypter(req *apiv1.CreateDecrypterRequest) (crypto.Decrypter, error) {
+	if req.DecryptionKey == "" {
+		return nil, errors.New("decryption key cannot be empty")
+	}
+	return newDecrypter(k.client, req.DecryptionKey)
+}
+
+// Close is a noop, AWS KMS does not keep any connection open.
+func (k *KMS) Close() error {
+	return nil
+}
+
+func (k *KMS) getPublicKey(ctx context.Context, keyID string) (crypto.PublicKey, error) {
+	var out struct {
+		PublicKey []byte `json:"PublicKey"`
+	}
+	if err := k.client.do(ctx, regionOf(keyID), "GetPublicKey", map[string]interface{}{
+		"KeyId": keyID,
+	}, &out); err != nil {
+		return nil, errors.Wrap(err, "awsKMS GetPublicKey failed")
+	}
+	pk, err := x509.ParsePKIXPublicKey(out.PublicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing awsKMS public key")
+	}
+	return pk, nil
+}
+
+// regionOf returns the region in a key or alias ARN, with the format
+// arn:aws:kms:<region>:<account>:key/<id>, or an empty string.
+func regionOf(keyID string) string {
+	if parts := strings.Split(keyID, ":"); len(parts) >= 6 && parts[0] == "arn" {
+		return parts[3]
+	}
+	return ""
+}
+
+func defaultContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 15*time.Second)
+}
@@ -0,0 +1,447 @@
+package awskms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+// fakeKMS is an http server that implements the AWS KMS operations used.
+type fakeKMS struct {
+	mu   sync.Mutex
+	keys map[string]crypto.Signer
+}
+
+func (f *fakeKMS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") ||
+		!strings.Contains(auth, "/us-west-2/kms/aws4_request") {
+		fakeError(w, "UnrecognizedClientException", "The security token included in the request is invalid.")
+		return
+	}
+
+	var req struct {
+		KeyID            string `json:"KeyId"`
+		KeySpec          string `json:"KeySpec"`
+		AliasName        string `json:"AliasName"`
+		TargetKeyID      string `json:"TargetKeyId"`
+		Message          []byte `json:"Message"`
+		MessageType      string `json:"MessageType"`
+		SigningAlgorithm string `json:"SigningAlgorithm"`
+		CiphertextBlob   []byte `json:"CiphertextBlob"`
+		EncryptionAlg    string `json:"EncryptionAlgorithm"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var resp interface{}
+	switch strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "TrentService.") {
+	case "CreateKey":
+		var key crypto.Signer
+		var err error
+		switch req.KeySpec {
+		case "ECC_NIST_P256":
+			key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		case "ECC_NIST_P384":
+			key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		case "RSA_2048":
+			key, err = rsa.GenerateKey(rand.Reader, 2048)
+		default:
+			fakeError(w, "ValidationException", "unsupported key spec "+req.KeySpec)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		id := "key-" + strconv.Itoa(len(f.keys))
+		f.keys[id] = key
+		resp = map[string]interface{}{
+			"KeyMetadata": map[string]string{
+				"Arn":   "arn:aws:kms:us-west-2:123456789012:key/" + id,
+				"KeyId": id,
+			},
+		}
+	case "CreateAlias":
+		if _, ok := f.keys[req.AliasName]; ok {
+			fakeError(w, "AlreadyExistsException", "alias already exists")
+			return
+		}
+		f.keys[req.AliasName] = f.keys[req.TargetKeyID]
+	case "GetPublicKey":
+		key, ok := f.lookup(req.KeyID)
+		if !ok {
+			fakeError(w, "NotFoundException", "key not found")
+			return
+		}
+		b, err := x509.MarshalPKIXPublicKey(key.Public())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		resp = map[string]interface{}{"PublicKey": b}
+	case "Sign":
+		key, ok := f.lookup(req.KeyID)
+		if !ok {
+			fakeError(w, "NotFoundException", "key not found")
+			return
+		}
+		if req.MessageType != "DIGEST" {
+			fakeError(w, "ValidationException", "message type must be DIGEST")
+			return
+		}
+		var opts crypto.SignerOpts = crypto.SHA256
+		switch req.SigningAlgorithm {
+		case "ECDSA_SHA_256", "RSASSA_PKCS1_V1_5_SHA_256":
+		case "RSASSA_PSS_SHA_256":
+			opts = &rsa.PSSOptions{Hash: crypto.SHA256, SaltLength: rsa.PSSSaltLengthEqualsHash}
+		default:
+			fakeError(w, "InvalidKeyUsageException", "unsupported signing algorithm "+req.SigningAlgorithm)
+			return
+		}
+		sig, err := key.Sign(rand.Reader, req.Message, opts)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		resp = map[string]interface{}{"Signature": sig}
+	case "Decrypt":
+		key, ok := f.lookup(req.KeyID)
+		if !ok {
+			fakeError(w, "NotFoundException", "key not found")
+			return
+		}
+		dec, ok := key.(crypto.Decrypter)
+		if !ok || req.EncryptionAlg != "RSAES_OAEP_SHA_256" {
+			fakeError(w, "InvalidKeyUsageException", "unsupported encryption algorithm "+req.EncryptionAlg)
+			return
+		}
+		plaintext, err := dec.Decrypt(rand.Reader, req.CiphertextBlob, &rsa.OAEPOptions{Hash: crypto.SHA256})
+		if err != nil {
+			fakeError(w, "InvalidCiphertextException", err.Error())
+			return
+		}
+		resp = map[string]interface{}{"Plaintext": plaintext}
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (f *fakeKMS) lookup(keyID string) (crypto.Signer, bool) {
+	if i := strings.LastIndex(keyID, ":key/"); i >= 0 {
+		keyID = keyID[i+5:]
+	}
+	key, ok := f.keys[keyID]
+	return key, ok
+}
+
+func fakeError(w http.ResponseWriter, typ, msg string) {
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"__type": typ, "message": msg})
+}
+
+func newTestKMS() (*KMS, *fakeKMS, func()) {
+	f := &fakeKMS{keys: make(map[string]crypto.Signer)}
+	srv := httptest.NewServer(f)
+	c := newClient("us-west-2", credentials.NewStaticCredentials("AKID", "secret", ""))
+	c.api.Endpoint = srv.URL
+	c.api.HTTPClient = srv.Client()
+	return &KMS{client: c}, f, srv.Close
+}
+
+func TestNew(t *testing.T) {
+	os.Setenv("AWS_ACCESS_KEY_ID", "ENVAKID")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+	os.Setenv("AWS_REGION", "eu-west-1")
+	defer func() {
+		os.Unsetenv("AWS_ACCESS_KEY_ID")
+		os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+		os.Unsetenv("AWS_REGION")
+	}()
+
+	tests := []struct {
+		name       string
+		opts       apiv1.Options
+		wantRegion string
+		wantCreds  credentials.Value
+		wantErr    bool
+	}{
+		{"ok/env", apiv1.Options{Type: "awskms"}, "eu-west-1", credentials.Value{AccessKeyID: "ENVAKID", SecretAccessKey: "env-secret"}, false},
+		{"ok/file", apiv1.Options{Type: "awskms", Region: "us-west-2", CredentialsFile: "testdata/credentials"}, "us-west-2", credentials.Value{AccessKeyID: "AKID", SecretAccessKey: "secret"}, false},
+		{"ok/profile", apiv1.Options{Type: "awskms", CredentialsFile: "testdata/credentials", Profile: "step"}, "eu-west-1", credentials.Value{AccessKeyID: "STEPAKID", SecretAccessKey: "step-secret", SessionToken: "session"}, false},
+		{"fail/profile", apiv1.Options{Type: "awskms", CredentialsFile: "testdata/credentials", Profile: "missing"}, "", credentials.Value{}, true},
+		{"fail/file", apiv1.Options{Type: "awskms", CredentialsFile: "testdata/missing"}, "", credentials.Value{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(context.Background(), tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.client.region != tt.wantRegion {
+				t.Errorf("New() region = %v, want %v", got.client.region, tt.wantRegion)
+			}
+			creds, err := got.client.api.Credentials.Get()
+			if err != nil {
+				t.Fatalf("New() credentials error = %v", err)
+			}
+			creds.ProviderName = ""
+			if !reflect.DeepEqual(creds, tt.wantCreds) {
+				t.Errorf("New() credentials = %v, want %v", creds, tt.wantCreds)
+			}
+		})
+	}
+}
+
+func TestKMS_CreateKey(t *testing.T) {
+	k, _, closer := newTestKMS()
+	defer closer()
+
+	tests := []struct {
+		name    string
+		req     *apiv1.CreateKeyRequest
+		want    interface{}
+		wantErr bool
+	}{
+		{"ok", &apiv1.CreateKeyRequest{Name: "root"}, elliptic.P256(), false},
+		{"ok/no-alias", &apiv1.CreateKeyRequest{SignatureAlgorithm: apiv1.ECDSAWithSHA384}, elliptic.P384(), false},
+		{"ok/rsa", &apiv1.CreateKeyRequest{Name: "alias/rsa", SignatureAlgorithm: apiv1.SHA256WithRSAPSS, Bits: 2048}, 2048, false},
+		{"fail/alias-exists", &apiv1.CreateKeyRequest{Name: "root"}, nil, true},
+		{"fail/bits", &apiv1.CreateKeyRequest{SignatureAlgorithm: apiv1.SHA256WithRSA, Bits: 1024}, nil, true},
+		{"fail/ed25519", &apiv1.CreateKeyRequest{SignatureAlgorithm: apiv1.PureEd25519}, nil, true},
+		{"fail/key-spec", &apiv1.CreateKeyRequest{SignatureAlgorithm: apiv1.ECDSAWithSHA512}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.CreateKey(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("KMS.CreateKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !strings.HasPrefix(got.Name, "arn:aws:kms:us-west-2:123456789012:key/") || got.CreateSignerRequest.SigningKey != got.Name {
+				t.Errorf("KMS.CreateKey() name = %v, signing key = %v", got.Name, got.CreateSignerRequest.SigningKey)
+			}
+			switch pub := got.PublicKey.(type) {
+			case *ecdsa.PublicKey:
+				if pub.Curve != tt.want {
+					t.Errorf("KMS.CreateKey() curve = %v, want %v", pub.Curve.Params().Name, tt.want)
+				}
+			case *rsa.PublicKey:
+				if pub.N.BitLen() != tt.want {
+					t.Errorf("KMS.CreateKey() bits = %d, want %v", pub.N.BitLen(), tt.want)
+				}
+			default:
+				t.Errorf("KMS.CreateKey() public key type = %T", pub)
+			}
+		})
+	}
+}
+
+func TestKMS_CreateSigner(t *testing.T) {
+	k, f, closer := newTestKMS()
+	defer closer()
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.keys["ec"] = ecKey
+	f.keys["alias/rsa"] = rsaKey
+
+	digest := sha256.Sum256([]byte("message"))
+	pss := &rsa.PSSOptions{Hash: crypto.SHA256, SaltLength: rsa.PSSSaltLengthEqualsHash}
+
+	tests := []struct {
+		name       string
+		signingKey string
+		opts       crypto.SignerOpts
+		wantErr    bool
+	}{
+		{"ok/ecdsa", "arn:aws:kms:us-west-2:123456789012:key/ec", crypto.SHA256, false},
+		{"ok/rsa", "alias/rsa", crypto.SHA256, false},
+		{"ok/rsa-pss", "alias/rsa", pss, false},
+		{"fail/hash", "ec", crypto.SHA1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: tt.signingKey})
+			if err != nil {
+				t.Fatalf("KMS.CreateSigner() error = %v", err)
+			}
+			sig, err := signer.Sign(rand.Reader, digest[:], tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Signer.Sign() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			switch pub := signer.Public().(type) {
+			case *ecdsa.PublicKey:
+				if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+					t.Error("Signer.Sign() ecdsa signature is not valid")
+				}
+			case *rsa.PublicKey:
+				if _, ok := tt.opts.(*rsa.PSSOptions); ok {
+					err = rsa.VerifyPSS(pub, crypto.SHA256, digest[:], sig, pss)
+				} else {
+					err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig)
+				}
+				if err != nil {
+					t.Errorf("Signer.Sign() rsa signature is not valid: %v", err)
+				}
+			}
+		})
+	}
+
+	if _, err := k.CreateSigner(&apiv1.CreateSignerRequest{}); err == nil {
+		t.Error("KMS.CreateSigner() error = nil, want an error with an empty key")
+	}
+	if _, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: "missing"}); err == nil {
+		t.Error("KMS.CreateSigner() error = nil, want an error with a missing key")
+	}
+	// The region in the ARN is used to sign the request.
+	if _, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: "arn:aws:kms:us-east-1:123456789012:key/ec"}); err == nil {
+		t.Error("KMS.CreateSigner() error = nil, want an error with a different region")
+	}
+}
+
+func TestKMS_CreateDecrypter(t *testing.T) {
+	k, f, closer := newTestKMS()
+	defer closer()
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.keys["ec"] = ecKey
+	f.keys["alias/rsa"] = rsaKey
+
+	if _, err := k.CreateDecrypter(&apiv1.CreateDecrypterRequest{}); err == nil {
+		t.Error("KMS.CreateDecrypter() error = nil, want an error with an empty key")
+	}
+	if _, err := k.CreateDecrypter(&apiv1.CreateDecrypterRequest{DecryptionKey: "ec"}); err == nil {
+		t.Error("KMS.CreateDecrypter() error = nil, want an error with an EC key")
+	}
+	dec, err := k.CreateDecrypter(&apiv1.CreateDecrypterRequest{DecryptionKey: "alias/rsa"})
+	if err != nil {
+		t.Fatalf("KMS.CreateDecrypter() error = %v", err)
+	}
+	if !reflect.DeepEqual(dec.Public(), rsaKey.Public()) {
+		t.Errorf("Decrypter.Public() = %v, want %v", dec.Public(), rsaKey.Public())
+	}
+
+	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, &rsaKey.PublicKey, []byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		opts    crypto.DecrypterOpts
+		want    []byte
+		wantErr bool
+	}{
+		{"ok", &rsa.OAEPOptions{Hash: crypto.SHA256}, []byte("secret"), false},
+		{"fail/pkcs1", nil, nil, true},
+		{"fail/label", &rsa.OAEPOptions{Hash: crypto.SHA256, Label: []byte("label")}, nil, true},
+		{"fail/hash", &rsa.OAEPOptions{Hash: crypto.SHA384}, nil, true},
+		{"fail/sha1", &rsa.OAEPOptions{Hash: crypto.SHA1}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := dec.Decrypt(rand.Reader, ciphertext, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decrypter.Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decrypter.Decrypt() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKMS_GetPublicKey(t *testing.T) {
+	k, f, closer := newTestKMS()
+	defer closer()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.keys["ec"] = key
+
+	tests := []struct {
+		name    string
+		keyID   string
+		want    crypto.PublicKey
+		wantErr bool
+	}{
+		{"ok", "ec", key.Public(), false},
+		{"fail/empty", "", nil, true},
+		{"fail/missing", "missing", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: tt.keyID})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("KMS.GetPublicKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KMS.GetPublicKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_regionOf(t *testing.T) {
+	tests := []struct {
+		keyID string
+		want  string
+	}{
+		{"arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab", "us-east-1"},
+		{"arn:aws:kms:eu-west-1:123456789012:alias/step-ca", "eu-west-1"},
+		{"1234abcd-12ab-34cd-56ef-1234567890ab", ""},
+		{"alias/step-ca", ""},
+	}
+	for _, tt := range tests {
+		if got := regionOf(tt.keyID); got != tt.want {
+			t.Errorf("regionOf(%q) = %v, want %v", tt.keyID, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package awskms
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/internal/awsutil"
+)
+
+const awsKMSService = "kms"
+
+// client is a minimal AWS KMS client that does the JSON requests to the
+// region of the keys.
+type client struct {
+	region string
+	api    *awsutil.Client
+}
+
+func newClient(region string, creds *credentials.Credentials) *client {
+	if region == "" {
+		region = awsutil.DefaultRegion()
+	}
+	return &client{
+		region: region,
+		api: &awsutil.Client{
+			Service:     awsKMSService,
+			Target:      "TrentService",
+			Credentials: creds,
+			HTTPClient:  &http.Client{Timeout: 15 * time.Second},
+		},
+	}
+}
+
+// do calls the given AWS KMS action, in the given region or the default one,
+// and decodes the response in out.
+func (c *client) do(ctx context.Context, region, action string, in, out interface{}) error {
+	if region == "" {
+		region = c.region
+	}
+	if region == "" {
+		return errors.New("aws region is not set, use the kms region, AWS_REGION or a key ARN")
+	}
+	return c.api.Do(ctx, region, action, in, out)
+}
@@ -0,0 +1,77 @@
+package awskms
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Decrypter implements a crypto.Decrypter using AWS KMS.
+type Decrypter struct {
+	client    *client
+	keyID     string
+	publicKey crypto.PublicKey
+}
+
+// newDecrypter creates a new decrypter using the key with the given id, ARN
+// or alias. The public key is retrieved on creation.
+func newDecrypter(c *client, keyID string) (*Decrypter, error) {
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	pk, err := (&KMS{client: c}).getPublicKey(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := pk.(*rsa.PublicKey); !ok {
+		return nil, errors.Errorf("awsKMS key %s is not an RSA key", keyID)
+	}
+	return &Decrypter{
+		client:    c,
+		keyID:     keyID,
+		publicKey: pk,
+	}, nil
+}
+
+// Public returns the public key of this decrypter.
+func (d *Decrypter) Public() crypto.PublicKey {
+	return d.publicKey
+}
+
+// Decrypt decrypts the given RSA-OAEP ciphertext with the private key stored
+// in AWS KMS. AWS KMS only supports OAEP with SHA-1 or SHA-256, and without a
+// label.
+func (d *Decrypter) Decrypt(rand io.Reader, ciphertext []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	oaep, ok := opts.(*rsa.OAEPOptions)
+	if !ok {
+		return nil, errors.New("awsKMS only supports RSA-OAEP decryption")
+	}
+	var alg string
+	switch {
+	case len(oaep.Label) > 0:
+		return nil, errors.New("awsKMS does not support RSA-OAEP labels")
+	case oaep.Hash == crypto.SHA1:
+		alg = "RSAES_OAEP_SHA_1"
+	case oaep.Hash == crypto.SHA256:
+		alg = "RSAES_OAEP_SHA_256"
+	default:
+		return nil, errors.Errorf("unsupported hash function %v", oaep.Hash)
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	var out struct {
+		Plaintext []byte `json:"Plaintext"`
+	}
+	if err := d.client.do(ctx, regionOf(d.keyID), "Decrypt", map[string]interface{}{
+		"KeyId":               d.keyID,
+		"CiphertextBlob":      ciphertext,
+		"EncryptionAlgorithm": alg,
+	}, &out); err != nil {
+		return nil, errors.Wrap(err, "awsKMS Decrypt failed")
+	}
+	return out.Plaintext, nil
+}
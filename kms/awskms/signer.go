@@ -0,0 +1,94 @@
+package awskms
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Signer implements a crypto.Signer using AWS KMS.
+type Signer struct {
+	client    *client
+	keyID     string
+	publicKey crypto.PublicKey
+}
+
+// newSigner creates a new signer using the key with the given id, ARN or
+// alias. The public key is retrieved on creation.
+func newSigner(c *client, keyID string) (*Signer, error) {
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	pk, err := (&KMS{client: c}).getPublicKey(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{
+		client:    c,
+		keyID:     keyID,
+		publicKey: pk,
+	}, nil
+}
+
+// Public returns the public key of this signer.
+func (s *Signer) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign signs digest with the private key stored in AWS KMS.
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	alg, err := signingAlgorithm(s.publicKey, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	var out struct {
+		Signature []byte `json:"Signature"`
+	}
+	if err := s.client.do(ctx, regionOf(s.keyID), "Sign", map[string]interface{}{
+		"KeyId":            s.keyID,
+		"Message":          digest,
+		"MessageType":      "DIGEST",
+		"SigningAlgorithm": alg,
+	}, &out); err != nil {
+		return nil, errors.Wrap(err, "awsKMS Sign failed")
+	}
+
+	// ECDSA signatures are already ASN.1 encoded.
+	return out.Signature, nil
+}
+
+// signingAlgorithm returns the AWS KMS signing algorithm for the given key and
+// options. AWS KMS uses a salt length equal to the hash length in RSA-PSS
+// signatures.
+func signingAlgorithm(pub crypto.PublicKey, opts crypto.SignerOpts) (string, error) {
+	var size string
+	switch h := opts.HashFunc(); h {
+	case crypto.SHA256:
+		size = "256"
+	case crypto.SHA384:
+		size = "384"
+	case crypto.SHA512:
+		size = "512"
+	default:
+		return "", errors.Errorf("unsupported hash function %v", h)
+	}
+
+	switch pub.(type) {
+	case *ecdsa.PublicKey:
+		return "ECDSA_SHA_" + size, nil
+	case *rsa.PublicKey:
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			return "RSASSA_PSS_SHA_" + size, nil
+		}
+		return "RSASSA_PKCS1_V1_5_SHA_" + size, nil
+	default:
+		return "", errors.Errorf("unsupported public key type %T", pub)
+	}
+}
@@ -0,0 +1,220 @@
+// Package azurekms implements a KMS using the keys in Azure Key Vault or Azure
+// Key Vault Managed HSM. The private keys never leave Azure, all the signatures
+// are done using the sign operation of the vault.
+//
+// The keys are referenced by their key identifier, with or without version,
+// e.g. https://my-vault.vault.azure.net/keys/intermediate/<version> or
+// https://my-hsm.managedhsm.azure.net/keys/intermediate. If the version is not
+// set the current version of the key is used.
+//
+// The requests are authorized using the managed identity of the host, or the
+// workload identity on AKS.
+package azurekms
+
+import (
+	"context"
+	"crypto"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+// DefaultRSAKeySize is the default size for RSA keys.
+const DefaultRSAKeySize = 3072
+
+type keyType struct {
+	Kty   string
+	Curve string
+}
+
+// signatureAlgorithmMapping maps the step signature algorithms with the key
+// types and curves in Azure Key Vault.
+//
+// Azure Key Vault does not support PureEd25519.
+var signatureAlgorithmMapping = map[apiv1.SignatureAlgorithm]keyType{
+	apiv1.UnspecifiedSignAlgorithm: {"EC", "P-256"},
+	apiv1.SHA256WithRSA:            {"RSA", ""},
+	apiv1.SHA384WithRSA:            {"RSA", ""},
+	apiv1.SHA512WithRSA:            {"RSA", ""},
+	apiv1.SHA256WithRSAPSS:         {"RSA", ""},
+	apiv1.SHA384WithRSAPSS:         {"RSA", ""},
+	apiv1.SHA512WithRSAPSS:         {"RSA", ""},
+	apiv1.ECDSAWithSHA256:          {"EC", "P-256"},
+	apiv1.ECDSAWithSHA384:          {"EC", "P-384"},
+	apiv1.ECDSAWithSHA512:          {"EC", "P-521"},
+}
+
+// KeyVault implements a KMS using Azure Key Vault or Managed HSM.
+type KeyVault struct {
+	client *client
+}
+
+// New creates a new KeyVault that authenticates using the workload identity
+// if AZURE_FEDERATED_TOKEN_FILE is set, or the managed identity of the host
+// otherwise.
+func New(ctx context.Context, opts apiv1.Options) (*KeyVault, error) {
+	tokens, err := newTokenSource(opts.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyVault{
+		client: newClient(tokens),
+	}, nil
+}
+
+// GetPublicKey returns the public key of the key with the given identifier.
+func (k *KeyVault) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error) {
+	if req.Name == "" {
+		return nil, errors.New("getPublicKeyRequest 'name' cannot be empty")
+	}
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	key, err := k.getKey(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return key.publicKey()
+}
+
+// CreateKey creates a new key in the vault with the given identifier, without
+// version. The name in the response is the identifier of the new version.
+//
+// Keys are created in an HSM if the protection level is HSM, and always in
+// Managed HSM vaults.
+func (k *KeyVault) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	if req.Name == "" {
+		return nil, errors.New("createKeyRequest 'name' cannot be empty")
+	}
+	v, ok := signatureAlgorithmMapping[req.SignatureAlgorithm]
+	if !ok {
+		return nil, errors.Errorf("azureKMS does not support signature algorithm '%s'", req.SignatureAlgorithm)
+	}
+	u, version, err := parseKeyID(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if version != "" {
+		return nil, errors.Errorf("key %s cannot include a version", req.Name)
+	}
+
+	body := map[string]interface{}{
+		"kty":     v.Kty,
+		"key_ops": []string{"sign", "verify"},
+	}
+	switch req.ProtectionLevel {
+	case apiv1.UnspecifiedProtectionLevel, apiv1.Software:
+		if isManagedHSM(u) {
+			body["kty"] = v.Kty + "-HSM"
+		}
+	case apiv1.HSM:
+		body["kty"] = v.Kty + "-HSM"
+	default:
+		return nil, errors.Errorf("azureKMS does not support protection level '%s'", req.ProtectionLevel)
+	}
+	if v.Kty == "EC" {
+		body["crv"] = v.Curve
+	} else {
+		bits := req.Bits
+		if bits == 0 {
+			bits = DefaultRSAKeySize
+		}
+		body["key_size"] = bits
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	var bundle keyBundle
+	if err := k.client.do(ctx, "POST", u.String()+"/create", body, &bundle); err != nil {
+		return nil, errors.Wrap(err, "azureKMS CreateKey failed")
+	}
+	pk, err := bundle.Key.publicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return &apiv1.CreateKeyResponse{
+		Name:      bundle.Key.Kid,
+		PublicKey: pk,
+		CreateSignerRequest: apiv1.CreateSignerRequest{
+			SigningKey: bundle.Key.Kid,
+		},
+	}, nil
+}
+
+// CreateSigner returns a new signer configured with the given key identifier.
+// The signer is pinned to the version of the key at the time of creation.
+func (k *KeyVault) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
+	if req.SigningKey == "" {
+		return nil, errors.New("signing key cannot be empty")
+	}
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	key, err := k.getKey(ctx, req.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	pk, err := key.publicKey()
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{
+		client:    k.client,
+		keyID:     key.Kid,
+		publicKey: pk,
+	}, nil
+}
+
+// Close is a noop, Azure Key Vault does not keep any connection open.
+func (k *KeyVault) Close() error {
+	return nil
+}
+
+func (k *KeyVault) getKey(ctx context.Context, name string) (*jsonWebKey, error) {
+	u, _, err := parseKeyID(name)
+	if err != nil {
+		return nil, err
+	}
+	var bundle keyBundle
+	if err := k.client.do(ctx, "GET", u.String(), nil, &bundle); err != nil {
+		return nil, errors.Wrap(err, "azureKMS GetKey failed")
+	}
+	return &bundle.Key, nil
+}
+
+// parseKeyID parses a key identifier with the format
+// https://<vault>/keys/<name>[/<version>], and returns the url without query
+// and the version.
+func parseKeyID(name string) (*url.URL, string, error) {
+	u, err := url.Parse(name)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "key %s is not a valid azure key identifier", name)
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if u.Scheme != "https" || u.Host == "" || len(parts) < 2 || len(parts) > 3 || parts[0] != "keys" || parts[1] == "" {
+		return nil, "", errors.Errorf("key %s is not a valid azure key identifier: it must have the format https://<vault>/keys/<name>[/<version>]", name)
+	}
+	var version string
+	if len(parts) == 3 {
+		version = parts[2]
+	}
+	return &url.URL{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+		Path:   "/" + strings.Join(parts, "/"),
+	}, version, nil
+}
+
+// isManagedHSM returns if the url is the one of a Managed HSM vault.
+func isManagedHSM(u *url.URL) bool {
+	return strings.HasSuffix(u.Hostname(), ".managedhsm.azure.net")
+}
+
+func defaultContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 15*time.Second)
+}
@@ -0,0 +1,523 @@
+package azurekms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+type staticTokenSource string
+
+func (s staticTokenSource) token(ctx context.Context, resource string) (string, error) {
+	if resource != vaultResource {
+		return "", os.ErrPermission
+	}
+	return string(s), nil
+}
+
+// fakeVault is an http server that implements the Key Vault operations used.
+type fakeVault struct {
+	mu   sync.Mutex
+	url  string
+	keys map[string]crypto.Signer
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer token" || r.URL.Query().Get("api-version") != apiVersion {
+		fakeError(w, http.StatusUnauthorized, "Unauthorized", "unauthorized")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == "POST" && len(parts) == 3 && parts[2] == "create":
+		var req struct {
+			Kty     string `json:"kty"`
+			Crv     string `json:"crv"`
+			KeySize int    `json:"key_size"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var key crypto.Signer
+		var err error
+		switch {
+		case req.Kty == "EC" && req.Crv == "P-256", req.Kty == "EC-HSM" && req.Crv == "P-256":
+			key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		case req.Kty == "EC" && req.Crv == "P-384":
+			key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		case req.Kty == "RSA-HSM" && req.KeySize == 2048:
+			key, err = rsa.GenerateKey(rand.Reader, 2048)
+		default:
+			fakeError(w, http.StatusBadRequest, "BadParameter", "unsupported key")
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		f.keys[parts[1]] = key
+		f.writeKey(w, parts[1], req.Kty, key)
+	case r.Method == "GET" && (len(parts) == 2 || len(parts) == 3):
+		key, ok := f.keys[parts[1]]
+		if !ok || (len(parts) == 3 && parts[2] != "v1") {
+			fakeError(w, http.StatusNotFound, "KeyNotFound", "key not found")
+			return
+		}
+		f.writeKey(w, parts[1], "", key)
+	case r.Method == "POST" && len(parts) == 4 && parts[3] == "sign":
+		key, ok := f.keys[parts[1]]
+		if !ok || parts[2] != "v1" {
+			fakeError(w, http.StatusNotFound, "KeyNotFound", "key not found")
+			return
+		}
+		var req struct {
+			Alg   string `json:"alg"`
+			Value string `json:"value"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		digest, err := base64.RawURLEncoding.DecodeString(req.Value)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var sig []byte
+		switch req.Alg {
+		case "ES256":
+			r, s, err := ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), digest)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		case "RS256":
+			sig, err = key.Sign(rand.Reader, digest, crypto.SHA256)
+		case "PS256":
+			sig, err = key.Sign(rand.Reader, digest, &rsa.PSSOptions{Hash: crypto.SHA256, SaltLength: rsa.PSSSaltLengthEqualsHash})
+		default:
+			fakeError(w, http.StatusBadRequest, "BadParameter", "unsupported algorithm")
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"kid":   f.url + "/keys/" + parts[1] + "/v1",
+			"value": base64.RawURLEncoding.EncodeToString(sig),
+		})
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (f *fakeVault) writeKey(w http.ResponseWriter, name, kty string, key crypto.Signer) {
+	enc := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	jwk := map[string]string{"kid": f.url + "/keys/" + name + "/v1"}
+	switch pub := key.Public().(type) {
+	case *ecdsa.PublicKey:
+		jwk["kty"] = "EC"
+		jwk["crv"] = pub.Curve.Params().Name
+		jwk["x"] = enc(pub.X.Bytes())
+		jwk["y"] = enc(pub.Y.Bytes())
+	case *rsa.PublicKey:
+		jwk["kty"] = "RSA"
+		jwk["n"] = enc(pub.N.Bytes())
+		jwk["e"] = enc(big.NewInt(int64(pub.E)).Bytes())
+	}
+	if kty != "" {
+		jwk["kty"] = kty
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"key": jwk})
+}
+
+func fakeError(w http.ResponseWriter, status int, code, msg string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"code": code, "message": msg},
+	})
+}
+
+func newTestKeyVault() (*KeyVault, *fakeVault, func()) {
+	f := &fakeVault{keys: make(map[string]crypto.Signer)}
+	srv := httptest.NewTLSServer(f)
+	f.url = srv.URL
+	c := newClient(staticTokenSource("token"))
+	c.http = srv.Client()
+	return &KeyVault{client: c}, f, srv.Close
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		opts    apiv1.Options
+		want    interface{}
+		wantErr bool
+	}{
+		{"ok/managed-identity", nil, apiv1.Options{Type: "azurekms", ClientID: "client-id"}, &managedIdentity{endpoint: imdsEndpoint, clientID: "client-id"}, false},
+		{"ok/managed-identity-env", map[string]string{"AZURE_CLIENT_ID": "env-client-id"}, apiv1.Options{Type: "azurekms"}, &managedIdentity{endpoint: imdsEndpoint, clientID: "env-client-id"}, false},
+		{"ok/workload-identity", map[string]string{
+			"AZURE_FEDERATED_TOKEN_FILE": "/var/run/secrets/azure/tokens/azure-identity-token",
+			"AZURE_TENANT_ID":            "tenant-id",
+			"AZURE_CLIENT_ID":            "client-id",
+		}, apiv1.Options{Type: "azurekms"}, &workloadIdentity{
+			endpoint:  "https://login.microsoftonline.com/tenant-id/oauth2/v2.0/token",
+			clientID:  "client-id",
+			tokenFile: "/var/run/secrets/azure/tokens/azure-identity-token",
+		}, false},
+		{"fail/workload-identity", map[string]string{
+			"AZURE_FEDERATED_TOKEN_FILE": "/var/run/secrets/azure/tokens/azure-identity-token",
+		}, apiv1.Options{Type: "azurekms"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				os.Setenv(k, v)
+			}
+			defer func() {
+				for k := range tt.env {
+					os.Unsetenv(k)
+				}
+			}()
+
+			got, err := New(context.Background(), tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			source := got.client.tokens.(*cachedTokenSource).source
+			switch s := source.(type) {
+			case *managedIdentity:
+				s.http = nil
+			case *workloadIdentity:
+				s.http = nil
+			}
+			if !reflect.DeepEqual(source, tt.want) {
+				t.Errorf("New() token source = %v, want %v", source, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyVault_CreateKey(t *testing.T) {
+	k, f, closer := newTestKeyVault()
+	defer closer()
+
+	tests := []struct {
+		name    string
+		req     *apiv1.CreateKeyRequest
+		want    interface{}
+		wantErr bool
+	}{
+		{"ok", &apiv1.CreateKeyRequest{Name: f.url + "/keys/root"}, elliptic.P256(), false},
+		{"ok/hsm", &apiv1.CreateKeyRequest{Name: f.url + "/keys/hsm", ProtectionLevel: apiv1.HSM}, elliptic.P256(), false},
+		{"ok/p384", &apiv1.CreateKeyRequest{Name: f.url + "/keys/p384", SignatureAlgorithm: apiv1.ECDSAWithSHA384}, elliptic.P384(), false},
+		{"ok/rsa", &apiv1.CreateKeyRequest{Name: f.url + "/keys/rsa", SignatureAlgorithm: apiv1.SHA256WithRSA, Bits: 2048, ProtectionLevel: apiv1.HSM}, 2048, false},
+		{"fail/name", &apiv1.CreateKeyRequest{}, nil, true},
+		{"fail/version", &apiv1.CreateKeyRequest{Name: f.url + "/keys/root/v1"}, nil, true},
+		{"fail/identifier", &apiv1.CreateKeyRequest{Name: "root"}, nil, true},
+		{"fail/ed25519", &apiv1.CreateKeyRequest{Name: f.url + "/keys/ed25519", SignatureAlgorithm: apiv1.PureEd25519}, nil, true},
+		{"fail/protection-level", &apiv1.CreateKeyRequest{Name: f.url + "/keys/foo", ProtectionLevel: apiv1.ProtectionLevel(100)}, nil, true},
+		{"fail/create", &apiv1.CreateKeyRequest{Name: f.url + "/keys/p521", SignatureAlgorithm: apiv1.ECDSAWithSHA512}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.CreateKey(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("KeyVault.CreateKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Name != tt.req.Name+"/v1" || got.CreateSignerRequest.SigningKey != got.Name {
+				t.Errorf("KeyVault.CreateKey() name = %v, signing key = %v", got.Name, got.CreateSignerRequest.SigningKey)
+			}
+			switch pub := got.PublicKey.(type) {
+			case *ecdsa.PublicKey:
+				if pub.Curve != tt.want {
+					t.Errorf("KeyVault.CreateKey() curve = %v, want %v", pub.Curve.Params().Name, tt.want)
+				}
+			case *rsa.PublicKey:
+				if pub.N.BitLen() != tt.want {
+					t.Errorf("KeyVault.CreateKey() bits = %d, want %v", pub.N.BitLen(), tt.want)
+				}
+			default:
+				t.Errorf("KeyVault.CreateKey() public key type = %T", pub)
+			}
+		})
+	}
+}
+
+func TestKeyVault_CreateSigner(t *testing.T) {
+	k, f, closer := newTestKeyVault()
+	defer closer()
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.keys["ec"] = ecKey
+	f.keys["rsa"] = rsaKey
+
+	digest := sha256.Sum256([]byte("message"))
+	pss := &rsa.PSSOptions{Hash: crypto.SHA256, SaltLength: rsa.PSSSaltLengthEqualsHash}
+
+	tests := []struct {
+		name       string
+		signingKey string
+		opts       crypto.SignerOpts
+		wantErr    bool
+	}{
+		{"ok/ecdsa", f.url + "/keys/ec", crypto.SHA256, false},
+		{"ok/ecdsa-version", f.url + "/keys/ec/v1", crypto.SHA256, false},
+		{"ok/rsa", f.url + "/keys/rsa", crypto.SHA256, false},
+		{"ok/rsa-pss", f.url + "/keys/rsa/v1?foo=bar", pss, false},
+		{"fail/hash", f.url + "/keys/ec", crypto.SHA1, true},
+		{"fail/algorithm", f.url + "/keys/ec", crypto.SHA384, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: tt.signingKey})
+			if err != nil {
+				t.Fatalf("KeyVault.CreateSigner() error = %v", err)
+			}
+			if !reflect.DeepEqual(signer.Public(), f.keys[strings.Split(tt.signingKey, "/")[4]].Public()) {
+				t.Errorf("Signer.Public() = %v, want %v", signer.Public(), f.keys["ec"].Public())
+			}
+			sig, err := signer.Sign(rand.Reader, digest[:], tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Signer.Sign() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			switch pub := signer.Public().(type) {
+			case *ecdsa.PublicKey:
+				if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+					t.Error("Signer.Sign() ecdsa signature is not valid")
+				}
+			case *rsa.PublicKey:
+				if _, ok := tt.opts.(*rsa.PSSOptions); ok {
+					err = rsa.VerifyPSS(pub, crypto.SHA256, digest[:], sig, pss)
+				} else {
+					err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig)
+				}
+				if err != nil {
+					t.Errorf("Signer.Sign() rsa signature is not valid: %v", err)
+				}
+			}
+		})
+	}
+
+	for _, name := range []string{"", f.url + "/keys/missing", f.url + "/keys/ec/v2", "http://example.com/keys/ec"} {
+		if _, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: name}); err == nil {
+			t.Errorf("KeyVault.CreateSigner(%q) error = nil, want an error", name)
+		}
+	}
+}
+
+func TestKeyVault_GetPublicKey(t *testing.T) {
+	k, f, closer := newTestKeyVault()
+	defer closer()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.keys["ec"] = key
+
+	tests := []struct {
+		name    string
+		keyID   string
+		want    crypto.PublicKey
+		wantErr bool
+	}{
+		{"ok", f.url + "/keys/ec", key.Public(), false},
+		{"fail/empty", "", nil, true},
+		{"fail/missing", f.url + "/keys/missing", nil, true},
+		{"fail/managed-hsm-token", "https://my-hsm.managedhsm.azure.net/keys/ec", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: tt.keyID})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("KeyVault.GetPublicKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KeyVault.GetPublicKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseKeyID(t *testing.T) {
+	tests := []struct {
+		name        string
+		keyID       string
+		wantURL     string
+		wantVersion string
+		wantErr     bool
+	}{
+		{"ok", "https://my-vault.vault.azure.net/keys/root", "https://my-vault.vault.azure.net/keys/root", "", false},
+		{"ok/version", "https://my-vault.vault.azure.net/keys/root/0123456789abcdef?foo=bar", "https://my-vault.vault.azure.net/keys/root/0123456789abcdef", "0123456789abcdef", false},
+		{"ok/managed-hsm", "https://my-hsm.managedhsm.azure.net/keys/root/", "https://my-hsm.managedhsm.azure.net/keys/root", "", false},
+		{"fail/scheme", "http://my-vault.vault.azure.net/keys/root", "", "", true},
+		{"fail/secrets", "https://my-vault.vault.azure.net/secrets/root", "", "", true},
+		{"fail/name", "https://my-vault.vault.azure.net/keys/", "", "", true},
+		{"fail/path", "https://my-vault.vault.azure.net/keys/root/version/foo", "", "", true},
+		{"fail/parse", "https://my-vault.vault.azure.net/%zz", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotURL, gotVersion, err := parseKeyID(tt.keyID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseKeyID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if gotURL.String() != tt.wantURL || gotVersion != tt.wantVersion {
+				t.Errorf("parseKeyID() = %v, %v, want %v, %v", gotURL, gotVersion, tt.wantURL, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func Test_managedIdentity(t *testing.T) {
+	expiresOn := time.Now().Add(time.Hour).Unix()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.Header.Get("Metadata") != "true" || q.Get("resource") != vaultResource || q.Get("client_id") != "client-id" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_request", "error_description": "Identity not found"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "token",
+			"expires_on":   big.NewInt(expiresOn).String(),
+		})
+	}))
+	defer srv.Close()
+
+	m := &managedIdentity{endpoint: srv.URL, clientID: "client-id", http: srv.Client()}
+	token, expiresAt, err := m.expiringToken(context.Background(), vaultResource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "token" || expiresAt.Unix() != expiresOn {
+		t.Errorf("managedIdentity.expiringToken() = %v, %v", token, expiresAt)
+	}
+
+	m.clientID = "foo"
+	if _, _, err := m.expiringToken(context.Background(), vaultResource); err == nil || !strings.Contains(err.Error(), "Identity not found") {
+		t.Errorf("managedIdentity.expiringToken() error = %v, want Identity not found", err)
+	}
+}
+
+func Test_workloadIdentity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "azurekms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := dir + "/token"
+	if err := ioutil.WriteFile(tokenFile, []byte("federated-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil ||
+			r.PostForm.Get("client_assertion") != "federated-token" ||
+			r.PostForm.Get("client_id") != "client-id" ||
+			r.PostForm.Get("scope") != vaultResource+"/.default" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "token",
+			"expires_in":   3600,
+		})
+	}))
+	defer srv.Close()
+
+	wi := &workloadIdentity{endpoint: srv.URL, clientID: "client-id", tokenFile: tokenFile, http: srv.Client()}
+	token, expiresAt, err := wi.expiringToken(context.Background(), vaultResource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "token" || expiresAt.Before(time.Now().Add(59*time.Minute)) {
+		t.Errorf("workloadIdentity.expiringToken() = %v, %v", token, expiresAt)
+	}
+
+	wi.tokenFile = dir + "/missing"
+	if _, _, err := wi.expiringToken(context.Background(), vaultResource); err == nil {
+		t.Error("workloadIdentity.expiringToken() error = nil, want an error")
+	}
+}
+
+type countingTokenSource struct {
+	calls     int
+	expiresIn time.Duration
+}
+
+func (c *countingTokenSource) expiringToken(ctx context.Context, resource string) (string, time.Time, error) {
+	c.calls++
+	return "token", time.Now().Add(c.expiresIn), nil
+}
+
+func Test_cachedTokenSource(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiresIn time.Duration
+		wantCalls int
+	}{
+		{"cached", time.Hour, 1},
+		{"expiring", time.Minute, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &countingTokenSource{expiresIn: tt.expiresIn}
+			s := newCachedTokenSource(source)
+			for i := 0; i < 2; i++ {
+				if _, err := s.token(context.Background(), vaultResource); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if source.calls != tt.wantCalls {
+				t.Errorf("cachedTokenSource.token() calls = %d, want %d", source.calls, tt.wantCalls)
+			}
+		})
+	}
+}
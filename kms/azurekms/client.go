@@ -0,0 +1,349 @@
+package azurekms
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// apiVersion is the version of the Key Vault REST API used.
+const apiVersion = "7.3"
+
+const (
+	vaultResource      = "https://vault.azure.net"
+	managedHSMResource = "https://managedhsm.azure.net"
+)
+
+// imdsEndpoint is the endpoint of the Azure Instance Metadata Service used to
+// get the managed identity tokens.
+const imdsEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+// defaultAuthorityHost is the Azure AD host used with the workload identity
+// if AZURE_AUTHORITY_HOST is not set.
+const defaultAuthorityHost = "https://login.microsoftonline.com/"
+
+// keyBundle is the response of the key operations.
+type keyBundle struct {
+	Key jsonWebKey `json:"key"`
+}
+
+// jsonWebKey is the public part of a key in the vault.
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// publicKey returns the crypto.PublicKey of the JSON web key.
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch strings.TrimSuffix(k.Kty, "-HSM") {
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.Errorf("unsupported azureKMS curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.Errorf("azureKMS key %s is not a valid %s key", k.Kid, k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.Errorf("azureKMS key %s has an invalid exponent", k.Kid)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	default:
+		return nil, errors.Errorf("unsupported azureKMS key type %s", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("error decoding azureKMS key")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// client is a minimal Azure Key Vault client.
+type client struct {
+	tokens tokenSource
+	http   *http.Client
+}
+
+func newClient(tokens tokenSource) *client {
+	return &client{
+		tokens: tokens,
+		http:   &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// do sends a request to the given key url, authorized with a token for the
+// vault, and decodes the response in out.
+func (c *client) do(ctx context.Context, method, rawurl string, in, out interface{}) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return errors.Wrapf(err, "error parsing url %s", rawurl)
+	}
+	u.RawQuery = url.Values{"api-version": []string{apiVersion}}.Encode()
+
+	resource := vaultResource
+	if isManagedHSM(u) {
+		resource = managedHSMResource
+	}
+	token, err := c.tokens.token(ctx, resource)
+	if err != nil {
+		return err
+	}
+
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return errors.Wrap(err, "error marshaling request")
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return errors.Wrapf(err, "error creating request for url %s", u)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return doJSON(c.http, req, out)
+}
+
+// doJSON does the request and decodes the JSON response in out.
+func doJSON(client *http.Client, req *http.Request, out interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "error doing http %s for url %s", req.Method, req.URL)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "error reading response from url %s", req.URL)
+	}
+	if resp.StatusCode >= 400 {
+		// Key Vault errors have an error object, and Azure AD and IMDS
+		// errors an error code and a description.
+		var e struct {
+			Error       json.RawMessage `json:"error"`
+			Description string          `json:"error_description"`
+		}
+		var vaultError struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(b, &e) == nil {
+			switch {
+			case e.Description != "":
+				return errors.New(e.Description)
+			case json.Unmarshal(e.Error, &vaultError) == nil && vaultError.Code != "":
+				return errors.Errorf("%s: %s", vaultError.Code, vaultError.Message)
+			}
+		}
+		return errors.Errorf("error doing http %s for url %s with status code %d", req.Method, req.URL, resp.StatusCode)
+	}
+	if err := json.Unmarshal(b, out); err != nil {
+		return errors.Wrapf(err, "error decoding response from url %s", req.URL)
+	}
+	return nil
+}
+
+// tokenSource returns the access tokens for a resource.
+type tokenSource interface {
+	token(ctx context.Context, resource string) (string, error)
+}
+
+// newTokenSource returns a workload identity token source if
+// AZURE_FEDERATED_TOKEN_FILE is set, or a managed identity one otherwise.
+func newTokenSource(clientID string) (tokenSource, error) {
+	if clientID == "" {
+		clientID = os.Getenv("AZURE_CLIENT_ID")
+	}
+	if tokenFile := os.Getenv("AZURE_FEDERATED_TOKEN_FILE"); tokenFile != "" {
+		tenantID := os.Getenv("AZURE_TENANT_ID")
+		if tenantID == "" || clientID == "" {
+			return nil, errors.New("azure workload identity requires AZURE_TENANT_ID and AZURE_CLIENT_ID")
+		}
+		host := os.Getenv("AZURE_AUTHORITY_HOST")
+		if host == "" {
+			host = defaultAuthorityHost
+		}
+		return newCachedTokenSource(&workloadIdentity{
+			endpoint:  strings.TrimSuffix(host, "/") + "/" + tenantID + "/oauth2/v2.0/token",
+			clientID:  clientID,
+			tokenFile: tokenFile,
+			http:      &http.Client{Timeout: 15 * time.Second},
+		}), nil
+	}
+	return newCachedTokenSource(&managedIdentity{
+		endpoint: imdsEndpoint,
+		clientID: clientID,
+		http:     &http.Client{Timeout: 15 * time.Second},
+	}), nil
+}
+
+// expiringTokenSource returns the access tokens and their expiration.
+type expiringTokenSource interface {
+	expiringToken(ctx context.Context, resource string) (string, time.Time, error)
+}
+
+type cachedToken struct {
+	value     string
+	expiresAt time.Time
+}
+
+// cachedTokenSource keeps the tokens until five minutes before they expire.
+type cachedTokenSource struct {
+	mu     sync.Mutex
+	source expiringTokenSource
+	tokens map[string]cachedToken
+}
+
+func newCachedTokenSource(source expiringTokenSource) *cachedTokenSource {
+	return &cachedTokenSource{
+		source: source,
+		tokens: make(map[string]cachedToken),
+	}
+}
+
+func (s *cachedTokenSource) token(ctx context.Context, resource string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.tokens[resource]; ok && time.Now().Add(5*time.Minute).Before(t.expiresAt) {
+		return t.value, nil
+	}
+	value, expiresAt, err := s.source.expiringToken(ctx, resource)
+	if err != nil {
+		return "", err
+	}
+	s.tokens[resource] = cachedToken{value: value, expiresAt: expiresAt}
+	return value, nil
+}
+
+// managedIdentity gets the tokens of the managed identity of the host from the
+// Azure Instance Metadata Service.
+type managedIdentity struct {
+	endpoint string
+	clientID string
+	http     *http.Client
+}
+
+func (m *managedIdentity) expiringToken(ctx context.Context, resource string) (string, time.Time, error) {
+	q := url.Values{
+		"api-version": []string{"2018-02-01"},
+		"resource":    []string{resource},
+	}
+	if m.clientID != "" {
+		q.Set("client_id", m.clientID)
+	}
+	req, err := http.NewRequest("GET", m.endpoint+"?"+q.Encode(), nil)
+	if err != nil {
+		return "", time.Time{}, errors.Wrapf(err, "error creating request for url %s", m.endpoint)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Metadata", "true")
+
+	// expires_on is a string with the unix time of the expiration.
+	var out struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresOn   json.Number `json:"expires_on"`
+	}
+	if err := doJSON(m.http, req, &out); err != nil {
+		return "", time.Time{}, errors.Wrap(err, "error getting azure managed identity token")
+	}
+	expiresOn, err := strconv.ParseInt(out.ExpiresOn.String(), 10, 64)
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "error parsing azure managed identity token")
+	}
+	return out.AccessToken, time.Unix(expiresOn, 0), nil
+}
+
+// workloadIdentity exchanges the federated token of an AKS workload identity
+// for Azure AD tokens.
+type workloadIdentity struct {
+	endpoint  string
+	clientID  string
+	tokenFile string
+	http      *http.Client
+}
+
+func (w *workloadIdentity) expiringToken(ctx context.Context, resource string) (string, time.Time, error) {
+	// The federated token is rotated by kubernetes, so it's read every time.
+	assertion, err := ioutil.ReadFile(w.tokenFile)
+	if err != nil {
+		return "", time.Time{}, errors.Wrapf(err, "error reading %s", w.tokenFile)
+	}
+	form := url.Values{
+		"client_id":             []string{w.clientID},
+		"scope":                 []string{resource + "/.default"},
+		"grant_type":            []string{"client_credentials"},
+		"client_assertion_type": []string{"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      []string{strings.TrimSpace(string(assertion))},
+	}
+	req, err := http.NewRequest("POST", w.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, errors.Wrapf(err, "error creating request for url %s", w.endpoint)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	now := time.Now()
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := doJSON(w.http, req, &out); err != nil {
+		return "", time.Time{}, errors.Wrap(err, "error getting azure workload identity token")
+	}
+	return out.AccessToken, now.Add(time.Duration(out.ExpiresIn) * time.Second), nil
+}
@@ -0,0 +1,90 @@
+package azurekms
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/base64"
+	"io"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// Signer implements a crypto.Signer using Azure Key Vault.
+type Signer struct {
+	client    *client
+	keyID     string
+	publicKey crypto.PublicKey
+}
+
+// Public returns the public key of this signer.
+func (s *Signer) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign signs digest with the private key stored in Azure Key Vault.
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	alg, err := signingAlgorithm(s.publicKey, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	var out struct {
+		Value string `json:"value"`
+	}
+	if err := s.client.do(ctx, "POST", s.keyID+"/sign", map[string]string{
+		"alg":   alg,
+		"value": base64.RawURLEncoding.EncodeToString(digest),
+	}, &out); err != nil {
+		return nil, errors.Wrap(err, "azureKMS Sign failed")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(out.Value)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding azureKMS signature")
+	}
+
+	// ECDSA signatures are the concatenation of r and s, but crypto.Signer
+	// returns them ASN.1 encoded.
+	if _, ok := s.publicKey.(*ecdsa.PublicKey); ok {
+		if len(sig) == 0 || len(sig)%2 != 0 {
+			return nil, errors.New("azureKMS returned an invalid ecdsa signature")
+		}
+		n := len(sig) / 2
+		return asn1.Marshal(struct {
+			R, S *big.Int
+		}{new(big.Int).SetBytes(sig[:n]), new(big.Int).SetBytes(sig[n:])})
+	}
+	return sig, nil
+}
+
+// signingAlgorithm returns the JWA algorithm for the given key and options.
+func signingAlgorithm(pub crypto.PublicKey, opts crypto.SignerOpts) (string, error) {
+	var size string
+	switch h := opts.HashFunc(); h {
+	case crypto.SHA256:
+		size = "256"
+	case crypto.SHA384:
+		size = "384"
+	case crypto.SHA512:
+		size = "512"
+	default:
+		return "", errors.Errorf("unsupported hash function %v", h)
+	}
+
+	switch pub.(type) {
+	case *ecdsa.PublicKey:
+		return "ES" + size, nil
+	case *rsa.PublicKey:
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			return "PS" + size, nil
+		}
+		return "RS" + size, nil
+	default:
+		return "", errors.Errorf("unsupported public key type %T", pub)
+	}
+}
@@ -21,6 +21,23 @@ import (
 
 const pendingGenerationRetries = 10
 
+// transientRetries is the number of times a request that failed with a
+// transient error is retried.
+const transientRetries = 3
+
+// retryBackoff is the initial time to wait before retrying a request, it is
+// doubled on every retry.
+var retryBackoff = 500 * time.Millisecond
+
+// transientCodes are the status codes of the errors that are retried.
+var transientCodes = map[codes.Code]bool{
+	codes.Unavailable:       true,
+	codes.DeadlineExceeded:  true,
+	codes.ResourceExhausted: true,
+	codes.Aborted:           true,
+	codes.Internal:          true,
+}
+
 // protectionLevelMapping maps step protection levels with cloud kms ones.
 var protectionLevelMapping = map[apiv1.ProtectionLevel]kmspb.ProtectionLevel{
 	apiv1.UnspecifiedProtectionLevel: kmspb.ProtectionLevel_PROTECTION_LEVEL_UNSPECIFIED,
@@ -69,6 +86,7 @@ type KeyManagementClient interface {
 	GetKeyRing(context.Context, *kmspb.GetKeyRingRequest, ...gax.CallOption) (*kmspb.KeyRing, error)
 	CreateKeyRing(context.Context, *kmspb.CreateKeyRingRequest, ...gax.CallOption) (*kmspb.KeyRing, error)
 	CreateCryptoKeyVersion(ctx context.Context, req *kmspb.CreateCryptoKeyVersionRequest, opts ...gax.CallOption) (*kmspb.CryptoKeyVersion, error)
+	GetCryptoKeyVersion(ctx context.Context, req *kmspb.GetCryptoKeyVersionRequest, opts ...gax.CallOption) (*kmspb.CryptoKeyVersion, error)
 }
 
 // CloudKMS implements a KMS using Google's Cloud apiv1.
@@ -109,13 +127,41 @@ func (k *CloudKMS) Close() error {
 }
 
 // CreateSigner returns a new cloudkms signer configured with the given signing
-// key name.
+// key name. The name must be pinned to a key version, and the version must be
+// enabled; the public key of that version is loaded by the signer.
 func (k *CloudKMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
 	if req.SigningKey == "" {
 		return nil, errors.New("signing key cannot be empty")
 	}
+	if !strings.Contains(req.SigningKey, "/cryptoKeyVersions/") {
+		return nil, errors.Errorf("signing key %s must include the key version: .../cryptoKeys/<key-id>/cryptoKeyVersions/<version>", req.SigningKey)
+	}
 
-	return NewSigner(k.client, req.SigningKey), nil
+	var version *kmspb.CryptoKeyVersion
+	if err := withRetries(func(ctx context.Context) (err error) {
+		version, err = k.client.GetCryptoKeyVersion(ctx, &kmspb.GetCryptoKeyVersionRequest{
+			Name: req.SigningKey,
+		})
+		return
+	}); err != nil {
+		return nil, errors.Wrap(err, "cloudKMS GetCryptoKeyVersion failed")
+	}
+	if version.State != kmspb.CryptoKeyVersion_ENABLED {
+		return nil, errors.Errorf("cloudKMS key version %s is %s", req.SigningKey, version.State)
+	}
+
+	pk, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{
+		Name: req.SigningKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Signer{
+		client:     k.client,
+		signingKey: req.SigningKey,
+		publicKey:  pk,
+	}, nil
 }
 
 // CreateKey creates in Google's Cloud KMS a new asymmetric key for signing.
@@ -263,12 +309,14 @@ func (k *CloudKMS) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKe
 // FailedPrecondition, caused because the key is in the PENDING_GENERATION
 // status.
 func (k *CloudKMS) getPublicKeyWithRetries(name string, retries int) (response *kmspb.PublicKey, err error) {
-	workFn := func() (*kmspb.PublicKey, error) {
-		ctx, cancel := defaultContext()
-		defer cancel()
-		return k.client.GetPublicKey(ctx, &kmspb.GetPublicKeyRequest{
-			Name: name,
+	workFn := func() (response *kmspb.PublicKey, err error) {
+		err = withRetries(func(ctx context.Context) (err error) {
+			response, err = k.client.GetPublicKey(ctx, &kmspb.GetPublicKeyRequest{
+				Name: name,
+			})
+			return
 		})
+		return
 	}
 	for i := 0; i < retries; i++ {
 		if response, err = workFn(); err == nil {
@@ -287,6 +335,22 @@ func defaultContext() (context.Context, context.CancelFunc) {
 	return context.WithTimeout(context.Background(), 15*time.Second)
 }
 
+// withRetries calls fn with a new default context, and retries it with an
+// exponential backoff if it fails with a transient error.
+func withRetries(fn func(ctx context.Context) error) error {
+	backoff := retryBackoff
+	for i := 0; ; i++ {
+		ctx, cancel := defaultContext()
+		err := fn(ctx)
+		cancel()
+		if err == nil || i == transientRetries || !transientCodes[status.Code(err)] {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
 // Parent splits a string in the format `key/value/key2/value2` in a parent and
 // child, for the previous string it will return `key/value` and `value2`.
 func Parent(name string) (string, string) {
@@ -128,6 +128,31 @@ func TestCloudKMS_Close(t *testing.T) {
 
 func TestCloudKMS_CreateSigner(t *testing.T) {
 	keyName := "projects/p/locations/l/keyRings/k/cryptoKeys/c/cryptoKeyVersions/1"
+	testError := fmt.Errorf("an error")
+
+	pemBytes, err := ioutil.ReadFile("testdata/pub.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, err := pemutil.ParseKey(pemBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmp := retryBackoff
+	retryBackoff = 0
+	defer func() { retryBackoff = tmp }()
+
+	var retries int
+	okPublicKey := func(_ context.Context, _ *kmspb.GetPublicKeyRequest, _ ...gax.CallOption) (*kmspb.PublicKey, error) {
+		return &kmspb.PublicKey{Pem: string(pemBytes)}, nil
+	}
+	versionState := func(state kmspb.CryptoKeyVersion_CryptoKeyVersionState) func(context.Context, *kmspb.GetCryptoKeyVersionRequest, ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
+		return func(_ context.Context, req *kmspb.GetCryptoKeyVersionRequest, _ ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
+			return &kmspb.CryptoKeyVersion{Name: req.Name, State: state}, nil
+		}
+	}
+
 	type fields struct {
 		client KeyManagementClient
 	}
@@ -138,11 +163,39 @@ func TestCloudKMS_CreateSigner(t *testing.T) {
 		name    string
 		fields  fields
 		args    args
-		want    crypto.Signer
+		want    crypto.PublicKey
 		wantErr bool
 	}{
-		{"ok", fields{&MockClient{}}, args{&apiv1.CreateSignerRequest{SigningKey: keyName}}, &Signer{client: &MockClient{}, signingKey: keyName}, false},
-		{"fail", fields{&MockClient{}}, args{&apiv1.CreateSignerRequest{SigningKey: ""}}, nil, true},
+		{"ok", fields{&MockClient{
+			getCryptoKeyVersion: versionState(kmspb.CryptoKeyVersion_ENABLED),
+			getPublicKey:        okPublicKey,
+		}}, args{&apiv1.CreateSignerRequest{SigningKey: keyName}}, pk, false},
+		{"ok with retries", fields{&MockClient{
+			getCryptoKeyVersion: func(_ context.Context, req *kmspb.GetCryptoKeyVersionRequest, _ ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
+				if retries != 2 {
+					retries++
+					return nil, status.Error(codes.Unavailable, "unavailable")
+				}
+				return &kmspb.CryptoKeyVersion{Name: req.Name, State: kmspb.CryptoKeyVersion_ENABLED}, nil
+			},
+			getPublicKey: okPublicKey,
+		}}, args{&apiv1.CreateSignerRequest{SigningKey: keyName}}, pk, false},
+		{"fail empty", fields{&MockClient{}}, args{&apiv1.CreateSignerRequest{SigningKey: ""}}, nil, true},
+		{"fail no version", fields{&MockClient{}}, args{&apiv1.CreateSignerRequest{SigningKey: "projects/p/locations/l/keyRings/k/cryptoKeys/c"}}, nil, true},
+		{"fail get version", fields{&MockClient{
+			getCryptoKeyVersion: func(_ context.Context, _ *kmspb.GetCryptoKeyVersionRequest, _ ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
+				return nil, testError
+			},
+		}}, args{&apiv1.CreateSignerRequest{SigningKey: keyName}}, nil, true},
+		{"fail disabled", fields{&MockClient{
+			getCryptoKeyVersion: versionState(kmspb.CryptoKeyVersion_DISABLED),
+		}}, args{&apiv1.CreateSignerRequest{SigningKey: keyName}}, nil, true},
+		{"fail get public key", fields{&MockClient{
+			getCryptoKeyVersion: versionState(kmspb.CryptoKeyVersion_ENABLED),
+			getPublicKey: func(_ context.Context, _ *kmspb.GetPublicKeyRequest, _ ...gax.CallOption) (*kmspb.PublicKey, error) {
+				return nil, testError
+			},
+		}}, args{&apiv1.CreateSignerRequest{SigningKey: keyName}}, nil, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -154,8 +207,52 @@ func TestCloudKMS_CreateSigner(t *testing.T) {
 				t.Errorf("CloudKMS.CreateSigner() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("CloudKMS.CreateSigner() = %v, want %v", got, tt.want)
+			if err != nil {
+				return
+			}
+			s, ok := got.(*Signer)
+			if !ok {
+				t.Fatalf("CloudKMS.CreateSigner() = %T, want *Signer", got)
+			}
+			if s.client != tt.fields.client || s.signingKey != tt.args.req.SigningKey {
+				t.Errorf("CloudKMS.CreateSigner() = %v, want client %v and signing key %s", s, tt.fields.client, tt.args.req.SigningKey)
+			}
+			if !reflect.DeepEqual(s.Public(), tt.want) {
+				t.Errorf("Signer.Public() = %v, want %v", s.Public(), tt.want)
+			}
+		})
+	}
+}
+
+func Test_withRetries(t *testing.T) {
+	tmp := retryBackoff
+	retryBackoff = 0
+	defer func() { retryBackoff = tmp }()
+
+	tests := []struct {
+		name      string
+		err       error
+		wantCalls int
+		wantErr   bool
+	}{
+		{"ok", nil, 1, false},
+		{"fail unavailable", status.Error(codes.Unavailable, "unavailable"), transientRetries + 1, true},
+		{"fail resource exhausted", status.Error(codes.ResourceExhausted, "quota exceeded"), transientRetries + 1, true},
+		{"fail not found", status.Error(codes.NotFound, "not found"), 1, true},
+		{"fail other", fmt.Errorf("an error"), 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			err := withRetries(func(ctx context.Context) error {
+				calls++
+				return tt.err
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("withRetries() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("withRetries() calls = %d, want %d", calls, tt.wantCalls)
 			}
 		})
 	}
@@ -1,6 +1,7 @@
 package cloudkms
 
 import (
+	"context"
 	"crypto"
 	"io"
 
@@ -13,6 +14,7 @@ import (
 type Signer struct {
 	client     KeyManagementClient
 	signingKey string
+	publicKey  crypto.PublicKey
 }
 
 func NewSigner(c KeyManagementClient, signingKey string) *Signer {
@@ -22,8 +24,13 @@ func NewSigner(c KeyManagementClient, signingKey string) *Signer {
 	}
 }
 
-// Public returns the public key of this signer or an error.
+// Public returns the public key of this signer or an error. If the signer was
+// created with CloudKMS.CreateSigner the public key loaded then is returned.
 func (s *Signer) Public() crypto.PublicKey {
+	if s.publicKey != nil {
+		return s.publicKey
+	}
+
 	ctx, cancel := defaultContext()
 	defer cancel()
 
@@ -66,10 +73,11 @@ func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]
 		return nil, errors.Errorf("unsupported hash function %v", h)
 	}
 
-	ctx, cancel := defaultContext()
-	defer cancel()
-
-	response, err := s.client.AsymmetricSign(ctx, req)
+	var response *kmspb.AsymmetricSignResponse
+	err := withRetries(func(ctx context.Context) (err error) {
+		response, err = s.client.AsymmetricSign(ctx, req)
+		return
+	})
 	if err != nil {
 		return nil, errors.Wrap(err, "cloudKMS AsymmetricSign failed")
 	}
@@ -13,6 +13,8 @@ import (
 	gax "github.com/googleapis/gax-go/v2"
 	"github.com/smallstep/cli/crypto/pemutil"
 	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func Test_newSigner(t *testing.T) {
@@ -106,6 +108,25 @@ func Test_signer_Sign(t *testing.T) {
 			return nil, fmt.Errorf("an error")
 		},
 	}
+	var retries int
+	retryClient := &MockClient{
+		asymmetricSign: func(_ context.Context, _ *kmspb.AsymmetricSignRequest, _ ...gax.CallOption) (*kmspb.AsymmetricSignResponse, error) {
+			if retries != 2 {
+				retries++
+				return nil, status.Error(codes.Unavailable, "unavailable")
+			}
+			return &kmspb.AsymmetricSignResponse{Signature: []byte("ok signature")}, nil
+		},
+	}
+	unavailableClient := &MockClient{
+		asymmetricSign: func(_ context.Context, _ *kmspb.AsymmetricSignRequest, _ ...gax.CallOption) (*kmspb.AsymmetricSignResponse, error) {
+			return nil, status.Error(codes.Unavailable, "unavailable")
+		},
+	}
+
+	tmp := retryBackoff
+	retryBackoff = 0
+	defer func() { retryBackoff = tmp }()
 
 	type fields struct {
 		client     KeyManagementClient
@@ -128,6 +149,8 @@ func Test_signer_Sign(t *testing.T) {
 		{"ok sha512", fields{okClient, keyName}, args{rand.Reader, []byte("digest"), crypto.SHA512}, []byte("ok signature"), false},
 		{"fail MD5", fields{okClient, keyName}, args{rand.Reader, []byte("digest"), crypto.MD5}, nil, true},
 		{"fail asymmetric sign", fields{failClient, keyName}, args{rand.Reader, []byte("digest"), crypto.SHA256}, nil, true},
+		{"ok with retries", fields{retryClient, keyName}, args{rand.Reader, []byte("digest"), crypto.SHA256}, []byte("ok signature"), false},
+		{"fail unavailable", fields{unavailableClient, keyName}, args{rand.Reader, []byte("digest"), crypto.SHA256}, nil, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
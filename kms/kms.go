@@ -7,8 +7,12 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/kms/awskms"
+	"github.com/smallstep/certificates/kms/azurekms"
 	"github.com/smallstep/certificates/kms/cloudkms"
+	"github.com/smallstep/certificates/kms/pkcs11"
 	"github.com/smallstep/certificates/kms/softkms"
+	"github.com/smallstep/certificates/kms/tpmkms"
 )
 
 // KeyManager is the interface implemented by all the KMS.
@@ -19,6 +23,12 @@ type KeyManager interface {
 	Close() error
 }
 
+// Decrypter is an optional interface implemented by the KMSs that can decrypt
+// data with the keys they manage.
+type Decrypter interface {
+	CreateDecrypter(req *apiv1.CreateDecrypterRequest) (crypto.Decrypter, error)
+}
+
 // New initializes a new KMS from the given type.
 func New(ctx context.Context, opts apiv1.Options) (KeyManager, error) {
 	if err := opts.Validate(); err != nil {
@@ -30,6 +40,14 @@ func New(ctx context.Context, opts apiv1.Options) (KeyManager, error) {
 		return softkms.New(ctx, opts)
 	case apiv1.CloudKMS:
 		return cloudkms.New(ctx, opts)
+	case apiv1.AmazonKMS:
+		return awskms.New(ctx, opts)
+	case apiv1.AzureKMS:
+		return azurekms.New(ctx, opts)
+	case apiv1.PKCS11:
+		return pkcs11.New(ctx, opts)
+	case apiv1.TPMKMS:
+		return tpmkms.New(ctx, opts)
 	default:
 		return nil, errors.Errorf("unsupported kms type '%s'", opts.Type)
 	}
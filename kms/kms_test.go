@@ -7,8 +7,12 @@ import (
 	"testing"
 
 	"github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/kms/awskms"
+	"github.com/smallstep/certificates/kms/azurekms"
 	"github.com/smallstep/certificates/kms/cloudkms"
+	"github.com/smallstep/certificates/kms/pkcs11"
 	"github.com/smallstep/certificates/kms/softkms"
+	"github.com/smallstep/certificates/kms/tpmkms"
 )
 
 func TestNew(t *testing.T) {
@@ -28,9 +32,12 @@ func TestNew(t *testing.T) {
 		{"softkms", false, args{ctx, apiv1.Options{Type: "softkms"}}, &softkms.SoftKMS{}, false},
 		{"default", false, args{ctx, apiv1.Options{}}, &softkms.SoftKMS{}, false},
 		{"cloudkms", true, args{ctx, apiv1.Options{Type: "cloudkms"}}, &cloudkms.CloudKMS{}, true}, // fails because not credentials
-		{"awskms", false, args{ctx, apiv1.Options{Type: "awskms"}}, nil, true},                     // not yet supported
-		{"pkcs11", false, args{ctx, apiv1.Options{Type: "pkcs11"}}, nil, true},                     // not yet supported
+		{"pkcs11", false, args{ctx, apiv1.Options{Type: "pkcs11"}}, nil, true},                     // fails because no module
 		{"fail validation", false, args{ctx, apiv1.Options{Type: "foobar"}}, nil, true},
+		{"fail pkcs11 module", false, args{ctx, apiv1.Options{Type: "pkcs11", Module: "testdata/missing.so"}}, &pkcs11.PKCS11{}, true},
+		{"fail awskms credentials", false, args{ctx, apiv1.Options{Type: "awskms", CredentialsFile: "testdata/missing"}}, &awskms.KMS{}, true},
+		{"azurekms", false, args{ctx, apiv1.Options{Type: "azurekms"}}, &azurekms.KeyVault{}, false},
+		{"fail tpmkms device", false, args{ctx, apiv1.Options{Type: "tpmkms", Device: "testdata/missing"}}, &tpmkms.TPMKMS{}, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
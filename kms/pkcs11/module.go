@@ -0,0 +1,129 @@
+//go:build cgo
+// +build cgo
+
+package pkcs11
+
+import (
+	"crypto"
+	"crypto/elliptic"
+	"crypto/rand"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
+)
+
+// p11Module is a PKCS #11 module used through crypto11. The operations use a
+// pool of sessions in the token.
+type p11Module struct {
+	ctx *crypto11.Context
+}
+
+// openModule loads the module in the given path, opens a session in the token
+// with the given label, or in the first token, and logs in with the pin.
+func openModule(path, tokenLabel, pin string) (Module, error) {
+	config := &crypto11.Config{
+		Path:              path,
+		TokenLabel:        tokenLabel,
+		Pin:               pin,
+		LoginNotSupported: pin == "",
+	}
+	if tokenLabel == "" {
+		slot, err := firstSlot(path)
+		if err != nil {
+			return nil, err
+		}
+		config.SlotNumber = &slot
+	}
+	ctx, err := crypto11.Configure(config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error initializing pkcs11 module %s", path)
+	}
+	return &p11Module{ctx: ctx}, nil
+}
+
+// firstSlot returns the first slot with a token in the module.
+func firstSlot(path string) (int, error) {
+	p := pkcs11.New(path)
+	if p == nil {
+		return 0, errors.Errorf("error loading pkcs11 module %s", path)
+	}
+	defer p.Destroy()
+	// Another context may be using the module, it's only finalized if it was
+	// initialized here.
+	if err := p.Initialize(); err == nil {
+		defer p.Finalize()
+	} else if e, ok := err.(pkcs11.Error); !ok || e != pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED {
+		return 0, errors.Wrapf(err, "error initializing pkcs11 module %s", path)
+	}
+	slots, err := p.GetSlotList(true)
+	if err != nil {
+		return 0, errors.Wrap(err, "error listing pkcs11 slots")
+	}
+	if len(slots) == 0 {
+		return 0, errors.New("pkcs11 module does not have any token")
+	}
+	return int(slots[0]), nil
+}
+
+// Close closes the sessions and finalizes the module.
+func (m *p11Module) Close() error {
+	return m.ctx.Close()
+}
+
+// FindKeyPair returns a signer with the private key and the public key with
+// the given id and label.
+func (m *p11Module) FindKeyPair(id []byte, label string) (crypto.Signer, error) {
+	var lbl []byte
+	if label != "" {
+		lbl = []byte(label)
+	}
+	if len(id) == 0 {
+		id = nil
+	}
+	signer, err := m.ctx.FindKeyPair(id, lbl)
+	if err != nil {
+		return nil, err
+	}
+	if signer == nil {
+		return nil, errKeyNotFound
+	}
+	return signer, nil
+}
+
+// GenerateECDSAKeyPair generates an EC key pair in the token.
+func (m *p11Module) GenerateECDSAKeyPair(id []byte, label string, curve elliptic.Curve) (crypto.Signer, error) {
+	id, err := keyID(id)
+	if err != nil {
+		return nil, err
+	}
+	if label == "" {
+		return m.ctx.GenerateECDSAKeyPair(id, curve)
+	}
+	return m.ctx.GenerateECDSAKeyPairWithLabel(id, []byte(label), curve)
+}
+
+// GenerateRSAKeyPair generates a RSA key pair in the token.
+func (m *p11Module) GenerateRSAKeyPair(id []byte, label string, bits int) (crypto.Signer, error) {
+	id, err := keyID(id)
+	if err != nil {
+		return nil, err
+	}
+	if label == "" {
+		return m.ctx.GenerateRSAKeyPair(id, bits)
+	}
+	return m.ctx.GenerateRSAKeyPairWithLabel(id, []byte(label), bits)
+}
+
+// keyID returns the given id, or a random one if it's empty. Key pairs are
+// found by their id, so keys created only with a label need one too.
+func keyID(id []byte) ([]byte, error) {
+	if len(id) > 0 {
+		return id, nil
+	}
+	id = make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, errors.Wrap(err, "error generating pkcs11 key id")
+	}
+	return id, nil
+}
@@ -0,0 +1,10 @@
+//go:build !cgo
+// +build !cgo
+
+package pkcs11
+
+import "github.com/pkg/errors"
+
+func openModule(path, tokenLabel, pin string) (Module, error) {
+	return nil, errors.New("pkcs11 is not supported: step-ca was compiled without cgo")
+}
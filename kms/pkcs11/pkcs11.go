@@ -0,0 +1,206 @@
+// Package pkcs11 implements a KMS that stores the keys in a Hardware Security
+// Module using a PKCS #11 module, like SoftHSM, Thales Luna or AWS CloudHSM.
+// The module is loaded with crypto11.
+//
+// The keys are referenced with a PKCS #11 URI, as defined in RFC 7512, with
+// the id and the label of the key, e.g. pkcs11:id=7331;object=intermediate.
+package pkcs11
+
+import (
+	"context"
+	"crypto"
+	"crypto/elliptic"
+	"encoding/hex"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+// DefaultRSAKeySize is the default size for RSA keys.
+const DefaultRSAKeySize = 3072
+
+// errKeyNotFound is the error returned by the modules if the key pair does not
+// exist.
+var errKeyNotFound = errors.New("key not found")
+
+type keyAttributes struct {
+	Type  string
+	Curve elliptic.Curve
+}
+
+var signatureAlgorithmMapping = map[apiv1.SignatureAlgorithm]keyAttributes{
+	apiv1.UnspecifiedSignAlgorithm: {"EC", elliptic.P256()},
+	apiv1.SHA256WithRSA:            {"RSA", nil},
+	apiv1.SHA384WithRSA:            {"RSA", nil},
+	apiv1.SHA512WithRSA:            {"RSA", nil},
+	apiv1.SHA256WithRSAPSS:         {"RSA", nil},
+	apiv1.SHA384WithRSAPSS:         {"RSA", nil},
+	apiv1.SHA512WithRSAPSS:         {"RSA", nil},
+	apiv1.ECDSAWithSHA256:          {"EC", elliptic.P256()},
+	apiv1.ECDSAWithSHA384:          {"EC", elliptic.P384()},
+	apiv1.ECDSAWithSHA512:          {"EC", elliptic.P521()},
+}
+
+// Module defines the methods of a PKCS #11 module used by the KMS. The
+// signers returned sign with the private key in the module. This interface
+// will be used for unit testing.
+type Module interface {
+	FindKeyPair(id []byte, label string) (crypto.Signer, error)
+	GenerateECDSAKeyPair(id []byte, label string, curve elliptic.Curve) (crypto.Signer, error)
+	GenerateRSAKeyPair(id []byte, label string, bits int) (crypto.Signer, error)
+	Close() error
+}
+
+// PKCS11 implements a KMS using a PKCS #11 module.
+type PKCS11 struct {
+	module Module
+}
+
+// New opens the PKCS #11 module in the options and logs in the token with the
+// given label, or the first token if the label is empty, using the pin.
+func New(ctx context.Context, opts apiv1.Options) (*PKCS11, error) {
+	if opts.Module == "" {
+		return nil, errors.New("kms module cannot be empty")
+	}
+	module, err := openModule(opts.Module, opts.TokenLabel, opts.Pin)
+	if err != nil {
+		return nil, err
+	}
+	return &PKCS11{
+		module: module,
+	}, nil
+}
+
+// NewPKCS11 creates a PKCS11 with the given module.
+func NewPKCS11(module Module) *PKCS11 {
+	return &PKCS11{
+		module: module,
+	}
+}
+
+// GetPublicKey returns the public key of the key pair with the given URI.
+func (k *PKCS11) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error) {
+	signer, err := k.findKeyPair(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return signer.Public(), nil
+}
+
+// CreateKey generates a new key pair in the token with the id and label in the
+// given URI. It fails if a key pair with the same id and label already exists.
+func (k *PKCS11) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	if req.Name == "" {
+		return nil, errors.New("createKeyRequest 'name' cannot be empty")
+	}
+	v, ok := signatureAlgorithmMapping[req.SignatureAlgorithm]
+	if !ok {
+		return nil, errors.Errorf("pkcs11 does not support signature algorithm '%s'", req.SignatureAlgorithm)
+	}
+	id, label, err := parseURI(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := k.module.FindKeyPair(id, label); err == nil {
+		return nil, errors.Errorf("pkcs11 key %s already exists", req.Name)
+	} else if err != errKeyNotFound {
+		return nil, errors.Wrapf(err, "pkcs11 FindKeyPair failed")
+	}
+
+	var signer crypto.Signer
+	switch v.Type {
+	case "EC":
+		signer, err = k.module.GenerateECDSAKeyPair(id, label, v.Curve)
+	default:
+		bits := req.Bits
+		if bits == 0 {
+			bits = DefaultRSAKeySize
+		}
+		signer, err = k.module.GenerateRSAKeyPair(id, label, bits)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "pkcs11 GenerateKeyPair failed")
+	}
+
+	return &apiv1.CreateKeyResponse{
+		Name:      req.Name,
+		PublicKey: signer.Public(),
+		CreateSignerRequest: apiv1.CreateSignerRequest{
+			SigningKey: req.Name,
+		},
+	}, nil
+}
+
+// CreateSigner returns a signer that uses the private key with the URI in the
+// signing key.
+func (k *PKCS11) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
+	if req.SigningKey == "" {
+		return nil, errors.New("signing key cannot be empty")
+	}
+	return k.findKeyPair(req.SigningKey)
+}
+
+// Close logs out and closes the PKCS #11 module.
+func (k *PKCS11) Close() error {
+	if err := k.module.Close(); err != nil {
+		return errors.Wrap(err, "pkcs11 Close failed")
+	}
+	return nil
+}
+
+func (k *PKCS11) findKeyPair(rawuri string) (crypto.Signer, error) {
+	id, label, err := parseURI(rawuri)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := k.module.FindKeyPair(id, label)
+	switch {
+	case err == errKeyNotFound:
+		return nil, errors.Errorf("pkcs11 key %s not found", rawuri)
+	case err != nil:
+		return nil, errors.Wrap(err, "pkcs11 FindKeyPair failed")
+	default:
+		return signer, nil
+	}
+}
+
+// parseURI returns the id and the label in a PKCS #11 URI with the format
+// pkcs11:id=<hex-id>;object=<label>. The id can also be percent-encoded.
+func parseURI(rawuri string) (id []byte, label string, err error) {
+	if !strings.HasPrefix(rawuri, "pkcs11:") {
+		return nil, "", errors.Errorf("key %s is not a pkcs11 uri", rawuri)
+	}
+	// Attributes in the query, like pin-value, are not used.
+	path := strings.SplitN(strings.TrimPrefix(rawuri, "pkcs11:"), "?", 2)[0]
+	for _, attr := range strings.Split(path, ";") {
+		if attr == "" {
+			continue
+		}
+		kv := strings.SplitN(attr, "=", 2)
+		if len(kv) != 2 {
+			return nil, "", errors.Errorf("key %s is not a valid pkcs11 uri", rawuri)
+		}
+		value, err := url.PathUnescape(kv[1])
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "key %s is not a valid pkcs11 uri", rawuri)
+		}
+		switch kv[0] {
+		case "id":
+			// Hex encoded ids are the most common, but RFC 7512 uses
+			// percent-encoded bytes.
+			if strings.Contains(kv[1], "%") {
+				id = []byte(value)
+			} else if id, err = hex.DecodeString(value); err != nil {
+				return nil, "", errors.Errorf("key %s is not a valid pkcs11 uri: id must be hex or percent-encoded", rawuri)
+			}
+		case "object":
+			label = value
+		}
+	}
+	if len(id) == 0 && label == "" {
+		return nil, "", errors.Errorf("key %s is not a valid pkcs11 uri: id or object are required", rawuri)
+	}
+	return id, label, nil
+}
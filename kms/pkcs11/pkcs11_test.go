@@ -0,0 +1,211 @@
+package pkcs11
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+type mockModule struct {
+	keys   map[string]crypto.Signer
+	err    error
+	closed bool
+}
+
+func newMockModule() *mockModule {
+	return &mockModule{keys: make(map[string]crypto.Signer)}
+}
+
+func mockKey(id []byte, label string) string {
+	return string(id) + ";" + label
+}
+
+func (m *mockModule) FindKeyPair(id []byte, label string) (crypto.Signer, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	if s, ok := m.keys[mockKey(id, label)]; ok {
+		return s, nil
+	}
+	return nil, errKeyNotFound
+}
+
+func (m *mockModule) GenerateECDSAKeyPair(id []byte, label string, curve elliptic.Curve) (crypto.Signer, error) {
+	s, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	m.keys[mockKey(id, label)] = s
+	return s, nil
+}
+
+func (m *mockModule) GenerateRSAKeyPair(id []byte, label string, bits int) (crypto.Signer, error) {
+	s, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, err
+	}
+	m.keys[mockKey(id, label)] = s
+	return s, nil
+}
+
+func (m *mockModule) Close() error {
+	m.closed = true
+	return m.err
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    apiv1.Options
+		wantErr bool
+	}{
+		{"fail/no-module", apiv1.Options{Type: "pkcs11"}, true},
+		{"fail/missing-module", apiv1.Options{Type: "pkcs11", Module: "testdata/missing.so"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(context.Background(), tt.opts); (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPKCS11_CreateKey(t *testing.T) {
+	m := newMockModule()
+	k := NewPKCS11(m)
+
+	tests := []struct {
+		name    string
+		req     *apiv1.CreateKeyRequest
+		wantPub interface{}
+		wantErr bool
+	}{
+		{"ok/default", &apiv1.CreateKeyRequest{Name: "pkcs11:id=01;object=root"}, elliptic.P256(), false},
+		{"ok/p384", &apiv1.CreateKeyRequest{Name: "pkcs11:id=02", SignatureAlgorithm: apiv1.ECDSAWithSHA384}, elliptic.P384(), false},
+		{"ok/p521", &apiv1.CreateKeyRequest{Name: "pkcs11:object=p521", SignatureAlgorithm: apiv1.ECDSAWithSHA512}, elliptic.P521(), false},
+		{"ok/rsa", &apiv1.CreateKeyRequest{Name: "pkcs11:id=03", SignatureAlgorithm: apiv1.SHA256WithRSA, Bits: 1024}, 1024, false},
+		{"ok/rsa-pss", &apiv1.CreateKeyRequest{Name: "pkcs11:id=04", SignatureAlgorithm: apiv1.SHA256WithRSAPSS, Bits: 2048}, 2048, false},
+		{"fail/exists", &apiv1.CreateKeyRequest{Name: "pkcs11:id=01;object=root"}, nil, true},
+		{"fail/name", &apiv1.CreateKeyRequest{}, nil, true},
+		{"fail/uri", &apiv1.CreateKeyRequest{Name: "projects/p/locations/global/keyRings/r/cryptoKeys/k"}, nil, true},
+		{"fail/ed25519", &apiv1.CreateKeyRequest{Name: "pkcs11:id=05", SignatureAlgorithm: apiv1.PureEd25519}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.CreateKey(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PKCS11.CreateKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Name != tt.req.Name || got.CreateSignerRequest.SigningKey != tt.req.Name {
+				t.Errorf("PKCS11.CreateKey() = %v, want name %s", got, tt.req.Name)
+			}
+			switch pub := got.PublicKey.(type) {
+			case *ecdsa.PublicKey:
+				if pub.Curve != tt.wantPub {
+					t.Errorf("PKCS11.CreateKey() curve = %v, want %v", pub.Curve.Params().Name, tt.wantPub)
+				}
+			case *rsa.PublicKey:
+				if pub.N.BitLen() != tt.wantPub {
+					t.Errorf("PKCS11.CreateKey() bits = %d, want %v", pub.N.BitLen(), tt.wantPub)
+				}
+			default:
+				t.Errorf("PKCS11.CreateKey() public key type = %T", pub)
+			}
+		})
+	}
+}
+
+func TestPKCS11_CreateSigner(t *testing.T) {
+	m := newMockModule()
+	signer, err := m.GenerateECDSAKeyPair([]byte{0x73, 0x31}, "intermediate", elliptic.P256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := NewPKCS11(m)
+
+	tests := []struct {
+		name    string
+		key     string
+		want    crypto.Signer
+		wantErr bool
+	}{
+		{"ok", "pkcs11:id=7331;object=intermediate", signer, false},
+		{"ok/percent-encoded", "pkcs11:id=%73%31;object=intermediate", signer, false},
+		{"ok/query", "pkcs11:id=7331;object=intermediate?pin-value=password", signer, false},
+		{"fail/empty", "", nil, true},
+		{"fail/not-found", "pkcs11:id=7332;object=intermediate", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: tt.key})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PKCS11.CreateSigner() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("PKCS11.CreateSigner() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	pub, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: "pkcs11:id=7331;object=intermediate"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pub, signer.Public()) {
+		t.Errorf("PKCS11.GetPublicKey() = %v, want %v", pub, signer.Public())
+	}
+
+	m.err = errors.New("force")
+	if _, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: "pkcs11:id=7331"}); err == nil {
+		t.Error("PKCS11.CreateSigner() error = nil, want an error")
+	}
+	if err := k.Close(); err == nil || !m.closed {
+		t.Errorf("PKCS11.Close() error = %v, closed = %v", err, m.closed)
+	}
+}
+
+func Test_parseURI(t *testing.T) {
+	tests := []struct {
+		name      string
+		rawuri    string
+		wantID    []byte
+		wantLabel string
+		wantErr   bool
+	}{
+		{"ok", "pkcs11:id=7331;object=intermediate", []byte{0x73, 0x31}, "intermediate", false},
+		{"ok/id", "pkcs11:id=01", []byte{0x01}, "", false},
+		{"ok/object", "pkcs11:object=ssh%20host", nil, "ssh host", false},
+		{"ok/token", "pkcs11:token=smallstep;object=root", nil, "root", false},
+		{"fail/scheme", "pkcs12:id=01", nil, "", true},
+		{"fail/empty", "pkcs11:", nil, "", true},
+		{"fail/attribute", "pkcs11:id", nil, "", true},
+		{"fail/hex", "pkcs11:id=zz", nil, "", true},
+		{"fail/escape", "pkcs11:object=%zz", nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotID, gotLabel, err := parseURI(tt.rawuri)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseURI() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(gotID, tt.wantID) {
+				t.Errorf("parseURI() id = %x, want %x", gotID, tt.wantID)
+			}
+			if gotLabel != tt.wantLabel {
+				t.Errorf("parseURI() label = %v, want %v", gotLabel, tt.wantLabel)
+			}
+		})
+	}
+}
@@ -92,6 +92,38 @@ func (k *SoftKMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, e
 	}
 }
 
+// CreateDecrypter returns a new decrypter configured with the given decryption
+// key.
+func (k *SoftKMS) CreateDecrypter(req *apiv1.CreateDecrypterRequest) (crypto.Decrypter, error) {
+	var opts []pemutil.Options
+	if req.Password != nil {
+		opts = append(opts, pemutil.WithPassword(req.Password))
+	}
+
+	var (
+		v   interface{}
+		err error
+	)
+	switch {
+	case req.Decrypter != nil:
+		return req.Decrypter, nil
+	case len(req.DecryptionKeyPEM) != 0:
+		v, err = pemutil.ParseKey(req.DecryptionKeyPEM, opts...)
+	case req.DecryptionKey != "":
+		v, err = pemutil.Read(req.DecryptionKey, opts...)
+	default:
+		return nil, errors.New("failed to load softKMS: please define decryptionKeyPEM or decryptionKey")
+	}
+	if err != nil {
+		return nil, err
+	}
+	dec, ok := v.(crypto.Decrypter)
+	if !ok {
+		return nil, errors.New("decryption key is not a crypto.Decrypter")
+	}
+	return dec, nil
+}
+
 func (k *SoftKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
 	v, ok := signatureAlgorithmMapping[req.SignatureAlgorithm]
 	if !ok {
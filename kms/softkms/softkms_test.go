@@ -12,6 +12,8 @@ import (
 	"encoding/pem"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -137,6 +139,59 @@ func TestSoftKMS_CreateSigner(t *testing.T) {
 	}
 }
 
+func TestSoftKMS_CreateDecrypter(t *testing.T) {
+	pk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemBlock, err := pemutil.Serialize(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemBlockPassword, err := pemutil.Serialize(pk, pemutil.WithPassword([]byte("pass")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "softkms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(pemBlock), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		req     *apiv1.CreateDecrypterRequest
+		want    crypto.Decrypter
+		wantErr bool
+	}{
+		{"decrypter", &apiv1.CreateDecrypterRequest{Decrypter: pk}, pk, false},
+		{"pem", &apiv1.CreateDecrypterRequest{DecryptionKeyPEM: pem.EncodeToMemory(pemBlock)}, pk, false},
+		{"pem password", &apiv1.CreateDecrypterRequest{DecryptionKeyPEM: pem.EncodeToMemory(pemBlockPassword), Password: []byte("pass")}, pk, false},
+		{"file", &apiv1.CreateDecrypterRequest{DecryptionKey: keyFile}, pk, false},
+		{"fail", &apiv1.CreateDecrypterRequest{}, nil, true},
+		{"fail bad pem", &apiv1.CreateDecrypterRequest{DecryptionKeyPEM: []byte("bad pem")}, nil, true},
+		{"fail not a decrypter", &apiv1.CreateDecrypterRequest{DecryptionKey: "testdata/priv.pem", Password: []byte("pass")}, nil, true},
+		{"fail missing", &apiv1.CreateDecrypterRequest{DecryptionKey: "testdata/missing"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &SoftKMS{}
+			got, err := k.CreateDecrypter(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SoftKMS.CreateDecrypter() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.want != nil && !reflect.DeepEqual(got.Public(), tt.want.Public()) {
+				t.Errorf("SoftKMS.CreateDecrypter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func restoreGenerateKey() func() {
 	oldGenerateKey := generateKey
 	return func() {
@@ -0,0 +1,153 @@
+package tpmkms
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/tpm"
+)
+
+// TPM 2.0 structure tags, command codes, handles and response codes.
+const (
+	tagNoSessions = 0x8001
+	tagSessions   = 0x8002
+	tagHashCheck  = 0x8024
+
+	ccEvictControl  = 0x00000120
+	ccCreatePrimary = 0x00000131
+	ccSign          = 0x0000015d
+	ccFlushContext  = 0x00000165
+	ccReadPublic    = 0x00000173
+
+	rhOwner = 0x40000001
+	rhNull  = 0x40000007
+	rsPW    = 0x40000009
+
+	persistentFirst = 0x81000000
+	persistentLast  = 0x81ffffff
+
+	rcFormat1 = 0x080
+	rcHandle  = 0x00b
+
+	// maxResponseSize is the maximum size of a TPM response.
+	maxResponseSize = 4096
+)
+
+// Object attributes of the keys created: fixedTPM, fixedParent,
+// sensitiveDataOrigin, userWithAuth, noDA and sign.
+const signingKeyAttributes = 0x00000002 | 0x00000010 | 0x00000020 | 0x00000040 | 0x00000400 | 0x00040000
+
+// tpmError is the error returned if a command does not succeed.
+type tpmError struct {
+	command uint32
+	code    uint32
+}
+
+func (e *tpmError) Error() string {
+	return fmt.Sprintf("tpm command 0x%03x failed with response code 0x%03x", e.command, e.code)
+}
+
+// isHandleError returns if the error is TPM_RC_HANDLE, the error returned if
+// a handle does not exist.
+func isHandleError(err error) bool {
+	e, ok := err.(*tpmError)
+	return ok && e.code&rcFormat1 != 0 && e.code&0x3f == rcHandle
+}
+
+// device sends the commands to a TPM, like /dev/tpmrm0.
+type device struct {
+	mu sync.Mutex
+	rw io.ReadWriter
+}
+
+// command is a TPM command, the handles requiring authorization must be first.
+type command struct {
+	code        uint32
+	handles     []uint32
+	auths       [][]byte
+	params      []byte
+	respHandles int
+}
+
+// run sends the command to the TPM and returns the response handles and
+// parameters. The auths are sent in password sessions.
+func (d *device) run(c *command) ([]uint32, []byte, error) {
+	var body bytes.Buffer
+	for _, h := range c.handles {
+		writeUint32(&body, h)
+	}
+	tag := uint16(tagNoSessions)
+	if len(c.auths) > 0 {
+		tag = tagSessions
+		var sessions bytes.Buffer
+		for _, auth := range c.auths {
+			writeUint32(&sessions, rsPW)
+			writeTPM2B(&sessions, nil) // nonceCaller
+			sessions.WriteByte(0)      // sessionAttributes
+			writeTPM2B(&sessions, auth)
+		}
+		writeUint32(&body, uint32(sessions.Len()))
+		body.Write(sessions.Bytes())
+	}
+	body.Write(c.params)
+
+	var cmd bytes.Buffer
+	writeUint16(&cmd, tag)
+	writeUint32(&cmd, uint32(10+body.Len()))
+	writeUint32(&cmd, c.code)
+	cmd.Write(body.Bytes())
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, err := d.rw.Write(cmd.Bytes()); err != nil {
+		return nil, nil, errors.Wrap(err, "error writing tpm command")
+	}
+	resp := make([]byte, maxResponseSize)
+	n, err := d.rw.Read(resp)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error reading tpm response")
+	}
+
+	r := tpm.NewReader(resp[:n])
+	respTag := r.Uint16()
+	size := r.Uint32()
+	code := r.Uint32()
+	if r.Err() != nil || int(size) != n {
+		return nil, nil, errors.New("tpm response is truncated")
+	}
+	if code != 0 {
+		return nil, nil, &tpmError{command: c.code, code: code}
+	}
+	handles := make([]uint32, c.respHandles)
+	for i := range handles {
+		handles[i] = r.Uint32()
+	}
+	var params []byte
+	if respTag == tagSessions {
+		params = r.Bytes(int(r.Uint32()))
+	} else {
+		params = r.Rest()
+	}
+	if r.Err() != nil {
+		return nil, nil, errors.New("tpm response is truncated")
+	}
+	return handles, params, nil
+}
+
+func writeUint16(w *bytes.Buffer, v uint16) {
+	binary.Write(w, binary.BigEndian, v)
+}
+
+func writeUint32(w *bytes.Buffer, v uint32) {
+	binary.Write(w, binary.BigEndian, v)
+}
+
+// writeTPM2B writes a TPM2B structure, a size followed by the data.
+func writeTPM2B(w *bytes.Buffer, b []byte) {
+	writeUint16(w, uint16(len(b)))
+	w.Write(b)
+}
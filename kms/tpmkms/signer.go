@@ -0,0 +1,108 @@
+package tpmkms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/asn1"
+	"io"
+	"math/big"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/tpm"
+)
+
+// Signer implements a crypto.Signer using a key persisted in a TPM 2.0.
+type Signer struct {
+	device    *device
+	handle    uint32
+	publicKey crypto.PublicKey
+}
+
+// Public returns the public key of this signer.
+func (s *Signer) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign signs digest with the private key stored in the TPM. ECDSA signatures
+// are returned ASN.1 encoded.
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	scheme, err := signatureScheme(s.publicKey, opts)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := hashAlgorithm(opts.HashFunc())
+	if err != nil {
+		return nil, err
+	}
+
+	var params bytes.Buffer
+	writeTPM2B(&params, digest)
+	writeUint16(&params, scheme)
+	writeUint16(&params, hash)
+	// NULL hashcheck ticket, the digest was not computed by the TPM.
+	writeUint16(&params, tagHashCheck)
+	writeUint32(&params, rhNull)
+	writeTPM2B(&params, nil)
+
+	_, resp, err := s.device.run(&command{
+		code:    ccSign,
+		handles: []uint32{s.handle},
+		auths:   [][]byte{nil},
+		params:  params.Bytes(),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "tpmKMS Sign failed")
+	}
+
+	r := tpm.NewReader(resp)
+	sigAlg := r.Uint16()
+	r.Uint16() // hash
+	switch sigAlg {
+	case tpm.AlgECDSA:
+		sig := struct{ R, S *big.Int }{
+			R: new(big.Int).SetBytes(r.TPM2B()),
+			S: new(big.Int).SetBytes(r.TPM2B()),
+		}
+		if r.Err() != nil {
+			return nil, errors.Wrap(r.Err(), "error parsing tpm signature")
+		}
+		return asn1.Marshal(sig)
+	case tpm.AlgRSASSA:
+		sig := r.TPM2B()
+		if r.Err() != nil {
+			return nil, errors.Wrap(r.Err(), "error parsing tpm signature")
+		}
+		return sig, nil
+	default:
+		return nil, errors.Errorf("unsupported tpm signature algorithm 0x%04x", sigAlg)
+	}
+}
+
+func signatureScheme(pub crypto.PublicKey, opts crypto.SignerOpts) (uint16, error) {
+	switch pub.(type) {
+	case *ecdsa.PublicKey:
+		return tpm.AlgECDSA, nil
+	case *rsa.PublicKey:
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			return 0, errors.New("tpmKMS does not support RSA-PSS signatures")
+		}
+		return tpm.AlgRSASSA, nil
+	default:
+		return 0, errors.Errorf("unsupported public key type %T", pub)
+	}
+}
+
+func hashAlgorithm(h crypto.Hash) (uint16, error) {
+	switch h {
+	case crypto.SHA256:
+		return tpm.AlgSHA256, nil
+	case crypto.SHA384:
+		return tpm.AlgSHA384, nil
+	case crypto.SHA512:
+		return tpm.AlgSHA512, nil
+	default:
+		return 0, errors.Errorf("unsupported hash function %v", h)
+	}
+}
@@ -0,0 +1,309 @@
+// Package tpmkms implements a KMS that keeps the keys in a TPM 2.0. The keys
+// are primary keys of the owner hierarchy persisted in the TPM, they never
+// leave it and all the signatures are done by the TPM.
+//
+// The keys are referenced by their persistent handle, e.g.
+// tpmkms:handle=0x81000100.
+package tpmkms
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/tpm"
+)
+
+// DefaultDevice is the TPM device used if none is configured. It is the
+// in-kernel resource manager, so the TPM can be shared with other processes.
+const DefaultDevice = "/dev/tpmrm0"
+
+// DefaultRSAKeySize is the default size for RSA keys.
+const DefaultRSAKeySize = 2048
+
+// uniqueSize is the size of the random data used to make every primary key
+// unique.
+const uniqueSize = 32
+
+type keyType struct {
+	alg   uint16
+	curve uint16
+}
+
+// signatureAlgorithmMapping maps the step signature algorithms with the TPM
+// key types. TPMs do not support PureEd25519 and the salt length in RSA-PSS
+// signatures depends on the TPM, so RSA-PSS is not supported either.
+var signatureAlgorithmMapping = map[apiv1.SignatureAlgorithm]keyType{
+	apiv1.UnspecifiedSignAlgorithm: {tpm.AlgECC, tpm.ECCNistP256},
+	apiv1.SHA256WithRSA:            {tpm.AlgRSA, 0},
+	apiv1.SHA384WithRSA:            {tpm.AlgRSA, 0},
+	apiv1.SHA512WithRSA:            {tpm.AlgRSA, 0},
+	apiv1.ECDSAWithSHA256:          {tpm.AlgECC, tpm.ECCNistP256},
+	apiv1.ECDSAWithSHA384:          {tpm.AlgECC, tpm.ECCNistP384},
+	apiv1.ECDSAWithSHA512:          {tpm.AlgECC, tpm.ECCNistP521},
+}
+
+// TPMKMS is a KMS implementation using a TPM 2.0.
+type TPMKMS struct {
+	device    *device
+	closer    io.Closer
+	ownerAuth []byte
+}
+
+// New opens the TPM device in the options, /dev/tpmrm0 by default. The pin in
+// the options is used as the authorization value of the owner hierarchy when
+// keys are created.
+func New(ctx context.Context, opts apiv1.Options) (*TPMKMS, error) {
+	path := opts.Device
+	if path == "" {
+		path = DefaultDevice
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening tpm device")
+	}
+	k := newTPMKMS(f, opts.Pin)
+	k.closer = f
+	return k, nil
+}
+
+func newTPMKMS(rw io.ReadWriter, ownerAuth string) *TPMKMS {
+	return &TPMKMS{
+		device:    &device{rw: rw},
+		ownerAuth: []byte(ownerAuth),
+	}
+}
+
+// GetPublicKey returns the public key of the persistent key with the given
+// handle.
+func (k *TPMKMS) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error) {
+	if req.Name == "" {
+		return nil, errors.New("getPublicKeyRequest 'name' cannot be empty")
+	}
+	handle, err := parseURI(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return k.readPublic(handle)
+}
+
+// CreateKey creates a new primary key in the owner hierarchy and persists it
+// with the handle in the name. It fails if the handle is already in use.
+func (k *TPMKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	if req.Name == "" {
+		return nil, errors.New("createKeyRequest 'name' cannot be empty")
+	}
+	handle, err := parseURI(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	kt, ok := signatureAlgorithmMapping[req.SignatureAlgorithm]
+	if !ok {
+		return nil, errors.Errorf("tpmKMS does not support signature algorithm '%s'", req.SignatureAlgorithm)
+	}
+	bits := req.Bits
+	if bits == 0 {
+		bits = DefaultRSAKeySize
+	}
+
+	switch _, err := k.readPublic(handle); {
+	case err == nil:
+		return nil, errors.Errorf("tpmKMS handle %s already exists", req.Name)
+	case !isHandleError(errors.Cause(err)):
+		return nil, err
+	}
+
+	template, err := keyTemplate(kt, bits)
+	if err != nil {
+		return nil, err
+	}
+	transient, err := k.createPrimary(template)
+	if err != nil {
+		return nil, errors.Wrap(err, "tpmKMS CreatePrimary failed")
+	}
+	defer k.flushContext(transient)
+
+	if err := k.evictControl(transient, handle); err != nil {
+		return nil, errors.Wrap(err, "tpmKMS EvictControl failed")
+	}
+
+	pub, err := k.readPublic(handle)
+	if err != nil {
+		return nil, err
+	}
+	return &apiv1.CreateKeyResponse{
+		Name:      req.Name,
+		PublicKey: pub,
+		CreateSignerRequest: apiv1.CreateSignerRequest{
+			SigningKey: req.Name,
+		},
+	}, nil
+}
+
+// CreateSigner returns a signer that uses the persistent key with the given
+// handle.
+func (k *TPMKMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
+	if req.SigningKey == "" {
+		return nil, errors.New("createSignerRequest 'signingKey' cannot be empty")
+	}
+	handle, err := parseURI(req.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := k.readPublic(handle)
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{
+		device:    k.device,
+		handle:    handle,
+		publicKey: pub,
+	}, nil
+}
+
+// Close closes the TPM device.
+func (k *TPMKMS) Close() error {
+	if k.closer == nil {
+		return nil
+	}
+	return errors.Wrap(k.closer.Close(), "error closing tpm device")
+}
+
+// readPublic returns the public key of a loaded or persistent object.
+func (k *TPMKMS) readPublic(handle uint32) (crypto.PublicKey, error) {
+	_, params, err := k.device.run(&command{
+		code:    ccReadPublic,
+		handles: []uint32{handle},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "tpmKMS ReadPublic failed")
+	}
+	r := tpm.NewReader(params)
+	pubArea := r.TPM2B()
+	if r.Err() != nil {
+		return nil, errors.Wrap(r.Err(), "error parsing tpm public area")
+	}
+	pub, err := tpm.ParsePublic(pubArea)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing tpm public area")
+	}
+	return pub, nil
+}
+
+// createPrimary creates a primary key in the owner hierarchy and returns its
+// transient handle.
+func (k *TPMKMS) createPrimary(template []byte) (uint32, error) {
+	var params bytes.Buffer
+	// inSensitive: empty userAuth and data
+	writeTPM2B(&params, []byte{0, 0, 0, 0})
+	writeTPM2B(&params, template)
+	// outsideInfo and creationPCR
+	writeTPM2B(&params, nil)
+	writeUint32(&params, 0)
+
+	handles, _, err := k.device.run(&command{
+		code:        ccCreatePrimary,
+		handles:     []uint32{rhOwner},
+		auths:       [][]byte{k.ownerAuth},
+		params:      params.Bytes(),
+		respHandles: 1,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return handles[0], nil
+}
+
+// evictControl persists the transient object with the given handle.
+func (k *TPMKMS) evictControl(transient, persistent uint32) error {
+	var params bytes.Buffer
+	writeUint32(&params, persistent)
+	_, _, err := k.device.run(&command{
+		code:    ccEvictControl,
+		handles: []uint32{rhOwner, transient},
+		auths:   [][]byte{k.ownerAuth},
+		params:  params.Bytes(),
+	})
+	return err
+}
+
+// flushContext removes a transient object from the TPM.
+func (k *TPMKMS) flushContext(handle uint32) error {
+	var params bytes.Buffer
+	writeUint32(&params, handle)
+	_, _, err := k.device.run(&command{
+		code:   ccFlushContext,
+		params: params.Bytes(),
+	})
+	return err
+}
+
+// keyTemplate returns the TPMT_PUBLIC template of an unrestricted signing key.
+// The unique field is random, so every key created is different.
+func keyTemplate(kt keyType, bits int) ([]byte, error) {
+	unique := make([]byte, uniqueSize)
+	if _, err := rand.Read(unique); err != nil {
+		return nil, errors.Wrap(err, "error generating random data")
+	}
+
+	var b bytes.Buffer
+	writeUint16(&b, kt.alg)
+	writeUint16(&b, tpm.AlgSHA256)
+	writeUint32(&b, signingKeyAttributes)
+	writeTPM2B(&b, nil)          // authPolicy
+	writeUint16(&b, tpm.AlgNull) // symmetric
+	writeUint16(&b, tpm.AlgNull) // scheme
+	switch kt.alg {
+	case tpm.AlgECC:
+		writeUint16(&b, kt.curve)
+		writeUint16(&b, tpm.AlgNull) // kdf
+		writeTPM2B(&b, unique)       // x
+		writeTPM2B(&b, nil)          // y
+	case tpm.AlgRSA:
+		switch bits {
+		case 1024, 2048, 3072, 4096:
+		default:
+			return nil, errors.Errorf("tpmKMS does not support RSA keys of %d bits", bits)
+		}
+		writeUint16(&b, uint16(bits))
+		writeUint32(&b, 0) // default exponent
+		writeTPM2B(&b, unique)
+	}
+	return b.Bytes(), nil
+}
+
+// parseURI returns the persistent handle in a URI with the format
+// tpmkms:handle=0x81000100.
+func parseURI(rawuri string) (uint32, error) {
+	u, err := url.Parse(rawuri)
+	if err != nil {
+		return 0, errors.Wrapf(err, "error parsing %s", rawuri)
+	}
+	if u.Scheme != string(apiv1.TPMKMS) {
+		return 0, errors.Errorf("error parsing %s: scheme is not tpmkms", rawuri)
+	}
+	values, err := url.ParseQuery(u.Opaque)
+	if err != nil {
+		return 0, errors.Wrapf(err, "error parsing %s", rawuri)
+	}
+	s := values.Get("handle")
+	if s == "" {
+		return 0, errors.Errorf("error parsing %s: handle is missing", rawuri)
+	}
+	h, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 32)
+	if err != nil {
+		return 0, errors.Errorf("error parsing %s: handle is not valid", rawuri)
+	}
+	if h < persistentFirst || h > persistentLast {
+		return 0, errors.Errorf("error parsing %s: handle is not a persistent handle", rawuri)
+	}
+	return uint32(h), nil
+}
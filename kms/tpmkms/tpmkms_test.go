@@ -0,0 +1,502 @@
+package tpmkms
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/asn1"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/tpm"
+)
+
+// Response codes returned by fakeTPM.
+const (
+	rcAuthFail    = 0x98e
+	rcHandle1     = 0x18b
+	rcNVDefined   = 0x14c
+	rcValue       = 0x084
+	rcCommandCode = 0x143
+)
+
+type fakeObject struct {
+	signer  crypto.Signer
+	pubArea []byte
+}
+
+// fakeTPM implements the TPM 2.0 commands used by TPMKMS.
+type fakeTPM struct {
+	mu        sync.Mutex
+	ownerAuth []byte
+	objects   map[uint32]*fakeObject
+	next      uint32
+	resp      []byte
+}
+
+func newFakeTPM(ownerAuth string) *fakeTPM {
+	return &fakeTPM{
+		ownerAuth: []byte(ownerAuth),
+		objects:   make(map[uint32]*fakeObject),
+		next:      0x80000000,
+	}
+}
+
+func (f *fakeTPM) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	r := tpm.NewReader(b)
+	tag := r.Uint16()
+	r.Uint32() // size
+	cc := r.Uint32()
+
+	var numHandles int
+	switch cc {
+	case ccReadPublic, ccCreatePrimary, ccSign:
+		numHandles = 1
+	case ccEvictControl:
+		numHandles = 2
+	}
+	handles := make([]uint32, numHandles)
+	for i := range handles {
+		handles[i] = r.Uint32()
+	}
+	var auths [][]byte
+	if tag == tagSessions {
+		sessions := tpm.NewReader(r.Bytes(int(r.Uint32())))
+		for sessions.Len() > 0 && sessions.Err() == nil {
+			sessions.Uint32() // handle
+			sessions.TPM2B()  // nonce
+			sessions.Bytes(1) // attributes
+			auths = append(auths, sessions.TPM2B())
+		}
+	}
+
+	respHandles, params, rc := f.execute(cc, handles, auths, r)
+	var resp bytes.Buffer
+	if rc != 0 {
+		writeUint16(&resp, tagNoSessions)
+		writeUint32(&resp, 10)
+		writeUint32(&resp, rc)
+	} else {
+		var body bytes.Buffer
+		for _, h := range respHandles {
+			writeUint32(&body, h)
+		}
+		if tag == tagSessions {
+			writeUint32(&body, uint32(len(params)))
+			body.Write(params)
+			for range auths {
+				body.Write([]byte{0, 0, 1, 0, 0})
+			}
+		} else {
+			body.Write(params)
+		}
+		writeUint16(&resp, tag)
+		writeUint32(&resp, uint32(10+body.Len()))
+		writeUint32(&resp, 0)
+		resp.Write(body.Bytes())
+	}
+	f.resp = resp.Bytes()
+	return len(b), nil
+}
+
+func (f *fakeTPM) Read(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := copy(b, f.resp)
+	f.resp = nil
+	return n, nil
+}
+
+func (f *fakeTPM) execute(cc uint32, handles []uint32, auths [][]byte, r *tpm.Reader) ([]uint32, []byte, uint32) {
+	var params bytes.Buffer
+	switch cc {
+	case ccReadPublic:
+		obj, ok := f.objects[handles[0]]
+		if !ok {
+			return nil, nil, rcHandle1
+		}
+		name := sha256.Sum256(obj.pubArea)
+		writeTPM2B(&params, obj.pubArea)
+		writeTPM2B(&params, append([]byte{0x00, 0x0b}, name[:]...))
+		writeTPM2B(&params, nil)
+		return nil, params.Bytes(), 0
+	case ccCreatePrimary:
+		if handles[0] != rhOwner || !bytes.Equal(auths[0], f.ownerAuth) {
+			return nil, nil, rcAuthFail
+		}
+		r.TPM2B() // inSensitive
+		obj, err := newFakeObject(r.TPM2B())
+		if err != nil {
+			return nil, nil, rcValue
+		}
+		handle := f.next
+		f.next++
+		f.objects[handle] = obj
+		writeTPM2B(&params, obj.pubArea)
+		return []uint32{handle}, params.Bytes(), 0
+	case ccEvictControl:
+		if !bytes.Equal(auths[0], f.ownerAuth) {
+			return nil, nil, rcAuthFail
+		}
+		obj, ok := f.objects[handles[1]]
+		if !ok {
+			return nil, nil, rcHandle1
+		}
+		persistent := r.Uint32()
+		if _, ok := f.objects[persistent]; ok {
+			return nil, nil, rcNVDefined
+		}
+		f.objects[persistent] = obj
+		return nil, nil, 0
+	case ccFlushContext:
+		handle := r.Uint32()
+		if _, ok := f.objects[handle]; !ok {
+			return nil, nil, rcHandle1
+		}
+		delete(f.objects, handle)
+		return nil, nil, 0
+	case ccSign:
+		obj, ok := f.objects[handles[0]]
+		if !ok {
+			return nil, nil, rcHandle1
+		}
+		digest := r.TPM2B()
+		scheme := r.Uint16()
+		hashAlg := r.Uint16()
+		var hash crypto.Hash
+		switch hashAlg {
+		case tpm.AlgSHA256:
+			hash = crypto.SHA256
+		case tpm.AlgSHA384:
+			hash = crypto.SHA384
+		case tpm.AlgSHA512:
+			hash = crypto.SHA512
+		}
+		if len(digest) != hash.Size() {
+			return nil, nil, rcValue
+		}
+		sig, err := obj.signer.Sign(rand.Reader, digest, hash)
+		if err != nil {
+			return nil, nil, rcValue
+		}
+		writeUint16(&params, scheme)
+		writeUint16(&params, hashAlg)
+		switch scheme {
+		case tpm.AlgECDSA:
+			var esig struct{ R, S *big.Int }
+			if _, err := asn1.Unmarshal(sig, &esig); err != nil {
+				return nil, nil, rcValue
+			}
+			writeTPM2B(&params, esig.R.Bytes())
+			writeTPM2B(&params, esig.S.Bytes())
+		case tpm.AlgRSASSA:
+			writeTPM2B(&params, sig)
+		default:
+			return nil, nil, rcValue
+		}
+		return nil, params.Bytes(), 0
+	default:
+		return nil, nil, rcCommandCode
+	}
+}
+
+// newFakeObject generates a key using the template, the public area returned
+// is the template with the public key as the unique field.
+func newFakeObject(template []byte) (*fakeObject, error) {
+	r := tpm.NewReader(template)
+	alg := r.Uint16()
+	r.Uint16() // nameAlg
+	r.Uint32() // objectAttributes
+	r.TPM2B()  // authPolicy
+	r.Bytes(4) // symmetric and scheme
+	var signer crypto.Signer
+	var err error
+	var unique bytes.Buffer
+	switch alg {
+	case tpm.AlgECC:
+		var curve elliptic.Curve
+		switch r.Uint16() {
+		case tpm.ECCNistP256:
+			curve = elliptic.P256()
+		case tpm.ECCNistP384:
+			curve = elliptic.P384()
+		case tpm.ECCNistP521:
+			curve = elliptic.P521()
+		}
+		r.Uint16() // kdf
+		var key *ecdsa.PrivateKey
+		if key, err = ecdsa.GenerateKey(curve, rand.Reader); err != nil {
+			return nil, err
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		writeTPM2B(&unique, key.X.FillBytes(make([]byte, size)))
+		writeTPM2B(&unique, key.Y.FillBytes(make([]byte, size)))
+		signer = key
+	case tpm.AlgRSA:
+		bits := r.Uint16()
+		r.Uint32() // exponent
+		var key *rsa.PrivateKey
+		if key, err = rsa.GenerateKey(rand.Reader, int(bits)); err != nil {
+			return nil, err
+		}
+		writeTPM2B(&unique, key.N.Bytes())
+		signer = key
+	}
+	params := len(template) - r.Len()
+	r.TPM2B() // unique
+	if alg == tpm.AlgECC {
+		r.TPM2B()
+	}
+	if r.Err() != nil || r.Len() != 0 || signer == nil {
+		return nil, errors.New("invalid template")
+	}
+	return &fakeObject{
+		signer:  signer,
+		pubArea: append(append([]byte{}, template[:params]...), unique.Bytes()...),
+	}, nil
+}
+
+func newTestTPMKMS() (*TPMKMS, *fakeTPM) {
+	f := newFakeTPM("owner-password")
+	return newTPMKMS(f, "owner-password"), f
+}
+
+func TestNew(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tpmkms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	device := filepath.Join(dir, "tpm0")
+	if err := ioutil.WriteFile(device, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    apiv1.Options
+		wantErr bool
+	}{
+		{"ok", apiv1.Options{Type: "tpmkms", Device: device, Pin: "password"}, false},
+		{"fail/missing", apiv1.Options{Type: "tpmkms", Device: filepath.Join(dir, "missing")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(context.Background(), tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if string(got.ownerAuth) != tt.opts.Pin {
+				t.Errorf("New() ownerAuth = %s, want %s", got.ownerAuth, tt.opts.Pin)
+			}
+			if err := got.Close(); err != nil {
+				t.Errorf("TPMKMS.Close() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestTPMKMS_CreateKey(t *testing.T) {
+	k, f := newTestTPMKMS()
+	if _, err := k.CreateKey(&apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x81000001"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		k       *TPMKMS
+		req     *apiv1.CreateKeyRequest
+		want    interface{}
+		wantErr bool
+	}{
+		{"ok/default", k, &apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x81000100"}, elliptic.P256(), false},
+		{"ok/p384", k, &apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x81000101", SignatureAlgorithm: apiv1.ECDSAWithSHA384}, elliptic.P384(), false},
+		{"ok/p521", k, &apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x81000102", SignatureAlgorithm: apiv1.ECDSAWithSHA512}, elliptic.P521(), false},
+		{"ok/rsa", k, &apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x81000103", SignatureAlgorithm: apiv1.SHA256WithRSA}, 2048, false},
+		{"ok/rsa3072", k, &apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x81000104", SignatureAlgorithm: apiv1.SHA384WithRSA, Bits: 3072}, 3072, false},
+		{"fail/empty", k, &apiv1.CreateKeyRequest{}, nil, true},
+		{"fail/exists", k, &apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x81000001"}, nil, true},
+		{"fail/handle", k, &apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x80000001"}, nil, true},
+		{"fail/bits", k, &apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x81000105", SignatureAlgorithm: apiv1.SHA256WithRSA, Bits: 1000}, nil, true},
+		{"fail/rsa-pss", k, &apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x81000105", SignatureAlgorithm: apiv1.SHA256WithRSAPSS}, nil, true},
+		{"fail/ed25519", k, &apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x81000105", SignatureAlgorithm: apiv1.PureEd25519}, nil, true},
+		{"fail/ownerAuth", newTPMKMS(f, "bad-password"), &apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x81000105"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.k.CreateKey(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TPMKMS.CreateKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Name != tt.req.Name || got.CreateSignerRequest.SigningKey != tt.req.Name {
+				t.Errorf("TPMKMS.CreateKey() name = %v, signing key = %v", got.Name, got.CreateSignerRequest.SigningKey)
+			}
+			switch pub := got.PublicKey.(type) {
+			case *ecdsa.PublicKey:
+				if pub.Curve != tt.want {
+					t.Errorf("TPMKMS.CreateKey() curve = %v, want %v", pub.Curve.Params().Name, tt.want)
+				}
+			case *rsa.PublicKey:
+				if pub.N.BitLen() != tt.want {
+					t.Errorf("TPMKMS.CreateKey() bits = %d, want %v", pub.N.BitLen(), tt.want)
+				}
+			default:
+				t.Errorf("TPMKMS.CreateKey() public key type = %T", pub)
+			}
+		})
+	}
+
+	// Only the persistent keys must remain in the TPM.
+	for h := range f.objects {
+		if h < persistentFirst {
+			t.Errorf("transient handle 0x%x was not flushed", h)
+		}
+	}
+}
+
+func TestTPMKMS_GetPublicKey(t *testing.T) {
+	k, f := newTestTPMKMS()
+	resp, err := k.CreateKey(&apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x81000001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		keyName string
+		want    crypto.PublicKey
+		wantErr bool
+	}{
+		{"ok", "tpmkms:handle=0x81000001", resp.PublicKey, false},
+		{"ok/upper", "tpmkms:handle=0X81000001", resp.PublicKey, false},
+		{"fail/empty", "", nil, true},
+		{"fail/missing", "tpmkms:handle=0x81000002", nil, true},
+		{"fail/uri", "pkcs11:id=7330", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: tt.keyName})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TPMKMS.GetPublicKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TPMKMS.GetPublicKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if len(f.objects) != 1 {
+		t.Errorf("fakeTPM objects = %d, want 1", len(f.objects))
+	}
+}
+
+func TestTPMKMS_CreateSigner(t *testing.T) {
+	k, _ := newTestTPMKMS()
+	for _, req := range []*apiv1.CreateKeyRequest{
+		{Name: "tpmkms:handle=0x81000001"},
+		{Name: "tpmkms:handle=0x81000002", SignatureAlgorithm: apiv1.ECDSAWithSHA384},
+		{Name: "tpmkms:handle=0x81000003", SignatureAlgorithm: apiv1.SHA256WithRSA},
+	} {
+		if _, err := k.CreateKey(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sha256Digest := sha256.Sum256([]byte("message"))
+	sha384Digest := sha512.Sum384([]byte("message"))
+
+	tests := []struct {
+		name       string
+		signingKey string
+		digest     []byte
+		opts       crypto.SignerOpts
+		wantErr    bool
+	}{
+		{"ok/ecdsa", "tpmkms:handle=0x81000001", sha256Digest[:], crypto.SHA256, false},
+		{"ok/ecdsa-p384", "tpmkms:handle=0x81000002", sha384Digest[:], crypto.SHA384, false},
+		{"ok/rsa", "tpmkms:handle=0x81000003", sha256Digest[:], crypto.SHA256, false},
+		{"ok/rsa-sha384", "tpmkms:handle=0x81000003", sha384Digest[:], crypto.SHA384, false},
+		{"fail/rsa-pss", "tpmkms:handle=0x81000003", sha256Digest[:], &rsa.PSSOptions{Hash: crypto.SHA256}, true},
+		{"fail/hash", "tpmkms:handle=0x81000001", sha256Digest[:], crypto.SHA1, true},
+		{"fail/digest", "tpmkms:handle=0x81000001", sha256Digest[:], crypto.SHA384, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: tt.signingKey})
+			if err != nil {
+				t.Fatalf("TPMKMS.CreateSigner() error = %v", err)
+			}
+			sig, err := signer.Sign(rand.Reader, tt.digest, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Signer.Sign() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			switch pub := signer.Public().(type) {
+			case *ecdsa.PublicKey:
+				if !ecdsa.VerifyASN1(pub, tt.digest, sig) {
+					t.Error("Signer.Sign() ecdsa signature is not valid")
+				}
+			case *rsa.PublicKey:
+				if err := rsa.VerifyPKCS1v15(pub, tt.opts.HashFunc(), tt.digest, sig); err != nil {
+					t.Errorf("Signer.Sign() rsa signature is not valid: %v", err)
+				}
+			}
+		})
+	}
+
+	for _, name := range []string{"", "tpmkms:handle=0x81000004"} {
+		if _, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: name}); err == nil {
+			t.Errorf("TPMKMS.CreateSigner(%q) error = nil, want error", name)
+		}
+	}
+}
+
+func Test_parseURI(t *testing.T) {
+	tests := []struct {
+		rawuri  string
+		want    uint32
+		wantErr bool
+	}{
+		{"tpmkms:handle=0x81000001", 0x81000001, false},
+		{"tpmkms:handle=81FFFFFF", 0x81ffffff, false},
+		{"tpmkms:handle=0x80000001", 0, true},
+		{"tpmkms:handle=0x82000000", 0, true},
+		{"tpmkms:handle=foo", 0, true},
+		{"tpmkms:id=1", 0, true},
+		{"pkcs11:handle=0x81000001", 0, true},
+		{"tpmkms:handle=%zz", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseURI(tt.rawuri)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseURI(%q) error = %v, wantErr %v", tt.rawuri, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("parseURI(%q) = 0x%x, want 0x%x", tt.rawuri, got, tt.want)
+		}
+	}
+}
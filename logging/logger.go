@@ -25,6 +25,7 @@ type Logger struct {
 // loggerConfig represents the configuration options for the logger.
 type loggerConfig struct {
 	Format      string `json:"format"`
+	Level       string `json:"level"`
 	TraceHeader string `json:"traceHeader"`
 }
 
@@ -35,9 +36,20 @@ func New(name string, raw json.RawMessage) (*Logger, error) {
 		return nil, errors.Wrap(err, "error unmarshalling logging attribute")
 	}
 
+	level := logrus.InfoLevel
+	if config.Level != "" {
+		l, err := logrus.ParseLevel(config.Level)
+		if err != nil {
+			return nil, errors.Errorf("unsupported logger.level '%s'", config.Level)
+		}
+		level = l
+	}
+
 	var formatter logrus.Formatter
 	switch strings.ToLower(config.Format) {
 	case "", "text":
+	case "console":
+		formatter = &logrus.TextFormatter{FullTimestamp: true}
 	case "json":
 		formatter = new(logrus.JSONFormatter)
 	case "common":
@@ -54,9 +66,20 @@ func New(name string, raw json.RawMessage) (*Logger, error) {
 	if formatter != nil {
 		logger.Formatter = formatter
 	}
+	logger.SetLevel(level)
 	return logger, nil
 }
 
+// NewWithLogger creates a Logger with the given name that writes to the given
+// logrus logger. It allows applications embedding the CA to use their own
+// logger.
+func NewWithLogger(name string, logger *logrus.Logger) *Logger {
+	return &Logger{
+		Logger: logger,
+		name:   name,
+	}
+}
+
 // GetImpl returns the real implementation of the logger.
 func (l *Logger) GetImpl() *logrus.Logger {
 	return l.Logger
@@ -0,0 +1,80 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name      string
+		raw       string
+		wantLevel logrus.Level
+		wantErr   bool
+	}{
+		{"ok/empty", `{}`, logrus.InfoLevel, false},
+		{"ok/text", `{"format":"text"}`, logrus.InfoLevel, false},
+		{"ok/console", `{"format":"console","level":"debug"}`, logrus.DebugLevel, false},
+		{"ok/json", `{"format":"json","level":"warn"}`, logrus.WarnLevel, false},
+		{"ok/common", `{"format":"common","level":"error"}`, logrus.ErrorLevel, false},
+		{"fail/json", `{`, 0, true},
+		{"fail/format", `{"format":"yaml"}`, 0, true},
+		{"fail/level", `{"level":"verbose"}`, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New("ca", json.RawMessage(tt.raw))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.GetLevel() != tt.wantLevel {
+				t.Errorf("New() level = %v, want %v", got.GetLevel(), tt.wantLevel)
+			}
+		})
+	}
+}
+
+func TestLogger_Middleware(t *testing.T) {
+	var buf bytes.Buffer
+	l := logrus.New()
+	l.Out = &buf
+	l.Formatter = new(logrus.JSONFormatter)
+	logger := NewWithLogger("ca", l)
+
+	handler := logger.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rl, ok := w.(ResponseLogger); ok {
+			rl.WithFields(map[string]interface{}{
+				"account-id": "accID",
+				"serial":     "1234",
+			})
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	req := httptest.NewRequest("POST", "/1.0/sign", nil)
+	req.Header.Set("X-Smallstep-Id", "reqID")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("error parsing log entry %q: %v", buf.String(), err)
+	}
+	want := map[string]interface{}{
+		"name":       "ca",
+		"request-id": "reqID",
+		"account-id": "accID",
+		"serial":     "1234",
+		"status":     float64(http.StatusCreated),
+		"level":      "info",
+	}
+	for k, v := range want {
+		if entry[k] != v {
+			t.Errorf("log entry %s = %v, want %v", k, entry[k], v)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Package metrics implements the counters and histograms used to instrument
+// the CA, and exposes them using the Prometheus text format. The metrics are
+// backed by the Prometheus client library.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// DefaultBuckets are the default histogram buckets, in seconds, used to
+// measure latencies.
+var DefaultBuckets = prometheus.DefBuckets
+
+// DefaultRegistry is the registry used by NewCounter, NewHistogram and
+// Handler.
+var DefaultRegistry = NewRegistry()
+
+// NewCounter creates a new counter in the DefaultRegistry.
+func NewCounter(name, help string, labels ...string) *Counter {
+	return DefaultRegistry.NewCounter(name, help, labels...)
+}
+
+// NewHistogram creates a new histogram in the DefaultRegistry.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return DefaultRegistry.NewHistogram(name, help, buckets, labels...)
+}
+
+// Handler returns the http.Handler that exposes the metrics in the
+// DefaultRegistry.
+func Handler() http.Handler {
+	return DefaultRegistry
+}
+
+// Registry is a set of metrics. Registry implements the http.Handler
+// interface, writing all its metrics in the Prometheus text format.
+type Registry struct {
+	reg     *prometheus.Registry
+	handler http.Handler
+}
+
+// NewRegistry creates a new empty registry.
+func NewRegistry() *Registry {
+	reg := prometheus.NewRegistry()
+	return &Registry{
+		reg:     reg,
+		handler: promhttp.HandlerFor(reg, promhttp.HandlerOpts{}),
+	}
+}
+
+// NewCounter creates and registers a new counter with the given name and
+// label names. It panics if a metric with the same name is already
+// registered.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		vec:    prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labels),
+		labels: labels,
+	}
+	r.reg.MustRegister(c.vec)
+	return c
+}
+
+// NewHistogram creates and registers a new histogram with the given name,
+// buckets and label names. The buckets must be sorted in increasing order. It
+// panics if a metric with the same name is already registered.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		vec:    prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: help, Buckets: buckets}, labels),
+		labels: labels,
+	}
+	r.reg.MustRegister(h.vec)
+	return h
+}
+
+// Write writes all the metrics in the Prometheus text format.
+func (r *Registry) Write(w io.Writer) error {
+	families, err := r.reg.Gather()
+	if err != nil {
+		return err
+	}
+	enc := expfmt.NewEncoder(w, expfmt.FmtText)
+	for _, mf := range families {
+		if err := enc.Encode(mf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.handler.ServeHTTP(w, req)
+}
+
+// Counter is a metric that can only increase. The label values used in Inc
+// and Add must match the label names of the counter.
+type Counter struct {
+	vec    *prometheus.CounterVec
+	labels []string
+}
+
+// Inc increments by one the counter with the given label values.
+func (c *Counter) Inc(labels ...string) {
+	c.vec.WithLabelValues(labels...).Inc()
+}
+
+// Add adds the given value to the counter with the given label values. It
+// panics if the value is negative.
+func (c *Counter) Add(v float64, labels ...string) {
+	c.vec.WithLabelValues(labels...).Add(v)
+}
+
+// Value returns the value of the counter with the given label values.
+func (c *Counter) Value(labels ...string) float64 {
+	return lookup(c.vec, c.labels, labels).GetCounter().GetValue()
+}
+
+// Histogram is a metric that counts observations in configurable buckets.
+// The label values used in Observe must match the label names of the
+// histogram.
+type Histogram struct {
+	vec    *prometheus.HistogramVec
+	labels []string
+}
+
+// Observe adds an observation to the histogram with the given label values.
+func (h *Histogram) Observe(v float64, labels ...string) {
+	h.vec.WithLabelValues(labels...).Observe(v)
+}
+
+// Count returns the number of observations of the histogram with the given
+// label values.
+func (h *Histogram) Count(labels ...string) uint64 {
+	return lookup(h.vec, h.labels, labels).GetHistogram().GetSampleCount()
+}
+
+// lookup returns the current value of the metric with the given label values
+// in the collector, or nil if it has not been observed yet. Unlike
+// WithLabelValues it does not create the metric.
+func lookup(c prometheus.Collector, names, values []string) *dto.Metric {
+	if len(names) != len(values) {
+		panic(fmt.Sprintf("metric expects %d label values, got %d", len(names), len(values)))
+	}
+	want := make(map[string]string, len(names))
+	for i, name := range names {
+		want[name] = values[i]
+	}
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	var found *dto.Metric
+	for m := range ch {
+		var d dto.Metric
+		if found != nil || m.Write(&d) != nil {
+			continue
+		}
+		match := len(d.GetLabel()) == len(want)
+		for _, l := range d.GetLabel() {
+			if v, ok := want[l.GetName()]; !ok || v != l.GetValue() {
+				match = false
+			}
+		}
+		if match {
+			found = &d
+		}
+	}
+	return found
+}
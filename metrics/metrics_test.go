@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_requests_total", "Number of requests.", "method", "code")
+	h := r.NewHistogram("test_duration_seconds", "Request duration.", []float64{0.1, 1}, "method")
+	n := r.NewCounter("test_events_total", "Number of \"events\".\nSecond line.")
+
+	c.Inc("GET", "200")
+	c.Inc("GET", "200")
+	c.Add(3, "POST", "4\"0\\0")
+	h.Observe(0.05, "GET")
+	h.Observe(0.5, "GET")
+	h.Observe(2, "GET")
+	n.Inc()
+
+	assert.Equals(t, float64(2), c.Value("GET", "200"))
+	assert.Equals(t, float64(0), c.Value("GET", "500"))
+	assert.Equals(t, uint64(3), h.Count("GET"))
+	assert.Equals(t, uint64(0), h.Count("POST"))
+
+	var buf bytes.Buffer
+	assert.FatalError(t, r.Write(&buf))
+	assert.Equals(t, `# HELP test_duration_seconds Request duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{method="GET",le="0.1"} 1
+test_duration_seconds_bucket{method="GET",le="1"} 2
+test_duration_seconds_bucket{method="GET",le="+Inf"} 3
+test_duration_seconds_sum{method="GET"} 2.55
+test_duration_seconds_count{method="GET"} 3
+# HELP test_events_total Number of "events".\nSecond line.
+# TYPE test_events_total counter
+test_events_total 1
+# HELP test_requests_total Number of requests.
+# TYPE test_requests_total counter
+test_requests_total{code="200",method="GET"} 2
+test_requests_total{code="4\"0\\0",method="POST"} 3
+`, buf.String())
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	res := w.Result()
+	body, err := ioutil.ReadAll(res.Body)
+	assert.FatalError(t, err)
+	assert.Equals(t, 200, res.StatusCode)
+	assert.Equals(t, "text/plain; version=0.0.4; charset=utf-8", res.Header.Get("Content-Type"))
+	assert.Equals(t, buf.String(), string(body))
+}
+
+func TestRegistryPanics(t *testing.T) {
+	assertPanic := func(t *testing.T, fn func()) {
+		t.Helper()
+		defer func() {
+			assert.NotNil(t, recover())
+		}()
+		fn()
+	}
+
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "Test.", "label")
+	assertPanic(t, func() { r.NewCounter("test_total", "Test.") })
+	assertPanic(t, func() { r.NewHistogram("test_total", "Test.", DefaultBuckets) })
+	assertPanic(t, func() { c.Inc() })
+	assertPanic(t, func() { c.Inc("a", "b") })
+	assertPanic(t, func() { c.Add(-1, "a") })
+}
@@ -432,11 +432,13 @@ func (p *PKI) GenerateConfig(opt ...Option) (*authority.Config, error) {
 			DisableIssuedAtCheck: false,
 			Provisioners:         provisioner.List{prov},
 		},
-		TLS: &tlsutil.TLSOptions{
-			MinVersion:    x509util.DefaultTLSMinVersion,
-			MaxVersion:    x509util.DefaultTLSMaxVersion,
-			Renegotiation: x509util.DefaultTLSRenegotiation,
-			CipherSuites:  x509util.DefaultTLSCipherSuites,
+		TLS: &authority.TLSOptions{
+			TLSOptions: tlsutil.TLSOptions{
+				MinVersion:    x509util.DefaultTLSMinVersion,
+				MaxVersion:    x509util.DefaultTLSMaxVersion,
+				Renegotiation: x509util.DefaultTLSRenegotiation,
+				CipherSuites:  x509util.DefaultTLSCipherSuites,
+			},
 		},
 		Templates: p.getTemplates(),
 	}
@@ -0,0 +1,57 @@
+package ratelimit
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// Config is the configuration of the rate limits of the CA. Each limit is a
+// token bucket, a nil limit disables it.
+type Config struct {
+	Global         *Limit `json:"global,omitempty"`
+	PerIP          *Limit `json:"perIP,omitempty"`
+	PerProvisioner *Limit `json:"perProvisioner,omitempty"`
+}
+
+// Limit is a token bucket that allows Rate requests per second on average
+// with bursts of up to Burst requests. Burst defaults to the rate rounded up.
+type Limit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst,omitempty"`
+}
+
+// Validate checks the fields in Config.
+func (c *Config) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if err := c.Global.validate("global"); err != nil {
+		return err
+	}
+	if err := c.PerIP.validate("perIP"); err != nil {
+		return err
+	}
+	return c.PerProvisioner.validate("perProvisioner")
+}
+
+func (l *Limit) validate(name string) error {
+	switch {
+	case l == nil:
+		return nil
+	case l.Rate <= 0:
+		return errors.Errorf("rateLimit %s rate must be greater than 0", name)
+	case l.Burst < 0:
+		return errors.Errorf("rateLimit %s burst cannot be less than 0", name)
+	default:
+		return nil
+	}
+}
+
+// burst returns the size of the bucket.
+func (l *Limit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return math.Ceil(l.Rate)
+}
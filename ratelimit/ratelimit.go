@@ -0,0 +1,304 @@
+// Package ratelimit implements the token bucket rate limits of the endpoints
+// of the CA that issue certificates, and the ACME endpoints.
+//
+// The buckets are kept in memory, so each instance of the CA enforces its own
+// limits.
+package ratelimit
+
+import (
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/smallstep/certificates/metrics"
+)
+
+// gcInterval is the interval used to drop the buckets of inactive keys.
+const gcInterval = time.Minute
+
+var rateLimitedTotal = metrics.NewCounter("ca_rate_limited_total",
+	"Number of requests rejected by a rate limit.", "limit")
+
+// limitedPaths are the endpoints of the CA that issue certificates, with and
+// without the /1.0 prefix.
+var limitedPaths = map[string]bool{
+	"/sign":       true,
+	"/sign/batch": true,
+	"/renew":      true,
+	"/rekey":      true,
+	"/re-sign":    true,
+	"/ssh/sign":   true,
+	"/sign-ssh":   true,
+	"/ssh/renew":  true,
+	"/ssh/rekey":  true,
+}
+
+// acmePrefixes are the prefixes of the ACME endpoints.
+var acmePrefixes = []string{"/acme/", "/2.0/acme/"}
+
+// ProvisionerFunc returns the id of the provisioner a request to a CA
+// endpoint is for, or an empty string if it cannot be determined.
+type ProvisionerFunc func(r *http.Request) string
+
+// Option is the type of the options passed to New.
+type Option func(l *Limiter)
+
+// WithProvisionerFunc sets the function used to get the provisioner of the
+// requests to the CA endpoints. Requests to the ACME endpoints use the
+// provisioner in the URL.
+func WithProvisionerFunc(fn ProvisionerFunc) Option {
+	return func(l *Limiter) {
+		l.provisionerFunc = fn
+	}
+}
+
+// Limiter enforces the global, per-IP and per-provisioner limits.
+type Limiter struct {
+	global          *Limit
+	perIP           *Limit
+	perProvisioner  *Limit
+	provisionerFunc ProvisionerFunc
+	now             func() time.Time
+
+	mu           sync.Mutex
+	globalBucket bucket
+	ipBuckets    map[string]*bucket
+	provBuckets  map[string]*bucket
+	lastGC       time.Time
+}
+
+// New creates a Limiter with the limits in the configuration. It returns a
+// nil Limiter if the configuration is nil.
+func New(c *Config, opts ...Option) (*Limiter, error) {
+	if c == nil {
+		return nil, nil
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	l := &Limiter{
+		global:         c.Global,
+		perIP:          c.PerIP,
+		perProvisioner: c.PerProvisioner,
+		now:            time.Now,
+		ipBuckets:      make(map[string]*bucket),
+		provBuckets:    make(map[string]*bucket),
+	}
+	for _, fn := range opts {
+		fn(l)
+	}
+	l.lastGC = l.now()
+	return l, nil
+}
+
+// Middleware is an HTTP middleware that rejects the requests to the limited
+// endpoints with a 429 Too Many Requests and a Retry-After header when any
+// of the limits is exceeded.
+//
+// The global and per-IP limits are checked first. The provisioner of a
+// request, which may require reading the body and verifying a token, is only
+// resolved if the request is within them, so a request rejected by the
+// per-provisioner limit has already used a global and a per-IP token.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acmeProvisioner, isACME := parseACMEPath(r.URL.EscapedPath())
+		if !isACME && !limitedPaths[strings.TrimPrefix(r.URL.Path, "/1.0")] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var ip string
+		if l.perIP != nil {
+			ip = remoteIP(r)
+		}
+		if name, retryAfter, ok := l.allow(ip); !ok {
+			rateLimitedTotal.Inc(name)
+			writeError(w, isACME, name, retryAfter)
+			return
+		}
+
+		if l.perProvisioner != nil {
+			var prov string
+			switch {
+			case isACME:
+				prov = "acme/" + acmeProvisioner
+			case l.provisionerFunc != nil:
+				prov = l.provisionerFunc(r)
+			}
+			if name, retryAfter, ok := l.allowProvisioner(prov); !ok {
+				rateLimitedTotal.Inc(name)
+				writeError(w, isACME, name, retryAfter)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// check is a bucket that applies to a request and its limit.
+type check struct {
+	name  string
+	limit *Limit
+	b     *bucket
+}
+
+// allow takes a token from the global and per-IP buckets. If a bucket is
+// empty no token is taken, and it returns the name of the limit exceeded and
+// the time until the request can be retried.
+func (l *Limiter) allow(ip string) (string, time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.collect()
+	var checks []check
+	if l.global != nil {
+		checks = append(checks, check{"global", l.global, &l.globalBucket})
+	}
+	if l.perIP != nil && ip != "" {
+		checks = append(checks, check{"perIP", l.perIP, getBucket(l.ipBuckets, ip)})
+	}
+	return take(checks, now)
+}
+
+// allowProvisioner takes a token from the bucket of the given provisioner,
+// like allow. Requests without provisioner are not limited.
+func (l *Limiter) allowProvisioner(prov string) (string, time.Duration, bool) {
+	if l.perProvisioner == nil || prov == "" {
+		return "", 0, true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.collect()
+	return take([]check{{"perProvisioner", l.perProvisioner, getBucket(l.provBuckets, prov)}}, now)
+}
+
+// collect runs gc if the interval has passed and returns the current time.
+// It must be called with the lock held, before getting the buckets.
+func (l *Limiter) collect() time.Time {
+	now := l.now()
+	if now.Sub(l.lastGC) > gcInterval {
+		l.gc(now)
+	}
+	return now
+}
+
+// take takes a token from each bucket if all of them have one. It must be
+// called with the lock held.
+func take(checks []check, now time.Time) (string, time.Duration, bool) {
+	for _, c := range checks {
+		if wait := c.b.refill(c.limit, now); wait > 0 {
+			return c.name, wait, false
+		}
+	}
+	for _, c := range checks {
+		c.b.tokens--
+	}
+	return "", 0, true
+}
+
+// gc drops the buckets that are full, a full bucket behaves as a new one. It
+// must be called with the lock held.
+func (l *Limiter) gc(now time.Time) {
+	for k, b := range l.ipBuckets {
+		if b.refill(l.perIP, now); b.tokens >= l.perIP.burst() {
+			delete(l.ipBuckets, k)
+		}
+	}
+	for k, b := range l.provBuckets {
+		if b.refill(l.perProvisioner, now); b.tokens >= l.perProvisioner.burst() {
+			delete(l.provBuckets, k)
+		}
+	}
+	l.lastGC = now
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func getBucket(m map[string]*bucket, key string) *bucket {
+	b, ok := m[key]
+	if !ok {
+		b = new(bucket)
+		m[key] = b
+	}
+	return b
+}
+
+// refill adds the tokens accumulated since the last refill and returns the
+// time until a token is available, or 0 if there's one.
+func (b *bucket) refill(l *Limit, now time.Time) time.Duration {
+	burst := l.burst()
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed.Seconds()*l.Rate)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+}
+
+// parseACMEPath returns the unescaped provisioner name of a request to an
+// ACME endpoint, and whether the path is an ACME one.
+func parseACMEPath(path string) (string, bool) {
+	for _, prefix := range acmePrefixes {
+		if strings.HasPrefix(path, prefix) {
+			name := strings.SplitN(strings.TrimPrefix(path, prefix), "/", 2)[0]
+			if v, err := url.PathUnescape(name); err == nil {
+				name = v
+			}
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// remoteIP returns the IP address of the client.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type errorResponse struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+type acmeErrorResponse struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+// writeError writes the 429 response, ACME requests get an ACME rateLimited
+// problem document.
+func writeError(w http.ResponseWriter, isACME bool, name string, retryAfter time.Duration) {
+	msg := "rate limit exceeded (" + name + ")"
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	var v interface{}
+	if isACME {
+		w.Header().Set("Content-Type", "application/problem+json")
+		v = acmeErrorResponse{Type: "urn:ietf:params:acme:error:rateLimited", Detail: msg}
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		v = errorResponse{Status: http.StatusTooManyRequests, Message: msg}
+	}
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(v)
+}
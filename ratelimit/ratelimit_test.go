@@ -0,0 +1,222 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{t: time.Unix(1600000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func okHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func newTestLimiter(t *testing.T, c *Config, clk *fakeClock, opts ...Option) *Limiter {
+	t.Helper()
+	l, err := New(c, opts...)
+	assert.FatalError(t, err)
+	l.now = clk.Now
+	l.lastGC = clk.Now()
+	return l
+}
+
+func do(h http.Handler, method, target, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	req.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *Config
+		wantErr bool
+	}{
+		{"nil", nil, false},
+		{"empty", &Config{}, false},
+		{"ok", &Config{Global: &Limit{Rate: 10}, PerIP: &Limit{Rate: 0.5, Burst: 5}, PerProvisioner: &Limit{Rate: 1}}, false},
+		{"fail global rate", &Config{Global: &Limit{}}, true},
+		{"fail perIP rate", &Config{PerIP: &Limit{Rate: -1}}, true},
+		{"fail perProvisioner burst", &Config{PerProvisioner: &Limit{Rate: 1, Burst: -1}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Config.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNew_nil(t *testing.T) {
+	l, err := New(nil)
+	assert.FatalError(t, err)
+	assert.Nil(t, l)
+
+	// A nil limiter does not wrap the handler.
+	h := l.Middleware(http.HandlerFunc(okHandler))
+	for i := 0; i < 10; i++ {
+		assert.Equals(t, http.StatusOK, do(h, "POST", "/sign", "10.0.0.1:1234").Code)
+	}
+}
+
+func TestLimiter_Middleware_global(t *testing.T) {
+	clk := newFakeClock()
+	l := newTestLimiter(t, &Config{Global: &Limit{Rate: 1, Burst: 2}}, clk)
+	h := l.Middleware(http.HandlerFunc(okHandler))
+
+	assert.Equals(t, http.StatusOK, do(h, "POST", "/sign", "10.0.0.1:1234").Code)
+	assert.Equals(t, http.StatusOK, do(h, "POST", "/1.0/renew", "10.0.0.2:1234").Code)
+
+	w := do(h, "POST", "/ssh/sign", "10.0.0.3:1234")
+	assert.Equals(t, http.StatusTooManyRequests, w.Code)
+	assert.Equals(t, "1", w.Header().Get("Retry-After"))
+	assert.Equals(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equals(t, `{"status":429,"message":"rate limit exceeded (global)"}`+"\n", w.Body.String())
+
+	// Endpoints that do not issue certificates are not limited.
+	assert.Equals(t, http.StatusOK, do(h, "GET", "/roots", "10.0.0.1:1234").Code)
+	assert.Equals(t, http.StatusOK, do(h, "POST", "/revoke", "10.0.0.1:1234").Code)
+
+	clk.Advance(time.Second)
+	assert.Equals(t, http.StatusOK, do(h, "POST", "/sign", "10.0.0.1:1234").Code)
+	assert.Equals(t, http.StatusTooManyRequests, do(h, "POST", "/sign", "10.0.0.1:1234").Code)
+}
+
+func TestLimiter_Middleware_perIP(t *testing.T) {
+	clk := newFakeClock()
+	l := newTestLimiter(t, &Config{PerIP: &Limit{Rate: 0.1}}, clk)
+	h := l.Middleware(http.HandlerFunc(okHandler))
+
+	assert.Equals(t, http.StatusOK, do(h, "POST", "/sign", "10.0.0.1:1234").Code)
+	assert.Equals(t, http.StatusOK, do(h, "POST", "/sign", "10.0.0.2:1234").Code)
+	w := do(h, "POST", "/sign", "10.0.0.1:4321")
+	assert.Equals(t, http.StatusTooManyRequests, w.Code)
+	assert.Equals(t, "10", w.Header().Get("Retry-After"))
+
+	clk.Advance(5 * time.Second)
+	w = do(h, "POST", "/sign", "10.0.0.1:1234")
+	assert.Equals(t, http.StatusTooManyRequests, w.Code)
+	assert.Equals(t, "5", w.Header().Get("Retry-After"))
+
+	clk.Advance(5 * time.Second)
+	assert.Equals(t, http.StatusOK, do(h, "POST", "/sign", "10.0.0.1:1234").Code)
+
+	// Full buckets are dropped.
+	clk.Advance(time.Hour)
+	l.mu.Lock()
+	l.gc(clk.Now())
+	assert.Len(t, 0, l.ipBuckets)
+	l.mu.Unlock()
+}
+
+func TestLimiter_Middleware_perProvisioner(t *testing.T) {
+	clk := newFakeClock()
+	provisionerFunc := func(r *http.Request) string {
+		return r.Header.Get("X-Provisioner")
+	}
+	l := newTestLimiter(t, &Config{PerProvisioner: &Limit{Rate: 1}}, clk, WithProvisionerFunc(provisionerFunc))
+	h := l.Middleware(http.HandlerFunc(okHandler))
+
+	sign := func(prov string) int {
+		req := httptest.NewRequest("POST", "/sign", nil)
+		req.Header.Set("X-Provisioner", prov)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equals(t, http.StatusOK, sign("jwk"))
+	assert.Equals(t, http.StatusOK, sign("oidc"))
+	assert.Equals(t, http.StatusTooManyRequests, sign("jwk"))
+	// Requests without provisioner are not limited.
+	assert.Equals(t, http.StatusOK, sign(""))
+	assert.Equals(t, http.StatusOK, sign(""))
+
+	// ACME requests use the provisioner in the URL.
+	assert.Equals(t, http.StatusOK, do(h, "POST", "/acme/my%2Facme/new-order", "10.0.0.1:1234").Code)
+	w := do(h, "POST", "/2.0/acme/my%2Facme/finalize/abc", "10.0.0.1:1234")
+	assert.Equals(t, http.StatusTooManyRequests, w.Code)
+	assert.Equals(t, "1", w.Header().Get("Retry-After"))
+	assert.Equals(t, "application/problem+json", w.Header().Get("Content-Type"))
+	assert.Equals(t, `{"type":"urn:ietf:params:acme:error:rateLimited","detail":"rate limit exceeded (perProvisioner)"}`+"\n", w.Body.String())
+	assert.Equals(t, http.StatusOK, do(h, "POST", "/acme/other/new-order", "10.0.0.1:1234").Code)
+
+	l.mu.Lock()
+	_, ok := l.provBuckets["acme/my/acme"]
+	l.mu.Unlock()
+	assert.True(t, ok)
+}
+
+func TestLimiter_Middleware_provisionerAfterClientLimits(t *testing.T) {
+	clk := newFakeClock()
+	var calls int
+	provisionerFunc := func(r *http.Request) string {
+		calls++
+		return "jwk"
+	}
+	l := newTestLimiter(t, &Config{
+		Global:         &Limit{Rate: 1, Burst: 2},
+		PerIP:          &Limit{Rate: 1},
+		PerProvisioner: &Limit{Rate: 1},
+	}, clk, WithProvisionerFunc(provisionerFunc))
+	h := l.Middleware(http.HandlerFunc(okHandler))
+
+	assert.Equals(t, http.StatusOK, do(h, "POST", "/sign", "10.0.0.1:1234").Code)
+	assert.Equals(t, 1, calls)
+
+	// The provisioner is not resolved for requests over the per-IP or global
+	// limits.
+	w := do(h, "POST", "/sign", "10.0.0.1:1234")
+	assert.Equals(t, http.StatusTooManyRequests, w.Code)
+	assert.Equals(t, `{"status":429,"message":"rate limit exceeded (perIP)"}`+"\n", w.Body.String())
+	assert.Equals(t, 1, calls)
+
+	w = do(h, "POST", "/sign", "10.0.0.2:1234")
+	assert.Equals(t, http.StatusTooManyRequests, w.Code)
+	assert.Equals(t, `{"status":429,"message":"rate limit exceeded (perProvisioner)"}`+"\n", w.Body.String())
+	assert.Equals(t, 2, calls)
+
+	w = do(h, "POST", "/sign", "10.0.0.3:1234")
+	assert.Equals(t, http.StatusTooManyRequests, w.Code)
+	assert.Equals(t, `{"status":429,"message":"rate limit exceeded (global)"}`+"\n", w.Body.String())
+	assert.Equals(t, 2, calls)
+}
+
+func TestLimiter_allow_noTokensTaken(t *testing.T) {
+	clk := newFakeClock()
+	l := newTestLimiter(t, &Config{Global: &Limit{Rate: 1, Burst: 2}, PerIP: &Limit{Rate: 1}}, clk)
+
+	_, _, ok := l.allow("10.0.0.1")
+	assert.True(t, ok)
+	name, wait, ok := l.allow("10.0.0.1")
+	assert.False(t, ok)
+	assert.Equals(t, "perIP", name)
+	assert.Equals(t, time.Second, wait)
+
+	// The request rejected by the per-IP limit did not use a global token.
+	_, _, ok = l.allow("10.0.0.2")
+	assert.True(t, ok)
+	name, _, ok = l.allow("10.0.0.3")
+	assert.False(t, ok)
+	assert.Equals(t, "global", name)
+}
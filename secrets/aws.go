@@ -0,0 +1,63 @@
+package secrets
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/internal/awsutil"
+)
+
+const awsSecretsManagerService = "secretsmanager"
+
+// awsSecretsManager reads the secrets from AWS Secrets Manager. The name of the
+// secret is its name or ARN, and the region is the one in the ARN or the one
+// in AWS_REGION or AWS_DEFAULT_REGION.
+//
+// The requests are signed with the credentials found using the default chain
+// of the AWS SDK, like the ones in AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY,
+// or the role of the instance.
+type awsSecretsManager struct {
+	region string
+	client *awsutil.Client
+}
+
+func newAWSSecretsManager(ctx context.Context) (source, error) {
+	creds, err := awsutil.NewCredentials("", "")
+	if err != nil {
+		return nil, err
+	}
+	return &awsSecretsManager{
+		region: awsutil.DefaultRegion(),
+		client: &awsutil.Client{
+			Service:     awsSecretsManagerService,
+			Target:      "secretsmanager",
+			Credentials: creds,
+			HTTPClient:  &http.Client{Timeout: defaultTimeout},
+		},
+	}, nil
+}
+
+// Get returns the current version of the secret, or the given field in it.
+func (s *awsSecretsManager) Get(ctx context.Context, name, field string) (string, error) {
+	region := s.region
+	// arn:aws:secretsmanager:<region>:<account>:secret:<name>
+	if parts := strings.Split(name, ":"); len(parts) >= 7 && parts[0] == "arn" {
+		region = parts[3]
+	}
+	if region == "" {
+		return "", errors.New("aws region is not set, use AWS_REGION or the secret ARN")
+	}
+	var out struct {
+		SecretString *string `json:"SecretString"`
+		SecretBinary []byte  `json:"SecretBinary"`
+	}
+	if err := s.client.Do(ctx, region, "GetSecretValue", map[string]string{"SecretId": name}, &out); err != nil {
+		return "", errors.Wrap(err, "error getting secret")
+	}
+	if out.SecretString != nil {
+		return selectField([]byte(*out.SecretString), field)
+	}
+	return selectField(out.SecretBinary, field)
+}
@@ -0,0 +1,52 @@
+package secrets
+
+import (
+	"context"
+	"strings"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1beta1"
+	gax "github.com/googleapis/gax-go/v2"
+	"github.com/pkg/errors"
+	secretmanagerpb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1beta1"
+)
+
+// SecretManagerClient defines the methods on the Secret Manager client that
+// this package will use. This interface will be used for unit testing.
+type SecretManagerClient interface {
+	AccessSecretVersion(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error)
+	Close() error
+}
+
+// gcpSecretManager reads the secrets from Google Cloud Secret Manager. The name
+// of the secret is the resource name of a secret version,
+// projects/<project>/secrets/<secret>/versions/<version>; if the version is not
+// set the latest one is used.
+//
+// The client uses the Application Default Credentials.
+type gcpSecretManager struct {
+	client SecretManagerClient
+}
+
+func newGCPSecretManager(ctx context.Context) (source, error) {
+	client, err := secretmanager.NewClient(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating secret manager client")
+	}
+	return &gcpSecretManager{client: client}, nil
+}
+
+// Get returns the payload of the secret version, or the given field in it.
+func (s *gcpSecretManager) Get(ctx context.Context, name, field string) (string, error) {
+	defer s.client.Close()
+
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	resp, err := s.client.AccessSecretVersion(ctx, &secretmanagerpb.AccessSecretVersionRequest{
+		Name: name,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "secretManager AccessSecretVersion failed")
+	}
+	return selectField(resp.GetPayload().GetData(), field)
+}
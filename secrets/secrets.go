@@ -0,0 +1,115 @@
+// Package secrets resolves the references to secrets stored in external secret
+// managers. A reference is a URI with the scheme of the secret manager:
+//
+//	vault://secret/data/step-ca#password
+//	awssm://step-ca/password
+//	gcpsm://projects/my-project/secrets/step-ca-password/versions/latest
+//
+// The optional fragment selects a field in a secret with a JSON object, it is
+// required on Vault secrets.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Secret manager schemes.
+const (
+	VaultScheme = "vault"
+	AWSScheme   = "awssm"
+	GCPScheme   = "gcpsm"
+)
+
+// source is the interface implemented by the secret managers. Get returns the
+// value of the secret with the given name, or the given field in it.
+type source interface {
+	Get(ctx context.Context, name, field string) (string, error)
+}
+
+// sources are the constructors of the supported secret managers.
+var sources = map[string]func(ctx context.Context) (source, error){
+	VaultScheme: newVault,
+	AWSScheme:   newAWSSecretsManager,
+	GCPScheme:   newGCPSecretManager,
+}
+
+// IsReference returns true if s is a reference to a secret in one of the
+// supported secret managers.
+func IsReference(s string) bool {
+	scheme, _, _, ok := parseReference(s)
+	if !ok {
+		return false
+	}
+	_, ok = sources[scheme]
+	return ok
+}
+
+// Resolve returns the value of the secret referenced by s. If s is not a
+// reference it's returned as it is, so plain values can still be used.
+func Resolve(ctx context.Context, s string) (string, error) {
+	scheme, name, field, ok := parseReference(s)
+	if !ok {
+		return s, nil
+	}
+	fn, ok := sources[scheme]
+	if !ok {
+		return s, nil
+	}
+	src, err := fn(ctx)
+	if err != nil {
+		return "", errors.Wrapf(err, "error resolving secret %s", s)
+	}
+	value, err := src.Get(ctx, name, field)
+	if err != nil {
+		return "", errors.Wrapf(err, "error resolving secret %s", s)
+	}
+	return value, nil
+}
+
+// parseReference splits a reference with the format scheme://name#field. The
+// name is not parsed as a URL path because it can be an ARN.
+func parseReference(s string) (scheme, name, field string, ok bool) {
+	parts := strings.SplitN(s, "://", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return
+	}
+	scheme, name = strings.ToLower(parts[0]), parts[1]
+	if i := strings.LastIndex(name, "#"); i >= 0 {
+		name, field = name[:i], name[i+1:]
+	}
+	return scheme, name, field, name != ""
+}
+
+// selectField returns the given field of a secret with a JSON object, or the
+// secret if the field is empty.
+func selectField(secret []byte, field string) (string, error) {
+	if field == "" {
+		return string(secret), nil
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(secret, &m); err != nil {
+		return "", errors.Errorf("secret is not a JSON object, field %s cannot be selected", field)
+	}
+	return fieldValue(m, field)
+}
+
+// fieldValue returns the given field in m. Strings are returned as they are,
+// other values are returned in JSON.
+func fieldValue(m map[string]interface{}, field string) (string, error) {
+	v, ok := m[field]
+	if !ok {
+		return "", errors.Errorf("secret does not have the field %s", field)
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", errors.Wrapf(err, "error marshaling field %s", field)
+	}
+	return string(b), nil
+}
@@ -0,0 +1,298 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	gax "github.com/googleapis/gax-go/v2"
+	"github.com/smallstep/certificates/internal/awsutil"
+	secretmanagerpb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1beta1"
+)
+
+type mockSource map[string]string
+
+func (m mockSource) Get(ctx context.Context, name, field string) (string, error) {
+	v, ok := m[name]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return selectField([]byte(v), field)
+}
+
+func TestResolve(t *testing.T) {
+	tmp := sources
+	defer func() { sources = tmp }()
+	sources = map[string]func(context.Context) (source, error){
+		"mock": func(context.Context) (source, error) {
+			return mockSource{
+				"password": "secret",
+				"db":       `{"user":"step","port":3306}`,
+			}, nil
+		},
+		"fail": func(context.Context) (source, error) {
+			return nil, errors.New("force")
+		},
+	}
+
+	tests := []struct {
+		name    string
+		s       string
+		want    string
+		wantErr bool
+	}{
+		{"ok/plain", "password", "password", false},
+		{"ok/unknown-scheme", "file:///etc/step/password", "file:///etc/step/password", false},
+		{"ok/secret", "mock://password", "secret", false},
+		{"ok/scheme-case", "MOCK://password", "secret", false},
+		{"ok/field", "mock://db#user", "step", false},
+		{"ok/field-number", "mock://db#port", "3306", false},
+		{"fail/not-found", "mock://foo", "", true},
+		{"fail/missing-field", "mock://db#password", "", true},
+		{"fail/not-object", "mock://password#user", "", true},
+		{"fail/source", "fail://password", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Resolve(context.Background(), tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Resolve() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsReference(t *testing.T) {
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"vault://secret/data/step-ca#password", true},
+		{"awssm://arn:aws:secretsmanager:us-east-1:123456789012:secret:step-ca", true},
+		{"gcpsm://projects/my-project/secrets/step-ca", true},
+		{"file:///etc/step/password", false},
+		{"vault://", false},
+		{"vault://#password", false},
+		{"password", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := IsReference(tt.s); got != tt.want {
+			t.Errorf("IsReference(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func Test_vault_Get(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" || r.Header.Get("X-Vault-Namespace") != "ns" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/step-ca":
+			w.Write([]byte(`{"data":{"data":{"password":"v2-secret"},"metadata":{"version":1}}}`))
+		case "/v1/kv/step-ca":
+			w.Write([]byte(`{"data":{"password":"v1-secret","data":"foo"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	os.Setenv("VAULT_ADDR", srv.URL+"/")
+	os.Setenv("VAULT_TOKEN", "token")
+	os.Setenv("VAULT_NAMESPACE", "ns")
+	defer func() {
+		os.Unsetenv("VAULT_ADDR")
+		os.Unsetenv("VAULT_TOKEN")
+		os.Unsetenv("VAULT_NAMESPACE")
+	}()
+
+	tests := []struct {
+		name    string
+		s       string
+		want    string
+		wantErr bool
+	}{
+		{"ok/kv2", "vault://secret/data/step-ca#password", "v2-secret", false},
+		{"ok/kv1", "vault://kv/step-ca#password", "v1-secret", false},
+		{"ok/kv1-data-field", "vault://kv/step-ca#data", "foo", false},
+		{"fail/no-field", "vault://secret/data/step-ca", "", true},
+		{"fail/missing-field", "vault://secret/data/step-ca#user", "", true},
+		{"fail/not-found", "vault://secret/data/foo#password", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Resolve(context.Background(), tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Resolve() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	os.Unsetenv("VAULT_TOKEN")
+	if _, err := Resolve(context.Background(), "vault://secret/data/step-ca#password"); err == nil {
+		t.Error("Resolve() error = nil, want an error without VAULT_TOKEN")
+	}
+}
+
+func Test_awsSecretsManager_Get(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") ||
+			!strings.Contains(auth, "/us-west-2/secretsmanager/aws4_request") ||
+			!strings.Contains(auth, ";x-amz-security-token;x-amz-target,") ||
+			r.Header.Get("X-Amz-Security-Token") != "session" ||
+			r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var req struct {
+			SecretID string `json:"SecretId"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch req.SecretID {
+		case "step-ca/password", "arn:aws:secretsmanager:us-west-2:123456789012:secret:step-ca/password":
+			w.Write([]byte(`{"Name":"step-ca/password","SecretString":"secret"}`))
+		case "step-ca/db":
+			w.Write([]byte(`{"Name":"step-ca/db","SecretString":"{\"password\":\"db-secret\"}"}`))
+		case "step-ca/binary":
+			w.Write([]byte(`{"Name":"step-ca/binary","SecretBinary":"YmluYXJ5"}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"ResourceNotFoundException","message":"Secrets Manager can't find the specified secret."}`))
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		region  string
+		secret  string
+		field   string
+		want    string
+		wantErr bool
+	}{
+		{"ok", "us-west-2", "step-ca/password", "", "secret", false},
+		{"ok/arn", "", "arn:aws:secretsmanager:us-west-2:123456789012:secret:step-ca/password", "", "secret", false},
+		{"ok/field", "us-west-2", "step-ca/db", "password", "db-secret", false},
+		{"ok/binary", "us-west-2", "step-ca/binary", "", "binary", false},
+		{"fail/not-found", "us-west-2", "step-ca/foo", "", "", true},
+		{"fail/region", "", "step-ca/password", "", "", true},
+		{"fail/signature", "us-east-1", "step-ca/password", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &awsSecretsManager{
+				region: tt.region,
+				client: &awsutil.Client{
+					Service:     awsSecretsManagerService,
+					Target:      "secretsmanager",
+					Credentials: credentials.NewStaticCredentials("AKID", "secret", "session"),
+					Endpoint:    srv.URL,
+					HTTPClient:  srv.Client(),
+				},
+			}
+			got, err := s.Get(context.Background(), tt.secret, tt.field)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("awsSecretsManager.Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("awsSecretsManager.Get() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+type mockSecretManagerClient struct {
+	access func(req *secretmanagerpb.AccessSecretVersionRequest) (*secretmanagerpb.AccessSecretVersionResponse, error)
+	closed bool
+}
+
+func (m *mockSecretManagerClient) AccessSecretVersion(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+	return m.access(req)
+}
+
+func (m *mockSecretManagerClient) Close() error {
+	m.closed = true
+	return nil
+}
+
+func Test_gcpSecretManager_Get(t *testing.T) {
+	access := func(req *secretmanagerpb.AccessSecretVersionRequest) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+		switch req.Name {
+		case "projects/p/secrets/password/versions/latest":
+			return &secretmanagerpb.AccessSecretVersionResponse{Payload: &secretmanagerpb.SecretPayload{Data: []byte("secret")}}, nil
+		case "projects/p/secrets/db/versions/2":
+			return &secretmanagerpb.AccessSecretVersionResponse{Payload: &secretmanagerpb.SecretPayload{Data: []byte(`{"password":"db-secret"}`)}}, nil
+		default:
+			return nil, errors.New("not found")
+		}
+	}
+	tests := []struct {
+		name    string
+		secret  string
+		field   string
+		want    string
+		wantErr bool
+	}{
+		{"ok/latest", "projects/p/secrets/password", "", "secret", false},
+		{"ok/version", "projects/p/secrets/db/versions/2", "password", "db-secret", false},
+		{"fail/not-found", "projects/p/secrets/foo", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &mockSecretManagerClient{access: access}
+			s := &gcpSecretManager{client: client}
+			got, err := s.Get(context.Background(), tt.secret, tt.field)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("gcpSecretManager.Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("gcpSecretManager.Get() = %v, want %v", got, tt.want)
+			}
+			if !client.closed {
+				t.Error("gcpSecretManager.Get() did not close the client")
+			}
+		})
+	}
+}
+
+func Test_newVault_cacert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fn := dir + "/ca.crt"
+	if err := ioutil.WriteFile(fn, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("VAULT_TOKEN", "token")
+	os.Setenv("VAULT_CACERT", fn)
+	defer func() {
+		os.Unsetenv("VAULT_TOKEN")
+		os.Unsetenv("VAULT_CACERT")
+	}()
+	if _, err := newVault(context.Background()); err == nil {
+		t.Error("newVault() error = nil, want an error with an invalid VAULT_CACERT")
+	}
+}
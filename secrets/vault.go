@@ -0,0 +1,107 @@
+package secrets
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultVaultAddress = "https://127.0.0.1:8200"
+	defaultTimeout      = 30 * time.Second
+)
+
+// vault reads the secrets from a HashiCorp Vault KV secrets engine, version 1
+// or 2. The name of the secret is the API path without the /v1/ prefix, e.g.
+// secret/data/step-ca in a KV version 2 engine mounted in secret/.
+//
+// It's configured with the standard Vault environment variables: VAULT_ADDR,
+// VAULT_TOKEN, VAULT_NAMESPACE and VAULT_CACERT.
+type vault struct {
+	address   string
+	token     string
+	namespace string
+	client    *http.Client
+}
+
+func newVault(ctx context.Context) (source, error) {
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		return nil, errors.New("environment variable VAULT_TOKEN is not set")
+	}
+	address := os.Getenv("VAULT_ADDR")
+	if address == "" {
+		address = defaultVaultAddress
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if fn := os.Getenv("VAULT_CACERT"); fn != "" {
+		b, err := ioutil.ReadFile(fn)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading %s", fn)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, errors.Errorf("error parsing %s: no certificates found", fn)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &vault{
+		address:   strings.TrimSuffix(address, "/"),
+		token:     token,
+		namespace: os.Getenv("VAULT_NAMESPACE"),
+		client:    &http.Client{Timeout: defaultTimeout, Transport: transport},
+	}, nil
+}
+
+// Get returns the field of the secret in the given path.
+func (v *vault) Get(ctx context.Context, name, field string) (string, error) {
+	if field == "" {
+		return "", errors.New("vault references require a field, e.g. vault://secret/data/step-ca#password")
+	}
+
+	u := v.address + "/v1/" + strings.TrimPrefix(name, "/")
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return "", errors.Wrapf(err, "error creating request for url %s", u)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Vault-Token", v.token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "error doing http GET for url %s", u)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("error doing http GET for url %s with status code %d", u, resp.StatusCode)
+	}
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", errors.Wrapf(err, "error decoding response from url %s", u)
+	}
+
+	// The KV version 2 engine has the secret in data.data.
+	data := body.Data
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = inner
+		}
+	}
+	return fieldValue(data, field)
+}
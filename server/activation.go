@@ -0,0 +1,69 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package server
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd.
+const listenFdsStart = 3
+
+// ActivationListeners returns the TCP and unix listeners passed to the process by
+// systemd socket activation, in the order of the sockets in the unit. It
+// returns nil if the process was not socket activated. The environment
+// variables used by systemd are unset so child processes do not inherit them.
+//
+// With socket activation systemd binds privileged ports like 443, so the CA
+// can run as an unprivileged user without the CAP_NET_BIND_SERVICE
+// capability.
+func ActivationListeners() ([]net.Listener, error) {
+	pid, fds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if fds == "" || pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 1 {
+		return nil, errors.Errorf("error parsing LISTEN_FDS: %s is not a valid number of sockets", fds)
+	}
+	return activationListeners(listenFdsStart, n)
+}
+
+// activationListeners creates the listeners of the n file descriptors
+// starting at start.
+func activationListeners(start, n int) ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, n)
+	closeAll := func() {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+	}
+	for fd := start; fd < start+n; fd++ {
+		syscall.CloseOnExec(fd)
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			closeAll()
+			return nil, errors.Wrapf(err, "error using socket activation file descriptor %d", fd)
+		}
+		switch ln.(type) {
+		case *net.TCPListener, *net.UnixListener:
+		default:
+			ln.Close()
+			closeAll()
+			return nil, errors.Errorf("socket activation file descriptor %d is not a TCP or unix socket", fd)
+		}
+		listeners = append(listeners, ln)
+	}
+	return listeners, nil
+}
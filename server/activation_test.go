@@ -0,0 +1,86 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+// dupFd returns a copy of the file descriptor of f, the copy is owned by the
+// listeners. The file is closed.
+func dupFd(t *testing.T, f *os.File) int {
+	t.Helper()
+	defer f.Close()
+	fd, err := syscall.Dup(int(f.Fd()))
+	assert.FatalError(t, err)
+	return fd
+}
+
+func Test_activationListeners(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.FatalError(t, err)
+	defer ln.Close()
+
+	f, err := ln.(*net.TCPListener).File()
+	assert.FatalError(t, err)
+	listeners, err := activationListeners(dupFd(t, f), 1)
+	assert.FatalError(t, err)
+	if assert.Len(t, 1, listeners) {
+		assert.Equals(t, ln.Addr().String(), listeners[0].Addr().String())
+		listeners[0].Close()
+	}
+
+	// Unix sockets are supported.
+	dir, err := ioutil.TempDir("", "activation")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+	uln, err := net.Listen("unix", filepath.Join(dir, "ca.sock"))
+	assert.FatalError(t, err)
+	defer uln.Close()
+	f, err = uln.(*net.UnixListener).File()
+	assert.FatalError(t, err)
+	listeners, err = activationListeners(dupFd(t, f), 1)
+	assert.FatalError(t, err)
+	if assert.Len(t, 1, listeners) {
+		assert.Equals(t, uln.Addr().String(), listeners[0].Addr().String())
+		listeners[0].Close()
+	}
+
+	// Only TCP and unix sockets are supported.
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.FatalError(t, err)
+	defer pc.Close()
+	f, err = pc.(*net.UDPConn).File()
+	assert.FatalError(t, err)
+	_, err = activationListeners(dupFd(t, f), 1)
+	assert.Error(t, err)
+}
+
+func TestActivationListeners(t *testing.T) {
+	// Not activated.
+	listeners, err := ActivationListeners()
+	assert.FatalError(t, err)
+	assert.Nil(t, listeners)
+
+	// Sockets for another process are ignored and the variables unset.
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	os.Setenv("LISTEN_FDS", "1")
+	listeners, err = ActivationListeners()
+	assert.FatalError(t, err)
+	assert.Nil(t, listeners)
+	assert.Equals(t, "", os.Getenv("LISTEN_FDS"))
+
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	os.Setenv("LISTEN_FDS", "foo")
+	_, err = ActivationListeners()
+	assert.Error(t, err)
+}
@@ -0,0 +1,12 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package server
+
+import "net"
+
+// ActivationListeners always returns nil, systemd socket activation is not
+// supported on this platform.
+func ActivationListeners() ([]net.Listener, error) {
+	return nil, nil
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// UnixPrefix is the prefix of the addresses of unix domain sockets, e.g.
+// unix:/run/step-ca/ca.sock.
+const UnixPrefix = "unix:"
+
+// DefaultSocketMode is the default permissions of the unix socket files, only
+// the user running the CA can connect to them.
+const DefaultSocketMode os.FileMode = 0600
+
+// UnixSocketPath returns the path of the unix socket in addr and true, or
+// false if addr is not a unix socket address.
+func UnixSocketPath(addr string) (string, bool) {
+	if !strings.HasPrefix(addr, UnixPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(addr, UnixPrefix), true
+}
+
+// Listen announces on the given address, a TCP host and port or a unix socket
+// with the UnixPrefix. The socket file is created with the given permissions,
+// replacing the stale socket of a previous run if there's one.
+func Listen(addr string, mode os.FileMode) (net.Listener, error) {
+	path, ok := UnixSocketPath(addr)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, errors.Wrapf(err, "error setting permissions of %s", path)
+	}
+	return ln, nil
+}
+
+// removeStaleSocket removes the socket file in path if no process is
+// listening on it. Other kinds of files are never removed.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return errors.Errorf("error listening on %s: address already in use", path)
+	}
+	return errors.Wrapf(os.Remove(path), "error removing stale socket %s", path)
+}
@@ -0,0 +1,112 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+func TestUnixSocketPath(t *testing.T) {
+	path, ok := UnixSocketPath("unix:/run/step-ca/ca.sock")
+	assert.True(t, ok)
+	assert.Equals(t, "/run/step-ca/ca.sock", path)
+
+	path, ok = UnixSocketPath("127.0.0.1:443")
+	assert.False(t, ok)
+	assert.Equals(t, "", path)
+}
+
+func TestListen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "listen")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ca.sock")
+
+	ln, err := Listen("127.0.0.1:0", DefaultSocketMode)
+	assert.FatalError(t, err)
+	assert.Equals(t, "tcp", ln.Addr().Network())
+	ln.Close()
+
+	ln, err = Listen("unix:"+path, 0660)
+	assert.FatalError(t, err)
+	assert.Equals(t, "unix", ln.Addr().Network())
+	fi, err := os.Stat(path)
+	assert.FatalError(t, err)
+	assert.Equals(t, os.FileMode(0660), fi.Mode().Perm())
+
+	// The socket is in use.
+	_, err = Listen("unix:"+path, DefaultSocketMode)
+	assert.Error(t, err)
+
+	// A stale socket is replaced.
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	ln, err = Listen("unix:"+path, DefaultSocketMode)
+	assert.FatalError(t, err)
+	fi, err = os.Stat(path)
+	assert.FatalError(t, err)
+	assert.Equals(t, DefaultSocketMode, fi.Mode().Perm())
+	ln.Close()
+
+	// Other files are never removed.
+	file := filepath.Join(dir, "file")
+	assert.FatalError(t, ioutil.WriteFile(file, []byte("foo"), 0600))
+	_, err = Listen("unix:"+file, DefaultSocketMode)
+	assert.Error(t, err)
+	b, err := ioutil.ReadFile(file)
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("foo"), b)
+}
+
+func TestServer_unixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ca.sock")
+
+	newServer := func(body string) *Server {
+		return New("unix:"+path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		}), nil)
+	}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+		DisableKeepAlives: true,
+	}}
+	get := func() string {
+		t.Helper()
+		resp, err := client.Get("http://ca/")
+		assert.FatalError(t, err)
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		assert.FatalError(t, err)
+		return string(b)
+	}
+
+	ln, err := Listen("unix:"+path, DefaultSocketMode)
+	assert.FatalError(t, err)
+	srv := newServer("one")
+	done := make(chan error)
+	go func() {
+		done <- srv.Serve(ln)
+	}()
+	assert.Equals(t, "one", get())
+
+	// The socket file is kept on reloads.
+	assert.FatalError(t, srv.Reload(newServer("two")))
+	assert.Equals(t, "two", get())
+
+	assert.FatalError(t, srv.Shutdown())
+	assert.Equals(t, http.ErrServerClosed, <-done)
+}
@@ -0,0 +1,24 @@
+package server
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/smallstep/certificates/metrics"
+)
+
+var tlsHandshakeErrorsTotal = metrics.NewCounter("ca_tls_handshake_errors_total",
+	"Number of failed TLS handshakes.")
+
+// errorLogWriter is the writer of the http.Server error log. It counts the
+// TLS handshake errors reported by the server.
+type errorLogWriter struct {
+	io.Writer
+}
+
+func (w errorLogWriter) Write(p []byte) (int, error) {
+	if bytes.Contains(p, []byte("TLS handshake error")) {
+		tlsHandshakeErrorsTotal.Inc()
+	}
+	return w.Writer.Write(p)
+}
@@ -20,15 +20,26 @@ const ServerShutdownTimeout = 60 * time.Second
 // server.
 type Server struct {
 	*http.Server
-	listener   *net.TCPListener
+	// SocketMode is the permissions of the socket file if the address is a
+	// unix socket, DefaultSocketMode by default.
+	SocketMode os.FileMode
+	listener   fileListener
 	reloadCh   chan net.Listener
 	shutdownCh chan struct{}
 }
 
+// fileListener is the interface implemented by the TCP and unix listeners. The
+// file is used to pass the socket to the new server on reloads.
+type fileListener interface {
+	net.Listener
+	File() (*os.File, error)
+}
+
 // New creates a new HTTP/HTTPS server configured with the passed
 // address, http.Handler and tls.Config.
 func New(addr string, handler http.Handler, tlsConfig *tls.Config) *Server {
 	return &Server{
+		SocketMode: DefaultSocketMode,
 		reloadCh:   make(chan net.Listener),
 		shutdownCh: make(chan struct{}),
 		Server:     newHTTPServer(addr, handler, tlsConfig),
@@ -45,14 +56,14 @@ func newHTTPServer(addr string, handler http.Handler, tlsConfig *tls.Config) *ht
 		WriteTimeout: 15 * time.Second,
 		ReadTimeout:  15 * time.Second,
 		IdleTimeout:  15 * time.Second,
-		ErrorLog:     log.New(os.Stderr, "", log.Ldate|log.Ltime|log.Llongfile),
+		ErrorLog:     log.New(errorLogWriter{os.Stderr}, "", log.Ldate|log.Ltime|log.Llongfile),
 	}
 }
 
-// ListenAndServe listens on the TCP network address srv.Addr and then calls
-// Serve to handle requests on incoming connections.
+// ListenAndServe listens on the TCP network address or unix socket srv.Addr
+// and then calls Serve to handle requests on incoming connections.
 func (srv *Server) ListenAndServe() error {
-	ln, err := net.Listen("tcp", srv.Addr)
+	ln, err := Listen(srv.Addr, srv.SocketMode)
 	if err != nil {
 		return err
 	}
@@ -66,16 +77,18 @@ func (srv *Server) Serve(ln net.Listener) error {
 	var err error
 	// Store the current listener.
 	// In reloads we'll create a copy of the underlying os.File so the close of the server one does not affect the copy.
-	srv.listener = ln.(*net.TCPListener)
+	if srv.listener, err = toFileListener(ln); err != nil {
+		return err
+	}
 
 	for {
 		// Start server
 		if srv.TLSConfig == nil || (len(srv.TLSConfig.Certificates) == 0 && srv.TLSConfig.GetCertificate == nil) {
 			log.Printf("Serving HTTP on %s ...", srv.Addr)
-			err = srv.Server.Serve(tcpKeepAliveListener{ln.(*net.TCPListener)})
+			err = srv.Server.Serve(keepAlive(srv.listener))
 		} else {
 			log.Printf("Serving HTTPS on %s ...", srv.Addr)
-			err = srv.Server.ServeTLS(tcpKeepAliveListener{ln.(*net.TCPListener)}, "", "")
+			err = srv.Server.ServeTLS(keepAlive(srv.listener), "", "")
 		}
 
 		// log unexpected errors
@@ -85,7 +98,9 @@ func (srv *Server) Serve(ln net.Listener) error {
 
 		select {
 		case ln = <-srv.reloadCh:
-			srv.listener = ln.(*net.TCPListener)
+			if srv.listener, err = toFileListener(ln); err != nil {
+				return err
+			}
 		case <-srv.shutdownCh:
 			return http.ErrServerClosed
 		}
@@ -96,7 +111,14 @@ func (srv *Server) Serve(ln net.Listener) error {
 // connections.
 func (srv *Server) Shutdown() error {
 	ctx, cancel := context.WithTimeout(context.Background(), ServerShutdownTimeout)
-	defer cancel()              // release resources if Shutdown ends before the timeout
+	defer cancel() // release resources if Shutdown ends before the timeout
+	return srv.ShutdownContext(ctx)
+}
+
+// ShutdownContext gracefully shuts down the server, it stops accepting new
+// connections and waits for the active ones to finish or for the context to
+// be done.
+func (srv *Server) ShutdownContext(ctx context.Context) error {
 	defer close(srv.shutdownCh) // close shutdown channel
 	return srv.Server.Shutdown(ctx)
 }
@@ -115,11 +137,16 @@ func (srv *Server) Reload(ns *Server) error {
 
 	if srv.Addr != ns.Addr {
 		// Open new address
-		ln, err = net.Listen("tcp", ns.Addr)
+		ln, err = Listen(ns.Addr, ns.SocketMode)
 		if err != nil {
 			return errors.WithStack(err)
 		}
 	} else {
+		// The socket file must be kept when the old listener is closed.
+		if ul, ok := srv.listener.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+
 		// Get a copy of the underlying os.File
 		fd, err := srv.listener.File()
 		if err != nil {
@@ -156,6 +183,27 @@ func (srv *Server) Forbidden(w http.ResponseWriter) {
 	w.Write([]byte("Forbidden.\n"))
 }
 
+// toFileListener returns ln as a fileListener, only TCP and unix listeners are
+// supported.
+func toFileListener(ln net.Listener) (fileListener, error) {
+	switch l := ln.(type) {
+	case *net.TCPListener:
+		return l, nil
+	case *net.UnixListener:
+		return l, nil
+	default:
+		return nil, errors.Errorf("unsupported listener type %T", ln)
+	}
+}
+
+// keepAlive returns the listener with TCP keep-alives if it's a TCP listener.
+func keepAlive(ln fileListener) net.Listener {
+	if tl, ok := ln.(*net.TCPListener); ok {
+		return tcpKeepAliveListener{tl}
+	}
+	return ln
+}
+
 // tcpKeepAliveListener sets TCP keep-alive timeouts on accepted
 // connections. It's used by ListenAndServe and ListenAndServeTLS so
 // dead TCP connections (e.g. closing laptop mid-download) eventually
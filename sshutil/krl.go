@@ -0,0 +1,75 @@
+package sshutil
+
+import (
+	"sort"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// KRL section types, see PROTOCOL.krl in the OpenSSH sources.
+const (
+	krlMagic                 = "SSHKRL\n\x00"
+	krlFormatVersion         = 1
+	krlSectionCertificates   = 1
+	krlSectionCertSerialList = 0x20
+)
+
+// KRL is an OpenSSH key revocation list that revokes certificates by serial
+// number. The same serial numbers are revoked for all the CA keys.
+type KRL struct {
+	Version     uint64
+	GeneratedAt time.Time
+	Comment     string
+	CAKeys      []ssh.PublicKey
+	Serials     []uint64
+}
+
+// Marshal returns the KRL in the binary format used by the RevokedKeys option
+// of sshd and by ssh-keygen -Q.
+func (k *KRL) Marshal() []byte {
+	serials := make([]uint64, len(k.Serials))
+	copy(serials, k.Serials)
+	sort.Slice(serials, func(i, j int) bool { return serials[i] < serials[j] })
+
+	var list []byte
+	for i, sn := range serials {
+		if i > 0 && sn == serials[i-1] {
+			continue
+		}
+		list = appendUint64(list, sn)
+	}
+
+	b := []byte(krlMagic)
+	b = appendUint32(b, krlFormatVersion)
+	b = appendUint64(b, k.Version)
+	b = appendUint64(b, uint64(k.GeneratedAt.Unix()))
+	b = appendUint64(b, 0) // flags
+	b = appendString(b, nil)
+	b = appendString(b, []byte(k.Comment))
+	if len(list) == 0 {
+		return b
+	}
+	for _, key := range k.CAKeys {
+		var section []byte
+		section = appendString(section, key.Marshal())
+		section = appendString(section, nil)
+		section = append(section, krlSectionCertSerialList)
+		section = appendString(section, list)
+		b = append(b, krlSectionCertificates)
+		b = appendString(b, section)
+	}
+	return b
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v>>32)), uint32(v))
+}
+
+func appendString(b, s []byte) []byte {
+	return append(appendUint32(b, uint32(len(s))), s...)
+}
@@ -0,0 +1,265 @@
+// Package tpm implements the verification of TPM 2.0 key attestations and
+// endorsement key (EK) certificates, and the parsing of the TPM 2.0
+// structures they use.
+//
+// A key attestation is a TPMS_ATTEST structure of type TPM_ST_ATTEST_CERTIFY
+// signed by an attestation key (AK), whose certificate chains to a trusted
+// root. EK certificates are issued by the TPM vendors, and they are verified
+// against the vendor roots.
+package tpm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/asn1"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	tpmGeneratedValue  = 0xff544347
+	tpmSTAttestCertify = 0x8017
+)
+
+var (
+	oidSubjectAltName      = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidPermanentIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 8, 3}
+	oidHardwareModuleName  = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 8, 4}
+)
+
+// AttestationStatement is a TPM 2.0 key attestation, as in the tpm
+// attestation statement format of WebAuthn.
+type AttestationStatement struct {
+	// Version must be 2.0.
+	Version string
+	// Alg is the COSE algorithm of the signature.
+	Alg int64
+	// Sig is the signature of CertInfo with the AK.
+	Sig []byte
+	// X5C is the certificate chain of the AK in DER, starting with the AK
+	// certificate.
+	X5C [][]byte
+	// CertInfo is the TPMS_ATTEST structure signed.
+	CertInfo []byte
+	// PubArea is the TPMT_PUBLIC structure of the certified key.
+	PubArea []byte
+}
+
+// VerifyOptions are the options used to verify an attestation statement.
+type VerifyOptions struct {
+	// Roots are the roots of the AK certificate chain.
+	Roots *x509.CertPool
+	// CurrentTime is the time used to verify the chain, if it's zero the
+	// current time is used.
+	CurrentTime time.Time
+	// ExtraData is the expected qualifying data in the statement, usually
+	// the hash of a challenge.
+	ExtraData []byte
+}
+
+// Attestation is the result of a verified attestation statement.
+type Attestation struct {
+	// AKCertificate is the certificate of the attestation key.
+	AKCertificate *x509.Certificate
+	// PermanentIdentifiers are the permanent identifiers and hardware module
+	// serial numbers in the AK certificate.
+	PermanentIdentifiers []string
+	// PubArea is the TPMT_PUBLIC structure of the certified key, it can be
+	// parsed with ParsePublic.
+	PubArea []byte
+}
+
+// CertifyInfo contains the fields of a TPMS_ATTEST structure of type
+// TPM_ST_ATTEST_CERTIFY used to verify an attestation.
+type CertifyInfo struct {
+	ExtraData []byte
+	Name      []byte
+}
+
+// Verify verifies the AK certificate chain, the signature of the statement,
+// that the extra data matches and that the statement certifies the key in
+// PubArea.
+func (s *AttestationStatement) Verify(opts VerifyOptions) (*Attestation, error) {
+	if s.Version != "2.0" {
+		return nil, errors.New("tpm attestation version must be 2.0")
+	}
+	if len(s.PubArea) < 4 {
+		return nil, errors.New("tpm attestation does not contain a valid pubArea")
+	}
+	ak, err := verifyChain(s.X5C, opts.Roots, opts.CurrentTime)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(ak, s.Alg, s.CertInfo, s.Sig); err != nil {
+		return nil, err
+	}
+
+	info, err := ParseCertifyInfo(s.CertInfo)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(info.ExtraData, opts.ExtraData) != 1 {
+		return nil, errors.New("tpm attestation extraData does not match")
+	}
+	if err := verifyName(info.Name, s.PubArea); err != nil {
+		return nil, err
+	}
+
+	ids, err := PermanentIdentifiers(ak)
+	if err != nil {
+		return nil, err
+	}
+	return &Attestation{
+		AKCertificate:        ak,
+		PermanentIdentifiers: ids,
+		PubArea:              s.PubArea,
+	}, nil
+}
+
+// verifyChain parses the certificates in DER and verifies them against the
+// roots. It returns the leaf certificate.
+func verifyChain(x5c [][]byte, roots *x509.CertPool, now time.Time) (*x509.Certificate, error) {
+	if len(x5c) == 0 {
+		return nil, errors.New("attestation statement does not contain a valid x5c")
+	}
+	certs := make([]*x509.Certificate, len(x5c))
+	for i, der := range x5c {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing x5c certificate")
+		}
+		certs[i] = cert
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if _, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, errors.Wrap(err, "error verifying x5c certificate chain")
+	}
+	return certs[0], nil
+}
+
+// verifySignature verifies the signature of data using the COSE algorithm
+// and the key in the certificate.
+func verifySignature(cert *x509.Certificate, alg int64, data, sig []byte) error {
+	var algo x509.SignatureAlgorithm
+	// COSE algorithm identifiers, RFC 8152.
+	switch alg {
+	case -7:
+		algo = x509.ECDSAWithSHA256
+	case -35:
+		algo = x509.ECDSAWithSHA384
+	case -257:
+		algo = x509.SHA256WithRSA
+	case -37:
+		algo = x509.SHA256WithRSAPSS
+	default:
+		return errors.Errorf("attestation statement alg %d is not supported", alg)
+	}
+	if err := cert.CheckSignature(algo, data, sig); err != nil {
+		return errors.Wrap(err, "error verifying attestation signature")
+	}
+	return nil
+}
+
+// verifyName checks that the TPM name, the name algorithm followed by the
+// digest of the TPMT_PUBLIC, is the one of the given public area.
+func verifyName(name, pubArea []byte) error {
+	nameAlg := pubArea[2:4]
+	if !bytes.Equal(nameAlg, []byte{0x00, AlgSHA256}) {
+		return errors.New("tpm attestation pubArea name algorithm is not supported")
+	}
+	sum := sha256.Sum256(pubArea)
+	if !bytes.Equal(name, append(nameAlg[:2:2], sum[:]...)) {
+		return errors.New("tpm attestation certInfo does not certify pubArea")
+	}
+	return nil
+}
+
+// ParseCertifyInfo parses a TPMS_ATTEST structure of type
+// TPM_ST_ATTEST_CERTIFY.
+func ParseCertifyInfo(b []byte) (*CertifyInfo, error) {
+	r := NewReader(b)
+	if magic := r.Uint32(); r.Err() != nil || magic != tpmGeneratedValue {
+		return nil, errors.New("tpm attestation certInfo has an invalid magic value")
+	}
+	if typ := r.Uint16(); r.Err() != nil || typ != tpmSTAttestCertify {
+		return nil, errors.New("tpm attestation certInfo is not a certify structure")
+	}
+	r.TPM2B() // qualifiedSigner
+	extraData := r.TPM2B()
+	r.Bytes(17 + 8) // clockInfo and firmwareVersion
+	name := r.TPM2B()
+	r.TPM2B() // qualifiedName
+	if r.Err() != nil || len(name) == 0 {
+		return nil, errors.New("tpm attestation certInfo is truncated")
+	}
+	return &CertifyInfo{
+		ExtraData: extraData,
+		Name:      name,
+	}, nil
+}
+
+// PermanentIdentifiers returns the permanent identifiers and hardware module
+// serial numbers in the subject alternative names of a certificate.
+func PermanentIdentifiers(cert *x509.Certificate) ([]string, error) {
+	var ids []string
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+		var names []asn1.RawValue
+		if _, err := asn1.Unmarshal(ext.Value, &names); err != nil {
+			return nil, errors.Wrap(err, "error parsing subject alternative names")
+		}
+		for _, n := range names {
+			// otherName [0]
+			if n.Class != asn1.ClassContextSpecific || n.Tag != 0 {
+				continue
+			}
+			var other struct {
+				ID    asn1.ObjectIdentifier
+				Value asn1.RawValue `asn1:"explicit,tag:0"`
+			}
+			if _, err := asn1.UnmarshalWithParams(n.FullBytes, &other, "tag:0"); err != nil {
+				return nil, errors.Wrap(err, "error parsing subject alternative name")
+			}
+			switch {
+			case other.ID.Equal(oidPermanentIdentifier):
+				var pi struct {
+					IdentifierValue string                `asn1:"utf8,optional"`
+					Assigner        asn1.ObjectIdentifier `asn1:"optional"`
+				}
+				if _, err := asn1.Unmarshal(other.Value.Bytes, &pi); err != nil {
+					return nil, errors.Wrap(err, "error parsing permanent identifier")
+				}
+				if pi.IdentifierValue != "" {
+					ids = append(ids, pi.IdentifierValue)
+				}
+			case other.ID.Equal(oidHardwareModuleName):
+				var hmn struct {
+					Type         asn1.ObjectIdentifier
+					SerialNumber []byte
+				}
+				if _, err := asn1.Unmarshal(other.Value.Bytes, &hmn); err != nil {
+					return nil, errors.Wrap(err, "error parsing hardware module name")
+				}
+				ids = append(ids, string(hmn.SerialNumber))
+			}
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,234 @@
+package tpm
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	root *x509.Certificate
+	key  crypto.Signer
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(root)
+	return &testCA{root: root, key: key, pool: pool}
+}
+
+// sign returns a certificate signed by the CA for the given template.
+func (ca *testCA) sign(t *testing.T, tmpl *x509.Certificate, pub crypto.PublicKey) []byte {
+	t.Helper()
+	tmpl.SerialNumber = big.NewInt(2)
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.root, pub, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// permanentIdentifierSAN returns a subject alternative name extension with
+// the given permanent identifier.
+func permanentIdentifierSAN(t *testing.T, id string) pkix.Extension {
+	t.Helper()
+	pi, err := asn1.Marshal(struct {
+		IdentifierValue string `asn1:"utf8"`
+	}{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherName, err := asn1.Marshal(struct {
+		ID    asn1.ObjectIdentifier
+		Value asn1.RawValue
+	}{oidPermanentIdentifier, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: pi}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// otherName is an implicitly tagged [0] sequence.
+	otherName[0] = 0xa0
+	san, err := asn1.Marshal([]asn1.RawValue{{FullBytes: otherName}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkix.Extension{Id: oidSubjectAltName, Value: san}
+}
+
+// marshalCertifyInfo returns a TPMS_ATTEST of type TPM_ST_ATTEST_CERTIFY.
+func marshalCertifyInfo(extraData, name []byte) []byte {
+	var b []byte
+	tpm2b := func(v []byte) {
+		b = append(b, byte(len(v)>>8), byte(len(v)))
+		b = append(b, v...)
+	}
+	b = append(b, 0xff, 0x54, 0x43, 0x47, 0x80, 0x17)
+	tpm2b(nil) // qualifiedSigner
+	tpm2b(extraData)
+	b = append(b, make([]byte, 17+8)...)
+	tpm2b(name)
+	tpm2b(nil) // qualifiedName
+	return b
+}
+
+func newStatement(t *testing.T, ca *testCA, id string, extraData []byte) (*AttestationStatement, crypto.PublicKey) {
+	t.Helper()
+	akKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ak := ca.sign(t, &x509.Certificate{
+		Subject:         pkix.Name{CommonName: "AK"},
+		ExtraExtensions: []pkix.Extension{permanentIdentifierSAN(t, id)},
+	}, akKey.Public())
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubArea := marshalPublic(t, key.Public())
+	sum := sha256.Sum256(pubArea)
+	certInfo := marshalCertifyInfo(extraData, append([]byte{0x00, 0x0b}, sum[:]...))
+	digest := sha256.Sum256(certInfo)
+	sig, err := akKey.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &AttestationStatement{
+		Version:  "2.0",
+		Alg:      -7,
+		Sig:      sig,
+		X5C:      [][]byte{ak},
+		CertInfo: certInfo,
+		PubArea:  pubArea,
+	}, key.Public()
+}
+
+func TestAttestationStatement_Verify(t *testing.T) {
+	ca := newTestCA(t, "Attestation CA")
+	other := newTestCA(t, "Other CA")
+	extraData := sha256.Sum256([]byte("token.thumbprint"))
+
+	modify := func(fn func(s *AttestationStatement)) *AttestationStatement {
+		s, _ := newStatement(t, ca, "device-id", extraData[:])
+		fn(s)
+		return s
+	}
+
+	stmt, pub := newStatement(t, ca, "device-id", extraData[:])
+	tests := []struct {
+		name    string
+		stmt    *AttestationStatement
+		opts    VerifyOptions
+		wantIDs []string
+		wantErr string
+	}{
+		{"ok", stmt, VerifyOptions{Roots: ca.pool, ExtraData: extraData[:]}, []string{"device-id"}, ""},
+		{"fail/version", modify(func(s *AttestationStatement) { s.Version = "1.2" }), VerifyOptions{Roots: ca.pool, ExtraData: extraData[:]}, nil, "tpm attestation version must be 2.0"},
+		{"fail/pubArea", modify(func(s *AttestationStatement) { s.PubArea = nil }), VerifyOptions{Roots: ca.pool, ExtraData: extraData[:]}, nil, "tpm attestation does not contain a valid pubArea"},
+		{"fail/x5c", modify(func(s *AttestationStatement) { s.X5C = nil }), VerifyOptions{Roots: ca.pool, ExtraData: extraData[:]}, nil, "attestation statement does not contain a valid x5c"},
+		{"fail/x5c-parse", modify(func(s *AttestationStatement) { s.X5C = [][]byte{[]byte("foo")} }), VerifyOptions{Roots: ca.pool, ExtraData: extraData[:]}, nil, "error parsing x5c certificate"},
+		{"fail/roots", stmt, VerifyOptions{Roots: other.pool, ExtraData: extraData[:]}, nil, "error verifying x5c certificate chain"},
+		{"fail/expired", stmt, VerifyOptions{Roots: ca.pool, ExtraData: extraData[:], CurrentTime: time.Now().Add(2 * time.Hour)}, nil, "error verifying x5c certificate chain"},
+		{"fail/alg", modify(func(s *AttestationStatement) { s.Alg = -8 }), VerifyOptions{Roots: ca.pool, ExtraData: extraData[:]}, nil, "attestation statement alg -8 is not supported"},
+		{"fail/sig", modify(func(s *AttestationStatement) { s.Sig = []byte("foo") }), VerifyOptions{Roots: ca.pool, ExtraData: extraData[:]}, nil, "error verifying attestation signature"},
+		{"fail/extraData", stmt, VerifyOptions{Roots: ca.pool, ExtraData: []byte("foo")}, nil, "tpm attestation extraData does not match"},
+		{"fail/name", modify(func(s *AttestationStatement) { s.PubArea = marshalPublic(t, pub) }), VerifyOptions{Roots: ca.pool, ExtraData: extraData[:]}, nil, "tpm attestation certInfo does not certify pubArea"},
+		{"fail/nameAlg", modify(func(s *AttestationStatement) { s.PubArea[3] = 0x0c }), VerifyOptions{Roots: ca.pool, ExtraData: extraData[:]}, nil, "tpm attestation pubArea name algorithm is not supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.stmt.Verify(tt.opts)
+			if err != nil {
+				if tt.wantErr == "" || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("AttestationStatement.Verify() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				return
+			}
+			if tt.wantErr != "" {
+				t.Fatalf("AttestationStatement.Verify() error = nil, wantErr %v", tt.wantErr)
+			}
+			if !reflect.DeepEqual(got.PermanentIdentifiers, tt.wantIDs) {
+				t.Errorf("AttestationStatement.Verify() ids = %v, want %v", got.PermanentIdentifiers, tt.wantIDs)
+			}
+			if got.AKCertificate == nil {
+				t.Error("AttestationStatement.Verify() AKCertificate = nil")
+			}
+			if key, err := ParsePublic(got.PubArea); err != nil || !reflect.DeepEqual(key, pub) {
+				t.Errorf("ParsePublic() = %v, %v, want %v", key, err, pub)
+			}
+		})
+	}
+}
+
+func TestParseCertifyInfo(t *testing.T) {
+	valid := marshalCertifyInfo([]byte("extra"), []byte("name"))
+	wrongType := append([]byte{}, valid...)
+	binary.BigEndian.PutUint16(wrongType[4:], 0x8018)
+
+	tests := []struct {
+		name    string
+		b       []byte
+		want    *CertifyInfo
+		wantErr bool
+	}{
+		{"ok", valid, &CertifyInfo{ExtraData: []byte("extra"), Name: []byte("name")}, false},
+		{"fail/magic", append([]byte{0x00}, valid[1:]...), nil, true},
+		{"fail/type", wrongType, nil, true},
+		{"fail/truncated", valid[:20], nil, true},
+		{"fail/empty-name", marshalCertifyInfo([]byte("extra"), nil), nil, true},
+	}
+	// Truncated structures never read past the end of the data.
+	for i := 0; i < len(valid); i++ {
+		if _, err := ParseCertifyInfo(valid[:i]); err == nil {
+			t.Errorf("ParseCertifyInfo() with %d bytes error = nil", i)
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCertifyInfo(tt.b)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCertifyInfo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseCertifyInfo() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
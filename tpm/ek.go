@@ -0,0 +1,146 @@
+package tpm
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	oidTCGKpEKCertificate = asn1.ObjectIdentifier{2, 23, 133, 8, 1}
+	oidTPMManufacturer    = asn1.ObjectIdentifier{2, 23, 133, 2, 1}
+	oidTPMModel           = asn1.ObjectIdentifier{2, 23, 133, 2, 2}
+	oidTPMVersion         = asn1.ObjectIdentifier{2, 23, 133, 2, 3}
+)
+
+// EKVerifyOptions are the options used to verify an EK certificate.
+type EKVerifyOptions struct {
+	// Roots are the roots of the TPM vendors.
+	Roots *x509.CertPool
+	// Intermediates are optional intermediate certificates of the vendors.
+	Intermediates *x509.CertPool
+	// CurrentTime is the time used to verify the chain, if it's zero the
+	// current time is used.
+	CurrentTime time.Time
+}
+
+// EndorsementKey is the result of a verified EK certificate.
+type EndorsementKey struct {
+	Certificate *x509.Certificate
+	// Manufacturer, Model and Version are the TPM attributes in the subject
+	// alternative name of the certificate, the manufacturer has the format
+	// id:<hex-vendor-id>.
+	Manufacturer string
+	Model        string
+	Version      string
+	// URN is the permanent identifier of the EK, see EKURN.
+	URN string
+}
+
+// VerifyEKCertificate verifies the chain of an EK certificate against the
+// vendor roots, and that the certificate is valid for an EK.
+//
+// EK certificates usually have a critical subject alternative name with only
+// a directory name, that crypto/x509 does not consider handled, so the
+// extension is ignored in the verification.
+func VerifyEKCertificate(cert *x509.Certificate, opts EKVerifyOptions) (*EndorsementKey, error) {
+	var isEK bool
+	for _, oid := range cert.UnknownExtKeyUsage {
+		if oid.Equal(oidTCGKpEKCertificate) {
+			isEK = true
+			break
+		}
+	}
+	if !isEK {
+		return nil, errors.New("ek certificate does not have the tcg-kp-EKCertificate extended key usage")
+	}
+
+	c := *cert
+	c.UnhandledCriticalExtensions = nil
+	for _, oid := range cert.UnhandledCriticalExtensions {
+		if !oid.Equal(oidSubjectAltName) {
+			c.UnhandledCriticalExtensions = append(c.UnhandledCriticalExtensions, oid)
+		}
+	}
+	now := opts.CurrentTime
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if _, err := c.Verify(x509.VerifyOptions{
+		Roots:         opts.Roots,
+		Intermediates: opts.Intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, errors.Wrap(err, "error verifying ek certificate chain")
+	}
+
+	urn, err := EKURN(cert.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	ek := &EndorsementKey{
+		Certificate: cert,
+		URN:         urn,
+	}
+	if err := parseTPMAttributes(cert, ek); err != nil {
+		return nil, err
+	}
+	return ek, nil
+}
+
+// EKURN returns the permanent identifier of an EK public key, with the format
+// urn:ek:sha256:<base64-sha256-of-the-der-public-key>.
+func EKURN(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", errors.Wrap(err, "error marshaling ek public key")
+	}
+	sum := sha256.Sum256(der)
+	return "urn:ek:sha256:" + base64.StdEncoding.EncodeToString(sum[:]), nil
+}
+
+// parseTPMAttributes sets the TPM manufacturer, model and version in the
+// directory name of the subject alternative name.
+func parseTPMAttributes(cert *x509.Certificate, ek *EndorsementKey) error {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+		var names []asn1.RawValue
+		if _, err := asn1.Unmarshal(ext.Value, &names); err != nil {
+			return errors.Wrap(err, "error parsing subject alternative names")
+		}
+		for _, n := range names {
+			// directoryName [4]
+			if n.Class != asn1.ClassContextSpecific || n.Tag != 4 {
+				continue
+			}
+			var rdns pkix.RDNSequence
+			if _, err := asn1.Unmarshal(n.Bytes, &rdns); err != nil {
+				return errors.Wrap(err, "error parsing subject alternative name")
+			}
+			for _, rdn := range rdns {
+				for _, atv := range rdn {
+					v := fmt.Sprint(atv.Value)
+					switch {
+					case atv.Type.Equal(oidTPMManufacturer):
+						ek.Manufacturer = v
+					case atv.Type.Equal(oidTPMModel):
+						ek.Model = v
+					case atv.Type.Equal(oidTPMVersion):
+						ek.Version = v
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package tpm
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"testing"
+)
+
+// tpmSAN returns a critical subject alternative name extension with a
+// directory name with the TPM manufacturer, model and version.
+func tpmSAN(t *testing.T) pkix.Extension {
+	t.Helper()
+	rdns, err := asn1.Marshal(pkix.RDNSequence{
+		{{Type: oidTPMManufacturer, Value: "id:49465800"}},
+		{{Type: oidTPMModel, Value: "SLB9670"}},
+		{{Type: oidTPMVersion, Value: "id:00070055"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	san, err := asn1.Marshal([]asn1.RawValue{{Class: asn1.ClassContextSpecific, Tag: 4, IsCompound: true, Bytes: rdns}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkix.Extension{Id: oidSubjectAltName, Critical: true, Value: san}
+}
+
+func TestVerifyEKCertificate(t *testing.T) {
+	vendor := newTestCA(t, "TPM Vendor Root")
+	other := newTestCA(t, "Other Root")
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parse := func(der []byte) *x509.Certificate {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	ek := parse(vendor.sign(t, &x509.Certificate{
+		UnknownExtKeyUsage: []asn1.ObjectIdentifier{oidTCGKpEKCertificate},
+		ExtraExtensions:    []pkix.Extension{tpmSAN(t)},
+	}, key.Public()))
+	noEKU := parse(vendor.sign(t, &x509.Certificate{
+		ExtraExtensions: []pkix.Extension{tpmSAN(t)},
+	}, key.Public()))
+
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(der)
+	wantURN := "urn:ek:sha256:" + base64.StdEncoding.EncodeToString(sum[:])
+
+	tests := []struct {
+		name    string
+		cert    *x509.Certificate
+		opts    EKVerifyOptions
+		wantErr bool
+	}{
+		{"ok", ek, EKVerifyOptions{Roots: vendor.pool}, false},
+		{"fail/eku", noEKU, EKVerifyOptions{Roots: vendor.pool}, true},
+		{"fail/roots", ek, EKVerifyOptions{Roots: other.pool}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := VerifyEKCertificate(tt.cert, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyEKCertificate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Manufacturer != "id:49465800" || got.Model != "SLB9670" || got.Version != "id:00070055" {
+				t.Errorf("VerifyEKCertificate() = %+v, want TPM attributes", got)
+			}
+			if got.URN != wantURN {
+				t.Errorf("VerifyEKCertificate() urn = %v, want %v", got.URN, wantURN)
+			}
+			if got.Certificate != tt.cert || len(tt.cert.UnhandledCriticalExtensions) != 1 {
+				t.Error("VerifyEKCertificate() modified the certificate")
+			}
+		})
+	}
+}
+
+func TestEKURN(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := EKURN(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len("urn:ek:sha256:")+44 {
+		t.Errorf("EKURN() = %v", got)
+	}
+	if _, err := EKURN("foo"); err == nil {
+		t.Error("EKURN() error = nil, want an error")
+	}
+}
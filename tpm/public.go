@@ -0,0 +1,95 @@
+package tpm
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// TPM 2.0 algorithm identifiers.
+const (
+	AlgRSA    = 0x0001
+	AlgSHA256 = 0x000b
+	AlgSHA384 = 0x000c
+	AlgSHA512 = 0x000d
+	AlgNull   = 0x0010
+	AlgRSASSA = 0x0014
+	AlgRSAPSS = 0x0016
+	AlgECDSA  = 0x0018
+	AlgECC    = 0x0023
+)
+
+// TPM 2.0 ECC curve identifiers.
+const (
+	ECCNistP256 = 0x0003
+	ECCNistP384 = 0x0004
+	ECCNistP521 = 0x0005
+)
+
+// defaultRSAExponent is the exponent used when TPMS_RSA_PARMS has 0.
+const defaultRSAExponent = 65537
+
+// ParsePublic parses a TPMT_PUBLIC structure, like the pubArea of an
+// attestation statement, and returns its RSA or ECDSA public key.
+func ParsePublic(pubArea []byte) (crypto.PublicKey, error) {
+	r := NewReader(pubArea)
+	typ := r.Uint16()
+	r.Uint16() // nameAlg
+	r.Uint32() // objectAttributes
+	r.TPM2B()  // authPolicy
+	if sym := r.Uint16(); sym != AlgNull {
+		// Signing keys do not have a symmetric algorithm.
+		return nil, errors.New("tpm public area is not a signing key")
+	}
+	if scheme := r.Uint16(); scheme != AlgNull {
+		r.Uint16() // scheme hash algorithm
+	}
+
+	switch typ {
+	case AlgRSA:
+		bits := r.Uint16()
+		exponent := r.Uint32()
+		n := r.TPM2B()
+		if r.Err() != nil {
+			return nil, errors.New("tpm public area is truncated")
+		}
+		if exponent == 0 {
+			exponent = defaultRSAExponent
+		}
+		if len(n)*8 != int(bits) {
+			return nil, errors.New("tpm public area has an invalid rsa modulus")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent)}, nil
+	case AlgECC:
+		curveID := r.Uint16()
+		if kdf := r.Uint16(); kdf != AlgNull {
+			r.Uint16() // kdf hash algorithm
+		}
+		x, y := r.TPM2B(), r.TPM2B()
+		if r.Err() != nil {
+			return nil, errors.New("tpm public area is truncated")
+		}
+		var curve elliptic.Curve
+		switch curveID {
+		case ECCNistP256:
+			curve = elliptic.P256()
+		case ECCNistP384:
+			curve = elliptic.P384()
+		case ECCNistP521:
+			curve = elliptic.P521()
+		default:
+			return nil, errors.Errorf("tpm public area curve 0x%04x is not supported", curveID)
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("tpm public area has an invalid ecc point")
+		}
+		return pub, nil
+	default:
+		return nil, errors.Errorf("tpm public area type 0x%04x is not supported", typ)
+	}
+}
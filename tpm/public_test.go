@@ -0,0 +1,119 @@
+package tpm
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// marshalPublic returns a TPMT_PUBLIC of a signing key with the given public
+// key.
+func marshalPublic(t *testing.T, pub crypto.PublicKey) []byte {
+	t.Helper()
+	var b []byte
+	u16 := func(v uint16) { b = append(b, byte(v>>8), byte(v)) }
+	u32 := func(v uint32) {
+		var buf [4]byte
+		binary.BigEndian.PutUint32(buf[:], v)
+		b = append(b, buf[:]...)
+	}
+	tpm2b := func(v []byte) {
+		u16(uint16(len(v)))
+		b = append(b, v...)
+	}
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		u16(AlgECC)
+		u16(AlgSHA256)
+		u32(0x00040072)
+		tpm2b(nil)
+		u16(AlgNull)
+		u16(AlgECDSA)
+		u16(AlgSHA256)
+		switch pub.Curve {
+		case elliptic.P256():
+			u16(ECCNistP256)
+		case elliptic.P384():
+			u16(ECCNistP384)
+		default:
+			u16(0x0010)
+		}
+		u16(AlgNull)
+		tpm2b(pub.X.FillBytes(make([]byte, size)))
+		tpm2b(pub.Y.FillBytes(make([]byte, size)))
+	case *rsa.PublicKey:
+		u16(AlgRSA)
+		u16(AlgSHA256)
+		u32(0x00040072)
+		tpm2b(nil)
+		u16(AlgNull)
+		u16(AlgNull)
+		u16(uint16(pub.N.BitLen()))
+		u32(0)
+		tpm2b(pub.N.Bytes())
+	default:
+		t.Fatalf("unsupported key %T", pub)
+	}
+	return b
+}
+
+func TestParsePublic(t *testing.T) {
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p224, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecPub := marshalPublic(t, p256.Public())
+	notOnCurve := append([]byte{}, ecPub...)
+	notOnCurve[len(notOnCurve)-1] ^= 0xff
+	symmetric := append([]byte{}, ecPub...)
+	binary.BigEndian.PutUint16(symmetric[10:], 0x0006)
+	unknownType := append([]byte{}, ecPub...)
+	binary.BigEndian.PutUint16(unknownType, 0x0008)
+
+	tests := []struct {
+		name    string
+		pubArea []byte
+		want    crypto.PublicKey
+		wantErr bool
+	}{
+		{"ok/p256", ecPub, p256.Public(), false},
+		{"ok/p384", marshalPublic(t, p384.Public()), p384.Public(), false},
+		{"ok/rsa", marshalPublic(t, rsaKey.Public()), rsaKey.Public(), false},
+		{"fail/curve", marshalPublic(t, p224.Public()), nil, true},
+		{"fail/point", notOnCurve, nil, true},
+		{"fail/symmetric", symmetric, nil, true},
+		{"fail/type", unknownType, nil, true},
+		{"fail/truncated", ecPub[:len(ecPub)-10], nil, true},
+		{"fail/empty", nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePublic(tt.pubArea)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePublic() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParsePublic() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
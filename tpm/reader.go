@@ -0,0 +1,70 @@
+package tpm
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// Reader reads big-endian TPM 2.0 structures. It keeps the first error, after
+// it the methods return zero values, so a structure can be read and the error
+// checked once with Err.
+type Reader struct {
+	b   []byte
+	err error
+}
+
+// NewReader returns a Reader that reads from b.
+func NewReader(b []byte) *Reader {
+	return &Reader{b: b}
+}
+
+// Err returns the first error found reading the data.
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Len returns the number of bytes not read yet.
+func (r *Reader) Len() int {
+	return len(r.b)
+}
+
+// Bytes reads the next n bytes.
+func (r *Reader) Bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.b) {
+		r.err = errors.New("unexpected end of data")
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+// Uint16 reads a big-endian UINT16.
+func (r *Reader) Uint16() uint16 {
+	if b := r.Bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+// Uint32 reads a big-endian UINT32.
+func (r *Reader) Uint32() uint32 {
+	if b := r.Bytes(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+// TPM2B reads a TPM2B structure, a size followed by the data.
+func (r *Reader) TPM2B() []byte {
+	return r.Bytes(int(r.Uint16()))
+}
+
+// Rest reads all the remaining bytes.
+func (r *Reader) Rest() []byte {
+	return r.Bytes(len(r.b))
+}
@@ -0,0 +1,40 @@
+package tpm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+	r := NewReader([]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x02, 0x00, 0x02, 'a', 'b', 'c', 'd'})
+	if v := r.Uint16(); v != 1 {
+		t.Errorf("Reader.Uint16() = %d, want 1", v)
+	}
+	if v := r.Uint32(); v != 2 {
+		t.Errorf("Reader.Uint32() = %d, want 2", v)
+	}
+	if b := r.TPM2B(); !reflect.DeepEqual(b, []byte("ab")) {
+		t.Errorf("Reader.TPM2B() = %q, want ab", b)
+	}
+	if n := r.Len(); n != 2 {
+		t.Errorf("Reader.Len() = %d, want 2", n)
+	}
+	if b := r.Rest(); !reflect.DeepEqual(b, []byte("cd")) {
+		t.Errorf("Reader.Rest() = %q, want cd", b)
+	}
+	if err := r.Err(); err != nil {
+		t.Errorf("Reader.Err() = %v, want nil", err)
+	}
+
+	// The first error is kept and the next reads return zero values.
+	r = NewReader([]byte{0x00, 0x05, 'a'})
+	if b := r.TPM2B(); b != nil {
+		t.Errorf("Reader.TPM2B() = %q, want nil", b)
+	}
+	if v := r.Uint16(); v != 0 {
+		t.Errorf("Reader.Uint16() = %d, want 0", v)
+	}
+	if err := r.Err(); err == nil || err.Error() != "unexpected end of data" {
+		t.Errorf("Reader.Err() = %v, want unexpected end of data", err)
+	}
+}
@@ -0,0 +1,86 @@
+package tracing
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+// Config is the configuration of the OTLP exporter used to send the traces to
+// an OpenTelemetry collector.
+type Config struct {
+	Endpoint    string                `json:"endpoint"`
+	ServiceName string                `json:"serviceName,omitempty"`
+	Headers     map[string]string     `json:"headers,omitempty"`
+	SampleRatio *float64              `json:"sampleRatio,omitempty"`
+	Timeout     *provisioner.Duration `json:"timeout,omitempty"`
+}
+
+// Validate checks the fields in Config.
+func (c *Config) Validate() error {
+	if c == nil {
+		return nil
+	}
+	u, err := url.Parse(c.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.Errorf("tracing endpoint %s is not valid", c.Endpoint)
+	}
+	if c.SampleRatio != nil && (*c.SampleRatio < 0 || *c.SampleRatio > 1) {
+		return errors.New("tracing sampleRatio must be between 0 and 1")
+	}
+	if c.Timeout != nil && c.Timeout.Duration < 0 {
+		return errors.New("tracing timeout cannot be less than 0")
+	}
+	return nil
+}
+
+// New creates a Tracer that exports the spans to the endpoint in the
+// configuration. It returns a nil Tracer if the configuration is nil.
+func New(c *Config) (*Tracer, error) {
+	if c == nil {
+		return nil, nil
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	serviceName := c.ServiceName
+	if serviceName == "" {
+		serviceName = "step-ca"
+	}
+	sampleRatio := 1.0
+	if c.SampleRatio != nil {
+		sampleRatio = *c.SampleRatio
+	}
+
+	// Validate already checked the endpoint.
+	u, _ := url.Parse(c.Endpoint)
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithURLPath(u.Path),
+	}
+	if u.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if len(c.Headers) > 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(c.Headers))
+	}
+	if c.Timeout != nil && c.Timeout.Duration > 0 {
+		opts = append(opts, otlptracehttp.WithTimeout(c.Timeout.Duration))
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating tracing exporter")
+	}
+
+	return newTracer(sampleRatio,
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))),
+	), nil
+}
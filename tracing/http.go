@@ -0,0 +1,81 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/smallstep/certificates/logging"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer starts the spans of the traced requests and exports them.
+type Tracer struct {
+	provider   *sdktrace.TracerProvider
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// newTracer creates a Tracer that samples the given ratio of the new traces,
+// the traces started by the caller are sampled if the caller sampled them.
+func newTracer(sampleRatio float64, opts ...sdktrace.TracerProviderOption) *Tracer {
+	opts = append([]sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	}, opts...)
+	provider := sdktrace.NewTracerProvider(opts...)
+	return &Tracer{
+		provider:   provider,
+		tracer:     provider.Tracer(instrumentationName),
+		propagator: propagation.TraceContext{},
+	}
+}
+
+// Middleware is an HTTP middleware that starts a server span for each sampled
+// request. If the request has a W3C traceparent header the span continues the
+// trace in the header, and it's sampled if the caller sampled it.
+func (t *Tracer) Middleware(next http.Handler) http.Handler {
+	if t == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := t.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, s := t.tracer.Start(ctx, "HTTP "+r.Method, trace.WithSpanKind(trace.SpanKindServer))
+		defer s.End()
+		if !s.IsRecording() {
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		span := &Span{span: s}
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.target", r.URL.Path)
+		if v, ok := logging.GetRequestID(r.Context()); ok {
+			span.SetAttribute("request.id", v)
+		}
+
+		rw := logging.NewResponseLogger(w)
+		next.ServeHTTP(rw, r.WithContext(ctx))
+
+		status := rw.StatusCode()
+		span.SetAttribute("http.status_code", status)
+		if status >= 500 {
+			span.SetError(errorStatus(status))
+		}
+	})
+}
+
+// Shutdown exports the queued spans and stops the exporter. Spans ended after
+// Shutdown are dropped.
+func (t *Tracer) Shutdown() error {
+	if t == nil {
+		return nil
+	}
+	return t.provider.Shutdown(context.Background())
+}
+
+type errorStatus int
+
+func (e errorStatus) Error() string {
+	return http.StatusText(int(e))
+}
@@ -0,0 +1,143 @@
+// Package tracing implements the spans used to trace the requests to the CA,
+// and exports them to an OpenTelemetry collector using OTLP over HTTP. The
+// spans are backed by the OpenTelemetry SDK.
+//
+// Spans are carried in the context of the request, a span started with a
+// context without a sampled span is a no-op, so code paths without a traced
+// request don't pay for the instrumentation.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the name of the tracer used to start the spans.
+const instrumentationName = "github.com/smallstep/certificates"
+
+// Span represents an operation in a trace. All the methods of Span can be
+// called on a nil span.
+type Span struct {
+	span trace.Span
+}
+
+// FromContext returns the span in the context, or nil if the context does not
+// have a sampled span.
+func FromContext(ctx context.Context) *Span {
+	if s := trace.SpanFromContext(ctx); s.IsRecording() {
+		return &Span{span: s}
+	}
+	return nil
+}
+
+// Start starts a new span with the given name as a child of the span in the
+// context. It returns a context with the new span. If the context does not
+// have a sampled span, it returns the same context and a nil span.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	tp := tracerProvider(ctx)
+	if tp == nil {
+		return ctx, nil
+	}
+	ctx, s := tp.Tracer(instrumentationName).Start(ctx, name)
+	return ctx, &Span{span: s}
+}
+
+type providerKey struct{}
+
+// Detach returns a new background context with the span in the given one, so
+// the spans of the work that outlives a request, like the ACME challenge
+// validations, belong to the trace of the request. The returned context is
+// not canceled when the request ends.
+func Detach(ctx context.Context) context.Context {
+	s := trace.SpanFromContext(ctx)
+	if !s.IsRecording() {
+		return context.Background()
+	}
+	ctx = trace.ContextWithSpanContext(context.Background(), s.SpanContext())
+	return context.WithValue(ctx, providerKey{}, s.TracerProvider())
+}
+
+// tracerProvider returns the provider of the sampled span in the context, or
+// of the span in a detached context. It returns nil if the context is not
+// traced.
+func tracerProvider(ctx context.Context) trace.TracerProvider {
+	s := trace.SpanFromContext(ctx)
+	if s.IsRecording() {
+		return s.TracerProvider()
+	}
+	if tp, ok := ctx.Value(providerKey{}).(trace.TracerProvider); ok && s.SpanContext().IsSampled() {
+		return tp
+	}
+	return nil
+}
+
+// TraceID returns the trace id of the span.
+func (s *Span) TraceID() trace.TraceID {
+	if s == nil {
+		return trace.TraceID{}
+	}
+	return s.span.SpanContext().TraceID()
+}
+
+// SpanID returns the id of the span.
+func (s *Span) SpanID() trace.SpanID {
+	if s == nil {
+		return trace.SpanID{}
+	}
+	return s.span.SpanContext().SpanID()
+}
+
+// SetName replaces the name of the span.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.span.SetName(name)
+}
+
+// SetAttribute adds an attribute to the span. String, boolean and integer
+// values are exported with their type, other values are exported as strings.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	var kv attribute.KeyValue
+	switch v := value.(type) {
+	case string:
+		kv = attribute.String(key, v)
+	case bool:
+		kv = attribute.Bool(key, v)
+	case int:
+		kv = attribute.Int(key, v)
+	case int64:
+		kv = attribute.Int64(key, v)
+	case float64:
+		kv = attribute.Float64(key, v)
+	default:
+		kv = attribute.String(key, fmt.Sprint(v))
+	}
+	s.span.SetAttributes(kv)
+}
+
+// SetError marks the span as failed with the given error, it does nothing if
+// the error is nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// End ends the span and queues it to be exported. Calls after the first one
+// are ignored.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.span.End()
+}
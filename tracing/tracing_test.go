@@ -0,0 +1,225 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type collector struct {
+	mu      sync.Mutex
+	paths   []string
+	headers []http.Header
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	c.paths = append(c.paths, r.URL.Path)
+	c.headers = append(c.headers, r.Header)
+	c.mu.Unlock()
+}
+
+func attributeValue(s sdktrace.ReadOnlySpan, key string) (attribute.Value, bool) {
+	for _, kv := range s.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestStart(t *testing.T) {
+	// Without a span in the context the spans are no-ops.
+	ctx, s := Start(context.Background(), "noop")
+	assert.Nil(t, s)
+	assert.Nil(t, FromContext(ctx))
+	s.SetName("foo")
+	s.SetAttribute("foo", "bar")
+	s.SetError(errors.New("force"))
+	s.End()
+	assert.Equals(t, trace.TraceID{}, s.TraceID())
+	assert.Equals(t, trace.SpanID{}, s.SpanID())
+	assert.Equals(t, context.Background(), Detach(ctx))
+
+	var tracer *Tracer
+	assert.FatalError(t, tracer.Shutdown())
+	h := new(collector)
+	assert.Equals(t, http.Handler(h), tracer.Middleware(h))
+}
+
+func TestTracer_Middleware(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tracer := newTracer(1, sdktrace.WithSpanProcessor(sr))
+
+	var detached context.Context
+	handler := tracer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, s := Start(r.Context(), "authority.Sign")
+		_, child := Start(ctx, "db.StoreCertificate")
+		child.End()
+		s.SetAttribute("provisioner", "jwk")
+		s.SetError(errors.New("force"))
+		s.End()
+		s.End()
+		detached = Detach(r.Context())
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	req := httptest.NewRequest("POST", "/1.0/sign", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Spans started after the request ends are part of the trace.
+	_, async := Start(detached, "acme.ValidateChallenge.attempt")
+	assert.NotNil(t, async)
+	async.End()
+	assert.FatalError(t, tracer.Shutdown())
+
+	spans := sr.Ended()
+	if assert.Equals(t, 4, len(spans)) {
+		child, sign, server, attempt := spans[0], spans[1], spans[2], spans[3]
+		for _, s := range spans {
+			assert.Equals(t, "4bf92f3577b34da6a3ce929d0e0e4736", s.SpanContext().TraceID().String())
+		}
+
+		assert.Equals(t, "HTTP POST", server.Name())
+		assert.Equals(t, trace.SpanKindServer, server.SpanKind())
+		assert.Equals(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+		assert.True(t, server.Parent().IsRemote())
+		assert.Equals(t, codes.Unset, server.Status().Code)
+		v, ok := attributeValue(server, "http.status_code")
+		assert.True(t, ok)
+		assert.Equals(t, int64(201), v.AsInt64())
+		v, ok = attributeValue(server, "http.target")
+		assert.True(t, ok)
+		assert.Equals(t, "/1.0/sign", v.AsString())
+
+		assert.Equals(t, "authority.Sign", sign.Name())
+		assert.Equals(t, trace.SpanKindInternal, sign.SpanKind())
+		assert.Equals(t, server.SpanContext().SpanID(), sign.Parent().SpanID())
+		assert.Equals(t, sdktrace.Status{Code: codes.Error, Description: "force"}, sign.Status())
+		v, ok = attributeValue(sign, "provisioner")
+		assert.True(t, ok)
+		assert.Equals(t, "jwk", v.AsString())
+
+		assert.Equals(t, "db.StoreCertificate", child.Name())
+		assert.Equals(t, sign.SpanContext().SpanID(), child.Parent().SpanID())
+		assert.False(t, child.EndTime().Before(child.StartTime()))
+
+		assert.Equals(t, "acme.ValidateChallenge.attempt", attempt.Name())
+		assert.Equals(t, server.SpanContext().SpanID(), attempt.Parent().SpanID())
+	}
+}
+
+func TestTracer_sampling(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tracer := newTracer(0, sdktrace.WithSpanProcessor(sr))
+
+	var got []*Span
+	handler := tracer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, FromContext(r.Context()))
+		_, s := Start(r.Context(), "child")
+		s.End()
+	}))
+
+	// New traces are not sampled.
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+	// The caller did not sample the trace.
+	req := httptest.NewRequest("GET", "/health", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	// The caller sampled the trace.
+	req = httptest.NewRequest("GET", "/health", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	// Invalid headers start a new trace.
+	req = httptest.NewRequest("GET", "/health", nil)
+	req.Header.Set("traceparent", "00-00000000000000000000000000000000-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.FatalError(t, tracer.Shutdown())
+
+	if assert.Equals(t, 4, len(got)) {
+		assert.Nil(t, got[0])
+		assert.Nil(t, got[1])
+		assert.NotNil(t, got[2])
+		assert.Nil(t, got[3])
+	}
+	assert.Equals(t, 2, len(sr.Ended()))
+}
+
+func TestNew(t *testing.T) {
+	c := new(collector)
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	tracer, err := New(&Config{
+		Endpoint:    srv.URL + "/v1/traces",
+		ServiceName: "test-ca",
+		Headers:     map[string]string{"X-Api-Key": "secret"},
+		Timeout:     &provisioner.Duration{Duration: 5 * time.Second},
+	})
+	assert.FatalError(t, err)
+
+	handler := tracer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, s := Start(r.Context(), "authority.Sign")
+		s.End()
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/1.0/sign", nil))
+	assert.FatalError(t, tracer.Shutdown())
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if assert.Equals(t, 1, len(c.paths)) {
+		assert.Equals(t, "/v1/traces", c.paths[0])
+		assert.Equals(t, "secret", c.headers[0].Get("X-Api-Key"))
+		assert.Equals(t, "application/x-protobuf", c.headers[0].Get("Content-Type"))
+	}
+
+	tracer, err = New(nil)
+	assert.FatalError(t, err)
+	assert.Nil(t, tracer)
+
+	_, err = New(&Config{Endpoint: "localhost:4318"})
+	assert.NotNil(t, err)
+}
+
+func TestConfig_Validate(t *testing.T) {
+	ratio := func(v float64) *float64 { return &v }
+	tests := []struct {
+		name    string
+		config  *Config
+		wantErr bool
+	}{
+		{"ok/nil", nil, false},
+		{"ok", &Config{Endpoint: "http://localhost:4318/v1/traces"}, false},
+		{"ok/full", &Config{
+			Endpoint:    "https://otlp.example.com/v1/traces",
+			ServiceName: "ca",
+			Headers:     map[string]string{"X-Api-Key": "secret"},
+			SampleRatio: ratio(0.25),
+			Timeout:     &provisioner.Duration{Duration: time.Second},
+		}, false},
+		{"fail/empty", &Config{}, true},
+		{"fail/endpoint", &Config{Endpoint: "localhost:4318"}, true},
+		{"fail/sampleRatio", &Config{Endpoint: "http://localhost:4318", SampleRatio: ratio(1.5)}, true},
+		{"fail/timeout", &Config{Endpoint: "http://localhost:4318", Timeout: &provisioner.Duration{Duration: -time.Second}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Config.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}